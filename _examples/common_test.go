@@ -41,6 +41,7 @@ var args = map[string][]string{
 // tests not working / set-up
 var ignored = map[string]bool{
 	"azure_devops":    true,
+	"http-server":     true,
 	"ls":              true,
 	"sha256":          true,
 	"submodule":       true,
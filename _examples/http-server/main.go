@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	. "github.com/go-git/go-git/v5/_examples"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// Example of how to serve a directory of bare repositories over the smart
+// HTTP protocol, they can be cloned with `git clone http://<addr>/<repo>`.
+func main() {
+	CheckArgs("<directory>", "<addr>")
+	directory, addr := os.Args[1], os.Args[2]
+
+	// The repositories are looked up by the path of the request.
+	loader := server.NewFilesystemLoader(osfs.New(directory))
+	handler := githttp.NewHandler(loader, &githttp.HandlerOptions{
+		EnableReceivePack: true,
+	})
+
+	Info("serving %s at http://%s", directory, addr)
+	CheckIfError(http.ListenAndServe(addr, handler))
+}
@@ -0,0 +1,316 @@
+package git
+
+import (
+	"bytes"
+	"io"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+const (
+	// batchWindow is the number of requests of a BatchObjectIter read
+	// ahead, in the order of the objects in their packfiles.
+	batchWindow = 256
+	// batchPrefetchSize is the maximum size of the objects read ahead, the
+	// bigger ones are read when returned.
+	batchPrefetchSize = 64 * 1024
+)
+
+// BatchObjectRequest is an object requested from BatchObjects.
+type BatchObjectRequest struct {
+	// Hash is the hash of the object, or of the commit or tree containing
+	// it if Path is set.
+	Hash plumbing.Hash
+	// Path, if set, is the path of the object in the tree of Hash, as in
+	// `git cat-file --batch` "<rev>:<path>" requests.
+	Path string
+}
+
+// BatchObject is an object returned by BatchObjects.
+type BatchObject struct {
+	// Hash is the hash of the object.
+	Hash plumbing.Hash
+	// Path is the path of the request.
+	Path string
+	// Missing is set if the object doesn't exist, only Hash and Path are set
+	// then, Hash being the one requested if the path doesn't exist.
+	Missing bool
+	// Type is the type of the object.
+	Type plumbing.ObjectType
+	// Size is the size of the content of the object.
+	Size int64
+	// Reader is the content of the object, nil with
+	// BatchObjectsOptions.CheckOnly. It is only valid until the next object
+	// is read.
+	Reader io.Reader
+}
+
+// BatchObjects returns the objects requested, in the order of the requests,
+// as `git cat-file --batch` does. It reads many objects much faster than
+// one by one, as the packfiles are kept open and the objects are read ahead
+// in the order they are stored in.
+func (r *Repository) BatchObjects(requests []BatchObjectRequest, o BatchObjectsOptions) (*BatchObjectIter, error) {
+	iter := &BatchObjectIter{r: r, o: o, requests: requests}
+	if bs, ok := r.Storer.(storer.BatchObjectStorer); ok {
+		reader, err := bs.ObjectBatchReader()
+		if err != nil {
+			return nil, err
+		}
+
+		iter.reader = reader
+	}
+
+	return iter, nil
+}
+
+// BatchObjectIter is the iterator of the objects returned by BatchObjects.
+type BatchObjectIter struct {
+	r        *Repository
+	o        BatchObjectsOptions
+	reader   storer.ObjectBatchReader
+	requests []BatchObjectRequest
+
+	// window are the objects read ahead, and pending their requests.
+	window  []*batchObject
+	current io.Closer
+}
+
+// batchObject is an object read ahead, with its content if small enough.
+type batchObject struct {
+	BatchObject
+	obj     plumbing.EncodedObject
+	content []byte
+	err     error
+}
+
+// Next returns the next object, io.EOF when all are returned.
+func (iter *BatchObjectIter) Next() (*BatchObject, error) {
+	if err := iter.closeCurrent(); err != nil {
+		return nil, err
+	}
+
+	if len(iter.window) == 0 {
+		if len(iter.requests) == 0 {
+			return nil, io.EOF
+		}
+
+		n := batchWindow
+		if n > len(iter.requests) {
+			n = len(iter.requests)
+		}
+
+		iter.window = iter.readAhead(iter.requests[:n])
+		iter.requests = iter.requests[n:]
+	}
+
+	b := iter.window[0]
+	iter.window = iter.window[1:]
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	switch {
+	case b.Missing || iter.o.CheckOnly:
+	case b.content != nil:
+		b.Reader = bytes.NewReader(b.content)
+	default:
+		rc, err := b.obj.Reader()
+		if err != nil {
+			return nil, err
+		}
+
+		b.Reader, iter.current = rc, rc
+	}
+
+	return &b.BatchObject, nil
+}
+
+// ForEach calls the function with each object, until an error is returned,
+// storer.ErrStop stopping the iteration without error.
+func (iter *BatchObjectIter) ForEach(cb func(*BatchObject) error) error {
+	defer iter.Close()
+	for {
+		b, err := iter.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := cb(b); err != nil {
+			if err == storer.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// Close releases the resources of the iterator.
+func (iter *BatchObjectIter) Close() {
+	_ = iter.closeCurrent()
+	if iter.reader != nil {
+		_ = iter.reader.Close()
+		iter.reader = nil
+	}
+}
+
+func (iter *BatchObjectIter) closeCurrent() error {
+	if iter.current == nil {
+		return nil
+	}
+
+	err := iter.current.Close()
+	iter.current = nil
+	return err
+}
+
+// readAhead reads the objects of the requests, in the order of their offsets
+// in the packfiles, the loose objects last.
+func (iter *BatchObjectIter) readAhead(requests []BatchObjectRequest) []*batchObject {
+	window := make([]*batchObject, len(requests))
+	type location struct {
+		i      int
+		pack   plumbing.Hash
+		offset int64
+	}
+
+	locations := make([]location, 0, len(requests))
+	for i, req := range requests {
+		b := &batchObject{BatchObject: BatchObject{Hash: req.Hash, Path: req.Path}}
+		window[i] = b
+		if req.Path != "" {
+			b.Hash, b.Missing, b.err = iter.resolvePath(req)
+			if b.Missing || b.err != nil {
+				continue
+			}
+		}
+
+		loc := location{i: i, offset: -1}
+		if iter.reader != nil {
+			loc.pack, loc.offset = iter.reader.ObjectOffset(b.Hash)
+		}
+
+		locations = append(locations, loc)
+	}
+
+	sort.SliceStable(locations, func(i, j int) bool {
+		a, b := locations[i], locations[j]
+		if (a.offset == -1) != (b.offset == -1) {
+			return b.offset == -1
+		}
+
+		if a.pack != b.pack {
+			return bytes.Compare(a.pack[:], b.pack[:]) < 0
+		}
+
+		return a.offset < b.offset
+	})
+
+	for _, loc := range locations {
+		iter.read(window[loc.i])
+	}
+
+	return window
+}
+
+// resolvePath returns the hash of the object at the path of the request.
+func (iter *BatchObjectIter) resolvePath(req BatchObjectRequest) (plumbing.Hash, bool, error) {
+	obj, err := object.GetObject(iter.r.Storer, req.Hash)
+	if err == plumbing.ErrObjectNotFound {
+		return req.Hash, true, nil
+	}
+
+	if err != nil {
+		return req.Hash, false, err
+	}
+
+	var tree *object.Tree
+	switch o := obj.(type) {
+	case *object.Commit:
+		tree, err = o.Tree()
+	case *object.Tree:
+		tree = o
+	default:
+		return req.Hash, true, nil
+	}
+
+	if err != nil {
+		return req.Hash, false, err
+	}
+
+	entry, err := tree.FindEntry(req.Path)
+	if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+		return req.Hash, true, nil
+	}
+
+	if err != nil {
+		return req.Hash, false, err
+	}
+
+	return entry.Hash, false, nil
+}
+
+// read reads the type and size of the object, and its content if it is
+// small enough and requested.
+func (iter *BatchObjectIter) read(b *batchObject) {
+	if iter.o.CheckOnly {
+		b.Type, b.Size, b.err = iter.objectHeader(b.Hash)
+	} else {
+		b.obj, b.err = iter.encodedObject(b.Hash)
+		if b.err == nil {
+			b.Type, b.Size = b.obj.Type(), b.obj.Size()
+		}
+	}
+
+	if b.err == plumbing.ErrObjectNotFound {
+		b.Missing, b.err = true, nil
+		return
+	}
+
+	if b.err != nil || b.obj == nil || b.Size > batchPrefetchSize {
+		return
+	}
+
+	rc, err := b.obj.Reader()
+	if err != nil {
+		b.err = err
+		return
+	}
+
+	b.content, b.err = io.ReadAll(rc)
+	if err := rc.Close(); err != nil && b.err == nil {
+		b.err = err
+	}
+
+	if b.content == nil {
+		b.content = []byte{}
+	}
+}
+
+func (iter *BatchObjectIter) encodedObject(h plumbing.Hash) (plumbing.EncodedObject, error) {
+	if iter.reader != nil {
+		return iter.reader.EncodedObject(plumbing.AnyObject, h)
+	}
+
+	return iter.r.Storer.EncodedObject(plumbing.AnyObject, h)
+}
+
+func (iter *BatchObjectIter) objectHeader(h plumbing.Hash) (plumbing.ObjectType, int64, error) {
+	if iter.reader != nil {
+		return iter.reader.ObjectHeader(h)
+	}
+
+	obj, err := iter.r.Storer.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return plumbing.InvalidObject, 0, err
+	}
+
+	return obj.Type(), obj.Size(), nil
+}
@@ -0,0 +1,215 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type BatchObjectsSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&BatchObjectsSuite{})
+
+// batchRequests returns requests of all the objects of the repository, in
+// reverse order, along with requests of a missing object and paths.
+func (s *BatchObjectsSuite) batchRequests(c *C, r *Repository) []BatchObjectRequest {
+	iter, err := r.Storer.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+
+	var requests []BatchObjectRequest
+	c.Assert(iter.ForEach(func(obj plumbing.EncodedObject) error {
+		requests = append([]BatchObjectRequest{{Hash: obj.Hash()}}, requests...)
+		return nil
+	}), IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	return append(requests,
+		BatchObjectRequest{Hash: plumbing.NewHash("0000000000000000000000000000000000000001")},
+		BatchObjectRequest{Hash: head.Hash(), Path: "go/example.go"},
+		BatchObjectRequest{Hash: head.Hash(), Path: "go/missing.go"},
+	)
+}
+
+func (s *BatchObjectsSuite) testBatchObjects(c *C, r *Repository) {
+	requests := s.batchRequests(c, r)
+	iter, err := r.BatchObjects(requests, BatchObjectsOptions{})
+	c.Assert(err, IsNil)
+
+	i := 0
+	c.Assert(iter.ForEach(func(b *BatchObject) error {
+		req := requests[i]
+		i++
+
+		c.Assert(b.Path, Equals, req.Path)
+		if req.Path == "" {
+			c.Assert(b.Hash, Equals, req.Hash)
+		}
+
+		obj, err := r.Storer.EncodedObject(plumbing.AnyObject, b.Hash)
+		if err == plumbing.ErrObjectNotFound || req.Path == "go/missing.go" {
+			c.Assert(b.Missing, Equals, true)
+			c.Assert(b.Reader, IsNil)
+			return nil
+		}
+
+		c.Assert(err, IsNil)
+		c.Assert(b.Missing, Equals, false)
+		c.Assert(b.Type, Equals, obj.Type())
+		c.Assert(b.Size, Equals, obj.Size())
+
+		content, err := io.ReadAll(b.Reader)
+		c.Assert(err, IsNil)
+		c.Assert(plumbing.ComputeHash(b.Type, content), Equals, b.Hash)
+		return nil
+	}), IsNil)
+
+	c.Assert(i, Equals, len(requests))
+}
+
+func (s *BatchObjectsSuite) TestBatchObjects(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	s.testBatchObjects(c, r)
+}
+
+func (s *BatchObjectsSuite) TestBatchObjectsMemory(c *C) {
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	s.testBatchObjects(c, r)
+}
+
+func (s *BatchObjectsSuite) TestBatchObjectsCheckOnly(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	fs := fixtures.Basic().One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	requests := s.batchRequests(c, r)
+	iter, err := r.BatchObjects(requests, BatchObjectsOptions{CheckOnly: true})
+	c.Assert(err, IsNil)
+
+	var input, lines []string
+	for _, req := range requests {
+		if req.Path != "" {
+			input = append(input, fmt.Sprintf("%s:%s", req.Hash, req.Path))
+		} else {
+			input = append(input, req.Hash.String())
+		}
+	}
+
+	c.Assert(iter.ForEach(func(b *BatchObject) error {
+		c.Assert(b.Reader, IsNil)
+		switch {
+		case b.Missing && b.Path != "":
+			lines = append(lines, fmt.Sprintf("%s:%s missing", b.Hash, b.Path))
+		case b.Missing:
+			lines = append(lines, fmt.Sprintf("%s missing", b.Hash))
+		default:
+			lines = append(lines, fmt.Sprintf("%s %s %d", b.Hash, b.Type, b.Size))
+		}
+
+		return nil
+	}), IsNil)
+
+	cmd := exec.Command("git", "--git-dir", fs.Root(), "cat-file", "--batch-check")
+	cmd.Stdin = strings.NewReader(strings.Join(input, "\n") + "\n")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	c.Assert(lines, DeepEquals, strings.Split(strings.TrimSpace(string(out)), "\n"))
+}
+
+func BenchmarkBatchObjects(b *testing.B) {
+	defer fixtures.Clean()
+
+	fs := fixtures.ByURL("https://github.com/src-d/go-git.git").One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	files, err := commit.Files()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var requests []BatchObjectRequest
+	err = files.ForEach(func(f *object.File) error {
+		requests = append(requests, BatchObjectRequest{Hash: f.Hash})
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("objects", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, req := range requests {
+				blob, err := r.BlobObject(req.Hash)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				rd, err := blob.Reader()
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if _, err := io.Copy(io.Discard, rd); err != nil {
+					b.Fatal(err)
+				}
+
+				if err := rd.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			iter, err := r.BatchObjects(requests, BatchObjectsOptions{})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			err = iter.ForEach(func(o *BatchObject) error {
+				_, err := io.Copy(io.Discard, o.Reader)
+				return err
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,1156 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	// ErrBisectNotSupported is returned by Repository.Bisect when the
+	// repository is not stored in a filesystem, where the state of the
+	// bisection is kept.
+	ErrBisectNotSupported = errors.New("bisect not supported by the storage")
+	// ErrBisectNotStarted is returned when no bisection is in progress.
+	ErrBisectNotStarted = errors.New("not bisecting")
+	// ErrBisectRevisionsNeeded is returned by Bisect.Next when the bad
+	// commit or the good ones are not known yet.
+	ErrBisectRevisionsNeeded = errors.New("at least one good and one bad revision are needed")
+	// ErrBisectBadMergeBase is returned by Bisect.Next when a merge base of
+	// the bad commit and the good ones is bad, the bug having been fixed
+	// between them.
+	ErrBisectBadMergeBase = errors.New("a merge base of the good and bad commits is bad")
+	// ErrBisectBadIsGood is returned by Bisect.Next when the bad commit is
+	// reachable from a good one.
+	ErrBisectBadIsGood = errors.New("the bad commit is also good")
+	// ErrBisectSkip can be returned by the function given to Bisect.Run to
+	// skip a commit which can't be tested.
+	ErrBisectSkip = errors.New("skip the commit")
+)
+
+const (
+	bisectStartFile       = "BISECT_START"
+	bisectTermsFile       = "BISECT_TERMS"
+	bisectLogFile         = "BISECT_LOG"
+	bisectNamesFile       = "BISECT_NAMES"
+	bisectAncestorsOKFile = "BISECT_ANCESTORS_OK"
+	bisectRefPrefix       = "refs/bisect/"
+
+	bisectHead        plumbing.ReferenceName = "BISECT_HEAD"
+	bisectExpectedRev plumbing.ReferenceName = "BISECT_EXPECTED_REV"
+
+	bisectSkip = "skip"
+)
+
+// Bisect is the bisection of a repository, finding the commit which
+// introduced a bug by a binary search in the history, as `git bisect` does.
+//
+// Its state is kept in the BISECT_* files and the refs/bisect references of
+// the repository, as git does, so a bisection can be started by git and
+// continued by Bisect, or the other way around. The commits to test are
+// checked out, unless the bisection is started without checkout.
+type Bisect struct {
+	r  *Repository
+	fs billy.Filesystem
+}
+
+// BisectResult is a step of a bisection.
+type BisectResult struct {
+	// Commit is the commit to test, or the first bad commit if Found. It is
+	// nil when only skipped commits are left to test.
+	Commit *object.Commit
+	// Found tells whether Commit is the first bad commit.
+	Found bool
+	// MergeBase tells whether Commit is a merge base of the bad commit and
+	// of a good one which is not its ancestor, which has to be tested first.
+	MergeBase bool
+	// Candidates are the commits which can be the first bad one when only
+	// skipped commits are left to test, in the order of git rev-list.
+	Candidates []*object.Commit
+	// Remaining is the number of commits left to test after Commit.
+	Remaining int
+	// Steps is the estimated number of steps left after Commit.
+	Steps int
+}
+
+// Bisect returns the bisection of the repository, in progress or not.
+func (r *Repository) Bisect() (*Bisect, error) {
+	fs, ok := storageFilesystem(r.Storer)
+	if !ok {
+		return nil, ErrBisectNotSupported
+	}
+
+	return &Bisect{r: r, fs: fs}, nil
+}
+
+// Start starts a bisection, as git bisect start does, removing the state of
+// the one in progress if any. The bad commit and the good ones, if given,
+// are marked, and Next gives the first commit to test.
+func (b *Bisect) Start(o *BisectStartOptions) error {
+	if o.Bad.IsZero() && len(o.Good) != 0 {
+		return fmt.Errorf("%w: the good commits are given without a bad one", ErrBisectRevisionsNeeded)
+	}
+
+	for _, h := range append([]plumbing.Hash{o.Bad}, o.Good...) {
+		if h.IsZero() {
+			continue
+		}
+
+		if _, err := b.r.CommitObject(h); err != nil {
+			return err
+		}
+	}
+
+	noCheckout := o.NoCheckout || b.r.wt == nil
+
+	// A bisection started again goes on from the same commit.
+	start, err := b.readFile(bisectStartFile)
+	if err != nil {
+		return err
+	}
+
+	start = strings.TrimSpace(start)
+	if start != "" {
+		if !noCheckout {
+			if err := b.checkoutStart(start); err != nil {
+				return err
+			}
+		}
+	} else if start, err = b.startHead(); err != nil {
+		return err
+	}
+
+	if err := b.cleanState(); err != nil {
+		return err
+	}
+
+	if noCheckout {
+		h, err := b.r.ResolveRevision(plumbing.Revision(start))
+		if err != nil {
+			return err
+		}
+
+		if err := b.r.Storer.SetReference(plumbing.NewHashReference(bisectHead, *h)); err != nil {
+			return err
+		}
+	}
+
+	if err := util.WriteFile(b.fs, bisectStartFile, []byte(start+"\n"), 0o644); err != nil {
+		return err
+	}
+
+	if err := util.WriteFile(b.fs, bisectNamesFile, []byte("\n"), 0o644); err != nil {
+		return err
+	}
+
+	args := "git bisect start"
+	if o.NoCheckout {
+		args += " " + shellQuote("--no-checkout")
+	}
+
+	if !o.Bad.IsZero() {
+		if err := b.mark("bad", o.Bad, false); err != nil {
+			return err
+		}
+
+		args += " " + shellQuote(o.Bad.String())
+	}
+
+	for _, h := range o.Good {
+		if err := b.mark("good", h, false); err != nil {
+			return err
+		}
+
+		args += " " + shellQuote(h.String())
+	}
+
+	if err := util.WriteFile(b.fs, bisectTermsFile, []byte("bad\ngood\n"), 0o644); err != nil {
+		return err
+	}
+
+	return b.appendLog(args + "\n")
+}
+
+// startHead returns the branch HEAD points to, or its commit if detached.
+func (b *Bisect) startHead() (string, error) {
+	head, err := b.r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	if head.Type() == plumbing.HashReference {
+		return head.Hash().String(), nil
+	}
+
+	if !head.Target().IsBranch() {
+		return "", fmt.Errorf("bad HEAD - strange symbolic ref %s", head.Target())
+	}
+
+	if _, err := b.r.Reference(head.Target(), true); err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(head.Target().String(), "refs/heads/"), nil
+}
+
+// checkoutStart checks out the branch or the commit the bisection started
+// from.
+func (b *Bisect) checkoutStart(start string) error {
+	w, err := b.r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	if plumbing.IsHash(start) {
+		return w.Checkout(&CheckoutOptions{Hash: plumbing.NewHash(start)})
+	}
+
+	return w.Checkout(&CheckoutOptions{Branch: plumbing.NewBranchReferenceName(start)})
+}
+
+// MarkGood marks commits as good, the commit being tested if none is given.
+func (b *Bisect) MarkGood(commits ...plumbing.Hash) error {
+	_, good, err := b.terms()
+	if err != nil {
+		return err
+	}
+
+	return b.markState(good, commits)
+}
+
+// MarkBad marks a commit as bad, the commit being tested if zero.
+func (b *Bisect) MarkBad(commit plumbing.Hash) error {
+	bad, _, err := b.terms()
+	if err != nil {
+		return err
+	}
+
+	var commits []plumbing.Hash
+	if !commit.IsZero() {
+		commits = append(commits, commit)
+	}
+
+	return b.markState(bad, commits)
+}
+
+// Skip marks commits as not testable, the commit being tested if none is
+// given. A commit next to them is tested instead.
+func (b *Bisect) Skip(commits ...plumbing.Hash) error {
+	return b.markState(bisectSkip, commits)
+}
+
+func (b *Bisect) markState(state string, commits []plumbing.Hash) error {
+	if err := b.checkStarted(); err != nil {
+		return err
+	}
+
+	if len(commits) == 0 {
+		current, err := b.current()
+		if err != nil {
+			return err
+		}
+
+		commits = append(commits, current)
+	}
+
+	expected, err := b.r.Storer.Reference(bisectExpectedRev)
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+
+	for _, h := range commits {
+		if err := b.mark(state, h, true); err != nil {
+			return err
+		}
+
+		// The merge bases are checked again if another commit than the
+		// expected one is marked.
+		if expected != nil && expected.Hash() != h {
+			if err := b.removeFile(bisectAncestorsOKFile); err != nil {
+				return err
+			}
+
+			if err := b.removeReference(bisectExpectedRev); err != nil {
+				return err
+			}
+
+			expected = nil
+		}
+	}
+
+	return nil
+}
+
+// mark sets the reference of the state of a commit and logs it.
+func (b *Bisect) mark(state string, h plumbing.Hash, log bool) error {
+	commit, err := b.r.CommitObject(h)
+	if err != nil {
+		return err
+	}
+
+	bad, _, err := b.terms()
+	if err != nil {
+		return err
+	}
+
+	name := plumbing.ReferenceName(bisectRefPrefix + state)
+	if state != bad {
+		name = plumbing.ReferenceName(bisectRefPrefix + state + "-" + h.String())
+	}
+
+	if err := b.r.Storer.SetReference(plumbing.NewHashReference(name, h)); err != nil {
+		return err
+	}
+
+	entry := fmt.Sprintf("# %s: [%s] %s\n", state, h, formatSubject(commit.Message))
+	if log {
+		entry += fmt.Sprintf("git bisect %s %s\n", state, h)
+	}
+
+	return b.appendLog(entry)
+}
+
+// current returns the commit being tested.
+func (b *Bisect) current() (plumbing.Hash, error) {
+	ref, err := b.r.Storer.Reference(bisectHead)
+	if err == nil {
+		return ref.Hash(), nil
+	}
+
+	if err != plumbing.ErrReferenceNotFound {
+		return plumbing.ZeroHash, err
+	}
+
+	head, err := b.r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return head.Hash(), nil
+}
+
+// Next returns the next step of the bisection, as git does after a commit is
+// marked: the commit to test, which is checked out, or the first bad commit
+// once found.
+//
+// The commit to test is the one splitting the commits which can be the first
+// bad one in two halves, weighted by the number of these commits each one
+// can reach, as git does. The merge bases of the bad commit and of the good
+// commits which are not its ancestors are tested first.
+func (b *Bisect) Next() (*BisectResult, error) {
+	if err := b.checkStarted(); err != nil {
+		return nil, err
+	}
+
+	state, err := b.readState()
+	if err != nil {
+		return nil, err
+	}
+
+	if state.bad.IsZero() || len(state.good) == 0 {
+		return nil, ErrBisectRevisionsNeeded
+	}
+
+	if res, err := b.checkMergeBases(state); res != nil || err != nil {
+		return res, err
+	}
+
+	walk := &bisectWalk{
+		r:       b.r,
+		commits: make(map[plumbing.Hash]*object.Commit),
+		flags:   make(map[plumbing.Hash]uint8),
+		weights: make(map[plumbing.Hash]int),
+	}
+
+	list, err := walk.candidates(state.bad, state.good)
+	if err != nil {
+		return nil, err
+	}
+
+	all := len(list)
+	best, err := walk.findBisection(list, len(state.skip) != 0)
+	if err != nil {
+		return nil, err
+	}
+
+	reaches := 0
+	if len(best) != 0 {
+		reaches = walk.weights[best[0].Hash]
+	}
+
+	best, tried := managedSkipped(best, state.skip, state.bad)
+	if len(best) == 0 && len(tried) == 0 {
+		return nil, fmt.Errorf("%w: %s was both good and bad", ErrBisectBadIsGood, state.bad)
+	}
+
+	if len(best) == 0 || best[0].Hash == state.bad && len(tried) != 0 {
+		return b.onlySkipped(state, walk, list)
+	}
+
+	commit := best[0]
+	if commit.Hash == state.bad {
+		if err := b.appendLog(fmt.Sprintf("# first %s commit: [%s] %s\n",
+			state.termBad, commit.Hash, formatSubject(commit.Message))); err != nil {
+			return nil, err
+		}
+
+		return &BisectResult{Commit: commit, Found: true}, nil
+	}
+
+	if err := b.checkout(commit.Hash); err != nil {
+		return nil, err
+	}
+
+	return &BisectResult{
+		Commit:    commit,
+		Remaining: all - reaches - 1,
+		Steps:     estimateBisectSteps(all),
+	}, nil
+}
+
+// onlySkipped returns the result of a bisection whose commits left to test
+// are all skipped.
+func (b *Bisect) onlySkipped(state *bisectState, walk *bisectWalk, list []*object.Commit) (*BisectResult, error) {
+	log := "# only skipped commits left to test\n"
+	res := &BisectResult{}
+
+	// The candidates are in the order of the walk, the newest first.
+	for i := len(list) - 1; i >= 0; i-- {
+		c := list[i]
+		res.Candidates = append(res.Candidates, c)
+		log += fmt.Sprintf("# possible first %s commit: [%s] %s\n", state.termBad, c.Hash, formatSubject(c.Message))
+	}
+
+	return res, b.appendLog(log)
+}
+
+// checkMergeBases returns the merge base to test first, if the good commits
+// are not all ancestors of the bad one.
+func (b *Bisect) checkMergeBases(state *bisectState) (*BisectResult, error) {
+	if _, err := b.fs.Stat(bisectAncestorsOKFile); err == nil {
+		return nil, nil
+	}
+
+	bad, err := b.r.CommitObject(state.bad)
+	if err != nil {
+		return nil, err
+	}
+
+	var bases []*object.Commit
+	ancestors := true
+	for _, h := range state.good {
+		good, err := b.r.CommitObject(h)
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := good.IsAncestor(bad)
+		if err != nil {
+			return nil, err
+		}
+
+		ancestors = ancestors && ok
+		mb, err := bad.MergeBase(good)
+		if err != nil {
+			return nil, err
+		}
+
+		bases = append(bases, mb...)
+	}
+
+	if ancestors {
+		bases = nil
+	}
+
+	if len(bases) != 0 {
+		if bases, err = object.Independents(bases); err != nil {
+			return nil, err
+		}
+
+		sort.SliceStable(bases, func(i, j int) bool {
+			return bases[i].Committer.When.After(bases[j].Committer.When)
+		})
+	}
+
+	for _, mb := range bases {
+		switch {
+		case mb.Hash == state.bad:
+			return nil, fmt.Errorf("%w: the bug has been fixed between %s and %s", ErrBisectBadMergeBase, mb.Hash, state.good)
+		case hashIn(state.good, mb.Hash), hashIn(state.skip, mb.Hash):
+			continue
+		}
+
+		if err := b.checkout(mb.Hash); err != nil {
+			return nil, err
+		}
+
+		return &BisectResult{Commit: mb, MergeBase: true}, nil
+	}
+
+	return nil, util.WriteFile(b.fs, bisectAncestorsOKFile, nil, 0o600)
+}
+
+// checkout checks out the commit to test, or sets BISECT_HEAD to it.
+func (b *Bisect) checkout(h plumbing.Hash) error {
+	if err := b.r.Storer.SetReference(plumbing.NewHashReference(bisectExpectedRev, h)); err != nil {
+		return err
+	}
+
+	if _, err := b.r.Storer.Reference(bisectHead); err == nil {
+		return b.r.Storer.SetReference(plumbing.NewHashReference(bisectHead, h))
+	}
+
+	w, err := b.r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	return w.Checkout(&CheckoutOptions{Hash: h})
+}
+
+// Run runs the bisection until the first bad commit is found, testing the
+// commits with the given function, which returns whether a commit is good,
+// or ErrBisectSkip if it can't be tested. The bisection must have been
+// started with a bad commit and a good one.
+func (b *Bisect) Run(test func(*object.Commit) (bool, error)) (*BisectResult, error) {
+	for {
+		res, err := b.Next()
+		if err != nil || res.Found || res.Commit == nil {
+			return res, err
+		}
+
+		good, err := test(res.Commit)
+		switch {
+		case errors.Is(err, ErrBisectSkip):
+			err = b.Skip(res.Commit.Hash)
+		case err != nil:
+			return nil, err
+		case good:
+			err = b.MarkGood(res.Commit.Hash)
+		default:
+			err = b.MarkBad(res.Commit.Hash)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Reset ends the bisection, as git bisect reset does, checking out the
+// branch or the commit it was started from, unless started without
+// checkout, and removing its state.
+func (b *Bisect) Reset() error {
+	start, err := b.readFile(bisectStartFile)
+	if err != nil {
+		return err
+	}
+
+	if start = strings.TrimSpace(start); start == "" {
+		return ErrBisectNotStarted
+	}
+
+	if _, err := b.r.Storer.Reference(bisectHead); err == plumbing.ErrReferenceNotFound {
+		if err := b.checkoutStart(start); err != nil {
+			return err
+		}
+	}
+
+	return b.cleanState()
+}
+
+// cleanState removes the state of the bisection.
+func (b *Bisect) cleanState() error {
+	refs, err := b.r.References()
+	if err != nil {
+		return err
+	}
+
+	var names []plumbing.ReferenceName
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), bisectRefPrefix) {
+			names = append(names, ref.Name())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range append(names, bisectExpectedRev, bisectHead) {
+		if err := b.removeReference(name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range []string{
+		bisectAncestorsOKFile, bisectLogFile, bisectTermsFile, bisectNamesFile,
+		"BISECT_RUN", "BISECT_FIRST_PARENT", bisectStartFile,
+	} {
+		if err := b.removeFile(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bisectState is the state of a bisection, read from its references.
+type bisectState struct {
+	termBad, termGood string
+
+	bad  plumbing.Hash
+	good []plumbing.Hash
+	skip []plumbing.Hash
+}
+
+func (b *Bisect) readState() (*bisectState, error) {
+	bad, good, err := b.terms()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &bisectState{termBad: bad, termGood: good}
+	refs, err := b.r.References()
+	if err != nil {
+		return nil, err
+	}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name, ok := strings.CutPrefix(ref.Name().String(), bisectRefPrefix)
+		switch {
+		case !ok || ref.Type() != plumbing.HashReference:
+		case name == bad:
+			state.bad = ref.Hash()
+		case strings.HasPrefix(name, good+"-"):
+			state.good = append(state.good, ref.Hash())
+		case strings.HasPrefix(name, bisectSkip+"-"):
+			state.skip = append(state.skip, ref.Hash())
+		}
+
+		return nil
+	})
+
+	// The good commits are walked in the order of their references.
+	sort.Slice(state.good, func(i, j int) bool {
+		return state.good[i].String() < state.good[j].String()
+	})
+
+	return state, err
+}
+
+// terms returns the terms of the bad and good commits, "bad" and "good"
+// unless others are given to git bisect start.
+func (b *Bisect) terms() (string, string, error) {
+	content, err := b.readFile(bisectTermsFile)
+	if err != nil {
+		return "", "", err
+	}
+
+	terms := strings.Fields(content)
+	if len(terms) < 2 {
+		return "bad", "good", nil
+	}
+
+	return terms[0], terms[1], nil
+}
+
+func (b *Bisect) checkStarted() error {
+	start, err := b.readFile(bisectStartFile)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(start) == "" {
+		return ErrBisectNotStarted
+	}
+
+	return nil
+}
+
+// readFile returns the content of a file of the git directory, empty if it
+// doesn't exist.
+func (b *Bisect) readFile(name string) (string, error) {
+	content, err := util.ReadFile(b.fs, name)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	return string(content), err
+}
+
+func (b *Bisect) appendLog(s string) error {
+	f, err := b.fs.OpenFile(bisectLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(f, s); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (b *Bisect) removeFile(name string) error {
+	if err := b.fs.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (b *Bisect) removeReference(name plumbing.ReferenceName) error {
+	if _, err := b.r.Storer.Reference(name); err == plumbing.ErrReferenceNotFound {
+		return nil
+	}
+
+	return b.r.Storer.RemoveReference(name)
+}
+
+const (
+	bisectSeen uint8 = 1 << iota
+	bisectUninteresting
+	bisectCounted
+)
+
+// bisectWalk finds the commit to test, walking the history as git does, so
+// the same commit is chosen.
+type bisectWalk struct {
+	r       *Repository
+	commits map[plumbing.Hash]*object.Commit
+	flags   map[plumbing.Hash]uint8
+	weights map[plumbing.Hash]int
+}
+
+func (w *bisectWalk) commit(h plumbing.Hash) (*object.Commit, error) {
+	if c, ok := w.commits[h]; ok {
+		return c, nil
+	}
+
+	c, err := w.r.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+
+	w.commits[h] = c
+	return c, nil
+}
+
+// candidates returns the commits reachable from the bad commit but not from
+// the good ones, the oldest first, in the reverse order of a walk by commit
+// date, as git rev-list does.
+func (w *bisectWalk) candidates(bad plumbing.Hash, good []plumbing.Hash) ([]*object.Commit, error) {
+	var queue []*object.Commit
+	for i, h := range append([]plumbing.Hash{bad}, good...) {
+		c, err := w.commit(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if i != 0 {
+			w.flags[h] |= bisectUninteresting
+			w.markParentsUninteresting(c)
+		}
+
+		if w.flags[h]&bisectSeen == 0 {
+			w.flags[h] |= bisectSeen
+			queue = append(queue, c)
+		}
+	}
+
+	sort.SliceStable(queue, func(i, j int) bool {
+		return queue[i].Committer.When.Unix() > queue[j].Committer.When.Unix()
+	})
+
+	const slop = 5
+	remaining := slop
+	date := int64(1<<63 - 1)
+
+	var list []*object.Commit
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		var err error
+		if queue, err = w.processParents(c, queue); err != nil {
+			return nil, err
+		}
+
+		if w.flags[c.Hash]&bisectUninteresting != 0 {
+			w.markParentsUninteresting(c)
+
+			// The walk goes on while interesting commits may be found.
+			switch {
+			case len(queue) == 0:
+				remaining = 0
+			case date <= queue[0].Committer.When.Unix() || !w.everybodyUninteresting(queue):
+				remaining = slop
+			default:
+				remaining--
+			}
+
+			if remaining > 0 {
+				continue
+			}
+
+			break
+		}
+
+		date = c.Committer.When.Unix()
+		list = append(list, c)
+	}
+
+	// The commits marked uninteresting after being walked are removed.
+	res := make([]*object.Commit, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		if w.flags[list[i].Hash]&bisectUninteresting == 0 {
+			res = append(res, list[i])
+		}
+	}
+
+	return res, nil
+}
+
+// processParents adds the parents of the commit not seen yet to the queue,
+// sorted by commit date.
+func (w *bisectWalk) processParents(c *object.Commit, queue []*object.Commit) ([]*object.Commit, error) {
+	uninteresting := w.flags[c.Hash]&bisectUninteresting != 0
+	for _, h := range c.ParentHashes {
+		p, err := w.commit(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if uninteresting {
+			w.flags[h] |= bisectUninteresting
+			w.markParentsUninteresting(p)
+		}
+
+		if w.flags[h]&bisectSeen != 0 {
+			continue
+		}
+
+		w.flags[h] |= bisectSeen
+		i := sort.Search(len(queue), func(i int) bool {
+			return queue[i].Committer.When.Unix() < p.Committer.When.Unix()
+		})
+
+		queue = append(queue, nil)
+		copy(queue[i+1:], queue[i:])
+		queue[i] = p
+	}
+
+	return queue, nil
+}
+
+// markParentsUninteresting marks the ancestors of the commit already read as
+// uninteresting.
+func (w *bisectWalk) markParentsUninteresting(c *object.Commit) {
+	pending := append([]plumbing.Hash(nil), c.ParentHashes...)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if w.flags[h]&bisectUninteresting != 0 {
+			continue
+		}
+
+		w.flags[h] |= bisectUninteresting
+		if p, ok := w.commits[h]; ok {
+			pending = append(pending, p.ParentHashes...)
+		}
+	}
+}
+
+func (w *bisectWalk) everybodyUninteresting(queue []*object.Commit) bool {
+	for _, c := range queue {
+		if w.flags[c.Hash]&bisectUninteresting == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// findBisection returns the best commit to test, the one whose number of
+// reachable candidates is the closest to the half of them, or all the
+// candidates sorted from the best one if all is true.
+func (w *bisectWalk) findBisection(list []*object.Commit, all bool) ([]*object.Commit, error) {
+	nr := len(list)
+	counted := 0
+	for _, c := range list {
+		switch w.interestingParents(c) {
+		case 0:
+			w.weights[c.Hash] = 1
+			counted++
+		case 1:
+			w.weights[c.Hash] = -1
+		default:
+			w.weights[c.Hash] = -2
+		}
+	}
+
+	// The merges count the commits they can reach.
+	for _, c := range list {
+		if w.weights[c.Hash] != -2 {
+			continue
+		}
+
+		weight, err := w.countDistance(c.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		w.weights[c.Hash] = weight
+		for _, c := range list {
+			w.flags[c.Hash] &^= bisectCounted
+		}
+
+		if !all && halfway(weight, nr) {
+			return []*object.Commit{c}, nil
+		}
+
+		counted++
+	}
+
+	// The other commits reach one more commit than their parent.
+	for progress := true; counted < nr && progress; {
+		progress = false
+		for _, c := range list {
+			if w.weights[c.Hash] >= 0 {
+				continue
+			}
+
+			weight := -1
+			for _, p := range c.ParentHashes {
+				if w.flags[p]&bisectUninteresting != 0 {
+					continue
+				}
+
+				if pw, ok := w.weights[p]; ok && pw >= 0 {
+					weight = pw
+					break
+				}
+			}
+
+			if weight < 0 {
+				continue
+			}
+
+			w.weights[c.Hash] = weight + 1
+			counted++
+			progress = true
+			if !all && halfway(weight+1, nr) {
+				return []*object.Commit{c}, nil
+			}
+		}
+	}
+
+	distance := func(c *object.Commit) int {
+		weight := w.weights[c.Hash]
+		if nr-weight < weight {
+			return nr - weight
+		}
+
+		return weight
+	}
+
+	if !all {
+		var best *object.Commit
+		bestDistance := -1
+		for _, c := range list {
+			if d := distance(c); d > bestDistance {
+				best, bestDistance = c, d
+			}
+		}
+
+		if best == nil {
+			return nil, nil
+		}
+
+		return []*object.Commit{best}, nil
+	}
+
+	sorted := append([]*object.Commit(nil), list...)
+	sort.Slice(sorted, func(i, j int) bool {
+		di, dj := distance(sorted[i]), distance(sorted[j])
+		if di != dj {
+			return di > dj
+		}
+
+		return sorted[i].Hash.String() < sorted[j].Hash.String()
+	})
+
+	return sorted, nil
+}
+
+func (w *bisectWalk) interestingParents(c *object.Commit) int {
+	n := 0
+	for _, p := range c.ParentHashes {
+		if w.flags[p]&bisectUninteresting == 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// countDistance returns the number of candidates reachable from a commit,
+// marking them as counted.
+func (w *bisectWalk) countDistance(h plumbing.Hash) (int, error) {
+	n := 0
+	for w.flags[h]&(bisectUninteresting|bisectCounted) == 0 {
+		n++
+		w.flags[h] |= bisectCounted
+		c, err := w.commit(h)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(c.ParentHashes) == 0 {
+			break
+		}
+
+		for _, p := range c.ParentHashes[1:] {
+			d, err := w.countDistance(p)
+			if err != nil {
+				return 0, err
+			}
+
+			n += d
+		}
+
+		h = c.ParentHashes[0]
+	}
+
+	return n, nil
+}
+
+// halfway tells whether a commit reaching weight of the nr candidates splits
+// them in two halves.
+func halfway(weight, nr int) bool {
+	d := 2*weight - nr
+	return d >= -1 && d <= 1
+}
+
+// managedSkipped returns the commits to test but the skipped ones, and the
+// skipped ones tried. If the best commit is skipped, another one is picked
+// away from it, pseudo-randomly as git does.
+func managedSkipped(list []*object.Commit, skipped []plumbing.Hash, bad plumbing.Hash) ([]*object.Commit, []*object.Commit) {
+	if len(skipped) == 0 {
+		return list, nil
+	}
+
+	var filtered, tried []*object.Commit
+	for i, c := range list {
+		if hashIn(skipped, c.Hash) {
+			tried = append(tried, c)
+			continue
+		}
+
+		if i == 0 {
+			return []*object.Commit{c}, nil
+		}
+
+		filtered = append(filtered, c)
+	}
+
+	return skipAway(filtered, bad), tried
+}
+
+// skipAway returns the commits from one picked at a pseudo-random position,
+// the same as git, the one before it if it is the bad commit.
+func skipAway(list []*object.Commit, bad plumbing.Hash) []*object.Commit {
+	const prnModulo = 32768
+
+	count := len(list)
+	prn := int((uint32(count)*1103515245 + 12345) / 65536 % prnModulo)
+	index := (count * prn / prnModulo) * sqrti(prn) / sqrti(prnModulo)
+	if index >= count {
+		return list
+	}
+
+	if list[index].Hash != bad {
+		return list[index:]
+	}
+
+	if index > 0 {
+		return list[index-1:]
+	}
+
+	return list
+}
+
+// sqrti returns the integer square root of val, computed as git does.
+func sqrti(val int) int {
+	if val == 0 {
+		return 0
+	}
+
+	x := float32(val)
+	for {
+		y := (x + float32(val)/x) / 2
+		d := y - x
+		if d < 0 {
+			d = -d
+		}
+
+		x = y
+		if d < 0.5 {
+			return int(x)
+		}
+	}
+}
+
+// estimateBisectSteps returns the estimated number of steps to find the
+// first bad commit among all, as git does.
+func estimateBisectSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+
+	n := bits.Len(uint(all)) - 1
+	e := 1 << n
+	x := all - e
+	if e < 3*x {
+		return n
+	}
+
+	return n - 1
+}
+
+// formatSubject returns the subject of a commit message, its first paragraph
+// on one line, as the %s placeholder of git log.
+func formatSubject(msg string) string {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimLeft(msg, "\n"), "\n") {
+		l = strings.TrimRight(l, " \t\r")
+		if strings.TrimSpace(l) == "" {
+			break
+		}
+
+		lines = append(lines, l)
+	}
+
+	return strings.Join(lines, " ")
+}
+
+func hashIn(hashes []plumbing.Hash, h plumbing.Hash) bool {
+	for _, other := range hashes {
+		if other == h {
+			return true
+		}
+	}
+
+	return false
+}
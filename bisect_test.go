@@ -0,0 +1,330 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type BisectSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&BisectSuite{})
+
+// bisectRepository returns a bare repository with a history of merges, and
+// its commits by message.
+func (s *BisectSuite) bisectRepository(c *C) (*Repository, string, map[string]plumbing.Hash) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, true)
+	c.Assert(err, IsNil)
+
+	commits := make(map[string]plumbing.Hash)
+	b := &historyBuilder{c: c, r: r, when: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	add := func(msg string, parents ...plumbing.Hash) plumbing.Hash {
+		h := b.commit(msg, map[string]string{"file": msg}, parents...)
+		commits[msg] = h
+		return h
+	}
+
+	tip := add("root")
+	for i := 0; i < 6; i++ {
+		tip = add(fmt.Sprintf("main %d", i), tip)
+	}
+
+	side := tip
+	for i := 0; i < 8; i++ {
+		side = add(fmt.Sprintf("side %d", i), side)
+		if i%3 == 0 {
+			tip = add(fmt.Sprintf("main %d", i+6), tip)
+		}
+	}
+
+	tip = add("merge", tip, side)
+	for i := 0; i < 5; i++ {
+		tip = add(fmt.Sprintf("after %d", i), tip)
+	}
+
+	c.Assert(r.Storer.SetReference(plumbing.NewHashReference("refs/heads/master", tip)), IsNil)
+	return r, dir, commits
+}
+
+func copyGitDir(c *C, dir string) string {
+	dst := filepath.Join(c.MkDir(), "copy.git")
+	out, err := exec.Command("cp", "-r", dir, dst).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	return dst
+}
+
+func gitBisect(c *C, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"bisect"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+
+	// git fails once only skipped commits are left to test.
+	if !strings.Contains(string(out), "only 'skip'ped commits left") {
+		c.Assert(err, IsNil, Commentf("%s", out))
+	}
+
+	return string(out)
+}
+
+func readGitDirFile(c *C, dir, name string) string {
+	content, err := util.ReadFile(osfs.New(dir), name)
+	c.Assert(err, IsNil)
+	return string(content)
+}
+
+// bisectLikeGit bisects the repository in lockstep with git, the commits
+// reachable from culprit being bad, and the skipped ones skipped.
+func (s *BisectSuite) bisectLikeGit(c *C, culprit string, skipped ...string) {
+	r, dir, commits := s.bisectRepository(c)
+	gitDir := copyGitDir(c, dir)
+
+	bad := commits["after 4"]
+	good := commits["main 2"]
+	b, err := r.Bisect()
+	c.Assert(err, IsNil)
+	c.Assert(b.Start(&BisectStartOptions{Bad: bad, Good: []plumbing.Hash{good}}), IsNil)
+	out := gitBisect(c, gitDir, "start", bad.String(), good.String())
+
+	culpritCommit, err := r.CommitObject(commits[culprit])
+	c.Assert(err, IsNil)
+
+	for i := 0; ; i++ {
+		c.Assert(i < len(commits), Equals, true)
+
+		res, err := b.Next()
+		c.Assert(err, IsNil)
+		if res.Commit == nil || res.Found {
+			c.Assert(readGitDirFile(c, dir, bisectLogFile), Equals, readGitDirFile(c, gitDir, bisectLogFile))
+			if res.Found {
+				c.Assert(res.Commit.Hash, Equals, commits[culprit])
+			}
+
+			return
+		}
+
+		head, err := r.Reference(bisectHead, false)
+		c.Assert(err, IsNil)
+		c.Assert(head.Hash(), Equals, res.Commit.Hash)
+		c.Assert(revList(c, gitDir, "-1", "BISECT_HEAD"), DeepEquals, []string{res.Commit.Hash.String()})
+		c.Assert(out, Matches, fmt.Sprintf("(?s).*Bisecting: %d revisions? left to test after this \\(roughly %d steps?\\).*", res.Remaining, res.Steps))
+
+		isBad, err := culpritCommit.IsAncestor(res.Commit)
+		c.Assert(err, IsNil)
+		switch {
+		case res.Commit.Hash == culpritCommit.Hash || isBad:
+			c.Assert(b.MarkBad(plumbing.ZeroHash), IsNil)
+			out = gitBisect(c, gitDir, "bad")
+		case inSkipped(res.Commit, skipped):
+			c.Assert(b.Skip(), IsNil)
+			out = gitBisect(c, gitDir, "skip")
+		default:
+			c.Assert(b.MarkGood(), IsNil)
+			out = gitBisect(c, gitDir, "good")
+		}
+	}
+}
+
+func inSkipped(c *object.Commit, skipped []string) bool {
+	for _, msg := range skipped {
+		if c.Message == msg {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *BisectSuite) TestBisectLikeGit(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	for _, culprit := range []string{"main 3", "main 5", "side 0", "side 4", "main 9", "merge", "after 2", "after 4"} {
+		s.bisectLikeGit(c, culprit)
+	}
+}
+
+func (s *BisectSuite) TestBisectSkipLikeGit(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	s.bisectLikeGit(c, "side 4", "side 3", "side 4", "side 5", "main 7")
+	s.bisectLikeGit(c, "after 1", "merge", "after 0", "after 1", "after 2")
+	s.bisectLikeGit(c, "side 2", "side 1", "side 3", "main 6", "main 9")
+}
+
+func (s *BisectSuite) TestBisectOnlySkipped(c *C) {
+	r, _, commits := s.bisectRepository(c)
+	b, err := r.Bisect()
+	c.Assert(err, IsNil)
+
+	c.Assert(b.Start(&BisectStartOptions{Bad: commits["after 3"], Good: []plumbing.Hash{commits["after 1"]}}), IsNil)
+	res, err := b.Next()
+	c.Assert(err, IsNil)
+	c.Assert(res.Commit.Hash, Equals, commits["after 2"])
+	c.Assert(res.Remaining, Equals, 0)
+
+	c.Assert(b.Skip(), IsNil)
+	res, err = b.Next()
+	c.Assert(err, IsNil)
+	c.Assert(res.Commit, IsNil)
+	c.Assert(res.Found, Equals, false)
+	c.Assert(res.Candidates, HasLen, 2)
+	c.Assert(res.Candidates[0].Hash, Equals, commits["after 3"])
+	c.Assert(res.Candidates[1].Hash, Equals, commits["after 2"])
+}
+
+func (s *BisectSuite) TestBisectMergeBase(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	r, dir, commits := s.bisectRepository(c)
+	gitDir := copyGitDir(c, dir)
+
+	// The side branch is not an ancestor of main 9, so their merge base is
+	// tested first.
+	bad, good := commits["main 9"], commits["side 5"]
+	b, err := r.Bisect()
+	c.Assert(err, IsNil)
+	c.Assert(b.Start(&BisectStartOptions{Bad: bad, Good: []plumbing.Hash{good}}), IsNil)
+	gitBisect(c, gitDir, "start", bad.String(), good.String())
+
+	res, err := b.Next()
+	c.Assert(err, IsNil)
+	c.Assert(res.MergeBase, Equals, true)
+	c.Assert(res.Commit.Hash, Equals, commits["main 5"])
+	c.Assert(revList(c, gitDir, "-1", "BISECT_HEAD"), DeepEquals, []string{res.Commit.Hash.String()})
+
+	c.Assert(b.MarkBad(plumbing.ZeroHash), IsNil)
+	_, err = b.Next()
+	c.Assert(err, ErrorMatches, ErrBisectBadMergeBase.Error()+".*")
+}
+
+func (s *BisectSuite) TestBisectRun(c *C) {
+	r, _, commits := s.bisectRepository(c)
+	b, err := r.Bisect()
+	c.Assert(err, IsNil)
+
+	c.Assert(b.Start(&BisectStartOptions{Bad: commits["after 4"], Good: []plumbing.Hash{commits["root"]}}), IsNil)
+
+	culprit, err := r.CommitObject(commits["side 3"])
+	c.Assert(err, IsNil)
+
+	tested := 0
+	res, err := b.Run(func(commit *object.Commit) (bool, error) {
+		tested++
+		if commit.Message == "after 0" {
+			return false, ErrBisectSkip
+		}
+
+		bad, err := culprit.IsAncestor(commit)
+		return !bad, err
+	})
+	c.Assert(err, IsNil)
+	c.Assert(res.Found, Equals, true)
+	c.Assert(res.Commit.Hash, Equals, culprit.Hash)
+	c.Assert(tested < len(commits)/2, Equals, true)
+
+	c.Assert(b.Reset(), IsNil)
+	_, err = r.Reference(bisectHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+	c.Assert(b.Reset(), Equals, ErrBisectNotStarted)
+}
+
+func (s *BisectSuite) TestBisectContinuedFromGit(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	_, dir, commits := s.bisectRepository(c)
+	gitBisect(c, dir, "start", commits["after 4"].String(), commits["root"].String())
+	gitBisect(c, dir, "good", commits["main 5"].String())
+	gitBisect(c, dir, "bad", commits["after 1"].String())
+
+	r, err := PlainOpen(dir)
+	c.Assert(err, IsNil)
+	b, err := r.Bisect()
+	c.Assert(err, IsNil)
+
+	res, err := b.Next()
+	c.Assert(err, IsNil)
+	c.Assert(revList(c, dir, "-1", "BISECT_HEAD"), DeepEquals, []string{res.Commit.Hash.String()})
+
+	// git takes over again.
+	c.Assert(b.MarkGood(), IsNil)
+	gitBisect(c, dir, "next")
+	c.Assert(b.Reset(), IsNil)
+	c.Assert(revList(c, dir, "--all"), HasLen, len(commits))
+}
+
+func (s *BisectSuite) TestBisectCheckout(c *C) {
+	r := s.NewRepositoryWithEmptyWorktree(fixtures.Basic().One())
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	c.Assert(w.Checkout(&CheckoutOptions{Force: true}), IsNil)
+
+	b, err := r.Bisect()
+	c.Assert(err, IsNil)
+
+	_, err = b.Next()
+	c.Assert(err, Equals, ErrBisectNotStarted)
+
+	c.Assert(b.Start(&BisectStartOptions{}), IsNil)
+	_, err = b.Next()
+	c.Assert(err, Equals, ErrBisectRevisionsNeeded)
+
+	c.Assert(b.MarkBad(plumbing.ZeroHash), IsNil)
+	c.Assert(b.MarkGood(plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")), IsNil)
+
+	res, err := b.Next()
+	c.Assert(err, IsNil)
+	c.Assert(res.Found, Equals, false)
+	c.Assert(res.Remaining, Equals, 2)
+	c.Assert(res.Steps, Equals, 2)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.HEAD)
+	c.Assert(head.Hash(), Equals, res.Commit.Hash)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	c.Assert(b.Reset(), IsNil)
+	head, err = r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+}
+
+func (s *BisectSuite) TestBisectNotSupported(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.Bisect()
+	c.Assert(err, Equals, ErrBisectNotSupported)
+}
+
+func (s *BisectSuite) TestEstimateBisectSteps(c *C) {
+	for all, steps := range map[int]int{1: 0, 2: 0, 3: 1, 4: 1, 5: 1, 6: 2, 7: 2, 8: 2, 11: 3, 12: 3, 1000: 9} {
+		c.Assert(estimateBisectSteps(all), Equals, steps, Commentf("%d", all))
+	}
+
+	c.Assert(sqrti(32768), Equals, 181)
+}
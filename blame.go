@@ -3,6 +3,7 @@ package git
 import (
 	"bytes"
 	"container/heap"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -29,6 +30,13 @@ type BlameResult struct {
 // Blame returns a BlameResult with the information about the last author of
 // each line from file `path` at commit `c`.
 func Blame(c *object.Commit, path string) (*BlameResult, error) {
+	return BlameContext(context.Background(), c, path)
+}
+
+// BlameContext returns a BlameResult with the information about the last
+// author of each line from file `path` at commit `c`, as Blame does. The
+// context is checked for each commit of the history walked.
+func BlameContext(ctx context.Context, c *object.Commit, path string) (*BlameResult, error) {
 	// The file to blame is identified by the input arguments:
 	// commit and path. commit is a Commit object obtained from a Repository. Path
 	// represents a path to a specific file contained in the repository.
@@ -81,6 +89,10 @@ func Blame(c *object.Commit, path string) (*BlameResult, error) {
 	})
 	items := make([]*queueItem, 0)
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		items = items[:0]
 		for {
 			if b.q.Len() == 0 {
@@ -119,6 +131,49 @@ func Blame(c *object.Commit, path string) (*BlameResult, error) {
 	}, nil
 }
 
+// Blame returns a BlameResult with the information about the last author of
+// each line from file `path` at commit `c`, as the Blame function does, the
+// authors being mapped with the mailmap of the repository if requested.
+func (r *Repository) Blame(c *object.Commit, path string, o *BlameOptions) (*BlameResult, error) {
+	return r.BlameContext(context.Background(), c, path, o)
+}
+
+// BlameContext returns a BlameResult as Blame does, the context being checked
+// for each commit of the history walked.
+func (r *Repository) BlameContext(ctx context.Context, c *object.Commit, path string, o *BlameOptions) (*BlameResult, error) {
+	if o == nil {
+		o = &BlameOptions{}
+	}
+
+	s, err := r.replacements()
+	if err != nil {
+		return nil, err
+	}
+
+	// The commit is read again, for its history to be replaced.
+	if s != nil {
+		if c, err = object.GetCommit(s, c.Hash); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := BlameContext(ctx, c, path)
+	if err != nil || !o.UseMailmap {
+		return result, err
+	}
+
+	m, err := r.Mailmap()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range result.Lines {
+		l.AuthorName, l.Author = m.Lookup(l.AuthorName, l.Author)
+	}
+
+	return result, nil
+}
+
 // Line values represent the contents and author of a line in BlamedResult values.
 type Line struct {
 	// Author is the email address of the last author that modified the line.
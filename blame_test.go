@@ -1,6 +1,8 @@
 package git
 
 import (
+	"context"
+
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 
@@ -65,6 +67,41 @@ func (s *BlameSuite) TestBlame(c *C) {
 	}
 }
 
+func (s *BlameSuite) TestBlameContextCancelled(c *C) {
+	t := blameTests[0]
+	r := s.NewRepositoryFromPackfile(fixtures.ByURL(t.repo).One())
+	commit, err := r.CommitObject(plumbing.NewHash(t.rev))
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = BlameContext(ctx, commit, t.path)
+	c.Assert(err, Equals, context.Canceled)
+
+	_, err = r.BlameContext(ctx, commit, t.path, nil)
+	c.Assert(err, Equals, context.Canceled)
+}
+
+func (s *BlameSuite) TestBlameMailmap(c *C) {
+	r := mailmapRepository(c)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+
+	result, err := r.Blame(commit, ".mailmap", &BlameOptions{UseMailmap: true})
+	c.Assert(err, IsNil)
+	c.Assert(result.Lines, HasLen, 1)
+	c.Assert(result.Lines[0].AuthorName, Equals, "Jane Doe")
+	c.Assert(result.Lines[0].Author, Equals, "JANE@example.com")
+
+	result, err = r.Blame(commit, ".mailmap", nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Lines[0].AuthorName, Equals, "jane")
+}
+
 func (s *BlameSuite) mockBlame(c *C, t blameTest, r *Repository) (blame *BlameResult) {
 	commit, err := r.CommitObject(plumbing.NewHash(t.rev))
 	c.Assert(err, IsNil, Commentf("%v: repo=%s, rev=%s", err, t.repo, t.rev))
@@ -0,0 +1,172 @@
+package git
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/bundle"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+)
+
+// ErrEmptyBundle is returned by CreateBundle when the bundle would contain no
+// references or no objects.
+var ErrEmptyBundle = errors.New("refusing to create an empty bundle")
+
+// CreateBundle writes to w a bundle containing the references and the objects
+// described by the given options, same as `git bundle create`. The resulting
+// file can be cloned or fetched from, using its path as URL.
+func (r *Repository) CreateBundle(w io.Writer, o *BundleOptions) error {
+	if err := o.Validate(r); err != nil {
+		return err
+	}
+
+	h := bundle.NewHeader()
+	for _, name := range o.Refs {
+		ref, err := r.Reference(name, true)
+		if err != nil {
+			return err
+		}
+
+		h.References = append(h.References, plumbing.NewHashReference(name, ref.Hash()))
+	}
+
+	prerequisites := o.Basis
+	if !o.Since.IsZero() {
+		var err error
+		h.References, prerequisites, err = r.bundleSince(h.References, o)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(h.References) == 0 {
+		return ErrEmptyBundle
+	}
+
+	var tips []plumbing.Hash
+	for _, ref := range h.References {
+		tips = append(tips, ref.Hash())
+	}
+
+	for _, p := range prerequisites {
+		c, err := r.CommitObject(p)
+		if err != nil {
+			return err
+		}
+
+		subject, _, _ := strings.Cut(c.Message, "\n")
+		h.Prerequisites = append(h.Prerequisites, bundle.Prerequisite{
+			Hash:    p,
+			Comment: subject,
+		})
+	}
+
+	objs, err := revlist.Objects(r.Storer, tips, prerequisites)
+	if err != nil {
+		return err
+	}
+
+	if len(objs) == 0 {
+		return ErrEmptyBundle
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	if err := bundle.NewEncoder(w).Encode(h); err != nil {
+		return err
+	}
+
+	_, err = packfile.NewEncoder(w, r.Storer, false).Encode(objs, cfg.Pack.Window)
+	return err
+}
+
+// bundleSince drops the references pointing to commits older than o.Since,
+// and returns them along with the prerequisites of the bundle: o.Basis plus
+// the old parents of the commits being bundled.
+func (r *Repository) bundleSince(refs []*plumbing.Reference, o *BundleOptions) (
+	[]*plumbing.Reference, []plumbing.Hash, error) {
+
+	prerequisites := append([]plumbing.Hash(nil), o.Basis...)
+	seen := make(map[plumbing.Hash]bool)
+	for _, h := range o.Basis {
+		seen[h] = true
+	}
+
+	var kept []*plumbing.Reference
+	var pending []*object.Commit
+	for _, ref := range refs {
+		c, err := r.peelToCommit(ref.Hash())
+		if err == plumbing.ErrObjectNotFound || err == object.ErrUnsupportedObject {
+			// Not pointing to a commit, such as a tag of a tree.
+			kept = append(kept, ref)
+			continue
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if c.Committer.When.Before(o.Since) {
+			continue
+		}
+
+		kept = append(kept, ref)
+		if !seen[c.Hash] {
+			seen[c.Hash] = true
+			pending = append(pending, c)
+		}
+	}
+
+	for len(pending) != 0 {
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		err := c.Parents().ForEach(func(p *object.Commit) error {
+			if seen[p.Hash] {
+				return nil
+			}
+
+			seen[p.Hash] = true
+			if p.Committer.When.Before(o.Since) {
+				prerequisites = append(prerequisites, p.Hash)
+			} else {
+				pending = append(pending, p)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return kept, prerequisites, nil
+}
+
+// peelToCommit returns the commit the object h points to, following tags.
+func (r *Repository) peelToCommit(h plumbing.Hash) (*object.Commit, error) {
+	obj, err := r.Object(plumbing.AnyObject, h)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch o := obj.(type) {
+		case *object.Commit:
+			return o, nil
+		case *object.Tag:
+			if obj, err = o.Object(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, object.ErrUnsupportedObject
+		}
+	}
+}
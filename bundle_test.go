@@ -0,0 +1,152 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/bundle"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type BundleSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&BundleSuite{})
+
+func (s *BundleSuite) createBundle(c *C, r *Repository, o *BundleOptions) string {
+	path := filepath.Join(c.MkDir(), "repo.bundle")
+	f, err := os.Create(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	c.Assert(r.CreateBundle(f, o), IsNil)
+	return path
+}
+
+func (s *BundleSuite) TestCreateBundleAndClone(c *C) {
+	path := s.createBundle(c, s.Repository, &BundleOptions{})
+
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: path})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+	c.Assert(head.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	ref, err := r.Reference("refs/remotes/origin/branch", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+
+	objects, err := r.Storer.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+	count := 0
+	c.Assert(objects.ForEach(func(plumbing.EncodedObject) error { count++; return nil }), IsNil)
+	c.Assert(count, Equals, 31)
+}
+
+func (s *BundleSuite) TestCloneBundleWithoutExtension(c *C) {
+	path := s.createBundle(c, s.Repository, &BundleOptions{})
+	renamed := filepath.Join(filepath.Dir(path), "repo")
+	c.Assert(os.Rename(path, renamed), IsNil)
+
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: renamed})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+}
+
+func (s *BundleSuite) TestCreateBundleHeader(c *C) {
+	var buf bytes.Buffer
+	err := s.Repository.CreateBundle(&buf, &BundleOptions{
+		Refs:  []plumbing.ReferenceName{plumbing.Master},
+		Basis: []plumbing.Hash{plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")},
+	})
+	c.Assert(err, IsNil)
+
+	h := bundle.NewHeader()
+	c.Assert(bundle.NewDecoder(&buf).Decode(h), IsNil)
+	c.Assert(h.Version, Equals, bundle.V2)
+	c.Assert(h.Prerequisites, DeepEquals, []bundle.Prerequisite{{
+		Hash:    plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+		Comment: "some code",
+	}})
+	c.Assert(h.References, DeepEquals, []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("refs/heads/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+}
+
+func (s *BundleSuite) TestCreateBundleIncremental(c *C) {
+	basis := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+	old := plumbing.NewBranchReferenceName("old")
+	c.Assert(s.Repository.Storer.SetReference(plumbing.NewHashReference(old, basis)), IsNil)
+
+	full := s.createBundle(c, s.Repository, &BundleOptions{Refs: []plumbing.ReferenceName{old}})
+	incremental := s.createBundle(c, s.Repository, &BundleOptions{
+		Refs:  []plumbing.ReferenceName{plumbing.Master},
+		Basis: []plumbing.Hash{basis},
+	})
+
+	_, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: incremental})
+	c.Assert(errors.Is(err, transport.ErrMissingPrerequisites), Equals, true)
+
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: full, ReferenceName: old})
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{Name: "incremental", URLs: []string{incremental}})
+	c.Assert(err, IsNil)
+	c.Assert(r.Fetch(&FetchOptions{RemoteName: "incremental"}), IsNil)
+
+	ref, err := r.Reference("refs/remotes/incremental/master", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	_, err = r.CommitObject(ref.Hash())
+	c.Assert(err, IsNil)
+}
+
+func (s *BundleSuite) TestCreateBundleSince(c *C) {
+	basis, err := s.Repository.CommitObject(plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"))
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	err = s.Repository.CreateBundle(&buf, &BundleOptions{
+		Refs:  []plumbing.ReferenceName{plumbing.Master},
+		Since: basis.Committer.When.Add(1),
+	})
+	c.Assert(err, IsNil)
+
+	h := bundle.NewHeader()
+	c.Assert(bundle.NewDecoder(&buf).Decode(h), IsNil)
+	c.Assert(h.Prerequisites, Not(HasLen), 0)
+	for _, p := range h.Prerequisites {
+		commit, err := s.Repository.CommitObject(p.Hash)
+		c.Assert(err, IsNil)
+		c.Assert(commit.Committer.When.After(basis.Committer.When), Equals, false)
+	}
+
+	err = s.Repository.CreateBundle(&buf, &BundleOptions{
+		Refs:  []plumbing.ReferenceName{plumbing.Master},
+		Since: basis.Committer.When.AddDate(10, 0, 0),
+	})
+	c.Assert(err, Equals, ErrEmptyBundle)
+}
+
+func (s *BundleSuite) TestCreateBundleEmpty(c *C) {
+	var buf bytes.Buffer
+	err := s.Repository.CreateBundle(&buf, &BundleOptions{
+		Refs:  []plumbing.ReferenceName{plumbing.Master},
+		Basis: []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")},
+	})
+	c.Assert(err, Equals, ErrEmptyBundle)
+}
@@ -0,0 +1,547 @@
+package git
+
+import (
+	"io"
+	"math"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	commitgraphfmt "github.com/go-git/go-git/v5/plumbing/format/commitgraph/v2"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/object/commitgraph"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// WriteCommitGraph writes the commit-graph file of the repository, at
+// objects/info/commit-graph, holding all its commits, as
+// `git commit-graph write --reachable` does. The commit-graph speeds up the
+// history walks, such as Log and MergeBase. The commits added afterwards are
+// read from the objects, until the file is written again.
+func (r *Repository) WriteCommitGraph() error {
+	return r.WriteCommitGraphWithOptions(&WriteCommitGraphOptions{})
+}
+
+// WriteCommitGraphWithOptions writes the commit-graph file of the repository
+// as WriteCommitGraph does, with the given options.
+func (r *Repository) WriteCommitGraphWithOptions(o *WriteCommitGraphOptions) error {
+	if o == nil {
+		o = &WriteCommitGraphOptions{}
+	}
+
+	fs, ok := storageFilesystem(r.Storer)
+	if !ok {
+		return ErrCommitGraphNotSupported
+	}
+
+	idx, err := commitgraph.BuildMemoryIndex(r.Storer)
+	if err != nil {
+		return err
+	}
+
+	if o.ChangedPaths {
+		if err := commitgraph.AddBloomFilters(idx, r.Storer); err != nil {
+			return err
+		}
+	}
+
+	dir := path.Join("objects", "info")
+	if err := fs.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	f, err := fs.TempFile(dir, "tmp_graph_")
+	if err != nil {
+		return err
+	}
+
+	if err := commitgraphfmt.NewEncoder(f).Encode(idx); err != nil {
+		_ = f.Close()
+		_ = fs.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = fs.Remove(f.Name())
+		return err
+	}
+
+	return fs.Rename(f.Name(), path.Join(dir, "commit-graph"))
+}
+
+// MergeBase returns the best common ancestors of the commits, as
+// object.Commit.MergeBase does. The commit-graph of the repository, if any,
+// is used to walk the history.
+func (r *Repository) MergeBase(c, other *object.Commit) ([]*object.Commit, error) {
+	s, err := r.replacements()
+	if err != nil {
+		return nil, err
+	}
+
+	// The commits are read again, for their parents to be replaced.
+	if s != nil {
+		if c, err = object.GetCommit(s, c.Hash); err != nil {
+			return nil, err
+		}
+
+		if other, err = object.GetCommit(s, other.Hash); err != nil {
+			return nil, err
+		}
+
+		return c.MergeBase(other)
+	}
+
+	graph := openCommitGraph(r.Storer)
+	if graph == nil {
+		return c.MergeBase(other)
+	}
+
+	defer graph.Close()
+	return commitgraphMergeBase(commitgraph.NewGraphCommitNodeIndex(graph, r.Storer), c.Hash, other.Hash)
+}
+
+// AheadBehind returns the number of commits reachable from local but not
+// from upstream, and from upstream but not from local, as
+// `git rev-list --left-right --count local...upstream` does. Both histories
+// are walked at once, with the generation numbers of the commit-graph of the
+// repository, if any, until only their common ancestors are left. With
+// unrelated histories, all the commits of both are counted.
+func (r *Repository) AheadBehind(local, upstream plumbing.Hash) (ahead, behind int, err error) {
+	if local == upstream {
+		return 0, 0, nil
+	}
+
+	index, closeIndex, err := r.commitNodeIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer closeIndex()
+	ln, err := index.Get(local)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	un, err := index.Get(upstream)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return commitgraph.AheadBehind(ln, un)
+}
+
+// ReachableFrom returns, for each of the queries, whether the commit is
+// reachable from any of the tips, as `git merge-base --is-ancestor` does for
+// each pair. The history of all the tips is walked once, and only down to the
+// generation of the queries when the repository has a commit-graph, instead
+// of once per pair.
+func (r *Repository) ReachableFrom(tips, queries []plumbing.Hash) ([]bool, error) {
+	index, closeIndex, err := r.commitNodeIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeIndex()
+	tipNodes, err := getCommitNodes(index, tips)
+	if err != nil {
+		return nil, err
+	}
+
+	queryNodes, err := getCommitNodes(index, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	return commitgraph.Reachable(tipNodes, queryNodes)
+}
+
+// MergeBaseOctopus returns the best common ancestors of all the commits, as
+// `git merge-base --octopus` does to find the base of an octopus merge. The
+// commit-graph of the repository, if any, is used to walk the history.
+func (r *Repository) MergeBaseOctopus(commits ...*object.Commit) ([]*object.Commit, error) {
+	index, closeIndex, err := r.commitNodeIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeIndex()
+	hashes := make([]plumbing.Hash, len(commits))
+	for i, c := range commits {
+		hashes[i] = c.Hash
+	}
+
+	nodes, err := getCommitNodes(index, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	bases, err := commitgraph.MergeBaseOctopus(nodes...)
+	if err != nil {
+		return nil, err
+	}
+
+	return nodeCommits(bases)
+}
+
+// commitNodeIndex returns the index of the commits of the repository, over
+// its commit-graph if any and nothing is replaced, or over its objects. The
+// returned function releases it.
+func (r *Repository) commitNodeIndex() (commitgraph.CommitNodeIndex, func(), error) {
+	s, err := r.replacements()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s == nil {
+		if graph := openCommitGraph(r.Storer); graph != nil {
+			return commitgraph.NewGraphCommitNodeIndex(graph, r.Storer),
+				func() { _ = graph.Close() }, nil
+		}
+	}
+
+	objects, err := r.objectStorer()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return commitgraph.NewObjectCommitNodeIndex(objects), func() {}, nil
+}
+
+func getCommitNodes(idx commitgraph.CommitNodeIndex, hashes []plumbing.Hash) ([]commitgraph.CommitNode, error) {
+	nodes := make([]commitgraph.CommitNode, len(hashes))
+	for i, h := range hashes {
+		n, err := idx.Get(h)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes[i] = n
+	}
+
+	return nodes, nil
+}
+
+func nodeCommits(nodes []commitgraph.CommitNode) ([]*object.Commit, error) {
+	res := make([]*object.Commit, 0, len(nodes))
+	for _, n := range nodes {
+		commit, err := n.Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, commit)
+	}
+
+	return res, nil
+}
+
+func commitgraphMergeBase(idx commitgraph.CommitNodeIndex, c, other plumbing.Hash) ([]*object.Commit, error) {
+	cn, err := idx.Get(c)
+	if err != nil {
+		return nil, err
+	}
+
+	on, err := idx.Get(other)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := commitgraph.MergeBase(cn, on)
+	if err != nil {
+		return nil, err
+	}
+
+	return nodeCommits(nodes)
+}
+
+// storageFilesystem returns the filesystem of the .git directory, if the
+// storage is backed by one.
+func storageFilesystem(s storer.EncodedObjectStorer) (billy.Filesystem, bool) {
+	type fsBased interface {
+		Filesystem() billy.Filesystem
+	}
+
+	fs, ok := s.(fsBased)
+	if !ok {
+		return nil, false
+	}
+
+	return fs.Filesystem(), true
+}
+
+// openCommitGraph returns the commit-graph of the storage, or nil if there is
+// none.
+func openCommitGraph(s storer.EncodedObjectStorer) commitgraphfmt.Index {
+	fs, ok := storageFilesystem(s)
+	if !ok {
+		return nil
+	}
+
+	graph, err := commitgraphfmt.OpenChainOrFileIndex(fs)
+	if err != nil {
+		// A missing or unreadable commit-graph only means the objects are
+		// read instead.
+		return nil
+	}
+
+	return graph
+}
+
+// logCommitGraphFor returns the commit-graph of the repository, if it speeds up
+// the log with the given options, otherwise nil.
+func (r *Repository) logCommitGraphFor(o *LogOptions) commitgraphfmt.Index {
+	if o.Order != LogOrderCommitterTime && !o.Order.topological() && o.FileName == nil && len(o.Paths) == 0 {
+		return nil
+	}
+
+	// The commit-graph doesn't know the replaced objects.
+	if s, err := r.replacements(); err != nil || s != nil {
+		return nil
+	}
+
+	return openCommitGraph(r.Storer)
+}
+
+// logCommitGraph returns the history of the commit from, in committer time
+// order, walking the commit-graph.
+func (r *Repository) logCommitGraph(graph commitgraphfmt.Index, from plumbing.Hash) (object.CommitIter, error) {
+	h, err := r.logStart(from)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := commitgraph.NewGraphCommitNodeIndex(graph, r.Storer).Get(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return &commitNodeIter{commitgraph.NewCommitNodeIterCTime(n, nil, nil)}, nil
+}
+
+// logTopological returns the history in one of the topological orders,
+// from the commit of the options or from all the references. The commits are
+// read as the generation numbers of the commit-graph allow it, if any, or all
+// of them before the first one is returned otherwise.
+func (r *Repository) logTopological(graph commitgraphfmt.Index, o *LogOptions) (object.CommitIter, error) {
+	var index commitgraph.CommitNodeIndex
+	if graph != nil {
+		index = commitgraph.NewGraphCommitNodeIndex(graph, r.Storer)
+	} else {
+		s, err := r.objectStorer()
+		if err != nil {
+			return nil, err
+		}
+
+		index = commitgraph.NewObjectCommitNodeIndex(s)
+	}
+
+	var start commitgraph.CommitNode
+	if o.All {
+		tips, err := r.logTips(index)
+		if err != nil {
+			return nil, err
+		}
+
+		start = tips
+	} else {
+		h, err := r.logStart(o.From)
+		if err != nil {
+			return nil, err
+		}
+
+		if start, err = index.Get(h); err != nil {
+			return nil, err
+		}
+	}
+
+	var iter commitgraph.CommitNodeIter
+	if o.Order == LogOrderAuthorDate {
+		iter = commitgraph.NewCommitNodeIterAuthorDateOrder(start, nil, nil)
+	} else {
+		iter = commitgraph.NewCommitNodeIterTopoOrder(start, nil, nil)
+	}
+
+	if o.All {
+		// The node of the tips has no children, so it comes first.
+		if _, err := iter.Next(); err != nil {
+			iter.Close()
+			return nil, err
+		}
+	}
+
+	return &commitNodeIter{iter}, nil
+}
+
+// logTips returns a node having the commits the references and HEAD point to
+// as parents, so all of them are walked at once. The most recent commits are
+// the last parents, which are shown first in topological order, as git does.
+func (r *Repository) logTips(index commitgraph.CommitNodeIndex) (*tipsCommitNode, error) {
+	commits, err := r.refCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].Committer.When.Before(commits[j].Committer.When)
+	})
+
+	tips := &tipsCommitNode{}
+	for _, c := range commits {
+		n, err := index.Get(c.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		tips.parents = append(tips.parents, n)
+	}
+
+	return tips, nil
+}
+
+// tipsCommitNode is a virtual commitgraph.CommitNode, which is not a commit,
+// whose parents are the tips of the history to walk.
+type tipsCommitNode struct {
+	parents []commitgraph.CommitNode
+}
+
+func (n *tipsCommitNode) ID() plumbing.Hash {
+	return plumbing.ZeroHash
+}
+
+func (n *tipsCommitNode) Tree() (*object.Tree, error) {
+	return nil, plumbing.ErrObjectNotFound
+}
+
+func (n *tipsCommitNode) CommitTime() time.Time {
+	return time.Time{}
+}
+
+func (n *tipsCommitNode) NumParents() int {
+	return len(n.parents)
+}
+
+func (n *tipsCommitNode) Generation() uint64 {
+	return math.MaxUint64
+}
+
+func (n *tipsCommitNode) GenerationV2() uint64 {
+	return math.MaxUint64
+}
+
+func (n *tipsCommitNode) Commit() (*object.Commit, error) {
+	return nil, plumbing.ErrObjectNotFound
+}
+
+// ParentNodes is not used by the walkers, which get the parents by index.
+func (n *tipsCommitNode) ParentNodes() commitgraph.CommitNodeIter {
+	return nil
+}
+
+func (n *tipsCommitNode) ParentNode(i int) (commitgraph.CommitNode, error) {
+	if i < 0 || i >= len(n.parents) {
+		return nil, object.ErrParentNotFound
+	}
+
+	return n.parents[i], nil
+}
+
+func (n *tipsCommitNode) ParentHashes() []plumbing.Hash {
+	hashes := make([]plumbing.Hash, len(n.parents))
+	for i, p := range n.parents {
+		hashes[i] = p.ID()
+	}
+
+	return hashes
+}
+
+// changedPathsHint returns the function telling whether a commit may change
+// any of the paths, from the changed-path Bloom filters of the commit-graph,
+// or nil if it has none.
+func changedPathsHint(graph commitgraphfmt.Index, paths []string) func(*object.Commit) bool {
+	bloomIndex, ok := graph.(commitgraphfmt.BloomFilterIndex)
+	if !ok || len(paths) == 0 {
+		return nil
+	}
+
+	return func(c *object.Commit) bool {
+		i, err := graph.GetIndexByHash(c.Hash)
+		if err != nil {
+			return true
+		}
+
+		f, err := bloomIndex.GetBloomFilterByIndex(i)
+		if err != nil {
+			return true
+		}
+
+		for _, p := range paths {
+			if f.Contains(p) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// commitNodeIter is an object.CommitIter over a commitgraph.CommitNodeIter,
+// the commits are read from the objects once returned.
+type commitNodeIter struct {
+	iter commitgraph.CommitNodeIter
+}
+
+func (i *commitNodeIter) Next() (*object.Commit, error) {
+	n, err := i.iter.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return n.Commit()
+}
+
+func (i *commitNodeIter) ForEach(cb func(*object.Commit) error) error {
+	return i.iter.ForEach(func(n commitgraph.CommitNode) error {
+		c, err := n.Commit()
+		if err != nil {
+			return err
+		}
+
+		return cb(c)
+	})
+}
+
+func (i *commitNodeIter) Close() {
+	i.iter.Close()
+}
+
+// commitGraphIter is an object.CommitIter closing the commit-graph it reads,
+// along with the iterator or once it is exhausted.
+type commitGraphIter struct {
+	object.CommitIter
+	graph io.Closer
+}
+
+func (i *commitGraphIter) Next() (*object.Commit, error) {
+	c, err := i.CommitIter.Next()
+	if err != nil {
+		i.Close()
+	}
+
+	return c, err
+}
+
+func (i *commitGraphIter) ForEach(cb func(*object.Commit) error) error {
+	defer i.Close()
+	return i.CommitIter.ForEach(cb)
+}
+
+func (i *commitGraphIter) Close() {
+	i.CommitIter.Close()
+	if i.graph != nil {
+		_ = i.graph.Close()
+		i.graph = nil
+	}
+}
@@ -0,0 +1,376 @@
+package git
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	commitgraphfmt "github.com/go-git/go-git/v5/plumbing/format/commitgraph/v2"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type CommitGraphSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&CommitGraphSuite{})
+
+func (s *CommitGraphSuite) TestWriteCommitGraph(c *C) {
+	fs := fixtures.ByTag("merge-base").One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	head := plumbing.NewHash("dce0e0c20d701c3d260146e443d6b3b079505191")
+	expected := s.logHashes(c, r, head)
+
+	err = r.WriteCommitGraph()
+	c.Assert(err, IsNil)
+
+	graph, err := commitgraphfmt.OpenChainOrFileIndex(fs)
+	c.Assert(err, IsNil)
+	defer graph.Close()
+
+	_, err = graph.GetIndexByHash(head)
+	c.Assert(err, IsNil)
+	c.Assert(graph.Hashes(), HasLen, 23)
+
+	c.Assert(s.logHashes(c, r, head), DeepEquals, expected)
+}
+
+func (s *CommitGraphSuite) logHashes(c *C, r *Repository, from plumbing.Hash) []plumbing.Hash {
+	iter, err := r.Log(&LogOptions{From: from, Order: LogOrderCommitterTime})
+	c.Assert(err, IsNil)
+
+	var hashes []plumbing.Hash
+	err = iter.ForEach(func(commit *object.Commit) error {
+		hashes = append(hashes, commit.Hash)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	return hashes
+}
+
+func (s *CommitGraphSuite) TestLogChangedPaths(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	logs := func() [][]plumbing.Hash {
+		var res [][]plumbing.Hash
+		for _, order := range []LogOrder{LogOrderDefault, LogOrderCommitterTime} {
+			for _, p := range []string{"go", "go/example.go", "php/", "LICENSE", "foo"} {
+				fileName := p
+				for _, o := range []*LogOptions{
+					{Order: order, Paths: []string{p}},
+					{Order: order, FileName: &fileName},
+					{Order: order, All: true, Paths: []string{p}},
+				} {
+					iter, err := r.Log(o)
+					c.Assert(err, IsNil)
+
+					var hashes []plumbing.Hash
+					err = iter.ForEach(func(commit *object.Commit) error {
+						hashes = append(hashes, commit.Hash)
+						return nil
+					})
+					c.Assert(err, IsNil)
+					res = append(res, hashes)
+				}
+			}
+		}
+
+		return res
+	}
+
+	expected := logs()
+	c.Assert(expected[0], HasLen, 1)
+	c.Assert(expected[12], HasLen, 0)
+
+	err = r.WriteCommitGraphWithOptions(&WriteCommitGraphOptions{ChangedPaths: true})
+	c.Assert(err, IsNil)
+
+	graph, err := commitgraphfmt.OpenChainOrFileIndex(fs)
+	c.Assert(err, IsNil)
+	defer graph.Close()
+
+	i, err := graph.GetIndexByHash(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+	filter, err := graph.(commitgraphfmt.BloomFilterIndex).GetBloomFilterByIndex(i)
+	c.Assert(err, IsNil)
+	c.Assert(filter.Contains("vendor/foo.go"), Equals, true)
+
+	c.Assert(logs(), DeepEquals, expected)
+}
+
+func (s *CommitGraphSuite) TestWriteCommitGraphNotSupported(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	err = r.WriteCommitGraph()
+	c.Assert(err, Equals, ErrCommitGraphNotSupported)
+}
+
+func (s *CommitGraphSuite) TestMergeBase(c *C) {
+	fs := fixtures.ByTag("merge-base").One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	commitC, err := r.CommitObject(plumbing.NewHash("8b72fabdc4222c3ff965bc310ded788c601c50ed"))
+	c.Assert(err, IsNil)
+	commitD, err := r.CommitObject(plumbing.NewHash("14777cf3e209334592fbfd0b878f6868394db836"))
+	c.Assert(err, IsNil)
+
+	expected := []plumbing.Hash{
+		plumbing.NewHash("38468e274e91e50ffb637b88a1954ab6193fe974"),
+		plumbing.NewHash("4709e13a3cbb300c2b8a917effda776e1b8955c7"),
+	}
+
+	assertMergeBase := func() {
+		bases, err := r.MergeBase(commitC, commitD)
+		c.Assert(err, IsNil)
+
+		var hashes []plumbing.Hash
+		for _, b := range bases {
+			hashes = append(hashes, b.Hash)
+		}
+
+		plumbing.HashesSort(hashes)
+		c.Assert(hashes, DeepEquals, expected)
+	}
+
+	assertMergeBase()
+	c.Assert(r.WriteCommitGraph(), IsNil)
+	assertMergeBase()
+}
+
+func (s *CommitGraphSuite) TestAheadBehind(c *C) {
+	fs := fixtures.ByTag("merge-base").One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	commitA := plumbing.NewHash("29740cfaf0c2ee4bb532dba9e80040ca738f367c")
+	commitB := plumbing.NewHash("2c84807970299ba98951c65fe81ebbaac01030f0")
+	commitM := plumbing.NewHash("bb355b64e18386dbc3af63dfd09c015c44cbd9b6")
+	commitN := plumbing.NewHash("d64b894762ab5f09e2b155221b90c18bd0637236")
+
+	assertAheadBehind := func() {
+		ahead, behind, err := r.AheadBehind(commitA, commitB)
+		c.Assert(err, IsNil)
+		c.Assert(ahead, Equals, 1)
+		c.Assert(behind, Equals, 5)
+
+		// Unrelated histories.
+		ahead, behind, err = r.AheadBehind(commitM, commitN)
+		c.Assert(err, IsNil)
+		c.Assert(ahead, Equals, 3)
+		c.Assert(behind, Equals, 3)
+
+		ahead, behind, err = r.AheadBehind(commitA, commitA)
+		c.Assert(err, IsNil)
+		c.Assert(ahead, Equals, 0)
+		c.Assert(behind, Equals, 0)
+	}
+
+	assertAheadBehind()
+	c.Assert(r.WriteCommitGraph(), IsNil)
+	assertAheadBehind()
+}
+
+func (s *CommitGraphSuite) TestReachableFrom(c *C) {
+	fs := fixtures.ByTag("merge-base").One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	tips := []plumbing.Hash{
+		plumbing.NewHash("29740cfaf0c2ee4bb532dba9e80040ca738f367c"), // A
+		plumbing.NewHash("8b72fabdc4222c3ff965bc310ded788c601c50ed"), // C
+	}
+
+	queries := []plumbing.Hash{
+		plumbing.NewHash("25ca6c810c08482d61113fbcaaada38bb59093a8"), // dev
+		plumbing.NewHash("bb355b64e18386dbc3af63dfd09c015c44cbd9b6"), // M
+		plumbing.NewHash("d1b0093698e398d596ef94d646c4db37e8d1e970"), // G
+		plumbing.NewHash("4709e13a3cbb300c2b8a917effda776e1b8955c7"), // CD1
+		plumbing.NewHash("29740cfaf0c2ee4bb532dba9e80040ca738f367c"), // A
+	}
+
+	assertReachableFrom := func() {
+		res, err := r.ReachableFrom(tips, queries)
+		c.Assert(err, IsNil)
+		c.Assert(res, DeepEquals, []bool{false, true, false, true, true})
+	}
+
+	assertReachableFrom()
+	c.Assert(r.WriteCommitGraph(), IsNil)
+	assertReachableFrom()
+
+	_, err = r.ReachableFrom(tips, []plumbing.Hash{plumbing.NewHash("0000000000000000000000000000000000000001")})
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *CommitGraphSuite) TestMergeBaseOctopus(c *C) {
+	fs := fixtures.ByTag("merge-base").One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	var commits []*object.Commit
+	for _, h := range []string{
+		"8b72fabdc4222c3ff965bc310ded788c601c50ed", // C
+		"14777cf3e209334592fbfd0b878f6868394db836", // D
+		"d1b0093698e398d596ef94d646c4db37e8d1e970", // G
+	} {
+		commit, err := r.CommitObject(plumbing.NewHash(h))
+		c.Assert(err, IsNil)
+		commits = append(commits, commit)
+	}
+
+	expected := []plumbing.Hash{
+		plumbing.NewHash("38468e274e91e50ffb637b88a1954ab6193fe974"),
+		plumbing.NewHash("4709e13a3cbb300c2b8a917effda776e1b8955c7"),
+	}
+
+	assertMergeBaseOctopus := func() {
+		bases, err := r.MergeBaseOctopus(commits...)
+		c.Assert(err, IsNil)
+
+		var hashes []plumbing.Hash
+		for _, b := range bases {
+			hashes = append(hashes, b.Hash)
+		}
+
+		plumbing.HashesSort(hashes)
+		c.Assert(hashes, DeepEquals, expected)
+	}
+
+	assertMergeBaseOctopus()
+	c.Assert(r.WriteCommitGraph(), IsNil)
+	assertMergeBaseOctopus()
+}
+
+// commitGraphBenchmarkRepository returns a repository with two branches of n
+// commits each, merging each other every 10 commits, and their tips.
+func commitGraphBenchmarkRepository(b *testing.B, n int) (*Repository, *object.Commit, *object.Commit) {
+	r, err := Init(filesystem.NewStorage(memfs.New(), cache.NewObjectLRUDefault()), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	when := time.Unix(1500000000, 0)
+	commit := func(msg string, parents ...plumbing.Hash) plumbing.Hash {
+		when = when.Add(time.Minute)
+		sig := object.Signature{Name: "foo", Email: "foo@foo.foo", When: when}
+		c := &object.Commit{
+			Author:       sig,
+			Committer:    sig,
+			Message:      msg,
+			TreeHash:     plumbing.ZeroHash,
+			ParentHashes: parents,
+		}
+
+		obj := r.Storer.NewEncodedObject()
+		if err := c.Encode(obj); err != nil {
+			b.Fatal(err)
+		}
+
+		h, err := r.Storer.SetEncodedObject(obj)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		return h
+	}
+
+	left := commit("root")
+	right := left
+	for i := 0; i < n; i++ {
+		left = commit(fmt.Sprintf("left %d", i), left)
+		if i%10 == 0 {
+			right = commit(fmt.Sprintf("merge %d", i), right, left)
+		} else {
+			right = commit(fmt.Sprintf("right %d", i), right)
+		}
+	}
+
+	l, err := r.CommitObject(left)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	rc, err := r.CommitObject(right)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return r, l, rc
+}
+
+func BenchmarkMergeBase(b *testing.B) {
+	r, left, right := commitGraphBenchmarkRepository(b, 2000)
+
+	b.Run("objects", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := r.MergeBase(left, right); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	if err := r.WriteCommitGraph(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("commit-graph", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := r.MergeBase(left, right); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkReachableFrom(b *testing.B) {
+	r, left, right := commitGraphBenchmarkRepository(b, 2000)
+
+	// Every fourth commit of the left branch, as the tags of a history.
+	var queries []plumbing.Hash
+	for c, i := left, 0; len(c.ParentHashes) > 0; i++ {
+		if i%4 == 0 {
+			queries = append(queries, c.Hash)
+		}
+
+		var err error
+		if c, err = c.Parent(0); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	tips := []plumbing.Hash{right.Hash}
+	b.Run("objects", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := r.ReachableFrom(tips, queries); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	if err := r.WriteCommitGraph(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("commit-graph", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := r.ReachableFrom(tips, queries); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"strconv"
 
 	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5/internal/url"
 	"github.com/go-git/go-git/v5/plumbing"
 	format "github.com/go-git/go-git/v5/plumbing/format/config"
@@ -47,6 +48,12 @@ const (
 	SystemScope
 )
 
+// WorktreeScope is the scope of the config.worktree file of the worktree,
+// which takes precedence over the local config when extensions.worktreeConfig
+// is enabled. It is narrower than LocalScope, the config of the scope being
+// read along with the local one.
+const WorktreeScope Scope = -1
+
 // Config contains the repository configuration
 // https://www.kernel.org/pub/software/scm/git/docs/git-config.html#FILES
 type Config struct {
@@ -61,6 +68,32 @@ type Config struct {
 		CommentChar string
 		// RepositoryFormatVersion identifies the repository format and layout version.
 		RepositoryFormatVersion format.RepositoryFormatVersion
+		// SSHCommand is the command line used to connect to ssh remotes, if
+		// set an external ssh program is used instead of the builtin client.
+		SSHCommand string
+		// UntrackedCache enables the untracked cache of the index, which
+		// saves the untracked files of the directories of the worktree, so
+		// they are not listed again while their modification time is the
+		// same.
+		UntrackedCache bool
+		// IgnoreCase is true if the worktree is on a case-insensitive
+		// filesystem, so the paths of its files are matched with the ones of
+		// the index ignoring case.
+		IgnoreCase bool
+		// NoSymlinks is true if core.symlinks is false, the worktree not
+		// supporting symlinks, so they are checked out as plain files holding
+		// the path of their target.
+		NoSymlinks bool
+		// NoFileMode is true if core.fileMode is false, the worktree not
+		// supporting the executable bit, so the mode of the files is taken
+		// from the index instead.
+		NoFileMode bool
+	}
+
+	SSH struct {
+		// Variant is the flavour of the program used by SSHCommand, it
+		// can be auto, ssh, plink, putty, tortoiseplink or simple.
+		Variant string
 	}
 
 	User struct {
@@ -89,6 +122,33 @@ type Config struct {
 		// compression.  The default is 10.  A value of 0 turns off
 		// delta compression entirely.
 		Window uint
+		// Depth is the maximum number of deltas based on deltas. The
+		// default is 50.
+		Depth int
+		// Threads is the number of goroutines searching for deltas. The
+		// default, 0, lets go-git choose.
+		Threads uint
+	}
+
+	Index struct {
+		// Version is the version of the index written when there is none,
+		// 2, 3 or 4. If not set, it is 4 if Feature.ManyFiles is set, 2
+		// otherwise. Existing indexes keep their version.
+		Version uint
+		// RecordEndOfIndexEntries writes the End Of Index Entry (EOIE)
+		// extension, allowing git to find the extensions without reading
+		// the entries.
+		RecordEndOfIndexEntries bool
+		// RecordOffsetTable writes the Index Entry Offset Table (IEOT)
+		// extension, allowing git to read the entries with several
+		// threads.
+		RecordOffsetTable bool
+	}
+
+	Feature struct {
+		// ManyFiles enables the settings for repositories with many
+		// files, in go-git the version 4 of the index.
+		ManyFiles bool
 	}
 
 	Init struct {
@@ -96,6 +156,9 @@ type Config struct {
 		// e.g. when initializing a new repository or when cloning
 		// an empty repository.
 		DefaultBranch string
+		// TemplateDir is the directory whose files are copied into the git
+		// directory of the new repositories.
+		TemplateDir string
 	}
 
 	Extensions struct {
@@ -107,6 +170,10 @@ type Config struct {
 		// This setting must not be changed after repository initialization
 		// (e.g. clone or init).
 		ObjectFormat format.ObjectFormat
+		// RefStorage specifies the format of the references storage, files
+		// or reftable. If not specified, files is assumed. It is an error
+		// to specify this key unless core.repositoryFormatVersion is 1.
+		RefStorage format.RefStorage
 	}
 
 	// Remotes list of repository remotes, the key of the map is the name
@@ -119,7 +186,8 @@ type Config struct {
 	// equal Branch.Name
 	Branches map[string]*Branch
 	// URLs list of url rewrite rules, if repo url starts with URL.InsteadOf value, it will be replaced with the
-	// key instead.
+	// key instead. The rules are applied by the remotes when connecting, see
+	// Config.ApplyInsteadOf, the urls of RemoteConfig are kept as is.
 	URLs map[string]*URL
 	// Raw contains the raw information of a config file. The main goal is
 	// preserve the parsed information from the original format, to avoid
@@ -138,6 +206,7 @@ func NewConfig() *Config {
 	}
 
 	config.Pack.Window = DefaultPackWindow
+	config.Pack.Depth = DefaultPackDepth
 
 	return config
 }
@@ -158,8 +227,9 @@ func ReadConfig(r io.Reader) (*Config, error) {
 }
 
 // LoadConfig loads a config file from a given scope. The returned Config,
-// contains exclusively information from the given scope. If it couldn't find a
-// config file to the given scope, an empty one is returned.
+// contains exclusively information from the given scope, without the
+// included files, so it can be written back with SaveConfig. If it couldn't
+// find a config file to the given scope, an empty one is returned.
 func LoadConfig(scope Scope) (*Config, error) {
 	if scope == LocalScope {
 		return nil, fmt.Errorf("LocalScope should be read from the a ConfigStorer")
@@ -187,11 +257,69 @@ func LoadConfig(scope Scope) (*Config, error) {
 	return NewConfig(), nil
 }
 
-// Paths returns the config file location for a given scope.
+// SaveConfig writes the config to the file of the given scope, the one read
+// by LoadConfig, or the default one of the scope if there is none, keeping
+// the comments and formatting of the sections of the file that are
+// unchanged.
+func SaveConfig(scope Scope, cfg *Config) error {
+	if scope == LocalScope {
+		return fmt.Errorf("LocalScope should be written with a ConfigStorer")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	files, err := Paths(scope)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("no config file for the scope")
+	}
+
+	// As git, the global config is written by default in ~/.gitconfig.
+	file := files[0]
+	if scope == GlobalScope && len(files) > 1 {
+		file = files[len(files)-2]
+	}
+
+	for _, f := range files {
+		if _, err := osfs.Default.Stat(f); err == nil {
+			file = f
+			break
+		}
+	}
+
+	src, err := util.ReadFile(osfs.Default, file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	b, err := cfg.MarshalScopedPreserving(src)
+	if err != nil {
+		return err
+	}
+
+	if err := osfs.Default.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	return util.WriteFile(osfs.Default, file, b, 0o644)
+}
+
+// Paths returns the config file location for a given scope. The
+// GIT_CONFIG_GLOBAL, GIT_CONFIG_SYSTEM and GIT_CONFIG_NOSYSTEM environment
+// variables are honored as in git.
 func Paths(scope Scope) ([]string, error) {
 	var files []string
 	switch scope {
 	case GlobalScope:
+		if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+			return []string{global}, nil
+		}
+
 		xdg := os.Getenv("XDG_CONFIG_HOME")
 		if xdg != "" {
 			files = append(files, filepath.Join(xdg, "git/config"))
@@ -207,12 +335,37 @@ func Paths(scope Scope) ([]string, error) {
 			filepath.Join(home, ".config/git/config"),
 		)
 	case SystemScope:
+		if noSystem, _ := strconv.ParseBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); noSystem {
+			return nil, nil
+		}
+
+		if system := os.Getenv("GIT_CONFIG_SYSTEM"); system != "" {
+			return []string{system}, nil
+		}
+
 		files = append(files, "/etc/gitconfig")
 	}
 
 	return files, nil
 }
 
+// readPaths returns the config files of the given scope in the order git
+// reads them, the last one taking precedence.
+func readPaths(scope Scope) ([]string, error) {
+	files, err := Paths(scope)
+	if err != nil || scope != GlobalScope || len(files) < 2 {
+		return files, err
+	}
+
+	// The XDG config is read before ~/.gitconfig, and ~/.config/git/config
+	// only when XDG_CONFIG_HOME is not set.
+	if os.Getenv("XDG_CONFIG_HOME") != "" {
+		return []string{files[0], files[1]}, nil
+	}
+
+	return []string{files[1], files[0]}, nil
+}
+
 // Validate validates the fields and sets the default values.
 func (c *Config) Validate() error {
 	for name, r := range c.Remotes {
@@ -250,6 +403,9 @@ const (
 	initSection                = "init"
 	urlSection                 = "url"
 	extensionsSection          = "extensions"
+	indexSection               = "index"
+	featureSection             = "feature"
+	sshSection                 = "ssh"
 	fetchKey                   = "fetch"
 	urlKey                     = "url"
 	pushurlKey                 = "pushurl"
@@ -257,19 +413,38 @@ const (
 	worktreeKey                = "worktree"
 	commentCharKey             = "commentChar"
 	windowKey                  = "window"
+	depthKey                   = "depth"
+	threadsKey                 = "threads"
 	mergeKey                   = "merge"
 	rebaseKey                  = "rebase"
 	nameKey                    = "name"
 	emailKey                   = "email"
 	descriptionKey             = "description"
 	defaultBranchKey           = "defaultBranch"
+	templateDirKey             = "templateDir"
 	repositoryFormatVersionKey = "repositoryformatversion"
 	objectFormat               = "objectformat"
+	refStorageKey              = "refstorage"
 	mirrorKey                  = "mirror"
+	tagOptKey                  = "tagOpt"
+	sshCommandKey              = "sshCommand"
+	untrackedCacheKey          = "untrackedCache"
+	ignoreCaseKey              = "ignorecase"
+	symlinksKey                = "symlinks"
+	fileModeKey                = "filemode"
+	variantKey                 = "variant"
+	versionKey                 = "version"
+	recordEOIEKey              = "recordEndOfIndexEntries"
+	recordIEOTKey              = "recordOffsetTable"
+	manyFilesKey               = "manyFiles"
 
 	// DefaultPackWindow holds the number of previous objects used to
 	// generate deltas. The value 10 is the same used by git command.
 	DefaultPackWindow = uint(10)
+
+	// DefaultPackDepth holds the maximum number of deltas based on deltas.
+	// The value 50 is the same used by git command.
+	DefaultPackDepth = 50
 )
 
 // Unmarshal parses a git-config file and stores it.
@@ -282,12 +457,22 @@ func (c *Config) Unmarshal(b []byte) error {
 		return err
 	}
 
+	return c.unmarshal()
+}
+
+// unmarshal stores the fields of the config from its Raw content.
+func (c *Config) unmarshal() error {
 	c.unmarshalCore()
 	c.unmarshalUser()
 	c.unmarshalInit()
+	c.unmarshalExtensions()
 	if err := c.unmarshalPack(); err != nil {
 		return err
 	}
+
+	if err := c.unmarshalIndex(); err != nil {
+		return err
+	}
 	unmarshalSubmodules(c.Raw, c.Submodules)
 
 	if err := c.unmarshalBranches(); err != nil {
@@ -303,12 +488,24 @@ func (c *Config) Unmarshal(b []byte) error {
 
 func (c *Config) unmarshalCore() {
 	s := c.Raw.Section(coreSection)
-	if s.Options.Get(bareKey) == "true" {
+	if bare, _ := optionBool(s.Options, bareKey); bare {
 		c.Core.IsBare = true
 	}
 
 	c.Core.Worktree = s.Options.Get(worktreeKey)
 	c.Core.CommentChar = s.Options.Get(commentCharKey)
+	c.Core.SSHCommand = s.Options.Get(sshCommandKey)
+	c.Core.UntrackedCache = s.Options.Get(untrackedCacheKey) == "true"
+	c.Core.IgnoreCase = s.Options.Get(ignoreCaseKey) == "true"
+	c.Core.NoSymlinks = s.Options.Get(symlinksKey) == "false"
+	c.Core.NoFileMode = s.Options.Get(fileModeKey) == "false"
+
+	c.SSH.Variant = c.Raw.Section(sshSection).Options.Get(variantKey)
+}
+
+func (c *Config) unmarshalExtensions() {
+	s := c.Raw.Section(extensionsSection)
+	c.Extensions.RefStorage = format.RefStorage(s.Options.Get(refStorageKey))
 }
 
 func (c *Config) unmarshalUser() {
@@ -337,6 +534,29 @@ func (c *Config) unmarshalPack() error {
 		}
 		c.Pack.Window = uint(winUint)
 	}
+
+	depth := s.Options.Get(depthKey)
+	if depth == "" {
+		c.Pack.Depth = DefaultPackDepth
+	} else {
+		d, err := strconv.ParseInt(depth, 10, 32)
+		if err != nil {
+			return err
+		}
+		c.Pack.Depth = int(d)
+	}
+
+	threads := s.Options.Get(threadsKey)
+	if threads == "" {
+		c.Pack.Threads = 0
+	} else {
+		t, err := strconv.ParseUint(threads, 10, 32)
+		if err != nil {
+			return err
+		}
+		c.Pack.Threads = uint(t)
+	}
+
 	return nil
 }
 
@@ -351,11 +571,6 @@ func (c *Config) unmarshalRemotes() error {
 		c.Remotes[r.Name] = r
 	}
 
-	// Apply insteadOf url rules
-	for _, r := range c.Remotes {
-		r.applyURLRules(c.URLs)
-	}
-
 	return nil
 }
 
@@ -401,34 +616,108 @@ func (c *Config) unmarshalBranches() error {
 	return nil
 }
 
+func (c *Config) unmarshalIndex() error {
+	s := c.Raw.Section(indexSection)
+	c.Index.RecordEndOfIndexEntries = s.Options.Get(recordEOIEKey) == "true"
+	c.Index.RecordOffsetTable = s.Options.Get(recordIEOTKey) == "true"
+	c.Feature.ManyFiles = c.Raw.Section(featureSection).Options.Get(manyFilesKey) == "true"
+
+	version := s.Options.Get(versionKey)
+	if version == "" {
+		c.Index.Version = 0
+		return nil
+	}
+
+	v, err := strconv.ParseUint(version, 10, 32)
+	if err != nil {
+		return err
+	}
+
+	c.Index.Version = uint(v)
+	return nil
+}
+
 func (c *Config) unmarshalInit() {
 	s := c.Raw.Section(initSection)
 	c.Init.DefaultBranch = s.Options.Get(defaultBranchKey)
+	c.Init.TemplateDir = s.Options.Get(templateDirKey)
 }
 
 // Marshal returns Config encoded as a git-config file.
 func (c *Config) Marshal() ([]byte, error) {
+	c.marshal()
+
+	buf := bytes.NewBuffer(nil)
+	if err := format.NewEncoder(buf).Encode(c.Raw); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MarshalPreserving returns Config encoded as a git-config file replacing
+// src, keeping the comments and formatting of the sections of src that are
+// unchanged.
+func (c *Config) MarshalPreserving(src []byte) ([]byte, error) {
+	c.marshal()
+
+	buf := bytes.NewBuffer(nil)
+	if err := format.NewEncoder(buf).EncodePreserving(c.Raw, src); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MarshalScopedPreserving returns the config encoded as MarshalPreserving
+// does, for a config file other than the one of the repository, such as the
+// global or the config.worktree ones: core.bare is only written if it is set.
+func (c *Config) MarshalScopedPreserving(src []byte) ([]byte, error) {
+	c.marshalRaw()
+
+	buf := bytes.NewBuffer(nil)
+	if err := format.NewEncoder(buf).EncodePreserving(c.Raw, src); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// marshalRaw stores the fields of the config in its Raw content as marshal
+// does, but without setting core.bare if it was not set, since only the
+// config of a repository needs it.
+func (c *Config) marshalRaw() {
+	keepBare := c.Core.IsBare ||
+		c.Raw.HasSection(coreSection) && c.Raw.Section(coreSection).HasOption(bareKey)
+
+	c.marshal()
+	if !keepBare {
+		c.Raw.Section(coreSection).RemoveOption(bareKey)
+	}
+}
+
+// marshal stores the fields of the config in its Raw content.
+func (c *Config) marshal() {
 	c.marshalCore()
 	c.marshalExtensions()
 	c.marshalUser()
 	c.marshalPack()
+	c.marshalIndex()
 	c.marshalRemotes()
 	c.marshalSubmodules()
 	c.marshalBranches()
 	c.marshalURLs()
 	c.marshalInit()
-
-	buf := bytes.NewBuffer(nil)
-	if err := format.NewEncoder(buf).Encode(c.Raw); err != nil {
-		return nil, err
-	}
-
-	return buf.Bytes(), nil
 }
 
 func (c *Config) marshalCore() {
 	s := c.Raw.Section(coreSection)
-	s.SetOption(bareKey, fmt.Sprintf("%t", c.Core.IsBare))
+	// core.bare is kept as written if it holds the value already, such as
+	// the key alone for true.
+	if bare, err := optionBool(s.Options, bareKey); err != nil || !s.Options.Has(bareKey) || bare != c.Core.IsBare {
+		s.SetOption(bareKey, fmt.Sprintf("%t", c.Core.IsBare))
+	}
+
 	if string(c.Core.RepositoryFormatVersion) != "" {
 		s.SetOption(repositoryFormatVersionKey, string(c.Core.RepositoryFormatVersion))
 	}
@@ -436,6 +725,38 @@ func (c *Config) marshalCore() {
 	if c.Core.Worktree != "" {
 		s.SetOption(worktreeKey, c.Core.Worktree)
 	}
+
+	if c.Core.SSHCommand != "" {
+		s.SetOption(sshCommandKey, c.Core.SSHCommand)
+	}
+
+	if c.Core.UntrackedCache {
+		s.SetOption(untrackedCacheKey, "true")
+	} else if s.Options.Get(untrackedCacheKey) == "true" {
+		s.SetOption(untrackedCacheKey, "false")
+	}
+
+	if c.Core.IgnoreCase {
+		s.SetOption(ignoreCaseKey, "true")
+	} else if s.Options.Get(ignoreCaseKey) == "true" {
+		s.SetOption(ignoreCaseKey, "false")
+	}
+
+	if c.Core.NoSymlinks {
+		s.SetOption(symlinksKey, "false")
+	} else if s.Options.Get(symlinksKey) == "false" {
+		s.SetOption(symlinksKey, "true")
+	}
+
+	if c.Core.NoFileMode {
+		s.SetOption(fileModeKey, "false")
+	} else if s.Options.Get(fileModeKey) == "false" {
+		s.SetOption(fileModeKey, "true")
+	}
+
+	if c.SSH.Variant != "" {
+		c.Raw.Section(sshSection).SetOption(variantKey, c.SSH.Variant)
+	}
 }
 
 func (c *Config) marshalExtensions() {
@@ -444,6 +765,9 @@ func (c *Config) marshalExtensions() {
 	if c.Core.RepositoryFormatVersion == format.Version_1 {
 		s := c.Raw.Section(extensionsSection)
 		s.SetOption(objectFormat, string(c.Extensions.ObjectFormat))
+		if c.Extensions.RefStorage != "" {
+			s.SetOption(refStorageKey, string(c.Extensions.RefStorage))
+		}
 	}
 }
 
@@ -481,6 +805,14 @@ func (c *Config) marshalPack() {
 	if c.Pack.Window != DefaultPackWindow {
 		s.SetOption(windowKey, fmt.Sprintf("%d", c.Pack.Window))
 	}
+
+	if c.Pack.Depth != DefaultPackDepth {
+		s.SetOption(depthKey, fmt.Sprintf("%d", c.Pack.Depth))
+	}
+
+	if c.Pack.Threads != 0 {
+		s.SetOption(threadsKey, fmt.Sprintf("%d", c.Pack.Threads))
+	}
 }
 
 func (c *Config) marshalRemotes() {
@@ -511,17 +843,10 @@ func (c *Config) marshalRemotes() {
 }
 
 func (c *Config) marshalSubmodules() {
-	s := c.Raw.Section(submoduleSection)
-	s.Subsections = make(format.Subsections, len(c.Submodules))
-
-	var i int
-	for _, r := range c.Submodules {
-		section := r.marshal()
+	for _, section := range marshalSubmodules(c.Raw, c.Submodules) {
 		// the submodule section at config is a subset of the .gitmodule file
 		// we should remove the non-valid options for the config file.
 		section.RemoveOption(pathKey)
-		s.Subsections[i] = section
-		i++
 	}
 }
 
@@ -566,11 +891,34 @@ func (c *Config) marshalURLs() {
 	}
 }
 
+func (c *Config) marshalIndex() {
+	s := c.Raw.Section(indexSection)
+	if c.Index.Version != 0 {
+		s.SetOption(versionKey, fmt.Sprintf("%d", c.Index.Version))
+	}
+
+	if c.Index.RecordEndOfIndexEntries {
+		s.SetOption(recordEOIEKey, "true")
+	}
+
+	if c.Index.RecordOffsetTable {
+		s.SetOption(recordIEOTKey, "true")
+	}
+
+	if c.Feature.ManyFiles {
+		c.Raw.Section(featureSection).SetOption(manyFilesKey, "true")
+	}
+}
+
 func (c *Config) marshalInit() {
 	s := c.Raw.Section(initSection)
 	if c.Init.DefaultBranch != "" {
 		s.SetOption(defaultBranchKey, c.Init.DefaultBranch)
 	}
+
+	if c.Init.TemplateDir != "" {
+		s.SetOption(templateDirKey, c.Init.TemplateDir)
+	}
 }
 
 // RemoteConfig contains the configuration for a given remote repository.
@@ -578,18 +926,20 @@ type RemoteConfig struct {
 	// Name of the remote
 	Name string
 	// URLs the URLs of a remote repository. It must be non-empty. Fetch will
-	// always use the first URL, while push will use all of them.
+	// always use the first URL, while push will use all of them, unless
+	// PushURLs is set.
 	URLs []string
+	// PushURLs the URLs used to push to the remote repository instead of
+	// URLs, as the remote.<name>.pushurl option. Push will use all of them.
+	PushURLs []string
 	// Mirror indicates that the repository is a mirror of remote.
 	Mirror bool
 
-	// insteadOfRulesApplied have urls been modified
-	insteadOfRulesApplied bool
-	// originalURLs are the urls before applying insteadOf rules
-	originalURLs []string
-
 	// Fetch the default set of "refspec" for fetch operation
 	Fetch []RefSpec
+	// TagOpt is the default tag option used when fetching from the remote,
+	// either "--no-tags" or "--tags", as the remote.<name>.tagOpt option.
+	TagOpt string
 
 	// raw representation of the subsection, filled by marshal or unmarshal are
 	// called
@@ -634,9 +984,10 @@ func (c *RemoteConfig) unmarshal(s *format.Subsection) error {
 
 	c.Name = c.raw.Name
 	c.URLs = append([]string(nil), c.raw.Options.GetAll(urlKey)...)
-	c.URLs = append(c.URLs, c.raw.Options.GetAll(pushurlKey)...)
+	c.PushURLs = append([]string(nil), c.raw.Options.GetAll(pushurlKey)...)
 	c.Fetch = fetch
 	c.Mirror = c.raw.Options.Get(mirrorKey) == "true"
+	c.TagOpt = c.raw.Options.Get(tagOptKey)
 
 	return nil
 }
@@ -650,12 +1001,13 @@ func (c *RemoteConfig) marshal() *format.Subsection {
 	if len(c.URLs) == 0 {
 		c.raw.RemoveOption(urlKey)
 	} else {
-		urls := c.URLs
-		if c.insteadOfRulesApplied {
-			urls = c.originalURLs
-		}
+		c.raw.SetOption(urlKey, c.URLs...)
+	}
 
-		c.raw.SetOption(urlKey, urls...)
+	if len(c.PushURLs) == 0 {
+		c.raw.RemoveOption(pushurlKey)
+	} else {
+		c.raw.SetOption(pushurlKey, c.PushURLs...)
 	}
 
 	if len(c.Fetch) == 0 {
@@ -673,26 +1025,15 @@ func (c *RemoteConfig) marshal() *format.Subsection {
 		c.raw.SetOption(mirrorKey, strconv.FormatBool(c.Mirror))
 	}
 
+	if c.TagOpt == "" {
+		c.raw.RemoveOption(tagOptKey)
+	} else {
+		c.raw.SetOption(tagOptKey, c.TagOpt)
+	}
+
 	return c.raw
 }
 
 func (c *RemoteConfig) IsFirstURLLocal() bool {
 	return url.IsLocalEndpoint(c.URLs[0])
 }
-
-func (c *RemoteConfig) applyURLRules(urlRules map[string]*URL) {
-	// save original urls
-	originalURLs := make([]string, len(c.URLs))
-	copy(originalURLs, c.URLs)
-
-	for i, url := range c.URLs {
-		if matchingURLRule := findLongestInsteadOfMatch(url, urlRules); matchingURLRule != nil {
-			c.URLs[i] = matchingURLRule.ApplyInsteadOf(c.URLs[i])
-			c.insteadOfRulesApplied = true
-		}
-	}
-
-	if c.insteadOfRulesApplied {
-		c.originalURLs = originalURLs
-	}
-}
@@ -8,6 +8,7 @@ import (
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5/plumbing"
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
 	. "gopkg.in/check.v1"
 )
 
@@ -39,6 +40,7 @@ func (s *ConfigSuite) TestUnmarshal(c *C) {
 		url = git@github.com:src-d/go-git.git
 		fetch = +refs/heads/*:refs/remotes/origin/*
 		fetch = +refs/pull/*:refs/remotes/origin/pull/*
+		tagOpt = --no-tags
 [remote "insteadOf"]
 		url = https://github.com/kostyay/go-git.git
 [remote "win-local"]
@@ -53,6 +55,7 @@ func (s *ConfigSuite) TestUnmarshal(c *C) {
 		description = "Add support for branch description.\\n\\nEdit branch description: git branch --edit-description\\n"
 [init]
 		defaultBranch = main
+		templateDir = ~/.git-template
 [url "ssh://git@github.com/"]
 	insteadOf = https://github.com/
 `)
@@ -78,9 +81,11 @@ func (s *ConfigSuite) TestUnmarshal(c *C) {
 	c.Assert(cfg.Remotes["alt"].Name, Equals, "alt")
 	c.Assert(cfg.Remotes["alt"].URLs, DeepEquals, []string{"git@github.com:mcuadros/go-git.git", "git@github.com:src-d/go-git.git"})
 	c.Assert(cfg.Remotes["alt"].Fetch, DeepEquals, []RefSpec{"+refs/heads/*:refs/remotes/origin/*", "+refs/pull/*:refs/remotes/origin/pull/*"})
+	c.Assert(cfg.Remotes["alt"].TagOpt, Equals, "--no-tags")
 	c.Assert(cfg.Remotes["win-local"].Name, Equals, "win-local")
 	c.Assert(cfg.Remotes["win-local"].URLs, DeepEquals, []string{"X:\\Git\\"})
-	c.Assert(cfg.Remotes["insteadOf"].URLs, DeepEquals, []string{"ssh://git@github.com/kostyay/go-git.git"})
+	c.Assert(cfg.Remotes["insteadOf"].URLs, DeepEquals, []string{"https://github.com/kostyay/go-git.git"})
+	c.Assert(cfg.ApplyInsteadOf(cfg.Remotes["insteadOf"].URLs[0]), Equals, "ssh://git@github.com/kostyay/go-git.git")
 	c.Assert(cfg.Submodules, HasLen, 1)
 	c.Assert(cfg.Submodules["qux"].Name, Equals, "qux")
 	c.Assert(cfg.Submodules["qux"].URL, Equals, "https://github.com/foo/qux.git")
@@ -89,6 +94,7 @@ func (s *ConfigSuite) TestUnmarshal(c *C) {
 	c.Assert(cfg.Branches["master"].Merge, Equals, plumbing.ReferenceName("refs/heads/master"))
 	c.Assert(cfg.Branches["master"].Description, Equals, "Add support for branch description.\n\nEdit branch description: git branch --edit-description\n")
 	c.Assert(cfg.Init.DefaultBranch, Equals, "main")
+	c.Assert(cfg.Init.TemplateDir, Equals, "~/.git-template")
 }
 
 func (s *ConfigSuite) TestMarshal(c *C) {
@@ -102,6 +108,7 @@ func (s *ConfigSuite) TestMarshal(c *C) {
 	url = git@github.com:src-d/go-git.git
 	fetch = +refs/heads/*:refs/remotes/origin/*
 	fetch = +refs/pull/*:refs/remotes/origin/pull/*
+	tagOpt = --tags
 [remote "insteadOf"]
 	url = https://github.com/kostyay/go-git.git
 [remote "origin"]
@@ -131,9 +138,10 @@ func (s *ConfigSuite) TestMarshal(c *C) {
 	}
 
 	cfg.Remotes["alt"] = &RemoteConfig{
-		Name:  "alt",
-		URLs:  []string{"git@github.com:mcuadros/go-git.git", "git@github.com:src-d/go-git.git"},
-		Fetch: []RefSpec{"+refs/heads/*:refs/remotes/origin/*", "+refs/pull/*:refs/remotes/origin/pull/*"},
+		Name:   "alt",
+		URLs:   []string{"git@github.com:mcuadros/go-git.git", "git@github.com:src-d/go-git.git"},
+		Fetch:  []RefSpec{"+refs/heads/*:refs/remotes/origin/*", "+refs/pull/*:refs/remotes/origin/pull/*"},
+		TagOpt: "--tags",
 	}
 
 	cfg.Remotes["win-local"] = &RemoteConfig{
@@ -209,6 +217,176 @@ func (s *ConfigSuite) TestUnmarshalMarshal(c *C) {
 	c.Assert(string(output), DeepEquals, string(input))
 }
 
+func (s *ConfigSuite) TestUnmarshalMarshalSSHCommand(c *C) {
+	input := []byte(`[core]
+	bare = false
+	sshCommand = ssh -i ~/.ssh/deploy_key
+[ssh]
+	variant = plink
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.SSHCommand, Equals, "ssh -i ~/.ssh/deploy_key")
+	c.Assert(cfg.SSH.Variant, Equals, "plink")
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+}
+
+func (s *ConfigSuite) TestUnmarshalMarshalUntrackedCache(c *C) {
+	input := []byte(`[core]
+	bare = false
+	untrackedCache = true
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.UntrackedCache, Equals, true)
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+
+	cfg.Core.UntrackedCache = false
+	output, err = cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, "[core]\n\tbare = false\n\tuntrackedCache = false\n")
+}
+
+func (s *ConfigSuite) TestUnmarshalMarshalIgnoreCase(c *C) {
+	input := []byte(`[core]
+	bare = false
+	ignorecase = true
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.IgnoreCase, Equals, true)
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+
+	cfg.Core.IgnoreCase = false
+	output, err = cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, "[core]\n\tbare = false\n\tignorecase = false\n")
+}
+
+func (s *ConfigSuite) TestUnmarshalMarshalSymlinks(c *C) {
+	input := []byte(`[core]
+	bare = false
+	symlinks = false
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.NoSymlinks, Equals, true)
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+
+	cfg.Core.NoSymlinks = false
+	output, err = cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, "[core]\n\tbare = false\n\tsymlinks = true\n")
+}
+
+func (s *ConfigSuite) TestUnmarshalMarshalFileMode(c *C) {
+	input := []byte(`[core]
+	bare = false
+	filemode = false
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.NoFileMode, Equals, true)
+
+	cfg.Core.NoFileMode = false
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, "[core]\n\tbare = false\n\tfilemode = true\n")
+}
+
+func (s *ConfigSuite) TestUnmarshalMarshalRefStorage(c *C) {
+	input := []byte(`[core]
+	bare = false
+	repositoryformatversion = 1
+[extensions]
+	refstorage = reftable
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Extensions.RefStorage, Equals, format.RefStorageReftable)
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+}
+
+func (s *ConfigSuite) TestUnmarshalMarshalIndex(c *C) {
+	input := []byte(`[core]
+	bare = false
+[index]
+	version = 4
+	recordEndOfIndexEntries = true
+	recordOffsetTable = true
+[feature]
+	manyFiles = true
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Index.Version, Equals, uint(4))
+	c.Assert(cfg.Index.RecordEndOfIndexEntries, Equals, true)
+	c.Assert(cfg.Index.RecordOffsetTable, Equals, true)
+	c.Assert(cfg.Feature.ManyFiles, Equals, true)
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+
+	err = cfg.Unmarshal([]byte("[index]\n\tversion = foo\n"))
+	c.Assert(err, NotNil)
+}
+
+func (s *ConfigSuite) TestUnmarshalMarshalNegativeRefSpecs(c *C) {
+	input := []byte(`[core]
+	bare = false
+[remote "origin"]
+	url = git@github.com:mcuadros/go-git.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = ^refs/heads/tmp/*
+	fetch = +refs/pull/*:refs/remotes/origin/pull/*
+	fetch = ^refs/pull/*/merge
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Remotes["origin"].Fetch, DeepEquals, []RefSpec{
+		"+refs/heads/*:refs/remotes/origin/*",
+		"^refs/heads/tmp/*",
+		"+refs/pull/*:refs/remotes/origin/pull/*",
+		"^refs/pull/*/merge",
+	})
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+}
+
 func (s *ConfigSuite) TestLoadConfigXDG(c *C) {
 	cfg := NewConfig()
 	cfg.User.Name = "foo"
@@ -342,6 +520,31 @@ func (s *ConfigSuite) TestRemoteConfigDefaultValues(c *C) {
 	c.Assert(config.Submodules, HasLen, 0)
 	c.Assert(config.Raw, NotNil)
 	c.Assert(config.Pack.Window, Equals, DefaultPackWindow)
+	c.Assert(config.Pack.Depth, Equals, DefaultPackDepth)
+	c.Assert(config.Pack.Threads, Equals, uint(0))
+}
+
+func (s *ConfigSuite) TestUnmarshalMarshalPack(c *C) {
+	input := []byte(`[pack]
+	window = 20
+	depth = 10
+	threads = 4
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Pack.Window, Equals, uint(20))
+	c.Assert(cfg.Pack.Depth, Equals, 10)
+	c.Assert(cfg.Pack.Threads, Equals, uint(4))
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(output), string(input)), Equals, true)
+
+	cfg = NewConfig()
+	err = cfg.Unmarshal([]byte("[pack]\n\tdepth = foo\n"))
+	c.Assert(err, NotNil)
 }
 
 func (s *ConfigSuite) TestLoadConfigLocalScope(c *C) {
@@ -391,7 +594,29 @@ func (s *ConfigSuite) TestUnmarshalRemotes(c *C) {
 	err := cfg.Unmarshal(input)
 	c.Assert(err, IsNil)
 
-	c.Assert(cfg.Remotes["origin"].URLs[0], Equals, "https://git.sr.ht/~mcepl/go-git")
-	c.Assert(cfg.Remotes["origin"].URLs[1], Equals, "git@git.sr.ht:~mcepl/go-git.git")
+	c.Assert(cfg.Remotes["origin"].URLs, DeepEquals, []string{"https://git.sr.ht/~mcepl/go-git"})
+	c.Assert(cfg.Remotes["origin"].PushURLs, DeepEquals, []string{"git@git.sr.ht:~mcepl/go-git.git"})
 }
 
+func (s *ConfigSuite) TestUnmarshalMarshalPushURLs(c *C) {
+	input := []byte(`[core]
+	bare = false
+[remote "origin"]
+	url = https://git.sr.ht/~mcepl/go-git
+	pushurl = git@git.sr.ht:~mcepl/go-git.git
+	pushurl = git@github.com:mcepl/go-git.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Remotes["origin"].PushURLs, DeepEquals, []string{
+		"git@git.sr.ht:~mcepl/go-git.git",
+		"git@github.com:mcepl/go-git.git",
+	})
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+}
@@ -0,0 +1,342 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+)
+
+// maxIncludeDepth is the maximum depth of nested includes, as in git.
+const maxIncludeDepth = 10
+
+// ErrMaxIncludeDepth is returned when the includes of a config file are
+// nested too deep, usually because of an include cycle.
+var ErrMaxIncludeDepth = errors.New("exceeded maximum include depth")
+
+// IncludeOptions describes the repository the includeIf conditions of the
+// config files are evaluated against.
+type IncludeOptions struct {
+	// GitDir is the absolute path of the git directory of the repository,
+	// for the gitdir: and gitdir/i: conditions, and of the local config
+	// file, for its relative includes.
+	GitDir string
+	// Branch is the short name of the current branch, for the onbranch:
+	// conditions.
+	Branch string
+	// Worktree is the config of the config.worktree file of the worktree,
+	// merged over the local config, if extensions.worktreeConfig is enabled.
+	Worktree *Config
+}
+
+// LoadMergedConfig loads the config files of the given scope and the lower
+// ones, and merges them with the local config, as git does: the system
+// config first, then the global and the local ones, each one taking
+// precedence over the previous ones. The include.path and includeIf
+// directives are followed.
+//
+// The returned Config must not be written, since the content of all the
+// files is mixed in it, LoadConfig and SaveConfig must be used instead.
+func LoadMergedConfig(scope Scope, local *Config, opts IncludeOptions) (*Config, error) {
+	i := &includer{opts: opts}
+	raw, err := i.load(scope, local)
+	if err != nil {
+		return nil, err
+	}
+
+	// The hasconfig: conditions are evaluated against the config read
+	// without them, as git does.
+	if i.hasConfig {
+		i.urls = remoteURLs(raw)
+		if raw, err = i.load(scope, local); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := NewConfig()
+	cfg.Raw = raw
+	if err := cfg.unmarshal(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+type includer struct {
+	opts IncludeOptions
+	// urls are the remote URLs the hasconfig:remote.*.url: conditions
+	// match, nil while they are not evaluated yet.
+	urls      []string
+	hasConfig bool
+}
+
+func (i *includer) load(scope Scope, local *Config) (*format.Config, error) {
+	raw := format.New()
+	for _, s := range []Scope{SystemScope, GlobalScope} {
+		if s > scope {
+			continue
+		}
+
+		files, err := readPaths(s)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			b, err := util.ReadFile(osfs.Default, file)
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			if err := i.decode(raw, file, b, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, c := range []struct {
+		cfg   *Config
+		name  string
+		scope Scope
+	}{
+		{local, "config", LocalScope},
+		{i.opts.Worktree, "config.worktree", WorktreeScope},
+	} {
+		if c.cfg == nil {
+			continue
+		}
+
+		var b []byte
+		var err error
+		if c.scope == LocalScope {
+			b, err = c.cfg.Marshal()
+		} else {
+			b, err = c.cfg.MarshalScopedPreserving(nil)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var file string
+		if i.opts.GitDir != "" {
+			file = filepath.Join(i.opts.GitDir, c.name)
+		}
+
+		if err := i.decode(raw, file, b, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return raw, nil
+}
+
+// decode decodes the content of the given config file in raw, with the
+// files it includes.
+func (i *includer) decode(raw *format.Config, file string, b []byte, depth int) error {
+	d := format.NewDecoder(bytes.NewReader(b))
+	d.Include = func(cfg *format.Config, condition, p string) error {
+		if !i.match(condition, file) {
+			return nil
+		}
+
+		target, ok := expandPath(p, file)
+		if !ok {
+			return nil
+		}
+
+		if depth >= maxIncludeDepth {
+			return fmt.Errorf("%w (%d) while including %s from %s",
+				ErrMaxIncludeDepth, maxIncludeDepth, target, file)
+		}
+
+		content, err := util.ReadFile(osfs.Default, target)
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return i.decode(cfg, target, content, depth+1)
+	}
+
+	return d.Decode(raw)
+}
+
+// match returns true if the condition of an includeIf directive of the given
+// file is met.
+func (i *includer) match(condition, file string) bool {
+	if condition == "" {
+		return true
+	}
+
+	kind, pattern, ok := strings.Cut(condition, ":")
+	if !ok {
+		return false
+	}
+
+	switch kind {
+	case "gitdir", "gitdir/i":
+		if i.opts.GitDir == "" {
+			return false
+		}
+
+		pattern, ok := gitdirPattern(pattern, file)
+		if !ok {
+			return false
+		}
+
+		fold := kind == "gitdir/i"
+		if matchPattern(pattern, i.opts.GitDir, fold) {
+			return true
+		}
+
+		real, err := filepath.EvalSymlinks(i.opts.GitDir)
+		return err == nil && matchPattern(pattern, real, fold)
+	case "onbranch":
+		if i.opts.Branch == "" {
+			return false
+		}
+
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+
+		return matchPattern(pattern, i.opts.Branch, false)
+	case "hasconfig":
+		key, pattern, ok := strings.Cut(pattern, ":")
+		if !ok || key != "remote.*.url" {
+			return false
+		}
+
+		i.hasConfig = true
+		for _, url := range i.urls {
+			if matchPattern(pattern, url, false) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// gitdirPattern returns the pattern of a gitdir: condition as git expands
+// it: ~/ is the home directory, ./ the directory of the config file, a
+// relative pattern matches at any depth and a trailing slash matches
+// everything under it.
+func gitdirPattern(pattern, file string) (string, bool) {
+	switch {
+	case strings.HasPrefix(pattern, "~/"):
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", false
+		}
+
+		pattern = filepath.ToSlash(home) + pattern[1:]
+	case strings.HasPrefix(pattern, "./"):
+		if file == "" {
+			return "", false
+		}
+
+		pattern = filepath.ToSlash(filepath.Dir(file)) + pattern[1:]
+	}
+
+	if !path.IsAbs(pattern) && !filepath.IsAbs(pattern) {
+		pattern = "**/" + pattern
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	return pattern, true
+}
+
+// expandPath returns the path of the file included from the given file,
+// false if it is relative and the including file is unknown.
+func expandPath(p, file string) (string, bool) {
+	if strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", false
+		}
+
+		return filepath.Join(home, p[2:]), true
+	}
+
+	if filepath.IsAbs(p) {
+		return p, true
+	}
+
+	if file == "" {
+		return "", false
+	}
+
+	return filepath.Join(filepath.Dir(file), p), true
+}
+
+// matchPattern matches the name with the pattern as git wildmatch does with
+// WM_PATHNAME: a wildcard doesn't match a slash, but ** matches any number
+// of directories.
+func matchPattern(pattern, name string, fold bool) bool {
+	if fold {
+		pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	}
+
+	return matchParts(strings.Split(pattern, "/"), strings.Split(filepath.ToSlash(name), "/"))
+}
+
+func matchParts(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchParts(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+
+	return matchParts(pattern[1:], name[1:])
+}
+
+// remoteURLs returns the URLs of the remotes of the config.
+func remoteURLs(raw *format.Config) []string {
+	urls := []string{}
+	for _, s := range raw.Sections {
+		if !s.IsName(remoteSection) {
+			continue
+		}
+
+		for _, ss := range s.Subsections {
+			urls = append(urls, ss.Options.GetAll(urlKey)...)
+		}
+	}
+
+	return urls
+}
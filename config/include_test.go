@@ -0,0 +1,154 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/internal/test"
+	. "gopkg.in/check.v1"
+)
+
+type IncludeSuite struct {
+	home    string
+	restore func()
+}
+
+var _ = Suite(&IncludeSuite{})
+
+func (s *IncludeSuite) SetUpTest(c *C) {
+	s.home = c.MkDir()
+	s.restore = test.SetEnv(map[string]string{
+		"HOME":                s.home,
+		"XDG_CONFIG_HOME":     "",
+		"GIT_CONFIG_GLOBAL":   "",
+		"GIT_CONFIG_SYSTEM":   "",
+		"GIT_CONFIG_NOSYSTEM": "true",
+	})
+}
+
+func (s *IncludeSuite) TearDownTest(c *C) {
+	s.restore()
+}
+
+func (s *IncludeSuite) write(c *C, name, content string) string {
+	file := filepath.Join(s.home, name)
+	err := util.WriteFile(osfs.Default, file, []byte(content), 0o644)
+	c.Assert(err, IsNil)
+	return file
+}
+
+func (s *IncludeSuite) TestLoadMergedConfigPrecedence(c *C) {
+	s.write(c, ".config/git/config", "[user]\n\tname = xdg\n\temail = xdg@example.com\n")
+	s.write(c, ".gitconfig", "[user]\n\tname = global\n[core]\n\teditor = vim\n")
+
+	local := NewConfig()
+	err := local.Unmarshal([]byte("[user]\n\temail = local@example.com\n"))
+	c.Assert(err, IsNil)
+
+	cfg, err := LoadMergedConfig(GlobalScope, local, IncludeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Name, Equals, "global")
+	c.Assert(cfg.User.Email, Equals, "local@example.com")
+	c.Assert(cfg.Raw.Section("core").Option("editor"), Equals, "vim")
+
+	cfg, err = LoadMergedConfig(LocalScope, local, IncludeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Name, Equals, "")
+}
+
+func (s *IncludeSuite) TestLoadMergedConfigInclude(c *C) {
+	s.write(c, "inc/identity", "[user]\n\tname = included\n\temail = included@example.com\n[include]\n\tpath = nested\n")
+	s.write(c, "inc/nested", "[core]\n\teditor = nano\n")
+	s.write(c, ".gitconfig", "[user]\n\tname = global\n[include]\n\tpath = inc/identity\n\tpath = ~/missing\n[user]\n\temail = after@example.com\n")
+
+	cfg, err := LoadMergedConfig(GlobalScope, nil, IncludeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Name, Equals, "included")
+	c.Assert(cfg.User.Email, Equals, "after@example.com")
+	c.Assert(cfg.Raw.Section("core").Option("editor"), Equals, "nano")
+
+	loaded, err := LoadConfig(GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(loaded.User.Name, Equals, "global")
+}
+
+func (s *IncludeSuite) TestLoadMergedConfigIncludeCycle(c *C) {
+	s.write(c, ".gitconfig", "[include]\n\tpath = ~/.gitconfig\n")
+
+	_, err := LoadMergedConfig(GlobalScope, nil, IncludeOptions{})
+	c.Assert(errors.Is(err, ErrMaxIncludeDepth), Equals, true)
+}
+
+func (s *IncludeSuite) TestLoadMergedConfigIncludeIf(c *C) {
+	gitDir := filepath.Join(s.home, "work", "project", ".git")
+	s.write(c, "work.inc", "[user]\n\temail = work@example.com\n")
+	s.write(c, "branch.inc", "[core]\n\teditor = emacs\n")
+	s.write(c, "url.inc", "[user]\n\tname = Worker\n")
+	s.write(c, "other.inc", "[user]\n\tname = Other\n")
+	s.write(c, ".gitconfig", `[user]
+	email = home@example.com
+[includeIf "gitdir:~/work/"]
+	path = work.inc
+[includeIf "gitdir/i:**/PROJECT/.git"]
+	path = branch.inc
+[includeIf "onbranch:feature/"]
+	path = other.inc
+[includeIf "hasconfig:remote.*.url:https://example.com/**"]
+	path = url.inc
+`)
+
+	local := NewConfig()
+	err := local.Unmarshal([]byte("[remote \"origin\"]\n\turl = https://example.com/org/project\n"))
+	c.Assert(err, IsNil)
+
+	cfg, err := LoadMergedConfig(GlobalScope, local, IncludeOptions{GitDir: gitDir, Branch: "feature/foo"})
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Email, Equals, "work@example.com")
+	c.Assert(cfg.User.Name, Equals, "Worker")
+	c.Assert(cfg.Raw.Section("core").Option("editor"), Equals, "emacs")
+
+	cfg, err = LoadMergedConfig(GlobalScope, nil, IncludeOptions{GitDir: "/elsewhere/.git", Branch: "feature/foo"})
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Email, Equals, "home@example.com")
+	c.Assert(cfg.User.Name, Equals, "Other")
+	c.Assert(cfg.Raw.Section("core").Option("editor"), Equals, "")
+}
+
+func (s *IncludeSuite) TestSaveConfig(c *C) {
+	file := s.write(c, ".gitconfig", "# identity\n[user]\n\tname = foo ; nickname\n[core]\n\teditor = vim\n")
+
+	cfg, err := LoadConfig(GlobalScope)
+	c.Assert(err, IsNil)
+
+	cfg.Raw.Section("core").SetOption("editor", "nano")
+	err = SaveConfig(GlobalScope, cfg)
+	c.Assert(err, IsNil)
+
+	b, err := util.ReadFile(osfs.Default, file)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "# identity\n[user]\n\tname = foo ; nickname\n[core]\n\teditor = nano\n")
+}
+
+func (s *IncludeSuite) TestSaveConfigDefaultPath(c *C) {
+	cfg := NewConfig()
+	cfg.User.Name = "foo"
+	err := SaveConfig(GlobalScope, cfg)
+	c.Assert(err, IsNil)
+
+	_, err = os.Stat(filepath.Join(s.home, ".gitconfig"))
+	c.Assert(err, IsNil)
+
+	system := filepath.Join(s.home, "system")
+	os.Setenv("GIT_CONFIG_NOSYSTEM", "")
+	os.Setenv("GIT_CONFIG_SYSTEM", system)
+
+	err = SaveConfig(SystemScope, cfg)
+	c.Assert(err, IsNil)
+
+	loaded, err := LoadConfig(SystemScope)
+	c.Assert(err, IsNil)
+	c.Assert(loaded.User.Name, Equals, "foo")
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"regexp"
+	"sort"
 
 	format "github.com/go-git/go-git/v5/plumbing/format/config"
 )
@@ -37,8 +38,10 @@ func NewModules() *Modules {
 }
 
 const (
-	pathKey   = "path"
-	branchKey = "branch"
+	pathKey    = "path"
+	branchKey  = "branch"
+	ignoreKey  = "ignore"
+	shallowKey = "shallow"
 )
 
 // Unmarshal parses a git-config file and stores it.
@@ -57,23 +60,59 @@ func (m *Modules) Unmarshal(b []byte) error {
 
 // Marshal returns Modules encoded as a git-config file.
 func (m *Modules) Marshal() ([]byte, error) {
-	s := m.raw.Section(submoduleSection)
-	s.Subsections = make(format.Subsections, len(m.Submodules))
+	marshalSubmodules(m.raw, m.Submodules)
 
-	var i int
-	for _, r := range m.Submodules {
-		s.Subsections[i] = r.marshal()
-		i++
+	buf := bytes.NewBuffer(nil)
+	if err := format.NewEncoder(buf).Encode(m.raw); err != nil {
+		return nil, err
 	}
 
+	return buf.Bytes(), nil
+}
+
+// MarshalPreserving returns Modules encoded as a git-config file replacing
+// src, keeping the comments and formatting of the submodules of src that are
+// unchanged.
+func (m *Modules) MarshalPreserving(src []byte) ([]byte, error) {
+	marshalSubmodules(m.raw, m.Submodules)
+
 	buf := bytes.NewBuffer(nil)
-	if err := format.NewEncoder(buf).Encode(m.raw); err != nil {
+	if err := format.NewEncoder(buf).EncodePreserving(m.raw, src); err != nil {
 		return nil, err
 	}
 
 	return buf.Bytes(), nil
 }
 
+// marshalSubmodules stores the submodules in the submodule subsections of
+// the raw config, in their original order, the new ones last, by name.
+func marshalSubmodules(raw *format.Config, submodules map[string]*Submodule) []*format.Subsection {
+	s := raw.Section(submoduleSection)
+	newSubsections := make(format.Subsections, 0, len(submodules))
+	added := make(map[string]bool)
+	for _, subsection := range s.Subsections {
+		if sub, ok := submodules[subsection.Name]; ok && !added[subsection.Name] {
+			newSubsections = append(newSubsections, sub.marshal())
+			added[subsection.Name] = true
+		}
+	}
+
+	names := make([]string, 0, len(submodules))
+	for name := range submodules {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		if !added[name] {
+			newSubsections = append(newSubsections, submodules[name].marshal())
+		}
+	}
+
+	s.Subsections = newSubsections
+	return newSubsections
+}
+
 // Submodule defines a submodule.
 type Submodule struct {
 	// Name module name
@@ -86,6 +125,12 @@ type Submodule struct {
 	// Branch is a remote branch name for tracking updates in the upstream
 	// submodule. Optional value.
 	Branch string
+	// Ignore defines which changes of the submodule are ignored by the
+	// status: "none", "untracked", "dirty" or "all". Optional value.
+	Ignore string
+	// Shallow, if true, makes the submodule be cloned with a history depth
+	// of 1, unless a depth is requested. Optional value.
+	Shallow bool
 
 	// raw representation of the subsection, filled by marshal or unmarshal are
 	// called.
@@ -116,6 +161,8 @@ func (m *Submodule) unmarshal(s *format.Subsection) {
 	m.Path = m.raw.Option(pathKey)
 	m.URL = m.raw.Option(urlKey)
 	m.Branch = m.raw.Option(branchKey)
+	m.Ignore = m.raw.Option(ignoreKey)
+	m.Shallow, _ = parseBool(m.raw.Option(shallowKey))
 }
 
 func (m *Submodule) marshal() *format.Subsection {
@@ -133,6 +180,20 @@ func (m *Submodule) marshal() *format.Subsection {
 
 	if m.Branch != "" {
 		m.raw.SetOption(branchKey, m.Branch)
+	} else {
+		m.raw.RemoveOption(branchKey)
+	}
+
+	if m.Ignore != "" {
+		m.raw.SetOption(ignoreKey, m.Ignore)
+	} else {
+		m.raw.RemoveOption(ignoreKey)
+	}
+
+	if m.Shallow {
+		m.raw.SetOption(shallowKey, "true")
+	} else {
+		m.raw.RemoveOption(shallowKey)
 	}
 
 	return m.raw
@@ -84,13 +84,52 @@ func (s *ModulesSuite) TestUnmarshalMarshal(c *C) {
 	path = foo/bar
 	url = https://github.com/foo/bar.git
 	ignore = all
+	shallow = true
 `)
 
 	cfg := NewModules()
 	err := cfg.Unmarshal(input)
 	c.Assert(err, IsNil)
+	c.Assert(cfg.Submodules["foo/bar"].Ignore, Equals, "all")
+	c.Assert(cfg.Submodules["foo/bar"].Shallow, Equals, true)
 
 	output, err := cfg.Marshal()
 	c.Assert(err, IsNil)
 	c.Assert(string(output), DeepEquals, string(input))
 }
+
+func (s *ModulesSuite) TestMarshalPreserving(c *C) {
+	input := []byte(`# vendored
+[submodule "foo"]
+    path = foo
+    url = https://github.com/foo/foo.git # mirror
+[submodule "bar"]
+	path = bar
+	url = https://github.com/foo/bar.git
+	branch = dev
+`)
+
+	cfg := NewModules()
+	c.Assert(cfg.Unmarshal(input), IsNil)
+
+	cfg.Submodules["bar"].Branch = ""
+	cfg.Submodules["baz"] = &Submodule{Name: "baz", Path: "baz", URL: "../baz"}
+	cfg.Submodules["aaa"] = &Submodule{Name: "aaa", Path: "aaa", URL: "../aaa"}
+
+	output, err := cfg.MarshalPreserving(input)
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, `# vendored
+[submodule "foo"]
+    path = foo
+    url = https://github.com/foo/foo.git # mirror
+[submodule "bar"]
+	path = bar
+	url = https://github.com/foo/bar.git
+[submodule "aaa"]
+	path = aaa
+	url = ../aaa
+[submodule "baz"]
+	path = baz
+	url = ../baz
+`)
+}
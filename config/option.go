@@ -0,0 +1,303 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+)
+
+var (
+	// ErrInvalidKey is returned when a config key is not a valid
+	// <section>.<key> or <section>.<subsection>.<key> key.
+	ErrInvalidKey = errors.New("invalid config key")
+	// ErrInvalidValue is returned when a config value can't be parsed as
+	// the requested type.
+	ErrInvalidValue = errors.New("invalid config value")
+)
+
+// ParseKey splits a config key, such as "core.autocrlf" or
+// "remote.origin.url", in its section, subsection and key names. The
+// subsection is everything between the first and the last dot, so it may
+// contain dots itself. The section and key names are case-insensitive, and
+// returned in lower case, while the subsection is case-sensitive.
+func ParseKey(key string) (section, subsection, name string, err error) {
+	first, last := strings.IndexByte(key, '.'), strings.LastIndexByte(key, '.')
+	if first == -1 {
+		return "", "", "", fmt.Errorf("%w: %q, missing a section", ErrInvalidKey, key)
+	}
+
+	section, name = key[:first], key[last+1:]
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+
+	if !isValidName(section, false) || !isValidName(name, true) {
+		return "", "", "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	return strings.ToLower(section), subsection, strings.ToLower(name), nil
+}
+
+// isValidName returns true if the section or key name is made of
+// alphanumeric characters and dashes, a key starting with a letter.
+func isValidName(name string, key bool) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case (r >= '0' && r <= '9' || r == '-') && (!key || i > 0):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// options returns the options of the section or subsection of the key, nil
+// if the section or subsection doesn't exist, and the key name.
+func (c *Config) options(key string) (format.Options, string, error) {
+	section, subsection, name, err := ParseKey(key)
+	if err != nil || c.Raw == nil || !c.Raw.HasSection(section) {
+		return nil, name, err
+	}
+
+	s := c.Raw.Section(section)
+	if subsection == "" {
+		return s.Options, name, nil
+	}
+
+	if !s.HasSubsection(subsection) {
+		return nil, name, nil
+	}
+
+	return s.Subsection(subsection).Options, name, nil
+}
+
+// GetString returns the value of the given key, such as "core.autocrlf",
+// from the raw content of the config. If the key has multiple values, the
+// last one is returned, as git does, and an empty string if it is not set or
+// is not a valid key.
+func (c *Config) GetString(key string) string {
+	opts, name, _ := c.options(key)
+	return opts.Get(name)
+}
+
+// GetAll returns all the values of the given multi-valued key, such as
+// "remote.origin.fetch", in order.
+func (c *Config) GetAll(key string) []string {
+	opts, name, _ := c.options(key)
+	return opts.GetAll(name)
+}
+
+// GetBool returns the value of the given key as a boolean, following the
+// git rules: true, yes, on, any non-zero number and a key without "=" are
+// true, and false, no, off, 0 and an empty value are false. It returns false
+// if the key is not set.
+func (c *Config) GetBool(key string) (bool, error) {
+	opts, name, err := c.options(key)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := optionBool(opts, name)
+	if err != nil {
+		return false, fmt.Errorf("%w: bad boolean value %q for %q", ErrInvalidValue, opts.Get(name), key)
+	}
+
+	return b, nil
+}
+
+// optionBool returns the last value of the key as a boolean, true for a key
+// without value, false if it is not set.
+func optionBool(opts format.Options, key string) (bool, error) {
+	for i := len(opts) - 1; i >= 0; i-- {
+		if !opts[i].IsKey(key) {
+			continue
+		}
+
+		if opts[i].NoValue {
+			return true, nil
+		}
+
+		return parseBool(opts[i].Value)
+	}
+
+	return false, nil
+}
+
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+
+	n, err := parseInt(v)
+	if err != nil {
+		return false, err
+	}
+
+	return n != 0, nil
+}
+
+// GetInt returns the value of the given key as an integer, following the git
+// rules: a decimal number with an optional k, m or g suffix, to multiply it
+// by 1024, 1024² or 1024³. It returns 0 if the key is not set.
+func (c *Config) GetInt(key string) (int64, error) {
+	opts, name, err := c.options(key)
+	if err != nil || !opts.Has(name) {
+		return 0, err
+	}
+
+	v := opts.Get(name)
+
+	n, err := parseInt(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad numeric value %q for %q", ErrInvalidValue, v, key)
+	}
+
+	return n, nil
+}
+
+func parseInt(v string) (int64, error) {
+	if v == "" {
+		return 0, strconv.ErrSyntax
+	}
+
+	var unit int64 = 1
+	switch v[len(v)-1] {
+	case 'k', 'K':
+		unit = 1 << 10
+	case 'm', 'M':
+		unit = 1 << 20
+	case 'g', 'G':
+		unit = 1 << 30
+	}
+
+	if unit != 1 {
+		v = v[:len(v)-1]
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > 0 && n > (1<<63-1)/unit || n < 0 && n < -(1<<63)/unit {
+		return 0, strconv.ErrRange
+	}
+
+	return n * unit, nil
+}
+
+// Set sets the value of the given key, replacing all its values, in the raw
+// content of the config. The new value takes the position of the first one.
+// Only the key is changed in the raw content, and the fields of the config
+// are reloaded after, so they reflect the change.
+func (c *Config) Set(key, value string) error {
+	return c.edit(key, func(s *format.Section, subsection, name string) {
+		if subsection == "" {
+			s.Options = setOption(s.Options, name, value)
+			return
+		}
+
+		ss := s.Subsection(subsection)
+		ss.Options = setOption(ss.Options, name, value)
+	})
+}
+
+// setOption replaces the values of the key with the given one, at the
+// position of the first one.
+func setOption(opts format.Options, key, value string) format.Options {
+	var result format.Options
+	var found bool
+	for _, o := range opts {
+		if !o.IsKey(key) {
+			result = append(result, o)
+			continue
+		}
+
+		if !found {
+			found = true
+			result = append(result, &format.Option{Key: o.Key, Value: value})
+		}
+	}
+
+	if !found {
+		result = append(result, &format.Option{Key: key, Value: value})
+	}
+
+	return result
+}
+
+// Add adds a value to the given multi-valued key, such as
+// "remote.origin.fetch", in the raw content of the config, as Set does.
+func (c *Config) Add(key, value string) error {
+	return c.edit(key, func(s *format.Section, subsection, name string) {
+		if subsection == "" {
+			s.AddOption(name, value)
+			return
+		}
+
+		s.Subsection(subsection).AddOption(name, value)
+	})
+}
+
+// Unset removes all the values of the given key from the raw content of the
+// config, as Set does. A subsection left empty is removed.
+func (c *Config) Unset(key string) error {
+	section, _, _, err := ParseKey(key)
+	if err != nil || c.Raw == nil || !c.Raw.HasSection(section) {
+		return err
+	}
+
+	return c.edit(key, func(s *format.Section, subsection, name string) {
+		if subsection == "" {
+			s.RemoveOption(name)
+			return
+		}
+
+		if !s.HasSubsection(subsection) {
+			return
+		}
+
+		ss := s.Subsection(subsection)
+		ss.RemoveOption(name)
+		if len(ss.Options) == 0 {
+			c.Raw.RemoveSubsection(s.Name, subsection)
+		}
+	})
+}
+
+func (c *Config) edit(key string, f func(s *format.Section, subsection, name string)) error {
+	_, subsection, _, err := ParseKey(key)
+	if err != nil {
+		return err
+	}
+
+	// New sections and options are written as spelled in the key, as git
+	// does, the existing ones being matched case-insensitively.
+	section, name := key[:strings.IndexByte(key, '.')], key[strings.LastIndexByte(key, '.')+1:]
+	if c.Raw == nil {
+		c.Raw = format.New()
+	}
+
+	f(c.Raw.Section(section), subsection, name)
+
+	reloaded := NewConfig()
+	reloaded.Raw = c.Raw
+	if err := reloaded.unmarshal(); err != nil {
+		return err
+	}
+
+	*c = *reloaded
+	return nil
+}
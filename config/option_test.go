@@ -0,0 +1,221 @@
+package config
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+type OptionSuite struct{}
+
+var _ = Suite(&OptionSuite{})
+
+func (s *OptionSuite) TestParseKey(c *C) {
+	for key, expected := range map[string][3]string{
+		"core.autocrlf":                      {"core", "", "autocrlf"},
+		"Core.AutoCRLF":                      {"core", "", "autocrlf"},
+		"remote.origin.url":                  {"remote", "origin", "url"},
+		"remote.Origin.URL":                  {"remote", "Origin", "url"},
+		"url.https://example.com/.insteadOf": {"url", "https://example.com/", "insteadof"},
+		"branch.feature.v1.2.merge":          {"branch", "feature.v1.2", "merge"},
+		"includeIf.gitdir:~/work/.path":      {"includeif", "gitdir:~/work/", "path"},
+		"my-section.my-key":                  {"my-section", "", "my-key"},
+	} {
+		section, subsection, name, err := ParseKey(key)
+		c.Assert(err, IsNil, Commentf("key %s", key))
+		c.Assert([3]string{section, subsection, name}, Equals, expected, Commentf("key %s", key))
+	}
+
+	for _, key := range []string{"core", ".key", "core.", "core.1key", "co re.key", "core.k_ey", "remote.origin."} {
+		_, _, _, err := ParseKey(key)
+		c.Assert(errors.Is(err, ErrInvalidKey), Equals, true, Commentf("key %s", key))
+	}
+}
+
+func (s *OptionSuite) TestGet(c *C) {
+	cfg := NewConfig()
+	err := cfg.Unmarshal([]byte(`[core]
+	autocrlf = input
+	bigFileThreshold = 512m
+	compression = -1
+	filemode = yes
+	symlinks = off
+	ignorecase = 2
+	bare = maybe
+[remote "origin"]
+	url = https://example.com/repo
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[remote "Origin"]
+	url = https://example.com/other
+[branch "release.v1.2"]
+	merge = refs/heads/release.v1.2
+[user]
+	name = first
+	name = last
+`))
+	c.Assert(err, IsNil)
+
+	c.Assert(cfg.GetString("core.autocrlf"), Equals, "input")
+	c.Assert(cfg.GetString("CORE.AutoCRLF"), Equals, "input")
+	c.Assert(cfg.GetString("core.missing"), Equals, "")
+	c.Assert(cfg.GetString("missing.key"), Equals, "")
+	c.Assert(cfg.GetString("invalid"), Equals, "")
+	c.Assert(cfg.GetString("user.name"), Equals, "last")
+	c.Assert(cfg.GetAll("user.name"), DeepEquals, []string{"first", "last"})
+	c.Assert(cfg.GetString("remote.origin.url"), Equals, "https://example.com/repo")
+	c.Assert(cfg.GetString("remote.Origin.url"), Equals, "https://example.com/other")
+	c.Assert(cfg.GetString("remote.ORIGIN.url"), Equals, "")
+	c.Assert(cfg.GetAll("remote.origin.fetch"), DeepEquals, []string{
+		"+refs/heads/*:refs/remotes/origin/*",
+		"+refs/tags/*:refs/tags/*",
+	})
+	c.Assert(cfg.GetString("branch.release.v1.2.merge"), Equals, "refs/heads/release.v1.2")
+
+	for key, expected := range map[string]bool{
+		"core.filemode":   true,
+		"core.symlinks":   false,
+		"core.ignorecase": true,
+		"core.missing":    false,
+	} {
+		v, err := cfg.GetBool(key)
+		c.Assert(err, IsNil, Commentf("key %s", key))
+		c.Assert(v, Equals, expected, Commentf("key %s", key))
+	}
+
+	_, err = cfg.GetBool("core.bare")
+	c.Assert(errors.Is(err, ErrInvalidValue), Equals, true)
+
+	_, err = cfg.GetBool("core")
+	c.Assert(errors.Is(err, ErrInvalidKey), Equals, true)
+
+	for key, expected := range map[string]int64{
+		"core.bigfilethreshold": 512 << 20,
+		"core.compression":      -1,
+		"core.missing":          0,
+	} {
+		v, err := cfg.GetInt(key)
+		c.Assert(err, IsNil, Commentf("key %s", key))
+		c.Assert(v, Equals, expected, Commentf("key %s", key))
+	}
+
+	_, err = cfg.GetInt("core.autocrlf")
+	c.Assert(errors.Is(err, ErrInvalidValue), Equals, true)
+}
+
+func (s *OptionSuite) TestGetBoolWithoutValue(c *C) {
+	input := `[core]
+	bare
+	filemode =
+`
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal([]byte(input))
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.IsBare, Equals, true)
+
+	v, err := cfg.GetBool("core.bare")
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, true)
+
+	v, err = cfg.GetBool("core.filemode")
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, false)
+
+	// The key without value is kept as is when another key is set.
+	c.Assert(cfg.Set("user.email", "foo@example.com"), IsNil)
+	output, err := cfg.MarshalPreserving([]byte(input))
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, `[core]
+	bare
+	filemode =
+[user]
+	email = foo@example.com
+`)
+}
+
+func (s *OptionSuite) TestParseInt(c *C) {
+	for v, expected := range map[string]int64{
+		"0": 0, "42": 42, "-3": -3, "1k": 1024, "2M": 2 << 20, "3g": 3 << 30, "-1k": -1024,
+	} {
+		n, err := parseInt(v)
+		c.Assert(err, IsNil, Commentf("value %s", v))
+		c.Assert(n, Equals, expected, Commentf("value %s", v))
+	}
+
+	for _, v := range []string{"", "k", "1t", "1.5", "0x10", "99999999999g"} {
+		_, err := parseInt(v)
+		c.Assert(err, NotNil, Commentf("value %s", v))
+	}
+}
+
+func (s *OptionSuite) TestSet(c *C) {
+	input := `# settings
+[core]
+	bare = false ; not bare
+	autocrlf = true
+[remote "origin"]
+	url = https://example.com/repo
+	fetch = +refs/heads/*:refs/remotes/origin/*
+`
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal([]byte(input))
+	c.Assert(err, IsNil)
+
+	// Only the raw content is edited, the changes of the fields not marshaled
+	// are discarded.
+	cfg.User.Name = "from field"
+	c.Assert(cfg.Set("core.autocrlf", "input"), IsNil)
+	c.Assert(cfg.Set("Remote.origin.URL", "https://example.com/moved"), IsNil)
+	c.Assert(cfg.Add("remote.origin.fetch", "+refs/tags/*:refs/tags/*"), IsNil)
+	c.Assert(cfg.Set("branch.release.v1.merge", "refs/heads/release.v1"), IsNil)
+
+	c.Assert(cfg.User.Name, Equals, "")
+	c.Assert(cfg.Remotes["origin"].URLs, DeepEquals, []string{"https://example.com/moved"})
+	c.Assert(cfg.Remotes["origin"].Fetch, HasLen, 2)
+	c.Assert(cfg.Branches["release.v1"].Merge.String(), Equals, "refs/heads/release.v1")
+
+	output, err := cfg.MarshalPreserving([]byte(input))
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, `# settings
+[core]
+	bare = false ; not bare
+	autocrlf = input
+[remote "origin"]
+	url = https://example.com/moved
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[branch "release.v1"]
+	merge = refs/heads/release.v1
+`)
+
+	c.Assert(cfg.Set("core", "value"), NotNil)
+}
+
+func (s *OptionSuite) TestUnset(c *C) {
+	input := `[core]
+	autocrlf = true ; line endings
+	# keep me
+	filemode = false
+[remote "origin"]
+	url = https://example.com/repo
+`
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal([]byte(input))
+	c.Assert(err, IsNil)
+
+	c.Assert(cfg.Unset("core.autocrlf"), IsNil)
+	c.Assert(cfg.Unset("remote.origin.url"), IsNil)
+	c.Assert(cfg.Unset("missing.key"), IsNil)
+	c.Assert(cfg.Remotes, HasLen, 0)
+
+	output, err := cfg.MarshalPreserving([]byte(input))
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, `[core]
+	# keep me
+	filemode = false
+	bare = false
+`)
+}
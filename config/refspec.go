@@ -11,11 +11,13 @@ const (
 	refSpecWildcard  = "*"
 	refSpecForce     = "+"
 	refSpecSeparator = ":"
+	refSpecNegative  = "^"
 )
 
 var (
 	ErrRefSpecMalformedSeparator = errors.New("malformed refspec, separators are wrong")
 	ErrRefSpecMalformedWildcard  = errors.New("malformed refspec, mismatched number of wildcards")
+	ErrRefSpecMalformedNegative  = errors.New("malformed refspec, negative refspecs must be a single pattern")
 )
 
 // RefSpec is a mapping from local branches to remote references.
@@ -25,12 +27,30 @@ var (
 // reference even if it isn’t a fast-forward.
 // eg.: "+refs/heads/*:refs/remotes/origin/*"
 //
+// A refspec prefixed by ^ is a negative one, made only of a <src> pattern,
+// excluding the references matching it from the ones matched by the other
+// refspecs. eg.: "^refs/heads/tmp/*"
+//
 // https://git-scm.com/book/en/v2/Git-Internals-The-Refspec
 type RefSpec string
 
 // Validate validates the RefSpec
 func (s RefSpec) Validate() error {
 	spec := string(s)
+	if s.IsNegative() {
+		pattern := spec[1:]
+		if pattern == "" || strings.Contains(pattern, refSpecSeparator) ||
+			strings.HasPrefix(pattern, refSpecForce) || plumbing.IsHash(pattern) {
+			return ErrRefSpecMalformedNegative
+		}
+
+		if strings.Count(pattern, refSpecWildcard) > 1 {
+			return ErrRefSpecMalformedWildcard
+		}
+
+		return nil
+	}
+
 	if strings.Count(spec, refSpecSeparator) != 1 {
 		return ErrRefSpecMalformedSeparator
 	}
@@ -54,6 +74,12 @@ func (s RefSpec) IsForceUpdate() bool {
 	return s[0] == refSpecForce[0]
 }
 
+// IsNegative returns true if the refspec excludes the references matching
+// its pattern.
+func (s RefSpec) IsNegative() bool {
+	return len(s) != 0 && s[0] == refSpecNegative[0]
+}
+
 // IsDelete returns true if the refspec indicates a delete (empty src).
 func (s RefSpec) IsDelete() bool {
 	return s[0] == refSpecSeparator[0]
@@ -61,12 +87,15 @@ func (s RefSpec) IsDelete() bool {
 
 // IsExactSHA1 returns true if the source is a SHA1 hash.
 func (s RefSpec) IsExactSHA1() bool {
-	return plumbing.IsHash(s.Src())
+	return !s.IsNegative() && plumbing.IsHash(s.Src())
 }
 
-// Src returns the src side.
+// Src returns the src side, the pattern for a negative refspec.
 func (s RefSpec) Src() string {
 	spec := string(s)
+	if s.IsNegative() {
+		return spec[1:]
+	}
 
 	var start int
 	if s.IsForceUpdate() {
@@ -79,8 +108,23 @@ func (s RefSpec) Src() string {
 	return spec[start:end]
 }
 
-// Match match the given plumbing.ReferenceName against the source.
+// Match match the given plumbing.ReferenceName against the source. A negative
+// refspec never matches, see Excludes.
 func (s RefSpec) Match(n plumbing.ReferenceName) bool {
+	if s.IsNegative() {
+		return false
+	}
+
+	return s.matchSrc(n)
+}
+
+// Excludes returns true if the refspec is a negative one and its pattern
+// matches the given plumbing.ReferenceName.
+func (s RefSpec) Excludes(n plumbing.ReferenceName) bool {
+	return s.IsNegative() && s.matchSrc(n)
+}
+
+func (s RefSpec) matchSrc(n plumbing.ReferenceName) bool {
 	if !s.IsWildcard() {
 		return s.matchExact(n)
 	}
@@ -113,8 +157,13 @@ func (s RefSpec) matchGlob(n plumbing.ReferenceName) bool {
 		strings.HasSuffix(name, suffix)
 }
 
-// Dst returns the destination for the given remote reference.
+// Dst returns the destination for the given remote reference, empty for a
+// negative refspec.
 func (s RefSpec) Dst(n plumbing.ReferenceName) plumbing.ReferenceName {
+	if s.IsNegative() {
+		return ""
+	}
+
 	spec := string(s)
 	start := strings.Index(spec, refSpecSeparator) + 1
 	dst := spec[start:]
@@ -132,19 +181,35 @@ func (s RefSpec) Dst(n plumbing.ReferenceName) plumbing.ReferenceName {
 	return plumbing.ReferenceName(dst[0:wd] + match + dst[wd+1:])
 }
 
+// Reverse returns the refspec with the src and dst sides swapped. A negative
+// refspec is returned as is.
 func (s RefSpec) Reverse() RefSpec {
+	if s.IsNegative() {
+		return s
+	}
+
+	var force string
+	if s.IsForceUpdate() {
+		force = refSpecForce
+	}
+
 	spec := string(s)
 	separator := strings.Index(spec, refSpecSeparator)
 
-	return RefSpec(spec[separator+1:] + refSpecSeparator + spec[:separator])
+	return RefSpec(force + spec[separator+1:] + refSpecSeparator + s.Src())
 }
 
 func (s RefSpec) String() string {
 	return string(s)
 }
 
-// MatchAny returns true if any of the RefSpec match with the given ReferenceName.
+// MatchAny returns true if any of the RefSpec match with the given
+// ReferenceName, and none of the negative ones excludes it.
 func MatchAny(l []RefSpec, n plumbing.ReferenceName) bool {
+	if Excluded(l, n) {
+		return false
+	}
+
 	for _, r := range l {
 		if r.Match(n) {
 			return true
@@ -153,3 +218,15 @@ func MatchAny(l []RefSpec, n plumbing.ReferenceName) bool {
 
 	return false
 }
+
+// Excluded returns true if any of the negative RefSpec excludes the given
+// ReferenceName.
+func Excluded(l []RefSpec, n plumbing.ReferenceName) bool {
+	for _, r := range l {
+		if r.Excludes(n) {
+			return true
+		}
+	}
+
+	return false
+}
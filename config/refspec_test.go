@@ -188,6 +188,12 @@ func (s *RefSpecSuite) TestRefSpecReverse(c *C) {
 		spec.Reverse(), Equals,
 		RefSpec("refs/remotes/origin/*:refs/heads/*"),
 	)
+
+	spec = RefSpec("+refs/heads/*:refs/remotes/origin/*")
+	c.Assert(
+		spec.Reverse(), Equals,
+		RefSpec("+refs/remotes/origin/*:refs/heads/*"),
+	)
 }
 
 func (s *RefSpecSuite) TestMatchAny(c *C) {
@@ -200,3 +206,54 @@ func (s *RefSpecSuite) TestMatchAny(c *C) {
 	c.Assert(MatchAny(specs, plumbing.ReferenceName("refs/heads/bar")), Equals, true)
 	c.Assert(MatchAny(specs, plumbing.ReferenceName("refs/heads/master")), Equals, false)
 }
+
+func (s *RefSpecSuite) TestRefSpecNegativeIsValid(c *C) {
+	spec := RefSpec("^refs/heads/tmp/*")
+	c.Assert(spec.Validate(), Equals, nil)
+
+	spec = RefSpec("^refs/pull/*/head")
+	c.Assert(spec.Validate(), Equals, nil)
+
+	spec = RefSpec("^refs/heads/master")
+	c.Assert(spec.Validate(), Equals, nil)
+
+	spec = RefSpec("^")
+	c.Assert(spec.Validate(), Equals, ErrRefSpecMalformedNegative)
+
+	spec = RefSpec("^refs/heads/*:refs/remotes/origin/*")
+	c.Assert(spec.Validate(), Equals, ErrRefSpecMalformedNegative)
+
+	spec = RefSpec("^12039e008f9a4e3394f3f94f8ea897785cb09448")
+	c.Assert(spec.Validate(), Equals, ErrRefSpecMalformedNegative)
+
+	spec = RefSpec("^refs/*/tmp/*")
+	c.Assert(spec.Validate(), Equals, ErrRefSpecMalformedWildcard)
+}
+
+func (s *RefSpecSuite) TestRefSpecNegative(c *C) {
+	spec := RefSpec("^refs/heads/tmp/*")
+	c.Assert(spec.IsNegative(), Equals, true)
+	c.Assert(spec.IsForceUpdate(), Equals, false)
+	c.Assert(spec.IsDelete(), Equals, false)
+	c.Assert(spec.Src(), Equals, "refs/heads/tmp/*")
+	c.Assert(spec.Reverse(), Equals, spec)
+	c.Assert(spec.Match(plumbing.ReferenceName("refs/heads/tmp/foo")), Equals, false)
+	c.Assert(spec.Excludes(plumbing.ReferenceName("refs/heads/tmp/foo")), Equals, true)
+	c.Assert(spec.Excludes(plumbing.ReferenceName("refs/heads/master")), Equals, false)
+
+	spec = RefSpec("+refs/heads/*:refs/remotes/origin/*")
+	c.Assert(spec.IsNegative(), Equals, false)
+	c.Assert(spec.Excludes(plumbing.ReferenceName("refs/heads/tmp/foo")), Equals, false)
+}
+
+func (s *RefSpecSuite) TestMatchAnyNegative(c *C) {
+	specs := []RefSpec{
+		"+refs/heads/*:refs/remotes/origin/*",
+		"^refs/heads/tmp/*",
+	}
+
+	c.Assert(MatchAny(specs, plumbing.ReferenceName("refs/heads/master")), Equals, true)
+	c.Assert(MatchAny(specs, plumbing.ReferenceName("refs/heads/tmp/foo")), Equals, false)
+	c.Assert(Excluded(specs, plumbing.ReferenceName("refs/heads/tmp/foo")), Equals, true)
+	c.Assert(Excluded(specs, plumbing.ReferenceName("refs/heads/master")), Equals, false)
+}
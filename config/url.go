@@ -18,6 +18,9 @@ type URL struct {
 	// Any URL that starts with this value will be rewritten to start, instead, with <base>.
 	// When more than one insteadOf strings match a given URL, the longest match is used.
 	InsteadOf string
+	// PushInsteadOf is same as InsteadOf, but only applies to the URLs used
+	// to push. It takes precedence over InsteadOf when both match.
+	PushInsteadOf string
 
 	// raw representation of the subsection, filled by marshal or unmarshal are
 	// called.
@@ -26,7 +29,7 @@ type URL struct {
 
 // Validate validates fields of branch
 func (b *URL) Validate() error {
-	if b.InsteadOf == "" {
+	if b.InsteadOf == "" && b.PushInsteadOf == "" {
 		return errURLEmptyInsteadOf
 	}
 
@@ -34,7 +37,8 @@ func (b *URL) Validate() error {
 }
 
 const (
-	insteadOfKey = "insteadOf"
+	insteadOfKey     = "insteadOf"
+	pushInsteadOfKey = "pushInsteadOf"
 )
 
 func (u *URL) unmarshal(s *format.Subsection) error {
@@ -42,6 +46,7 @@ func (u *URL) unmarshal(s *format.Subsection) error {
 
 	u.Name = s.Name
 	u.InsteadOf = u.raw.Option(insteadOfKey)
+	u.PushInsteadOf = u.raw.Option(pushInsteadOfKey)
 	return nil
 }
 
@@ -51,20 +56,32 @@ func (u *URL) marshal() *format.Subsection {
 	}
 
 	u.raw.Name = u.Name
-	u.raw.SetOption(insteadOfKey, u.InsteadOf)
+	setOptionIfNotEmpty(u.raw, insteadOfKey, u.InsteadOf)
+	setOptionIfNotEmpty(u.raw, pushInsteadOfKey, u.PushInsteadOf)
 
 	return u.raw
 }
 
-func findLongestInsteadOfMatch(remoteURL string, urls map[string]*URL) *URL {
+func setOptionIfNotEmpty(s *format.Subsection, key, value string) {
+	if value == "" {
+		s.RemoveOption(key)
+	} else {
+		s.SetOption(key, value)
+	}
+}
+
+// findLongestMatch returns the rule whose prefix, returned by the given
+// function, is the longest one matching remoteURL.
+func findLongestMatch(remoteURL string, urls map[string]*URL, prefix func(*URL) string) *URL {
 	var longestMatch *URL
 	for _, u := range urls {
-		if !strings.HasPrefix(remoteURL, u.InsteadOf) {
+		p := prefix(u)
+		if p == "" || !strings.HasPrefix(remoteURL, p) {
 			continue
 		}
 
 		// according to spec if there is more than one match, take the logest
-		if longestMatch == nil || len(longestMatch.InsteadOf) < len(u.InsteadOf) {
+		if longestMatch == nil || len(prefix(longestMatch)) < len(p) {
 			longestMatch = u
 		}
 	}
@@ -72,10 +89,48 @@ func findLongestInsteadOfMatch(remoteURL string, urls map[string]*URL) *URL {
 	return longestMatch
 }
 
+// ApplyInsteadOf returns the given url rewritten to start with the base url,
+// if it starts with InsteadOf.
 func (u *URL) ApplyInsteadOf(url string) string {
-	if !strings.HasPrefix(url, u.InsteadOf) {
+	return u.apply(url, u.InsteadOf)
+}
+
+// ApplyPushInsteadOf returns the given url rewritten to start with the base
+// url, if it starts with PushInsteadOf.
+func (u *URL) ApplyPushInsteadOf(url string) string {
+	return u.apply(url, u.PushInsteadOf)
+}
+
+func (u *URL) apply(url, prefix string) string {
+	if prefix == "" || !strings.HasPrefix(url, prefix) {
 		return url
 	}
 
-	return u.Name + url[len(u.InsteadOf):]
+	return u.Name + url[len(prefix):]
+}
+
+// ApplyInsteadOf returns the given url rewritten by the url.<base>.insteadOf
+// rule with the longest matching prefix, or as is if none matches. It is
+// the url used to fetch from a remote.
+func (c *Config) ApplyInsteadOf(url string) string {
+	if u := findLongestMatch(url, c.URLs, insteadOf); u != nil {
+		return u.ApplyInsteadOf(url)
+	}
+
+	return url
+}
+
+// ApplyPushInsteadOf returns the given url rewritten by the
+// url.<base>.pushInsteadOf rule with the longest matching prefix, falling
+// back to the insteadOf rules if none matches. It is the url used to push to
+// a remote.
+func (c *Config) ApplyPushInsteadOf(url string) string {
+	if u := findLongestMatch(url, c.URLs, pushInsteadOf); u != nil {
+		return u.ApplyPushInsteadOf(url)
+	}
+
+	return c.ApplyInsteadOf(url)
 }
+
+func insteadOf(u *URL) string     { return u.InsteadOf }
+func pushInsteadOf(u *URL) string { return u.PushInsteadOf }
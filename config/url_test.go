@@ -60,3 +60,66 @@ func (b *URLSuite) TestApplyInsteadOf(c *C) {
 	c.Assert(urlRule.ApplyInsteadOf("http://google.com"), Equals, "http://google.com")
 	c.Assert(urlRule.ApplyInsteadOf("http://github.com/myrepo"), Equals, "ssh://github.com/myrepo")
 }
+
+func (b *URLSuite) TestValidatePushInsteadOf(c *C) {
+	url := URL{
+		Name:          "ssh://github.com",
+		PushInsteadOf: "http://github.com",
+	}
+	c.Assert(url.Validate(), IsNil)
+}
+
+func (b *URLSuite) TestMarshalUnmarshalPushInsteadOf(c *C) {
+	input := []byte(`[core]
+	bare = false
+[url "ssh://git@github.com/"]
+	pushInsteadOf = https://github.com/
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	url := cfg.URLs["ssh://git@github.com/"]
+	c.Assert(url.InsteadOf, Equals, "")
+	c.Assert(url.PushInsteadOf, Equals, "https://github.com/")
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+}
+
+func (b *URLSuite) TestConfigApplyInsteadOf(c *C) {
+	cfg := NewConfig()
+	cfg.URLs["ssh://git@github.com/"] = &URL{
+		Name:      "ssh://git@github.com/",
+		InsteadOf: "https://github.com/",
+	}
+	cfg.URLs["ssh://git@example.com/go-git/"] = &URL{
+		Name:      "ssh://git@example.com/go-git/",
+		InsteadOf: "https://github.com/go-git/",
+	}
+	cfg.URLs["git@github.com:"] = &URL{
+		Name:          "git@github.com:",
+		PushInsteadOf: "https://github.com/",
+	}
+
+	c.Assert(cfg.ApplyInsteadOf("https://github.com/src-d/go-git.git"), Equals, "ssh://git@github.com/src-d/go-git.git")
+	c.Assert(cfg.ApplyInsteadOf("https://github.com/go-git/go-git.git"), Equals, "ssh://git@example.com/go-git/go-git.git")
+	c.Assert(cfg.ApplyInsteadOf("https://gitlab.com/go-git/go-git.git"), Equals, "https://gitlab.com/go-git/go-git.git")
+
+	c.Assert(cfg.ApplyPushInsteadOf("https://github.com/go-git/go-git.git"), Equals, "git@github.com:go-git/go-git.git")
+	c.Assert(cfg.ApplyPushInsteadOf("https://gitlab.com/go-git/go-git.git"), Equals, "https://gitlab.com/go-git/go-git.git")
+
+	delete(cfg.URLs, "git@github.com:")
+	c.Assert(cfg.ApplyPushInsteadOf("https://github.com/go-git/go-git.git"), Equals, "ssh://git@example.com/go-git/go-git.git")
+}
+
+func (b *URLSuite) TestApplyPushInsteadOf(c *C) {
+	urlRule := URL{
+		Name:          "ssh://github.com",
+		PushInsteadOf: "http://github.com",
+	}
+
+	c.Assert(urlRule.ApplyInsteadOf("http://github.com/myrepo"), Equals, "http://github.com/myrepo")
+	c.Assert(urlRule.ApplyPushInsteadOf("http://github.com/myrepo"), Equals, "ssh://github.com/myrepo")
+}
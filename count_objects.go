@@ -0,0 +1,281 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// ObjectCount is the number and disk usage of the objects of a repository,
+// as given by `git count-objects -v`. The sizes are in bytes.
+type ObjectCount struct {
+	// Count is the number of loose objects.
+	Count int
+	// Size is the size of the loose objects.
+	Size int64
+	// InPack is the number of objects in the packfiles.
+	InPack int
+	// Packs is the number of packfiles.
+	Packs int
+	// SizePack is the size of the packfiles and their indexes.
+	SizePack int64
+	// PrunePackable is the number of loose objects which are also in a
+	// packfile, removed when the repository is repacked.
+	PrunePackable int
+	// Garbage is the number of files of the objects directory which are
+	// neither objects, nor packfiles and their companion files.
+	Garbage int
+	// SizeGarbage is the size of the garbage files.
+	SizeGarbage int64
+	// Packfiles are the packfiles counted.
+	Packfiles []PackfileCount
+}
+
+// PackfileCount is the number of objects and the size of a packfile.
+type PackfileCount struct {
+	// Hash is the hash of the packfile, as in its name.
+	Hash plumbing.Hash
+	// Objects is the number of objects of the packfile.
+	Objects int
+	// Size is the size of the packfile.
+	Size int64
+	// IndexSize is the size of the index of the packfile.
+	IndexSize int64
+}
+
+// packCompanionExts are the extensions of the files which may accompany a
+// packfile, and are not garbage if the packfile exists.
+var packCompanionExts = []string{".idx", ".keep", ".bitmap", ".rev", ".mtimes", ".promisor"}
+
+// CountObjects counts the objects of the repository and their disk usage,
+// as `git count-objects -v` does. The number of objects of the packfiles is
+// read from the header of their index, so the packfiles are not read.
+//
+// For the storages which are not a filesystem, all the objects are counted
+// as loose objects, their size being their uncompressed size.
+func (r *Repository) CountObjects() (*ObjectCount, error) {
+	fs, ok := storageFilesystem(r.Storer)
+	if !ok {
+		return r.countStoredObjects()
+	}
+
+	count := &ObjectCount{}
+	if err := countPackfiles(fs, count); err != nil {
+		return nil, err
+	}
+
+	if err := countLooseObjects(fs, count); err != nil {
+		return nil, err
+	}
+
+	return count, nil
+}
+
+func (r *Repository) countStoredObjects() (*ObjectCount, error) {
+	iter, err := r.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return nil, err
+	}
+
+	count := &ObjectCount{}
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		count.Count++
+		count.Size += obj.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return count, nil
+}
+
+// countPackfiles counts the packfiles with an index, and the garbage files
+// of the pack directory.
+func countPackfiles(fs billy.Filesystem, count *ObjectCount) error {
+	files, err := fs.ReadDir(packDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	sizes := make(map[string]int64, len(files))
+	for _, f := range files {
+		if !f.IsDir() {
+			sizes[f.Name()] = f.Size()
+		}
+	}
+
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, "multi-pack-index") {
+			continue
+		}
+
+		base, ext, garbage := packFileName(name)
+		if ext == ".pack" {
+			idxSize, ok := sizes[base+".idx"]
+			h := plumbing.NewHash(strings.TrimPrefix(base, "pack-"))
+			if !ok || garbage {
+				count.Garbage++
+				count.SizeGarbage += f.Size()
+				continue
+			}
+
+			objects, err := packIndexObjectCount(fs, path.Join(packDir, base+".idx"))
+			if err != nil {
+				return err
+			}
+
+			count.Packs++
+			count.InPack += objects
+			count.SizePack += f.Size() + idxSize
+			count.Packfiles = append(count.Packfiles, PackfileCount{
+				Hash:      h,
+				Objects:   objects,
+				Size:      f.Size(),
+				IndexSize: idxSize,
+			})
+
+			continue
+		}
+
+		if _, ok := sizes[base+".pack"]; garbage || !ok {
+			count.Garbage++
+			count.SizeGarbage += f.Size()
+		}
+	}
+
+	return nil
+}
+
+// packFileName splits the name of a file of the pack directory into its base,
+// such as "pack-<hash>", and its extension, telling whether it is garbage.
+func packFileName(name string) (base, ext string, garbage bool) {
+	ext = path.Ext(name)
+	base = strings.TrimSuffix(name, ext)
+	h := strings.TrimPrefix(base, "pack-")
+	if h == base || len(h) != hash.HexSize || !plumbing.IsHash(h) {
+		return base, ext, true
+	}
+
+	if ext == ".pack" {
+		return base, ext, false
+	}
+
+	for _, companion := range packCompanionExts {
+		if ext == companion {
+			return base, ext, false
+		}
+	}
+
+	return base, ext, true
+}
+
+// packIndexObjectCount returns the number of objects of a packfile index,
+// the last entry of its fanout table.
+func packIndexObjectCount(fs billy.Filesystem, name string) (n int, err error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return 0, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	// Version 1 indexes start with the fanout table, the others with a
+	// header and the version.
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0, err
+	}
+
+	offset := int64(255 * 4)
+	if bytes.Equal(header[:4], []byte{255, 't', 'O', 'c'}) {
+		offset += 8
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var objects uint32
+	if err := binary.Read(f, binary.BigEndian, &objects); err != nil {
+		return 0, err
+	}
+
+	return int(objects), nil
+}
+
+// countLooseObjects counts the loose objects, and the garbage files of the
+// loose object directories.
+func countLooseObjects(fs billy.Filesystem, count *ObjectCount) error {
+	var indexes []*idxfile.MemoryIndex
+	for i := 0; i < 256; i++ {
+		dir := path.Join("objects", fmt.Sprintf("%02x", i))
+		files, err := fs.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			name := f.Name()
+			if f.IsDir() || len(name) != hash.HexSize-2 || !plumbing.IsHash(dir[len(dir)-2:]+name) {
+				count.Garbage++
+				count.SizeGarbage += f.Size()
+				continue
+			}
+
+			count.Count++
+			count.Size += f.Size()
+
+			// The indexes are only read if there are loose objects.
+			if indexes == nil {
+				if indexes, err = readPackIndexes(fs, count.Packfiles); err != nil {
+					return err
+				}
+			}
+
+			h := plumbing.NewHash(dir[len(dir)-2:] + name)
+			for _, idx := range indexes {
+				if ok, _ := idx.Contains(h); ok {
+					count.PrunePackable++
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func readPackIndexes(fs billy.Filesystem, packs []PackfileCount) ([]*idxfile.MemoryIndex, error) {
+	indexes := make([]*idxfile.MemoryIndex, 0, len(packs))
+	for _, p := range packs {
+		idx, err := readPackIndex(fs, p.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		indexes = append(indexes, idx)
+	}
+
+	return indexes, nil
+}
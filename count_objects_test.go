@@ -0,0 +1,127 @@
+package git
+
+import (
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type CountObjectsSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&CountObjectsSuite{})
+
+func (s *CountObjectsSuite) TestCountObjects(c *C) {
+	f := fixtures.Basic().One()
+	fs := f.DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	count, err := r.CountObjects()
+	c.Assert(err, IsNil)
+	c.Assert(count.Count, Equals, 0)
+	c.Assert(count.InPack, Equals, 31)
+	c.Assert(count.Packs, Equals, 1)
+	c.Assert(count.Garbage, Equals, 0)
+	c.Assert(count.Packfiles, HasLen, 1)
+	c.Assert(count.Packfiles[0].Hash, Equals, plumbing.NewHash(f.PackfileHash))
+	c.Assert(count.Packfiles[0].Objects, Equals, 31)
+	c.Assert(count.SizePack, Equals, count.Packfiles[0].Size+count.Packfiles[0].IndexSize)
+
+	// A packed object written as a loose object, and a new one.
+	obj, err := r.Storer.EncodedObject(plumbing.AnyObject, plumbing.NewHash("d3ff53e0564a9f87d8e84b6e28e5060e517008aa"))
+	c.Assert(err, IsNil)
+	_, err = r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	blob := r.Storer.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	_, err = r.Storer.SetEncodedObject(blob)
+	c.Assert(err, IsNil)
+
+	for _, name := range []string{
+		path.Join("objects", "ab", "foo"),
+		path.Join("objects", "pack", "pack-foo.pack"),
+		path.Join("objects", "pack", "pack-"+strings.Repeat("1", 40)+".idx"),
+		path.Join("objects", "pack", "pack-"+f.PackfileHash+".keep"),
+	} {
+		c.Assert(util.WriteFile(fs, name, []byte("garbage"), 0o644), IsNil)
+	}
+
+	count, err = r.CountObjects()
+	c.Assert(err, IsNil)
+	c.Assert(count.Count, Equals, 2)
+	c.Assert(count.PrunePackable, Equals, 1)
+	c.Assert(count.InPack, Equals, 31)
+	c.Assert(count.Garbage, Equals, 3)
+	c.Assert(count.SizeGarbage, Equals, int64(3*len("garbage")))
+
+	if _, err := exec.LookPath("git"); err != nil {
+		return
+	}
+
+	out, err := exec.Command("git", "--git-dir", fs.Root(), "count-objects", "-v").Output()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	expected := make(map[string]int64)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		key, value, ok := strings.Cut(line, ": ")
+		c.Assert(ok, Equals, true, Commentf("%s", out))
+		expected[key], err = strconv.ParseInt(value, 10, 64)
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(map[string]int64{
+		"count":          int64(count.Count),
+		"in-pack":        int64(count.InPack),
+		"packs":          int64(count.Packs),
+		"size-pack":      count.SizePack / 1024,
+		"prune-packable": int64(count.PrunePackable),
+		"garbage":        int64(count.Garbage),
+	}, DeepEquals, map[string]int64{
+		"count":          expected["count"],
+		"in-pack":        expected["in-pack"],
+		"packs":          expected["packs"],
+		"size-pack":      expected["size-pack"],
+		"prune-packable": expected["prune-packable"],
+		"garbage":        expected["garbage"],
+	})
+}
+
+func (s *CountObjectsSuite) TestCountObjectsMemory(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	for _, content := range []string{"foo", "bar"} {
+		blob := r.Storer.NewEncodedObject()
+		blob.SetType(plumbing.BlobObject)
+		w, err := blob.Writer()
+		c.Assert(err, IsNil)
+		_, err = w.Write([]byte(content))
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+		_, err = r.Storer.SetEncodedObject(blob)
+		c.Assert(err, IsNil)
+	}
+
+	count, err := r.CountObjects()
+	c.Assert(err, IsNil)
+	c.Assert(count, DeepEquals, &ObjectCount{Count: 2, Size: 6})
+}
@@ -0,0 +1,387 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/fastimport"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// FastExport writes the history of the references to w as a fast-import
+// stream, as `git fast-export` does, so it can be imported by FastImport,
+// `git fast-import` or the importers of other version control systems.
+//
+// The commits are written with their parents first, each one with the blobs
+// of the files it changes from its first parent. Imported by git, the
+// objects keep their hashes, but for the signed commits and the merges of
+// signed tags, whose signatures are left out, and their descendants.
+func (r *Repository) FastExport(w io.Writer, o *FastExportOptions) error {
+	e := &fastExport{r: r, o: o, enc: fastimport.NewEncoder(w), marks: o.Marks}
+	if e.marks == nil {
+		e.marks = fastimport.NewMarks()
+	}
+
+	refs, err := e.references()
+	if err != nil {
+		return err
+	}
+
+	// The tips are read first, so the tags of the blobs are written after
+	// the blobs.
+	var tips []*plumbing.Reference
+	tags := make(map[plumbing.ReferenceName][]*object.Tag)
+	for _, ref := range refs {
+		tip, chain, err := e.peel(ref.Hash())
+		if err != nil {
+			return err
+		}
+
+		tags[ref.Name()] = chain
+		tips = append(tips, plumbing.NewHashReference(ref.Name(), tip))
+	}
+
+	for _, tip := range tips {
+		if err := e.exportHistory(tip); err != nil {
+			return err
+		}
+	}
+
+	for i, tip := range tips {
+		if err := e.exportReference(refs[i], tip.Hash(), tags[tip.Name()]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type fastExport struct {
+	r     *Repository
+	o     *FastExportOptions
+	enc   *fastimport.Encoder
+	marks *fastimport.Marks
+}
+
+// references returns the references to export, the symbolic ones being
+// resolved, as git does.
+func (e *fastExport) references() ([]*plumbing.Reference, error) {
+	names := e.o.Refs
+	if len(names) == 0 {
+		iter, err := e.r.References()
+		if err != nil {
+			return nil, err
+		}
+
+		err = iter.ForEach(func(ref *plumbing.Reference) error {
+			if ref.Type() == plumbing.HashReference {
+				names = append(names, ref.Name())
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	}
+
+	seen := make(map[plumbing.ReferenceName]bool, len(names))
+	refs := make([]*plumbing.Reference, 0, len(names))
+	for _, name := range names {
+		ref, err := e.r.Storer.Reference(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if ref.Type() == plumbing.SymbolicReference {
+			if ref, err = e.r.Reference(ref.Target(), true); err != nil {
+				return nil, err
+			}
+		}
+
+		if seen[ref.Name()] {
+			continue
+		}
+
+		seen[ref.Name()] = true
+		refs = append(refs, ref)
+	}
+
+	return refs, nil
+}
+
+// peel returns the object an object points to, through the tags, and these
+// tags, the outermost one first.
+func (e *fastExport) peel(h plumbing.Hash) (plumbing.Hash, []*object.Tag, error) {
+	var chain []*object.Tag
+	for {
+		obj, err := e.r.Storer.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return h, nil, err
+		}
+
+		if obj.Type() != plumbing.TagObject {
+			if obj.Type() == plumbing.TreeObject {
+				return h, nil, fmt.Errorf("cannot export tree %s, only commits and blobs can be tagged", h)
+			}
+
+			return h, chain, nil
+		}
+
+		tag, err := object.DecodeTag(e.r.Storer, obj)
+		if err != nil {
+			return h, nil, err
+		}
+
+		chain = append(chain, tag)
+		h = tag.Target
+	}
+}
+
+// exportHistory writes the commits reachable from the reference not exported
+// yet, with their parents first.
+func (e *fastExport) exportHistory(ref *plumbing.Reference) error {
+	obj, err := e.r.Storer.EncodedObject(plumbing.AnyObject, ref.Hash())
+	if err != nil {
+		return err
+	}
+
+	if obj.Type() == plumbing.BlobObject {
+		_, err := e.exportBlob(ref.Hash())
+		return err
+	}
+
+	if _, ok := e.marks.Mark(ref.Hash()); ok {
+		return nil
+	}
+
+	commit, err := object.DecodeCommit(e.r.Storer, obj)
+	if err != nil {
+		return err
+	}
+
+	type frame struct {
+		commit *object.Commit
+		parent int
+	}
+
+	seen := map[plumbing.Hash]bool{commit.Hash: true}
+	stack := []*frame{{commit: commit}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		if f.parent == len(f.commit.ParentHashes) {
+			stack = stack[:len(stack)-1]
+			if err := e.exportCommit(ref.Name(), f.commit); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		p := f.commit.ParentHashes[f.parent]
+		f.parent++
+		if _, ok := e.marks.Mark(p); ok || seen[p] {
+			continue
+		}
+
+		seen[p] = true
+		parent, err := e.r.CommitObject(p)
+		if err != nil {
+			return err
+		}
+
+		stack = append(stack, &frame{commit: parent})
+	}
+
+	return nil
+}
+
+func (e *fastExport) exportBlob(h plumbing.Hash) (int, error) {
+	if mark, ok := e.marks.Mark(h); ok {
+		return mark, nil
+	}
+
+	blob, err := e.r.BlobObject(h)
+	if err != nil {
+		return 0, err
+	}
+
+	rd, err := blob.Reader()
+	if err != nil {
+		return 0, err
+	}
+
+	defer rd.Close()
+
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return 0, err
+	}
+
+	mark := e.marks.Next()
+	e.marks.Set(mark, h)
+	return mark, e.enc.Encode(&fastimport.Blob{
+		Mark:        mark,
+		OriginalOID: e.originalOID(h),
+		Data:        data,
+	})
+}
+
+func (e *fastExport) exportCommit(ref plumbing.ReferenceName, c *object.Commit) error {
+	var parent *object.Tree
+	if len(c.ParentHashes) != 0 {
+		p, err := e.r.CommitObject(c.ParentHashes[0])
+		if err != nil {
+			return err
+		}
+
+		if parent, err = p.Tree(); err != nil {
+			return err
+		}
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	changes, err := object.DiffTree(parent, tree)
+	if err != nil {
+		return err
+	}
+
+	ops, err := e.fileOps(changes)
+	if err != nil {
+		return err
+	}
+
+	author := c.Author
+	commit := &fastimport.Commit{
+		Ref:         ref.String(),
+		OriginalOID: e.originalOID(c.Hash),
+		Author:      &author,
+		Committer:   c.Committer,
+		Message:     c.Message,
+		FileOps:     ops,
+	}
+
+	if c.Encoding != "" && c.Encoding != "UTF-8" {
+		commit.Encoding = string(c.Encoding)
+	}
+
+	for i, p := range c.ParentHashes {
+		from := e.markRef(p)
+		if i == 0 {
+			commit.From = from
+		} else {
+			commit.Merge = append(commit.Merge, from)
+		}
+	}
+
+	// The root commits start a new history on their branch.
+	if len(c.ParentHashes) == 0 {
+		if err := e.enc.Encode(&fastimport.Reset{Ref: ref.String()}); err != nil {
+			return err
+		}
+	}
+
+	commit.Mark = e.marks.Next()
+	e.marks.Set(commit.Mark, c.Hash)
+	return e.enc.Encode(commit)
+}
+
+// fileOps writes the blobs of the changes not exported yet, and returns the
+// changes, the deletions first.
+func (e *fastExport) fileOps(changes object.Changes) ([]fastimport.FileOp, error) {
+	var deletes, modifies []fastimport.FileOp
+	for _, ch := range changes {
+		action, err := ch.Action()
+		if err != nil {
+			return nil, err
+		}
+
+		if action == merkletrie.Delete {
+			deletes = append(deletes, fastimport.FileOp{Type: fastimport.FileDelete, Path: ch.From.Name})
+			continue
+		}
+
+		entry := ch.To.TreeEntry
+		op := fastimport.FileOp{Type: fastimport.FileModify, Mode: entry.Mode, Path: ch.To.Name}
+		if entry.Mode == filemode.Submodule {
+			op.DataRef = entry.Hash.String()
+		} else {
+			mark, err := e.exportBlob(entry.Hash)
+			if err != nil {
+				return nil, err
+			}
+
+			op.DataRef = fastimport.MarkRef(mark)
+		}
+
+		modifies = append(modifies, op)
+	}
+
+	return append(deletes, modifies...), nil
+}
+
+// exportReference writes the tags of the reference, or sets it to its
+// commit.
+func (e *fastExport) exportReference(ref *plumbing.Reference, tip plumbing.Hash, tags []*object.Tag) error {
+	if len(tags) == 0 || !ref.Name().IsTag() {
+		return e.enc.Encode(&fastimport.Reset{Ref: ref.Name().String(), From: e.markRef(tip)})
+	}
+
+	// The tags of tags are written from the innermost one.
+	for i := len(tags) - 1; i >= 0; i-- {
+		t := tags[i]
+		if _, ok := e.marks.Mark(t.Hash); ok {
+			continue
+		}
+
+		name := t.Name
+		if i == 0 {
+			name = strings.TrimPrefix(ref.Name().String(), "refs/tags/")
+		}
+
+		tagger := t.Tagger
+		tag := &fastimport.Tag{
+			Name:        name,
+			Mark:        e.marks.Next(),
+			From:        e.markRef(t.Target),
+			OriginalOID: e.originalOID(t.Hash),
+			Tagger:      &tagger,
+			Message:     t.Message + t.PGPSignature,
+		}
+
+		e.marks.Set(tag.Mark, t.Hash)
+		if err := e.enc.Encode(tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// markRef returns the mark of an exported object, or its hash.
+func (e *fastExport) markRef(h plumbing.Hash) string {
+	if mark, ok := e.marks.Mark(h); ok {
+		return fastimport.MarkRef(mark)
+	}
+
+	return h.String()
+}
+
+func (e *fastExport) originalOID(h plumbing.Hash) string {
+	if e.o.ShowOriginalIDs {
+		return h.String()
+	}
+
+	return ""
+}
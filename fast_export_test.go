@@ -0,0 +1,143 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/fastimport"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type FastExportSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&FastExportSuite{})
+
+// forEachRef returns the references of a repository but the symbolic ones,
+// as given by git for-each-ref.
+func forEachRef(c *C, dir string, patterns ...string) []string {
+	args := append([]string{"--git-dir", dir, "for-each-ref", "--format=%(symref) %(objectname) %(refname)"}, patterns...)
+	out, err := exec.Command("git", args...).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	var refs []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if ref, ok := strings.CutPrefix(line, " "); ok {
+			refs = append(refs, ref)
+		}
+	}
+
+	return refs
+}
+
+// gitFastImport imports a stream into a new bare repository with git, and
+// returns its path.
+func gitFastImport(c *C, stream []byte, args ...string) string {
+	dir := c.MkDir()
+	out, err := exec.Command("git", "init", "--bare", dir).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	gitFastImportInto(c, dir, stream, args...)
+	return dir
+}
+
+func gitFastImportInto(c *C, dir string, stream []byte, args ...string) {
+	cmd := exec.Command("git", append([]string{"--git-dir", dir, "fast-import", "--quiet"}, args...)...)
+	cmd.Stdin = bytes.NewReader(stream)
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+}
+
+func (s *FastExportSuite) TestFastExport(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	for _, f := range []*fixtures.Fixture{
+		fixtures.Basic().One(),
+		fixtures.ByTag("merge-conflict").One(),
+		fixtures.ByURL("https://github.com/git-fixtures/submodule.git").One(),
+	} {
+		dir := f.DotGit().Root()
+		r, err := PlainOpen(dir)
+		c.Assert(err, IsNil)
+
+		var stream bytes.Buffer
+		c.Assert(r.FastExport(&stream, &FastExportOptions{}), IsNil)
+
+		expected := forEachRef(c, dir)
+		c.Assert(forEachRef(c, gitFastImport(c, stream.Bytes())), DeepEquals, expected)
+	}
+}
+
+func (s *FastExportSuite) TestFastExportTags(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	dir := fixtures.ByTag("tags").One().DotGit().Root()
+	r, err := PlainOpen(dir)
+	c.Assert(err, IsNil)
+
+	refs := []plumbing.ReferenceName{"refs/heads/master", "refs/tags/annotated-tag", "refs/tags/commit-tag", "refs/tags/blob-tag", "refs/tags/lightweight-tag"}
+
+	var stream bytes.Buffer
+	c.Assert(r.FastExport(&stream, &FastExportOptions{Refs: refs}), IsNil)
+
+	var names []string
+	for _, ref := range refs {
+		names = append(names, ref.String())
+	}
+
+	imported := gitFastImport(c, stream.Bytes())
+	c.Assert(forEachRef(c, imported), DeepEquals, forEachRef(c, dir, names...))
+}
+
+func (s *FastExportSuite) TestFastExportMarks(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	dir := fixtures.Basic().One().DotGit().Root()
+	r, err := PlainOpen(dir)
+	c.Assert(err, IsNil)
+
+	// The history of a first commit is exported, then the rest of it.
+	marks := fastimport.NewMarks()
+	c.Assert(r.Storer.SetReference(plumbing.NewReferenceFromStrings(
+		"refs/heads/first", "b029517f6300c2da0f4b651b8642506cd6aaf45d",
+	)), IsNil)
+
+	var first bytes.Buffer
+	c.Assert(r.FastExport(&first, &FastExportOptions{
+		Refs:  []plumbing.ReferenceName{"refs/heads/first"},
+		Marks: marks,
+	}), IsNil)
+
+	var marksFile bytes.Buffer
+	c.Assert(marks.Encode(&marksFile), IsNil)
+	c.Assert(marks.Len(), Not(Equals), 0)
+
+	imported := gitFastImport(c, first.Bytes())
+
+	var rest bytes.Buffer
+	c.Assert(r.FastExport(&rest, &FastExportOptions{
+		Refs:  []plumbing.ReferenceName{"refs/heads/master"},
+		Marks: marks,
+	}), IsNil)
+
+	// The objects of the first export are referred to by their marks.
+	c.Assert(strings.Contains(rest.String(), "b029517f6300c2da0f4b651b8642506cd6aaf45d"), Equals, false)
+
+	marksPath := c.MkDir() + "/marks"
+	c.Assert(os.WriteFile(marksPath, marksFile.Bytes(), 0644), IsNil)
+	gitFastImportInto(c, imported, rest.Bytes(), "--import-marks="+marksPath)
+
+	c.Assert(forEachRef(c, imported), DeepEquals, forEachRef(c, dir, "refs/heads/first", "refs/heads/master"))
+}
@@ -0,0 +1,577 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/fastimport"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// fastImportFilesCache is the number of commits whose files are kept by
+// FastImport, to avoid reading the trees of the branches again.
+const fastImportFilesCache = 16
+
+// FastImport reads a fast-import stream from rd, as written by FastExport or
+// `git fast-export`, storing its objects and updating its references, as
+// `git fast-import` does.
+//
+// The references are updated at the end of the stream and on checkpoints.
+// As git does, the branches whose new commit doesn't contain their current
+// one are left untouched unless FastImportOptions.Force is set, and
+// ErrForceNeeded is returned. The notes and the commands asking the importer
+// for data, such as cat-blob and ls, are not supported.
+func (r *Repository) FastImport(rd io.Reader, o *FastImportOptions) error {
+	i := &fastImport{
+		r:        r,
+		o:        o,
+		marks:    o.Marks,
+		branches: make(map[plumbing.ReferenceName]plumbing.Hash),
+		files:    make(map[plumbing.Hash]*fastImportFiles),
+	}
+
+	if i.marks == nil {
+		i.marks = fastimport.NewMarks()
+	}
+
+	d := fastimport.NewDecoder(rd)
+	for {
+		cmd, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, ok := cmd.(*fastimport.Done); ok {
+			break
+		}
+
+		if err := i.apply(cmd); err != nil {
+			return err
+		}
+	}
+
+	if err := i.updateReferences(); err != nil {
+		return err
+	}
+
+	if i.forceNeeded {
+		return ErrForceNeeded
+	}
+
+	return nil
+}
+
+type fastImport struct {
+	r     *Repository
+	o     *FastImportOptions
+	marks *fastimport.Marks
+
+	// branches are the commits of the references, to update at the next
+	// checkpoint, the zero hash for the ones reset without a commit.
+	branches map[plumbing.ReferenceName]plumbing.Hash
+	// files are the files of the last commits.
+	files map[plumbing.Hash]*fastImportFiles
+	// forceNeeded is set if a branch couldn't be updated.
+	forceNeeded bool
+}
+
+func (i *fastImport) apply(cmd fastimport.Command) error {
+	switch c := cmd.(type) {
+	case *fastimport.Blob:
+		h, err := i.r.HashObject(bytes.NewReader(c.Data), int64(len(c.Data)), plumbing.BlobObject, true)
+		if err != nil {
+			return err
+		}
+
+		i.mark(c.Mark, h)
+	case *fastimport.Commit:
+		return i.importCommit(c)
+	case *fastimport.Tag:
+		return i.importTag(c)
+	case *fastimport.Reset:
+		h := plumbing.ZeroHash
+		if c.From != "" {
+			var err error
+			if h, err = i.resolve(c.From); err != nil {
+				return err
+			}
+		}
+
+		i.branches[plumbing.ReferenceName(c.Ref)] = h
+	case *fastimport.Checkpoint:
+		return i.updateReferences()
+	case *fastimport.Feature:
+		return i.feature(c)
+	}
+
+	return nil
+}
+
+// feature checks a feature required by the stream is supported, the marks
+// files being handled by FastImportOptions.Marks.
+func (i *fastImport) feature(f *fastimport.Feature) error {
+	switch f.Name {
+	case "done", "import-marks", "import-marks-if-exists", "export-marks",
+		"relative-marks", "no-relative-marks":
+	case "force":
+		i.o.Force = true
+	case "date-format":
+		if f.Value != "raw" && f.Value != "raw-permissive" {
+			return fmt.Errorf("%w: date format %s", fastimport.ErrUnsupportedCommand, f.Value)
+		}
+	default:
+		return fmt.Errorf("%w: feature %s", fastimport.ErrUnsupportedCommand, f.Name)
+	}
+
+	return nil
+}
+
+func (i *fastImport) importCommit(c *fastimport.Commit) error {
+	ref := plumbing.ReferenceName(c.Ref)
+
+	var parents []plumbing.Hash
+	// As git does, a commit without from starts a new history on a branch not
+	// written in the stream yet, even if its reference exists.
+	if c.From != "" {
+		h, err := i.resolve(c.From)
+		if err != nil {
+			return err
+		}
+
+		if !h.IsZero() {
+			parents = append(parents, h)
+		}
+	} else if from := i.branches[ref]; !from.IsZero() {
+		parents = append(parents, from)
+	}
+
+	for _, m := range c.Merge {
+		h, err := i.resolve(m)
+		if err != nil {
+			return err
+		}
+
+		parents = append(parents, h)
+	}
+
+	var base plumbing.Hash
+	if len(parents) != 0 {
+		base = parents[0]
+	}
+
+	files, err := i.commitFiles(base)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range c.FileOps {
+		if err := i.applyFileOp(files, op); err != nil {
+			return err
+		}
+	}
+
+	idx := &index.Index{Version: 2}
+	for _, e := range files.entries {
+		idx.Entries = append(idx.Entries, e)
+	}
+
+	h := &buildTreeHelper{s: i.r.Storer}
+	tree, err := h.BuildTree(idx, nil)
+	if err != nil {
+		return err
+	}
+
+	commit := &object.Commit{
+		Author:       c.Committer,
+		Committer:    c.Committer,
+		Encoding:     object.MessageEncoding(c.Encoding),
+		Message:      c.Message,
+		TreeHash:     tree,
+		ParentHashes: parents,
+	}
+
+	if c.Author != nil {
+		commit.Author = *c.Author
+	}
+
+	obj := i.r.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return err
+	}
+
+	hash, err := i.r.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return err
+	}
+
+	if len(i.files) >= fastImportFilesCache {
+		i.files = make(map[plumbing.Hash]*fastImportFiles)
+	}
+
+	i.files[hash] = files
+	i.branches[ref] = hash
+	i.mark(c.Mark, hash)
+	return nil
+}
+
+// commitFiles returns a copy of the files of a commit.
+func (i *fastImport) commitFiles(h plumbing.Hash) (*fastImportFiles, error) {
+	if h.IsZero() {
+		return newFastImportFiles(), nil
+	}
+
+	if cached, ok := i.files[h]; ok {
+		return cached.copy(), nil
+	}
+
+	commit, err := i.r.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	files := newFastImportFiles()
+	return files, files.addTree(tree, "")
+}
+
+func (i *fastImport) applyFileOp(files *fastImportFiles, op fastimport.FileOp) error {
+	switch op.Type {
+	case fastimport.FileModify:
+		return i.modifyFile(files, op)
+	case fastimport.FileDelete:
+		files.remove(op.Path)
+	case fastimport.FileCopy, fastimport.FileRename:
+		copied := files.under(op.Source, op.Path)
+		if len(copied) == 0 {
+			return fmt.Errorf("path %s not in branch", op.Source)
+		}
+
+		if op.Type == fastimport.FileRename {
+			files.remove(op.Source)
+		}
+
+		files.remove(op.Path)
+		for _, e := range copied {
+			files.add(e)
+		}
+	case fastimport.FileDeleteAll:
+		files.remove("")
+	}
+
+	return nil
+}
+
+func (i *fastImport) modifyFile(files *fastImportFiles, op fastimport.FileOp) error {
+	var (
+		h   plumbing.Hash
+		err error
+	)
+
+	if op.DataRef == fastimport.Inline {
+		h, err = i.r.HashObject(bytes.NewReader(op.Data), int64(len(op.Data)), plumbing.BlobObject, true)
+	} else {
+		h, err = i.resolveObject(op.DataRef)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	files.remove(op.Path)
+	if op.Mode != filemode.Dir {
+		files.add(&index.Entry{Name: op.Path, Mode: op.Mode, Hash: h})
+		return nil
+	}
+
+	tree, err := i.r.TreeObject(h)
+	if err != nil {
+		return err
+	}
+
+	return files.addTree(tree, op.Path)
+}
+
+// fastImportFiles are the files of a commit being imported, by path, along
+// with the number of files of their directories.
+type fastImportFiles struct {
+	entries map[string]*index.Entry
+	dirs    map[string]int
+}
+
+func newFastImportFiles() *fastImportFiles {
+	return &fastImportFiles{
+		entries: make(map[string]*index.Entry),
+		dirs:    make(map[string]int),
+	}
+}
+
+func (f *fastImportFiles) copy() *fastImportFiles {
+	c := &fastImportFiles{
+		entries: make(map[string]*index.Entry, len(f.entries)),
+		dirs:    make(map[string]int, len(f.dirs)),
+	}
+
+	for name, e := range f.entries {
+		c.entries[name] = e
+	}
+
+	for dir, n := range f.dirs {
+		c.dirs[dir] = n
+	}
+
+	return c
+}
+
+func (f *fastImportFiles) add(e *index.Entry) {
+	if _, ok := f.entries[e.Name]; !ok {
+		// The files replaced by the directory of the new file are removed.
+		for dir := path.Dir(e.Name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := f.entries[dir]; ok {
+				delete(f.entries, dir)
+				f.updateDirs(dir, -1)
+			}
+		}
+
+		f.updateDirs(e.Name, 1)
+	}
+
+	f.entries[e.Name] = e
+}
+
+// addTree adds the files of a tree, under the given directory.
+func (f *fastImportFiles) addTree(tree *object.Tree, dir string) error {
+	w := object.NewTreeWalker(tree, true, nil)
+	defer w.Close()
+
+	for {
+		name, entry, err := w.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if entry.Mode != filemode.Dir {
+			name = joinPath(dir, name)
+			f.add(&index.Entry{Name: name, Mode: entry.Mode, Hash: entry.Hash})
+		}
+	}
+}
+
+// remove removes a file, or the files of a directory, all of them if p is
+// empty.
+func (f *fastImportFiles) remove(p string) {
+	if _, ok := f.entries[p]; ok {
+		delete(f.entries, p)
+		f.updateDirs(p, -1)
+		return
+	}
+
+	if p != "" && f.dirs[p] == 0 {
+		return
+	}
+
+	for name := range f.entries {
+		if _, ok := underPath(name, p); ok {
+			delete(f.entries, name)
+			f.updateDirs(name, -1)
+		}
+	}
+}
+
+// under returns the files of the file or directory src, moved to dst.
+func (f *fastImportFiles) under(src, dst string) []*index.Entry {
+	if e, ok := f.entries[src]; ok {
+		return []*index.Entry{{Name: dst, Mode: e.Mode, Hash: e.Hash}}
+	}
+
+	var entries []*index.Entry
+	for name, e := range f.entries {
+		if rel, ok := underPath(name, src); ok {
+			entries = append(entries, &index.Entry{Name: joinPath(dst, rel), Mode: e.Mode, Hash: e.Hash})
+		}
+	}
+
+	return entries
+}
+
+func (f *fastImportFiles) updateDirs(name string, n int) {
+	for {
+		i := strings.LastIndexByte(name, '/')
+		if i < 0 {
+			return
+		}
+
+		name = name[:i]
+		if f.dirs[name] += n; f.dirs[name] == 0 {
+			delete(f.dirs, name)
+		}
+	}
+}
+
+// underPath returns the path of name relative to the file or directory p,
+// and whether it is under it.
+func underPath(name, p string) (string, bool) {
+	if p == "" {
+		return name, true
+	}
+
+	if name == p {
+		return "", true
+	}
+
+	return strings.CutPrefix(name, p+"/")
+}
+
+func joinPath(dir, rel string) string {
+	if rel == "" {
+		return dir
+	}
+
+	if dir == "" {
+		return rel
+	}
+
+	return dir + "/" + rel
+}
+
+func (i *fastImport) importTag(t *fastimport.Tag) error {
+	target, err := i.resolve(t.From)
+	if err != nil {
+		return err
+	}
+
+	obj, err := i.r.Storer.EncodedObject(plumbing.AnyObject, target)
+	if err != nil {
+		return err
+	}
+
+	tag := &object.Tag{
+		Name:       t.Name,
+		Message:    t.Message,
+		Target:     target,
+		TargetType: obj.Type(),
+	}
+
+	if t.Tagger != nil {
+		tag.Tagger = *t.Tagger
+	}
+
+	o := i.r.Storer.NewEncodedObject()
+	if err := tag.Encode(o); err != nil {
+		return err
+	}
+
+	h, err := i.r.Storer.SetEncodedObject(o)
+	if err != nil {
+		return err
+	}
+
+	i.branches[plumbing.NewTagReferenceName(t.Name)] = h
+	i.mark(t.Mark, h)
+	return nil
+}
+
+func (i *fastImport) mark(mark int, h plumbing.Hash) {
+	if mark != 0 {
+		i.marks.Set(mark, h)
+	}
+}
+
+// resolve returns the commit of a mark, a hash or a branch.
+func (i *fastImport) resolve(ref string) (plumbing.Hash, error) {
+	if h, ok := i.branches[plumbing.ReferenceName(ref)]; ok {
+		return h, nil
+	}
+
+	if _, ok := fastimport.ParseMarkRef(ref); ok || plumbing.IsHash(ref) {
+		return i.resolveObject(ref)
+	}
+
+	h, err := i.r.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return *h, nil
+}
+
+// resolveObject returns the object of a mark or a hash.
+func (i *fastImport) resolveObject(ref string) (plumbing.Hash, error) {
+	if mark, ok := fastimport.ParseMarkRef(ref); ok {
+		h, ok := i.marks.Hash(mark)
+		if !ok {
+			return plumbing.ZeroHash, fmt.Errorf("mark %s not declared", ref)
+		}
+
+		return h, nil
+	}
+
+	if !plumbing.IsHash(ref) {
+		return plumbing.ZeroHash, fmt.Errorf("%w: invalid object %q", fastimport.ErrMalformedStream, ref)
+	}
+
+	return plumbing.NewHash(ref), nil
+}
+
+// updateReferences sets the references to their new commit, the branches
+// which are not fast-forwarded only if forced, forceNeeded being set
+// otherwise.
+func (i *fastImport) updateReferences() error {
+	names := make([]plumbing.ReferenceName, 0, len(i.branches))
+	for name, h := range i.branches {
+		if !h.IsZero() {
+			names = append(names, name)
+		}
+	}
+
+	sort.Slice(names, func(a, b int) bool { return names[a] < names[b] })
+	for _, name := range names {
+		h := i.branches[name]
+		old, err := i.r.Storer.Reference(name)
+		if err != nil && err != plumbing.ErrReferenceNotFound {
+			return err
+		}
+
+		if old != nil {
+			if old.Hash() == h {
+				continue
+			}
+
+			if !i.o.Force && name.IsBranch() {
+				ff, err := isFastForward(i.r.Storer, old.Hash(), h, nil)
+				if err != nil {
+					return err
+				}
+
+				if !ff {
+					i.forceNeeded = true
+					continue
+				}
+			}
+		}
+
+		if err := i.r.Storer.SetReference(plumbing.NewHashReference(name, h)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
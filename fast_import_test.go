@@ -0,0 +1,219 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/fastimport"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type FastImportSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&FastImportSuite{})
+
+func (s *FastImportSuite) TestFastImport(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	for _, f := range []*fixtures.Fixture{
+		fixtures.Basic().One(),
+		fixtures.ByTag("merge-conflict").One(),
+		fixtures.ByTag("tags").One(),
+		fixtures.ByURL("https://github.com/git-fixtures/submodule.git").One(),
+	} {
+		src := f.DotGit().Root()
+		cmd := exec.Command("git", "--git-dir", src, "fast-export", "--signed-tags=verbatim", "--branches", "--tags")
+		stream, err := cmd.Output()
+		c.Assert(err, IsNil)
+
+		dir := c.MkDir()
+		r, err := PlainInit(dir, true)
+		c.Assert(err, IsNil)
+		c.Assert(r.FastImport(bytes.NewReader(stream), &FastImportOptions{}), IsNil)
+
+		// The tags of trees are left out by git.
+		var expected []string
+		for _, ref := range forEachRef(c, src, "refs/heads", "refs/tags") {
+			if !strings.HasSuffix(ref, "refs/tags/tree-tag") {
+				expected = append(expected, ref)
+			}
+		}
+
+		c.Assert(forEachRef(c, dir), DeepEquals, expected)
+
+		report, err := r.Fsck(FsckOptions{})
+		c.Assert(err, IsNil)
+		c.Assert(report.HasErrors(), Equals, false, Commentf("%v", report.Problems))
+	}
+}
+
+func (s *FastImportSuite) TestFastImportFastExport(c *C) {
+	r, err := PlainOpen(fixtures.Basic().One().DotGit().Root())
+	c.Assert(err, IsNil)
+
+	var stream bytes.Buffer
+	c.Assert(r.FastExport(&stream, &FastExportOptions{ShowOriginalIDs: true}), IsNil)
+
+	imported, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+	c.Assert(imported.FastImport(&stream, &FastImportOptions{}), IsNil)
+
+	for _, name := range []plumbing.ReferenceName{"refs/heads/master", "refs/heads/branch", "refs/tags/v1.0.0"} {
+		expected, err := r.Reference(name, false)
+		c.Assert(err, IsNil)
+
+		ref, err := imported.Reference(name, false)
+		c.Assert(err, IsNil)
+		c.Assert(ref.Hash(), Equals, expected.Hash())
+	}
+}
+
+const fastImportFileOps = `feature done
+# The files of the first commit.
+blob
+mark :1
+data 4
+foo
+
+commit refs/heads/master
+mark :2
+author A U Thor <author@example.com> 1600000000 +0200
+committer C O Mitter <committer@example.com> 1600000001 -0130
+data <<END
+first
+END
+M 100644 :1 foo
+M 644 :1 dir/a
+M 755 inline "dir/with space\ttab"
+data 4
+bar
+M 120000 inline link
+data 3
+foo
+
+commit refs/heads/master
+mark :3
+committer C O Mitter <committer@example.com> 1600000002 +0000
+data 7
+second
+from :2
+C dir copy
+R foo dir/foo
+D "dir/with space\ttab"
+M 100644 :1 link/file
+
+reset refs/heads/other
+from :2
+
+commit refs/heads/other
+committer C O Mitter <committer@example.com> 1600000003 +0000
+data 6
+other
+deleteall
+M 100644 inline other
+data 5
+other
+
+commit refs/heads/master
+mark :4
+committer C O Mitter <committer@example.com> 1600000004 +0000
+data 6
+merge
+merge refs/heads/other
+M 040000 :5 tree
+
+tag v1
+from :4
+tagger T A Gger <tagger@example.com> 1600000005 +0000
+data 4
+tag
+
+done
+`
+
+func (s *FastImportSuite) TestFastImportFileOps(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	// The mark of a tree can't be declared in a stream, so the one of the
+	// first commit is imported first, and set with a marks file.
+	first := strings.SplitN(fastImportFileOps, "commit refs/heads/master\nmark :3", 2)[0] + "done\n"
+	dir := gitFastImport(c, []byte(first))
+	out, err := exec.Command("git", "--git-dir", dir, "rev-parse", "master^{tree}").Output()
+	c.Assert(err, IsNil)
+	tree := strings.TrimSpace(string(out))
+
+	marksPath := c.MkDir() + "/marks"
+	c.Assert(os.WriteFile(marksPath, []byte(":5 "+tree+"\n"), 0644), IsNil)
+	gitFastImportInto(c, dir, []byte(fastImportFileOps), "--import-marks="+marksPath)
+	expected := dir
+
+	dir = c.MkDir()
+	r, err := PlainInit(dir, true)
+	c.Assert(err, IsNil)
+
+	// The tree is imported in a first run.
+	c.Assert(r.FastImport(strings.NewReader(first), &FastImportOptions{}), IsNil)
+
+	marks := fastimport.NewMarks()
+	marks.Set(5, plumbing.NewHash(tree))
+	c.Assert(r.FastImport(strings.NewReader(fastImportFileOps), &FastImportOptions{Marks: marks, Force: true}), IsNil)
+	c.Assert(forEachRef(c, dir), DeepEquals, forEachRef(c, expected))
+
+	h, ok := marks.Hash(4)
+	c.Assert(ok, Equals, true)
+	ref, err := r.Reference("refs/heads/master", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, h)
+}
+
+func (s *FastImportSuite) TestFastImportForceNeeded(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	stream := "commit refs/heads/master\n" +
+		"committer C O Mitter <committer@example.com> 1600000000 +0000\n" +
+		"data 6\nfirst\n" +
+		"M 100644 inline foo\ndata 3\nfoo\n\n"
+
+	c.Assert(r.FastImport(strings.NewReader(stream), &FastImportOptions{}), IsNil)
+	first, err := r.Reference("refs/heads/master", false)
+	c.Assert(err, IsNil)
+
+	// The commit follows the existing branch.
+	next := "commit refs/heads/master\n" +
+		"committer C O Mitter <committer@example.com> 1600000001 +0000\n" +
+		"data 7\nsecond\n" +
+		"from refs/heads/master^0\n" +
+		"M 100644 inline foo\ndata 3\nbar\n\n"
+
+	c.Assert(r.FastImport(strings.NewReader(next), &FastImportOptions{}), IsNil)
+	second, err := r.Reference("refs/heads/master", false)
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(second.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{first.Hash()})
+
+	// Without from, the commit starts a new history.
+	c.Assert(r.FastImport(strings.NewReader(stream), &FastImportOptions{}), Equals, ErrForceNeeded)
+	ref, err := r.Reference("refs/heads/master", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, second.Hash())
+
+	c.Assert(r.FastImport(strings.NewReader(stream), &FastImportOptions{Force: true}), IsNil)
+	ref, err = r.Reference("refs/heads/master", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, first.Hash())
+}
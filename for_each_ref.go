@@ -0,0 +1,326 @@
+package git
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// RefRecord is a reference listed by Repository.ForEachRef. The fields
+// other than the name and the hash of the reference are loaded on demand,
+// and only once.
+type RefRecord struct {
+	ref  *plumbing.Reference
+	hash plumbing.Hash
+	refs *refRecords
+
+	loaded bool
+	obj    object.Object
+	peeled object.Object
+	err    error
+}
+
+// refRecords holds what the records of a listing share.
+type refRecords struct {
+	r   *Repository
+	cfg *config.Config
+}
+
+// ForEachRef lists the references of the repository, as git for-each-ref
+// does, sorted and filtered by the given options. HEAD and the references
+// whose target doesn't exist are left out. The objects of the references
+// are only read to sort them by date, or when a field of a record needing
+// them is requested, so a page of a large listing sorted by name is cheap.
+func (r *Repository) ForEachRef(o *ForEachRefOptions) ([]*RefRecord, error) {
+	if o == nil {
+		o = &ForEachRefOptions{}
+	}
+
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	iter, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := &refRecords{r: r}
+	var records []*RefRecord
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		if !strings.HasPrefix(name, "refs/") || !matchRefPatterns(o.Patterns, name) {
+			return nil
+		}
+
+		resolved, err := storer.ResolveReference(r.Storer, ref.Name())
+		if err != nil {
+			// A broken symbolic reference is ignored, as git does.
+			return nil
+		}
+
+		records = append(records, &RefRecord{ref: ref, hash: resolved.Hash(), refs: refs})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sortRefRecords(records, o.SortBy); err != nil {
+		return nil, err
+	}
+
+	if o.Count > 0 && len(records) > o.Count {
+		records = records[:o.Count]
+	}
+
+	return records, nil
+}
+
+// matchRefPatterns returns true if the name matches one of the patterns, or
+// if there are none.
+func matchRefPatterns(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, p := range patterns {
+		if strings.ContainsAny(p, "*?[") {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+
+			continue
+		}
+
+		p = strings.TrimSuffix(p, "/")
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortRefRecords sorts the records by the given keys, then by name.
+func sortRefRecords(records []*RefRecord, keys []string) error {
+	for _, key := range keys {
+		if strings.TrimPrefix(key, "-") == SortByRefName {
+			continue
+		}
+
+		for _, rec := range records {
+			if err := rec.load(); err != nil {
+				return err
+			}
+		}
+
+		break
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		for _, key := range keys {
+			c := compareRefRecords(a, b, strings.TrimPrefix(key, "-"))
+			if strings.HasPrefix(key, "-") {
+				c = -c
+			}
+
+			if c != 0 {
+				return c < 0
+			}
+		}
+
+		return a.Name() < b.Name()
+	})
+
+	return nil
+}
+
+func compareRefRecords(a, b *RefRecord, key string) int {
+	switch key {
+	case SortByRefName:
+		return strings.Compare(a.Name().String(), b.Name().String())
+	case SortByCreatorDate:
+		return compareTimes(a.creatorDate(), b.creatorDate())
+	case SortByCommitterDate:
+		return compareTimes(a.committerDate(), b.committerDate())
+	}
+
+	return 0
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+
+	return 0
+}
+
+// Reference returns the reference, as stored: the target of a symbolic
+// reference isn't resolved.
+func (rec *RefRecord) Reference() *plumbing.Reference {
+	return rec.ref
+}
+
+// Name returns the name of the reference, as %(refname).
+func (rec *RefRecord) Name() plumbing.ReferenceName {
+	return rec.ref.Name()
+}
+
+// Hash returns the hash of the object the reference points to, a symbolic
+// reference being resolved, as %(objectname).
+func (rec *RefRecord) Hash() plumbing.Hash {
+	return rec.hash
+}
+
+// Type returns the type of the object the reference points to, as
+// %(objecttype).
+func (rec *RefRecord) Type() (plumbing.ObjectType, error) {
+	if err := rec.load(); err != nil {
+		return plumbing.InvalidObject, err
+	}
+
+	return rec.obj.Type(), nil
+}
+
+// Peeled returns the hash of the object the reference points to, once the
+// annotated tags are peeled, as %(*objectname). It's the one of Hash if the
+// reference doesn't point to a tag.
+func (rec *RefRecord) Peeled() (plumbing.Hash, error) {
+	if err := rec.load(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return rec.peeled.ID(), nil
+}
+
+// Commit returns the commit the reference points to, once the annotated
+// tags are peeled, for its author, date or subject.
+// object.ErrUnsupportedObject is returned if it's not a commit.
+func (rec *RefRecord) Commit() (*object.Commit, error) {
+	if err := rec.load(); err != nil {
+		return nil, err
+	}
+
+	c, ok := rec.peeled.(*object.Commit)
+	if !ok {
+		return nil, object.ErrUnsupportedObject
+	}
+
+	return c, nil
+}
+
+// CreatorDate returns the date of the tagger of an annotated tag, or of
+// the committer of a commit, as %(creatordate). It's zero for the other
+// objects.
+func (rec *RefRecord) CreatorDate() (time.Time, error) {
+	if err := rec.load(); err != nil {
+		return time.Time{}, err
+	}
+
+	return rec.creatorDate(), nil
+}
+
+// CommitterDate returns the date of the committer of the commit the
+// reference points to, as %(committerdate). It's zero if it doesn't point
+// to a commit, as an annotated tag.
+func (rec *RefRecord) CommitterDate() (time.Time, error) {
+	if err := rec.load(); err != nil {
+		return time.Time{}, err
+	}
+
+	return rec.committerDate(), nil
+}
+
+// Upstream returns the name of the reference tracking the upstream of a
+// branch, as %(upstream). ErrNoUpstream is returned if the reference isn't
+// a branch or has no upstream.
+func (rec *RefRecord) Upstream() (plumbing.ReferenceName, error) {
+	if !rec.Name().IsBranch() {
+		return "", ErrNoUpstream
+	}
+
+	if rec.refs.cfg == nil {
+		cfg, err := rec.refs.r.Config()
+		if err != nil {
+			return "", err
+		}
+
+		rec.refs.cfg = cfg
+	}
+
+	return upstreamTrackingName(rec.refs.cfg, rec.Name().Short())
+}
+
+// AheadBehind returns the number of commits of a branch not in its
+// upstream, and of its upstream not in the branch, as
+// %(upstream:track). ErrNoUpstream is returned if the reference isn't a
+// branch or has no upstream.
+func (rec *RefRecord) AheadBehind() (ahead, behind int, err error) {
+	upstream, err := rec.Upstream()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ref, err := storer.ResolveReference(rec.refs.r.Storer, upstream)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return rec.refs.r.AheadBehind(rec.hash, ref.Hash())
+}
+
+func (rec *RefRecord) creatorDate() time.Time {
+	switch o := rec.obj.(type) {
+	case *object.Tag:
+		return o.Tagger.When
+	case *object.Commit:
+		return o.Committer.When
+	}
+
+	return time.Time{}
+}
+
+func (rec *RefRecord) committerDate() time.Time {
+	if c, ok := rec.obj.(*object.Commit); ok {
+		return c.Committer.When
+	}
+
+	return time.Time{}
+}
+
+// load reads the object of the reference, and the one it peels to.
+func (rec *RefRecord) load() error {
+	if rec.loaded {
+		return rec.err
+	}
+
+	rec.loaded = true
+	rec.obj, rec.err = rec.refs.r.Object(plumbing.AnyObject, rec.hash)
+	if rec.err != nil {
+		return rec.err
+	}
+
+	rec.peeled = rec.obj
+	for {
+		tag, ok := rec.peeled.(*object.Tag)
+		if !ok {
+			return nil
+		}
+
+		if rec.peeled, rec.err = tag.Object(); rec.err != nil {
+			return rec.err
+		}
+	}
+}
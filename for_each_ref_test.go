@@ -0,0 +1,169 @@
+package git
+
+import (
+	"errors"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type ForEachRefSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ForEachRefSuite{})
+
+func (s *ForEachRefSuite) open(c *C, f *fixtures.Fixture) *Repository {
+	r, err := Open(filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+	return r
+}
+
+func (s *ForEachRefSuite) names(records []*RefRecord) []string {
+	var names []string
+	for _, rec := range records {
+		names = append(names, rec.Name().String())
+	}
+
+	return names
+}
+
+func (s *ForEachRefSuite) TestForEachRef(c *C) {
+	r := s.open(c, fixtures.ByTag("tags").One())
+
+	records, err := r.ForEachRef(nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.names(records), DeepEquals, []string{
+		"refs/heads/master",
+		"refs/remotes/origin/HEAD",
+		"refs/remotes/origin/master",
+		"refs/tags/annotated-tag",
+		"refs/tags/blob-tag",
+		"refs/tags/commit-tag",
+		"refs/tags/lightweight-tag",
+		"refs/tags/tree-tag",
+	})
+
+	// The symbolic references are resolved.
+	c.Assert(records[1].Reference().Type(), Equals, plumbing.SymbolicReference)
+	c.Assert(records[1].Hash(), Equals, plumbing.NewHash("f7b877701fbf855b44c0a9e86f3fdce2c298b07f"))
+
+	tag := records[4]
+	typ, err := tag.Type()
+	c.Assert(err, IsNil)
+	c.Assert(typ, Equals, plumbing.TagObject)
+
+	peeled, err := tag.Peeled()
+	c.Assert(err, IsNil)
+	c.Assert(peeled, Equals, plumbing.NewHash("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"))
+
+	_, err = tag.Commit()
+	c.Assert(err, Equals, object.ErrUnsupportedObject)
+
+	date, err := tag.CreatorDate()
+	c.Assert(err, IsNil)
+	c.Assert(date.Unix(), Equals, int64(1474485453))
+
+	date, err = tag.CommitterDate()
+	c.Assert(err, IsNil)
+	c.Assert(date.IsZero(), Equals, true)
+
+	commit, err := records[3].Commit()
+	c.Assert(err, IsNil)
+	c.Assert(commit.Hash, Equals, plumbing.NewHash("f7b877701fbf855b44c0a9e86f3fdce2c298b07f"))
+}
+
+func (s *ForEachRefSuite) TestForEachRefPatterns(c *C) {
+	r := s.open(c, fixtures.ByTag("tags").One())
+
+	records, err := r.ForEachRef(&ForEachRefOptions{
+		Patterns: []string{"refs/heads/", "refs/tags/*-tag", "refs/remotes/origin/mas"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.names(records), DeepEquals, []string{
+		"refs/heads/master",
+		"refs/tags/annotated-tag",
+		"refs/tags/blob-tag",
+		"refs/tags/commit-tag",
+		"refs/tags/lightweight-tag",
+		"refs/tags/tree-tag",
+	})
+
+	records, err = r.ForEachRef(&ForEachRefOptions{Patterns: []string{"refs/*"}})
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 0)
+}
+
+func (s *ForEachRefSuite) TestForEachRefSort(c *C) {
+	r := s.open(c, fixtures.ByTag("tags").One())
+
+	// The results of git for-each-ref --sort.
+	records, err := r.ForEachRef(&ForEachRefOptions{SortBy: []string{"-creatordate"}})
+	c.Assert(err, IsNil)
+	c.Assert(s.names(records), DeepEquals, []string{
+		"refs/tags/tree-tag",
+		"refs/tags/blob-tag",
+		"refs/tags/commit-tag",
+		"refs/tags/annotated-tag",
+		"refs/heads/master",
+		"refs/remotes/origin/HEAD",
+		"refs/remotes/origin/master",
+		"refs/tags/lightweight-tag",
+	})
+
+	records, err = r.ForEachRef(&ForEachRefOptions{SortBy: []string{SortByCommitterDate}})
+	c.Assert(err, IsNil)
+	c.Assert(s.names(records), DeepEquals, []string{
+		"refs/tags/annotated-tag",
+		"refs/tags/blob-tag",
+		"refs/tags/commit-tag",
+		"refs/tags/tree-tag",
+		"refs/heads/master",
+		"refs/remotes/origin/HEAD",
+		"refs/remotes/origin/master",
+		"refs/tags/lightweight-tag",
+	})
+
+	records, err = r.ForEachRef(&ForEachRefOptions{SortBy: []string{"-refname"}, Count: 2})
+	c.Assert(err, IsNil)
+	c.Assert(s.names(records), DeepEquals, []string{
+		"refs/tags/tree-tag",
+		"refs/tags/lightweight-tag",
+	})
+
+	_, err = r.ForEachRef(&ForEachRefOptions{SortBy: []string{"authordate"}})
+	c.Assert(errors.Is(err, ErrInvalidRefSortKey), Equals, true)
+}
+
+func (s *ForEachRefSuite) TestForEachRefUpstream(c *C) {
+	r := s.open(c, fixtures.Basic().One())
+	c.Assert(r.SetUpstream("master", "origin", "refs/heads/branch"), IsNil)
+
+	records, err := r.ForEachRef(&ForEachRefOptions{Patterns: []string{"refs/heads", "refs/tags"}})
+	c.Assert(err, IsNil)
+	c.Assert(s.names(records), DeepEquals, []string{
+		"refs/heads/branch",
+		"refs/heads/master",
+		"refs/tags/v1.0.0",
+	})
+
+	upstream, err := records[1].Upstream()
+	c.Assert(err, IsNil)
+	c.Assert(upstream, Equals, plumbing.ReferenceName("refs/remotes/origin/branch"))
+
+	ahead, behind, err := records[1].AheadBehind()
+	c.Assert(err, IsNil)
+	c.Assert([]int{ahead, behind}, DeepEquals, []int{1, 1})
+
+	ahead, behind, err = records[0].AheadBehind()
+	c.Assert(err, IsNil)
+	c.Assert([]int{ahead, behind}, DeepEquals, []int{0, 0})
+
+	_, err = records[2].Upstream()
+	c.Assert(err, Equals, ErrNoUpstream)
+}
@@ -0,0 +1,707 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	objfsck "github.com/go-git/go-git/v5/plumbing/fsck"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// FsckProblemType is the kind of a problem found by Fsck.
+type FsckProblemType int8
+
+const (
+	// FsckHashMismatch is an object whose content doesn't hash to its ID.
+	FsckHashMismatch FsckProblemType = iota
+	// FsckCorruptObject is an object which can't be read.
+	FsckCorruptObject
+	// FsckBadObject is a commit, tree or tag which is not well formed.
+	FsckBadObject
+	// FsckMissingObject is an object referenced by a reference, a reflog,
+	// the index or another object, which is not in the repository.
+	FsckMissingObject
+	// FsckDanglingObject is an object not reachable from the references,
+	// nor referenced by another object. It is not an error, the dangling
+	// objects are removed by GC once old enough.
+	FsckDanglingObject
+	// FsckBadPackIndex is a packfile index which can't be read, whose
+	// checksum is wrong, or which is not the one of its packfile.
+	FsckBadPackIndex
+	// FsckBadPack is a packfile which can't be read, or whose checksum is
+	// wrong.
+	FsckBadPack
+)
+
+func (t FsckProblemType) String() string {
+	switch t {
+	case FsckHashMismatch:
+		return "hash mismatch"
+	case FsckCorruptObject:
+		return "corrupt object"
+	case FsckBadObject:
+		return "bad object"
+	case FsckMissingObject:
+		return "missing object"
+	case FsckDanglingObject:
+		return "dangling object"
+	case FsckBadPackIndex:
+		return "bad pack index"
+	case FsckBadPack:
+		return "bad pack"
+	default:
+		return "unknown"
+	}
+}
+
+// FsckProblem is a problem found by Fsck.
+type FsckProblem struct {
+	Type FsckProblemType
+	// Hash is the object with the problem, or the packfile for the
+	// FsckBadPackIndex and FsckBadPack problems.
+	Hash plumbing.Hash
+	// ObjectType is the type of the object, if known.
+	ObjectType plumbing.ObjectType
+	// Pack is the packfile containing the object, zero if the object is
+	// loose or not in the repository.
+	Pack plumbing.Hash
+	// Message details the problem.
+	Message string
+}
+
+func (p FsckProblem) String() string {
+	s := fmt.Sprintf("%s %s", p.Type, p.Hash)
+	if p.Message != "" {
+		s += ": " + p.Message
+	}
+
+	return s
+}
+
+// FsckReport is the result of Fsck.
+type FsckReport struct {
+	// Objects is the number of objects checked.
+	Objects int
+	// Problems are the problems found, in the order they were found, the
+	// missing and dangling objects last.
+	Problems []FsckProblem
+}
+
+// HasErrors tells whether the repository is corrupt, that is whether any
+// problem other than a dangling object was found.
+func (r *FsckReport) HasErrors() bool {
+	for _, p := range r.Problems {
+		if p.Type != FsckDanglingObject {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fsck verifies the integrity of the repository, as `git fsck` does. Every
+// loose and packed object is read and hashed again to check its content
+// matches its ID, and the commits, trees and tags are checked to be well
+// formed. The checksums of the packfiles and their indexes are verified.
+// Finally, the objects reachable from the references, their reflogs and the
+// index are checked to exist, and the unreachable objects not referenced by
+// any other object are reported as dangling.
+//
+// The problems found are reported in the returned FsckReport, an error is
+// only returned if the repository can't be read at all.
+func (r *Repository) Fsck(o FsckOptions) (*FsckReport, error) {
+	f := &fsck{
+		r:        r,
+		o:        &o,
+		report:   &FsckReport{},
+		progress: &fsckProgress{w: o.Progress},
+		objects:  make(map[plumbing.Hash]plumbing.ObjectType),
+		links:    make(map[plumbing.Hash][]plumbing.Hash),
+	}
+
+	if err := f.checkObjects(); err != nil {
+		return nil, err
+	}
+
+	if err := f.checkConnectivity(); err != nil {
+		return nil, err
+	}
+
+	return f.report, nil
+}
+
+// fsck holds the state of a Fsck run.
+type fsck struct {
+	r        *Repository
+	o        *FsckOptions
+	report   *FsckReport
+	progress *fsckProgress
+	// objects are the types of the objects of the repository.
+	objects map[plumbing.Hash]plumbing.ObjectType
+	// links are the objects referenced by the commits, trees and tags.
+	links map[plumbing.Hash][]plumbing.Hash
+}
+
+func (f *fsck) problem(t FsckProblemType, h plumbing.Hash, typ plumbing.ObjectType, pack plumbing.Hash, format string, args ...interface{}) {
+	f.report.Problems = append(f.report.Problems, FsckProblem{
+		Type:       t,
+		Hash:       h,
+		ObjectType: typ,
+		Pack:       pack,
+		Message:    fmt.Sprintf(format, args...),
+	})
+}
+
+// checkObjects checks all the objects of the repository, reading the loose
+// objects and the packfiles directly if the storage is a filesystem, so the
+// objects found in several places are all checked.
+func (f *fsck) checkObjects() error {
+	fs, ok := storageFilesystem(f.r.Storer)
+	if !ok {
+		return f.checkStoredObjects()
+	}
+
+	var loose []plumbing.Hash
+	if los, ok := f.r.Storer.(storer.LooseObjectStorer); ok {
+		err := los.ForEachObjectHash(func(h plumbing.Hash) error {
+			loose = append(loose, h)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var packs []plumbing.Hash
+	if pos, ok := f.r.Storer.(storer.PackedObjectStorer); ok {
+		var err error
+		if packs, err = pos.ObjectPacks(); err != nil {
+			return err
+		}
+	}
+
+	total := len(loose)
+	indexes := make([]*idxfile.MemoryIndex, len(packs))
+	for i, pack := range packs {
+		indexes[i] = f.readPackIndex(fs, pack)
+		if indexes[i] == nil {
+			continue
+		}
+
+		count, err := indexes[i].Count()
+		if err != nil {
+			return err
+		}
+
+		total += int(count)
+	}
+
+	f.progress.start("Checking objects", total)
+	for _, h := range loose {
+		f.checkLooseObject(fs, h)
+	}
+
+	for i, pack := range packs {
+		if indexes[i] == nil {
+			continue
+		}
+
+		if err := f.checkPack(fs, pack, indexes[i]); err != nil {
+			return err
+		}
+	}
+
+	f.progress.done()
+	return nil
+}
+
+// checkStoredObjects checks the objects of a storage which is not a
+// filesystem, as returned by it.
+func (f *fsck) checkStoredObjects() error {
+	iter, err := f.r.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return err
+	}
+
+	var objs []plumbing.EncodedObject
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		objs = append(objs, obj)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f.progress.start("Checking objects", len(objs))
+	for _, obj := range objs {
+		f.checkObject(obj.Hash(), plumbing.ZeroHash, obj)
+	}
+
+	f.progress.done()
+	return nil
+}
+
+func (f *fsck) checkLooseObject(fs billy.Filesystem, h plumbing.Hash) {
+	obj, err := readLooseObject(fs, h, f.o.ConnectivityOnly)
+	if err != nil {
+		f.corruptObject(h, plumbing.ZeroHash, err)
+		return
+	}
+
+	f.checkObject(h, plumbing.ZeroHash, obj)
+}
+
+// corruptObject reports an object which can't be read. It is still
+// considered to be in the repository when checking the connectivity.
+func (f *fsck) corruptObject(h, pack plumbing.Hash, err error) {
+	f.progress.add()
+	f.report.Objects++
+	if _, ok := f.objects[h]; !ok {
+		f.objects[h] = plumbing.InvalidObject
+	}
+
+	f.problem(FsckCorruptObject, h, plumbing.InvalidObject, pack, "%s", err)
+}
+
+// readLooseObject reads the loose object, without the content of the blobs
+// if headerOnly is true.
+func readLooseObject(fs billy.Filesystem, h plumbing.Hash, headerOnly bool) (obj plumbing.EncodedObject, err error) {
+	hex := h.String()
+	file, err := fs.Open(path.Join("objects", hex[:2], hex[2:]))
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(file, &err)
+
+	r, err := objfile.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	typ, size, err := r.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	obj = &plumbing.MemoryObject{}
+	obj.SetType(typ)
+	obj.SetSize(size)
+	if headerOnly && typ == plumbing.BlobObject {
+		return obj, nil
+	}
+
+	w, err := obj.Writer()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+
+	return obj, w.Close()
+}
+
+// readPackIndex reads the index of the packfile, checking its checksum. It
+// returns nil if the index can't be read.
+func (f *fsck) readPackIndex(fs billy.Filesystem, pack plumbing.Hash) *idxfile.MemoryIndex {
+	content, err := util.ReadFile(fs, path.Join(packDir, fmt.Sprintf("pack-%s.idx", pack)))
+	if err != nil {
+		f.problem(FsckBadPackIndex, pack, plumbing.InvalidObject, pack, "%s", err)
+		return nil
+	}
+
+	idx := idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(bytes.NewReader(content)).Decode(idx); err != nil {
+		f.problem(FsckBadPackIndex, pack, plumbing.InvalidObject, pack, "%s", err)
+		return nil
+	}
+
+	if !f.o.ConnectivityOnly {
+		sum := hash.New(hash.CryptoType)
+		sum.Write(content[:len(content)-hash.Size])
+		if !bytes.Equal(sum.Sum(nil), idx.IdxChecksum[:]) {
+			f.problem(FsckBadPackIndex, pack, plumbing.InvalidObject, pack, "index checksum mismatch")
+		}
+	}
+
+	return idx
+}
+
+// checkPack checks the checksum of the packfile, then all its objects.
+func (f *fsck) checkPack(fs billy.Filesystem, pack plumbing.Hash, idx *idxfile.MemoryIndex) error {
+	name := path.Join(packDir, fmt.Sprintf("pack-%s.pack", pack))
+	fi, err := fs.Stat(name)
+	if err != nil {
+		f.problem(FsckBadPack, pack, plumbing.InvalidObject, pack, "%s", err)
+		return nil
+	}
+
+	file, err := fs.Open(name)
+	if err != nil {
+		return err
+	}
+
+	if !f.o.ConnectivityOnly {
+		if err := f.checkPackChecksum(file, fi.Size(), pack, idx); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+
+	p := packfile.NewPackfile(idx, fs, file, 0)
+	defer p.Close()
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	defer entries.Close()
+	for {
+		e, err := entries.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		obj, err := p.GetByOffset(int64(e.Offset))
+		if err != nil {
+			f.corruptObject(e.Hash, pack, err)
+			continue
+		}
+
+		f.checkObject(e.Hash, pack, obj)
+	}
+}
+
+// checkPackChecksum checks the trailing checksum of the packfile is the one
+// of its content, and the one recorded in its index.
+func (f *fsck) checkPackChecksum(file billy.File, size int64, pack plumbing.Hash, idx *idxfile.MemoryIndex) error {
+	if size < hash.Size {
+		f.problem(FsckBadPack, pack, plumbing.InvalidObject, pack, "packfile too short")
+		return nil
+	}
+
+	sum := hash.New(hash.CryptoType)
+	if _, err := io.Copy(sum, io.LimitReader(file, size-hash.Size)); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, hash.Size)
+	if _, err := io.ReadFull(file, trailer); err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(sum.Sum(nil), trailer) {
+		f.problem(FsckBadPack, pack, plumbing.InvalidObject, pack, "packfile checksum mismatch")
+	} else if !bytes.Equal(trailer, idx.PackfileChecksum[:]) {
+		f.problem(FsckBadPackIndex, pack, plumbing.InvalidObject, pack, "index doesn't match the packfile")
+	}
+
+	return nil
+}
+
+// checkObject checks the content of the object hashes to its ID and is well
+// formed, and records the objects it references.
+func (f *fsck) checkObject(h, pack plumbing.Hash, obj plumbing.EncodedObject) {
+	f.progress.add()
+	f.report.Objects++
+
+	typ := obj.Type()
+	f.objects[h] = typ
+	if f.o.ConnectivityOnly && typ == plumbing.BlobObject {
+		return
+	}
+
+	content, err := readObjectContent(obj)
+	if err != nil {
+		f.problem(FsckCorruptObject, h, typ, pack, "%s", err)
+		return
+	}
+
+	if !f.o.ConnectivityOnly {
+		if actual := plumbing.ComputeHash(typ, content); actual != h {
+			f.problem(FsckHashMismatch, h, typ, pack, "content hashes to %s", actual)
+			return
+		}
+	}
+
+	links, msg := fsckObject(typ, content, f.o.Strict)
+	if msg != "" && !f.o.ConnectivityOnly {
+		f.problem(FsckBadObject, h, typ, pack, "%s", msg)
+	}
+
+	if len(links) > 0 {
+		f.links[h] = links
+	}
+}
+
+func readObjectContent(obj plumbing.EncodedObject) (content []byte, err error) {
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	return io.ReadAll(r)
+}
+
+// fsckLink is an object to walk, along with where it is referenced from.
+type fsckLink struct {
+	hash plumbing.Hash
+	from string
+}
+
+// checkConnectivity walks the objects from the references, their reflogs
+// and the index, reporting the missing objects, then reports the dangling
+// ones.
+func (f *fsck) checkConnectivity() error {
+	pending, err := f.roots()
+	if err != nil {
+		return err
+	}
+
+	missing := make(map[plumbing.Hash]string)
+	reachable := make(map[plumbing.Hash]bool)
+	for len(pending) > 0 {
+		l := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[l.hash] {
+			continue
+		}
+
+		if _, ok := missing[l.hash]; ok {
+			continue
+		}
+
+		typ, links, err := f.objectLinks(l.hash)
+		if err == plumbing.ErrObjectNotFound {
+			missing[l.hash] = l.from
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		reachable[l.hash] = true
+		for _, h := range links {
+			pending = append(pending, fsckLink{h, fmt.Sprintf("%s %s", typ, l.hash)})
+		}
+	}
+
+	// The unreachable objects may reference missing objects too.
+	referenced := make(map[plumbing.Hash]bool)
+	for h, links := range f.links {
+		for _, l := range links {
+			referenced[l] = true
+			if reachable[l] {
+				continue
+			}
+
+			if _, ok := missing[l]; ok {
+				continue
+			}
+
+			if _, ok := f.objects[l]; ok {
+				continue
+			}
+
+			if err := f.r.Storer.HasEncodedObject(l); err == plumbing.ErrObjectNotFound {
+				missing[l] = fmt.Sprintf("%s %s", f.objects[h], h)
+			}
+		}
+	}
+
+	hashes := make([]plumbing.Hash, 0, len(missing))
+	for h := range missing {
+		hashes = append(hashes, h)
+	}
+
+	plumbing.HashesSort(hashes)
+	for _, h := range hashes {
+		f.problem(FsckMissingObject, h, plumbing.InvalidObject, plumbing.ZeroHash, "referenced by %s", missing[h])
+	}
+
+	var dangling []plumbing.Hash
+	for h := range f.objects {
+		if !reachable[h] && !referenced[h] {
+			dangling = append(dangling, h)
+		}
+	}
+
+	plumbing.HashesSort(dangling)
+	for _, h := range dangling {
+		f.problem(FsckDanglingObject, h, f.objects[h], plumbing.ZeroHash, "")
+	}
+
+	if f.progress.w != nil {
+		fmt.Fprintf(f.progress.w, "Checking connectivity: %d, done.\n", len(reachable))
+	}
+
+	return nil
+}
+
+// roots returns the objects the references, their reflogs and the entries
+// of the index point to.
+func (f *fsck) roots() ([]fsckLink, error) {
+	var roots []fsckLink
+	names := []plumbing.ReferenceName{plumbing.HEAD}
+	refs, err := f.r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() != plumbing.HEAD {
+			names = append(names, ref.Name())
+		}
+
+		if ref.Type() == plumbing.HashReference {
+			roots = append(roots, fsckLink{ref.Hash(), ref.Name().String()})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if head, err := f.r.Storer.Reference(plumbing.HEAD); err == nil && head.Type() == plumbing.HashReference {
+		roots = append(roots, fsckLink{head.Hash(), plumbing.HEAD.String()})
+	}
+
+	if rr, ok := f.r.Storer.(storer.ReflogReader); ok {
+		for _, name := range names {
+			entries, err := rr.Reflog(name)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range entries {
+				for _, h := range []plumbing.Hash{e.Old, e.New} {
+					if !h.IsZero() {
+						roots = append(roots, fsckLink{h, "reflog of " + name.String()})
+					}
+				}
+			}
+		}
+	}
+
+	idx, err := f.r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Mode != filemode.Submodule {
+			roots = append(roots, fsckLink{e.Hash, "index entry " + e.Name})
+		}
+	}
+
+	return roots, nil
+}
+
+// objectLinks returns the type of the object and the objects it references,
+// reading it if it was not checked, such as the objects of the alternates.
+func (f *fsck) objectLinks(h plumbing.Hash) (plumbing.ObjectType, []plumbing.Hash, error) {
+	if typ, ok := f.objects[h]; ok {
+		return typ, f.links[h], nil
+	}
+
+	obj, err := f.r.Storer.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return plumbing.InvalidObject, nil, err
+	}
+
+	typ := obj.Type()
+	f.objects[h] = typ
+	if typ == plumbing.BlobObject {
+		return typ, nil, nil
+	}
+
+	content, err := readObjectContent(obj)
+	if err != nil {
+		return typ, nil, err
+	}
+
+	links, _ := fsckObject(typ, content, false)
+	f.links[h] = links
+	return typ, links, nil
+}
+
+// fsckObject returns the objects referenced by the commit, tree or tag, and
+// the first error found in it, if it is not well formed.
+func fsckObject(typ plumbing.ObjectType, content []byte, strict bool) ([]plumbing.Hash, string) {
+	c := &objfsck.Checker{Strict: strict}
+	links, problems := c.Check(typ, content)
+	if p, ok := objfsck.FirstError(problems); ok {
+		return links, p.Message
+	}
+
+	return links, ""
+}
+
+// fsckProgress writes the progress of Fsck, as git does.
+type fsckProgress struct {
+	w       io.Writer
+	title   string
+	total   int
+	count   int
+	percent int
+}
+
+func (p *fsckProgress) start(title string, total int) {
+	p.title, p.total, p.count, p.percent = title, total, 0, -1
+	p.update()
+}
+
+func (p *fsckProgress) add() {
+	p.count++
+	p.update()
+}
+
+func (p *fsckProgress) update() {
+	if p.w == nil {
+		return
+	}
+
+	percent := 100
+	if p.total > 0 {
+		percent = p.count * 100 / p.total
+	}
+
+	if percent != p.percent {
+		p.percent = percent
+		fmt.Fprintf(p.w, "%s: %3d%% (%d/%d)\r", p.title, percent, p.count, p.total)
+	}
+}
+
+func (p *fsckProgress) done() {
+	if p.w != nil {
+		fmt.Fprintf(p.w, "%s: 100%% (%d/%d), done.\n", p.title, p.count, p.total)
+	}
+}
@@ -0,0 +1,301 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type FsckSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&FsckSuite{})
+
+// problems returns the problems of the given type found by Fsck.
+func (s *FsckSuite) problems(c *C, r *Repository, o FsckOptions, t FsckProblemType) []FsckProblem {
+	report, err := r.Fsck(o)
+	c.Assert(err, IsNil)
+
+	var problems []FsckProblem
+	for _, p := range report.Problems {
+		if p.Type == t {
+			problems = append(problems, p)
+		}
+	}
+
+	return problems
+}
+
+func (s *FsckSuite) storeRaw(c *C, r *Repository, t plumbing.ObjectType, content string) plumbing.Hash {
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(t)
+	w, err := obj.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte(content))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	h, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+	return h
+}
+
+func (s *FsckSuite) TestFsck(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	progress := bytes.NewBuffer(nil)
+	report, err := r.Fsck(FsckOptions{Progress: progress})
+	c.Assert(err, IsNil)
+	c.Assert(report.Objects, Equals, 31)
+	c.Assert(report.Problems, HasLen, 0)
+	c.Assert(report.HasErrors(), Equals, false)
+	c.Assert(strings.HasSuffix(progress.String(), "Checking objects: 100% (31/31), done.\nChecking connectivity: 31, done.\n"), Equals, true,
+		Commentf("%q", progress.String()))
+}
+
+func (s *FsckSuite) TestFsckMemory(c *C) {
+	r := s.NewRepositoryWithEmptyWorktree(fixtures.Basic().One())
+	dangling := s.storeRaw(c, r, plumbing.BlobObject, "dangling")
+
+	report, err := r.Fsck(FsckOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(report.Objects, Equals, 32)
+	c.Assert(report.Problems, DeepEquals, []FsckProblem{
+		{Type: FsckDanglingObject, Hash: dangling, ObjectType: plumbing.BlobObject},
+	})
+	c.Assert(report.HasErrors(), Equals, false)
+}
+
+func (s *FsckSuite) TestFsckLooseObjects(c *C) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	files := map[string]plumbing.Hash{}
+	for _, name := range []string{"foo", "bar", "qux"} {
+		c.Assert(os.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0o644), IsNil)
+		files[name], err = w.Add(name)
+		c.Assert(err, IsNil)
+	}
+
+	_, err = w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	report, err := r.Fsck(FsckOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(report.Objects, Equals, 5)
+	c.Assert(report.Problems, HasLen, 0)
+
+	objectPath := func(h plumbing.Hash) string {
+		return filepath.Join(dir, ".git", "objects", h.String()[:2], h.String()[2:])
+	}
+
+	// qux has the content of foo, and bar is removed.
+	content, err := os.ReadFile(objectPath(files["foo"]))
+	c.Assert(err, IsNil)
+	c.Assert(os.Chmod(objectPath(files["qux"]), 0o644), IsNil)
+	c.Assert(os.WriteFile(objectPath(files["qux"]), content, 0o644), IsNil)
+	c.Assert(os.Remove(objectPath(files["bar"])), IsNil)
+	dangling := s.storeRaw(c, r, plumbing.BlobObject, "dangling")
+
+	c.Assert(s.problems(c, r, FsckOptions{}, FsckHashMismatch), DeepEquals, []FsckProblem{{
+		Type:       FsckHashMismatch,
+		Hash:       files["qux"],
+		ObjectType: plumbing.BlobObject,
+		Message:    "content hashes to " + files["foo"].String(),
+	}})
+
+	c.Assert(s.problems(c, r, FsckOptions{}, FsckDanglingObject), DeepEquals, []FsckProblem{
+		{Type: FsckDanglingObject, Hash: dangling, ObjectType: plumbing.BlobObject},
+	})
+
+	expected := []FsckProblem{{
+		Type:       FsckMissingObject,
+		Hash:       files["bar"],
+		ObjectType: plumbing.InvalidObject,
+		Message:    "referenced by index entry bar",
+	}}
+	c.Assert(s.problems(c, r, FsckOptions{}, FsckMissingObject), DeepEquals, expected)
+
+	// The content of the blobs is not read.
+	report, err = r.Fsck(FsckOptions{ConnectivityOnly: true})
+	c.Assert(err, IsNil)
+	c.Assert(report.Problems, DeepEquals, append(expected, FsckProblem{
+		Type: FsckDanglingObject, Hash: dangling, ObjectType: plumbing.BlobObject,
+	}))
+}
+
+func (s *FsckSuite) TestFsckBadObjects(c *C) {
+	r, err := PlainInit(c.MkDir(), true)
+	c.Assert(err, IsNil)
+
+	blob := s.storeRaw(c, r, plumbing.BlobObject, "foo")
+	tree := func(entries ...string) plumbing.Hash {
+		var content string
+		for _, e := range entries {
+			content += e + "\x00" + string(blob[:])
+		}
+
+		return s.storeRaw(c, r, plumbing.TreeObject, content)
+	}
+
+	valid := tree("100644 a", "100644 b.txt", "40000 b")
+	unsorted := tree("100644 b", "100644 a")
+	duplicated := tree("100644 a", "40000 a")
+	badMode := tree("100666 a")
+	groupWritable := tree("100664 a")
+	zeroPadded := tree("040000 a")
+	dotGit := tree("40000 .GIT")
+
+	commit := func(header string) plumbing.Hash {
+		return s.storeRaw(c, r, plumbing.CommitObject, fmt.Sprintf("tree %s\n%s\nmessage\n", valid, header))
+	}
+
+	author := "author foo <foo@foo.foo> 1600000000 +0200\n"
+	committer := "committer foo <foo@foo.foo> 1600000000 +0200\n"
+	goodCommit := commit(author + committer)
+	noAuthor := commit(committer)
+	badEmail := commit("author foo <foo 1600000000 +0200\n" + committer)
+	badDate := commit(author + "committer foo <foo@foo.foo> 01600000000 +0200\n")
+	badTimezone := commit(author + "committer foo <foo@foo.foo> 1600000000 +02\n")
+
+	goodTag := s.storeRaw(c, r, plumbing.TagObject, fmt.Sprintf(
+		"object %s\ntype commit\ntag v1\ntagger foo <foo@foo.foo> 1600000000 +0200\n\nv1\n", goodCommit))
+	badType := s.storeRaw(c, r, plumbing.TagObject, fmt.Sprintf(
+		"object %s\ntype foo\ntag v2\n\nv2\n", goodCommit))
+
+	for _, h := range []plumbing.Hash{unsorted, duplicated, badMode, groupWritable, zeroPadded, dotGit,
+		noAuthor, badEmail, badDate, badTimezone, goodTag, badType} {
+		ref := plumbing.NewHashReference(plumbing.ReferenceName("refs/tags/"+h.String()), h)
+		c.Assert(r.Storer.SetReference(ref), IsNil)
+	}
+
+	messages := func(strict bool) map[plumbing.Hash]string {
+		m := make(map[plumbing.Hash]string)
+		for _, p := range s.problems(c, r, FsckOptions{Strict: strict}, FsckBadObject) {
+			m[p.Hash] = p.Message
+		}
+
+		return m
+	}
+
+	expected := map[plumbing.Hash]string{
+		unsorted:    "entries not properly sorted",
+		duplicated:  `duplicate entry "a"`,
+		badMode:     `bad file mode 100666 of "a"`,
+		noAuthor:    "missing author",
+		badEmail:    "invalid author: bad email",
+		badDate:     "invalid committer: zero padded date",
+		badTimezone: "invalid committer: bad timezone",
+		badType:     "invalid type",
+	}
+	c.Assert(messages(false), DeepEquals, expected)
+
+	expected[groupWritable] = `bad file mode 100664 of "a"`
+	expected[zeroPadded] = `zero padded file mode of "a"`
+	expected[dotGit] = "entry named .git"
+	c.Assert(messages(true), DeepEquals, expected)
+}
+
+func (s *FsckSuite) TestFsckPack(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	pack := plumbing.NewHash(fixtures.Basic().One().PackfileHash)
+	idxName := path.Join("objects", "pack", fmt.Sprintf("pack-%s.idx", pack))
+	packName := path.Join("objects", "pack", fmt.Sprintf("pack-%s.pack", pack))
+
+	open := func() *Repository {
+		r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+		c.Assert(err, IsNil)
+		return r
+	}
+
+	corrupt := func(name string, offset int) []byte {
+		content, err := util.ReadFile(fs, name)
+		c.Assert(err, IsNil)
+		corrupted := append([]byte(nil), content...)
+		corrupted[offset] ^= 0xff
+		c.Assert(util.WriteFile(fs, name, corrupted, 0o644), IsNil)
+		return content
+	}
+
+	// A byte of the offsets of the idx file.
+	original := corrupt(idxName, 8+256*4+31*20+31*4+31*4-1)
+	c.Assert(s.problems(c, open(), FsckOptions{}, FsckBadPackIndex), DeepEquals, []FsckProblem{{
+		Type:       FsckBadPackIndex,
+		Hash:       pack,
+		ObjectType: plumbing.InvalidObject,
+		Pack:       pack,
+		Message:    "index checksum mismatch",
+	}})
+	c.Assert(util.WriteFile(fs, idxName, original, 0o644), IsNil)
+
+	// The last byte of the trailing checksum of the packfile.
+	content, err := util.ReadFile(fs, packName)
+	c.Assert(err, IsNil)
+	corrupt(packName, len(content)-1)
+	report, err := open().Fsck(FsckOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(report.Problems, DeepEquals, []FsckProblem{{
+		Type:       FsckBadPack,
+		Hash:       pack,
+		ObjectType: plumbing.InvalidObject,
+		Pack:       pack,
+		Message:    "packfile checksum mismatch",
+	}})
+
+	report, err = open().Fsck(FsckOptions{ConnectivityOnly: true})
+	c.Assert(err, IsNil)
+	c.Assert(report.Problems, HasLen, 0)
+}
+
+func (s *FsckSuite) TestFsckReflog(c *C) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, true)
+	c.Assert(err, IsNil)
+
+	blob := s.storeRaw(c, r, plumbing.BlobObject, "foo")
+	tree := s.storeRaw(c, r, plumbing.TreeObject, "100644 foo\x00"+string(blob[:]))
+	sig := defaultSignature()
+	commit := &object.Commit{Author: *sig, Committer: *sig, Message: "foo\n", TreeHash: tree}
+	obj := r.Storer.NewEncodedObject()
+	c.Assert(commit.Encode(obj), IsNil)
+	first, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	commit.ParentHashes = []plumbing.Hash{first}
+	commit.Message = "bar\n"
+	obj = r.Storer.NewEncodedObject()
+	c.Assert(commit.Encode(obj), IsNil)
+	second, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	c.Assert(r.Storer.SetReference(plumbing.NewHashReference("refs/heads/master", first)), IsNil)
+	c.Assert(s.problems(c, r, FsckOptions{}, FsckDanglingObject), DeepEquals, []FsckProblem{
+		{Type: FsckDanglingObject, Hash: second, ObjectType: plumbing.CommitObject},
+	})
+
+	// The commits of the reflogs are reachable.
+	entry := fmt.Sprintf("%s %s foo <foo@foo.foo> 1600000000 +0200\treset: moving to HEAD~\n", second, first)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "logs", "refs", "heads"), 0o755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "logs", "refs", "heads", "master"), []byte(entry), 0o644), IsNil)
+	c.Assert(s.problems(c, r, FsckOptions{}, FsckDanglingObject), HasLen, 0)
+}
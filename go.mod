@@ -4,7 +4,6 @@ module github.com/go-git/go-git/v5
 go 1.21
 
 require (
-	dario.cat/mergo v1.0.0
 	github.com/ProtonMail/go-crypto v1.1.5
 	github.com/armon/go-socks5 v0.0.0-20160902184237-e75332964ef5
 	github.com/elazarl/goproxy v1.4.0
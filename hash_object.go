@@ -0,0 +1,273 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// ErrObjectSizeMismatch is returned by HashObject when the content read
+// doesn't have the given size.
+var ErrObjectSizeMismatch = errors.New("object size doesn't match its content")
+
+// HashObject returns the hash of the object with the given type and content,
+// as git hash-object does, storing the object if write is true. The content
+// is streamed, so size must be its size, or negative if unknown, the content
+// being then read in memory.
+//
+// The content is hashed as it is, see Worktree.HashObject to apply the
+// conversions git add does to the content of a file.
+func (r *Repository) HashObject(rd io.Reader, size int64, t plumbing.ObjectType, write bool) (plumbing.Hash, error) {
+	if !t.Valid() || t == plumbing.OFSDeltaObject || t == plumbing.REFDeltaObject {
+		return plumbing.ZeroHash, plumbing.ErrInvalidType
+	}
+
+	if size < 0 {
+		content, err := io.ReadAll(rd)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		rd, size = bytes.NewReader(content), int64(len(content))
+	}
+
+	if !write {
+		h := plumbing.NewHasher(t, size)
+		if err := copyObjectContent(h, rd, size); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		return h.Sum(), nil
+	}
+
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(t)
+	obj.SetSize(size)
+
+	if err := writeObjectContent(obj, rd, size); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return r.Storer.SetEncodedObject(obj)
+}
+
+func writeObjectContent(obj plumbing.EncodedObject, rd io.Reader, size int64) (err error) {
+	w, err := obj.Writer()
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(w, &err)
+	return copyObjectContent(w, rd, size)
+}
+
+// copyObjectContent copies the content of an object, failing if it doesn't
+// have the given size.
+func copyObjectContent(dst io.Writer, src io.Reader, size int64) error {
+	n, err := io.CopyN(dst, src, size)
+	if err == io.EOF || n != size {
+		return ErrObjectSizeMismatch
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if n, err := src.Read(make([]byte, 1)); n != 0 {
+		return ErrObjectSizeMismatch
+	} else if err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+// HashObject returns the hash of the blob of the content of the file at the
+// given path, relative to the root of the worktree, as git hash-object
+// --path does, storing the blob if write is true. The file doesn't need to
+// exist, the content is given.
+//
+// The end of lines of the content are converted as git add does, following
+// the text, eol and crlf gitattributes of the path and the core.autocrlf
+// config. The filter drivers are not run.
+func (w *Worktree) HashObject(path string, rd io.Reader, write bool) (plumbing.Hash, error) {
+	content, err := io.ReadAll(rd)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	name, _, err := w.worktreePath(path)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	action, err := w.crlfAction(name)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	convert := action.convertsToLF(content)
+	if convert && action == crlfAuto {
+		crlf, err := w.crlfInIndex(name)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		convert = !crlf
+	}
+
+	if convert {
+		content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	}
+
+	return w.r.HashObject(bytes.NewReader(content), int64(len(content)), plumbing.BlobObject, write)
+}
+
+// crlfAction is the end of line conversion of a file, as git defines it
+// from its gitattributes and the config.
+type crlfAction int
+
+const (
+	// crlfBinary doesn't convert the end of lines.
+	crlfBinary crlfAction = iota
+	// crlfText converts the end of lines of the file, which is text.
+	crlfText
+	// crlfAuto converts the end of lines of the file, if it is text.
+	crlfAuto
+)
+
+// convertsToLF tells whether the CRLF end of lines of the content are
+// converted to LF when the content is added.
+func (a crlfAction) convertsToLF(content []byte) bool {
+	if a == crlfBinary || !bytes.Contains(content, []byte("\r\n")) {
+		return false
+	}
+
+	return a == crlfText || !isBinaryContent(content)
+}
+
+// crlfAction returns the end of line conversion of the file, following the
+// text attribute, then the legacy crlf one, then the eol one, and finally the
+// core.autocrlf config.
+func (w *Worktree) crlfAction(name string) (crlfAction, error) {
+	attrs, err := w.CheckAttributes(name, "text", "crlf", "eol")
+	if err != nil {
+		return crlfBinary, err
+	}
+
+	if a, ok := attrs["text"]; ok {
+		switch {
+		case a.IsSet():
+			return crlfText, nil
+		case a.IsUnset():
+			return crlfBinary, nil
+		case a.Value() == "auto":
+			return crlfAuto, nil
+		}
+	}
+
+	if a, ok := attrs["crlf"]; ok {
+		switch {
+		case a.IsSet(), a.Value() == "input":
+			return crlfText, nil
+		case a.IsUnset():
+			return crlfBinary, nil
+		}
+	}
+
+	if a, ok := attrs["eol"]; ok && (a.Value() == "lf" || a.Value() == "crlf") {
+		return crlfText, nil
+	}
+
+	cfg, err := w.r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return crlfBinary, err
+	}
+
+	switch cfg.GetString("core.autocrlf") {
+	case "input":
+		return crlfAuto, nil
+	case "":
+		return crlfBinary, nil
+	}
+
+	if autocrlf, err := cfg.GetBool("core.autocrlf"); err == nil && autocrlf {
+		return crlfAuto, nil
+	}
+
+	return crlfBinary, nil
+}
+
+// crlfInIndex tells whether the blob of the file in the index has CRs, in
+// which case git doesn't convert the end of lines of the automatically
+// detected text files, so they aren't all changed by the next commit.
+func (w *Worktree) crlfInIndex(name string) (bool, error) {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return false, err
+	}
+
+	e, err := idx.Entry(name)
+	if err == index.ErrEntryNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	blob, err := w.r.BlobObject(e.Hash)
+	if err != nil {
+		return false, err
+	}
+
+	rd, err := blob.Reader()
+	if err != nil {
+		return false, err
+	}
+
+	defer rd.Close()
+
+	content, err := io.ReadAll(rd)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.IndexByte(content, '\r') >= 0, nil
+}
+
+// isBinaryContent tells whether the content is binary, as git guesses it for
+// the end of line conversions: it has NULs or lone CRs, or too many non
+// printable characters.
+func isBinaryContent(content []byte) bool {
+	var printable, nonPrintable int
+	for i, c := range content {
+		switch {
+		case c == '\r':
+			if i+1 >= len(content) || content[i+1] != '\n' {
+				return true
+			}
+		case c == 0:
+			return true
+		case c == '\n':
+		case c == 127:
+			nonPrintable++
+		case c < 32:
+			switch c {
+			case '\b', '\t', '\033', '\014':
+				printable++
+			default:
+				nonPrintable++
+			}
+		default:
+			printable++
+		}
+	}
+
+	return printable>>7 < nonPrintable
+}
@@ -0,0 +1,135 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type HashObjectSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&HashObjectSuite{})
+
+func (s *HashObjectSuite) TestHashObject(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	content := []byte("hello world\n")
+	expected := plumbing.ComputeHash(plumbing.BlobObject, content)
+
+	h, err := r.HashObject(bytes.NewReader(content), int64(len(content)), plumbing.BlobObject, false)
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, expected)
+
+	_, err = r.BlobObject(h)
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+
+	h, err = r.HashObject(bytes.NewReader(content), -1, plumbing.BlobObject, true)
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, expected)
+
+	blob, err := r.BlobObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(blob.Size, Equals, int64(len(content)))
+}
+
+func (s *HashObjectSuite) TestHashObjectWrite(c *C) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, true)
+	c.Assert(err, IsNil)
+
+	content := []byte("hello world\n")
+	h, err := r.HashObject(bytes.NewReader(content), int64(len(content)), plumbing.BlobObject, true)
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, plumbing.ComputeHash(plumbing.BlobObject, content))
+
+	obj, err := r.Storer.EncodedObject(plumbing.BlobObject, h)
+	c.Assert(err, IsNil)
+	c.Assert(obj.Size(), Equals, int64(len(content)))
+}
+
+func (s *HashObjectSuite) TestHashObjectErrors(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.HashObject(strings.NewReader("foo"), 2, plumbing.BlobObject, false)
+	c.Assert(err, Equals, ErrObjectSizeMismatch)
+
+	_, err = r.HashObject(strings.NewReader("foo"), 4, plumbing.BlobObject, true)
+	c.Assert(err, Equals, ErrObjectSizeMismatch)
+
+	_, err = r.HashObject(strings.NewReader("foo"), 3, plumbing.OFSDeltaObject, false)
+	c.Assert(err, Equals, plumbing.ErrInvalidType)
+}
+
+func (s *HashObjectSuite) TestWorktreeHashObject(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	c.Assert(os.WriteFile(filepath.Join(dir, ".gitattributes"), []byte(
+		"*.txt text\n*.bin binary\n*.auto text=auto\n*.lf eol=lf\n*.crlf -crlf\n",
+	), 0644), IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	contents := []string{"foo\r\nbar\r\n", "foo\r\nbar\rbaz\r\n", "foo\r\n\x00bar\r\n", "foo\nbar\n"}
+	for _, autocrlf := range []string{"false", "true", "input"} {
+		c.Assert(r.SetConfigValue(config.LocalScope, "core.autocrlf", autocrlf), IsNil)
+		for _, name := range []string{"a.txt", "a.bin", "a.auto", "a.lf", "a.crlf", "a.other"} {
+			for _, content := range contents {
+				cmd := exec.Command("git", "-C", dir, "hash-object", "--stdin", "--path", name)
+				cmd.Stdin = strings.NewReader(content)
+				out, err := cmd.Output()
+				c.Assert(err, IsNil)
+
+				h, err := w.HashObject(name, strings.NewReader(content), false)
+				c.Assert(err, IsNil)
+				c.Assert(h.String(), Equals, strings.TrimSpace(string(out)), Commentf("%s %s %q", autocrlf, name, content))
+			}
+		}
+	}
+}
+
+func (s *HashObjectSuite) TestWorktreeHashObjectCRLFInIndex(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	c.Assert(r.SetConfigValue(config.LocalScope, "core.autocrlf", "true"), IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	content := []byte("foo\r\nbar\r\n")
+	h, err := w.HashObject("foo", bytes.NewReader(content), false)
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, plumbing.ComputeHash(plumbing.BlobObject, []byte("foo\nbar\n")))
+
+	// The end of lines of the files with CRs in the index are kept, as git
+	// add does.
+	c.Assert(util.WriteFile(w.Filesystem, "foo", []byte("foo\r\n"), 0644), IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	h, err = w.HashObject("foo", bytes.NewReader(content), false)
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, plumbing.ComputeHash(plumbing.BlobObject, content))
+}
@@ -0,0 +1,642 @@
+package git
+
+import (
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// historyNode is a commit walked by the history simplification, as the
+// limited revision walk of git does.
+type historyNode struct {
+	commit *object.Commit
+	// parents are the parents followed by the walk, the ones the commit is
+	// rewritten to once simplified.
+	parents []plumbing.Hash
+	// treesame tells, for each parent, whether the commit has the same paths
+	// as the parent.
+	treesame []bool
+	// same tells whether the commit has the same paths as its relevant
+	// parents, that is it doesn't change them.
+	same bool
+	// uninteresting is set for the commits excluded by the ancestry path,
+	// which are not walked.
+	uninteresting bool
+	// bottom is set for the commit of the ancestry path.
+	bottom bool
+}
+
+// relevant tells whether the commit is part of the walked history, as
+// opposed to the boundary commits.
+func (n *historyNode) relevant() bool {
+	return !n.uninteresting || n.bottom
+}
+
+// historySimplifier selects the commits of a history limited to some paths
+// and rewrites their parents as git does, following the rules of
+// "History Simplification" in the documentation of git log.
+type historySimplifier struct {
+	r       *Repository
+	o       *LogOptions
+	filter  func(string) bool
+	rewrite bool
+	nodes   map[plumbing.Hash]*historyNode
+}
+
+// simplifiesHistory tells whether the log is computed by a historySimplifier,
+// instead of filtering the walked commits as they are read.
+func (o *LogOptions) simplifiesHistory() bool {
+	return o.HistorySimplification != HistoryDefault || o.RewriteParents || !o.AncestryPath.IsZero()
+}
+
+// logWithHistorySimplification returns the commits of the iterator selected
+// by the history simplification of the options, with their parents
+// rewritten if requested. All the history is walked first.
+func (r *Repository) logWithHistorySimplification(it object.CommitIter, o *LogOptions) (object.CommitIter, error) {
+	s := &historySimplifier{
+		r:       r,
+		o:       o,
+		filter:  historyPathFilter(o),
+		rewrite: o.RewriteParents || o.HistorySimplification == HistorySimplifyMerges,
+		nodes:   make(map[plumbing.Hash]*historyNode),
+	}
+
+	shown, err := s.simplify()
+	if err != nil {
+		it.Close()
+		return nil, err
+	}
+
+	return &historyCommitIter{CommitIter: it, shown: shown, rewrite: s.rewrite}, nil
+}
+
+// historyPathFilter returns the filter of the paths of the options, or nil
+// if the log is not limited to some paths.
+func historyPathFilter(o *LogOptions) func(string) bool {
+	filter := logPathFilter(o.Paths, o.PathFilter)
+	if o.FileName == nil {
+		return filter
+	}
+
+	name := *o.FileName
+	return func(path string) bool {
+		return path == name && (filter == nil || filter(path))
+	}
+}
+
+// simplify returns the commits to show, along with their parents.
+func (s *historySimplifier) simplify() (map[plumbing.Hash][]plumbing.Hash, error) {
+	if err := s.walk(); err != nil {
+		return nil, err
+	}
+
+	if !s.o.AncestryPath.IsZero() {
+		s.limitToAncestryPath()
+	}
+
+	if s.o.HistorySimplification == HistorySimplifyMerges {
+		if err := s.simplifyMerges(); err != nil {
+			return nil, err
+		}
+	}
+
+	shown := make(map[plumbing.Hash][]plumbing.Hash)
+	for h, n := range s.nodes {
+		if !s.show(n) {
+			continue
+		}
+
+		parents := n.parents
+		if s.rewrite && s.o.HistorySimplification != HistorySimplifyMerges {
+			parents = s.rewriteParents(n)
+		}
+
+		shown[h] = parents
+	}
+
+	return shown, nil
+}
+
+// walk walks the history from the starting commits, computing whether each
+// commit changes the paths compared to its parents, and following only the
+// parent a commit doesn't change the paths from, if any, by default.
+func (s *historySimplifier) walk() error {
+	if !s.o.AncestryPath.IsZero() {
+		excluded, err := s.r.ancestors(s.o.AncestryPath)
+		if err != nil {
+			return err
+		}
+
+		for h := range excluded {
+			s.nodes[h] = &historyNode{uninteresting: true, bottom: h == s.o.AncestryPath}
+		}
+	}
+
+	var pending []*object.Commit
+	if s.o.All {
+		commits, err := s.r.refCommits()
+		if err != nil {
+			return err
+		}
+
+		pending = commits
+	} else {
+		h, err := s.r.logStart(s.o.From)
+		if err != nil {
+			return err
+		}
+
+		c, err := s.r.CommitObject(h)
+		if err != nil {
+			return err
+		}
+
+		pending = append(pending, c)
+	}
+
+	for len(pending) > 0 {
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := s.nodes[c.Hash]; ok {
+			continue
+		}
+
+		n := &historyNode{commit: c}
+		s.nodes[c.Hash] = n
+		parents, err := s.visit(n)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range parents {
+			if _, ok := s.nodes[p.Hash]; !ok {
+				pending = append(pending, p)
+			}
+		}
+	}
+
+	return nil
+}
+
+// visit computes whether the commit of the node changes the paths, and the
+// parents to follow, which are returned.
+func (s *historySimplifier) visit(n *historyNode) ([]*object.Commit, error) {
+	tree, err := n.commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	if n.commit.NumParents() == 0 {
+		n.same, err = s.treesame(nil, tree)
+		return nil, err
+	}
+
+	var parents []*object.Commit
+	relevant := false
+	sameRelevant, sameIrrelevant := true, true
+	for _, h := range n.commit.ParentHashes {
+		p, err := s.r.CommitObject(h)
+		if err != nil {
+			return nil, err
+		}
+
+		parentTree, err := p.Tree()
+		if err != nil {
+			return nil, err
+		}
+
+		same, err := s.treesame(parentTree, tree)
+		if err != nil {
+			return nil, err
+		}
+
+		pn, walked := s.nodes[h]
+		isRelevant := !walked || pn.relevant()
+		if same && isRelevant && s.followsTreesameParent() {
+			// Follow only the parent with the same paths.
+			n.parents, n.treesame, n.same = []plumbing.Hash{h}, []bool{true}, true
+			return []*object.Commit{p}, nil
+		}
+
+		n.parents = append(n.parents, h)
+		n.treesame = append(n.treesame, same)
+		parents = append(parents, p)
+		if isRelevant {
+			relevant = true
+			sameRelevant = sameRelevant && same
+		} else {
+			sameIrrelevant = sameIrrelevant && same
+		}
+	}
+
+	if relevant {
+		n.same = sameRelevant
+	} else {
+		n.same = sameIrrelevant
+	}
+
+	return parents, nil
+}
+
+// followsTreesameParent tells whether the walk follows only the first
+// relevant parent a merge has the same paths as. The whole history is walked
+// with an ancestry path, as git does.
+func (s *historySimplifier) followsTreesameParent() bool {
+	return s.o.HistorySimplification == HistoryDefault && s.o.AncestryPath.IsZero()
+}
+
+// treesame tells whether the trees have the same paths, a nil tree being
+// empty. All the commits change the paths if the log is not limited to
+// some paths.
+func (s *historySimplifier) treesame(from, to *object.Tree) (bool, error) {
+	if s.filter == nil {
+		return false, nil
+	}
+
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return false, err
+	}
+
+	for _, ch := range changes {
+		if (ch.From.Name != "" && s.filter(ch.From.Name)) || (ch.To.Name != "" && s.filter(ch.To.Name)) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// limitToAncestryPath excludes the commits which are not descendants of the
+// commit of the ancestry path.
+func (s *historySimplifier) limitToAncestryPath() {
+	descendant := make(map[plumbing.Hash]bool)
+	var isDescendant func(h plumbing.Hash) bool
+	isDescendant = func(h plumbing.Hash) bool {
+		if d, ok := descendant[h]; ok {
+			return d
+		}
+
+		n := s.nodes[h]
+		if n == nil || n.uninteresting {
+			return n != nil && n.bottom
+		}
+
+		descendant[h] = false
+		for _, p := range n.parents {
+			if isDescendant(p) {
+				descendant[h] = true
+				break
+			}
+		}
+
+		return descendant[h]
+	}
+
+	for h, n := range s.nodes {
+		if !n.uninteresting && !isDescendant(h) {
+			n.uninteresting = true
+		}
+	}
+}
+
+// show tells whether the commit of the node is shown: the commits changing
+// the paths are, and the ones that don't only if they are merges needed to
+// connect the history, when the parents are rewritten.
+func (s *historySimplifier) show(n *historyNode) bool {
+	if n.uninteresting {
+		return false
+	}
+
+	if s.filter == nil || !n.same {
+		return true
+	}
+
+	return s.rewrite && s.relevantParents(n.parents) >= 2
+}
+
+func (s *historySimplifier) relevantParents(parents []plumbing.Hash) int {
+	count := 0
+	for _, p := range parents {
+		if s.nodes[p].relevant() {
+			count++
+		}
+	}
+
+	return count
+}
+
+// oneRelevantParent returns the only parent of a commit, or the only
+// relevant one of a merge, if any.
+func (s *historySimplifier) oneRelevantParent(parents []plumbing.Hash) (plumbing.Hash, bool) {
+	if len(parents) == 1 {
+		return parents[0], true
+	}
+
+	var relevant plumbing.Hash
+	found := false
+	for _, p := range parents {
+		if !s.nodes[p].relevant() {
+			continue
+		}
+
+		if found {
+			return plumbing.ZeroHash, false
+		}
+
+		relevant, found = p, true
+	}
+
+	return relevant, found
+}
+
+// rewriteParents returns the parents of the commit of the node rewritten
+// to their nearest shown ancestors.
+func (s *historySimplifier) rewriteParents(n *historyNode) []plumbing.Hash {
+	var parents []plumbing.Hash
+	for _, p := range n.parents {
+		for {
+			pn := s.nodes[p]
+			if pn.uninteresting || !pn.same {
+				break
+			}
+
+			if len(pn.parents) == 0 {
+				p = plumbing.ZeroHash
+				break
+			}
+
+			next, ok := s.oneRelevantParent(pn.parents)
+			if !ok {
+				break
+			}
+
+			p = next
+		}
+
+		if !p.IsZero() {
+			parents = appendUniqueHash(parents, p)
+		}
+	}
+
+	return parents
+}
+
+// simplifyMerges rewrites the parents of the commits, removing the merges
+// no commit changing the paths contributes to, as git log --simplify-merges
+// does. The parents of the commits are simplified before the commits.
+func (s *historySimplifier) simplifyMerges() error {
+	simplified := make(map[plumbing.Hash]plumbing.Hash)
+	for h, n := range s.nodes {
+		if n.uninteresting || len(n.parents) == 0 {
+			simplified[h] = h
+		}
+	}
+
+	for h := range s.nodes {
+		stack := []plumbing.Hash{h}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if _, ok := simplified[top]; ok {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			n := s.nodes[top]
+			ready := true
+			for _, p := range n.parents {
+				if _, ok := simplified[p]; !ok {
+					stack = append(stack, p)
+					ready = false
+				}
+			}
+
+			if !ready {
+				continue
+			}
+
+			stack = stack[:len(stack)-1]
+			to, err := s.simplifyMerge(n, simplified)
+			if err != nil {
+				return err
+			}
+
+			simplified[top] = to
+		}
+	}
+
+	for h, n := range s.nodes {
+		if simplified[h] != h {
+			n.uninteresting = true
+		}
+	}
+
+	return nil
+}
+
+// simplifyMerge rewrites the parents of the commit of the node to their
+// simplifications, and returns the commit it simplifies to.
+func (s *historySimplifier) simplifyMerge(n *historyNode, simplified map[plumbing.Hash]plumbing.Hash) (plumbing.Hash, error) {
+	var parents []plumbing.Hash
+	var treesame []bool
+	for i, p := range n.parents {
+		p = simplified[p]
+		if hashIndex(parents, p) >= 0 {
+			continue
+		}
+
+		parents = append(parents, p)
+		treesame = append(treesame, n.treesame[i])
+	}
+
+	if len(parents) > 1 {
+		marked, err := s.markRedundantParents(parents)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		leaveOneTreesameParent(marked, treesame)
+
+		var kept []plumbing.Hash
+		var keptTreesame []bool
+		for i, p := range parents {
+			if !marked[i] {
+				kept = append(kept, p)
+				keptTreesame = append(keptTreesame, treesame[i])
+			}
+		}
+
+		parents, treesame = kept, keptTreesame
+	}
+
+	n.parents, n.treesame = parents, treesame
+	n.same = s.sameAsRelevantParents(n)
+
+	parent, ok := s.oneRelevantParent(parents)
+	if len(parents) == 0 || !n.same || !ok {
+		return n.commit.Hash, nil
+	}
+
+	return simplified[parent], nil
+}
+
+// markRedundantParents marks the parents which are ancestors of other ones,
+// and the root commits not having any of the paths.
+func (s *historySimplifier) markRedundantParents(parents []plumbing.Hash) ([]bool, error) {
+	commits := make([]*object.Commit, len(parents))
+	for i, p := range parents {
+		c, err := s.r.CommitObject(p)
+		if err != nil {
+			return nil, err
+		}
+
+		commits[i] = c
+	}
+
+	marked := make([]bool, len(parents))
+	for i, c := range commits {
+		if pn := s.nodes[parents[i]]; c.NumParents() == 0 && pn.commit != nil && pn.same {
+			marked[i] = true
+			continue
+		}
+
+		for j, other := range commits {
+			if i == j || marked[j] {
+				continue
+			}
+
+			ancestor, err := c.IsAncestor(other)
+			if err != nil {
+				return nil, err
+			}
+
+			if ancestor {
+				marked[i] = true
+				break
+			}
+		}
+	}
+
+	return marked, nil
+}
+
+// leaveOneTreesameParent unmarks the first parent the commit has the same
+// paths as, if all of them are marked, so the commit is never rewritten to
+// a parent it changes the paths from.
+func leaveOneTreesameParent(marked, treesame []bool) {
+	first := -1
+	for i, same := range treesame {
+		if !same {
+			continue
+		}
+
+		if !marked[i] {
+			return
+		}
+
+		if first == -1 {
+			first = i
+		}
+	}
+
+	if first != -1 {
+		marked[first] = false
+	}
+}
+
+// sameAsRelevantParents tells whether the commit of the node has the same
+// paths as all its relevant parents, or as all its parents if none is.
+func (s *historySimplifier) sameAsRelevantParents(n *historyNode) bool {
+	if len(n.parents) == 0 {
+		return n.same
+	}
+
+	relevant := s.relevantParents(n.parents) > 0
+	for i, p := range n.parents {
+		if s.nodes[p].relevant() == relevant && !n.treesame[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hashIndex(hashes []plumbing.Hash, h plumbing.Hash) int {
+	for i, other := range hashes {
+		if other == h {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func appendUniqueHash(hashes []plumbing.Hash, h plumbing.Hash) []plumbing.Hash {
+	if hashIndex(hashes, h) >= 0 {
+		return hashes
+	}
+
+	return append(hashes, h)
+}
+
+// historyCommitIter returns the commits of the iterator selected by the
+// history simplification, with their parents rewritten if requested.
+type historyCommitIter struct {
+	object.CommitIter
+	shown   map[plumbing.Hash][]plumbing.Hash
+	rewrite bool
+}
+
+func (i *historyCommitIter) Next() (*object.Commit, error) {
+	for {
+		c, err := i.CommitIter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if c, ok := i.commit(c); ok {
+			return c, nil
+		}
+	}
+}
+
+func (i *historyCommitIter) ForEach(cb func(*object.Commit) error) error {
+	for {
+		c, err := i.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := cb(c); err != nil {
+			if err == storer.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// commit returns the commit if it is shown, a copy of it with its parents
+// rewritten if requested, the commit itself being possibly shared with a
+// cache.
+func (i *historyCommitIter) commit(c *object.Commit) (*object.Commit, bool) {
+	parents, ok := i.shown[c.Hash]
+	if !ok {
+		return nil, false
+	}
+
+	if !i.rewrite {
+		return c, true
+	}
+
+	rewritten := *c
+	rewritten.ParentHashes = parents
+	return &rewritten, true
+}
@@ -0,0 +1,228 @@
+package git
+
+import (
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	. "gopkg.in/check.v1"
+)
+
+type HistorySimplificationSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&HistorySimplificationSuite{})
+
+// historyBuilder writes commits with the given files and parents.
+type historyBuilder struct {
+	c    *C
+	r    *Repository
+	when time.Time
+}
+
+func (b *historyBuilder) commit(msg string, files map[string]string, parents ...plumbing.Hash) plumbing.Hash {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tree := &object.Tree{}
+	for _, name := range names {
+		blob := b.r.Storer.NewEncodedObject()
+		blob.SetType(plumbing.BlobObject)
+		w, err := blob.Writer()
+		b.c.Assert(err, IsNil)
+		_, err = w.Write([]byte(files[name]))
+		b.c.Assert(err, IsNil)
+		b.c.Assert(w.Close(), IsNil)
+
+		h, err := b.r.Storer.SetEncodedObject(blob)
+		b.c.Assert(err, IsNil)
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: h})
+	}
+
+	b.when = b.when.Add(time.Minute)
+	sig := object.Signature{Name: "foo", Email: "foo@foo.foo", When: b.when}
+	commit := &object.Commit{Author: sig, Committer: sig, Message: msg, ParentHashes: parents}
+
+	var err error
+	commit.TreeHash, err = b.store(tree)
+	b.c.Assert(err, IsNil)
+
+	h, err := b.store(commit)
+	b.c.Assert(err, IsNil)
+	return h
+}
+
+func (b *historyBuilder) store(o interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	obj := b.r.Storer.NewEncodedObject()
+	if err := o.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return b.r.Storer.SetEncodedObject(obj)
+}
+
+// simplificationRepository returns the repository of the example of the
+// "History Simplification" section of the documentation of git log.
+func (s *HistorySimplificationSuite) simplificationRepository(c *C) (*Repository, string) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, true)
+	c.Assert(err, IsNil)
+
+	b := &historyBuilder{c: c, r: r, when: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	i := b.commit("I", map[string]string{"foo": "asdf", "quux": "quux"})
+	a := b.commit("A", map[string]string{"foo": "foo", "quux": "quux"}, i)
+	bb := b.commit("B", map[string]string{"foo": "foo", "quux": "quux"}, i)
+	m := b.commit("M", map[string]string{"foo": "foo", "quux": "quux"}, a, bb)
+	cc := b.commit("C", map[string]string{"foo": "asdf", "quux": "quux", "c": "c"}, i)
+	n := b.commit("N", map[string]string{"foo": "foobar", "quux": "quux", "c": "c"}, m, cc)
+	d := b.commit("D", map[string]string{"foo": "baz", "quux": "quux"}, i)
+	o := b.commit("O", map[string]string{"foo": "foobarbaz", "quux": "quux", "c": "c"}, n, d)
+	e := b.commit("E", map[string]string{"foo": "asdf", "quux": "xyzzy"}, i)
+	p := b.commit("P", map[string]string{"foo": "foobarbaz", "quux": "quux xyzzy", "c": "c"}, o, e)
+	x := b.commit("X", map[string]string{"side": "side"})
+	y := b.commit("Y", map[string]string{"side": "side 2"}, x)
+	q := b.commit("Q", map[string]string{"foo": "foobarbaz", "quux": "quux xyzzy", "c": "c", "side": "side 2"}, p, y)
+
+	c.Assert(r.Storer.SetReference(plumbing.NewHashReference("refs/heads/master", q)), IsNil)
+	return r, dir
+}
+
+// revList returns the lines of git rev-list, or of the commits of the log
+// with their parents.
+// revList returns the lines written by `git rev-list` in the repository at
+// dir, given the args.
+func revList(c *C, dir string, args ...string) []string {
+	args = append([]string{"-c", "advice.graftFileDeprecated=false", "--git-dir", dir, "rev-list"}, args...)
+	out, err := exec.Command("git", args...).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	return strings.Split(strings.TrimSpace(string(out)), "\n")
+}
+
+// logParents returns the commits of Log as revList does with --parents, the
+// hash of each commit followed by the ones of its parents.
+func logParents(c *C, r *Repository, o *LogOptions) []string {
+	iter, err := r.Log(o)
+	c.Assert(err, IsNil)
+
+	var lines []string
+	c.Assert(iter.ForEach(func(commit *object.Commit) error {
+		line := commit.Hash.String()
+		for _, p := range commit.ParentHashes {
+			line += " " + p.String()
+		}
+
+		lines = append(lines, line)
+		return nil
+	}), IsNil)
+
+	return lines
+}
+
+func (s *HistorySimplificationSuite) TestHistorySimplification(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	r, dir := s.simplificationRepository(c)
+	for _, t := range []struct {
+		args []string
+		o    LogOptions
+	}{
+		{
+			[]string{"--full-history", "HEAD", "--", "foo"},
+			LogOptions{Order: LogOrderCommitterTime, HistorySimplification: HistoryFull},
+		},
+		{
+			[]string{"--parents", "HEAD", "--", "foo"},
+			LogOptions{Order: LogOrderCommitterTime, RewriteParents: true},
+		},
+		{
+			[]string{"--parents", "--full-history", "HEAD", "--", "foo"},
+			LogOptions{Order: LogOrderCommitterTime, HistorySimplification: HistoryFull, RewriteParents: true},
+		},
+		{
+			[]string{"--parents", "--simplify-merges", "HEAD", "--", "foo"},
+			LogOptions{Order: LogOrderTopo, HistorySimplification: HistorySimplifyMerges},
+		},
+		{
+			[]string{"--parents", "--full-history", "HEAD", "--", "side"},
+			LogOptions{Order: LogOrderCommitterTime, HistorySimplification: HistoryFull, RewriteParents: true},
+		},
+		{
+			[]string{"--parents", "--simplify-merges", "HEAD", "--", "quux"},
+			LogOptions{Order: LogOrderTopo, HistorySimplification: HistorySimplifyMerges},
+		},
+	} {
+		t.o.Paths = []string{t.args[len(t.args)-1]}
+		expected := revList(c, dir, t.args...)
+		if !t.o.RewriteParents && t.o.HistorySimplification != HistorySimplifyMerges {
+			// The commits are shown with their original parents.
+			for i, line := range expected {
+				h := plumbing.NewHash(line)
+				commit, err := r.CommitObject(h)
+				c.Assert(err, IsNil)
+
+				expected[i] = h.String()
+				for _, p := range commit.ParentHashes {
+					expected[i] += " " + p.String()
+				}
+			}
+		}
+
+		c.Assert(logParents(c, r, &t.o), DeepEquals, expected, Commentf("%v", t.args))
+	}
+}
+
+func (s *HistorySimplificationSuite) TestAncestryPath(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	dir := c.MkDir()
+	r, err := PlainInit(dir, true)
+	c.Assert(err, IsNil)
+
+	// The example of --ancestry-path in the documentation of git log.
+	b := &historyBuilder{c: c, r: r, when: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	a := b.commit("A", map[string]string{"a": "a"})
+	bb := b.commit("B", map[string]string{"a": "a", "b": "b"}, a)
+	cc := b.commit("C", map[string]string{"a": "a", "b": "b", "c": "c"}, bb)
+	d := b.commit("D", map[string]string{"a": "a", "b": "b", "d": "d"}, bb)
+	e := b.commit("E", map[string]string{"a": "a", "b": "b", "d": "d", "e": "e"}, d)
+	g := b.commit("G", map[string]string{"a": "a", "b": "b", "c": "c", "d": "d", "e": "e"}, cc, e)
+	h := b.commit("H", map[string]string{"a": "a", "b": "b", "c": "c", "d": "d", "e": "e", "h": "h"}, g)
+	f := b.commit("F", map[string]string{"a": "a", "b": "b", "d": "d", "e": "e", "f": "f"}, e)
+	i := b.commit("I", map[string]string{"a": "a", "b": "b", "c": "c", "d": "d", "e": "e", "f": "f", "h": "h"}, h, f)
+	j := b.commit("J", map[string]string{"a": "a", "b": "b", "c": "c", "d": "d", "e": "e", "f": "f", "h": "h", "j": "j"}, i)
+	k := b.commit("K", map[string]string{"a": "a", "k": "k"}, a)
+	l := b.commit("L", map[string]string{"a": "a", "b": "b", "c": "c", "d": "d", "e": "e", "f": "f", "h": "h", "j": "j", "k": "k"}, k, j)
+	m := b.commit("M", map[string]string{"a": "a", "b": "b", "c": "c", "d": "d", "e": "e", "f": "f", "h": "h", "j": "j", "k": "k", "m": "m"}, l)
+
+	for _, t := range []struct {
+		paths []string
+		args  []string
+	}{
+		{nil, []string{"--parents", "--ancestry-path", d.String() + ".." + m.String()}},
+		{[]string{"h", "k"}, []string{"--parents", "--ancestry-path", d.String() + ".." + m.String(), "--", "h", "k"}},
+	} {
+		expected := revList(c, dir, t.args...)
+		c.Assert(logParents(c, r, &LogOptions{
+			From:           m,
+			Order:          LogOrderCommitterTime,
+			AncestryPath:   d,
+			Paths:          t.paths,
+			RewriteParents: t.paths != nil,
+		}), DeepEquals, expected, Commentf("%v", t.args))
+	}
+}
@@ -0,0 +1,267 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+const (
+	hookPrePush      = "pre-push"
+	hookPostCheckout = "post-checkout"
+	hookPostMerge    = "post-merge"
+)
+
+// HookOptions describes how the client-side hooks of a repository are run,
+// the executables named after them in its hooks directory, or in the
+// directory set by core.hooksPath. They are only run by the operations
+// given HookOptions, and only for repositories stored in the filesystem of
+// the OS.
+type HookOptions struct {
+	// NoVerify skips the hooks able to abort the operation, such as
+	// pre-push, as the --no-verify flag of git does.
+	NoVerify bool
+	// Output, if not nil, receives the standard output and error of the
+	// hooks.
+	Output io.Writer
+}
+
+// HookError is returned when a hook exits with an error. For the hooks
+// run before an operation, such as pre-push, the operation is aborted.
+type HookError struct {
+	// Hook is the name of the hook.
+	Hook string
+	// Err is the error of the hook, usually an *exec.ExitError.
+	Err error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %s", e.Hook, e.Err)
+}
+
+// Unwrap returns the error of the hook.
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// hooks runs the hooks of a repository.
+type hooks struct {
+	// dir is the directory of the hooks.
+	dir string
+	// workdir is the directory the hooks are run in, the root of the
+	// worktree, or the git directory of a bare repository.
+	workdir string
+}
+
+// newHooks returns the hooks of the repository of the given storage and
+// worktree, which may be nil. It returns nil if the repository isn't stored
+// in the filesystem of the OS.
+func newHooks(s storage.Storer, wt billy.Filesystem) (*hooks, error) {
+	fs, ok := storageFilesystem(s)
+	if !ok || !isOSFilesystem(fs) {
+		return nil, nil
+	}
+
+	gitDir := fs.Root()
+	h := &hooks{workdir: gitDir}
+	switch {
+	case wt != nil:
+		h.workdir = wt.Root()
+	case filepath.Base(gitDir) == GitDirName:
+		h.workdir = filepath.Dir(gitDir)
+	}
+
+	cfg, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	if path := hooksPath(cfg); path != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(h.workdir, path)
+		}
+
+		h.dir = path
+		return h, nil
+	}
+
+	// The hooks of the linked worktrees are the ones of the main repository.
+	common, err := commonDirectory(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	h.dir = filepath.Join(common, "hooks")
+	return h, nil
+}
+
+// hooksPath returns the core.hooksPath config, with ~ expanded.
+func hooksPath(cfg *config.Config) string {
+	return expandHome(cfg.Raw.Section("core").Option("hooksPath"))
+}
+
+// expandHome returns the given path of a config, with a leading ~ expanded
+// to the home directory of the user.
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+
+	return filepath.FromSlash(path)
+}
+
+// isOSFilesystem returns true if the files of fs are the ones of the
+// filesystem of the OS, as a hook can only be run from there.
+func isOSFilesystem(fs billy.Filesystem) bool {
+	fi, err := fs.Stat("HEAD")
+	if err != nil {
+		return false
+	}
+
+	osfi, err := os.Stat(filepath.Join(fs.Root(), "HEAD"))
+	return err == nil && os.SameFile(fi, osfi)
+}
+
+// run runs the given hook with the given arguments and standard input. As
+// git does, nothing is run if there is no such hook, or if it isn't
+// executable.
+func (h *hooks) run(ctx context.Context, o *HookOptions, name string, stdin io.Reader, args ...string) error {
+	if h == nil || o == nil {
+		return nil
+	}
+
+	path := filepath.Join(h.dir, name)
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+
+	if runtime.GOOS != "windows" && fi.Mode()&0o111 == 0 {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = h.workdir
+	cmd.Stdin = stdin
+	cmd.Stdout = o.Output
+	cmd.Stderr = o.Output
+
+	if err := cmd.Run(); err != nil {
+		return &HookError{Hook: name, Err: err}
+	}
+
+	return nil
+}
+
+// runPrePushHook runs the pre-push hook for a push of the given commands to
+// the given URL. It gets the name of the remote and the URL as arguments,
+// and a line per reference to update on its standard input:
+// <local ref> <local hash> <remote ref> <remote hash>.
+func (r *Remote) runPrePushHook(ctx context.Context, o *PushOptions, url string, cmds []*packp.Command) error {
+	if o.Hooks == nil || o.Hooks.NoVerify {
+		return nil
+	}
+
+	h, err := newHooks(r.s, nil)
+	if err != nil || h == nil {
+		return err
+	}
+
+	localRefs, err := r.references()
+	if err != nil {
+		return err
+	}
+
+	var stdin bytes.Buffer
+	for _, cmd := range cmds {
+		local := "(delete)"
+		if cmd.Action() != packp.Delete {
+			local = pushedLocalRef(o.RefSpecs, localRefs, cmd).String()
+		}
+
+		fmt.Fprintf(&stdin, "%s %s %s %s\n", local, cmd.New, cmd.Name, cmd.Old)
+	}
+
+	name := r.c.Name
+	if name == "" {
+		name = url
+	}
+
+	return h.run(ctx, o.Hooks, hookPrePush, &stdin, name, url)
+}
+
+// pushedLocalRef returns the name of the local reference pushed by the given
+// command, or the pushed hash if it's not pushed from a reference.
+func pushedLocalRef(specs []config.RefSpec, localRefs []*plumbing.Reference, cmd *packp.Command) plumbing.ReferenceName {
+	for _, ref := range localRefs {
+		if ref.Type() != plumbing.HashReference || ref.Hash() != cmd.New {
+			continue
+		}
+
+		// The tags pushed by FollowTags keep their name.
+		if ref.Name() == cmd.Name {
+			return ref.Name()
+		}
+
+		for _, rs := range specs {
+			if rs.IsNegative() || rs.IsDelete() || rs.IsExactSHA1() {
+				continue
+			}
+
+			if rs.Match(ref.Name()) && rs.Dst(ref.Name()) == cmd.Name {
+				return ref.Name()
+			}
+		}
+	}
+
+	return plumbing.ReferenceName(cmd.New.String())
+}
+
+// runPostCheckoutHook runs the post-checkout hook, with the previous and the
+// new HEAD, and whether branches were switched, as arguments.
+func (w *Worktree) runPostCheckoutHook(ctx context.Context, o *HookOptions, from, to plumbing.Hash, branch bool) error {
+	if o == nil {
+		return nil
+	}
+
+	h, err := newHooks(w.r.Storer, w.Filesystem)
+	if err != nil {
+		return err
+	}
+
+	flag := "0"
+	if branch {
+		flag = "1"
+	}
+
+	return h.run(ctx, o, hookPostCheckout, nil, from.String(), to.String(), flag)
+}
+
+// runPostMergeHook runs the post-merge hook, with whether the merge was a
+// squash merge as argument, which it never is.
+func (r *Repository) runPostMergeHook(ctx context.Context, o *HookOptions) error {
+	if o == nil {
+		return nil
+	}
+
+	h, err := newHooks(r.Storer, r.wt)
+	if err != nil {
+		return err
+	}
+
+	return h.run(ctx, o, hookPostMerge, nil, "0")
+}
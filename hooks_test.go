@@ -0,0 +1,225 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+type HooksSuite struct {
+	BaseSuite
+
+	dir string
+	r   *Repository
+}
+
+var _ = Suite(&HooksSuite{})
+
+func (s *HooksSuite) SetUpTest(c *C) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		c.Skip("the hooks of the tests are shell scripts")
+	}
+
+	s.dir = c.MkDir()
+
+	var err error
+	s.r, err = PlainClone(s.dir, false, &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+}
+
+// installHook installs a hook in the given directory, which writes its
+// arguments and its standard input to <dir>/<name>.out, and exits with the
+// given code.
+func (s *HooksSuite) installHook(c *C, dir, name string, code int) string {
+	out := filepath.Join(dir, name+".out")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %q\ncat >> %q\nexit %d\n", out, out, code)
+
+	c.Assert(os.MkdirAll(dir, 0o755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755), IsNil)
+
+	return out
+}
+
+func (s *HooksSuite) hooksDir() string {
+	return filepath.Join(s.dir, GitDirName, "hooks")
+}
+
+func (s *HooksSuite) readOutput(c *C, out string) string {
+	b, err := os.ReadFile(out)
+	c.Assert(err, IsNil)
+
+	return string(b)
+}
+
+func (s *HooksSuite) pushRemote(c *C) string {
+	url := c.MkDir()
+	_, err := PlainInit(url, true)
+	c.Assert(err, IsNil)
+
+	_, err = s.r.CreateRemote(&config.RemoteConfig{Name: "bar", URLs: []string{url}})
+	c.Assert(err, IsNil)
+
+	return url
+}
+
+func (s *HooksSuite) TestPrePush(c *C) {
+	url := s.pushRemote(c)
+	out := s.installHook(c, s.hooksDir(), hookPrePush, 0)
+
+	err := s.r.Push(&PushOptions{
+		RemoteName: "bar",
+		RefSpecs:   []config.RefSpec{"refs/heads/master:refs/heads/main"},
+		Hooks:      &HookOptions{},
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.readOutput(c, out), Equals, fmt.Sprintf(
+		"bar %s\nrefs/heads/master 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/main %s\n",
+		url, plumbing.ZeroHash))
+}
+
+func (s *HooksSuite) TestPrePushAborts(c *C) {
+	url := s.pushRemote(c)
+	s.installHook(c, s.hooksDir(), hookPrePush, 1)
+
+	var output bytes.Buffer
+	err := s.r.Push(&PushOptions{
+		RemoteName: "bar",
+		Hooks:      &HookOptions{Output: &output},
+	})
+
+	var hookErr *HookError
+	c.Assert(errors.As(err, &hookErr), Equals, true)
+	c.Assert(hookErr.Hook, Equals, hookPrePush)
+
+	var exitErr *exec.ExitError
+	c.Assert(errors.As(err, &exitErr), Equals, true)
+	c.Assert(exitErr.ExitCode(), Equals, 1)
+
+	// Nothing was pushed.
+	server, err := PlainOpen(url)
+	c.Assert(err, IsNil)
+	_, err = server.Reference(plumbing.Master, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	err = s.r.Push(&PushOptions{
+		RemoteName: "bar",
+		Hooks:      &HookOptions{NoVerify: true},
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *HooksSuite) TestNoHookOptions(c *C) {
+	s.pushRemote(c)
+	out := s.installHook(c, s.hooksDir(), hookPrePush, 1)
+
+	err := s.r.Push(&PushOptions{RemoteName: "bar"})
+	c.Assert(err, IsNil)
+
+	_, err = os.Stat(out)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *HooksSuite) TestNotExecutable(c *C) {
+	s.pushRemote(c)
+	out := s.installHook(c, s.hooksDir(), hookPrePush, 1)
+	c.Assert(os.Chmod(filepath.Join(s.hooksDir(), hookPrePush), 0o644), IsNil)
+
+	err := s.r.Push(&PushOptions{RemoteName: "bar", Hooks: &HookOptions{}})
+	c.Assert(err, IsNil)
+
+	_, err = os.Stat(out)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *HooksSuite) TestPostCheckout(c *C) {
+	out := s.installHook(c, s.hooksDir(), hookPostCheckout, 0)
+
+	w, err := s.r.Worktree()
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{
+		Hash:  plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9"),
+		Hooks: &HookOptions{},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.readOutput(c, out), Equals,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 35e85108805c84807bc66a02d91535e1e24b38b9 1\n")
+
+	err = w.Checkout(&CheckoutOptions{
+		Branch:   plumbing.Master,
+		Pathspec: []string{"LICENSE"},
+		Hooks:    &HookOptions{},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.readOutput(c, out), Equals,
+		"35e85108805c84807bc66a02d91535e1e24b38b9 35e85108805c84807bc66a02d91535e1e24b38b9 0\n")
+}
+
+func (s *HooksSuite) TestPostCheckoutFails(c *C) {
+	s.installHook(c, s.hooksDir(), hookPostCheckout, 1)
+
+	w, err := s.r.Worktree()
+	c.Assert(err, IsNil)
+
+	second := plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9")
+	err = w.Checkout(&CheckoutOptions{Hash: second, Hooks: &HookOptions{}})
+
+	var hookErr *HookError
+	c.Assert(errors.As(err, &hookErr), Equals, true)
+
+	// The checkout is done anyway.
+	head, err := s.r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, second)
+}
+
+func (s *HooksSuite) TestCoreHooksPath(c *C) {
+	cfg, err := s.r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("core").SetOption("hooksPath", "custom-hooks")
+	c.Assert(s.r.SetConfig(cfg), IsNil)
+
+	ignored := s.installHook(c, s.hooksDir(), hookPostCheckout, 0)
+	out := s.installHook(c, filepath.Join(s.dir, "custom-hooks"), hookPostCheckout, 0)
+
+	w, err := s.r.Worktree()
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{Branch: "refs/heads/branch", Hooks: &HookOptions{}})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.readOutput(c, out), Equals,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 e8d3ffab552895c19b9fcf7aa264d277cde33881 1\n")
+
+	_, err = os.Stat(ignored)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *HooksSuite) TestPostMerge(c *C) {
+	out := s.installHook(c, s.hooksDir(), hookPostMerge, 0)
+
+	old := plumbing.NewBranchReferenceName("old")
+	err := s.r.Storer.SetReference(plumbing.NewHashReference(old,
+		plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9")))
+	c.Assert(err, IsNil)
+	c.Assert(s.r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, old)), IsNil)
+
+	master, err := s.r.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+
+	err = s.r.Merge(*master, MergeOptions{Hooks: &HookOptions{}})
+	c.Assert(err, IsNil)
+	c.Assert(s.readOutput(c, out), Equals, "0\n")
+}
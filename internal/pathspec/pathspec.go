@@ -0,0 +1,231 @@
+// Package pathspec implements the matching of paths with git pathspecs.
+//
+// See https://git-scm.com/docs/gitglossary#Documentation/gitglossary.txt-aiddefpathspecapathspec
+package pathspec
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrUnknownMagic is returned when a pathspec has an unknown or
+	// unsupported magic word.
+	ErrUnknownMagic = errors.New("unsupported pathspec magic")
+)
+
+// magic words of a pathspec, as in ":(glob,exclude)pattern".
+const (
+	magicTop     = "top"
+	magicLiteral = "literal"
+	magicGlob    = "glob"
+	magicICase   = "icase"
+	magicExclude = "exclude"
+)
+
+// Pathspec matches paths, relative to the root of the worktree, with a list
+// of git pathspecs.
+type Pathspec struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// Parse parses the given pathspecs. Without any pathspec, or with only
+// excluding ones, every path is included.
+//
+// The pathspecs can use the magic words top, literal, glob, icase and
+// exclude, in their long form as in ":(glob,exclude)*.js" or in their short
+// form as in ":!*.js". Without the glob magic, the wildcards match slashes,
+// as in git: "*.js" matches "a/b.js". With it, they don't, and "**" matches
+// any number of directories.
+func Parse(specs ...string) (*Pathspec, error) {
+	ps := &Pathspec{}
+	for _, spec := range specs {
+		re, exclude, err := parse(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if exclude {
+			ps.exclude = append(ps.exclude, re)
+		} else {
+			ps.include = append(ps.include, re)
+		}
+	}
+
+	return ps, nil
+}
+
+// Match returns whether the given path, with forward slashes, is matched
+// by the pathspecs. A path is matched if a pathspec matches it, or one of
+// its leading directories.
+func (ps *Pathspec) Match(name string) bool {
+	for _, re := range ps.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	if len(ps.include) == 0 {
+		return true
+	}
+
+	for _, re := range ps.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parse(spec string) (*regexp.Regexp, bool, error) {
+	magic, pattern, err := parseMagic(spec)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pattern = strings.TrimPrefix(path.Clean("/"+pattern), "/")
+	dirOnly := strings.HasSuffix(spec, "/") && pattern != ""
+
+	var expr string
+	switch {
+	case magic[magicLiteral]:
+		expr = regexp.QuoteMeta(pattern)
+	case magic[magicGlob]:
+		expr = translate(pattern, true)
+	default:
+		expr = translate(pattern, false)
+	}
+
+	// The pathspecs match the leading directories too, as "dir" matches
+	// everything under it.
+	switch {
+	case pattern == "":
+		expr = ".*"
+	case dirOnly:
+		expr += "/.*"
+	default:
+		expr += "(?:/.*)?"
+	}
+
+	if magic[magicICase] {
+		expr = "(?i)" + expr
+	}
+
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid pathspec %q: %w", spec, err)
+	}
+
+	return re, magic[magicExclude], nil
+}
+
+// parseMagic returns the magic words of the given pathspec, and the pattern
+// following them.
+func parseMagic(spec string) (map[string]bool, string, error) {
+	magic := make(map[string]bool)
+	if !strings.HasPrefix(spec, ":") {
+		return magic, spec, nil
+	}
+
+	rest := spec[1:]
+	if strings.HasPrefix(rest, "(") {
+		end := strings.IndexByte(rest, ')')
+		if end < 0 {
+			return nil, "", fmt.Errorf("%w: missing ')' in %q", ErrUnknownMagic, spec)
+		}
+
+		for _, word := range strings.Split(rest[1:end], ",") {
+			word = strings.TrimSpace(word)
+			switch word {
+			case magicTop, magicLiteral, magicGlob, magicICase, magicExclude:
+				magic[word] = true
+			case "":
+			default:
+				return nil, "", fmt.Errorf("%w: %q in %q", ErrUnknownMagic, word, spec)
+			}
+		}
+
+		if magic[magicLiteral] && magic[magicGlob] {
+			return nil, "", fmt.Errorf("%w: literal and glob are incompatible in %q", ErrUnknownMagic, spec)
+		}
+
+		return magic, rest[end+1:], nil
+	}
+
+	// The short form is a sequence of magic signatures, optionally
+	// terminated by another colon.
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '/':
+			magic[magicTop] = true
+		case '!', '^':
+			magic[magicExclude] = true
+		case ':':
+			return magic, rest[1:], nil
+		default:
+			return magic, rest, nil
+		}
+
+		rest = rest[1:]
+	}
+
+	return magic, rest, nil
+}
+
+// translate translates the given wildcard pattern to a regular expression.
+// If glob is true, the wildcards don't match slashes, except "**".
+func translate(pattern string, glob bool) string {
+	many, one := ".*", "."
+	if glob {
+		many, one = "[^/]*", "[^/]"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch {
+		case glob && strings.HasPrefix(pattern[i:], "**") &&
+			(i == 0 || pattern[i-1] == '/') &&
+			(i+2 == len(pattern) || pattern[i+2] == '/'):
+			if i+2 == len(pattern) {
+				b.WriteString(".*")
+				i++
+				continue
+			}
+
+			// "**/" matches zero or more directories.
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case ch == '*':
+			b.WriteString(many)
+		case ch == '?':
+			b.WriteString(one)
+		case ch == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(string(ch)))
+				continue
+			}
+
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case ch == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package pathspec
+
+import (
+	"errors"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type PathspecSuite struct{}
+
+var _ = Suite(&PathspecSuite{})
+
+func (s *PathspecSuite) TestMatch(c *C) {
+	cases := []struct {
+		specs   []string
+		match   []string
+		noMatch []string
+	}{{
+		specs: nil,
+		match: []string{"foo", "dir/foo"},
+	}, {
+		specs:   []string{"dir"},
+		match:   []string{"dir", "dir/foo", "dir/sub/foo"},
+		noMatch: []string{"dirfoo", "foo/dir"},
+	}, {
+		specs:   []string{"./dir/"},
+		match:   []string{"dir/foo"},
+		noMatch: []string{"dir"},
+	}, {
+		specs:   []string{"*.js"},
+		match:   []string{"foo.js", "dir/foo.js"},
+		noMatch: []string{"foo.json"},
+	}, {
+		specs:   []string{":(glob)*.js"},
+		match:   []string{"foo.js"},
+		noMatch: []string{"dir/foo.js"},
+	}, {
+		specs:   []string{":(glob)**/*.js"},
+		match:   []string{"foo.js", "dir/foo.js", "dir/sub/foo.js"},
+		noMatch: []string{"foo.go"},
+	}, {
+		specs:   []string{":(glob)dir/**/test/*.go"},
+		match:   []string{"dir/test/foo.go", "dir/a/b/test/foo.go"},
+		noMatch: []string{"dir/test/a/foo.go", "test/foo.go"},
+	}, {
+		specs:   []string{":(glob)dir/**"},
+		match:   []string{"dir/foo", "dir/sub/foo"},
+		noMatch: []string{"foo"},
+	}, {
+		specs:   []string{"dir", ":(exclude)*.min.js"},
+		match:   []string{"dir/foo.js"},
+		noMatch: []string{"dir/foo.min.js", "dir/sub/foo.min.js", "foo.js"},
+	}, {
+		specs:   []string{":!*.md", ":^vendor"},
+		match:   []string{"foo.go", "dir/foo.go"},
+		noMatch: []string{"README.md", "vendor/foo.go"},
+	}, {
+		specs:   []string{":(literal)f*o"},
+		match:   []string{"f*o"},
+		noMatch: []string{"foo"},
+	}, {
+		specs:   []string{":(icase)readme", ":/dir/f?o.[ch]"},
+		match:   []string{"README", "dir/foo.c", "dir/fao.h"},
+		noMatch: []string{"dir/foo.go", "dir/fooo.c"},
+	}, {
+		specs:   []string{"[!a]b"},
+		match:   []string{"bb"},
+		noMatch: []string{"ab"},
+	}}
+
+	for _, tc := range cases {
+		ps, err := Parse(tc.specs...)
+		c.Assert(err, IsNil)
+
+		for _, name := range tc.match {
+			c.Assert(ps.Match(name), Equals, true, Commentf("%v should match %q", tc.specs, name))
+		}
+
+		for _, name := range tc.noMatch {
+			c.Assert(ps.Match(name), Equals, false, Commentf("%v should not match %q", tc.specs, name))
+		}
+	}
+}
+
+func (s *PathspecSuite) TestParseUnknownMagic(c *C) {
+	for _, spec := range []string{":(attr:foo)bar", ":(glob", ":(glob,literal)foo"} {
+		_, err := Parse(spec)
+		c.Assert(errors.Is(err, ErrUnknownMagic), Equals, true, Commentf("%q", spec))
+	}
+}
@@ -0,0 +1,98 @@
+package revision
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// dateLayouts are the layouts of the absolute dates, the ones without a
+// zone are in the local time zone.
+var dateLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// ParseDate parses the date of an @{<date>} statement, or of a config value
+// such as gc.reflogExpire, as git approxidate does for the most common
+// formats: the ISO 8601 dates, "now", "yesterday" and the relative dates
+// such as "2.weeks.ago" or "1 day 3 hours ago".
+func ParseDate(s string, now time.Time) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+
+	// A date without a time keeps the current time of the day, as in git.
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		now = now.In(time.Local)
+		return time.Date(t.Year(), t.Month(), t.Day(),
+			now.Hour(), now.Minute(), now.Second(), 0, time.Local), true
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if len(words) == 0 {
+		return time.Time{}, false
+	}
+
+	t, n := now, -1
+	for _, w := range words {
+		if v, err := strconv.Atoi(w); err == nil {
+			if n != -1 {
+				return time.Time{}, false
+			}
+
+			n = v
+			continue
+		}
+
+		count := n
+		if count == -1 {
+			count = 1
+		}
+
+		switch strings.TrimSuffix(w, "s") {
+		case "now", "ago", "yesterday":
+			if n != -1 {
+				return time.Time{}, false
+			}
+
+			if w == "yesterday" {
+				t = t.AddDate(0, 0, -1)
+			}
+		case "second":
+			t = t.Add(-time.Duration(count) * time.Second)
+		case "minute":
+			t = t.Add(-time.Duration(count) * time.Minute)
+		case "hour":
+			t = t.Add(-time.Duration(count) * time.Hour)
+		case "day":
+			t = t.AddDate(0, 0, -count)
+		case "week":
+			t = t.AddDate(0, 0, -7*count)
+		case "month":
+			t = t.AddDate(0, -count, 0)
+		case "year":
+			t = t.AddDate(-count, 0, 0)
+		default:
+			return time.Time{}, false
+		}
+
+		n = -1
+	}
+
+	if n != -1 {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
@@ -0,0 +1,43 @@
+package revision
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type DateSuite struct{}
+
+var _ = Suite(&DateSuite{})
+
+func (s *DateSuite) TestParseDate(c *C) {
+	now := time.Date(2024, 3, 31, 15, 4, 5, 0, time.Local)
+
+	datas := map[string]time.Time{
+		"2016-12-16T21:42:47Z":      time.Date(2016, 12, 16, 21, 42, 47, 0, time.UTC),
+		"2016-12-16T21:42:47+01:00": time.Date(2016, 12, 16, 20, 42, 47, 0, time.UTC),
+		"2016-12-16 21:42:47 +0100": time.Date(2016, 12, 16, 20, 42, 47, 0, time.UTC),
+		"2016-12-16 21:42:47":       time.Date(2016, 12, 16, 21, 42, 47, 0, time.Local),
+		"2016-12-16 21:42":          time.Date(2016, 12, 16, 21, 42, 0, 0, time.Local),
+		"2016-12-16":                time.Date(2016, 12, 16, 15, 4, 5, 0, time.Local),
+		"now":                       now,
+		"yesterday":                 time.Date(2024, 3, 30, 15, 4, 5, 0, time.Local),
+		"2.weeks.ago":               time.Date(2024, 3, 17, 15, 4, 5, 0, time.Local),
+		"1 day 3 hours ago":         time.Date(2024, 3, 30, 12, 4, 5, 0, time.Local),
+		"1.month.ago":               time.Date(2024, 3, 2, 15, 4, 5, 0, time.Local),
+		"hour.ago":                  time.Date(2024, 3, 31, 14, 4, 5, 0, time.Local),
+		"10.seconds.2.minutes.ago":  time.Date(2024, 3, 31, 15, 1, 55, 0, time.Local),
+		"1.year":                    time.Date(2023, 3, 31, 15, 4, 5, 0, time.Local),
+	}
+
+	for d, expected := range datas {
+		t, ok := ParseDate(d, now)
+		c.Assert(ok, Equals, true, Commentf("%s", d))
+		c.Assert(t.Equal(expected), Equals, true, Commentf("%s: %s", d, t))
+	}
+
+	for _, d := range []string{"", "test", "2.ago", "1 2 days", "2016-13-01", "3 yesterday"} {
+		_, ok := ParseDate(d, now)
+		c.Assert(ok, Equals, false, Commentf("%s", d))
+	}
+}
@@ -72,7 +72,7 @@ type AtPush struct {
 	BranchName string
 }
 
-// AtDate represents @{"2006-01-02T15:04:05Z"}
+// AtDate represents @{"2006-01-02T15:04:05Z"}, @{yesterday}, @{2.weeks.ago}
 type AtDate struct {
 	Date time.Time
 }
@@ -191,32 +191,37 @@ func (p *Parser) validateFullRevision(chunks *[]Revisioner) error {
 				return &ErrInvalidRevision{`reference must be defined once at the beginning`}
 			}
 		case AtDate:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasReference = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{<ISO-8601 date>}, @{<ISO-8601 date>}`}
 		case AtReflog:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasReference = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{<n>}, @{<n>}`}
 		case AtCheckout:
-			if len(*chunks) == 1 {
-				return nil
+			if i == 0 {
+				hasReference = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : @{-<n>}`}
 		case AtUpstream:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasReference = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{upstream}, @{upstream}, <refname>@{u}, @{u}`}
 		case AtPush:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasReference = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{push}, @{push}`}
@@ -315,10 +320,10 @@ func (p *Parser) parseAt() (Revisioner, error) {
 
 			switch {
 			case tok == cbrace:
-				t, err := time.Parse("2006-01-02T15:04:05Z", date)
+				t, ok := ParseDate(date, time.Now())
 
-				if err != nil {
-					return nil, &ErrInvalidRevision{fmt.Sprintf(`wrong date "%s" must fit ISO-8601 format : 2006-01-02T15:04:05Z`, date)}
+				if !ok {
+					return nil, &ErrInvalidRevision{fmt.Sprintf(`wrong date "%s" must be an ISO-8601 date or a relative date like "2.weeks.ago"`, date)}
 				}
 
 				return AtDate{t}, nil
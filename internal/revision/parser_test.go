@@ -113,6 +113,15 @@ func (s *ParserSuite) TestParseWithValidExpression(c *C) {
 			Ref("master"),
 			AtPush{},
 		},
+		"@{u}~2": []Revisioner{
+			AtUpstream{},
+			TildePath{2},
+		},
+		"master@{push}^": []Revisioner{
+			Ref("master"),
+			AtPush{},
+			CaretPath{1},
+		},
 		"master@{2016-12-16T21:42:47Z}": []Revisioner{
 			Ref("master"),
 			AtDate{tim},
@@ -163,6 +172,15 @@ func (s *ParserSuite) TestParseWithValidExpression(c *C) {
 		":3:README": []Revisioner{
 			ColonStagePath{"README", 3},
 		},
+		"HEAD@{1}~2": []Revisioner{
+			Ref("HEAD"),
+			AtReflog{1},
+			TildePath{2},
+		},
+		"@{-1}^": []Revisioner{
+			AtCheckout{1},
+			CaretPath{1},
+		},
 		"master~1^{/update}~5~^^1": []Revisioner{
 			Ref("master"),
 			TildePath{1},
@@ -236,7 +254,7 @@ func (s *ParserSuite) TestParseAtWithValidExpression(c *C) {
 
 func (s *ParserSuite) TestParseAtWithInvalidExpression(c *C) {
 	datas := map[string]error{
-		"{test}": &ErrInvalidRevision{`wrong date "test" must fit ISO-8601 format : 2006-01-02T15:04:05Z`},
+		"{test}": &ErrInvalidRevision{`wrong date "test" must be an ISO-8601 date or a relative date like "2.weeks.ago"`},
 		"{-1":    &ErrInvalidRevision{`missing "}" in @{-n} structure`},
 	}
 
@@ -0,0 +1,12 @@
+package test
+
+import "github.com/go-git/go-git/v5/plumbing/hash"
+
+// Resum sets again the trailing checksum of data, such as the one of the
+// packfile indexes, multi-pack-indexes and bitmaps, once altered by a test.
+func Resum(data []byte) []byte {
+	h := hash.New(hash.CryptoType)
+	h.Write(data[:len(data)-hash.Size])
+	copy(data[len(data)-hash.Size:], h.Sum(nil))
+	return data
+}
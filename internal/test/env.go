@@ -0,0 +1,35 @@
+package test
+
+import "os"
+
+// SetEnv sets the given environment variables, unsetting the ones with an
+// empty value, until the returned function is called, restoring the previous
+// environment, the variables unset before included.
+func SetEnv(env map[string]string) (restore func()) {
+	type value struct {
+		v  string
+		ok bool
+	}
+
+	old := make(map[string]value, len(env))
+	for k, v := range env {
+		prev, ok := os.LookupEnv(k)
+		old[k] = value{prev, ok}
+
+		if v == "" {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, v)
+		}
+	}
+
+	return func() {
+		for k, v := range old {
+			if v.ok {
+				os.Setenv(k, v.v)
+			} else {
+				os.Unsetenv(k)
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package test
+
+// ZeroReader reads an endless stream of zeros, such as the content of the
+// large sparse files.
+type ZeroReader struct{}
+
+func (ZeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
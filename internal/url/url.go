@@ -8,7 +8,9 @@ var (
 	isSchemeRegExp = regexp.MustCompile(`^[^:]+://`)
 
 	// Ref: https://github.com/git/git/blob/master/Documentation/urls.txt#L37
-	scpLikeUrlRegExp = regexp.MustCompile(`^(?:(?P<user>[^@]+)@)?(?P<host>[^:\s]+):(?:(?P<port>[0-9]{1,5}):)?(?P<path>[^\\].*)$`)
+	// The host may be enclosed in square brackets, such as the IPv6 literals,
+	// along with a port, such as [host:port] or [[::1]:port].
+	scpLikeUrlRegExp = regexp.MustCompile(`^(?:(?P<user>[^@]+)@)?(?P<host>\[(?:\[[^\[\]\s]+\]:[0-9]{1,5}|[^\[\]\s]+)\]|[^:\s\[\]]+):(?:(?P<port>[0-9]{1,5}):)?(?P<path>[^\\].*)$`)
 )
 
 // MatchesScheme returns true if the given string matches a URL-like
@@ -24,7 +26,7 @@ func MatchesScpLike(url string) bool {
 }
 
 // FindScpLikeComponents returns the user, host, port and path of the
-// given SCP-like URL.
+// given SCP-like URL. The square brackets enclosing the host are kept.
 func FindScpLikeComponents(url string) (user, host, port, path string) {
 	m := scpLikeUrlRegExp.FindStringSubmatch(url)
 	return m[1], m[2], m[3], m[4]
@@ -34,6 +34,9 @@ func (s *URLSuite) TestMatchesScpLike(c *C) {
 		"git@github.com:_007.git",
 		"git@github.com:_james.git",
 		"git@github.com:_james/bond.git",
+		// IPv6 literals
+		"git@[2001:db8::1]:james/bond",
+		"[::1]:22:james/bond",
 	}
 
 	for _, url := range examples {
@@ -89,6 +92,22 @@ func (s *URLSuite) TestFindScpLikeComponents(c *C) {
 			// Repo path ending with .git and starting with _
 			url: "git@github.com:_james/bond.git", user: "git", host: "github.com", port: "", path: "_james/bond.git",
 		},
+		{
+			// IPv6 literal
+			url: "git@[2001:db8::1]:james/bond", user: "git", host: "[2001:db8::1]", port: "", path: "james/bond",
+		},
+		{
+			// IPv6 literal with port
+			url: "[::1]:22:james/bond", user: "", host: "[::1]", port: "22", path: "james/bond",
+		},
+		{
+			// IPv6 literal with port, in square brackets
+			url: "git@[[::1]:22]:james/bond", user: "git", host: "[[::1]:22]", port: "", path: "james/bond",
+		},
+		{
+			// Path relative to the home directory of another user
+			url: "git@github.com:~james/bond", user: "git", host: "github.com", port: "", path: "~james/bond",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -0,0 +1,124 @@
+package git
+
+import (
+	"os"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/internal/path_util"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/mailmap"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+const defaultMailmapBlob = "HEAD:.mailmap"
+
+// Mailmap returns the mailmap of the repository, which maps the names and
+// emails of authors and committers to their canonical ones. It is read from,
+// in increasing order of priority, the .mailmap file of the tree at HEAD,
+// the blob given by the mailmap.blob property, such as "HEAD:.mailmap", and
+// the file given by the mailmap.file property. The blobs and files that
+// don't exist are ignored, as git does.
+func (r *Repository) Mailmap() (*mailmap.Mailmap, error) {
+	cfg, err := r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return nil, err
+	}
+
+	m := mailmap.New()
+	blobs := []string{defaultMailmapBlob}
+	if blob := cfg.GetString("mailmap.blob"); blob != "" && blob != defaultMailmapBlob {
+		blobs = append(blobs, blob)
+	}
+
+	for _, blob := range blobs {
+		if err := r.readMailmapBlob(m, blob); err != nil {
+			return nil, err
+		}
+	}
+
+	if file := cfg.GetString("mailmap.file"); file != "" {
+		if err := readMailmapFile(m, file); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (r *Repository) readMailmapBlob(m *mailmap.Mailmap, rev string) (err error) {
+	h, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil
+	}
+
+	blob, err := r.BlobObject(*h)
+	if err != nil {
+		return err
+	}
+
+	rd, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(rd, &err)
+	return m.Decode(rd)
+}
+
+func readMailmapFile(m *mailmap.Mailmap, file string) (err error) {
+	file, err = path_util.ReplaceTildeWithHome(file)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return m.Decode(f)
+}
+
+// mapSignature returns the signature with the canonical name and email given
+// by the mailmap.
+func mapSignature(m *mailmap.Mailmap, s object.Signature) object.Signature {
+	s.Name, s.Email = m.Lookup(s.Name, s.Email)
+	return s
+}
+
+// mailmapCommitIter maps the authors and committers of the commits with a
+// mailmap.
+type mailmapCommitIter struct {
+	object.CommitIter
+	mailmap *mailmap.Mailmap
+}
+
+func (i *mailmapCommitIter) Next() (*object.Commit, error) {
+	c, err := i.CommitIter.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return i.mapCommit(c), nil
+}
+
+func (i *mailmapCommitIter) ForEach(cb func(*object.Commit) error) error {
+	return i.CommitIter.ForEach(func(c *object.Commit) error {
+		return cb(i.mapCommit(c))
+	})
+}
+
+// mapCommit returns a copy of the commit with its author and committer
+// mapped, the commit itself being possibly shared with a cache.
+func (i *mailmapCommitIter) mapCommit(c *object.Commit) *object.Commit {
+	mapped := *c
+	mapped.Author = mapSignature(i.mailmap, c.Author)
+	mapped.Committer = mapSignature(i.mailmap, c.Committer)
+	return &mapped
+}
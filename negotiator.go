@@ -0,0 +1,132 @@
+package git
+
+import (
+	"github.com/emirpasic/gods/trees/binaryheap"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// havesNegotiator implements transport.Negotiator walking the history of the
+// local references, newest commits first, as the default negotiator of git.
+// The ancestors of the commits known to be in common with the server are
+// skipped: the ones acknowledged by the server and the ones it advertises.
+type havesNegotiator struct {
+	s storer.EncodedObjectStorer
+	// remote are the commits advertised by the server.
+	remote  map[plumbing.Hash]bool
+	commits map[plumbing.Hash]*object.Commit
+	common  map[plumbing.Hash]bool
+	popped  map[plumbing.Hash]bool
+	queue   *binaryheap.Heap
+	// nonCommon is the number of commits in the queue not known to be in
+	// common, the negotiation is over once it reaches zero.
+	nonCommon int
+}
+
+func newHavesNegotiator(s storer.EncodedObjectStorer, localRefs []*plumbing.Reference,
+	remoteRefs map[plumbing.Hash]bool) *havesNegotiator {
+
+	n := &havesNegotiator{
+		s:       s,
+		remote:  remoteRefs,
+		commits: make(map[plumbing.Hash]*object.Commit),
+		common:  make(map[plumbing.Hash]bool),
+		popped:  make(map[plumbing.Hash]bool),
+		queue: binaryheap.NewWith(func(a, b interface{}) int {
+			if a.(*object.Commit).Committer.When.Before(b.(*object.Commit).Committer.When) {
+				return 1
+			}
+			return -1
+		}),
+	}
+
+	for _, ref := range localRefs {
+		if ref.Type() == plumbing.HashReference {
+			n.push(ref.Hash())
+		}
+	}
+
+	for h := range remoteRefs {
+		n.push(h)
+	}
+
+	return n
+}
+
+// push queues the commit h, if present and not queued yet.
+func (n *havesNegotiator) push(h plumbing.Hash) {
+	if _, ok := n.commits[h]; ok {
+		return
+	}
+
+	c, err := object.GetCommit(n.s, h)
+	if err != nil {
+		// Not a commit, or missing such as the parents of a shallow commit.
+		return
+	}
+
+	n.commits[h] = c
+	n.queue.Push(c)
+	if !n.common[h] {
+		n.nonCommon++
+	}
+}
+
+// Next returns up to max commits not known to be in common with the server.
+func (n *havesNegotiator) Next(max int) ([]plumbing.Hash, error) {
+	var haves []plumbing.Hash
+	for len(haves) < max && n.nonCommon > 0 {
+		v, _ := n.queue.Pop()
+		c := v.(*object.Commit)
+		n.popped[c.Hash] = true
+
+		common := n.common[c.Hash]
+		if !common {
+			n.nonCommon--
+			haves = append(haves, c.Hash)
+		}
+
+		for _, p := range c.ParentHashes {
+			if common || n.remote[c.Hash] {
+				n.markCommon(p)
+			}
+
+			n.push(p)
+		}
+	}
+
+	return haves, nil
+}
+
+// Ack marks h and its ancestors walked so far as common with the server.
+func (n *havesNegotiator) Ack(h plumbing.Hash) error {
+	pending := []plumbing.Hash{h}
+	for len(pending) != 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if n.common[h] {
+			continue
+		}
+
+		n.markCommon(h)
+		if c, ok := n.commits[h]; ok && n.popped[h] {
+			// The parents of the queued commits are marked once popped.
+			pending = append(pending, c.ParentHashes...)
+		}
+	}
+
+	return nil
+}
+
+func (n *havesNegotiator) markCommon(h plumbing.Hash) {
+	if n.common[h] {
+		return
+	}
+
+	n.common[h] = true
+	if _, ok := n.commits[h]; ok && !n.popped[h] {
+		n.nonCommon--
+	}
+}
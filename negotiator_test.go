@@ -0,0 +1,71 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type NegotiatorSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&NegotiatorSuite{})
+
+func (s *NegotiatorSuite) newNegotiator(c *C, remote ...string) *havesNegotiator {
+	r := s.NewRepository(fixtures.Basic().One())
+	master, err := r.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+
+	advertised := make(map[plumbing.Hash]bool)
+	for _, h := range remote {
+		advertised[plumbing.NewHash(h)] = true
+	}
+
+	return newHavesNegotiator(r.Storer, []*plumbing.Reference{master}, advertised)
+}
+
+func (s *NegotiatorSuite) TestNext(c *C) {
+	n := s.newNegotiator(c)
+
+	haves, err := n.Next(2)
+	c.Assert(err, IsNil)
+	c.Assert(haves, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+	})
+
+	haves, err = n.Next(32)
+	c.Assert(err, IsNil)
+	c.Assert(haves, HasLen, 6)
+
+	haves, err = n.Next(32)
+	c.Assert(err, IsNil)
+	c.Assert(haves, HasLen, 0)
+}
+
+func (s *NegotiatorSuite) TestAck(c *C) {
+	n := s.newNegotiator(c)
+
+	haves, err := n.Next(2)
+	c.Assert(err, IsNil)
+	c.Assert(haves, HasLen, 2)
+
+	c.Assert(n.Ack(plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")), IsNil)
+
+	haves, err = n.Next(32)
+	c.Assert(err, IsNil)
+	c.Assert(haves, HasLen, 0)
+}
+
+func (s *NegotiatorSuite) TestAdvertised(c *C) {
+	n := s.newNegotiator(c, "918c48b83bd081e863dbe1b80f8998f058cd8294")
+
+	haves, err := n.Next(32)
+	c.Assert(err, IsNil)
+	c.Assert(haves, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+	})
+}
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/go-git/go-git/v5/plumbing"
@@ -11,14 +12,16 @@ import (
 
 type objectWalker struct {
 	Storer storage.Storer
+	// ctx is checked for each commit and tree walked.
+	ctx context.Context
 	// seen is the set of objects seen in the repo.
 	// seen map can become huge if walking over large
 	// repos. Thus using struct{} as the value type.
 	seen map[plumbing.Hash]struct{}
 }
 
-func newObjectWalker(s storage.Storer) *objectWalker {
-	return &objectWalker{s, map[plumbing.Hash]struct{}{}}
+func newObjectWalker(ctx context.Context, s storage.Storer) *objectWalker {
+	return &objectWalker{s, ctx, map[plumbing.Hash]struct{}{}}
 }
 
 // walkAllRefs walks all (hash) references from the repo.
@@ -57,6 +60,9 @@ func (p *objectWalker) walkObjectTree(hash plumbing.Hash) error {
 		return nil
 	}
 	p.add(hash)
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
 	// Fetch the object.
 	obj, err := object.GetObject(p.Storer, hash)
 	if err != nil {
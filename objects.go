@@ -0,0 +1,332 @@
+package git
+
+import (
+	"io"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// WalkedObject is an object found by Repository.WalkObjects.
+type WalkedObject struct {
+	// Hash is the hash of the object.
+	Hash plumbing.Hash
+	// Type is the type of the object.
+	Type plumbing.ObjectType
+	// Path is the path the tree or blob was first found at, from the root
+	// tree of a commit, or from a tree included in the walk. It is empty for
+	// commits, tags and the root trees.
+	Path string
+}
+
+// WalkObjects returns an iterator over the objects reachable from the included
+// objects but not from the excluded ones, as `git rev-list --objects` does.
+// Each object is returned once, the commits before their trees and blobs.
+// The blobs are not read, only their hash and path are known from the trees.
+func (r *Repository) WalkObjects(o *WalkOptions) (*ObjectWalkIter, error) {
+	if err := o.Validate(r); err != nil {
+		return nil, err
+	}
+
+	it := &ObjectWalkIter{
+		s:      r.Storer,
+		prefix: o.PathPrefix,
+		seen:   make(map[plumbing.Hash]bool),
+	}
+
+	if len(o.Types) != 0 {
+		it.types = make(map[plumbing.ObjectType]bool, len(o.Types))
+		for _, t := range o.Types {
+			it.types[t] = true
+		}
+	}
+
+	if err := it.exclude(o.Include, o.Exclude); err != nil {
+		return nil, err
+	}
+
+	// The objects are walked from a stack, so the included objects are
+	// pushed in reverse order, to be walked in the given one.
+	for i := len(o.Include) - 1; i >= 0; i-- {
+		it.pending = append(it.pending, walkItem{hash: o.Include[i], typ: plumbing.AnyObject})
+	}
+
+	return it, nil
+}
+
+// walkItem is an object pending in an ObjectWalkIter.
+type walkItem struct {
+	hash plumbing.Hash
+	typ  plumbing.ObjectType
+	path string
+}
+
+// ObjectWalkIter is the iterator returned by Repository.WalkObjects.
+type ObjectWalkIter struct {
+	s      storer.EncodedObjectStorer
+	types  map[plumbing.ObjectType]bool
+	prefix string
+
+	// seen are the objects already returned or excluded, and the commits
+	// already walked.
+	seen map[plumbing.Hash]bool
+	// excluded are the commits reachable from the excluded objects.
+	excluded map[plumbing.Hash]bool
+	pending  []walkItem
+}
+
+// exclude marks the commits reachable from the excluded objects as excluded,
+// and the trees and blobs of the ones at the boundary of the history of the
+// included objects as seen, as git does.
+func (it *ObjectWalkIter) exclude(include, exclude []plumbing.Hash) error {
+	it.excluded = make(map[plumbing.Hash]bool)
+	var boundary []plumbing.Hash
+	for _, h := range exclude {
+		obj, err := it.peel(h)
+		if err != nil {
+			return err
+		}
+
+		c, ok := obj.(*object.Commit)
+		if !ok {
+			boundary = append(boundary, obj.ID())
+			continue
+		}
+
+		boundary = append(boundary, c.TreeHash)
+		err = object.NewCommitPreorderIter(c, it.excluded, nil).ForEach(func(c *object.Commit) error {
+			it.excluded[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(it.excluded) != 0 {
+		edges, err := it.edges(include)
+		if err != nil {
+			return err
+		}
+
+		boundary = append(boundary, edges...)
+	}
+
+	for _, h := range boundary {
+		if err := it.markSeen(h); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// edges returns the trees of the excluded commits which are parents of the
+// commits reachable from the included objects. They are found before the
+// walk, for none of their objects to be returned.
+func (it *ObjectWalkIter) edges(include []plumbing.Hash) ([]plumbing.Hash, error) {
+	var pending []plumbing.Hash
+	for _, h := range include {
+		obj, err := it.peel(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := obj.(*object.Commit); ok {
+			pending = append(pending, obj.ID())
+		}
+	}
+
+	var edges []plumbing.Hash
+	seen := make(map[plumbing.Hash]bool)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[h] {
+			continue
+		}
+
+		seen[h] = true
+		c, err := object.GetCommit(it.s, h)
+		if err != nil {
+			return nil, err
+		}
+
+		if it.excluded[h] {
+			edges = append(edges, c.TreeHash)
+			continue
+		}
+
+		pending = append(pending, c.ParentHashes...)
+	}
+
+	return edges, nil
+}
+
+// markSeen marks the given object, and all the objects reachable from it if
+// it is a tree, as seen.
+func (it *ObjectWalkIter) markSeen(h plumbing.Hash) error {
+	if it.seen[h] {
+		return nil
+	}
+
+	it.seen[h] = true
+	obj, err := it.s.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return err
+	}
+
+	if obj.Type() != plumbing.TreeObject {
+		return nil
+	}
+
+	t, err := object.DecodeTree(it.s, obj)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range t.Entries {
+		switch e.Mode {
+		case filemode.Submodule:
+		case filemode.Dir:
+			if err := it.markSeen(e.Hash); err != nil {
+				return err
+			}
+		default:
+			it.seen[e.Hash] = true
+		}
+	}
+
+	return nil
+}
+
+// peel returns the object of the given hash, following the tags.
+func (it *ObjectWalkIter) peel(h plumbing.Hash) (object.Object, error) {
+	for {
+		obj, err := object.GetObject(it.s, h)
+		if err != nil {
+			return nil, err
+		}
+
+		tag, ok := obj.(*object.Tag)
+		if !ok {
+			return obj, nil
+		}
+
+		h = tag.Target
+	}
+}
+
+// Next returns the next object, or io.EOF when all of them were returned.
+func (it *ObjectWalkIter) Next() (*WalkedObject, error) {
+	for len(it.pending) > 0 {
+		item := it.pending[len(it.pending)-1]
+		it.pending = it.pending[:len(it.pending)-1]
+		if it.seen[item.hash] || it.excluded[item.hash] {
+			continue
+		}
+
+		it.seen[item.hash] = true
+		typ, err := it.walk(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if it.types != nil && !it.types[typ] {
+			continue
+		}
+
+		// The trees leading to the path prefix are walked, not returned.
+		if it.prefix != "" && (typ == plumbing.TreeObject || typ == plumbing.BlobObject) && !inPathPrefix(item.path, it.prefix) {
+			continue
+		}
+
+		return &WalkedObject{Hash: item.hash, Type: typ, Path: item.path}, nil
+	}
+
+	return nil, io.EOF
+}
+
+// walk pushes the objects the given one points to, returning its type. The
+// blobs are not read.
+func (it *ObjectWalkIter) walk(item walkItem) (plumbing.ObjectType, error) {
+	if item.typ == plumbing.BlobObject {
+		return plumbing.BlobObject, nil
+	}
+
+	obj, err := object.GetObject(it.s, item.hash)
+	if err != nil {
+		return plumbing.InvalidObject, err
+	}
+
+	switch o := obj.(type) {
+	case *object.Commit:
+		for i := len(o.ParentHashes) - 1; i >= 0; i-- {
+			it.pending = append(it.pending, walkItem{hash: o.ParentHashes[i], typ: plumbing.CommitObject})
+		}
+
+		it.pending = append(it.pending, walkItem{hash: o.TreeHash, typ: plumbing.TreeObject})
+	case *object.Tag:
+		it.pending = append(it.pending, walkItem{hash: o.Target, typ: o.TargetType})
+	case *object.Tree:
+		for i := len(o.Entries) - 1; i >= 0; i-- {
+			e := o.Entries[i]
+			if e.Mode == filemode.Submodule {
+				continue
+			}
+
+			name := path.Join(item.path, e.Name)
+			if it.prefix != "" && !inPathPrefix(name, it.prefix) && !inPathPrefix(it.prefix, name) {
+				continue
+			}
+
+			typ := plumbing.BlobObject
+			if e.Mode == filemode.Dir {
+				typ = plumbing.TreeObject
+			}
+
+			it.pending = append(it.pending, walkItem{hash: e.Hash, typ: typ, path: name})
+		}
+	}
+
+	return obj.Type(), nil
+}
+
+// ForEach calls the given function for each object, until an error happens
+// or all the objects were returned. If storer.ErrStop is returned, the
+// iteration stops without error.
+func (it *ObjectWalkIter) ForEach(cb func(*WalkedObject) error) error {
+	defer it.Close()
+	for {
+		obj, err := it.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := cb(obj); err != nil {
+			if err == storer.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// Close releases the objects pending in the iterator.
+func (it *ObjectWalkIter) Close() {
+	it.pending = nil
+}
+
+// inPathPrefix returns whether name is the given path prefix, or under it.
+func inPathPrefix(name, prefix string) bool {
+	return name == prefix || strings.HasPrefix(name, prefix+"/")
+}
@@ -0,0 +1,102 @@
+package git
+
+import (
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+
+	. "gopkg.in/check.v1"
+)
+
+type ObjectsSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ObjectsSuite{})
+
+func (s *ObjectsSuite) walk(c *C, o *WalkOptions) []*WalkedObject {
+	iter, err := s.Repository.WalkObjects(o)
+	c.Assert(err, IsNil)
+
+	var objs []*WalkedObject
+	err = iter.ForEach(func(obj *WalkedObject) error {
+		objs = append(objs, obj)
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	return objs
+}
+
+func (s *ObjectsSuite) TestWalkObjects(c *C) {
+	head := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	base := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+
+	for _, exclude := range [][]plumbing.Hash{nil, {base}} {
+		expected, err := revlist.Objects(s.Repository.Storer, []plumbing.Hash{head}, exclude)
+		c.Assert(err, IsNil)
+
+		objs := s.walk(c, &WalkOptions{Exclude: exclude})
+		c.Assert(objs, HasLen, len(expected))
+		c.Assert(objs[0].Hash, Equals, head)
+		c.Assert(objs[0].Type, Equals, plumbing.CommitObject)
+
+		seen := make(map[plumbing.Hash]bool)
+		for _, obj := range objs {
+			c.Assert(seen[obj.Hash], Equals, false)
+			seen[obj.Hash] = true
+		}
+
+		for _, h := range expected {
+			c.Assert(seen[h], Equals, true)
+		}
+	}
+}
+
+func (s *ObjectsSuite) TestWalkObjectsOptions(c *C) {
+	objs := s.walk(c, &WalkOptions{Types: []plumbing.ObjectType{plumbing.CommitObject}})
+	c.Assert(objs, HasLen, 8)
+
+	objs = s.walk(c, &WalkOptions{PathPrefix: "go/"})
+	c.Assert(len(objs) > 8, Equals, true)
+	for _, obj := range objs {
+		if obj.Type == plumbing.CommitObject {
+			continue
+		}
+
+		c.Assert(obj.Path == "go" || strings.HasPrefix(obj.Path, "go/"), Equals, true, Commentf("%s", obj.Path))
+	}
+
+	objs = s.walk(c, &WalkOptions{
+		Types:      []plumbing.ObjectType{plumbing.BlobObject},
+		PathPrefix: "json",
+	})
+	c.Assert(objs, HasLen, 2)
+	for _, obj := range objs {
+		c.Assert(obj.Type, Equals, plumbing.BlobObject)
+		c.Assert(strings.HasPrefix(obj.Path, "json/"), Equals, true)
+	}
+}
+
+func (s *ObjectsSuite) TestWalkObjectsStop(c *C) {
+	iter, err := s.Repository.WalkObjects(&WalkOptions{})
+	c.Assert(err, IsNil)
+
+	var n int
+	err = iter.ForEach(func(*WalkedObject) error {
+		n++
+		if n == 3 {
+			return storer.ErrStop
+		}
+
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+
+	_, err = iter.Next()
+	c.Assert(err, Equals, io.EOF)
+}
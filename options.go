@@ -1,16 +1,21 @@
 package git
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	formatcfg "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/format/fastimport"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/go-git/go-git/v5/plumbing/transport"
@@ -65,10 +70,22 @@ type CloneOptions struct {
 	// ShallowSubmodules limit cloning submodules to the 1 level of depth.
 	// It matches the git command --shallow-submodules.
 	ShallowSubmodules bool
+	// SubmoduleJobs is the number of submodules cloned concurrently, see
+	// SubmoduleUpdateOptions.Jobs.
+	SubmoduleJobs int
+	// SubmoduleProgress returns where the progress of the clone of each
+	// submodule is stored, see SubmoduleUpdateOptions.Progress.
+	SubmoduleProgress func(path string) sideband.Progress
+	// SubmoduleProgressHandler returns the handler of the progress of the
+	// clone of each submodule, see SubmoduleUpdateOptions.ProgressHandler.
+	SubmoduleProgressHandler func(path string) ProgressHandler
 	// Progress is where the human readable information sent by the server is
 	// stored, if nil nothing is stored and the capability (if supported)
 	// no-progress, is sent to the server to avoid send this information.
 	Progress sideband.Progress
+	// ProgressHandler, if not nil, is called with the progress of the
+	// reception of the packfile and of the checkout.
+	ProgressHandler ProgressHandler
 	// Tags describe how the tags will be fetched from the remote repository,
 	// by default is AllTags.
 	Tags TagMode
@@ -78,6 +95,9 @@ type CloneOptions struct {
 	CABundle []byte
 	// ProxyOptions provides info required for connecting to a proxy.
 	ProxyOptions transport.ProxyOptions
+	// Timeouts are the time limits of the connection to the remote, the non
+	// zero ones override the ones of the transport client.
+	Timeouts transport.Timeouts
 	// When the repository to clone is on the local machine, instead of
 	// using hard links, automatically setup .git/objects/info/alternates
 	// to share the objects with the source repository.
@@ -87,12 +107,31 @@ type CloneOptions struct {
 	//
 	// [Reference]: https://git-scm.com/docs/git-clone#Documentation/git-clone.txt---shared
 	Shared bool
+	// Reference is the path of a local repository to borrow the objects
+	// from, setting up .git/objects/info/alternates as Shared does, so only
+	// the objects missing in it are fetched from the remote.
+	//
+	// [Reference]: https://git-scm.com/docs/git-clone#Documentation/git-clone.txt---reference-if-ableltrepositorygt
+	Reference string
+	// Dissociate copies the objects borrowed from the Reference or Shared
+	// repository into the clone, and removes the alternates, so the clone
+	// doesn't depend on that repository anymore.
+	Dissociate bool
+	// Resume, if not nil, retries the clone when the connection drops while
+	// receiving the packfile, see FetchOptions.Resume.
+	Resume *ResumeOptions
+	// InitialBranch is the branch HEAD points to when the cloned repository
+	// is empty and its HEAD isn't advertised, the init.defaultBranch config
+	// or master by default.
+	InitialBranch plumbing.ReferenceName
 }
 
 // MergeOptions describes how a merge should be performed.
 type MergeOptions struct {
 	// Strategy defines the merge strategy to be used.
 	Strategy MergeStrategy
+	// Hooks, if not nil, runs the post-merge hook once merged.
+	Hooks *HookOptions
 }
 
 // MergeStrategy represents the different types of merge strategies.
@@ -150,6 +189,9 @@ type PullOptions struct {
 	// stored, if nil nothing is stored and the capability (if supported)
 	// no-progress, is sent to the server to avoid send this information.
 	Progress sideband.Progress
+	// ProgressHandler, if not nil, is called with the progress of the
+	// reception of the packfile and of the update of the worktree.
+	ProgressHandler ProgressHandler
 	// Force allows the pull to update a local branch even when the remote
 	// branch does not descend from it.
 	Force bool
@@ -159,10 +201,51 @@ type PullOptions struct {
 	CABundle []byte
 	// ProxyOptions provides info required for connecting to a proxy.
 	ProxyOptions transport.ProxyOptions
+	// Timeouts are the time limits of the connection to the remote, the non
+	// zero ones override the ones of the transport client.
+	Timeouts transport.Timeouts
+	// FFOnly refuses to pull, with ErrNonFastForwardUpdate, when the current
+	// branch can't be fast-forwarded to the fetched head, instead of merging
+	// it. If false, pull.ff=only in the config has the same effect.
+	FFOnly bool
+	// NoFF creates a merge commit even when the current branch could be
+	// fast-forwarded. If false, pull.ff=false in the config has the same
+	// effect.
+	NoFF bool
+	// Rebase rebases the local commits on top of the fetched head, instead
+	// of merging it. If false, the branch.<name>.rebase and pull.rebase
+	// config are used.
+	Rebase bool
+	// Author is the author's signature of the merge commit. If Author is
+	// empty the Name and Email is read from the config, and time.Now it's
+	// used as When. The rebased commits keep their author.
+	Author *object.Signature
+	// Committer is the committer's signature of the merge or rebased
+	// commits. If Committer is nil the Author signature is used.
+	Committer *object.Signature
+	// SignKey denotes a key to sign the merge or rebased commits with. A nil
+	// value here means the commits will not be signed. The private key must
+	// be present and already decrypted.
+	SignKey *openpgp.Entity
+	// Signer denotes a cryptographic signer to sign the merge or rebased
+	// commits with. A nil value here means the commits will not be signed.
+	// Takes precedence over SignKey.
+	Signer Signer
+	// Hooks, if not nil, runs the post-merge hook once the fetched head is
+	// merged, or fast-forwarded to. It isn't run when rebasing.
+	Hooks *HookOptions
 }
 
+var (
+	ErrFFOnlyAndNoFF = errors.New("fast-forward only and no fast-forward cannot be used together")
+)
+
 // Validate validates the fields and sets the default values.
 func (o *PullOptions) Validate() error {
+	if o.FFOnly && o.NoFF {
+		return ErrFFOnlyAndNoFF
+	}
+
 	if o.RemoteName == "" {
 		o.RemoteName = DefaultRemoteName
 	}
@@ -205,8 +288,12 @@ type FetchOptions struct {
 	// stored, if nil nothing is stored and the capability (if supported)
 	// no-progress, is sent to the server to avoid send this information.
 	Progress sideband.Progress
+	// ProgressHandler, if not nil, is called with the progress of the
+	// reception of the packfile.
+	ProgressHandler ProgressHandler
 	// Tags describe how the tags will be fetched from the remote repository,
-	// by default is TagFollowing.
+	// by default is the one set by the remote.<name>.tagOpt config option,
+	// or TagFollowing.
 	Tags TagMode
 	// Force allows the fetch to update a local branch even when the remote
 	// branch does not descend from it.
@@ -217,9 +304,32 @@ type FetchOptions struct {
 	CABundle []byte
 	// ProxyOptions provides info required for connecting to a proxy.
 	ProxyOptions transport.ProxyOptions
+	// Timeouts are the time limits of the connection to the remote, the non
+	// zero ones override the ones of the transport client.
+	Timeouts transport.Timeouts
 	// Prune specify that local refs that match given RefSpecs and that do
 	// not exist remotely will be removed.
 	Prune bool
+	// PruneTags specify that local tags that do not exist remotely will be
+	// removed, as if refs/tags/*:refs/tags/* was pruned along the RefSpecs.
+	// It has no effect if Tags is NoTags.
+	PruneTags bool
+	// Resume, if not nil, retries the fetch when the connection drops while
+	// receiving the packfile. The objects fully received are kept, and the
+	// server is not asked again for the commits whose tree is complete.
+	Resume *ResumeOptions
+}
+
+// ResumeOptions describes how a fetch interrupted while receiving the
+// packfile is retried.
+type ResumeOptions struct {
+	// Retries is the maximum number of times the fetch is retried, by
+	// default 3.
+	Retries int
+	// Filesystem is where the packfile is kept while being received, so the
+	// objects can be recovered. By default the temporary directory of the
+	// system is used.
+	Filesystem billy.Filesystem
 }
 
 // Validate validates the fields and sets the default values.
@@ -261,6 +371,9 @@ type PushOptions struct {
 	// Progress is where the human readable information sent by the server is
 	// stored, if nil nothing is stored.
 	Progress sideband.Progress
+	// ProgressHandler, if not nil, is called with the progress of the
+	// writing of the packfile sent.
+	ProgressHandler ProgressHandler
 	// Prune specify that remote refs that match given RefSpecs and that do
 	// not exist locally will be removed.
 	Prune bool
@@ -285,8 +398,32 @@ type PushOptions struct {
 	Atomic bool
 	// ProxyOptions provides info required for connecting to a proxy.
 	ProxyOptions transport.ProxyOptions
+	// Timeouts are the time limits of the connection to the remote, the non
+	// zero ones override the ones of the transport client.
+	Timeouts transport.Timeouts
+	// Mirror pushes all the local references under refs/, force-updating
+	// them and deleting the remote references which do not exist locally,
+	// as git push --mirror does. It cannot be used along with RefSpecs.
+	Mirror bool
+	// SetUpstream sets, once pushed, the remote branches as the upstream of
+	// the local branches pushed to them, in the branch.<name>.remote and
+	// branch.<name>.merge config, as git push --set-upstream does. It is
+	// only honored by Repository.Push.
+	SetUpstream bool
+	// DryRun does everything but sending the packfile and updating the
+	// references, of the remote and the local remote-tracking ones. See
+	// Remote.PushDryRun to know what the push would do.
+	DryRun bool
+	// Hooks, if not nil, runs the pre-push hook before sending the
+	// packfile, for each URL pushed to. The push is aborted with a
+	// *HookError if it fails.
+	Hooks *HookOptions
 }
 
+// ErrMirrorRefSpecs is returned when PushOptions.Mirror is used along with
+// refspecs.
+var ErrMirrorRefSpecs = errors.New("mirror push cannot be used along with refspecs")
+
 // ForceWithLease sets fields on the lease
 // If neither RefName nor Hash are set, ForceWithLease protects
 // all refs in the refspec by ensuring the ref of the remote in the local repsitory
@@ -306,6 +443,15 @@ func (o *PushOptions) Validate() error {
 		o.RemoteName = DefaultRemoteName
 	}
 
+	if o.Mirror {
+		if len(o.RefSpecs) != 0 && !o.isMirrorRefSpecs() {
+			return ErrMirrorRefSpecs
+		}
+
+		o.RefSpecs = []config.RefSpec{refspecMirror}
+		o.Prune = true
+	}
+
 	if len(o.RefSpecs) == 0 {
 		o.RefSpecs = []config.RefSpec{
 			config.RefSpec(config.DefaultPushRefSpec),
@@ -321,6 +467,12 @@ func (o *PushOptions) Validate() error {
 	return nil
 }
 
+// isMirrorRefSpecs returns true if the refspecs are the ones set by Validate
+// for a mirror push, so the options can be validated more than once.
+func (o *PushOptions) isMirrorRefSpecs() bool {
+	return len(o.RefSpecs) == 1 && o.RefSpecs[0] == refspecMirror
+}
+
 // SubmoduleUpdateOptions describes how a submodule update should be performed.
 type SubmoduleUpdateOptions struct {
 	// Init, if true initializes the submodules recorded in the index.
@@ -335,25 +487,116 @@ type SubmoduleUpdateOptions struct {
 	// Auth credentials, if required, to use with the remote repository.
 	Auth transport.AuthMethod
 	// Depth limit fetching to the specified number of commits from the tip of
-	// each remote branch history.
+	// each remote branch history. If zero, the submodules with the shallow
+	// setting in .gitmodules are fetched with a depth of 1.
+	Depth int
+	// Jobs is the number of submodules updated concurrently. If zero, the
+	// submodule.fetchJobs config is used, defaulting to 1.
+	Jobs int
+	// Progress, if not nil, returns where the human readable information
+	// sent by the server while fetching the submodule at the given path is
+	// stored. The path of the nested submodules is relative to the
+	// superproject.
+	Progress func(path string) sideband.Progress
+	// ProgressHandler, if not nil, returns the handler of the progress of the
+	// fetch and of the checkout of the submodule at the given path, relative
+	// to the superproject as for Progress.
+	ProgressHandler func(path string) ProgressHandler
+}
+
+// SubmoduleIgnore defines which changes of a submodule are ignored by the
+// status, as the ignore setting of the submodule does.
+type SubmoduleIgnore string
+
+const (
+	// SubmoduleIgnoreNone reports the new commits, and the modified and the
+	// untracked content of the submodule. It is the default.
+	SubmoduleIgnoreNone SubmoduleIgnore = "none"
+	// SubmoduleIgnoreUntracked ignores the untracked content.
+	SubmoduleIgnoreUntracked SubmoduleIgnore = "untracked"
+	// SubmoduleIgnoreDirty ignores the changes of the worktree of the
+	// submodule, only reporting the new commits.
+	SubmoduleIgnoreDirty SubmoduleIgnore = "dirty"
+	// SubmoduleIgnoreAll ignores all the changes of the submodule.
+	SubmoduleIgnoreAll SubmoduleIgnore = "all"
+)
+
+// SubmoduleStatusOptions describes how the status of a submodule should be
+// computed.
+type SubmoduleStatusOptions struct {
+	// Ignore defines which changes of the worktree of the submodule are
+	// ignored. By default, the ignore setting of the submodule, in the config
+	// or in the .gitmodules file, is used.
+	Ignore SubmoduleIgnore
+	// Recursive computes the status of the nested submodules too, their
+	// changes being changes of the content of their parent submodule.
+	Recursive bool
+}
+
+// SubmoduleAddOptions describes how a submodule should be added.
+type SubmoduleAddOptions struct {
+	// URL of the repository of the submodule. A URL starting with "./" or
+	// "../" is relative to the URL of the origin remote of the
+	// superproject, or to its worktree if it has no origin remote.
+	URL string
+	// Path of the submodule, relative to the root of the worktree.
+	Path string
+	// Name of the submodule, the path by default.
+	Name string
+	// Branch of the repository to check out, and to track in updates,
+	// instead of the remote HEAD.
+	Branch string
+	// Auth credentials, if required, to use with the remote repository.
+	Auth transport.AuthMethod
+	// Depth limit fetching to the specified number of commits.
 	Depth int
 }
 
+// Validate validates the fields and sets the default values.
+func (o *SubmoduleAddOptions) Validate() error {
+	if o.URL == "" {
+		return ErrMissingURL
+	}
+
+	if o.Path == "" {
+		return config.ErrModuleEmptyPath
+	}
+
+	o.Path = filepath.ToSlash(filepath.Clean(o.Path))
+	if o.Name == "" {
+		o.Name = o.Path
+	}
+
+	return nil
+}
+
 var (
-	ErrBranchHashExclusive  = errors.New("Branch and Hash are mutually exclusive")
-	ErrCreateRequiresBranch = errors.New("Branch is mandatory when Create is used")
+	ErrBranchHashExclusive      = errors.New("Branch and Hash are mutually exclusive")
+	ErrCreateRequiresBranch     = errors.New("Branch is mandatory when Create is used")
+	ErrStartPointRequiresCreate = errors.New("StartPoint is only allowed with Create and without Hash")
+	ErrPathspecWithCreate       = errors.New("Pathspec can't be used with Create")
+	ErrDetachWithCreate         = errors.New("Detach can't be used with Create or Pathspec")
 )
 
 // CheckoutOptions describes how a checkout operation should be performed.
 type CheckoutOptions struct {
-	// Hash is the hash of a commit or tag to be checked out. If used, HEAD
-	// will be in detached mode. If Create is not used, Branch and Hash are
-	// mutually exclusive.
+	// Hash is the hash of a commit to be checked out, or of an annotated tag
+	// peeled to its commit. With Pathspec, it can be the hash of a tree too.
+	// If used, HEAD will be in detached mode. If Create is not used, Branch
+	// and Hash are mutually exclusive.
 	Hash plumbing.Hash
 	// Branch to be checked out, if Branch and Hash are empty is set to `master`.
 	Branch plumbing.ReferenceName
+	// Detach, if true, checks out the commit of Branch with HEAD detached
+	// at it, as `git checkout --detach` does, HEAD if Branch is empty.
+	Detach bool
 	// Create a new branch named Branch and start it at Hash.
 	Create bool
+	// StartPoint is the branch or remote-tracking branch the new branch
+	// starts at when Create is used, instead of Hash. The new branch tracks
+	// it as its upstream as branch.autoSetupMerge says, a remote-tracking
+	// branch by default.
+	StartPoint plumbing.ReferenceName
 	// Force, if true when switching branches, proceed even if the index or the
 	// working tree differs from HEAD. This is used to throw away local changes
 	Force bool
@@ -364,6 +607,26 @@ type CheckoutOptions struct {
 	Keep bool
 	// SparseCheckoutDirectories
 	SparseCheckoutDirectories []string
+	// AllowCaseCollisions, if true, checks out the trees with paths only
+	// differing in case even if core.ignoreCase is set, one of the colliding
+	// files overwriting the other in the worktree.
+	AllowCaseCollisions bool
+	// ProgressHandler, if not nil, is called with the progress of the update
+	// of the files of the worktree.
+	ProgressHandler ProgressHandler
+	// Pathspec, if not empty, only checks out the paths matching the given
+	// git pathspecs, such as "dir" or ":(glob)**/*.go", from the commit of
+	// Hash or Branch, or HEAD if none is set, without switching branches, as
+	// `git checkout <tree-ish> -- <pathspec>` does. The index and the
+	// worktree are updated for those paths, discarding their changes.
+	Pathspec []string
+	// ConflictHandler, if not nil, is called for each local change that
+	// would be overwritten by the checkout, to decide whether to overwrite it,
+	// keep it or abort the checkout. By default the checkout is aborted with a
+	// *CheckoutConflictError. It is not used with Force or Keep.
+	ConflictHandler CheckoutConflictHandler
+	// Hooks, if not nil, runs the post-checkout hook once checked out.
+	Hooks *HookOptions
 }
 
 // Validate validates the fields and sets the default values.
@@ -376,6 +639,31 @@ func (o *CheckoutOptions) Validate() error {
 		return ErrCreateRequiresBranch
 	}
 
+	if o.StartPoint != "" && (!o.Create || !o.Hash.IsZero()) {
+		return ErrStartPointRequiresCreate
+	}
+
+	if o.Detach {
+		if o.Create || len(o.Pathspec) != 0 {
+			return ErrDetachWithCreate
+		}
+
+		if o.Hash.IsZero() && o.Branch == "" {
+			o.Branch = plumbing.HEAD
+		}
+	}
+
+	if len(o.Pathspec) != 0 {
+		if o.Create {
+			return ErrPathspecWithCreate
+		}
+
+		// The paths are checked out from HEAD by default.
+		if o.Hash.IsZero() && o.Branch == "" {
+			o.Branch = plumbing.HEAD
+		}
+	}
+
 	if o.Branch == "" {
 		o.Branch = plumbing.Master
 	}
@@ -419,6 +707,9 @@ type ResetOptions struct {
 	// Files, if not empty will constrain the reseting the index to only files
 	// specified in this list.
 	Files []string
+	// ProgressHandler, if not nil, is called with the progress of the update
+	// of the files of the worktree.
+	ProgressHandler ProgressHandler
 }
 
 // Validate validates the fields and sets the default values.
@@ -448,6 +739,34 @@ const (
 	LogOrderDFSPost
 	LogOrderBSF
 	LogOrderCommitterTime
+	// LogOrderTopo shows no parent before all its children, without
+	// intermixing the lines of history, as `git log --topo-order` does.
+	LogOrderTopo
+	// LogOrderAuthorDate shows no parent before all its children, the
+	// commits being otherwise in author time order, as
+	// `git log --author-date-order` does.
+	LogOrderAuthorDate
+)
+
+// HistorySimplification is the way the history is simplified when the log
+// is limited to some paths. A commit is said to be TREESAME to a parent when
+// it doesn't change the paths compared to it.
+type HistorySimplification int8
+
+const (
+	// HistoryDefault shows the commits changing the paths. The commits read
+	// are diffed with the next ones, unless the parents are rewritten, in
+	// which case the merges TREESAME to a parent are followed along that
+	// parent only, as git log does by default.
+	HistoryDefault HistorySimplification = iota
+	// HistoryFull follows all the parents of the merges, showing the
+	// commits not TREESAME to at least one of their parents.
+	// It is equivalent to running `git log --full-history -- <path>`.
+	HistoryFull
+	// HistorySimplifyMerges is HistoryFull with the parents rewritten,
+	// further removing the merges no commit changing the paths contributes
+	// to. It is equivalent to running `git log --simplify-merges -- <path>`.
+	HistorySimplifyMerges
 )
 
 // LogOptions describes how a log action should be performed.
@@ -460,6 +779,7 @@ type LogOptions struct {
 	// The default traversal algorithm is Depth-first search
 	// set Order=LogOrderCommitterTime for ordering by committer time (more compatible with `git log`)
 	// set Order=LogOrderBSF for Breadth-first search
+	// set Order=LogOrderTopo for topological order, to draw the graph of the commits
 	Order LogOrder
 
 	// Show only those commits in which the specified file was inserted/updated.
@@ -473,6 +793,13 @@ type LogOptions struct {
 	// either <path> is a file path, or directory path, or a regexp of file/directory path
 	PathFilter func(string) bool
 
+	// Show only those commits updating a file at or under one of the paths,
+	// files or directories. It is equivalent to running `git log -- <path>...`.
+	// Along with PathFilter, the commits updating a file matched by both are
+	// shown. The changed-path Bloom filters of the commit-graph, if any, are
+	// used to skip the commits not changing any of the paths.
+	Paths []string
+
 	// Pretend as if all the refs in refs/, along with HEAD, are listed on the command line as <commit>.
 	// It is equivalent to running `git log --all`.
 	// If set on true, the From option will be ignored.
@@ -485,12 +812,234 @@ type LogOptions struct {
 	// Show commits older than a specific date.
 	// It is equivalent to running `git log --until <date>` or `git log --before <date>`.
 	Until *time.Time
+
+	// Map the authors and committers of the commits to their canonical
+	// names and emails with the mailmap of the repository.
+	// It is equivalent to running `git log --use-mailmap`.
+	UseMailmap bool
+	// Output the selected commits in reverse order, oldest first, once the
+	// filters above are applied. All the commits are read before the first
+	// one is returned.
+	// It is equivalent to running `git log --reverse`.
+	Reverse bool
+
+	// HistorySimplification tells how the history limited to some paths,
+	// with FileName, PathFilter or Paths, is simplified. Apart from the
+	// default one, the simplifications read all the history before the
+	// first commit is returned.
+	HistorySimplification HistorySimplification
+
+	// Rewrite the parents of the commits to their nearest ancestors shown,
+	// so the history limited to some paths remains connected. The merges
+	// are then always shown with HistoryFull. It is implied by
+	// HistorySimplifyMerges.
+	// It is equivalent to running `git log --parents -- <path>`.
+	RewriteParents bool
+
+	// Show only the commits which are descendants of this commit, and not
+	// ancestors of it. All the parents of the merges are then followed, as
+	// with HistoryFull.
+	// It is equivalent to running `git log --ancestry-path <commit>..<From>`.
+	AncestryPath plumbing.Hash
+}
+
+// ShortlogOptions describes how a shortlog should be computed.
+type ShortlogOptions struct {
+	// LogOptions select the commits, as in Log, the identities being mapped
+	// with the mailmap of the repository if UseMailmap is set.
+	LogOptions
+
+	// Range is a range of commits such as "v1.0..HEAD", the commits
+	// reachable from the right revision and not from the left one, or a
+	// single revision. The right revision is HEAD if it is omitted. When it
+	// is set, it replaces LogOptions.From.
+	Range string
+
+	// Group the commits by committer instead of by author.
+	// It is equivalent to running `git shortlog --committer`.
+	GroupByCommitter bool
+
+	// Group the commits by name and email, instead of by name only.
+	// It is equivalent to running `git shortlog --email`.
+	Email bool
+
+	// Only count the commits, leaving out their subjects.
+	// It is equivalent to running `git shortlog --summary`.
+	SummaryOnly bool
+
+	// Sort the entries by decreasing number of commits instead of by name.
+	// It is equivalent to running `git shortlog --numbered`.
+	Numbered bool
+}
+
+// BlameOptions describes how a blame should be performed.
+type BlameOptions struct {
+	// Map the authors of the lines to their canonical names and emails with
+	// the mailmap of the repository, as git blame does by default.
+	UseMailmap bool
 }
 
 var (
 	ErrMissingAuthor = errors.New("author field is required")
 )
 
+// WriteCommitGraphOptions describes how a commit-graph should be written.
+type WriteCommitGraphOptions struct {
+	// ChangedPaths computes the changed-path Bloom filters of the commits, as
+	// `git commit-graph write --changed-paths` does. They speed up the logs
+	// of given paths, at the cost of diffing the tree of every commit once.
+	ChangedPaths bool
+}
+
+// RepackOptions describes how a repack should be performed.
+type RepackOptions struct {
+	// DeleteLooseObjects deletes the loose objects written to the new
+	// packfile, as `git repack -d` does.
+	DeleteLooseObjects bool
+	// WriteIndex writes a multi-pack-index of the new packfile and the kept
+	// ones, as `git repack --write-midx` does. Otherwise, the existing
+	// multi-pack-index is removed, as it would cover removed packfiles.
+	WriteIndex bool
+	// Window is the number of objects tried as delta base of each object, if
+	// zero the pack.window configuration is used, 10 by default.
+	Window uint
+	// Depth is the maximum length of the delta chains, if zero the
+	// pack.depth configuration is used, 50 by default.
+	Depth int
+	// PackKeptObjects includes in the new packfile the objects of the
+	// packfiles with a .keep file, as `git repack --pack-kept-objects` does.
+	// These packfiles are never removed.
+	PackKeptObjects bool
+	// OnlyDeleteOlderThan, if not zero, only removes the superseded packfiles
+	// modified before the given time, so the unreachable objects written
+	// recently, such as the ones of a concurrent push, are not lost.
+	OnlyDeleteOlderThan time.Time
+}
+
+// Validate validates the fields and sets the default values.
+func (o *RepackOptions) Validate(r *Repository) error {
+	if o.Window != 0 && o.Depth != 0 {
+		return nil
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	if o.Window == 0 {
+		o.Window = cfg.Pack.Window
+	}
+
+	if o.Depth == 0 {
+		o.Depth = cfg.Pack.Depth
+	}
+
+	return nil
+}
+
+const (
+	// DefaultGCPruneExpiry is the age of the unreachable objects removed by
+	// GC by default, same as the gc.pruneExpire default of git.
+	DefaultGCPruneExpiry = 14 * 24 * time.Hour
+
+	aggressiveGCWindow = 250
+	aggressiveGCDepth  = 50
+)
+
+// GCOptions describes how a garbage collection should be performed.
+type GCOptions struct {
+	// PruneOlderThan is the time before which the unreachable objects must
+	// have been written to be removed, if zero DefaultGCPruneExpiry ago.
+	PruneOlderThan time.Time
+	// Aggressive looks harder for deltas, as `git gc --aggressive` does,
+	// it is much slower.
+	Aggressive bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *GCOptions) Validate() error {
+	if o.PruneOlderThan.IsZero() {
+		o.PruneOlderThan = time.Now().Add(-DefaultGCPruneExpiry)
+	}
+
+	return nil
+}
+
+// PackRefsOptions describes how the references should be packed.
+type PackRefsOptions struct {
+	// All packs all the references, otherwise only the tags and the
+	// references already packed are, as `git pack-refs --all` does.
+	All bool
+	// Prune removes the loose references once packed, as `git pack-refs`
+	// does by default.
+	Prune bool
+}
+
+// FsckOptions describes how the integrity of a repository is verified.
+type FsckOptions struct {
+	// ConnectivityOnly only checks the objects reachable from the references
+	// exist, as `git fsck --connectivity-only` does. The blobs are not read,
+	// and the objects are not hashed again nor checked to be well formed,
+	// nor are the checksums of the packfiles verified.
+	ConnectivityOnly bool
+	// Strict also reports the tree entries git only warns about, such as the
+	// file modes with the group write bit set, the zero padded modes or the
+	// entries named ".git", as `git fsck --strict` does.
+	Strict bool
+	// Progress is where the progress of the verification is written to, as
+	// every object of the repository is read.
+	Progress sideband.Progress
+}
+
+// BatchObjectsOptions describes how the objects are read by BatchObjects.
+type BatchObjectsOptions struct {
+	// CheckOnly only returns the type and the size of the objects, without
+	// their content, as `git cat-file --batch-check` does.
+	CheckOnly bool
+}
+
+// FastExportOptions describes how the history is exported by FastExport.
+type FastExportOptions struct {
+	// Refs are the references exported. If empty, every reference but the
+	// symbolic ones, such as HEAD, is exported.
+	Refs []plumbing.ReferenceName
+	// Marks, if not nil, are the marks of the objects exported by a previous
+	// run, as read from the file of `git fast-export --export-marks`. These
+	// objects and the history behind the marked commits are left out and
+	// referred to by their marks. The marks of the objects exported are
+	// added to it.
+	Marks *fastimport.Marks
+	// ShowOriginalIDs writes the hashes of the exported blobs, commits and
+	// tags in original-oid commands, as `git fast-export --show-original-ids`
+	// does.
+	ShowOriginalIDs bool
+}
+
+// FastImportOptions describes how a stream is imported by FastImport.
+type FastImportOptions struct {
+	// Marks, if not nil, are the marks of the objects of a previous run the
+	// stream refers to, as read from the file of `git fast-import
+	// --export-marks`. The marks of the objects imported are added to it.
+	Marks *fastimport.Marks
+	// Force updates the branches whose new commit doesn't contain their
+	// current one, which are left untouched otherwise.
+	Force bool
+}
+
+// BisectStartOptions describes how a bisection is started by Bisect.Start.
+type BisectStartOptions struct {
+	// Bad is the commit known to be bad, if any yet.
+	Bad plumbing.Hash
+	// Good are the commits known to be good. They can only be given with
+	// a bad commit.
+	Good []plumbing.Hash
+	// NoCheckout leaves the worktree untouched, the commit to test being
+	// given by the BISECT_HEAD reference instead, as the --no-checkout option
+	// of git bisect start does. It is implied in the bare repositories.
+	NoCheckout bool
+}
+
 // AddOptions describes how an `add` operation should be performed
 type AddOptions struct {
 	// All equivalent to `git add -A`, update the index not only where the
@@ -509,6 +1058,20 @@ type AddOptions struct {
 	// Notice that when passing an ignored path it will be added anyway.
 	// When true it can speed up adding files to the worktree in very large repositories.
 	SkipStatus bool
+	// IntentToAdd records only the fact that the untracked paths will be
+	// added later, as `git add -N` does: an entry is added to the index with
+	// the content of an empty file, and the path is reported as added in the
+	// worktree by Status until its content is added.
+	IntentToAdd bool
+	// Update only updates the index where it already has an entry matching
+	// the paths, as `git add -u` does: modified files are added and deleted
+	// ones are removed from the index, but untracked files are never added.
+	// Without Path, Glob nor Pathspec, the whole worktree is updated.
+	Update bool
+	// Pathspec adds the paths matching the given git pathspecs, such as
+	// "dir", ":(glob)src/**/*.js" or ":(exclude)*.min.js". It can't be used
+	// with Path or Glob.
+	Pathspec []string
 }
 
 // Validate validates the fields and sets the default values.
@@ -517,6 +1080,14 @@ func (o *AddOptions) Validate(r *Repository) error {
 		return fmt.Errorf("fields Path and Glob are mutual exclusive")
 	}
 
+	if len(o.Pathspec) != 0 && (o.Path != "" || o.Glob != "") {
+		return fmt.Errorf("field Pathspec is mutual exclusive with Path and Glob")
+	}
+
+	if o.Update && o.IntentToAdd {
+		return fmt.Errorf("fields Update and IntentToAdd are mutual exclusive")
+	}
+
 	return nil
 }
 
@@ -549,6 +1120,16 @@ type CommitOptions struct {
 	// Amend will create a new commit object and replace the commit that HEAD currently
 	// points to. Cannot be used with All nor Parents.
 	Amend bool
+	// Encoding is the encoding of the message, recorded in the encoding header
+	// of the commit, as the i18n.commitEncoding option of git does. The
+	// message is written as is, so it must be in this encoding already. By
+	// default, the message is in UTF-8 and no header is written.
+	Encoding object.MessageEncoding
+	// AllowInvalidPaths disables the validation of the paths of the index,
+	// done as the receiving side of a push does with receive.fsckObjects, so
+	// trees with names such as ".." or ".git", or duplicate entries, can be
+	// written, to test how other implementations handle them.
+	AllowInvalidPaths bool
 }
 
 // Validate validates the fields and sets the default values.
@@ -640,6 +1221,11 @@ type CreateTagOptions struct {
 	// SignKey denotes a key to sign the tag with. A nil value here means the tag
 	// will not be signed. The private key must be present and already decrypted.
 	SignKey *openpgp.Entity
+	// Signer denotes a cryptographic signer to sign the tag with, such as an
+	// SSH or a KMS backed one. The signature is appended to the message, as
+	// git does. A nil value here means the tag will not be signed. Takes
+	// precedence over SignKey.
+	Signer Signer
 }
 
 // Validate validates the fields and sets the default values.
@@ -704,6 +1290,29 @@ type ListOptions struct {
 	ProxyOptions transport.ProxyOptions
 	// Timeout specifies the timeout in seconds for list operations
 	Timeout int
+	// Patterns only lists the references matching one of the patterns, as
+	// `git ls-remote <remote> <patterns>` does: a pattern matches the end of
+	// the name of a reference, after a slash, such as "main" or "v1.*" for
+	// "refs/heads/main" or "refs/tags/v1.2", and its wildcards match slashes
+	// too. The references are filtered after they were advertised, as the
+	// protocol advertises all of them.
+	Patterns []string
+}
+
+// timeoutContext returns a context cancelled after the timeout of the
+// options, 10 seconds by default.
+func (o *ListOptions) timeoutContext() (context.Context, context.CancelFunc, error) {
+	timeout := o.Timeout
+	// Default to the old hardcoded 10s value if a timeout is not explicitly set.
+	if timeout == 0 {
+		timeout = 10
+	}
+	if timeout < 0 {
+		return nil, nil, fmt.Errorf("invalid timeout: %d", timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	return ctx, cancel, nil
 }
 
 // PeelingOption represents the different ways to handle peeled references.
@@ -724,7 +1333,38 @@ const (
 
 // CleanOptions describes how a clean should be performed.
 type CleanOptions struct {
+	// Dir also removes untracked directories, as `git clean -d` does.
+	// Without it, only untracked files in directories holding tracked files
+	// are removed.
 	Dir bool
+	// DryRun reports the paths which would be removed, without removing
+	// them.
+	DryRun bool
+	// RemoveIgnored also removes the files ignored by the .gitignore and
+	// exclude files, as `git clean -x` does.
+	RemoveIgnored bool
+	// OnlyIgnored removes only the ignored files, as `git clean -X` does.
+	OnlyIgnored bool
+	// Paths limits the clean to the given paths, relative to the root of the
+	// worktree. If empty, the whole worktree is cleaned.
+	Paths []string
+	// Force also removes untracked nested repositories, directories
+	// containing a .git entry, which are otherwise left untouched. It is
+	// only meaningful with Dir.
+	Force bool
+}
+
+var (
+	ErrRemoveIgnoredAndOnlyIgnored = errors.New("RemoveIgnored and OnlyIgnored cannot be used together")
+)
+
+// Validate validates the fields and sets the default values.
+func (o *CleanOptions) Validate() error {
+	if o.RemoveIgnored && o.OnlyIgnored {
+		return ErrRemoveIgnoredAndOnlyIgnored
+	}
+
+	return nil
 }
 
 // GrepOptions describes how a grep should be performed.
@@ -739,10 +1379,40 @@ type GrepOptions struct {
 	ReferenceName plumbing.ReferenceName
 	// PathSpecs are compiled Regexp objects of pathspec to use in the matching.
 	PathSpecs []*regexp.Regexp
+	// ExcludePathSpecs are compiled Regexp objects of pathspec of the files
+	// to skip, even if they match PathSpecs.
+	ExcludePathSpecs []*regexp.Regexp
+	// IgnoreCase ignores the case differences between the patterns and the
+	// content.
+	IgnoreCase bool
+	// WordRegexp matches the patterns only at word boundaries.
+	WordRegexp bool
+	// ContextBefore is the number of lines of leading context reported
+	// before each match.
+	ContextBefore int
+	// ContextAfter is the number of lines of trailing context reported after
+	// each match.
+	ContextAfter int
+	// Source is what the grep is performed on, the tree of CommitHash or
+	// ReferenceName by default.
+	Source GrepSource
 }
 
+// GrepSource defines what a grep is performed on.
+type GrepSource uint8
+
+const (
+	// GrepTree greps the tree of a commit. This is the default behavior.
+	GrepTree GrepSource = 0
+	// GrepWorktree greps the files of the worktree tracked in the index.
+	GrepWorktree GrepSource = 1
+	// GrepIndex greps the content staged in the index.
+	GrepIndex GrepSource = 2
+)
+
 var (
-	ErrHashOrReference = errors.New("ambiguous options, only one of CommitHash or ReferenceName can be passed")
+	ErrHashOrReference    = errors.New("ambiguous options, only one of CommitHash or ReferenceName can be passed")
+	ErrGrepSourceRevision = errors.New("CommitHash and ReferenceName can only be used when grepping a tree")
 )
 
 // Validate validates the fields and sets the default values.
@@ -757,6 +1427,14 @@ func (o *GrepOptions) validate(r *Repository) error {
 		return ErrHashOrReference
 	}
 
+	if o.Source != GrepTree {
+		if !o.CommitHash.IsZero() || o.ReferenceName != "" {
+			return ErrGrepSourceRevision
+		}
+
+		return nil
+	}
+
 	// If none of CommitHash and ReferenceName are provided, set commit hash of
 	// the repository's head.
 	if o.CommitHash.IsZero() && o.ReferenceName == "" {
@@ -778,21 +1456,62 @@ type PlainOpenOptions struct {
 	DetectDotGit bool
 	// Enable .git/commondir support (see https://git-scm.com/docs/gitrepository-layout#Documentation/gitrepository-layout.txt).
 	// NOTE: This option will only work with the filesystem storage.
+	//
+	// Deprecated: the commondir file of the linked worktrees is always
+	// followed.
 	EnableDotGitCommonDir bool
+	// CeilingDirectories are the directories DetectDotGit doesn't walk up
+	// into, as GIT_CEILING_DIRECTORIES.
+	CeilingDirectories []string
+	// DiscoveryAcrossFilesystem lets DetectDotGit walk up the parent
+	// directories across filesystem boundaries, as
+	// GIT_DISCOVERY_ACROSS_FILESYSTEM. By default the walk stops at them.
+	DiscoveryAcrossFilesystem bool
+	// Namespace scopes the repository to a ref namespace, as GIT_NAMESPACE.
+	// Its references are read and written without the prefix of the
+	// namespace, the ones outside of it are hidden.
+	Namespace string
 }
 
 // Validate validates the fields and sets the default values.
 func (o *PlainOpenOptions) Validate() error { return nil }
 
+var (
+	ErrSeparateGitDirBare = errors.New("SeparateGitDir can't be used with Bare")
+)
+
+// PlainInitOptions describes how a repository is created by
+// PlainInitWithOptions. The DefaultBranch of InitOptions is the branch HEAD
+// initially points to, the init.defaultBranch config or master by default.
 type PlainInitOptions struct {
 	InitOptions
+	// InitialBranch is an alias of InitOptions.DefaultBranch, named as the
+	// --initial-branch flag of git init. DefaultBranch takes precedence.
+	InitialBranch plumbing.ReferenceName
 	// Determines if the repository will have a worktree (non-bare) or not (bare).
 	Bare         bool
 	ObjectFormat formatcfg.ObjectFormat
+	// TemplateDir is the directory whose files, such as hooks, info/exclude
+	// or config, are copied into the git directory, without overwriting the
+	// ones created by go-git, as the --template flag of git init does. It
+	// defaults to the init.templateDir config, nothing is copied if it's
+	// unset or doesn't exist.
+	TemplateDir string
+	// SeparateGitDir, if not empty, is the path the git directory is
+	// created at, instead of the .git directory of the worktree, which gets
+	// a .git file pointing to it, as the --separate-git-dir flag of git init
+	// does.
+	SeparateGitDir string
 }
 
 // Validate validates the fields and sets the default values.
-func (o *PlainInitOptions) Validate() error { return nil }
+func (o *PlainInitOptions) Validate() error {
+	if o.Bare && o.SeparateGitDir != "" {
+		return ErrSeparateGitDirBare
+	}
+
+	return nil
+}
 
 var (
 	ErrNoRestorePaths = errors.New("you must specify path(s) to restore")
@@ -816,3 +1535,178 @@ func (o *RestoreOptions) Validate() error {
 
 	return nil
 }
+
+// BundleOptions describes how a bundle should be created.
+type BundleOptions struct {
+	// Refs are the references included in the bundle. If empty, HEAD and
+	// every branch and tag of the repository are included.
+	Refs []plumbing.ReferenceName
+	// Basis are commits the receiver of the bundle is known to have. The
+	// objects reachable from them are not included and they are recorded as
+	// prerequisites of the bundle.
+	Basis []plumbing.Hash
+	// Since, if not zero, leaves out the commits older than the given time.
+	// The left out parents of the included commits are recorded as
+	// prerequisites.
+	Since time.Time
+}
+
+// Validate validates the fields and sets the default values.
+func (o *BundleOptions) Validate(r *Repository) error {
+	if len(o.Refs) != 0 {
+		return nil
+	}
+
+	if _, err := r.Head(); err == nil {
+		o.Refs = append(o.Refs, plumbing.HEAD)
+	} else if err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+
+	refs, err := r.References()
+	if err != nil {
+		return err
+	}
+
+	return refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name().IsBranch() || ref.Name().IsTag() {
+			o.Refs = append(o.Refs, ref.Name())
+		}
+
+		return nil
+	})
+}
+
+// WalkOptions describes how the objects are walked by
+// Repository.WalkObjects.
+type WalkOptions struct {
+	// Include are the objects to walk from, commits, tags, trees or blobs.
+	// If empty, HEAD is used.
+	Include []plumbing.Hash
+	// Exclude are objects whose history is not walked, as the ^<rev>
+	// arguments of `git rev-list --objects`: the commits reachable from them
+	// are left out, with the trees and blobs of the commits at the boundary.
+	Exclude []plumbing.Hash
+	// Types, if not empty, only returns the objects of the given types. The
+	// objects of other types are still walked.
+	Types []plumbing.ObjectType
+	// PathPrefix, if not empty, only returns the trees and blobs at or under
+	// the given path, with forward slashes. The subtrees unrelated to it are
+	// not walked, and the trees leading to it are walked but not returned.
+	PathPrefix string
+}
+
+// Validate validates the fields and sets the default values.
+func (o *WalkOptions) Validate(r *Repository) error {
+	o.PathPrefix = strings.Trim(path.Clean("/"+o.PathPrefix), "/")
+	if len(o.Include) != 0 {
+		return nil
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+
+	o.Include = []plumbing.Hash{head.Hash()}
+	return nil
+}
+
+// SizeOptions describes how the size of a repository is reported by
+// Repository.SizeReport.
+type SizeOptions struct {
+	// TopN is the number of largest blobs reported, 10 by default.
+	TopN int
+	// ByPath, if true, reports the size of the blobs accumulated by
+	// directory.
+	ByPath bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *SizeOptions) Validate() error {
+	if o.TopN <= 0 {
+		o.TopN = 10
+	}
+
+	return nil
+}
+
+// ErrInvalidRefSortKey is returned by ForEachRef when a sort key of the
+// options isn't supported.
+var ErrInvalidRefSortKey = errors.New("invalid ref sort key")
+
+// Ref sort keys of ForEachRefOptions.SortBy, prefixed with "-" to sort in
+// descending order.
+const (
+	// SortByRefName sorts the references by name.
+	SortByRefName = "refname"
+	// SortByCreatorDate sorts the references by the date of the tagger of
+	// an annotated tag, or of the committer of a commit.
+	SortByCreatorDate = "creatordate"
+	// SortByCommitterDate sorts the references by the date of the committer
+	// of the commit they point to, the ones not pointing to a commit first.
+	SortByCommitterDate = "committerdate"
+)
+
+// ForEachRefOptions describes how the references are listed by
+// Repository.ForEachRef.
+type ForEachRefOptions struct {
+	// Patterns only lists the references matching one of the patterns, as
+	// `git for-each-ref <pattern>...` does: a pattern matches the name of a
+	// reference if it's equal to it or to one of its leading directories,
+	// such as "refs/heads", or as a glob whose wildcards don't match
+	// slashes, such as "refs/tags/v1.*".
+	Patterns []string
+	// SortBy are the keys the references are sorted by, the first one
+	// being the primary one, as the --sort options of git for-each-ref:
+	// SortByRefName, SortByCreatorDate or SortByCommitterDate, prefixed with
+	// "-" to sort in descending order. The references are sorted by name
+	// by default, and when the keys are equal.
+	SortBy []string
+	// Count, if positive, only lists the first Count references, once
+	// sorted.
+	Count int
+}
+
+// Validate validates the fields and sets the default values.
+func (o *ForEachRefOptions) Validate() error {
+	for _, key := range o.SortBy {
+		switch strings.TrimPrefix(key, "-") {
+		case SortByRefName, SortByCreatorDate, SortByCommitterDate:
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalidRefSortKey, key)
+		}
+	}
+
+	return nil
+}
+
+const (
+	// DefaultReflogExpiry is the age of the reflog entries removed by
+	// ExpireReflog by default, same as the gc.reflogExpire default of git.
+	DefaultReflogExpiry = 90 * 24 * time.Hour
+	// DefaultReflogExpiryUnreachable is the age of the reflog entries whose
+	// commit isn't reachable from the reference removed by ExpireReflog by
+	// default, same as the gc.reflogExpireUnreachable default of git.
+	DefaultReflogExpiryUnreachable = 30 * 24 * time.Hour
+)
+
+// ExpireReflogOptions describes how the reflogs are expired by
+// Repository.ExpireReflog.
+type ExpireReflogOptions struct {
+	// Ref is the reference whose reflog is expired, HEAD if empty.
+	Ref plumbing.ReferenceName
+	// All expires the reflogs of all the references, and of HEAD, instead
+	// of the one of Ref.
+	All bool
+	// Expire is the time before which the entries are removed. If zero,
+	// the gc.<pattern>.reflogExpire or gc.reflogExpire config of the
+	// reference is used, DefaultReflogExpiry ago if there is none.
+	Expire time.Time
+	// ExpireUnreachable is the time before which the entries whose commit
+	// isn't reachable from the reference are removed. If zero, the
+	// gc.<pattern>.reflogExpireUnreachable or gc.reflogExpireUnreachable
+	// config of the reference is used, DefaultReflogExpiryUnreachable ago
+	// if there is none.
+	ExpireUnreachable time.Time
+}
@@ -1,10 +1,9 @@
 package git
 
 import (
-	"os"
-
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/internal/test"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	. "gopkg.in/check.v1"
@@ -105,8 +104,6 @@ func (s *OptionsSuite) writeGlobalConfig(c *C, cfg *config.Config) func() {
 	err = fs.MkdirAll(fs.Join(tmp, "git"), 0777)
 	c.Assert(err, IsNil)
 
-	os.Setenv("XDG_CONFIG_HOME", fs.Join(fs.Root(), tmp))
-
 	content, err := cfg.Marshal()
 	c.Assert(err, IsNil)
 
@@ -114,8 +111,9 @@ func (s *OptionsSuite) writeGlobalConfig(c *C, cfg *config.Config) func() {
 	err = util.WriteFile(fs, cfgFile, content, 0777)
 	c.Assert(err, IsNil)
 
-	return func() {
-		os.Setenv("XDG_CONFIG_HOME", "")
-
-	}
+	return test.SetEnv(map[string]string{
+		"XDG_CONFIG_HOME":     fs.Join(fs.Root(), tmp),
+		"GIT_CONFIG_GLOBAL":   fs.Join(fs.Root(), cfgFile),
+		"GIT_CONFIG_NOSYSTEM": "true",
+	})
 }
@@ -25,6 +25,14 @@ type Object interface {
 	Clear()
 }
 
+// Stats are the counters of the lookups in a cache.
+type Stats struct {
+	// Hits is the number of lookups that found the item.
+	Hits uint64
+	// Misses is the number of lookups that didn't find the item.
+	Misses uint64
+}
+
 // Buffer is an interface to a buffer cache.
 type Buffer interface {
 	// Put puts a buffer into the cache. If the buffer is already in the cache,
@@ -11,11 +11,15 @@ import (
 // maximum size (measured in object size).
 type ObjectLRU struct {
 	MaxSize FileSize
+	// MaxObjectSize is the size above which the objects aren't cached. If 0,
+	// the objects up to MaxSize are cached.
+	MaxObjectSize FileSize
 
 	actualSize FileSize
 	ll         *list.List
 	cache      map[interface{}]*list.Element
 	mut        sync.Mutex
+	stats      Stats
 }
 
 // NewObjectLRU creates a new ObjectLRU with the given maximum size. The maximum
@@ -51,7 +55,7 @@ func (c *ObjectLRU) Put(obj plumbing.EncodedObject) {
 		c.ll.MoveToFront(ee)
 		ee.Value = obj
 	} else {
-		if objSize > c.MaxSize {
+		if objSize > c.MaxSize || (c.MaxObjectSize > 0 && objSize > c.MaxObjectSize) {
 			return
 		}
 		ee := c.ll.PushFront(obj)
@@ -83,13 +87,24 @@ func (c *ObjectLRU) Get(k plumbing.Hash) (plumbing.EncodedObject, bool) {
 
 	ee, ok := c.cache[k]
 	if !ok {
+		c.stats.Misses++
 		return nil, false
 	}
 
+	c.stats.Hits++
 	c.ll.MoveToFront(ee)
 	return ee.Value.(plumbing.EncodedObject), true
 }
 
+// Stats returns the counters of the lookups in this object cache, since it
+// was created.
+func (c *ObjectLRU) Stats() Stats {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.stats
+}
+
 // Clear the content of this object cache.
 func (c *ObjectLRU) Clear() {
 	c.mut.Lock()
@@ -166,6 +166,34 @@ func (s *ObjectSuite) TestObjectUpdateOverflow(c *C) {
 	o.Put(b)
 }
 
+func (s *ObjectSuite) TestMaxObjectSize(c *C) {
+	o := NewObjectLRU(9 * Byte)
+	o.MaxObjectSize = 2 * Byte
+
+	o.Put(s.aObject)
+	o.Put(s.bObject)
+
+	_, ok := o.Get(s.aObject.Hash())
+	c.Assert(ok, Equals, true)
+	_, ok = o.Get(s.bObject.Hash())
+	c.Assert(ok, Equals, false)
+}
+
+func (s *ObjectSuite) TestStats(c *C) {
+	o := NewObjectLRUDefault()
+	o.Put(s.aObject)
+
+	o.Get(s.aObject.Hash())
+	o.Get(s.aObject.Hash())
+	o.Get(s.bObject.Hash())
+	c.Assert(o.Stats(), Equals, Stats{Hits: 2, Misses: 1})
+
+	// Clearing the cache keeps the counters.
+	o.Clear()
+	o.Get(s.aObject.Hash())
+	c.Assert(o.Stats(), Equals, Stats{Hits: 2, Misses: 2})
+}
+
 type dummyObject struct {
 	hash plumbing.Hash
 	size FileSize
@@ -18,6 +18,11 @@ func (e *PermanentError) Error() string {
 	return fmt.Sprintf("permanent client error: %s", e.Err.Error())
 }
 
+// Unwrap returns the underlying error, such as a TLS or a network one.
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
 type UnexpectedError struct {
 	Err error
 }
@@ -33,3 +38,8 @@ func NewUnexpectedError(err error) *UnexpectedError {
 func (e *UnexpectedError) Error() string {
 	return fmt.Sprintf("unexpected client error: %s", e.Err.Error())
 }
+
+// Unwrap returns the underlying error, such as a TLS or a network one.
+func (e *UnexpectedError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,197 @@
+package bitmap
+
+import (
+	"io"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+)
+
+const (
+	// VersionSupported is the only bitmap file version supported.
+	VersionSupported = 1
+
+	// FlagFullDAG is set when the bitmaps are closed under reachability,
+	// it is required.
+	FlagFullDAG = 0x1
+	// FlagHashCache is set when the file holds the name-hash cache.
+	FlagHashCache = 0x4
+	// FlagLookupTable is set when the file holds the lookup table of the
+	// entries.
+	FlagLookupTable = 0x10
+)
+
+// MemoryIndex is the in memory representation of a pack bitmap file.
+type MemoryIndex struct {
+	Version uint16
+	Flags   uint16
+	// PackfileChecksum is the checksum of the packfile of the bitmaps, which
+	// names it.
+	PackfileChecksum [hash.Size]byte
+	// Commits, Trees, Blobs and Tags have the bits of the objects of each
+	// type set.
+	Commits, Trees, Blobs, Tags *Bitmap
+	// Entries are the bitmapped commits.
+	Entries []*Entry
+	// NameHashes are the hashes of the paths of the objects, in pack order,
+	// if the file has a name-hash cache.
+	NameHashes []uint32
+	Checksum   [hash.Size]byte
+}
+
+// NewMemoryIndex returns an instance of a new MemoryIndex.
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{}
+}
+
+// Entry is a bitmapped commit.
+type Entry struct {
+	// Position is the position of the commit in the idx file, sorted by hash.
+	Position uint32
+	Flags    uint8
+	// Bitmap has the bits of the objects reachable from the commit set.
+	Bitmap *Bitmap
+}
+
+// PackBitmap gives the objects of a packfile reachable from its bitmapped
+// commits, joining its bitmap file with its idx file.
+type PackBitmap struct {
+	idx      idxfile.Index
+	hashes   []plumbing.Hash
+	offsets  []int64
+	bitmaps  map[plumbing.Hash]*Bitmap
+	typeMaps [4]*Bitmap
+}
+
+// NewPackBitmap returns the PackBitmap of the bitmap file b, given the idx
+// file of its packfile. ErrPackfileMismatch is returned if the idx file is
+// the one of another packfile, and ErrMalformedBitmap if the bitmaps do not
+// match it.
+func NewPackBitmap(b *MemoryIndex, idx idxfile.Index) (*PackBitmap, error) {
+	if m, ok := idx.(*idxfile.MemoryIndex); ok && m.PackfileChecksum != b.PackfileChecksum {
+		return nil, ErrPackfileMismatch
+	}
+
+	count, err := idx.Count()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PackBitmap{
+		idx:      idx,
+		hashes:   make([]plumbing.Hash, 0, count),
+		offsets:  make([]int64, 0, count),
+		bitmaps:  make(map[plumbing.Hash]*Bitmap, len(b.Entries)),
+		typeMaps: [4]*Bitmap{b.Commits, b.Trees, b.Blobs, b.Tags},
+	}
+
+	err = forEachEntry(idx.EntriesByOffset, func(e *idxfile.Entry) {
+		p.hashes = append(p.hashes, e.Hash)
+		p.offsets = append(p.offsets, int64(e.Offset))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byPosition := make(map[uint32]*Bitmap, len(b.Entries))
+	for _, e := range b.Entries {
+		byPosition[e.Position] = e.Bitmap
+	}
+
+	var i uint32
+	err = forEachEntry(idx.Entries, func(e *idxfile.Entry) {
+		if bm, ok := byPosition[i]; ok {
+			p.bitmaps[e.Hash] = bm
+		}
+		i++
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.bitmaps) != len(b.Entries) {
+		return nil, ErrMalformedBitmap
+	}
+
+	var typed int
+	for _, tm := range p.typeMaps {
+		typed += tm.Count()
+	}
+
+	if typed != len(p.hashes) {
+		return nil, ErrMalformedBitmap
+	}
+
+	return p, nil
+}
+
+func forEachEntry(entries func() (idxfile.EntryIter, error), cb func(*idxfile.Entry)) error {
+	iter, err := entries()
+	if err != nil {
+		return err
+	}
+
+	defer iter.Close()
+	for {
+		e, err := iter.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		cb(e)
+	}
+}
+
+// Count returns the number of objects of the packfile.
+func (p *PackBitmap) Count() int {
+	return len(p.hashes)
+}
+
+// Reachable returns the bitmap of the objects reachable from the commit, if
+// it is bitmapped. The bitmap must not be modified.
+func (p *PackBitmap) Reachable(commit plumbing.Hash) (*Bitmap, bool) {
+	b, ok := p.bitmaps[commit]
+	return b, ok
+}
+
+// Position returns the position of the object in the packfile, sorted by
+// offset, which is its bit in the bitmaps, if it is in the packfile.
+func (p *PackBitmap) Position(h plumbing.Hash) (int, bool) {
+	offset, err := p.idx.FindOffset(h)
+	if err != nil {
+		return 0, false
+	}
+
+	i := sort.Search(len(p.offsets), func(i int) bool {
+		return p.offsets[i] >= offset
+	})
+
+	return i, i < len(p.offsets) && p.offsets[i] == offset
+}
+
+// Hash returns the hash of the object at the position i of the packfile.
+func (p *PackBitmap) Hash(i int) plumbing.Hash {
+	return p.hashes[i]
+}
+
+// ObjectType returns the type of the object at the position i of the
+// packfile.
+func (p *PackBitmap) ObjectType(i int) plumbing.ObjectType {
+	for j, t := range []plumbing.ObjectType{
+		plumbing.CommitObject,
+		plumbing.TreeObject,
+		plumbing.BlobObject,
+		plumbing.TagObject,
+	} {
+		if p.typeMaps[j].Get(i) {
+			return t
+		}
+	}
+
+	return plumbing.InvalidObject
+}
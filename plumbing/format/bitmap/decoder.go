@@ -0,0 +1,149 @@
+package bitmap
+
+import (
+	"bytes"
+	encbin "encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing/hash"
+)
+
+var (
+	// ErrUnsupportedVersion is returned by Decode when the bitmap file version
+	// is not supported.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	// ErrUnsupportedOptions is returned by Decode when the bitmaps are not
+	// closed under reachability.
+	ErrUnsupportedOptions = errors.New("unsupported bitmap options")
+	// ErrMalformedBitmap is returned by Decode when the bitmap file is
+	// corrupted.
+	ErrMalformedBitmap = errors.New("malformed bitmap file")
+	// ErrPackfileMismatch is returned by NewPackBitmap when the bitmap file
+	// is the one of another packfile.
+	ErrPackfileMismatch = errors.New("bitmap file of another packfile")
+
+	bitmapHeader = []byte{'B', 'I', 'T', 'M'}
+)
+
+const (
+	szHeader = 12 + hash.Size
+	// maxXorOffset is the maximum XOR offset of an entry, as written by git.
+	maxXorOffset = 160
+)
+
+// Decoder reads and decodes pack bitmap files from an input stream.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder builds a new pack bitmap stream decoder, that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r}
+}
+
+// Decode reads from the stream and decode the content into the MemoryIndex
+// struct. The whole file is read and its checksum is verified, the bitmaps
+// XORed with others are resolved.
+func (d *Decoder) Decode(idx *MemoryIndex) error {
+	data, err := io.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < szHeader+hash.Size || !bytes.Equal(data[:4], bitmapHeader) {
+		return ErrMalformedBitmap
+	}
+
+	content, checksum := data[:len(data)-hash.Size], data[len(data)-hash.Size:]
+	h := hash.New(hash.CryptoType)
+	h.Write(content)
+	if !bytes.Equal(h.Sum(nil), checksum) {
+		return ErrMalformedBitmap
+	}
+	copy(idx.Checksum[:], checksum)
+
+	idx.Version = encbin.BigEndian.Uint16(data[4:])
+	if idx.Version != VersionSupported {
+		return ErrUnsupportedVersion
+	}
+
+	idx.Flags = encbin.BigEndian.Uint16(data[6:])
+	if idx.Flags&FlagFullDAG == 0 {
+		return ErrUnsupportedOptions
+	}
+
+	count := int(encbin.BigEndian.Uint32(data[8:]))
+	copy(idx.PackfileChecksum[:], data[12:szHeader])
+
+	rest := content[szHeader:]
+	for _, b := range []**Bitmap{&idx.Commits, &idx.Trees, &idx.Blobs, &idx.Tags} {
+		bm, n, err := DecodeEWAH(rest)
+		if err != nil {
+			return err
+		}
+
+		*b, rest = bm, rest[n:]
+	}
+
+	if rest, err = readEntries(idx, rest, count); err != nil {
+		return err
+	}
+
+	if idx.Flags&FlagHashCache != 0 {
+		return readNameHashes(idx, rest)
+	}
+
+	return nil
+}
+
+func readEntries(idx *MemoryIndex, data []byte, count int) ([]byte, error) {
+	idx.Entries = make([]*Entry, 0, count)
+	for i := 0; i < count; i++ {
+		if len(data) < 6 {
+			return nil, ErrMalformedBitmap
+		}
+
+		e := &Entry{
+			Position: encbin.BigEndian.Uint32(data),
+			Flags:    data[5],
+		}
+
+		xor := int(data[4])
+		bm, n, err := DecodeEWAH(data[6:])
+		if err != nil {
+			return nil, err
+		}
+		data = data[6+n:]
+
+		if xor > 0 {
+			if xor > i || xor > maxXorOffset {
+				return nil, ErrMalformedBitmap
+			}
+
+			bm.Xor(idx.Entries[i-xor].Bitmap)
+		}
+
+		e.Bitmap = bm
+		idx.Entries = append(idx.Entries, e)
+	}
+
+	return data, nil
+}
+
+// readNameHashes reads the name-hash cache, one per object, the number of
+// objects is the number of bits of the type bitmaps. The lookup table that
+// may follow is not needed, as all the entries are read.
+func readNameHashes(idx *MemoryIndex, data []byte) error {
+	count := idx.Commits.Count() + idx.Trees.Count() + idx.Blobs.Count() + idx.Tags.Count()
+	if len(data) < count*4 {
+		return ErrMalformedBitmap
+	}
+
+	idx.NameHashes = make([]uint32, count)
+	for i := range idx.NameHashes {
+		idx.NameHashes[i] = encbin.BigEndian.Uint32(data[i*4:])
+	}
+
+	return nil
+}
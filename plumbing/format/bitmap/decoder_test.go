@@ -0,0 +1,249 @@
+package bitmap_test
+
+import (
+	"bytes"
+	encbin "encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/go-git/go-git/v5/internal/test"
+	"github.com/go-git/go-git/v5/plumbing"
+	. "github.com/go-git/go-git/v5/plumbing/format/bitmap"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type BitmapSuite struct {
+	fixtures.Suite
+}
+
+var _ = Suite(&BitmapSuite{})
+
+// The testdata files are the idx and bitmap files written by
+// `git repack -adb` for the basic fixture.
+const testdataPack = "testdata/pack-ca7509c8118d8d2cc0977d9045311a8a627532d6"
+
+func (s *BitmapSuite) decodeTestdata(c *C) (*MemoryIndex, *idxfile.MemoryIndex) {
+	f, err := os.Open(testdataPack + ".bitmap")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	b := NewMemoryIndex()
+	c.Assert(NewDecoder(f).Decode(b), IsNil)
+
+	fi, err := os.Open(testdataPack + ".idx")
+	c.Assert(err, IsNil)
+	defer fi.Close()
+
+	idx := idxfile.NewMemoryIndex()
+	c.Assert(idxfile.NewDecoder(fi).Decode(idx), IsNil)
+	return b, idx
+}
+
+func (s *BitmapSuite) TestDecode(c *C) {
+	b, _ := s.decodeTestdata(c)
+	c.Assert(b.Version, Equals, uint16(1))
+	c.Assert(b.Flags, Equals, uint16(FlagFullDAG|FlagHashCache))
+	c.Assert(plumbing.Hash(b.PackfileChecksum).String(), Equals, "ca7509c8118d8d2cc0977d9045311a8a627532d6")
+	c.Assert(b.Entries, HasLen, 9)
+	c.Assert(b.Commits.Count(), Equals, 9)
+	c.Assert(b.Trees.Count(), Equals, 12)
+	c.Assert(b.Blobs.Count(), Equals, 10)
+	c.Assert(b.Tags.Count(), Equals, 0)
+	c.Assert(b.NameHashes, HasLen, 31)
+}
+
+func (s *BitmapSuite) TestPackBitmap(c *C) {
+	b, idx := s.decodeTestdata(c)
+	p, err := NewPackBitmap(b, idx)
+	c.Assert(err, IsNil)
+	c.Assert(p.Count(), Equals, 31)
+
+	// The counts are the ones of `git rev-list --objects`.
+	master := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	reachable, ok := p.Reachable(master)
+	c.Assert(ok, Equals, true)
+	c.Assert(reachable.Count(), Equals, 28)
+
+	branch := plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	reachable, ok = p.Reachable(branch)
+	c.Assert(ok, Equals, true)
+	c.Assert(reachable.Count(), Equals, 27)
+
+	pos, ok := p.Position(master)
+	c.Assert(ok, Equals, true)
+	c.Assert(p.Hash(pos), Equals, master)
+	c.Assert(p.ObjectType(pos), Equals, plumbing.CommitObject)
+	c.Assert(reachable.Get(pos), Equals, false)
+
+	reachable.ForEach(func(i int) {
+		h := p.Hash(i)
+		pos, ok := p.Position(h)
+		c.Assert(ok, Equals, true)
+		c.Assert(pos, Equals, i)
+	})
+
+	_, ok = p.Reachable(plumbing.NewHash("a8d315b2b1c615d43042c3a62402b8a54288cf5c"))
+	c.Assert(ok, Equals, false)
+
+	_, ok = p.Position(plumbing.ZeroHash)
+	c.Assert(ok, Equals, false)
+}
+
+func (s *BitmapSuite) TestPackBitmapMismatch(c *C) {
+	b, _ := s.decodeTestdata(c)
+
+	idx := idxfile.NewMemoryIndex()
+	c.Assert(idxfile.NewDecoder(fixtures.Basic().One().Idx()).Decode(idx), IsNil)
+
+	_, err := NewPackBitmap(b, idx)
+	c.Assert(err, Equals, ErrPackfileMismatch)
+}
+
+func (s *BitmapSuite) TestDecodeXor(c *C) {
+	// The bitmap of the second entry is XORed with the one of the first.
+	data := encodeBitmapFile(FlagFullDAG, []testEntry{
+		{position: 0, bitmap: []uint64{0x7}},
+		{position: 1, xor: 1, bitmap: []uint64{0x8}},
+	})
+
+	b := NewMemoryIndex()
+	c.Assert(NewDecoder(bytes.NewReader(data)).Decode(b), IsNil)
+	c.Assert(b.Entries, HasLen, 2)
+	c.Assert(bitmapPositions(b.Entries[0].Bitmap), DeepEquals, []int{0, 1, 2})
+	c.Assert(bitmapPositions(b.Entries[1].Bitmap), DeepEquals, []int{0, 1, 2, 3})
+	c.Assert(b.Entries[1].Position, Equals, uint32(1))
+	c.Assert(b.NameHashes, IsNil)
+}
+
+func (s *BitmapSuite) TestDecodeRunningLengthWords(c *C) {
+	b := NewMemoryIndex()
+	data := encodeBitmapFile(FlagFullDAG, []testEntry{
+		{position: 0, bitmap: []uint64{0, 0, ^uint64(0), 0x1}},
+	})
+	c.Assert(NewDecoder(bytes.NewReader(data)).Decode(b), IsNil)
+
+	bm := b.Entries[0].Bitmap
+	c.Assert(bm.Count(), Equals, 65)
+	c.Assert(bm.Get(127), Equals, false)
+	c.Assert(bm.Get(128), Equals, true)
+	c.Assert(bm.Get(191), Equals, true)
+	c.Assert(bm.Get(192), Equals, true)
+	c.Assert(bm.Get(193), Equals, false)
+}
+
+func (s *BitmapSuite) TestDecodeUnsupportedOptions(c *C) {
+	data := encodeBitmapFile(0, nil)
+	err := NewDecoder(bytes.NewReader(data)).Decode(NewMemoryIndex())
+	c.Assert(err, Equals, ErrUnsupportedOptions)
+}
+
+func (s *BitmapSuite) TestDecodeUnsupportedVersion(c *C) {
+	data := encodeBitmapFile(FlagFullDAG, nil)
+	data[5] = 2
+	err := NewDecoder(bytes.NewReader(test.Resum(data))).Decode(NewMemoryIndex())
+	c.Assert(err, Equals, ErrUnsupportedVersion)
+}
+
+func (s *BitmapSuite) TestDecodeMalformed(c *C) {
+	data, err := os.ReadFile(testdataPack + ".bitmap")
+	c.Assert(err, IsNil)
+
+	// Wrong checksum.
+	corrupted := append([]byte(nil), data...)
+	corrupted[40]++
+	err = NewDecoder(bytes.NewReader(corrupted)).Decode(NewMemoryIndex())
+	c.Assert(err, Equals, ErrMalformedBitmap)
+
+	// Truncated, with a valid checksum.
+	truncated := append([]byte(nil), data[:100]...)
+	truncated = append(truncated, make([]byte, hash.Size)...)
+	err = NewDecoder(bytes.NewReader(test.Resum(truncated))).Decode(NewMemoryIndex())
+	c.Assert(err, Equals, ErrMalformedBitmap)
+
+	// XOR offset before the first entry.
+	data = encodeBitmapFile(FlagFullDAG, []testEntry{
+		{position: 0, xor: 1, bitmap: []uint64{0x1}},
+	})
+	err = NewDecoder(bytes.NewReader(data)).Decode(NewMemoryIndex())
+	c.Assert(err, Equals, ErrMalformedBitmap)
+}
+
+type testEntry struct {
+	position uint32
+	xor      uint8
+	bitmap   []uint64
+}
+
+// encodeBitmapFile encodes a bitmap file of a packfile with objects of
+// unknown types, with the given entries. The zero words and the all ones
+// words are compressed with running-length words.
+func encodeBitmapFile(flags uint16, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{'B', 'I', 'T', 'M'})
+	_ = encbin.Write(&buf, encbin.BigEndian, uint16(1))
+	_ = encbin.Write(&buf, encbin.BigEndian, flags)
+	_ = encbin.Write(&buf, encbin.BigEndian, uint32(len(entries)))
+	buf.Write(make([]byte, hash.Size))
+
+	for i := 0; i < 4; i++ {
+		buf.Write(encodeEWAH(nil))
+	}
+
+	for _, e := range entries {
+		_ = encbin.Write(&buf, encbin.BigEndian, e.position)
+		buf.WriteByte(e.xor)
+		buf.WriteByte(0)
+		buf.Write(encodeEWAH(e.bitmap))
+	}
+
+	buf.Write(make([]byte, hash.Size))
+	return test.Resum(buf.Bytes())
+}
+
+func encodeEWAH(words []uint64) []byte {
+	isFill := func(w uint64) bool { return w == 0 || w == ^uint64(0) }
+
+	var out []uint64
+	for i := 0; i < len(words); {
+		var rlw uint64
+		if isFill(words[i]) {
+			fill := words[i]
+			rlw = fill & 1
+			for ; i < len(words) && words[i] == fill; i++ {
+				rlw += 1 << 1
+			}
+		}
+
+		start := i
+		for ; i < len(words) && !isFill(words[i]); i++ {
+			rlw += 1 << 33
+		}
+
+		out = append(out, rlw)
+		out = append(out, words[start:i]...)
+	}
+
+	var buf bytes.Buffer
+	_ = encbin.Write(&buf, encbin.BigEndian, uint32(len(words)*64))
+	_ = encbin.Write(&buf, encbin.BigEndian, uint32(len(out)))
+	for _, w := range out {
+		_ = encbin.Write(&buf, encbin.BigEndian, w)
+	}
+	_ = encbin.Write(&buf, encbin.BigEndian, uint32(0))
+	return buf.Bytes()
+}
+
+func bitmapPositions(b *Bitmap) []int {
+	var positions []int
+	b.ForEach(func(i int) {
+		positions = append(positions, i)
+	})
+
+	return positions
+}
@@ -0,0 +1,69 @@
+// Package bitmap implements encoding and decoding of pack bitmap files.
+//
+// A pack bitmap file, stored along a packfile as pack-<hash>.bitmap, holds
+// the reachability bitmaps of some of the commits of the packfile: for each
+// of these commits, a bitmap with one bit per object of the packfile, set if
+// the object is reachable from the commit. They are used to compute the
+// objects reachable from commits without walking their history and trees.
+//
+// The bits of the bitmaps are the positions of the objects in the packfile,
+// sorted by offset, and the bitmaps are compressed with EWAH.
+//
+// == Original (version 1) bitmap format ==
+//
+//   - A header appears at the beginning:
+//
+//     4-byte signature: {'B', 'I', 'T', 'M'}
+//
+//     2-byte version number (network byte order): 1
+//
+//     2-byte flags (network byte order). BITMAP_OPT_FULL_DAG (0x1) is
+//     required, the bitmaps are closed: every object reachable from a
+//     bitmapped commit is in the packfile. BITMAP_OPT_HASH_CACHE (0x4) is set
+//     when a name-hash cache follows the entries, BITMAP_OPT_LOOKUP_TABLE
+//     (0x10) when a lookup table of the entries follows the name-hash cache.
+//
+//     4-byte entry count (network byte order): the number of bitmapped
+//     commits.
+//
+//     20-byte checksum: the SHA-1 checksum of the packfile.
+//
+//   - 4 EWAH bitmaps, one per object type, with the bits of the objects of
+//     the type set: commits, trees, blobs and tags.
+//
+//   - N entries of bitmapped commits, each of:
+//
+//     4-byte object position (network byte order): the position of the
+//     commit in the idx file of the packfile, sorted by hash.
+//
+//     1-byte XOR offset: if not 0, the bitmap of the entry is XORed with
+//     the one of the entry that many entries before.
+//
+//     1-byte flags.
+//
+//     EWAH bitmap of the objects reachable from the commit.
+//
+//   - If BITMAP_OPT_HASH_CACHE is set, one 4-byte name-hash (network byte
+//     order) per object of the packfile, in pack order.
+//
+//   - If BITMAP_OPT_LOOKUP_TABLE is set, N 16-byte entries of commit position,
+//     offset of the entry in the file and XOR position.
+//
+//   - A 20-byte SHA-1 checksum of all of the above.
+//
+// == EWAH bitmaps ==
+//
+//	4-byte number of bits (network byte order).
+//
+//	4-byte number of words (network byte order).
+//
+//	8-byte words (network byte order), alternating run-length words and
+//	literal words. A run-length word holds, from the least significant
+//	bit, the running bit, a 32-bit number of words of running bits and a
+//	31-bit number of literal words following it.
+//
+//	4-byte position of the last run-length word (network byte order).
+//
+// Source:
+// https://github.com/git/git/blob/master/Documentation/technical/bitmap-format.txt
+package bitmap
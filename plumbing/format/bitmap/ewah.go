@@ -0,0 +1,192 @@
+package bitmap
+
+import (
+	encbin "encoding/binary"
+	"math/bits"
+)
+
+const (
+	rlwRunningLenBits = 32
+	rlwRunningLenMask = 1<<rlwRunningLenBits - 1
+	rlwLiteralShift   = 1 + rlwRunningLenBits
+	rlwMaxLiterals    = 1<<(64-rlwLiteralShift) - 1
+)
+
+// Bitmap is an uncompressed set of object positions.
+type Bitmap struct {
+	words []uint64
+}
+
+// NewBitmap returns an empty bitmap.
+func NewBitmap() *Bitmap {
+	return &Bitmap{}
+}
+
+// Get returns whether the bit at the position i is set.
+func (b *Bitmap) Get(i int) bool {
+	w := i / 64
+	return w < len(b.words) && b.words[w]&(1<<(uint(i)%64)) != 0
+}
+
+// Set sets the bit at the position i.
+func (b *Bitmap) Set(i int) {
+	b.grow(i/64 + 1)
+	b.words[i/64] |= 1 << (uint(i) % 64)
+}
+
+// Or sets the bits set in other.
+func (b *Bitmap) Or(other *Bitmap) {
+	b.grow(len(other.words))
+	for i, w := range other.words {
+		b.words[i] |= w
+	}
+}
+
+// AndNot clears the bits set in other.
+func (b *Bitmap) AndNot(other *Bitmap) {
+	for i := 0; i < len(b.words) && i < len(other.words); i++ {
+		b.words[i] &^= other.words[i]
+	}
+}
+
+// Xor toggles the bits set in other.
+func (b *Bitmap) Xor(other *Bitmap) {
+	b.grow(len(other.words))
+	for i, w := range other.words {
+		b.words[i] ^= w
+	}
+}
+
+// Count returns the number of bits set.
+func (b *Bitmap) Count() int {
+	var n int
+	for _, w := range b.words {
+		n += bits.OnesCount64(w)
+	}
+
+	return n
+}
+
+// ForEach calls cb with the positions of the bits set, in ascending order.
+func (b *Bitmap) ForEach(cb func(i int)) {
+	for i, w := range b.words {
+		for w != 0 {
+			cb(i*64 + bits.TrailingZeros64(w))
+			w &= w - 1
+		}
+	}
+}
+
+// Clone returns a copy of the bitmap.
+func (b *Bitmap) Clone() *Bitmap {
+	return &Bitmap{words: append([]uint64(nil), b.words...)}
+}
+
+func (b *Bitmap) grow(n int) {
+	if n > len(b.words) {
+		b.words = append(b.words, make([]uint64, n-len(b.words))...)
+	}
+}
+
+// DecodeEWAH decodes the EWAH compressed bitmap at the beginning of data, as
+// git serializes them, returning it along with its size in bytes.
+func DecodeEWAH(data []byte) (*Bitmap, int, error) {
+	if len(data) < 12 {
+		return nil, 0, ErrMalformedBitmap
+	}
+
+	size := int(encbin.BigEndian.Uint32(data))
+	count := int(encbin.BigEndian.Uint32(data[4:]))
+	if count > (len(data)-12)/8 {
+		return nil, 0, ErrMalformedBitmap
+	}
+	n := 8 + count*8 + 4
+
+	b := &Bitmap{words: make([]uint64, 0, (size+63)/64)}
+	words := data[8 : 8+count*8]
+	for len(words) > 0 {
+		rlw := encbin.BigEndian.Uint64(words)
+		words = words[8:]
+
+		running := int(rlw >> 1 & rlwRunningLenMask)
+		literals := int(rlw >> rlwLiteralShift)
+		if literals > len(words)/8 || len(b.words)+running+literals > (size+63)/64 {
+			return nil, 0, ErrMalformedBitmap
+		}
+
+		var fill uint64
+		if rlw&1 != 0 {
+			fill = ^uint64(0)
+		}
+
+		for i := 0; i < running; i++ {
+			b.words = append(b.words, fill)
+		}
+
+		for i := 0; i < literals; i++ {
+			b.words = append(b.words, encbin.BigEndian.Uint64(words))
+			words = words[8:]
+		}
+	}
+
+	return b, n, nil
+}
+
+// EncodeEWAH returns the bitmap compressed with EWAH and serialized as git
+// does, its size in bits being the position of its last bit set plus one.
+func (b *Bitmap) EncodeEWAH() []byte {
+	var size int
+	for i := len(b.words) - 1; i >= 0; i-- {
+		if b.words[i] != 0 {
+			size = i*64 + 64 - bits.LeadingZeros64(b.words[i])
+			break
+		}
+	}
+
+	n := (size + 63) / 64
+	word := func(i int) uint64 {
+		if i < len(b.words) {
+			return b.words[i]
+		}
+
+		return 0
+	}
+
+	var words []uint64
+	var rlwPos int
+	for i := 0; i < n || len(words) == 0; {
+		rlwPos = len(words)
+		words = append(words, 0)
+
+		var running uint64
+		fill := word(i)
+		if fill == 0 || fill == ^uint64(0) {
+			for i < n && word(i) == fill && running < rlwRunningLenMask {
+				running++
+				i++
+			}
+		}
+
+		var literals uint64
+		for i < n && word(i) != 0 && word(i) != ^uint64(0) && literals < rlwMaxLiterals {
+			words = append(words, word(i))
+			literals++
+			i++
+		}
+
+		rlw := running<<1 | literals<<rlwLiteralShift
+		if running > 0 && fill != 0 {
+			rlw |= 1
+		}
+		words[rlwPos] = rlw
+	}
+
+	data := make([]byte, 0, 12+8*len(words))
+	data = encbin.BigEndian.AppendUint32(data, uint32(size))
+	data = encbin.BigEndian.AppendUint32(data, uint32(len(words)))
+	for _, w := range words {
+		data = encbin.BigEndian.AppendUint64(data, w)
+	}
+
+	return encbin.BigEndian.AppendUint32(data, uint32(rlwPos))
+}
@@ -0,0 +1,69 @@
+package bitmap_test
+
+import (
+	. "github.com/go-git/go-git/v5/plumbing/format/bitmap"
+
+	. "gopkg.in/check.v1"
+)
+
+type EWAHSuite struct{}
+
+var _ = Suite(&EWAHSuite{})
+
+func (s *EWAHSuite) TestBitmapOperations(c *C) {
+	a := NewBitmap()
+	a.Set(1)
+	a.Set(70)
+	c.Assert(a.Get(1), Equals, true)
+	c.Assert(a.Get(2), Equals, false)
+	c.Assert(a.Get(1000), Equals, false)
+
+	b := NewBitmap()
+	b.Set(2)
+	b.Set(70)
+	b.Set(200)
+
+	or := a.Clone()
+	or.Or(b)
+	c.Assert(bitmapPositions(or), DeepEquals, []int{1, 2, 70, 200})
+	c.Assert(bitmapPositions(a), DeepEquals, []int{1, 70})
+
+	andNot := or.Clone()
+	andNot.AndNot(a)
+	c.Assert(bitmapPositions(andNot), DeepEquals, []int{2, 200})
+
+	xor := a.Clone()
+	xor.Xor(b)
+	c.Assert(bitmapPositions(xor), DeepEquals, []int{1, 2, 200})
+	c.Assert(xor.Count(), Equals, 3)
+}
+
+func (s *EWAHSuite) TestEncodeDecodeEWAH(c *C) {
+	b := NewBitmap()
+	b.Set(0)
+	c.Assert(b.EncodeEWAH(), DeepEquals, []byte{
+		0, 0, 0, 1, // size in bits
+		0, 0, 0, 2, // number of words
+		0, 0, 0, 2, 0, 0, 0, 0, // no running word and one literal word
+		0, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0, 0, // position of the last running length word
+	})
+
+	b = NewBitmap()
+	b.Set(3)
+	for i := 64 * 2; i < 64*5; i++ {
+		b.Set(i)
+	}
+	b.Set(64*9 + 1)
+
+	data := b.EncodeEWAH()
+	decoded, n, err := DecodeEWAH(append(data, 'x'))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(data))
+	c.Assert(bitmapPositions(decoded), DeepEquals, bitmapPositions(b))
+
+	empty, n, err := DecodeEWAH(NewBitmap().EncodeEWAH())
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 20)
+	c.Assert(empty.Count(), Equals, 0)
+}
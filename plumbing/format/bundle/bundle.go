@@ -0,0 +1,74 @@
+package bundle
+
+import (
+	"errors"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	// V2 is the version 2 of the bundle format.
+	V2 = 2
+	// V3 is the version 3 of the bundle format, it adds capabilities.
+	V3 = 3
+
+	v2Signature = "# v2 git bundle"
+	v3Signature = "# v3 git bundle"
+
+	// ObjectFormatCapability is the capability defining the hash algorithm
+	// of the objects in the bundle.
+	ObjectFormatCapability = "object-format"
+)
+
+var (
+	// ErrUnsupportedVersion is returned by Decode when the bundle version is
+	// not supported.
+	ErrUnsupportedVersion = errors.New("unsupported bundle version")
+	// ErrMalformedHeader is returned by Decode when the bundle header is
+	// corrupted.
+	ErrMalformedHeader = errors.New("malformed bundle header")
+)
+
+// Prerequisite is an object the receiver of a bundle must have to be able to
+// use the packfile contained in it.
+type Prerequisite struct {
+	Hash plumbing.Hash
+	// Comment is an optional description, usually the subject of the
+	// commit.
+	Comment string
+}
+
+// Capability is a capability of a version 3 bundle.
+type Capability struct {
+	Key   string
+	Value string
+}
+
+// Header is the header of a bundle file, everything but the packfile.
+type Header struct {
+	// Version is the bundle format version, V2 or V3.
+	Version int
+	// Capabilities are only written for V3 bundles.
+	Capabilities []Capability
+	// Prerequisites are the objects expected to exist in the receiver.
+	Prerequisites []Prerequisite
+	// References are the references carried by the bundle, in order.
+	References []*plumbing.Reference
+}
+
+// NewHeader returns an empty version 2 header.
+func NewHeader() *Header {
+	return &Header{Version: V2}
+}
+
+// Capability returns the value of the capability with the given key, and
+// whether it is set.
+func (h *Header) Capability(key string) (string, bool) {
+	for _, c := range h.Capabilities {
+		if c.Key == key {
+			return c.Value, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,127 @@
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type BundleSuite struct{}
+
+var _ = Suite(&BundleSuite{})
+
+const v2Bundle = "# v2 git bundle\n" +
+	"-a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69 first commit\n" +
+	"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n" +
+	"b8e471f58bcbca63b07bda20e428190409c2db47 refs/tags/v1.0.0\n" +
+	"\n" +
+	"PACK"
+
+func (s *BundleSuite) TestDecode(c *C) {
+	d := NewDecoder(strings.NewReader(v2Bundle))
+	h := NewHeader()
+	c.Assert(d.Decode(h), IsNil)
+
+	c.Assert(h.Version, Equals, V2)
+	c.Assert(h.Prerequisites, DeepEquals, []Prerequisite{{
+		Hash:    plumbing.NewHash("a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69"),
+		Comment: "first commit",
+	}})
+	c.Assert(h.References, DeepEquals, []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("refs/heads/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewReferenceFromStrings("refs/tags/v1.0.0", "b8e471f58bcbca63b07bda20e428190409c2db47"),
+	})
+
+	pack, err := io.ReadAll(d)
+	c.Assert(err, IsNil)
+	c.Assert(string(pack), Equals, "PACK")
+}
+
+func (s *BundleSuite) TestDecodeV3(c *C) {
+	input := "# v3 git bundle\n" +
+		"@object-format=sha1\n" +
+		"@filter=blob:none\n" +
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD\n" +
+		"\n"
+
+	h := NewHeader()
+	c.Assert(NewDecoder(strings.NewReader(input)).Decode(h), IsNil)
+	c.Assert(h.Version, Equals, V3)
+
+	format, ok := h.Capability(ObjectFormatCapability)
+	c.Assert(ok, Equals, true)
+	c.Assert(format, Equals, "sha1")
+
+	filter, ok := h.Capability("filter")
+	c.Assert(ok, Equals, true)
+	c.Assert(filter, Equals, "blob:none")
+
+	_, ok = h.Capability("unknown")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *BundleSuite) TestDecodeErrors(c *C) {
+	for _, input := range []string{
+		"",
+		"# v2 git bundle\n6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD\n",
+		"# v2 git bundle\n@object-format=sha1\n\n",
+		"# v2 git bundle\n-foo\n\n",
+		"# v2 git bundle\n6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n\n",
+	} {
+		err := NewDecoder(strings.NewReader(input)).Decode(NewHeader())
+		c.Assert(errors.Is(err, ErrMalformedHeader), Equals, true, Commentf("input %q: %s", input, err))
+	}
+
+	err := NewDecoder(strings.NewReader("# v4 git bundle\n\n")).Decode(NewHeader())
+	c.Assert(err, Equals, ErrUnsupportedVersion)
+}
+
+func (s *BundleSuite) TestEncode(c *C) {
+	h := NewHeader()
+	c.Assert(NewDecoder(strings.NewReader(v2Bundle)).Decode(h), IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(h), IsNil)
+	c.Assert(buf.String()+"PACK", Equals, v2Bundle)
+}
+
+func (s *BundleSuite) TestEncodeV3(c *C) {
+	h := &Header{
+		Version:      V3,
+		Capabilities: []Capability{{Key: ObjectFormatCapability, Value: "sha1"}},
+		References: []*plumbing.Reference{
+			plumbing.NewReferenceFromStrings("HEAD", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		},
+	}
+
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(h), IsNil)
+	c.Assert(buf.String(), Equals, "# v3 git bundle\n"+
+		"@object-format=sha1\n"+
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD\n\n")
+
+	h.Version = V2
+	c.Assert(NewEncoder(&buf).Encode(h), NotNil)
+}
+
+func (s *BundleSuite) TestIsBundle(c *C) {
+	ok, err := IsBundle(strings.NewReader(v2Bundle))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+
+	ok, err = IsBundle(strings.NewReader("PACK"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+
+	ok, err = IsBundle(strings.NewReader("# v1 git bundle\n"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+}
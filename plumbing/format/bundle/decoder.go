@@ -0,0 +1,129 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Decoder reads and decodes the header of a bundle from an input stream.
+type Decoder struct {
+	r *bufio.Reader
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{bufio.NewReader(r)}
+}
+
+// Decode reads the bundle header into h. Once decoded, the packfile can be
+// read from the Decoder.
+func (d *Decoder) Decode(h *Header) error {
+	line, err := d.readLine()
+	if err != nil {
+		return err
+	}
+
+	switch line {
+	case v2Signature:
+		h.Version = V2
+	case v3Signature:
+		h.Version = V3
+	default:
+		return ErrUnsupportedVersion
+	}
+
+	for {
+		line, err = d.readLine()
+		if err != nil {
+			return err
+		}
+
+		if line == "" {
+			return nil
+		}
+
+		switch {
+		case line[0] == '@':
+			if h.Version < V3 {
+				return fmt.Errorf("%w: capabilities require version 3", ErrMalformedHeader)
+			}
+
+			key, value, _ := strings.Cut(line[1:], "=")
+			h.Capabilities = append(h.Capabilities, Capability{Key: key, Value: value})
+		case line[0] == '-':
+			id, comment, _ := strings.Cut(line[1:], " ")
+			hash, err := parseHash(id)
+			if err != nil {
+				return err
+			}
+
+			h.Prerequisites = append(h.Prerequisites, Prerequisite{Hash: hash, Comment: comment})
+		default:
+			id, name, ok := strings.Cut(line, " ")
+			if !ok || name == "" {
+				return fmt.Errorf("%w: invalid reference line %q", ErrMalformedHeader, line)
+			}
+
+			hash, err := parseHash(id)
+			if err != nil {
+				return err
+			}
+
+			h.References = append(h.References,
+				plumbing.NewHashReference(plumbing.ReferenceName(name), hash))
+		}
+	}
+}
+
+// Read reads the packfile following the header.
+func (d *Decoder) Read(p []byte) (int, error) {
+	return d.r.Read(p)
+}
+
+func (d *Decoder) readLine() (string, error) {
+	line, err := d.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			return "", fmt.Errorf("%w: unexpected end of header", ErrMalformedHeader)
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+func parseHash(s string) (plumbing.Hash, error) {
+	if !plumbing.IsHash(s) {
+		return plumbing.ZeroHash, fmt.Errorf("%w: invalid object id %q", ErrMalformedHeader, s)
+	}
+
+	return plumbing.NewHash(s), nil
+}
+
+// hasSignature returns whether b starts with a bundle signature.
+func hasSignature(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(v2Signature+"\n")) ||
+		bytes.HasPrefix(b, []byte(v3Signature+"\n"))
+}
+
+// IsBundle reports whether the content of r starts like a bundle. It reads
+// up to the length of the signature.
+func IsBundle(r io.Reader) (bool, error) {
+	b := make([]byte, len(v2Signature)+1)
+	n, err := io.ReadFull(r, b)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return hasSignature(b[:n]), nil
+}
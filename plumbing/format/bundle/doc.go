@@ -0,0 +1,20 @@
+// Package bundle implements encoding and decoding of git bundle files.
+//
+// A bundle is a single file holding a set of references and a packfile with
+// the objects needed to reach them, which allows to move repositories
+// without a network connection. See
+// https://git-scm.com/docs/gitformat-bundle for details.
+//
+//   - The header starts with a signature line, "# v2 git bundle" or
+//     "# v3 git bundle".
+//
+//   - Version 3 bundles follow with capability lines, "@<key>[=<value>]",
+//     such as "@object-format=sha1".
+//
+//   - Prerequisites, one per line: "-<object id> [<comment>]". These are
+//     the objects the receiver must already have to use the packfile.
+//
+//   - References, one per line: "<object id> <refname>".
+//
+//   - An empty line ends the header, the packfile follows it.
+package bundle
@@ -0,0 +1,71 @@
+package bundle
+
+import (
+	"fmt"
+	"io"
+)
+
+// Encoder writes bundle headers to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w}
+}
+
+// Encode writes the header h. The packfile must be written right after it.
+func (e *Encoder) Encode(h *Header) error {
+	switch h.Version {
+	case V2:
+		if len(h.Capabilities) != 0 {
+			return fmt.Errorf("capabilities require bundle version 3")
+		}
+
+		if err := e.writeLine(v2Signature); err != nil {
+			return err
+		}
+	case V3:
+		if err := e.writeLine(v3Signature); err != nil {
+			return err
+		}
+	default:
+		return ErrUnsupportedVersion
+	}
+
+	for _, c := range h.Capabilities {
+		line := "@" + c.Key
+		if c.Value != "" {
+			line += "=" + c.Value
+		}
+
+		if err := e.writeLine(line); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range h.Prerequisites {
+		line := "-" + p.Hash.String()
+		if p.Comment != "" {
+			line += " " + p.Comment
+		}
+
+		if err := e.writeLine(line); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range h.References {
+		if err := e.writeLine(fmt.Sprintf("%s %s", r.Hash(), r.Name())); err != nil {
+			return err
+		}
+	}
+
+	return e.writeLine("")
+}
+
+func (e *Encoder) writeLine(line string) error {
+	_, err := io.WriteString(e.w, line+"\n")
+	return err
+}
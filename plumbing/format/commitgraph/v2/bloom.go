@@ -0,0 +1,190 @@
+package v2
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// BloomFilterSettings are the settings of the changed-path Bloom filters, as
+// stored in the header of the BDAT chunk.
+type BloomFilterSettings struct {
+	// HashVersion is the version of the murmur3 hash used: 1, written by git
+	// by default, sign-extends the bytes of the paths over 0x7f, 2 is the
+	// correct murmur3.
+	HashVersion uint32
+	// NumHashes is the number of bits set by each path.
+	NumHashes uint32
+	// BitsPerEntry is the number of bits of the filter per path.
+	BitsPerEntry uint32
+}
+
+// DefaultBloomFilterSettings are the settings used by git by default.
+var DefaultBloomFilterSettings = BloomFilterSettings{
+	HashVersion:  1,
+	NumHashes:    7,
+	BitsPerEntry: 10,
+}
+
+const (
+	// MaxBloomFilterPaths is the maximum number of paths, including the
+	// leading directories, held by a changed-path Bloom filter. The filters of
+	// the commits changing more paths match any path, as git does.
+	MaxBloomFilterPaths = 512
+
+	bloomSeed0 = 0x293ae76f
+	bloomSeed1 = 0x7e646e2c
+)
+
+// BloomFilter is the changed-path Bloom filter of a commit, holding the paths
+// changed by the commit compared to its first parent, along with their
+// leading directories. See
+// https://github.com/git/git/blob/master/Documentation/gitformat-commit-graph.txt
+type BloomFilter struct {
+	Settings BloomFilterSettings
+	Data     []byte
+}
+
+// NewBloomFilter returns the filter of the given changed paths, their leading
+// directories are added.
+func NewBloomFilter(settings BloomFilterSettings, paths []string) *BloomFilter {
+	keys := make(map[string]bool)
+	for _, p := range paths {
+		for p != "" && !keys[p] {
+			keys[p] = true
+			i := strings.LastIndexByte(p, '/')
+			if i < 0 {
+				break
+			}
+
+			p = p[:i]
+		}
+	}
+
+	f := &BloomFilter{Settings: settings}
+	if len(keys) > MaxBloomFilterPaths {
+		f.Data = []byte{0xff}
+		return f
+	}
+
+	size := (len(keys)*int(settings.BitsPerEntry) + 7) / 8
+	if size == 0 {
+		size = 1
+	}
+
+	f.Data = make([]byte, size)
+	for k := range keys {
+		f.add(k)
+	}
+
+	return f
+}
+
+func (f *BloomFilter) add(key string) {
+	n := uint32(len(f.Data) * 8)
+	for _, h := range f.hashes(key) {
+		bit := h % n
+		f.Data[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Contains returns false if the path, a file or a directory, was surely not
+// changed by the commit, otherwise it may have been.
+func (f *BloomFilter) Contains(path string) bool {
+	if len(f.Data) == 0 {
+		return true
+	}
+
+	n := uint32(len(f.Data) * 8)
+	for p := strings.TrimSuffix(path, "/"); p != ""; {
+		for _, h := range f.hashes(p) {
+			bit := h % n
+			if f.Data[bit/8]&(1<<(bit%8)) == 0 {
+				return false
+			}
+		}
+
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			break
+		}
+
+		p = p[:i]
+	}
+
+	return true
+}
+
+func (f *BloomFilter) hashes(key string) []uint32 {
+	signed := f.Settings.HashVersion == 1
+	h0 := murmur3(bloomSeed0, key, signed)
+	h1 := murmur3(bloomSeed1, key, signed)
+
+	hashes := make([]uint32, f.Settings.NumHashes)
+	for i := range hashes {
+		hashes[i] = h0 + uint32(i)*h1
+	}
+
+	return hashes
+}
+
+// murmur3 is the 32 bits murmur3 hash of data, signed is whether the bytes
+// are sign-extended, as the version 1 of the filters of git does.
+func murmur3(seed uint32, data string, signed bool) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+		n  = 0xe6546b64
+	)
+
+	b := func(i int) uint32 {
+		if signed {
+			return uint32(int32(int8(data[i])))
+		}
+
+		return uint32(data[i])
+	}
+
+	blocks := len(data) / 4
+	for i := 0; i < blocks; i++ {
+		k := b(4*i) | b(4*i+1)<<8 | b(4*i+2)<<16 | b(4*i+3)<<24
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+
+		seed ^= k
+		seed = bits.RotateLeft32(seed, 13)*5 + n
+	}
+
+	var k uint32
+	tail := blocks * 4
+	switch len(data) & 3 {
+	case 3:
+		k ^= b(tail+2) << 16
+		fallthrough
+	case 2:
+		k ^= b(tail+1) << 8
+		fallthrough
+	case 1:
+		k ^= b(tail)
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		seed ^= k
+	}
+
+	seed ^= uint32(len(data))
+	seed ^= seed >> 16
+	seed *= 0x85ebca6b
+	seed ^= seed >> 13
+	seed *= 0xc2b2ae35
+	seed ^= seed >> 16
+	return seed
+}
+
+// BloomFilterIndex is implemented by the indexes holding changed-path Bloom
+// filters.
+type BloomFilterIndex interface {
+	// GetBloomFilterByIndex gets the changed-path Bloom filter of the commit
+	// at the index i, plumbing.ErrObjectNotFound is returned if there is none.
+	GetBloomFilterByIndex(i uint32) (*BloomFilter, error)
+}
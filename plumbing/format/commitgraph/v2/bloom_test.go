@@ -0,0 +1,120 @@
+package v2
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	. "gopkg.in/check.v1"
+)
+
+type BloomFilterSuite struct{}
+
+var _ = Suite(&BloomFilterSuite{})
+
+func (s *BloomFilterSuite) TestMurmur3(c *C) {
+	// The values of the murmur3 test vectors of git.
+	for data, expected := range map[string]uint32{
+		"":             0x00000000,
+		"Hello world!": 0x627b0c2c,
+		"The quick brown fox jumps over the lazy dog": 0x2e4ff723,
+	} {
+		c.Assert(murmur3(0, data, false), Equals, expected, Commentf("%q", data))
+		c.Assert(murmur3(0, data, true), Equals, expected, Commentf("%q", data))
+	}
+
+	highBits := "\x99\xaa\xbb\xcc\xdd\xee\xff"
+	c.Assert(murmur3(0, highBits, false), Not(Equals), murmur3(0, highBits, true))
+}
+
+func (s *BloomFilterSuite) TestNewBloomFilter(c *C) {
+	// The filter written by git for a commit changing these paths.
+	f := NewBloomFilter(DefaultBloomFilterSettings, []string{"d1/ñé1/f1", "top"})
+	c.Assert(f.Data, DeepEquals, []byte{0x00, 0x7d, 0xb3, 0x17, 0xcd})
+
+	for _, p := range []string{"d1/ñé1/f1", "d1/ñé1", "d1", "d1/", "top"} {
+		c.Assert(f.Contains(p), Equals, true, Commentf("%s", p))
+	}
+
+	c.Assert(f.Contains("d2"), Equals, false)
+}
+
+func (s *BloomFilterSuite) TestNewBloomFilterEmpty(c *C) {
+	f := NewBloomFilter(DefaultBloomFilterSettings, nil)
+	c.Assert(f.Data, DeepEquals, []byte{0x00})
+	c.Assert(f.Contains("foo"), Equals, false)
+
+	// A filter of no data is not computed, so it matches everything.
+	f = &BloomFilter{Settings: DefaultBloomFilterSettings}
+	c.Assert(f.Contains("foo"), Equals, true)
+}
+
+func (s *BloomFilterSuite) TestNewBloomFilterTooLarge(c *C) {
+	var paths []string
+	for i := 0; i <= MaxBloomFilterPaths; i++ {
+		paths = append(paths, fmt.Sprintf("file%d", i))
+	}
+
+	f := NewBloomFilter(DefaultBloomFilterSettings, paths)
+	c.Assert(f.Data, DeepEquals, []byte{0xff})
+	c.Assert(f.Contains("foo"), Equals, true)
+}
+
+func (s *BloomFilterSuite) TestEncodeBloomFilters(c *C) {
+	idx := NewMemoryIndex()
+	var hashes []plumbing.Hash
+	for i := 0; i < 3; i++ {
+		h := plumbing.ComputeHash(plumbing.CommitObject, []byte{byte(i)})
+		data := &CommitData{TreeHash: plumbing.ZeroHash, Generation: uint64(i + 1)}
+		if i > 0 {
+			data.ParentHashes = []plumbing.Hash{hashes[i-1]}
+		}
+
+		idx.Add(h, data)
+		hashes = append(hashes, h)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(NewEncoder(buf).Encode(idx), IsNil)
+	withoutFilters := buf.Len()
+
+	// The filters are written only once all the commits have one.
+	for i, h := range hashes[:2] {
+		err := idx.SetBloomFilter(h, NewBloomFilter(DefaultBloomFilterSettings, []string{fmt.Sprintf("file%d", i)}))
+		c.Assert(err, IsNil)
+	}
+
+	buf.Reset()
+	c.Assert(NewEncoder(buf).Encode(idx), IsNil)
+	c.Assert(buf.Len(), Equals, withoutFilters)
+
+	c.Assert(idx.SetBloomFilter(hashes[2], NewBloomFilter(DefaultBloomFilterSettings, []string{"file2"})), IsNil)
+	buf.Reset()
+	c.Assert(NewEncoder(buf).Encode(idx), IsNil)
+
+	file, err := OpenFileIndex(nopCloserReaderAt{bytes.NewReader(buf.Bytes())})
+	c.Assert(err, IsNil)
+
+	for i, h := range hashes {
+		fi, err := file.GetIndexByHash(h)
+		c.Assert(err, IsNil)
+		f, err := file.(BloomFilterIndex).GetBloomFilterByIndex(fi)
+		c.Assert(err, IsNil)
+
+		expected, err := idx.GetBloomFilterByIndex(uint32(i))
+		c.Assert(err, IsNil)
+		c.Assert(f, DeepEquals, expected)
+		c.Assert(f.Contains(fmt.Sprintf("file%d", i)), Equals, true)
+	}
+}
+
+func (s *BloomFilterSuite) TestSetBloomFilterNotFound(c *C) {
+	err := NewMemoryIndex().SetBloomFilter(plumbing.ZeroHash, NewBloomFilter(DefaultBloomFilterSettings, nil))
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+type nopCloserReaderAt struct {
+	*bytes.Reader
+}
+
+func (nopCloserReaderAt) Close() error { return nil }
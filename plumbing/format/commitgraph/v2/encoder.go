@@ -30,6 +30,7 @@ func (e *Encoder) Encode(idx Index) error {
 
 	// Sort the inout and prepare helper structures we'll need for encoding
 	hashToIndex, fanout, extraEdgesCount, generationV2OverflowCount := e.prepare(idx, hashes)
+	bloomFilters, bloomFiltersSize := e.prepareBloomFilters(idx, hashes)
 
 	chunkSignatures := [][]byte{OIDFanoutChunk.Signature(), OIDLookupChunk.Signature(), CommitDataChunk.Signature()}
 	chunkSizes := []uint64{szUint32 * lenFanout, uint64(len(hashes)) * hash.Size, uint64(len(hashes)) * (hash.Size + szCommitData)}
@@ -45,6 +46,10 @@ func (e *Encoder) Encode(idx Index) error {
 			chunkSizes = append(chunkSizes, uint64(generationV2OverflowCount)*szUint64)
 		}
 	}
+	if bloomFilters != nil {
+		chunkSignatures = append(chunkSignatures, BloomFilterIndexChunk.Signature(), BloomFilterDataChunk.Signature())
+		chunkSizes = append(chunkSizes, uint64(len(hashes))*szUint32, szBloomHeader+bloomFiltersSize)
+	}
 
 	if err := e.encodeFileHeader(len(chunkSignatures)); err != nil {
 		return err
@@ -75,6 +80,11 @@ func (e *Encoder) Encode(idx Index) error {
 			return err
 		}
 	}
+	if bloomFilters != nil {
+		if err = e.encodeBloomFilters(bloomFilters); err != nil {
+			return err
+		}
+	}
 
 	return e.encodeChecksum()
 }
@@ -110,6 +120,34 @@ func (e *Encoder) prepare(idx Index, hashes []plumbing.Hash) (hashToIndex map[pl
 	return
 }
 
+// prepareBloomFilters returns the changed-path Bloom filters of the sorted
+// hashes and their total size, or nil if some commits have none, or if their
+// settings differ, as a commit-graph holds either none or all of them.
+func (e *Encoder) prepareBloomFilters(idx Index, hashes []plumbing.Hash) (filters []*BloomFilter, size uint64) {
+	bloomIndex, ok := idx.(BloomFilterIndex)
+	if !ok || len(hashes) == 0 {
+		return nil, 0
+	}
+
+	filters = make([]*BloomFilter, 0, len(hashes))
+	for i, hash := range hashes {
+		origIndex, _ := idx.GetIndexByHash(hash)
+		filter, err := bloomIndex.GetBloomFilterByIndex(origIndex)
+		if err != nil || (i > 0 && filter.Settings != filters[0].Settings) {
+			return nil, 0
+		}
+
+		filters = append(filters, filter)
+		size += uint64(len(filter.Data))
+	}
+
+	if size > 0xffffffff {
+		return nil, 0
+	}
+
+	return filters, size
+}
+
 func (e *Encoder) encodeFileHeader(chunkCount int) (err error) {
 	if _, err = e.Write(commitFileSignature); err == nil {
 		version := byte(1)
@@ -244,6 +282,30 @@ func (e *Encoder) encodeGenerationV2Overflow(overflows []uint64) (err error) {
 	return
 }
 
+func (e *Encoder) encodeBloomFilters(filters []*BloomFilter) (err error) {
+	var offset uint32
+	for _, filter := range filters {
+		offset += uint32(len(filter.Data))
+		if err = binary.WriteUint32(e, offset); err != nil {
+			return
+		}
+	}
+
+	settings := filters[0].Settings
+	for _, v := range []uint32{settings.HashVersion, settings.NumHashes, settings.BitsPerEntry} {
+		if err = binary.WriteUint32(e, v); err != nil {
+			return
+		}
+	}
+
+	for _, filter := range filters {
+		if _, err = e.Write(filter.Data); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func (e *Encoder) encodeChecksum() error {
 	_, err := e.Write(e.hash.Sum(nil)[:hash.Size])
 	return err
@@ -37,9 +37,10 @@ const (
 	szUint32 = 4
 	szUint64 = 8
 
-	szSignature  = 4
-	szHeader     = 4
-	szCommitData = 2*szUint32 + szUint64
+	szSignature   = 4
+	szHeader      = 4
+	szCommitData  = 2*szUint32 + szUint64
+	szBloomHeader = 3 * szUint32
 
 	lenFanout = 256
 )
@@ -51,6 +52,7 @@ type fileIndex struct {
 	parent                Index
 	hasGenerationV2       bool
 	minimumNumberOfHashes uint32
+	bloomSettings         *BloomFilterSettings
 }
 
 // ReaderAtCloser is an interface that combines io.ReaderAt and io.Closer.
@@ -82,6 +84,9 @@ func OpenFileIndexWithParent(reader ReaderAtCloser, parent Index) (Index, error)
 	if err := fi.readFanout(); err != nil {
 		return nil, err
 	}
+	if err := fi.readBloomFilterSettings(); err != nil {
+		return nil, err
+	}
 
 	fi.hasGenerationV2 = fi.offsets[GenerationDataChunk] > 0
 	if fi.parent != nil {
@@ -184,6 +189,34 @@ func (fi *fileIndex) readFanout() error {
 	return nil
 }
 
+func (fi *fileIndex) readBloomFilterSettings() error {
+	if fi.offsets[BloomFilterIndexChunk] <= 0 || fi.offsets[BloomFilterDataChunk] <= 0 {
+		return nil
+	}
+
+	settingsReader := io.NewSectionReader(fi.reader, fi.offsets[BloomFilterDataChunk], szBloomHeader)
+	var settings [3]uint32
+	for i := range settings {
+		v, err := binary.ReadUint32(settingsReader)
+		if err != nil {
+			return err
+		}
+		settings[i] = v
+	}
+
+	// The filters of an unknown hash version are ignored, as git does.
+	if settings[0] != 1 && settings[0] != 2 {
+		return nil
+	}
+
+	fi.bloomSettings = &BloomFilterSettings{
+		HashVersion:  settings[0],
+		NumHashes:    settings[1],
+		BitsPerEntry: settings[2],
+	}
+	return nil
+}
+
 // GetIndexByHash looks up the provided hash in the commit-graph fanout and returns the index of the commit data for the given hash.
 func (fi *fileIndex) GetIndexByHash(h plumbing.Hash) (uint32, error) {
 	var oid plumbing.Hash
@@ -331,6 +364,48 @@ func (fi *fileIndex) GetCommitDataByIndex(idx uint32) (*CommitData, error) {
 	}, nil
 }
 
+// GetBloomFilterByIndex returns the changed-path Bloom filter for the given
+// index in the commit-graph.
+func (fi *fileIndex) GetBloomFilterByIndex(idx uint32) (*BloomFilter, error) {
+	if idx < fi.minimumNumberOfHashes {
+		if parent, ok := fi.parent.(BloomFilterIndex); ok {
+			return parent.GetBloomFilterByIndex(idx)
+		}
+
+		return nil, plumbing.ErrObjectNotFound
+	}
+	idx -= fi.minimumNumberOfHashes
+	if idx >= fi.fanout[0xff] || fi.bloomSettings == nil {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	// The index chunk holds the end offset of each filter in the data chunk,
+	// the filter starts at the end of the previous one.
+	var start uint32
+	buf := make([]byte, 2*szUint32)
+	offset := fi.offsets[BloomFilterIndexChunk] + int64(idx)*szUint32
+	if idx > 0 {
+		if _, err := fi.reader.ReadAt(buf, offset-szUint32); err != nil {
+			return nil, err
+		}
+		start = encbin.BigEndian.Uint32(buf)
+	}
+	if _, err := fi.reader.ReadAt(buf[szUint32:], offset); err != nil {
+		return nil, err
+	}
+	end := encbin.BigEndian.Uint32(buf[szUint32:])
+	if end < start {
+		return nil, ErrMalformedCommitGraphFile
+	}
+
+	data := make([]byte, end-start)
+	if _, err := fi.reader.ReadAt(data, fi.offsets[BloomFilterDataChunk]+szBloomHeader+int64(start)); err != nil {
+		return nil, err
+	}
+
+	return &BloomFilter{Settings: *fi.bloomSettings, Data: data}, nil
+}
+
 // GetHashByIndex looks up the hash for the given index in the commit-graph.
 func (fi *fileIndex) GetHashByIndex(idx uint32) (found plumbing.Hash, err error) {
 	if idx < fi.minimumNumberOfHashes {
@@ -17,6 +17,7 @@ type MemoryIndex struct {
 type commitData struct {
 	Hash plumbing.Hash
 	*CommitData
+	bloomFilter *BloomFilter
 }
 
 // NewMemoryIndex creates in-memory commit graph representation
@@ -93,6 +94,28 @@ func (mi *MemoryIndex) Add(hash plumbing.Hash, data *CommitData) {
 	mi.hasGenerationV2 = mi.hasGenerationV2 && data.GenerationV2 != 0
 }
 
+// SetBloomFilter sets the changed-path Bloom filter of the commit h, added
+// before. The filters are encoded only if all the commits have one.
+func (mi *MemoryIndex) SetBloomFilter(h plumbing.Hash, f *BloomFilter) error {
+	i, ok := mi.indexMap[h]
+	if !ok {
+		return plumbing.ErrObjectNotFound
+	}
+
+	mi.commitData[i].bloomFilter = f
+	return nil
+}
+
+// GetBloomFilterByIndex gets the changed-path Bloom filter using the index in
+// the commit graph, if available
+func (mi *MemoryIndex) GetBloomFilterByIndex(i uint32) (*BloomFilter, error) {
+	if i >= uint32(len(mi.commitData)) || mi.commitData[i].bloomFilter == nil {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	return mi.commitData[i].bloomFilter, nil
+}
+
 func (mi *MemoryIndex) HasGenerationV2() bool {
 	return mi.hasGenerationV2
 }
@@ -96,6 +96,18 @@ func (c *Config) AddOption(section string, subsection string, key string, value
 	return c
 }
 
+// addOption adds the option, as decoded, to a given section and subsection.
+func (c *Config) addOption(section, subsection string, o *Option) {
+	s := c.Section(section)
+	if subsection == "" {
+		s.Options = append(s.Options, o)
+		return
+	}
+
+	ss := s.Subsection(subsection)
+	ss.Options = append(ss.Options, o)
+}
+
 // SetOption sets an option to a given section and subsection. Use the
 // NoSubsection constant for the subsection argument if no subsection is wanted.
 func (c *Config) SetOption(section string, subsection string, key string, value string) *Config {
@@ -2,6 +2,7 @@ package config
 
 import (
 	"io"
+	"strings"
 
 	"github.com/go-git/gcfg"
 )
@@ -9,11 +10,18 @@ import (
 // A Decoder reads and decodes config files from an input stream.
 type Decoder struct {
 	io.Reader
+
+	// Include is called, if set, for each include.path and
+	// includeIf.<condition>.path option once it is added to the config, to
+	// decode the included file in the same config, as if its content was
+	// found at the location of the option. The condition is empty for an
+	// include.path option.
+	Include func(config *Config, condition, path string) error
 }
 
 // NewDecoder returns a new decoder that reads from r.
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r}
+	return &Decoder{Reader: r}
 }
 
 // Decode reads the whole config from its input and stores it in the
@@ -30,7 +38,18 @@ func (d *Decoder) Decode(config *Config) error {
 			return nil
 		}
 
-		config.AddOption(s, ss, k, v)
+		config.addOption(s, ss, &Option{Key: k, Value: v, NoValue: bv})
+		if d.Include == nil || v == "" || !strings.EqualFold(k, "path") {
+			return nil
+		}
+
+		switch {
+		case strings.EqualFold(s, "include") && ss == "":
+			return d.Include(config, "", v)
+		case strings.EqualFold(s, "includeIf") && ss != "":
+			return d.Include(config, ss, v)
+		}
+
 		return nil
 	}
 	return gcfg.ReadWithCallback(d, cb)
@@ -85,6 +85,37 @@ func (s *DecoderSuite) TestDecodeFailsWithGarbage(c *C) {
 	decodeFails(c, `[section]key=value"`)
 }
 
+func (s *DecoderSuite) TestDecodeInclude(c *C) {
+	included := map[string]string{
+		"a": "[user]\n\tname = included\n",
+		"b": "[core]\n\teditor = vim\n",
+	}
+
+	var conditions []string
+	d := NewDecoder(bytes.NewReader([]byte(`[user]
+	name = before
+[include]
+	path = a
+[includeIf "onbranch:main"]
+	path = b
+[user]
+	email = after
+`)))
+	d.Include = func(cfg *Config, condition, path string) error {
+		conditions = append(conditions, condition)
+		return NewDecoder(bytes.NewReader([]byte(included[path]))).Decode(cfg)
+	}
+
+	cfg := New()
+	err := d.Decode(cfg)
+	c.Assert(err, IsNil)
+	c.Assert(conditions, DeepEquals, []string{"", "onbranch:main"})
+	c.Assert(cfg.Section("user").Options.GetAll("name"), DeepEquals, []string{"before", "included"})
+	c.Assert(cfg.Section("user").Option("email"), Equals, "after")
+	c.Assert(cfg.Section("core").Option("editor"), Equals, "vim")
+	c.Assert(cfg.Section("include").Option("path"), Equals, "a")
+}
+
 func decodeFails(c *C, text string) {
 	r := bytes.NewReader([]byte(text))
 	d := NewDecoder(r)
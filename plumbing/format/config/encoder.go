@@ -61,14 +61,7 @@ func (e *Encoder) encodeSubsection(sectionName string, s *Subsection) error {
 
 func (e *Encoder) encodeOptions(opts Options) error {
 	for _, o := range opts {
-		var value string
-		if strings.ContainsAny(o.Value, "#;\"\t\n\\") || strings.HasPrefix(o.Value, " ") || strings.HasSuffix(o.Value, " ") {
-			value = `"`+valueReplacer.Replace(o.Value)+`"`
-		} else {
-			value = o.Value
-		}
-
-		if err := e.printf("\t%s = %s\n", o.Key, value); err != nil {
+		if err := e.printf("\t%s\n", encodeOption(o.Key, o)); err != nil {
 			return err
 		}
 	}
@@ -76,6 +69,25 @@ func (e *Encoder) encodeOptions(opts Options) error {
 	return nil
 }
 
+// encodeOption returns the line of the option, with the given key, without
+// its value if it has none.
+func encodeOption(key string, o *Option) string {
+	if o.NoValue && o.Value == "" {
+		return key
+	}
+
+	return key + " = " + encodeValue(o.Value)
+}
+
+// encodeValue returns the value quoted and escaped if needed.
+func encodeValue(v string) string {
+	if strings.ContainsAny(v, "#;\"\t\n\\") || strings.HasPrefix(v, " ") || strings.HasSuffix(v, " ") {
+		return `"` + valueReplacer.Replace(v) + `"`
+	}
+
+	return v
+}
+
 func (e *Encoder) printf(msg string, args ...interface{}) error {
 	_, err := fmt.Fprintf(e.w, msg, args...)
 	return err
@@ -19,3 +19,82 @@ func (s *EncoderSuite) TestEncode(c *C) {
 		c.Assert(buf.String(), Equals, fixture.Text, Commentf("bad result for fixture: %d", idx))
 	}
 }
+
+func (s *EncoderSuite) TestEncodePreserving(c *C) {
+	src := []byte(`# top comment
+[core]
+	bare = false ; why not
+# about remotes
+[remote "origin"]
+	url = a
+[user]
+	name = x
+[remote "origin"]
+	fetch = y
+[branch "gone"]
+	remote = origin
+`)
+
+	cfg := New()
+	err := NewDecoder(bytes.NewReader(src)).Decode(cfg)
+	c.Assert(err, IsNil)
+
+	buf := &bytes.Buffer{}
+	err = NewEncoder(buf).EncodePreserving(cfg, src)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, string(src))
+
+	cfg.Section("user").SetOption("name", "z")
+	cfg.Section("remote").Subsection("origin").SetOption("url", "b")
+	cfg.Section("remote").Subsection("upstream").SetOption("url", "u")
+	cfg.Section("new").SetOption("key", "value")
+	cfg.RemoveSubsection("branch", "gone")
+
+	buf.Reset()
+	err = NewEncoder(buf).EncodePreserving(cfg, src)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, `# top comment
+[core]
+	bare = false ; why not
+# about remotes
+[remote "origin"]
+	fetch = y
+	url = b
+[user]
+	name = z
+[remote "upstream"]
+	url = u
+[new]
+	key = value
+`)
+}
+
+func (s *EncoderSuite) TestEncodePreservingOptions(c *C) {
+	src := []byte(`[core]
+    # indented with spaces
+    AutoCRLF = true ; line endings
+    editor = "vim \
+-f"
+    fileMode = false
+`)
+
+	cfg := New()
+	err := NewDecoder(bytes.NewReader(src)).Decode(cfg)
+	c.Assert(err, IsNil)
+
+	core := cfg.Section("core")
+	core.Options[0].Value = "input"
+	core.RemoveOption("filemode")
+	core.AddOption("pager", "less -R; more")
+
+	buf := &bytes.Buffer{}
+	err = NewEncoder(buf).EncodePreserving(cfg, src)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, `[core]
+    # indented with spaces
+    AutoCRLF = input
+    editor = "vim \
+-f"
+    pager = "less -R; more"
+`)
+}
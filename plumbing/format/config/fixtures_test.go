@@ -32,6 +32,15 @@ var fixtures = []*Fixture{
 		Text:   "[core]\n\trepositoryformatversion = 0\n",
 		Config: New().AddOption("core", "", "repositoryformatversion", "0"),
 	},
+	{
+		Raw:  "[core]\n\tbare\n\tfilemode =\n",
+		Text: "[core]\n\tbare\n\tfilemode = \n",
+		Config: func() *Config {
+			c := New()
+			c.addOption("core", "", &Option{Key: "bare", NoValue: true})
+			return c.AddOption("core", "", "filemode", "")
+		}(),
+	},
 	{
 		Raw:    ";Commment\n[core]\n;Comment\nrepositoryformatversion = 0\n",
 		Text:   "[core]\n\trepositoryformatversion = 0\n",
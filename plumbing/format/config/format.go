@@ -51,3 +51,16 @@ const (
 	// DefaultObjectFormat holds the default object format.
 	DefaultObjectFormat = SHA1
 )
+
+// RefStorage defines the format of the references storage.
+type RefStorage string
+
+const (
+	// RefStorageFiles stores the references as loose files, and in the
+	// packed-refs file.
+	RefStorageFiles RefStorage = "files"
+
+	// RefStorageReftable stores the references in the reftable files of
+	// the reftable directory.
+	RefStorageReftable RefStorage = "reftable"
+)
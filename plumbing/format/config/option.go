@@ -12,6 +12,9 @@ type Option struct {
 	Key string
 	// Original value as string, could be not normalized.
 	Value string
+	// NoValue is true if the option is written as its key alone, without
+	// "=", which git reads as a true boolean. Value is empty then.
+	NoValue bool
 }
 
 type Options []*Option
@@ -22,6 +25,12 @@ func (o *Option) IsKey(key string) bool {
 	return strings.EqualFold(o.Key, key)
 }
 
+// sameValue returns true if the option has the same value as other, written the
+// same way, with or without "=".
+func (o *Option) sameValue(other *Option) bool {
+	return o.Value == other.Value && o.NoValue == other.NoValue
+}
+
 func (opts Options) GoString() string {
 	var strs []string
 	for _, opt := range opts {
@@ -86,7 +95,7 @@ func (opts Options) withoutOption(key string) Options {
 }
 
 func (opts Options) withAddedOption(key string, value string) Options {
-	return append(opts, &Option{key, value})
+	return append(opts, &Option{Key: key, Value: value})
 }
 
 func (opts Options) withSettedOption(key string, values ...string) Options {
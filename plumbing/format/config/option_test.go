@@ -10,9 +10,9 @@ var _ = Suite(&OptionSuite{})
 
 func (s *OptionSuite) TestOptions_Has(c *C) {
 	o := Options{
-		&Option{"k", "v"},
-		&Option{"ok", "v1"},
-		&Option{"K", "v2"},
+		&Option{Key: "k", Value: "v"},
+		&Option{Key: "ok", Value: "v1"},
+		&Option{Key: "K", Value: "v2"},
 	}
 	c.Assert(o.Has("k"), Equals, true)
 	c.Assert(o.Has("K"), Equals, true)
@@ -25,9 +25,9 @@ func (s *OptionSuite) TestOptions_Has(c *C) {
 
 func (s *OptionSuite) TestOptions_GetAll(c *C) {
 	o := Options{
-		&Option{"k", "v"},
-		&Option{"ok", "v1"},
-		&Option{"K", "v2"},
+		&Option{Key: "k", Value: "v"},
+		&Option{Key: "ok", Value: "v1"},
+		&Option{Key: "K", Value: "v2"},
 	}
 	c.Assert(o.GetAll("k"), DeepEquals, []string{"v", "v2"})
 	c.Assert(o.GetAll("K"), DeepEquals, []string{"v", "v2"})
@@ -0,0 +1,410 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/go-git/gcfg"
+)
+
+// partKey identifies a section, or a subsection, of a config.
+type partKey struct {
+	section    string
+	subsection string
+}
+
+func newPartKey(section, subsection string) partKey {
+	return partKey{strings.ToLower(section), subsection}
+}
+
+// sourceChunk is the text of a config file from a section header to the next
+// one.
+type sourceChunk struct {
+	key  partKey
+	text []byte
+}
+
+// EncodePreserving writes the config in git config format to the stream of
+// the encoder, as Encode does, but keeping the text of src, the config file
+// it replaces, for the sections and subsections whose options didn't
+// change, so their comments and formatting are preserved. The changed ones
+// are written in place of their first occurrence in src, and the new ones at
+// the end.
+func (e *Encoder) EncodePreserving(cfg *Config, src []byte) error {
+	preamble, chunks, orig, ok := splitSource(src)
+	if !ok {
+		return e.Encode(cfg)
+	}
+
+	w := &trackingWriter{w: e.w}
+	pe := &Encoder{w: w}
+	if err := w.write(preamble); err != nil {
+		return err
+	}
+
+	count := make(map[partKey]int)
+	for _, c := range chunks {
+		count[c.key]++
+	}
+
+	seen := make(map[partKey]bool)
+	written := make(map[partKey]bool)
+	for _, c := range chunks {
+		seen[c.key] = true
+
+		opts, ok := cfg.part(c.key)
+		origOpts, _ := orig.part(c.key)
+		if ok && equalOptions(opts, origOpts) {
+			if err := w.write(c.text); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if written[c.key] || !ok {
+			continue
+		}
+
+		written[c.key] = true
+		if count[c.key] == 1 {
+			if text, ok := patchChunk(c.text, origOpts, opts); ok {
+				if err := w.write(text); err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		if err := w.newline(); err != nil {
+			return err
+		}
+
+		if err := pe.encodePart(c.key, opts); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range cfg.Sections {
+		key := newPartKey(s.Name, NoSubsection)
+		if !seen[key] && len(s.Options) > 0 {
+			seen[key] = true
+			if err := w.newline(); err != nil {
+				return err
+			}
+
+			if err := pe.encodePart(key, s.Options); err != nil {
+				return err
+			}
+		}
+
+		for _, ss := range s.Subsections {
+			key := partKey{key.section, ss.Name}
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			if err := w.newline(); err != nil {
+				return err
+			}
+
+			if err := pe.encodeSubsection(s.Name, ss); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *Encoder) encodePart(key partKey, opts Options) error {
+	if key.subsection != NoSubsection {
+		return e.encodeSubsection(key.section, &Subsection{Name: key.subsection, Options: opts})
+	}
+
+	if len(opts) == 0 {
+		return nil
+	}
+
+	return e.encodeSection(&Section{Name: key.section, Options: opts})
+}
+
+// part returns the options of the section or subsection with the given key,
+// and whether it exists.
+func (c *Config) part(key partKey) (Options, bool) {
+	for i := len(c.Sections) - 1; i >= 0; i-- {
+		s := c.Sections[i]
+		if !s.IsName(key.section) {
+			continue
+		}
+
+		if key.subsection == NoSubsection {
+			return s.Options, true
+		}
+
+		for j := len(s.Subsections) - 1; j >= 0; j-- {
+			if s.Subsections[j].IsName(key.subsection) {
+				return s.Subsections[j].Options, true
+			}
+		}
+
+		return nil, false
+	}
+
+	return nil, false
+}
+
+func equalOptions(a, b Options) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].IsKey(b[i].Key) || !a[i].sameValue(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// patchChunk returns the text of a chunk with its options changed from orig
+// to opts, keeping the comments and the lines of the unchanged options, and
+// adding the new options after the last one. It returns false if the lines of
+// the options can't be told apart.
+func patchChunk(text []byte, orig, opts Options) ([]byte, bool) {
+	lines := bytes.SplitAfter(text, []byte{'\n'})
+
+	// The line ranges of each option, the first line being the header.
+	var spans [][2]int
+	for n := 1; n < len(lines); {
+		trimmed := bytes.TrimLeft(lines[n], " \t\r\n")
+		if len(trimmed) == 0 || trimmed[0] == ';' || trimmed[0] == '#' {
+			n++
+			continue
+		}
+
+		start := n
+		for isContinued(lines[n]) && n+1 < len(lines) {
+			n++
+		}
+
+		n++
+		spans = append(spans, [2]int{start, n})
+	}
+
+	if len(spans) != len(orig) {
+		return nil, false
+	}
+
+	for i, span := range spans {
+		if !orig[i].IsKey(optionKey(lines[span[0]])) {
+			return nil, false
+		}
+	}
+
+	// Each option is kept, changed or removed, in order, and the remaining
+	// ones are added.
+	updates := make([]*Option, len(orig))
+	var j int
+	for i := range orig {
+		if j < len(opts) && orig[i].IsKey(opts[j].Key) {
+			updates[i] = opts[j]
+			j++
+		}
+	}
+
+	indent := []byte{'\t'}
+	insert := 1
+	if len(spans) > 0 {
+		last := lines[spans[len(spans)-1][0]]
+		indent = last[:len(last)-len(bytes.TrimLeft(last, " \t"))]
+		insert = spans[len(spans)-1][1]
+	}
+
+	buf := bytes.NewBuffer(nil)
+	writeOption := func(indent []byte, key string, o *Option) {
+		if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+
+		buf.Write(indent)
+		buf.WriteString(encodeOption(key, o) + "\n")
+	}
+
+	var i int
+	for n := 0; n <= len(lines); n++ {
+		if n == insert {
+			for _, o := range opts[j:] {
+				writeOption(indent, o.Key, o)
+			}
+		}
+
+		if n == len(lines) {
+			break
+		}
+
+		if i >= len(spans) || n < spans[i][0] {
+			buf.Write(lines[n])
+			continue
+		}
+
+		span, o := spans[i], updates[i]
+		i++
+		n = span[1] - 1
+
+		switch {
+		case o == nil:
+		case o.sameValue(orig[i-1]):
+			for _, l := range lines[span[0]:span[1]] {
+				buf.Write(l)
+			}
+		default:
+			line := lines[span[0]]
+			writeOption(line[:len(line)-len(bytes.TrimLeft(line, " \t"))], optionKey(line), o)
+		}
+	}
+
+	return buf.Bytes(), true
+}
+
+// optionKey returns the key of the option defined in the line.
+func optionKey(line []byte) string {
+	line = bytes.TrimLeft(line, " \t")
+	end := bytes.IndexFunc(line, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-')
+	})
+
+	if end == -1 {
+		end = len(line)
+	}
+
+	return string(line[:end])
+}
+
+// splitSource decodes src and splits it in the text before the first
+// section header and the chunks starting at each header. It returns false if
+// src can't be decoded or the headers can't be matched with the decoded
+// sections.
+func splitSource(src []byte) ([]byte, []sourceChunk, *Config, bool) {
+	cfg := New()
+	var keys []partKey
+	err := gcfg.ReadWithCallback(bytes.NewReader(src), func(s, ss, k, v string, bv bool) error {
+		if k == "" {
+			cfg.Section(s)
+			if ss != "" {
+				cfg.Section(s).Subsection(ss)
+			}
+
+			keys = append(keys, newPartKey(s, ss))
+			return nil
+		}
+
+		cfg.addOption(s, ss, &Option{Key: k, Value: v, NoValue: bv})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, false
+	}
+
+	offsets := headerOffsets(src)
+	if len(offsets) != len(keys) {
+		return nil, nil, nil, false
+	}
+
+	chunks := make([]sourceChunk, len(keys))
+	for i, key := range keys {
+		end := len(src)
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+
+		chunks[i] = sourceChunk{key: key, text: src[offsets[i]:end]}
+	}
+
+	if len(offsets) == 0 {
+		return src, nil, cfg, true
+	}
+
+	return src[:offsets[0]], chunks, cfg, true
+}
+
+// headerOffsets returns the offsets of the lines of src starting with a
+// section header.
+func headerOffsets(src []byte) []int {
+	var offsets []int
+	var continued bool
+	for off := 0; off < len(src); {
+		end := len(src)
+		if i := bytes.IndexByte(src[off:], '\n'); i != -1 {
+			end = off + i + 1
+		}
+
+		line := src[off:end]
+		if trimmed := bytes.TrimLeft(line, " \t"); !continued && len(trimmed) > 0 && trimmed[0] == '[' {
+			offsets = append(offsets, off)
+		}
+
+		continued = isContinued(line)
+		off = end
+	}
+
+	return offsets
+}
+
+// isContinued returns true if the value of the line continues on the next
+// one, ending with a backslash out of a comment.
+func isContinued(line []byte) bool {
+	line = bytes.TrimRight(line, "\r\n")
+
+	var quoted bool
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if i == len(line)-1 {
+				return true
+			}
+
+			i++
+		case '"':
+			quoted = !quoted
+		case ';', '#':
+			if !quoted {
+				return false
+			}
+		}
+	}
+
+	return false
+}
+
+// trackingWriter keeps track of the last byte written, to start the encoded
+// parts on a new line.
+type trackingWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (w *trackingWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.last = p[len(p)-1]
+	}
+
+	return w.w.Write(p)
+}
+
+func (w *trackingWriter) write(p []byte) error {
+	_, err := w.Write(p)
+	return err
+}
+
+func (w *trackingWriter) newline() error {
+	if w.last == 0 || w.last == '\n' {
+		return nil
+	}
+
+	return w.write([]byte{'\n'})
+}
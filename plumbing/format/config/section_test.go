@@ -24,7 +24,7 @@ func (s *SectionSuite) TestSections_GoString(c *C) {
 		},
 	}
 
-	expected := "&config.Section{Name:\"\", Options:&config.Option{Key:\"key1\", Value:\"value1\"}, &config.Option{Key:\"key2\", Value:\"value2\"}, Subsections:}, &config.Section{Name:\"\", Options:&config.Option{Key:\"key1\", Value:\"value3\"}, &config.Option{Key:\"key2\", Value:\"value4\"}, Subsections:}"
+	expected := "&config.Section{Name:\"\", Options:&config.Option{Key:\"key1\", Value:\"value1\", NoValue:false}, &config.Option{Key:\"key2\", Value:\"value2\", NoValue:false}, Subsections:}, &config.Section{Name:\"\", Options:&config.Option{Key:\"key1\", Value:\"value3\", NoValue:false}, &config.Option{Key:\"key2\", Value:\"value4\", NoValue:false}, Subsections:}"
 	c.Assert(sects.GoString(), Equals, expected)
 }
 
@@ -46,7 +46,7 @@ func (s *SectionSuite) TestSubsections_GoString(c *C) {
 		},
 	}
 
-	expected := "&config.Subsection{Name:\"\", Options:&config.Option{Key:\"key1\", Value:\"value1\"}, &config.Option{Key:\"key2\", Value:\"value2\"}, &config.Option{Key:\"key1\", Value:\"value3\"}}, &config.Subsection{Name:\"\", Options:&config.Option{Key:\"key1\", Value:\"value1\"}, &config.Option{Key:\"key2\", Value:\"value2\"}, &config.Option{Key:\"key1\", Value:\"value3\"}}"
+	expected := "&config.Subsection{Name:\"\", Options:&config.Option{Key:\"key1\", Value:\"value1\", NoValue:false}, &config.Option{Key:\"key2\", Value:\"value2\", NoValue:false}, &config.Option{Key:\"key1\", Value:\"value3\", NoValue:false}}, &config.Subsection{Name:\"\", Options:&config.Option{Key:\"key1\", Value:\"value1\", NoValue:false}, &config.Option{Key:\"key2\", Value:\"value2\", NoValue:false}, &config.Option{Key:\"key1\", Value:\"value3\", NoValue:false}}"
 	c.Assert(sects.GoString(), Equals, expected)
 }
 
@@ -159,22 +159,22 @@ func (s *SectionSuite) TestSection_HasOption(c *C) {
 func (s *SectionSuite) TestSection_AddOption(c *C) {
 	sect := &Section{
 		Options: []*Option{
-			{"key1", "value1"},
+			{Key: "key1", Value: "value1"},
 		},
 	}
 	sect1 := &Section{
 		Options: []*Option{
-			{"key1", "value1"},
-			{"key2", "value2"},
+			{Key: "key1", Value: "value1"},
+			{Key: "key2", Value: "value2"},
 		},
 	}
 	c.Assert(sect.AddOption("key2", "value2"), DeepEquals, sect1)
 
 	sect2 := &Section{
 		Options: []*Option{
-			{"key1", "value1"},
-			{"key2", "value2"},
-			{"key1", "value3"},
+			{Key: "key1", Value: "value1"},
+			{Key: "key2", Value: "value2"},
+			{Key: "key1", Value: "value3"},
 		},
 	}
 	c.Assert(sect.AddOption("key1", "value3"), DeepEquals, sect2)
@@ -266,22 +266,22 @@ func (s *SectionSuite) TestSubsection_HasOption(c *C) {
 func (s *SectionSuite) TestSubsection_AddOption(c *C) {
 	sect := &Subsection{
 		Options: []*Option{
-			{"key1", "value1"},
+			{Key: "key1", Value: "value1"},
 		},
 	}
 	sect1 := &Subsection{
 		Options: []*Option{
-			{"key1", "value1"},
-			{"key2", "value2"},
+			{Key: "key1", Value: "value1"},
+			{Key: "key2", Value: "value2"},
 		},
 	}
 	c.Assert(sect.AddOption("key2", "value2"), DeepEquals, sect1)
 
 	sect2 := &Subsection{
 		Options: []*Option{
-			{"key1", "value1"},
-			{"key2", "value2"},
-			{"key1", "value3"},
+			{Key: "key1", Value: "value1"},
+			{Key: "key2", Value: "value2"},
+			{Key: "key1", Value: "value3"},
 		},
 	}
 	c.Assert(sect.AddOption("key1", "value3"), DeepEquals, sect2)
@@ -0,0 +1,97 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+)
+
+// base85Alphabet is the alphabet of the base85 encoding of git, which
+// differs from the ascii85 one.
+const base85Alphabet = "0123456789" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"abcdefghijklmnopqrstuvwxyz" +
+	"!#$%&()*+-;<=>?@^_`{|}~"
+
+var (
+	errInvalidBase85 = errors.New("invalid base85 data")
+
+	base85Decode [256]byte
+)
+
+func init() {
+	for i := range base85Decode {
+		base85Decode[i] = 0xff
+	}
+
+	for i := 0; i < len(base85Alphabet); i++ {
+		base85Decode[base85Alphabet[i]] = byte(i)
+	}
+}
+
+// base85EncodedLen returns the length of the encoding of n bytes, 5
+// characters per group of 4 bytes, the last one padded with zeros.
+func base85EncodedLen(n int) int {
+	return (n + 3) / 4 * 5
+}
+
+// encodeBase85 appends the encoding of src to dst, as encode_85 of git does.
+func encodeBase85(dst, src []byte) []byte {
+	for len(src) > 0 {
+		var acc uint32
+		for i := 0; i < 4; i++ {
+			acc <<= 8
+			if i < len(src) {
+				acc |= uint32(src[i])
+			}
+		}
+
+		var group [5]byte
+		for i := 4; i >= 0; i-- {
+			group[i] = base85Alphabet[acc%85]
+			acc /= 85
+		}
+
+		dst = append(dst, group[:]...)
+		if len(src) < 4 {
+			break
+		}
+
+		src = src[4:]
+	}
+
+	return dst
+}
+
+// decodeBase85 appends the n bytes encoded in src to dst, as decode_85 of git
+// does.
+func decodeBase85(dst, src []byte, n int) ([]byte, error) {
+	if len(src) != base85EncodedLen(n) {
+		return nil, fmt.Errorf("%w: %d characters for %d bytes", errInvalidBase85, len(src), n)
+	}
+
+	for n > 0 {
+		var acc uint64
+		for i := 0; i < 5; i++ {
+			d := base85Decode[src[i]]
+			if d == 0xff {
+				return nil, fmt.Errorf("%w: invalid character %q", errInvalidBase85, src[i])
+			}
+
+			acc = acc*85 + uint64(d)
+		}
+
+		if acc > 0xffffffff {
+			return nil, fmt.Errorf("%w: group overflow", errInvalidBase85)
+		}
+
+		for i := 0; i < 4 && n > 0; i++ {
+			dst = append(dst, byte(acc>>24))
+			acc <<= 8
+			n--
+		}
+
+		src = src[5:]
+	}
+
+	return dst, nil
+}
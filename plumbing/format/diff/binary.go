@@ -0,0 +1,336 @@
+package diff
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/utils/sync"
+)
+
+const (
+	binaryPatchHeader = "GIT binary patch"
+
+	// binaryLineBytes is the maximum number of bytes encoded per line of a
+	// binary hunk.
+	binaryLineBytes = 52
+)
+
+// ErrInvalidBinaryPatch is returned when a binary patch can't be decoded, or
+// applied.
+var ErrInvalidBinaryPatch = errors.New("invalid binary patch")
+
+// BinaryHunkType is the encoding of a hunk of a binary patch.
+type BinaryHunkType int
+
+const (
+	// LiteralBinaryHunk hunks hold the whole content of the resulting file.
+	LiteralBinaryHunk BinaryHunkType = iota
+	// DeltaBinaryHunk hunks hold a delta, in the format of the packfiles,
+	// to apply to the original file.
+	DeltaBinaryHunk
+)
+
+// String returns the keyword of the header of the hunks of the type.
+func (t BinaryHunkType) String() string {
+	switch t {
+	case LiteralBinaryHunk:
+		return "literal"
+	case DeltaBinaryHunk:
+		return "delta"
+	default:
+		return "unknown"
+	}
+}
+
+// BinaryHunk is a hunk of a binary patch, transforming a file into another.
+type BinaryHunk struct {
+	// Type is the encoding of the hunk.
+	Type BinaryHunkType
+	// Data is the content of the resulting file for a literal hunk, or the
+	// delta to apply to the original one.
+	Data []byte
+
+	// deflated is the compressed Data, if already computed.
+	deflated []byte
+}
+
+// Apply returns the file the hunk transforms src into.
+func (h *BinaryHunk) Apply(src []byte) ([]byte, error) {
+	if h.Type == LiteralBinaryHunk {
+		return h.Data, nil
+	}
+
+	dst, err := packfile.PatchDelta(src, h.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBinaryPatch, err)
+	}
+
+	return dst, nil
+}
+
+// BinaryPatch is the patch of a binary file, as written by git diff --binary
+// and read by git apply.
+type BinaryPatch struct {
+	// Forward transforms the original file into the new one.
+	Forward *BinaryHunk
+	// Reverse transforms the new file into the original one, it may be nil
+	// when decoded.
+	Reverse *BinaryHunk
+}
+
+// NewBinaryPatch returns the binary patch transforming from into to, which
+// are nil for a created or deleted file. As git does, the hunks are encoded
+// as deltas if they are smaller than the literal content.
+func NewBinaryPatch(from, to []byte) (*BinaryPatch, error) {
+	forward, err := newBinaryHunk(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	reverse, err := newBinaryHunk(to, from)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BinaryPatch{Forward: forward, Reverse: reverse}, nil
+}
+
+func newBinaryHunk(src, dst []byte) (*BinaryHunk, error) {
+	literal, err := deflate(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &BinaryHunk{Type: LiteralBinaryHunk, Data: dst, deflated: literal}
+	if len(src) == 0 || len(dst) == 0 {
+		return h, nil
+	}
+
+	delta := packfile.DiffDelta(src, dst)
+	deflated, err := deflate(delta)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deflated) < len(literal) {
+		h = &BinaryHunk{Type: DeltaBinaryHunk, Data: delta, deflated: deflated}
+	}
+
+	return h, nil
+}
+
+// Apply returns the file the forward hunk of the patch transforms src into.
+func (p *BinaryPatch) Apply(src []byte) ([]byte, error) {
+	return p.Forward.Apply(src)
+}
+
+// Encode writes the patch to w, from its "GIT binary patch" line.
+func (p *BinaryPatch) Encode(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString(binaryPatchHeader)
+	buf.WriteByte('\n')
+
+	for _, h := range []*BinaryHunk{p.Forward, p.Reverse} {
+		if h == nil {
+			continue
+		}
+
+		if err := h.encode(&buf); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// encode writes the hunk: its header, the base85 encoding of its compressed
+// data, with a line per 52 bytes prefixed by their number, and an empty
+// line.
+func (h *BinaryHunk) encode(buf *bytes.Buffer) error {
+	data := h.deflated
+	if data == nil {
+		var err error
+		if data, err = deflate(h.Data); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(buf, "%s %d\n", h.Type, len(h.Data))
+
+	line := make([]byte, 0, 1+base85EncodedLen(binaryLineBytes))
+	for len(data) > 0 {
+		n := len(data)
+		if n > binaryLineBytes {
+			n = binaryLineBytes
+		}
+
+		line = append(line[:0], binaryLineLength(n))
+		line = encodeBase85(line, data[:n])
+		buf.Write(line)
+		buf.WriteByte('\n')
+
+		data = data[n:]
+	}
+
+	buf.WriteByte('\n')
+	return nil
+}
+
+// binaryLineLength returns the character giving the number of bytes of a
+// line of a binary hunk, A-Z for 1 to 26, and a-z for 27 to 52.
+func binaryLineLength(n int) byte {
+	if n <= 26 {
+		return byte('A' + n - 1)
+	}
+
+	return byte('a' + n - 27)
+}
+
+func parseBinaryLineLength(c byte) (int, bool) {
+	switch {
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 1, true
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 27, true
+	default:
+		return 0, false
+	}
+}
+
+// DecodeBinaryPatch reads the binary patch held by r, from its
+// "GIT binary patch" line. The reverse hunk is optional, as for git apply.
+func DecodeBinaryPatch(r io.Reader) (*BinaryPatch, error) {
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: empty", ErrInvalidBinaryPatch)
+	}
+
+	if strings.TrimRight(s.Text(), "\r") != binaryPatchHeader {
+		return nil, fmt.Errorf("%w: missing %q line", ErrInvalidBinaryPatch, binaryPatchHeader)
+	}
+
+	p := &BinaryPatch{}
+	var err error
+	if p.Forward, err = decodeBinaryHunk(s); err != nil {
+		return nil, err
+	}
+
+	if p.Forward == nil {
+		return nil, fmt.Errorf("%w: missing forward hunk", ErrInvalidBinaryPatch)
+	}
+
+	if p.Reverse, err = decodeBinaryHunk(s); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// decodeBinaryHunk reads the next hunk, returning nil if there is none.
+func decodeBinaryHunk(s *bufio.Scanner) (*BinaryHunk, error) {
+	if !s.Scan() {
+		return nil, s.Err()
+	}
+
+	header := strings.TrimRight(s.Text(), "\r")
+	if header == "" {
+		return nil, nil
+	}
+
+	h := &BinaryHunk{}
+	keyword, value, _ := strings.Cut(header, " ")
+	switch keyword {
+	case LiteralBinaryHunk.String():
+		h.Type = LiteralBinaryHunk
+	case DeltaBinaryHunk.String():
+		h.Type = DeltaBinaryHunk
+	default:
+		return nil, fmt.Errorf("%w: invalid hunk header %q", ErrInvalidBinaryPatch, header)
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("%w: invalid hunk size %q", ErrInvalidBinaryPatch, value)
+	}
+
+	var deflated []byte
+	for s.Scan() {
+		line := bytes.TrimRight(s.Bytes(), "\r")
+		if len(line) == 0 {
+			break
+		}
+
+		n, ok := parseBinaryLineLength(line[0])
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid line length %q", ErrInvalidBinaryPatch, line[0])
+		}
+
+		if deflated, err = decodeBase85(deflated, line[1:], n); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidBinaryPatch, err)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if h.Data, err = inflate(deflated, size); err != nil {
+		return nil, err
+	}
+
+	h.deflated = deflated
+	return h, nil
+}
+
+// deflate compresses data with the fastest level, the default
+// core.compression of git for the binary patches.
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// inflate returns the decompressed data, which must be size bytes long.
+func inflate(data []byte, size int64) ([]byte, error) {
+	zr, err := sync.GetZlibReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBinaryPatch, err)
+	}
+
+	defer sync.PutZlibReader(zr)
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(zr.Reader, size+1)); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBinaryPatch, err)
+	}
+
+	if int64(buf.Len()) != size {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidBinaryPatch, size, buf.Len())
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,335 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+
+	. "gopkg.in/check.v1"
+)
+
+type BinaryPatchSuite struct{}
+
+var _ = Suite(&BinaryPatchSuite{})
+
+// binaryContent returns n pseudo-random bytes, with a NUL byte to be seen as
+// binary.
+func binaryContent(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	b[0] = 0
+
+	return b
+}
+
+func (s *BinaryPatchSuite) TestBase85(c *C) {
+	src := binaryContent(1, 64)
+	for n := 0; n <= len(src); n++ {
+		encoded := encodeBase85(nil, src[:n])
+		c.Assert(encoded, HasLen, base85EncodedLen(n))
+
+		decoded, err := decodeBase85(nil, encoded, n)
+		c.Assert(err, IsNil)
+		c.Assert(bytes.Equal(decoded, src[:n]), Equals, true)
+	}
+
+	_, err := decodeBase85(nil, []byte("0000"), 4)
+	c.Assert(errors.Is(err, errInvalidBase85), Equals, true)
+	_, err = decodeBase85(nil, []byte("0000\""), 4)
+	c.Assert(errors.Is(err, errInvalidBase85), Equals, true)
+	_, err = decodeBase85(nil, []byte("~~~~~"), 4)
+	c.Assert(errors.Is(err, errInvalidBase85), Equals, true)
+}
+
+func (s *BinaryPatchSuite) TestEncodeLiteral(c *C) {
+	p, err := NewBinaryPatch(nil, []byte("\x00foo"))
+	c.Assert(err, IsNil)
+	c.Assert(p.Forward.Type, Equals, LiteralBinaryHunk)
+	c.Assert(p.Reverse.Type, Equals, LiteralBinaryHunk)
+
+	var buf bytes.Buffer
+	c.Assert(p.Encode(&buf), IsNil)
+	c.Assert(buf.String(), Equals, "GIT binary patch\n"+
+		"literal 4\n"+
+		"QcmV(e0Q>&{W^Zo;00M&nMF0Q*\n"+
+		"\n"+
+		"literal 0\n"+
+		"HcmV?d00001\n"+
+		"\n")
+}
+
+func (s *BinaryPatchSuite) TestEncodeDelta(c *C) {
+	from := binaryContent(1, 4096)
+	to := append([]byte{}, from...)
+	copy(to[2048:], "changed")
+	to = append(to, "appended"...)
+
+	p, err := NewBinaryPatch(from, to)
+	c.Assert(err, IsNil)
+	c.Assert(p.Forward.Type, Equals, DeltaBinaryHunk)
+	c.Assert(p.Reverse.Type, Equals, DeltaBinaryHunk)
+
+	var buf bytes.Buffer
+	c.Assert(p.Encode(&buf), IsNil)
+
+	decoded, err := DecodeBinaryPatch(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(decoded.Forward.Type, Equals, DeltaBinaryHunk)
+
+	result, err := decoded.Apply(from)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(result, to), Equals, true)
+
+	result, err = decoded.Reverse.Apply(to)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(result, from), Equals, true)
+}
+
+func (s *BinaryPatchSuite) TestDecodeLiteral(c *C) {
+	to := binaryContent(2, 200)
+	p, err := NewBinaryPatch(nil, to)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(p.Encode(&buf), IsNil)
+
+	// The lines hold 52 bytes at most.
+	for _, line := range strings.Split(buf.String(), "\n") {
+		c.Assert(len(line) <= 66, Equals, true)
+	}
+
+	decoded, err := DecodeBinaryPatch(strings.NewReader(strings.ReplaceAll(buf.String(), "\n", "\r\n")))
+	c.Assert(err, IsNil)
+	c.Assert(decoded.Forward.Type, Equals, LiteralBinaryHunk)
+	c.Assert(bytes.Equal(decoded.Forward.Data, to), Equals, true)
+	c.Assert(decoded.Reverse.Data, HasLen, 0)
+}
+
+// gitDeltaPatch is the binary patch written by git for the change of
+// deltaFrom into deltaTo.
+const gitDeltaPatch = `GIT binary patch
+delta 27
+icmZo*>0nvF$ex^$n3tZKGMSz6Cr4sIL26z~Y6<{)wh3SW
+
+delta 18
+ZcmeBRX<%8v$gUV%5Ug{$ZZbRLPXIDJ1^EB~
+
+`
+
+func (s *BinaryPatchSuite) TestDecodeGitDelta(c *C) {
+	p, err := DecodeBinaryPatch(strings.NewReader(gitDeltaPatch))
+	c.Assert(err, IsNil)
+	c.Assert(p.Forward.Type, Equals, DeltaBinaryHunk)
+	c.Assert(p.Forward.Data, HasLen, 27)
+	c.Assert(p.Reverse.Type, Equals, DeltaBinaryHunk)
+
+	to, err := p.Apply([]byte(deltaFrom))
+	c.Assert(err, IsNil)
+	c.Assert(string(to), Equals, deltaTo)
+
+	from, err := p.Reverse.Apply(to)
+	c.Assert(err, IsNil)
+	c.Assert(string(from), Equals, deltaFrom)
+}
+
+func (s *BinaryPatchSuite) TestDecodeWithoutReverse(c *C) {
+	// Written by git for the creation of a file.
+	p, err := DecodeBinaryPatch(strings.NewReader("GIT binary patch\nliteral 4\nLcmZQb%g+Y@0)qiX\n"))
+	c.Assert(err, IsNil)
+	c.Assert(p.Forward.Data, DeepEquals, []byte("\x00foo"))
+	c.Assert(p.Reverse, IsNil)
+}
+
+func (s *BinaryPatchSuite) TestDecodeInvalid(c *C) {
+	for _, patch := range []string{
+		"",
+		"literal 4\nLcmZQb%g+Y@0)qiX\n",
+		"GIT binary patch\n\n",
+		"GIT binary patch\ncopy 4\nLcmZQb%g+Y@0)qiX\n",
+		"GIT binary patch\nliteral foo\nLcmZQb%g+Y@0)qiX\n",
+		"GIT binary patch\nliteral 5\nLcmZQb%g+Y@0)qiX\n",
+		"GIT binary patch\nliteral 4\nMcmZQb%g+Y@0)qiX\n",
+		"GIT binary patch\nliteral 4\n1cmZQb%g+Y@0)qiX\n",
+		"GIT binary patch\nliteral 4\nLcmZQb%g+Y@0)qiY\n",
+	} {
+		_, err := DecodeBinaryPatch(strings.NewReader(patch))
+		c.Assert(errors.Is(err, ErrInvalidBinaryPatch), Equals, true, Commentf("patch: %q", patch))
+	}
+}
+
+func (s *BinaryPatchSuite) TestApplyInvalidDelta(c *C) {
+	h := &BinaryHunk{Type: DeltaBinaryHunk, Data: []byte{0x01}}
+	_, err := h.Apply([]byte("foo"))
+	c.Assert(errors.Is(err, ErrInvalidBinaryPatch), Equals, true)
+}
+
+func (s *BinaryPatchSuite) TestUnifiedEncoderNotBinaryFilePatch(c *C) {
+	buffer := bytes.NewBuffer(nil)
+	e := NewUnifiedEncoder(buffer, 1).SetBinary(true)
+	p := testPatch{
+		filePatches: []testFilePatch{{
+			from: &testFile{mode: filemode.Regular, path: "binary", seed: "something"},
+			to:   &testFile{mode: filemode.Regular, path: "binary", seed: "otherthing"},
+		}},
+	}
+
+	c.Assert(e.Encode(p), IsNil)
+	c.Assert(buffer.String(), Equals, `diff --git a/binary b/binary
+index a459bc245bdbc45e1bca99e7fe61731da5c48da4..6879395eacf3cc7e5634064ccb617ac7aa62be7d 100644
+Binary files a/binary and b/binary differ
+`)
+}
+
+func (s *BinaryPatchSuite) TestUnifiedEncoderBinary(c *C) {
+	for _, f := range binaryFixtures {
+		c.Log("executing: ", f.desc)
+
+		buffer := bytes.NewBuffer(nil)
+		e := NewUnifiedEncoder(buffer, DefaultContextLines).SetBinary(true)
+		c.Assert(e.Encode(f.patch), IsNil)
+		c.Assert(buffer.String(), Equals, f.diff)
+	}
+}
+
+// TestGitApply checks that git applies the binary patches, and that the
+// files it results in are the ones of the patches.
+func (s *BinaryPatchSuite) TestGitApply(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	for _, f := range binaryFixtures {
+		c.Log("executing: ", f.desc)
+
+		dir := c.MkDir()
+		git := func(args ...string) {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = dir
+			out, err := cmd.CombinedOutput()
+			c.Assert(err, IsNil, Commentf("git %s: %s", strings.Join(args, " "), out))
+		}
+
+		git("init", "-q")
+		for _, fp := range f.patch.(testBinaryPatch).filePatches {
+			if fp.from != nil {
+				err := os.WriteFile(filepath.Join(dir, fp.from.path), []byte(fp.from.seed), 0o644)
+				c.Assert(err, IsNil)
+			}
+		}
+
+		patch := filepath.Join(c.MkDir(), "binary.patch")
+		c.Assert(os.WriteFile(patch, []byte(f.diff), 0o644), IsNil)
+		git("apply", "--check", patch)
+		git("apply", patch)
+
+		for _, fp := range f.patch.(testBinaryPatch).filePatches {
+			if fp.to == nil {
+				_, err := os.Stat(filepath.Join(dir, fp.from.path))
+				c.Assert(os.IsNotExist(err), Equals, true)
+				continue
+			}
+
+			b, err := os.ReadFile(filepath.Join(dir, fp.to.path))
+			c.Assert(err, IsNil)
+			c.Assert(string(b), Equals, fp.to.seed)
+		}
+
+		git("apply", "-R", patch)
+	}
+}
+
+// testBinaryPatch is a testPatch of binary files, whose content is the seed
+// of their testFile.
+type testBinaryPatch struct {
+	testPatch
+}
+
+func (t testBinaryPatch) FilePatches() []FilePatch {
+	var result []FilePatch
+	for _, f := range t.filePatches {
+		result = append(result, testBinaryFilePatch{f})
+	}
+
+	return result
+}
+
+type testBinaryFilePatch struct {
+	testFilePatch
+}
+
+func (t testBinaryFilePatch) BinaryContent() (from, to []byte, err error) {
+	if t.from != nil {
+		from = []byte(t.from.seed)
+	}
+
+	if t.to != nil {
+		to = []byte(t.to.seed)
+	}
+
+	return from, to, nil
+}
+
+// deltaFrom and deltaTo are the original and modified content of a binary
+// file, whose patch is a delta.
+var (
+	deltaFrom = string(binaryContent(3, 512))
+	deltaTo   = deltaFrom[:256] + "changed" + deltaFrom[263:] + "appended"
+)
+
+var binaryFixtures = []*fixture{{
+	desc: "new binary file",
+	patch: testBinaryPatch{testPatch{filePatches: []testFilePatch{{
+		to: &testFile{mode: filemode.Regular, path: "new.bin", seed: "\x00foo"},
+	}}}},
+	diff: `diff --git a/new.bin b/new.bin
+new file mode 100644
+index 0000000000000000000000000000000000000000..988b22428f1e8b32ff2b808e284c260761f2d2a7
+GIT binary patch
+literal 4
+QcmV(e0Q>&{W^Zo;00M&nMF0Q*
+
+literal 0
+HcmV?d00001
+
+`,
+}, {
+	desc: "deleted binary file",
+	patch: testBinaryPatch{testPatch{filePatches: []testFilePatch{{
+		from: &testFile{mode: filemode.Regular, path: "old.bin", seed: "\x00foo"},
+	}}}},
+	diff: `diff --git a/old.bin b/old.bin
+deleted file mode 100644
+index 988b22428f1e8b32ff2b808e284c260761f2d2a7..0000000000000000000000000000000000000000
+GIT binary patch
+literal 0
+HcmV?d00001
+
+literal 4
+QcmV(e0Q>&{W^Zo;00M&nMF0Q*
+
+`,
+}, {
+	desc: "modified binary file, as a delta of the original one",
+	patch: testBinaryPatch{testPatch{filePatches: []testFilePatch{{
+		from: &testFile{mode: filemode.Regular, path: "file.bin", seed: deltaFrom},
+		to:   &testFile{mode: filemode.Regular, path: "file.bin", seed: deltaTo},
+	}}}},
+	diff: `diff --git a/file.bin b/file.bin
+index a46d5e72894a3b3bc1926873e2602e9b1092900e..0bc3d404b381e63d53bfe5ad33577937ca1ba020 100644
+GIT binary patch
+delta 52
+zcmV)30L%Y?1c(Hn0U%>&VQyz-WNjf)r^%x_R#UCzI$D;QXsdP0g={_9+sKn30pJK>
+MaByXAWMyOn05{PVpa1{>
+
+delta 43
+zcmV(_0M!481b_sf0U#k$a8oSHer+L9r^%x_R#UCzI$D;QXsdP0g={_9+sKn30pJ4w
+Dbs7^n
+
+`,
+}}
@@ -39,6 +39,15 @@ type FilePatch interface {
 	Chunks() []Chunk
 }
 
+// BinaryFilePatch is a FilePatch of a binary file able to give the content
+// of its files, for the encoders to write it as a binary patch.
+type BinaryFilePatch interface {
+	FilePatch
+	// BinaryContent returns the content of the from and to Files, nil for
+	// the missing one.
+	BinaryContent() (from, to []byte, err error)
+}
+
 // File contains all the file metadata necessary to print some patch formats.
 type File interface {
 	// Hash returns the File Hash.
@@ -44,6 +44,9 @@ type UnifiedEncoder struct {
 
 	// colorConfig is the color configuration. The default is no color.
 	color ColorConfig
+
+	// binary encodes the binary files as binary patches.
+	binary bool
 }
 
 // NewUnifiedEncoder returns a new UnifiedEncoder that writes to w.
@@ -74,6 +77,15 @@ func (e *UnifiedEncoder) SetDstPrefix(prefix string) *UnifiedEncoder {
 	return e
 }
 
+// SetBinary sets whether the changes of the binary files are encoded as
+// binary patches, which git apply can apply, instead of a line saying that
+// they differ, as the --binary flag of git diff does. Only the FilePatches
+// implementing BinaryFilePatch are encoded as binary patches. It returns e.
+func (e *UnifiedEncoder) SetBinary(binary bool) *UnifiedEncoder {
+	e.binary = binary
+	return e
+}
+
 // Encode encodes patch.
 func (e *UnifiedEncoder) Encode(patch Patch) error {
 	sb := &strings.Builder{}
@@ -87,6 +99,14 @@ func (e *UnifiedEncoder) Encode(patch Patch) error {
 
 	for _, filePatch := range patch.FilePatches() {
 		e.writeFilePatchHeader(sb, filePatch)
+		if bfp, ok := e.binaryFilePatch(filePatch); ok {
+			if err := e.writeBinaryPatch(sb, bfp); err != nil {
+				return err
+			}
+
+			continue
+		}
+
 		g := newHunksGenerator(filePatch.Chunks(), e.contextLines)
 		for _, hunk := range g.Generate() {
 			hunk.writeTo(sb, e.color)
@@ -97,12 +117,51 @@ func (e *UnifiedEncoder) Encode(patch Patch) error {
 	return err
 }
 
+// binaryFilePatch returns the given FilePatch if it's encoded as a binary
+// patch: if it changes the content of a binary file, and binary patches are
+// enabled.
+func (e *UnifiedEncoder) binaryFilePatch(filePatch FilePatch) (BinaryFilePatch, bool) {
+	if !e.binary || !filePatch.IsBinary() {
+		return nil, false
+	}
+
+	bfp, ok := filePatch.(BinaryFilePatch)
+	if !ok {
+		return nil, false
+	}
+
+	from, to := filePatch.Files()
+	switch {
+	case from == nil && to == nil:
+		return nil, false
+	case from != nil && to != nil && from.Hash() == to.Hash():
+		return nil, false
+	}
+
+	return bfp, true
+}
+
+func (e *UnifiedEncoder) writeBinaryPatch(sb *strings.Builder, filePatch BinaryFilePatch) error {
+	from, to, err := filePatch.BinaryContent()
+	if err != nil {
+		return err
+	}
+
+	p, err := NewBinaryPatch(from, to)
+	if err != nil {
+		return err
+	}
+
+	return p.Encode(sb)
+}
+
 func (e *UnifiedEncoder) writeFilePatchHeader(sb *strings.Builder, filePatch FilePatch) {
 	from, to := filePatch.Files()
 	if from == nil && to == nil {
 		return
 	}
 	isBinary := filePatch.IsBinary()
+	_, isBinaryPatch := e.binaryFilePatch(filePatch)
 
 	var lines []string
 	switch {
@@ -134,7 +193,7 @@ func (e *UnifiedEncoder) writeFilePatchHeader(sb *strings.Builder, filePatch Fil
 			)
 		}
 		if !hashEquals {
-			lines = e.appendPathLines(lines, e.srcPrefix+from.Path(), e.dstPrefix+to.Path(), isBinary)
+			lines = e.appendPathLines(lines, e.srcPrefix+from.Path(), e.dstPrefix+to.Path(), isBinary, isBinaryPatch)
 		}
 	case from == nil:
 		lines = append(lines,
@@ -142,14 +201,14 @@ func (e *UnifiedEncoder) writeFilePatchHeader(sb *strings.Builder, filePatch Fil
 			fmt.Sprintf("new file mode %o", to.Mode()),
 			fmt.Sprintf("index %s..%s", plumbing.ZeroHash, to.Hash()),
 		)
-		lines = e.appendPathLines(lines, "/dev/null", e.dstPrefix+to.Path(), isBinary)
+		lines = e.appendPathLines(lines, "/dev/null", e.dstPrefix+to.Path(), isBinary, isBinaryPatch)
 	case to == nil:
 		lines = append(lines,
 			fmt.Sprintf("diff --git %s %s", e.srcPrefix+from.Path(), e.dstPrefix+from.Path()),
 			fmt.Sprintf("deleted file mode %o", from.Mode()),
 			fmt.Sprintf("index %s..%s", from.Hash(), plumbing.ZeroHash),
 		)
-		lines = e.appendPathLines(lines, e.srcPrefix+from.Path(), "/dev/null", isBinary)
+		lines = e.appendPathLines(lines, e.srcPrefix+from.Path(), "/dev/null", isBinary, isBinaryPatch)
 	}
 
 	sb.WriteString(e.color[Meta])
@@ -162,7 +221,12 @@ func (e *UnifiedEncoder) writeFilePatchHeader(sb *strings.Builder, filePatch Fil
 	sb.WriteByte('\n')
 }
 
-func (e *UnifiedEncoder) appendPathLines(lines []string, fromPath, toPath string, isBinary bool) []string {
+func (e *UnifiedEncoder) appendPathLines(lines []string, fromPath, toPath string, isBinary, isBinaryPatch bool) []string {
+	if isBinaryPatch {
+		// The binary patch follows the header.
+		return lines
+	}
+
 	if isBinary {
 		return append(lines,
 			fmt.Sprintf("Binary files %s and %s differ", fromPath, toPath),
@@ -0,0 +1,411 @@
+package fastimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Decoder reads and decodes the commands of a stream from an input stream.
+type Decoder struct {
+	r       *bufio.Reader
+	pending *string
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next command, returning io.EOF at the end of the stream.
+// The comments are skipped.
+func (d *Decoder) Decode() (Command, error) {
+	line, err := d.readLine()
+	for err == nil && line == "" {
+		line, err = d.readLine()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	name, arg, _ := strings.Cut(line, " ")
+	switch name {
+	case "blob":
+		return d.decodeBlob()
+	case "commit":
+		return d.decodeCommit(arg)
+	case "tag":
+		return d.decodeTag(arg)
+	case "reset":
+		reset := &Reset{Ref: arg}
+		reset.From, err = d.optional("from")
+		return reset, err
+	case "progress":
+		return &Progress{Message: arg}, nil
+	case "checkpoint":
+		return &Checkpoint{}, nil
+	case "feature":
+		feature := &Feature{}
+		feature.Name, feature.Value, _ = strings.Cut(arg, "=")
+		return feature, nil
+	case "option":
+		return &Option{Value: arg}, nil
+	case "done":
+		return &Done{}, nil
+	case "alias", "cat-blob", "get-mark", "ls":
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCommand, name)
+	}
+
+	return nil, fmt.Errorf("%w: unknown command %q", ErrMalformedStream, line)
+}
+
+func (d *Decoder) decodeBlob() (*Blob, error) {
+	var err error
+	blob := &Blob{}
+	if blob.Mark, err = d.mark(); err != nil {
+		return nil, err
+	}
+
+	if blob.OriginalOID, err = d.optional("original-oid"); err != nil {
+		return nil, err
+	}
+
+	if blob.Data, err = d.data(); err != nil {
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+func (d *Decoder) decodeCommit(ref string) (*Commit, error) {
+	var err error
+	commit := &Commit{Ref: ref}
+	if commit.Mark, err = d.mark(); err != nil {
+		return nil, err
+	}
+
+	if commit.OriginalOID, err = d.optional("original-oid"); err != nil {
+		return nil, err
+	}
+
+	author, err := d.optional("author")
+	if err != nil {
+		return nil, err
+	}
+
+	if author != "" {
+		if commit.Author, err = parseSignature(author); err != nil {
+			return nil, err
+		}
+	}
+
+	committer, err := d.required("committer")
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := parseSignature(committer)
+	if err != nil {
+		return nil, err
+	}
+
+	commit.Committer = *sig
+	if commit.Encoding, err = d.optional("encoding"); err != nil {
+		return nil, err
+	}
+
+	message, err := d.data()
+	if err != nil {
+		return nil, err
+	}
+
+	commit.Message = string(message)
+	if commit.From, err = d.optional("from"); err != nil {
+		return nil, err
+	}
+
+	for {
+		merge, err := d.optional("merge")
+		if err != nil {
+			return nil, err
+		}
+
+		if merge == "" {
+			break
+		}
+
+		commit.Merge = append(commit.Merge, merge)
+	}
+
+	for {
+		op, err := d.fileOp()
+		if err != nil {
+			return nil, err
+		}
+
+		if op == nil {
+			return commit, nil
+		}
+
+		commit.FileOps = append(commit.FileOps, *op)
+	}
+}
+
+// fileOp reads the next change of the files of a commit, nil at the end of
+// the commit.
+func (d *Decoder) fileOp() (*FileOp, error) {
+	line, err := d.readLine()
+	if err == io.EOF {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	name, arg, _ := strings.Cut(line, " ")
+	switch name {
+	case "M":
+		mode, arg, _ := strings.Cut(arg, " ")
+		ref, p, _ := strings.Cut(arg, " ")
+		op := &FileOp{Type: FileModify, DataRef: ref}
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid mode in %q", ErrMalformedStream, line)
+		}
+
+		// The short modes of the regular files are allowed.
+		switch op.Mode = filemode.FileMode(m); op.Mode {
+		case 0644:
+			op.Mode = filemode.Regular
+		case 0755:
+			op.Mode = filemode.Executable
+		case filemode.Regular, filemode.Executable, filemode.Symlink, filemode.Submodule, filemode.Dir:
+		default:
+			return nil, fmt.Errorf("%w: invalid mode in %q", ErrMalformedStream, line)
+		}
+
+		if op.Path, _, err = unquotePath(p, false); err != nil {
+			return nil, err
+		}
+
+		if ref == Inline {
+			if op.Data, err = d.data(); err != nil {
+				return nil, err
+			}
+		}
+
+		return op, nil
+	case "D":
+		p, _, err := unquotePath(arg, false)
+		return &FileOp{Type: FileDelete, Path: p}, err
+	case "C", "R":
+		op := &FileOp{Type: FileCopy}
+		if name == "R" {
+			op.Type = FileRename
+		}
+
+		src, dst, err := unquotePath(arg, true)
+		if err != nil {
+			return nil, err
+		}
+
+		op.Source = src
+		op.Path, _, err = unquotePath(dst, false)
+		return op, err
+	case "deleteall":
+		return &FileOp{Type: FileDeleteAll}, nil
+	case "N", "ls":
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCommand, name)
+	}
+
+	d.unreadLine(line)
+	return nil, nil
+}
+
+func (d *Decoder) decodeTag(name string) (*Tag, error) {
+	var err error
+	tag := &Tag{Name: name}
+	if tag.Mark, err = d.mark(); err != nil {
+		return nil, err
+	}
+
+	if tag.From, err = d.required("from"); err != nil {
+		return nil, err
+	}
+
+	if tag.OriginalOID, err = d.optional("original-oid"); err != nil {
+		return nil, err
+	}
+
+	tagger, err := d.optional("tagger")
+	if err != nil {
+		return nil, err
+	}
+
+	if tagger != "" {
+		if tag.Tagger, err = parseSignature(tagger); err != nil {
+			return nil, err
+		}
+	}
+
+	message, err := d.data()
+	if err != nil {
+		return nil, err
+	}
+
+	tag.Message = string(message)
+	return tag, nil
+}
+
+func parseSignature(s string) (*object.Signature, error) {
+	if !strings.Contains(s, "<") || !strings.Contains(s, ">") {
+		return nil, fmt.Errorf("%w: invalid identity %q", ErrMalformedStream, s)
+	}
+
+	sig := &object.Signature{}
+	sig.Decode([]byte(s))
+	return sig, nil
+}
+
+func (d *Decoder) mark() (int, error) {
+	ref, err := d.optional("mark")
+	if err != nil || ref == "" {
+		return 0, err
+	}
+
+	mark, ok := ParseMarkRef(ref)
+	if !ok {
+		return 0, fmt.Errorf("%w: invalid mark %q", ErrMalformedStream, ref)
+	}
+
+	return mark, nil
+}
+
+// optional returns the argument of the next line if it is the given command,
+// or an empty string.
+func (d *Decoder) optional(name string) (string, error) {
+	line, err := d.readLine()
+	if err == io.EOF {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if arg, ok := strings.CutPrefix(line, name+" "); ok {
+		return arg, nil
+	}
+
+	d.unreadLine(line)
+	return "", nil
+}
+
+// required returns the argument of the next line, which must be the given
+// command.
+func (d *Decoder) required(name string) (string, error) {
+	line, err := d.readLine()
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	arg, ok := strings.CutPrefix(line, name+" ")
+	if !ok {
+		return "", fmt.Errorf("%w: expected %s, got %q", ErrMalformedStream, name, line)
+	}
+
+	return arg, nil
+}
+
+// data reads a data command, with its exact byte count or delimited.
+func (d *Decoder) data() ([]byte, error) {
+	arg, err := d.required("data")
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if delim, ok := strings.CutPrefix(arg, "<<"); ok {
+		var b strings.Builder
+		for {
+			line, err := d.readRawLine()
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			if line == delim {
+				break
+			}
+
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+
+		data = []byte(b.String())
+	} else {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: invalid data length %q", ErrMalformedStream, arg)
+		}
+
+		data = make([]byte, n)
+		if _, err := io.ReadFull(d.r, data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return nil, err
+		}
+	}
+
+	// The data may be followed by a line feed.
+	if b, err := d.r.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = d.r.Discard(1)
+	}
+
+	return data, nil
+}
+
+// readLine returns the next line which is not a comment.
+func (d *Decoder) readLine() (string, error) {
+	if d.pending != nil {
+		line := *d.pending
+		d.pending = nil
+		return line, nil
+	}
+
+	for {
+		line, err := d.readRawLine()
+		if err != nil || !strings.HasPrefix(line, "#") {
+			return line, err
+		}
+	}
+}
+
+func (d *Decoder) unreadLine(line string) {
+	d.pending = &line
+}
+
+func (d *Decoder) readRawLine() (string, error) {
+	line, err := d.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+
+	return strings.TrimSuffix(line, "\n"), err
+}
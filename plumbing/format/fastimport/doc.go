@@ -0,0 +1,23 @@
+// Package fastimport implements encoding and decoding of the fast-import
+// stream format, the format of git fast-export and git fast-import.
+//
+// A stream is a sequence of commands, creating blobs, commits and tags and
+// updating references, which allows to move the history of a repository
+// from or to other version control systems, or to rewrite it. See
+// https://git-scm.com/docs/git-fast-import for details.
+//
+//   - "blob" creates a blob, usually with a mark to refer to it later.
+//
+//   - "commit <ref>" creates a commit on a branch, from its first parent
+//     and the changes of its files, "M", "D", "C", "R" and "deleteall".
+//
+//   - "tag <name>" creates an annotated tag.
+//
+//   - "reset <ref>" sets a branch to a commit, or resets it.
+//
+//   - "progress", "checkpoint", "feature", "option" and "done".
+//
+// The objects are referred to by their hash or by marks, ":<number>", which
+// can be kept from a run to another in marks files, so a stream can be
+// exported or imported incrementally.
+package fastimport
@@ -0,0 +1,141 @@
+package fastimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Encoder writes the commands of a stream to an output stream.
+type Encoder struct {
+	w *bufio.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{bufio.NewWriter(w)}
+}
+
+// Encode writes a command, as git fast-export does.
+func (e *Encoder) Encode(cmd Command) error {
+	switch c := cmd.(type) {
+	case *Blob:
+		e.printf("blob\n")
+		e.mark(c.Mark)
+		e.originalOID(c.OriginalOID)
+		e.data(c.Data)
+	case *Commit:
+		e.encodeCommit(c)
+	case *Tag:
+		e.printf("tag %s\n", c.Name)
+		e.mark(c.Mark)
+		e.printf("from %s\n", c.From)
+		e.originalOID(c.OriginalOID)
+		if c.Tagger != nil {
+			e.signature("tagger", c.Tagger)
+		}
+
+		e.data([]byte(c.Message))
+	case *Reset:
+		e.printf("reset %s\n", c.Ref)
+		if c.From != "" {
+			e.printf("from %s\n", c.From)
+		}
+
+		e.printf("\n")
+	case *Progress:
+		e.printf("progress %s\n\n", c.Message)
+	case *Checkpoint:
+		e.printf("checkpoint\n\n")
+	case *Feature:
+		if c.Value != "" {
+			e.printf("feature %s=%s\n", c.Name, c.Value)
+		} else {
+			e.printf("feature %s\n", c.Name)
+		}
+	case *Option:
+		e.printf("option %s\n", c.Value)
+	case *Done:
+		e.printf("done\n")
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedCommand, cmd)
+	}
+
+	return e.w.Flush()
+}
+
+func (e *Encoder) encodeCommit(c *Commit) {
+	e.printf("commit %s\n", c.Ref)
+	e.mark(c.Mark)
+	e.originalOID(c.OriginalOID)
+	if c.Author != nil {
+		e.signature("author", c.Author)
+	}
+
+	e.signature("committer", &c.Committer)
+	if c.Encoding != "" {
+		e.printf("encoding %s\n", c.Encoding)
+	}
+
+	// As git does, the message is not followed by a line feed.
+	e.printf("data %d\n%s", len(c.Message), c.Message)
+	if c.From != "" {
+		e.printf("from %s\n", c.From)
+	}
+
+	for _, m := range c.Merge {
+		e.printf("merge %s\n", m)
+	}
+
+	for _, op := range c.FileOps {
+		switch op.Type {
+		case FileModify:
+			e.printf("M %06o %s %s\n", uint32(op.Mode), op.DataRef, quotePath(op.Path, false))
+			if op.DataRef == Inline {
+				e.data(op.Data)
+			}
+		case FileDelete:
+			e.printf("D %s\n", quotePath(op.Path, false))
+		case FileCopy:
+			e.printf("C %s %s\n", quotePath(op.Source, true), quotePath(op.Path, false))
+		case FileRename:
+			e.printf("R %s %s\n", quotePath(op.Source, true), quotePath(op.Path, false))
+		case FileDeleteAll:
+			e.printf("deleteall\n")
+		}
+	}
+
+	e.printf("\n")
+}
+
+func (e *Encoder) mark(mark int) {
+	if mark != 0 {
+		e.printf("mark %s\n", MarkRef(mark))
+	}
+}
+
+func (e *Encoder) originalOID(oid string) {
+	if oid != "" {
+		e.printf("original-oid %s\n", oid)
+	}
+}
+
+func (e *Encoder) signature(name string, s *object.Signature) {
+	e.printf("%s ", name)
+	_ = s.Encode(e.w)
+	e.printf("\n")
+}
+
+func (e *Encoder) data(data []byte) {
+	e.printf("data %d\n", len(data))
+	_, _ = e.w.Write(data)
+	e.printf("\n")
+}
+
+// printf writes to the buffered writer, the errors being returned when it is
+// flushed.
+func (e *Encoder) printf(format string, a ...interface{}) {
+	fmt.Fprintf(e.w, format, a...)
+}
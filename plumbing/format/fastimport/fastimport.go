@@ -0,0 +1,394 @@
+package fastimport
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	// ErrMalformedStream is returned by Decode when the stream is corrupted.
+	ErrMalformedStream = errors.New("malformed fast-import stream")
+	// ErrUnsupportedCommand is returned by Decode for the commands which
+	// need a response from the importer, such as cat-blob and ls, and for
+	// the notes.
+	ErrUnsupportedCommand = errors.New("unsupported fast-import command")
+	// ErrMalformedMarks is returned by Marks.Decode when a marks file is
+	// corrupted.
+	ErrMalformedMarks = errors.New("malformed marks file")
+)
+
+// Inline is the data reference of the file modifications which carry their
+// content.
+const Inline = "inline"
+
+// Command is a command of a stream, one of *Blob, *Commit, *Tag, *Reset,
+// *Progress, *Checkpoint, *Feature, *Option or *Done.
+type Command interface {
+	command()
+}
+
+// Blob creates a blob.
+type Blob struct {
+	// Mark is the mark of the blob, or 0.
+	Mark int
+	// OriginalOID is the hash of the blob in the exported repository, if
+	// given.
+	OriginalOID string
+	// Data is the content of the blob.
+	Data []byte
+}
+
+// Commit creates a commit on a branch, updating it.
+type Commit struct {
+	// Ref is the name of the branch, such as refs/heads/master.
+	Ref string
+	// Mark is the mark of the commit, or 0.
+	Mark int
+	// OriginalOID is the hash of the commit in the exported repository, if
+	// given.
+	OriginalOID string
+	// Author is the author of the commit, the committer if nil.
+	Author *object.Signature
+	// Committer is the committer of the commit.
+	Committer object.Signature
+	// Encoding is the encoding of the message, if not UTF-8.
+	Encoding string
+	// Message is the message of the commit.
+	Message string
+	// From is the first parent of the commit, a mark, a hash or a branch.
+	// If empty, the commit follows the current commit of the branch.
+	From string
+	// Merge are the other parents of the commit.
+	Merge []string
+	// FileOps are the changes of the files from the first parent.
+	FileOps []FileOp
+}
+
+// FileOpType is the type of a change of the files of a commit.
+type FileOpType int
+
+const (
+	// FileModify adds or modifies a file, "M".
+	FileModify FileOpType = iota
+	// FileDelete deletes a file or a directory, "D".
+	FileDelete
+	// FileCopy copies a file or a directory, "C".
+	FileCopy
+	// FileRename renames a file or a directory, "R".
+	FileRename
+	// FileDeleteAll deletes all the files, "deleteall".
+	FileDeleteAll
+)
+
+// FileOp is a change of the files of a commit.
+type FileOp struct {
+	Type FileOpType
+	// Mode is the mode of the modified file.
+	Mode filemode.FileMode
+	// DataRef is the content of the modified file: a mark or a hash, or
+	// Inline, the content being then Data.
+	DataRef string
+	// Data is the content of the modified file, with an Inline DataRef.
+	Data []byte
+	// Path is the path of the file, the destination of the copies and
+	// renames.
+	Path string
+	// Source is the source of the copies and renames.
+	Source string
+}
+
+// Tag creates an annotated tag, and its reference refs/tags/<name>.
+type Tag struct {
+	// Name is the name of the tag.
+	Name string
+	// Mark is the mark of the tag, or 0.
+	Mark int
+	// From is the tagged object, a mark, a hash or a branch.
+	From string
+	// OriginalOID is the hash of the tag in the exported repository, if
+	// given.
+	OriginalOID string
+	// Tagger is the tagger of the tag.
+	Tagger *object.Signature
+	// Message is the message of the tag.
+	Message string
+}
+
+// Reset sets a branch to a commit, or makes it start a new history if From
+// is empty.
+type Reset struct {
+	// Ref is the name of the branch, such as refs/heads/master.
+	Ref string
+	// From is the commit of the branch, a mark, a hash or a branch.
+	From string
+}
+
+// Progress makes the importer print a message.
+type Progress struct {
+	Message string
+}
+
+// Checkpoint makes the importer write the objects and references created
+// so far.
+type Checkpoint struct{}
+
+// Feature requires a feature from the importer, such as "done" or
+// "import-marks=<file>".
+type Feature struct {
+	Name  string
+	Value string
+}
+
+// Option sets an option of the importer, such as "git quiet".
+type Option struct {
+	Value string
+}
+
+// Done ends the stream.
+type Done struct{}
+
+func (*Blob) command()       {}
+func (*Commit) command()     {}
+func (*Tag) command()        {}
+func (*Reset) command()      {}
+func (*Progress) command()   {}
+func (*Checkpoint) command() {}
+func (*Feature) command()    {}
+func (*Option) command()     {}
+func (*Done) command()       {}
+
+// MarkRef returns the reference to a mark, ":<mark>".
+func MarkRef(mark int) string {
+	return ":" + strconv.Itoa(mark)
+}
+
+// ParseMarkRef returns the mark of a reference to a mark, and whether it is
+// one.
+func ParseMarkRef(ref string) (int, bool) {
+	if !strings.HasPrefix(ref, ":") {
+		return 0, false
+	}
+
+	mark, err := strconv.Atoi(ref[1:])
+	if err != nil || mark <= 0 {
+		return 0, false
+	}
+
+	return mark, true
+}
+
+// Marks are the marks of the objects of a stream, which can be read from and
+// written to a marks file to export or import incrementally.
+type Marks struct {
+	hashes map[int]plumbing.Hash
+	marks  map[plumbing.Hash]int
+	last   int
+}
+
+// NewMarks returns empty marks.
+func NewMarks() *Marks {
+	return &Marks{
+		hashes: make(map[int]plumbing.Hash),
+		marks:  make(map[plumbing.Hash]int),
+	}
+}
+
+// Set sets the object of a mark.
+func (m *Marks) Set(mark int, h plumbing.Hash) {
+	if old, ok := m.hashes[mark]; ok && m.marks[old] == mark {
+		delete(m.marks, old)
+	}
+
+	m.hashes[mark] = h
+	if _, ok := m.marks[h]; !ok {
+		m.marks[h] = mark
+	}
+
+	if mark > m.last {
+		m.last = mark
+	}
+}
+
+// Hash returns the object of a mark.
+func (m *Marks) Hash(mark int) (plumbing.Hash, bool) {
+	h, ok := m.hashes[mark]
+	return h, ok
+}
+
+// Mark returns the mark of an object.
+func (m *Marks) Mark(h plumbing.Hash) (int, bool) {
+	mark, ok := m.marks[h]
+	return mark, ok
+}
+
+// Next returns a new mark, after the ones already set.
+func (m *Marks) Next() int {
+	m.last++
+	return m.last
+}
+
+// Len returns the number of marks.
+func (m *Marks) Len() int {
+	return len(m.hashes)
+}
+
+// Decode reads a marks file, with a ":<mark> <hash>" line per mark, as
+// written by the --export-marks option of git fast-import and fast-export.
+func (m *Marks) Decode(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+
+		ref, id, _ := strings.Cut(line, " ")
+		mark, ok := ParseMarkRef(ref)
+		if !ok || !plumbing.IsHash(id) {
+			return fmt.Errorf("%w: %q", ErrMalformedMarks, line)
+		}
+
+		m.Set(mark, plumbing.NewHash(id))
+	}
+
+	return s.Err()
+}
+
+// Encode writes the marks file, in the order of the marks.
+func (m *Marks) Encode(w io.Writer) error {
+	marks := make([]int, 0, len(m.hashes))
+	for mark := range m.hashes {
+		marks = append(marks, mark)
+	}
+
+	sort.Ints(marks)
+
+	bw := bufio.NewWriter(w)
+	for _, mark := range marks {
+		if _, err := fmt.Fprintf(bw, ":%d %s\n", mark, m.hashes[mark]); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// quotePath quotes a path in the C style, as git does, if it has to be. The
+// paths with spaces are quoted as the source of copies and renames.
+func quotePath(p string, space bool) string {
+	needsQuote := space && strings.Contains(p, " ")
+	for i := 0; i < len(p) && !needsQuote; i++ {
+		needsQuote = p[i] < 0x20 || p[i] == '"' || p[i] == '\\' || p[i] == 0x7f
+	}
+
+	if !needsQuote {
+		return p
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\%03o`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unquotePath reads a path at the beginning of s, quoted in the C style or
+// not, returning it and the rest of s. The paths not quoted end at the first
+// space if space is true, at the end of s otherwise.
+func unquotePath(s string, space bool) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		if !space {
+			return s, "", nil
+		}
+
+		p, rest, _ := strings.Cut(s, " ")
+		return p, rest, nil
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			return b.String(), strings.TrimPrefix(s[i+1:], " "), nil
+		case '\\':
+		default:
+			b.WriteByte(c)
+			continue
+		}
+
+		i++
+		if i >= len(s) {
+			break
+		}
+
+		switch c = s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '"', '\\':
+			b.WriteByte(c)
+		default:
+			if i+3 > len(s) {
+				return "", "", fmt.Errorf("%w: invalid path %s", ErrMalformedStream, s)
+			}
+
+			n, err := strconv.ParseUint(s[i:i+3], 8, 8)
+			if err != nil {
+				return "", "", fmt.Errorf("%w: invalid path %s", ErrMalformedStream, s)
+			}
+
+			b.WriteByte(byte(n))
+			i += 2
+		}
+	}
+
+	return "", "", fmt.Errorf("%w: invalid path %s", ErrMalformedStream, s)
+}
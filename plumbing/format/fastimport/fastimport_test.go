@@ -0,0 +1,261 @@
+package fastimport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type FastImportSuite struct{}
+
+var _ = Suite(&FastImportSuite{})
+
+const stream = "feature done\n" +
+	"blob\n" +
+	"mark :1\n" +
+	"original-oid 257cc5642cb1a054f08cc83f2d943e56fd3ebe99\n" +
+	"data 4\n" +
+	"foo\n" +
+	"\n" +
+	"reset refs/heads/master\n" +
+	"\n" +
+	"commit refs/heads/master\n" +
+	"mark :2\n" +
+	"author A U Thor <author@example.com> 1600000000 +0200\n" +
+	"committer C O Mitter <committer@example.com> 1600000001 -0130\n" +
+	"encoding ISO-8859-1\n" +
+	"data 6\n" +
+	"first\n" +
+	"M 100644 :1 foo\n" +
+	"M 100755 inline \"with space\\ttab\"\n" +
+	"data 4\n" +
+	"bar\n" +
+	"\n" +
+	"\n" +
+	"commit refs/heads/master\n" +
+	"mark :3\n" +
+	"author A U Thor <author@example.com> 1600000002 +0000\n" +
+	"committer C O Mitter <committer@example.com> 1600000002 +0000\n" +
+	"data 7\n" +
+	"second\n" +
+	"from :2\n" +
+	"merge 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n" +
+	"D foo\n" +
+	"C \"with space\\ttab\" copy\n" +
+	"R \"a b\" \"c\\\"d\"\n" +
+	"M 160000 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 sub\n" +
+	"deleteall\n" +
+	"\n" +
+	"tag v1\n" +
+	"mark :4\n" +
+	"from :3\n" +
+	"tagger T A Gger <tagger@example.com> 1600000003 +0000\n" +
+	"data 4\n" +
+	"tag\n" +
+	"\n" +
+	"reset refs/heads/other\n" +
+	"from :3\n" +
+	"\n" +
+	"progress 1 of 2\n" +
+	"\n" +
+	"checkpoint\n" +
+	"\n" +
+	"done\n"
+
+func decodeAll(c *C, s string) []Command {
+	d := NewDecoder(strings.NewReader(s))
+
+	var cmds []Command
+	for {
+		cmd, err := d.Decode()
+		if err == io.EOF {
+			return cmds
+		}
+
+		c.Assert(err, IsNil)
+		cmds = append(cmds, cmd)
+	}
+}
+
+func (s *FastImportSuite) TestDecode(c *C) {
+	cmds := decodeAll(c, stream)
+	c.Assert(cmds, HasLen, 10)
+
+	c.Assert(cmds[0], DeepEquals, &Feature{Name: "done"})
+	c.Assert(cmds[1], DeepEquals, &Blob{
+		Mark:        1,
+		OriginalOID: "257cc5642cb1a054f08cc83f2d943e56fd3ebe99",
+		Data:        []byte("foo\n"),
+	})
+	c.Assert(cmds[2], DeepEquals, &Reset{Ref: "refs/heads/master"})
+
+	first := cmds[3].(*Commit)
+	c.Assert(first.Ref, Equals, "refs/heads/master")
+	c.Assert(first.Mark, Equals, 2)
+	c.Assert(first.Author.Name, Equals, "A U Thor")
+	c.Assert(first.Author.When.Unix(), Equals, int64(1600000000))
+	c.Assert(first.Committer.Email, Equals, "committer@example.com")
+	c.Assert(first.Encoding, Equals, "ISO-8859-1")
+	c.Assert(first.Message, Equals, "first\n")
+	c.Assert(first.From, Equals, "")
+	c.Assert(first.FileOps, DeepEquals, []FileOp{
+		{Type: FileModify, Mode: filemode.Regular, DataRef: ":1", Path: "foo"},
+		{Type: FileModify, Mode: filemode.Executable, DataRef: Inline, Data: []byte("bar\n"), Path: "with space\ttab"},
+	})
+
+	second := cmds[4].(*Commit)
+	c.Assert(second.From, Equals, ":2")
+	c.Assert(second.Merge, DeepEquals, []string{"6ecf0ef2c2dffb796033e5a02219af86ec6584e5"})
+	c.Assert(second.FileOps, DeepEquals, []FileOp{
+		{Type: FileDelete, Path: "foo"},
+		{Type: FileCopy, Source: "with space\ttab", Path: "copy"},
+		{Type: FileRename, Source: "a b", Path: `c"d`},
+		{Type: FileModify, Mode: filemode.Submodule, DataRef: "6ecf0ef2c2dffb796033e5a02219af86ec6584e5", Path: "sub"},
+		{Type: FileDeleteAll},
+	})
+
+	tag := cmds[5].(*Tag)
+	c.Assert(tag.Name, Equals, "v1")
+	c.Assert(tag.Mark, Equals, 4)
+	c.Assert(tag.From, Equals, ":3")
+	c.Assert(tag.Tagger.Name, Equals, "T A Gger")
+	c.Assert(tag.Message, Equals, "tag\n")
+
+	c.Assert(cmds[6], DeepEquals, &Reset{Ref: "refs/heads/other", From: ":3"})
+	c.Assert(cmds[7], DeepEquals, &Progress{Message: "1 of 2"})
+	c.Assert(cmds[8], DeepEquals, &Checkpoint{})
+	c.Assert(cmds[9], DeepEquals, &Done{})
+}
+
+func (s *FastImportSuite) TestEncode(c *C) {
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	for _, cmd := range decodeAll(c, stream) {
+		c.Assert(e.Encode(cmd), IsNil)
+	}
+
+	c.Assert(buf.String(), Equals, stream)
+}
+
+func (s *FastImportSuite) TestDecodeDelimitedData(c *C) {
+	cmds := decodeAll(c, "# a comment\n"+
+		"commit refs/heads/master\n"+
+		"committer C O Mitter <committer@example.com> 1600000000 +0000\n"+
+		"data <<END\n"+
+		"first\n"+
+		"\n"+
+		"body\n"+
+		"END\n"+
+		"# another comment\n"+
+		"M 644 inline foo\n"+
+		"data 3\n"+
+		"foo"+
+		"M 755 inline bar\n"+
+		"data <<EOT\n"+
+		"bar\n"+
+		"EOT\n")
+
+	c.Assert(cmds, HasLen, 1)
+	commit := cmds[0].(*Commit)
+	c.Assert(commit.Author, IsNil)
+	c.Assert(commit.Message, Equals, "first\n\nbody\n")
+	c.Assert(commit.FileOps, DeepEquals, []FileOp{
+		{Type: FileModify, Mode: filemode.Regular, DataRef: Inline, Data: []byte("foo"), Path: "foo"},
+		{Type: FileModify, Mode: filemode.Executable, DataRef: Inline, Data: []byte("bar\n"), Path: "bar"},
+	})
+}
+
+func (s *FastImportSuite) TestDecodeErrors(c *C) {
+	for _, t := range []struct {
+		stream string
+		err    error
+	}{
+		{"foo\n", ErrMalformedStream},
+		{"cat-blob :1\n", ErrUnsupportedCommand},
+		{"blob\nmark :a\ndata 0\n", ErrMalformedStream},
+		{"blob\ndata -1\n", ErrMalformedStream},
+		{"blob\ndata 4\nfoo", io.ErrUnexpectedEOF},
+		{"blob\ndata <<END\nfoo\n", io.ErrUnexpectedEOF},
+		{"commit refs/heads/master\ndata 0\n", ErrMalformedStream},
+		{"commit refs/heads/master\ncommitter foo\ndata 0\n", ErrMalformedStream},
+		{"commit refs/heads/master\ncommitter C <c@example.com> 0 +0000\ndata 0\nM 100600 :1 foo\n", ErrMalformedStream},
+		{"commit refs/heads/master\ncommitter C <c@example.com> 0 +0000\ndata 0\nN :1 :2\n", ErrUnsupportedCommand},
+		{"commit refs/heads/master\ncommitter C <c@example.com> 0 +0000\ndata 0\nD \"foo\n", ErrMalformedStream},
+		{"tag v1\ndata 0\n", ErrMalformedStream},
+	} {
+		_, err := NewDecoder(strings.NewReader(t.stream)).Decode()
+		c.Assert(errors.Is(err, t.err), Equals, true, Commentf("%q: %v", t.stream, err))
+	}
+}
+
+func (s *FastImportSuite) TestQuotePath(c *C) {
+	for _, t := range []struct {
+		path, quoted, source string
+	}{
+		{"foo", "foo", "foo"},
+		{"foo bar", "foo bar", `"foo bar"`},
+		{`"foo`, `"\"foo"`, `"\"foo"`},
+		{`foo"bar`, `"foo\"bar"`, `"foo\"bar"`},
+		{"a\tb\nc\\d", `"a\tb\nc\\d"`, `"a\tb\nc\\d"`},
+		{"a\x01\x7f", `"a\001\177"`, `"a\001\177"`},
+		{"ñ", "ñ", "ñ"},
+	} {
+		c.Assert(quotePath(t.path, false), Equals, t.quoted)
+		c.Assert(quotePath(t.path, true), Equals, t.source)
+
+		p, rest, err := unquotePath(t.quoted, false)
+		c.Assert(err, IsNil)
+		c.Assert(p, Equals, t.path)
+		c.Assert(rest, Equals, "")
+
+		p, rest, err = unquotePath(t.source+" dst", true)
+		c.Assert(err, IsNil)
+		c.Assert(p, Equals, t.path)
+		c.Assert(rest, Equals, "dst")
+	}
+}
+
+func (s *FastImportSuite) TestMarks(c *C) {
+	m := NewMarks()
+	c.Assert(m.Decode(strings.NewReader(
+		":2 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n"+
+			":1 a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69\n",
+	)), IsNil)
+
+	c.Assert(m.Len(), Equals, 2)
+	h, ok := m.Hash(1)
+	c.Assert(ok, Equals, true)
+	c.Assert(h, Equals, plumbing.NewHash("a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69"))
+
+	mark, ok := m.Mark(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(ok, Equals, true)
+	c.Assert(mark, Equals, 2)
+	c.Assert(m.Next(), Equals, 3)
+
+	// The mark of a hash follows the mark set again.
+	m.Set(2, plumbing.NewHash("b8e471f58bcbca63b07bda20e428190409c2db47"))
+	_, ok = m.Mark(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(ok, Equals, false)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(m.Encode(buf), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		":1 a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69\n"+
+		":2 b8e471f58bcbca63b07bda20e428190409c2db47\n")
+
+	err := NewMarks().Decode(strings.NewReader(":1 foo\n"))
+	c.Assert(errors.Is(err, ErrMalformedMarks), Equals, true)
+
+	_, ok = ParseMarkRef(":0")
+	c.Assert(ok, Equals, false)
+	c.Assert(MarkRef(12), Equals, ":12")
+}
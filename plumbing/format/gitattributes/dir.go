@@ -7,6 +7,7 @@ import (
 
 	"github.com/go-git/go-billy/v5"
 
+	"github.com/go-git/go-git/v5/internal/path_util"
 	"github.com/go-git/go-git/v5/plumbing/format/config"
 	gioutil "github.com/go-git/go-git/v5/utils/ioutil"
 )
@@ -18,8 +19,13 @@ const (
 	gitattributesFile = ".gitattributes"
 	gitconfigFile     = ".gitconfig"
 	systemFile        = "/etc/gitconfig"
+	infoAttributes    = "info/attributes"
 )
 
+// SystemAttributesFile is the path of the gitattributes file of the system,
+// read with the lowest priority.
+const SystemAttributesFile = "/etc/gitattributes"
+
 func ReadAttributesFile(fs billy.Filesystem, path []string, attributesFile string, allowMacro bool) ([]MatchAttribute, error) {
 	f, err := fs.Open(fs.Join(append(path, attributesFile)...))
 	if os.IsNotExist(err) {
@@ -135,3 +141,40 @@ func LoadGlobalPatterns(fs billy.Filesystem) (attributes []MatchAttribute, err e
 func LoadSystemPatterns(fs billy.Filesystem) (attributes []MatchAttribute, err error) {
 	return loadPatterns(fs, systemFile)
 }
+
+// LoadAttributesFile loads the gitattributes patterns and attributes of the
+// given file, such as the one declared by the core.attributesfile property, a
+// leading ~ standing for the home directory. Macro definitions are allowed. If
+// the file does not exist the function will return nil.
+//
+// The function assumes fs is rooted at the root filesystem.
+func LoadAttributesFile(fs billy.Filesystem, path string) ([]MatchAttribute, error) {
+	path, _ = path_util.ReplaceTildeWithHome(path)
+	return ReadAttributesFile(fs, nil, path, true)
+}
+
+// DefaultAttributesFile returns the path of the gitattributes file git reads
+// when the core.attributesfile property is not declared:
+// $XDG_CONFIG_HOME/git/attributes, or ~/.config/git/attributes if
+// XDG_CONFIG_HOME is not set. It returns an empty path if the home directory
+// is unknown.
+func DefaultAttributesFile() string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "attributes")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".config", "git", "attributes")
+}
+
+// LoadInfoPatterns loads the gitattributes patterns and attributes of the
+// $GIT_DIR/info/attributes file, which have the highest priority. The fs is
+// rooted at the git directory. If the file does not exist the function will
+// return nil.
+func LoadInfoPatterns(fs billy.Filesystem) ([]MatchAttribute, error) {
+	return ReadAttributesFile(fs, nil, infoAttributes, true)
+}
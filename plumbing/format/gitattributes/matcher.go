@@ -8,6 +8,12 @@ type Matcher interface {
 
 type MatcherOptions struct{}
 
+// builtinMacros are the macros defined by git itself, which can be redefined
+// by the gitattributes files.
+var builtinMacros = []string{
+	"[attr]binary -diff -merge -text",
+}
+
 // NewMatcher constructs a new matcher. Patterns must be given in the order of
 // increasing priority. That is the most generic settings files first, then the
 // content of the repo .gitattributes, then content of .gitattributes down the
@@ -27,6 +33,11 @@ type matcher struct {
 func (m *matcher) init() {
 	m.macros = make(map[string]MatchAttribute)
 
+	for _, line := range builtinMacros {
+		macro, _ := ParseAttributesLine(line, nil, true)
+		m.macros[macro.Name] = macro
+	}
+
 	for _, attr := range m.stack {
 		if attr.Pattern == nil {
 			m.macros[attr.Name] = attr
@@ -39,6 +50,12 @@ func (m *matcher) init() {
 //
 // Specific attributes can be specified otherwise all attributes are returned.
 //
+// As git does, the value of an attribute is the one given by the pattern of
+// highest priority matching the path, the last one of its line if it is given
+// several times, an unspecified attribute hiding the values of lower
+// priority. The macros set for the path, such as the built-in binary one, are
+// expanded.
+//
 // Matched is true if any path was matched to a rule, even if the results map
 // is empty.
 func (m *matcher) Match(path []string, attributes []string) (results map[string]Attribute, matched bool) {
@@ -46,8 +63,8 @@ func (m *matcher) Match(path []string, attributes []string) (results map[string]
 
 	n := len(m.stack)
 	for i := n - 1; i >= 0; i-- {
-		if len(attributes) > 0 && len(attributes) == len(results) {
-			return
+		if len(attributes) > 0 && known(results, attributes) {
+			break
 		}
 
 		pattern := m.stack[i].Pattern
@@ -57,22 +74,51 @@ func (m *matcher) Match(path []string, attributes []string) (results map[string]
 
 		if match := pattern.Match(path); match {
 			matched = true
-			for _, attr := range m.stack[i].Attributes {
-				if attr.IsSet() {
-					m.expandMacro(attr.Name(), results)
-				}
-				results[attr.Name()] = attr
-			}
+			m.fill(m.stack[i].Attributes, results)
+		}
+	}
+
+	if len(attributes) == 0 {
+		return
+	}
+
+	requested := make(map[string]Attribute, len(attributes))
+	for _, name := range attributes {
+		if attr, ok := results[name]; ok {
+			requested[name] = attr
+		}
+	}
+
+	return requested, matched
+}
+
+// fill adds the attributes to the results, unless they were already given by
+// a pattern of higher priority, the last ones first, expanding the macros
+// being set.
+func (m *matcher) fill(attrs []Attribute, results map[string]Attribute) {
+	for i := len(attrs) - 1; i >= 0; i-- {
+		attr := attrs[i]
+		if _, ok := results[attr.Name()]; ok {
+			continue
+		}
+
+		results[attr.Name()] = attr
+		if !attr.IsSet() {
+			continue
+		}
+
+		if macro, ok := m.macros[attr.Name()]; ok {
+			m.fill(macro.Attributes, results)
 		}
 	}
-	return
 }
 
-func (m *matcher) expandMacro(name string, results map[string]Attribute) bool {
-	if macro, ok := m.macros[name]; ok {
-		for _, attr := range macro.Attributes {
-			results[attr.Name()] = attr
+func known(results map[string]Attribute, attributes []string) bool {
+	for _, name := range attributes {
+		if _, ok := results[name]; !ok {
+			return false
 		}
 	}
-	return false
+
+	return true
 }
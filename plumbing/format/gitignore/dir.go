@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/go-git/go-billy/v5"
@@ -33,11 +34,15 @@ func readIgnoreFile(fs billy.Filesystem, path []string, ignoreFile string) (ps [
 	if err == nil {
 		defer f.Close()
 
+		source := strings.Join(append(path, ignoreFile), "/")
+
 		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
+		for line := 1; scanner.Scan(); line++ {
 			s := scanner.Text()
 			if !strings.HasPrefix(s, commentPrefix) && len(strings.TrimSpace(s)) > 0 {
-				ps = append(ps, ParsePattern(s, path))
+				p := ParsePattern(s, path)
+				p.(*pattern).origin = Origin{Source: source, Line: line, Text: s}
+				ps = append(ps, p)
 			}
 		}
 	} else if !os.IsNotExist(err) {
@@ -136,6 +141,37 @@ func LoadGlobalPatterns(fs billy.Filesystem) (ps []Pattern, err error) {
 	return loadPatterns(fs, fs.Join(home, gitconfigFile))
 }
 
+// LoadExcludesFile loads the gitignore patterns of the given file, such as
+// the one declared by the core.excludesfile property, a leading ~ standing for
+// the home directory. If the file does not exist the function will return nil.
+//
+// The function assumes fs is rooted at the root filesystem.
+func LoadExcludesFile(fs billy.Filesystem, path string) ([]Pattern, error) {
+	ps, err := readIgnoreFile(fs, nil, path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return ps, err
+}
+
+// DefaultExcludesFile returns the path of the gitignore file git reads when
+// the core.excludesfile property is not declared: $XDG_CONFIG_HOME/git/ignore,
+// or ~/.config/git/ignore if XDG_CONFIG_HOME is not set. It returns an empty
+// path if the home directory is unknown.
+func DefaultExcludesFile() string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".config", "git", "ignore")
+}
+
 // LoadSystemPatterns loads gitignore patterns from the gitignore file
 // declared in a system's /etc/gitconfig file.  If the /etc/gitconfig file does
 // not exist the function will return nil.  If the core.excludesfile property
@@ -302,6 +302,31 @@ func (s *MatcherSuite) TestDir_ReadPatterns(c *C) {
 	checkPatterns(ps)
 }
 
+func (s *MatcherSuite) TestDir_ReadPatternsOrigin(c *C) {
+	ps, err := ReadPatterns(s.GFS, nil)
+	c.Assert(err, IsNil)
+
+	m := NewMatcher(ps).(PatternMatcher)
+
+	match, p := m.MatchPattern([]string{"vendor", "gopkg.in", "file"}, false)
+	c.Assert(match, Equals, Exclude)
+	c.Assert(PatternOrigin(p), Equals, Origin{Source: ".gitignore", Line: 1, Text: "vendor/g*/"})
+
+	match, p = m.MatchPattern([]string{"vendor", "github.com"}, true)
+	c.Assert(match, Equals, Include)
+	c.Assert(PatternOrigin(p), Equals, Origin{Source: "vendor/.gitignore", Line: 1, Text: "!github.com/"})
+
+	match, p = m.MatchPattern([]string{"exclude.crlf"}, false)
+	c.Assert(match, Equals, Exclude)
+	c.Assert(PatternOrigin(p), Equals, Origin{Source: ".git/info/exclude", Line: 1, Text: "exclude.crlf"})
+
+	match, p = m.MatchPattern([]string{"another"}, true)
+	c.Assert(match, Equals, NoMatch)
+	c.Assert(p, IsNil)
+
+	c.Assert(PatternOrigin(ParsePattern("*.go", nil)), Equals, Origin{Text: "*.go"})
+}
+
 func (s *MatcherSuite) TestDir_ReadRelativeGlobalGitIgnore(c *C) {
 	for _, fs := range []billy.Filesystem{s.RFSR, s.RFSU} {
 		ps, err := LoadGlobalPatterns(fs)
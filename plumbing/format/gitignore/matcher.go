@@ -15,16 +15,52 @@ func NewMatcher(ps []Pattern) Matcher {
 	return &matcher{ps}
 }
 
+// PatternMatcher is a Matcher also telling which pattern decides a match, as
+// the ones returned by NewMatcher.
+type PatternMatcher interface {
+	Matcher
+	// MatchPattern returns the result of the match of the path, as Match
+	// does, and the pattern deciding it, nil if none matches. For a path in
+	// an excluded directory it is the pattern excluding the directory.
+	MatchPattern(path []string, isDir bool) (MatchResult, Pattern)
+}
+
 type matcher struct {
 	patterns []Pattern
 }
 
+// Match returns true if the path is excluded. As git does, a path in an
+// excluded directory is excluded, whatever the patterns matching it, since
+// git doesn't list the content of the excluded directories. Otherwise the
+// last pattern matching the path itself, not one of its parents, decides.
 func (m *matcher) Match(path []string, isDir bool) bool {
-	n := len(m.patterns)
-	for i := n - 1; i >= 0; i-- {
-		if match := m.patterns[i].Match(path, isDir); match > NoMatch {
-			return match == Exclude
+	match, _ := m.MatchPattern(path, isDir)
+	return match == Exclude
+}
+
+func (m *matcher) MatchPattern(path []string, isDir bool) (MatchResult, Pattern) {
+	for i := 1; i < len(path); i++ {
+		if match, p := m.match(path[:i], true); match == Exclude {
+			return match, p
+		}
+	}
+
+	return m.match(path, isDir)
+}
+
+func (m *matcher) match(path []string, isDir bool) (MatchResult, Pattern) {
+	for i := len(m.patterns) - 1; i >= 0; i-- {
+		var match MatchResult
+		if p, ok := m.patterns[i].(*pattern); ok {
+			match = p.matchPath(path, isDir)
+		} else {
+			match = m.patterns[i].Match(path, isDir)
+		}
+
+		if match > NoMatch {
+			return match, m.patterns[i]
 		}
 	}
-	return false
+
+	return NoMatch, nil
 }
@@ -1,6 +1,12 @@
 package gitignore
 
 import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/osfs"
 	. "gopkg.in/check.v1"
 )
 
@@ -14,3 +20,89 @@ func (s *MatcherSuite) TestMatcher_Match(c *C) {
 	c.Assert(m.Match([]string{"head", "middle", "vulkano"}, false), Equals, true)
 	c.Assert(m.Match([]string{"head", "middle", "volcano"}, false), Equals, false)
 }
+
+func (s *MatcherSuite) TestMatcher_MatchLikeGit(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	for i, t := range []struct {
+		files map[string]string
+		paths []string
+	}{{
+		files: map[string]string{".gitignore": "*.log\n!important.log\n"},
+		paths: []string{"a.log", "important.log", "sub/b.log", "sub/important.log"},
+	}, {
+		files: map[string]string{".gitignore": "build/\n"},
+		paths: []string{"build/", "build/x", "sub/build/", "sub/build/y", "other/build"},
+	}, {
+		files: map[string]string{".gitignore": "*\n!*/\n!*.go\n"},
+		paths: []string{"a/", "a/x.go", "a/y.txt", "z.txt", "z.go"},
+	}, {
+		files: map[string]string{".gitignore": "/a/\n"},
+		paths: []string{"a/x", "a/sub/z", "b/a/x"},
+	}, {
+		files: map[string]string{".gitignore": "*.txt\n", "sub/.gitignore": "!keep.txt\n"},
+		paths: []string{"keep.txt", "sub/keep.txt", "sub/other.txt"},
+	}, {
+		files: map[string]string{".gitignore": "logs/\n", "logs/.gitignore": "!keep\n"},
+		paths: []string{"logs/keep", "logs/other"},
+	}, {
+		files: map[string]string{".gitignore": "a/**/b\nfoo/**\n"},
+		paths: []string{"a/b", "a/x/b", "a/x/y/b", "c/a/b", "foo/", "foo/x", "foo/y/z"},
+	}, {
+		files: map[string]string{".git/info/exclude": "*.tmp\n", ".gitignore": "!keep.tmp\n"},
+		paths: []string{"x.tmp", "keep.tmp", "sub/keep.tmp"},
+	}, {
+		files: map[string]string{".gitignore": "doc/*.html\n"},
+		paths: []string{"doc/a.html", "doc/sub/b.html", "x/doc/a.html"},
+	}, {
+		files: map[string]string{".gitignore": "*\n!dir/\n"},
+		paths: []string{"dir/", "dir/file", "file"},
+	}, {
+		files: map[string]string{".gitignore": "dir\n!dir/file\n"},
+		paths: []string{"dir/", "dir/file"},
+	}} {
+		dir := c.MkDir()
+		cmd := exec.Command("git", "init", "-q", dir)
+		c.Assert(cmd.Run(), IsNil)
+
+		for name, content := range t.files {
+			c.Assert(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755), IsNil)
+			c.Assert(os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644), IsNil)
+		}
+
+		var args []string
+		for _, p := range t.paths {
+			full := filepath.Join(dir, p)
+			if strings.HasSuffix(p, "/") {
+				c.Assert(os.MkdirAll(full, 0o755), IsNil)
+			} else {
+				c.Assert(os.MkdirAll(filepath.Dir(full), 0o755), IsNil)
+				c.Assert(os.WriteFile(full, nil, 0o644), IsNil)
+			}
+
+			args = append(args, strings.TrimSuffix(p, "/"))
+		}
+
+		cmd = exec.Command("git", append([]string{"check-ignore", "--no-index", "--"}, args...)...)
+		cmd.Dir = dir
+		out, _ := cmd.Output()
+
+		ignored := make(map[string]bool)
+		for _, l := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			ignored[l] = true
+		}
+
+		ps, err := ReadPatterns(osfs.New(dir), nil)
+		c.Assert(err, IsNil)
+		m := NewMatcher(ps)
+
+		for _, p := range t.paths {
+			isDir := strings.HasSuffix(p, "/")
+			p = strings.TrimSuffix(p, "/")
+			c.Assert(m.Match(strings.Split(p, "/"), isDir), Equals, ignored[p],
+				Commentf("case %d, path %s", i, p))
+		}
+	}
+}
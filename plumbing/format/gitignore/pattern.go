@@ -35,13 +35,36 @@ type pattern struct {
 	inclusion bool
 	dirOnly   bool
 	isGlob    bool
+	origin    Origin
+}
+
+// Origin is where a pattern is defined, as git check-ignore -v shows it.
+type Origin struct {
+	// Source is the path of the file the pattern is read from, relative to
+	// the root of the worktree for the files in it, empty if the pattern
+	// isn't read from a file.
+	Source string
+	// Line is the line number of the pattern in its source file, from 1.
+	Line int
+	// Text is the pattern as written.
+	Text string
+}
+
+// PatternOrigin returns where the given pattern, returned by ParsePattern or
+// read from a file, is defined.
+func PatternOrigin(p Pattern) Origin {
+	if p, ok := p.(*pattern); ok {
+		return p.origin
+	}
+
+	return Origin{}
 }
 
 // ParsePattern parses a gitignore pattern string into the Pattern structure.
 func ParsePattern(p string, domain []string) Pattern {
 	// storing domain, copy it to ensure it isn't changed externally
 	domain = append([]string(nil), domain...)
-	res := pattern{domain: domain}
+	res := pattern{domain: domain, origin: Origin{Text: p}}
 
 	if strings.HasPrefix(p, inclusionPrefix) {
 		res.inclusion = true
@@ -89,6 +112,79 @@ func (p *pattern) Match(path []string, isDir bool) MatchResult {
 	}
 }
 
+// matchPath matches the pattern with the path itself, unlike Match which
+// also matches it with the parent directories of the path.
+func (p *pattern) matchPath(path []string, isDir bool) MatchResult {
+	if len(path) <= len(p.domain) {
+		return NoMatch
+	}
+
+	for i, e := range p.domain {
+		if path[i] != e {
+			return NoMatch
+		}
+	}
+
+	path = path[len(p.domain):]
+	if p.dirOnly && !isDir {
+		return NoMatch
+	}
+
+	var match bool
+	if p.isGlob {
+		pattern := p.pattern
+		if len(pattern) > 0 && pattern[0] == "" {
+			pattern = pattern[1:]
+		}
+
+		match = matchComponents(pattern, path)
+	} else {
+		match, _ = filepath.Match(p.pattern[0], path[len(path)-1])
+	}
+
+	switch {
+	case !match:
+		return NoMatch
+	case p.inclusion:
+		return Include
+	default:
+		return Exclude
+	}
+}
+
+// matchComponents returns true if the path matches all the components of the
+// pattern, a "**" component matching zero or more directories, or everything
+// inside when it is the last one.
+func matchComponents(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+
+	if pattern[0] == zeroToManyDirs {
+		if len(pattern) == 1 {
+			return len(path) > 0
+		}
+
+		for i := 0; i < len(path); i++ {
+			if matchComponents(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(path) == 0 {
+		return false
+	}
+
+	if match, err := filepath.Match(pattern[0], path[0]); err != nil || !match {
+		return false
+	}
+
+	return matchComponents(pattern[1:], path[1:])
+}
+
 func (p *pattern) simpleNameMatch(path []string, isDir bool) bool {
 	for i, name := range path {
 		if match, err := filepath.Match(p.pattern[0], name); err != nil {
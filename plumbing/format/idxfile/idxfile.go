@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"sort"
+	"sync"
 
 	encbin "encoding/binary"
 
@@ -57,6 +58,9 @@ type MemoryIndex struct {
 	PackfileChecksum [hash.Size]byte
 	IdxChecksum      [hash.Size]byte
 
+	// offsetHashMu guards the reverse offset/hash map, which is filled by
+	// the lookups of the goroutines sharing the index.
+	offsetHashMu     sync.RWMutex
 	offsetHash       map[int64]plumbing.Hash
 	offsetHashIsFull bool
 }
@@ -126,6 +130,7 @@ func (idx *MemoryIndex) FindOffset(h plumbing.Hash) (int64, error) {
 
 	offset := idx.getOffset(k, i)
 
+	idx.offsetHashMu.Lock()
 	if !idx.offsetHashIsFull {
 		// Save the offset for reverse lookup
 		if idx.offsetHash == nil {
@@ -133,6 +138,7 @@ func (idx *MemoryIndex) FindOffset(h plumbing.Hash) (int64, error) {
 		}
 		idx.offsetHash[int64(offset)] = h
 	}
+	idx.offsetHashMu.Unlock()
 
 	return int64(offset), nil
 }
@@ -173,21 +179,26 @@ func (idx *MemoryIndex) FindHash(o int64) (plumbing.Hash, error) {
 	var hash plumbing.Hash
 	var ok bool
 
+	idx.offsetHashMu.RLock()
 	if idx.offsetHash != nil {
-		if hash, ok = idx.offsetHash[o]; ok {
-			return hash, nil
-		}
+		hash, ok = idx.offsetHash[o]
+	}
+	idx.offsetHashMu.RUnlock()
+	if ok {
+		return hash, nil
 	}
 
+	idx.offsetHashMu.Lock()
+	defer idx.offsetHashMu.Unlock()
+
 	// Lazily generate the reverse offset/hash map if required.
 	if !idx.offsetHashIsFull || idx.offsetHash == nil {
 		if err := idx.genOffsetHash(); err != nil {
 			return plumbing.ZeroHash, err
 		}
-
-		hash, ok = idx.offsetHash[o]
 	}
 
+	hash, ok = idx.offsetHash[o]
 	if !ok {
 		return plumbing.ZeroHash, plumbing.ErrObjectNotFound
 	}
@@ -195,7 +206,8 @@ func (idx *MemoryIndex) FindHash(o int64) (plumbing.Hash, error) {
 	return hash, nil
 }
 
-// genOffsetHash generates the offset/hash mapping for reverse search.
+// genOffsetHash generates the offset/hash mapping for reverse search. It must
+// be called with idx.offsetHashMu held.
 func (idx *MemoryIndex) genOffsetHash() error {
 	count, err := idx.Count()
 	if err != nil {
@@ -3,13 +3,16 @@ package index
 import (
 	"bufio"
 	"bytes"
+	"context"
+	encbin "encoding/binary"
 	"errors"
 	"io"
-
 	"strconv"
 	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/bitmap"
 	"github.com/go-git/go-git/v5/plumbing/hash"
 	"github.com/go-git/go-git/v5/utils/binary"
 )
@@ -26,42 +29,103 @@ var (
 	ErrInvalidChecksum = errors.New("invalid checksum")
 	// ErrUnknownExtension is returned when an index extension is encountered that is considered mandatory
 	ErrUnknownExtension = errors.New("unknown extension")
+	// ErrMalformedEntryName is returned by Decode when the prefix of a
+	// version 4 entry name is longer than the previous name.
+	ErrMalformedEntryName = errors.New("malformed entry name")
+	// ErrUnsupportedOffsetTable is returned by Decode when the version of
+	// the index entry offset table extension is not supported.
+	ErrUnsupportedOffsetTable = errors.New("unsupported index entry offset table version")
+
+	// errMalformedCache is returned by the decoders of the untracked cache
+	// and the file system monitor extensions, which are dropped, as git
+	// does, when they are malformed.
+	errMalformedCache = errors.New("malformed cache extension")
 )
 
 const (
-	entryHeaderLength = 62
+	// entryHeaderLength is the length of the fixed size fields of an
+	// entry: the times, the stat data, the hash and the flags.
+	entryHeaderLength = 40 + hash.Size + 2
 	entryExtended     = 0x4000
 	entryValid        = 0x8000
 	nameMask          = 0xfff
 	intentToAddMask   = 1 << 13
 	skipWorkTreeMask  = 1 << 14
+
+	// decoderBufferSize is the size of the buffer of the decoder, the
+	// entries are parsed from it without copying them.
+	decoderBufferSize = 64 * 1024
 )
 
 // A Decoder reads and decodes index files from an input stream.
 type Decoder struct {
 	buf       *bufio.Reader
 	r         io.Reader
-	hash      hash.Hash
+	hash      *checksumReader
 	lastEntry *Entry
 
-	extReader *bufio.Reader
+	extReader      *bufio.Reader
+	skipExtensions bool
 }
 
 // NewDecoder returns a new decoder that reads from r.
 func NewDecoder(r io.Reader) *Decoder {
-	h := hash.New(hash.CryptoType)
-	buf := bufio.NewReader(r)
+	h := &checksumReader{r: r, h: hash.NewChecksum(hash.CryptoType)}
+	buf := bufio.NewReaderSize(h, decoderBufferSize)
 	return &Decoder{
 		buf:       buf,
-		r:         io.TeeReader(buf, h),
+		r:         buf,
 		hash:      h,
 		extReader: bufio.NewReader(nil),
 	}
 }
 
+// checksumReader hashes the bytes read from r as they are buffered, but the
+// last hash.Size ones, which are the checksum of the index when r is read
+// to the end.
+type checksumReader struct {
+	r    io.Reader
+	h    hash.Hash
+	tail [hash.Size]byte
+	n    int
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	data := p[:n]
+	if over := c.n + len(data) - hash.Size; over > 0 {
+		if over <= c.n {
+			c.h.Write(c.tail[:over])
+			c.n = copy(c.tail[:], c.tail[over:c.n])
+		} else {
+			c.h.Write(c.tail[:c.n])
+			c.h.Write(data[:over-c.n])
+			data = data[over-c.n:]
+			c.n = 0
+		}
+	}
+
+	c.n += copy(c.tail[c.n:], data)
+	return n, err
+}
+
+// SetSkipExtensions sets whether the optional extensions, the cache ones as
+// the untracked cache or the file system monitor, are skipped instead of
+// decoded. They are still verified by the checksum, but not kept in the
+// index, which is faster when only the entries are needed.
+func (d *Decoder) SetSkipExtensions(skip bool) {
+	d.skipExtensions = skip
+}
+
 // Decode reads the whole index object from its input and stores it in the
 // value pointed to by idx.
 func (d *Decoder) Decode(idx *Index) error {
+	return d.DecodeContext(context.Background(), idx)
+}
+
+// DecodeContext reads the whole index object as Decode does, checking the
+// given context between the entries.
+func (d *Decoder) DecodeContext(ctx context.Context, idx *Index) error {
 	var err error
 	idx.Version, err = validateHeader(d.r)
 	if err != nil {
@@ -73,17 +137,33 @@ func (d *Decoder) Decode(idx *Index) error {
 		return err
 	}
 
-	if err := d.readEntries(idx, int(entryCount)); err != nil {
+	if err := d.readEntries(ctx, idx, int(entryCount)); err != nil {
 		return err
 	}
 
 	return d.readExtensions(idx)
 }
 
-func (d *Decoder) readEntries(idx *Index, count int) error {
-	for i := 0; i < count; i++ {
-		e, err := d.readEntry(idx)
-		if err != nil {
+func (d *Decoder) readEntries(ctx context.Context, idx *Index, count int) error {
+	if count == 0 {
+		return nil
+	}
+
+	// The entries are allocated at once, large indexes have hundreds of
+	// thousands of them.
+	entries := make([]Entry, count)
+	idx.Entries = make([]*Entry, 0, count)
+	for i := range entries {
+		// The context is checked once per block of the entry offset
+		// table, not to slow down the decoding.
+		if i%entryOffsetBlockSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		e := &entries[i]
+		if err := d.readEntry(idx, e); err != nil {
 			return err
 		}
 
@@ -94,28 +174,25 @@ func (d *Decoder) readEntries(idx *Index, count int) error {
 	return nil
 }
 
-func (d *Decoder) readEntry(idx *Index) (*Entry, error) {
-	e := &Entry{}
-
-	var msec, mnsec, sec, nsec uint32
-	var flags uint16
-
-	flow := []interface{}{
-		&sec, &nsec,
-		&msec, &mnsec,
-		&e.Dev,
-		&e.Inode,
-		&e.Mode,
-		&e.UID,
-		&e.GID,
-		&e.Size,
-		&e.Hash,
-		&flags,
+// readEntry parses the entry from the buffer of the decoder, the name of
+// the version 2 and 3 entries being peeked along with its header.
+func (d *Decoder) readEntry(idx *Index, e *Entry) error {
+	header, err := d.peek(entryHeaderLength)
+	if err != nil {
+		return err
 	}
 
-	if err := binary.Read(d.r, flow...); err != nil {
-		return nil, err
-	}
+	be := encbin.BigEndian
+	sec, nsec := be.Uint32(header[0:]), be.Uint32(header[4:])
+	msec, mnsec := be.Uint32(header[8:]), be.Uint32(header[12:])
+	e.Dev = be.Uint32(header[16:])
+	e.Inode = be.Uint32(header[20:])
+	e.Mode = filemode.FileMode(be.Uint32(header[24:]))
+	e.UID = be.Uint32(header[28:])
+	e.GID = be.Uint32(header[32:])
+	e.Size = be.Uint32(header[36:])
+	copy(e.Hash[:], header[40:])
+	flags := be.Uint16(header[40+hash.Size:])
 
 	read := entryHeaderLength
 
@@ -130,57 +207,60 @@ func (d *Decoder) readEntry(idx *Index) (*Entry, error) {
 	e.Stage = Stage(flags>>12) & 0x3
 
 	if flags&entryExtended != 0 {
-		extended, err := binary.ReadUint16(d.r)
+		b, err := d.peek(read + 2)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
+		extended := be.Uint16(b[read:])
 		read += 2
 		e.IntentToAdd = extended&intentToAddMask != 0
 		e.SkipWorktree = extended&skipWorkTreeMask != 0
 	}
 
-	if err := d.readEntryName(idx, e, flags); err != nil {
-		return nil, err
-	}
-
-	return e, d.padEntry(idx, e, read)
-}
-
-func (d *Decoder) readEntryName(idx *Index, e *Entry, flags uint16) error {
-	var name string
-	var err error
-
 	switch idx.Version {
 	case 2, 3:
-		len := flags & nameMask
-		name, err = d.doReadEntryName(len)
+		// Index entries are padded out to the next 8 byte alignment
+		// for historical reasons related to how C Git read the files.
+		l := int(flags & nameMask)
+		size := read + l
+		size += 8 - size%8
+
+		b, err := d.peek(size)
+		if err != nil {
+			return err
+		}
+
+		e.Name = string(b[read : read+l])
+		return d.discard(size)
 	case 4:
-		name, err = d.doReadEntryNameV4()
-	default:
-		return ErrUnsupportedVersion
-	}
+		if err := d.discard(read); err != nil {
+			return err
+		}
 
-	if err != nil {
+		e.Name, err = d.doReadEntryNameV4()
 		return err
+	default:
+		return ErrUnsupportedVersion
 	}
-
-	e.Name = name
-	return nil
 }
 
 func (d *Decoder) doReadEntryNameV4() (string, error) {
-	l, err := binary.ReadVariableWidthInt(d.r)
+	l, err := d.readVariableWidthInt()
 	if err != nil {
 		return "", err
 	}
 
 	var base string
 	if d.lastEntry != nil {
+		if l < 0 || int(l) > len(d.lastEntry.Name) {
+			return "", ErrMalformedEntryName
+		}
+
 		base = d.lastEntry.Name[:len(d.lastEntry.Name)-int(l)]
 	}
 
-	name, err := binary.ReadUntil(d.r, '\x00')
+	name, err := d.readUntil('\x00')
 	if err != nil {
 		return "", err
 	}
@@ -188,40 +268,97 @@ func (d *Decoder) doReadEntryNameV4() (string, error) {
 	return base + string(name), nil
 }
 
-func (d *Decoder) doReadEntryName(len uint16) (string, error) {
-	name := make([]byte, len)
-	_, err := io.ReadFull(d.r, name)
+// readVariableWidthInt reads a variable width integer as
+// binary.ReadVariableWidthInt does, from the buffer of the decoder.
+func (d *Decoder) readVariableWidthInt() (int64, error) {
+	// An index has always a checksum after the entries, the integer can be
+	// peeked at once.
+	const maxLength = 10
+	b, err := d.buf.Peek(maxLength)
+	if len(b) == 0 {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 
-	return string(name), err
+		return 0, err
+	}
+
+	var v int64
+	for i, c := range b {
+		if i == 0 {
+			v = int64(c & 0x7f)
+		} else {
+			v = (v+1)<<7 + int64(c&0x7f)
+		}
+
+		if c&0x80 == 0 {
+			return v, d.discard(i + 1)
+		}
+	}
+
+	return 0, ErrMalformedEntryName
 }
 
-// Index entries are padded out to the next 8 byte alignment
-// for historical reasons related to how C Git read the files.
-func (d *Decoder) padEntry(idx *Index, e *Entry, read int) error {
-	if idx.Version == 4 {
-		return nil
+// peek returns the next n bytes of the buffer, without consuming them.
+func (d *Decoder) peek(n int) ([]byte, error) {
+	b, err := d.buf.Peek(n)
+	if len(b) < n {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// discard consumes the next n bytes of the buffer.
+func (d *Decoder) discard(n int) error {
+	if _, err := d.peek(n); err != nil {
+		return err
 	}
 
-	entrySize := read + len(e.Name)
-	padLen := 8 - entrySize%8
-	_, err := io.CopyN(io.Discard, d.r, int64(padLen))
+	_, err := d.buf.Discard(n)
 	return err
 }
 
+// readUntil reads from the buffer up to delim, which is dropped. It avoids
+// reading byte by byte from d.r.
+func (d *Decoder) readUntil(delim byte) ([]byte, error) {
+	b, err := d.buf.ReadSlice(delim)
+	if err == bufio.ErrBufferFull {
+		// The slice is only valid until the next read.
+		b = append([]byte(nil), b...)
+
+		var rest []byte
+		rest, err = d.buf.ReadBytes(delim)
+		b = append(b, rest...)
+	}
+
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	return b[:len(b)-1], nil
+}
+
 func (d *Decoder) readExtensions(idx *Index) error {
-	// TODO: support 'Split index' and 'Untracked cache' extensions, take in
-	// count that they are not supported by jgit or libgit
+	// TODO: support the 'Split index' extension, take in count that it is
+	// not supported by jgit or libgit
 
-	var expected []byte
 	var peeked []byte
 	var err error
 
 	// we should always be able to peek for 4 bytes (header) + 4 bytes (extlen) + final hash
 	// if this fails, we know that we're at the end of the index
-	peekLen := 4 + 4 + d.hash.Size()
+	peekLen := 4 + 4 + hash.Size
 
 	for {
-		expected = d.hash.Sum(nil)
 		peeked, err = d.buf.Peek(peekLen)
 		if len(peeked) < peekLen {
 			// there can't be an extension at this point, so let's bail out
@@ -237,7 +374,7 @@ func (d *Decoder) readExtensions(idx *Index) error {
 		}
 	}
 
-	return d.readChecksum(expected)
+	return d.readChecksum()
 }
 
 func (d *Decoder) readExtension(idx *Index) error {
@@ -252,6 +389,11 @@ func (d *Decoder) readExtension(idx *Index) error {
 		return err
 	}
 
+	if d.skipExtensions && header[0] >= 'A' && header[0] <= 'Z' {
+		d := &unknownExtensionDecoder{r}
+		return d.Decode()
+	}
+
 	switch {
 	case bytes.Equal(header[:], treeExtSignature):
 		idx.Cache = &Tree{}
@@ -271,6 +413,34 @@ func (d *Decoder) readExtension(idx *Index) error {
 		if err := d.Decode(idx.EndOfIndexEntry); err != nil {
 			return err
 		}
+	case bytes.Equal(header[:], entryOffsetTableExtSignature):
+		idx.EntryOffsetTable = &EntryOffsetTable{}
+		d := &entryOffsetTableDecoder{r}
+		if err := d.Decode(idx.EntryOffsetTable); err != nil {
+			return err
+		}
+	case bytes.Equal(header[:], untrackedCacheExtSignature):
+		idx.UntrackedCache = &UntrackedCache{}
+		d := &untrackedCacheDecoder{r}
+		err := d.Decode(idx.UntrackedCache)
+		if err == errMalformedCache {
+			idx.UntrackedCache, err = nil, nil
+		}
+
+		if err != nil {
+			return err
+		}
+	case bytes.Equal(header[:], fsMonitorExtSignature):
+		idx.FSMonitor = &FSMonitor{}
+		d := &fsMonitorDecoder{r}
+		err := d.Decode(idx)
+		if err == errMalformedCache {
+			idx.FSMonitor, err = nil, nil
+		}
+
+		if err != nil {
+			return err
+		}
 	default:
 		// See https://git-scm.com/docs/index-format, which says:
 		// If the first byte is 'A'..'Z' the extension is optional and can be ignored.
@@ -297,13 +467,24 @@ func (d *Decoder) getExtensionReader() (*bufio.Reader, error) {
 	return d.extReader, nil
 }
 
-func (d *Decoder) readChecksum(expected []byte) error {
+func (d *Decoder) readChecksum() error {
 	var h plumbing.Hash
 
 	if _, err := io.ReadFull(d.r, h[:]); err != nil {
 		return err
 	}
 
+	// The input is read to the end, for the checksum reader to hash all
+	// the bytes before the checksum.
+	if _, err := d.buf.Peek(1); err != io.EOF {
+		if err != nil {
+			return err
+		}
+
+		return ErrInvalidChecksum
+	}
+
+	expected := d.hash.h.Sum(nil)
 	if !bytes.Equal(h[:], expected) {
 		return ErrInvalidChecksum
 	}
@@ -483,6 +664,301 @@ func (d *endOfIndexEntryDecoder) Decode(e *EndOfIndexEntry) error {
 	return err
 }
 
+type entryOffsetTableDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *entryOffsetTableDecoder) Decode(t *EntryOffsetTable) error {
+	version, err := binary.ReadUint32(d.r)
+	if err != nil {
+		return err
+	}
+
+	if version != entryOffsetTableVersion {
+		return ErrUnsupportedOffsetTable
+	}
+
+	for {
+		var b EntryOffsetBlock
+		b.Offset, err = binary.ReadUint32(d.r)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if b.Count, err = binary.ReadUint32(d.r); err != nil {
+			return err
+		}
+
+		t.Blocks = append(t.Blocks, b)
+	}
+}
+
+type untrackedCacheDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *untrackedCacheDecoder) Decode(uc *UntrackedCache) error {
+	data, err := io.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < 2 || data[len(data)-1] != 0 {
+		return errMalformedCache
+	}
+
+	r := bytes.NewReader(data[:len(data)-1])
+	if err := d.readHeader(r, uc); err != nil {
+		return errMalformedCache
+	}
+
+	count, err := binary.ReadVariableWidthInt(r)
+	if err != nil {
+		return errMalformedCache
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	var dirs []*UntrackedCacheDir
+	if uc.Root, err = d.readDir(r, &dirs); err != nil {
+		return errMalformedCache
+	}
+
+	if int64(len(dirs)) != count {
+		return errMalformedCache
+	}
+
+	pos := len(data) - 1 - r.Len()
+	var bitmaps [3]*bitmap.Bitmap
+	for i := range bitmaps {
+		var n int
+		bitmaps[i], n, err = bitmap.DecodeEWAH(data[pos:])
+		if err != nil {
+			return errMalformedCache
+		}
+
+		pos += n
+	}
+
+	valid, checkOnly, hashValid := bitmaps[0], bitmaps[1], bitmaps[2]
+	r = bytes.NewReader(data[pos : len(data)-1])
+	for i, dir := range dirs {
+		dir.CheckOnly = checkOnly.Get(i)
+		if !valid.Get(i) {
+			continue
+		}
+
+		dir.Valid = true
+		if err := readStatData(r, &dir.Stat); err != nil {
+			return errMalformedCache
+		}
+	}
+
+	for i, dir := range dirs {
+		if !hashValid.Get(i) {
+			continue
+		}
+
+		if _, err := io.ReadFull(r, dir.ExcludeHash[:]); err != nil {
+			return errMalformedCache
+		}
+	}
+
+	if r.Len() != 0 {
+		return errMalformedCache
+	}
+
+	return nil
+}
+
+func (d *untrackedCacheDecoder) readHeader(r *bytes.Reader, uc *UntrackedCache) error {
+	n, err := binary.ReadVariableWidthInt(r)
+	if err != nil {
+		return err
+	}
+
+	if n < 0 || n > int64(r.Len()) {
+		return errMalformedCache
+	}
+
+	envs := make([]byte, n)
+	if _, err := io.ReadFull(r, envs); err != nil {
+		return err
+	}
+
+	if len(envs) != 0 && envs[len(envs)-1] != 0 {
+		return errMalformedCache
+	}
+
+	for len(envs) != 0 {
+		i := bytes.IndexByte(envs, 0)
+		uc.Environments = append(uc.Environments, string(envs[:i]))
+		envs = envs[i+1:]
+	}
+
+	if err := readStatData(r, &uc.InfoExcludeStat); err != nil {
+		return err
+	}
+
+	if err := readStatData(r, &uc.ExcludesFileStat); err != nil {
+		return err
+	}
+
+	if uc.Flags, err = binary.ReadUint32(r); err != nil {
+		return err
+	}
+
+	if _, err := io.ReadFull(r, uc.InfoExcludeHash[:]); err != nil {
+		return err
+	}
+
+	if _, err := io.ReadFull(r, uc.ExcludesFileHash[:]); err != nil {
+		return err
+	}
+
+	name, err := binary.ReadUntil(r, '\x00')
+	uc.ExcludePerDir = string(name)
+	return err
+}
+
+// readDir reads a directory block and the ones of its sub-directories,
+// appending them to dirs in depth-first order.
+func (d *untrackedCacheDecoder) readDir(r *bytes.Reader, dirs *[]*UntrackedCacheDir) (*UntrackedCacheDir, error) {
+	untracked, err := binary.ReadVariableWidthInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	subdirs, err := binary.ReadVariableWidthInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// each name takes at least one byte
+	if untracked < 0 || subdirs < 0 || untracked+subdirs > int64(r.Len()) {
+		return nil, errMalformedCache
+	}
+
+	name, err := binary.ReadUntil(r, '\x00')
+	if err != nil {
+		return nil, err
+	}
+
+	dir := &UntrackedCacheDir{Name: string(name)}
+	*dirs = append(*dirs, dir)
+
+	for i := int64(0); i < untracked; i++ {
+		name, err := binary.ReadUntil(r, '\x00')
+		if err != nil {
+			return nil, err
+		}
+
+		dir.Untracked = append(dir.Untracked, string(name))
+	}
+
+	for i := int64(0); i < subdirs; i++ {
+		sub, err := d.readDir(r, dirs)
+		if err != nil {
+			return nil, err
+		}
+
+		dir.Dirs = append(dir.Dirs, sub)
+	}
+
+	return dir, nil
+}
+
+func readStatData(r io.Reader, s *StatData) error {
+	var v [9]uint32
+	if err := binary.Read(r, &v); err != nil {
+		return err
+	}
+
+	s.CreatedAt = statTime(v[0], v[1])
+	s.ModifiedAt = statTime(v[2], v[3])
+	s.Dev, s.Inode = v[4], v[5]
+	s.UID, s.GID = v[6], v[7]
+	s.Size = v[8]
+	return nil
+}
+
+func statTime(sec, nsec uint32) time.Time {
+	if sec == 0 && nsec == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+type fsMonitorDecoder struct {
+	r *bufio.Reader
+}
+
+// Decode decodes the extension into idx.FSMonitor, setting FSMonitorValid on
+// the entries of idx it covers.
+func (d *fsMonitorDecoder) Decode(idx *Index) error {
+	data, err := io.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < 4 {
+		return errMalformedCache
+	}
+
+	version := encbin.BigEndian.Uint32(data)
+	data = data[4:]
+	switch version {
+	case fsMonitorVersion1:
+		if len(data) < 8 {
+			return errMalformedCache
+		}
+
+		idx.FSMonitor.Token = strconv.FormatUint(encbin.BigEndian.Uint64(data), 10)
+		data = data[8:]
+	case fsMonitorVersion2:
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			return errMalformedCache
+		}
+
+		idx.FSMonitor.Token = string(data[:i])
+		data = data[i+1:]
+	default:
+		return errMalformedCache
+	}
+
+	if len(data) < 4 || int(encbin.BigEndian.Uint32(data)) != len(data)-4 {
+		return errMalformedCache
+	}
+
+	dirty, _, err := bitmap.DecodeEWAH(data[4:])
+	if err != nil {
+		return errMalformedCache
+	}
+
+	invalid := false
+	dirty.ForEach(func(i int) {
+		invalid = invalid || i >= len(idx.Entries)
+	})
+
+	if invalid {
+		return errMalformedCache
+	}
+
+	for i, e := range idx.Entries {
+		e.FSMonitorValid = !dirty.Get(i)
+	}
+
+	return nil
+}
+
 type unknownExtensionDecoder struct {
 	r *bufio.Reader
 }
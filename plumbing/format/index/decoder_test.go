@@ -2,6 +2,7 @@ package index
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"github.com/go-git/go-git/v5/plumbing/hash"
 	"github.com/go-git/go-git/v5/utils/binary"
@@ -272,6 +273,30 @@ func (s *IndexSuite) TestDecodeUnknownMandatoryExt(c *C) {
 	c.Assert(err, ErrorMatches, ErrUnknownExtension.Error())
 }
 
+func (s *IndexSuite) TestDecodeSkipExtensions(c *C) {
+	f, err := fixtures.Basic().One().DotGit().Open("index")
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(f.Close(), IsNil) }()
+
+	idx := &Index{}
+	d := NewDecoder(f)
+	d.SetSkipExtensions(true)
+	c.Assert(d.Decode(idx), IsNil)
+	c.Assert(idx.Entries, HasLen, 9)
+	c.Assert(idx.Cache, IsNil)
+}
+
+func (s *IndexSuite) TestDecodeContext(c *C) {
+	buf := bytes.NewBuffer(nil)
+	c.Assert(NewEncoder(buf).Encode(syntheticIndex(4, 42)), IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	idx := &Index{}
+	c.Assert(NewDecoder(buf).DecodeContext(ctx, idx), Equals, context.Canceled)
+}
+
 func (s *IndexSuite) TestDecodeTruncatedExt(c *C) {
 	idx := s.readSimpleIndex(c)
 
@@ -1,15 +1,15 @@
 package index
 
 import (
-	"bytes"
+	"context"
+	encbin "encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"path"
 	"sort"
-	"strings"
 	"time"
 
+	"github.com/go-git/go-git/v5/plumbing/format/bitmap"
 	"github.com/go-git/go-git/v5/plumbing/hash"
 	"github.com/go-git/go-git/v5/utils/binary"
 )
@@ -23,28 +23,60 @@ var (
 	ErrInvalidTimestamp = errors.New("negative timestamps are not allowed")
 )
 
+// entryOffsetBlockSize is the number of entries of each block of the index
+// entry offset table, git doesn't use a thread for less than 10000 entries.
+const entryOffsetBlockSize = 10000
+
+// encoderFlushSize is the size from which the encoded entries are written,
+// they are appended to a buffer instead of written one field at a time.
+const encoderFlushSize = 32 * 1024
+
 // An Encoder writes an Index to an output stream.
 type Encoder struct {
 	w         io.Writer
 	hash      hash.Hash
 	lastEntry *Entry
+	// written is the number of bytes written, used as the offsets of the
+	// extensions.
+	written *countingWriter
+	// blockStart is set when the next entry starts a block of the entry
+	// offset table, which are not prefix compressed.
+	blockStart bool
+	// buf holds the entries encoded but not written yet.
+	buf      []byte
+	progress func(written, total int)
 }
 
 // NewEncoder returns a new encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder {
-	h := hash.New(hash.CryptoType)
-	mw := io.MultiWriter(w, h)
-	return &Encoder{mw, h, nil}
+	h := hash.NewChecksum(hash.CryptoType)
+	cw := &countingWriter{w: io.MultiWriter(w, h)}
+	return &Encoder{w: cw, hash: h, written: cw}
+}
+
+// SetProgress sets a function called as the entries are written, with the
+// number of entries written and the total of entries of the index.
+func (e *Encoder) SetProgress(f func(written, total int)) {
+	e.progress = f
 }
 
 // Encode writes the Index to the stream of the encoder.
 func (e *Encoder) Encode(idx *Index) error {
-	return e.encode(idx, true)
+	return e.EncodeContext(context.Background(), idx)
+}
+
+// EncodeContext writes the Index to the stream of the encoder as Encode
+// does, checking the given context between the entries.
+func (e *Encoder) EncodeContext(ctx context.Context, idx *Index) error {
+	return e.encodeContext(ctx, idx, true)
 }
 
 func (e *Encoder) encode(idx *Index, footer bool) error {
+	return e.encodeContext(context.Background(), idx, footer)
+}
 
-	// TODO: support extensions
+func (e *Encoder) encodeContext(ctx context.Context, idx *Index, footer bool) error {
+	// TODO: support the cache tree and resolve undo extensions
 	if idx.Version > EncodeVersionSupported {
 		return ErrUnsupportedVersion
 	}
@@ -53,54 +85,124 @@ func (e *Encoder) encode(idx *Index, footer bool) error {
 		return err
 	}
 
-	if err := e.encodeEntries(idx); err != nil {
+	if err := e.encodeEntries(ctx, idx); err != nil {
 		return err
 	}
 
 	if footer {
+		if err := e.encodeExtensions(idx); err != nil {
+			return err
+		}
+
 		return e.encodeFooter()
 	}
 	return nil
 }
 
 func (e *Encoder) encodeHeader(idx *Index) error {
+	version := idx.Version
+	if version == 2 && hasExtendedFlags(idx) {
+		// The extended flags are only supported since version 3, git
+		// upgrades the index as well when they are used.
+		version = 3
+	}
+
 	return binary.Write(e.w,
 		indexSignature,
-		idx.Version,
+		version,
 		uint32(len(idx.Entries)),
 	)
 }
 
-func (e *Encoder) encodeEntries(idx *Index) error {
-	sort.Sort(byName(idx.Entries))
-
+func hasExtendedFlags(idx *Index) bool {
 	for _, entry := range idx.Entries {
-		if err := e.encodeEntry(idx, entry); err != nil {
-			return err
-		}
-		entryLength := entryHeaderLength
 		if entry.IntentToAdd || entry.SkipWorktree {
-			entryLength += 2
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *Encoder) encodeEntries(ctx context.Context, idx *Index) error {
+	// The entries are usually kept sorted, checking it is cheaper than
+	// sorting them again.
+	if !sort.IsSorted(byName(idx.Entries)) {
+		sort.Sort(byName(idx.Entries))
+	}
+
+	t := idx.EntryOffsetTable
+	if t != nil {
+		t.Blocks = nil
+	}
+
+	total := len(idx.Entries)
+	e.buf = e.buf[:0]
+	for i, entry := range idx.Entries {
+		if i%entryOffsetBlockSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if e.progress != nil {
+				e.progress(i, total)
+			}
+
+			if t != nil {
+				offset := e.written.n + int64(len(e.buf))
+				t.Blocks = append(t.Blocks, EntryOffsetBlock{Offset: uint32(offset)})
+				e.blockStart = true
+			}
 		}
 
-		wrote := entryLength + len(entry.Name)
-		if err := e.padEntry(idx, wrote); err != nil {
+		var err error
+		if e.buf, err = e.appendEntry(e.buf, idx, entry); err != nil {
 			return err
 		}
+
+		if t != nil {
+			t.Blocks[len(t.Blocks)-1].Count++
+		}
+
+		if len(e.buf) >= encoderFlushSize {
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := e.flush(); err != nil {
+		return err
+	}
+
+	if e.progress != nil {
+		e.progress(total, total)
 	}
 
 	return nil
 }
 
-func (e *Encoder) encodeEntry(idx *Index, entry *Entry) error {
+// flush writes the entries encoded in the buffer.
+func (e *Encoder) flush() error {
+	if len(e.buf) == 0 {
+		return nil
+	}
+
+	_, err := e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
+
+// appendEntry appends the encoded entry to data.
+func (e *Encoder) appendEntry(data []byte, idx *Index, entry *Entry) ([]byte, error) {
 	sec, nsec, err := e.timeToUint32(&entry.CreatedAt)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	msec, mnsec, err := e.timeToUint32(&entry.ModifiedAt)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	flags := uint16(entry.Stage&0x3) << 12
@@ -110,19 +212,22 @@ func (e *Encoder) encodeEntry(idx *Index, entry *Entry) error {
 		flags |= nameMask
 	}
 
-	flow := []interface{}{
+	start := len(data)
+	be := encbin.BigEndian
+	for _, v := range [...]uint32{
 		sec, nsec,
 		msec, mnsec,
 		entry.Dev,
 		entry.Inode,
-		entry.Mode,
+		uint32(entry.Mode),
 		entry.UID,
 		entry.GID,
 		entry.Size,
-		entry.Hash[:],
+	} {
+		data = be.AppendUint32(data, v)
 	}
 
-	flagsFlow := []interface{}{flags}
+	data = append(data, entry.Hash[:]...)
 
 	if entry.IntentToAdd || entry.SkipWorktree {
 		var extendedFlags uint16
@@ -134,52 +239,259 @@ func (e *Encoder) encodeEntry(idx *Index, entry *Entry) error {
 			extendedFlags |= skipWorkTreeMask
 		}
 
-		flagsFlow = []interface{}{flags | entryExtended, extendedFlags}
-	}
-
-	flow = append(flow, flagsFlow...)
-
-	if err := binary.Write(e.w, flow...); err != nil {
-		return err
+		data = be.AppendUint16(data, flags|entryExtended)
+		data = be.AppendUint16(data, extendedFlags)
+	} else {
+		data = be.AppendUint16(data, flags)
 	}
 
 	switch idx.Version {
 	case 2, 3:
-		err = e.encodeEntryName(entry)
+		data = append(data, entry.Name...)
+		// Index entries are padded out to the next 8 byte alignment
+		// for historical reasons related to how C Git read the files.
+		var pad [8]byte
+		data = append(data, pad[:8-(len(data)-start)%8]...)
 	case 4:
-		err = e.encodeEntryNameV4(entry)
+		data = e.appendEntryNameV4(data, entry)
 	default:
-		err = ErrUnsupportedVersion
+		return nil, ErrUnsupportedVersion
 	}
 
-	return err
-}
-
-func (e *Encoder) encodeEntryName(entry *Entry) error {
-	return binary.Write(e.w, []byte(entry.Name))
+	return data, nil
 }
 
-func (e *Encoder) encodeEntryNameV4(entry *Entry) error {
+// appendEntryNameV4 appends the name prefix compressed, as the number of
+// bytes to remove from the end of the previous name followed by the rest of
+// the name.
+func (e *Encoder) appendEntryNameV4(data []byte, entry *Entry) []byte {
 	name := entry.Name
 	l := 0
 	if e.lastEntry != nil {
-		dir := path.Dir(e.lastEntry.Name) + "/"
-		if strings.HasPrefix(entry.Name, dir) {
-			l = len(e.lastEntry.Name) - len(dir)
-			name = strings.TrimPrefix(entry.Name, dir)
-		} else {
-			l = len(e.lastEntry.Name)
+		var common int
+		if !e.blockStart {
+			common = commonPrefix(e.lastEntry.Name, entry.Name)
 		}
+
+		l = len(e.lastEntry.Name) - common
+		name = entry.Name[common:]
 	}
 
 	e.lastEntry = entry
+	e.blockStart = false
+
+	data = appendVarint(data, uint64(l))
+	data = append(data, name...)
+	return append(data, 0)
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+
+	return i
+}
+
+// encodeExtensions writes the extensions present in the index, the entry
+// offset table first and the end of index entry last, as git does, updating
+// them.
+func (e *Encoder) encodeExtensions(idx *Index) error {
+	offset := uint32(e.written.n)
+
+	// The end of index entry hash covers the signature and the size of
+	// the extensions written before it.
+	eoie := hash.New(hash.CryptoType)
+	write := func(signature, data []byte) error {
+		eoie.Write(signature)
+		eoie.Write(encbin.BigEndian.AppendUint32(nil, uint32(len(data))))
+		return e.encodeRawExtension(string(signature), data)
+	}
+
+	if t := idx.EntryOffsetTable; t != nil {
+		data := make([]byte, 0, 4+8*len(t.Blocks))
+		data = encbin.BigEndian.AppendUint32(data, entryOffsetTableVersion)
+		for _, b := range t.Blocks {
+			data = encbin.BigEndian.AppendUint32(data, b.Offset)
+			data = encbin.BigEndian.AppendUint32(data, b.Count)
+		}
+
+		if err := write(entryOffsetTableExtSignature, data); err != nil {
+			return err
+		}
+	}
+
+	if uc := idx.UntrackedCache; uc != nil {
+		data, err := e.encodeUntrackedCache(uc)
+		if err != nil {
+			return err
+		}
+
+		if err := write(untrackedCacheExtSignature, data); err != nil {
+			return err
+		}
+	}
+
+	if idx.FSMonitor != nil {
+		if err := write(fsMonitorExtSignature, e.encodeFSMonitor(idx)); err != nil {
+			return err
+		}
+	}
+
+	if eie := idx.EndOfIndexEntry; eie != nil {
+		eie.Offset = offset
+		copy(eie.Hash[:], eoie.Sum(nil))
+
+		data := encbin.BigEndian.AppendUint32(nil, eie.Offset)
+		data = append(data, eie.Hash[:]...)
+		if err := e.encodeRawExtension(string(endOfIndexEntryExtSignature), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Encoder) encodeUntrackedCache(uc *UntrackedCache) ([]byte, error) {
+	var envs []byte
+	for _, env := range uc.Environments {
+		envs = append(envs, env...)
+		envs = append(envs, 0)
+	}
+
+	data := appendVarint(nil, uint64(len(envs)))
+	data = append(data, envs...)
+
+	var err error
+	if data, err = e.appendStatData(data, &uc.InfoExcludeStat); err != nil {
+		return nil, err
+	}
+
+	if data, err = e.appendStatData(data, &uc.ExcludesFileStat); err != nil {
+		return nil, err
+	}
+
+	data = encbin.BigEndian.AppendUint32(data, uc.Flags)
+	data = append(data, uc.InfoExcludeHash[:]...)
+	data = append(data, uc.ExcludesFileHash[:]...)
+	data = append(data, uc.ExcludePerDir...)
+	data = append(data, 0)
+
+	if uc.Root == nil {
+		data = appendVarint(data, 0)
+		return append(data, 0), nil
+	}
+
+	var dirs []*UntrackedCacheDir
+	blocks := appendUntrackedCacheDir(nil, uc.Root, &dirs)
+	data = appendVarint(data, uint64(len(dirs)))
+	data = append(data, blocks...)
+
+	valid, checkOnly, hashValid := bitmap.NewBitmap(), bitmap.NewBitmap(), bitmap.NewBitmap()
+	var stats, hashes []byte
+	for i, dir := range dirs {
+		if dir.CheckOnly {
+			checkOnly.Set(i)
+		}
+
+		if dir.Valid {
+			valid.Set(i)
+			if stats, err = e.appendStatData(stats, &dir.Stat); err != nil {
+				return nil, err
+			}
+		}
+
+		if !dir.ExcludeHash.IsZero() {
+			hashValid.Set(i)
+			hashes = append(hashes, dir.ExcludeHash[:]...)
+		}
+	}
+
+	data = append(data, valid.EncodeEWAH()...)
+	data = append(data, checkOnly.EncodeEWAH()...)
+	data = append(data, hashValid.EncodeEWAH()...)
+	data = append(data, stats...)
+	data = append(data, hashes...)
+	return append(data, 0), nil
+}
+
+// appendUntrackedCacheDir appends the block of dir and the ones of its
+// sub-directories, appending them to dirs in depth-first order. As git, the
+// untracked files of the directories not valid are not written.
+func appendUntrackedCacheDir(data []byte, dir *UntrackedCacheDir, dirs *[]*UntrackedCacheDir) []byte {
+	*dirs = append(*dirs, dir)
 
-	err := binary.WriteVariableWidthInt(e.w, int64(l))
+	var untracked []string
+	if dir.Valid {
+		untracked = dir.Untracked
+	}
+
+	data = appendVarint(data, uint64(len(untracked)))
+	data = appendVarint(data, uint64(len(dir.Dirs)))
+	data = append(data, dir.Name...)
+	data = append(data, 0)
+	for _, name := range untracked {
+		data = append(data, name...)
+		data = append(data, 0)
+	}
+
+	for _, sub := range dir.Dirs {
+		data = appendUntrackedCacheDir(data, sub, dirs)
+	}
+
+	return data
+}
+
+func (e *Encoder) appendStatData(data []byte, s *StatData) ([]byte, error) {
+	sec, nsec, err := e.timeToUint32(&s.CreatedAt)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	msec, mnsec, err := e.timeToUint32(&s.ModifiedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range []uint32{sec, nsec, msec, mnsec, s.Dev, s.Inode, s.UID, s.GID, s.Size} {
+		data = encbin.BigEndian.AppendUint32(data, v)
+	}
+
+	return data, nil
+}
+
+// encodeFSMonitor returns the file system monitor extension, always in its
+// version 2, with a bitmap of the entries without FSMonitorValid.
+func (e *Encoder) encodeFSMonitor(idx *Index) []byte {
+	dirty := bitmap.NewBitmap()
+	for i, entry := range idx.Entries {
+		if !entry.FSMonitorValid {
+			dirty.Set(i)
+		}
 	}
 
-	return binary.Write(e.w, []byte(name+string('\x00')))
+	bm := dirty.EncodeEWAH()
+	data := encbin.BigEndian.AppendUint32(nil, fsMonitorVersion2)
+	data = append(data, idx.FSMonitor.Token...)
+	data = append(data, 0)
+	data = encbin.BigEndian.AppendUint32(data, uint32(len(bm)))
+	return append(data, bm...)
+}
+
+// appendVarint appends n in the variable width encoding of git, as written by
+// binary.WriteVariableWidthInt.
+func appendVarint(data []byte, n uint64) []byte {
+	var buf [10]byte
+	i := len(buf) - 1
+	buf[i] = byte(n & 0x7f)
+	for n >>= 7; n != 0; n >>= 7 {
+		n--
+		i--
+		buf[i] = 0x80 | byte(n&0x7f)
+	}
+
+	return append(data, buf[i:]...)
 }
 
 func (e *Encoder) encodeRawExtension(signature string, data []byte) error {
@@ -217,19 +529,19 @@ func (e *Encoder) timeToUint32(t *time.Time) (uint32, uint32, error) {
 	return uint32(t.Unix()), uint32(t.Nanosecond()), nil
 }
 
-func (e *Encoder) padEntry(idx *Index, wrote int) error {
-	if idx.Version == 4 {
-		return nil
-	}
-
-	padLen := 8 - wrote%8
+func (e *Encoder) encodeFooter() error {
+	return binary.Write(e.w, e.hash.Sum(nil))
+}
 
-	_, err := e.w.Write(bytes.Repeat([]byte{'\x00'}, padLen))
-	return err
+type countingWriter struct {
+	w io.Writer
+	n int64
 }
 
-func (e *Encoder) encodeFooter() error {
-	return binary.Write(e.w, e.hash.Sum(nil))
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
 }
 
 type byName []*Entry
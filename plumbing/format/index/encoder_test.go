@@ -2,11 +2,19 @@ package index
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/hash"
 
+	fixtures "github.com/go-git/go-git-fixtures/v4"
 	"github.com/google/go-cmp/cmp"
 	. "gopkg.in/check.v1"
 )
@@ -141,6 +149,27 @@ func (s *IndexSuite) TestEncodeWithIntentToAddUnsupportedVersion(c *C) {
 	c.Assert(output.Entries[0].IntentToAdd, Equals, true)
 }
 
+func (s *IndexSuite) TestEncodeWithIntentToAddVersion2(c *C) {
+	idx := &Index{
+		Version: 2,
+		Entries: []*Entry{{Name: "foo", IntentToAdd: true}},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	err := e.Encode(idx)
+	c.Assert(err, IsNil)
+
+	output := &Index{}
+	d := NewDecoder(buf)
+	err = d.Decode(output)
+	c.Assert(err, IsNil)
+
+	c.Assert(output.Version, Equals, uint32(3))
+	c.Assert(output.Entries[0].Name, Equals, "foo")
+	c.Assert(output.Entries[0].IntentToAdd, Equals, true)
+}
+
 func (s *IndexSuite) TestEncodeWithSkipWorktreeUnsupportedVersion(c *C) {
 	idx := &Index{
 		Version: 3,
@@ -160,3 +189,212 @@ func (s *IndexSuite) TestEncodeWithSkipWorktreeUnsupportedVersion(c *C) {
 	c.Assert(cmp.Equal(idx, output), Equals, true)
 	c.Assert(output.Entries[0].SkipWorktree, Equals, true)
 }
+
+func (s *IndexSuite) TestEncodeV4SameAsGit(c *C) {
+	f, err := fixtures.Basic().ByTag("index-v4").One().DotGit().Open("index")
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(f.Close(), IsNil) }()
+
+	expected, err := io.ReadAll(f)
+	c.Assert(err, IsNil)
+
+	idx := &Index{}
+	c.Assert(NewDecoder(bytes.NewReader(expected)).Decode(idx), IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(NewEncoder(buf).encode(idx, false), IsNil)
+
+	// The entries are prefix compressed the same way git does.
+	c.Assert(buf.Bytes(), DeepEquals, expected[:buf.Len()])
+}
+
+func (s *IndexSuite) TestEncodeEntryOffsetTable(c *C) {
+	idx := &Index{
+		Version:          4,
+		EndOfIndexEntry:  &EndOfIndexEntry{},
+		EntryOffsetTable: &EntryOffsetTable{},
+	}
+
+	for i := 0; i < 2*entryOffsetBlockSize+42; i++ {
+		e := idx.Add(fmt.Sprintf("dir-%d/file-%05d", i%3, i))
+		e.Hash = plumbing.NewHash("e25b29c8946e0e192fae2edc1dabf7be71e8ecf3")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(NewEncoder(buf).Encode(idx), IsNil)
+
+	blocks := idx.EntryOffsetTable.Blocks
+	c.Assert(blocks, HasLen, 3)
+	c.Assert(blocks[0], Equals, EntryOffsetBlock{Offset: 12, Count: entryOffsetBlockSize})
+	c.Assert(blocks[1].Count, Equals, uint32(entryOffsetBlockSize))
+	c.Assert(blocks[2].Count, Equals, uint32(42))
+
+	// Each block can be decoded on its own, its first name isn't prefix
+	// compressed.
+	data := buf.Bytes()
+	first := 0
+	for _, b := range blocks {
+		e := &Entry{}
+		d := NewDecoder(bytes.NewReader(data[b.Offset:]))
+		c.Assert(d.readEntry(idx, e), IsNil)
+		c.Assert(e.Name, Equals, idx.Entries[first].Name)
+		first += int(b.Count)
+	}
+
+	output := &Index{}
+	c.Assert(NewDecoder(bytes.NewReader(data)).Decode(output), IsNil)
+	c.Assert(cmp.Equal(idx, output), Equals, true)
+
+	// The end of index entry points to the entry offset table.
+	eoie := output.EndOfIndexEntry
+	c.Assert(string(data[eoie.Offset:eoie.Offset+4]), Equals, "IEOT")
+
+	h := hash.New(hash.CryptoType)
+	h.Write([]byte("IEOT"))
+	h.Write([]byte{0, 0, 0, 4 + 3*8})
+	c.Assert(eoie.Hash[:], DeepEquals, h.Sum(nil))
+}
+
+func (s *IndexSuite) TestEncodeUntrackedCacheAndFSMonitor(c *C) {
+	ts := time.Unix(1700000000, 42)
+	idx := &Index{
+		Version: 2,
+		UntrackedCache: &UntrackedCache{
+			Environments:    []string{"Location /foo, system Linux", "bar"},
+			InfoExcludeStat: StatData{ModifiedAt: ts, Size: 240},
+			InfoExcludeHash: plumbing.NewHash("e25b29c8946e0e192fae2edc1dabf7be71e8ecf3"),
+			Flags:           6,
+			ExcludePerDir:   ".gitignore",
+			Root: &UntrackedCacheDir{
+				Untracked: []string{"foo", "bar/"},
+				Valid:     true,
+				Stat:      StatData{CreatedAt: ts, ModifiedAt: ts, Dev: 1, Inode: 2, UID: 3, GID: 4, Size: 5},
+				Dirs: []*UntrackedCacheDir{{
+					Name:        "a",
+					ExcludeHash: plumbing.NewHash("ce013625030ba8dba906f756967f9e9ca394464a"),
+				}, {
+					Name:      "b",
+					Untracked: []string{"qux"},
+					Valid:     true,
+					Stat:      StatData{ModifiedAt: ts},
+				}},
+			},
+		},
+		FSMonitor: &FSMonitor{Token: "builtin:1:2"},
+	}
+
+	for i := 0; i < 100; i++ {
+		e := idx.Add(fmt.Sprintf("file-%02d", i))
+		e.Hash = plumbing.NewHash("e25b29c8946e0e192fae2edc1dabf7be71e8ecf3")
+		e.FSMonitorValid = i%7 != 0
+	}
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(NewEncoder(buf).Encode(idx), IsNil)
+
+	output := &Index{}
+	c.Assert(NewDecoder(buf).Decode(output), IsNil)
+	c.Assert(cmp.Equal(idx, output), Equals, true, Commentf("%s", cmp.Diff(idx, output)))
+
+	idx.UntrackedCache = &UntrackedCache{ExcludePerDir: ".gitignore"}
+	idx.FSMonitor = nil
+
+	buf.Reset()
+	c.Assert(NewEncoder(buf).Encode(idx), IsNil)
+
+	output = &Index{}
+	c.Assert(NewDecoder(buf).Decode(output), IsNil)
+	c.Assert(output.UntrackedCache, DeepEquals, idx.UntrackedCache)
+	c.Assert(output.FSMonitor, IsNil)
+	c.Assert(output.Entries[1].FSMonitorValid, Equals, false)
+}
+
+func (s *IndexSuite) TestDecodeMalformedCacheExtensions(c *C) {
+	idx := &Index{Version: 2}
+	idx.Add("foo").FSMonitorValid = true
+
+	buf := bytes.NewBuffer(nil)
+	e := NewEncoder(buf)
+	c.Assert(e.encode(idx, false), IsNil)
+	c.Assert(e.encodeRawExtension("UNTR", []byte{0, 1, 2}), IsNil)
+	// the bitmap covers more entries than the index has
+	bm := []byte{0, 0, 0, 64, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0}
+	data := append([]byte{0, 0, 0, 2, 'f', 'o', 'o', 0, 0, 0, 0, byte(len(bm))}, bm...)
+	c.Assert(e.encodeRawExtension("FSMN", data), IsNil)
+	c.Assert(e.encodeFooter(), IsNil)
+
+	output := &Index{}
+	c.Assert(NewDecoder(buf).Decode(output), IsNil)
+	c.Assert(output.UntrackedCache, IsNil)
+	c.Assert(output.FSMonitor, IsNil)
+	c.Assert(output.Entries, HasLen, 1)
+	c.Assert(output.Entries[0].FSMonitorValid, Equals, false)
+}
+
+func (s *IndexSuite) TestEncodeContext(c *C) {
+	idx := syntheticIndex(2, 2*entryOffsetBlockSize+42)
+
+	var progress [][2]int
+	e := NewEncoder(io.Discard)
+	e.SetProgress(func(written, total int) {
+		progress = append(progress, [2]int{written, total})
+	})
+
+	c.Assert(e.EncodeContext(context.Background(), idx), IsNil)
+	c.Assert(progress, DeepEquals, [][2]int{
+		{0, len(idx.Entries)},
+		{entryOffsetBlockSize, len(idx.Entries)},
+		{2 * entryOffsetBlockSize, len(idx.Entries)},
+		{len(idx.Entries), len(idx.Entries)},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e = NewEncoder(io.Discard)
+	e.SetProgress(func(written, total int) {
+		if written > 0 {
+			cancel()
+		}
+	})
+
+	c.Assert(e.EncodeContext(ctx, idx), Equals, context.Canceled)
+}
+
+func (s *IndexSuite) TestEncodeReadByGit(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	for _, version := range []uint32{2, 4} {
+		dir := c.MkDir()
+		git := func(args ...string) string {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = dir
+			out, err := cmd.CombinedOutput()
+			c.Assert(err, IsNil, Commentf("git %s: %s", args, out))
+			return string(out)
+		}
+
+		git("init", "-q")
+		blob := plumbing.NewHash(strings.TrimSpace(git("hash-object", "-w", "--stdin")))
+
+		idx := syntheticIndex(version, 2*entryOffsetBlockSize+42)
+		idx.EndOfIndexEntry = &EndOfIndexEntry{}
+		idx.EntryOffsetTable = &EntryOffsetTable{}
+
+		var expected strings.Builder
+		for _, e := range idx.Entries {
+			e.Hash = blob
+			fmt.Fprintf(&expected, "100644 %s 0\t%s\n", blob, e.Name)
+		}
+
+		f, err := os.Create(filepath.Join(dir, ".git", "index"))
+		c.Assert(err, IsNil)
+		c.Assert(NewEncoder(f).Encode(idx), IsNil)
+		c.Assert(f.Close(), IsNil)
+
+		c.Assert(git("ls-files", "--stage") == expected.String(), Equals, true)
+		// fsck verifies the checksum of the index, which is not checked
+		// when it is read otherwise.
+		git("fsck", "--no-dangling")
+	}
+}
@@ -19,10 +19,19 @@ var (
 	// ErrEntryNotFound is returned by Index.Entry, if an entry is not found.
 	ErrEntryNotFound = errors.New("entry not found")
 
-	indexSignature              = []byte{'D', 'I', 'R', 'C'}
-	treeExtSignature            = []byte{'T', 'R', 'E', 'E'}
-	resolveUndoExtSignature     = []byte{'R', 'E', 'U', 'C'}
-	endOfIndexEntryExtSignature = []byte{'E', 'O', 'I', 'E'}
+	indexSignature               = []byte{'D', 'I', 'R', 'C'}
+	treeExtSignature             = []byte{'T', 'R', 'E', 'E'}
+	resolveUndoExtSignature      = []byte{'R', 'E', 'U', 'C'}
+	endOfIndexEntryExtSignature  = []byte{'E', 'O', 'I', 'E'}
+	entryOffsetTableExtSignature = []byte{'I', 'E', 'O', 'T'}
+	untrackedCacheExtSignature   = []byte{'U', 'N', 'T', 'R'}
+	fsMonitorExtSignature        = []byte{'F', 'S', 'M', 'N'}
+)
+
+const (
+	entryOffsetTableVersion = 1
+	fsMonitorVersion1       = 1
+	fsMonitorVersion2       = 2
 )
 
 // Stage during merge
@@ -54,6 +63,13 @@ type Index struct {
 	ResolveUndo *ResolveUndo
 	// EndOfIndexEntry represents the 'End of Index Entry' extension
 	EndOfIndexEntry *EndOfIndexEntry
+	// EntryOffsetTable represents the 'Index Entry Offset Table' extension
+	EntryOffsetTable *EntryOffsetTable
+	// UntrackedCache represents the 'Untracked cache' extension
+	UntrackedCache *UntrackedCache
+	// FSMonitor represents the 'File System Monitor cache' extension, the
+	// entries it covers have FSMonitorValid set
+	FSMonitor *FSMonitor
 }
 
 // Add creates a new Entry and returns it. The caller should first check that
@@ -149,6 +165,10 @@ type Entry struct {
 	// IntentToAdd record only the fact that the path will be added later
 	// https://git-scm.com/docs/git-add ("git add -N")
 	IntentToAdd bool
+	// FSMonitorValid is set when the file system monitor reported no changes
+	// to the tracked path since it was found to match the entry, see the
+	// FSMonitor extension of the Index
+	FSMonitorValid bool
 }
 
 func (e Entry) String() string {
@@ -203,8 +223,8 @@ type ResolveUndoEntry struct {
 // can take advantage of this to quickly locate the index extensions without
 // having to parse through all of the index entries.
 //
-//  Because it must be able to be loaded before the variable length cache
-//  entries and other index extensions, this extension must be written last.
+//	Because it must be able to be loaded before the variable length cache
+//	entries and other index extensions, this extension must be written last.
 type EndOfIndexEntry struct {
 	// Offset to the end of the index entries
 	Offset uint32
@@ -213,6 +233,86 @@ type EndOfIndexEntry struct {
 	Hash plumbing.Hash
 }
 
+// EntryOffsetTable is the Index Entry Offset Table (IEOT), it splits the index
+// entries in blocks, each starting without prefix compression in version 4,
+// so they can be loaded by several threads. It is only used along with the
+// End of Index Entry extension, which allows to find it.
+type EntryOffsetTable struct {
+	Blocks []EntryOffsetBlock
+}
+
+// EntryOffsetBlock is a block of entries of the Index Entry Offset Table.
+type EntryOffsetBlock struct {
+	// Offset of the first entry of the block, from the beginning of the file
+	Offset uint32
+	// Count is the number of entries in the block
+	Count uint32
+}
+
+// UntrackedCache is the Untracked Cache extension (UNTR), it saves the
+// untracked files of each directory of the worktree, along with the stat data
+// of the directory, so they are not listed again while it is unchanged.
+type UntrackedCache struct {
+	// Environments describe where the cache can be used, it is not valid
+	// anywhere else. Git only uses the caches listing its own environment.
+	Environments []string
+	// InfoExcludeStat and InfoExcludeHash are the stat data and the hash of
+	// $GIT_DIR/info/exclude, a zero hash means the file does not exist
+	InfoExcludeStat StatData
+	InfoExcludeHash plumbing.Hash
+	// ExcludesFileStat and ExcludesFileHash are the stat data and the hash
+	// of the core.excludesFile
+	ExcludesFileStat StatData
+	ExcludesFileHash plumbing.Hash
+	// Flags are the flags of the directory traversal the cache was built
+	// with, see struct dir_struct in git
+	Flags uint32
+	// ExcludePerDir is the name of the per directory exclude files, usually
+	// .gitignore
+	ExcludePerDir string
+	// Root is the root directory of the worktree, nil for an empty cache
+	Root *UntrackedCacheDir
+}
+
+// UntrackedCacheDir is a directory of the Untracked Cache extension.
+type UntrackedCacheDir struct {
+	// Name of the directory, relative to its parent directory
+	Name string
+	// Untracked are the names of the untracked files of the directory
+	Untracked []string
+	// Dirs are the sub-directories of the directory
+	Dirs []*UntrackedCacheDir
+	// Valid is set when Untracked is valid for the directory with the
+	// given stat data
+	Valid bool
+	// CheckOnly is the "check-only" bit of read_directory_recursive() in git
+	CheckOnly bool
+	// Stat is the stat data of the directory, when Valid
+	Stat StatData
+	// ExcludeHash is the hash of the per directory exclude file, if any
+	ExcludeHash plumbing.Hash
+}
+
+// StatData is the stat data of a file or directory, as stored in the index
+// for an entry, without the mode.
+type StatData struct {
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+	Dev, Inode uint32
+	UID, GID   uint32
+	Size       uint32
+}
+
+// FSMonitor is the File System Monitor cache extension (FSMN), it records the
+// token of the file system monitor when the entries with FSMonitorValid set
+// were found to match the worktree. Only the paths reported as changed by the
+// monitor since need to be checked again.
+type FSMonitor struct {
+	// Token is the opaque token of the file system monitor, or the time in
+	// nanoseconds since the Unix epoch for the version 1 of the extension
+	Token string
+}
+
 // SkipUnless applies patterns in the form of A, A/B, A/B/C
 // to the index to prevent the files from being checked out
 func (i *Index) SkipUnless(patterns []string) {
@@ -1,7 +1,15 @@
 package index
 
 import (
+	"bytes"
+	encbin "encoding/binary"
+	"fmt"
 	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 
 	. "gopkg.in/check.v1"
 )
@@ -74,3 +82,61 @@ func (s *IndexSuite) TestIndexGlob(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(m, HasLen, 1)
 }
+
+// syntheticIndex returns an index with n entries, spread in directories as
+// the ones of a large repository.
+func syntheticIndex(version uint32, n int) *Index {
+	idx := &Index{Version: version}
+	now := time.Unix(1600000000, 42)
+	for i := 0; i < n; i++ {
+		var h plumbing.Hash
+		encbin.BigEndian.PutUint32(h[:], uint32(i))
+		idx.Entries = append(idx.Entries, &Entry{
+			Hash:       h,
+			Name:       fmt.Sprintf("src/module%03d/package%02d/file%05d.go", i/4000, i/100%40, i),
+			CreatedAt:  now,
+			ModifiedAt: now,
+			Mode:       filemode.Regular,
+			Size:       uint32(i),
+			Inode:      uint32(i),
+		})
+	}
+
+	return idx
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, version := range []uint32{2, 4} {
+		idx := syntheticIndex(version, 400000)
+		b.Run(fmt.Sprintf("v%d", version), func(b *testing.B) {
+			var buf bytes.Buffer
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := NewEncoder(&buf).Encode(idx); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.SetBytes(int64(buf.Len()))
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, version := range []uint32{2, 4} {
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(syntheticIndex(version, 400000)); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("v%d", version), func(b *testing.B) {
+			b.SetBytes(int64(buf.Len()))
+			for i := 0; i < b.N; i++ {
+				idx := &Index{}
+				if err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(idx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Package mailmap implements the decoding of the .mailmap files, which map
+// the names and emails of authors and committers to their canonical ones.
+//
+// Each line of a mailmap file takes one of the forms:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+//
+// The text after a '#' starting a line, or following the emails, is a
+// comment.
+package mailmap
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Mailmap maps the names and emails of authors and committers to their
+// canonical ones. The zero value is an empty mailmap, mapping nothing.
+type Mailmap struct {
+	entries map[string]*entry
+}
+
+// entry is the mapping of an email, and the mappings of the email along with
+// one of the names, by lower case name.
+type entry struct {
+	mapping
+	names map[string]*mapping
+}
+
+// mapping is the proper name and email, any of them being empty if it is
+// not mapped.
+type mapping struct {
+	name  string
+	email string
+}
+
+// New returns an empty mailmap.
+func New() *Mailmap {
+	return &Mailmap{}
+}
+
+// Decode reads the entries of a mailmap file into the mailmap. The entries
+// read last take precedence over the ones read before, so several files are
+// read in increasing order of priority.
+func (m *Mailmap) Decode(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		m.decodeLine(s.Text())
+	}
+
+	return s.Err()
+}
+
+func (m *Mailmap) decodeLine(line string) {
+	if strings.HasPrefix(line, "#") {
+		return
+	}
+
+	name, email, rest, ok := parseNameAndEmail(line)
+	if !ok {
+		return
+	}
+
+	oldName, oldEmail, _, ok := parseNameAndEmail(rest)
+	if !ok {
+		m.add(name, "", "", email)
+		return
+	}
+
+	m.add(name, email, oldName, oldEmail)
+}
+
+// parseNameAndEmail parses a name followed by an email between angle
+// brackets, returning the text after the email.
+func parseNameAndEmail(s string) (name, email, rest string, ok bool) {
+	left := strings.IndexByte(s, '<')
+	if left == -1 {
+		return "", "", "", false
+	}
+
+	right := strings.IndexByte(s[left+1:], '>')
+	if right == -1 {
+		return "", "", "", false
+	}
+
+	right += left + 1
+	return strings.TrimSpace(s[:left]), s[left+1 : right], s[right+1:], true
+}
+
+func (m *Mailmap) add(name, email, oldName, oldEmail string) {
+	if m.entries == nil {
+		m.entries = make(map[string]*entry)
+	}
+
+	key := strings.ToLower(oldEmail)
+	e, ok := m.entries[key]
+	if !ok {
+		e = &entry{}
+		m.entries[key] = e
+	}
+
+	mp := &e.mapping
+	if oldName != "" {
+		if e.names == nil {
+			e.names = make(map[string]*mapping)
+		}
+
+		nameKey := strings.ToLower(oldName)
+		if mp, ok = e.names[nameKey]; !ok {
+			mp = &mapping{}
+			e.names[nameKey] = mp
+		}
+	}
+
+	if name != "" {
+		mp.name = name
+	}
+
+	if email != "" {
+		mp.email = email
+	}
+}
+
+// Lookup returns the canonical name and email of the given ones, which are
+// returned unchanged if the mailmap doesn't map them. The emails and names
+// are matched case-insensitively, as git does.
+func (m *Mailmap) Lookup(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+
+	e, ok := m.entries[strings.ToLower(email)]
+	if !ok {
+		return name, email
+	}
+
+	mp := &e.mapping
+	if named, ok := e.names[strings.ToLower(name)]; ok {
+		mp = named
+	}
+
+	if mp.name != "" {
+		name = mp.name
+	}
+
+	if mp.email != "" {
+		email = mp.email
+	}
+
+	return name, email
+}
@@ -0,0 +1,73 @@
+package mailmap
+
+import (
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MailmapSuite struct{}
+
+var _ = Suite(&MailmapSuite{})
+
+const mailmap = `# comment
+Jane Doe <jane@example.com>
+<john@example.com> <john@old.example.com>
+Joe Smith <joe@example.com> <JOE@work.example.com>  # moved
+Alice <alice@example.com> Bob <shared@example.com>
+Shared <shared@example.com>
+broken <line
+Jane Doe <jane.doe@example.com>
+Jane Q. Doe <jane@example.com>
+`
+
+func (s *MailmapSuite) TestLookup(c *C) {
+	m := New()
+	c.Assert(m.Decode(strings.NewReader(mailmap)), IsNil)
+
+	// The expected values are the ones of git check-mailmap.
+	for _, t := range []struct {
+		name, email        string
+		properName, proper string
+	}{
+		{"jane", "jane@example.com", "Jane Q. Doe", "jane@example.com"},
+		{"X", "JANE@example.com", "Jane Q. Doe", "JANE@example.com"},
+		{"John", "john@old.example.com", "John", "john@example.com"},
+		{"j", "joe@WORK.example.com", "Joe Smith", "joe@example.com"},
+		{"bob", "shared@example.com", "Alice", "alice@example.com"},
+		{"Carol", "shared@example.com", "Shared", "shared@example.com"},
+		{"Nobody", "nobody@example.com", "Nobody", "nobody@example.com"},
+	} {
+		name, email := m.Lookup(t.name, t.email)
+		c.Assert(name, Equals, t.properName, Commentf("%s <%s>", t.name, t.email))
+		c.Assert(email, Equals, t.proper, Commentf("%s <%s>", t.name, t.email))
+	}
+}
+
+func (s *MailmapSuite) TestLookupEmpty(c *C) {
+	var m *Mailmap
+	name, email := m.Lookup("Jane", "jane@example.com")
+	c.Assert(name, Equals, "Jane")
+	c.Assert(email, Equals, "jane@example.com")
+
+	name, email = New().Lookup("Jane", "jane@example.com")
+	c.Assert(name, Equals, "Jane")
+	c.Assert(email, Equals, "jane@example.com")
+}
+
+func (s *MailmapSuite) TestDecodePriority(c *C) {
+	m := New()
+	c.Assert(m.Decode(strings.NewReader("Old <jane@example.com>\n")), IsNil)
+	c.Assert(m.Decode(strings.NewReader("<new@example.com> <jane@example.com>\n")), IsNil)
+
+	name, email := m.Lookup("jane", "jane@example.com")
+	c.Assert(name, Equals, "Old")
+	c.Assert(email, Equals, "new@example.com")
+
+	c.Assert(m.Decode(strings.NewReader("New <jane@example.com>\n")), IsNil)
+	name, _ = m.Lookup("jane", "jane@example.com")
+	c.Assert(name, Equals, "New")
+}
@@ -0,0 +1,175 @@
+package midx
+
+import (
+	"bytes"
+	"crypto"
+	encbin "encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/hash"
+)
+
+var (
+	// ErrUnsupportedVersion is returned by Decode when the multi-pack-index
+	// version is not supported.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	// ErrUnsupportedHash is returned by Decode when the hash function of the
+	// multi-pack-index is not the one in use.
+	ErrUnsupportedHash = errors.New("unsupported hash algorithm")
+	// ErrMalformedMultiPackIndex is returned by Decode when the
+	// multi-pack-index is corrupted.
+	ErrMalformedMultiPackIndex = errors.New("malformed multi-pack-index file")
+
+	midxHeader = []byte{'M', 'I', 'D', 'X'}
+)
+
+const (
+	szHeader      = 12
+	szChunkHeader = 12
+	lenFanout     = 256
+)
+
+var (
+	chunkPackNames    = [4]byte{'P', 'N', 'A', 'M'}
+	chunkOIDFanout    = [4]byte{'O', 'I', 'D', 'F'}
+	chunkOIDLookup    = [4]byte{'O', 'I', 'D', 'L'}
+	chunkObjectOffset = [4]byte{'O', 'O', 'F', 'F'}
+	chunkLargeOffsets = [4]byte{'L', 'O', 'F', 'F'}
+)
+
+// Decoder reads and decodes multi-pack-index files from an input stream.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder builds a new multi-pack-index stream decoder, that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r}
+}
+
+// Decode reads from the stream and decode the content into the MemoryIndex
+// struct. The whole file is read, as the chunks may come in any order, and
+// its checksum is verified.
+func (d *Decoder) Decode(idx *MemoryIndex) error {
+	data, err := io.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < szHeader+hash.Size || !bytes.Equal(data[:4], midxHeader) {
+		return ErrMalformedMultiPackIndex
+	}
+
+	content, checksum := data[:len(data)-hash.Size], data[len(data)-hash.Size:]
+	h := hash.New(hash.CryptoType)
+	h.Write(content)
+	if !bytes.Equal(h.Sum(nil), checksum) {
+		return ErrMalformedMultiPackIndex
+	}
+	copy(idx.Checksum[:], checksum)
+
+	if data[4] != VersionSupported {
+		return ErrUnsupportedVersion
+	}
+	idx.Version = uint32(data[4])
+
+	if !(hash.CryptoType == crypto.SHA1 && data[5] == 1) &&
+		!(hash.CryptoType == crypto.SHA256 && data[5] == 2) {
+		return ErrUnsupportedHash
+	}
+
+	if data[7] != 0 {
+		// Incremental multi-pack-index chains are not supported.
+		return ErrUnsupportedVersion
+	}
+
+	chunks, err := readChunks(content, int(data[6]))
+	if err != nil {
+		return err
+	}
+
+	packs := int(encbin.BigEndian.Uint32(data[8:12]))
+	for _, f := range []func(*MemoryIndex, map[[4]byte][]byte, int) error{
+		readPackNames,
+		readFanout,
+		readObjects,
+	} {
+		if err := f(idx, chunks, packs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readChunks returns the content of the chunks by id.
+func readChunks(data []byte, count int) (map[[4]byte][]byte, error) {
+	if len(data) < szHeader+(count+1)*szChunkHeader {
+		return nil, ErrMalformedMultiPackIndex
+	}
+
+	chunks := make(map[[4]byte][]byte, count)
+	for i := 0; i < count; i++ {
+		entry := data[szHeader+i*szChunkHeader:]
+		next := entry[szChunkHeader:]
+
+		var id [4]byte
+		copy(id[:], entry)
+		start := encbin.BigEndian.Uint64(entry[4:])
+		end := encbin.BigEndian.Uint64(next[4:])
+		if start > end || end > uint64(len(data)) {
+			return nil, ErrMalformedMultiPackIndex
+		}
+
+		chunks[id] = data[start:end]
+	}
+
+	return chunks, nil
+}
+
+func readPackNames(idx *MemoryIndex, chunks map[[4]byte][]byte, packs int) error {
+	names := strings.Split(string(chunks[chunkPackNames]), "\x00")
+	for _, n := range names {
+		// The chunk is padded with null bytes.
+		if n != "" {
+			idx.PackNames = append(idx.PackNames, n)
+		}
+	}
+
+	if len(idx.PackNames) != packs {
+		return ErrMalformedMultiPackIndex
+	}
+
+	return nil
+}
+
+func readFanout(idx *MemoryIndex, chunks map[[4]byte][]byte, _ int) error {
+	fanout := chunks[chunkOIDFanout]
+	if len(fanout) != lenFanout*4 {
+		return ErrMalformedMultiPackIndex
+	}
+
+	for i := range idx.Fanout {
+		idx.Fanout[i] = encbin.BigEndian.Uint32(fanout[i*4:])
+		if i > 0 && idx.Fanout[i] < idx.Fanout[i-1] {
+			return ErrMalformedMultiPackIndex
+		}
+	}
+
+	return nil
+}
+
+func readObjects(idx *MemoryIndex, chunks map[[4]byte][]byte, _ int) error {
+	count := idx.Count()
+	idx.Names = chunks[chunkOIDLookup]
+	idx.Offsets = chunks[chunkObjectOffset]
+	idx.LargeOffsets = chunks[chunkLargeOffsets]
+	if len(idx.Names) != count*hash.Size || len(idx.Offsets) != count*8 ||
+		len(idx.LargeOffsets)%8 != 0 {
+		return ErrMalformedMultiPackIndex
+	}
+
+	return nil
+}
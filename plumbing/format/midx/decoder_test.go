@@ -0,0 +1,191 @@
+package midx_test
+
+import (
+	"bytes"
+	encbin "encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/go-git/go-git/v5/internal/test"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	. "github.com/go-git/go-git/v5/plumbing/format/midx"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MidxSuite struct {
+	fixtures.Suite
+}
+
+var _ = Suite(&MidxSuite{})
+
+// testdata/multi-pack-index is written by `git multi-pack-index write` for
+// the basic fixture.
+func (s *MidxSuite) decodeTestdata(c *C) *MemoryIndex {
+	f, err := os.Open("testdata/multi-pack-index")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	idx := NewMemoryIndex()
+	c.Assert(NewDecoder(f).Decode(idx), IsNil)
+	return idx
+}
+
+func (s *MidxSuite) TestDecode(c *C) {
+	idx := s.decodeTestdata(c)
+	c.Assert(idx.Version, Equals, uint32(1))
+	c.Assert(idx.PackNames, DeepEquals, []string{"pack-a3fed42da1e8189a077c0e6846c040dcf73fc9dd.idx"})
+	c.Assert(idx.Count(), Equals, 31)
+
+	packIdx := idxfile.NewMemoryIndex()
+	c.Assert(idxfile.NewDecoder(fixtures.Basic().One().Idx()).Decode(packIdx), IsNil)
+
+	entries, err := packIdx.Entries()
+	c.Assert(err, IsNil)
+	defer entries.Close()
+	for {
+		e, err := entries.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+
+		c.Assert(idx.Contains(e.Hash), Equals, true)
+		pack, offset, err := idx.FindOffset(e.Hash)
+		c.Assert(err, IsNil)
+		c.Assert(pack, Equals, 0)
+		c.Assert(offset, Equals, int64(e.Offset))
+	}
+}
+
+func (s *MidxSuite) TestFindOffsetNotFound(c *C) {
+	idx := s.decodeTestdata(c)
+
+	h := plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488eb")
+	c.Assert(idx.Contains(h), Equals, false)
+	_, _, err := idx.FindOffset(h)
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *MidxSuite) TestHashesWithPrefix(c *C) {
+	idx := s.decodeTestdata(c)
+
+	hashes := idx.HashesWithPrefix([]byte{0x35})
+	c.Assert(hashes, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9"),
+	})
+
+	c.Assert(idx.HashesWithPrefix([]byte{0x35, 0x00}), HasLen, 0)
+	c.Assert(idx.HashesWithPrefix(nil), HasLen, 31)
+}
+
+func (s *MidxSuite) TestDecodeLargeOffsets(c *C) {
+	a := plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")
+	b := plumbing.NewHash("a3fed42da1e8189a077c0e6846c040dcf73fc9dd")
+	data := encodeMultiPackIndex([]string{"pack-1.idx", "pack-2.idx"}, []testEntry{
+		{a, 1, 12},
+		{b, 0, 1 << 33},
+	})
+
+	idx := NewMemoryIndex()
+	c.Assert(NewDecoder(bytes.NewReader(data)).Decode(idx), IsNil)
+	c.Assert(idx.PackNames, DeepEquals, []string{"pack-1.idx", "pack-2.idx"})
+
+	pack, offset, err := idx.FindOffset(a)
+	c.Assert(err, IsNil)
+	c.Assert(pack, Equals, 1)
+	c.Assert(offset, Equals, int64(12))
+
+	pack, offset, err = idx.FindOffset(b)
+	c.Assert(err, IsNil)
+	c.Assert(pack, Equals, 0)
+	c.Assert(offset, Equals, int64(1<<33))
+}
+
+func (s *MidxSuite) TestDecodeMalformed(c *C) {
+	data, err := os.ReadFile("testdata/multi-pack-index")
+	c.Assert(err, IsNil)
+
+	for _, corrupt := range []func([]byte) []byte{
+		func(b []byte) []byte { b[0] = 'X'; return b },
+		func(b []byte) []byte { b[100]++; return b },
+		func(b []byte) []byte { return b[:len(b)-1] },
+		func(b []byte) []byte { return b[:8] },
+	} {
+		b := corrupt(append([]byte(nil), data...))
+		err := NewDecoder(bytes.NewReader(b)).Decode(NewMemoryIndex())
+		c.Assert(err, Equals, ErrMalformedMultiPackIndex)
+	}
+}
+
+func (s *MidxSuite) TestDecodeUnsupportedVersion(c *C) {
+	data := encodeMultiPackIndex([]string{"pack-1.idx"}, nil)
+	data[4] = 2
+	data = test.Resum(data)
+
+	err := NewDecoder(bytes.NewReader(data)).Decode(NewMemoryIndex())
+	c.Assert(err, Equals, ErrUnsupportedVersion)
+}
+
+type testEntry struct {
+	hash   plumbing.Hash
+	pack   uint32
+	offset uint64
+}
+
+// encodeMultiPackIndex returns a multi-pack-index of the entries, sorted by
+// hash, writing the offsets over 2^31 in the large offsets chunk.
+func encodeMultiPackIndex(packs []string, entries []testEntry) []byte {
+	var names []byte
+	for _, p := range packs {
+		names = append(names, p...)
+		names = append(names, 0)
+	}
+	for len(names)%4 != 0 {
+		names = append(names, 0)
+	}
+
+	fanout := make([]byte, 256*4)
+	var oids, offsets, large []byte
+	for _, e := range entries {
+		for i := int(e.hash[0]); i < 256; i++ {
+			encbin.BigEndian.PutUint32(fanout[i*4:], encbin.BigEndian.Uint32(fanout[i*4:])+1)
+		}
+
+		oids = append(oids, e.hash[:]...)
+		offsets = encbin.BigEndian.AppendUint32(offsets, e.pack)
+		if e.offset < 1<<31 {
+			offsets = encbin.BigEndian.AppendUint32(offsets, uint32(e.offset))
+			continue
+		}
+
+		offsets = encbin.BigEndian.AppendUint32(offsets, uint32(len(large)/8)|0x80000000)
+		large = encbin.BigEndian.AppendUint64(large, e.offset)
+	}
+
+	ids := []string{"PNAM", "OIDF", "OIDL", "OOFF", "LOFF"}
+	chunks := [][]byte{names, fanout, oids, offsets, large}
+
+	data := []byte{'M', 'I', 'D', 'X', 1, 1, byte(len(chunks)), 0}
+	data = encbin.BigEndian.AppendUint32(data, uint32(len(packs)))
+	offset := uint64(len(data) + (len(chunks)+1)*12)
+	for i, chunk := range chunks {
+		data = append(data, ids[i]...)
+		data = encbin.BigEndian.AppendUint64(data, offset)
+		offset += uint64(len(chunk))
+	}
+	data = append(data, 0, 0, 0, 0)
+	data = encbin.BigEndian.AppendUint64(data, offset)
+
+	for _, chunk := range chunks {
+		data = append(data, chunk...)
+	}
+
+	return test.Resum(append(data, make([]byte, hash.Size)...))
+}
@@ -0,0 +1,48 @@
+// Package midx implements encoding and decoding of multi-pack-index files.
+//
+// A multi-pack-index, at objects/pack/multi-pack-index, indexes the objects
+// of several packfiles, so an object is located with a single lookup instead
+// of one per pack idx file. It has the following format:
+//
+//   - A 12-byte header:
+//
+//     4-byte signature "MIDX".
+//
+//     1-byte version number, 1.
+//
+//     1-byte object id version, 1 for SHA-1 and 2 for SHA-256.
+//
+//     1-byte number of chunks.
+//
+//     1-byte number of base multi-pack-index files, 0.
+//
+//     4-byte network byte order number of packfiles.
+//
+//   - The chunk lookup table, one 12-byte entry per chunk, each made of a
+//     4-byte chunk id and the 8-byte offset of the chunk in the file. It is
+//     terminated by an entry of id 0, with the offset of the end of the last
+//     chunk.
+//
+//   - The chunks:
+//
+//     "PNAM": the null-terminated names of the pack idx files, sorted. The
+//     packs are identified by their position in this list.
+//
+//     "OIDF": the 256 4-byte fan-out table of the object names, same as the
+//     one of the idx files.
+//
+//     "OIDL": the sorted object names.
+//
+//     "OOFF": for each object, the 4-byte id of the pack holding it and the
+//     4-byte offset of the object in the pack. If the most significant bit
+//     of the offset is set, the other bits are the position of the offset in
+//     the "LOFF" chunk instead.
+//
+//     "LOFF": the 8-byte offsets over 2^31, if any.
+//
+//     The other chunks, such as the reverse index, are ignored.
+//
+//   - A trailer, the checksum of all of the above.
+//
+// See https://github.com/git/git/blob/master/Documentation/gitformat-pack.txt
+package midx
@@ -0,0 +1,85 @@
+package midx
+
+import (
+	"bytes"
+	"crypto"
+	encbin "encoding/binary"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing/hash"
+)
+
+// Encoder writes MemoryIndex structs to an output stream.
+type Encoder struct {
+	io.Writer
+	hash hash.Hash
+}
+
+// NewEncoder returns a new stream encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	h := hash.New(hash.CryptoType)
+	mw := io.MultiWriter(w, h)
+	return &Encoder{mw, h}
+}
+
+// Encode encodes a MemoryIndex to the encoder writer, the chunks are written
+// in the order git writes them, the LOFF chunk only if there are large
+// offsets.
+func (e *Encoder) Encode(idx *MemoryIndex) error {
+	var names []byte
+	for _, n := range idx.PackNames {
+		names = append(names, n...)
+		names = append(names, 0)
+	}
+
+	// The chunk is padded to a multiple of 4 bytes.
+	for len(names)%4 != 0 {
+		names = append(names, 0)
+	}
+
+	fanout := make([]byte, lenFanout*4)
+	for i, f := range idx.Fanout {
+		encbin.BigEndian.PutUint32(fanout[i*4:], f)
+	}
+
+	ids := [][4]byte{chunkPackNames, chunkOIDFanout, chunkOIDLookup, chunkObjectOffset}
+	chunks := [][]byte{names, fanout, idx.Names, idx.Offsets}
+	if len(idx.LargeOffsets) > 0 {
+		ids = append(ids, chunkLargeOffsets)
+		chunks = append(chunks, idx.LargeOffsets)
+	}
+
+	hashID := byte(1)
+	if hash.CryptoType == crypto.SHA256 {
+		hashID = 2
+	}
+
+	var buf bytes.Buffer
+	buf.Write(midxHeader)
+	buf.Write([]byte{VersionSupported, hashID, byte(len(chunks)), 0})
+	_ = encbin.Write(&buf, encbin.BigEndian, uint32(len(idx.PackNames)))
+
+	offset := uint64(szHeader + (len(chunks)+1)*szChunkHeader)
+	for i, chunk := range chunks {
+		buf.Write(ids[i][:])
+		_ = encbin.Write(&buf, encbin.BigEndian, offset)
+		offset += uint64(len(chunk))
+	}
+
+	buf.Write([]byte{0, 0, 0, 0})
+	_ = encbin.Write(&buf, encbin.BigEndian, offset)
+
+	if _, err := e.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if _, err := e.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	copy(idx.Checksum[:], e.hash.Sum(nil))
+	_, err := e.Writer.Write(idx.Checksum[:])
+	return err
+}
@@ -0,0 +1,85 @@
+package midx_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	. "github.com/go-git/go-git/v5/plumbing/format/midx"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MidxSuite) TestEncode(c *C) {
+	packIdx := idxfile.NewMemoryIndex()
+	c.Assert(idxfile.NewDecoder(fixtures.Basic().One().Idx()).Decode(packIdx), IsNil)
+
+	idx, err := BuildMemoryIndex(
+		[]string{"pack-a3fed42da1e8189a077c0e6846c040dcf73fc9dd.idx"},
+		[]idxfile.Index{packIdx},
+	)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(idx), IsNil)
+
+	// The file is the same as the one written by git.
+	expected, err := os.ReadFile("testdata/multi-pack-index")
+	c.Assert(err, IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, expected)
+}
+
+func (s *MidxSuite) TestEncodeDecode(c *C) {
+	fs := fixtures.ByTag(".git").ByTag("multi-packfile").One().DotGit()
+	files, err := fs.ReadDir("objects/pack")
+	c.Assert(err, IsNil)
+
+	// The names are reversed, to check they are sorted.
+	var names []string
+	var indexes []idxfile.Index
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".idx") {
+			continue
+		}
+
+		f, err := fs.Open(fs.Join("objects/pack", fi.Name()))
+		c.Assert(err, IsNil)
+		packIdx := idxfile.NewMemoryIndex()
+		c.Assert(idxfile.NewDecoder(f).Decode(packIdx), IsNil)
+		c.Assert(f.Close(), IsNil)
+
+		names = append([]string{fi.Name()}, names...)
+		indexes = append([]idxfile.Index{packIdx}, indexes...)
+	}
+	c.Assert(indexes, HasLen, 2)
+
+	idx, err := BuildMemoryIndex(names, indexes)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(idx), IsNil)
+
+	decoded := NewMemoryIndex()
+	c.Assert(NewDecoder(&buf).Decode(decoded), IsNil)
+	c.Assert(decoded, DeepEquals, idx)
+	c.Assert(decoded.PackNames[0] < decoded.PackNames[1], Equals, true)
+
+	for i, packIdx := range indexes {
+		count, err := packIdx.Count()
+		c.Assert(err, IsNil)
+		c.Assert(count > 0, Equals, true)
+
+		entries, err := packIdx.Entries()
+		c.Assert(err, IsNil)
+		e, err := entries.Next()
+		c.Assert(err, IsNil)
+		c.Assert(entries.Close(), IsNil)
+
+		pack, offset, err := decoded.FindOffset(e.Hash)
+		c.Assert(err, IsNil)
+		c.Assert(decoded.PackNames[pack], Equals, names[i])
+		c.Assert(offset, Equals, int64(e.Offset))
+	}
+}
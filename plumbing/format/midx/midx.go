@@ -0,0 +1,203 @@
+package midx
+
+import (
+	"bytes"
+	encbin "encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+)
+
+const (
+	// VersionSupported is the only multi-pack-index version supported.
+	VersionSupported = 1
+
+	largeOffsetFlag = uint32(0x80000000)
+)
+
+// MemoryIndex is the in memory representation of a multi-pack-index file.
+type MemoryIndex struct {
+	Version uint32
+	// PackNames are the names of the pack idx files indexed, such as
+	// "pack-<hash>.idx".
+	PackNames []string
+	Fanout    [256]uint32
+	// Names are the object names, sorted, concatenated.
+	Names []byte
+	// Offsets are, for each object, the 4-byte id of its pack and its 4-byte
+	// offset, concatenated.
+	Offsets []byte
+	// LargeOffsets are the 8-byte offsets over 2^31, concatenated.
+	LargeOffsets []byte
+	Checksum     [hash.Size]byte
+}
+
+// NewMemoryIndex returns an instance of a new MemoryIndex.
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{}
+}
+
+// BuildMemoryIndex returns the multi-pack-index of the packfiles with the
+// given idx file names, such as "pack-<hash>.idx", and indexes. The objects
+// in several packfiles are located in the first one holding them, in the
+// order of the names.
+func BuildMemoryIndex(names []string, indexes []idxfile.Index) (*MemoryIndex, error) {
+	type object struct {
+		hash   plumbing.Hash
+		pack   uint32
+		offset uint64
+	}
+
+	// The packfiles are identified by their position in the sorted names.
+	order := make([]int, len(names))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return names[order[i]] < names[order[j]] })
+
+	positions := make([]uint32, len(names))
+	idx := NewMemoryIndex()
+	idx.Version = VersionSupported
+	for pos, i := range order {
+		positions[i] = uint32(pos)
+		idx.PackNames = append(idx.PackNames, names[i])
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+	var objects []object
+	for i, packIdx := range indexes {
+		iter, err := packIdx.Entries()
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			e, err := iter.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				_ = iter.Close()
+				return nil, err
+			}
+
+			if !seen[e.Hash] {
+				seen[e.Hash] = true
+				objects = append(objects, object{e.Hash, positions[i], e.Offset})
+			}
+		}
+
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return bytes.Compare(objects[i].hash[:], objects[j].hash[:]) < 0
+	})
+
+	for _, o := range objects {
+		idx.Fanout[o.hash[0]]++
+		idx.Names = append(idx.Names, o.hash[:]...)
+		idx.Offsets = encbin.BigEndian.AppendUint32(idx.Offsets, o.pack)
+		if o.offset < uint64(largeOffsetFlag) {
+			idx.Offsets = encbin.BigEndian.AppendUint32(idx.Offsets, uint32(o.offset))
+			continue
+		}
+
+		idx.Offsets = encbin.BigEndian.AppendUint32(idx.Offsets,
+			uint32(len(idx.LargeOffsets)/8)|largeOffsetFlag)
+		idx.LargeOffsets = encbin.BigEndian.AppendUint64(idx.LargeOffsets, o.offset)
+	}
+
+	for i := 1; i < len(idx.Fanout); i++ {
+		idx.Fanout[i] += idx.Fanout[i-1]
+	}
+
+	return idx, nil
+}
+
+// Count returns the number of objects in the index.
+func (idx *MemoryIndex) Count() int {
+	return int(idx.Fanout[0xff])
+}
+
+func (idx *MemoryIndex) name(i int) []byte {
+	return idx.Names[i*hash.Size : (i+1)*hash.Size]
+}
+
+func (idx *MemoryIndex) findHashIndex(h plumbing.Hash) (int, bool) {
+	var low int
+	if h[0] > 0 {
+		low = int(idx.Fanout[h[0]-1])
+	}
+	high := int(idx.Fanout[h[0]])
+
+	i := low + sort.Search(high-low, func(i int) bool {
+		return bytes.Compare(idx.name(low+i), h[:]) >= 0
+	})
+
+	return i, i < high && bytes.Equal(idx.name(i), h[:])
+}
+
+// Contains checks whether the given hash is in the index.
+func (idx *MemoryIndex) Contains(h plumbing.Hash) bool {
+	_, ok := idx.findHashIndex(h)
+	return ok
+}
+
+// FindOffset returns the position in PackNames of the pack holding the object
+// with the given hash, and its offset in the pack.
+func (idx *MemoryIndex) FindOffset(h plumbing.Hash) (pack int, offset int64, err error) {
+	i, ok := idx.findHashIndex(h)
+	if !ok {
+		return 0, 0, plumbing.ErrObjectNotFound
+	}
+
+	entry := idx.Offsets[i*8 : (i+1)*8]
+	pack = int(encbin.BigEndian.Uint32(entry[:4]))
+	if pack >= len(idx.PackNames) {
+		return 0, 0, ErrMalformedMultiPackIndex
+	}
+
+	o := encbin.BigEndian.Uint32(entry[4:])
+	if o&largeOffsetFlag == 0 {
+		return pack, int64(o), nil
+	}
+
+	l := int(o&^largeOffsetFlag) * 8
+	if l+8 > len(idx.LargeOffsets) {
+		return 0, 0, ErrMalformedMultiPackIndex
+	}
+
+	return pack, int64(encbin.BigEndian.Uint64(idx.LargeOffsets[l:])), nil
+}
+
+// HashesWithPrefix returns the hashes of the objects starting with prefix.
+func (idx *MemoryIndex) HashesWithPrefix(prefix []byte) []plumbing.Hash {
+	var low, high int
+	if len(prefix) == 0 {
+		high = idx.Count()
+	} else {
+		if prefix[0] > 0 {
+			low = int(idx.Fanout[prefix[0]-1])
+		}
+		high = int(idx.Fanout[prefix[0]])
+	}
+
+	i := low + sort.Search(high-low, func(i int) bool {
+		return bytes.Compare(idx.name(low+i), prefix) >= 0
+	})
+
+	var hashes []plumbing.Hash
+	for ; i < high && bytes.HasPrefix(idx.name(i), prefix); i++ {
+		var h plumbing.Hash
+		copy(h[:], idx.name(i))
+		hashes = append(hashes, h)
+	}
+
+	return hashes
+}
@@ -22,13 +22,14 @@ const (
 )
 
 // UpdateObjectStorage updates the storer with the objects in the given
-// packfile.
-func UpdateObjectStorage(s storer.Storer, packfile io.Reader) error {
+// packfile. The observers are notified by the parser of the packfile, see
+// WritePackfileToObjectStorage for the storers writing it directly.
+func UpdateObjectStorage(s storer.Storer, packfile io.Reader, ob ...Observer) error {
 	if pw, ok := s.(storer.PackfileWriter); ok {
-		return WritePackfileToObjectStorage(pw, packfile)
+		return WritePackfileToObjectStorage(pw, packfile, ob...)
 	}
 
-	p, err := NewParserWithStorage(NewScanner(packfile), s)
+	p, err := NewParserWithStorage(NewScanner(packfile), s, ob...)
 	if err != nil {
 		return err
 	}
@@ -37,17 +38,63 @@ func UpdateObjectStorage(s storer.Storer, packfile io.Reader) error {
 	return err
 }
 
+// UpdateObjectStorageKept updates the storer with the objects in the given
+// packfile, as UpdateObjectStorage does. If the storer is a
+// storer.PackfileKeeper, the packfile is written along with a .keep file
+// holding the given reason, until the returned function is called, once the
+// references pointing to its objects are updated.
+func UpdateObjectStorageKept(s storer.EncodedObjectStorer, packfile io.Reader, reason string, ob ...Observer) (unkeep func() error, err error) {
+	unkeep = func() error { return nil }
+	pk, ok := s.(storer.PackfileKeeper)
+	if !ok {
+		if pw, ok := s.(storer.PackfileWriter); ok {
+			return unkeep, WritePackfileToObjectStorage(pw, packfile, ob...)
+		}
+
+		p, err := NewParserWithStorage(NewScanner(packfile), s, ob...)
+		if err != nil {
+			return unkeep, err
+		}
+
+		_, err = p.Parse()
+		return unkeep, err
+	}
+
+	w, keep, err := pk.KeptPackfileWriter(reason)
+	if err != nil {
+		return unkeep, err
+	}
+
+	unkeep = keep
+
+	return unkeep, writePackfile(w, packfile, ob...)
+}
+
 // WritePackfileToObjectStorage writes all the packfile objects into the given
-// object storage.
+// object storage. The observers are notified if the packfile writer of the
+// storage parses the packfile as it is written, and accepts them with an
+// AddObserver(Observer) method, as the one of the filesystem storage does.
 func WritePackfileToObjectStorage(
 	sw storer.PackfileWriter,
 	packfile io.Reader,
+	ob ...Observer,
 ) (err error) {
 	w, err := sw.PackfileWriter()
 	if err != nil {
 		return err
 	}
 
+	return writePackfile(w, packfile, ob...)
+}
+
+// writePackfile writes the packfile to the given writer, closing it.
+func writePackfile(w io.WriteCloser, packfile io.Reader, ob ...Observer) (err error) {
+	if ow, ok := w.(interface{ AddObserver(Observer) }); ok {
+		for _, o := range ob {
+			ow.AddObserver(o)
+		}
+	}
+
 	defer ioutil.CheckClose(w, &err)
 
 	var n int64
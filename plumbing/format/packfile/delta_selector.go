@@ -22,10 +22,18 @@ var applyDelta = map[plumbing.ObjectType]bool{
 
 type deltaSelector struct {
 	storer storer.EncodedObjectStorer
+	// maxDepth is the maximum number of deltas based on deltas.
+	maxDepth int64
+	// threads is the number of goroutines walking the objects of each type.
+	threads int
+	// names are the path hints of the objects, see nameHash.
+	names map[plumbing.Hash]string
+	// bases are the objects not packed the objects can be deltified against.
+	bases []plumbing.Hash
 }
 
 func newDeltaSelector(s storer.EncodedObjectStorer) *deltaSelector {
-	return &deltaSelector{s}
+	return &deltaSelector{storer: s, maxDepth: maxDepth}
 }
 
 // ObjectsToPack creates a list of ObjectToPack from the hashes
@@ -46,12 +54,17 @@ func (dw *deltaSelector) ObjectsToPack(
 		return otp, nil
 	}
 
-	dw.sort(otp)
+	candidates, err := dw.withExternalBases(otp)
+	if err != nil {
+		return nil, err
+	}
+
+	dw.sort(candidates)
 
 	var objectGroups [][]*ObjectToPack
 	var prev *ObjectToPack
 	i := -1
-	for _, obj := range otp {
+	for _, obj := range candidates {
 		if prev == nil || prev.Type() != obj.Type() {
 			objectGroups = append(objectGroups, []*ObjectToPack{obj})
 			i++
@@ -63,17 +76,19 @@ func (dw *deltaSelector) ObjectsToPack(
 
 	var wg sync.WaitGroup
 	var once sync.Once
-	for _, objs := range objectGroups {
-		objs := objs
-		wg.Add(1)
-		go func() {
-			if walkErr := dw.walk(objs, packWindow); walkErr != nil {
-				once.Do(func() {
-					err = walkErr
-				})
-			}
-			wg.Done()
-		}()
+	for _, group := range objectGroups {
+		for _, objs := range dw.segments(group, packWindow) {
+			objs := objs
+			wg.Add(1)
+			go func() {
+				if walkErr := dw.walk(objs, packWindow); walkErr != nil {
+					once.Do(func() {
+						err = walkErr
+					})
+				}
+				wg.Done()
+			}()
+		}
 	}
 	wg.Wait()
 
@@ -81,9 +96,94 @@ func (dw *deltaSelector) ObjectsToPack(
 		return nil, err
 	}
 
+	otp = candidates[:0]
+	for _, o := range candidates {
+		if !o.external {
+			otp = append(otp, o)
+		}
+	}
+
 	return otp, nil
 }
 
+// withExternalBases returns the objects to pack along with the bases not
+// packed, which are only used as delta bases. The objects to pack get their
+// name hashes.
+func (dw *deltaSelector) withExternalBases(otp []*ObjectToPack) ([]*ObjectToPack, error) {
+	packed := make(map[plumbing.Hash]bool, len(otp))
+	for _, o := range otp {
+		o.nameHash = nameHash(dw.names[o.Hash()])
+		packed[o.Hash()] = true
+	}
+
+	candidates := otp
+	for _, h := range dw.bases {
+		if packed[h] {
+			continue
+		}
+
+		o, err := dw.encodedObject(h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !applyDelta[o.Type()] {
+			continue
+		}
+
+		packed[h] = true
+		base := newObjectToPack(o)
+		base.nameHash = nameHash(dw.names[h])
+		base.external = true
+		candidates = append(candidates, base)
+	}
+
+	return candidates, nil
+}
+
+// segments splits the objects of a type in up to dw.threads parts walked
+// concurrently. The objects at the start of a part can't be deltified
+// against the ones of the previous part, so the parts are kept large.
+func (dw *deltaSelector) segments(objs []*ObjectToPack, packWindow uint) [][]*ObjectToPack {
+	n := dw.threads
+	if minSize := 4 * int(packWindow); n > 1 && len(objs)/n < minSize {
+		n = len(objs) / minSize
+	}
+
+	if n <= 1 {
+		return [][]*ObjectToPack{objs}
+	}
+
+	size := (len(objs) + n - 1) / n
+	segments := make([][]*ObjectToPack, 0, n)
+	for len(objs) > size {
+		segments = append(segments, objs[:size])
+		objs = objs[size:]
+	}
+
+	return append(segments, objs)
+}
+
+// nameHash returns the hash of a path hint used by git, sorting the objects
+// by it places the versions of the same file together, and the files with
+// the same extension close to each other.
+func nameHash(name string) uint32 {
+	var h uint32
+	for _, c := range []byte(name) {
+		switch c {
+		case ' ', '\t', '\n', '\v', '\f', '\r':
+			continue
+		}
+
+		h = (h >> 2) + (uint32(c) << 24)
+	}
+
+	return h
+}
+
 func (dw *deltaSelector) objectsToPack(
 	hashes []plumbing.Hash,
 	packWindow uint,
@@ -178,6 +278,11 @@ func (dw *deltaSelector) fixAndBreakChainsOne(objectsToPack map[plumbing.Hash]*O
 		return err
 	}
 
+	if int64(base.Depth) >= dw.maxDepth {
+		// The reused delta would make the chain too long.
+		return dw.undeltify(otp)
+	}
+
 	otp.SetDelta(base, otp.Object)
 	return nil
 }
@@ -240,8 +345,8 @@ func (dw *deltaSelector) walk(
 
 		// If we already have a delta, we don't try to find a new one for this
 		// object. This happens when a delta is set to be reused from an existing
-		// packfile.
-		if target.IsDelta() {
+		// packfile. The objects not packed are only used as bases.
+		if target.IsDelta() || target.external {
 			continue
 		}
 
@@ -329,7 +434,7 @@ func (dw *deltaSelector) deltaSizeLimit(targetSize int64, baseDepth int,
 		// Evenly distribute delta size limits over allowed depth.
 		// If src is non-delta (depth = 0), delta <= 50% of original.
 		// If src is almost at limit (9/10), delta <= 10% of original.
-		return n * (maxDepth - int64(baseDepth)) / maxDepth
+		return n * (dw.maxDepth - int64(baseDepth)) / dw.maxDepth
 	}
 
 	// With a delta base chosen any new delta must be "better".
@@ -338,7 +443,7 @@ func (dw *deltaSelector) deltaSizeLimit(targetSize int64, baseDepth int,
 	n := targetSize
 
 	// If target depth is bigger than maxDepth, this delta is not suitable to be used.
-	if d >= maxDepth {
+	if d >= dw.maxDepth {
 		return 0
 	}
 
@@ -347,7 +452,7 @@ func (dw *deltaSelector) deltaSizeLimit(targetSize int64, baseDepth int,
 	//
 	// If src is near limit (depth=9/10) and base is whole (depth=0)
 	// a new delta dependent on src must be 1/10th the size.
-	return n * (maxDepth - int64(baseDepth)) / (maxDepth - d)
+	return n * (dw.maxDepth - int64(baseDepth)) / (dw.maxDepth - d)
 }
 
 type byTypeAndSize []*ObjectToPack
@@ -365,5 +470,14 @@ func (a byTypeAndSize) Less(i, j int) bool {
 		return true
 	}
 
+	if a[i].nameHash != a[j].nameHash {
+		return a[i].nameHash < a[j].nameHash
+	}
+
+	// The objects not packed come first, to be in the window of the others.
+	if a[i].external != a[j].external {
+		return a[i].external
+	}
+
 	return a[i].Size() > a[j].Size()
 }
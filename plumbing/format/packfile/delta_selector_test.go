@@ -38,6 +38,31 @@ func (s *DeltaSelectorSuite) TestSort(c *C) {
 	c.Assert(toSort, DeepEquals, expected)
 }
 
+func (s *DeltaSelectorSuite) TestSortByName(c *C) {
+	var o1 = newObjectToPack(newObject(plumbing.BlobObject, []byte("00000")))
+	var o2 = newObjectToPack(newObject(plumbing.BlobObject, []byte("0000")))
+	var o3 = newObjectToPack(newObject(plumbing.BlobObject, []byte("000")))
+	var o4 = newObjectToPack(newObject(plumbing.BlobObject, []byte("00")))
+	o1.nameHash = nameHash("a.txt")
+	o2.nameHash = nameHash("b.go")
+	o3.nameHash = nameHash("a.txt")
+	o4.nameHash = nameHash("b.go")
+	o4.external = true
+
+	toSort := []*ObjectToPack{o1, o2, o3, o4}
+	s.ds.sort(toSort)
+	expected := []*ObjectToPack{o4, o2, o1, o3}
+	c.Assert(toSort, DeepEquals, expected)
+}
+
+func (s *DeltaSelectorSuite) TestNameHash(c *C) {
+	c.Assert(nameHash(""), Equals, uint32(0))
+	c.Assert(nameHash("a b"), Equals, nameHash("ab"))
+	// The hash mostly depends on the last characters.
+	c.Assert(nameHash("dir/a.txt")>>16, Equals, nameHash("other/a.txt")>>16)
+	c.Assert(nameHash("a.txt"), Not(Equals), nameHash("a.go"))
+}
+
 type testObject struct {
 	id     string
 	object plumbing.EncodedObject
@@ -234,3 +259,56 @@ func (s *DeltaSelectorSuite) TestMaxDepth(c *C) {
 	dsl := s.ds.deltaSizeLimit(0, 0, int(maxDepth), true)
 	c.Assert(dsl, Equals, int64(0))
 }
+
+func (s *DeltaSelectorSuite) TestMaxDepthSet(c *C) {
+	s.ds.maxDepth = 1
+	hashes := []plumbing.Hash{
+		s.hashes["o1"],
+		s.hashes["o2"],
+		s.hashes["o3"],
+	}
+	otp, err := s.ds.ObjectsToPack(hashes, 10)
+	c.Assert(err, IsNil)
+	c.Assert(len(otp), Equals, 3)
+	for _, o := range otp {
+		c.Assert(o.Depth <= 1, Equals, true)
+	}
+
+	dsl := s.ds.deltaSizeLimit(0, 0, 1, true)
+	c.Assert(dsl, Equals, int64(0))
+}
+
+func (s *DeltaSelectorSuite) TestObjectsToPackExternalBases(c *C) {
+	s.ds.bases = []plumbing.Hash{s.hashes["base"], s.hashes["treeType"], plumbing.ZeroHash}
+	otp, err := s.ds.ObjectsToPack([]plumbing.Hash{s.hashes["target"]}, 10)
+	c.Assert(err, IsNil)
+	c.Assert(otp, HasLen, 1)
+	c.Assert(otp[0].Original, Equals, s.store.Objects[s.hashes["target"]])
+	c.Assert(otp[0].IsDelta(), Equals, true)
+	c.Assert(otp[0].Base.external, Equals, true)
+	c.Assert(otp[0].Base.Hash(), Equals, s.hashes["base"])
+
+	// The packed objects are not used as external bases.
+	s.ds.bases = []plumbing.Hash{s.hashes["base"]}
+	otp, err = s.ds.ObjectsToPack([]plumbing.Hash{s.hashes["base"], s.hashes["target"]}, 10)
+	c.Assert(err, IsNil)
+	c.Assert(otp, HasLen, 2)
+	for _, o := range otp {
+		c.Assert(o.external, Equals, false)
+	}
+}
+
+func (s *DeltaSelectorSuite) TestSegments(c *C) {
+	objs := make([]*ObjectToPack, 100)
+	c.Assert(s.ds.segments(objs, 10), HasLen, 1)
+
+	s.ds.threads = 4
+	c.Assert(s.ds.segments(objs, 10), HasLen, 2)
+	segments := s.ds.segments(objs, 5)
+	c.Assert(segments, HasLen, 4)
+	for _, seg := range segments {
+		c.Assert(seg, HasLen, 25)
+	}
+
+	c.Assert(s.ds.segments(objs[:10], 10), HasLen, 1)
+}
@@ -21,6 +21,7 @@ type Encoder struct {
 	hasher   plumbing.Hasher
 
 	useRefDeltas bool
+	progress     func(written, total int, size int64)
 }
 
 // NewEncoder creates a new packfile encoder using a specific Writer and
@@ -42,6 +43,42 @@ func NewEncoder(w io.Writer, s storer.EncodedObjectStorer, useRefDeltas bool) *E
 	}
 }
 
+// SetMaxDeltaDepth sets the maximum number of deltas based on deltas of the
+// packfile, 50 by default. The deltas of the storage making longer chains are
+// not reused.
+func (e *Encoder) SetMaxDeltaDepth(depth int) {
+	if depth > 0 {
+		e.selector.maxDepth = int64(depth)
+	}
+}
+
+// SetThreads sets the number of goroutines searching for deltas among the
+// objects of each type, one by default.
+func (e *Encoder) SetThreads(n int) {
+	e.selector.threads = n
+}
+
+// SetObjectNames sets the paths of the objects, the objects with the same
+// path, likely to be good delta bases of each other, are compared first.
+func (e *Encoder) SetObjectNames(names map[plumbing.Hash]string) {
+	e.selector.names = names
+}
+
+// SetThinPackBases sets the objects, not written to the packfile, the objects
+// can be deltified against. The packfile is then thin, only readable by a
+// receiver having these objects, and the deltas against them are always
+// reference deltas.
+func (e *Encoder) SetThinPackBases(bases []plumbing.Hash) {
+	e.selector.bases = bases
+}
+
+// SetProgress sets the function called after each object is written, with
+// the number of objects written, their total and the size of the packfile
+// written so far.
+func (e *Encoder) SetProgress(f func(written, total int, size int64)) {
+	e.progress = f
+}
+
 // Encode creates a packfile containing all the objects referenced in
 // hashes and writes it to the writer in the Encoder.  `packWindow`
 // specifies the size of the sliding window used to compare objects
@@ -63,10 +100,15 @@ func (e *Encoder) encode(objects []*ObjectToPack) (plumbing.Hash, error) {
 		return plumbing.ZeroHash, err
 	}
 
-	for _, o := range objects {
+	for i, o := range objects {
 		if err := e.entry(o); err != nil {
 			return plumbing.ZeroHash, err
 		}
+
+		// The bases written before their deltas are counted when reached.
+		if e.progress != nil {
+			e.progress(i+1, len(objects), e.w.Offset())
+		}
 	}
 
 	return e.footer()
@@ -135,7 +177,7 @@ func (e *Encoder) entry(o *ObjectToPack) (err error) {
 }
 
 func (e *Encoder) writeBaseIfDelta(o *ObjectToPack) error {
-	if o.IsDelta() && !o.Base.IsWritten() {
+	if o.IsDelta() && !o.Base.IsWritten() && !o.Base.external {
 		// We must write base first
 		return e.entry(o.Base)
 	}
@@ -144,9 +186,11 @@ func (e *Encoder) writeBaseIfDelta(o *ObjectToPack) error {
 }
 
 func (e *Encoder) writeDeltaHeader(o *ObjectToPack) error {
-	// Write offset deltas by default
+	// Write offset deltas by default, the bases not in the packfile have no
+	// offset.
+	useRefDeltas := e.useRefDeltas || o.Base.external
 	t := plumbing.OFSDeltaObject
-	if e.useRefDeltas {
+	if useRefDeltas {
 		t = plumbing.REFDeltaObject
 	}
 
@@ -154,7 +198,7 @@ func (e *Encoder) writeDeltaHeader(o *ObjectToPack) error {
 		return err
 	}
 
-	if e.useRefDeltas {
+	if useRefDeltas {
 		return e.writeRefDeltaHeader(o.Base.Hash())
 	} else {
 		return e.writeOfsDeltaHeader(o)
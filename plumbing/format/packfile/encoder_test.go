@@ -72,6 +72,30 @@ func (s *EncoderSuite) TestCorrectPackWithOneEmptyObject(c *C) {
 	c.Assert(result, DeepEquals, expectedResult)
 }
 
+func (s *EncoderSuite) TestProgress(c *C) {
+	var hashes []plumbing.Hash
+	for i := 0; i < 3; i++ {
+		o := newObject(plumbing.BlobObject, bytes.Repeat([]byte{byte('a' + i)}, 100))
+		h, err := s.store.SetEncodedObject(o)
+		c.Assert(err, IsNil)
+		hashes = append(hashes, h)
+	}
+
+	var written []int
+	var size int64
+	s.enc.SetProgress(func(n, total int, bytes int64) {
+		c.Assert(total, Equals, 3)
+		c.Assert(bytes > size, Equals, true)
+		written = append(written, n)
+		size = bytes
+	})
+
+	_, err := s.enc.Encode(hashes, 10)
+	c.Assert(err, IsNil)
+	c.Assert(written, DeepEquals, []int{1, 2, 3})
+	c.Assert(size <= int64(s.buf.Len()), Equals, true)
+}
+
 func (s *EncoderSuite) TestMaxObjectSize(c *C) {
 	o := s.store.NewEncodedObject()
 	o.SetSize(9223372036854775807)
@@ -120,6 +144,38 @@ func (s *EncoderSuite) TestDecodeEncodeWithCycleOFS(c *C) {
 	s.deltaOverDeltaCyclicTest(c)
 }
 
+func (s *EncoderSuite) TestEncodeThinPack(c *C) {
+	base := newObject(plumbing.BlobObject, bytes.Repeat([]byte("0123456789"), 100))
+	target := newObject(plumbing.BlobObject, append(bytes.Repeat([]byte("0123456789"), 100), 'a'))
+	_, err := s.store.SetEncodedObject(base)
+	c.Assert(err, IsNil)
+	_, err = s.store.SetEncodedObject(target)
+	c.Assert(err, IsNil)
+
+	s.enc.SetThinPackBases([]plumbing.Hash{base.Hash()})
+	_, err = s.enc.Encode([]plumbing.Hash{target.Hash()}, 10)
+	c.Assert(err, IsNil)
+
+	// Only the target is written, as a reference delta.
+	scanner := NewScanner(bytes.NewReader(s.buf.Bytes()))
+	_, count, err := scanner.Header()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint32(1))
+	oh, err := scanner.NextObjectHeader()
+	c.Assert(err, IsNil)
+	c.Assert(oh.Type, Equals, plumbing.REFDeltaObject)
+	c.Assert(oh.Reference, Equals, base.Hash())
+
+	// It is readable having the base.
+	sto := memory.NewStorage()
+	_, err = sto.SetEncodedObject(base)
+	c.Assert(err, IsNil)
+	c.Assert(UpdateObjectStorage(sto, bytes.NewReader(s.buf.Bytes())), IsNil)
+	decTarget, err := sto.EncodedObject(plumbing.AnyObject, target.Hash())
+	c.Assert(err, IsNil)
+	objectsEqual(c, decTarget, target)
+}
+
 func (s *EncoderSuite) simpleDeltaTest(c *C) {
 	srcObject := newObject(plumbing.BlobObject, []byte("0"))
 	targetObject := newObject(plumbing.BlobObject, []byte("01"))
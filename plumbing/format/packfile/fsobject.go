@@ -82,11 +82,11 @@ func (o *FSObject) Reader() (io.ReadCloser, error) {
 	}
 	r, err := p.getObjectContent(o.offset)
 	if err != nil {
-		_ = f.Close()
+		_ = p.Close()
 		return nil, err
 	}
 
-	if err := f.Close(); err != nil {
+	if err := p.Close(); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,400 @@
+package packfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+var (
+	// ErrInvalidChecksum is returned when the trailer of a packfile doesn't
+	// match its content, or the checksum stored in its idx file.
+	ErrInvalidChecksum = errors.New("invalid packfile checksum")
+	// ErrThinPackfile is returned by Index when the packfile is thin and
+	// there is no writer to complete it.
+	ErrThinPackfile = errors.New("thin packfile must be completed to be indexed")
+	// ErrCorruptedObject is returned by Verify when an object of the
+	// packfile doesn't match its idx file.
+	ErrCorruptedObject = errors.New("corrupted object")
+)
+
+// packfileHeaderSize is the size of the signature, the version and the
+// number of objects of a packfile.
+const packfileHeaderSize = 12
+
+// IndexOptions describes how a packfile is indexed.
+type IndexOptions struct {
+	// Storer holds the external bases of a thin packfile. They are appended
+	// to the packfile written to Pack, as git index-pack --fix-thin does.
+	Storer storer.EncodedObjectStorer
+	// Pack, if set, receives the indexed packfile, completed with its
+	// external bases if it's thin. It's required to index a thin packfile.
+	Pack io.Writer
+	// TempFS is the filesystem where the packfile is written while being
+	// indexed when it isn't read from an io.ReadSeeker, the temporary
+	// directory of the OS by default.
+	TempFS billy.Filesystem
+	// Observers are notified while the packfile is parsed, a
+	// ProgressObserver reports the progress of the indexing.
+	Observers []Observer
+}
+
+// Index writes to w the idx file, version 2, of the packfile read from r, as
+// git index-pack does, and returns the checksum of the indexed packfile. The
+// packfile is read several times, if r isn't an io.ReadSeeker it's first
+// written to a temporary file.
+//
+// A thin packfile is indexed once completed with its external bases, read
+// from IndexOptions.Storer, and written to IndexOptions.Pack. Otherwise
+// ErrReferenceDeltaNotFound or ErrThinPackfile is returned.
+func Index(r io.Reader, w io.Writer, o *IndexOptions) (h plumbing.Hash, err error) {
+	if o == nil {
+		o = &IndexOptions{}
+	}
+
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		var cleanup func() error
+		rs, cleanup, err = tempPackfile(o.TempFS, r)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		defer func() {
+			if cerr := cleanup(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	end, checksum, err := readTrailer(rs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	p, err := NewParser(NewScanner(rs), o.Observers...)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	p.bases = o.Storer
+	if _, err := p.Parse(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	// The external bases still referenced once the deltas are resolved
+	// weren't found in the packfile.
+	var bases []plumbing.Hash
+	for h, oi := range p.oiByHash {
+		if oi.ExternalRef {
+			bases = append(bases, h)
+		}
+	}
+
+	if len(bases) > 0 && o.Pack == nil {
+		return plumbing.ZeroHash, ErrThinPackfile
+	}
+
+	sort.Slice(bases, func(i, j int) bool {
+		return bytes.Compare(bases[i][:], bases[j][:]) < 0
+	})
+
+	iw := new(idxfile.Writer)
+	if err := iw.OnHeader(p.count + uint32(len(bases))); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	for _, oi := range p.oi {
+		iw.Add(oi.SHA1, uint64(oi.Offset), oi.Crc32)
+	}
+
+	switch {
+	case len(bases) > 0:
+		checksum, err = fixThinPackfile(o.Pack, rs, end, p.count, bases, o.Storer, iw)
+	case o.Pack != nil:
+		err = copyPackfile(o.Pack, rs, end+hash.Size)
+	}
+
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := iw.OnFooter(checksum); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	idx, err := iw.Index()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := idxfile.NewEncoder(w).Encode(idx); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return checksum, nil
+}
+
+// fixThinPackfile writes to w the packfile read from r, ending at end, with
+// the given external bases appended and added to iw, and returns its
+// checksum.
+func fixThinPackfile(
+	w io.Writer,
+	r io.ReadSeeker,
+	end int64,
+	count uint32,
+	bases []plumbing.Hash,
+	s storer.EncodedObjectStorer,
+	iw *idxfile.Writer,
+) (plumbing.Hash, error) {
+	crc := crc32.NewIEEE()
+	e := NewEncoder(io.MultiWriter(w, crc), s, false)
+	if err := e.head(int(count) + len(bases)); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := r.Seek(packfileHeaderSize, io.SeekStart); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := io.CopyN(e.w, r, end-packfileHeaderSize); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	for _, h := range bases {
+		obj, err := s.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		crc.Reset()
+		offset := e.w.Offset()
+		if err := e.entry(newObjectToPack(obj)); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		iw.Add(h, uint64(offset), crc.Sum32())
+	}
+
+	return e.footer()
+}
+
+func copyPackfile(w io.Writer, r io.ReadSeeker, size int64) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err := io.CopyN(w, r, size)
+	return err
+}
+
+// tempPackfile writes the packfile read from r to a temporary file of fs.
+// The returned function closes and removes it.
+func tempPackfile(fs billy.Filesystem, r io.Reader) (billy.File, func() error, error) {
+	if fs == nil {
+		fs = osfs.New(os.TempDir())
+	}
+
+	f, err := fs.TempFile("", "go-git-index-pack-")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func() error {
+		err := f.Close()
+		if rerr := fs.Remove(f.Name()); err == nil {
+			err = rerr
+		}
+
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		_ = cleanup()
+		return nil, nil, err
+	}
+
+	return f, cleanup, nil
+}
+
+// readTrailer checks the trailer of the packfile read from r, and returns
+// its offset and the checksum. r is left at the start of the packfile.
+func readTrailer(r io.ReadSeeker) (int64, plumbing.Hash, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, plumbing.ZeroHash, err
+	}
+
+	end := size - hash.Size
+	if end < packfileHeaderSize {
+		return 0, plumbing.ZeroHash, ErrEmptyPackfile
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, plumbing.ZeroHash, err
+	}
+
+	hasher := hash.New(hash.CryptoType)
+	if _, err := io.CopyN(hasher, r, end); err != nil {
+		return 0, plumbing.ZeroHash, err
+	}
+
+	var computed, checksum plumbing.Hash
+	copy(computed[:], hasher.Sum(nil))
+	if _, err := io.ReadFull(r, checksum[:]); err != nil {
+		return 0, plumbing.ZeroHash, err
+	}
+
+	if computed != checksum {
+		return 0, plumbing.ZeroHash, fmt.Errorf("%w: trailer %s, computed %s",
+			ErrInvalidChecksum, checksum, computed)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, plumbing.ZeroHash, err
+	}
+
+	return end, checksum, nil
+}
+
+// VerifyOptions describes how a packfile is verified.
+type VerifyOptions struct {
+	// OnObject is called with each object once verified, in the order of
+	// the packfile. Returning an error stops the verification.
+	OnObject func(*VerifiedObject) error
+	// Observers are notified while the packfile is parsed, a
+	// ProgressObserver reports the progress of the verification.
+	Observers []Observer
+}
+
+// VerifiedObject is an object of a verified packfile, as listed by git
+// verify-pack -v.
+type VerifiedObject struct {
+	Hash plumbing.Hash
+	Type plumbing.ObjectType
+	// Size is the size of the object, PackedSize the size of its entry in
+	// the packfile.
+	Size       int64
+	PackedSize int64
+	Offset     int64
+	CRC32      uint32
+	// Depth is the length of the delta chain of a delta, Base the object
+	// it's a delta of. Both are zero if it's not a delta.
+	Depth int
+	Base  plumbing.Hash
+}
+
+// Verify checks the packfile read from pack against its idx file, as git
+// verify-pack does: the trailer of the packfile, and the offset and CRC32 of
+// each object. ErrInvalidChecksum or ErrCorruptedObject is returned on the
+// first mismatch.
+func Verify(pack io.ReadSeeker, idx idxfile.Index, o *VerifyOptions) error {
+	if o == nil {
+		o = &VerifyOptions{}
+	}
+
+	end, checksum, err := readTrailer(pack)
+	if err != nil {
+		return err
+	}
+
+	if m, ok := idx.(*idxfile.MemoryIndex); ok && plumbing.Hash(m.PackfileChecksum) != checksum {
+		return fmt.Errorf("%w: trailer %s, index %s",
+			ErrInvalidChecksum, checksum, plumbing.Hash(m.PackfileChecksum))
+	}
+
+	p, err := NewParser(NewScanner(pack), o.Observers...)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.Parse(); err != nil {
+		return err
+	}
+
+	count, err := idx.Count()
+	if err != nil {
+		return err
+	}
+
+	if count != int64(len(p.oi)) {
+		return fmt.Errorf("%w: %d objects in the packfile, %d in the index",
+			ErrCorruptedObject, len(p.oi), count)
+	}
+
+	for i, oi := range p.oi {
+		if err := verifyObject(idx, oi); err != nil {
+			return err
+		}
+
+		if o.OnObject == nil {
+			continue
+		}
+
+		next := end
+		if i+1 < len(p.oi) {
+			next = p.oi[i+1].Offset
+		}
+
+		if err := o.OnObject(newVerifiedObject(oi, next-oi.Offset)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func verifyObject(idx idxfile.Index, oi *objectInfo) error {
+	offset, err := idx.FindOffset(oi.SHA1)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrCorruptedObject, oi.SHA1, err)
+	}
+
+	if offset != oi.Offset {
+		return fmt.Errorf("%w: %s: offset %d, index %d",
+			ErrCorruptedObject, oi.SHA1, oi.Offset, offset)
+	}
+
+	crc, err := idx.FindCRC32(oi.SHA1)
+	if err != nil {
+		return err
+	}
+
+	if crc != oi.Crc32 {
+		return fmt.Errorf("%w: %s: crc32 %08x, index %08x",
+			ErrCorruptedObject, oi.SHA1, oi.Crc32, crc)
+	}
+
+	return nil
+}
+
+func newVerifiedObject(oi *objectInfo, packedSize int64) *VerifiedObject {
+	vo := &VerifiedObject{
+		Hash:       oi.SHA1,
+		Type:       oi.Type,
+		Size:       oi.Length,
+		PackedSize: packedSize,
+		Offset:     oi.Offset,
+		CRC32:      oi.Crc32,
+	}
+
+	if oi.DiskType.IsDelta() {
+		vo.Base = oi.Parent.SHA1
+		for b := oi; b != nil && b.DiskType.IsDelta(); b = b.Parent {
+			vo.Depth++
+		}
+	}
+
+	return vo
+}
@@ -0,0 +1,213 @@
+package packfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/storage/memory"
+	. "gopkg.in/check.v1"
+)
+
+type IndexSuite struct {
+	fixtures.Suite
+}
+
+var _ = Suite(&IndexSuite{})
+
+func (s *IndexSuite) TestIndex(c *C) {
+	f := fixtures.Basic().One()
+
+	var idx bytes.Buffer
+	h, err := Index(f.Packfile(), &idx, nil)
+	c.Assert(err, IsNil)
+	c.Assert(h.String(), Equals, f.PackfileHash)
+
+	expected, err := io.ReadAll(f.Idx())
+	c.Assert(err, IsNil)
+	c.Assert(idx.Bytes(), DeepEquals, expected)
+}
+
+func (s *IndexSuite) TestIndexNotSeekable(c *C) {
+	f := fixtures.Basic().One()
+	pack, err := io.ReadAll(f.Packfile())
+	c.Assert(err, IsNil)
+
+	fs := memfs.New()
+	var idx, copied bytes.Buffer
+	h, err := Index(struct{ io.Reader }{bytes.NewReader(pack)}, &idx, &IndexOptions{
+		Pack:   &copied,
+		TempFS: fs,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(h.String(), Equals, f.PackfileHash)
+	c.Assert(copied.Bytes(), DeepEquals, pack)
+
+	expected, err := io.ReadAll(f.Idx())
+	c.Assert(err, IsNil)
+	c.Assert(idx.Bytes(), DeepEquals, expected)
+
+	// The temporary packfile is removed.
+	files, err := fs.ReadDir("")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+}
+
+func (s *IndexSuite) TestIndexThinPack(c *C) {
+	base := newObject(plumbing.BlobObject, bytes.Repeat([]byte("0123456789"), 100))
+	target := newObject(plumbing.BlobObject, append(bytes.Repeat([]byte("0123456789"), 100), 'a'))
+
+	sto := memory.NewStorage()
+	_, err := sto.SetEncodedObject(base)
+	c.Assert(err, IsNil)
+	_, err = sto.SetEncodedObject(target)
+	c.Assert(err, IsNil)
+
+	var thin bytes.Buffer
+	e := NewEncoder(&thin, sto, false)
+	e.SetThinPackBases([]plumbing.Hash{base.Hash()})
+	_, err = e.Encode([]plumbing.Hash{target.Hash()}, 10)
+	c.Assert(err, IsNil)
+
+	bases := memory.NewStorage()
+	_, err = bases.SetEncodedObject(base)
+	c.Assert(err, IsNil)
+
+	_, err = Index(bytes.NewReader(thin.Bytes()), io.Discard, nil)
+	c.Assert(err, Equals, ErrReferenceDeltaNotFound)
+
+	_, err = Index(bytes.NewReader(thin.Bytes()), io.Discard, &IndexOptions{Storer: bases})
+	c.Assert(err, Equals, ErrThinPackfile)
+
+	var pack, idxBuf bytes.Buffer
+	h, err := Index(bytes.NewReader(thin.Bytes()), &idxBuf, &IndexOptions{
+		Storer: bases,
+		Pack:   &pack,
+	})
+	c.Assert(err, IsNil)
+
+	idx := idxfile.NewMemoryIndex()
+	c.Assert(idxfile.NewDecoder(&idxBuf).Decode(idx), IsNil)
+	c.Assert(plumbing.Hash(idx.PackfileChecksum), Equals, h)
+
+	count, err := idx.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, int64(2))
+
+	// The completed packfile holds both objects and matches its index.
+	var objects []*VerifiedObject
+	err = Verify(bytes.NewReader(pack.Bytes()), idx, &VerifyOptions{
+		OnObject: func(o *VerifiedObject) error {
+			objects = append(objects, o)
+			return nil
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 2)
+	c.Assert(objects[0].Hash, Equals, target.Hash())
+	c.Assert(objects[0].Depth, Equals, 1)
+	c.Assert(objects[0].Base, Equals, base.Hash())
+	c.Assert(objects[1].Hash, Equals, base.Hash())
+	c.Assert(objects[1].Depth, Equals, 0)
+
+	p, err := NewParserWithStorage(NewScanner(bytes.NewReader(pack.Bytes())), memory.NewStorage())
+	c.Assert(err, IsNil)
+	_, err = p.Parse()
+	c.Assert(err, IsNil)
+}
+
+func (s *IndexSuite) TestVerify(c *C) {
+	f := fixtures.Basic().One()
+	pack, idx := s.readFixture(c, f)
+
+	var objects []*VerifiedObject
+	var resolved uint32
+	err := Verify(bytes.NewReader(pack), idx, &VerifyOptions{
+		OnObject: func(o *VerifiedObject) error {
+			objects = append(objects, o)
+			return nil
+		},
+		Observers: []Observer{&progressRecorder{deltas: &resolved}},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 31)
+	c.Assert(resolved > 0, Equals, true)
+
+	end := int64(len(pack) - 20)
+	var deltas int
+	for i, o := range objects {
+		next := end
+		if i+1 < len(objects) {
+			next = objects[i+1].Offset
+		}
+
+		c.Assert(o.PackedSize, Equals, next-o.Offset)
+
+		offset, err := idx.FindOffset(o.Hash)
+		c.Assert(err, IsNil)
+		c.Assert(o.Offset, Equals, offset)
+
+		if o.Depth > 0 {
+			deltas++
+			c.Assert(o.Base, Not(Equals), plumbing.ZeroHash)
+		}
+	}
+
+	c.Assert(uint32(deltas), Equals, resolved)
+}
+
+func (s *IndexSuite) TestVerifyInvalidChecksum(c *C) {
+	pack, idx := s.readFixture(c, fixtures.Basic().One())
+	pack[len(pack)-1] ^= 0xff
+
+	err := Verify(bytes.NewReader(pack), idx, nil)
+	c.Assert(errors.Is(err, ErrInvalidChecksum), Equals, true)
+
+	_, err = Index(bytes.NewReader(pack), io.Discard, nil)
+	c.Assert(errors.Is(err, ErrInvalidChecksum), Equals, true)
+}
+
+func (s *IndexSuite) TestVerifyCorruptedObject(c *C) {
+	pack, idx := s.readFixture(c, fixtures.Basic().One())
+	idx.CRC32[0][0] ^= 0xff
+
+	err := Verify(bytes.NewReader(pack), idx, nil)
+	c.Assert(errors.Is(err, ErrCorruptedObject), Equals, true)
+}
+
+func (s *IndexSuite) readFixture(c *C, f *fixtures.Fixture) ([]byte, *idxfile.MemoryIndex) {
+	pack, err := io.ReadAll(f.Packfile())
+	c.Assert(err, IsNil)
+
+	idx := idxfile.NewMemoryIndex()
+	c.Assert(idxfile.NewDecoder(f.Idx()).Decode(idx), IsNil)
+
+	return pack, idx
+}
+
+type progressRecorder struct {
+	deltas *uint32
+}
+
+func (r *progressRecorder) OnHeader(uint32) error { return nil }
+
+func (r *progressRecorder) OnInflatedObjectHeader(plumbing.ObjectType, int64, int64) error {
+	return nil
+}
+
+func (r *progressRecorder) OnInflatedObjectContent(plumbing.Hash, int64, uint32, []byte) error {
+	return nil
+}
+
+func (r *progressRecorder) OnFooter(plumbing.Hash) error { return nil }
+
+func (r *progressRecorder) OnObjectRead(uint32) error { return nil }
+
+func (r *progressRecorder) OnDeltaResolved(count, _ uint32) error {
+	*r.deltas = count
+	return nil
+}
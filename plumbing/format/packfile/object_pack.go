@@ -24,6 +24,12 @@ type ObjectToPack struct {
 	// has not been written yet
 	Offset int64
 
+	// nameHash is the hash of the path hint of the object, see nameHash.
+	nameHash uint32
+	// external is set for the delta bases not written to the pack, the
+	// deltas against them make a thin pack.
+	external bool
+
 	// Information from the original object
 	resolvedOriginal bool
 	originalType     plumbing.ObjectType
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	gosync "sync"
 
 	billy "github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -34,6 +35,15 @@ var (
 // wrapped in FSObject.
 const smallObjectThreshold = 16 * 1024
 
+// scannerPool holds the Scanners of the closed Packfiles. A Packfile is
+// created each time an object is read from a storage not keeping the file
+// descriptors open, reusing the Scanners avoids allocating their buffers.
+var scannerPool = gosync.Pool{
+	New: func() interface{} {
+		return NewScanner(nil)
+	},
+}
+
 // Packfile allows retrieving information from inside a packfile.
 type Packfile struct {
 	idxfile.Index
@@ -43,6 +53,11 @@ type Packfile struct {
 	deltaBaseCache       cache.Object
 	offsetToType         map[int64]plumbing.ObjectType
 	largeObjectThreshold int64
+
+	// iters is the number of iterators not closed yet, the Scanner is only
+	// put back in the pool once the Packfile and all of them are closed.
+	iters  int
+	closed bool
 }
 
 // NewPackfileWithCache creates a new Packfile with the given object cache.
@@ -55,15 +70,16 @@ func NewPackfileWithCache(
 	cache cache.Object,
 	largeObjectThreshold int64,
 ) *Packfile {
-	s := NewScanner(file)
+	s := scannerPool.Get().(*Scanner)
+	s.Reset(file)
 	return &Packfile{
-		index,
-		fs,
-		file,
-		s,
-		cache,
-		make(map[int64]plumbing.ObjectType),
-		largeObjectThreshold,
+		Index:                index,
+		fs:                   fs,
+		file:                 file,
+		s:                    s,
+		deltaBaseCache:       cache,
+		offsetToType:         make(map[int64]plumbing.ObjectType),
+		largeObjectThreshold: largeObjectThreshold,
 	}
 }
 
@@ -471,6 +487,11 @@ func (p *Packfile) cachePut(obj plumbing.EncodedObject) {
 		return
 	}
 
+	// The large objects would evict all the others from the cache.
+	if p.largeObjectThreshold > 0 && obj.Size() > p.largeObjectThreshold {
+		return
+	}
+
 	p.deltaBaseCache.Put(obj)
 }
 
@@ -494,6 +515,7 @@ func (p *Packfile) GetByType(typ plumbing.ObjectType) (storer.EncodedObjectIter,
 			return nil, err
 		}
 
+		p.iters++
 		return &objectIter{
 			// Easiest way to provide an object decoder is just to pass a Packfile
 			// instance. To not mess with the seeks, it's a new instance with a
@@ -532,8 +554,12 @@ func (p *Packfile) Scanner() *Scanner {
 	return p.s
 }
 
-// Close the packfile and its resources.
+// Close the packfile and its resources. Its Scanner is reused once the
+// iterators of the packfile are closed too.
 func (p *Packfile) Close() error {
+	p.closed = true
+	p.releaseScanner()
+
 	closer, ok := p.file.(io.Closer)
 	if !ok {
 		return nil
@@ -542,10 +568,23 @@ func (p *Packfile) Close() error {
 	return closer.Close()
 }
 
+// releaseScanner puts the Scanner back in the pool, if the packfile and its
+// iterators are closed.
+func (p *Packfile) releaseScanner() {
+	if !p.closed || p.iters > 0 || p.s == nil {
+		return
+	}
+
+	p.s.Reset(nil)
+	scannerPool.Put(p.s)
+	p.s = nil
+}
+
 type objectIter struct {
-	p    *Packfile
-	typ  plumbing.ObjectType
-	iter idxfile.EntryIter
+	p      *Packfile
+	typ    plumbing.ObjectType
+	iter   idxfile.EntryIter
+	closed bool
 }
 
 func (i *objectIter) Next() (plumbing.EncodedObject, error) {
@@ -620,6 +659,13 @@ func (i *objectIter) ForEach(f func(plumbing.EncodedObject) error) error {
 
 func (i *objectIter) Close() {
 	i.iter.Close()
+	if i.closed {
+		return
+	}
+
+	i.closed = true
+	i.p.iters--
+	i.p.releaseScanner()
 }
 
 // isInvalid checks whether an error is an os.PathError with an os.ErrInvalid
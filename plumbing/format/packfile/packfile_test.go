@@ -6,6 +6,7 @@ import (
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
 	. "gopkg.in/check.v1"
@@ -117,6 +118,25 @@ func (s *PackfileSuite) TearDownTest(c *C) {
 	c.Assert(s.p.Close(), IsNil)
 }
 
+func (s *PackfileSuite) TestGetLargeObjectNotCached(c *C) {
+	objects := cache.NewObjectLRUDefault()
+	p := packfile.NewPackfileWithCache(s.idx, nil, s.f.Packfile(), objects, 1024)
+	defer p.Close()
+
+	large := plumbing.NewHash("d5c0f4ab811897cadf03aec358ae60d21f91c50d")
+	obj, err := p.Get(large)
+	c.Assert(err, IsNil)
+	c.Assert(obj.Size(), Equals, int64(76110))
+	_, ok := objects.Get(large)
+	c.Assert(ok, Equals, false)
+
+	small := plumbing.NewHash("9dea2395f5403188298c1dabe8bdafe562c491e3")
+	_, err = p.Get(small)
+	c.Assert(err, IsNil)
+	_, ok = objects.Get(small)
+	c.Assert(ok, Equals, true)
+}
+
 func (s *PackfileSuite) TestDecode(c *C) {
 	fixtures.Basic().ByTag("packfile").Test(c, func(f *fixtures.Fixture) {
 		index := getIndexFromIdxFile(f.Idx())
@@ -184,6 +204,24 @@ func (s *PackfileSuite) TestDecodeByType(c *C) {
 	})
 }
 
+func (s *PackfileSuite) TestCloseWithOpenIterator(c *C) {
+	f := fixtures.Basic().ByTag("packfile").One()
+	index := getIndexFromIdxFile(f.Idx())
+
+	p := packfile.NewPackfile(index, fixtures.Filesystem, f.Packfile(), 0)
+	iter, err := p.GetByType(plumbing.CommitObject)
+	c.Assert(err, IsNil)
+
+	c.Assert(p.Close(), IsNil)
+	c.Assert(p.Scanner(), NotNil)
+
+	iter.Close()
+	c.Assert(p.Scanner(), IsNil)
+
+	iter.Close()
+	c.Assert(p.Scanner(), IsNil)
+}
+
 func (s *PackfileSuite) TestDecodeByTypeConstructor(c *C) {
 	f := fixtures.Basic().ByTag("packfile").One()
 	index := getIndexFromIdxFile(f.Idx())
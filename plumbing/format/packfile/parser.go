@@ -11,6 +11,8 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 	"github.com/go-git/go-git/v5/utils/sync"
+
+	"github.com/go-git/go-billy/v5"
 )
 
 var (
@@ -38,6 +40,18 @@ type Observer interface {
 	OnFooter(h plumbing.Hash) error
 }
 
+// ProgressObserver is an Observer also notified of the progress of the
+// parsing: the objects read from the packfile, then the deltas resolved.
+type ProgressObserver interface {
+	Observer
+	// OnObjectRead is called after each object is read from the packfile,
+	// with the number of objects read.
+	OnObjectRead(count uint32) error
+	// OnDeltaResolved is called after each delta is resolved, with the
+	// number of deltas resolved and their total.
+	OnDeltaResolved(count, total uint32) error
+}
+
 // Parser decodes a packfile and calls any observer associated to it. Is used
 // to generate indexes.
 type Parser struct {
@@ -53,6 +67,19 @@ type Parser struct {
 	// delta content by offset, only used if source is not seekable
 	deltas map[int64][]byte
 
+	largeObjectThreshold int64
+	tempFS               billy.Filesystem
+	tempDir              string
+
+	// bases holds the external bases of a thin packfile, read when the
+	// parser doesn't have a storage.
+	bases storer.EncodedObjectStorer
+
+	// deltaCount is the number of deltas of the packfile, resolvedCount the
+	// number of them resolved.
+	deltaCount    uint32
+	resolvedCount uint32
+
 	ob []Observer
 }
 
@@ -88,6 +115,16 @@ func NewParserWithStorage(
 	}, nil
 }
 
+// SetLargeObjectThreshold sets the size, in bytes, above which the delta bases
+// and the deltas aren't held in memory while resolving the deltas. They are
+// written to temporary files in dir of fs instead, and they aren't cached.
+// If threshold is 0, the default, all of them are held in memory.
+func (p *Parser) SetLargeObjectThreshold(threshold int64, fs billy.Filesystem, dir string) {
+	p.largeObjectThreshold = threshold
+	p.tempFS = fs
+	p.tempDir = dir
+}
+
 func (p *Parser) forEachObserver(f func(o Observer) error) error {
 	for _, o := range p.ob {
 		if err := f(o); err != nil {
@@ -124,6 +161,27 @@ func (p *Parser) onInflatedObjectContent(
 	})
 }
 
+func (p *Parser) onObjectRead(count uint32) error {
+	return p.forEachObserver(func(o Observer) error {
+		if po, ok := o.(ProgressObserver); ok {
+			return po.OnObjectRead(count)
+		}
+
+		return nil
+	})
+}
+
+func (p *Parser) onDeltaResolved() error {
+	p.resolvedCount++
+	return p.forEachObserver(func(o Observer) error {
+		if po, ok := o.(ProgressObserver); ok {
+			return po.OnDeltaResolved(p.resolvedCount, p.deltaCount)
+		}
+
+		return nil
+	})
+}
+
 func (p *Parser) onFooter(h plumbing.Hash) error {
 	return p.forEachObserver(func(o Observer) error {
 		return o.OnFooter(h)
@@ -309,49 +367,85 @@ func (p *Parser) indexObjects() error {
 
 		p.oiByOffset[oh.Offset] = ota
 		p.oi[i] = ota
+
+		if delta {
+			p.deltaCount++
+		}
+
+		if err := p.onObjectRead(i + 1); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (p *Parser) resolveDeltas() error {
-	buf := sync.GetBytesBuffer()
-	defer sync.PutBytesBuffer(buf)
-
 	for _, obj := range p.oi {
-		buf.Reset()
-		buf.Grow(int(obj.Length))
-		err := p.get(obj, buf)
-		if err != nil {
-			return err
-		}
+		// The objects without children were already hashed, either while
+		// being indexed or while resolving their base, their content isn't
+		// needed.
+		if len(obj.Children) == 0 && (!obj.DiskType.IsDelta() || obj.SHA1 != plumbing.ZeroHash) {
+			if err := p.onInflatedObject(obj); err != nil {
+				return err
+			}
 
-		if err := p.onInflatedObjectHeader(obj.Type, obj.Length, obj.Offset); err != nil {
-			return err
+			continue
 		}
 
-		if err := p.onInflatedObjectContent(obj.SHA1, obj.Offset, obj.Crc32, nil); err != nil {
+		if err := p.resolveDeltasOf(obj); err != nil {
 			return err
 		}
+	}
 
-		if !obj.IsDelta() && len(obj.Children) > 0 {
-			// Dealing with an io.ReaderAt object, means we can
-			// create it once and reuse across all children.
-			r := bytes.NewReader(buf.Bytes())
-			for _, child := range obj.Children {
-				// Even though we are discarding the output, we still need to read it to
-				// so that the scanner can advance to the next object, and the SHA1 can be
-				// calculated.
-				if err := p.resolveObject(io.Discard, child, r); err != nil {
-					return err
-				}
-				p.resolveExternalRef(child)
-			}
+	return nil
+}
+
+func (p *Parser) onInflatedObject(obj *objectInfo) error {
+	if err := p.onInflatedObjectHeader(obj.Type, obj.Length, obj.Offset); err != nil {
+		return err
+	}
+
+	if err := p.onInflatedObjectContent(obj.SHA1, obj.Offset, obj.Crc32, nil); err != nil {
+		return err
+	}
+
+	if obj.DiskType.IsDelta() {
+		return p.onDeltaResolved()
+	}
+
+	return nil
+}
+
+func (p *Parser) resolveDeltasOf(obj *objectInfo) (err error) {
+	buf := p.newSpillBuffer()
+	defer ioutil.CheckClose(buf, &err)
+
+	if err := p.get(obj, buf); err != nil {
+		return err
+	}
+
+	if err := p.onInflatedObject(obj); err != nil {
+		return err
+	}
 
-			// Remove the delta from the cache.
-			if obj.DiskType.IsDelta() && !p.scanner.IsSeekable {
-				delete(p.deltas, obj.Offset)
+	if !obj.IsDelta() && len(obj.Children) > 0 {
+		// Dealing with an io.ReaderAt object, means we can
+		// create it once and reuse across all children.
+		r := buf.ReaderAt()
+		for _, child := range obj.Children {
+			// Even though we are discarding the output, we still need to read it to
+			// so that the scanner can advance to the next object, and the SHA1 can be
+			// calculated.
+			if err := p.resolveObject(io.Discard, child, r); err != nil {
+				return err
 			}
+			p.resolveExternalRef(child)
+		}
+
+		// Remove the delta from the cache.
+		if obj.DiskType.IsDelta() && !p.scanner.IsSeekable {
+			delete(p.deltas, obj.Offset)
 		}
 	}
 
@@ -368,7 +462,7 @@ func (p *Parser) resolveExternalRef(o *objectInfo) {
 	}
 }
 
-func (p *Parser) get(o *objectInfo, buf *bytes.Buffer) (err error) {
+func (p *Parser) get(o *objectInfo, buf *spillBuffer) (err error) {
 	if !o.ExternalRef { // skip cache check for placeholder parents
 		b, ok := p.cache.Get(o.Offset)
 		if ok {
@@ -379,9 +473,14 @@ func (p *Parser) get(o *objectInfo, buf *bytes.Buffer) (err error) {
 
 	// If it's not on the cache and is not a delta we can try to find it in the
 	// storage, if there's one. External refs must enter here.
-	if p.storage != nil && !o.Type.IsDelta() {
+	s := p.storage
+	if s == nil && o.ExternalRef {
+		s = p.bases
+	}
+
+	if s != nil && !o.Type.IsDelta() {
 		var e plumbing.EncodedObject
-		e, err = p.storage.EncodedObject(plumbing.AnyObject, o.SHA1)
+		e, err = s.EncodedObject(plumbing.AnyObject, o.SHA1)
 		if err != nil {
 			return err
 		}
@@ -395,7 +494,7 @@ func (p *Parser) get(o *objectInfo, buf *bytes.Buffer) (err error) {
 
 		defer ioutil.CheckClose(r, &err)
 
-		_, err = buf.ReadFrom(io.LimitReader(r, e.Size()))
+		_, err = io.Copy(buf, io.LimitReader(r, e.Size()))
 		return err
 	}
 
@@ -405,15 +504,14 @@ func (p *Parser) get(o *objectInfo, buf *bytes.Buffer) (err error) {
 	}
 
 	if o.DiskType.IsDelta() {
-		b := sync.GetBytesBuffer()
-		defer sync.PutBytesBuffer(b)
-		buf.Grow(int(o.Length))
+		b := p.newSpillBuffer()
+		defer ioutil.CheckClose(b, &err)
 		err := p.get(o.Parent, b)
 		if err != nil {
 			return err
 		}
 
-		err = p.resolveObject(buf, o, bytes.NewReader(b.Bytes()))
+		err = p.resolveObject(buf, o, b.ReaderAt())
 		if err != nil {
 			return err
 		}
@@ -431,10 +529,10 @@ func (p *Parser) get(o *objectInfo, buf *bytes.Buffer) (err error) {
 	//
 	// TODO: improve seekable execution time, so that we can
 	// skip this cache.
-	if len(o.Children) > 0 {
-		data := make([]byte, buf.Len())
-		copy(data, buf.Bytes())
-		p.cache.Put(o.Offset, data)
+	//
+	// The objects written to temporary files are too large to be cached.
+	if data, ok := buf.Bytes(); ok && len(o.Children) > 0 {
+		p.cache.Put(o.Offset, append([]byte(nil), data...))
 	}
 	return nil
 }
@@ -458,13 +556,13 @@ func (p *Parser) resolveObject(
 	w io.Writer,
 	o *objectInfo,
 	base io.ReaderAt,
-) error {
+) (err error) {
 	if !o.DiskType.IsDelta() {
 		return nil
 	}
-	buf := sync.GetBytesBuffer()
-	defer sync.PutBytesBuffer(buf)
-	err := p.readData(buf, o)
+	buf := p.newSpillBuffer()
+	defer ioutil.CheckClose(buf, &err)
+	err = p.readData(buf, o)
 	if err != nil {
 		return err
 	}
@@ -496,7 +594,7 @@ func (p *Parser) resolveObject(
 
 	mw := io.MultiWriter(writers...)
 
-	err = applyPatchBase(o, base, buf, mw, lwh)
+	err = applyPatchBase(o, base, buf.Reader(), mw, lwh)
 	if err != nil {
 		return err
 	}
@@ -558,6 +656,99 @@ func applyPatchBase(ota *objectInfo, base io.ReaderAt, delta io.Reader, target i
 	return nil
 }
 
+// spillBuffer holds the data written to it in memory, until its size exceeds
+// the large object threshold of the parser, then in a temporary file.
+type spillBuffer struct {
+	p    *Parser
+	mem  *bytes.Buffer
+	file billy.File
+	size int64
+}
+
+func (p *Parser) newSpillBuffer() *spillBuffer {
+	return &spillBuffer{p: p, mem: sync.GetBytesBuffer()}
+}
+
+func (b *spillBuffer) Write(data []byte) (int, error) {
+	if b.file == nil && b.p.tempFS != nil && b.p.largeObjectThreshold > 0 &&
+		b.size+int64(len(data)) > b.p.largeObjectThreshold {
+		if err := b.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if b.file != nil {
+		n, err = b.file.Write(data)
+	} else {
+		n, err = b.mem.Write(data)
+	}
+
+	b.size += int64(n)
+	return n, err
+}
+
+func (b *spillBuffer) spill() error {
+	f, err := b.p.tempFS.TempFile(b.p.tempDir, "tmp_obj_")
+	if err != nil {
+		return err
+	}
+
+	b.file = f
+	if _, err := f.Write(b.mem.Bytes()); err != nil {
+		return err
+	}
+
+	sync.PutBytesBuffer(b.mem)
+	b.mem = nil
+	return nil
+}
+
+// Bytes returns the data written, if it is held in memory.
+func (b *spillBuffer) Bytes() ([]byte, bool) {
+	if b.file != nil {
+		return nil, false
+	}
+
+	return b.mem.Bytes(), true
+}
+
+// ReaderAt returns an io.ReaderAt reading the data written.
+func (b *spillBuffer) ReaderAt() io.ReaderAt {
+	if b.file != nil {
+		return io.NewSectionReader(b.file, 0, b.size)
+	}
+
+	return bytes.NewReader(b.mem.Bytes())
+}
+
+// Reader returns an io.Reader reading the data written.
+func (b *spillBuffer) Reader() io.Reader {
+	return io.NewSectionReader(b.ReaderAt(), 0, b.size)
+}
+
+// Close releases the memory buffer and removes the temporary file.
+func (b *spillBuffer) Close() error {
+	if b.mem != nil {
+		sync.PutBytesBuffer(b.mem)
+		b.mem = nil
+	}
+
+	if b.file == nil {
+		return nil
+	}
+
+	name := b.file.Name()
+	err := b.file.Close()
+	if rerr := b.p.tempFS.Remove(name); err == nil {
+		err = rerr
+	}
+
+	b.file = nil
+	return err
+}
+
 func getSHA1(t plumbing.ObjectType, data []byte) (plumbing.Hash, error) {
 	hasher := plumbing.NewHasher(t, int64(len(data)))
 	if _, err := hasher.Write(data); err != nil {
@@ -5,6 +5,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-billy/v5/util"
 	fixtures "github.com/go-git/go-git-fixtures/v4"
@@ -81,6 +83,63 @@ func (s *ParserSuite) TestParserHashes(c *C) {
 	c.Assert(obs.objects, DeepEquals, objs)
 }
 
+func (s *ParserSuite) TestParserProgress(c *C) {
+	f := fixtures.Basic().One()
+	obs := new(testProgressObserver)
+	parser, err := packfile.NewParser(packfile.NewScanner(f.Packfile()), obs)
+	c.Assert(err, IsNil)
+
+	_, err = parser.Parse()
+	c.Assert(err, IsNil)
+
+	c.Assert(obs.read, HasLen, 31)
+	for i, n := range obs.read {
+		c.Assert(n, Equals, uint32(i+1))
+	}
+
+	c.Assert(len(obs.resolved) > 0, Equals, true)
+	for i, n := range obs.resolved {
+		c.Assert(n, Equals, uint32(i+1))
+	}
+	c.Assert(obs.total, Equals, uint32(len(obs.resolved)))
+}
+
+type tempFileCounter struct {
+	billy.Filesystem
+	count int
+}
+
+func (fs *tempFileCounter) TempFile(dir, prefix string) (billy.File, error) {
+	fs.count++
+	return fs.Filesystem.TempFile(dir, prefix)
+}
+
+func (s *ParserSuite) TestParserLargeObjectThreshold(c *C) {
+	for _, f := range fixtures.ByTag("packfile").ByTag("ofs-delta") {
+		expected := new(testObserver)
+		parser, err := packfile.NewParser(packfile.NewScanner(f.Packfile()), expected)
+		c.Assert(err, IsNil)
+		_, err = parser.Parse()
+		c.Assert(err, IsNil)
+
+		fs := &tempFileCounter{Filesystem: memfs.New()}
+		obs := new(testObserver)
+		parser, err = packfile.NewParser(packfile.NewScanner(f.Packfile()), obs)
+		c.Assert(err, IsNil)
+		parser.SetLargeObjectThreshold(64, fs, "tmp")
+
+		_, err = parser.Parse()
+		c.Assert(err, IsNil)
+		c.Assert(obs, DeepEquals, expected)
+		c.Assert(fs.count, Not(Equals), 0)
+
+		// The temporary files are removed.
+		files, err := fs.ReadDir("tmp")
+		c.Assert(err, IsNil)
+		c.Assert(files, HasLen, 0)
+	}
+}
+
 func (s *ParserSuite) TestThinPack(c *C) {
 	fs := osfs.New(c.MkDir())
 	path, err := util.TempDir(fs, "", "")
@@ -227,6 +286,24 @@ func (t *testObserver) put(pos int64, o observerObject) {
 	t.objects = append(t.objects, o)
 }
 
+type testProgressObserver struct {
+	testObserver
+	read     []uint32
+	resolved []uint32
+	total    uint32
+}
+
+func (t *testProgressObserver) OnObjectRead(count uint32) error {
+	t.read = append(t.read, count)
+	return nil
+}
+
+func (t *testProgressObserver) OnDeltaResolved(count, total uint32) error {
+	t.resolved = append(t.resolved, count)
+	t.total = total
+	return nil
+}
+
 func BenchmarkParse(b *testing.B) {
 	defer fixtures.Clean()
 
@@ -0,0 +1,137 @@
+// Package reflog implements encoding and decoding of the reflog files, the
+// logs of the updates of the references stored in the logs directory.
+//
+// Each line of a reflog file is an update of the reference, oldest first:
+//
+//	<old hash> SP <new hash> SP <name> SP '<' <email> '>' SP <seconds> SP <zone> [HT <message>] LF
+package reflog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+)
+
+// ErrMalformedEntry is returned when a line of a reflog file is malformed.
+var ErrMalformedEntry = errors.New("malformed reflog entry")
+
+// Entry is an update of a reference.
+type Entry struct {
+	Old plumbing.Hash
+	New plumbing.Hash
+	// Committer name, email and time of the update.
+	Committer string
+	Email     string
+	When      time.Time
+	Message   string
+}
+
+// Decode reads the entries of a reflog file, oldest first.
+func Decode(r io.Reader) ([]*Entry, error) {
+	var entries []*Entry
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		e, err := decodeEntry(s.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func decodeEntry(line []byte) (*Entry, error) {
+	if len(line) < 2*hash.HexSize+2 || line[hash.HexSize] != ' ' || line[2*hash.HexSize+1] != ' ' {
+		return nil, ErrMalformedEntry
+	}
+
+	old, new := string(line[:hash.HexSize]), string(line[hash.HexSize+1:2*hash.HexSize+1])
+	if !plumbing.IsHash(old) || !plumbing.IsHash(new) {
+		return nil, ErrMalformedEntry
+	}
+
+	e := &Entry{Old: plumbing.NewHash(old), New: plumbing.NewHash(new)}
+
+	sig := line[2*hash.HexSize+2:]
+	if tab := bytes.IndexByte(sig, '\t'); tab != -1 {
+		e.Message = string(sig[tab+1:])
+		sig = sig[:tab]
+	}
+
+	open, close := bytes.IndexByte(sig, '<'), bytes.IndexByte(sig, '>')
+	if open == -1 || close < open {
+		return nil, ErrMalformedEntry
+	}
+
+	e.Committer = strings.TrimSpace(string(sig[:open]))
+	e.Email = string(sig[open+1 : close])
+
+	fields := strings.Fields(string(sig[close+1:]))
+	if len(fields) != 2 || len(fields[1]) != 5 {
+		return nil, ErrMalformedEntry
+	}
+
+	secs, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, ErrMalformedEntry
+	}
+
+	zone, err := strconv.Atoi(fields[1][1:])
+	if err != nil || fields[1][0] != '+' && fields[1][0] != '-' {
+		return nil, ErrMalformedEntry
+	}
+
+	offset := (zone/100)*3600 + (zone%100)*60
+	if fields[1][0] == '-' {
+		offset = -offset
+	}
+
+	e.When = time.Unix(secs, 0).In(time.FixedZone("", offset))
+	return e, nil
+}
+
+// Encode writes the given entries as a reflog file, in order.
+func Encode(w io.Writer, entries []*Entry) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		_, err := fmt.Fprintf(bw, "%s %s %s <%s> %d %s",
+			e.Old, e.New, e.Committer, e.Email, e.When.Unix(), e.When.Format("-0700"))
+		if err != nil {
+			return err
+		}
+
+		if e.Message != "" {
+			// The message is a single line, as git writes it.
+			msg := strings.ReplaceAll(strings.TrimRight(e.Message, "\n"), "\n", " ")
+			if _, err := fmt.Fprintf(bw, "\t%s", msg); err != nil {
+				return err
+			}
+		}
+
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
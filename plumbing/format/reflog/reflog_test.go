@@ -0,0 +1,61 @@
+package reflog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ReflogSuite struct{}
+
+var _ = Suite(&ReflogSuite{})
+
+const reflog = "0000000000000000000000000000000000000000 b029517f6300c2da0f4b651b8642506cd6aaf45d John Doe <john@example.com> 1427806800 +0200\tcommit (initial): Initial commit\n" +
+	"b029517f6300c2da0f4b651b8642506cd6aaf45d 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 Jane Doe <jane@example.com> 1427806900 -0530\n"
+
+func (s *ReflogSuite) TestDecode(c *C) {
+	entries, err := Decode(strings.NewReader(reflog))
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+
+	c.Assert(entries[0].Old, Equals, plumbing.ZeroHash)
+	c.Assert(entries[0].New, Equals, plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"))
+	c.Assert(entries[0].Committer, Equals, "John Doe")
+	c.Assert(entries[0].Email, Equals, "john@example.com")
+	c.Assert(entries[0].When.Equal(time.Unix(1427806800, 0)), Equals, true)
+	_, offset := entries[0].When.Zone()
+	c.Assert(offset, Equals, 2*3600)
+	c.Assert(entries[0].Message, Equals, "commit (initial): Initial commit")
+
+	c.Assert(entries[1].Message, Equals, "")
+	_, offset = entries[1].When.Zone()
+	c.Assert(offset, Equals, -5*3600-30*60)
+}
+
+func (s *ReflogSuite) TestEncode(c *C) {
+	entries, err := Decode(strings.NewReader(reflog))
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(Encode(&buf, entries), IsNil)
+	c.Assert(buf.String(), Equals, reflog)
+}
+
+func (s *ReflogSuite) TestDecodeMalformed(c *C) {
+	for _, line := range []string{
+		"foo",
+		"0000000000000000000000000000000000000000 b029517f6300c2da0f4b651b8642506cd6aaf45d John Doe 1427806800 +0200",
+		"0000000000000000000000000000000000000000 b029517f6300c2da0f4b651b8642506cd6aaf45d John Doe <john@example.com> 1427806800",
+		"0000000000000000000000000000000000000000 b029517f6300c2da0f4b651b8642506cd6aaf45d John Doe <john@example.com> foo +0200",
+	} {
+		_, err := Decode(strings.NewReader(line))
+		c.Assert(err, Equals, ErrMalformedEntry, Commentf("%s", line))
+	}
+}
@@ -0,0 +1,208 @@
+package reftable
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+)
+
+// blockWriter writes the records of a block. The block of the first block of
+// a table starts with the table header, which is written later.
+type blockWriter struct {
+	typ       byte
+	buf       []byte
+	headerOff int
+	blockSize int
+	restarts  []uint32
+	lastKey   []byte
+	entries   int
+}
+
+func newBlockWriter(typ byte, headerOff, blockSize int) *blockWriter {
+	buf := make([]byte, headerOff+blockHeaderSize, blockSize)
+	return &blockWriter{typ: typ, buf: buf, headerOff: headerOff, blockSize: blockSize}
+}
+
+// add appends the record to the block, the key prefix compressed, followed
+// by the value type and the value. It returns false if the record doesn't
+// fit in the block.
+func (w *blockWriter) add(key []byte, valueType byte, value []byte) bool {
+	restart := w.entries%restartInterval == 0
+	prefix := 0
+	if !restart {
+		prefix = commonPrefix(w.lastKey, key)
+	}
+
+	start := len(w.buf)
+	w.buf = putVarint(w.buf, uint64(prefix))
+	w.buf = putVarint(w.buf, uint64(len(key)-prefix)<<3|uint64(valueType))
+	w.buf = append(w.buf, key[prefix:]...)
+	w.buf = append(w.buf, value...)
+
+	restarts := len(w.restarts)
+	if restart {
+		restarts++
+	}
+
+	if len(w.buf)+3*restarts+2 > w.blockSize {
+		w.buf = w.buf[:start]
+		return false
+	}
+
+	if restart {
+		w.restarts = append(w.restarts, uint32(start))
+	}
+
+	w.lastKey = append(w.lastKey[:0], key...)
+	w.entries++
+	return true
+}
+
+// finish appends the restart points to the block, and writes its header. The
+// block is returned, log blocks compressed.
+func (w *blockWriter) finish() ([]byte, error) {
+	for _, r := range w.restarts {
+		var b [3]byte
+		putUint24(b[:], r)
+		w.buf = append(w.buf, b[:]...)
+	}
+
+	w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(len(w.restarts)))
+
+	w.buf[w.headerOff] = w.typ
+	putUint24(w.buf[w.headerOff+1:], uint32(len(w.buf)))
+
+	if w.typ != blockTypeLog {
+		return w.buf, nil
+	}
+
+	skip := w.headerOff + blockHeaderSize
+	var compressed bytes.Buffer
+	compressed.Write(w.buf[:skip])
+
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(w.buf[skip:]); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return compressed.Bytes(), nil
+}
+
+func commonPrefix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+// block is a block read from a table.
+type block struct {
+	typ       byte
+	data      []byte
+	headerOff int
+	// size is the number of bytes the block takes in the table, including
+	// the padding, or the compressed size of a log block.
+	size int
+}
+
+// readBlock reads the block at off, which may not go past end. The first
+// block of the table, at 0, starts with the table header.
+func readBlock(data []byte, off, end, headerOff, blockSize int) (*block, error) {
+	skip := headerOff + blockHeaderSize
+	if off+skip > end {
+		return nil, ErrMalformedTable
+	}
+
+	b := &block{typ: data[off+headerOff], headerOff: headerOff}
+	length := int(getUint24(data[off+headerOff+1:]))
+	if length < skip+2 {
+		return nil, ErrMalformedTable
+	}
+
+	if b.typ == blockTypeLog {
+		r := bytes.NewReader(data[off+skip : end])
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, ErrMalformedTable
+		}
+
+		b.data = make([]byte, length)
+		copy(b.data, data[off:off+skip])
+		if _, err := io.ReadFull(zr, b.data[skip:]); err != nil {
+			return nil, ErrMalformedTable
+		}
+
+		// Read the end of the stream, with its checksum, to know the
+		// compressed size.
+		if n, err := zr.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			return nil, ErrMalformedTable
+		}
+
+		b.size = end - off - r.Len()
+		return b, nil
+	}
+
+	if off+length > end {
+		return nil, ErrMalformedTable
+	}
+
+	b.data = data[off : off+length]
+	b.size = length
+	if next := off + length; next < end && data[next] == 0 && blockSize > length {
+		// the block is padded
+		b.size = blockSize
+	}
+
+	return b, nil
+}
+
+// records calls fn for each record of the block, with its key, value type
+// and the rest of the block from its value. fn returns the size of the
+// value. The key is only valid until fn returns.
+func (b *block) records(fn func(key []byte, valueType byte, value []byte) (int, error)) error {
+	count := int(binary.BigEndian.Uint16(b.data[len(b.data)-2:]))
+	end := len(b.data) - 2 - 3*count
+	pos := b.headerOff + blockHeaderSize
+	if end < pos {
+		return ErrMalformedTable
+	}
+
+	var key []byte
+	for pos < end {
+		prefix, n, err := getVarint(b.data[pos:end])
+		if err != nil {
+			return err
+		}
+		pos += n
+
+		suffix, n, err := getVarint(b.data[pos:end])
+		if err != nil {
+			return err
+		}
+		pos += n
+
+		valueType := byte(suffix & 0x7)
+		suffix >>= 3
+		if prefix > uint64(len(key)) || suffix > uint64(end-pos) {
+			return ErrMalformedTable
+		}
+
+		key = append(key[:prefix], b.data[pos:pos+int(suffix)]...)
+		pos += int(suffix)
+
+		n, err = fn(key, valueType, b.data[pos:end])
+		if err != nil {
+			return err
+		}
+		pos += n
+	}
+
+	return nil
+}
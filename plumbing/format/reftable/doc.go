@@ -0,0 +1,45 @@
+// Package reftable implements reading and writing of reftable files, and of
+// the stacks of them storing the references of a repository.
+//
+// A reftable file stores references and their logs sorted by name, in
+// prefix-compressed blocks. It has the following format:
+//
+//   - A 24-byte header, or 28-byte for version 2:
+//
+//     4-byte signature "REFT".
+//
+//     1-byte version number, 1 or 2.
+//
+//     3-byte block size.
+//
+//     8-byte min and max update index of the records.
+//
+//     For version 2, the 4-byte hash function id, "sha1" or "s256".
+//
+//   - The ref blocks, of type 'r', and their index. The first block shares
+//     the block size with the header.
+//
+//   - The obj blocks, of type 'o', mapping objects to the blocks of the refs
+//     pointing to them, and their index. They are optional and ignored by
+//     this package.
+//
+//   - The log blocks, of type 'g', compressed with zlib, and their index.
+//
+//   - A footer, made of the header repeated, the 8-byte positions of the ref
+//     index, obj blocks, obj index, log blocks and log index, and the 4-byte
+//     CRC-32 of the footer.
+//
+// Each block is made of the 1-byte block type, the 3-byte length of the
+// block, the records and the 3-byte offsets of the restart points, the
+// records whose key isn't prefix compressed, followed by their 2-byte count.
+// The ref, obj and index blocks are padded up to the block size.
+//
+// The reftable files of a repository, in $GIT_DIR/reftable, are listed in
+// the tables.list file, oldest first. A newer table overrides the records of
+// the older ones. Tables are added by locking tables.list, with a
+// tables.list.lock file, and then renaming it with the new table added to
+// it. The tables are compacted, merged, as they are added so their sizes
+// form a geometric sequence, and the stack stays small.
+//
+// See https://git-scm.com/docs/reftable
+package reftable
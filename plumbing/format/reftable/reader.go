@@ -0,0 +1,190 @@
+package reftable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Table is a reftable file, read in memory. Its indexes and obj blocks are
+// not used, the records are looked up by reading the blocks in order.
+type Table struct {
+	data        []byte
+	version     byte
+	blockSize   int
+	headerSize  int
+	footerStart int
+	min, max    uint64
+	logPos      int
+	hasLogs     bool
+}
+
+// NewTable returns the table read from data, a whole reftable file.
+func NewTable(data []byte) (*Table, error) {
+	if len(data) < headerSizeV1+footerSizeV1 || !bytes.Equal(data[:4], magic) {
+		return nil, ErrMalformedTable
+	}
+
+	t := &Table{data: data, version: data[4]}
+	if t.version != 1 && t.version != 2 {
+		return nil, ErrUnsupportedVersion
+	}
+
+	t.headerSize = headerSize(t.version)
+	t.footerStart = len(data) - footerSize(t.version)
+	if t.footerStart < t.headerSize {
+		return nil, ErrMalformedTable
+	}
+
+	footer := data[t.footerStart:]
+	if !bytes.Equal(footer[:t.headerSize], data[:t.headerSize]) {
+		return nil, ErrMalformedTable
+	}
+
+	crc := binary.BigEndian.Uint32(footer[len(footer)-4:])
+	if crc32.ChecksumIEEE(footer[:len(footer)-4]) != crc {
+		return nil, ErrMalformedTable
+	}
+
+	id, _ := hashID()
+	if t.version == 1 && id != hashIDSHA1 ||
+		t.version == 2 && binary.BigEndian.Uint32(data[24:]) != id {
+		return nil, ErrUnsupportedHash
+	}
+
+	t.blockSize = int(getUint24(data[5:]))
+	t.min = binary.BigEndian.Uint64(data[8:])
+	t.max = binary.BigEndian.Uint64(data[16:])
+
+	logPos := binary.BigEndian.Uint64(footer[t.headerSize+24:])
+	if logPos > uint64(t.footerStart) {
+		return nil, ErrMalformedTable
+	}
+
+	t.logPos = int(logPos)
+	t.hasLogs = logPos > 0 || t.footerStart > t.headerSize && data[t.headerSize] == blockTypeLog
+
+	return t, nil
+}
+
+// MinUpdateIndex returns the lowest update index of the records of the table.
+func (t *Table) MinUpdateIndex() uint64 {
+	return t.min
+}
+
+// MaxUpdateIndex returns the highest update index of the records of the
+// table.
+func (t *Table) MaxUpdateIndex() uint64 {
+	return t.max
+}
+
+// Size returns the size of the table file.
+func (t *Table) Size() int {
+	return len(t.data)
+}
+
+// Refs returns the ref records of the table, including the deletions,
+// sorted by name.
+func (t *Table) Refs() ([]*RefRecord, error) {
+	var refs []*RefRecord
+	err := t.refs(func(r *RefRecord) bool {
+		refs = append(refs, r)
+		return true
+	})
+
+	return refs, err
+}
+
+// Ref returns the ref record of the given reference, which may be a
+// deletion, or plumbing.ErrReferenceNotFound if the table has none.
+func (t *Table) Ref(name plumbing.ReferenceName) (*RefRecord, error) {
+	var found *RefRecord
+	err := t.refs(func(r *RefRecord) bool {
+		if r.Name == name {
+			found = r
+		}
+
+		return r.Name < name
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if found == nil {
+		return nil, plumbing.ErrReferenceNotFound
+	}
+
+	return found, nil
+}
+
+// refs calls fn with the ref records in order, until it returns false.
+func (t *Table) refs(fn func(*RefRecord) bool) error {
+	return t.records(0, blockTypeRef, func(key []byte, valueType byte, value []byte) (int, bool, error) {
+		r, n, err := decodeRef(key, valueType, value, t.min)
+		if err != nil {
+			return 0, false, err
+		}
+
+		return n, fn(r), nil
+	})
+}
+
+// Logs returns the log records of the table, sorted by name and newest
+// first.
+func (t *Table) Logs() ([]*LogRecord, error) {
+	var logs []*LogRecord
+	if !t.hasLogs {
+		return nil, nil
+	}
+
+	err := t.records(t.logPos, blockTypeLog, func(key []byte, valueType byte, value []byte) (int, bool, error) {
+		l, n, err := decodeLog(key, valueType, value)
+		if err != nil {
+			return 0, false, err
+		}
+
+		logs = append(logs, l)
+		return n, true, nil
+	})
+
+	return logs, err
+}
+
+// records calls fn with the records of the blocks of the given type starting
+// at off, until it returns false.
+func (t *Table) records(off int, typ byte, fn func(key []byte, valueType byte, value []byte) (int, bool, error)) error {
+	headerOff := 0
+	if off == 0 {
+		headerOff = t.headerSize
+	}
+
+	for off+headerOff < t.footerStart && t.data[off+headerOff] == typ {
+		b, err := readBlock(t.data, off, t.footerStart, headerOff, t.blockSize)
+		if err != nil {
+			return err
+		}
+
+		done := false
+		err = b.records(func(key []byte, valueType byte, value []byte) (int, error) {
+			if done {
+				return len(value), nil
+			}
+
+			n, more, err := fn(key, valueType, value)
+			done = !more
+			return n, err
+		})
+
+		if err != nil || done {
+			return err
+		}
+
+		off += b.size
+		headerOff = 0
+	}
+
+	return nil
+}
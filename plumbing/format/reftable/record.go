@@ -0,0 +1,209 @@
+package reftable
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+)
+
+const (
+	logTypeDeletion = 0
+	logTypeUpdate   = 1
+)
+
+// encodeRef returns the key, value type and value of the ref record, its
+// update index stored as a delta of the min update index of the table.
+func encodeRef(r *RefRecord, minUpdateIndex uint64) ([]byte, byte, []byte) {
+	value := putVarint(nil, r.UpdateIndex-minUpdateIndex)
+	switch r.Type {
+	case RefHash:
+		value = append(value, r.Value[:]...)
+	case RefPeeledHash:
+		value = append(value, r.Value[:]...)
+		value = append(value, r.Peeled[:]...)
+	case RefSymbolic:
+		value = putVarint(value, uint64(len(r.Target)))
+		value = append(value, r.Target...)
+	}
+
+	return []byte(r.Name), byte(r.Type), value
+}
+
+// decodeRef decodes a ref record, and returns it and the size of its value.
+func decodeRef(key []byte, valueType byte, value []byte, minUpdateIndex uint64) (*RefRecord, int, error) {
+	delta, pos, err := getVarint(value)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r := &RefRecord{
+		Name:        plumbing.ReferenceName(key),
+		UpdateIndex: minUpdateIndex + delta,
+		Type:        RefValueType(valueType),
+	}
+
+	switch r.Type {
+	case RefDeletion:
+	case RefHash, RefPeeledHash:
+		n := hash.Size
+		if r.Type == RefPeeledHash {
+			n *= 2
+		}
+
+		if len(value) < pos+n {
+			return nil, 0, ErrMalformedTable
+		}
+
+		copy(r.Value[:], value[pos:])
+		if r.Type == RefPeeledHash {
+			copy(r.Peeled[:], value[pos+hash.Size:])
+		}
+		pos += n
+	case RefSymbolic:
+		s, n, err := getString(value[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		r.Target = plumbing.ReferenceName(s)
+		pos += n
+	default:
+		return nil, 0, ErrMalformedTable
+	}
+
+	return r, pos, nil
+}
+
+// logKey returns the key of a log record, the name of the reference followed
+// by the reversed update index, so the newest records come first.
+func logKey(name plumbing.ReferenceName, updateIndex uint64) []byte {
+	key := make([]byte, 0, len(name)+9)
+	key = append(key, name...)
+	key = append(key, 0)
+	return binary.BigEndian.AppendUint64(key, ^updateIndex)
+}
+
+// encodeLog returns the key, value type and value of the log record.
+func encodeLog(l *LogRecord) ([]byte, byte, []byte) {
+	key := logKey(l.Name, l.UpdateIndex)
+	if l.Deletion {
+		return key, logTypeDeletion, nil
+	}
+
+	_, offset := l.When.Zone()
+	value := make([]byte, 0, 2*hash.Size+len(l.Committer)+len(l.Email)+len(l.Message)+16)
+	value = append(value, l.Old[:]...)
+	value = append(value, l.New[:]...)
+	value = putString(value, l.Committer)
+	value = putString(value, l.Email)
+	value = putVarint(value, uint64(l.When.Unix()))
+	value = binary.BigEndian.AppendUint16(value, uint16(int16(offset/60)))
+	value = putString(value, l.Message)
+
+	return key, logTypeUpdate, value
+}
+
+// decodeLog decodes a log record, and returns it and the size of its value.
+func decodeLog(key []byte, valueType byte, value []byte) (*LogRecord, int, error) {
+	if len(key) < 9 || key[len(key)-9] != 0 {
+		return nil, 0, ErrMalformedTable
+	}
+
+	l := &LogRecord{
+		Name:        plumbing.ReferenceName(key[:len(key)-9]),
+		UpdateIndex: ^binary.BigEndian.Uint64(key[len(key)-8:]),
+	}
+
+	switch valueType {
+	case logTypeDeletion:
+		l.Deletion = true
+		return l, 0, nil
+	case logTypeUpdate:
+	default:
+		return nil, 0, ErrMalformedTable
+	}
+
+	if len(value) < 2*hash.Size {
+		return nil, 0, ErrMalformedTable
+	}
+
+	copy(l.Old[:], value)
+	copy(l.New[:], value[hash.Size:])
+	pos := 2 * hash.Size
+
+	var n int
+	var err error
+	if l.Committer, n, err = getString(value[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+
+	if l.Email, n, err = getString(value[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+
+	secs, n, err := getVarint(value[pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += n
+
+	if len(value) < pos+2 {
+		return nil, 0, ErrMalformedTable
+	}
+
+	offset := int(int16(binary.BigEndian.Uint16(value[pos:])))
+	l.When = time.Unix(int64(secs), 0).In(time.FixedZone("", offset*60))
+	pos += 2
+
+	if l.Message, n, err = getString(value[pos:]); err != nil {
+		return nil, 0, err
+	}
+	pos += n
+
+	return l, pos, nil
+}
+
+// encodeIndex returns the key, value type and value of an index record,
+// pointing to the block at the given position.
+func encodeIndex(lastKey []byte, position uint64) ([]byte, byte, []byte) {
+	return lastKey, 0, putVarint(nil, position)
+}
+
+func putString(b []byte, s string) []byte {
+	b = putVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func getString(b []byte) (string, int, error) {
+	l, n, err := getVarint(b)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if l > uint64(len(b)-n) {
+		return "", 0, ErrMalformedTable
+	}
+
+	return string(b[n : n+int(l)]), n + int(l), nil
+}
+
+// compareLogs orders log records by their key.
+func compareLogs(a, b *LogRecord) int {
+	if c := strings.Compare(string(a.Name), string(b.Name)); c != 0 {
+		return c
+	}
+
+	switch {
+	case a.UpdateIndex > b.UpdateIndex:
+		return -1
+	case a.UpdateIndex < b.UpdateIndex:
+		return 1
+	}
+
+	return 0
+}
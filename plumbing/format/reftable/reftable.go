@@ -0,0 +1,202 @@
+package reftable
+
+import (
+	"crypto"
+	"errors"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+)
+
+var (
+	// ErrMalformedTable is returned when a reftable file is corrupted.
+	ErrMalformedTable = errors.New("malformed reftable file")
+	// ErrUnsupportedVersion is returned when the version of a reftable file
+	// is not supported.
+	ErrUnsupportedVersion = errors.New("unsupported reftable version")
+	// ErrUnsupportedHash is returned when the hash function of a reftable
+	// file is not the one in use.
+	ErrUnsupportedHash = errors.New("unsupported hash algorithm")
+	// ErrRecordTooLarge is returned by the Writer when a record doesn't fit
+	// in a block.
+	ErrRecordTooLarge = errors.New("record too large for the block size")
+	// ErrUnsorted is returned by the Writer when the records are not added
+	// in order, or the update index of a ref is out of the range of the
+	// table.
+	ErrUnsorted = errors.New("records not sorted or out of the update index range")
+
+	magic = []byte{'R', 'E', 'F', 'T'}
+)
+
+const (
+	// DefaultBlockSize is the block size of the tables written, the same
+	// as git's.
+	DefaultBlockSize = 4096
+	// MaxBlockSize is the maximum block size, its length is 3 bytes.
+	MaxBlockSize = 1<<24 - 1
+
+	headerSizeV1 = 24
+	headerSizeV2 = 28
+	footerSizeV1 = headerSizeV1 + 44
+	footerSizeV2 = headerSizeV2 + 44
+
+	blockHeaderSize = 4
+	restartInterval = 16
+
+	blockTypeRef   = 'r'
+	blockTypeObj   = 'o'
+	blockTypeIndex = 'i'
+	blockTypeLog   = 'g'
+
+	// hash function ids of the version 2
+	hashIDSHA1   = 0x73686131 // "sha1"
+	hashIDSHA256 = 0x73323536 // "s256"
+)
+
+// RefValueType is the type of the value of a ref record.
+type RefValueType byte
+
+const (
+	// RefDeletion is a deleted reference, it has no value.
+	RefDeletion RefValueType = iota
+	// RefHash is a reference to an object.
+	RefHash
+	// RefPeeledHash is a reference to an annotated tag, along with the
+	// object the tag points to.
+	RefPeeledHash
+	// RefSymbolic is a symbolic reference.
+	RefSymbolic
+)
+
+// RefRecord is a reference stored in a table.
+type RefRecord struct {
+	Name        plumbing.ReferenceName
+	UpdateIndex uint64
+	Type        RefValueType
+	// Value is the object pointed to by a RefHash or RefPeeledHash.
+	Value plumbing.Hash
+	// Peeled is the object pointed to by the annotated tag Value, for a
+	// RefPeeledHash.
+	Peeled plumbing.Hash
+	// Target is the reference pointed to by a RefSymbolic.
+	Target plumbing.ReferenceName
+}
+
+// NewRefRecord returns the record of the given reference, or a deletion
+// record if ref is nil.
+func NewRefRecord(name plumbing.ReferenceName, ref *plumbing.Reference, updateIndex uint64) *RefRecord {
+	r := &RefRecord{Name: name, UpdateIndex: updateIndex}
+	switch {
+	case ref == nil:
+		r.Type = RefDeletion
+	case ref.Type() == plumbing.SymbolicReference:
+		r.Type = RefSymbolic
+		r.Target = ref.Target()
+	default:
+		r.Type = RefHash
+		r.Value = ref.Hash()
+	}
+
+	return r
+}
+
+// Reference returns the reference stored by the record, or nil if it is a
+// deletion.
+func (r *RefRecord) Reference() *plumbing.Reference {
+	switch r.Type {
+	case RefHash, RefPeeledHash:
+		return plumbing.NewHashReference(r.Name, r.Value)
+	case RefSymbolic:
+		return plumbing.NewSymbolicReference(r.Name, r.Target)
+	}
+
+	return nil
+}
+
+// LogRecord is an entry of the log of a reference stored in a table.
+type LogRecord struct {
+	Name        plumbing.ReferenceName
+	UpdateIndex uint64
+	// Deletion is true if the record deletes the entry, the other fields
+	// are then empty.
+	Deletion bool
+	Old      plumbing.Hash
+	New      plumbing.Hash
+	// Committer name, email and time of the update.
+	Committer string
+	Email     string
+	When      time.Time
+	Message   string
+}
+
+// hashID returns the hash function id in use, and the version of the tables
+// supporting it.
+func hashID() (id uint32, version byte) {
+	if hash.CryptoType == crypto.SHA256 {
+		return hashIDSHA256, 2
+	}
+
+	return hashIDSHA1, 1
+}
+
+func headerSize(version byte) int {
+	if version == 2 {
+		return headerSizeV2
+	}
+
+	return headerSizeV1
+}
+
+func footerSize(version byte) int {
+	if version == 2 {
+		return footerSizeV2
+	}
+
+	return footerSizeV1
+}
+
+// putVarint appends v to b with the variable length encoding of the
+// reftable and packfile offsets, where each byte after the first adds one
+// to the value so no value has two encodings.
+func putVarint(b []byte, v uint64) []byte {
+	var buf [10]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	for v >>= 7; v != 0; v >>= 7 {
+		v--
+		i--
+		buf[i] = 0x80 | byte(v&0x7f)
+	}
+
+	return append(b, buf[i:]...)
+}
+
+// getVarint reads a value encoded by putVarint, and returns it and the
+// number of bytes read.
+func getVarint(b []byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrMalformedTable
+	}
+
+	v := uint64(b[0] & 0x7f)
+	i := 0
+	for b[i]&0x80 != 0 {
+		i++
+		if i >= len(b) || i > 9 {
+			return 0, 0, ErrMalformedTable
+		}
+
+		v = (v+1)<<7 | uint64(b[i]&0x7f)
+	}
+
+	return v, i + 1, nil
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+func getUint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
@@ -0,0 +1,214 @@
+package reftable_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	. "github.com/go-git/go-git/v5/plumbing/format/reftable"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ReftableSuite struct{}
+
+var _ = Suite(&ReftableSuite{})
+
+func (s *ReftableSuite) TestWriteSymbolicRef(c *C) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1, 1, &WriterOptions{BlockSize: 256})
+	c.Assert(w.AddRef(&RefRecord{
+		Name:        plumbing.HEAD,
+		UpdateIndex: 1,
+		Type:        RefSymbolic,
+		Target:      "refs/heads/main",
+	}), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	header := []byte{
+		'R', 'E', 'F', 'T', 1, 0, 1, 0, // version 1, block size 256
+		0, 0, 0, 0, 0, 0, 0, 1, // min update index
+		0, 0, 0, 0, 0, 0, 0, 1, // max update index
+	}
+
+	expected := append([]byte{}, header...)
+	expected = append(expected, 'r', 0, 0, 56) // the block length, with the header
+	expected = append(expected, 0, 4<<3|3)     // prefix, suffix length and symref type
+	expected = append(expected, "HEAD"...)
+	expected = append(expected, 0, 15) // update index delta, target length
+	expected = append(expected, "refs/heads/main"...)
+	expected = append(expected, 0, 0, 28, 0, 1) // restart offset and count
+
+	data := buf.Bytes()
+	c.Assert(data, HasLen, 256+68)
+	c.Assert(data[:56], DeepEquals, expected)
+	c.Assert(data[56:256], DeepEquals, make([]byte, 200))
+	c.Assert(data[256:280], DeepEquals, header)
+
+	t, err := NewTable(data)
+	c.Assert(err, IsNil)
+	c.Assert(t.MinUpdateIndex(), Equals, uint64(1))
+	c.Assert(t.MaxUpdateIndex(), Equals, uint64(1))
+
+	r, err := t.Ref(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(r.Reference(), DeepEquals, plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main"))
+}
+
+func (s *ReftableSuite) TestWriteRead(c *C) {
+	var refs []*RefRecord
+	for i := 0; i < 500; i++ {
+		r := &RefRecord{
+			Name:        plumbing.ReferenceName(fmt.Sprintf("refs/heads/branch-%04d", i)),
+			UpdateIndex: 1000 + uint64(i%3),
+			Type:        RefHash,
+			Value:       plumbing.ComputeHash(plumbing.BlobObject, []byte{byte(i), byte(i >> 8)}),
+		}
+
+		switch i % 5 {
+		case 1:
+			r.Type = RefDeletion
+			r.Value = plumbing.ZeroHash
+		case 2:
+			r.Type = RefPeeledHash
+			r.Peeled = plumbing.ComputeHash(plumbing.CommitObject, []byte{byte(i)})
+		case 3:
+			r.Type = RefSymbolic
+			r.Value = plumbing.ZeroHash
+			r.Target = "refs/heads/main"
+		}
+
+		refs = append(refs, r)
+	}
+
+	when := time.Unix(1700000000, 0).In(time.FixedZone("", -5*3600-30*60))
+	logs := []*LogRecord{{
+		Name:        "refs/heads/branch-0000",
+		UpdateIndex: 1002,
+		Old:         refs[0].Value,
+		New:         refs[2].Value,
+		Committer:   "John Doe",
+		Email:       "john@example.com",
+		When:        when,
+		Message:     "commit: foo\n",
+	}, {
+		Name:        "refs/heads/branch-0000",
+		UpdateIndex: 1000,
+		Deletion:    true,
+	}, {
+		Name:        "refs/heads/branch-0001",
+		UpdateIndex: 1001,
+		New:         refs[1].Value,
+		Committer:   "Jane Doe",
+		Email:       "jane@example.com",
+		When:        time.Unix(1700000001, 0).In(time.FixedZone("", 3600)),
+		Message:     "branch: Created from HEAD\n",
+	}}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1000, 1002, &WriterOptions{BlockSize: 512})
+	for _, r := range refs {
+		c.Assert(w.AddRef(r), IsNil)
+	}
+	for _, l := range logs {
+		c.Assert(w.AddLog(l), IsNil)
+	}
+	c.Assert(w.Close(), IsNil)
+
+	t, err := NewTable(buf.Bytes())
+	c.Assert(err, IsNil)
+	c.Assert(t.MinUpdateIndex(), Equals, uint64(1000))
+	c.Assert(t.MaxUpdateIndex(), Equals, uint64(1002))
+
+	read, err := t.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, refs)
+
+	r, err := t.Ref("refs/heads/branch-0042")
+	c.Assert(err, IsNil)
+	c.Assert(r, DeepEquals, refs[42])
+
+	_, err = t.Ref("refs/heads/branch-0042-foo")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	readLogs, err := t.Logs()
+	c.Assert(err, IsNil)
+	c.Assert(readLogs, HasLen, 3)
+	c.Assert(readLogs[0].When.Equal(when), Equals, true)
+	_, offset := readLogs[0].When.Zone()
+	c.Assert(offset, Equals, -5*3600-30*60)
+	readLogs[0].When, readLogs[2].When = logs[0].When, logs[2].When
+	c.Assert(readLogs, DeepEquals, logs)
+}
+
+func (s *ReftableSuite) TestWriteOnlyLogs(c *C) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1, 1, nil)
+	c.Assert(w.AddLog(&LogRecord{Name: "refs/heads/main", UpdateIndex: 1, Deletion: true}), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	t, err := NewTable(buf.Bytes())
+	c.Assert(err, IsNil)
+
+	refs, err := t.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 0)
+
+	logs, err := t.Logs()
+	c.Assert(err, IsNil)
+	c.Assert(logs, DeepEquals, []*LogRecord{{Name: "refs/heads/main", UpdateIndex: 1, Deletion: true}})
+}
+
+func (s *ReftableSuite) TestWriteEmpty(c *C) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1, 1, nil)
+	c.Assert(w.Close(), IsNil)
+	c.Assert(buf.Len(), Equals, 24+68)
+
+	t, err := NewTable(buf.Bytes())
+	c.Assert(err, IsNil)
+
+	refs, err := t.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 0)
+
+	logs, err := t.Logs()
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 0)
+}
+
+func (s *ReftableSuite) TestWriteUnsorted(c *C) {
+	w := NewWriter(&bytes.Buffer{}, 1, 2, nil)
+	c.Assert(w.AddRef(&RefRecord{Name: "refs/heads/b", UpdateIndex: 1}), IsNil)
+	c.Assert(w.AddRef(&RefRecord{Name: "refs/heads/a", UpdateIndex: 1}), Equals, ErrUnsorted)
+	c.Assert(w.AddRef(&RefRecord{Name: "refs/heads/c", UpdateIndex: 3}), Equals, ErrUnsorted)
+
+	c.Assert(w.AddLog(&LogRecord{Name: "refs/heads/a", UpdateIndex: 1}), IsNil)
+	c.Assert(w.AddLog(&LogRecord{Name: "refs/heads/a", UpdateIndex: 2}), Equals, ErrUnsorted)
+	c.Assert(w.AddLog(&LogRecord{Name: "refs/heads/b", UpdateIndex: 5}), IsNil)
+	c.Assert(w.AddRef(&RefRecord{Name: "refs/heads/d", UpdateIndex: 1}), Equals, ErrUnsorted)
+}
+
+func (s *ReftableSuite) TestReadMalformed(c *C) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1, 1, nil)
+	c.Assert(w.AddRef(&RefRecord{Name: plumbing.HEAD, UpdateIndex: 1, Type: RefSymbolic, Target: "refs/heads/main"}), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	data := buf.Bytes()
+	data[len(data)-1]++
+	_, err := NewTable(data)
+	c.Assert(err, Equals, ErrMalformedTable)
+
+	data[len(data)-1]--
+	data[4] = 3
+	_, err = NewTable(data)
+	c.Assert(err, Equals, ErrUnsupportedVersion)
+
+	_, err = NewTable([]byte("REFT"))
+	c.Assert(err, Equals, ErrMalformedTable)
+}
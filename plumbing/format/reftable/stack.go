@@ -0,0 +1,615 @@
+package reftable
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+const (
+	// DefaultLockTimeout is how long the tables.list file locked by another
+	// process is waited for by default, the same as git's
+	// reftable.lockTimeout.
+	DefaultLockTimeout = 100 * time.Millisecond
+
+	tablesListName = "tables.list"
+	lockSuffix     = ".lock"
+	maxLockBackoff = 10 * time.Millisecond
+	// maxReloads is how many times tables.list is read again when one of
+	// its tables was removed meanwhile by a compaction.
+	maxReloads = 5
+)
+
+var (
+	// ErrLocked is returned when tables.list, or a table being compacted,
+	// is locked by another process.
+	ErrLocked = errors.New("reftable locked")
+	// ErrAdditionClosed is returned when an Addition is used after being
+	// committed or closed.
+	ErrAdditionClosed = errors.New("reftable addition closed")
+)
+
+// StackOptions are the options of a Stack.
+type StackOptions struct {
+	// BlockSize is the size of the blocks of the tables written. If left
+	// unset or set to 0, DefaultBlockSize is used.
+	BlockSize int
+	// LockTimeout is how long to keep trying to lock tables.list, locked
+	// by another process. If left unset or set to 0, DefaultLockTimeout is
+	// used.
+	LockTimeout time.Duration
+	// DisableAutoCompact disables the compaction of the tables after a
+	// table is added.
+	DisableAutoCompact bool
+}
+
+// Stack is the stack of reftable files listed in the tables.list file of a
+// directory, usually $GIT_DIR/reftable. It's reloaded each time it's read,
+// and is safe for concurrent use by multiple goroutines.
+type Stack struct {
+	fs      billy.Filesystem
+	options StackOptions
+
+	mu     sync.Mutex
+	names  []string
+	tables []*Table
+}
+
+// NewStack returns the Stack of the tables in the root of fs.
+func NewStack(fs billy.Filesystem, o StackOptions) *Stack {
+	return &Stack{fs: fs, options: o}
+}
+
+// Ref returns the newest ref record of the given reference, or
+// plumbing.ErrReferenceNotFound if it doesn't exist or was deleted.
+func (s *Stack) Ref(name plumbing.ReferenceName) (*RefRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	for i := len(s.tables) - 1; i >= 0; i-- {
+		r, err := s.tables[i].Ref(name)
+		if err == plumbing.ErrReferenceNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Type == RefDeletion {
+			break
+		}
+
+		return r, nil
+	}
+
+	return nil, plumbing.ErrReferenceNotFound
+}
+
+// Refs returns the newest ref records of all the references, without the
+// deleted ones, sorted by name.
+func (s *Stack) Refs() ([]*RefRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return mergeRefs(s.tables, true)
+}
+
+// Logs returns the log records of all the references, sorted by name and
+// newest first.
+func (s *Stack) Logs() ([]*LogRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return mergeLogs(s.tables, true)
+}
+
+// reload reads tables.list, and the tables not read yet.
+func (s *Stack) reload() error {
+	for i := 0; ; i++ {
+		names, err := s.readList()
+		if err != nil {
+			return err
+		}
+
+		tables, err := s.openTables(names)
+		if os.IsNotExist(err) && i < maxReloads {
+			// a table was compacted after tables.list was read
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		s.names, s.tables = names, tables
+		return nil
+	}
+}
+
+func (s *Stack) readList() ([]string, error) {
+	content, err := util.ReadFile(s.fs, tablesListName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(content), "\n") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+func (s *Stack) openTables(names []string) ([]*Table, error) {
+	open := make(map[string]*Table, len(s.names))
+	for i, name := range s.names {
+		open[name] = s.tables[i]
+	}
+
+	tables := make([]*Table, len(names))
+	for i, name := range names {
+		if t, ok := open[name]; ok {
+			tables[i] = t
+			continue
+		}
+
+		data, err := util.ReadFile(s.fs, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if tables[i], err = NewTable(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return tables, nil
+}
+
+// nextUpdateIndex returns the update index of the next table added.
+func (s *Stack) nextUpdateIndex() uint64 {
+	if len(s.tables) == 0 {
+		return 1
+	}
+
+	return s.tables[len(s.tables)-1].MaxUpdateIndex() + 1
+}
+
+// Addition adds a table to a Stack, holding the lock of tables.list so no
+// other table is added meanwhile.
+type Addition struct {
+	s           *Stack
+	lock        *lockFile
+	updateIndex uint64
+	refs        []*RefRecord
+	logs        []*LogRecord
+}
+
+// NewAddition locks the stack, and reloads it. The Addition must be closed
+// to release the lock, if it isn't committed.
+func (s *Stack) NewAddition() (*Addition, error) {
+	lock, err := s.lock(tablesListName, s.lockTimeout())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		_ = lock.Close()
+		return nil, err
+	}
+
+	return &Addition{s: s, lock: lock, updateIndex: s.nextUpdateIndex()}, nil
+}
+
+// UpdateIndex returns the update index of the records of the table added.
+func (a *Addition) UpdateIndex() uint64 {
+	return a.updateIndex
+}
+
+// AddRef adds the ref record to the table, with the update index of the
+// addition.
+func (a *Addition) AddRef(r *RefRecord) {
+	r.UpdateIndex = a.updateIndex
+	a.refs = append(a.refs, r)
+}
+
+// AddLog adds the log record to the table, with the update index of the
+// addition.
+func (a *Addition) AddLog(l *LogRecord) {
+	l.UpdateIndex = a.updateIndex
+	a.logs = append(a.logs, l)
+}
+
+// AddLogRecord adds the log record to the table with its own update index,
+// such as the deletion of a record of another table, or a record copied from
+// another reference.
+func (a *Addition) AddLogRecord(l *LogRecord) {
+	a.logs = append(a.logs, l)
+}
+
+// Commit writes the table, if any record was added, and adds it to
+// tables.list. The lock is then released, and the stack compacted unless
+// it's disabled.
+func (a *Addition) Commit() (err error) {
+	if a.lock == nil {
+		return ErrAdditionClosed
+	}
+
+	if len(a.refs) == 0 && len(a.logs) == 0 {
+		return a.Close()
+	}
+
+	sort.SliceStable(a.refs, func(i, j int) bool { return a.refs[i].Name < a.refs[j].Name })
+	sort.SliceStable(a.logs, func(i, j int) bool { return compareLogs(a.logs[i], a.logs[j]) < 0 })
+
+	s := a.s
+	name, err := s.writeTable(a.updateIndex, a.updateIndex, a.refs, a.logs)
+	if err == nil {
+		s.mu.Lock()
+		err = a.lock.commit(listContent(append(s.names[:len(s.names):len(s.names)], name)))
+		s.mu.Unlock()
+
+		if err != nil {
+			_ = s.fs.Remove(name)
+		}
+	}
+
+	if cerr := a.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil || s.options.DisableAutoCompact {
+		return err
+	}
+
+	if err := s.AutoCompact(); err != nil && !errors.Is(err, ErrLocked) {
+		return err
+	}
+
+	return nil
+}
+
+// Close releases the lock of tables.list, the addition is discarded if it
+// wasn't committed.
+func (a *Addition) Close() error {
+	if a.lock == nil {
+		return nil
+	}
+
+	lock := a.lock
+	a.lock = nil
+	return lock.Close()
+}
+
+// Compact merges all the tables of the stack into a single one.
+func (s *Stack) Compact() error {
+	return s.compact(func(sizes []int) (int, int) {
+		return 0, len(sizes)
+	})
+}
+
+// AutoCompact merges the newest tables of the stack so their sizes form a
+// geometric sequence, each table being at least twice as large as the next
+// one, as git does after adding a table.
+func (s *Stack) AutoCompact() error {
+	return s.compact(compactionSegment)
+}
+
+// compact merges the tables in the range returned by segment, given the
+// sizes of the tables. The tables merged are locked too, so they aren't
+// compacted by another process meanwhile.
+func (s *Stack) compact(segment func(sizes []int) (start, end int)) (err error) {
+	lock, err := s.lock(tablesListName, s.lockTimeout())
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(lock, &err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	sizes := make([]int, len(s.tables))
+	for i, t := range s.tables {
+		sizes[i] = t.Size() - headerSize(t.version) + 1
+	}
+
+	start, end := segment(sizes)
+	if end-start < 2 {
+		return nil
+	}
+
+	for _, name := range s.names[start:end] {
+		l, err := s.lock(name, 0)
+		if err != nil {
+			return err
+		}
+		defer ioutil.CheckClose(l, &err)
+	}
+
+	tables := s.tables[start:end]
+	refs, err := mergeRefs(tables, start == 0)
+	if err != nil {
+		return err
+	}
+
+	logs, err := mergeLogs(tables, start == 0)
+	if err != nil {
+		return err
+	}
+
+	name, err := s.writeTable(tables[0].MinUpdateIndex(), tables[len(tables)-1].MaxUpdateIndex(), refs, logs)
+	if err != nil {
+		return err
+	}
+
+	names := append(append(append([]string(nil), s.names[:start]...), name), s.names[end:]...)
+	if err := lock.commit(listContent(names)); err != nil {
+		_ = s.fs.Remove(name)
+		return err
+	}
+
+	for _, old := range s.names[start:end] {
+		// The table may still be read by another process, which reloads
+		// tables.list if so.
+		_ = s.fs.Remove(old)
+	}
+
+	return s.reload()
+}
+
+// compactionSegment returns the range of tables to merge for their sizes to
+// form a geometric sequence of factor 2, the same way as git.
+func compactionSegment(sizes []int) (start, end int) {
+	const factor = 2
+	if len(sizes) < 2 {
+		return 0, 0
+	}
+
+	// The end is the first table from the newest one smaller than half of
+	// the previous one.
+	var bytes int
+	i := len(sizes) - 1
+	for ; i > 0; i-- {
+		if sizes[i-1] < sizes[i]*factor {
+			end = i + 1
+			bytes = sizes[i]
+			break
+		}
+	}
+
+	// The start is the oldest table smaller than twice the tables after it,
+	// as they are merged.
+	start = end
+	for ; i > 0; i-- {
+		current := bytes
+		bytes += sizes[i-1]
+		if sizes[i-1] < current*factor {
+			start = i - 1
+		}
+	}
+
+	return start, end
+}
+
+// writeTable writes a table with the given records, and returns its name.
+func (s *Stack) writeTable(min, max uint64, refs []*RefRecord, logs []*LogRecord) (name string, err error) {
+	f, err := s.fs.TempFile("", "tmp_reftable_")
+	if err != nil {
+		return "", err
+	}
+
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			_ = s.fs.Remove(tmp)
+		}
+	}()
+
+	w := NewWriter(f, min, max, &WriterOptions{BlockSize: s.options.BlockSize})
+	for _, r := range refs {
+		if err := w.AddRef(r); err != nil {
+			_ = f.Close()
+			return "", err
+		}
+	}
+
+	for _, l := range logs {
+		if err := w.AddLog(l); err != nil {
+			_ = f.Close()
+			return "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	name = fmt.Sprintf("0x%012x-0x%012x-%08x.ref", min, max, rand.Uint32())
+	return name, s.fs.Rename(tmp, name)
+}
+
+func listContent(names []string) []byte {
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('\n')
+	}
+
+	return []byte(b.String())
+}
+
+func (s *Stack) lockTimeout() time.Duration {
+	if s.options.LockTimeout <= 0 {
+		return DefaultLockTimeout
+	}
+
+	return s.options.LockTimeout
+}
+
+// mergeRefs returns the newest ref record of each reference in the tables,
+// sorted by name. The deletions are dropped if drop is true.
+func mergeRefs(tables []*Table, drop bool) ([]*RefRecord, error) {
+	newest := make(map[plumbing.ReferenceName]*RefRecord)
+	for _, t := range tables {
+		refs, err := t.Refs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range refs {
+			newest[r.Name] = r
+		}
+	}
+
+	refs := make([]*RefRecord, 0, len(newest))
+	for _, r := range newest {
+		if drop && r.Type == RefDeletion {
+			continue
+		}
+
+		refs = append(refs, r)
+	}
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
+}
+
+// mergeLogs returns the log records in the tables, the newest of each key,
+// sorted by name and newest first. The deletions are dropped if drop is true.
+func mergeLogs(tables []*Table, drop bool) ([]*LogRecord, error) {
+	type key struct {
+		name        plumbing.ReferenceName
+		updateIndex uint64
+	}
+
+	newest := make(map[key]*LogRecord)
+	for _, t := range tables {
+		logs, err := t.Logs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range logs {
+			newest[key{l.Name, l.UpdateIndex}] = l
+		}
+	}
+
+	logs := make([]*LogRecord, 0, len(newest))
+	for _, l := range newest {
+		if drop && l.Deletion {
+			continue
+		}
+
+		logs = append(logs, l)
+	}
+
+	sort.Slice(logs, func(i, j int) bool { return compareLogs(logs[i], logs[j]) < 0 })
+	return logs, nil
+}
+
+// lockFile is a <name>.lock file, created exclusively to lock the file
+// <name>. Closing it removes it, releasing the lock, unless it was committed.
+type lockFile struct {
+	fs        billy.Filesystem
+	name      string
+	target    string
+	committed bool
+}
+
+// lock creates the lock file of the given file, waiting for up to timeout if
+// it already exists.
+func (s *Stack) lock(name string, timeout time.Duration) (*lockFile, error) {
+	lockName := name + lockSuffix
+	deadline := time.Now().Add(timeout)
+	backoff := time.Millisecond
+	for {
+		f, err := s.fs.OpenFile(lockName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			if err := f.Close(); err != nil {
+				_ = s.fs.Remove(lockName)
+				return nil, err
+			}
+
+			return &lockFile{fs: s.fs, name: lockName, target: name}, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, lockName)
+		}
+
+		time.Sleep(backoff)
+		if backoff < maxLockBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// commit writes content to the lock file and renames it over the locked
+// file.
+func (l *lockFile) commit(content []byte) error {
+	if err := util.WriteFile(l.fs, l.name, content, 0666); err != nil {
+		return err
+	}
+
+	if err := l.fs.Rename(l.name, l.target); err != nil {
+		return err
+	}
+
+	l.committed = true
+	return nil
+}
+
+// Close removes the lock file, unless it was committed.
+func (l *lockFile) Close() error {
+	if l.committed {
+		return nil
+	}
+
+	return l.fs.Remove(l.name)
+}
@@ -0,0 +1,158 @@
+package reftable_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	. "github.com/go-git/go-git/v5/plumbing/format/reftable"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	. "gopkg.in/check.v1"
+)
+
+func addRefs(c *C, s *Stack, refs ...*plumbing.Reference) {
+	a, err := s.NewAddition()
+	c.Assert(err, IsNil)
+	for _, ref := range refs {
+		a.AddRef(NewRefRecord(ref.Name(), ref, 0))
+	}
+	c.Assert(a.Commit(), IsNil)
+}
+
+func tablesList(c *C, fs billy.Filesystem) []string {
+	content, err := util.ReadFile(fs, "tables.list")
+	c.Assert(err, IsNil)
+	return strings.Fields(string(content))
+}
+
+func (s *ReftableSuite) TestStack(c *C) {
+	fs := memfs.New()
+	st := NewStack(fs, StackOptions{DisableAutoCompact: true})
+
+	_, err := st.Ref(plumbing.HEAD)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")
+	main := plumbing.NewReferenceFromStrings("refs/heads/main", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	foo := plumbing.NewReferenceFromStrings("refs/heads/foo", "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	addRefs(c, st, head, main)
+	addRefs(c, st, foo)
+
+	a, err := st.NewAddition()
+	c.Assert(err, IsNil)
+	c.Assert(a.UpdateIndex(), Equals, uint64(3))
+	a.AddRef(NewRefRecord("refs/heads/main", nil, 0))
+	c.Assert(a.Commit(), IsNil)
+
+	names := tablesList(c, fs)
+	c.Assert(names, HasLen, 3)
+	c.Assert(strings.HasPrefix(names[2], "0x000000000003-0x000000000003-"), Equals, true)
+
+	r, err := st.Ref(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(r.Reference(), DeepEquals, head)
+
+	_, err = st.Ref("refs/heads/main")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	refs, err := st.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 2)
+	c.Assert(refs[0].Reference(), DeepEquals, head)
+	c.Assert(refs[1].Reference(), DeepEquals, foo)
+
+	// Another stack on the same directory sees the tables added.
+	other := NewStack(fs, StackOptions{})
+	r, err = other.Ref("refs/heads/foo")
+	c.Assert(err, IsNil)
+	c.Assert(r.Reference(), DeepEquals, foo)
+
+	c.Assert(st.Compact(), IsNil)
+	names = tablesList(c, fs)
+	c.Assert(names, HasLen, 1)
+	c.Assert(strings.HasPrefix(names[0], "0x000000000001-0x000000000003-"), Equals, true)
+
+	files, err := fs.ReadDir("")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 2)
+
+	refs, err = other.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 2)
+	c.Assert(refs[1].Reference(), DeepEquals, foo)
+}
+
+func (s *ReftableSuite) TestStackAutoCompact(c *C) {
+	fs := memfs.New()
+	st := NewStack(fs, StackOptions{})
+
+	for i := 0; i < 64; i++ {
+		addRefs(c, st, plumbing.NewReferenceFromStrings(
+			fmt.Sprintf("refs/heads/branch-%d", i),
+			"6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		))
+	}
+
+	// The tables are compacted as they are added, the stack grows
+	// logarithmically.
+	names := tablesList(c, fs)
+	c.Assert(len(names) <= 7, Equals, true, Commentf("%d tables", len(names)))
+
+	refs, err := st.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 64)
+}
+
+func (s *ReftableSuite) TestStackLocked(c *C) {
+	fs := memfs.New()
+	st := NewStack(fs, StackOptions{LockTimeout: 10 * time.Millisecond})
+
+	a, err := st.NewAddition()
+	c.Assert(err, IsNil)
+
+	_, err = st.NewAddition()
+	c.Assert(errors.Is(err, ErrLocked), Equals, true)
+
+	c.Assert(a.Close(), IsNil)
+
+	a, err = st.NewAddition()
+	c.Assert(err, IsNil)
+	c.Assert(a.Close(), IsNil)
+
+	_, err = fs.Stat("tables.list.lock")
+	c.Assert(err, NotNil)
+}
+
+func (s *ReftableSuite) TestStackKeepsLogs(c *C) {
+	fs := memfs.New()
+	st := NewStack(fs, StackOptions{DisableAutoCompact: true})
+
+	main := plumbing.NewReferenceFromStrings("refs/heads/main", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	for i := 0; i < 2; i++ {
+		a, err := st.NewAddition()
+		c.Assert(err, IsNil)
+		a.AddRef(NewRefRecord(main.Name(), main, 0))
+		a.AddLog(&LogRecord{
+			Name:      main.Name(),
+			New:       main.Hash(),
+			Committer: "John Doe",
+			Email:     "john@example.com",
+			When:      time.Unix(1700000000+int64(i), 0).UTC(),
+			Message:   "update\n",
+		})
+		c.Assert(a.Commit(), IsNil)
+	}
+
+	c.Assert(st.Compact(), IsNil)
+
+	logs, err := st.Logs()
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].UpdateIndex, Equals, uint64(2))
+	c.Assert(logs[1].UpdateIndex, Equals, uint64(1))
+}
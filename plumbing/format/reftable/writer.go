@@ -0,0 +1,247 @@
+package reftable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// indexThreshold is the number of blocks of a section above which it is
+// indexed, the same as git's.
+const indexThreshold = 3
+
+// WriterOptions are the options of a Writer.
+type WriterOptions struct {
+	// BlockSize is the size of the blocks, up to MaxBlockSize. If left
+	// unset or set to 0, DefaultBlockSize is used.
+	BlockSize int
+}
+
+// Writer writes a reftable file. The refs are added first, sorted by name,
+// and then the logs, sorted by name and newest first.
+type Writer struct {
+	w         io.Writer
+	blockSize int
+	version   byte
+	hashID    uint32
+	min, max  uint64
+
+	next    uint64
+	block   *blockWriter
+	lastKey []byte
+	logs    bool
+	// index holds the last key and position of the blocks of the section
+	// being written.
+	index []indexEntry
+
+	refIndexPos, logPos, logIndexPos uint64
+}
+
+type indexEntry struct {
+	key      []byte
+	position uint64
+}
+
+// NewWriter returns a Writer of a table holding records whose update index
+// are in the given range.
+func NewWriter(w io.Writer, minUpdateIndex, maxUpdateIndex uint64, o *WriterOptions) *Writer {
+	blockSize := DefaultBlockSize
+	if o != nil && o.BlockSize > 0 && o.BlockSize <= MaxBlockSize {
+		blockSize = o.BlockSize
+	}
+
+	hashID, version := hashID()
+	return &Writer{
+		w:         w,
+		blockSize: blockSize,
+		version:   version,
+		hashID:    hashID,
+		min:       minUpdateIndex,
+		max:       maxUpdateIndex,
+	}
+}
+
+// AddRef adds a ref record to the table. The refs must be added before the
+// logs, sorted by name.
+func (w *Writer) AddRef(r *RefRecord) error {
+	if w.logs || r.UpdateIndex < w.min || r.UpdateIndex > w.max {
+		return ErrUnsorted
+	}
+
+	key, valueType, value := encodeRef(r, w.min)
+	return w.add(blockTypeRef, key, valueType, value)
+}
+
+// AddLog adds a log record to the table. The logs must be added after the
+// refs, sorted by name and newest first. As git does, their update index may
+// be out of the range of the table, such as the one of a log copied from
+// another reference.
+func (w *Writer) AddLog(l *LogRecord) error {
+	if !w.logs {
+		pos, err := w.finishSection()
+		if err != nil {
+			return err
+		}
+
+		w.refIndexPos = pos
+		w.logPos = w.next
+		w.logs = true
+		w.lastKey = nil
+	}
+
+	key, valueType, value := encodeLog(l)
+	return w.add(blockTypeLog, key, valueType, value)
+}
+
+func (w *Writer) add(typ byte, key []byte, valueType byte, value []byte) error {
+	if w.lastKey != nil && bytes.Compare(key, w.lastKey) <= 0 {
+		return ErrUnsorted
+	}
+
+	w.lastKey = append(w.lastKey[:0], key...)
+	return w.addToBlock(typ, key, valueType, value)
+}
+
+func (w *Writer) addToBlock(typ byte, key []byte, valueType byte, value []byte) error {
+	if w.block == nil {
+		w.newBlock(typ)
+	}
+
+	if w.block.add(key, valueType, value) {
+		return nil
+	}
+
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+
+	w.newBlock(typ)
+	if !w.block.add(key, valueType, value) {
+		return ErrRecordTooLarge
+	}
+
+	return nil
+}
+
+func (w *Writer) newBlock(typ byte) {
+	headerOff := 0
+	if w.next == 0 {
+		headerOff = headerSize(w.version)
+	}
+
+	w.block = newBlockWriter(typ, headerOff, w.blockSize)
+}
+
+// flushBlock writes the current block, padded unless it's a log block, and
+// adds it to the index.
+func (w *Writer) flushBlock() error {
+	b := w.block
+	w.block = nil
+	if b == nil || b.entries == 0 {
+		return nil
+	}
+
+	data, err := b.finish()
+	if err != nil {
+		return err
+	}
+
+	if b.headerOff != 0 {
+		copy(data, w.header())
+	}
+
+	if b.typ != blockTypeLog && len(data) < w.blockSize {
+		data = append(data, make([]byte, w.blockSize-len(data))...)
+	}
+
+	w.index = append(w.index, indexEntry{
+		key:      append([]byte(nil), b.lastKey...),
+		position: w.next,
+	})
+
+	return w.write(data)
+}
+
+// finishSection writes the last block of the section, and its index if it
+// has more blocks than indexThreshold. The position of the index, or 0, is
+// returned. The index has as many levels as needed for the top one to not
+// have more blocks than indexThreshold.
+func (w *Writer) finishSection() (uint64, error) {
+	if err := w.flushBlock(); err != nil {
+		return 0, err
+	}
+
+	var pos uint64
+	index := w.index
+	w.index = nil
+	for len(index) > indexThreshold {
+		pos = w.next
+		for _, e := range index {
+			key, valueType, value := encodeIndex(e.key, e.position)
+			if err := w.addToBlock(blockTypeIndex, key, valueType, value); err != nil {
+				return 0, err
+			}
+		}
+
+		if err := w.flushBlock(); err != nil {
+			return 0, err
+		}
+
+		index = w.index
+		w.index = nil
+	}
+
+	return pos, nil
+}
+
+// Close writes the last blocks, and the footer of the table.
+func (w *Writer) Close() error {
+	pos, err := w.finishSection()
+	if err != nil {
+		return err
+	}
+
+	if w.logs {
+		w.logIndexPos = pos
+	} else {
+		w.refIndexPos = pos
+	}
+
+	if w.next == 0 {
+		// an empty table
+		if err := w.write(w.header()); err != nil {
+			return err
+		}
+	}
+
+	footer := w.header()
+	footer = binary.BigEndian.AppendUint64(footer, w.refIndexPos)
+	footer = binary.BigEndian.AppendUint64(footer, 0) // obj blocks position
+	footer = binary.BigEndian.AppendUint64(footer, 0) // obj index position
+	footer = binary.BigEndian.AppendUint64(footer, w.logPos)
+	footer = binary.BigEndian.AppendUint64(footer, w.logIndexPos)
+	footer = binary.BigEndian.AppendUint32(footer, crc32.ChecksumIEEE(footer))
+
+	return w.write(footer)
+}
+
+func (w *Writer) header() []byte {
+	h := make([]byte, 0, headerSizeV2)
+	h = append(h, magic...)
+	h = append(h, w.version, 0, 0, 0)
+	putUint24(h[5:], uint32(w.blockSize))
+	h = binary.BigEndian.AppendUint64(h, w.min)
+	h = binary.BigEndian.AppendUint64(h, w.max)
+	if w.version == 2 {
+		h = binary.BigEndian.AppendUint32(h, w.hashID)
+	}
+
+	return h
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.next += uint64(n)
+	return err
+}
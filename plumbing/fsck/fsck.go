@@ -0,0 +1,520 @@
+// Package fsck checks the commits, trees and tags are well formed, in a
+// similar way as the git-fsck command. The problems are identified by the
+// message IDs of git, so their severity can be configured as the
+// fsck.<msg-id> options of git.
+package fsck
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+)
+
+// MsgID identifies a kind of problem, with the message ID used by git.
+type MsgID string
+
+const (
+	NulInHeader             MsgID = "nulInHeader"
+	UnterminatedHeader      MsgID = "unterminatedHeader"
+	BadDate                 MsgID = "badDate"
+	BadEmail                MsgID = "badEmail"
+	BadFilemode             MsgID = "badFilemode"
+	BadName                 MsgID = "badName"
+	BadObjectSha1           MsgID = "badObjectSha1"
+	BadParentSha1           MsgID = "badParentSha1"
+	BadTimezone             MsgID = "badTimezone"
+	BadTree                 MsgID = "badTree"
+	BadTreeSha1             MsgID = "badTreeSha1"
+	BadType                 MsgID = "badType"
+	DuplicateEntries        MsgID = "duplicateEntries"
+	MissingAuthor           MsgID = "missingAuthor"
+	MissingCommitter        MsgID = "missingCommitter"
+	MissingEmail            MsgID = "missingEmail"
+	MissingNameBeforeEmail  MsgID = "missingNameBeforeEmail"
+	MissingObject           MsgID = "missingObject"
+	MissingSpaceBeforeDate  MsgID = "missingSpaceBeforeDate"
+	MissingSpaceBeforeEmail MsgID = "missingSpaceBeforeEmail"
+	MissingTagEntry         MsgID = "missingTagEntry"
+	MissingTree             MsgID = "missingTree"
+	MissingTypeEntry        MsgID = "missingTypeEntry"
+	TreeNotSorted           MsgID = "treeNotSorted"
+	ZeroPaddedDate          MsgID = "zeroPaddedDate"
+	EmptyName               MsgID = "emptyName"
+	FullPathname            MsgID = "fullPathname"
+	HasDot                  MsgID = "hasDot"
+	HasDotdot               MsgID = "hasDotdot"
+	HasDotgit               MsgID = "hasDotgit"
+	NullSha1                MsgID = "nullSha1"
+	ZeroPaddedFilemode      MsgID = "zeroPaddedFilemode"
+)
+
+// defaultWarnings are the problems git only warns about by default, all the
+// others are errors.
+var defaultWarnings = map[MsgID]bool{
+	EmptyName:          true,
+	FullPathname:       true,
+	HasDot:             true,
+	HasDotdot:          true,
+	HasDotgit:          true,
+	NullSha1:           true,
+	ZeroPaddedFilemode: true,
+}
+
+// Severity is how a problem is handled.
+type Severity int8
+
+const (
+	// Ignore skips the problem.
+	Ignore Severity = iota
+	// Warn reports the problem, without rejecting the object.
+	Warn
+	// Error rejects the object.
+	Error
+)
+
+// ParseSeverity parses the severity, as written in the fsck.<msg-id>
+// options of git: "ignore", "warn" or "error".
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "ignore":
+		return Ignore, nil
+	case "warn":
+		return Warn, nil
+	case "error":
+		return Error, nil
+	default:
+		return Ignore, fmt.Errorf("invalid fsck severity: %q", s)
+	}
+}
+
+func (s Severity) String() string {
+	switch s {
+	case Ignore:
+		return "ignore"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Problem is a problem found in an object.
+type Problem struct {
+	ID       MsgID
+	Severity Severity
+	// Message details the problem.
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.ID, p.Message)
+}
+
+// Checker checks the objects are well formed.
+type Checker struct {
+	// Strict makes the problems git only warns about by default errors, and
+	// reports the group writable files, as `git fsck --strict` does.
+	Strict bool
+	// Severities overrides the severity of the problems.
+	Severities map[MsgID]Severity
+}
+
+// Severity returns the severity of the given problem.
+func (c *Checker) Severity(id MsgID) Severity {
+	if s, ok := c.Severities[id]; ok {
+		return s
+	}
+
+	if defaultWarnings[id] && !c.Strict {
+		return Warn
+	}
+
+	return Error
+}
+
+// Check checks the content of the commit, tree or tag is well formed. It
+// returns the objects the object references, and the problems found, which
+// end with the first error, if any.
+func (c *Checker) Check(typ plumbing.ObjectType, content []byte) ([]plumbing.Hash, []Problem) {
+	o := &objectCheck{c: c}
+	var links []plumbing.Hash
+	switch typ {
+	case plumbing.CommitObject:
+		links = o.commit(content)
+	case plumbing.TreeObject:
+		links = o.tree(content)
+	case plumbing.TagObject:
+		links = o.tag(content)
+	}
+
+	return links, o.problems
+}
+
+// FirstError returns the first problem which is an error, if any.
+func FirstError(problems []Problem) (Problem, bool) {
+	for _, p := range problems {
+		if p.Severity == Error {
+			return p, true
+		}
+	}
+
+	return Problem{}, false
+}
+
+// CheckEntryName checks the name of a tree entry, returning why it is
+// invalid, as git does: it must not be empty, hold a slash or a NUL, or be
+// ".", ".." or ".git", even disguised as .git on HFS+ or NTFS. The message
+// is empty if the name is valid.
+func CheckEntryName(name string) (MsgID, string) {
+	switch {
+	case name == "":
+		return EmptyName, "empty name"
+	case strings.IndexByte(name, '/') >= 0:
+		return FullPathname, fmt.Sprintf("full path name %q", name)
+	case strings.IndexByte(name, 0) >= 0:
+		return BadTree, fmt.Sprintf("name with NUL %q", name)
+	case name == ".":
+		return HasDot, fmt.Sprintf("entry named %q", name)
+	case name == "..":
+		return HasDotdot, fmt.Sprintf("entry named %q", name)
+	case strings.EqualFold(name, ".git"):
+		return HasDotgit, "entry named .git"
+	case isHFSDotGit(name), isNTFSDotGit(name):
+		return HasDotgit, fmt.Sprintf("entry %q disguising .git", name)
+	}
+
+	return "", ""
+}
+
+// objectCheck holds the problems found checking an object.
+type objectCheck struct {
+	c        *Checker
+	problems []Problem
+}
+
+// report records the problem, unless ignored, and returns whether it is an
+// error, so the check stops.
+func (o *objectCheck) report(id MsgID, format string, args ...interface{}) bool {
+	s := o.c.Severity(id)
+	if s == Ignore {
+		return false
+	}
+
+	o.problems = append(o.problems, Problem{
+		ID:       id,
+		Severity: s,
+		Message:  fmt.Sprintf(format, args...),
+	})
+
+	return s == Error
+}
+
+// header returns the lines of the header of a commit or a tag, or false if
+// it can't be parsed.
+func (o *objectCheck) header(content []byte) ([]string, bool) {
+	header := content
+	if i := bytes.Index(content, []byte("\n\n")); i >= 0 {
+		header = content[:i+1]
+	} else if len(content) == 0 || content[len(content)-1] != '\n' {
+		o.report(UnterminatedHeader, "unterminated header")
+		return nil, false
+	}
+
+	if bytes.IndexByte(header, 0) >= 0 {
+		o.report(NulInHeader, "NUL byte in the header")
+		return nil, false
+	}
+
+	return strings.Split(string(header[:len(header)-1]), "\n"), true
+}
+
+// headerHash parses the hash of the header line with the given key.
+func headerHash(line, key string) (plumbing.Hash, bool) {
+	value, ok := strings.CutPrefix(line, key+" ")
+	if !ok || len(value) != hash.HexSize || !plumbing.IsHash(value) {
+		return plumbing.ZeroHash, false
+	}
+
+	return plumbing.NewHash(value), true
+}
+
+func (o *objectCheck) commit(content []byte) []plumbing.Hash {
+	lines, ok := o.header(content)
+	if !ok {
+		return nil
+	}
+
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "tree ") {
+		o.report(MissingTree, "missing tree")
+		return nil
+	}
+
+	tree, ok := headerHash(lines[0], "tree")
+	if !ok {
+		o.report(BadTreeSha1, "invalid tree")
+		return nil
+	}
+
+	links := []plumbing.Hash{tree}
+	i := 1
+	for ; i < len(lines) && strings.HasPrefix(lines[i], "parent "); i++ {
+		parent, ok := headerHash(lines[i], "parent")
+		if !ok {
+			if o.report(BadParentSha1, "invalid parent") {
+				return links
+			}
+
+			continue
+		}
+
+		links = append(links, parent)
+	}
+
+	for _, key := range []struct {
+		name    string
+		missing MsgID
+	}{{"author", MissingAuthor}, {"committer", MissingCommitter}} {
+		if i >= len(lines) || !strings.HasPrefix(lines[i], key.name+" ") {
+			if o.report(key.missing, "missing %s", key.name) {
+				return links
+			}
+
+			continue
+		}
+
+		if o.ident(key.name, lines[i][len(key.name)+1:]) {
+			return links
+		}
+
+		i++
+	}
+
+	return links
+}
+
+func (o *objectCheck) tag(content []byte) []plumbing.Hash {
+	lines, ok := o.header(content)
+	if !ok {
+		return nil
+	}
+
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "object ") {
+		o.report(MissingObject, "missing object")
+		return nil
+	}
+
+	target, ok := headerHash(lines[0], "object")
+	if !ok {
+		o.report(BadObjectSha1, "invalid object")
+		return nil
+	}
+
+	links := []plumbing.Hash{target}
+	if len(lines) < 2 || !strings.HasPrefix(lines[1], "type ") {
+		o.report(MissingTypeEntry, "missing type")
+		return links
+	}
+
+	if typ, err := plumbing.ParseObjectType(lines[1][len("type "):]); err != nil || !typ.Valid() {
+		if o.report(BadType, "invalid type") {
+			return links
+		}
+	}
+
+	if len(lines) < 3 || !strings.HasPrefix(lines[2], "tag ") || len(lines[2]) == len("tag ") {
+		o.report(MissingTagEntry, "missing tag name")
+		return links
+	}
+
+	// The tagger is optional, as some old tags don't have it.
+	if len(lines) > 3 && strings.HasPrefix(lines[3], "tagger ") {
+		o.ident("tagger", lines[3][len("tagger "):])
+	}
+
+	return links
+}
+
+// ident checks the identity of an author, committer or tagger is of the form
+// "Name <email> timestamp timezone", and returns whether an error was found.
+func (o *objectCheck) ident(key, s string) bool {
+	report := func(id MsgID, msg string) bool {
+		return o.report(id, "invalid %s: %s", key, msg)
+	}
+
+	lt := strings.IndexByte(s, '<')
+	switch {
+	case lt < 0:
+		return report(MissingEmail, "missing email")
+	case lt == 0:
+		return report(MissingNameBeforeEmail, "missing name before email")
+	case strings.IndexByte(s[:lt], '>') >= 0:
+		return report(BadName, "bad name")
+	case s[lt-1] != ' ':
+		return report(MissingSpaceBeforeEmail, "missing space before email")
+	}
+
+	gt := strings.IndexByte(s[lt+1:], '>')
+	if gt < 0 || strings.IndexByte(s[lt+1:lt+1+gt], '<') >= 0 {
+		return report(BadEmail, "bad email")
+	}
+
+	rest, ok := strings.CutPrefix(s[lt+1+gt+1:], " ")
+	if !ok {
+		return report(MissingSpaceBeforeDate, "missing space before date")
+	}
+
+	date, tz, ok := strings.Cut(rest, " ")
+	if !ok || date == "" || strings.Trim(date, "0123456789") != "" {
+		return report(BadDate, "bad date")
+	}
+
+	if len(date) > 1 && date[0] == '0' {
+		if report(ZeroPaddedDate, "zero padded date") {
+			return true
+		}
+	}
+
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') || strings.Trim(tz[1:], "0123456789") != "" {
+		return report(BadTimezone, "bad timezone")
+	}
+
+	return false
+}
+
+// tree checks the entries of the tree are well formed and sorted.
+func (o *objectCheck) tree(content []byte) []plumbing.Hash {
+	var links []plumbing.Hash
+	names := make(map[string]bool)
+	var last string
+	for len(content) > 0 {
+		sp := bytes.IndexByte(content, ' ')
+		nul := bytes.IndexByte(content, 0)
+		if sp <= 0 || nul < sp || len(content) < nul+1+hash.Size {
+			o.report(BadTree, "malformed entry")
+			return links
+		}
+
+		mode, name := string(content[:sp]), string(content[sp+1:nul])
+		var h plumbing.Hash
+		copy(h[:], content[nul+1:nul+1+hash.Size])
+		content = content[nul+1+hash.Size:]
+
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			o.report(BadTree, "malformed mode %q", mode)
+			return links
+		}
+
+		fm := filemode.FileMode(m)
+		if fm != filemode.Submodule {
+			links = append(links, h)
+		}
+
+		if o.treeEntry(mode, fm, name, h) {
+			return links
+		}
+
+		if names[name] {
+			if o.report(DuplicateEntries, "duplicate entry %q", name) {
+				return links
+			}
+		}
+
+		names[name] = true
+		key := name
+		if fm == filemode.Dir {
+			key += "/"
+		}
+
+		if len(names) > 1 && key <= last {
+			if o.report(TreeNotSorted, "entries not properly sorted") {
+				return links
+			}
+		}
+
+		last = key
+	}
+
+	return links
+}
+
+// treeEntry checks the mode, name and hash of a tree entry, and returns
+// whether an error was found.
+func (o *objectCheck) treeEntry(mode string, m filemode.FileMode, name string, h plumbing.Hash) bool {
+	switch m {
+	case filemode.Regular, filemode.Executable, filemode.Symlink, filemode.Dir, filemode.Submodule:
+	case filemode.Deprecated:
+		if o.c.Strict && o.report(BadFilemode, "bad file mode %s of %q", mode, name) {
+			return true
+		}
+	default:
+		if o.report(BadFilemode, "bad file mode %s of %q", mode, name) {
+			return true
+		}
+	}
+
+	if mode[0] == '0' && o.report(ZeroPaddedFilemode, "zero padded file mode of %q", name) {
+		return true
+	}
+
+	if h.IsZero() && o.report(NullSha1, "null hash of %q", name) {
+		return true
+	}
+
+	if id, msg := CheckEntryName(name); msg != "" {
+		return o.report(id, "%s", msg)
+	}
+
+	return false
+}
+
+// isHFSDotGit returns whether the name refers to .git on HFS+, which ignores
+// the case and some Unicode code points.
+func isHFSDotGit(name string) bool {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 0x200c && r <= 0x200f, r >= 0x202a && r <= 0x202e,
+			r >= 0x206a && r <= 0x206f, r == 0xfeff:
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return strings.EqualFold(b.String(), ".git")
+}
+
+// isNTFSDotGit returns whether the name refers to .git on NTFS, which ignores
+// the trailing spaces and dots, the alternate data streams, and accepts the
+// short name git~1.
+func isNTFSDotGit(name string) bool {
+	var rest string
+	switch {
+	case len(name) >= 4 && strings.EqualFold(name[:4], ".git"):
+		rest = name[4:]
+	case len(name) >= 5 && strings.EqualFold(name[:5], "git~1"):
+		rest = name[5:]
+	default:
+		return false
+	}
+
+	for _, r := range rest {
+		switch r {
+		case ':', '\\':
+			return true
+		case '.', ' ':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
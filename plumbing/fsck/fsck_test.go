@@ -0,0 +1,124 @@
+package fsck_test
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/fsck"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type FsckSuite struct{}
+
+var _ = Suite(&FsckSuite{})
+
+const tree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+func (s *FsckSuite) TestCheckCommit(c *C) {
+	checker := &fsck.Checker{}
+	content := "tree " + tree + "\nauthor foo <foo@foo.foo> 1600000000 +0200\n" +
+		"committer foo <foo@foo.foo> 1600000000 +0200\n\nmessage\n"
+	links, problems := checker.Check(plumbing.CommitObject, []byte(content))
+	c.Assert(links, DeepEquals, []plumbing.Hash{plumbing.NewHash(tree)})
+	c.Assert(problems, HasLen, 0)
+
+	content = "tree " + tree + "\nauthor foo <foo 1600000000 +0200\n" +
+		"committer foo <foo@foo.foo> 01600000000 +0200\n\nmessage\n"
+	_, problems = checker.Check(plumbing.CommitObject, []byte(content))
+	c.Assert(problems, DeepEquals, []fsck.Problem{
+		{ID: fsck.BadEmail, Severity: fsck.Error, Message: "invalid author: bad email"},
+	})
+
+	checker.Severities = map[fsck.MsgID]fsck.Severity{fsck.BadEmail: fsck.Warn}
+	_, problems = checker.Check(plumbing.CommitObject, []byte(content))
+	c.Assert(problems, DeepEquals, []fsck.Problem{
+		{ID: fsck.BadEmail, Severity: fsck.Warn, Message: "invalid author: bad email"},
+		{ID: fsck.ZeroPaddedDate, Severity: fsck.Error, Message: "invalid committer: zero padded date"},
+	})
+
+	checker.Severities[fsck.ZeroPaddedDate] = fsck.Ignore
+	_, problems = checker.Check(plumbing.CommitObject, []byte(content))
+	_, ok := fsck.FirstError(problems)
+	c.Assert(ok, Equals, false)
+}
+
+func (s *FsckSuite) TestCheckTree(c *C) {
+	h := plumbing.NewHash(tree)
+	entry := func(mode, name string) string {
+		return mode + " " + name + "\x00" + string(h[:])
+	}
+
+	content := []byte(entry("040000", ".git") + entry("100644", "a") + entry("100644", "a"))
+
+	checker := &fsck.Checker{}
+	links, problems := checker.Check(plumbing.TreeObject, content)
+	c.Assert(links, DeepEquals, []plumbing.Hash{h, h, h})
+	c.Assert(problems, DeepEquals, []fsck.Problem{
+		{ID: fsck.ZeroPaddedFilemode, Severity: fsck.Warn, Message: `zero padded file mode of ".git"`},
+		{ID: fsck.HasDotgit, Severity: fsck.Warn, Message: "entry named .git"},
+		{ID: fsck.DuplicateEntries, Severity: fsck.Error, Message: `duplicate entry "a"`},
+	})
+
+	checker = &fsck.Checker{Strict: true}
+	_, problems = checker.Check(plumbing.TreeObject, content)
+	c.Assert(problems, DeepEquals, []fsck.Problem{
+		{ID: fsck.ZeroPaddedFilemode, Severity: fsck.Error, Message: `zero padded file mode of ".git"`},
+	})
+
+	checker.Severities = map[fsck.MsgID]fsck.Severity{fsck.ZeroPaddedFilemode: fsck.Ignore, fsck.HasDotgit: fsck.Ignore}
+	_, problems = checker.Check(plumbing.TreeObject, content)
+	c.Assert(problems, DeepEquals, []fsck.Problem{
+		{ID: fsck.DuplicateEntries, Severity: fsck.Error, Message: `duplicate entry "a"`},
+	})
+}
+
+func (s *FsckSuite) TestCheckTag(c *C) {
+	checker := &fsck.Checker{}
+	content := "object " + tree + "\ntype foo\ntag v1\n\nv1\n"
+	links, problems := checker.Check(plumbing.TagObject, []byte(content))
+	c.Assert(links, DeepEquals, []plumbing.Hash{plumbing.NewHash(tree)})
+	c.Assert(problems, DeepEquals, []fsck.Problem{
+		{ID: fsck.BadType, Severity: fsck.Error, Message: "invalid type"},
+	})
+
+	_, problems = checker.Check(plumbing.TagObject, []byte("object "+tree+"\n"))
+	c.Assert(problems, DeepEquals, []fsck.Problem{
+		{ID: fsck.MissingTypeEntry, Severity: fsck.Error, Message: "missing type"},
+	})
+}
+
+func (s *FsckSuite) TestCheckEntryName(c *C) {
+	for name, id := range map[string]fsck.MsgID{
+		"":           fsck.EmptyName,
+		"a/b":        fsck.FullPathname,
+		".":          fsck.HasDot,
+		"..":         fsck.HasDotdot,
+		".GIT":       fsck.HasDotgit,
+		".git\u200c": fsck.HasDotgit,
+		"git~1":      fsck.HasDotgit,
+		".git. .":    fsck.HasDotgit,
+		"a":          "",
+		".gitignore": "",
+	} {
+		got, _ := fsck.CheckEntryName(name)
+		c.Assert(got, Equals, id, Commentf("name %q", name))
+	}
+}
+
+func (s *FsckSuite) TestParseSeverity(c *C) {
+	for _, sev := range []fsck.Severity{fsck.Ignore, fsck.Warn, fsck.Error} {
+		parsed, err := fsck.ParseSeverity(sev.String())
+		c.Assert(err, IsNil)
+		c.Assert(parsed, Equals, sev)
+	}
+
+	parsed, err := fsck.ParseSeverity("WARN")
+	c.Assert(err, IsNil)
+	c.Assert(parsed, Equals, fsck.Warn)
+
+	_, err = fsck.ParseSeverity("fatal")
+	c.Assert(err, NotNil)
+}
@@ -4,6 +4,7 @@ package hash
 
 import (
 	"crypto"
+	"crypto/sha1"
 	"fmt"
 	"hash"
 
@@ -58,3 +59,16 @@ func New(h crypto.Hash) Hash {
 	}
 	return hh()
 }
+
+// NewChecksum returns a new Hash for the given hash function, used for the
+// trailing checksums of the files, such as the index, which only detect their
+// corruption. As git does since 2.47, the SHA-1 checksums don't use the
+// collision detection of the default SHA-1 implementation, which is several
+// times slower.
+func NewChecksum(h crypto.Hash) Hash {
+	if h == crypto.SHA1 {
+		return sha1.New()
+	}
+
+	return New(h)
+}
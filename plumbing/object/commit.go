@@ -3,14 +3,19 @@ package object
 import (
 	"bytes"
 	"context"
+	"crypto"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/hash"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 	"github.com/go-git/go-git/v5/utils/sync"
@@ -20,6 +25,7 @@ const (
 	beginpgp       string = "-----BEGIN PGP SIGNATURE-----"
 	endpgp         string = "-----END PGP SIGNATURE-----"
 	headerpgp      string = "gpgsig"
+	headerpgp256   string = "gpgsig-sha256"
 	headerencoding string = "encoding"
 
 	// https://github.com/git/git/blob/bcb6cae2966cc407ca1afc77413b3ef11103c175/Documentation/gitformat-signature.txt#L153
@@ -258,7 +264,7 @@ func (c *Commit) Decode(o plumbing.EncodedObject) (err error) {
 				mergetag = true
 			case headerencoding:
 				c.Encoding = MessageEncoding(data)
-			case headerpgp:
+			case signatureHeader():
 				c.PGPSignature += string(data) + "\n"
 				pgpsig = true
 			}
@@ -274,6 +280,17 @@ func (c *Commit) Decode(o plumbing.EncodedObject) (err error) {
 	return nil
 }
 
+// signatureHeader returns the header of the signature of a commit, which
+// signs the SHA-256 version of the commit in gpgsig-sha256 with SHA-256
+// objects.
+func signatureHeader() string {
+	if hash.CryptoType == crypto.SHA256 {
+		return headerpgp256
+	}
+
+	return headerpgp
+}
+
 // Encode transforms a Commit into a plumbing.EncodedObject.
 func (c *Commit) Encode(o plumbing.EncodedObject) error {
 	return c.encode(o, true)
@@ -319,6 +336,14 @@ func (c *Commit) encode(o plumbing.EncodedObject, includeSig bool) (err error) {
 		return err
 	}
 
+	// The encoding header comes before the other ones, as git writes it, so
+	// the hash of the decoded commits is preserved.
+	if string(c.Encoding) != "" && c.Encoding != defaultUtf8CommitMessageEncoding {
+		if _, err = fmt.Fprintf(w, "\n%s %s", headerencoding, c.Encoding); err != nil {
+			return err
+		}
+	}
+
 	if c.MergeTag != "" {
 		if _, err = fmt.Fprint(w, "\n"+headermergetag+" "); err != nil {
 			return err
@@ -335,14 +360,8 @@ func (c *Commit) encode(o plumbing.EncodedObject, includeSig bool) (err error) {
 		}
 	}
 
-	if string(c.Encoding) != "" && c.Encoding != defaultUtf8CommitMessageEncoding {
-		if _, err = fmt.Fprintf(w, "\n%s %s", headerencoding, c.Encoding); err != nil {
-			return err
-		}
-	}
-
 	if c.PGPSignature != "" && includeSig {
-		if _, err = fmt.Fprint(w, "\n"+headerpgp+" "); err != nil {
+		if _, err = fmt.Fprint(w, "\n"+signatureHeader()+" "); err != nil {
 			return err
 		}
 
@@ -431,6 +450,46 @@ func (c *Commit) Verify(armoredKeyRing string) (*openpgp.Entity, error) {
 	return openpgp.CheckArmoredDetachedSignature(keyring, er, signature, nil)
 }
 
+// ErrUnknownEncoding is returned when the encoding of a commit message is not
+// known, or not supported.
+var ErrUnknownEncoding = errors.New("unknown message encoding")
+
+// UTF8Message returns the message of the commit transcoded to UTF-8 from the
+// encoding given by its encoding header, such as ISO-2022-JP, as git log
+// does. The message is returned unchanged if the commit has no encoding
+// header or if it is UTF-8 already.
+func (c *Commit) UTF8Message() (string, error) {
+	if isUTF8Encoding(c.Encoding) {
+		return c.Message, nil
+	}
+
+	enc, err := messageEncoding(c.Encoding)
+	if err != nil {
+		return "", err
+	}
+
+	return enc.NewDecoder().String(c.Message)
+}
+
+func isUTF8Encoding(e MessageEncoding) bool {
+	return e == "" || strings.EqualFold(string(e), "utf-8") || strings.EqualFold(string(e), "utf8")
+}
+
+// messageEncoding returns the encoding with the given IANA name, or label
+// of the WHATWG Encoding Standard, such as "latin1", which git, through
+// iconv, accepts too.
+func messageEncoding(name MessageEncoding) (encoding.Encoding, error) {
+	if enc, err := ianaindex.IANA.Encoding(string(name)); err == nil && enc != nil {
+		return enc, nil
+	}
+
+	if enc, err := htmlindex.Get(string(name)); err == nil {
+		return enc, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownEncoding, name)
+}
+
 // Less defines a compare function to determine which commit is 'earlier' by:
 // - First use Committer.When
 // - If Committer.When are equal then use Author.When
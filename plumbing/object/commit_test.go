@@ -3,6 +3,7 @@ package object
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -13,6 +14,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/cache"
 
 	"github.com/go-git/go-git/v5/storage/filesystem"
+	"golang.org/x/text/encoding/japanese"
 	. "gopkg.in/check.v1"
 )
 
@@ -281,6 +283,68 @@ change
 	}
 }
 
+func (s *SuiteCommit) TestCommitEncodingRoundTrip(c *C) {
+	msg, err := japanese.ISO2022JP.NewEncoder().String("日本語のメッセージ\n")
+	c.Assert(err, IsNil)
+
+	// The headers are in the order git writes them.
+	raw := "tree f000000000000000000000000000000000000001\n" +
+		"parent f000000000000000000000000000000000000002\n" +
+		"parent f000000000000000000000000000000000000003\n" +
+		"author Foo <foo@example.local> 1136239445 -0700\n" +
+		"committer Bar <bar@example.local> 1136239445 -0700\n" +
+		"encoding ISO-2022-JP\n" +
+		"mergetag object f000000000000000000000000000000000000003\n" +
+		" type commit\n" +
+		" tag change\n" +
+		" tagger Foo <foo@example.local> 1695827841 -0400\n" +
+		" \n" +
+		" change\n" +
+		"\n" + msg
+
+	obj := &plumbing.MemoryObject{}
+	obj.SetType(plumbing.CommitObject)
+	_, err = obj.Write([]byte(raw))
+	c.Assert(err, IsNil)
+
+	commit := &Commit{}
+	c.Assert(commit.Decode(obj), IsNil)
+	c.Assert(commit.Encoding, Equals, MessageEncoding("ISO-2022-JP"))
+	c.Assert(commit.Message, Equals, msg)
+
+	encoded := &plumbing.MemoryObject{}
+	c.Assert(commit.Encode(encoded), IsNil)
+	c.Assert(encoded.Hash(), Equals, obj.Hash())
+
+	utf8, err := commit.UTF8Message()
+	c.Assert(err, IsNil)
+	c.Assert(utf8, Equals, "日本語のメッセージ\n")
+}
+
+func (s *SuiteCommit) TestUTF8Message(c *C) {
+	for _, t := range []struct {
+		encoding MessageEncoding
+		message  string
+		expected string
+	}{
+		{"", "caf\xc3\xa9", "caf\xc3\xa9"},
+		{"utf8", "caf\xc3\xa9", "caf\xc3\xa9"},
+		{"ISO-8859-1", "caf\xe9", "caf\xc3\xa9"},
+		{"latin1", "caf\xe9", "caf\xc3\xa9"},
+		{"Shift_JIS", "\x93\xfa\x96\x7b", "日本"},
+		{"GBK", "\xd6\xd0\xce\xc4", "中文"},
+	} {
+		commit := &Commit{Message: t.message, Encoding: t.encoding}
+		msg, err := commit.UTF8Message()
+		c.Assert(err, IsNil, Commentf("%s", t.encoding))
+		c.Assert(msg, Equals, t.expected, Commentf("%s", t.encoding))
+	}
+
+	commit := &Commit{Message: "foo", Encoding: "no-such-encoding"}
+	_, err := commit.UTF8Message()
+	c.Assert(errors.Is(err, ErrUnknownEncoding), Equals, true)
+}
+
 func (s *SuiteCommit) TestFile(c *C) {
 	file, err := s.Commit.File("CHANGELOG")
 	c.Assert(err, IsNil)
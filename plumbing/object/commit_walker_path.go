@@ -9,6 +9,7 @@ import (
 
 type commitPathIter struct {
 	pathFilter    func(string) bool
+	mayChange     func(*Commit) bool
 	sourceIter    CommitIter
 	currentCommit *Commit
 	checkParent   bool
@@ -28,6 +29,18 @@ func NewCommitPathIterFromIter(pathFilter func(string) bool, commitIter CommitIt
 	return iterator
 }
 
+// NewCommitPathIterFromIterWithHint is NewCommitPathIterFromIter, with
+// mayChange telling whether a commit may change a path matching pathFilter
+// compared to its first parent, such as the changed-path Bloom filters of a
+// commit-graph do. The commits for which it returns false are not diffed, so
+// it must return true for all the commits changing such a path.
+func NewCommitPathIterFromIterWithHint(pathFilter func(string) bool, mayChange func(*Commit) bool,
+	commitIter CommitIter, checkParent bool) CommitIter {
+	iterator := NewCommitPathIterFromIter(pathFilter, commitIter, checkParent).(*commitPathIter)
+	iterator.mayChange = mayChange
+	return iterator
+}
+
 // NewCommitFileIterFromIter is kept for compatibility, can be replaced with NewCommitPathIterFromIter
 func NewCommitFileIterFromIter(fileName string, commitIter CommitIter, checkParent bool) CommitIter {
 	return NewCommitPathIterFromIter(
@@ -70,6 +83,16 @@ func (c *commitPathIter) getNextFileCommit() (*Commit, error) {
 			parentCommit = nil
 		}
 
+		if c.isUnchanged(parentCommit) {
+			c.currentCommit = parentCommit
+			parentTree = nil
+			if parentCommit == nil {
+				return nil, io.EOF
+			}
+
+			continue
+		}
+
 		if parentTree == nil {
 			var currTreeErr error
 			currentTree, currTreeErr = c.currentCommit.Tree()
@@ -113,6 +136,26 @@ func (c *commitPathIter) getNextFileCommit() (*Commit, error) {
 	}
 }
 
+// isUnchanged returns true if mayChange tells the current commit does not
+// change any path matching pathFilter, as long as the commit it is diffed
+// with is its first parent.
+func (c *commitPathIter) isUnchanged(parent *Commit) bool {
+	if c.mayChange == nil {
+		return false
+	}
+
+	parents := c.currentCommit.ParentHashes
+	if parent == nil {
+		if len(parents) != 0 {
+			return false
+		}
+	} else if len(parents) == 0 || parents[0] != parent.Hash {
+		return false
+	}
+
+	return !c.mayChange(c.currentCommit)
+}
+
 func (c *commitPathIter) hasFileChange(changes Changes, parent *Commit) bool {
 	for _, change := range changes {
 		if !c.pathFilter(change.name()) {
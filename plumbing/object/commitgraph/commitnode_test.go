@@ -8,6 +8,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	commitgraph "github.com/go-git/go-git/v5/plumbing/format/commitgraph/v2"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
@@ -151,3 +152,69 @@ func (s *CommitNodeSuite) TestMixedGraph(c *C) {
 	testParents(c, nodeIndex)
 	testCommitAndTree(c, nodeIndex)
 }
+
+func (s *CommitNodeSuite) TestBuildMemoryIndex(c *C) {
+	f := fixtures.ByTag("commit-graph").One()
+	storer := unpackRepository(f)
+	reader, err := storer.Filesystem().Open(path.Join("objects", "info", "commit-graph"))
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	expected, err := commitgraph.OpenFileIndex(reader)
+	c.Assert(err, IsNil)
+
+	idx, err := BuildMemoryIndex(storer)
+	c.Assert(err, IsNil)
+	hashes, expectedHashes := idx.Hashes(), expected.Hashes()
+	plumbing.HashesSort(hashes)
+	plumbing.HashesSort(expectedHashes)
+	c.Assert(hashes, DeepEquals, expectedHashes)
+
+	for _, h := range expected.Hashes() {
+		i, err := idx.GetIndexByHash(h)
+		c.Assert(err, IsNil)
+		data, err := idx.GetCommitDataByIndex(i)
+		c.Assert(err, IsNil)
+
+		i, err = expected.GetIndexByHash(h)
+		c.Assert(err, IsNil)
+		expectedData, err := expected.GetCommitDataByIndex(i)
+		c.Assert(err, IsNil)
+
+		c.Assert(data.TreeHash, Equals, expectedData.TreeHash)
+		c.Assert(data.ParentHashes, HasLen, len(expectedData.ParentHashes))
+		for j, p := range expectedData.ParentHashes {
+			c.Assert(data.ParentHashes[j], Equals, p)
+		}
+
+		c.Assert(data.Generation, Equals, expectedData.Generation)
+		c.Assert(data.When.Unix(), Equals, expectedData.When.Unix())
+	}
+
+	testWalker(c, NewGraphCommitNodeIndex(idx, storer))
+	testParents(c, NewGraphCommitNodeIndex(idx, storer))
+}
+
+func (s *CommitNodeSuite) TestAddBloomFilters(c *C) {
+	f := fixtures.ByTag("commit-graph").One()
+	storer := unpackRepository(f)
+
+	idx, err := BuildMemoryIndex(storer)
+	c.Assert(err, IsNil)
+	c.Assert(AddBloomFilters(idx, storer), IsNil)
+
+	for _, h := range idx.Hashes() {
+		commit, err := object.GetCommit(storer, h)
+		c.Assert(err, IsNil)
+		paths, err := changedPaths(storer, commit)
+		c.Assert(err, IsNil)
+
+		i, err := idx.GetIndexByHash(h)
+		c.Assert(err, IsNil)
+		filter, err := idx.GetBloomFilterByIndex(i)
+		c.Assert(err, IsNil)
+
+		for _, p := range paths {
+			c.Assert(filter.Contains(p), Equals, true)
+		}
+	}
+}
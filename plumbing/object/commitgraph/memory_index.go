@@ -0,0 +1,192 @@
+package commitgraph
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+	commitgraph "github.com/go-git/go-git/v5/plumbing/format/commitgraph/v2"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// generation holds the generation numbers of a commit, complete is whether
+// all its ancestors are present.
+type generation struct {
+	topological uint64
+	corrected   uint64
+	complete    bool
+}
+
+// BuildMemoryIndex returns a commit-graph index of the commits of the
+// storage, with their generation numbers, ready to be encoded. The commits
+// with missing ancestors, such as the ones of a shallow repository, are left
+// out, as a commit-graph holds all the ancestors of its commits.
+func BuildMemoryIndex(s storer.EncodedObjectStorer) (*commitgraph.MemoryIndex, error) {
+	commits := make(map[plumbing.Hash]*object.Commit)
+	var hashes []plumbing.Hash
+	iter, err := s.IterEncodedObjects(plumbing.CommitObject)
+	if err != nil {
+		return nil, err
+	}
+
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		c, err := object.DecodeCommit(s, obj)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := commits[c.Hash]; !ok {
+			commits[c.Hash] = c
+			hashes = append(hashes, c.Hash)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	generations := make(map[plumbing.Hash]*generation, len(commits))
+	for _, h := range hashes {
+		computeGenerations(h, commits, generations)
+	}
+
+	plumbing.HashesSort(hashes)
+	idx := commitgraph.NewMemoryIndex()
+	for _, h := range hashes {
+		g := generations[h]
+		if !g.complete {
+			continue
+		}
+
+		c := commits[h]
+		idx.Add(h, &commitgraph.CommitData{
+			TreeHash:     c.TreeHash,
+			ParentHashes: c.ParentHashes,
+			Generation:   g.topological,
+			GenerationV2: g.corrected,
+			When:         c.Committer.When,
+		})
+	}
+
+	return idx, nil
+}
+
+// computeGenerations computes the generation numbers of the commit h and its
+// ancestors, walking them without recursion, as histories can be deep.
+func computeGenerations(h plumbing.Hash, commits map[plumbing.Hash]*object.Commit,
+	generations map[plumbing.Hash]*generation) {
+
+	pending := []plumbing.Hash{h}
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		if _, ok := generations[h]; ok {
+			pending = pending[:len(pending)-1]
+			continue
+		}
+
+		c := commits[h]
+		ready := true
+		for _, p := range c.ParentHashes {
+			if _, ok := commits[p]; !ok {
+				continue
+			}
+
+			if _, ok := generations[p]; !ok {
+				pending = append(pending, p)
+				ready = false
+			}
+		}
+
+		if !ready {
+			continue
+		}
+
+		pending = pending[:len(pending)-1]
+		g := &generation{
+			topological: 1,
+			corrected:   uint64(c.Committer.When.Unix()),
+			complete:    true,
+		}
+
+		for _, p := range c.ParentHashes {
+			pg, ok := generations[p]
+			if !ok {
+				g.complete = false
+				continue
+			}
+
+			g.complete = g.complete && pg.complete
+			if pg.topological+1 > g.topological {
+				g.topological = pg.topological + 1
+			}
+
+			if pg.corrected+1 > g.corrected {
+				g.corrected = pg.corrected + 1
+			}
+		}
+
+		generations[h] = g
+	}
+}
+
+// AddBloomFilters computes and sets the changed-path Bloom filters of the
+// commits of the index, from the diff of their tree with the one of their
+// first parent, as `git commit-graph write --changed-paths` does.
+func AddBloomFilters(idx *commitgraph.MemoryIndex, s storer.EncodedObjectStorer) error {
+	for _, h := range idx.Hashes() {
+		c, err := object.GetCommit(s, h)
+		if err != nil {
+			return err
+		}
+
+		paths, err := changedPaths(s, c)
+		if err != nil {
+			return err
+		}
+
+		f := commitgraph.NewBloomFilter(commitgraph.DefaultBloomFilterSettings, paths)
+		if err := idx.SetBloomFilter(h, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// changedPaths returns the paths changed by the commit, compared to its first
+// parent.
+func changedPaths(s storer.EncodedObjectStorer, c *object.Commit) ([]string, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var parentTree *object.Tree
+	if len(c.ParentHashes) > 0 {
+		parent, err := object.GetCommit(s, c.ParentHashes[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if parentTree, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if change.From.Name != "" {
+			paths = append(paths, change.From.Name)
+		}
+
+		if change.To.Name != "" && change.To.Name != change.From.Name {
+			paths = append(paths, change.To.Name)
+		}
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,345 @@
+package commitgraph
+
+import (
+	"math"
+
+	"github.com/emirpasic/gods/trees/binaryheap"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	paintedFromFirst = 1 << iota
+	paintedFromSecond
+	paintedStale
+	paintedResult
+)
+
+// paintItem is an entry of the queue of paintDownToCommon, nonStale is whether
+// it was counted as a non stale entry when queued.
+type paintItem struct {
+	node     CommitNode
+	nonStale bool
+}
+
+// MergeBase mimics the behavior of `git merge-base actual other`, returning
+// the best common ancestors of the commits, as object.Commit.MergeBase does.
+// The history is walked in generation order, so the walk stops as soon as
+// the remaining commits can only reach the common ancestors already found.
+func MergeBase(c, other CommitNode) ([]CommitNode, error) {
+	if c.ID() == other.ID() {
+		return []CommitNode{c}, nil
+	}
+
+	candidates, err := paintDownToCommon(c, other)
+	if err != nil {
+		return nil, err
+	}
+
+	return Independents(candidates)
+}
+
+// MergeBaseOctopus returns the best common ancestors of all the commits,
+// as `git merge-base --octopus` does. The merge bases of the first two
+// commits are merged with the third one, and so on, the commits reachable
+// from other results are left out.
+func MergeBaseOctopus(commits ...CommitNode) ([]CommitNode, error) {
+	if len(commits) == 0 {
+		return nil, nil
+	}
+
+	result := []CommitNode{commits[0]}
+	for _, c := range commits[1:] {
+		var next []CommitNode
+		for _, r := range result {
+			bases, err := MergeBase(r, c)
+			if err != nil {
+				return nil, err
+			}
+
+			next = append(next, bases...)
+		}
+
+		if len(next) == 0 {
+			return nil, nil
+		}
+
+		result = next
+	}
+
+	return Independents(result)
+}
+
+// paintDownToCommon walks the history of both commits, newest generation
+// first, returning the commits reachable from both of them that are not
+// reachable from other such commits found before, as git does.
+func paintDownToCommon(c, other CommitNode) ([]CommitNode, error) {
+	flags := make(map[plumbing.Hash]int)
+	queue := binaryheap.NewWith(func(a, b interface{}) int {
+		return generationAndDateOrderComparator(a.(*paintItem).node, b.(*paintItem).node)
+	})
+
+	var nonStale int
+	push := func(n CommitNode) {
+		item := &paintItem{node: n, nonStale: flags[n.ID()]&paintedStale == 0}
+		if item.nonStale {
+			nonStale++
+		}
+
+		queue.Push(item)
+	}
+
+	flags[c.ID()] |= paintedFromFirst
+	push(c)
+	flags[other.ID()] |= paintedFromSecond
+	push(other)
+
+	var results []CommitNode
+	for nonStale > 0 {
+		v, _ := queue.Pop()
+		item := v.(*paintItem)
+		if item.nonStale {
+			nonStale--
+		}
+
+		n := item.node
+		f := flags[n.ID()] & (paintedFromFirst | paintedFromSecond | paintedStale)
+		if f == paintedFromFirst|paintedFromSecond {
+			if flags[n.ID()]&paintedResult == 0 {
+				flags[n.ID()] |= paintedResult
+				results = append(results, n)
+			}
+
+			// The ancestors of a common commit are not the best ones.
+			f |= paintedStale
+		}
+
+		err := n.ParentNodes().ForEach(func(p CommitNode) error {
+			if flags[p.ID()]&f == f {
+				return nil
+			}
+
+			flags[p.ID()] |= f
+			push(p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var bases []CommitNode
+	for _, n := range results {
+		if flags[n.ID()]&paintedStale == 0 {
+			bases = append(bases, n)
+		}
+	}
+
+	return bases, nil
+}
+
+// Independents returns the commits not reachable from the others, as
+// object.Independents does. It mimics the behavior of
+// `git merge-base --independent commit...`.
+func Independents(commits []CommitNode) ([]CommitNode, error) {
+	var unique []CommitNode
+	seen := make(map[plumbing.Hash]bool)
+	for _, c := range commits {
+		if !seen[c.ID()] {
+			seen[c.ID()] = true
+			unique = append(unique, c)
+		}
+	}
+
+	var res []CommitNode
+	for i, c := range unique {
+		reachable := false
+		for j, other := range unique {
+			if i == j {
+				continue
+			}
+
+			ok, err := IsAncestor(c, other)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				reachable = true
+				break
+			}
+		}
+
+		if !reachable {
+			res = append(res, c)
+		}
+	}
+
+	return res, nil
+}
+
+// IsAncestor returns true if the commit c is an ancestor of other, or the
+// same commit. It mimics the behavior of `git merge-base --is-ancestor`. The
+// commits with a generation lower than the one of c are not walked, as they
+// cannot reach c.
+func IsAncestor(c, other CommitNode) (bool, error) {
+	generation := c.Generation()
+	seen := make(map[plumbing.Hash]bool)
+	pending := []CommitNode{other}
+	for len(pending) > 0 {
+		n := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if n.ID() == c.ID() {
+			return true, nil
+		}
+
+		if seen[n.ID()] || n.Generation() < generation {
+			continue
+		}
+
+		seen[n.ID()] = true
+		err := n.ParentNodes().ForEach(func(p CommitNode) error {
+			pending = append(pending, p)
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// Reachable returns, for each of the queries, whether the commit is
+// reachable from any of the tips, as IsAncestor does for each pair. The
+// history of the tips is walked once, the commits with a generation lower
+// than the ones of all the queries not found yet are not walked, and the
+// walk stops as soon as all the queries are found.
+func Reachable(tips, queries []CommitNode) ([]bool, error) {
+	res := make([]bool, len(queries))
+	pending := make(map[plumbing.Hash][]int, len(queries))
+	for i, q := range queries {
+		pending[q.ID()] = append(pending[q.ID()], i)
+	}
+
+	generation := func() uint64 {
+		lowest := uint64(math.MaxUint64)
+		for _, q := range queries {
+			if _, ok := pending[q.ID()]; ok && q.Generation() < lowest {
+				lowest = q.Generation()
+			}
+		}
+
+		return lowest
+	}
+
+	minGeneration := generation()
+	seen := make(map[plumbing.Hash]bool)
+	stack := append([]CommitNode(nil), tips...)
+	for len(stack) > 0 && len(pending) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[n.ID()] {
+			continue
+		}
+
+		seen[n.ID()] = true
+		if indexes, ok := pending[n.ID()]; ok {
+			for _, i := range indexes {
+				res[i] = true
+			}
+
+			delete(pending, n.ID())
+			minGeneration = generation()
+		}
+
+		if n.Generation() < minGeneration {
+			continue
+		}
+
+		err := n.ParentNodes().ForEach(func(p CommitNode) error {
+			if !seen[p.ID()] {
+				stack = append(stack, p)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// AheadBehind returns the number of commits reachable from c but not from
+// other, and from other but not from c, as
+// `git rev-list --left-right --count c...other` does. The history of both
+// commits is walked at once, newest generation first, stopping as soon as
+// only their common ancestors are left. With unrelated histories, all the
+// commits of both are counted.
+func AheadBehind(c, other CommitNode) (ahead, behind int, err error) {
+	if c.ID() == other.ID() {
+		return 0, 0, nil
+	}
+
+	flags := make(map[plumbing.Hash]int)
+	queue := binaryheap.NewWith(func(a, b interface{}) int {
+		return generationAndDateOrderComparator(a.(*paintItem).node, b.(*paintItem).node)
+	})
+
+	var nonStale int
+	push := func(n CommitNode) {
+		item := &paintItem{node: n, nonStale: flags[n.ID()]&paintedStale == 0}
+		if item.nonStale {
+			nonStale++
+		}
+
+		queue.Push(item)
+	}
+
+	flags[c.ID()] |= paintedFromFirst
+	push(c)
+	flags[other.ID()] |= paintedFromSecond
+	push(other)
+
+	for nonStale > 0 {
+		v, _ := queue.Pop()
+		item := v.(*paintItem)
+		if item.nonStale {
+			nonStale--
+		}
+
+		n := item.node
+		f := flags[n.ID()] & (paintedFromFirst | paintedFromSecond | paintedStale)
+		if f&(paintedFromFirst|paintedFromSecond) == paintedFromFirst|paintedFromSecond {
+			// The ancestors of a common commit are common too.
+			f |= paintedStale
+			flags[n.ID()] |= paintedStale
+		}
+
+		err := n.ParentNodes().ForEach(func(p CommitNode) error {
+			if flags[p.ID()]&f == f {
+				return nil
+			}
+
+			flags[p.ID()] |= f
+			push(p)
+			return nil
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	for _, f := range flags {
+		switch f & (paintedFromFirst | paintedFromSecond) {
+		case paintedFromFirst:
+			ahead++
+		case paintedFromSecond:
+			behind++
+		}
+	}
+
+	return ahead, behind, nil
+}
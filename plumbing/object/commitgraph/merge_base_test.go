@@ -0,0 +1,216 @@
+package commitgraph
+
+import (
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type MergeBaseSuite struct {
+	fixtures.Suite
+	indexes map[string]CommitNodeIndex
+}
+
+var _ = Suite(&MergeBaseSuite{})
+
+// The revisions of the merge-base fixture, as described in
+// plumbing/object/merge_base_test.go.
+var mergeBaseRevisions = map[string]plumbing.Hash{
+	"dev": plumbing.NewHash("25ca6c810c08482d61113fbcaaada38bb59093a8"),
+	"M":   plumbing.NewHash("bb355b64e18386dbc3af63dfd09c015c44cbd9b6"),
+	"N":   plumbing.NewHash("d64b894762ab5f09e2b155221b90c18bd0637236"),
+	"A":   plumbing.NewHash("29740cfaf0c2ee4bb532dba9e80040ca738f367c"),
+	"B":   plumbing.NewHash("2c84807970299ba98951c65fe81ebbaac01030f0"),
+	"AB":  plumbing.NewHash("31a7e081a28f149ee98ffd13ba1a6d841a5f46fd"),
+	"P":   plumbing.NewHash("ff84393134864cf9d3a9853a81bde81778bd5805"),
+	"C":   plumbing.NewHash("8b72fabdc4222c3ff965bc310ded788c601c50ed"),
+	"D":   plumbing.NewHash("14777cf3e209334592fbfd0b878f6868394db836"),
+	"CD1": plumbing.NewHash("4709e13a3cbb300c2b8a917effda776e1b8955c7"),
+	"CD2": plumbing.NewHash("38468e274e91e50ffb637b88a1954ab6193fe974"),
+	"S":   plumbing.NewHash("628f1a42b70380ed05734bf01b468b46206ef1ea"),
+	"G":   plumbing.NewHash("d1b0093698e398d596ef94d646c4db37e8d1e970"),
+	"Q":   plumbing.NewHash("dce0e0c20d701c3d260146e443d6b3b079505191"),
+	"GQ1": plumbing.NewHash("ccaaa99c21dad7e9f392c36ae8cb72dc63bed458"),
+	"GQ2": plumbing.NewHash("806824d4778e94fe7c3244e92a9cd07090c9ab54"),
+	"A^^": plumbing.NewHash("bb355b64e18386dbc3af63dfd09c015c44cbd9b6"),
+}
+
+func (s *MergeBaseSuite) SetUpSuite(c *C) {
+	f := fixtures.ByTag("merge-base").One()
+	storer := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+	graph, err := BuildMemoryIndex(storer)
+	c.Assert(err, IsNil)
+
+	s.indexes = map[string]CommitNodeIndex{
+		"object": NewObjectCommitNodeIndex(storer),
+		"graph":  NewGraphCommitNodeIndex(graph, storer),
+	}
+}
+
+func (s *MergeBaseSuite) nodes(c *C, idx CommitNodeIndex, revs ...string) []CommitNode {
+	var nodes []CommitNode
+	for _, rev := range revs {
+		n, err := idx.Get(mergeBaseRevisions[rev])
+		c.Assert(err, IsNil)
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+func (s *MergeBaseSuite) assertHashes(c *C, nodes []CommitNode, expectedRevs []string) {
+	var hashes, expected []string
+	for _, n := range nodes {
+		hashes = append(hashes, n.ID().String())
+	}
+
+	for _, rev := range expectedRevs {
+		expected = append(expected, mergeBaseRevisions[rev].String())
+	}
+
+	sort.Strings(hashes)
+	sort.Strings(expected)
+	c.Assert(hashes, DeepEquals, expected)
+}
+
+func (s *MergeBaseSuite) TestMergeBase(c *C) {
+	cases := []struct {
+		revs     []string
+		expected []string
+	}{
+		{[]string{"M", "N"}, nil},
+		{[]string{"A", "B"}, []string{"AB"}},
+		{[]string{"A", "A"}, []string{"A"}},
+		{[]string{"Q", "N"}, []string{"N"}},
+		{[]string{"C", "D"}, []string{"CD1", "CD2"}},
+		{[]string{"G", "Q"}, []string{"GQ1", "GQ2"}},
+	}
+
+	for name, idx := range s.indexes {
+		for _, t := range cases {
+			nodes := s.nodes(c, idx, t.revs...)
+			res, err := MergeBase(nodes[0], nodes[1])
+			c.Assert(err, IsNil, Commentf("%s %v", name, t.revs))
+			s.assertHashes(c, res, t.expected)
+		}
+	}
+}
+
+func (s *MergeBaseSuite) TestIndependents(c *C) {
+	cases := []struct {
+		revs     []string
+		expected []string
+	}{
+		{[]string{"A", "A", "M", "M", "N"}, []string{"A", "N"}},
+		{[]string{"S", "G", "P"}, []string{"S", "G"}},
+		{[]string{"CD1", "CD2", "M", "N"}, []string{"CD1", "CD2"}},
+		{[]string{"C", "G", "dev", "M", "N"}, []string{"C", "G", "dev"}},
+	}
+
+	for name, idx := range s.indexes {
+		for _, t := range cases {
+			res, err := Independents(s.nodes(c, idx, t.revs...))
+			c.Assert(err, IsNil, Commentf("%s %v", name, t.revs))
+			s.assertHashes(c, res, t.expected)
+		}
+	}
+}
+
+func (s *MergeBaseSuite) TestIsAncestor(c *C) {
+	cases := []struct {
+		revs     []string
+		expected bool
+	}{
+		{[]string{"A^^", "A"}, true},
+		{[]string{"A", "A^^"}, false},
+		{[]string{"M", "G"}, true},
+		{[]string{"G", "M"}, false},
+		{[]string{"A", "A"}, true},
+		{[]string{"M", "N"}, false},
+		{[]string{"N", "M"}, false},
+	}
+
+	for name, idx := range s.indexes {
+		for _, t := range cases {
+			nodes := s.nodes(c, idx, t.revs...)
+			ok, err := IsAncestor(nodes[0], nodes[1])
+			c.Assert(err, IsNil)
+			c.Assert(ok, Equals, t.expected, Commentf("%s %v", name, t.revs))
+		}
+	}
+}
+
+func (s *MergeBaseSuite) TestAheadBehind(c *C) {
+	cases := []struct {
+		revs          []string
+		ahead, behind int
+	}{
+		{[]string{"A", "B"}, 1, 5},
+		{[]string{"A", "A"}, 0, 0},
+		{[]string{"M", "N"}, 3, 3},
+		{[]string{"Q", "N"}, 19, 0},
+		{[]string{"N", "Q"}, 0, 19},
+		{[]string{"C", "D"}, 2, 2},
+		{[]string{"G", "Q"}, 1, 5},
+	}
+
+	for name, idx := range s.indexes {
+		for _, t := range cases {
+			nodes := s.nodes(c, idx, t.revs...)
+			ahead, behind, err := AheadBehind(nodes[0], nodes[1])
+			c.Assert(err, IsNil)
+			c.Assert([]int{ahead, behind}, DeepEquals, []int{t.ahead, t.behind}, Commentf("%s %v", name, t.revs))
+		}
+	}
+}
+
+func (s *MergeBaseSuite) TestMergeBaseOctopus(c *C) {
+	cases := []struct {
+		revs     []string
+		expected []string
+	}{
+		{[]string{"A"}, []string{"A"}},
+		{[]string{"A", "B"}, []string{"AB"}},
+		{[]string{"A", "B", "C"}, []string{"AB"}},
+		{[]string{"C", "D", "G"}, []string{"CD1", "CD2"}},
+		{[]string{"G", "Q", "S"}, []string{"GQ1"}},
+		{[]string{"M", "N", "A"}, nil},
+	}
+
+	for name, idx := range s.indexes {
+		for _, t := range cases {
+			res, err := MergeBaseOctopus(s.nodes(c, idx, t.revs...)...)
+			c.Assert(err, IsNil, Commentf("%s %v", name, t.revs))
+			s.assertHashes(c, res, t.expected)
+		}
+	}
+}
+
+func (s *MergeBaseSuite) TestReachable(c *C) {
+	queries := []string{"dev", "M", "N", "G", "S", "AB", "A", "CD1", "Q", "M"}
+	expected := []bool{false, true, true, false, false, true, true, true, false, true}
+
+	for name, idx := range s.indexes {
+		res, err := Reachable(s.nodes(c, idx, "A", "C"), s.nodes(c, idx, queries...))
+		c.Assert(err, IsNil)
+		c.Assert(res, DeepEquals, expected, Commentf("%s", name))
+
+		// The results match the ones of IsAncestor.
+		tips := s.nodes(c, idx, "A", "C")
+		for i, q := range s.nodes(c, idx, queries...) {
+			var ok bool
+			for _, tip := range tips {
+				reachable, err := IsAncestor(q, tip)
+				c.Assert(err, IsNil)
+				ok = ok || reachable
+			}
+
+			c.Assert(ok, Equals, res[i], Commentf("%s %s", name, queries[i]))
+		}
+	}
+}
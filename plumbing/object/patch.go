@@ -13,6 +13,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/filemode"
 	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/go-git/go-git/v5/utils/ioutil"
 
 	dmp "github.com/sergi/go-diff/diffmatchpatch"
 )
@@ -62,7 +63,11 @@ func filePatchWithContext(ctx context.Context, c *Change) (fdiff.FilePatch, erro
 	}
 
 	if fIsBinary || tIsBinary {
-		return &textFilePatch{from: c.From, to: c.To}, nil
+		return &binaryFilePatch{
+			textFilePatch: textFilePatch{from: c.From, to: c.To},
+			fromFile:      from,
+			toFile:        to,
+		}, nil
 	}
 
 	diffs := diff.Do(fromContent, toContent)
@@ -125,8 +130,26 @@ func (p *Patch) Message() string {
 	return p.message
 }
 
+// DiffOptions describes how a Patch is encoded.
+type DiffOptions struct {
+	// Binary encodes the changes of the binary files as binary patches,
+	// which git apply can apply, instead of a line saying that they differ,
+	// as the --binary flag of git diff does.
+	Binary bool
+}
+
 func (p *Patch) Encode(w io.Writer) error {
-	ue := fdiff.NewUnifiedEncoder(w, fdiff.DefaultContextLines)
+	return p.EncodeWithOptions(w, &DiffOptions{})
+}
+
+// EncodeWithOptions encodes the patch as an unified diff, with the given
+// options.
+func (p *Patch) EncodeWithOptions(w io.Writer, o *DiffOptions) error {
+	if o == nil {
+		o = &DiffOptions{}
+	}
+
+	ue := fdiff.NewUnifiedEncoder(w, fdiff.DefaultContextLines).SetBinary(o.Binary)
 
 	return ue.Encode(p)
 }
@@ -202,6 +225,38 @@ func (tf *textFilePatch) Chunks() []fdiff.Chunk {
 	return tf.chunks
 }
 
+// binaryFilePatch is an implementation of fdiff.BinaryFilePatch interface
+type binaryFilePatch struct {
+	textFilePatch
+	fromFile, toFile *File
+}
+
+func (bf *binaryFilePatch) BinaryContent() (from, to []byte, err error) {
+	if from, err = binaryFileContent(bf.fromFile); err != nil {
+		return nil, nil, err
+	}
+
+	if to, err = binaryFileContent(bf.toFile); err != nil {
+		return nil, nil, err
+	}
+
+	return from, to, nil
+}
+
+func binaryFileContent(f *File) (content []byte, err error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	reader, err := f.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer ioutil.CheckClose(reader, &err)
+
+	return io.ReadAll(reader)
+}
+
 // textChunk is an implementation of fdiff.Chunk interface
 type textChunk struct {
 	content string
@@ -1,8 +1,13 @@
 package object
 
 import (
+	"bytes"
+	"io"
+	"strings"
+
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
@@ -46,6 +51,52 @@ func (s *PatchSuite) TestStatsWithSubmodules(c *C) {
 	c.Assert(p, NotNil)
 }
 
+func (s *PatchSuite) TestEncodeWithOptionsBinary(c *C) {
+	from := s.commit(c, plumbing.NewHash("b8e471f58bcbca63b07bda20e428190409c2db47"))
+	to := s.commit(c, plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9"))
+
+	patch, err := from.Patch(to)
+	c.Assert(err, IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(patch.EncodeWithOptions(buf, &DiffOptions{Binary: true}), IsNil)
+
+	header := `diff --git a/binary.jpg b/binary.jpg
+new file mode 100644
+index 0000000000000000000000000000000000000000..d5c0f4ab811897cadf03aec358ae60d21f91c50d
+`
+	text, binary, found := strings.Cut(buf.String(), header)
+	c.Assert(found, Equals, true)
+	c.Assert(text, Equals, `diff --git a/CHANGELOG b/CHANGELOG
+deleted file mode 100644
+index d3ff53e0564a9f87d8e84b6e28e5060e517008aa..0000000000000000000000000000000000000000
+--- a/CHANGELOG
++++ /dev/null
+@@ -1 +0,0 @@
+-Initial changelog
+`)
+
+	bp, err := fdiff.DecodeBinaryPatch(strings.NewReader(binary))
+	c.Assert(err, IsNil)
+	c.Assert(bp.Forward.Type, Equals, fdiff.LiteralBinaryHunk)
+
+	blob, err := GetBlob(s.Storer, plumbing.NewHash("d5c0f4ab811897cadf03aec358ae60d21f91c50d"))
+	c.Assert(err, IsNil)
+	r, err := blob.Reader()
+	c.Assert(err, IsNil)
+	content, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+
+	result, err := bp.Apply(nil)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(result, content), Equals, true)
+
+	// The binary files are only said to differ by default.
+	c.Assert(patch.String(), Equals, text+header+
+		"Binary files /dev/null and b/binary.jpg differ\n")
+}
+
 func (s *PatchSuite) TestFileStatsString(c *C) {
 	testCases := []struct {
 		description string
@@ -202,6 +202,20 @@ func (a *AdvRefs) supportSymrefs() bool {
 	return a.Capabilities.Supports(capability.SymRef)
 }
 
+// HeadTarget returns the reference HEAD points to, as advertised by the
+// symref capability, or an empty name if it isn't. The target may not be
+// advertised, as the branch HEAD points to in an empty repository.
+func (a *AdvRefs) HeadTarget() plumbing.ReferenceName {
+	for _, symref := range a.Capabilities.Get(capability.SymRef) {
+		name, target, ok := strings.Cut(symref, ":")
+		if ok && plumbing.ReferenceName(name) == plumbing.HEAD {
+			return plumbing.ReferenceName(target)
+		}
+	}
+
+	return ""
+}
+
 // IsEmpty returns true if doesn't contain any reference.
 func (a *AdvRefs) IsEmpty() bool {
 	return a.Head == nil &&
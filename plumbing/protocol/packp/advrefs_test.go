@@ -132,6 +132,17 @@ func (s *AdvRefSuite) TestNoSymRefCapabilityHeadToNoRef(c *C) {
 	c.Assert(err, NotNil)
 }
 
+func (s *AdvRefSuite) TestHeadTarget(c *C) {
+	a := NewAdvRefs()
+	c.Assert(a.HeadTarget(), Equals, plumbing.ReferenceName(""))
+
+	// The target of HEAD may not be advertised, as in an empty repository.
+	err := a.AddReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/trunk"))
+	c.Assert(err, IsNil)
+	c.Assert(a.HeadTarget(), Equals, plumbing.ReferenceName("refs/heads/trunk"))
+	c.Assert(a.IsEmpty(), Equals, true)
+}
+
 func (s *AdvRefSuite) TestNoSymRefCapabilityHeadToNoMasterAlphabeticallyOrdered(c *C) {
 	a := NewAdvRefs()
 	headHash := plumbing.NewHash("5dc01c595e6c6ec9ccda4f6f69c131c0dd945f8c")
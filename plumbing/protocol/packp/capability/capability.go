@@ -89,6 +89,9 @@ const (
 	// understood thin packs. Adding 'no-thin' later allowed receive-pack
 	// to disable the feature in a backwards-compatible manner.
 	ThinPack Capability = "thin-pack"
+	// NoThin is advertised by a receive-pack server which cannot handle
+	// thin packs, see ThinPack.
+	NoThin Capability = "no-thin"
 	// Sideband means that server can send, and client understand multiplexed
 	// progress reports and error info interleaved with the packfile itself.
 	//
@@ -254,7 +257,7 @@ func DefaultAgent() string {
 }
 
 var known = map[Capability]bool{
-	MultiACK: true, MultiACKDetailed: true, NoDone: true, ThinPack: true,
+	MultiACK: true, MultiACKDetailed: true, NoDone: true, ThinPack: true, NoThin: true,
 	Sideband: true, Sideband64k: true, OFSDelta: true, Agent: true,
 	Shallow: true, DeepenSince: true, DeepenNot: true, DeepenRelative: true,
 	NoProgress: true, IncludeTag: true, ReportStatus: true, DeleteRefs: true,
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 )
@@ -28,8 +29,8 @@ type Progress interface {
 // In order to demultiplex the data stream, method `Read` should be called to
 // retrieve the PackData channel, the incoming data from the ProgressMessage is
 // written at `Progress` (if any), if any message is retrieved from the
-// ErrorMessage channel an error is returned and we can assume that the
-// connection has been closed.
+// ErrorMessage channel it is returned as a *pktline.ErrorLine, as the ERR
+// pkt-lines are, and we can assume that the connection has been closed.
 type Demuxer struct {
 	t Type
 	r io.Reader
@@ -128,7 +129,7 @@ func (d *Demuxer) nextPackData() ([]byte, error) {
 			return nil, err
 		}
 	case ErrorMessage:
-		return nil, fmt.Errorf("unexpected error: %s", content[1:])
+		return nil, &pktline.ErrorLine{Text: strings.TrimSpace(string(content[1:]))}
 	default:
 		return nil, fmt.Errorf("unknown channel %s", content)
 	}
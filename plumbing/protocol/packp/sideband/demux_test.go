@@ -63,7 +63,7 @@ func (s *SidebandSuite) TestDecodeWithError(c *C) {
 	content := make([]byte, 26)
 	d := NewDemuxer(Sideband64k, buf)
 	n, err := io.ReadFull(d, content)
-	c.Assert(err, ErrorMatches, "unexpected error: FOO\n")
+	c.Assert(err, DeepEquals, &pktline.ErrorLine{Text: "FOO"})
 	c.Assert(n, Equals, 8)
 	c.Assert(content[0:8], DeepEquals, expected[0:8])
 }
@@ -219,6 +219,22 @@ const (
 	Main   ReferenceName = "refs/heads/main"
 )
 
+// The pseudo-references written by the operations of git, at the root of the
+// git directory.
+const (
+	// FetchHead is the first reference fetched by the last fetch, see
+	// storer.FetchHeadStorer.
+	FetchHead ReferenceName = "FETCH_HEAD"
+	// OrigHead is the previous value of HEAD, saved by the operations
+	// moving it drastically, such as reset, merge or rebase.
+	OrigHead ReferenceName = "ORIG_HEAD"
+	// MergeHead is the commit being merged by a merge stopped on conflicts.
+	MergeHead ReferenceName = "MERGE_HEAD"
+	// CherryPickHead is the commit being cherry-picked by a cherry-pick
+	// stopped on conflicts.
+	CherryPickHead ReferenceName = "CHERRY_PICK_HEAD"
+)
+
 // Reference is a representation of git reference
 type Reference struct {
 	t      ReferenceType
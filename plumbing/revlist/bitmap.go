@@ -0,0 +1,204 @@
+package revlist
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/bitmap"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// packBitmap returns the reachability bitmaps of the storage, if any.
+func packBitmap(s storer.EncodedObjectStorer) (*bitmap.PackBitmap, error) {
+	bs, ok := s.(storer.BitmapObjectStorer)
+	if !ok {
+		return nil, nil
+	}
+
+	return bs.PackBitmap()
+}
+
+// objectsWithBitmap is the same as Objects, but the objects reachable from
+// the bitmapped commits are taken from their bitmaps instead of walking
+// their history and trees. Only the commits not reachable from a bitmapped
+// commit, such as the ones added since the packfile was written, are walked.
+func objectsWithBitmap(
+	s storer.EncodedObjectStorer,
+	b *bitmap.PackBitmap,
+	objs,
+	ignore []plumbing.Hash,
+) ([]plumbing.Hash, error) {
+	ignored := newReachableSet(s, b)
+	for _, h := range ignore {
+		if err := ignored.walk(h); err != nil {
+			if err == plumbing.ErrObjectNotFound {
+				continue
+			}
+
+			return nil, err
+		}
+	}
+
+	wanted := newReachableSet(s, b)
+	for _, h := range objs {
+		if err := wanted.walk(h); err != nil {
+			return nil, err
+		}
+	}
+
+	wanted.bits.AndNot(ignored.bits)
+	result := make([]plumbing.Hash, 0, wanted.bits.Count()+len(wanted.extra))
+	wanted.bits.ForEach(func(i int) {
+		result = append(result, b.Hash(i))
+	})
+
+	for h := range wanted.extra {
+		if !ignored.extra[h] {
+			result = append(result, h)
+		}
+	}
+
+	return result, nil
+}
+
+// reachableSet is a set of objects closed under reachability, the objects of
+// the bitmapped packfile are held as bits, the others as hashes.
+type reachableSet struct {
+	s      storer.EncodedObjectStorer
+	bitmap *bitmap.PackBitmap
+	bits   *bitmap.Bitmap
+	extra  map[plumbing.Hash]bool
+}
+
+func newReachableSet(s storer.EncodedObjectStorer, b *bitmap.PackBitmap) *reachableSet {
+	return &reachableSet{
+		s:      s,
+		bitmap: b,
+		bits:   bitmap.NewBitmap(),
+		extra:  make(map[plumbing.Hash]bool),
+	}
+}
+
+func (r *reachableSet) has(h plumbing.Hash) bool {
+	if i, ok := r.bitmap.Position(h); ok {
+		return r.bits.Get(i)
+	}
+
+	return r.extra[h]
+}
+
+func (r *reachableSet) add(h plumbing.Hash) {
+	if i, ok := r.bitmap.Position(h); ok {
+		r.bits.Set(i)
+		return
+	}
+
+	r.extra[h] = true
+}
+
+// walk adds the object h and the objects reachable from it. A set object is
+// never walked, as the objects reachable from it are set too.
+func (r *reachableSet) walk(h plumbing.Hash) error {
+	if r.has(h) {
+		return nil
+	}
+
+	o, err := r.s.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return err
+	}
+
+	do, err := object.DecodeObject(r.s, o)
+	if err != nil {
+		return err
+	}
+
+	switch do := do.(type) {
+	case *object.Commit:
+		return r.walkCommits(do)
+	case *object.Tree:
+		return r.walkTree(do)
+	case *object.Tag:
+		r.add(do.Hash)
+		return r.walk(do.Target)
+	case *object.Blob:
+		r.add(do.Hash)
+	default:
+		return fmt.Errorf("object type not valid: %s. "+
+			"Object reference: %s", o.Type(), o.Hash())
+	}
+
+	return nil
+}
+
+// walkCommits adds the commit, its ancestors and their trees, the bitmaps of
+// the bitmapped commits are used instead of walking them.
+func (r *reachableSet) walkCommits(c *object.Commit) error {
+	pending := []*object.Commit{c}
+	for len(pending) > 0 {
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if r.has(c.Hash) {
+			continue
+		}
+
+		if bits, ok := r.bitmap.Reachable(c.Hash); ok {
+			r.bits.Or(bits)
+			continue
+		}
+
+		r.add(c.Hash)
+		tree, err := c.Tree()
+		if err != nil {
+			return err
+		}
+
+		if err := r.walkTree(tree); err != nil {
+			return err
+		}
+
+		err = c.Parents().ForEach(func(p *object.Commit) error {
+			if !r.has(p.Hash) {
+				pending = append(pending, p)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *reachableSet) walkTree(t *object.Tree) error {
+	if r.has(t.Hash) {
+		return nil
+	}
+
+	r.add(t.Hash)
+	for _, e := range t.Entries {
+		if e.Mode == filemode.Submodule || r.has(e.Hash) {
+			continue
+		}
+
+		if e.Mode != filemode.Dir {
+			r.add(e.Hash)
+			continue
+		}
+
+		subtree, err := object.GetTree(r.s, e.Hash)
+		if err != nil {
+			return err
+		}
+
+		if err := r.walkTree(subtree); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
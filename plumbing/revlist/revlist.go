@@ -15,12 +15,22 @@ import (
 // Objects applies a complementary set. It gets all the hashes from all
 // the reachable objects from the given objects. Ignore param are object hashes
 // that we want to ignore on the result. All that objects must be accessible
-// from the object storer.
+// from the object storer. If the object storer has pack bitmaps, they are
+// used instead of walking the objects reachable from the bitmapped commits.
 func Objects(
 	s storer.EncodedObjectStorer,
 	objs,
 	ignore []plumbing.Hash,
 ) ([]plumbing.Hash, error) {
+	b, err := packBitmap(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if b != nil {
+		return objectsWithBitmap(s, b, objs, ignore)
+	}
+
 	return ObjectsWithStorageForIgnores(s, s, objs, ignore)
 }
 
@@ -1,10 +1,14 @@
 package revlist
 
 import (
+	"os/exec"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/storage/filesystem"
@@ -291,3 +295,104 @@ func (s *RevListSuite) TestReachableObjectsNoRevisit(c *C) {
 		plumbing.NewHash("b8e471f58bcbca63b07bda20e428190409c2db47"),
 	})
 }
+
+func (s *RevListSuite) TestRevListObjectsWithBitmap(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	fs := fixtures.Basic().One().DotGit()
+	out, err := exec.Command("git", "--git-dir", fs.Root(), "repack", "-adb").CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	sto := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	b, err := sto.PackBitmap()
+	c.Assert(err, IsNil)
+	c.Assert(b, NotNil)
+
+	// A commit added after the packfile was written, it has no bitmap.
+	blob := sto.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte("new file"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	blobHash, err := sto.SetEncodedObject(blob)
+	c.Assert(err, IsNil)
+
+	master, err := object.GetCommit(sto, plumbing.NewHash(someCommitOtherBranch))
+	c.Assert(err, IsNil)
+	masterTree, err := master.Tree()
+	c.Assert(err, IsNil)
+
+	tree := &object.Tree{Entries: append(masterTree.Entries, object.TreeEntry{
+		Name: "zz-new-file", Mode: filemode.Regular, Hash: blobHash,
+	})}
+	treeHash := storeObject(c, sto, tree)
+
+	sig := object.Signature{Name: "foo", Email: "foo@foo.foo", When: time.Unix(1700000000, 0)}
+	newCommit := storeObject(c, sto, &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      "new commit",
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{master.Hash},
+	})
+
+	for _, t := range []struct {
+		objs, ignore []plumbing.Hash
+	}{
+		{objs: []plumbing.Hash{plumbing.NewHash(someCommitOtherBranch)}},
+		{
+			objs:   []plumbing.Hash{plumbing.NewHash(someCommitBranch)},
+			ignore: []plumbing.Hash{plumbing.NewHash(someCommitOtherBranch)},
+		},
+		{
+			objs:   []plumbing.Hash{plumbing.NewHash(someCommitOtherBranch), plumbing.NewHash(someCommitBranch)},
+			ignore: []plumbing.Hash{plumbing.NewHash(initialCommit), plumbing.NewHash(secondCommit)},
+		},
+		{
+			objs:   []plumbing.Hash{newCommit},
+			ignore: []plumbing.Hash{plumbing.NewHash(someCommitBranch)},
+		},
+		{
+			objs:   []plumbing.Hash{plumbing.NewHash(someCommitBranch)},
+			ignore: []plumbing.Hash{newCommit, plumbing.NewHash("0000000000000000000000000000000000000001")},
+		},
+		{objs: []plumbing.Hash{treeHash}, ignore: []plumbing.Hash{masterTree.Hash}},
+	} {
+		withBitmap, err := Objects(sto, t.objs, t.ignore)
+		c.Assert(err, IsNil)
+
+		expected, err := Objects(struct{ storer.EncodedObjectStorer }{sto}, t.objs, t.ignore)
+		c.Assert(err, IsNil)
+
+		sortHashes(withBitmap)
+		sortHashes(expected)
+		c.Assert(withBitmap, DeepEquals, expected, Commentf("objs: %v, ignore: %v", t.objs, t.ignore))
+	}
+
+	revList, err := Objects(sto, []plumbing.Hash{newCommit}, []plumbing.Hash{master.Hash})
+	c.Assert(err, IsNil)
+	sortHashes(revList)
+	expected := []plumbing.Hash{newCommit, treeHash, blobHash}
+	sortHashes(expected)
+	c.Assert(revList, DeepEquals, expected)
+}
+
+func storeObject(c *C, sto storer.EncodedObjectStorer, o interface {
+	Encode(plumbing.EncodedObject) error
+}) plumbing.Hash {
+	obj := sto.NewEncodedObject()
+	c.Assert(o.Encode(obj), IsNil)
+	h, err := sto.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+	return h
+}
+
+func sortHashes(hashes []plumbing.Hash) {
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i].String() < hashes[j].String()
+	})
+}
@@ -0,0 +1,23 @@
+package storer
+
+import "github.com/go-git/go-git/v5/plumbing"
+
+// FetchHead is a reference fetched by the last fetch, as recorded in the
+// FETCH_HEAD file.
+type FetchHead struct {
+	// Hash is the hash the reference pointed to on the remote.
+	Hash plumbing.Hash
+	// NotForMerge is true for the references fetched but not to be merged
+	// by a pull, such as the branches other than the upstream of the
+	// current one.
+	NotForMerge bool
+	// Description describes the reference, such as "branch 'master' of
+	// https://github.com/go-git/go-git".
+	Description string
+}
+
+// FetchHeadStorer is a storage of the references fetched by the last fetch.
+type FetchHeadStorer interface {
+	SetFetchHead([]FetchHead) error
+	FetchHead() ([]FetchHead, error)
+}
@@ -0,0 +1,119 @@
+package storer
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// namespaceRefPrefix is the prefix of the references of a namespace.
+const namespaceRefPrefix = "refs/namespaces/"
+
+// NamespacePrefix returns the prefix of the names of the references of the
+// given ref namespace, as GIT_NAMESPACE: refs/namespaces/foo/ for foo. The
+// namespaces separated by slashes are nested, foo/bar is
+// refs/namespaces/foo/refs/namespaces/bar/.
+func NamespacePrefix(namespace string) string {
+	var b strings.Builder
+	for _, ns := range strings.Split(namespace, "/") {
+		if ns == "" {
+			continue
+		}
+
+		b.WriteString(namespaceRefPrefix)
+		b.WriteString(ns)
+		b.WriteByte('/')
+	}
+
+	return b.String()
+}
+
+type namespacedReferenceStorer struct {
+	ReferenceStorer
+	prefix string
+}
+
+// NewNamespacedReferenceStorer returns a ReferenceStorer scoped to the given
+// ref namespace. The references are stored in s with the prefix of the
+// namespace, and read without it. The references outside the namespace are
+// hidden. If namespace is empty, s is returned.
+func NewNamespacedReferenceStorer(s ReferenceStorer, namespace string) ReferenceStorer {
+	prefix := NamespacePrefix(namespace)
+	if prefix == "" {
+		return s
+	}
+
+	return &namespacedReferenceStorer{ReferenceStorer: s, prefix: prefix}
+}
+
+func (s *namespacedReferenceStorer) SetReference(ref *plumbing.Reference) error {
+	return s.ReferenceStorer.SetReference(s.wrap(ref))
+}
+
+func (s *namespacedReferenceStorer) CheckAndSetReference(new, old *plumbing.Reference) error {
+	return s.ReferenceStorer.CheckAndSetReference(s.wrap(new), s.wrap(old))
+}
+
+func (s *namespacedReferenceStorer) Reference(n plumbing.ReferenceName) (*plumbing.Reference, error) {
+	ref, err := s.ReferenceStorer.Reference(s.name(n))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.unwrap(ref), nil
+}
+
+func (s *namespacedReferenceStorer) IterReferences() (ReferenceIter, error) {
+	iter, err := s.ReferenceStorer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), s.prefix) {
+			refs = append(refs, s.unwrap(ref))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReferenceSliceIter(refs), nil
+}
+
+func (s *namespacedReferenceStorer) RemoveReference(n plumbing.ReferenceName) error {
+	return s.ReferenceStorer.RemoveReference(s.name(n))
+}
+
+func (s *namespacedReferenceStorer) name(n plumbing.ReferenceName) plumbing.ReferenceName {
+	return plumbing.ReferenceName(s.prefix + n.String())
+}
+
+// wrap returns ref with the prefix of the namespace, the target of a
+// symbolic reference is in the namespace too.
+func (s *namespacedReferenceStorer) wrap(ref *plumbing.Reference) *plumbing.Reference {
+	if ref == nil {
+		return nil
+	}
+
+	if ref.Type() == plumbing.SymbolicReference {
+		return plumbing.NewSymbolicReference(s.name(ref.Name()), s.name(ref.Target()))
+	}
+
+	return plumbing.NewHashReference(s.name(ref.Name()), ref.Hash())
+}
+
+// unwrap returns ref without the prefix of the namespace. The target of a
+// symbolic reference outside of the namespace is kept as is.
+func (s *namespacedReferenceStorer) unwrap(ref *plumbing.Reference) *plumbing.Reference {
+	name := plumbing.ReferenceName(strings.TrimPrefix(ref.Name().String(), s.prefix))
+	if ref.Type() == plumbing.SymbolicReference {
+		target := plumbing.ReferenceName(strings.TrimPrefix(ref.Target().String(), s.prefix))
+		return plumbing.NewSymbolicReference(name, target)
+	}
+
+	return plumbing.NewHashReference(name, ref.Hash())
+}
@@ -0,0 +1,15 @@
+package storer
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type NamespaceSuite struct{}
+
+var _ = Suite(&NamespaceSuite{})
+
+func (s *NamespaceSuite) TestNamespacePrefix(c *C) {
+	c.Assert(NamespacePrefix(""), Equals, "")
+	c.Assert(NamespacePrefix("foo"), Equals, "refs/namespaces/foo/")
+	c.Assert(NamespacePrefix("foo/bar/"), Equals, "refs/namespaces/foo/refs/namespaces/bar/")
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/bitmap"
 )
 
 var (
@@ -88,6 +89,50 @@ type PackedObjectStorer interface {
 	DeleteOldObjectPackAndIndex(plumbing.Hash, time.Time) error
 }
 
+// BitmapObjectStorer is an optional interface for the storages having pack
+// bitmaps, used to compute the objects reachable from commits without walking
+// their whole history.
+type BitmapObjectStorer interface {
+	// PackBitmap returns the reachability bitmaps of a packfile, or nil if
+	// there is none.
+	PackBitmap() (*bitmap.PackBitmap, error)
+}
+
+// BatchObjectStorer is an optional interface for the storages which can read
+// many objects faster than one by one.
+type BatchObjectStorer interface {
+	// ObjectBatchReader returns a reader of objects, which keeps the
+	// resources needed to read them, such as the packfiles, open until it is
+	// closed.
+	ObjectBatchReader() (ObjectBatchReader, error)
+}
+
+// ObjectBatchReader reads many objects of a storage, see BatchObjectStorer.
+type ObjectBatchReader interface {
+	// ObjectOffset returns the packfile containing the object and its
+	// offset in it, or -1 if the object is not in a packfile. The objects
+	// are read faster in the order of their offsets.
+	ObjectOffset(plumbing.Hash) (pack plumbing.Hash, offset int64)
+	// EncodedObject returns the object, as EncodedObjectStorer does.
+	EncodedObject(plumbing.ObjectType, plumbing.Hash) (plumbing.EncodedObject, error)
+	// ObjectHeader returns the type and the size of the object, without
+	// reading its content when possible. It returns
+	// plumbing.ErrObjectNotFound if the object doesn't exist.
+	ObjectHeader(plumbing.Hash) (plumbing.ObjectType, int64, error)
+	// Close releases the resources of the reader.
+	Close() error
+}
+
+// StreamObjectStorer is an optional interface for the storages which can
+// store an object read from a stream, holding at most a fixed size buffer of
+// its content in memory.
+type StreamObjectStorer interface {
+	// SetEncodedObjectFromReader stores the object of the given type and
+	// size whose content is read from r, and returns its hash. An error is
+	// returned, and nothing is stored, if r doesn't hold size bytes.
+	SetEncodedObjectFromReader(t plumbing.ObjectType, size int64, r io.Reader) (plumbing.Hash, error)
+}
+
 // PackfileWriter is an optional method for ObjectStorer, it enables directly writing
 // a packfile to storage.
 type PackfileWriter interface {
@@ -98,6 +143,38 @@ type PackfileWriter interface {
 	PackfileWriter() (io.WriteCloser, error)
 }
 
+// PackfileKeeper is an optional method for ObjectStorer, it enables writing a
+// packfile along with a .keep file, so it isn't deleted by a repack before
+// the references pointing to its objects are updated.
+type PackfileKeeper interface {
+	// KeptPackfileWriter returns a writer for writing a packfile to the
+	// storage along with a .keep file holding the given reason, and a
+	// function removing the .keep file, to be called once the writer is
+	// closed and the references are updated.
+	KeptPackfileWriter(reason string) (w io.WriteCloser, unkeep func() error, err error)
+}
+
+// QuarantineStorer is an optional interface for the storages which can hold
+// new objects in a quarantine, apart from their other objects, until they are
+// accepted.
+type QuarantineStorer interface {
+	// Quarantine returns a new quarantine of the storage.
+	Quarantine() (Quarantine, error)
+}
+
+// Quarantine is an object storage holding the objects written to it apart
+// from the ones of a storage, which are readable through it too. The objects
+// are written as packfiles, with PackfileKeeper.
+type Quarantine interface {
+	EncodedObjectStorer
+	PackfileKeeper
+	// Migrate moves the objects of the quarantine to the storage, and
+	// removes the quarantine.
+	Migrate() error
+	// Remove removes the quarantine and its objects.
+	Remove() error
+}
+
 // EncodedObjectIter is a generic closable interface for iterating over objects.
 type EncodedObjectIter interface {
 	Next() (plumbing.EncodedObject, error)
@@ -2,16 +2,28 @@ package storer
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"sort"
+	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/reflog"
 )
 
 const MaxResolveRecursion = 1024
 
-// ErrMaxResolveRecursion is returned by ResolveReference is MaxResolveRecursion
-// is exceeded
-var ErrMaxResolveRecursion = errors.New("max. recursion level reached")
+var (
+	// ErrMaxResolveRecursion is returned by ResolveReference is MaxResolveRecursion
+	// is exceeded
+	ErrMaxResolveRecursion = errors.New("max. recursion level reached")
+	// ErrReferenceUpdatedTwice is returned by ReferenceTransaction.Commit when
+	// a reference is updated twice in the same transaction.
+	ErrReferenceUpdatedTwice = errors.New("reference updated twice in the transaction")
+	// ErrReflogNotSupported is returned when the reflogs of a storer can't
+	// be written.
+	ErrReflogNotSupported = errors.New("reflogs cannot be written by the storer")
+)
 
 // ReferenceStorer is a generic storage of references.
 type ReferenceStorer interface {
@@ -28,6 +40,148 @@ type ReferenceStorer interface {
 	PackRefs() error
 }
 
+// ReferencePacker is an optional interface for ReferenceStorer, it enables
+// choosing which references are packed.
+type ReferencePacker interface {
+	// PackReferences packs the loose references, if all is false only the
+	// tags and the references already packed. If prune is true the packed
+	// loose references are removed.
+	PackReferences(all, prune bool) error
+}
+
+// ReferenceTransactioner is an optional interface for ReferenceStorer, it
+// enables updating several references at once, all or nothing.
+type ReferenceTransactioner interface {
+	// BeginReferenceTransaction starts a reference transaction.
+	BeginReferenceTransaction() ReferenceTransaction
+}
+
+// ReflogReader is an optional interface for ReferenceStorer, it enables
+// reading the reflogs of the references.
+type ReflogReader interface {
+	// Reflog returns the entries of the reflog of the given reference,
+	// oldest first, or none if it has no reflog.
+	Reflog(plumbing.ReferenceName) ([]*reflog.Entry, error)
+}
+
+// ReflogWriter is an optional interface for ReferenceStorer, it enables
+// rewriting the reflogs of the references.
+type ReflogWriter interface {
+	// SetReflog replaces the entries of the reflog of the given reference,
+	// oldest first, at once.
+	SetReflog(plumbing.ReferenceName, []*reflog.Entry) error
+}
+
+// ReflogMover is an optional interface for ReferenceStorer, it enables
+// renaming and removing the reflogs along with their references.
+type ReflogMover interface {
+	// RenameReflog moves the reflog of the reference oldName to newName,
+	// replacing the one of newName, if any.
+	RenameReflog(oldName, newName plumbing.ReferenceName) error
+	// RemoveReflog removes the reflog of the given reference, if any.
+	RemoveReflog(plumbing.ReferenceName) error
+}
+
+// ReferenceTransaction queues reference updates, none of them is applied
+// until Commit is called.
+type ReferenceTransaction interface {
+	// Update sets the reference to the hash new. If old is not
+	// plumbing.ZeroHash, the reference must currently point to old.
+	Update(name plumbing.ReferenceName, new, old plumbing.Hash)
+	// Create sets the reference to the hash new, the reference must not
+	// exist.
+	Create(name plumbing.ReferenceName, new plumbing.Hash)
+	// Delete removes the reference. If old is not plumbing.ZeroHash, the
+	// reference must currently point to old.
+	Delete(name plumbing.ReferenceName, old plumbing.Hash)
+	// Commit checks the current value of every reference, and then applies
+	// all the updates. If any of them can't be applied none is, and a
+	// *ReferenceTransactionError is returned.
+	Commit() error
+}
+
+// ReferenceUpdate is an update queued in a ReferenceTransaction.
+type ReferenceUpdate struct {
+	// Name is the name of the reference.
+	Name plumbing.ReferenceName
+	// New is the new hash of the reference, plumbing.ZeroHash deletes it.
+	New plumbing.Hash
+	// Old is the hash the reference must currently point to, if it is not
+	// plumbing.ZeroHash.
+	Old plumbing.Hash
+	// MustNotExist requires the reference to not exist currently.
+	MustNotExist bool
+}
+
+// Matches tells whether the current reference, nil if it doesn't exist, is
+// the one expected by the update.
+func (u ReferenceUpdate) Matches(current *plumbing.Reference) bool {
+	if current == nil {
+		return u.Old.IsZero()
+	}
+
+	return !u.MustNotExist && (u.Old.IsZero() || current.Hash() == u.Old)
+}
+
+// ReferenceTransactionError is returned by ReferenceTransaction.Commit when
+// some updates couldn't be applied, with the error of each one of them.
+type ReferenceTransactionError struct {
+	Errors map[plumbing.ReferenceName]error
+}
+
+func (e *ReferenceTransactionError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name.String())
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %s", name, e.Errors[plumbing.ReferenceName(name)])
+	}
+
+	return "reference transaction failed: " + strings.Join(msgs, ", ")
+}
+
+type referenceTransaction struct {
+	updates []ReferenceUpdate
+	commit  func([]ReferenceUpdate) error
+}
+
+// NewReferenceTransaction returns a ReferenceTransaction queueing the updates,
+// which are given to commit when the transaction is committed.
+func NewReferenceTransaction(commit func([]ReferenceUpdate) error) ReferenceTransaction {
+	return &referenceTransaction{commit: commit}
+}
+
+func (tx *referenceTransaction) Update(name plumbing.ReferenceName, new, old plumbing.Hash) {
+	tx.updates = append(tx.updates, ReferenceUpdate{Name: name, New: new, Old: old})
+}
+
+func (tx *referenceTransaction) Create(name plumbing.ReferenceName, new plumbing.Hash) {
+	tx.updates = append(tx.updates, ReferenceUpdate{Name: name, New: new, MustNotExist: true})
+}
+
+func (tx *referenceTransaction) Delete(name plumbing.ReferenceName, old plumbing.Hash) {
+	tx.updates = append(tx.updates, ReferenceUpdate{Name: name, Old: old})
+}
+
+func (tx *referenceTransaction) Commit() error {
+	seen := make(map[plumbing.ReferenceName]bool, len(tx.updates))
+	for _, u := range tx.updates {
+		if seen[u.Name] {
+			return &ReferenceTransactionError{Errors: map[plumbing.ReferenceName]error{
+				u.Name: ErrReferenceUpdatedTwice,
+			}}
+		}
+
+		seen[u.Name] = true
+	}
+
+	return tx.commit(tx.updates)
+}
+
 // ReferenceIter is a generic closable interface for iterating over references.
 type ReferenceIter interface {
 	Next() (*plumbing.Reference, error)
@@ -0,0 +1,186 @@
+// Package bundle implements a transport reading git bundle files, so they can
+// be used as the source of fetch and clone operations.
+package bundle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/bundle"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// ErrPushNotSupported is returned when trying to push to a bundle.
+var ErrPushNotSupported = errors.New("pushing to a bundle is not supported")
+
+// DefaultClient is the default bundle client.
+var DefaultClient = NewClient()
+
+// IsBundle reports whether the endpoint points to a local bundle file: a
+// regular file starting with a bundle signature, as git checks it.
+func IsBundle(ep *transport.Endpoint) bool {
+	if ep.Protocol != "file" {
+		return false
+	}
+
+	fi, err := os.Stat(ep.Path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+
+	f, err := os.Open(ep.Path)
+	if err != nil {
+		return false
+	}
+
+	defer f.Close()
+
+	ok, err := bundle.IsBundle(f)
+	return err == nil && ok
+}
+
+type client struct{}
+
+// NewClient returns a new bundle client, reading the bundle file at the path
+// of the endpoint.
+func NewClient() transport.Transport {
+	return &client{}
+}
+
+func (c *client) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (
+	transport.UploadPackSession, error) {
+
+	return &upSession{path: ep.Path}, nil
+}
+
+func (c *client) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (
+	transport.ReceivePackSession, error) {
+
+	return nil, ErrPushNotSupported
+}
+
+type upSession struct {
+	path   string
+	header *bundle.Header
+}
+
+// open opens the bundle and decodes its header, the returned decoder is
+// positioned at the beginning of the packfile.
+func (s *upSession) open() (*bundle.Decoder, io.Closer, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, transport.ErrRepositoryNotFound
+		}
+
+		return nil, nil, err
+	}
+
+	d := bundle.NewDecoder(f)
+	h := bundle.NewHeader()
+	if err := d.Decode(h); err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+
+	if format, ok := h.Capability(bundle.ObjectFormatCapability); ok && format != "sha1" {
+		_ = f.Close()
+		return nil, nil, bundle.ErrUnsupportedVersion
+	}
+
+	s.header = h
+	return d, f, nil
+}
+
+func (s *upSession) readHeader() (*bundle.Header, error) {
+	if s.header != nil {
+		return s.header, nil
+	}
+
+	_, closer, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.header, closer.Close()
+}
+
+func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return s.AdvertisedReferencesContext(context.TODO())
+}
+
+func (s *upSession) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	h, err := s.readHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	ar := packp.NewAdvRefs()
+	for _, ref := range h.References {
+		if ref.Name() == plumbing.HEAD {
+			hash := ref.Hash()
+			ar.Head = &hash
+			continue
+		}
+
+		if err := ar.AddReference(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	if ar.IsEmpty() {
+		return nil, transport.ErrEmptyRemoteRepository
+	}
+
+	if err := ar.Capabilities.Set(capability.OFSDelta); err != nil {
+		return nil, err
+	}
+
+	return ar, nil
+}
+
+// Prerequisites returns the prerequisites of the bundle.
+func (s *upSession) Prerequisites(ctx context.Context) ([]plumbing.Hash, error) {
+	h, err := s.readHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]plumbing.Hash, 0, len(h.Prerequisites))
+	for _, p := range h.Prerequisites {
+		hashes = append(hashes, p.Hash)
+	}
+
+	return hashes, nil
+}
+
+// UploadPack returns the packfile of the bundle, it holds all the objects
+// of the bundle whatever the wants of the request are.
+func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	if req.IsEmpty() {
+		return nil, transport.ErrEmptyUploadPackRequest
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	d, closer, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	rc := ioutil.NewReadCloser(d, closer)
+	return packp.NewUploadPackResponseWithPackfile(req, ioutil.NewContextReadCloser(ctx, rc)), nil
+}
+
+// Close does nothing.
+func (s *upSession) Close() error {
+	return nil
+}
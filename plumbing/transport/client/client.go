@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/bundle"
 	"github.com/go-git/go-git/v5/plumbing/transport/file"
 	"github.com/go-git/go-git/v5/plumbing/transport/git"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
@@ -32,13 +33,18 @@ func InstallProtocol(scheme string, c transport.Transport) {
 }
 
 // NewClient returns the appropriate client among of the set of known protocols:
-// http://, https://, ssh:// and file://.
+// http://, https://, ssh:// and file://. Local regular files starting with a
+// bundle signature are read as bundle files.
 // See `InstallProtocol` to add or modify protocols.
 func NewClient(endpoint *transport.Endpoint) (transport.Transport, error) {
 	return getTransport(endpoint)
 }
 
 func getTransport(endpoint *transport.Endpoint) (transport.Transport, error) {
+	if bundle.IsBundle(endpoint) {
+		return bundle.DefaultClient, nil
+	}
+
 	f, ok := Protocols[endpoint.Protocol]
 	if !ok {
 		return nil, fmt.Errorf("unsupported scheme %q", endpoint.Protocol)
@@ -2,9 +2,12 @@ package client
 
 import (
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/bundle"
 
 	. "gopkg.in/check.v1"
 )
@@ -41,6 +44,41 @@ func (s *ClientSuite) TestNewClientNil(c *C) {
 	c.Assert(err, NotNil)
 }
 
+func (s *ClientSuite) TestNewClientBundle(c *C) {
+	dir := c.MkDir()
+	tests := []struct {
+		path    string
+		content string
+		bundle  bool
+	}{
+		{"repo", "# v2 git bundle\n", true},
+		{"repo-v3.bundle", "# v3 git bundle\n", true},
+		{"not-a.bundle", "PACK", false},
+		{"empty.bundle", "", false},
+	}
+
+	for _, t := range tests {
+		path := filepath.Join(dir, t.path)
+		c.Assert(os.WriteFile(path, []byte(t.content), 0644), IsNil)
+
+		e, err := transport.NewEndpoint(path)
+		c.Assert(err, IsNil)
+
+		output, err := NewClient(e)
+		c.Assert(err, IsNil)
+		c.Assert(output == bundle.DefaultClient, Equals, t.bundle, Commentf("path: %s", t.path))
+	}
+
+	path := filepath.Join(dir, "dir.bundle")
+	c.Assert(os.Mkdir(path, 0755), IsNil)
+	e, err := transport.NewEndpoint(path)
+	c.Assert(err, IsNil)
+
+	output, err := NewClient(e)
+	c.Assert(err, IsNil)
+	c.Assert(output == bundle.DefaultClient, Equals, false)
+}
+
 func (s *ClientSuite) TestInstallProtocol(c *C) {
 	InstallProtocol("newscheme", &dummyClient{})
 	c.Assert(Protocols["newscheme"], NotNil)
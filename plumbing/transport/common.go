@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/url"
 	"path/filepath"
 	"strconv"
@@ -27,6 +28,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
 var (
@@ -37,6 +39,7 @@ var (
 	ErrEmptyUploadPackRequest = errors.New("empty git-upload-pack given")
 	ErrInvalidAuthMethod      = errors.New("invalid auth method")
 	ErrAlreadyConnected       = errors.New("session already established")
+	ErrMissingPrerequisites   = errors.New("missing prerequisite objects")
 )
 
 const (
@@ -84,6 +87,53 @@ type UploadPackSession interface {
 	UploadPack(context.Context, *packp.UploadPackRequest) (*packp.UploadPackResponse, error)
 }
 
+// PrerequisitesSession is implemented by the upload-pack sessions serving a
+// packfile that depends on objects the client must already have, such as
+// the ones reading bundles. The packfile can not be used unless all the
+// prerequisites are present in the client.
+type PrerequisitesSession interface {
+	// Prerequisites returns the objects the client must have.
+	Prerequisites(context.Context) ([]plumbing.Hash, error)
+}
+
+// UnbornHeadSession is implemented by the upload-pack sessions able to tell
+// the branch HEAD of an empty repository points to, when the server
+// advertises it with the symref capability although it has no commit.
+type UnbornHeadSession interface {
+	// UnbornHead returns the branch HEAD points to, once AdvertisedReferences
+	// returned ErrEmptyRemoteRepository, or an empty name if unknown.
+	UnbornHead() plumbing.ReferenceName
+}
+
+// LocalStorageSession is implemented by the upload-pack sessions building
+// the packfile on the client side, such as the ones of the dumb HTTP
+// protocol. They use the local storage to skip the objects already present.
+type LocalStorageSession interface {
+	// SetLocalStorage sets the storage the fetched objects are written to.
+	SetLocalStorage(storer.EncodedObjectStorer)
+}
+
+// Negotiator provides the haves of an upload-pack request a batch at a time,
+// as the negotiation with the server progresses, see NegotiatorSession.
+type Negotiator interface {
+	// Next returns up to n commits to send as haves, none once there is
+	// nothing left to send.
+	Next(n int) ([]plumbing.Hash, error)
+	// Ack marks h as a commit the server has in common with the client, so
+	// its ancestors are not sent.
+	Ack(h plumbing.Hash) error
+}
+
+// NegotiatorSession is implemented by the upload-pack sessions able to
+// negotiate the haves in several rounds, instead of sending them all at once.
+// It requires the multi_ack_detailed capability to be requested.
+type NegotiatorSession interface {
+	// NegotiateUploadPack is like UploadPack, but the haves are taken from
+	// the negotiator instead of the request, until the server has enough of
+	// them to build the packfile.
+	NegotiateUploadPack(context.Context, *packp.UploadPackRequest, Negotiator) (*packp.UploadPackResponse, error)
+}
+
 // ReceivePackSession represents a git-receive-pack session.
 // A git-receive-pack session has two steps: reference discovery
 // (AdvertisedReferences) and receiving pack (ReceivePack).
@@ -119,6 +169,9 @@ type Endpoint struct {
 	CaBundle []byte
 	// Proxy provides info required for connecting to a proxy.
 	Proxy ProxyOptions
+	// Timeouts are the time limits of the connection, the non zero ones
+	// override the ones of the client.
+	Timeouts Timeouts
 }
 
 type ProxyOptions struct {
@@ -159,6 +212,10 @@ var defaultPorts = map[string]int{
 
 // String returns a string representation of the Git URL.
 func (u *Endpoint) String() string {
+	if u.isSCPLike() {
+		return u.scpLikeString()
+	}
+
 	var buf bytes.Buffer
 	if u.Protocol != "" {
 		buf.WriteString(u.Protocol)
@@ -198,6 +255,47 @@ func (u *Endpoint) String() string {
 	return buf.String()
 }
 
+// isSCPLike returns whether the endpoint is written as a SCP-like URL, the
+// only form holding a path relative to the home directory of the user.
+func (u *Endpoint) isSCPLike() bool {
+	return u.Protocol == "ssh" && u.Host != "" && u.Password == "" &&
+		u.Path != "" && u.Path[0] != '/' && u.Path[0] != '~'
+}
+
+// scpLikeString returns the endpoint as a SCP-like URL. As git does, a port
+// other than the default one is written along with the host in square
+// brackets, [host:port]:path, or [[::1]:port]:path for the IPv6 literals.
+func (u *Endpoint) scpLikeString() string {
+	var buf bytes.Buffer
+	if u.User != "" {
+		buf.WriteString(u.User)
+		buf.WriteByte('@')
+	}
+
+	if u.Port != 0 && u.Port != defaultPorts["ssh"] {
+		fmt.Fprintf(&buf, "[%s:%d]", u.Host, u.Port)
+	} else {
+		buf.WriteString(u.Host)
+	}
+
+	buf.WriteByte(':')
+	buf.WriteString(u.Path)
+	return buf.String()
+}
+
+// Hostname returns the host without the square brackets enclosing the IPv6
+// literals, as expected to connect to it.
+func (u *Endpoint) Hostname() string {
+	return strings.TrimSuffix(strings.TrimPrefix(u.Host, "["), "]")
+}
+
+// NewEndpoint parses the URL of a repository, either a URL with a scheme, a
+// SCP-like URL, such as user@host:path, or a local path.
+//
+// The paths of the SCP-like URLs not starting with a slash are relative to the
+// home directory of the user, so they are kept relative. The paths starting
+// with /~ of the ssh and git URLs are relative to a home directory too, the
+// leading slash is removed, same as git does.
 func NewEndpoint(endpoint string) (*Endpoint, error) {
 	if e, ok := parseSCPLike(endpoint); ok {
 		return e, nil
@@ -234,13 +332,18 @@ func parseURL(endpoint string) (*Endpoint, error) {
 		host = "[" + host + "]"
 	}
 
+	path := getPath(u)
+	if (u.Scheme == "ssh" || u.Scheme == "git") && strings.HasPrefix(path, "/~") {
+		path = path[1:]
+	}
+
 	return &Endpoint{
 		Protocol: u.Scheme,
 		User:     user,
 		Password: pass,
 		Host:     host,
 		Port:     getPort(u),
-		Path:     getPath(u),
+		Path:     path,
 	}, nil
 }
 
@@ -277,6 +380,18 @@ func parseSCPLike(endpoint string) (*Endpoint, bool) {
 	}
 
 	user, host, portStr, path := giturl.FindScpLikeComponents(endpoint)
+	if strings.HasPrefix(host, "[") {
+		// Besides the IPv6 literals, git allows a port in a bracketed host,
+		// such as [host:port]:path, or [[::1]:port]:path.
+		h, p, err := net.SplitHostPort(host[1 : len(host)-1])
+		if _, perr := strconv.Atoi(p); err == nil && perr == nil && portStr == "" {
+			host, portStr = h, p
+			if strings.Contains(h, ":") {
+				host = "[" + h + "]"
+			}
+		}
+	}
+
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
 		port = 22
@@ -308,7 +423,9 @@ func parseFile(endpoint string) (*Endpoint, bool) {
 }
 
 // UnsupportedCapabilities are the capabilities not supported by any client
-// implementation
+// implementation. The clients using a stateful connection, such as the file,
+// git and ssh ones, support multi_ack_detailed regardless, see
+// NegotiatorSession.
 var UnsupportedCapabilities = []capability.Capability{
 	capability.MultiACK,
 	capability.MultiACKDetailed,
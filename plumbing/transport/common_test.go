@@ -1,12 +1,14 @@
 package transport
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 
@@ -95,7 +97,7 @@ func (s *SuiteCommon) TestNewEndpointSCPLike(c *C) {
 	c.Assert(e.Host, Equals, "github.com")
 	c.Assert(e.Port, Equals, 22)
 	c.Assert(e.Path, Equals, "user/repository.git")
-	c.Assert(e.String(), Equals, "ssh://git@github.com/user/repository.git")
+	c.Assert(e.String(), Equals, "git@github.com:user/repository.git")
 }
 
 func (s *SuiteCommon) TestNewEndpointSCPLikeWithNumericPath(c *C) {
@@ -107,7 +109,7 @@ func (s *SuiteCommon) TestNewEndpointSCPLikeWithNumericPath(c *C) {
 	c.Assert(e.Host, Equals, "github.com")
 	c.Assert(e.Port, Equals, 22)
 	c.Assert(e.Path, Equals, "9999/user/repository.git")
-	c.Assert(e.String(), Equals, "ssh://git@github.com/9999/user/repository.git")
+	c.Assert(e.String(), Equals, "git@github.com:9999/user/repository.git")
 }
 
 func (s *SuiteCommon) TestNewEndpointSCPLikeWithPort(c *C) {
@@ -119,7 +121,7 @@ func (s *SuiteCommon) TestNewEndpointSCPLikeWithPort(c *C) {
 	c.Assert(e.Host, Equals, "github.com")
 	c.Assert(e.Port, Equals, 8080)
 	c.Assert(e.Path, Equals, "9999/user/repository.git")
-	c.Assert(e.String(), Equals, "ssh://git@github.com:8080/9999/user/repository.git")
+	c.Assert(e.String(), Equals, "git@[github.com:8080]:9999/user/repository.git")
 }
 
 func (s *SuiteCommon) TestNewEndpointFileAbs(c *C) {
@@ -234,6 +236,114 @@ func (s *SuiteCommon) TestNewEndpointIPv6(c *C) {
 	c.Assert(e.String(), Equals, "http://[::1]:8080/foo.git")
 }
 
+func (s *SuiteCommon) TestNewEndpointForms(c *C) {
+	for _, tc := range []struct {
+		url      string
+		user     string
+		host     string
+		port     int
+		path     string
+		hostname string
+		// str is the result of String, if it differs from url.
+		str string
+	}{
+		{url: "host:repo.git", host: "host", port: 22, path: "repo.git"},
+		{url: "user@host:repo.git", user: "user", host: "host", port: 22, path: "repo.git"},
+		{url: "user@host:relative/path", user: "user", host: "host", port: 22, path: "relative/path"},
+		{
+			url: "user@host:/abs/path", user: "user", host: "host", port: 22, path: "/abs/path",
+			str: "ssh://user@host/abs/path",
+		},
+		{
+			url: "user@host:~user/repo.git", user: "user", host: "host", port: 22, path: "~user/repo.git",
+			str: "ssh://user@host/~user/repo.git",
+		},
+		{
+			url: "user@host:2222:repo.git", user: "user", host: "host", port: 2222, path: "repo.git",
+			str: "user@[host:2222]:repo.git",
+		},
+		{url: "[host:2222]:repo.git", host: "host", port: 2222, path: "repo.git"},
+		{url: "user@[host:2222]:repo.git", user: "user", host: "host", port: 2222, path: "repo.git"},
+		{
+			url: "[2001:db8::1]:repo.git", host: "[2001:db8::1]", port: 22, path: "repo.git",
+			hostname: "2001:db8::1",
+		},
+		{
+			url: "git@[2001:db8::1]:/srv/repo.git", user: "git", host: "[2001:db8::1]", port: 22,
+			path: "/srv/repo.git", hostname: "2001:db8::1", str: "ssh://git@[2001:db8::1]/srv/repo.git",
+		},
+		{
+			url: "[::1]:2222:repo.git", host: "[::1]", port: 2222, path: "repo.git",
+			hostname: "::1", str: "[[::1]:2222]:repo.git",
+		},
+		{
+			url: "git@[[2001:db8::1]:2222]:repo.git", user: "git", host: "[2001:db8::1]", port: 2222,
+			path: "repo.git", hostname: "2001:db8::1",
+		},
+		{url: "ssh://host/abs/path", host: "host", path: "/abs/path"},
+		{url: "ssh://host:2222/~user/repo.git", host: "host", port: 2222, path: "~user/repo.git"},
+		{url: "ssh://user@host/~/repo.git", user: "user", host: "host", path: "~/repo.git"},
+		{
+			url: "ssh://git@[2001:db8::1]:2222/repo.git", user: "git", host: "[2001:db8::1]", port: 2222,
+			path: "/repo.git", hostname: "2001:db8::1",
+		},
+		{url: "git://host/~user/repo.git", host: "host", path: "~user/repo.git"},
+		{url: "https://host/~user/repo.git", host: "host", path: "/~user/repo.git"},
+	} {
+		comment := Commentf("url: %s", tc.url)
+		e, err := NewEndpoint(tc.url)
+		c.Assert(err, IsNil, comment)
+		c.Assert(e.User, Equals, tc.user, comment)
+		c.Assert(e.Host, Equals, tc.host, comment)
+		c.Assert(e.Port, Equals, tc.port, comment)
+		c.Assert(e.Path, Equals, tc.path, comment)
+
+		hostname := tc.hostname
+		if hostname == "" {
+			hostname = tc.host
+		}
+
+		c.Assert(e.Hostname(), Equals, hostname, comment)
+
+		str := tc.str
+		if str == "" {
+			str = tc.url
+		}
+
+		c.Assert(e.String(), Equals, str, comment)
+
+		// The parsing of the string results in the same endpoint, but the port
+		// of the SCP-like URLs, always set.
+		r, err := NewEndpoint(e.String())
+		c.Assert(err, IsNil, comment)
+		c.Assert(r.User, Equals, tc.user, comment)
+		c.Assert(r.Host, Equals, tc.host, comment)
+		c.Assert(r.Path, Equals, tc.path, comment)
+		c.Assert(r.String(), Equals, e.String(), comment)
+	}
+}
+
+func (s *SuiteCommon) TestTimeoutsOverride(c *C) {
+	t := Timeouts{Dial: time.Second, Idle: time.Minute}
+	c.Assert(t.Override(Timeouts{Idle: time.Second, Operation: time.Hour}), Equals, Timeouts{
+		Dial:      time.Second,
+		Idle:      time.Second,
+		Operation: time.Hour,
+	})
+
+	c.Assert(t.Override(Timeouts{}), Equals, t)
+}
+
+func (s *SuiteCommon) TestTimeoutError(c *C) {
+	var err error = &TimeoutError{Phase: PhaseNegotiation, Timeout: time.Second, Idle: true}
+	c.Assert(errors.Is(err, ErrTimeout), Equals, true)
+	c.Assert(err.Error(), Equals, "timeout during negotiation: no data received in 1s")
+
+	err = fmt.Errorf("fetch: %w", &TimeoutError{Phase: PhasePackTransfer, Timeout: time.Minute})
+	c.Assert(errors.Is(err, ErrTimeout), Equals, true)
+	c.Assert(err.Error(), Equals, "fetch: timeout during pack transfer: operation exceeded 1m0s")
+}
+
 func FuzzNewEndpoint(f *testing.F) {
 	f.Add("http://127.0.0.1:8080/foo.git")
 	f.Add("http://[::1]:8080/foo.git")
@@ -245,3 +355,43 @@ func FuzzNewEndpoint(f *testing.F) {
 		NewEndpoint(input)
 	})
 }
+
+func (s *SuiteCommon) TestAuthenticationErrorIs(c *C) {
+	err := error(&AuthenticationError{Scheme: "Basic", Message: "denied"})
+	c.Assert(errors.Is(err, ErrAuthenticationRequired), Equals, true)
+	c.Assert(errors.Is(err, ErrAuthorizationFailed), Equals, false)
+	c.Assert(err, ErrorMatches, "authentication required: denied")
+
+	err = &AuthenticationError{Denied: true}
+	c.Assert(errors.Is(err, ErrAuthenticationRequired), Equals, false)
+	c.Assert(errors.Is(err, ErrAuthorizationFailed), Equals, true)
+	c.Assert(err, ErrorMatches, "authorization failed")
+
+	inner := errors.New("ssh: unable to authenticate")
+	err = fmt.Errorf("fetch: %w", &AuthenticationError{Err: inner})
+	c.Assert(errors.Is(err, inner), Equals, true)
+
+	var authErr *AuthenticationError
+	c.Assert(errors.As(err, &authErr), Equals, true)
+}
+
+func (s *SuiteCommon) TestRepositoryNotFoundErrorIs(c *C) {
+	ep, err := NewEndpoint("https://github.com/git-fixtures/missing")
+	c.Assert(err, IsNil)
+
+	err = &RepositoryNotFoundError{Endpoint: ep, Message: "Repository not found."}
+	c.Assert(errors.Is(err, ErrRepositoryNotFound), Equals, true)
+	c.Assert(err, ErrorMatches, "repository not found: Repository not found.")
+}
+
+func (s *SuiteCommon) TestRateLimitedError(c *C) {
+	err := &RateLimitedError{RetryAfter: time.Minute, Message: "slow down"}
+	c.Assert(err, ErrorMatches, "rate limit exceeded, retry after 1m0s: slow down")
+}
+
+func (s *SuiteCommon) TestProtocolError(c *C) {
+	inner := errors.New("access denied")
+	err := &ProtocolError{Message: "access denied", Err: inner}
+	c.Assert(err, ErrorMatches, "remote error: access denied")
+	c.Assert(errors.Is(err, inner), Equals, true)
+}
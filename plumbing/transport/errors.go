@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+)
+
+// AuthenticationError is returned when the server requires credentials, or
+// rejects the ones supplied. It matches ErrAuthenticationRequired with
+// errors.Is, or ErrAuthorizationFailed if the access was denied.
+type AuthenticationError struct {
+	// Endpoint is the endpoint of the server.
+	Endpoint *Endpoint
+	// Scheme is the authentication scheme asked for by the server, such as
+	// "Basic" or "Bearer" over HTTP, or the name of the auth method rejected
+	// over SSH.
+	Scheme string
+	// Realm is the realm of the HTTP authentication, if any.
+	Realm string
+	// CredentialsSupplied is whether credentials were sent to the server.
+	CredentialsSupplied bool
+	// Denied is whether the server denied the access to the repository with
+	// the credentials supplied, as a 403 HTTP response does, instead of
+	// asking for credentials.
+	Denied bool
+	// Message is the message of the server, if any.
+	Message string
+	// Err is the error reported by the transport, if any.
+	Err error
+}
+
+func (e *AuthenticationError) Error() string {
+	msg := ErrAuthenticationRequired.Error()
+	if e.Denied {
+		msg = ErrAuthorizationFailed.Error()
+	}
+
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("%s: %s", msg, e.Err)
+	case e.Message != "":
+		return fmt.Sprintf("%s: %s", msg, e.Message)
+	}
+
+	return msg
+}
+
+// Is returns whether target is ErrAuthenticationRequired, or
+// ErrAuthorizationFailed if the access was denied.
+func (e *AuthenticationError) Is(target error) bool {
+	if e.Denied {
+		return target == ErrAuthorizationFailed
+	}
+
+	return target == ErrAuthenticationRequired
+}
+
+// Unwrap returns the error reported by the transport.
+func (e *AuthenticationError) Unwrap() error {
+	return e.Err
+}
+
+// RepositoryNotFoundError is returned when the repository does not exist on
+// the server, or is hidden to the client. It matches ErrRepositoryNotFound
+// with errors.Is.
+type RepositoryNotFoundError struct {
+	// Endpoint is the endpoint of the repository.
+	Endpoint *Endpoint
+	// Message is the message of the server, if any.
+	Message string
+}
+
+func (e *RepositoryNotFoundError) Error() string {
+	if e.Message == "" {
+		return ErrRepositoryNotFound.Error()
+	}
+
+	return fmt.Sprintf("%s: %s", ErrRepositoryNotFound, e.Message)
+}
+
+// Is returns whether target is ErrRepositoryNotFound.
+func (e *RepositoryNotFoundError) Is(target error) bool {
+	return target == ErrRepositoryNotFound
+}
+
+// RateLimitedError is returned when the server rejects a request because the
+// client exceeded its rate limit.
+type RateLimitedError struct {
+	// Endpoint is the endpoint of the server.
+	Endpoint *Endpoint
+	// RetryAfter is the time to wait before retrying, as given by the
+	// server, zero if unknown.
+	RetryAfter time.Duration
+	// Message is the message of the server, if any.
+	Message string
+}
+
+func (e *RateLimitedError) Error() string {
+	msg := "rate limit exceeded"
+	if e.RetryAfter > 0 {
+		msg = fmt.Sprintf("%s, retry after %s", msg, e.RetryAfter)
+	}
+
+	if e.Message == "" {
+		return msg
+	}
+
+	return fmt.Sprintf("%s: %s", msg, e.Message)
+}
+
+// ProtocolError is returned when the server reports an error other than the
+// ones with a dedicated type, with a message sent over the protocol or
+// written to the standard error of the remote command.
+type ProtocolError struct {
+	// Endpoint is the endpoint of the server.
+	Endpoint *Endpoint
+	// Message is the message of the server, as it was sent.
+	Message string
+	// Err is the error the message was read from, such as a
+	// *pktline.ErrorLine, if any.
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("remote error: %s", e.Message)
+}
+
+// Unwrap returns the error the message was read from.
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// NewServerError returns err as a *ProtocolError with the message of the
+// server if it is, or wraps, a *pktline.ErrorLine: an ERR pkt-line, or a
+// message of the error channel of the sideband. Otherwise err is returned
+// as is.
+func NewServerError(ep *Endpoint, err error) error {
+	var line *pktline.ErrorLine
+	if !errors.As(err, &line) {
+		return err
+	}
+
+	var perr *ProtocolError
+	if errors.As(err, &perr) {
+		return err
+	}
+
+	return &ProtocolError{Endpoint: ep, Message: line.Text, Err: err}
+}
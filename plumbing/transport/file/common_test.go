@@ -1,6 +1,7 @@
 package file
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -29,9 +30,19 @@ func (s *CommonSuite) SetUpSuite(c *C) {
 	s.ReceivePackBin = filepath.Join(s.tmpDir, "git-receive-pack")
 	s.UploadPackBin = filepath.Join(s.tmpDir, "git-upload-pack")
 	bin := filepath.Join(s.tmpDir, "go-git")
+
+	// The binary is built with the go-git of this tree, through a workspace.
+	root, err := filepath.Abs("../../..")
+	c.Assert(err, IsNil)
+	work := filepath.Join(s.tmpDir, "go.work")
+	c.Assert(os.WriteFile(work, []byte(fmt.Sprintf(
+		"go 1.21\n\nuse (\n\t%s\n\t%s\n)\n", root, filepath.Join(root, "cli", "go-git"))), 0644), IsNil)
+
 	cmd := exec.Command("go", "build", "-o", bin)
 	cmd.Dir = "../../../cli/go-git"
-	c.Assert(cmd.Run(), IsNil)
+	cmd.Env = append(os.Environ(), "GOWORK="+work, "GOFLAGS=")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
 	c.Assert(os.Symlink(bin, s.ReceivePackBin), IsNil)
 	c.Assert(os.Symlink(bin, s.UploadPackBin), IsNil)
 }
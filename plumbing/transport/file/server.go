@@ -20,7 +20,7 @@ func ServeUploadPack(path string) error {
 	}
 
 	// TODO: define and implement a server-side AuthMethod
-	s, err := server.DefaultServer.NewUploadPackSession(ep, nil)
+	s, err := newServer().NewUploadPackSession(ep, nil)
 	if err != nil {
 		return fmt.Errorf("error creating session: %s", err)
 	}
@@ -38,7 +38,7 @@ func ServeReceivePack(path string) error {
 	}
 
 	// TODO: define and implement a server-side AuthMethod
-	s, err := server.DefaultServer.NewReceivePackSession(ep, nil)
+	s, err := newServer().NewReceivePackSession(ep, nil)
 	if err != nil {
 		return fmt.Errorf("error creating session: %s", err)
 	}
@@ -46,6 +46,17 @@ func ServeReceivePack(path string) error {
 	return common.ServeReceivePack(srvCmd, s)
 }
 
+// newServer returns the server serving the ref namespace of the
+// GIT_NAMESPACE environment variable, if set, as git does.
+func newServer() transport.Transport {
+	ns := os.Getenv("GIT_NAMESPACE")
+	if ns == "" {
+		return server.DefaultServer
+	}
+
+	return server.NewServerWithOptions(server.DefaultLoader, &server.Options{Namespace: ns})
+}
+
 var srvCmd = common.ServerCommand{
 	Stdin:  os.Stdin,
 	Stdout: ioutil.WriteNopCloser(os.Stdout),
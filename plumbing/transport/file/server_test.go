@@ -73,3 +73,42 @@ func (s *ServerSuite) checkExecPerm(c *C) bool {
 	c.Assert(err, IsNil)
 	return (info.Mode().Perm() & userExecPermMask) == userExecPermMask
 }
+
+func (s *ServerSuite) TestPushNamespace(c *C) {
+	if !s.checkExecPerm(c) {
+		c.Skip("go-git binary has not execution permissions")
+	}
+
+	dst := c.MkDir()
+	out, err := exec.Command("git", "init", "--bare", dst).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	cmd := exec.Command("git", "--namespace=foo", "push",
+		"--receive-pack", s.ReceivePackBin,
+		dst, "refs/heads/master:refs/heads/master",
+	)
+	cmd.Dir = s.SrcPath
+	out, err = cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("combined stdout and stderr:\n%s\n", out))
+
+	cmd = exec.Command("git", "for-each-ref", "--format=%(refname)")
+	cmd.Dir = dst
+	out, err = cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	c.Assert(string(out), Equals, "refs/namespaces/foo/refs/heads/master\n")
+
+	// The refs are listed within the namespace only.
+	cmd = exec.Command("git", "--namespace=foo", "ls-remote",
+		"--upload-pack", s.UploadPackBin, dst,
+	)
+	out, err = cmd.Output()
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Matches, "[0-9a-f]{40}\trefs/heads/master\n")
+
+	cmd = exec.Command("git", "--namespace=bar", "ls-remote",
+		"--upload-pack", s.UploadPackBin, dst,
+	)
+	out, err = cmd.Output()
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "")
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/transport"
@@ -13,11 +14,43 @@ import (
 )
 
 // DefaultClient is the default git client.
-var DefaultClient = common.NewClient(&runner{})
+var DefaultClient = NewClient(nil)
 
 const DefaultPort = 9418
 
-type runner struct{}
+// DefaultKeepAlive is the default interval between the TCP keepalive probes.
+const DefaultKeepAlive = 15 * time.Second
+
+// ClientOptions configures the git client.
+type ClientOptions struct {
+	// Timeouts are the time limits of the connections, the ones of the
+	// endpoints override them.
+	Timeouts transport.Timeouts
+	// KeepAlive is the interval between the TCP keepalive probes, zero means
+	// DefaultKeepAlive and a negative value disables them.
+	KeepAlive time.Duration
+}
+
+// NewClient creates a new git client with the given options, the default
+// ones are used if nil.
+func NewClient(o *ClientOptions) transport.Transport {
+	r := &runner{}
+	if o != nil {
+		r.opts = *o
+	}
+
+	if r.opts.KeepAlive == 0 {
+		r.opts.KeepAlive = DefaultKeepAlive
+	}
+
+	return common.NewClientWithOptions(r, &common.ClientOptions{
+		Timeouts: r.opts.Timeouts,
+	})
+}
+
+type runner struct {
+	opts ClientOptions
+}
 
 // Command returns a new Command for the given cmd in the given Endpoint
 func (r *runner) Command(cmd string, ep *transport.Endpoint, auth transport.AuthMethod) (common.Command, error) {
@@ -25,7 +58,14 @@ func (r *runner) Command(cmd string, ep *transport.Endpoint, auth transport.Auth
 	if auth != nil {
 		return nil, transport.ErrInvalidAuthMethod
 	}
-	c := &command{command: cmd, endpoint: ep}
+	c := &command{
+		command:  cmd,
+		endpoint: ep,
+		dialer: net.Dialer{
+			Timeout:   r.opts.Timeouts.Override(ep.Timeouts).Dial,
+			KeepAlive: r.opts.KeepAlive,
+		},
+	}
 	if err := c.connect(); err != nil {
 		return nil, err
 	}
@@ -37,6 +77,7 @@ type command struct {
 	connected bool
 	command   string
 	endpoint  *transport.Endpoint
+	dialer    net.Dialer
 }
 
 // Start executes the command sending the required message to the TCP connection
@@ -47,7 +88,7 @@ func (c *command) Start() error {
 	}
 	host := c.endpoint.Host
 	if c.endpoint.Port != DefaultPort {
-		host = net.JoinHostPort(c.endpoint.Host, strconv.Itoa(c.endpoint.Port))
+		host = net.JoinHostPort(c.endpoint.Hostname(), strconv.Itoa(c.endpoint.Port))
 	}
 
 	req.Host = host
@@ -61,7 +102,7 @@ func (c *command) connect() error {
 	}
 
 	var err error
-	c.conn, err = net.Dial("tcp", c.getHostWithPort())
+	c.conn, err = c.dialer.Dial("tcp", c.getHostWithPort())
 	if err != nil {
 		return err
 	}
@@ -71,7 +112,7 @@ func (c *command) connect() error {
 }
 
 func (c *command) getHostWithPort() string {
-	host := c.endpoint.Host
+	host := c.endpoint.Hostname()
 	port := c.endpoint.Port
 	if port <= 0 {
 		port = DefaultPort
@@ -2,7 +2,10 @@ package git
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
@@ -11,6 +14,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
@@ -110,3 +116,197 @@ func freePort() (int, error) {
 
 	return l.Addr().(*net.TCPAddr).Port, l.Close()
 }
+
+type TimeoutSuite struct {
+	listener net.Listener
+	closed   chan struct{}
+}
+
+var _ = Suite(&TimeoutSuite{})
+
+func (s *TimeoutSuite) SetUpTest(c *C) {
+	var err error
+	s.listener, err = net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+	s.closed = make(chan struct{})
+}
+
+func (s *TimeoutSuite) TearDownTest(c *C) {
+	close(s.closed)
+	_ = s.listener.Close()
+}
+
+// serve serves the connections with handle, keeping them open until the end
+// of the test.
+func (s *TimeoutSuite) serve(c *C, handle func(conn net.Conn)) *transport.Endpoint {
+	l, closed := s.listener, s.closed
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				handle(conn)
+				<-closed
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	ep, err := transport.NewEndpoint(fmt.Sprintf("git://%s/repo", l.Addr()))
+	c.Assert(err, IsNil)
+	return ep
+}
+
+const timeoutTestHash = "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"
+
+func advertise(conn net.Conn, caps string) {
+	e := pktline.NewEncoder(conn)
+	_ = e.Encodef("%s HEAD\x00%s\n", timeoutTestHash, caps)
+	_ = e.Encodef("%s refs/heads/master\n", timeoutTestHash)
+	_ = e.Flush()
+}
+
+// readUntil reads the pkt-lines sent by the client up to the line given.
+func readUntil(conn net.Conn, line string) {
+	sc := pktline.NewScanner(conn)
+	for sc.Scan() {
+		if string(sc.Bytes()) == line {
+			return
+		}
+	}
+}
+
+func (s *TimeoutSuite) newSession(c *C, ep *transport.Endpoint, o *ClientOptions) transport.UploadPackSession {
+	sess, err := NewClient(o).NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+	return sess
+}
+
+func assertTimeout(c *C, err error, phase transport.Phase, timeout time.Duration, idle bool) {
+	c.Assert(errors.Is(err, transport.ErrTimeout), Equals, true, Commentf("%v", err))
+
+	var terr *transport.TimeoutError
+	c.Assert(errors.As(err, &terr), Equals, true)
+	c.Assert(terr.Phase, Equals, phase)
+	c.Assert(terr.Timeout, Equals, timeout)
+	c.Assert(terr.Idle, Equals, idle)
+}
+
+func (s *TimeoutSuite) TestIdleAdvertisement(c *C) {
+	ep := s.serve(c, func(conn net.Conn) {})
+
+	sess := s.newSession(c, ep, &ClientOptions{
+		Timeouts: transport.Timeouts{Idle: 100 * time.Millisecond},
+	})
+	defer sess.Close()
+
+	_, err := sess.AdvertisedReferences()
+	assertTimeout(c, err, transport.PhaseAdvertisement, 100*time.Millisecond, true)
+}
+
+func (s *TimeoutSuite) TestIdleEndpointOverride(c *C) {
+	ep := s.serve(c, func(conn net.Conn) {})
+	ep.Timeouts.Idle = 100 * time.Millisecond
+
+	sess := s.newSession(c, ep, &ClientOptions{
+		Timeouts: transport.Timeouts{Idle: time.Hour},
+	})
+	defer sess.Close()
+
+	_, err := sess.AdvertisedReferences()
+	assertTimeout(c, err, transport.PhaseAdvertisement, 100*time.Millisecond, true)
+}
+
+func (s *TimeoutSuite) TestIdleNegotiation(c *C) {
+	ep := s.serve(c, func(conn net.Conn) {
+		advertise(conn, "multi_ack_detailed")
+	})
+
+	ep.Timeouts.Idle = 100 * time.Millisecond
+	sess := s.newSession(c, ep, nil)
+	defer sess.Close()
+
+	ar, err := sess.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
+	req.Wants = []plumbing.Hash{plumbing.NewHash(timeoutTestHash)}
+
+	haves := []plumbing.Hash{plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")}
+	_, err = sess.(transport.NegotiatorSession).NegotiateUploadPack(context.Background(), req,
+		&sliceNegotiator{haves: haves})
+	assertTimeout(c, err, transport.PhaseNegotiation, 100*time.Millisecond, true)
+}
+
+func (s *TimeoutSuite) TestIdlePackTransfer(c *C) {
+	ep := s.serve(c, func(conn net.Conn) {
+		advertise(conn, "ofs-delta")
+		readUntil(conn, "done\n")
+		_ = pktline.NewEncoder(conn).Encodef("NAK\n")
+		_, _ = conn.Write([]byte("PACK\x00\x00\x00\x02"))
+	})
+
+	ep.Timeouts.Idle = 100 * time.Millisecond
+	sess := s.newSession(c, ep, nil)
+	defer sess.Close()
+
+	ar, err := sess.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
+	req.Wants = []plumbing.Hash{plumbing.NewHash(timeoutTestHash)}
+
+	resp, err := sess.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	defer resp.Close()
+
+	_, err = io.ReadAll(resp)
+	assertTimeout(c, err, transport.PhasePackTransfer, 100*time.Millisecond, true)
+}
+
+func (s *TimeoutSuite) TestOperation(c *C) {
+	ep := s.serve(c, func(conn net.Conn) {
+		// A pkt-line never completed, sent slower than the operation lasts
+		// but faster than the idle timeout.
+		_, _ = conn.Write([]byte("fff0"))
+		for i := 0; i < 100; i++ {
+			if _, err := conn.Write([]byte("x")); err != nil {
+				return
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	sess := s.newSession(c, ep, &ClientOptions{
+		Timeouts: transport.Timeouts{
+			Idle:      100 * time.Millisecond,
+			Operation: 200 * time.Millisecond,
+		},
+	})
+	defer sess.Close()
+
+	_, err := sess.AdvertisedReferences()
+	assertTimeout(c, err, transport.PhaseAdvertisement, 200*time.Millisecond, false)
+}
+
+type sliceNegotiator struct {
+	haves []plumbing.Hash
+}
+
+func (n *sliceNegotiator) Next(max int) ([]plumbing.Hash, error) {
+	if max > len(n.haves) {
+		max = len(n.haves)
+	}
+
+	haves := n.haves[:max]
+	n.haves = n.haves[max:]
+	return haves, nil
+}
+
+func (n *sliceNegotiator) Ack(plumbing.Hash) error {
+	return nil
+}
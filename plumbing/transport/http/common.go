@@ -2,18 +2,22 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
@@ -46,14 +50,7 @@ func advertisedReferences(ctx context.Context, s *session, serviceName string) (
 		s.endpoint.String(), infoRefsPath, serviceName,
 	)
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	s.ApplyAuthToRequest(req)
-	applyHeadersToRequest(req, nil, s.endpoint.Host, serviceName)
-	res, err := s.client.Do(req.WithContext(ctx))
+	res, err := s.sendRequest(ctx, http.MethodGet, url, nil, serviceName)
 	if err != nil {
 		return nil, err
 	}
@@ -61,12 +58,30 @@ func advertisedReferences(ctx context.Context, s *session, serviceName string) (
 	s.ModifyEndpointIfRedirect(res)
 	defer ioutil.CheckClose(res.Body, &err)
 
-	if err = NewErr(res); err != nil {
+	if err = s.newErr(res); err != nil {
 		return nil, err
 	}
 
+	body := bufio.NewReader(res.Body)
+	if !isSmartResponse(res, body, serviceName) {
+		// The server does not speak the smart protocol, such as when the
+		// repository is served as static files.
+		if serviceName != transport.UploadPackServiceName {
+			return nil, ErrDumbPushNotSupported
+		}
+
+		ar, err := dumbAdvertisedReferences(ctx, s, body)
+		if err != nil {
+			return nil, err
+		}
+
+		s.dumb = true
+		s.advRefs = ar
+		return ar, nil
+	}
+
 	ar := packp.NewAdvRefs()
-	if err = ar.Decode(res.Body); err != nil {
+	if err = ar.Decode(body); err != nil {
 		if err == packp.ErrEmptyAdvRefs {
 			err = transport.ErrEmptyRemoteRepository
 		}
@@ -82,6 +97,7 @@ func advertisedReferences(ctx context.Context, s *session, serviceName string) (
 	if ar.IsEmpty() &&
 		// Empty repositories are valid for git-receive-pack.
 		transport.ReceivePackServiceName != serviceName {
+		s.unbornHead = ar.HeadTarget()
 		return nil, transport.ErrEmptyRemoteRepository
 	}
 
@@ -176,6 +192,11 @@ type session struct {
 	client   *http.Client
 	endpoint *transport.Endpoint
 	advRefs  *packp.AdvRefs
+	// unbornHead is the branch HEAD of an empty repository points to, if
+	// advertised.
+	unbornHead plumbing.ReferenceName
+	// dumb is set when the server only speaks the dumb protocol.
+	dumb bool
 }
 
 func transportWithInsecureTLS(transport *http.Transport) {
@@ -300,6 +321,67 @@ func (s *session) ApplyAuthToRequest(req *http.Request) {
 	s.auth.SetAuth(req)
 }
 
+func (s *session) applyAuth(req *http.Request, refresh bool) error {
+	if a, ok := s.auth.(*TokenSourceAuth); ok {
+		return a.setAuth(req, refresh)
+	}
+
+	s.ApplyAuthToRequest(req)
+	return nil
+}
+
+// sendRequest sends a request for the given service to url, with the
+// content, if any, as body. If the server answers 401 Unauthorized and the
+// auth method is a TokenSourceAuth, the credentials are refreshed and the
+// request is sent once more; this is safe as the body is fully buffered and
+// the server did not process the rejected request.
+func (s *session) sendRequest(
+	ctx context.Context, method, url string, content *bytes.Buffer, requestType string,
+) (*http.Response, error) {
+
+	var payload []byte
+	if content != nil {
+		payload = content.Bytes()
+	}
+
+	res, err := s.sendRequestOnce(ctx, method, url, payload, content, requestType, false)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	if _, ok := s.auth.(*TokenSourceAuth); !ok {
+		return res, nil
+	}
+
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+
+	return s.sendRequestOnce(ctx, method, url, payload, content, requestType, true)
+}
+
+func (s *session) sendRequestOnce(
+	ctx context.Context, method, url string, payload []byte, content *bytes.Buffer,
+	requestType string, refresh bool,
+) (*http.Response, error) {
+
+	var body io.Reader
+	if content != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, plumbing.NewPermanentError(err)
+	}
+
+	applyHeadersToRequest(req, content, s.endpoint.Host, requestType)
+	if err := s.applyAuth(req, refresh); err != nil {
+		return nil, err
+	}
+
+	return s.client.Do(req)
+}
+
 func (s *session) ModifyEndpointIfRedirect(res *http.Response) {
 	if res.Request == nil {
 		return
@@ -404,6 +486,48 @@ func (a *TokenAuth) String() string {
 	return fmt.Sprintf("%s - %s", a.Name(), masked)
 }
 
+// TokenSourceAuth implements an http.AuthMethod that obtains the credentials
+// from a callback before each HTTP request, so short-lived credentials can be
+// renewed during long operations. If the server rejects the credentials with
+// a 401 status, the callback is invoked again to get new ones and the request
+// is retried once.
+type TokenSourceAuth struct {
+	// Source returns the value of the Authorization header, such as
+	// "Bearer <token>" or "Basic <base64 credentials>". refresh is true when
+	// the server rejected the previously returned value, and a new one must
+	// be obtained. The context is the one of the ongoing request.
+	Source func(ctx context.Context, refresh bool) (string, error)
+}
+
+// SetAuth sets the Authorization header returned by Source, errors are
+// ignored. The transport reports the errors of Source to the caller.
+func (a *TokenSourceAuth) SetAuth(r *http.Request) {
+	_ = a.setAuth(r, false)
+}
+
+func (a *TokenSourceAuth) setAuth(r *http.Request, refresh bool) error {
+	if a == nil || a.Source == nil {
+		return nil
+	}
+
+	value, err := a.Source(r.Context(), refresh)
+	if err != nil {
+		return fmt.Errorf("error obtaining credentials: %w", err)
+	}
+
+	r.Header.Set("Authorization", value)
+	return nil
+}
+
+// Name is name of the auth
+func (a *TokenSourceAuth) Name() string {
+	return "http-token-source-auth"
+}
+
+func (a *TokenSourceAuth) String() string {
+	return a.Name()
+}
+
 // Err is a dedicated error to return errors based on status code
 type Err struct {
 	Response *http.Response
@@ -411,7 +535,10 @@ type Err struct {
 }
 
 // NewErr returns a new Err based on a http response and closes response body
-// if needed
+// if needed. The responses asking for credentials or denying the access are
+// returned as a *transport.AuthenticationError, the missing repositories as a
+// *transport.RepositoryNotFoundError and the rate limited requests as a
+// *transport.RateLimitedError.
 func NewErr(r *http.Response) error {
 	if r.StatusCode >= http.StatusOK && r.StatusCode < http.StatusMultipleChoices {
 		return nil
@@ -431,16 +558,86 @@ func NewErr(r *http.Response) error {
 
 	switch r.StatusCode {
 	case http.StatusUnauthorized:
-		return fmt.Errorf("%w: %s", transport.ErrAuthenticationRequired, reason)
+		return newAuthenticationError(r, reason, false)
 	case http.StatusForbidden:
-		return fmt.Errorf("%w: %s", transport.ErrAuthorizationFailed, reason)
+		if isRateLimited(r) {
+			return &transport.RateLimitedError{RetryAfter: retryAfter(r), Message: reason}
+		}
+
+		return newAuthenticationError(r, reason, true)
 	case http.StatusNotFound:
-		return fmt.Errorf("%w: %s", transport.ErrRepositoryNotFound, reason)
+		return &transport.RepositoryNotFoundError{Message: reason}
+	case http.StatusTooManyRequests:
+		return &transport.RateLimitedError{RetryAfter: retryAfter(r), Message: reason}
 	}
 
 	return plumbing.NewUnexpectedError(&Err{r, reason})
 }
 
+// newErr returns the error of the response as NewErr does, for the endpoint
+// of the session.
+func (s *session) newErr(r *http.Response) error {
+	err := NewErr(r)
+	switch e := err.(type) {
+	case *transport.AuthenticationError:
+		e.Endpoint = s.endpoint
+	case *transport.RepositoryNotFoundError:
+		e.Endpoint = s.endpoint
+	case *transport.RateLimitedError:
+		e.Endpoint = s.endpoint
+	}
+
+	return err
+}
+
+var realmRegExp = regexp.MustCompile(`realm="([^"]*)"`)
+
+func newAuthenticationError(r *http.Response, reason string, denied bool) error {
+	e := &transport.AuthenticationError{Denied: denied, Message: reason}
+	if r.Request != nil {
+		e.CredentialsSupplied = r.Request.Header.Get("Authorization") != ""
+	}
+
+	// WWW-Authenticate: Basic realm="GitHub"
+	challenge := r.Header.Get("WWW-Authenticate")
+	e.Scheme, _, _ = strings.Cut(challenge, " ")
+	if m := realmRegExp.FindStringSubmatch(challenge); m != nil {
+		e.Realm = m[1]
+	}
+
+	return e
+}
+
+// isRateLimited returns whether a 403 response is a rate limited request,
+// as the ones of GitHub, which have a Retry-After header or no requests
+// remaining.
+func isRateLimited(r *http.Response) bool {
+	return r.Header.Get("Retry-After") != "" || r.Header.Get("X-RateLimit-Remaining") == "0"
+}
+
+// retryAfter returns the time to wait before retrying a rate limited
+// request, from the Retry-After header, in seconds or as a date, or else the
+// X-RateLimit-Reset one, as a Unix time.
+func retryAfter(r *http.Response) time.Duration {
+	if v := r.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+
+		if t, err := http.ParseTime(v); err == nil {
+			return max(time.Until(t), 0)
+		}
+	}
+
+	if v := r.Header.Get("X-RateLimit-Reset"); v != "" {
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return max(time.Until(time.Unix(secs, 0)), 0)
+		}
+	}
+
+	return 0
+}
+
 // StatusCode returns the status code of the response
 func (e *Err) StatusCode() int {
 	return e.Response.StatusCode
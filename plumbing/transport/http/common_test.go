@@ -1,19 +1,24 @@
 package http
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/http/cgi"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
@@ -69,6 +74,20 @@ func (s *ClientSuite) TestNewTokenAuth(c *C) {
 	c.Assert(req.Header.Get("Authorization"), Equals, "Bearer OAUTH-TOKEN-TEXT")
 }
 
+func (s *ClientSuite) TestNewTokenSourceAuth(c *C) {
+	a := &TokenSourceAuth{Source: func(ctx context.Context, refresh bool) (string, error) {
+		return "Bearer OAUTH-TOKEN-TEXT", nil
+	}}
+
+	c.Assert(a.Name(), Equals, "http-token-source-auth")
+	c.Assert(a.String(), Equals, "http-token-source-auth")
+
+	req, err := http.NewRequest("GET", "https://github.com/git-fixtures/basic", nil)
+	c.Assert(err, Equals, nil)
+	a.SetAuth(req)
+	c.Assert(req.Header.Get("Authorization"), Equals, "Bearer OAUTH-TOKEN-TEXT")
+}
+
 func (s *ClientSuite) TestNewErrOK(c *C) {
 	res := &http.Response{StatusCode: http.StatusOK}
 	err := NewErr(res)
@@ -87,6 +106,90 @@ func (s *ClientSuite) TestNewErrNotFound(c *C) {
 	s.testNewHTTPError(c, http.StatusNotFound, ".*repository not found.*")
 }
 
+func (s *ClientSuite) TestNewErrUnauthorizedTyped(c *C) {
+	req, _ := http.NewRequest("GET", "https://github.com/git-fixtures/basic", nil)
+	req.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+	res := &http.Response{
+		StatusCode: http.StatusUnauthorized,
+		Request:    req,
+		Header:     http.Header{"Www-Authenticate": []string{`Basic realm="GitHub"`}},
+		Body:       io.NopCloser(strings.NewReader("Invalid username or password.")),
+	}
+
+	err := NewErr(res)
+	c.Assert(errors.Is(err, transport.ErrAuthenticationRequired), Equals, true)
+
+	var authErr *transport.AuthenticationError
+	c.Assert(errors.As(err, &authErr), Equals, true)
+	c.Assert(authErr.Scheme, Equals, "Basic")
+	c.Assert(authErr.Realm, Equals, "GitHub")
+	c.Assert(authErr.CredentialsSupplied, Equals, true)
+	c.Assert(authErr.Denied, Equals, false)
+	c.Assert(authErr.Message, Equals, "Invalid username or password.")
+}
+
+func (s *ClientSuite) TestNewErrNotFoundTyped(c *C) {
+	res := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Body:       io.NopCloser(strings.NewReader("Repository not found.")),
+	}
+
+	err := NewErr(res)
+	c.Assert(errors.Is(err, transport.ErrRepositoryNotFound), Equals, true)
+
+	var notFound *transport.RepositoryNotFoundError
+	c.Assert(errors.As(err, &notFound), Equals, true)
+	c.Assert(notFound.Message, Equals, "Repository not found.")
+}
+
+func (s *ClientSuite) TestNewErrTooManyRequests(c *C) {
+	res := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"120"}},
+	}
+
+	var rateErr *transport.RateLimitedError
+	c.Assert(errors.As(NewErr(res), &rateErr), Equals, true)
+	c.Assert(rateErr.RetryAfter, Equals, 2*time.Minute)
+}
+
+func (s *ClientSuite) TestNewErrForbiddenRateLimited(c *C) {
+	reset := time.Now().Add(time.Hour).Unix()
+	res := &http.Response{
+		StatusCode: http.StatusForbidden,
+		Header: http.Header{
+			"X-Ratelimit-Remaining": []string{"0"},
+			"X-Ratelimit-Reset":     []string{strconv.FormatInt(reset, 10)},
+		},
+	}
+
+	err := NewErr(res)
+	c.Assert(errors.Is(err, transport.ErrAuthorizationFailed), Equals, false)
+
+	var rateErr *transport.RateLimitedError
+	c.Assert(errors.As(err, &rateErr), Equals, true)
+	c.Assert(rateErr.RetryAfter > 59*time.Minute, Equals, true)
+	c.Assert(rateErr.RetryAfter <= time.Hour, Equals, true)
+}
+
+func (s *ClientSuite) TestSessionErrEndpoint(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ep, err := transport.NewEndpoint(server.URL + "/missing.git")
+	c.Assert(err, IsNil)
+
+	session, err := DefaultClient.NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+
+	_, err = session.AdvertisedReferences()
+	var notFound *transport.RepositoryNotFoundError
+	c.Assert(errors.As(err, &notFound), Equals, true)
+	c.Assert(notFound.Endpoint, Equals, ep)
+}
+
 func (s *ClientSuite) TestNewHTTPError40x(c *C) {
 	s.testNewHTTPError(c, http.StatusPaymentRequired,
 		"unexpected client error.*")
@@ -0,0 +1,485 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+var (
+	// ErrDumbPushNotSupported is returned when pushing to a server that only
+	// speaks the dumb HTTP protocol.
+	ErrDumbPushNotSupported = errors.New("push is not supported by the dumb HTTP protocol")
+	// ErrDumbShallowNotSupported is returned when requesting a shallow fetch
+	// to a server that only speaks the dumb HTTP protocol.
+	ErrDumbShallowNotSupported = errors.New("shallow fetches are not supported by the dumb HTTP protocol")
+)
+
+const (
+	dumbHeadPath       = "HEAD"
+	dumbPackedRefsPath = "packed-refs"
+	dumbInfoPacksPath  = "objects/info/packs"
+)
+
+// isSmartResponse reports whether the response to the info/refs request
+// comes from a smart server. Servers not setting the content type properly
+// are recognized by the service announcement starting the body.
+func isSmartResponse(res *http.Response, body *bufio.Reader, serviceName string) bool {
+	contentType := fmt.Sprintf("application/x-%s-advertisement", serviceName)
+	if res.Header.Get("Content-Type") == contentType {
+		return true
+	}
+
+	prefix, _ := body.Peek(14)
+	return len(prefix) == 14 && bytes.Equal(prefix[4:], []byte("# service="))
+}
+
+// dumbAdvertisedReferences builds the advertised references of a dumb
+// server from the content of info/refs, packed-refs and HEAD.
+func dumbAdvertisedReferences(ctx context.Context, s *session, infoRefs io.Reader) (*packp.AdvRefs, error) {
+	ar := packp.NewAdvRefs()
+	if err := decodeDumbRefs(infoRefs, ar, false); err != nil {
+		return nil, err
+	}
+
+	packedRefs, ok, err := s.dumbGet(ctx, dumbPackedRefsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		err := decodeDumbRefs(packedRefs, ar, true)
+		_ = packedRefs.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ar.References) == 0 {
+		return nil, transport.ErrEmptyRemoteRepository
+	}
+
+	if err := dumbHead(ctx, s, ar); err != nil {
+		return nil, err
+	}
+
+	// The packfile is built by the client itself, using offset deltas.
+	if err := ar.Capabilities.Set(capability.OFSDelta); err != nil {
+		return nil, err
+	}
+
+	return ar, nil
+}
+
+// decodeDumbRefs adds to ar the references listed in r, either in the
+// info/refs format, "<hash> TAB <name>", or in the packed-refs one. The
+// references already present in ar are kept.
+func decodeDumbRefs(r io.Reader, ar *packp.AdvRefs, packed bool) error {
+	var last string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if packed && line[0] == '^' {
+			if last != "" && plumbing.IsHash(line[1:]) {
+				ar.Peeled[last] = plumbing.NewHash(line[1:])
+			}
+
+			continue
+		}
+
+		sep := "\t"
+		if packed {
+			sep = " "
+		}
+
+		id, name, ok := strings.Cut(line, sep)
+		if !ok || !plumbing.IsHash(id) {
+			return fmt.Errorf("malformed references line: %q", line)
+		}
+
+		last = ""
+		if peeled, ok := strings.CutSuffix(name, "^{}"); ok {
+			if _, ok := ar.Peeled[peeled]; !ok {
+				ar.Peeled[peeled] = plumbing.NewHash(id)
+			}
+
+			continue
+		}
+
+		if _, ok := ar.References[name]; ok {
+			continue
+		}
+
+		ar.References[name] = plumbing.NewHash(id)
+		last = name
+	}
+
+	return s.Err()
+}
+
+// dumbHead resolves the HEAD of the repository, from the HEAD file.
+func dumbHead(ctx context.Context, s *session, ar *packp.AdvRefs) (err error) {
+	r, ok, err := s.dumbGet(ctx, dumbHeadPath)
+	if err != nil || !ok {
+		return err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	head := strings.TrimSpace(string(content))
+	if target, ok := strings.CutPrefix(head, "ref: "); ok {
+		h, ok := ar.References[target]
+		if !ok {
+			// HEAD points to an unborn branch.
+			return nil
+		}
+
+		ar.Head = &h
+		return ar.Capabilities.Add(capability.SymRef, fmt.Sprintf("%s:%s", plumbing.HEAD, target))
+	}
+
+	if plumbing.IsHash(head) {
+		h := plumbing.NewHash(head)
+		ar.Head = &h
+	}
+
+	return nil
+}
+
+// dumbGet retrieves the file at the given path of the repository, ok is
+// false if it does not exist.
+func (s *session) dumbGet(ctx context.Context, path string) (r io.ReadCloser, ok bool, err error) {
+	url := fmt.Sprintf("%s/%s", s.endpoint.String(), path)
+	res, err := s.sendRequest(ctx, http.MethodGet, url, nil, "")
+	if err != nil {
+		if _, ok := err.(*plumbing.PermanentError); ok {
+			return nil, false, err
+		}
+
+		return nil, false, plumbing.NewUnexpectedError(err)
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		return nil, false, nil
+	}
+
+	if err := s.newErr(res); err != nil {
+		return nil, false, err
+	}
+
+	return res.Body, true, nil
+}
+
+// dumbUploadPack walks the history of the repository from the wanted
+// objects, retrieving every object not present in the client, and returns
+// them as a packfile.
+func (s *upSession) dumbUploadPack(ctx context.Context, req *packp.UploadPackRequest) (
+	*packp.UploadPackResponse, error) {
+
+	if len(req.Shallows) != 0 || (req.Depth != nil && req.Depth != packp.DepthCommits(0)) {
+		return nil, ErrDumbShallowNotSupported
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	o := &dumbObjects{
+		ctx:   ctx,
+		s:     s.session,
+		cache: memory.NewStorage(),
+	}
+
+	skip := func(h plumbing.Hash) bool {
+		return s.local != nil && s.local.HasEncodedObject(h) == nil
+	}
+
+	if s.local == nil && len(req.Haves) != 0 {
+		// Without the local storage, the client is expected to have every
+		// object reachable from the haves.
+		haves, err := o.walk(req.Haves, func(plumbing.Hash) bool { return false })
+		if err != nil {
+			return nil, err
+		}
+
+		have := make(map[plumbing.Hash]bool, len(haves))
+		for _, h := range haves {
+			have[h] = true
+		}
+
+		skip = func(h plumbing.Hash) bool { return have[h] }
+	}
+
+	objs, err := o.walk(req.Wants, skip)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(objs) == 0 {
+		return nil, transport.ErrEmptyUploadPackRequest
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		e := packfile.NewEncoder(pw, o.cache, false)
+		_, err := e.Encode(objs, config.DefaultPackWindow)
+		_ = pw.CloseWithError(err)
+	}()
+
+	return packp.NewUploadPackResponseWithPackfile(req, pr), nil
+}
+
+// dumbObjects retrieves the objects of a repository served by a dumb HTTP
+// server, either as loose objects or from its packfiles, and keeps them in
+// memory.
+type dumbObjects struct {
+	ctx   context.Context
+	s     *session
+	cache *memory.Storage
+
+	// packs are the packfiles of the server not retrieved yet, nil until
+	// objects/info/packs is read.
+	packs   []string
+	indexes map[string]*idxfile.MemoryIndex
+}
+
+// walk returns the objects reachable from the given ones, the objects for
+// which skip returns true and the ones reachable only from them are left
+// out. Submodule commits are never followed.
+func (o *dumbObjects) walk(from []plumbing.Hash, skip func(plumbing.Hash) bool) ([]plumbing.Hash, error) {
+	var result []plumbing.Hash
+	seen := make(map[plumbing.Hash]bool)
+	pending := append([]plumbing.Hash(nil), from...)
+	for len(pending) != 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[h] || skip(h) {
+			continue
+		}
+
+		seen[h] = true
+		obj, err := o.object(h)
+		if err != nil {
+			return nil, err
+		}
+
+		decoded, err := object.DecodeObject(o.cache, obj)
+		if err != nil {
+			return nil, err
+		}
+
+		switch v := decoded.(type) {
+		case *object.Commit:
+			pending = append(pending, v.ParentHashes...)
+			pending = append(pending, v.TreeHash)
+		case *object.Tree:
+			for _, e := range v.Entries {
+				if e.Mode != filemode.Submodule {
+					pending = append(pending, e.Hash)
+				}
+			}
+		case *object.Tag:
+			pending = append(pending, v.Target)
+		}
+
+		result = append(result, h)
+	}
+
+	return result, nil
+}
+
+// object returns the object with the given hash, retrieving it from the
+// server if it was not yet.
+func (o *dumbObjects) object(h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := o.cache.EncodedObject(plumbing.AnyObject, h)
+	if err != plumbing.ErrObjectNotFound {
+		return obj, err
+	}
+
+	found, err := o.fetchLoose(h)
+	if err == nil && !found {
+		found, err = o.fetchFromPacks(h)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w: %s", plumbing.ErrObjectNotFound, h)
+	}
+
+	return o.cache.EncodedObject(plumbing.AnyObject, h)
+}
+
+func (o *dumbObjects) fetchLoose(h plumbing.Hash) (found bool, err error) {
+	id := h.String()
+	r, ok, err := o.s.dumbGet(o.ctx, fmt.Sprintf("objects/%s/%s", id[:2], id[2:]))
+	if err != nil || !ok {
+		return false, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	or, err := objfile.NewReader(r)
+	if err != nil {
+		return false, err
+	}
+
+	defer ioutil.CheckClose(or, &err)
+	t, size, err := or.Header()
+	if err != nil {
+		return false, err
+	}
+
+	obj := o.cache.NewEncodedObject()
+	obj.SetType(t)
+	obj.SetSize(size)
+	w, err := obj.Writer()
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := io.Copy(w, or); err != nil {
+		return false, err
+	}
+
+	if err := w.Close(); err != nil {
+		return false, err
+	}
+
+	if obj.Hash() != h {
+		return false, fmt.Errorf("object %s has an unexpected hash %s", h, obj.Hash())
+	}
+
+	_, err = o.cache.SetEncodedObject(obj)
+	return true, err
+}
+
+// fetchFromPacks retrieves the packfile containing the object, all its
+// objects are kept.
+func (o *dumbObjects) fetchFromPacks(h plumbing.Hash) (bool, error) {
+	if o.packs == nil {
+		if err := o.listPacks(); err != nil {
+			return false, err
+		}
+	}
+
+	for i, pack := range o.packs {
+		idx, err := o.index(pack)
+		if err != nil {
+			return false, err
+		}
+
+		if ok, err := idx.Contains(h); err != nil || !ok {
+			if err != nil {
+				return false, err
+			}
+
+			continue
+		}
+
+		if err := o.fetchPack(pack); err != nil {
+			return false, err
+		}
+
+		o.packs = append(o.packs[:i], o.packs[i+1:]...)
+		delete(o.indexes, pack)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (o *dumbObjects) listPacks() (err error) {
+	o.packs = []string{}
+	o.indexes = make(map[string]*idxfile.MemoryIndex)
+
+	r, ok, err := o.s.dumbGet(o.ctx, dumbInfoPacksPath)
+	if err != nil || !ok {
+		return err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		name, ok := strings.CutPrefix(strings.TrimSpace(s.Text()), "P ")
+		if ok && strings.HasPrefix(name, "pack-") && strings.HasSuffix(name, ".pack") {
+			o.packs = append(o.packs, strings.TrimSuffix(name, ".pack"))
+		}
+	}
+
+	return s.Err()
+}
+
+func (o *dumbObjects) index(pack string) (idx *idxfile.MemoryIndex, err error) {
+	if idx, ok := o.indexes[pack]; ok {
+		return idx, nil
+	}
+
+	r, ok, err := o.s.dumbGet(o.ctx, fmt.Sprintf("objects/pack/%s.idx", pack))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("missing index of packfile %s", pack)
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	idx = idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(r).Decode(idx); err != nil {
+		return nil, err
+	}
+
+	o.indexes[pack] = idx
+	return idx, nil
+}
+
+func (o *dumbObjects) fetchPack(pack string) (err error) {
+	r, ok, err := o.s.dumbGet(o.ctx, fmt.Sprintf("objects/pack/%s.pack", pack))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("missing packfile %s", pack)
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	return packfile.UpdateObjectStorage(o.cache, r)
+}
+
+// SetLocalStorage sets the storage of the client, the objects present in it
+// are not retrieved when talking to a dumb server.
+func (s *upSession) SetLocalStorage(sto storer.EncodedObjectStorer) {
+	s.local = sto
+}
@@ -0,0 +1,219 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/go-git/go-git/v5/internal/test"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type DumbSuite struct {
+	fixtures.Suite
+
+	server   *httptest.Server
+	base     string
+	requests []string
+}
+
+var _ = Suite(&DumbSuite{})
+
+func (s *DumbSuite) SetUpSuite(c *C) {
+	if err := exec.Command("git", "--version").Run(); err != nil {
+		c.Skip("git command not found")
+	}
+
+	s.base = c.MkDir()
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.prepareRepository(c, fixtures.ByTag("unpacked").One(), "unpacked.git")
+
+	files := http.FileServer(http.Dir(s.base))
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests = append(s.requests, r.URL.Path)
+		files.ServeHTTP(w, r)
+	}))
+}
+
+func (s *DumbSuite) TearDownSuite(c *C) {
+	if s.server != nil {
+		s.server.Close()
+	}
+
+	s.Suite.TearDownSuite(c)
+}
+
+func (s *DumbSuite) SetUpTest(c *C) {
+	s.requests = nil
+}
+
+func (s *DumbSuite) prepareRepository(c *C, f *fixtures.Fixture, name string) {
+	fs := f.DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+
+	path := filepath.Join(s.base, name)
+	c.Assert(os.Rename(fs.Root(), path), IsNil)
+
+	cmd := exec.Command("git", "update-server-info")
+	cmd.Dir = path
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+}
+
+func (s *DumbSuite) requested(path string) bool {
+	for _, r := range s.requests {
+		if r == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *DumbSuite) newSession(c *C, name string) transport.UploadPackSession {
+	ep, err := transport.NewEndpoint(s.server.URL + "/" + name)
+	c.Assert(err, IsNil)
+
+	r, err := DefaultClient.NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+	return r
+}
+
+func (s *DumbSuite) uploadPack(c *C, r transport.UploadPackSession, req *packp.UploadPackRequest) *memory.Storage {
+	reader, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(reader.Close(), IsNil) }()
+
+	b, err := io.ReadAll(reader)
+	c.Assert(err, IsNil)
+
+	sto := memory.NewStorage()
+	c.Assert(packfile.UpdateObjectStorage(sto, bytes.NewReader(b)), IsNil)
+	return sto
+}
+
+func (s *DumbSuite) TestAdvertisedReferences(c *C) {
+	r := s.newSession(c, "basic.git")
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	c.Assert(ar.References["refs/heads/master"].String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	c.Assert(ar.References["refs/heads/branch"].String(), Equals, "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	c.Assert(ar.Head, NotNil)
+	c.Assert(ar.Head.String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	c.Assert(ar.Capabilities.Get(capability.SymRef), DeepEquals, []string{"HEAD:refs/heads/master"})
+}
+
+func (s *DumbSuite) TestAdvertisedReferencesNotExists(c *C) {
+	r := s.newSession(c, "non-existent.git")
+	_, err := r.AdvertisedReferences()
+	c.Assert(err, ErrorIs, transport.ErrRepositoryNotFound)
+}
+
+func (s *DumbSuite) TestUploadPack(c *C) {
+	r := s.newSession(c, "basic.git")
+	_, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Wants = append(req.Wants, plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+
+	sto := s.uploadPack(c, r, req)
+	c.Assert(sto.Objects, HasLen, 31)
+	c.Assert(s.requested("/basic.git/objects/info/packs"), Equals, true)
+}
+
+func (s *DumbSuite) TestUploadPackLoose(c *C) {
+	r := s.newSession(c, "unpacked.git")
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.Head, NotNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, *ar.Head)
+
+	sto := s.uploadPack(c, r, req)
+	c.Assert(sto.HasEncodedObject(*ar.Head), IsNil)
+	head := ar.Head.String()
+	c.Assert(s.requested("/unpacked.git/objects/"+head[:2]+"/"+head[2:]), Equals, true)
+}
+
+func (s *DumbSuite) TestUploadPackPartial(c *C) {
+	r := s.newSession(c, "basic.git")
+	_, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Haves = append(req.Haves, plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"))
+
+	sto := s.uploadPack(c, r, req)
+	c.Assert(sto.Objects, HasLen, 4)
+}
+
+func (s *DumbSuite) TestUploadPackLocalStorage(c *C) {
+	r := s.newSession(c, "basic.git")
+	_, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"))
+	local := s.uploadPack(c, r, req)
+
+	r.(transport.LocalStorageSession).SetLocalStorage(local)
+	req = packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	sto := s.uploadPack(c, r, req)
+	c.Assert(sto.Objects, HasLen, 4)
+}
+
+func (s *DumbSuite) TestUploadPackNotFound(c *C) {
+	r := s.newSession(c, "basic.git")
+	_, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
+
+	_, err = r.UploadPack(context.Background(), req)
+	c.Assert(err, ErrorIs, plumbing.ErrObjectNotFound)
+}
+
+func (s *DumbSuite) TestUploadPackShallow(c *C) {
+	r := s.newSession(c, "basic.git")
+	_, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Depth = packp.DepthCommits(1)
+
+	_, err = r.UploadPack(context.Background(), req)
+	c.Assert(err, Equals, ErrDumbShallowNotSupported)
+}
+
+func (s *DumbSuite) TestReceivePack(c *C) {
+	ep, err := transport.NewEndpoint(s.server.URL + "/basic.git")
+	c.Assert(err, IsNil)
+
+	r, err := DefaultClient.NewReceivePackSession(ep, nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, ErrDumbPushNotSupported)
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"net/http"
 
 	"github.com/go-git/go-git/v5/plumbing"
@@ -73,7 +72,7 @@ func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateR
 
 	report := packp.NewReportStatus()
 	if err := report.Decode(rc); err != nil {
-		return nil, err
+		return nil, transport.NewServerError(s.endpoint, err)
 	}
 
 	return report, report.Error()
@@ -83,25 +82,16 @@ func (s *rpSession) doRequest(
 	ctx context.Context, method, url string, content *bytes.Buffer,
 ) (*http.Response, error) {
 
-	var body io.Reader
-	if content != nil {
-		body = content
-	}
-
-	req, err := http.NewRequest(method, url, body)
+	res, err := s.sendRequest(ctx, method, url, content, transport.ReceivePackServiceName)
 	if err != nil {
-		return nil, plumbing.NewPermanentError(err)
-	}
-
-	applyHeadersToRequest(req, content, s.endpoint.Host, transport.ReceivePackServiceName)
-	s.ApplyAuthToRequest(req)
+		if _, ok := err.(*plumbing.PermanentError); ok {
+			return nil, err
+		}
 
-	res, err := s.client.Do(req.WithContext(ctx))
-	if err != nil {
 		return nil, plumbing.NewUnexpectedError(err)
 	}
 
-	if err := NewErr(res); err != nil {
+	if err := s.newErr(res); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,307 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// ErrServiceNotEnabled is returned to the clients requesting a service
+// disabled in the handler.
+var ErrServiceNotEnabled = errors.New("service not enabled")
+
+// HandlerOptions configures the smart HTTP handler.
+type HandlerOptions struct {
+	// EnableReceivePack allows the clients to push to the repositories, as
+	// the http.receivepack git config option. It is disabled by default.
+	EnableReceivePack bool
+	// Authorize, if not nil, is called before serving each request with the
+	// endpoint of the repository and the requested service, either
+	// git-upload-pack or git-receive-pack. The request is rejected if it
+	// returns an error: transport.ErrAuthenticationRequired results in a
+	// 401 status asking for basic authentication credentials,
+	// transport.ErrAuthorizationFailed in a 403 one and
+	// transport.ErrRepositoryNotFound in a 404 one.
+	Authorize func(r *http.Request, ep *transport.Endpoint, service string) error
+	// Hooks are the callbacks run while receiving a push.
+	Hooks server.ReceiveHooks
+}
+
+type handler struct {
+	server transport.Transport
+	opts   HandlerOptions
+}
+
+// NewHandler returns a http.Handler serving the repositories found by the
+// loader using the smart HTTP protocol, as git-http-backend does. The loader
+// is given an endpoint with the path of the repository, the URL path of the
+// request without the info/refs or service suffix.
+func NewHandler(loader server.Loader, o *HandlerOptions) http.Handler {
+	h := &handler{}
+	if o != nil {
+		h.opts = *o
+	}
+
+	h.server = server.NewServerWithOptions(loader, &server.Options{Hooks: h.opts.Hooks})
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+	switch {
+	case strings.HasSuffix(urlPath, infoRefsPath):
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.error(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		repo := strings.TrimSuffix(urlPath, infoRefsPath)
+		h.serveInfoRefs(w, r, repo, r.URL.Query().Get("service"))
+	case strings.HasSuffix(urlPath, "/"+transport.UploadPackServiceName),
+		strings.HasSuffix(urlPath, "/"+transport.ReceivePackServiceName):
+
+		if r.Method != http.MethodPost {
+			h.error(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		repo, service := path.Split(urlPath)
+		h.serveService(w, r, path.Clean(repo), service)
+	default:
+		h.error(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *handler) serveInfoRefs(w http.ResponseWriter, r *http.Request, repo, service string) {
+	ep, err := h.endpoint(r, repo, service)
+	if err != nil {
+		h.sessionError(w, err)
+		return
+	}
+
+	sess, err := h.newSession(ep, service)
+	if err != nil {
+		h.sessionError(w, err)
+		return
+	}
+
+	defer sess.Close()
+	ar, err := sess.AdvertisedReferencesContext(r.Context())
+	if err != nil {
+		h.sessionError(w, err)
+		return
+	}
+
+	ar.Prefix = [][]byte{
+		[]byte(fmt.Sprintf("# service=%s", service)),
+		pktline.Flush,
+	}
+
+	noCache(w)
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+	w.WriteHeader(http.StatusOK)
+	_ = ar.Encode(w)
+}
+
+func (h *handler) serveService(w http.ResponseWriter, r *http.Request, repo, service string) {
+	if r.Header.Get("Content-Type") != fmt.Sprintf("application/x-%s-request", service) {
+		h.error(w, http.StatusUnsupportedMediaType, "unsupported content type")
+		return
+	}
+
+	ep, err := h.endpoint(r, repo, service)
+	if err != nil {
+		h.sessionError(w, err)
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			h.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		defer gz.Close()
+		body = gz
+	}
+
+	sess, err := h.newSession(ep, service)
+	if err != nil {
+		h.sessionError(w, err)
+		return
+	}
+
+	defer sess.Close()
+	if service == transport.UploadPackServiceName {
+		h.serveUploadPack(w, r, sess.(transport.UploadPackSession), body)
+		return
+	}
+
+	h.serveReceivePack(w, r, sess.(transport.ReceivePackSession), body)
+}
+
+func (h *handler) serveUploadPack(w http.ResponseWriter, r *http.Request,
+	sess transport.UploadPackSession, body io.Reader) {
+
+	req := packp.NewUploadPackRequest()
+	if err := req.UploadRequest.Decode(body); err != nil {
+		h.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	done, err := decodeHaves(body, &req.UploadHaves)
+	if err != nil {
+		h.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	noCache(w)
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", transport.UploadPackServiceName))
+	if !done {
+		// A negotiation round of the stateless protocol, the server does not
+		// support multi_ack, so no common object is acknowledged.
+		w.WriteHeader(http.StatusOK)
+		_ = pktline.NewEncoder(w).Encodef("NAK\n")
+		return
+	}
+
+	resp, err := sess.UploadPack(r.Context(), req)
+	if err != nil {
+		h.sessionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = resp.Encode(w)
+}
+
+// decodeHaves reads the have lines following the upload request, and
+// returns whether the client is done with the negotiation.
+func decodeHaves(r io.Reader, haves *packp.UploadHaves) (bool, error) {
+	s := pktline.NewScanner(r)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), []byte("\n"))
+		switch {
+		case len(line) == 0:
+			continue
+		case bytes.Equal(line, []byte("done")):
+			return true, nil
+		case bytes.HasPrefix(line, []byte("have ")):
+			id := string(line[len("have "):])
+			if !plumbing.IsHash(id) {
+				return false, fmt.Errorf("invalid have line: %q", line)
+			}
+
+			haves.Haves = append(haves.Haves, plumbing.NewHash(id))
+		default:
+			return false, fmt.Errorf("unexpected line: %q", line)
+		}
+	}
+
+	return false, s.Err()
+}
+
+func (h *handler) serveReceivePack(w http.ResponseWriter, r *http.Request,
+	sess transport.ReceivePackSession, body io.Reader) {
+
+	// The session checks the capabilities of the request against the
+	// advertised ones.
+	if _, err := sess.AdvertisedReferencesContext(r.Context()); err != nil {
+		h.sessionError(w, err)
+		return
+	}
+
+	req := packp.NewReferenceUpdateRequest()
+	if err := req.Decode(body); err != nil {
+		h.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rs, err := sess.ReceivePack(r.Context(), req)
+	if rs == nil && err != nil {
+		h.sessionError(w, err)
+		return
+	}
+
+	noCache(w)
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", transport.ReceivePackServiceName))
+	w.WriteHeader(http.StatusOK)
+	if rs != nil {
+		_ = rs.Encode(w)
+	}
+}
+
+// endpoint returns the endpoint of the repository, once the request is
+// authorized.
+func (h *handler) endpoint(r *http.Request, repo, service string) (*transport.Endpoint, error) {
+	switch service {
+	case transport.UploadPackServiceName:
+	case transport.ReceivePackServiceName:
+		if !h.opts.EnableReceivePack {
+			return nil, ErrServiceNotEnabled
+		}
+	default:
+		return nil, ErrServiceNotEnabled
+	}
+
+	ep, err := transport.NewEndpoint(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.opts.Authorize != nil {
+		if err := h.opts.Authorize(r, ep, service); err != nil {
+			return nil, err
+		}
+	}
+
+	return ep, nil
+}
+
+func (h *handler) newSession(ep *transport.Endpoint, service string) (transport.Session, error) {
+	if service == transport.UploadPackServiceName {
+		return h.server.NewUploadPackSession(ep, nil)
+	}
+
+	return h.server.NewReceivePackSession(ep, nil)
+}
+
+func (h *handler) sessionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired):
+		w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+		h.error(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, transport.ErrAuthorizationFailed), errors.Is(err, ErrServiceNotEnabled):
+		h.error(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, transport.ErrRepositoryNotFound):
+		h.error(w, http.StatusNotFound, err.Error())
+	default:
+		h.error(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (h *handler) error(w http.ResponseWriter, code int, msg string) {
+	noCache(w)
+	http.Error(w, msg, code)
+}
+
+// noCache sets the headers preventing the responses from being cached, same
+// as git-http-backend.
+func noCache(w http.ResponseWriter) {
+	w.Header().Set("Expires", "Fri, 01 Jan 1980 00:00:00 GMT")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+}
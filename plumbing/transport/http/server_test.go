@@ -0,0 +1,264 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/go-git/go-git/v5/internal/test"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/plumbing/transport/test"
+
+	"github.com/go-git/go-billy/v5/osfs"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type HandlerBaseSuite struct {
+	fixtures.Suite
+
+	base   string
+	server *httptest.Server
+	opts   HandlerOptions
+}
+
+func (s *HandlerBaseSuite) startServer(c *C) {
+	s.base = c.MkDir()
+	h := NewHandler(server.NewFilesystemLoader(osfs.New(s.base)), &s.opts)
+	s.server = httptest.NewServer(h)
+}
+
+func (s *HandlerBaseSuite) stopServer(c *C) {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+func (s *HandlerBaseSuite) prepareRepository(c *C, f *fixtures.Fixture, name string) *transport.Endpoint {
+	fs := f.DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+	c.Assert(os.Rename(fs.Root(), filepath.Join(s.base, name)), IsNil)
+
+	return s.newEndpoint(c, name)
+}
+
+func (s *HandlerBaseSuite) newEndpoint(c *C, name string) *transport.Endpoint {
+	ep, err := transport.NewEndpoint(fmt.Sprintf("%s/%s", s.server.URL, name))
+	c.Assert(err, IsNil)
+
+	return ep
+}
+
+type HandlerUploadPackSuite struct {
+	test.UploadPackSuite
+	HandlerBaseSuite
+}
+
+var _ = Suite(&HandlerUploadPackSuite{})
+
+func (s *HandlerUploadPackSuite) SetUpSuite(c *C) {
+	s.startServer(c)
+	s.UploadPackSuite.Client = DefaultClient
+	s.UploadPackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.UploadPackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.UploadPackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
+
+func (s *HandlerUploadPackSuite) TearDownSuite(c *C) {
+	s.stopServer(c)
+	s.Suite.TearDownSuite(c)
+}
+
+// Overwritten, different behaviour for HTTP.
+func (s *HandlerUploadPackSuite) TestAdvertisedReferencesNotExists(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.NonExistentEndpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	info, err := r.AdvertisedReferences()
+	c.Assert(err, ErrorIs, transport.ErrRepositoryNotFound)
+	c.Assert(info, IsNil)
+}
+
+func (s *HandlerUploadPackSuite) TestUnbornHead(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.EmptyEndpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrEmptyRemoteRepository)
+
+	us, ok := r.(transport.UnbornHeadSession)
+	c.Assert(ok, Equals, true)
+	c.Assert(us.UnbornHead(), Equals, plumbing.Master)
+}
+
+func (s *HandlerUploadPackSuite) TestUploadPackWithContextOnRead(c *C) {
+	c.Skip("flaky tests, looks like sometimes the request body is cached, so doesn't fail on context cancel")
+}
+
+type HandlerReceivePackSuite struct {
+	test.ReceivePackSuite
+	HandlerBaseSuite
+}
+
+var _ = Suite(&HandlerReceivePackSuite{})
+
+func (s *HandlerReceivePackSuite) SetUpTest(c *C) {
+	s.opts.EnableReceivePack = true
+	s.startServer(c)
+	s.ReceivePackSuite.Client = DefaultClient
+	s.ReceivePackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.ReceivePackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.ReceivePackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
+
+func (s *HandlerReceivePackSuite) TearDownTest(c *C) {
+	s.stopServer(c)
+}
+
+type HandlerSuite struct {
+	HandlerBaseSuite
+
+	endpoint  *transport.Endpoint
+	authorize func(r *http.Request, ep *transport.Endpoint, service string) error
+}
+
+var _ = Suite(&HandlerSuite{})
+
+func (s *HandlerSuite) SetUpSuite(c *C) {
+	s.opts.Authorize = func(r *http.Request, ep *transport.Endpoint, service string) error {
+		if s.authorize == nil {
+			return nil
+		}
+
+		return s.authorize(r, ep, service)
+	}
+
+	s.startServer(c)
+	s.endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+}
+
+func (s *HandlerSuite) TearDownSuite(c *C) {
+	s.stopServer(c)
+	s.Suite.TearDownSuite(c)
+}
+
+func (s *HandlerSuite) TearDownTest(c *C) {
+	s.authorize = nil
+}
+
+func (s *HandlerSuite) TestInfoRefs(c *C) {
+	res, err := http.Get(s.server.URL + "/basic.git/info/refs?service=git-upload-pack")
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "application/x-git-upload-pack-advertisement")
+	c.Assert(res.Header.Get("Cache-Control"), Equals, "no-cache, max-age=0, must-revalidate")
+
+	body, err := io.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(body), "001e# service=git-upload-pack\n0000"), Equals, true)
+}
+
+func (s *HandlerSuite) TestInfoRefsWithoutService(c *C) {
+	res, err := http.Get(s.server.URL + "/basic.git/info/refs")
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	c.Assert(res.StatusCode, Equals, http.StatusForbidden)
+}
+
+func (s *HandlerSuite) TestUploadPackGzip(c *C) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	_, err := io.WriteString(gz, "0032want 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n0000"+
+		"0032have 918c48b83bd081e863dbe1b80f8998f058cd8294\n0000")
+	c.Assert(err, IsNil)
+	c.Assert(gz.Close(), IsNil)
+
+	req, err := http.NewRequest(http.MethodPost, s.server.URL+"/basic.git/git-upload-pack", &body)
+	c.Assert(err, IsNil)
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	res, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "application/x-git-upload-pack-result")
+
+	// Without done, it is a negotiation round.
+	b, err := io.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "0008NAK\n")
+}
+
+func (s *HandlerSuite) TestUploadPackContentType(c *C) {
+	res, err := http.Post(s.server.URL+"/basic.git/git-upload-pack", "text/plain", strings.NewReader(""))
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	c.Assert(res.StatusCode, Equals, http.StatusUnsupportedMediaType)
+}
+
+func (s *HandlerSuite) TestReceivePackNotEnabled(c *C) {
+	r, err := DefaultClient.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, ErrorIs, transport.ErrAuthorizationFailed)
+}
+
+func (s *HandlerSuite) TestAuthorize(c *C) {
+	var services []string
+	s.authorize = func(r *http.Request, ep *transport.Endpoint, service string) error {
+		services = append(services, ep.Path+" "+service)
+		if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "secret" {
+			return transport.ErrAuthenticationRequired
+		}
+
+		return nil
+	}
+
+	r, err := DefaultClient.NewUploadPackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, ErrorIs, transport.ErrAuthenticationRequired)
+
+	r, err = DefaultClient.NewUploadPackSession(s.endpoint, &BasicAuth{Username: "user", Password: "secret"})
+	c.Assert(err, IsNil)
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	c.Assert(services, DeepEquals, []string{
+		"/basic.git git-upload-pack",
+		"/basic.git git-upload-pack",
+	})
+}
+
+func (s *HandlerSuite) TestCloneWithGit(c *C) {
+	if err := exec.Command("git", "--version").Run(); err != nil {
+		c.Skip("git command not found")
+	}
+
+	dir := filepath.Join(c.MkDir(), "basic")
+	out, err := exec.Command("git", "clone", s.endpoint.String(), dir).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	cmd := exec.Command("git", "rev-parse", "HEAD", "origin/branch")
+	cmd.Dir = dir
+	out, err = cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	c.Assert(string(out), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5\ne8d3ffab552895c19b9fcf7aa264d277cde33881\n")
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/internal/common"
 	"github.com/go-git/go-git/v5/utils/ioutil"
@@ -17,11 +18,12 @@ import (
 
 type upSession struct {
 	*session
+	local storer.EncodedObjectStorer
 }
 
 func newUploadPackSession(c *client, ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
 	s, err := newSession(c, ep, auth)
-	return &upSession{s}, err
+	return &upSession{session: s}, err
 }
 
 func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
@@ -32,6 +34,12 @@ func (s *upSession) AdvertisedReferencesContext(ctx context.Context) (*packp.Adv
 	return advertisedReferences(ctx, s.session, transport.UploadPackServiceName)
 }
 
+// UnbornHead returns the branch HEAD of the empty repository points to, if
+// advertised.
+func (s *upSession) UnbornHead() plumbing.ReferenceName {
+	return s.unbornHead
+}
+
 func (s *upSession) UploadPack(
 	ctx context.Context, req *packp.UploadPackRequest,
 ) (*packp.UploadPackResponse, error) {
@@ -40,6 +48,10 @@ func (s *upSession) UploadPack(
 		return nil, transport.ErrEmptyUploadPackRequest
 	}
 
+	if s.dumb {
+		return s.dumbUploadPack(ctx, req)
+	}
+
 	if err := req.Validate(); err != nil {
 		return nil, err
 	}
@@ -69,7 +81,12 @@ func (s *upSession) UploadPack(
 	}
 
 	rc := ioutil.NewReadCloser(r, res.Body)
-	return common.DecodeUploadPackResponse(rc, req)
+	resp, err := common.DecodeUploadPackResponse(rc, req)
+	if err != nil {
+		return nil, transport.NewServerError(s.endpoint, err)
+	}
+
+	return resp, nil
 }
 
 // Close does nothing.
@@ -81,25 +98,16 @@ func (s *upSession) doRequest(
 	ctx context.Context, method, url string, content *bytes.Buffer,
 ) (*http.Response, error) {
 
-	var body io.Reader
-	if content != nil {
-		body = content
-	}
-
-	req, err := http.NewRequest(method, url, body)
+	res, err := s.sendRequest(ctx, method, url, content, transport.UploadPackServiceName)
 	if err != nil {
-		return nil, plumbing.NewPermanentError(err)
-	}
-
-	applyHeadersToRequest(req, content, s.endpoint.Host, transport.UploadPackServiceName)
-	s.ApplyAuthToRequest(req)
+		if _, ok := err.(*plumbing.PermanentError); ok {
+			return nil, err
+		}
 
-	res, err := s.client.Do(req.WithContext(ctx))
-	if err != nil {
 		return nil, plumbing.NewUnexpectedError(err)
 	}
 
-	if err := NewErr(res); err != nil {
+	if err := s.newErr(res); err != nil {
 		return nil, err
 	}
 
@@ -2,11 +2,16 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	. "github.com/go-git/go-git/v5/internal/test"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -139,3 +144,71 @@ func (s *UploadPackSuite) TestAdvertisedReferencesContextCanceled(c *C) {
 func (s *UploadPackSuite) TestUploadPackWithContextOnRead(c *C) {
 	c.Skip("flaky tests, looks like sometimes the request body is cached, so doesn't fail on context cancel")
 }
+
+func (s *UploadPackSuite) TestTokenSourceAuthRefresh(c *C) {
+	backend, err := url.Parse(fmt.Sprintf("http://%s:%d", s.Endpoint.Host, s.Endpoint.Port))
+	c.Assert(err, IsNil)
+	proxy := httputil.NewSingleHostReverseProxy(backend)
+
+	// The first token expires after the advertised references are sent.
+	valid := "Bearer first"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/info/refs") {
+			valid = "Bearer second"
+		}
+
+		proxy.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	var calls, refreshes int
+	auth := &TokenSourceAuth{Source: func(ctx context.Context, refresh bool) (string, error) {
+		calls++
+		if refresh {
+			refreshes++
+			return "Bearer second", nil
+		}
+
+		if refreshes > 0 {
+			return "Bearer second", nil
+		}
+
+		return "Bearer first", nil
+	}}
+
+	ep, err := transport.NewEndpoint(server.URL + "/basic.git")
+	c.Assert(err, IsNil)
+
+	r, err := s.Client.NewUploadPackSession(ep, auth)
+	c.Assert(err, IsNil)
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	reader, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(reader.Close(), IsNil)
+
+	c.Assert(calls, Equals, 3)
+	c.Assert(refreshes, Equals, 1)
+}
+
+func (s *UploadPackSuite) TestTokenSourceAuthError(c *C) {
+	auth := &TokenSourceAuth{Source: func(ctx context.Context, refresh bool) (string, error) {
+		return "", errors.New("token expired")
+	}}
+
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, auth)
+	c.Assert(err, IsNil)
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, ErrorMatches, ".*token expired")
+}
@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
@@ -78,12 +79,32 @@ type CommandKiller interface {
 }
 
 type client struct {
-	cmdr Commander
+	cmdr     Commander
+	timeouts transport.Timeouts
+}
+
+// ClientOptions configures a client.
+type ClientOptions struct {
+	// Timeouts are the idle and operation timeouts of the sessions, the ones
+	// of the endpoints override them. The dial timeout must be enforced by
+	// the Commander.
+	Timeouts transport.Timeouts
 }
 
 // NewClient creates a new client using the given Commander.
 func NewClient(runner Commander) transport.Transport {
-	return &client{runner}
+	return NewClientWithOptions(runner, nil)
+}
+
+// NewClientWithOptions creates a new client using the given Commander and
+// options.
+func NewClientWithOptions(runner Commander, o *ClientOptions) transport.Transport {
+	c := &client{cmdr: runner}
+	if o != nil {
+		c.timeouts = o.Timeouts
+	}
+
+	return c
 }
 
 // NewUploadPackSession creates a new UploadPackSession.
@@ -105,11 +126,14 @@ type session struct {
 	Stdout  io.Reader
 	Command Command
 
+	endpoint      *transport.Endpoint
 	isReceivePack bool
 	advRefs       *packp.AdvRefs
+	unbornHead    plumbing.ReferenceName
 	packRun       bool
 	finished      bool
 	firstErrLine  chan string
+	watchdog      *watchdog
 }
 
 func (c *client) newSession(s string, ep *transport.Endpoint, auth transport.AuthMethod) (*session, error) {
@@ -137,13 +161,22 @@ func (c *client) newSession(s string, ep *transport.Endpoint, auth transport.Aut
 		return nil, err
 	}
 
-	return &session{
+	sess := &session{
 		Stdin:         stdin,
-		Stdout:        stdout,
 		Command:       cmd,
 		firstErrLine:  c.listenFirstError(stderr),
+		endpoint:      ep,
 		isReceivePack: s == transport.ReceivePackServiceName,
-	}, nil
+	}
+
+	timeouts := c.timeouts
+	if ep != nil {
+		timeouts = timeouts.Override(ep.Timeouts)
+	}
+
+	sess.watchdog = newWatchdog(timeouts, sess.kill)
+	sess.Stdout = sess.watchdog.reader(stdout)
+	return sess, nil
 }
 
 func (c *client) listenFirstError(r io.Reader) chan string {
@@ -185,6 +218,10 @@ func (s *session) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRe
 
 	ar := packp.NewAdvRefs()
 	if err := ar.Decode(s.StdoutContext(ctx)); err != nil {
+		if terr := s.watchdog.error(err); terr != err {
+			return nil, terr
+		}
+
 		if err := s.handleAdvRefDecodeError(err); err != nil {
 			return nil, err
 		}
@@ -194,22 +231,42 @@ func (s *session) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRe
 	// packp message with a flush. This verifies that we received a empty
 	// adv-refs, even it contains capabilities.
 	if !s.isReceivePack && ar.IsEmpty() {
+		s.unbornHead = ar.HeadTarget()
 		return nil, transport.ErrEmptyRemoteRepository
 	}
 
-	transport.FilterUnsupportedCapabilities(ar.Capabilities)
+	filterUnsupportedCapabilities(ar.Capabilities)
 	s.advRefs = ar
 	return ar, nil
 }
 
+// filterUnsupportedCapabilities is like transport.FilterUnsupportedCapabilities,
+// but keeps multi_ack_detailed, as the haves can be negotiated over the
+// stateful connection, see NegotiateUploadPack.
+// UnbornHead returns the branch HEAD of the empty repository points to, if
+// advertised.
+func (s *session) UnbornHead() plumbing.ReferenceName {
+	return s.unbornHead
+}
+
+func filterUnsupportedCapabilities(list *capability.List) {
+	for _, c := range transport.UnsupportedCapabilities {
+		if c == capability.MultiACKDetailed {
+			continue
+		}
+
+		list.Delete(c)
+	}
+}
+
 func (s *session) handleAdvRefDecodeError(err error) error {
 	var errLine *pktline.ErrorLine
 	if errors.As(err, &errLine) {
 		if isRepoNotFoundError(errLine.Text) {
-			return transport.ErrRepositoryNotFound
+			return &transport.RepositoryNotFoundError{Endpoint: s.endpoint, Message: errLine.Text}
 		}
 
-		return errLine
+		return &transport.ProtocolError{Endpoint: s.endpoint, Message: errLine.Text, Err: errLine}
 	}
 
 	// If repository is not found, we get empty stdout and server writes an
@@ -247,7 +304,7 @@ func (s *session) handleAdvRefDecodeError(err error) error {
 	// not found errors
 	if uerr, ok := err.(*packp.ErrUnexpectedData); ok {
 		if isRepoNotFoundError(string(uerr.Data)) {
-			return transport.ErrRepositoryNotFound
+			return &transport.RepositoryNotFoundError{Endpoint: s.endpoint, Message: string(uerr.Data)}
 		}
 	}
 
@@ -257,6 +314,45 @@ func (s *session) handleAdvRefDecodeError(err error) error {
 // UploadPack performs a request to the server to fetch a packfile. A reader is
 // returned with the packfile content. The reader must be closed after reading.
 func (s *session) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	return s.uploadPack(ctx, req, nil)
+}
+
+// NegotiateUploadPack performs an upload-pack request with the haves provided
+// by the negotiator, in batches, using the multi_ack_detailed capability. If
+// the capability is not part of the request, all the haves are sent at once.
+func (s *session) NegotiateUploadPack(ctx context.Context, req *packp.UploadPackRequest,
+	n transport.Negotiator) (*packp.UploadPackResponse, error) {
+
+	if req.Capabilities.Supports(capability.MultiACKDetailed) && req.Depth.IsZero() {
+		return s.uploadPack(ctx, req, n)
+	}
+
+	for {
+		haves, err := n.Next(havesWindow)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(haves) == 0 {
+			break
+		}
+
+		req.Haves = append(req.Haves, haves...)
+	}
+
+	return s.uploadPack(ctx, req, nil)
+}
+
+func (s *session) uploadPack(ctx context.Context, req *packp.UploadPackRequest,
+	n transport.Negotiator) (*packp.UploadPackResponse, error) {
+
+	resp, err := s.doUploadPack(ctx, req, n)
+	return resp, s.watchdog.error(s.serverError(err))
+}
+
+func (s *session) doUploadPack(ctx context.Context, req *packp.UploadPackRequest,
+	n transport.Negotiator) (*packp.UploadPackResponse, error) {
+
 	if req.IsEmpty() {
 		// XXX: IsEmpty means haves are a subset of wants, in that case we have
 		// everything we asked for. Close the connection and return nil.
@@ -276,20 +372,32 @@ func (s *session) UploadPack(ctx context.Context, req *packp.UploadPackRequest)
 	}
 
 	s.packRun = true
+	s.watchdog.setPhase(transport.PhaseNegotiation)
 
 	in := s.StdinContext(ctx)
 	out := s.StdoutContext(ctx)
 
-	if err := uploadPack(in, out, req); err != nil {
+	if n == nil {
+		if err := uploadPack(in, out, req); err != nil {
+			return nil, err
+		}
+	} else if err := negotiate(in, out, req, n); err != nil {
 		return nil, err
 	}
 
+	s.watchdog.setPhase(transport.PhasePackTransfer)
+
 	r, err := ioutil.NonEmptyReader(out)
 	if err == ioutil.ErrEmptyReader {
 		if c, ok := s.Stdout.(io.Closer); ok {
 			_ = c.Close()
 		}
 
+		// The server may have failed, with a message on its standard error.
+		if err := s.stderrError(); err != nil {
+			return nil, err
+		}
+
 		return nil, transport.ErrEmptyUploadPackRequest
 	}
 
@@ -323,12 +431,28 @@ func (s *session) onError(err error) {
 	_ = s.Close()
 }
 
+// kill stops the command once a timeout is exceeded.
+func (s *session) kill() {
+	if k, ok := s.Command.(CommandKiller); ok {
+		_ = k.Kill()
+		return
+	}
+
+	_ = s.Command.Close()
+}
+
 func (s *session) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	rs, err := s.receivePack(ctx, req)
+	return rs, s.watchdog.error(s.serverError(err))
+}
+
+func (s *session) receivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
 	if _, err := s.AdvertisedReferences(); err != nil {
 		return nil, err
 	}
 
 	s.packRun = true
+	s.watchdog.setPhase(transport.PhasePackTransfer)
 
 	w := s.StdinContext(ctx)
 	if err := req.Encode(w); err != nil {
@@ -371,6 +495,37 @@ func (s *session) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateReq
 	return report, s.Command.Close()
 }
 
+// serverError returns err as a *transport.ProtocolError if the server sent
+// an error message: in an ERR pkt-line, on the error channel of the sideband,
+// or on its standard error when its output ended early.
+func (s *session) serverError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		if serr := s.stderrError(); serr != nil {
+			return serr
+		}
+	}
+
+	return transport.NewServerError(s.endpoint, err)
+}
+
+// stderrError returns the error written by the server on its standard
+// error, if any.
+func (s *session) stderrError() error {
+	if s.firstErrLine == nil {
+		return nil
+	}
+
+	if err := s.checkNotFoundError(); err != nil && err != ErrTimeoutExceeded {
+		return err
+	}
+
+	return nil
+}
+
 func (s *session) finish() error {
 	if s.finished {
 		return nil
@@ -390,6 +545,7 @@ func (s *session) finish() error {
 }
 
 func (s *session) Close() (err error) {
+	s.watchdog.stop()
 	err = s.finish()
 
 	defer ioutil.CheckClose(s.Command, &err)
@@ -409,11 +565,14 @@ func (s *session) checkNotFoundError() error {
 		}
 
 		if isRepoNotFoundError(line) {
-			return transport.ErrRepositoryNotFound
+			return &transport.RepositoryNotFoundError{Endpoint: s.endpoint, Message: line}
+		}
+
+		if scheme, ok := sshAuthenticationError(line); ok {
+			return &transport.AuthenticationError{Endpoint: s.endpoint, Scheme: scheme, Message: line}
 		}
 
-		// TODO:(v6): return server error just as it is without a prefix
-		return fmt.Errorf("unknown error: %s", line)
+		return &transport.ProtocolError{Endpoint: s.endpoint, Message: line}
 	}
 }
 
@@ -447,12 +606,23 @@ func isRepoNotFoundError(s string) bool {
 	return false
 }
 
+// sshAuthenticationError returns whether the error written by a remote
+// command is the one of ssh failing to authenticate, as
+// "Permission denied (publickey).", along with the methods tried.
+func sshAuthenticationError(s string) (string, bool) {
+	const prefix = "Permission denied ("
+	i := strings.Index(s, prefix)
+	if i < 0 {
+		return "", false
+	}
+
+	methods, _, _ := strings.Cut(s[i+len(prefix):], ")")
+	return methods, true
+}
+
 // uploadPack implements the git-upload-pack protocol.
 func uploadPack(w io.WriteCloser, _ io.Reader, req *packp.UploadPackRequest) error {
-	// TODO support multi_ack mode
-	// TODO support multi_ack_detailed mode
-	// TODO support acks for common objects
-	// TODO build a proper state machine for all these processing options
+	// TODO support multi_ack mode, multi_ack_detailed is handled by negotiate
 
 	if err := req.UploadRequest.Encode(w); err != nil {
 		return fmt.Errorf("sending upload-req message: %s", err)
@@ -473,6 +643,117 @@ func uploadPack(w io.WriteCloser, _ io.Reader, req *packp.UploadPackRequest) err
 	return nil
 }
 
+const (
+	// havesWindow is the number of haves sent on each negotiation round.
+	havesWindow = 32
+	// maxInVain is the number of haves sent without any of them being
+	// acknowledged, once a common commit is found, before giving up on the
+	// negotiation, same as git.
+	maxInVain = 256
+)
+
+// negotiate sends the upload-pack request, followed by the haves of the
+// negotiator in rounds of havesWindow, until the server is ready to send the
+// packfile or there are no more haves. The request must have the
+// multi_ack_detailed capability.
+func negotiate(w io.WriteCloser, r io.Reader, req *packp.UploadPackRequest, n transport.Negotiator) error {
+	if err := req.UploadRequest.Encode(w); err != nil {
+		return fmt.Errorf("sending upload-req message: %s", err)
+	}
+
+	e := pktline.NewEncoder(w)
+	s := pktline.NewScanner(r)
+	var common bool
+	var inVain int
+	for {
+		haves, err := n.Next(havesWindow)
+		if err != nil {
+			return err
+		}
+
+		if len(haves) == 0 {
+			break
+		}
+
+		for _, h := range haves {
+			if err := e.Encodef("have %s\n", h); err != nil {
+				return fmt.Errorf("sending haves message: %s", err)
+			}
+		}
+
+		if err := e.Flush(); err != nil {
+			return fmt.Errorf("sending haves message: %s", err)
+		}
+
+		inVain += len(haves)
+		acked, ready, err := readACKs(s, n)
+		if err != nil {
+			return fmt.Errorf("reading acknowledgements: %s", err)
+		}
+
+		if acked {
+			common = true
+			inVain = 0
+		}
+
+		if ready || (common && inVain >= maxInVain) {
+			break
+		}
+	}
+
+	if err := sendDone(w); err != nil {
+		return fmt.Errorf("sending done message: %s", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("closing input: %s", err)
+	}
+
+	return nil
+}
+
+// readACKs reads the response to a round of haves, up to the NAK closing it,
+// telling the negotiator about the commits in common. It returns whether any
+// commit was acknowledged and whether the server is ready to send the
+// packfile.
+func readACKs(s *pktline.Scanner, n transport.Negotiator) (acked, ready bool, err error) {
+	for s.Scan() {
+		line := strings.TrimSuffix(string(s.Bytes()), "\n")
+		if line == "NAK" {
+			return acked, ready, nil
+		}
+
+		if strings.HasPrefix(line, "ERR ") {
+			return false, false, fmt.Errorf("%s", line[len("ERR "):])
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "ACK" || !plumbing.IsHash(fields[1]) {
+			return false, false, fmt.Errorf("unexpected line %q", line)
+		}
+
+		switch fields[2] {
+		case "common", "continue":
+			acked = true
+			if err := n.Ack(plumbing.NewHash(fields[1])); err != nil {
+				return false, false, err
+			}
+		case "ready":
+			// The object of a ready acknowledgement is not necessarily in
+			// common with the server.
+			ready = true
+		default:
+			return false, false, fmt.Errorf("unexpected line %q", line)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return false, false, err
+	}
+
+	return false, false, io.ErrUnexpectedEOF
+}
+
 func sendDone(w io.Writer) error {
 	e := pktline.NewEncoder(w)
 
@@ -485,7 +766,7 @@ func DecodeUploadPackResponse(r io.ReadCloser, req *packp.UploadPackRequest) (
 ) {
 	res := packp.NewUploadPackResponse(req)
 	if err := res.Decode(r); err != nil {
-		return nil, fmt.Errorf("error decoding upload-pack response: %s", err)
+		return nil, fmt.Errorf("error decoding upload-pack response: %w", err)
 	}
 
 	return res, nil
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -53,7 +54,7 @@ func TestAdvertisedReferencesWithRemoteError(t *testing.T) {
 		{
 			name:    "unknown error",
 			stderr:  "something",
-			wantErr: fmt.Errorf("unknown error: something"),
+			wantErr: fmt.Errorf("remote error: something"),
 		},
 		{
 			name: "GitLab: repository not found",
@@ -80,7 +81,7 @@ remote:`,
 			_, err = sess.AdvertisedReferences()
 
 			if tt.wantErr != nil {
-				if tt.wantErr != err {
+				if !errors.Is(err, tt.wantErr) {
 					if tt.wantErr.Error() != err.Error() {
 						t.Fatalf("expected a different error: got '%s', expected '%s'", err, tt.wantErr)
 					}
@@ -91,3 +92,41 @@ remote:`,
 		})
 	}
 }
+
+func (s *CommonSuite) TestCheckNotFoundErrorTyped(c *C) {
+	ep, err := transport.NewEndpoint("ssh://git@github.com/git-fixtures/basic.git")
+	c.Assert(err, IsNil)
+
+	for _, t := range []struct {
+		line  string
+		check func(error)
+	}{{
+		line: "ERROR: Repository not found.",
+		check: func(err error) {
+			var notFound *transport.RepositoryNotFoundError
+			c.Assert(errors.As(err, &notFound), Equals, true)
+			c.Assert(notFound.Endpoint, Equals, ep)
+			c.Assert(errors.Is(err, transport.ErrRepositoryNotFound), Equals, true)
+		},
+	}, {
+		line: "git@github.com: Permission denied (publickey).",
+		check: func(err error) {
+			var authErr *transport.AuthenticationError
+			c.Assert(errors.As(err, &authErr), Equals, true)
+			c.Assert(authErr.Scheme, Equals, "publickey")
+			c.Assert(errors.Is(err, transport.ErrAuthenticationRequired), Equals, true)
+		},
+	}, {
+		line: "fatal: the remote end hung up unexpectedly",
+		check: func(err error) {
+			var protoErr *transport.ProtocolError
+			c.Assert(errors.As(err, &protoErr), Equals, true)
+			c.Assert(protoErr.Message, Equals, "fatal: the remote end hung up unexpectedly")
+		},
+	}} {
+		firstErrLine := make(chan string, 1)
+		firstErrLine <- t.line
+		sess := session{firstErrLine: firstErrLine, endpoint: ep}
+		t.check(sess.checkNotFoundError())
+	}
+}
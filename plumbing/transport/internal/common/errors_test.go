@@ -0,0 +1,183 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+// scriptedCommander runs commands writing the given output, whatever their
+// input, as a server failing at some phase of the protocol.
+type scriptedCommander struct {
+	stdout []byte
+	stderr string
+}
+
+func (c *scriptedCommander) Command(string, *transport.Endpoint, transport.AuthMethod) (Command, error) {
+	return &scriptedCommand{
+		stdout: bytes.NewReader(c.stdout),
+		stderr: strings.NewReader(c.stderr),
+	}, nil
+}
+
+type scriptedCommand struct {
+	stdout io.Reader
+	stderr io.Reader
+}
+
+func (c *scriptedCommand) StderrPipe() (io.Reader, error) {
+	return c.stderr, nil
+}
+
+func (c *scriptedCommand) StdinPipe() (io.WriteCloser, error) {
+	return ioutil.WriteNopCloser(io.Discard), nil
+}
+
+func (c *scriptedCommand) StdoutPipe() (io.Reader, error) {
+	return c.stdout, nil
+}
+
+func (c *scriptedCommand) Start() error { return nil }
+
+func (c *scriptedCommand) Close() error { return nil }
+
+type ServerErrorSuite struct {
+	endpoint *transport.Endpoint
+}
+
+var _ = Suite(&ServerErrorSuite{})
+
+var scriptedHead = plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+func (s *ServerErrorSuite) SetUpSuite(c *C) {
+	var err error
+	s.endpoint, err = transport.NewEndpoint("ssh://git@example.com/repo.git")
+	c.Assert(err, IsNil)
+}
+
+// script returns the output of a server: the advertised references, then
+// the given pkt-lines, a nil one being a flush.
+func (s *ServerErrorSuite) script(c *C, lines ...[]byte) []byte {
+	ar := packp.NewAdvRefs()
+	ar.Head = &scriptedHead
+	ar.References["refs/heads/master"] = scriptedHead
+	for _, cp := range []capability.Capability{
+		capability.Sideband64k, capability.ReportStatus, capability.OFSDelta, capability.DeleteRefs,
+	} {
+		c.Assert(ar.Capabilities.Add(cp), IsNil)
+	}
+
+	var buf bytes.Buffer
+	c.Assert(ar.Encode(&buf), IsNil)
+
+	e := pktline.NewEncoder(&buf)
+	for _, l := range lines {
+		if l == nil {
+			c.Assert(e.Flush(), IsNil)
+			continue
+		}
+
+		c.Assert(e.Encode(l), IsNil)
+	}
+
+	return buf.Bytes()
+}
+
+func (s *ServerErrorSuite) assertProtocolError(c *C, err error, msg string) {
+	var perr *transport.ProtocolError
+	c.Assert(errors.As(err, &perr), Equals, true, Commentf("%v", err))
+	c.Assert(perr.Message, Equals, msg)
+	c.Assert(perr.Endpoint, Equals, s.endpoint)
+}
+
+func (s *ServerErrorSuite) uploadPack(c *C, cmdr Commander) (*packp.UploadPackResponse, error) {
+	session, err := NewClient(cmdr).NewUploadPackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{scriptedHead}
+	return session.UploadPack(context.Background(), req)
+}
+
+func (s *ServerErrorSuite) receivePack(c *C, cmdr Commander, progress io.Writer) (*packp.ReportStatus, error) {
+	session, err := NewClient(cmdr).NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+
+	req := packp.NewReferenceUpdateRequest()
+	req.Commands = []*packp.Command{{Name: "refs/heads/master", Old: scriptedHead, New: plumbing.ZeroHash}}
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	c.Assert(req.Capabilities.Set(capability.Sideband64k), IsNil)
+	req.Progress = progress
+	return session.ReceivePack(context.Background(), req)
+}
+
+func (s *ServerErrorSuite) TestAdvertisedReferences(c *C) {
+	session, err := NewClient(&scriptedCommander{
+		stdout: []byte("0018ERR service disabled\n"),
+	}).NewUploadPackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+
+	_, err = session.AdvertisedReferences()
+	s.assertProtocolError(c, err, "service disabled")
+}
+
+func (s *ServerErrorSuite) TestUploadPackNegotiation(c *C) {
+	_, err := s.uploadPack(c, &scriptedCommander{
+		stdout: s.script(c, []byte("ERR upload-pack: not our ref "+scriptedHead.String()+"\n")),
+	})
+	s.assertProtocolError(c, err, "upload-pack: not our ref "+scriptedHead.String())
+}
+
+func (s *ServerErrorSuite) TestUploadPackStderr(c *C) {
+	_, err := s.uploadPack(c, &scriptedCommander{
+		stdout: s.script(c),
+		stderr: "fatal: out of memory\n",
+	})
+	s.assertProtocolError(c, err, "fatal: out of memory")
+}
+
+func (s *ServerErrorSuite) TestUploadPackPackData(c *C) {
+	resp, err := s.uploadPack(c, &scriptedCommander{
+		stdout: s.script(c,
+			[]byte("NAK\n"),
+			sideband.PackData.WithPayload([]byte("PACK\x00\x00\x00\x02")),
+			sideband.ErrorMessage.WithPayload([]byte("fatal: pack-objects died\n")),
+		),
+	})
+	c.Assert(err, IsNil)
+
+	_, err = io.ReadAll(sideband.NewDemuxer(sideband.Sideband64k, resp))
+	err = transport.NewServerError(s.endpoint, err)
+	s.assertProtocolError(c, err, "fatal: pack-objects died")
+}
+
+func (s *ServerErrorSuite) TestReceivePackErrorLine(c *C) {
+	_, err := s.receivePack(c, &scriptedCommander{
+		stdout: s.script(c, []byte("ERR push disabled\n")),
+	}, nil)
+	s.assertProtocolError(c, err, "push disabled")
+}
+
+func (s *ServerErrorSuite) TestReceivePackErrorChannel(c *C) {
+	var progress bytes.Buffer
+	_, err := s.receivePack(c, &scriptedCommander{
+		stdout: s.script(c,
+			sideband.ProgressMessage.WithPayload([]byte("running the pre-receive hook\n")),
+			sideband.ErrorMessage.WithPayload([]byte("pre-receive hook declined\n")),
+		),
+	}, &progress)
+	s.assertProtocolError(c, err, "pre-receive hook declined")
+	c.Assert(progress.String(), Equals, "running the pre-receive hook\n")
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/utils/ioutil"
@@ -37,6 +38,8 @@ func ServeUploadPack(cmd ServerCommand, s transport.UploadPackSession) (err erro
 	var resp *packp.UploadPackResponse
 	resp, err = s.UploadPack(context.TODO(), req)
 	if err != nil {
+		// The request is refused with an ERR pkt-line, as git does.
+		_ = (&pktline.ErrorLine{Text: err.Error()}).Encode(cmd.Stdout)
 		return err
 	}
 
@@ -63,6 +66,8 @@ func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
 		if err := rs.Encode(cmd.Stdout); err != nil {
 			return fmt.Errorf("error in encoding report status %s", err)
 		}
+	} else if err != nil {
+		_ = (&pktline.ErrorLine{Text: err.Error()}).Encode(cmd.Stdout)
 	}
 
 	if err != nil {
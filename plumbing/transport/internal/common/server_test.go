@@ -0,0 +1,56 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+type ServerSuite struct{}
+
+var _ = Suite(&ServerSuite{})
+
+func (s *ServerSuite) TestServeUploadPackErrorLine(c *C) {
+	ep, err := transport.NewEndpoint("/repo.git")
+	c.Assert(err, IsNil)
+
+	srv := server.NewServerWithOptions(
+		server.MapLoader{ep.String(): memory.NewStorage()},
+		&server.Options{MaxWants: 1},
+	)
+
+	session, err := srv.NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+	}
+
+	var stdin, stdout bytes.Buffer
+	c.Assert(uploadPack(ioutil.WriteNopCloser(&stdin), nil, req), IsNil)
+
+	err = ServeUploadPack(ServerCommand{
+		Stdin:  &stdin,
+		Stdout: ioutil.WriteNopCloser(&stdout),
+	}, session)
+	c.Assert(errors.Is(err, server.ErrTooManyWants), Equals, true)
+
+	ar := packp.NewAdvRefs()
+	c.Assert(ar.Decode(&stdout), IsNil)
+
+	// The scanner returns the ERR pkt-line as an error.
+	var line *pktline.ErrorLine
+	c.Assert(errors.As(new(pktline.ErrorLine).Decode(&stdout), &line), Equals, true)
+	c.Assert(line.Text, Equals, err.Error())
+}
@@ -0,0 +1,159 @@
+package common
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// watchdog enforces the idle and operation timeouts of a session, killing its
+// command once one is exceeded, so the blocked reads and writes return. A nil
+// watchdog enforces nothing.
+type watchdog struct {
+	timeouts transport.Timeouts
+	kill     func()
+
+	mu        sync.Mutex
+	phase     transport.Phase
+	idle      *time.Timer
+	op        *time.Timer
+	reading   bool
+	readStart time.Time
+	stopped   bool
+	err       *transport.TimeoutError
+}
+
+// newWatchdog returns a watchdog calling kill once a timeout is exceeded, or
+// nil if there is no timeout to enforce.
+func newWatchdog(t transport.Timeouts, kill func()) *watchdog {
+	if t.Idle <= 0 && t.Operation <= 0 {
+		return nil
+	}
+
+	w := &watchdog{
+		timeouts: t,
+		kill:     kill,
+		phase:    transport.PhaseAdvertisement,
+	}
+
+	if t.Operation > 0 {
+		w.op = time.AfterFunc(t.Operation, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.expire(t.Operation, false)
+		})
+	}
+
+	if t.Idle > 0 {
+		w.idle = time.AfterFunc(t.Idle, w.expireIdle)
+		w.idle.Stop()
+	}
+
+	return w
+}
+
+// setPhase sets the phase reported by the timeout errors.
+func (w *watchdog) setPhase(p transport.Phase) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.phase = p
+}
+
+// reader returns r with the idle timeout enforced on each read.
+func (w *watchdog) reader(r io.Reader) io.Reader {
+	if w == nil || w.idle == nil {
+		return r
+	}
+
+	return &watchdogReader{r: r, w: w}
+}
+
+// error returns the timeout error if a timeout was exceeded, since it is the
+// cause of err, otherwise err.
+func (w *watchdog) error(err error) error {
+	if w == nil || err == nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+
+	return err
+}
+
+// stop stops enforcing the timeouts.
+func (w *watchdog) stop() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	if w.op != nil {
+		w.op.Stop()
+	}
+
+	if w.idle != nil {
+		w.idle.Stop()
+	}
+}
+
+func (w *watchdog) startRead() {
+	w.mu.Lock()
+	w.reading = true
+	w.readStart = time.Now()
+	w.mu.Unlock()
+	w.idle.Reset(w.timeouts.Idle)
+}
+
+func (w *watchdog) endRead() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.reading = false
+	w.idle.Stop()
+}
+
+func (w *watchdog) expireIdle() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// The timer may fire after the read it was armed for returned.
+	if !w.reading || time.Since(w.readStart) < w.timeouts.Idle {
+		return
+	}
+
+	w.expire(w.timeouts.Idle, true)
+}
+
+// expire kills the command, w.mu must be held, so the command is killed before
+// the error is reported.
+func (w *watchdog) expire(d time.Duration, idle bool) {
+	if w.stopped || w.err != nil {
+		return
+	}
+
+	w.err = &transport.TimeoutError{Phase: w.phase, Timeout: d, Idle: idle}
+	w.kill()
+}
+
+type watchdogReader struct {
+	r io.Reader
+	w *watchdog
+}
+
+func (r *watchdogReader) Read(p []byte) (int, error) {
+	r.w.startRead()
+	n, err := r.r.Read(p)
+	r.w.endRead()
+	return n, r.w.error(err)
+}
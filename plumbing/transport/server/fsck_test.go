@@ -0,0 +1,165 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/fsck"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type FsckSuite struct {
+	endpoint *transport.Endpoint
+}
+
+var _ = Suite(&FsckSuite{})
+
+const (
+	goodAuthor = "author foo <foo@foo.foo> 1600000000 +0200"
+	badAuthor  = "author foo <foo 1600000000 +0200"
+)
+
+func (s *FsckSuite) SetUpTest(c *C) {
+	var err error
+	s.endpoint, err = transport.NewEndpoint("/fsck.git")
+	c.Assert(err, IsNil)
+}
+
+// packfile returns a packfile holding an empty tree, if withTree, and a
+// commit of it with the given author line.
+func (s *FsckSuite) packfile(c *C, author string, withTree bool) (io.ReadCloser, plumbing.Hash) {
+	sto := memory.NewStorage()
+	store := func(t plumbing.ObjectType, content string) plumbing.Hash {
+		obj := sto.NewEncodedObject()
+		obj.SetType(t)
+		w, err := obj.Writer()
+		c.Assert(err, IsNil)
+		_, err = w.Write([]byte(content))
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+		h, err := sto.SetEncodedObject(obj)
+		c.Assert(err, IsNil)
+		return h
+	}
+
+	tree := store(plumbing.TreeObject, "")
+	commit := store(plumbing.CommitObject, fmt.Sprintf(
+		"tree %s\n%s\ncommitter foo <foo@foo.foo> 1600000000 +0200\n\nmessage\n", tree, author))
+
+	hashes := []plumbing.Hash{commit}
+	if withTree {
+		hashes = append(hashes, tree)
+	}
+
+	var buf bytes.Buffer
+	_, err := packfile.NewEncoder(&buf, sto, false).Encode(hashes, 10)
+	c.Assert(err, IsNil)
+	return io.NopCloser(&buf), commit
+}
+
+func (s *FsckSuite) receivePack(c *C, sto storer.Storer, o *server.Options, author string, withTree bool) (
+	*packp.ReportStatus, error) {
+
+	req := packp.NewReferenceUpdateRequest()
+	var head plumbing.Hash
+	req.Packfile, head = s.packfile(c, author, withTree)
+	req.Commands = []*packp.Command{{Name: plumbing.Master, New: head}}
+	req.Capabilities.Set(capability.ReportStatus)
+
+	srv := server.NewServerWithOptions(server.MapLoader{s.endpoint.String(): sto}, o)
+	r, err := srv.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	return r.ReceivePack(context.Background(), req)
+}
+
+func (s *FsckSuite) TestAccept(c *C) {
+	sto := memory.NewStorage()
+	report, err := s.receivePack(c, sto, &server.Options{FsckObjects: true}, goodAuthor, true)
+	c.Assert(err, IsNil)
+	c.Assert(report.Error(), IsNil)
+
+	_, err = sto.Reference(plumbing.Master)
+	c.Assert(err, IsNil)
+}
+
+func (s *FsckSuite) TestRejectInvalidObject(c *C) {
+	sto := memory.NewStorage()
+	report, err := s.receivePack(c, sto, &server.Options{FsckObjects: true}, badAuthor, true)
+	c.Assert(errors.Is(err, server.ErrInvalidObjects), Equals, true)
+	c.Assert(report.UnpackStatus, Matches, "invalid object commit .*: badEmail: invalid author: bad email")
+
+	c.Assert(sto.Objects, HasLen, 0)
+	_, err = sto.Reference(plumbing.Master)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *FsckSuite) TestRejectMissingObjects(c *C) {
+	sto := memory.NewStorage()
+	_, err := s.receivePack(c, sto, &server.Options{FsckObjects: true}, goodAuthor, false)
+	c.Assert(errors.Is(err, server.ErrMissingObjects), Equals, true)
+	c.Assert(sto.Objects, HasLen, 0)
+}
+
+func (s *FsckSuite) TestSeverities(c *C) {
+	sto := memory.NewStorage()
+	report, err := s.receivePack(c, sto, &server.Options{
+		FsckObjects:    true,
+		FsckSeverities: map[fsck.MsgID]fsck.Severity{fsck.BadEmail: fsck.Warn},
+	}, badAuthor, true)
+	c.Assert(err, IsNil)
+	c.Assert(report.Error(), IsNil)
+
+	_, err = sto.Reference(plumbing.Master)
+	c.Assert(err, IsNil)
+}
+
+func (s *FsckSuite) TestDisabled(c *C) {
+	sto := memory.NewStorage()
+	_, err := s.receivePack(c, sto, nil, badAuthor, true)
+	c.Assert(err, IsNil)
+
+	_, err = sto.Reference(plumbing.Master)
+	c.Assert(err, IsNil)
+}
+
+func (s *FsckSuite) TestRejectInQuarantine(c *C) {
+	fs := memfs.New()
+	sto := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	_, err := s.receivePack(c, sto, &server.Options{FsckObjects: true}, badAuthor, true)
+	c.Assert(errors.Is(err, server.ErrInvalidObjects), Equals, true)
+
+	files, err := fs.ReadDir("objects")
+	c.Assert(err, IsNil)
+	for _, f := range files {
+		c.Assert(strings.HasPrefix(f.Name(), "tmp_objdir-incoming-"), Equals, false)
+	}
+
+	files, err = fs.ReadDir("objects/pack")
+	if err == nil {
+		c.Assert(files, HasLen, 0)
+	}
+
+	_, err = sto.Reference(plumbing.Master)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/fsck"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// ReceiveHooks are the callbacks run by the server while processing a push,
+// the equivalent of the pre-receive, update and post-receive git hooks. Any
+// of them can be nil. The error messages of the rejections are reported to
+// the client through report-status.
+type ReceiveHooks struct {
+	// PreReceive is called with all the commands of the push once the
+	// packfile is received, before updating any reference. If it returns an
+	// error, the whole push is rejected and the received objects are
+	// discarded. The objects storage holds the received objects in addition
+	// to the ones of the repository.
+	PreReceive func(ctx context.Context, objects storer.EncodedObjectStorer, cmds []*packp.Command) error
+	// Update is called for each command before updating the reference, if
+	// it returns an error the reference is left untouched.
+	Update func(ctx context.Context, objects storer.EncodedObjectStorer, cmd *packp.Command) error
+	// PostReceive is called once the references are updated, with the
+	// commands that succeeded.
+	PostReceive func(ctx context.Context, sto storer.Storer, cmds []*packp.Command)
+}
+
+// Options configures the server.
+type Options struct {
+	// Hooks are the callbacks run while receiving a push.
+	Hooks ReceiveHooks
+	// FsckObjects checks the objects received in a push are well formed,
+	// and that all the objects reachable from the updated references are
+	// found, as receive.fsckObjects does. The push is rejected otherwise.
+	// The checks are strict, the problems git only warns about are errors.
+	FsckObjects bool
+	// FsckSeverities overrides the severity of the checks of FsckObjects,
+	// as the receive.fsck.<msg-id> options of git.
+	FsckSeverities map[fsck.MsgID]fsck.Severity
+	// Namespace is the ref namespace served, as GIT_NAMESPACE. Only its
+	// references are advertised, without the prefix of the namespace, and
+	// the pushed references are prefixed. The objects wanted in a fetch
+	// must be reachable from them, and the objects only reachable from the
+	// references outside of the namespace are ignored in the negotiation.
+	// The hooks get the commands with the names sent by the client, without
+	// the prefix, and the whole storage.
+	Namespace string
+	// Instrumentation, if not nil, receives the events of the sessions.
+	Instrumentation Instrumentation
+	// MaxWants, if positive, is the maximum number of objects a fetch can
+	// want, ErrTooManyWants is returned for more.
+	MaxWants int
+	// MaxReceivePackSize, if positive, is the maximum size in bytes of the
+	// packfile received in a push, ErrPackTooLarge is returned for larger
+	// ones.
+	MaxReceivePackSize int64
+	// Timeout, if positive, is the maximum duration of a session, from its
+	// creation. ErrSessionTimeout is returned once exceeded.
+	Timeout time.Duration
+}
+
+// quarantine is an object storage holding the objects received in a push
+// in a temporary directory, apart from the repository, until the push is
+// accepted. The objects of the repository are readable through it, so deltas
+// can be resolved against them. It is used for the storages which are not a
+// storer.QuarantineStorer.
+type quarantine struct {
+	storer.EncodedObjectStorer
+	objects *filesystem.ObjectStorage
+	dir     string
+}
+
+func newQuarantine(s storer.EncodedObjectStorer) (*quarantine, error) {
+	dir, err := os.MkdirTemp("", "go-git-incoming-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &quarantine{
+		EncodedObjectStorer: s,
+		objects:             filesystem.NewObjectStorage(dotgit.New(osfs.New(dir)), cache.NewObjectLRUDefault()),
+		dir:                 dir,
+	}, nil
+}
+
+// Migrate copies the received objects to the storage of the repository.
+func (q *quarantine) Migrate() error {
+	iter, err := q.objects.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return err
+	}
+
+	return iter.ForEach(func(obj plumbing.EncodedObject) error {
+		if q.EncodedObjectStorer.HasEncodedObject(obj.Hash()) == nil {
+			return nil
+		}
+
+		return copyObject(q.EncodedObjectStorer, obj)
+	})
+}
+
+// copyObject stores in s a copy of obj, whose content is read from the
+// quarantine directory.
+func copyObject(s storer.EncodedObjectStorer, obj plumbing.EncodedObject) (err error) {
+	c := s.NewEncodedObject()
+	c.SetType(obj.Type())
+	c.SetSize(obj.Size())
+
+	r, err := obj.Reader()
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	w, err := c.Writer()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	_, err = s.SetEncodedObject(c)
+	return err
+}
+
+// Remove deletes the quarantine directory, with the received objects.
+func (q *quarantine) Remove() error {
+	if err := q.objects.Close(); err != nil {
+		_ = os.RemoveAll(q.dir)
+		return err
+	}
+
+	return os.RemoveAll(q.dir)
+}
+
+func (q *quarantine) NewEncodedObject() plumbing.EncodedObject {
+	return q.objects.NewEncodedObject()
+}
+
+func (q *quarantine) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	return q.objects.SetEncodedObject(obj)
+}
+
+func (q *quarantine) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := q.objects.EncodedObject(t, h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.EncodedObjectStorer.EncodedObject(t, h)
+	}
+
+	return obj, err
+}
+
+func (q *quarantine) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	received, err := q.objects.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := q.EncodedObjectStorer.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return storer.NewMultiEncodedObjectIter([]storer.EncodedObjectIter{received, existing}), nil
+}
+
+func (q *quarantine) HasEncodedObject(h plumbing.Hash) error {
+	if err := q.objects.HasEncodedObject(h); err != plumbing.ErrObjectNotFound {
+		return err
+	}
+
+	return q.EncodedObjectStorer.HasEncodedObject(h)
+}
+
+func (q *quarantine) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := q.objects.EncodedObjectSize(h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.EncodedObjectStorer.EncodedObjectSize(h)
+	}
+
+	return size, err
+}
@@ -0,0 +1,305 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type HooksSuite struct {
+	fixtures.Suite
+
+	endpoint *transport.Endpoint
+	storage  *memory.Storage
+	loader   server.MapLoader
+}
+
+var _ = Suite(&HooksSuite{})
+
+func (s *HooksSuite) SetUpTest(c *C) {
+	var err error
+	s.endpoint, err = transport.NewEndpoint("/hooks.git")
+	c.Assert(err, IsNil)
+
+	s.storage = memory.NewStorage()
+	s.loader = server.MapLoader{s.endpoint.String(): s.storage}
+}
+
+func (s *HooksSuite) receivePack(c *C, hooks server.ReceiveHooks, names ...plumbing.ReferenceName) (
+	*packp.ReportStatus, error) {
+
+	fixture := fixtures.Basic().ByTag("packfile").One()
+	req := packp.NewReferenceUpdateRequest()
+	for _, name := range names {
+		req.Commands = append(req.Commands, &packp.Command{
+			Name: name, Old: plumbing.ZeroHash, New: plumbing.NewHash(fixture.Head),
+		})
+	}
+
+	req.Capabilities.Set(capability.ReportStatus)
+	req.Packfile = fixture.Packfile()
+
+	srv := server.NewServerWithOptions(s.loader, &server.Options{Hooks: hooks})
+	r, err := srv.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	return r.ReceivePack(context.Background(), req)
+}
+
+func (s *HooksSuite) TestPreReceiveReject(c *C) {
+	head := plumbing.NewHash(fixtures.Basic().ByTag("packfile").One().Head)
+	report, err := s.receivePack(c, server.ReceiveHooks{
+		PreReceive: func(ctx context.Context, objects storer.EncodedObjectStorer, cmds []*packp.Command) error {
+			c.Assert(cmds, HasLen, 1)
+			_, err := objects.EncodedObject(plumbing.CommitObject, head)
+			c.Assert(err, IsNil)
+			return errors.New("push declined")
+		},
+		Update: func(context.Context, storer.EncodedObjectStorer, *packp.Command) error {
+			c.Fatal("update hook called on a rejected push")
+			return nil
+		},
+	}, "refs/heads/master")
+
+	c.Assert(err, ErrorMatches, "push declined")
+	c.Assert(report.UnpackStatus, Equals, "ok")
+	c.Assert(report.CommandStatuses, HasLen, 1)
+	c.Assert(report.CommandStatuses[0].Status, Equals, "push declined")
+
+	c.Assert(s.storage.Objects, HasLen, 0)
+	_, err = s.storage.Reference("refs/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *HooksSuite) TestPreReceiveAccept(c *C) {
+	var received []*packp.Command
+	report, err := s.receivePack(c, server.ReceiveHooks{
+		PreReceive: func(context.Context, storer.EncodedObjectStorer, []*packp.Command) error {
+			return nil
+		},
+		PostReceive: func(ctx context.Context, sto storer.Storer, cmds []*packp.Command) {
+			received = cmds
+		},
+	}, "refs/heads/master")
+
+	c.Assert(err, IsNil)
+	c.Assert(report.Error(), IsNil)
+	c.Assert(s.storage.Objects, Not(HasLen), 0)
+	c.Assert(received, HasLen, 1)
+
+	ref, err := s.storage.Reference("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, received[0].New)
+}
+
+func (s *HooksSuite) TestPreReceiveQuarantineDir(c *C) {
+	tmp := c.MkDir()
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	for _, reject := range []bool{true, false} {
+		_, err := s.receivePack(c, server.ReceiveHooks{
+			PreReceive: func(context.Context, storer.EncodedObjectStorer, []*packp.Command) error {
+				entries, err := os.ReadDir(tmp)
+				c.Assert(err, IsNil)
+				c.Assert(entries, HasLen, 1)
+				c.Assert(strings.HasPrefix(entries[0].Name(), "go-git-incoming-"), Equals, true)
+				c.Assert(s.storage.Objects, HasLen, 0)
+
+				if reject {
+					return errors.New("push declined")
+				}
+
+				return nil
+			},
+		}, "refs/heads/master")
+
+		c.Assert(err != nil, Equals, reject)
+		c.Assert(len(s.storage.Objects) != 0, Equals, !reject)
+
+		entries, err := os.ReadDir(tmp)
+		c.Assert(err, IsNil)
+		c.Assert(entries, HasLen, 0)
+	}
+}
+
+func (s *HooksSuite) TestUpdateReject(c *C) {
+	var received []*packp.Command
+	report, err := s.receivePack(c, server.ReceiveHooks{
+		Update: func(ctx context.Context, objects storer.EncodedObjectStorer, cmd *packp.Command) error {
+			if cmd.Name == "refs/heads/protected" {
+				return errors.New("protected branch")
+			}
+
+			return nil
+		},
+		PostReceive: func(ctx context.Context, sto storer.Storer, cmds []*packp.Command) {
+			received = cmds
+		},
+	}, "refs/heads/master", "refs/heads/protected")
+
+	c.Assert(err, ErrorMatches, "protected branch")
+	c.Assert(report.CommandStatuses, HasLen, 2)
+	c.Assert(received, HasLen, 1)
+	c.Assert(received[0].Name, Equals, plumbing.ReferenceName("refs/heads/master"))
+
+	_, err = s.storage.Reference("refs/heads/master")
+	c.Assert(err, IsNil)
+	_, err = s.storage.Reference("refs/heads/protected")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+type QuarantineSuite struct {
+	fixtures.Suite
+
+	endpoint *transport.Endpoint
+	fs       billy.Filesystem
+	storage  *filesystem.Storage
+	loader   server.MapLoader
+}
+
+var _ = Suite(&QuarantineSuite{})
+
+func (s *QuarantineSuite) SetUpTest(c *C) {
+	var err error
+	s.endpoint, err = transport.NewEndpoint("/quarantine.git")
+	c.Assert(err, IsNil)
+
+	s.fs = memfs.New()
+	s.storage = filesystem.NewStorage(s.fs, cache.NewObjectLRUDefault())
+	s.loader = server.MapLoader{s.endpoint.String(): s.storage}
+}
+
+func (s *QuarantineSuite) receivePack(c *C, hooks server.ReceiveHooks, head plumbing.Hash) (*packp.ReportStatus, error) {
+	req := packp.NewReferenceUpdateRequest()
+	req.Commands = []*packp.Command{{Name: plumbing.Master, New: head}}
+	req.Capabilities.Set(capability.ReportStatus)
+	req.Packfile = fixtures.Basic().ByTag("packfile").One().Packfile()
+
+	srv := server.NewServerWithOptions(s.loader, &server.Options{Hooks: hooks})
+	r, err := srv.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	return r.ReceivePack(context.Background(), req)
+}
+
+// objectsDir returns the names of the quarantine directories, and of the
+// files of the pack directory.
+func (s *QuarantineSuite) objectsDir(c *C) ([]string, []string) {
+	var quarantines, packs []string
+	for _, name := range s.readDir(c, "objects") {
+		if strings.HasPrefix(name, "tmp_objdir-incoming-") {
+			quarantines = append(quarantines, name)
+		}
+	}
+
+	packs = s.readDir(c, "objects/pack")
+	return quarantines, packs
+}
+
+func (s *QuarantineSuite) readDir(c *C, dir string) []string {
+	files, err := s.fs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	c.Assert(err, IsNil)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+
+	return names
+}
+
+func (s *QuarantineSuite) TestAccept(c *C) {
+	head := plumbing.NewHash(fixtures.Basic().ByTag("packfile").One().Head)
+	report, err := s.receivePack(c, server.ReceiveHooks{
+		PreReceive: func(ctx context.Context, objects storer.EncodedObjectStorer, cmds []*packp.Command) error {
+			_, err := objects.EncodedObject(plumbing.CommitObject, head)
+			c.Assert(err, IsNil)
+
+			_, err = s.storage.EncodedObject(plumbing.CommitObject, head)
+			c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+
+			quarantines, packs := s.objectsDir(c)
+			c.Assert(quarantines, HasLen, 1)
+			c.Assert(packs, HasLen, 0)
+			return nil
+		},
+	}, head)
+
+	c.Assert(err, IsNil)
+	c.Assert(report.Error(), IsNil)
+
+	quarantines, packs := s.objectsDir(c)
+	c.Assert(quarantines, HasLen, 0)
+	c.Assert(packs, HasLen, 2)
+	for _, name := range packs {
+		c.Assert(strings.HasSuffix(name, ".keep"), Equals, false)
+	}
+
+	_, err = s.storage.EncodedObject(plumbing.CommitObject, head)
+	c.Assert(err, IsNil)
+
+	ref, err := s.storage.Reference(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, head)
+}
+
+func (s *QuarantineSuite) TestPreReceiveReject(c *C) {
+	head := plumbing.NewHash(fixtures.Basic().ByTag("packfile").One().Head)
+	_, err := s.receivePack(c, server.ReceiveHooks{
+		PreReceive: func(context.Context, storer.EncodedObjectStorer, []*packp.Command) error {
+			return errors.New("push declined")
+		},
+	}, head)
+	c.Assert(err, ErrorMatches, "push declined")
+
+	quarantines, packs := s.objectsDir(c)
+	c.Assert(quarantines, HasLen, 0)
+	c.Assert(packs, HasLen, 0)
+
+	_, err = s.storage.Reference(plumbing.Master)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *QuarantineSuite) TestMissingObjects(c *C) {
+	missing := plumbing.NewHash("1111111111111111111111111111111111111111")
+	report, err := s.receivePack(c, server.ReceiveHooks{
+		PreReceive: func(context.Context, storer.EncodedObjectStorer, []*packp.Command) error {
+			c.Fatal("pre-receive hook called with missing objects")
+			return nil
+		},
+	}, missing)
+	c.Assert(errors.Is(err, server.ErrMissingObjects), Equals, true)
+	c.Assert(report.UnpackStatus, Not(Equals), "ok")
+
+	quarantines, packs := s.objectsDir(c)
+	c.Assert(quarantines, HasLen, 0)
+	c.Assert(packs, HasLen, 0)
+}
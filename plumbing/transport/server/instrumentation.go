@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var (
+	// ErrTooManyWants is returned when a fetch wants more objects than
+	// Options.MaxWants.
+	ErrTooManyWants = errors.New("too many wants")
+	// ErrPackTooLarge is returned when the packfile received in a push is
+	// larger than Options.MaxReceivePackSize.
+	ErrPackTooLarge = errors.New("packfile too large")
+	// ErrSessionTimeout is returned when a session lasts longer than
+	// Options.Timeout.
+	ErrSessionTimeout = errors.New("session timeout")
+)
+
+// Instrumentation receives the events of the sessions of the server, to log
+// or measure them. Its methods are called concurrently for the different
+// sessions.
+type Instrumentation interface {
+	// SessionStarted is called when a session is created.
+	SessionStarted(s *SessionInfo)
+	// Negotiated is called with the request of the client once read, before
+	// sending or receiving the packfile. If it returns an error, the
+	// session is aborted with it.
+	Negotiated(s *SessionInfo, n *NegotiationInfo) error
+	// PackTransferred is called once the packfile is sent, or received.
+	PackTransferred(s *SessionInfo, t *TransferInfo)
+	// Error is called with the errors aborting the session, or rejecting
+	// the commands of a push.
+	Error(s *SessionInfo, err error)
+}
+
+// SessionInfo describes a session of the server.
+type SessionInfo struct {
+	// Service is the service of the session, transport.UploadPackServiceName
+	// or transport.ReceivePackServiceName.
+	Service string
+	// Endpoint is the endpoint of the repository served, if known.
+	Endpoint *transport.Endpoint
+	// Start is the time the session was created at.
+	Start time.Time
+}
+
+// NegotiationInfo is the request of the client of a session.
+type NegotiationInfo struct {
+	// Capabilities are the capabilities requested by the client.
+	Capabilities *capability.List
+	// Wants are the objects wanted in a fetch.
+	Wants []plumbing.Hash
+	// Haves are the objects the client of a fetch has.
+	Haves []plumbing.Hash
+	// Shallows are the shallow commits of the client of a fetch.
+	Shallows []plumbing.Hash
+	// Depth is the depth requested in a fetch.
+	Depth packp.Depth
+	// Objects is the number of objects to send in a fetch.
+	Objects int
+	// Commands are the updates of the references requested in a push.
+	Commands []*packp.Command
+}
+
+// TransferInfo describes the transfer of a packfile.
+type TransferInfo struct {
+	// Bytes is the size of the packfile transferred.
+	Bytes int64
+	// Objects is the number of objects of the packfile, as written in its
+	// header.
+	Objects uint32
+	// Duration is the time spent transferring the packfile.
+	Duration time.Duration
+}
+
+// instrumentedSession holds the instrumentation and the limits of a session.
+type instrumentedSession struct {
+	info     *SessionInfo
+	instr    Instrumentation
+	timeout  time.Duration
+	deadline time.Time
+
+	mu      sync.Mutex
+	cancels []context.CancelFunc
+}
+
+func newInstrumentedSession(h *handler, service string, ep *transport.Endpoint) *instrumentedSession {
+	s := &instrumentedSession{
+		info:    &SessionInfo{Service: service, Endpoint: ep, Start: time.Now()},
+		instr:   h.instr,
+		timeout: h.timeout,
+	}
+
+	if h.timeout > 0 {
+		s.deadline = s.info.Start.Add(h.timeout)
+	}
+
+	if s.instr != nil {
+		s.instr.SessionStarted(s.info)
+	}
+
+	return s
+}
+
+// withDeadline returns ctx expiring at the deadline of the session, if any.
+func (s *instrumentedSession) withDeadline(ctx context.Context) context.Context {
+	if s.deadline.IsZero() {
+		return ctx
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, s.deadline)
+	s.mu.Lock()
+	s.cancels = append(s.cancels, cancel)
+	s.mu.Unlock()
+
+	return ctx
+}
+
+// close releases the contexts returned by withDeadline.
+func (s *instrumentedSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+
+	s.cancels = nil
+}
+
+// expired returns ErrSessionTimeout, reported, if the deadline of the
+// session is exceeded.
+func (s *instrumentedSession) expired() error {
+	if s.deadline.IsZero() || time.Now().Before(s.deadline) {
+		return nil
+	}
+
+	return s.fail(context.DeadlineExceeded)
+}
+
+func (s *instrumentedSession) negotiated(n *NegotiationInfo) error {
+	if s.instr == nil {
+		return nil
+	}
+
+	return s.instr.Negotiated(s.info, n)
+}
+
+// fail reports the given error, returned as ErrSessionTimeout if the
+// deadline of the session is exceeded.
+func (s *instrumentedSession) fail(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) && !s.deadline.IsZero() && !time.Now().Before(s.deadline) {
+		err = fmt.Errorf("%w: exceeded %s", ErrSessionTimeout, s.timeout)
+	}
+
+	if s.instr != nil {
+		s.instr.Error(s.info, err)
+	}
+
+	return err
+}
+
+// transfer returns r reporting the transfer of the packfile read from it,
+// failing with ErrPackTooLarge once more than limit bytes are read if limit
+// is positive. If failures is true, the errors reading r are reported too.
+func (s *instrumentedSession) transfer(r io.ReadCloser, limit int64, failures bool) *transferReader {
+	return &transferReader{ReadCloser: r, s: s, limit: limit, failures: failures, start: time.Now()}
+}
+
+// transferReader measures the packfile read through it.
+type transferReader struct {
+	io.ReadCloser
+	s        *instrumentedSession
+	limit    int64
+	failures bool
+	start    time.Time
+
+	n      int64
+	header [12]byte
+	err    error
+	once   sync.Once
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.n < int64(len(r.header)) {
+		copy(r.header[r.n:], p[:n])
+	}
+
+	r.n += int64(n)
+	if r.limit > 0 && r.n > r.limit {
+		err = fmt.Errorf("%w: more than %d bytes", ErrPackTooLarge, r.limit)
+	}
+
+	switch {
+	case err == io.EOF:
+		r.done()
+	case err != nil:
+		r.err = err
+		r.done()
+	}
+
+	return n, err
+}
+
+func (r *transferReader) Close() error {
+	r.done()
+	return r.ReadCloser.Close()
+}
+
+// done reports the transfer, or its error, once.
+func (r *transferReader) done() {
+	r.once.Do(func() {
+		if r.err != nil {
+			if r.failures {
+				r.s.fail(r.err)
+			}
+
+			return
+		}
+
+		if r.s.instr == nil {
+			return
+		}
+
+		t := &TransferInfo{Bytes: r.n, Duration: time.Since(r.start)}
+		if r.n >= int64(len(r.header)) && string(r.header[:4]) == "PACK" {
+			t.Objects = binary.BigEndian.Uint32(r.header[8:])
+		}
+
+		r.s.instr.PackTransferred(r.s.info, t)
+	})
+}
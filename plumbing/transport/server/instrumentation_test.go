@@ -0,0 +1,228 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+// recorder is a server.Instrumentation recording the events.
+type recorder struct {
+	mu          sync.Mutex
+	sessions    []*server.SessionInfo
+	negotiated  []*server.NegotiationInfo
+	transferred []*server.TransferInfo
+	errors      []error
+
+	reject error
+}
+
+func (r *recorder) SessionStarted(s *server.SessionInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions = append(r.sessions, s)
+}
+
+func (r *recorder) Negotiated(_ *server.SessionInfo, n *server.NegotiationInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.negotiated = append(r.negotiated, n)
+	return r.reject
+}
+
+func (r *recorder) PackTransferred(_ *server.SessionInfo, t *server.TransferInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transferred = append(r.transferred, t)
+}
+
+func (r *recorder) Error(_ *server.SessionInfo, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, err)
+}
+
+type InstrumentationSuite struct {
+	fixtures.Suite
+
+	basic    *transport.Endpoint
+	empty    *transport.Endpoint
+	loader   server.MapLoader
+	recorder *recorder
+}
+
+var _ = Suite(&InstrumentationSuite{})
+
+func (s *InstrumentationSuite) SetUpTest(c *C) {
+	var err error
+	fs := fixtures.Basic().One().DotGit()
+	s.basic, err = transport.NewEndpoint(fs.Root())
+	c.Assert(err, IsNil)
+
+	s.empty, err = transport.NewEndpoint("/empty.git")
+	c.Assert(err, IsNil)
+
+	s.loader = server.MapLoader{
+		s.basic.String(): filesystem.NewStorage(fs, cache.NewObjectLRUDefault()),
+		s.empty.String(): memory.NewStorage(),
+	}
+
+	s.recorder = &recorder{}
+}
+
+func (s *InstrumentationSuite) server(o *server.Options) transport.Transport {
+	o.Instrumentation = s.recorder
+	return server.NewServerWithOptions(s.loader, o)
+}
+
+func (s *InstrumentationSuite) uploadPack(c *C, o *server.Options, wants ...plumbing.Hash) (*packp.UploadPackResponse, error) {
+	r, err := s.server(o).NewUploadPackSession(s.basic, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = wants
+	return r.UploadPack(context.Background(), req)
+}
+
+func (s *InstrumentationSuite) receivePack(c *C, o *server.Options) (*packp.ReportStatus, error) {
+	fixture := fixtures.Basic().ByTag("packfile").One()
+
+	req := packp.NewReferenceUpdateRequest()
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/master", Old: plumbing.ZeroHash, New: plumbing.NewHash(fixture.Head)},
+	}
+	req.Capabilities.Set(capability.ReportStatus)
+	req.Packfile = fixture.Packfile()
+
+	r, err := s.server(o).NewReceivePackSession(s.empty, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	return r.ReceivePack(context.Background(), req)
+}
+
+func (s *InstrumentationSuite) TestUploadPack(c *C) {
+	head := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	resp, err := s.uploadPack(c, &server.Options{}, head)
+	c.Assert(err, IsNil)
+
+	n, err := io.Copy(io.Discard, resp)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Close(), IsNil)
+
+	c.Assert(s.recorder.sessions, HasLen, 1)
+	c.Assert(s.recorder.sessions[0].Service, Equals, transport.UploadPackServiceName)
+	c.Assert(s.recorder.sessions[0].Endpoint, Equals, s.basic)
+
+	c.Assert(s.recorder.negotiated, HasLen, 1)
+	c.Assert(s.recorder.negotiated[0].Wants, DeepEquals, []plumbing.Hash{head})
+	c.Assert(s.recorder.negotiated[0].Objects, Equals, 28)
+
+	c.Assert(s.recorder.transferred, HasLen, 1)
+	c.Assert(s.recorder.transferred[0].Bytes, Equals, n)
+	c.Assert(s.recorder.transferred[0].Objects, Equals, uint32(28))
+	c.Assert(s.recorder.errors, HasLen, 0)
+}
+
+func (s *InstrumentationSuite) TestUploadPackMaxWants(c *C) {
+	_, err := s.uploadPack(c, &server.Options{MaxWants: 1},
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+	)
+	c.Assert(errors.Is(err, server.ErrTooManyWants), Equals, true)
+	c.Assert(s.recorder.errors, DeepEquals, []error{err})
+	c.Assert(s.recorder.negotiated, HasLen, 0)
+}
+
+func (s *InstrumentationSuite) TestUploadPackRejected(c *C) {
+	s.recorder.reject = errors.New("forbidden")
+
+	_, err := s.uploadPack(c, &server.Options{},
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, Equals, s.recorder.reject)
+	c.Assert(s.recorder.errors, DeepEquals, []error{err})
+	c.Assert(s.recorder.transferred, HasLen, 0)
+}
+
+func (s *InstrumentationSuite) TestReceivePack(c *C) {
+	report, err := s.receivePack(c, &server.Options{})
+	c.Assert(err, IsNil)
+	c.Assert(report.Error(), IsNil)
+
+	c.Assert(s.recorder.sessions, HasLen, 1)
+	c.Assert(s.recorder.sessions[0].Service, Equals, transport.ReceivePackServiceName)
+
+	c.Assert(s.recorder.negotiated, HasLen, 1)
+	c.Assert(s.recorder.negotiated[0].Commands, HasLen, 1)
+
+	c.Assert(s.recorder.transferred, HasLen, 1)
+	c.Assert(s.recorder.transferred[0].Objects, Equals, uint32(31))
+	c.Assert(s.recorder.transferred[0].Bytes > 0, Equals, true)
+}
+
+func (s *InstrumentationSuite) TestReceivePackMaxSize(c *C) {
+	report, err := s.receivePack(c, &server.Options{MaxReceivePackSize: 1024})
+	c.Assert(errors.Is(err, server.ErrPackTooLarge), Equals, true)
+	c.Assert(report.UnpackStatus, Matches, "packfile too large.*")
+	c.Assert(s.recorder.errors, HasLen, 1)
+	c.Assert(s.recorder.transferred, HasLen, 0)
+
+	sto, err := s.loader.Load(s.empty)
+	c.Assert(err, IsNil)
+	_, err = sto.Reference("refs/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *InstrumentationSuite) TestTimeout(c *C) {
+	r, err := s.server(&server.Options{Timeout: time.Millisecond}).NewUploadPackSession(s.basic, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(errors.Is(err, server.ErrSessionTimeout), Equals, true)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+	_, err = r.UploadPack(context.Background(), req)
+	c.Assert(errors.Is(err, server.ErrSessionTimeout), Equals, true)
+	c.Assert(s.recorder.errors, HasLen, 2)
+}
+
+func (s *InstrumentationSuite) TestNoInstrumentation(c *C) {
+	r, err := server.NewServerWithOptions(s.loader, &server.Options{MaxWants: 1}).
+		NewUploadPackSession(s.basic, nil)
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+	resp, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(resp.Encode(&buf), IsNil)
+	c.Assert(r.Close(), IsNil)
+}
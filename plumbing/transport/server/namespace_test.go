@@ -0,0 +1,123 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type NamespaceSuite struct {
+	fixtures.Suite
+
+	endpoint *transport.Endpoint
+	storage  *memory.Storage
+	server   transport.Transport
+}
+
+var _ = Suite(&NamespaceSuite{})
+
+func (s *NamespaceSuite) SetUpTest(c *C) {
+	var err error
+	s.endpoint, err = transport.NewEndpoint("/namespace.git")
+	c.Assert(err, IsNil)
+
+	s.storage = memory.NewStorage()
+	s.server = server.NewServerWithOptions(
+		server.MapLoader{s.endpoint.String(): s.storage},
+		&server.Options{Namespace: "foo"},
+	)
+}
+
+func (s *NamespaceSuite) push(c *C) plumbing.Hash {
+	fixture := fixtures.Basic().ByTag("packfile").One()
+	head := plumbing.NewHash(fixture.Head)
+
+	req := packp.NewReferenceUpdateRequest()
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/master", Old: plumbing.ZeroHash, New: head},
+	}
+	req.Capabilities.Set(capability.ReportStatus)
+	req.Packfile = fixture.Packfile()
+
+	r, err := s.server.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.References, HasLen, 0)
+
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(report.CommandStatuses, HasLen, 1)
+	c.Assert(report.CommandStatuses[0].ReferenceName, Equals, plumbing.ReferenceName("refs/heads/master"))
+
+	return head
+}
+
+func (s *NamespaceSuite) TestReceivePack(c *C) {
+	head := s.push(c)
+
+	ref, err := s.storage.Reference("refs/namespaces/foo/refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, head)
+
+	_, err = s.storage.Reference("refs/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *NamespaceSuite) TestAdvertisedReferences(c *C) {
+	head := s.push(c)
+
+	other := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	c.Assert(s.storage.SetReference(plumbing.NewHashReference("refs/heads/other", other)), IsNil)
+	c.Assert(s.storage.SetReference(plumbing.NewSymbolicReference(
+		"refs/namespaces/foo/HEAD", "refs/namespaces/foo/refs/heads/master")), IsNil)
+
+	r, err := s.server.NewUploadPackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.References, DeepEquals, map[string]plumbing.Hash{"refs/heads/master": head})
+	c.Assert(ar.Head, NotNil)
+	c.Assert(*ar.Head, Equals, head)
+	c.Assert(ar.Capabilities.Get(capability.SymRef), DeepEquals, []string{"HEAD:refs/heads/master"})
+}
+
+func (s *NamespaceSuite) TestUploadPackOutsideNamespace(c *C) {
+	head := s.push(c)
+
+	// A commit of the packfile, only reachable from a reference outside of
+	// the namespace once the namespace branch is moved.
+	other := plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")
+	c.Assert(s.storage.SetReference(plumbing.NewHashReference("refs/heads/other", head)), IsNil)
+	c.Assert(s.storage.SetReference(plumbing.NewHashReference(
+		"refs/namespaces/foo/refs/heads/master", other)), IsNil)
+
+	r, err := s.server.NewUploadPackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{head}
+	_, err = r.UploadPack(context.Background(), req)
+	c.Assert(errors.Is(err, server.ErrNotOurRef), Equals, true)
+
+	req = packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{other}
+	req.Haves = []plumbing.Hash{head}
+	resp, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Close(), IsNil)
+}
@@ -7,9 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/fsck"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/revlist"
@@ -28,10 +31,26 @@ type server struct {
 // NewServer returns a transport.Transport implementing a git server,
 // independent of transport. Each transport must wrap this.
 func NewServer(loader Loader) transport.Transport {
-	return &server{
-		loader,
-		&handler{asClient: false},
+	return NewServerWithOptions(loader, nil)
+}
+
+// NewServerWithOptions returns a transport.Transport implementing a git
+// server configured with the given options.
+func NewServerWithOptions(loader Loader, o *Options) transport.Transport {
+	h := &handler{asClient: false}
+	if o != nil {
+		h.hooks = o.Hooks
+		h.namespace = o.Namespace
+		h.instr = o.Instrumentation
+		h.maxWants = o.MaxWants
+		h.maxPackSize = o.MaxReceivePackSize
+		h.timeout = o.Timeout
+		if o.FsckObjects {
+			h.fsck = &fsck.Checker{Strict: true, Severities: o.FsckSeverities}
+		}
 	}
+
+	return &server{loader, h}
 }
 
 // NewClient returns a transport.Transport implementing a client with an
@@ -49,7 +68,7 @@ func (s *server) NewUploadPackSession(ep *transport.Endpoint, auth transport.Aut
 		return nil, err
 	}
 
-	return s.handler.NewUploadPackSession(sto)
+	return s.handler.newUploadPackSession(sto, ep), nil
 }
 
 func (s *server) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
@@ -58,33 +77,69 @@ func (s *server) NewReceivePackSession(ep *transport.Endpoint, auth transport.Au
 		return nil, err
 	}
 
-	return s.handler.NewReceivePackSession(sto)
+	return s.handler.newReceivePackSession(sto, ep), nil
 }
 
 type handler struct {
-	asClient bool
+	asClient    bool
+	hooks       ReceiveHooks
+	fsck        *fsck.Checker
+	namespace   string
+	instr       Instrumentation
+	maxWants    int
+	maxPackSize int64
+	timeout     time.Duration
 }
 
 func (h *handler) NewUploadPackSession(s storer.Storer) (transport.UploadPackSession, error) {
+	return h.newUploadPackSession(s, nil), nil
+}
+
+func (h *handler) newUploadPackSession(s storer.Storer, ep *transport.Endpoint) *upSession {
 	return &upSession{
-		session: session{storer: s, asClient: h.asClient},
-	}, nil
+		session:  h.newSession(s, transport.UploadPackServiceName, ep),
+		maxWants: h.maxWants,
+	}
 }
 
 func (h *handler) NewReceivePackSession(s storer.Storer) (transport.ReceivePackSession, error) {
+	return h.newReceivePackSession(s, nil), nil
+}
+
+func (h *handler) newReceivePackSession(s storer.Storer, ep *transport.Endpoint) *rpSession {
 	return &rpSession{
-		session:   session{storer: s, asClient: h.asClient},
-		cmdStatus: map[plumbing.ReferenceName]error{},
-	}, nil
+		session:     h.newSession(s, transport.ReceivePackServiceName, ep),
+		hooks:       h.hooks,
+		fsck:        h.fsck,
+		maxPackSize: h.maxPackSize,
+		cmdStatus:   map[plumbing.ReferenceName]error{},
+	}
+}
+
+func (h *handler) newSession(s storer.Storer, service string, ep *transport.Endpoint) session {
+	return session{
+		storer:       s,
+		refs:         storer.NewNamespacedReferenceStorer(s, h.namespace),
+		namespaced:   h.namespace != "",
+		asClient:     h.asClient,
+		instrumented: newInstrumentedSession(h, service, ep),
+	}
 }
 
 type session struct {
-	storer   storer.Storer
-	caps     *capability.List
-	asClient bool
+	storer storer.Storer
+	// refs are the references of the namespace of the session, the ones of
+	// the storer without namespace.
+	refs       storer.ReferenceStorer
+	namespaced bool
+	caps       *capability.List
+	asClient   bool
+
+	instrumented *instrumentedSession
 }
 
 func (s *session) Close() error {
+	s.instrumented.close()
 	return nil
 }
 
@@ -105,6 +160,8 @@ func (s *session) checkSupportedCapabilities(cl *capability.List) error {
 
 type upSession struct {
 	session
+	maxWants   int
+	unbornHead plumbing.ReferenceName
 }
 
 func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
@@ -112,6 +169,10 @@ func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
 }
 
 func (s *upSession) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	if err := s.instrumented.expired(); err != nil {
+		return nil, err
+	}
+
 	ar := packp.NewAdvRefs()
 
 	if err := s.setSupportedCapabilities(ar.Capabilities); err != nil {
@@ -120,22 +181,41 @@ func (s *upSession) AdvertisedReferencesContext(ctx context.Context) (*packp.Adv
 
 	s.caps = ar.Capabilities
 
-	if err := setReferences(s.storer, ar); err != nil {
+	if err := setReferences(s.refs, ar); err != nil {
 		return nil, err
 	}
 
-	if err := setHEAD(s.storer, ar); err != nil {
+	if err := setHEAD(s.refs, ar); err != nil {
 		return nil, err
 	}
 
 	if s.asClient && len(ar.References) == 0 {
+		s.unbornHead = ar.HeadTarget()
 		return nil, transport.ErrEmptyRemoteRepository
 	}
 
 	return ar, nil
 }
 
+// UnbornHead returns the branch HEAD of the empty repository points to.
+func (s *upSession) UnbornHead() plumbing.ReferenceName {
+	return s.unbornHead
+}
+
 func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	if err := s.instrumented.expired(); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.uploadPack(s.instrumented.withDeadline(ctx), req)
+	if err != nil {
+		return nil, s.instrumented.fail(err)
+	}
+
+	return resp, nil
+}
+
+func (s *upSession) uploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
 	if req.IsEmpty() {
 		return nil, transport.ErrEmptyUploadPackRequest
 	}
@@ -161,11 +241,27 @@ func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest
 		return nil, fmt.Errorf("shallow not supported")
 	}
 
+	if s.maxWants > 0 && len(req.Wants) > s.maxWants {
+		return nil, fmt.Errorf("%w: %d, the maximum is %d", ErrTooManyWants, len(req.Wants), s.maxWants)
+	}
+
 	objs, err := s.objectsToUpload(req)
 	if err != nil {
 		return nil, err
 	}
 
+	err = s.instrumented.negotiated(&NegotiationInfo{
+		Capabilities: req.Capabilities,
+		Wants:        req.Wants,
+		Haves:        req.Haves,
+		Shallows:     req.Shallows,
+		Depth:        req.Depth,
+		Objects:      len(objs),
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	pr, pw := io.Pipe()
 	e := packfile.NewEncoder(pw, s.storer, false)
 	go func() {
@@ -174,18 +270,73 @@ func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest
 		pw.CloseWithError(err)
 	}()
 
-	return packp.NewUploadPackResponseWithPackfile(req,
-		ioutil.NewContextReadCloser(ctx, pr),
-	), nil
+	pack := s.instrumented.transfer(ioutil.NewContextReadCloser(ctx, pr), 0, true)
+	return packp.NewUploadPackResponseWithPackfile(req, pack), nil
 }
 
 func (s *upSession) objectsToUpload(req *packp.UploadPackRequest) ([]plumbing.Hash, error) {
-	haves, err := revlist.Objects(s.storer, req.Haves, nil)
+	wants, haves := req.Wants, req.Haves
+	if s.namespaced {
+		var err error
+		wants, haves, err = s.namespaceObjects(wants, haves)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	haves, err := revlist.Objects(s.storer, haves, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return revlist.Objects(s.storer, req.Wants, haves)
+	return revlist.Objects(s.storer, wants, haves)
+}
+
+// namespaceObjects checks the wants are reachable from the references of the
+// namespace, and drops the haves that aren't, so the objects only reachable
+// from outside the namespace are not negotiated.
+func (s *upSession) namespaceObjects(wants, haves []plumbing.Hash) ([]plumbing.Hash, []plumbing.Hash, error) {
+	iter, err := s.refs.IterReferences()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tips []plumbing.Hash
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reachable, err := revlist.Objects(s.storer, tips, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	visible := make(map[plumbing.Hash]bool, len(reachable))
+	for _, h := range reachable {
+		visible[h] = true
+	}
+
+	for _, h := range wants {
+		if !visible[h] {
+			return nil, nil, fmt.Errorf("%w %s", ErrNotOurRef, h)
+		}
+	}
+
+	var namespaced []plumbing.Hash
+	for _, h := range haves {
+		if visible[h] {
+			namespaced = append(namespaced, h)
+		}
+	}
+
+	return wants, namespaced, nil
 }
 
 func (*upSession) setSupportedCapabilities(c *capability.List) error {
@@ -202,9 +353,12 @@ func (*upSession) setSupportedCapabilities(c *capability.List) error {
 
 type rpSession struct {
 	session
-	cmdStatus map[plumbing.ReferenceName]error
-	firstErr  error
-	unpackErr error
+	hooks       ReceiveHooks
+	fsck        *fsck.Checker
+	maxPackSize int64
+	cmdStatus   map[plumbing.ReferenceName]error
+	firstErr    error
+	unpackErr   error
 }
 
 func (s *rpSession) AdvertisedReferences() (*packp.AdvRefs, error) {
@@ -212,6 +366,10 @@ func (s *rpSession) AdvertisedReferences() (*packp.AdvRefs, error) {
 }
 
 func (s *rpSession) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	if err := s.instrumented.expired(); err != nil {
+		return nil, err
+	}
+
 	ar := packp.NewAdvRefs()
 
 	if err := s.setSupportedCapabilities(ar.Capabilities); err != nil {
@@ -220,11 +378,11 @@ func (s *rpSession) AdvertisedReferencesContext(ctx context.Context) (*packp.Adv
 
 	s.caps = ar.Capabilities
 
-	if err := setReferences(s.storer, ar); err != nil {
+	if err := setReferences(s.refs, ar); err != nil {
 		return nil, err
 	}
 
-	if err := setHEAD(s.storer, ar); err != nil {
+	if err := setHEAD(s.refs, ar); err != nil {
 		return nil, err
 	}
 
@@ -233,9 +391,46 @@ func (s *rpSession) AdvertisedReferencesContext(ctx context.Context) (*packp.Adv
 
 var (
 	ErrUpdateReference = errors.New("failed to update ref")
+	// ErrNotOurRef is returned when an object wanted in a fetch isn't
+	// reachable from the references of the namespace of the server.
+	ErrNotOurRef = errors.New("not our ref")
+	// ErrMissingObjects is returned when the objects received in a push,
+	// along with the ones of the repository, don't hold all the objects
+	// reachable from the updated references.
+	ErrMissingObjects = errors.New("missing necessary objects")
+	// ErrInvalidObjects is returned when an object received in a push is
+	// not well formed, with Options.FsckObjects.
+	ErrInvalidObjects = errors.New("invalid object")
 )
 
 func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	if err := s.instrumented.expired(); err != nil {
+		return nil, err
+	}
+
+	err := s.instrumented.negotiated(&NegotiationInfo{
+		Capabilities: req.Capabilities,
+		Commands:     req.Commands,
+	})
+	if err != nil {
+		return nil, s.instrumented.fail(err)
+	}
+
+	if req.Packfile != nil {
+		r := *req
+		r.Packfile = s.instrumented.transfer(req.Packfile, s.maxPackSize, false)
+		req = &r
+	}
+
+	rs, err := s.receivePack(s.instrumented.withDeadline(ctx), req)
+	if err != nil {
+		err = s.instrumented.fail(err)
+	}
+
+	return rs, err
+}
+
+func (s *rpSession) receivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
 	if s.caps == nil {
 		s.caps = capability.NewList()
 		if err := s.setSupportedCapabilities(s.caps); err != nil {
@@ -251,6 +446,14 @@ func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateR
 
 	//TODO: Implement 'atomic' update of references.
 
+	if qs, ok := s.storer.(storer.QuarantineStorer); ok && req.Packfile != nil {
+		return s.receivePackInQuarantine(ctx, req, qs)
+	}
+
+	if s.hooks.PreReceive != nil || s.fsck != nil {
+		return s.receivePackWithPreReceive(ctx, req)
+	}
+
 	if req.Packfile != nil {
 		r := ioutil.NewContextReadCloser(ctx, req.Packfile)
 		if err := s.writePackfile(r); err != nil {
@@ -260,13 +463,289 @@ func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateR
 		}
 	}
 
-	s.updateReferences(req)
+	s.updateReferences(ctx, req)
+	return s.reportStatus(), s.firstErr
+}
+
+// receivePackWithPreReceive keeps the received objects in a temporary
+// quarantine directory until the pre-receive hook, and the checks of the
+// objects if enabled, accept the push, so rejected pushes leave no trace in
+// the repository.
+func (s *rpSession) receivePackWithPreReceive(ctx context.Context, req *packp.ReferenceUpdateRequest) (rs *packp.ReportStatus, err error) {
+	q, err := newQuarantine(s.storer)
+	if err != nil {
+		s.unpackErr = err
+		s.firstErr = err
+		return s.reportStatus(), err
+	}
+
+	defer func() {
+		if rerr := q.Remove(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+
+	if req.Packfile != nil {
+		r := ioutil.NewContextReadCloser(ctx, req.Packfile)
+		received := &receivedObjects{}
+		if err := s.writeQuarantine(q, r, received); err != nil {
+			_ = r.Close()
+			s.unpackErr = err
+			s.firstErr = err
+			return s.reportStatus(), err
+		}
+
+		if err := r.Close(); err != nil {
+			s.unpackErr = err
+			s.firstErr = err
+			return s.reportStatus(), err
+		}
+
+		if err := s.checkObjects(q, received.hashes); err != nil {
+			s.unpackErr = err
+			s.firstErr = err
+			return s.reportStatus(), err
+		}
+	}
+
+	if s.fsck != nil {
+		if err := s.checkConnectivity(q, req.Commands); err != nil {
+			s.unpackErr = err
+			s.firstErr = err
+			return s.reportStatus(), err
+		}
+	}
+
+	if s.hooks.PreReceive != nil {
+		if err := s.hooks.PreReceive(ctx, q, req.Commands); err != nil {
+			for _, cmd := range req.Commands {
+				s.setStatus(cmd.Name, err)
+			}
+
+			return s.reportStatus(), s.firstErr
+		}
+	}
+
+	if err := q.Migrate(); err != nil {
+		s.unpackErr = err
+		s.firstErr = err
+		return s.reportStatus(), err
+	}
+
+	s.updateReferences(ctx, req)
 	return s.reportStatus(), s.firstErr
 }
 
-func (s *rpSession) updateReferences(req *packp.ReferenceUpdateRequest) {
+// receivePackInQuarantine writes the received packfile to a quarantine of
+// the storage, with a .keep file. The packfile is migrated to the repository
+// once the objects reachable from the commands are found, and the
+// pre-receive hook, if any, accepts the push. Otherwise the quarantine is
+// removed. The .keep file is removed once the references are updated.
+func (s *rpSession) receivePackInQuarantine(ctx context.Context, req *packp.ReferenceUpdateRequest, qs storer.QuarantineStorer) (*packp.ReportStatus, error) {
+	q, err := qs.Quarantine()
+	if err != nil {
+		s.unpackErr = err
+		s.firstErr = err
+		return s.reportStatus(), err
+	}
+
+	unkeep, err := s.writeInQuarantine(ctx, q, req)
+	if err != nil {
+		_ = q.Remove()
+		s.unpackErr = err
+		s.firstErr = err
+		return s.reportStatus(), err
+	}
+
+	if s.hooks.PreReceive != nil {
+		if err := s.hooks.PreReceive(ctx, q, req.Commands); err != nil {
+			_ = q.Remove()
+			for _, cmd := range req.Commands {
+				s.setStatus(cmd.Name, err)
+			}
+
+			return s.reportStatus(), s.firstErr
+		}
+	}
+
+	if err := q.Migrate(); err != nil {
+		_ = q.Remove()
+		s.unpackErr = err
+		s.firstErr = err
+		return s.reportStatus(), err
+	}
+
+	s.updateReferences(ctx, req)
+	if err := unkeep(); err != nil && s.firstErr == nil {
+		s.firstErr = err
+	}
+
+	return s.reportStatus(), s.firstErr
+}
+
+// writeInQuarantine writes the packfile of the request to the quarantine,
+// checks the objects received are well formed if enabled, then checks the
+// objects reachable from the commands are all found.
+func (s *rpSession) writeInQuarantine(ctx context.Context, q storer.Quarantine, req *packp.ReferenceUpdateRequest) (func() error, error) {
+	r := ioutil.NewContextReadCloser(ctx, req.Packfile)
+	received := &receivedObjects{}
+	unkeep, err := packfile.UpdateObjectStorageKept(q, r, keepReason("receive-pack"), received)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkObjects(q, received.hashes); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkConnectivity(q, req.Commands); err != nil {
+		return nil, err
+	}
+
+	return unkeep, nil
+}
+
+// checkConnectivity checks the objects reachable from the new values of the
+// references are all found, up to the ones reachable from the existing
+// references, as `git receive-pack` does.
+func (s *rpSession) checkConnectivity(objects storer.EncodedObjectStorer, cmds []*packp.Command) error {
+	var news []plumbing.Hash
+	for _, cmd := range cmds {
+		if cmd.Action() != packp.Delete {
+			news = append(news, cmd.New)
+		}
+	}
+
+	if len(news) == 0 {
+		return nil
+	}
+
+	iter, err := s.storer.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	var haves []plumbing.Hash
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && s.storer.HasEncodedObject(ref.Hash()) == nil {
+			haves = append(haves, ref.Hash())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := revlist.Objects(objects, news, haves); err != nil {
+		return fmt.Errorf("%w: %s", ErrMissingObjects, err)
+	}
+
+	return nil
+}
+
+// checkObjects checks the commits, trees and tags received are well formed,
+// if enabled, as `git index-pack --strict` does.
+func (s *rpSession) checkObjects(objects storer.EncodedObjectStorer, hashes []plumbing.Hash) error {
+	if s.fsck == nil {
+		return nil
+	}
+
+	for _, h := range hashes {
+		obj, err := objects.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return err
+		}
+
+		if obj.Type() == plumbing.BlobObject {
+			continue
+		}
+
+		content, err := readObject(obj)
+		if err != nil {
+			return err
+		}
+
+		_, problems := s.fsck.Check(obj.Type(), content)
+		if p, ok := fsck.FirstError(problems); ok {
+			return fmt.Errorf("%w %s %s: %s", ErrInvalidObjects, obj.Type(), h, p)
+		}
+	}
+
+	return nil
+}
+
+func readObject(obj plumbing.EncodedObject) (content []byte, err error) {
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	return io.ReadAll(r)
+}
+
+// receivedObjects records the objects of a packfile as it is parsed.
+type receivedObjects struct {
+	hashes []plumbing.Hash
+}
+
+func (*receivedObjects) OnHeader(uint32) error { return nil }
+
+func (*receivedObjects) OnInflatedObjectHeader(plumbing.ObjectType, int64, int64) error {
+	return nil
+}
+
+func (r *receivedObjects) OnInflatedObjectContent(h plumbing.Hash, _ int64, _ uint32, _ []byte) error {
+	r.hashes = append(r.hashes, h)
+	return nil
+}
+
+func (*receivedObjects) OnFooter(plumbing.Hash) error { return nil }
+
+// keepReason returns the reason written in the .keep files of the packfiles
+// received by the given command, as git writes it.
+func keepReason(cmd string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+
+	return fmt.Sprintf("%s %d on %s", cmd, os.Getpid(), host)
+}
+
+func (s *rpSession) writeQuarantine(q *quarantine, r io.Reader, ob ...packfile.Observer) error {
+	p, err := packfile.NewParserWithStorage(packfile.NewScanner(r), q, ob...)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.Parse()
+	return err
+}
+
+func (s *rpSession) updateReferences(ctx context.Context, req *packp.ReferenceUpdateRequest) {
+	var updated []*packp.Command
+	defer func() {
+		if s.hooks.PostReceive != nil && len(updated) != 0 {
+			s.hooks.PostReceive(ctx, s.storer, updated)
+		}
+	}()
+
 	for _, cmd := range req.Commands {
-		exists, err := referenceExists(s.storer, cmd.Name)
+		if s.hooks.Update != nil {
+			if err := s.hooks.Update(ctx, s.storer, cmd); err != nil {
+				s.setStatus(cmd.Name, err)
+				continue
+			}
+		}
+
+		exists, err := referenceExists(s.refs, cmd.Name)
 		if err != nil {
 			s.setStatus(cmd.Name, err)
 			continue
@@ -280,16 +759,22 @@ func (s *rpSession) updateReferences(req *packp.ReferenceUpdateRequest) {
 			}
 
 			ref := plumbing.NewHashReference(cmd.Name, cmd.New)
-			err := s.storer.SetReference(ref)
+			err := s.refs.SetReference(ref)
 			s.setStatus(cmd.Name, err)
+			if err == nil {
+				updated = append(updated, cmd)
+			}
 		case packp.Delete:
 			if !exists {
 				s.setStatus(cmd.Name, ErrUpdateReference)
 				continue
 			}
 
-			err := s.storer.RemoveReference(cmd.Name)
+			err := s.refs.RemoveReference(cmd.Name)
 			s.setStatus(cmd.Name, err)
+			if err == nil {
+				updated = append(updated, cmd)
+			}
 		case packp.Update:
 			if !exists {
 				s.setStatus(cmd.Name, ErrUpdateReference)
@@ -297,8 +782,11 @@ func (s *rpSession) updateReferences(req *packp.ReferenceUpdateRequest) {
 			}
 
 			ref := plumbing.NewHashReference(cmd.Name, cmd.New)
-			err := s.storer.SetReference(ref)
+			err := s.refs.SetReference(ref)
 			s.setStatus(cmd.Name, err)
+			if err == nil {
+				updated = append(updated, cmd)
+			}
 		}
 	}
 }
@@ -367,10 +855,15 @@ func (*rpSession) setSupportedCapabilities(c *capability.List) error {
 		return err
 	}
 
+	// The packfiles are stored as received, their delta bases must be in them.
+	if err := c.Set(capability.NoThin); err != nil {
+		return err
+	}
+
 	return c.Set(capability.ReportStatus)
 }
 
-func setHEAD(s storer.Storer, ar *packp.AdvRefs) error {
+func setHEAD(s storer.ReferenceStorer, ar *packp.AdvRefs) error {
 	ref, err := s.Reference(plumbing.HEAD)
 	if err == plumbing.ErrReferenceNotFound {
 		return nil
@@ -405,7 +898,7 @@ func setHEAD(s storer.Storer, ar *packp.AdvRefs) error {
 	return nil
 }
 
-func setReferences(s storer.Storer, ar *packp.AdvRefs) error {
+func setReferences(s storer.ReferenceStorer, ar *packp.AdvRefs) error {
 	//TODO: add peeled references.
 	iter, err := s.IterReferences()
 	if err != nil {
@@ -1,17 +1,23 @@
 package ssh
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/go-git/go-git/v5/plumbing/transport"
 
 	"github.com/skeema/knownhosts"
 	sshagent "github.com/xanzy/ssh-agent"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 const DefaultUsername = "git"
@@ -35,6 +41,7 @@ const (
 	PasswordCallbackName    = "ssh-password-callback"
 	PublicKeysName          = "ssh-public-keys"
 	PublicKeysCallbackName  = "ssh-public-key-callback"
+	PublicKeysListName      = "ssh-public-keys-list"
 )
 
 // KeyboardInteractive implements AuthMethod by using a
@@ -141,6 +148,57 @@ func NewPublicKeysFromFile(user, pemFile, password string) (*PublicKeys, error)
 	return NewPublicKeys(user, bytes, password)
 }
 
+// NewPublicKeysWithCert returns a PublicKeys from a PEM encoded private key
+// and the OpenSSH certificate issued for it, as found in a "*-cert.pub" file.
+// The certificate is presented to the server alongside the key. An encryption
+// password should be given if the pemBytes contains a password encrypted PEM
+// block otherwise password should be empty.
+func NewPublicKeysWithCert(user string, pemBytes, certBytes []byte, password string) (*PublicKeys, error) {
+	keys, err := NewPublicKeys(user, pemBytes, password)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("invalid certificate: got a %s public key", pub.Type())
+	}
+
+	keys.Signer, err = ssh.NewCertSigner(cert, keys.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// NewPublicKeysWithCertFromFile returns a PublicKeys from a file containing a
+// PEM encoded private key and a file containing its OpenSSH certificate. If
+// certFile is empty, pemFile with the suffix "-cert.pub" is used, following
+// the OpenSSH naming convention.
+func NewPublicKeysWithCertFromFile(user, pemFile, certFile, password string) (*PublicKeys, error) {
+	if certFile == "" {
+		certFile = pemFile + "-cert.pub"
+	}
+
+	pemBytes, err := os.ReadFile(pemFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certBytes, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPublicKeysWithCert(user, pemBytes, certBytes, password)
+}
+
 func (a *PublicKeys) Name() string {
 	return PublicKeysName
 }
@@ -150,9 +208,58 @@ func (a *PublicKeys) String() string {
 }
 
 func (a *PublicKeys) ClientConfig() (*ssh.ClientConfig, error) {
+	return a.trackedClientConfig(nil)
+}
+
+func (a *PublicKeys) trackedClientConfig(t *identityTracker) (*ssh.ClientConfig, error) {
+	return a.SetHostKeyCallback(&ssh.ClientConfig{
+		User: a.User,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(t.track(a.Signer)...)},
+	})
+}
+
+// PublicKeysList implements AuthMethod by offering several key pairs to the
+// server. The keys are tried one after another in the given order until the
+// server accepts one of them.
+type PublicKeysList struct {
+	User    string
+	Signers []ssh.Signer
+	HostKeyCallbackHelper
+}
+
+// NewPublicKeysListFromFiles returns a PublicKeysList with the PEM encoded
+// private keys read from the given files, in the same order. The password is
+// used for any key that is encrypted.
+func NewPublicKeysListFromFiles(user, password string, pemFiles ...string) (*PublicKeysList, error) {
+	list := &PublicKeysList{User: user}
+	for _, f := range pemFiles {
+		keys, err := NewPublicKeysFromFile(user, f, password)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+
+		list.Signers = append(list.Signers, keys.Signer)
+	}
+
+	return list, nil
+}
+
+func (a *PublicKeysList) Name() string {
+	return PublicKeysListName
+}
+
+func (a *PublicKeysList) String() string {
+	return fmt.Sprintf("user: %s, name: %s", a.User, a.Name())
+}
+
+func (a *PublicKeysList) ClientConfig() (*ssh.ClientConfig, error) {
+	return a.trackedClientConfig(nil)
+}
+
+func (a *PublicKeysList) trackedClientConfig(t *identityTracker) (*ssh.ClientConfig, error) {
 	return a.SetHostKeyCallback(&ssh.ClientConfig{
 		User: a.User,
-		Auth: []ssh.AuthMethod{ssh.PublicKeys(a.Signer)},
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(t.track(a.Signers...)...)},
 	})
 }
 
@@ -202,6 +309,108 @@ func NewSSHAgentAuth(u string) (*PublicKeysCallback, error) {
 	}, nil
 }
 
+// AgentKeyFilter restricts the keys of an SSH agent that are offered to the
+// server. A key must match every non-empty criteria to be offered.
+type AgentKeyFilter struct {
+	// Fingerprints is the list of allowed key fingerprints, either in the
+	// SHA256 ("SHA256:...") or the legacy MD5 ("aa:bb:...") format. When
+	// set, keys are offered in the order of this list.
+	Fingerprints []string
+	// Comment, if not nil, must match the comment of the key held by the
+	// agent, usually the path of the file the key was loaded from.
+	Comment *regexp.Regexp
+}
+
+// NewSSHAgentAuthWithFilter works like NewSSHAgentAuth, but only offers the
+// keys held by the agent that match the given filter.
+func NewSSHAgentAuthWithFilter(u string, filter AgentKeyFilter) (*PublicKeysCallback, error) {
+	var err error
+	if u == "" {
+		u, err = username()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a, _, err := sshagent.New()
+	if err != nil {
+		return nil, fmt.Errorf("error creating SSH agent: %q", err)
+	}
+
+	return &PublicKeysCallback{
+		User: u,
+		Callback: func() ([]ssh.Signer, error) {
+			return filterAgentSigners(a, filter)
+		},
+	}, nil
+}
+
+func filterAgentSigners(a agent.Agent, filter AgentKeyFilter) ([]ssh.Signer, error) {
+	keys, err := a.List()
+	if err != nil {
+		return nil, err
+	}
+
+	signers, err := a.Signers()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*agent.Key
+	for _, k := range keys {
+		if filter.Comment != nil && !filter.Comment.MatchString(k.Comment) {
+			continue
+		}
+
+		matched = append(matched, k)
+	}
+
+	if len(filter.Fingerprints) != 0 {
+		var ordered []*agent.Key
+		for _, fp := range filter.Fingerprints {
+			for _, k := range matched {
+				if ssh.FingerprintSHA256(k) == fp || ssh.FingerprintLegacyMD5(k) == fp {
+					ordered = append(ordered, k)
+				}
+			}
+		}
+
+		matched = ordered
+	}
+
+	var out []ssh.Signer
+	for _, k := range matched {
+		for _, s := range signers {
+			if bytes.Equal(s.PublicKey().Marshal(), k.Marshal()) {
+				out = append(out, &commentedSigner{Signer: s, comment: k.Comment})
+				break
+			}
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no key held by the SSH agent matches the filter, %d keys available", len(keys))
+	}
+
+	return out, nil
+}
+
+// commentedSigner keeps the comment the agent holds for a key, so it can be
+// used to describe the identity in error messages.
+type commentedSigner struct {
+	ssh.Signer
+	comment string
+}
+
+func (s *commentedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	as, ok := s.Signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("ssh: signer does not support signing with algorithm %q", algorithm)
+	}
+
+	return as.SignWithAlgorithm(rand, data, algorithm)
+}
+
 func (a *PublicKeysCallback) Name() string {
 	return PublicKeysCallbackName
 }
@@ -211,9 +420,21 @@ func (a *PublicKeysCallback) String() string {
 }
 
 func (a *PublicKeysCallback) ClientConfig() (*ssh.ClientConfig, error) {
+	return a.trackedClientConfig(nil)
+}
+
+func (a *PublicKeysCallback) trackedClientConfig(t *identityTracker) (*ssh.ClientConfig, error) {
+	cb := a.Callback
+	if t != nil && cb != nil {
+		cb = func() ([]ssh.Signer, error) {
+			signers, err := a.Callback()
+			return t.track(signers...), err
+		}
+	}
+
 	return a.SetHostKeyCallback(&ssh.ClientConfig{
 		User: a.User,
-		Auth: []ssh.AuthMethod{ssh.PublicKeysCallback(a.Callback)},
+		Auth: []ssh.AuthMethod{ssh.PublicKeysCallback(cb)},
 	})
 }
 
@@ -311,3 +532,174 @@ func (m *HostKeyCallbackHelper) SetHostKeyCallback(cfg *ssh.ClientConfig) (*ssh.
 	cfg.HostKeyCallback = m.HostKeyCallback
 	return cfg, nil
 }
+
+// trackedAuthMethod is implemented by the public key based auth methods, so
+// the identities offered to the server can be reported when the
+// authentication fails.
+type trackedAuthMethod interface {
+	trackedClientConfig(t *identityTracker) (*ssh.ClientConfig, error)
+}
+
+// IdentitiesError is returned when the server rejects every identity offered
+// by a public key based auth method.
+type IdentitiesError struct {
+	// Offered are the identities presented to the server, in order.
+	Offered []string
+	// Rejected are the offered identities the server refused.
+	Rejected []string
+	Err      error
+}
+
+func (e *IdentitiesError) Error() string {
+	if len(e.Offered) == 0 {
+		return fmt.Sprintf("%s, no identities were offered", e.Err)
+	}
+
+	return fmt.Sprintf("%s, offered identities: [%s], rejected: [%s]",
+		e.Err, strings.Join(e.Offered, ", "), strings.Join(e.Rejected, ", "))
+}
+
+func (e *IdentitiesError) Unwrap() error {
+	return e.Err
+}
+
+// identityTracker records which signers are offered to the server and which
+// of them are accepted and used to sign. A nil *identityTracker tracks
+// nothing.
+type identityTracker struct {
+	m        sync.Mutex
+	offered  []string
+	accepted map[string]bool
+}
+
+func (t *identityTracker) track(signers ...ssh.Signer) []ssh.Signer {
+	if t == nil {
+		return signers
+	}
+
+	out := make([]ssh.Signer, len(signers))
+	for i, s := range signers {
+		out[i] = t.wrap(s)
+	}
+
+	return out
+}
+
+func (t *identityTracker) wrap(s ssh.Signer) ssh.Signer {
+	as, ok := s.(ssh.AlgorithmSigner)
+	if !ok {
+		// Without SignWithAlgorithm the ssh package can not use the signer,
+		// so there is nothing to track.
+		return s
+	}
+
+	ts := &trackedSigner{AlgorithmSigner: as, tracker: t, id: describeSigner(s)}
+	if ms, ok := s.(ssh.MultiAlgorithmSigner); ok {
+		return &trackedMultiSigner{trackedSigner: ts, algorithms: ms.Algorithms()}
+	}
+
+	return ts
+}
+
+func (t *identityTracker) offer(id string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	for _, o := range t.offered {
+		if o == id {
+			return
+		}
+	}
+
+	t.offered = append(t.offered, id)
+}
+
+func (t *identityTracker) accept(id string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.accepted == nil {
+		t.accepted = make(map[string]bool)
+	}
+
+	t.accepted[id] = true
+}
+
+// wrapError wraps an authentication error into an IdentitiesError, other
+// errors are returned as they are.
+func (t *identityTracker) wrapError(err error) error {
+	if t == nil || !isAuthenticationError(err) {
+		return err
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	e := &IdentitiesError{Offered: t.offered, Err: err}
+	for _, id := range t.offered {
+		if !t.accepted[id] {
+			e.Rejected = append(e.Rejected, id)
+		}
+	}
+
+	return e
+}
+
+// authenticationErrorPrefix starts the error of the ssh client when the
+// server accepted none of the auth methods.
+const authenticationErrorPrefix = "ssh: unable to authenticate"
+
+// isAuthenticationError returns whether err is the error of the ssh client
+// when the server accepted none of the auth methods. The client of
+// golang.org/x/crypto/ssh has no error type for it, only a message
+// formatted with the methods tried, so its prefix is matched.
+func isAuthenticationError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), authenticationErrorPrefix)
+}
+
+type trackedSigner struct {
+	ssh.AlgorithmSigner
+	tracker *identityTracker
+	id      string
+}
+
+func (s *trackedSigner) PublicKey() ssh.PublicKey {
+	s.tracker.offer(s.id)
+	return s.AlgorithmSigner.PublicKey()
+}
+
+func (s *trackedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.tracker.accept(s.id)
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s *trackedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.tracker.accept(s.id)
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+type trackedMultiSigner struct {
+	*trackedSigner
+	algorithms []string
+}
+
+func (s *trackedMultiSigner) Algorithms() []string {
+	return s.algorithms
+}
+
+// describeSigner returns a human readable description of the identity of the
+// signer: its key type, fingerprint and, if known, the comment of the key.
+func describeSigner(s ssh.Signer) string {
+	pub := s.PublicKey()
+	key := pub
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+
+	id := fmt.Sprintf("%s %s", pub.Type(), ssh.FingerprintSHA256(key))
+	if cs, ok := s.(*commentedSigner); ok && cs.comment != "" {
+		id = fmt.Sprintf("%s (%s)", id, cs.comment)
+	}
+
+	return id
+}
@@ -2,14 +2,19 @@ package ssh
 
 import (
 	"bufio"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"regexp"
 	"runtime"
 	"strings"
 
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-billy/v5/util"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/testdata"
 
 	. "gopkg.in/check.v1"
@@ -230,3 +235,220 @@ func (*SuiteCommon) TestNewKnownHostsCallback(c *C) {
 	err = clb(mock.String(), mock, hostKey)
 	c.Assert(err, IsNil)
 }
+
+func testSigner(c *C, name string) ssh.Signer {
+	signer, err := ssh.ParsePrivateKey(testdata.PEMBytes[name])
+	c.Assert(err, IsNil)
+	return signer
+}
+
+func testRawKey(c *C, name string) interface{} {
+	key, err := ssh.ParseRawPrivateKey(testdata.PEMBytes[name])
+	c.Assert(err, IsNil)
+	return key
+}
+
+func newTestCert(c *C, key ssh.PublicKey) []byte {
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.UserCert,
+		KeyId:           "foo",
+		ValidPrincipals: []string{"foo"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	err := cert.SignCert(rand.Reader, testSigner(c, "ecdsa"))
+	c.Assert(err, IsNil)
+
+	return ssh.MarshalAuthorizedKey(cert)
+}
+
+func (*SuiteCommon) TestNewPublicKeysWithCert(c *C) {
+	cert := newTestCert(c, testSigner(c, "rsa").PublicKey())
+
+	auth, err := NewPublicKeysWithCert("foo", testdata.PEMBytes["rsa"], cert, "")
+	c.Assert(err, IsNil)
+	c.Assert(auth.Signer.PublicKey().Type(), Equals, ssh.CertAlgoRSAv01)
+}
+
+func (*SuiteCommon) TestNewPublicKeysWithCertMismatch(c *C) {
+	cert := newTestCert(c, testSigner(c, "ed25519").PublicKey())
+
+	_, err := NewPublicKeysWithCert("foo", testdata.PEMBytes["rsa"], cert, "")
+	c.Assert(err, NotNil)
+}
+
+func (*SuiteCommon) TestNewPublicKeysWithCertNotACert(c *C) {
+	pub := ssh.MarshalAuthorizedKey(testSigner(c, "rsa").PublicKey())
+
+	_, err := NewPublicKeysWithCert("foo", testdata.PEMBytes["rsa"], pub, "")
+	c.Assert(err, ErrorMatches, "invalid certificate: .*")
+}
+
+func (*SuiteCommon) TestNewPublicKeysWithCertFromFile(c *C) {
+	dir := c.MkDir()
+	key := dir + "/id_rsa"
+	c.Assert(os.WriteFile(key, testdata.PEMBytes["rsa"], 0600), IsNil)
+	c.Assert(os.WriteFile(key+"-cert.pub", newTestCert(c, testSigner(c, "rsa").PublicKey()), 0600), IsNil)
+
+	auth, err := NewPublicKeysWithCertFromFile("foo", key, "", "")
+	c.Assert(err, IsNil)
+	c.Assert(auth.Signer.PublicKey().Type(), Equals, ssh.CertAlgoRSAv01)
+}
+
+func (*SuiteCommon) TestNewPublicKeysListFromFiles(c *C) {
+	dir := c.MkDir()
+	var files []string
+	for _, name := range []string{"ed25519", "rsa"} {
+		f := dir + "/" + name
+		c.Assert(os.WriteFile(f, testdata.PEMBytes[name], 0600), IsNil)
+		files = append(files, f)
+	}
+
+	auth, err := NewPublicKeysListFromFiles("foo", "", files...)
+	c.Assert(err, IsNil)
+	c.Assert(auth.Name(), Equals, PublicKeysListName)
+	c.Assert(auth.Signers, HasLen, 2)
+	c.Assert(auth.Signers[0].PublicKey().Type(), Equals, ssh.KeyAlgoED25519)
+	c.Assert(auth.Signers[1].PublicKey().Type(), Equals, ssh.KeyAlgoRSA)
+}
+
+func newTestKeyring(c *C, names ...string) agent.Agent {
+	keyring := agent.NewKeyring()
+	for _, name := range names {
+		err := keyring.Add(agent.AddedKey{
+			PrivateKey: testRawKey(c, name),
+			Comment:    "/keys/" + name,
+		})
+		c.Assert(err, IsNil)
+	}
+
+	return keyring
+}
+
+func (*SuiteCommon) TestFilterAgentSignersByComment(c *C) {
+	a := newTestKeyring(c, "rsa", "ed25519", "ecdsa")
+
+	signers, err := filterAgentSigners(a, AgentKeyFilter{
+		Comment: regexp.MustCompile("ed25519$"),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(signers, HasLen, 1)
+	c.Assert(signers[0].PublicKey().Type(), Equals, ssh.KeyAlgoED25519)
+	c.Assert(describeSigner(signers[0]), Matches, `ssh-ed25519 SHA256:.* \(/keys/ed25519\)`)
+}
+
+func (*SuiteCommon) TestFilterAgentSignersByFingerprint(c *C) {
+	a := newTestKeyring(c, "rsa", "ed25519", "ecdsa")
+
+	signers, err := filterAgentSigners(a, AgentKeyFilter{
+		Fingerprints: []string{
+			ssh.FingerprintLegacyMD5(testSigner(c, "ecdsa").PublicKey()),
+			ssh.FingerprintSHA256(testSigner(c, "rsa").PublicKey()),
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(signers, HasLen, 2)
+	c.Assert(signers[0].PublicKey().Type(), Equals, ssh.KeyAlgoECDSA256)
+	c.Assert(signers[1].PublicKey().Type(), Equals, ssh.KeyAlgoRSA)
+}
+
+func (*SuiteCommon) TestFilterAgentSignersNoMatch(c *C) {
+	a := newTestKeyring(c, "rsa")
+
+	_, err := filterAgentSigners(a, AgentKeyFilter{
+		Comment: regexp.MustCompile("nope"),
+	})
+	c.Assert(err, ErrorMatches, "no key held by the SSH agent matches the filter.*")
+}
+
+// handshake performs an SSH handshake over an in-memory connection against a
+// server accepting only the given public key.
+func handshake(c *C, auth AuthMethod, accepted ssh.PublicKey) (*identityTracker, error) {
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if accepted != nil && string(key.Marshal()) == string(accepted.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("rejected")
+		},
+	}
+	serverConfig.AddHostKey(testSigner(c, "ecdsa"))
+
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	go func() {
+		server, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer server.Close()
+
+		conn, _, _, err := ssh.NewServerConn(server, serverConfig)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	// The host key must be ignored before the config is created, which
+	// otherwise reads the known_hosts files.
+	switch a := auth.(type) {
+	case *PublicKeys:
+		a.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	case *PublicKeysList:
+		a.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	case *PublicKeysCallback:
+		a.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	}
+
+	tracker := &identityTracker{}
+	config, err := auth.(trackedAuthMethod).trackedClientConfig(tracker)
+	c.Assert(err, IsNil)
+
+	_, _, _, err = ssh.NewClientConn(client, "localhost:22", config)
+	return tracker, tracker.wrapError(err)
+}
+
+func (*SuiteCommon) TestPublicKeysListRejected(c *C) {
+	auth := &PublicKeysList{
+		User:    "foo",
+		Signers: []ssh.Signer{testSigner(c, "ed25519"), testSigner(c, "rsa")},
+	}
+
+	_, err := handshake(c, auth, nil)
+	c.Assert(err, NotNil)
+
+	var idErr *IdentitiesError
+	c.Assert(errors.As(err, &idErr), Equals, true)
+	c.Assert(idErr.Offered, HasLen, 2)
+	c.Assert(idErr.Offered[0], Matches, "ssh-ed25519 SHA256:.*")
+	c.Assert(idErr.Offered[1], Matches, "ssh-rsa SHA256:.*")
+	c.Assert(idErr.Rejected, DeepEquals, idErr.Offered)
+	c.Assert(err, ErrorMatches, ".*unable to authenticate.*offered identities: .*")
+}
+
+func (*SuiteCommon) TestPublicKeysListAccepted(c *C) {
+	auth := &PublicKeysList{
+		User:    "foo",
+		Signers: []ssh.Signer{testSigner(c, "ed25519"), testSigner(c, "rsa")},
+	}
+
+	tracker, err := handshake(c, auth, testSigner(c, "rsa").PublicKey())
+	c.Assert(err, IsNil)
+	c.Assert(tracker.offered, HasLen, 2)
+	c.Assert(tracker.accepted[tracker.offered[1]], Equals, true)
+	c.Assert(tracker.accepted[tracker.offered[0]], Equals, false)
+}
+
+func (*SuiteCommon) TestPublicKeysCertAccepted(c *C) {
+	auth, err := NewPublicKeysWithCert("foo", testdata.PEMBytes["rsa"], newTestCert(c, testSigner(c, "rsa").PublicKey()), "")
+	c.Assert(err, IsNil)
+
+	_, err = handshake(c, auth, auth.Signer.PublicKey())
+	c.Assert(err, IsNil)
+}
@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/internal/common"
@@ -31,7 +32,28 @@ type sshConfig interface {
 
 // NewClient creates a new SSH client with an optional *ssh.ClientConfig.
 func NewClient(config *ssh.ClientConfig) transport.Transport {
-	return common.NewClient(&runner{config: config})
+	return NewClientWithOptions(config, nil)
+}
+
+// ClientOptions configures the SSH client.
+type ClientOptions struct {
+	// Timeouts are the time limits of the connections, the ones of the
+	// endpoints override them. The dial timeout overrides the Timeout of the
+	// *ssh.ClientConfig.
+	Timeouts transport.Timeouts
+}
+
+// NewClientWithOptions creates a new SSH client with an optional
+// *ssh.ClientConfig and options.
+func NewClientWithOptions(config *ssh.ClientConfig, o *ClientOptions) transport.Transport {
+	r := &runner{config: config}
+	if o != nil {
+		r.timeouts = o.Timeouts
+	}
+
+	return common.NewClientWithOptions(r, &common.ClientOptions{
+		Timeouts: r.timeouts,
+	})
 }
 
 // DefaultAuthBuilder is the function used to create a default AuthMethod, when
@@ -43,15 +65,23 @@ var DefaultAuthBuilder = func(user string) (AuthMethod, error) {
 const DefaultPort = 22
 
 type runner struct {
-	config *ssh.ClientConfig
+	config   *ssh.ClientConfig
+	timeouts transport.Timeouts
 }
 
 func (r *runner) Command(cmd string, ep *transport.Endpoint, auth transport.AuthMethod) (common.Command, error) {
-	c := &command{command: cmd, endpoint: ep, config: r.config}
+	c := &command{
+		command:     cmd,
+		endpoint:    ep,
+		config:      r.config,
+		dialTimeout: r.timeouts.Override(ep.Timeouts).Dial,
+	}
 	if auth != nil {
 		if err := c.setAuth(auth); err != nil {
 			return nil, err
 		}
+
+		c.authSupplied = true
 	}
 
 	if err := c.connect(); err != nil {
@@ -68,6 +98,11 @@ type command struct {
 	client    *ssh.Client
 	auth      AuthMethod
 	config    *ssh.ClientConfig
+	// authSupplied is whether auth was given, instead of built from the
+	// endpoint.
+	authSupplied bool
+	// dialTimeout, if not zero, overrides the Timeout of the config.
+	dialTimeout time.Duration
 }
 
 func (c *command) setAuth(auth transport.AuthMethod) error {
@@ -120,8 +155,17 @@ func (c *command) connect() error {
 		}
 	}
 
-	var err error
-	config, err := c.auth.ClientConfig()
+	var (
+		config  *ssh.ClientConfig
+		err     error
+		tracker *identityTracker
+	)
+	if ta, ok := c.auth.(trackedAuthMethod); ok {
+		tracker = &identityTracker{}
+		config, err = ta.trackedClientConfig(tracker)
+	} else {
+		config, err = c.auth.ClientConfig()
+	}
 	if err != nil {
 		return err
 	}
@@ -141,10 +185,22 @@ func (c *command) connect() error {
 	}
 
 	overrideConfig(c.config, config)
+	if c.dialTimeout > 0 {
+		config.Timeout = c.dialTimeout
+	}
 
 	c.client, err = dial("tcp", hostWithPort, c.endpoint.Proxy, config)
 	if err != nil {
-		return err
+		if !isAuthenticationError(err) {
+			return err
+		}
+
+		return &transport.AuthenticationError{
+			Endpoint:            c.endpoint,
+			Scheme:              c.auth.Name(),
+			CredentialsSupplied: c.authSupplied,
+			Err:                 tracker.wrapError(err),
+		}
 	}
 
 	c.Session, err = c.client.NewSession()
@@ -208,7 +264,7 @@ func (c *command) getHostWithPort() string {
 		return addr
 	}
 
-	host := c.endpoint.Host
+	host := c.endpoint.Hostname()
 	port := c.endpoint.Port
 	if port <= 0 {
 		port = DefaultPort
@@ -225,7 +281,7 @@ func (c *command) doGetHostWithPortFromSSHConfig() (addr string, found bool) {
 	host := c.endpoint.Host
 	port := c.endpoint.Port
 
-	configHost := DefaultSSHConfig.Get(c.endpoint.Host, "Hostname")
+	configHost := DefaultSSHConfig.Get(c.endpoint.Hostname(), "Hostname")
 	if configHost != "" {
 		host = configHost
 		found = true
@@ -235,7 +291,7 @@ func (c *command) doGetHostWithPortFromSSHConfig() (addr string, found bool) {
 		return
 	}
 
-	configPort := DefaultSSHConfig.Get(c.endpoint.Host, "Port")
+	configPort := DefaultSSHConfig.Get(c.endpoint.Hostname(), "Port")
 	if configPort != "" {
 		if i, err := strconv.Atoi(configPort); err == nil {
 			port = i
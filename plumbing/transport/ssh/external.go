@@ -0,0 +1,365 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/internal/common"
+	"golang.org/x/sys/execabs"
+)
+
+// The ssh variants understood by the external client, they follow the values
+// accepted by the ssh.variant git config option.
+const (
+	VariantAuto          = "auto"
+	VariantSSH           = "ssh"
+	VariantPlink         = "plink"
+	VariantPutty         = "putty"
+	VariantTortoisePlink = "tortoiseplink"
+	VariantSimple        = "simple"
+)
+
+// maxExternalStderr is the amount of stderr output of the external command
+// kept to be reported in errors.
+const maxExternalStderr = 4096
+
+// externalWaitDelay is how long the output of the external command is waited
+// for once it exited.
+const externalWaitDelay = time.Second
+
+// gitFatalExitCode is the exit status of git commands dying on a fatal error.
+const gitFatalExitCode = 128
+
+// ExternalOptions configures a transport that uses an external ssh program.
+type ExternalOptions struct {
+	// Command is the command line used to connect to the remote host, as in
+	// the core.sshCommand git config option. The GIT_SSH_COMMAND environment
+	// variable takes precedence over it. If both are empty, the program in
+	// GIT_SSH is used, and `ssh` as last resort.
+	Command string
+	// Variant is the flavour of the ssh program, as in the ssh.variant git
+	// config option, it defines how the port is passed to the program. The
+	// GIT_SSH_VARIANT environment variable takes precedence over it. If empty
+	// or VariantAuto, it is guessed from the name of the program.
+	Variant string
+}
+
+// NewExternalClient returns a transport that runs an external ssh program,
+// such as the OpenSSH or PuTTY clients, and speaks the pack protocol over its
+// standard input and output, as the git command line does. The ssh program
+// is in charge of the authentication, so no AuthMethod should be given.
+func NewExternalClient(o ExternalOptions) transport.Transport {
+	return common.NewClient(&externalRunner{opts: o})
+}
+
+type externalRunner struct {
+	opts ExternalOptions
+}
+
+func (r *externalRunner) Command(cmd string, ep *transport.Endpoint, auth transport.AuthMethod) (common.Command, error) {
+	if auth != nil {
+		return nil, transport.ErrInvalidAuthMethod
+	}
+
+	command, isShell := r.command()
+	variant := r.variant(command, isShell)
+
+	args, err := externalArgs(variant, ep)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, endpointToCommand(cmd, ep))
+	c := newExternalCmd(command, isShell, args)
+	// When killed, the children of the command, e.g. those started by the
+	// shell, can keep the output pipes open, so do not wait for them forever.
+	c.WaitDelay = externalWaitDelay
+
+	return &externalCommand{cmd: c}, nil
+}
+
+// command returns the ssh command to run, and whether it is a command line to
+// be interpreted by the shell or the path of a program.
+func (r *externalRunner) command() (string, bool) {
+	if cmd := os.Getenv("GIT_SSH_COMMAND"); cmd != "" {
+		return cmd, true
+	}
+
+	if r.opts.Command != "" {
+		return r.opts.Command, true
+	}
+
+	if cmd := os.Getenv("GIT_SSH"); cmd != "" {
+		return cmd, false
+	}
+
+	return "ssh", false
+}
+
+func (r *externalRunner) variant(command string, isShell bool) string {
+	variant := os.Getenv("GIT_SSH_VARIANT")
+	if variant == "" {
+		variant = r.opts.Variant
+	}
+
+	if variant != "" && variant != VariantAuto {
+		return strings.ToLower(variant)
+	}
+
+	program := command
+	if isShell {
+		if fields := strings.Fields(command); len(fields) > 0 {
+			program = fields[0]
+		}
+	}
+
+	return detectVariant(program)
+}
+
+// detectVariant guesses the ssh variant from the name of the program.
+// Unknown programs are expected to understand the OpenSSH options.
+func detectVariant(program string) string {
+	name := strings.ToLower(program)
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.TrimSuffix(name, ".exe")
+
+	switch name {
+	case "plink":
+		return VariantPlink
+	case "tortoiseplink":
+		return VariantTortoisePlink
+	case "putty":
+		return VariantPutty
+	default:
+		return VariantSSH
+	}
+}
+
+// externalArgs returns the arguments given to the ssh program to reach the
+// host of the endpoint.
+func externalArgs(variant string, ep *transport.Endpoint) ([]string, error) {
+	var args []string
+	if variant == VariantTortoisePlink {
+		args = append(args, "-batch")
+	}
+
+	// The endpoint does not tell an explicit default port from a missing
+	// one, so the default port is left to the ssh program configuration.
+	if ep.Port > 0 && ep.Port != DefaultPort {
+		port := strconv.Itoa(ep.Port)
+		switch variant {
+		case VariantSSH:
+			args = append(args, "-p", port)
+		case VariantPlink, VariantPutty, VariantTortoisePlink:
+			args = append(args, "-P", port)
+		case VariantSimple:
+			return nil, fmt.Errorf("ssh variant %q does not support setting port", variant)
+		default:
+			return nil, fmt.Errorf("unknown ssh variant %q", variant)
+		}
+	}
+
+	host := ep.Hostname()
+	if ep.User != "" {
+		host = ep.User + "@" + host
+	}
+
+	return append(args, host), nil
+}
+
+func newExternalCmd(command string, isShell bool, args []string) *execabs.Cmd {
+	if !isShell {
+		return execabs.Command(command, args...)
+	}
+
+	if runtime.GOOS == "windows" {
+		fields := strings.Fields(command)
+		return execabs.Command(fields[0], append(fields[1:], args...)...)
+	}
+
+	// Same as the git command line, the command is run by the shell with the
+	// arguments appended to it.
+	shellArgs := append([]string{"-c", command + ` "$@"`, command}, args...)
+	return execabs.Command("sh", shellArgs...)
+}
+
+// ExternalCommandError is returned when the external ssh program exits with
+// a non-zero status before the remote command produced any output, which
+// usually means the connection or the authentication failed.
+type ExternalCommandError struct {
+	// ExitCode is the exit status of the ssh program, 255 usually means that
+	// the connection could not be established.
+	ExitCode int
+	// Stderr holds the beginning of the error output of the program.
+	Stderr string
+	Err    error
+}
+
+func (e *ExternalCommandError) Error() string {
+	msg := fmt.Sprintf("ssh command exited with status %d", e.ExitCode)
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg = fmt.Sprintf("%s: %s", msg, stderr)
+	}
+
+	return msg
+}
+
+func (e *ExternalCommandError) Unwrap() error {
+	return e.Err
+}
+
+type externalCommand struct {
+	cmd          *execabs.Cmd
+	stderr       limitedBuffer
+	stderrCloser io.Closer
+	closed       bool
+	// reported is set once the exit status was returned through stdout, or
+	// left to the transport to report from stderr.
+	reported bool
+	killed   bool
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+func (c *externalCommand) Start() error {
+	return c.cmd.Start()
+}
+
+func (c *externalCommand) StderrPipe() (io.Reader, error) {
+	// Pipe returned by Command.StderrPipe has a race with Read + Command.Wait.
+	// We use an io.Pipe and close it after the command finishes.
+	r, w := io.Pipe()
+	c.cmd.Stderr = io.MultiWriter(w, &c.stderr)
+	c.stderrCloser = w
+	return r, nil
+}
+
+func (c *externalCommand) StdinPipe() (io.WriteCloser, error) {
+	return c.cmd.StdinPipe()
+}
+
+func (c *externalCommand) StdoutPipe() (io.Reader, error) {
+	r, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	return &externalStdout{Reader: r, c: c}, nil
+}
+
+func (c *externalCommand) Kill() error {
+	if c.cmd.Process != nil {
+		_ = c.cmd.Process.Kill()
+	}
+
+	c.killed = true
+
+	return c.Close()
+}
+
+// Close waits for the command to exit. A non-zero exit status is returned as
+// an *ExternalCommandError, unless it was already reported to the session or
+// the command was killed.
+func (c *externalCommand) Close() error {
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	err := c.wait()
+	if _, ok := err.(*ExternalCommandError); ok && (c.reported || c.killed) {
+		return nil
+	}
+
+	return err
+}
+
+func (c *externalCommand) wait() error {
+	c.waitOnce.Do(func() {
+		err := c.cmd.Wait()
+		if c.stderrCloser != nil {
+			_ = c.stderrCloser.Close()
+		}
+
+		if e, ok := err.(*execabs.ExitError); ok {
+			err = &ExternalCommandError{
+				ExitCode: e.ExitCode(),
+				Stderr:   c.stderr.String(),
+				Err:      err,
+			}
+		}
+
+		c.waitErr = err
+	})
+
+	return c.waitErr
+}
+
+// externalStdout reports the exit status of the ssh program when its output
+// ends without any content, so the transport error carries it.
+type externalStdout struct {
+	io.Reader
+	c    *externalCommand
+	read bool
+}
+
+func (r *externalStdout) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.read = true
+	}
+
+	if err == io.EOF && !r.read {
+		// git exits with 128 on fatal errors, such as a non existing
+		// repository, those are reported on stderr and handled by the
+		// transport as for any other command.
+		werr := r.c.wait()
+		if e, ok := werr.(*ExternalCommandError); ok {
+			r.c.reported = true
+			if e.ExitCode != gitFatalExitCode {
+				return n, werr
+			}
+		}
+	}
+
+	return n, err
+}
+
+type limitedBuffer struct {
+	m   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if left := maxExternalStderr - b.buf.Len(); left > 0 {
+		if len(p) > left {
+			b.buf.Write(p[:left])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.buf.String()
+}
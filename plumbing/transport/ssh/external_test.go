@@ -0,0 +1,176 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/test"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+// fakeSSH logs its arguments and runs the remote command locally, as an ssh
+// client connecting to localhost would do.
+const fakeSSH = `#!/bin/sh
+echo "$@" >> "$0.log"
+for last; do true; done
+exec sh -c "$last"
+`
+
+type ExternalSuite struct {
+	fixtures.Suite
+	test.UploadPackSuite
+
+	dir string
+	ssh string
+}
+
+var _ = Suite(&ExternalSuite{})
+
+func (s *ExternalSuite) SetUpSuite(c *C) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "js" {
+		c.Skip("requires a posix shell")
+	}
+
+	if err := exec.Command("git", "--version").Run(); err != nil {
+		c.Skip("git command not found")
+	}
+
+	s.dir = c.MkDir()
+	s.ssh = filepath.Join(s.dir, "fake-ssh")
+	c.Assert(os.WriteFile(s.ssh, []byte(fakeSSH), 0755), IsNil)
+
+	s.UploadPackSuite.Client = NewExternalClient(ExternalOptions{Command: s.ssh})
+	s.UploadPackSuite.Endpoint = s.newEndpoint(c, fixtures.Basic().One().DotGit().Root())
+	s.UploadPackSuite.EmptyEndpoint = s.newEndpoint(c, fixtures.ByTag("empty").One().DotGit().Root())
+	s.UploadPackSuite.NonExistentEndpoint = s.newEndpoint(c, "/non-existent")
+}
+
+func (s *ExternalSuite) SetUpTest(c *C) {
+	os.Unsetenv("GIT_SSH_COMMAND")
+	os.Unsetenv("GIT_SSH_VARIANT")
+}
+
+func (s *ExternalSuite) newEndpoint(c *C, path string) *transport.Endpoint {
+	ep, err := transport.NewEndpoint(fmt.Sprintf("ssh://git@localhost:2222%s", filepath.ToSlash(path)))
+	c.Assert(err, IsNil)
+	return ep
+}
+
+func (s *ExternalSuite) TestArguments(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, nil)
+	c.Assert(err, IsNil)
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+
+	log, err := os.ReadFile(s.ssh + ".log")
+	c.Assert(err, IsNil)
+	c.Assert(string(log), Matches, "(?s).*-p 2222 git@localhost git-upload-pack '.*'\n")
+}
+
+func (s *ExternalSuite) TestEnvCommandPrecedence(c *C) {
+	os.Setenv("GIT_SSH_COMMAND", s.ssh+" -o env=1")
+	defer os.Unsetenv("GIT_SSH_COMMAND")
+
+	client := NewExternalClient(ExternalOptions{Command: "/non-existent"})
+	r, err := client.NewUploadPackSession(s.Endpoint, nil)
+	c.Assert(err, IsNil)
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+
+	log, err := os.ReadFile(s.ssh + ".log")
+	c.Assert(err, IsNil)
+	c.Assert(string(log), Matches, "(?s).*-o env=1 -p 2222 git@localhost .*")
+}
+
+func (s *ExternalSuite) TestInvalidAuthMethod(c *C) {
+	_, err := s.Client.NewUploadPackSession(s.Endpoint, &Password{User: "foo"})
+	c.Assert(err, Equals, transport.ErrInvalidAuthMethod)
+}
+
+func (s *ExternalSuite) TestExitStatus(c *C) {
+	cmd := filepath.Join(s.dir, "failing-ssh")
+	script := "#!/bin/sh\necho 'ssh: connect to host localhost port 2222: Connection refused' >&2\nexit 255\n"
+	c.Assert(os.WriteFile(cmd, []byte(script), 0755), IsNil)
+
+	client := NewExternalClient(ExternalOptions{Command: cmd})
+	r, err := client.NewUploadPackSession(s.Endpoint, nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.AdvertisedReferences()
+	var exitErr *ExternalCommandError
+	c.Assert(errors.As(err, &exitErr), Equals, true)
+	c.Assert(exitErr.ExitCode, Equals, 255)
+	c.Assert(exitErr.Stderr, Matches, "(?s).*Connection refused.*")
+	c.Assert(r.Close(), IsNil)
+}
+
+func (s *ExternalSuite) TestCloseExitStatus(c *C) {
+	cmd := filepath.Join(s.dir, "failing-ssh")
+	script := "#!/bin/sh\necho 'ssh: Connection closed by remote host' >&2\nexit 255\n"
+	c.Assert(os.WriteFile(cmd, []byte(script), 0755), IsNil)
+
+	r := &externalRunner{opts: ExternalOptions{Command: cmd}}
+	command, err := r.Command(transport.UploadPackServiceName, s.Endpoint, nil)
+	c.Assert(err, IsNil)
+	stderr, err := command.StderrPipe()
+	c.Assert(err, IsNil)
+	go io.Copy(io.Discard, stderr)
+	c.Assert(command.Start(), IsNil)
+
+	err = command.Close()
+	var exitErr *ExternalCommandError
+	c.Assert(errors.As(err, &exitErr), Equals, true)
+	c.Assert(exitErr.ExitCode, Equals, 255)
+	c.Assert(exitErr.Stderr, Matches, "(?s).*Connection closed.*")
+}
+
+func (s *ExternalSuite) TestExternalArgs(c *C) {
+	ep, err := transport.NewEndpoint("ssh://git@example.com:2222/foo.git")
+	c.Assert(err, IsNil)
+
+	for variant, expected := range map[string]string{
+		VariantSSH:           "-p 2222 git@example.com",
+		VariantPlink:         "-P 2222 git@example.com",
+		VariantPutty:         "-P 2222 git@example.com",
+		VariantTortoisePlink: "-batch -P 2222 git@example.com",
+	} {
+		args, err := externalArgs(variant, ep)
+		c.Assert(err, IsNil)
+		c.Assert(strings.Join(args, " "), Equals, expected, Commentf("variant %s", variant))
+	}
+
+	_, err = externalArgs(VariantSimple, ep)
+	c.Assert(err, ErrorMatches, ".*does not support setting port")
+
+	ep, err = transport.NewEndpoint("example.com:foo.git")
+	c.Assert(err, IsNil)
+	args, err := externalArgs(VariantSimple, ep)
+	c.Assert(err, IsNil)
+	c.Assert(args, DeepEquals, []string{"example.com"})
+}
+
+func (s *ExternalSuite) TestDetectVariant(c *C) {
+	r := &externalRunner{}
+	c.Assert(r.variant("ssh", false), Equals, VariantSSH)
+	c.Assert(r.variant("/usr/bin/ssh -v", true), Equals, VariantSSH)
+	c.Assert(r.variant(`C:\Program Files\PuTTY\plink.exe`, false), Equals, VariantPlink)
+	c.Assert(r.variant("TortoisePlink.exe -v", true), Equals, VariantTortoisePlink)
+
+	r.opts.Variant = VariantPutty
+	c.Assert(r.variant("ssh", false), Equals, VariantPutty)
+
+	os.Setenv("GIT_SSH_VARIANT", VariantSimple)
+	defer os.Unsetenv("GIT_SSH_VARIANT")
+	c.Assert(r.variant("ssh", false), Equals, VariantSimple)
+}
@@ -5,9 +5,11 @@ package test
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"time"
 
+	. "github.com/go-git/go-git/v5/internal/test"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
@@ -42,7 +44,7 @@ func (s *UploadPackSuite) TestAdvertisedReferencesNotExists(c *C) {
 	defer func() { c.Assert(r.Close(), IsNil) }()
 
 	ar, err := r.AdvertisedReferences()
-	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+	c.Assert(err, ErrorIs, transport.ErrRepositoryNotFound)
 	c.Assert(ar, IsNil)
 
 	r, err = s.Client.NewUploadPackSession(s.NonExistentEndpoint, s.EmptyAuth)
@@ -50,7 +52,7 @@ func (s *UploadPackSuite) TestAdvertisedReferencesNotExists(c *C) {
 	req := packp.NewUploadPackRequest()
 	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
 	reader, err := r.UploadPack(context.Background(), req)
-	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+	c.Assert(err, ErrorIs, transport.ErrRepositoryNotFound)
 	c.Assert(reader, IsNil)
 }
 
@@ -237,6 +239,72 @@ func (s *UploadPackSuite) TestUploadPackPartial(c *C) {
 	s.checkObjectNumber(c, reader, 4)
 }
 
+// testNegotiator provides the given haves, recording the negotiation.
+type testNegotiator struct {
+	haves   []plumbing.Hash
+	batches []int
+	acks    []plumbing.Hash
+}
+
+func (n *testNegotiator) Next(max int) ([]plumbing.Hash, error) {
+	if max > len(n.haves) {
+		max = len(n.haves)
+	}
+
+	haves := n.haves[:max]
+	n.haves = n.haves[max:]
+	n.batches = append(n.batches, len(haves))
+	return haves, nil
+}
+
+func (n *testNegotiator) Ack(h plumbing.Hash) error {
+	n.acks = append(n.acks, h)
+	return nil
+}
+
+func (s *UploadPackSuite) TestNegotiateUploadPack(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ns, ok := r.(transport.NegotiatorSession)
+	if !ok {
+		c.Skip("negotiation not supported")
+	}
+
+	info, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	if !info.Capabilities.Supports(capability.MultiACKDetailed) {
+		c.Skip("multi_ack_detailed not supported")
+	}
+
+	// Many commits unknown to the server, followed by the parent of the
+	// wanted one.
+	n := &testNegotiator{}
+	for i := 0; i < 100; i++ {
+		n.haves = append(n.haves, plumbing.ComputeHash(plumbing.CommitObject, []byte(fmt.Sprint(i))))
+	}
+
+	parent := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+	grandparent := plumbing.NewHash("af2d6a6954d532f8ffb47615169c8fdf9d383a1a")
+	n.haves = append(n.haves, parent, grandparent)
+	n.haves = append(n.haves, n.haves[:40]...)
+
+	req := packp.NewUploadPackRequestFromCapabilities(info.Capabilities)
+	req.Capabilities.Delete(capability.Sideband64k)
+	req.Capabilities.Delete(capability.Sideband)
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	reader, err := ns.NegotiateUploadPack(context.Background(), req, n)
+	c.Assert(err, IsNil)
+
+	// The negotiation stops once the server is ready, so only the new
+	// objects are sent.
+	c.Assert(n.batches, DeepEquals, []int{32, 32, 32, 32})
+	c.Assert(n.acks, DeepEquals, []plumbing.Hash{parent, grandparent})
+	s.checkObjectNumber(c, reader, 4)
+}
+
 func (s *UploadPackSuite) TestFetchError(c *C) {
 	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
 	c.Assert(err, IsNil)
@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTimeout is matched by the errors returned when a time limit of the
+// connection is exceeded, see TimeoutError.
+var ErrTimeout = errors.New("timeout")
+
+// Timeouts are the time limits of a connection to a server. Zero means no
+// limit.
+type Timeouts struct {
+	// Dial is the maximum time to establish the connection.
+	Dial time.Duration
+	// Idle is the maximum time waiting for data from the server. It is reset
+	// by any data received, including the progress messages.
+	Idle time.Duration
+	// Operation is the maximum duration of the whole operation, once
+	// connected.
+	Operation time.Duration
+}
+
+// Override returns t with the non zero values of o.
+func (t Timeouts) Override(o Timeouts) Timeouts {
+	if o.Dial != 0 {
+		t.Dial = o.Dial
+	}
+
+	if o.Idle != 0 {
+		t.Idle = o.Idle
+	}
+
+	if o.Operation != 0 {
+		t.Operation = o.Operation
+	}
+
+	return t
+}
+
+// Phase is a phase of the operations of a session.
+type Phase string
+
+const (
+	// PhaseAdvertisement is the reception of the advertised references.
+	PhaseAdvertisement Phase = "reference advertisement"
+	// PhaseNegotiation is the negotiation of the objects to fetch.
+	PhaseNegotiation Phase = "negotiation"
+	// PhasePackTransfer is the transfer of the packfile, and on a push, the
+	// reception of the status report.
+	PhasePackTransfer Phase = "pack transfer"
+)
+
+// TimeoutError is returned when a time limit of the connection is exceeded.
+type TimeoutError struct {
+	// Phase is the phase of the session when the time limit was exceeded.
+	Phase Phase
+	// Timeout is the time limit exceeded.
+	Timeout time.Duration
+	// Idle is whether the limit exceeded is the idle one, otherwise it is the
+	// limit of the whole operation.
+	Idle bool
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Idle {
+		return fmt.Sprintf("timeout during %s: no data received in %s", e.Phase, e.Timeout)
+	}
+
+	return fmt.Sprintf("timeout during %s: operation exceeded %s", e.Phase, e.Timeout)
+}
+
+// Is returns whether target is ErrTimeout.
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
@@ -0,0 +1,99 @@
+package git
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// ProgressPhase is a phase of an operation reported to a ProgressHandler,
+// named as git names it in its progress output.
+type ProgressPhase string
+
+const (
+	// ProgressReceivingObjects is the phase reading the objects of the
+	// packfile received by a clone or a fetch.
+	ProgressReceivingObjects ProgressPhase = "Receiving objects"
+	// ProgressResolvingDeltas is the phase resolving the deltas of the
+	// packfile received by a clone or a fetch.
+	ProgressResolvingDeltas ProgressPhase = "Resolving deltas"
+	// ProgressWritingObjects is the phase writing the packfile sent by a
+	// push.
+	ProgressWritingObjects ProgressPhase = "Writing objects"
+	// ProgressUpdatingFiles is the phase writing the files of the worktree
+	// in a checkout.
+	ProgressUpdatingFiles ProgressPhase = "Updating files"
+)
+
+// ProgressEvent is the progress of a phase of an operation.
+type ProgressEvent struct {
+	// Phase is the phase of the operation.
+	Phase ProgressPhase
+	// Done is the number of objects, deltas or files done in the phase, out
+	// of Total.
+	Done, Total int
+	// Bytes is the number of bytes of the packfile received or sent so far,
+	// in the ProgressReceivingObjects and ProgressWritingObjects phases.
+	Bytes int64
+	// Path is the path of the file written in the ProgressUpdatingFiles
+	// phase.
+	Path string
+}
+
+// ProgressHandler is called as an operation progresses, with the progress of
+// its current phase. It is called from the goroutine running the phase,
+// which may not be the one of the operation.
+type ProgressHandler func(ProgressEvent)
+
+// packProgress is a packfile.Observer reporting the progress of the parsing
+// of a packfile received.
+type packProgress struct {
+	handler ProgressHandler
+	total   int
+	bytes   atomic.Int64
+}
+
+// reader returns a reader counting the bytes of the packfile received.
+func (p *packProgress) reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, n: &p.bytes}
+}
+
+func (p *packProgress) OnHeader(count uint32) error {
+	p.total = int(count)
+	p.handler(ProgressEvent{Phase: ProgressReceivingObjects, Total: p.total, Bytes: p.bytes.Load()})
+	return nil
+}
+
+func (p *packProgress) OnObjectRead(count uint32) error {
+	p.handler(ProgressEvent{Phase: ProgressReceivingObjects, Done: int(count), Total: p.total, Bytes: p.bytes.Load()})
+	return nil
+}
+
+func (p *packProgress) OnDeltaResolved(count, total uint32) error {
+	p.handler(ProgressEvent{Phase: ProgressResolvingDeltas, Done: int(count), Total: int(total)})
+	return nil
+}
+
+func (p *packProgress) OnInflatedObjectHeader(plumbing.ObjectType, int64, int64) error {
+	return nil
+}
+
+func (p *packProgress) OnInflatedObjectContent(plumbing.Hash, int64, uint32, []byte) error {
+	return nil
+}
+
+func (p *packProgress) OnFooter(plumbing.Hash) error {
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,126 @@
+package git
+
+import (
+	"sync"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	. "gopkg.in/check.v1"
+)
+
+type ProgressSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ProgressSuite{})
+
+type progressRecorder struct {
+	sync.Mutex
+	events map[ProgressPhase][]ProgressEvent
+}
+
+func newProgressRecorder() *progressRecorder {
+	return &progressRecorder{events: make(map[ProgressPhase][]ProgressEvent)}
+}
+
+func (r *progressRecorder) handle(e ProgressEvent) {
+	r.Lock()
+	defer r.Unlock()
+	r.events[e.Phase] = append(r.events[e.Phase], e)
+}
+
+func (r *progressRecorder) last(phase ProgressPhase) ProgressEvent {
+	events := r.events[phase]
+	return events[len(events)-1]
+}
+
+func (s *ProgressSuite) TestPlainClone(c *C) {
+	rec := newProgressRecorder()
+	_, err := PlainClone(c.MkDir(), false, &CloneOptions{
+		URL:             s.GetBasicLocalRepositoryURL(),
+		ProgressHandler: rec.handle,
+	})
+	c.Assert(err, IsNil)
+
+	received := rec.last(ProgressReceivingObjects)
+	c.Assert(received.Total, Equals, 31)
+	c.Assert(received.Done, Equals, received.Total)
+	c.Assert(received.Bytes > 0, Equals, true)
+
+	if _, ok := rec.events[ProgressResolvingDeltas]; ok {
+		resolved := rec.last(ProgressResolvingDeltas)
+		c.Assert(resolved.Done, Equals, resolved.Total)
+	}
+
+	updated := rec.events[ProgressUpdatingFiles]
+	c.Assert(updated, HasLen, 9)
+	for i, e := range updated {
+		c.Assert(e.Done, Equals, i+1)
+		c.Assert(e.Total, Equals, 9)
+		c.Assert(e.Path, Not(Equals), "")
+	}
+}
+
+func (s *ProgressSuite) TestCloneInMemory(c *C) {
+	rec := newProgressRecorder()
+	_, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{
+		URL:             s.GetBasicLocalRepositoryURL(),
+		ProgressHandler: rec.handle,
+	})
+	c.Assert(err, IsNil)
+
+	received := rec.last(ProgressReceivingObjects)
+	c.Assert(received.Total, Equals, 31)
+	c.Assert(received.Done, Equals, received.Total)
+	c.Assert(rec.events[ProgressUpdatingFiles], HasLen, 9)
+}
+
+func (s *ProgressSuite) TestPush(c *C) {
+	url := c.MkDir()
+	_, err := PlainInit(url, true)
+	c.Assert(err, IsNil)
+
+	r := NewRemote(s.Repository.Storer, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{url},
+	})
+
+	rec := newProgressRecorder()
+	err = r.Push(&PushOptions{
+		RefSpecs:        []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		ProgressHandler: rec.handle,
+	})
+	c.Assert(err, IsNil)
+
+	written := rec.last(ProgressWritingObjects)
+	c.Assert(written.Total, Equals, 28)
+	c.Assert(written.Done, Equals, written.Total)
+	c.Assert(written.Bytes > 0, Equals, true)
+}
+
+func (s *ProgressSuite) TestCheckout(c *C) {
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	rec := newProgressRecorder()
+	err = w.Checkout(&CheckoutOptions{
+		Hash:            plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+		ProgressHandler: rec.handle,
+	})
+	c.Assert(err, IsNil)
+
+	updated := rec.events[ProgressUpdatingFiles]
+	c.Assert(len(updated) > 0, Equals, true)
+	for i, e := range updated {
+		c.Assert(e.Done, Equals, i+1)
+		c.Assert(e.Total, Equals, len(updated))
+	}
+}
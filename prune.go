@@ -1,10 +1,14 @@
 package git
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
@@ -13,8 +17,33 @@ type PruneOptions struct {
 	// OnlyObjectsOlderThan if set to non-zero value
 	// selects only objects older than the time provided.
 	OnlyObjectsOlderThan time.Time
-	// Handler is called on matching objects
+	// Expire is the age the objects must have to be pruned, as
+	// `git prune --expire` sets, if OnlyObjectsOlderThan is not set. If both
+	// are zero, DefaultGCPruneExpiry is used.
+	Expire time.Duration
+	// Handler is called on matching objects, r.DeleteObject if nil.
 	Handler PruneHandler
+	// DryRun doesn't call Handler, the objects are only listed on Progress,
+	// as `git prune --dry-run` does.
+	DryRun bool
+	// Progress is where the progress of the prune is written to, and the
+	// objects are listed with their type in a dry run.
+	Progress sideband.Progress
+}
+
+// olderThan returns the time before which the objects must have been
+// written to be pruned.
+func (o *PruneOptions) olderThan() time.Time {
+	if !o.OnlyObjectsOlderThan.IsZero() {
+		return o.OnlyObjectsOlderThan
+	}
+
+	expire := o.Expire
+	if expire == 0 {
+		expire = DefaultGCPruneExpiry
+	}
+
+	return time.Now().Add(-expire)
 }
 
 var ErrLooseObjectsNotSupported = errors.New("loose objects not supported")
@@ -30,37 +59,239 @@ func (r *Repository) DeleteObject(hash plumbing.Hash) error {
 	return los.DeleteLooseObject(hash)
 }
 
+// Prune deletes the loose objects returned by PruneCandidates, as `git prune`
+// does, or calls the handler of the options on them if set.
 func (r *Repository) Prune(opt PruneOptions) error {
+	return r.PruneContext(context.Background(), opt)
+}
+
+// PruneContext deletes the loose objects as Prune does. The context is
+// checked for each object walked and for each object deleted, the objects
+// being deleted one by one once all of them are known: if it is cancelled,
+// some unreachable objects are left, which a later prune deletes.
+func (r *Repository) PruneContext(ctx context.Context, opt PruneOptions) error {
+	candidates, err := r.pruneCandidates(ctx, opt)
+	if err != nil {
+		return err
+	}
+
+	handler := opt.Handler
+	if handler == nil {
+		handler = r.DeleteObject
+	}
+
+	if opt.DryRun {
+		// The object type is listed, as git does.
+		for _, h := range candidates {
+			if obj, err := r.Storer.EncodedObject(plumbing.AnyObject, h); err == nil && opt.Progress != nil {
+				fmt.Fprintf(opt.Progress, "%s %s\n", h, obj.Type())
+			}
+		}
+
+		return nil
+	}
+
+	progress := &fsckProgress{w: opt.Progress}
+	progress.start("Removing unreachable objects", len(candidates))
+	for _, h := range candidates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := handler(h); err != nil {
+			return err
+		}
+
+		progress.add()
+	}
+
+	progress.done()
+	return nil
+}
+
+// PruneCandidates returns the loose objects Prune would prune with the given
+// options: the ones which are not reachable from the references, HEAD, their
+// reflogs, the index or the objects of the packfiles with a .keep file, and
+// which were written before the expiry time.
+//
+// The reachable objects are walked once, then the loose objects are listed.
+// The objects referenced by the unreachable objects written after the expiry
+// time are kept too, as they may be part of a history being written
+// concurrently, which isn't reachable yet.
+func (r *Repository) PruneCandidates(opt PruneOptions) ([]plumbing.Hash, error) {
+	return r.pruneCandidates(context.Background(), opt)
+}
+
+func (r *Repository) pruneCandidates(ctx context.Context, opt PruneOptions) ([]plumbing.Hash, error) {
 	los, ok := r.Storer.(storer.LooseObjectStorer)
 	if !ok {
-		return ErrLooseObjectsNotSupported
+		return nil, ErrLooseObjectsNotSupported
 	}
 
-	pw := newObjectWalker(r.Storer)
-	err := pw.walkAllRefs()
-	if err != nil {
-		return err
+	olderThan := opt.olderThan()
+	pw := newObjectWalker(ctx, r.Storer)
+	if err := pw.walkAllRefs(); err != nil {
+		return nil, err
+	}
+
+	if err := r.walkPruneRoots(pw); err != nil {
+		return nil, err
+	}
+
+	if opt.Progress != nil {
+		fmt.Fprintf(opt.Progress, "Checking connectivity: %d, done.\n", len(pw.seen))
 	}
-	// Now walk all (loose) objects in storage.
-	return los.ForEachObjectHash(func(hash plumbing.Hash) error {
+
+	var candidates, recent []plumbing.Hash
+	err := los.ForEachObjectHash(func(hash plumbing.Hash) error {
 		// Get out if we have seen this object.
 		if pw.isSeen(hash) {
 			return nil
 		}
-		// Otherwise it is a candidate for pruning.
-		// Check out for too new objects next.
-		if !opt.OnlyObjectsOlderThan.IsZero() {
-			// Errors here are non-fatal. The object may be e.g. packed.
-			// Or concurrently deleted. Skip such objects.
-			t, err := los.LooseObjectTime(hash)
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Errors here are non-fatal. The object may be e.g. packed.
+		// Or concurrently deleted. Skip such objects.
+		t, err := los.LooseObjectTime(hash)
+		if err != nil {
+			return nil
+		}
+
+		if t.Before(olderThan) {
+			candidates = append(candidates, hash)
+		} else {
+			recent = append(recent, hash)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range recent {
+		// The objects they reference may be missing yet, the ones
+		// walked before are kept anyway.
+		_ = pw.walkObjectTree(h)
+	}
+
+	// An interrupted walk of the recent objects may have missed some.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pruned := candidates[:0]
+	for _, h := range candidates {
+		if !pw.isSeen(h) {
+			pruned = append(pruned, h)
+		}
+	}
+
+	return pruned, nil
+}
+
+// walkPruneRoots walks the objects reachable from HEAD, the reflogs and the
+// index, besides the references.
+func (r *Repository) walkPruneRoots(pw *objectWalker) error {
+	names := []plumbing.ReferenceName{plumbing.HEAD}
+	refs, err := r.Storer.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() != plumbing.HEAD {
+			names = append(names, ref.Name())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var roots []plumbing.Hash
+	if head, err := r.Storer.Reference(plumbing.HEAD); err == nil && head.Type() == plumbing.HashReference {
+		roots = append(roots, head.Hash())
+	}
+
+	if rr, ok := r.Storer.(storer.ReflogReader); ok {
+		for _, name := range names {
+			entries, err := rr.Reflog(name)
 			if err != nil {
-				return nil
+				return err
 			}
-			// Skip too new objects.
-			if !t.Before(opt.OnlyObjectsOlderThan) {
+
+			for _, e := range entries {
+				roots = append(roots, e.Old, e.New)
+			}
+		}
+	}
+
+	for _, h := range roots {
+		// The reflogs may point to objects already pruned.
+		if h.IsZero() || pw.isSeen(h) || r.Storer.HasEncodedObject(h) != nil {
+			continue
+		}
+
+		if err := pw.walkObjectTree(h); err != nil {
+			return err
+		}
+	}
+
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Mode != filemode.Submodule {
+			pw.add(e.Hash)
+		}
+	}
+
+	return r.walkKeptPacks(pw)
+}
+
+// walkKeptPacks walks the objects reachable from the objects of the
+// packfiles with a .keep file, such as the ones being received, whose
+// references may not be updated yet.
+func (r *Repository) walkKeptPacks(pw *objectWalker) error {
+	fs, ok := storageFilesystem(r.Storer)
+	if !ok {
+		return nil
+	}
+
+	pos, ok := r.Storer.(storer.PackedObjectStorer)
+	if !ok {
+		return nil
+	}
+
+	packs, err := pos.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	kept, err := keptObjectPacks(fs, packs)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range kept {
+		err := forEachIndexEntry(idx, func(h plumbing.Hash) error {
+			if pw.isSeen(h) {
 				return nil
 			}
+
+			return pw.walkObjectTree(h)
+		})
+		if err != nil {
+			return err
 		}
-		return opt.Handler(hash)
-	})
+	}
+
+	return nil
 }
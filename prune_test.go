@@ -1,13 +1,25 @@
 package git
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/go-git/go-billy/v5/util"
+
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
 	. "gopkg.in/check.v1"
@@ -44,9 +56,17 @@ func (s *PruneSuite) testPrune(c *C, deleteTime time.Time) {
 	c.Assert(err, IsNil)
 	err = sto.RemoveReference(plumbing.ReferenceName("refs/remotes/origin/v4"))
 	c.Assert(err, IsNil)
+	// Their reflogs would keep the objects reachable.
+	c.Assert(util.RemoveAll(srcFs, "logs"), IsNil)
+
+	olderThan := deleteTime
+	if olderThan.IsZero() {
+		// The objects were all just written.
+		olderThan = time.Now()
+	}
 
 	err = r.Prune(PruneOptions{
-		OnlyObjectsOlderThan: deleteTime,
+		OnlyObjectsOlderThan: olderThan,
 		Handler:              r.DeleteObject,
 	})
 	c.Assert(err, IsNil)
@@ -73,3 +93,188 @@ func (s *PruneSuite) TestPrune(c *C) {
 func (s *PruneSuite) TestPruneWithNoDelete(c *C) {
 	s.testPrune(c, time.Unix(0, 1))
 }
+
+// pruneRepository returns a repository with a commit, and an unreachable
+// one written on top of it, all their objects written a month ago.
+func (s *PruneSuite) pruneRepository(c *C) (*Repository, string, plumbing.Hash) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "foo", []byte("foo"), 0o644), IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+	head, err := w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	b := &historyBuilder{c: c, r: r, when: time.Now()}
+	unreachable := b.commit("bar\n", map[string]string{"foo": "foo", "bar": "bar"}, head)
+
+	s.touchObjects(c, dir, time.Now().Add(-30*24*time.Hour))
+	return r, dir, unreachable
+}
+
+// touchObjects sets the modification time of the loose objects.
+func (s *PruneSuite) touchObjects(c *C, dir string, t time.Time, hashes ...plumbing.Hash) {
+	if len(hashes) == 0 {
+		err := filepath.Walk(filepath.Join(dir, ".git", "objects"), func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			return os.Chtimes(path, t, t)
+		})
+		c.Assert(err, IsNil)
+		return
+	}
+
+	for _, h := range hashes {
+		path := filepath.Join(dir, ".git", "objects", h.String()[:2], h.String()[2:])
+		c.Assert(os.Chtimes(path, t, t), IsNil)
+	}
+}
+
+func (s *PruneSuite) TestPruneCandidates(c *C) {
+	r, _, unreachable := s.pruneRepository(c)
+
+	commit, err := r.CommitObject(unreachable)
+	c.Assert(err, IsNil)
+	tree, err := commit.Tree()
+	c.Assert(err, IsNil)
+	bar, err := tree.File("bar")
+	c.Assert(err, IsNil)
+
+	candidates, err := r.PruneCandidates(PruneOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(sortedHashes(candidates), DeepEquals, sortedHashes([]plumbing.Hash{unreachable, tree.Hash, bar.Hash}))
+
+	// The objects written afterwards are kept.
+	candidates, err = r.PruneCandidates(PruneOptions{Expire: 60 * 24 * time.Hour})
+	c.Assert(err, IsNil)
+	c.Assert(candidates, HasLen, 0)
+
+	candidates, err = r.PruneCandidates(PruneOptions{OnlyObjectsOlderThan: time.Now().Add(-60 * 24 * time.Hour)})
+	c.Assert(err, IsNil)
+	c.Assert(candidates, HasLen, 0)
+}
+
+func (s *PruneSuite) TestPruneKeepsReferencedByRecent(c *C) {
+	r, dir, unreachable := s.pruneRepository(c)
+
+	// The tree and the blob of a commit being written are kept.
+	s.touchObjects(c, dir, time.Now(), unreachable)
+	candidates, err := r.PruneCandidates(PruneOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(candidates, HasLen, 0)
+}
+
+func (s *PruneSuite) TestPruneKeepsReflogs(c *C) {
+	r, dir, unreachable := s.pruneRepository(c)
+
+	// The commit was reset.
+	entry := fmt.Sprintf("%s %s foo <foo@foo.foo> 1257894000 +0000\tcommit: bar\n", plumbing.ZeroHash, unreachable)
+	c.Assert(os.MkdirAll(filepath.Join(dir, ".git", "logs"), 0o755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, ".git", "logs", "HEAD"), []byte(entry), 0o644), IsNil)
+
+	candidates, err := r.PruneCandidates(PruneOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(candidates, HasLen, 0)
+}
+
+func (s *PruneSuite) TestPruneKeepsIndex(c *C) {
+	r, dir, _ := s.pruneRepository(c)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "qux", []byte("qux"), 0o644), IsNil)
+	blob, err := w.Add("qux")
+	c.Assert(err, IsNil)
+
+	s.touchObjects(c, dir, time.Now().Add(-30*24*time.Hour), blob)
+	candidates, err := r.PruneCandidates(PruneOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(candidates, HasLen, 3)
+	for _, h := range candidates {
+		c.Assert(h, Not(Equals), blob)
+	}
+}
+
+func (s *PruneSuite) TestPruneKeepsKeptPacks(c *C) {
+	r, _, unreachable := s.pruneRepository(c)
+
+	// A packfile being received, with a tag of the unreachable commit.
+	tag := &object.Tag{
+		Name:       "bar",
+		Tagger:     *defaultSignature(),
+		Message:    "bar\n",
+		TargetType: plumbing.CommitObject,
+		Target:     unreachable,
+	}
+
+	mem := memory.NewStorage()
+	obj := mem.NewEncodedObject()
+	c.Assert(tag.Encode(obj), IsNil)
+	_, err := mem.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	w, unkeep, err := r.Storer.(storer.PackfileKeeper).KeptPackfileWriter("receive-pack")
+	c.Assert(err, IsNil)
+	_, err = packfile.NewEncoder(w, mem, false).Encode([]plumbing.Hash{obj.Hash()}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	candidates, err := r.PruneCandidates(PruneOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(candidates, HasLen, 0)
+
+	c.Assert(unkeep(), IsNil)
+	candidates, err = r.PruneCandidates(PruneOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(candidates, HasLen, 3)
+}
+
+func (s *PruneSuite) TestPruneDryRun(c *C) {
+	r, _, unreachable := s.pruneRepository(c)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(r.Prune(PruneOptions{DryRun: true, Progress: buf}), IsNil)
+	c.Assert(buf.String(), Matches, "(?s).*\n"+unreachable.String()+" commit\n.*")
+	c.Assert(strings.Count(buf.String(), "\n"), Equals, 4)
+	c.Assert(r.Storer.HasEncodedObject(unreachable), IsNil)
+
+	buf.Reset()
+	c.Assert(r.Prune(PruneOptions{Progress: buf}), IsNil)
+	c.Assert(buf.String(), Matches, "(?s).*Removing unreachable objects: 100% \\(3/3\\), done.\n")
+	c.Assert(r.Storer.HasEncodedObject(unreachable), Equals, plumbing.ErrObjectNotFound)
+
+	_, err := r.Head()
+	c.Assert(err, IsNil)
+	iter, err := r.Log(&LogOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(iter.ForEach(func(*object.Commit) error { return nil }), IsNil)
+}
+
+func (s *PruneSuite) TestPruneContextCancelled(c *C) {
+	r, _, unreachable := s.pruneRepository(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c.Assert(r.PruneContext(ctx, PruneOptions{}), Equals, context.Canceled)
+	c.Assert(r.Storer.HasEncodedObject(unreachable), IsNil)
+
+	c.Assert(r.PruneContext(context.Background(), PruneOptions{}), IsNil)
+	c.Assert(r.Storer.HasEncodedObject(unreachable), Equals, plumbing.ErrObjectNotFound)
+}
+
+func sortedHashes(hashes []plumbing.Hash) []string {
+	s := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		s = append(s, h.String())
+	}
+
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,359 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/internal/revision"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/reflog"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// ExpireReflog removes the old entries of the reflogs, as
+// `git reflog expire` does: the entries older than the expiry, and the ones
+// older than the stricter expiry of the unreachable entries whose commit
+// isn't reachable from the reference, or from any reference for HEAD. Each
+// reflog is rewritten at once, with a lock file renamed over it.
+//
+// The expiries default to the gc config of each reference, as git gc does.
+// storer.ErrReflogNotSupported is returned if a reflog must be rewritten
+// and the storage can't.
+func (r *Repository) ExpireReflog(o *ExpireReflogOptions) error {
+	if o == nil {
+		o = &ExpireReflogOptions{}
+	}
+
+	rr, ok := r.Storer.(storer.ReflogReader)
+	if !ok {
+		// There are no reflogs to expire.
+		return nil
+	}
+
+	names, err := r.reflogNames(o)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, name := range names {
+		entries, err := rr.Reflog(name)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) == 0 {
+			continue
+		}
+
+		expire, unreachable, err := reflogExpiries(cfg, name, o, now)
+		if err != nil {
+			return err
+		}
+
+		kept, err := r.expireReflogEntries(name, entries, expire, unreachable)
+		if err != nil {
+			return err
+		}
+
+		if len(kept) == len(entries) {
+			continue
+		}
+
+		w, ok := r.Storer.(storer.ReflogWriter)
+		if !ok {
+			return storer.ErrReflogNotSupported
+		}
+
+		if err := w.SetReflog(name, kept); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reflogNames returns the references whose reflogs are expired with the
+// given options.
+func (r *Repository) reflogNames(o *ExpireReflogOptions) ([]plumbing.ReferenceName, error) {
+	if !o.All {
+		if o.Ref == "" {
+			return []plumbing.ReferenceName{plumbing.HEAD}, nil
+		}
+
+		return []plumbing.ReferenceName{o.Ref}, nil
+	}
+
+	names := []plumbing.ReferenceName{plumbing.HEAD}
+	iter, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() != plumbing.HEAD {
+			names = append(names, ref.Name())
+		}
+
+		return nil
+	})
+
+	return names, err
+}
+
+// expireReflogEntries returns the entries of the reflog of the given
+// reference not expired, in order.
+func (r *Repository) expireReflogEntries(
+	name plumbing.ReferenceName,
+	entries []*reflog.Entry,
+	expire, unreachable time.Time,
+) ([]*reflog.Entry, error) {
+	drop := make([]bool, len(entries))
+	var candidates []int
+	var queries []plumbing.Hash
+	for i, e := range entries {
+		// The entries of deletions, and of objects other than commits, are
+		// never unreachable, as in git.
+		switch {
+		case e.When.Before(expire):
+			drop[i] = true
+		case e.When.Before(unreachable) && r.isCommit(e.New):
+			candidates = append(candidates, i)
+			queries = append(queries, e.New)
+		}
+	}
+
+	if len(candidates) > 0 {
+		tips, err := r.reflogTips(name)
+		if err != nil {
+			return nil, err
+		}
+
+		reachable, err := r.ReachableFrom(tips, queries)
+		if err != nil {
+			return nil, err
+		}
+
+		for j, i := range candidates {
+			drop[i] = !reachable[j]
+		}
+	}
+
+	var kept []*reflog.Entry
+	for i, e := range entries {
+		if !drop[i] {
+			kept = append(kept, e)
+		}
+	}
+
+	return kept, nil
+}
+
+// isCommit returns true if the object with the given hash is a commit of
+// the repository.
+func (r *Repository) isCommit(h plumbing.Hash) bool {
+	if h.IsZero() {
+		return false
+	}
+
+	obj, err := r.Storer.EncodedObject(plumbing.AnyObject, h)
+	return err == nil && obj.Type() == plumbing.CommitObject
+}
+
+// reflogTips returns the commits the entries of the reflog of the given
+// reference must be reachable from: the one of the reference, or the ones
+// of all the references for HEAD.
+func (r *Repository) reflogTips(name plumbing.ReferenceName) ([]plumbing.Hash, error) {
+	var refs []*plumbing.Reference
+	if name == plumbing.HEAD {
+		iter, err := r.Storer.IterReferences()
+		if err != nil {
+			return nil, err
+		}
+
+		err = iter.ForEach(func(ref *plumbing.Reference) error {
+			if ref.Type() == plumbing.HashReference {
+				refs = append(refs, ref)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if ref, err := storer.ResolveReference(r.Storer, name); err == nil {
+		refs = append(refs, ref)
+	} else if err != plumbing.ErrReferenceNotFound {
+		return nil, err
+	}
+
+	var tips []plumbing.Hash
+	for _, ref := range refs {
+		obj, err := r.Object(plumbing.AnyObject, ref.Hash())
+		if err != nil {
+			continue
+		}
+
+		if tag, ok := obj.(*object.Tag); ok {
+			if obj, err = tag.Commit(); err != nil {
+				continue
+			}
+		}
+
+		if c, ok := obj.(*object.Commit); ok {
+			tips = append(tips, c.Hash)
+		}
+	}
+
+	return tips, nil
+}
+
+// reflogExpiries returns the expiries of the entries of the reflog of the
+// given reference, from the options or from the gc config.
+func reflogExpiries(
+	cfg *config.Config,
+	name plumbing.ReferenceName,
+	o *ExpireReflogOptions,
+	now time.Time,
+) (expire, unreachable time.Time, err error) {
+	expire, unreachable = o.Expire, o.ExpireUnreachable
+	if expire.IsZero() {
+		expire, err = reflogExpiryConfig(cfg, name, "reflogExpire", now.Add(-DefaultReflogExpiry), now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	if unreachable.IsZero() {
+		unreachable, err = reflogExpiryConfig(cfg, name, "reflogExpireUnreachable",
+			now.Add(-DefaultReflogExpiryUnreachable), now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	return expire, unreachable, nil
+}
+
+// reflogExpiryConfig returns the expiry of the given key of the gc config
+// for the reference: the one of the first gc.<pattern> section matching
+// it, or the one of the gc section. A zero time never expires.
+func reflogExpiryConfig(cfg *config.Config, name plumbing.ReferenceName, key string, def, now time.Time) (time.Time, error) {
+	if !cfg.Raw.HasSection("gc") {
+		return def, nil
+	}
+
+	sec := cfg.Raw.Section("gc")
+	value, found := "", false
+	for _, ss := range sec.Subsections {
+		if ss.HasOption(key) && matchReflogPattern(ss.Name, name.String()) {
+			value, found = ss.Option(key), true
+			break
+		}
+	}
+
+	if !found {
+		if !sec.HasOption(key) {
+			return def, nil
+		}
+
+		value = sec.Option(key)
+	}
+
+	switch strings.ToLower(value) {
+	case "never", "false":
+		return time.Time{}, nil
+	case "all", "now":
+		return now, nil
+	}
+
+	t, ok := revision.ParseDate(value, now)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid gc.%s date: %q", key, value)
+	}
+
+	return t, nil
+}
+
+// matchReflogPattern matches the name of a reference with the pattern of a
+// gc.<pattern> section, whose wildcards match slashes too.
+func matchReflogPattern(pattern, name string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+
+	ok, err := regexp.MatchString("^"+expr+"$", name)
+	return err == nil && ok
+}
+
+// RecoverBranch recreates the deleted local branch with the given name at
+// the commit of the given revision, usually a reflog statement such as
+// HEAD@{2}. If the revision is empty, the branch is recreated at the
+// commit it was at the last time HEAD moved away from it, found in the
+// reflog of HEAD. ErrReflogNotFound is returned if there is no such entry.
+func (r *Repository) RecoverBranch(name string, rev plumbing.Revision) (*plumbing.Reference, error) {
+	name = strings.TrimPrefix(name, "refs/heads/")
+	ref := plumbing.NewBranchReferenceName(name)
+	if err := ref.Validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Storer.Reference(ref); err == nil {
+		return nil, fmt.Errorf("branch '%s' already exists: %w", name, ErrBranchExists)
+	} else if err != plumbing.ErrReferenceNotFound {
+		return nil, err
+	}
+
+	var h plumbing.Hash
+	if rev == "" {
+		var err error
+		if h, err = r.lastCheckoutFrom(name); err != nil {
+			return nil, err
+		}
+	} else {
+		resolved, err := r.ResolveRevision(rev)
+		if err != nil {
+			return nil, err
+		}
+
+		h = *resolved
+	}
+
+	if _, err := r.CommitObject(h); err != nil {
+		return nil, err
+	}
+
+	branch := plumbing.NewHashReference(ref, h)
+	return branch, r.Storer.SetReference(branch)
+}
+
+// lastCheckoutFrom returns the commit the given branch was at the last time
+// HEAD moved away from it, from the reflog of HEAD.
+func (r *Repository) lastCheckoutFrom(name string) (plumbing.Hash, error) {
+	var entries []*reflog.Entry
+	if rr, ok := r.Storer.(storer.ReflogReader); ok {
+		var err error
+		if entries, err = rr.Reflog(plumbing.HEAD); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	prefix := "checkout: moving from " + name + " to "
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(entries[i].Message, prefix) {
+			return entries[i].Old, nil
+		}
+	}
+
+	return plumbing.ZeroHash, fmt.Errorf("no checkout of branch '%s' in the reflog of HEAD: %w",
+		name, ErrReflogNotFound)
+}
@@ -0,0 +1,188 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type ReflogSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ReflogSuite{})
+
+const (
+	reflogZero    = "0000000000000000000000000000000000000000"
+	reflogInitial = "b029517f6300c2da0f4b651b8642506cd6aaf45d"
+	reflogSecond  = "35e85108805c84807bc66a02d91535e1e24b38b9"
+	reflogBranch  = "e8d3ffab552895c19b9fcf7aa264d277cde33881"
+	reflogMaster  = "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"
+)
+
+// writeReflog writes the reflog of the given reference, with entries of
+// the given ages.
+func (s *ReflogSuite) writeReflog(c *C, fs billy.Filesystem, name string, entries ...string) {
+	err := util.WriteFile(fs, "logs/"+name, []byte(strings.Join(entries, "")), 0o644)
+	c.Assert(err, IsNil)
+}
+
+func (s *ReflogSuite) entry(old, new string, age time.Duration, msg string) string {
+	return fmt.Sprintf("%s %s John Doe <john@example.com> %d +0000\t%s\n",
+		old, new, time.Now().Add(-age).Unix(), msg)
+}
+
+func (s *ReflogSuite) reflog(c *C, r *Repository, name plumbing.ReferenceName) []string {
+	entries, err := r.Storer.(storer.ReflogReader).Reflog(name)
+	c.Assert(err, IsNil)
+
+	var res []string
+	for _, e := range entries {
+		res = append(res, e.New.String())
+	}
+
+	return res
+}
+
+func (s *ReflogSuite) TestExpireReflog(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	day := 24 * time.Hour
+	s.writeReflog(c, fs, "refs/heads/master",
+		s.entry(reflogZero, reflogInitial, 100*day, "commit (initial): foo"),
+		s.entry(reflogInitial, reflogBranch, 60*day, "reset: moving to "+reflogBranch),
+		s.entry(reflogBranch, reflogSecond, 50*day, "reset: moving to "+reflogSecond),
+		s.entry(reflogSecond, reflogBranch, 10*day, "reset: moving to "+reflogBranch),
+		s.entry(reflogBranch, reflogMaster, day, "commit: bar"),
+	)
+
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	c.Assert(r.ExpireReflog(&ExpireReflogOptions{Ref: "refs/heads/master"}), IsNil)
+
+	// The old entry and the unreachable one older than 30 days are removed.
+	expected := []string{reflogSecond, reflogBranch, reflogMaster}
+	c.Assert(s.reflog(c, r, "refs/heads/master"), DeepEquals, expected)
+
+	// The rewritten reflog is read by git.
+	cmd := exec.Command("git", "--git-dir", fs.Root(), "reflog", "show", "--format=%H", "refs/heads/master")
+	out, err := cmd.Output()
+	c.Assert(err, IsNil)
+	c.Assert(strings.Fields(string(out)), DeepEquals, []string{reflogMaster, reflogBranch, reflogSecond})
+
+	c.Assert(r.ExpireReflog(&ExpireReflogOptions{Ref: "refs/heads/master", Expire: time.Now()}), IsNil)
+	c.Assert(s.reflog(c, r, "refs/heads/master"), HasLen, 0)
+}
+
+func (s *ReflogSuite) TestExpireReflogConfig(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	day := 24 * time.Hour
+	entries := []string{
+		s.entry(reflogZero, reflogInitial, 100*day, "commit (initial): foo"),
+		s.entry(reflogInitial, reflogBranch, 60*day, "reset: moving to "+reflogBranch),
+		s.entry(reflogBranch, reflogMaster, 20*day, "commit: bar"),
+	}
+
+	s.writeReflog(c, fs, "HEAD", entries...)
+	s.writeReflog(c, fs, "refs/heads/master", entries...)
+	s.writeReflog(c, fs, "refs/remotes/origin/master", entries...)
+
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("gc").SetOption("reflogExpire", "10.days.ago")
+	cfg.Raw.Section("gc").Subsection("refs/remotes/*").SetOption("reflogExpire", "never")
+	cfg.Raw.Section("gc").Subsection("refs/remotes/*").SetOption("reflogExpireUnreachable", "never")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	c.Assert(r.ExpireReflog(&ExpireReflogOptions{All: true}), IsNil)
+
+	c.Assert(s.reflog(c, r, plumbing.HEAD), HasLen, 0)
+	c.Assert(s.reflog(c, r, "refs/heads/master"), HasLen, 0)
+	c.Assert(s.reflog(c, r, "refs/remotes/origin/master"), DeepEquals,
+		[]string{reflogInitial, reflogBranch, reflogMaster})
+
+	cfg.Raw.Section("gc").SetOption("reflogExpire", "soon")
+	c.Assert(r.SetConfig(cfg), IsNil)
+	s.writeReflog(c, fs, "HEAD", entries...)
+
+	err = r.ExpireReflog(nil)
+	c.Assert(err, ErrorMatches, `invalid gc.reflogExpire date: "soon"`)
+}
+
+func (s *ReflogSuite) TestExpireReflogNotSupported(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.ExpireReflog(&ExpireReflogOptions{All: true}), IsNil)
+}
+
+func (s *ReflogSuite) TestRecoverBranch(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	s.writeReflog(c, fs, "HEAD",
+		s.entry(reflogZero, reflogSecond, time.Hour, "commit (initial): foo"),
+		s.entry(reflogSecond, reflogBranch, time.Hour, "checkout: moving from master to feature"),
+		s.entry(reflogBranch, reflogMaster, time.Hour, "checkout: moving from feature to master"),
+	)
+
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	ref, err := r.RecoverBranch("feature", "")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Name(), Equals, plumbing.ReferenceName("refs/heads/feature"))
+	c.Assert(ref.Hash(), Equals, plumbing.NewHash(reflogBranch))
+
+	stored, err := r.Reference("refs/heads/feature", false)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Hash(), Equals, plumbing.NewHash(reflogBranch))
+
+	ref, err = r.RecoverBranch("refs/heads/old", "HEAD@{2}")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, plumbing.NewHash(reflogSecond))
+
+	_, err = r.RecoverBranch("feature", "")
+	c.Assert(errors.Is(err, ErrBranchExists), Equals, true)
+
+	_, err = r.RecoverBranch("missing", "")
+	c.Assert(errors.Is(err, ErrReflogNotFound), Equals, true)
+}
+
+func (s *ReflogSuite) TestDescribeHeadDetached(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	s.writeReflog(c, fs, "HEAD",
+		s.entry(reflogZero, reflogMaster, time.Hour, "clone: from foo"),
+		s.entry(reflogMaster, reflogSecond, time.Hour, "checkout: moving from master to origin/second"),
+	)
+
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	c.Assert(r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, plumbing.NewHash(reflogSecond))), IsNil)
+	d, err := r.DescribeHead()
+	c.Assert(err, IsNil)
+	c.Assert(d.Detached, Equals, true)
+	c.Assert(d.String(), Equals, "HEAD detached at origin/second")
+
+	// HEAD moved since it was detached, as by a commit.
+	c.Assert(r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, plumbing.NewHash(reflogBranch))), IsNil)
+	d, err = r.DescribeHead()
+	c.Assert(err, IsNil)
+	c.Assert(d.Moved, Equals, true)
+	c.Assert(d.String(), Equals, "HEAD detached from origin/second")
+}
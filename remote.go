@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/go-git/go-billy/v5/osfs"
 
@@ -14,6 +17,7 @@ import (
 	"github.com/go-git/go-git/v5/internal/url"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
@@ -23,6 +27,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/go-git/go-git/v5/storage/memory"
@@ -35,6 +40,10 @@ var (
 	ErrForceNeeded           = errors.New("some refs were not updated")
 	ErrExactSHA1NotSupported = errors.New("server does not support exact SHA1 refspec")
 	ErrEmptyUrls             = errors.New("URLs cannot be empty")
+	// ErrRemoteHeadNotFound is returned by Remote.Head when the HEAD of the
+	// remote doesn't point to a branch, as when the remote is empty or its
+	// HEAD is detached.
+	ErrRemoteHeadNotFound = errors.New("remote HEAD not found")
 )
 
 type NoMatchingRefSpecError struct {
@@ -55,8 +64,14 @@ const (
 	// computing the haves to send to a server, for each ref in the
 	// repo containing this remote, when not using the multi-ack
 	// protocol.  Setting this to 0 means there is no limit.
+	// The haves are negotiated instead when the server supports
+	// multi_ack_detailed, see havesNegotiator.
 	maxHavesToVisitPerRef = 100
 
+	// smallPushSize is the size of the objects of a push under which no
+	// delta is searched, as it would save little.
+	smallPushSize = 32 * 1024
+
 	// peeledSuffix is the suffix used to build peeled reference names.
 	peeledSuffix = "^{}"
 )
@@ -79,11 +94,39 @@ func (r *Remote) Config() *config.RemoteConfig {
 	return r.c
 }
 
+// IsSingleBranch returns true if the fetch refspecs of the remote don't
+// fetch all its branches, as the ones of a single branch clone. A fetch with
+// explicit FetchOptions.RefSpecs fetches other references anyway, and
+// Repository.SetFetchAllBranches makes the remote fetch all of them.
+func (r *Remote) IsSingleBranch() bool {
+	for _, s := range r.c.Fetch {
+		if !s.IsNegative() && s.IsWildcard() && s.Src() == refspecAllBranches {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isBranchRefSpec returns true if s fetches a single branch, or HEAD.
+func isBranchRefSpec(s config.RefSpec) bool {
+	if s.IsNegative() || s.IsWildcard() || s.IsExactSHA1() {
+		return false
+	}
+
+	src := plumbing.ReferenceName(s.Src())
+	return src == plumbing.HEAD || src.IsBranch()
+}
+
 func (r *Remote) String() string {
 	var fetch, push string
 	if len(r.c.URLs) > 0 {
 		fetch = r.c.URLs[0]
-		push = r.c.URLs[len(r.c.URLs)-1]
+		push = r.c.URLs[0]
+	}
+
+	if len(r.c.PushURLs) > 0 {
+		push = r.c.PushURLs[0]
 	}
 
 	return fmt.Sprintf("%s\t%s (fetch)\n%[1]s\t%[3]s (push)", r.c.Name, fetch, push)
@@ -98,6 +141,11 @@ func (r *Remote) Push(o *PushOptions) error {
 // PushContext performs a push to the remote. Returns NoErrAlreadyUpToDate if
 // the remote was already up-to-date.
 //
+// Unless PushOptions.RemoteURL is set, the push is performed to all the push
+// URLs of the remote, or all its URLs if it has none. The errors of every URL
+// are returned joined, and NoErrAlreadyUpToDate only if all of them were
+// already up-to-date.
+//
 // The provided Context must be non-nil. If the context expires before the
 // operation is complete, an error is returned. The context only affects the
 // transport operations.
@@ -110,29 +158,192 @@ func (r *Remote) PushContext(ctx context.Context, o *PushOptions) (err error) {
 		return fmt.Errorf("remote names don't match: %s != %s", o.RemoteName, r.c.Name)
 	}
 
-	if o.RemoteURL == "" && len(r.c.URLs) > 0 {
-		o.RemoteURL = r.c.URLs[len(r.c.URLs)-1]
+	urls := r.pushURLs(o)
+	if len(urls) == 1 {
+		return r.push(ctx, o, urls[0])
+	}
+
+	var errs []error
+	upToDate := true
+	for _, url := range urls {
+		err := r.push(ctx, o, url)
+		if err == NoErrAlreadyUpToDate {
+			continue
+		}
+
+		upToDate = false
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+
+	if upToDate {
+		return NoErrAlreadyUpToDate
+	}
+
+	return nil
+}
+
+// pushURLs returns the URLs to push to, rewritten by the url.<base>.insteadOf
+// and url.<base>.pushInsteadOf rules. As git does, the latter are not applied
+// to the push URLs of the remote.
+func (r *Remote) pushURLs(o *PushOptions) []string {
+	cfg := r.repositoryConfig()
+	if cfg == nil {
+		cfg = config.NewConfig()
+	}
+
+	if o.RemoteURL != "" {
+		return []string{cfg.ApplyPushInsteadOf(o.RemoteURL)}
 	}
 
-	s, err := newSendPackSession(o.RemoteURL, o.Auth, o.InsecureSkipTLS, o.CABundle, o.ProxyOptions)
+	var urls []string
+	if len(r.c.PushURLs) != 0 {
+		for _, u := range r.c.PushURLs {
+			urls = append(urls, cfg.ApplyInsteadOf(u))
+		}
+
+		return urls
+	}
+
+	for _, u := range r.c.URLs {
+		urls = append(urls, cfg.ApplyPushInsteadOf(u))
+	}
+
+	if len(urls) == 0 {
+		// No URL at all, let the transport report the error.
+		urls = append(urls, "")
+	}
+
+	return urls
+}
+
+func (r *Remote) push(ctx context.Context, o *PushOptions, remoteURL string) (err error) {
+	s, err := newSendPackSession(remoteURL, o.Auth, o.InsecureSkipTLS, o.CABundle, o.ProxyOptions, o.Timeouts, r.repositoryConfig())
 	if err != nil {
 		return err
 	}
 
 	defer ioutil.CheckClose(s, &err)
 
-	ar, err := s.AdvertisedReferencesContext(ctx)
+	p, err := r.newPushPlan(ctx, s, o, remoteURL)
 	if err != nil {
 		return err
 	}
 
-	remoteRefs, err := ar.AllReferences()
+	if err := r.runPrePushHook(ctx, o, remoteURL, p.req.Commands); err != nil {
+		return err
+	}
+
+	if o.DryRun {
+		return nil
+	}
+
+	rs, err := pushHashes(ctx, s, r.s, p.req, p.hashes, p.useRefDeltas, p.thin, p.allDelete, o.ProgressHandler)
 	if err != nil {
 		return err
 	}
 
+	if rs != nil {
+		if err = rs.Error(); err != nil {
+			return err
+		}
+	}
+
+	return r.updateRemoteReferenceStorage(p.req)
+}
+
+// PushDryRun is what a push would do, as computed by Remote.PushDryRun.
+type PushDryRun struct {
+	// Commands are the updates of the remote references the push would
+	// request.
+	Commands []*packp.Command
+	// Objects is the number of objects the packfile would contain.
+	Objects int
+	// PackSize is the size of the packfile which would be sent, in bytes.
+	PackSize int64
+}
+
+// PushDryRun returns what a push with the given options would do: the
+// references of the remote it would update, and the number of objects and
+// the size of the packfile it would send. The references are advertised by
+// the remote and the packfile is encoded, as for a push, but nothing is sent
+// and no reference is updated. It returns NoErrAlreadyUpToDate if the remote
+// is already up-to-date.
+//
+// Unless PushOptions.RemoteURL is set, the first push URL of the remote, or
+// its first URL if it has none, is used.
+func (r *Remote) PushDryRun(o *PushOptions) (*PushDryRun, error) {
+	return r.PushDryRunContext(context.Background(), o)
+}
+
+// PushDryRunContext returns what a push with the given options would do, as
+// PushDryRun does.
+//
+// The provided Context must be non-nil. If the context expires before the
+// operation is complete, an error is returned. The context only affects the
+// transport operations.
+func (r *Remote) PushDryRunContext(ctx context.Context, o *PushOptions) (dr *PushDryRun, err error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	if o.RemoteName != r.c.Name {
+		return nil, fmt.Errorf("remote names don't match: %s != %s", o.RemoteName, r.c.Name)
+	}
+
+	s, err := newSendPackSession(r.pushURLs(o)[0], o.Auth, o.InsecureSkipTLS, o.CABundle, o.ProxyOptions, o.Timeouts, r.repositoryConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(s, &err)
+
+	p, err := r.newPushPlan(ctx, s, o, r.pushURLs(o)[0])
+	if err != nil {
+		return nil, err
+	}
+
+	dr = &PushDryRun{Commands: p.req.Commands, Objects: len(p.hashes)}
+	if p.allDelete {
+		return dr, nil
+	}
+
+	if dr.PackSize, err = encodedPackSize(r.s, p.hashes, p.useRefDeltas, p.thin); err != nil {
+		return nil, err
+	}
+
+	return dr, nil
+}
+
+// pushPlan is what a push sends to a remote.
+type pushPlan struct {
+	req          *packp.ReferenceUpdateRequest
+	hashes       []plumbing.Hash
+	useRefDeltas bool
+	thin         bool
+	allDelete    bool
+}
+
+// newPushPlan returns what to send to the remote of the given session, from
+// the references it advertises, or NoErrAlreadyUpToDate if nothing has to be.
+func (r *Remote) newPushPlan(ctx context.Context, s transport.ReceivePackSession, o *PushOptions, remoteURL string) (*pushPlan, error) {
+	ar, err := s.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteRefs, err := ar.AllReferences()
+	if err != nil {
+		return nil, err
+	}
+
 	if err := r.checkRequireRemoteRefs(o.RequireRemoteRefs, remoteRefs); err != nil {
-		return err
+		return nil, err
 	}
 
 	isDelete := false
@@ -149,13 +360,13 @@ func (r *Remote) PushContext(ctx context.Context, o *PushOptions) (err error) {
 	}
 
 	if isDelete && !ar.Capabilities.Supports(capability.DeleteRefs) {
-		return ErrDeleteRefNotSupported
+		return nil, ErrDeleteRefNotSupported
 	}
 
 	if o.Force {
 		for i := 0; i < len(o.RefSpecs); i++ {
 			rs := &o.RefSpecs[i]
-			if !rs.IsForceUpdate() && !rs.IsDelete() {
+			if !rs.IsForceUpdate() && !rs.IsDelete() && !rs.IsNegative() {
 				o.RefSpecs[i] = config.RefSpec("+" + rs.String())
 			}
 		}
@@ -163,28 +374,37 @@ func (r *Remote) PushContext(ctx context.Context, o *PushOptions) (err error) {
 
 	localRefs, err := r.references()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req, err := r.newReferenceUpdateRequest(o, localRefs, remoteRefs, ar)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if len(req.Commands) == 0 {
-		return NoErrAlreadyUpToDate
+		return nil, NoErrAlreadyUpToDate
+	}
+
+	if !ar.Capabilities.Supports(capability.DeleteRefs) {
+		// The deletions computed by prune, such as the ones of a mirror push.
+		for _, cmd := range req.Commands {
+			if cmd.Action() == packp.Delete {
+				return nil, ErrDeleteRefNotSupported
+			}
+		}
 	}
 
 	objects := objectsToPush(req.Commands)
 
 	haves, err := referencesToHashes(remoteRefs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	stop, err := r.s.Shallow()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// if we have shallow we should include this as part of the objects that
@@ -194,19 +414,19 @@ func (r *Remote) PushContext(ctx context.Context, o *PushOptions) (err error) {
 	var hashesToPush []plumbing.Hash
 	// Avoid the expensive revlist operation if we're only doing deletes.
 	if !allDelete {
-		if url.IsLocalEndpoint(o.RemoteURL) {
+		if url.IsLocalEndpoint(remoteURL) {
 			// If we're are pushing to a local repo, it might be much
 			// faster to use a local storage layer to get the commits
 			// to ignore, when calculating the object revlist.
 			localStorer := filesystem.NewStorage(
-				osfs.New(o.RemoteURL), cache.NewObjectLRUDefault())
+				osfs.New(remoteURL), cache.NewObjectLRUDefault())
 			hashesToPush, err = revlist.ObjectsWithStorageForIgnores(
 				r.s, localStorer, objects, haves)
 		} else {
 			hashesToPush, err = revlist.Objects(r.s, objects, haves)
 		}
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -220,18 +440,13 @@ func (r *Remote) PushContext(ctx context.Context, o *PushOptions) (err error) {
 		}
 	}
 
-	rs, err := pushHashes(ctx, s, r.s, req, hashesToPush, r.useRefDeltas(ar), allDelete)
-	if err != nil {
-		return err
-	}
-
-	if rs != nil {
-		if err = rs.Error(); err != nil {
-			return err
-		}
-	}
-
-	return r.updateRemoteReferenceStorage(req)
+	return &pushPlan{
+		req:          req,
+		hashes:       hashesToPush,
+		useRefDeltas: r.useRefDeltas(ar),
+		thin:         !ar.Capabilities.Supports(capability.NoThin),
+		allDelete:    allDelete,
+	}, nil
 }
 
 func (r *Remote) useRefDeltas(ar *packp.AdvRefs) bool {
@@ -354,7 +569,7 @@ func (r *Remote) updateRemoteReferenceStorage(
 
 	for _, spec := range r.c.Fetch {
 		for _, c := range req.Commands {
-			if !spec.Match(c.Name) {
+			if !spec.Match(c.Name) || config.Excluded(r.c.Fetch, c.Name) {
 				continue
 			}
 
@@ -380,30 +595,163 @@ func (r *Remote) updateRemoteReferenceStorage(
 // their histories.
 //
 // Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
-// no changes to be fetched, or an error.
+// no changes to be fetched, or an error. As git fetch succeeds on an empty
+// remote repository, NoErrAlreadyUpToDate is returned for it too, unlike
+// Worktree.Pull which has nothing to merge and returns
+// transport.ErrEmptyRemoteRepository.
+//
+// The fetched references are recorded in FETCH_HEAD, as git fetch does, the
+// ones to be merged first: all the ones matched by the given refspecs, or
+// the upstream of the current branch when the refspecs of the remote are used.
 //
 // The provided Context must be non-nil. If the context expires before the
 // operation is complete, an error is returned. The context only affects the
 // transport operations.
 func (r *Remote) FetchContext(ctx context.Context, o *FetchOptions) error {
-	_, err := r.fetch(ctx, o)
+	explicit := len(o.RefSpecs) != 0
+	remoteRefs, err := r.fetch(ctx, o)
+	if err != nil && err != NoErrAlreadyUpToDate {
+		return err
+	}
+
+	forMerge, ferr := r.fetchHeadMerge(explicit, len(o.RefSpecs))
+	if ferr != nil {
+		return ferr
+	}
+
+	if ferr := r.writeFetchHead(o, remoteRefs, forMerge); ferr != nil {
+		return ferr
+	}
+
 	return err
 }
 
+// fetchHeadMerge returns whether a reference matched by the refspec at the
+// given index is to be merged: all the ones matched by explicit refspecs, not
+// the tags fetched along, or else the upstream of the current branch if it is
+// on this remote.
+func (r *Remote) fetchHeadMerge(explicit bool, specs int) (func(int, plumbing.ReferenceName) bool, error) {
+	if explicit {
+		return func(i int, _ plumbing.ReferenceName) bool { return i < specs }, nil
+	}
+
+	none := func(int, plumbing.ReferenceName) bool { return false }
+	head, err := r.s.Reference(plumbing.HEAD)
+	if err != nil || head.Type() != plumbing.SymbolicReference || !head.Target().IsBranch() {
+		return none, nil
+	}
+
+	cfg, err := r.s.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	b, ok := cfg.Branches[head.Target().Short()]
+	if !ok || b.Remote != r.c.Name || b.Merge == "" {
+		return none, nil
+	}
+
+	return func(_ int, name plumbing.ReferenceName) bool { return name == b.Merge }, nil
+}
+
+// writeFetchHead writes the references matched by the refspecs of a fetch in
+// FETCH_HEAD, if the storer supports it.
+func (r *Remote) writeFetchHead(
+	o *FetchOptions, remoteRefs storer.ReferenceStorer, forMerge func(int, plumbing.ReferenceName) bool,
+) error {
+	fs, ok := r.s.(storer.FetchHeadStorer)
+	if !ok || remoteRefs == nil {
+		return nil
+	}
+
+	_, specToRefs, err := calculateRefs(o.RefSpecs, remoteRefs, o.Tags)
+	if err != nil {
+		return err
+	}
+
+	url := fetchHeadURL(o.RemoteURL)
+	seen := make(map[plumbing.ReferenceName]bool)
+	var merge, notForMerge []storer.FetchHead
+	for i, refs := range specToRefs {
+		for _, ref := range refs {
+			if seen[ref.Name()] {
+				continue
+			}
+
+			seen[ref.Name()] = true
+			head := storer.FetchHead{Hash: ref.Hash(), Description: fetchHeadDescription(ref.Name(), url)}
+			if forMerge(i, ref.Name()) {
+				merge = append(merge, head)
+				continue
+			}
+
+			head.NotForMerge = true
+			notForMerge = append(notForMerge, head)
+		}
+	}
+
+	return fs.SetFetchHead(append(merge, notForMerge...))
+}
+
+// fetchHeadDescription returns the description of a fetched reference in
+// FETCH_HEAD, such as "branch 'master' of https://example.com/repo".
+func fetchHeadDescription(name plumbing.ReferenceName, url string) string {
+	switch {
+	case name == plumbing.HEAD:
+		return url
+	case name.IsBranch():
+		return fmt.Sprintf("branch '%s' of %s", name.Short(), url)
+	case name.IsTag():
+		return fmt.Sprintf("tag '%s' of %s", name.Short(), url)
+	case name.IsRemote():
+		return fmt.Sprintf("remote-tracking branch '%s' of %s", name.Short(), url)
+	default:
+		return fmt.Sprintf("'%s' of %s", name, url)
+	}
+}
+
+// fetchHeadURL returns the url of a remote as written in FETCH_HEAD, without
+// its credentials, trailing slashes and .git suffix, as git does.
+func fetchHeadURL(url string) string {
+	if i := strings.Index(url, "://"); i != -1 {
+		rest := url[i+3:]
+		host, _, _ := strings.Cut(rest, "/")
+		if at := strings.LastIndex(host, "@"); at != -1 {
+			url = url[:i+3] + rest[at+1:]
+		}
+	}
+
+	url = strings.TrimRight(url, "/")
+	return strings.TrimSuffix(url, ".git")
+}
+
 // Fetch fetches references along with the objects necessary to complete their
 // histories.
 //
 // Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
-// no changes to be fetched, or an error.
+// no changes to be fetched, as for an empty remote repository, or an error.
 func (r *Remote) Fetch(o *FetchOptions) error {
 	return r.FetchContext(context.Background(), o)
 }
 
-func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.ReferenceStorer, err error) {
+func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (storer.ReferenceStorer, error) {
+	if o.Resume != nil {
+		return r.fetchResuming(ctx, o)
+	}
+
+	return r.fetchOnce(ctx, o, nil)
+}
+
+// fetchOnce performs a fetch, keeping the packfile received in fr if not nil.
+func (r *Remote) fetchOnce(ctx context.Context, o *FetchOptions, fr *fetchResume) (sto storer.ReferenceStorer, err error) {
 	if o.RemoteName == "" {
 		o.RemoteName = r.c.Name
 	}
 
+	if o.Tags == InvalidTagMode {
+		o.Tags = r.tagMode()
+	}
+
 	if err = o.Validate(); err != nil {
 		return nil, err
 	}
@@ -416,7 +764,7 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		o.RemoteURL = r.c.URLs[0]
 	}
 
-	s, err := newUploadPackSession(o.RemoteURL, o.Auth, o.InsecureSkipTLS, o.CABundle, o.ProxyOptions)
+	s, err := newUploadPackSession(o.RemoteURL, o.Auth, o.InsecureSkipTLS, o.CABundle, o.ProxyOptions, o.Timeouts, r.repositoryConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -424,6 +772,10 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 	defer ioutil.CheckClose(s, &err)
 
 	ar, err := s.AdvertisedReferencesContext(ctx)
+	if err == transport.ErrEmptyRemoteRepository {
+		ar, err = emptyAdvertisedReferences(s)
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -460,20 +812,58 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 	}
 
 	req.Wants, err = getWants(r.s, refs, o.Depth)
+	if fr != nil {
+		if err = fr.prepare(req, ar.Capabilities, refs); err != nil {
+			return nil, err
+		}
+	}
+
+	unkeep := func() error { return nil }
 	if len(req.Wants) > 0 {
-		req.Haves, err = getHaves(localRefs, remoteRefs, r.s, o.Depth)
+		haveRefs, err := r.haveReferences(localRefs)
 		if err != nil {
 			return nil, err
 		}
 
-		if err = r.fetchPack(ctx, o, s, req); err != nil {
+		var n transport.Negotiator
+		if canNegotiate(s, req) {
+			advertised, err := getRemoteRefsFromStorer(remoteRefs)
+			if err != nil {
+				return nil, err
+			}
+
+			n = newHavesNegotiator(r.s, haveRefs, advertised)
+		} else {
+			req.Haves, err = getHaves(haveRefs, remoteRefs, r.s, o.Depth)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if err = r.checkPrerequisites(ctx, s); err != nil {
+			return nil, err
+		}
+
+		if ls, ok := s.(transport.LocalStorageSession); ok {
+			ls.SetLocalStorage(r.s)
+		}
+
+		if unkeep, err = r.fetchPack(ctx, o, s, req, n, fr); err != nil {
 			return nil, err
 		}
 	}
 
+	// The packfile is kept from the repacks until the references pointing
+	// to its objects are updated.
+	defer func() {
+		if uerr := unkeep(); err == nil {
+			err = uerr
+		}
+	}()
+
 	var updatedPrune bool
-	if o.Prune {
-		updatedPrune, err = r.pruneRemotes(o.RefSpecs, localRefs, remoteRefs)
+	if specs := pruneRefSpecs(o); len(specs) != 0 {
+		updatedPrune, err = r.pruneRemotes(specs, o.RefSpecs, localRefs, remoteRefs)
 		if err != nil {
 			return nil, err
 		}
@@ -509,6 +899,51 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 	return remoteRefs, nil
 }
 
+// emptyAdvertisedReferences returns the references of the empty repository
+// of the session: none, but the unborn branch HEAD points to, if the session
+// knows it. Nothing is fetched from an empty repository, as git does.
+func emptyAdvertisedReferences(s transport.UploadPackSession) (*packp.AdvRefs, error) {
+	ar := packp.NewAdvRefs()
+	us, ok := s.(transport.UnbornHeadSession)
+	if !ok || us.UnbornHead() == "" {
+		return ar, nil
+	}
+
+	return ar, ar.AddReference(plumbing.NewSymbolicReference(plumbing.HEAD, us.UnbornHead()))
+}
+
+// checkPrerequisites verifies that the objects the packfile served by the
+// session depends on, if any, are present in the storage.
+func (r *Remote) checkPrerequisites(ctx context.Context, s transport.UploadPackSession) error {
+	ps, ok := s.(transport.PrerequisitesSession)
+	if !ok {
+		return nil
+	}
+
+	hashes, err := ps.Prerequisites(ctx)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, h := range hashes {
+		exists, err := objectExists(r.s, h)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			missing = append(missing, h.String())
+		}
+	}
+
+	if len(missing) != 0 {
+		return fmt.Errorf("%w: %s", transport.ErrMissingPrerequisites, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
 func depthChanged(before []plumbing.Hash, s storage.Storer) (bool, error) {
 	after, err := s.Shallow()
 	if err != nil {
@@ -532,8 +967,12 @@ func depthChanged(before []plumbing.Hash, s storage.Storer) (bool, error) {
 	return false, nil
 }
 
-func newUploadPackSession(url string, auth transport.AuthMethod, insecure bool, cabundle []byte, proxyOpts transport.ProxyOptions) (transport.UploadPackSession, error) {
-	c, ep, err := newClient(url, insecure, cabundle, proxyOpts)
+func newUploadPackSession(url string, auth transport.AuthMethod, insecure bool, cabundle []byte, proxyOpts transport.ProxyOptions, timeouts transport.Timeouts, cfg *config.Config) (transport.UploadPackSession, error) {
+	if cfg != nil {
+		url = cfg.ApplyInsteadOf(url)
+	}
+
+	c, ep, err := newClient(url, insecure, cabundle, proxyOpts, timeouts, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -541,8 +980,8 @@ func newUploadPackSession(url string, auth transport.AuthMethod, insecure bool,
 	return c.NewUploadPackSession(ep, auth)
 }
 
-func newSendPackSession(url string, auth transport.AuthMethod, insecure bool, cabundle []byte, proxyOpts transport.ProxyOptions) (transport.ReceivePackSession, error) {
-	c, ep, err := newClient(url, insecure, cabundle, proxyOpts)
+func newSendPackSession(url string, auth transport.AuthMethod, insecure bool, cabundle []byte, proxyOpts transport.ProxyOptions, timeouts transport.Timeouts, cfg *config.Config) (transport.ReceivePackSession, error) {
+	c, ep, err := newClient(url, insecure, cabundle, proxyOpts, timeouts, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -550,7 +989,10 @@ func newSendPackSession(url string, auth transport.AuthMethod, insecure bool, ca
 	return c.NewReceivePackSession(ep, auth)
 }
 
-func newClient(url string, insecure bool, cabundle []byte, proxyOpts transport.ProxyOptions) (transport.Transport, *transport.Endpoint, error) {
+// newClient returns the transport client for the given url. The repository
+// config, if not nil, is used to honor the settings changing which client
+// is used, such as core.sshCommand.
+func newClient(url string, insecure bool, cabundle []byte, proxyOpts transport.ProxyOptions, timeouts transport.Timeouts, cfg *config.Config) (transport.Transport, *transport.Endpoint, error) {
 	ep, err := transport.NewEndpoint(url)
 	if err != nil {
 		return nil, nil, err
@@ -558,6 +1000,17 @@ func newClient(url string, insecure bool, cabundle []byte, proxyOpts transport.P
 	ep.InsecureSkipTLS = insecure
 	ep.CaBundle = cabundle
 	ep.Proxy = proxyOpts
+	ep.Timeouts = timeouts
+
+	if ep.Protocol == "ssh" && useExternalSSH(cfg) {
+		var o ssh.ExternalOptions
+		if cfg != nil {
+			o.Command = cfg.Core.SSHCommand
+			o.Variant = cfg.SSH.Variant
+		}
+
+		return ssh.NewExternalClient(o), ep, nil
+	}
 
 	c, err := client.NewClient(ep)
 	if err != nil {
@@ -567,42 +1020,167 @@ func newClient(url string, insecure bool, cabundle []byte, proxyOpts transport.P
 	return c, ep, err
 }
 
-func (r *Remote) fetchPack(ctx context.Context, o *FetchOptions, s transport.UploadPackSession,
-	req *packp.UploadPackRequest) (err error) {
-
-	reader, err := s.UploadPack(ctx, req)
-	if err != nil {
-		if errors.Is(err, transport.ErrEmptyUploadPackRequest) {
-			// XXX: no packfile provided, everything is up-to-date.
-			return nil
-		}
-		return err
+// useExternalSSH returns whether the ssh remotes are reached with an external
+// ssh program, as git does when core.sshCommand or GIT_SSH_COMMAND is set.
+func useExternalSSH(cfg *config.Config) bool {
+	if os.Getenv("GIT_SSH_COMMAND") != "" {
+		return true
 	}
 
-	defer ioutil.CheckClose(reader, &err)
+	return cfg != nil && cfg.Core.SSHCommand != ""
+}
 
-	if err = r.updateShallow(o, reader); err != nil {
-		return err
+// repositoryConfig returns the config of the repository the remote belongs
+// to, or nil if it is not available.
+func (r *Remote) repositoryConfig() *config.Config {
+	if r.s == nil {
+		return nil
 	}
 
-	if err = packfile.UpdateObjectStorage(r.s,
-		buildSidebandIfSupported(req.Capabilities, reader, o.Progress),
-	); err != nil {
-		return err
+	cfg, err := r.s.Config()
+	if err != nil {
+		return nil
 	}
 
-	return err
+	return cfg
 }
 
-func (r *Remote) pruneRemotes(specs []config.RefSpec, localRefs []*plumbing.Reference, remoteRefs memory.ReferenceStorage) (bool, error) {
-	var updatedPrune bool
-	for _, spec := range specs {
+// canNegotiate returns whether the haves of the request can be negotiated
+// with the server in several rounds, instead of sending them all at once.
+func canNegotiate(s transport.UploadPackSession, req *packp.UploadPackRequest) bool {
+	_, ok := s.(transport.NegotiatorSession)
+	return ok && req.Capabilities.Supports(capability.MultiACKDetailed) && req.Depth.IsZero()
+}
+
+// fetchPack requests the packfile of the wants, taking the haves from the
+// negotiator if not nil. The packfile received is kept in fr if not nil.
+//
+// The packfile is written along with a .keep file if the storage supports it,
+// the returned function removes it.
+func (r *Remote) fetchPack(ctx context.Context, o *FetchOptions, s transport.UploadPackSession,
+	req *packp.UploadPackRequest, n transport.Negotiator, fr *fetchResume) (unkeep func() error, err error) {
+	unkeep = func() error { return nil }
+
+	var reader *packp.UploadPackResponse
+	if n != nil {
+		reader, err = s.(transport.NegotiatorSession).NegotiateUploadPack(ctx, req, n)
+	} else {
+		reader, err = s.UploadPack(ctx, req)
+	}
+
+	if err != nil {
+		if errors.Is(err, transport.ErrEmptyUploadPackRequest) {
+			// XXX: no packfile provided, everything is up-to-date.
+			return unkeep, nil
+		}
+		return unkeep, err
+	}
+
+	defer ioutil.CheckClose(reader, &err)
+
+	if err = r.updateShallow(o, reader); err != nil {
+		return unkeep, err
+	}
+
+	pack := buildSidebandIfSupported(req.Capabilities, reader, o.Progress)
+
+	var observers []packfile.Observer
+	if o.ProgressHandler != nil {
+		progress := &packProgress{handler: o.ProgressHandler}
+		pack = progress.reader(pack)
+		observers = append(observers, progress)
+	}
+
+	if fr != nil {
+		w, err := fr.begin()
+		if err != nil {
+			return unkeep, err
+		}
+
+		pack = io.TeeReader(pack, w)
+	}
+
+	unkeep, err = packfile.UpdateObjectStorageKept(r.s, pack, keepReason("fetch-pack"), observers...)
+	if err != nil {
+		// The server may abort while sending the packfile, with a message,
+		// the transfer is not to be resumed then.
+		if serr := transport.NewServerError(nil, err); serr != err {
+			return unkeep, serr
+		}
+
+		if fr != nil {
+			fr.interrupted = true
+		}
+
+		return unkeep, err
+	}
+
+	return unkeep, err
+}
+
+// keepReason returns the reason written in the .keep files of the packfiles
+// received by the given command, as git writes it.
+func keepReason(cmd string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+
+	return fmt.Sprintf("%s %d on %s", cmd, os.Getpid(), host)
+}
+
+// tagMode returns the tag mode set by the remote.<name>.tagOpt config option,
+// InvalidTagMode if not set.
+func (r *Remote) tagMode() TagMode {
+	switch r.c.TagOpt {
+	case "--no-tags":
+		return NoTags
+	case "--tags":
+		return AllTags
+	default:
+		return InvalidTagMode
+	}
+}
+
+// pruneRefSpecs returns the refspecs whose stale local refs are pruned by the
+// fetch. PruneTags adds the refspec of all the tags, unless tags are not being
+// fetched at all.
+func pruneRefSpecs(o *FetchOptions) []config.RefSpec {
+	var specs []config.RefSpec
+	if o.Prune {
+		specs = append(specs, o.RefSpecs...)
+	}
+
+	if !o.PruneTags || o.Tags == NoTags {
+		return specs
+	}
+
+	for _, spec := range specs {
+		if spec == refspecAllTags {
+			return specs
+		}
+	}
+
+	return append(specs, refspecAllTags)
+}
+
+func (r *Remote) pruneRemotes(specs, exclude []config.RefSpec, localRefs []*plumbing.Reference, remoteRefs memory.ReferenceStorage) (bool, error) {
+	var updatedPrune bool
+	for _, spec := range specs {
 		rev := spec.Reverse()
 		for _, ref := range localRefs {
-			if !rev.Match(ref.Name()) {
+			// As git, the symbolic references, such as the HEAD of the
+			// remote, are not pruned.
+			if !rev.Match(ref.Name()) || ref.Type() == plumbing.SymbolicReference {
 				continue
 			}
-			_, err := remoteRefs.Reference(rev.Dst(ref.Name()))
+
+			remoteName := rev.Dst(ref.Name())
+			if config.Excluded(exclude, remoteName) {
+				continue
+			}
+
+			_, err := remoteRefs.Reference(remoteName)
 			if errors.Is(err, plumbing.ErrReferenceNotFound) {
 				updatedPrune = true
 				err := r.s.RemoveReference(ref.Name())
@@ -623,25 +1201,37 @@ func (r *Remote) addReferencesToUpdate(
 	prune bool,
 	forceWithLease *ForceWithLease,
 ) error {
+	// The references excluded by the negative refspecs are never pushed.
+	var included []*plumbing.Reference
+	for _, ref := range localRefs {
+		if !config.Excluded(refspecs, ref.Name()) {
+			included = append(included, ref)
+		}
+	}
+
 	// This references dictionary will be used to search references by name.
 	refsDict := make(map[string]*plumbing.Reference)
-	for _, ref := range localRefs {
+	for _, ref := range included {
 		refsDict[ref.Name().String()] = ref
 	}
 
 	for _, rs := range refspecs {
+		if rs.IsNegative() {
+			continue
+		}
+
 		if rs.IsDelete() {
-			if err := r.deleteReferences(rs, remoteRefs, refsDict, req, false); err != nil {
+			if err := r.deleteReferences(rs, refspecs, remoteRefs, refsDict, req, false); err != nil {
 				return err
 			}
 		} else {
-			err := r.addOrUpdateReferences(rs, localRefs, refsDict, remoteRefs, req, forceWithLease)
+			err := r.addOrUpdateReferences(rs, included, refsDict, remoteRefs, req, forceWithLease)
 			if err != nil {
 				return err
 			}
 
 			if prune {
-				if err := r.deleteReferences(rs, remoteRefs, refsDict, req, true); err != nil {
+				if err := r.deleteReferences(rs, refspecs, remoteRefs, refsDict, req, true); err != nil {
 					return err
 				}
 			}
@@ -685,6 +1275,7 @@ func (r *Remote) addOrUpdateReferences(
 }
 
 func (r *Remote) deleteReferences(rs config.RefSpec,
+	refspecs []config.RefSpec,
 	remoteRefs storer.ReferenceStorer,
 	refsDict map[string]*plumbing.Reference,
 	req *packp.ReferenceUpdateRequest,
@@ -705,7 +1296,8 @@ func (r *Remote) deleteReferences(rs config.RefSpec,
 				return nil
 			}
 
-			if _, ok := refsDict[rs.Dst(ref.Name()).String()]; ok {
+			local := rs.Dst(ref.Name())
+			if _, ok := refsDict[local.String()]; ok || config.Excluded(refspecs, local) {
 				return nil
 			}
 		} else if rs.Dst("") != ref.Name() {
@@ -856,6 +1448,26 @@ func (r *Remote) references() ([]*plumbing.Reference, error) {
 	return localRefs, nil
 }
 
+// haveReferences returns the given local references, and the references of
+// the alternates of the storage, as their objects don't need to be fetched.
+func (r *Remote) haveReferences(localRefs []*plumbing.Reference) ([]*plumbing.Reference, error) {
+	type alternateReferencer interface {
+		AlternateReferences() ([]*plumbing.Reference, error)
+	}
+
+	ar, ok := r.s.(alternateReferencer)
+	if !ok {
+		return localRefs, nil
+	}
+
+	altRefs, err := ar.AlternateReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(localRefs[:len(localRefs):len(localRefs)], altRefs...), nil
+}
+
 func getRemoteRefsFromStorer(remoteRefStorer storer.ReferenceStorer) (
 	map[plumbing.Hash]bool, error) {
 	remoteRefs := map[plumbing.Hash]bool{}
@@ -979,8 +1591,12 @@ func calculateRefs(
 	// list of references matched for each spec
 	specToRefs := make([][]*plumbing.Reference, len(spec))
 	for i := range spec {
+		if spec[i].IsNegative() {
+			continue
+		}
+
 		var err error
-		specToRefs[i], err = doCalculateRefs(spec[i], remoteRefs, refs)
+		specToRefs[i], err = doCalculateRefs(spec[i], spec, remoteRefs, refs)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -991,6 +1607,7 @@ func calculateRefs(
 
 func doCalculateRefs(
 	s config.RefSpec,
+	specs []config.RefSpec,
 	remoteRefs storer.ReferenceStorer,
 	refs memory.ReferenceStorage,
 ) ([]*plumbing.Reference, error) {
@@ -1005,6 +1622,10 @@ func doCalculateRefs(
 
 	var matched bool
 	onMatched := func(ref *plumbing.Reference) error {
+		if config.Excluded(specs, ref.Name()) {
+			return nil
+		}
+
 		if ref.Type() == plumbing.SymbolicReference {
 			target, err := storer.ResolveReference(remoteRefs, ref.Name())
 			if err != nil {
@@ -1171,7 +1792,7 @@ func (r *Remote) newUploadPackRequest(o *FetchOptions,
 
 	isWildcard := true
 	for _, s := range o.RefSpecs {
-		if !s.IsWildcard() {
+		if !s.IsWildcard() && !s.IsNegative() {
 			isWildcard = false
 			break
 		}
@@ -1235,7 +1856,7 @@ func (r *Remote) updateLocalReferenceStorage(
 	forceNeeded := false
 
 	for i, spec := range specs {
-		if !spec.IsWildcard() {
+		if !spec.IsWildcard() && !spec.IsNegative() {
 			isWildcard = false
 		}
 
@@ -1285,7 +1906,7 @@ func (r *Remote) updateLocalReferenceStorage(
 	if isWildcard {
 		tags = remoteRefs
 	}
-	tagUpdated, err := r.buildFetchedTags(tags)
+	tagUpdated, err := r.buildFetchedTags(tags, specs)
 	if err != nil {
 		return updated, err
 	}
@@ -1301,9 +1922,9 @@ func (r *Remote) updateLocalReferenceStorage(
 	return
 }
 
-func (r *Remote) buildFetchedTags(refs memory.ReferenceStorage) (updated bool, err error) {
+func (r *Remote) buildFetchedTags(refs memory.ReferenceStorage, specs []config.RefSpec) (updated bool, err error) {
 	for _, ref := range refs {
-		if !ref.Name().IsTag() {
+		if !ref.Name().IsTag() || config.Excluded(specs, ref.Name()) {
 			continue
 		}
 
@@ -1338,37 +1959,16 @@ func (r *Remote) ListContext(ctx context.Context, o *ListOptions) (rfs []*plumbi
 }
 
 func (r *Remote) List(o *ListOptions) (rfs []*plumbing.Reference, err error) {
-	timeout := o.Timeout
-	// Default to the old hardcoded 10s value if a timeout is not explicitly set.
-	if timeout == 0 {
-		timeout = 10
-	}
-	if timeout < 0 {
-		return nil, fmt.Errorf("invalid timeout: %d", timeout)
+	ctx, cancel, err := o.timeoutContext()
+	if err != nil {
+		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
 	defer cancel()
 	return r.ListContext(ctx, o)
 }
 
 func (r *Remote) list(ctx context.Context, o *ListOptions) (rfs []*plumbing.Reference, err error) {
-	if r.c == nil || len(r.c.URLs) == 0 {
-		return nil, ErrEmptyUrls
-	}
-
-	s, err := newUploadPackSession(r.c.URLs[0], o.Auth, o.InsecureSkipTLS, o.CABundle, o.ProxyOptions)
-	if err != nil {
-		return nil, err
-	}
-
-	defer ioutil.CheckClose(s, &err)
-
-	ar, err := s.AdvertisedReferencesContext(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	allRefs, err := ar.AllReferences()
+	ar, allRefs, err := r.advertisedReferences(ctx, o)
 	if err != nil {
 		return nil, err
 	}
@@ -1378,10 +1978,14 @@ func (r *Remote) list(ctx context.Context, o *ListOptions) (rfs []*plumbing.Refe
 		return nil, err
 	}
 
+	match := newListMatcher(o.Patterns)
+
 	var resultRefs []*plumbing.Reference
 	if o.PeelingOption == AppendPeeled || o.PeelingOption == IgnorePeeled {
 		err = refs.ForEach(func(ref *plumbing.Reference) error {
-			resultRefs = append(resultRefs, ref)
+			if match(ref.Name().String()) {
+				resultRefs = append(resultRefs, ref)
+			}
 			return nil
 		})
 		if err != nil {
@@ -1391,13 +1995,226 @@ func (r *Remote) list(ctx context.Context, o *ListOptions) (rfs []*plumbing.Refe
 
 	if o.PeelingOption == AppendPeeled || o.PeelingOption == OnlyPeeled {
 		for k, v := range ar.Peeled {
-			resultRefs = append(resultRefs, plumbing.NewReferenceFromStrings(k+"^{}", v.String()))
+			if match(k) {
+				resultRefs = append(resultRefs, plumbing.NewReferenceFromStrings(k+peeledSuffix, v.String()))
+			}
 		}
 	}
 
 	return resultRefs, nil
 }
 
+// RemoteReference is a reference of a remote, as listed by `git ls-remote`.
+type RemoteReference struct {
+	// Name is the name of the reference.
+	Name plumbing.ReferenceName
+	// Hash is the object the reference points to, the one of its target for
+	// a symbolic reference.
+	Hash plumbing.Hash
+	// Target is the reference a symbolic reference, such as HEAD, points to.
+	// It is empty for the other references.
+	Target plumbing.ReferenceName
+	// Peeled is the object an annotated tag points to, following the tags.
+	// It is zero for the other references.
+	Peeled plumbing.Hash
+}
+
+// ListReferences lists the references of the remote, with the target of the
+// symbolic references, such as the branch HEAD points to, and the object the
+// annotated tags point to, as `git ls-remote --symref` does. HEAD comes
+// first, followed by the other references sorted by name. The PeelingOption
+// of the options is ignored.
+func (r *Remote) ListReferences(o *ListOptions) ([]*RemoteReference, error) {
+	ctx, cancel, err := o.timeoutContext()
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	return r.ListReferencesContext(ctx, o)
+}
+
+// ListReferencesContext lists the references of the remote, as
+// ListReferences does.
+//
+// The provided Context must be non-nil. If the context expires before the
+// operation is complete, an error is returned. The context only affects the
+// transport operations.
+func (r *Remote) ListReferencesContext(ctx context.Context, o *ListOptions) ([]*RemoteReference, error) {
+	ar, allRefs, err := r.advertisedReferences(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	match := newListMatcher(o.Patterns)
+
+	var result []*RemoteReference
+	for _, ref := range allRefs {
+		if !match(ref.Name().String()) {
+			continue
+		}
+
+		rr := &RemoteReference{Name: ref.Name(), Hash: ref.Hash()}
+		if ref.Type() == plumbing.SymbolicReference {
+			rr.Target = ref.Target()
+			resolved, err := storer.ResolveReference(allRefs, ref.Name())
+			switch err {
+			case nil:
+				rr.Hash = resolved.Hash()
+			case plumbing.ErrReferenceNotFound:
+				// The target of HEAD is an unborn branch.
+			default:
+				return nil, err
+			}
+		}
+
+		if peeled, ok := ar.Peeled[ref.Name().String()]; ok {
+			rr.Peeled = peeled
+		}
+
+		result = append(result, rr)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name == plumbing.HEAD || result[j].Name == plumbing.HEAD {
+			return result[i].Name == plumbing.HEAD && result[j].Name != plumbing.HEAD
+		}
+
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// Head returns the branch the HEAD of the remote points to, its default
+// branch, as `git ls-remote --symref <remote> HEAD` shows. It is advertised
+// with the symref capability, or guessed from the branches with the hash of
+// HEAD for the servers not advertising it, as git does.
+func (r *Remote) Head(o *ListOptions) (plumbing.ReferenceName, error) {
+	ctx, cancel, err := o.timeoutContext()
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+	return r.HeadContext(ctx, o)
+}
+
+// HeadContext returns the branch the HEAD of the remote points to, as Head
+// does.
+//
+// The provided Context must be non-nil. If the context expires before the
+// operation is complete, an error is returned. The context only affects the
+// transport operations.
+func (r *Remote) HeadContext(ctx context.Context, o *ListOptions) (plumbing.ReferenceName, error) {
+	_, refs, err := r.advertisedReferences(ctx, o)
+	if err != nil {
+		return "", err
+	}
+
+	return remoteHead(refs)
+}
+
+// remoteHead returns the branch HEAD points to in the references of a
+// remote.
+func remoteHead(refs storer.ReferenceStorer) (plumbing.ReferenceName, error) {
+	head, err := refs.Reference(plumbing.HEAD)
+	if err == plumbing.ErrReferenceNotFound {
+		return "", ErrRemoteHeadNotFound
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if head.Type() != plumbing.SymbolicReference || !head.Target().IsBranch() {
+		return "", ErrRemoteHeadNotFound
+	}
+
+	return head.Target(), nil
+}
+
+// advertisedReferences returns the references advertised by the remote.
+func (r *Remote) advertisedReferences(ctx context.Context, o *ListOptions) (ar *packp.AdvRefs, refs memory.ReferenceStorage, err error) {
+	if r.c == nil || len(r.c.URLs) == 0 {
+		return nil, nil, ErrEmptyUrls
+	}
+
+	s, err := newUploadPackSession(r.c.URLs[0], o.Auth, o.InsecureSkipTLS, o.CABundle, o.ProxyOptions, transport.Timeouts{}, r.repositoryConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer ioutil.CheckClose(s, &err)
+
+	ar, err = s.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refs, err = ar.AllReferences()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ar, refs, nil
+}
+
+// newListMatcher returns a function matching the names of the references
+// with the given patterns, as `git ls-remote` does: a pattern matches the
+// end of a name, after a slash, as "main" matches "refs/heads/main", and its
+// wildcards match slashes too. Without patterns, every name matches.
+func newListMatcher(patterns []string) func(name string) bool {
+	if len(patterns) == 0 {
+		return func(string) bool { return true }
+	}
+
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		res = append(res, regexp.MustCompile("^(?:.*/)?"+globToRegexp(p)+"$"))
+	}
+
+	return func(name string) bool {
+		for _, re := range res {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// globToRegexp translates the wildcards of the given pattern, which match
+// slashes, to a regular expression.
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end <= 0 {
+				b.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+
+	return b.String()
+}
+
 func objectsToPush(commands []*packp.Command) []plumbing.Hash {
 	objects := make([]plumbing.Hash, 0, len(commands))
 	for _, cmd := range commands {
@@ -1438,7 +2255,9 @@ func pushHashes(
 	req *packp.ReferenceUpdateRequest,
 	hs []plumbing.Hash,
 	useRefDeltas bool,
+	thin bool,
 	allDelete bool,
+	progress ProgressHandler,
 ) (*packp.ReportStatus, error) {
 	rd, wr := io.Pipe()
 
@@ -1456,7 +2275,19 @@ func pushHashes(
 		req.Packfile = rd
 		go func() {
 			e := packfile.NewEncoder(wr, s, useRefDeltas)
-			if _, err := e.Encode(hs, config.Pack.Window); err != nil {
+			window, err := setPushDeltaOptions(e, s, config, hs, thin)
+			if err != nil {
+				done <- wr.CloseWithError(err)
+				return
+			}
+
+			if progress != nil {
+				e.SetProgress(func(written, total int, size int64) {
+					progress(ProgressEvent{Phase: ProgressWritingObjects, Done: written, Total: total, Bytes: size})
+				})
+			}
+
+			if _, err := e.Encode(hs, window); err != nil {
 				done <- wr.CloseWithError(err)
 				return
 			}
@@ -1481,6 +2312,249 @@ func pushHashes(
 	return rs, nil
 }
 
+// setPushDeltaOptions sets the delta options of the encoder of the packfile
+// of a push from the pack configuration, and returns the window to use. The
+// objects are named after their paths, and if thin is true, the objects of
+// the pushed commits parents the remote has, at the same paths, are used as
+// delta bases, as git does. No delta is searched for small pushes.
+// encodedPackSize returns the size of the packfile of the given objects, as
+// encoded by pushHashes.
+func encodedPackSize(s storage.Storer, hs []plumbing.Hash, useRefDeltas, thin bool) (int64, error) {
+	config, err := s.Config()
+	if err != nil {
+		return 0, err
+	}
+
+	w := &countingWriter{w: io.Discard}
+	e := packfile.NewEncoder(w, s, useRefDeltas)
+	window, err := setPushDeltaOptions(e, s, config, hs, thin)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := e.Encode(hs, window); err != nil {
+		return 0, err
+	}
+
+	return w.n, nil
+}
+
+// countingWriter is a writer counting the bytes written to it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func setPushDeltaOptions(
+	e *packfile.Encoder,
+	s storer.EncodedObjectStorer,
+	cfg *config.Config,
+	hs []plumbing.Hash,
+	thin bool,
+) (uint, error) {
+	if cfg.Pack.Window == 0 {
+		return 0, nil
+	}
+
+	var size int64
+	for _, h := range hs {
+		n, err := s.EncodedObjectSize(h)
+		if err != nil {
+			return 0, err
+		}
+
+		if size += n; size >= smallPushSize {
+			break
+		}
+	}
+
+	if size < smallPushSize {
+		return 0, nil
+	}
+
+	e.SetMaxDeltaDepth(cfg.Pack.Depth)
+	e.SetThreads(int(cfg.Pack.Threads))
+
+	ph := &pushDeltaHints{
+		s:         s,
+		pushed:    make(map[plumbing.Hash]bool, len(hs)),
+		names:     make(map[plumbing.Hash]string),
+		edges:     make(map[plumbing.Hash]bool),
+		maxEdges:  int(cfg.Pack.Window),
+		thin:      thin,
+		baseNames: make(map[plumbing.Hash]string),
+	}
+
+	for _, h := range hs {
+		ph.pushed[h] = true
+	}
+
+	for _, h := range hs {
+		c, err := object.GetCommit(s, h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if err := ph.addCommit(c); err != nil {
+			return 0, err
+		}
+	}
+
+	for h, name := range ph.baseNames {
+		ph.names[h] = name
+	}
+
+	e.SetObjectNames(ph.names)
+	if thin {
+		e.SetThinPackBases(ph.bases)
+	}
+
+	return cfg.Pack.Window, nil
+}
+
+// pushDeltaHints gathers the paths of the pushed objects and the objects the
+// remote has at the same paths.
+type pushDeltaHints struct {
+	s         storer.EncodedObjectStorer
+	pushed    map[plumbing.Hash]bool
+	names     map[plumbing.Hash]string
+	edges     map[plumbing.Hash]bool
+	maxEdges  int
+	thin      bool
+	bases     []plumbing.Hash
+	baseNames map[plumbing.Hash]string
+}
+
+func (ph *pushDeltaHints) addCommit(c *object.Commit) error {
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	if err := ph.addTree(tree, ""); err != nil {
+		return err
+	}
+
+	if !ph.thin {
+		return nil
+	}
+
+	for _, p := range c.ParentHashes {
+		if ph.pushed[p] || ph.edges[p] || len(ph.edges) >= ph.maxEdges {
+			continue
+		}
+
+		ph.edges[p] = true
+		parent, err := object.GetCommit(ph.s, p)
+		if err == plumbing.ErrObjectNotFound {
+			// The parents of the shallow commits are missing.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		base, err := parent.Tree()
+		if err != nil {
+			return err
+		}
+
+		if err := ph.addBases(tree, base, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addTree names the pushed objects of the tree.
+func (ph *pushDeltaHints) addTree(t *object.Tree, dir string) error {
+	for _, e := range t.Entries {
+		if !ph.pushed[e.Hash] || ph.names[e.Hash] != "" {
+			continue
+		}
+
+		name := path.Join(dir, e.Name)
+		ph.names[e.Hash] = name
+		if e.Mode != filemode.Dir {
+			continue
+		}
+
+		subtree, err := object.GetTree(ph.s, e.Hash)
+		if err != nil {
+			return err
+		}
+
+		if err := ph.addTree(subtree, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addBases adds as delta bases the objects of base, not pushed, at the paths
+// of the pushed objects of t.
+func (ph *pushDeltaHints) addBases(t, base *object.Tree, dir string) error {
+	if ph.pushed[base.Hash] {
+		return nil
+	}
+
+	for _, e := range t.Entries {
+		if !ph.pushed[e.Hash] || e.Mode == filemode.Submodule {
+			continue
+		}
+
+		be, err := base.FindEntry(e.Name)
+		if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		isDir := e.Mode == filemode.Dir
+		if ph.pushed[be.Hash] || isDir != (be.Mode == filemode.Dir) || be.Mode == filemode.Submodule {
+			continue
+		}
+
+		name := path.Join(dir, e.Name)
+		if _, ok := ph.baseNames[be.Hash]; !ok {
+			ph.baseNames[be.Hash] = name
+			ph.bases = append(ph.bases, be.Hash)
+		}
+
+		if !isDir {
+			continue
+		}
+
+		subtree, err := object.GetTree(ph.s, e.Hash)
+		if err != nil {
+			return err
+		}
+
+		baseSubtree, err := object.GetTree(ph.s, be.Hash)
+		if err != nil {
+			return err
+		}
+
+		if err := ph.addBases(subtree, baseSubtree, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (r *Remote) updateShallow(o *FetchOptions, resp *packp.UploadPackResponse) error {
 	if o.Depth == 0 || len(resp.Shallows) == 0 {
 		return nil
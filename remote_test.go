@@ -6,9 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -20,10 +23,18 @@ import (
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
 	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/go-git/go-git/v5/storage/memory"
@@ -62,9 +73,31 @@ func (s *RemoteSuite) TestFetchOverriddenEndpoint(c *C) {
 	c.Assert(err, ErrorMatches, ".*invalid character.*")
 }
 
+func (s *RemoteSuite) TestNewClientExternalSSH(c *C) {
+	url := "ssh://git@github.com/git-fixtures/basic.git"
+	os.Unsetenv("GIT_SSH_COMMAND")
+
+	cl, _, err := newClient(url, false, nil, transport.ProxyOptions{}, transport.Timeouts{}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(cl, Equals, ssh.DefaultClient)
+
+	cfg := config.NewConfig()
+	cfg.Core.SSHCommand = "ssh -i key"
+	cl, _, err = newClient(url, false, nil, transport.ProxyOptions{}, transport.Timeouts{}, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(cl, Not(Equals), ssh.DefaultClient)
+
+	os.Setenv("GIT_SSH_COMMAND", "ssh -i key")
+	defer os.Unsetenv("GIT_SSH_COMMAND")
+
+	cl, _, err = newClient(url, false, nil, transport.ProxyOptions{}, transport.Timeouts{}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(cl, Not(Equals), ssh.DefaultClient)
+}
+
 func (s *RemoteSuite) TestFetchInvalidFetchOptions(c *C) {
 	r := NewRemote(nil, &config.RemoteConfig{Name: "foo", URLs: []string{"qux://foo"}})
-	invalid := config.RefSpec("^*$ñ")
+	invalid := config.RefSpec("*$ñ")
 	err := r.Fetch(&FetchOptions{RefSpecs: []config.RefSpec{invalid}})
 	c.Assert(err, Equals, config.ErrRefSpecMalformedSeparator)
 }
@@ -250,6 +283,62 @@ func (s *RemoteSuite) TestFetchContextCanceled(c *C) {
 	c.Assert(err, Equals, context.Canceled)
 }
 
+// silentGitServer returns the URL of a git:// server accepting the
+// connections without ever answering.
+func silentGitServer(c *C) (string, func()) {
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+
+	var conns []net.Conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			conns = append(conns, conn)
+		}
+	}()
+
+	return fmt.Sprintf("git://%s/repo", l.Addr()), func() {
+		_ = l.Close()
+		<-done
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}
+}
+
+func (s *RemoteSuite) TestFetchTimeouts(c *C) {
+	url, stop := silentGitServer(c)
+	defer stop()
+
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: DefaultRemoteName, URLs: []string{url}})
+	err := r.Fetch(&FetchOptions{
+		Timeouts: transport.Timeouts{Idle: 100 * time.Millisecond},
+	})
+
+	var terr *transport.TimeoutError
+	c.Assert(errors.As(err, &terr), Equals, true, Commentf("%v", err))
+	c.Assert(terr.Phase, Equals, transport.PhaseAdvertisement)
+}
+
+func (s *RemoteSuite) TestPushTimeouts(c *C) {
+	url, stop := silentGitServer(c)
+	defer stop()
+
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: DefaultRemoteName, URLs: []string{url}})
+	err := r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/*:refs/heads/*"},
+		Timeouts: transport.Timeouts{Operation: 100 * time.Millisecond},
+	})
+
+	c.Assert(errors.Is(err, transport.ErrTimeout), Equals, true, Commentf("%v", err))
+}
+
 func (s *RemoteSuite) TestFetchWithAllTags(c *C) {
 	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
 		URLs: []string{s.GetLocalRepositoryURL(fixtures.ByTag("tags").One())},
@@ -286,6 +375,132 @@ func (s *RemoteSuite) TestFetchWithNoTags(c *C) {
 
 }
 
+func (s *RemoteSuite) TestFetchWithTagOptConfig(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		URLs:   []string{s.GetLocalRepositoryURL(fixtures.ByTag("tags").One())},
+		TagOpt: "--no-tags",
+	})
+
+	s.testFetch(c, r, &FetchOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec("+refs/heads/*:refs/remotes/origin/*"),
+		},
+	}, []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("refs/remotes/origin/master", "f7b877701fbf855b44c0a9e86f3fdce2c298b07f"),
+	})
+}
+
+func (s *RemoteSuite) TestFetchWithTagOptConfigOverridden(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		URLs:   []string{s.GetLocalRepositoryURL(fixtures.ByTag("tags").One())},
+		TagOpt: "--no-tags",
+	})
+
+	s.testFetch(c, r, &FetchOptions{
+		Tags: AllTags,
+		RefSpecs: []config.RefSpec{
+			config.RefSpec("+refs/heads/master:refs/remotes/origin/master"),
+		},
+	}, []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("refs/remotes/origin/master", "f7b877701fbf855b44c0a9e86f3fdce2c298b07f"),
+		plumbing.NewReferenceFromStrings("refs/tags/annotated-tag", "b742a2a9fa0afcfa9a6fad080980fbc26b007c69"),
+		plumbing.NewReferenceFromStrings("refs/tags/tree-tag", "152175bf7e5580299fa1f0ba41ef6474cc043b70"),
+		plumbing.NewReferenceFromStrings("refs/tags/commit-tag", "ad7897c0fb8e7d9a9ba41fa66072cf06095a6cfc"),
+		plumbing.NewReferenceFromStrings("refs/tags/blob-tag", "fe6cb94756faa81e5ed9240f9191b833db5f40ae"),
+		plumbing.NewReferenceFromStrings("refs/tags/lightweight-tag", "f7b877701fbf855b44c0a9e86f3fdce2c298b07f"),
+	})
+}
+
+func (s *RemoteSuite) TestFetchWithNegativeRefSpec(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	s.testFetch(c, r, &FetchOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec("+refs/heads/*:refs/remotes/origin/*"),
+			config.RefSpec("^refs/heads/branch"),
+		},
+	}, []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("refs/remotes/origin/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewReferenceFromStrings("refs/tags/v1.0.0", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+}
+
+func (s *RemoteSuite) TestFetchAndPushInsteadOf(c *C) {
+	fetchURL := c.MkDir()
+	_, err := PlainClone(fetchURL, true, &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	pushURL := c.MkDir()
+	server, err := PlainInit(pushURL, true)
+	c.Assert(err, IsNil)
+
+	cfg := config.NewConfig()
+	cfg.URLs["/non-existent/"] = &config.URL{
+		Name:      "/non-existent/",
+		InsteadOf: "https://example.com/",
+	}
+	cfg.URLs[fetchURL] = &config.URL{
+		Name:      fetchURL,
+		InsteadOf: "https://example.com/go-git.git",
+	}
+	cfg.URLs[pushURL] = &config.URL{
+		Name:          pushURL,
+		PushInsteadOf: "https://example.com/go-git.git",
+	}
+
+	sto := memory.NewStorage()
+	c.Assert(sto.SetConfig(cfg), IsNil)
+
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{"https://example.com/go-git.git"},
+	})
+
+	err = r.Fetch(&FetchOptions{
+		RefSpecs: []config.RefSpec{"+refs/heads/master:refs/heads/master"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(r.Config().URLs, DeepEquals, []string{"https://example.com/go-git.git"})
+
+	err = r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"},
+	})
+	c.Assert(err, IsNil)
+
+	AssertReferences(c, server, map[string]string{
+		"refs/heads/master": "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+	})
+}
+
+func (s *RemoteSuite) TestFetchNegotiation(c *C) {
+	url := c.MkDir()
+	server, err := PlainClone(url, false, &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+
+	w, err := server.Worktree()
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "foo", []byte("foo"), 0644), IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	// Only the new commit, its tree and blob are received.
+	progress := bytes.NewBuffer(nil)
+	err = r.Fetch(&FetchOptions{Progress: progress})
+	c.Assert(err, IsNil)
+	c.Assert(progress.String(), Matches, "(?s).*Total 3 .*")
+}
+
 func (s *RemoteSuite) TestFetchWithDepth(c *C) {
 	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
 		URLs: []string{s.GetBasicLocalRepositoryURL()},
@@ -438,6 +653,45 @@ func (s *RemoteSuite) TestFetchWithPackfileWriter(c *C) {
 	c.Assert(mock.PackfileWriterCalled, Equals, true)
 }
 
+// keepCheckingStorage is a filesystem storage recording whether a packfile
+// has a .keep file when the references are set.
+type keepCheckingStorage struct {
+	*filesystem.Storage
+	kept bool
+}
+
+func (s *keepCheckingStorage) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	files, _ := s.Filesystem().ReadDir("objects/pack")
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".keep") {
+			s.kept = true
+		}
+	}
+
+	return s.Storage.CheckAndSetReference(ref, old)
+}
+
+func (s *RemoteSuite) TestFetchKeepsPackfile(c *C) {
+	fs := s.TemporalFilesystem(c)
+	sto := &keepCheckingStorage{Storage: filesystem.NewStorage(fs, cache.NewObjectLRUDefault())}
+
+	url := s.GetBasicLocalRepositoryURL()
+	r := NewRemote(sto, &config.RemoteConfig{Name: "foo", URLs: []string{url}})
+
+	err := r.Fetch(&FetchOptions{
+		RefSpecs: []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(sto.kept, Equals, true)
+
+	files, err := fs.ReadDir("objects/pack")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 2)
+	for _, f := range files {
+		c.Assert(strings.HasSuffix(f.Name(), ".keep"), Equals, false)
+	}
+}
+
 func (s *RemoteSuite) TestFetchNoErrAlreadyUpToDate(c *C) {
 	url := s.GetBasicLocalRepositoryURL()
 	s.doTestFetchNoErrAlreadyUpToDate(c, url)
@@ -562,6 +816,94 @@ func (s *RemoteSuite) TestString(c *C) {
 	)
 }
 
+func (s *RemoteSuite) TestStringPushURLs(c *C) {
+	r := NewRemote(nil, &config.RemoteConfig{
+		Name:     "foo",
+		URLs:     []string{"https://github.com/git-fixtures/basic.git"},
+		PushURLs: []string{"git@github.com:git-fixtures/basic.git"},
+	})
+
+	c.Assert(r.String(), Equals, ""+
+		"foo\thttps://github.com/git-fixtures/basic.git (fetch)\n"+
+		"foo\tgit@github.com:git-fixtures/basic.git (push)",
+	)
+}
+
+func (s *RemoteSuite) TestPushToMultipleURLs(c *C) {
+	urls := []string{c.MkDir(), c.MkDir()}
+	var servers []*Repository
+	for _, url := range urls {
+		server, err := PlainInit(url, true)
+		c.Assert(err, IsNil)
+		servers = append(servers, server)
+	}
+
+	sto := filesystem.NewStorage(fixtures.Basic().One().DotGit(), cache.NewObjectLRUDefault())
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: urls,
+	})
+
+	rs := []config.RefSpec{"refs/heads/master:refs/heads/master"}
+	err := r.Push(&PushOptions{RefSpecs: rs})
+	c.Assert(err, IsNil)
+
+	for _, server := range servers {
+		AssertReferences(c, server, map[string]string{
+			"refs/heads/master": "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		})
+	}
+
+	err = r.Push(&PushOptions{RefSpecs: rs})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+}
+
+func (s *RemoteSuite) TestPushToPushURLs(c *C) {
+	fetchURL := c.MkDir()
+	fetchServer, err := PlainInit(fetchURL, true)
+	c.Assert(err, IsNil)
+
+	pushURL := c.MkDir()
+	pushServer, err := PlainInit(pushURL, true)
+	c.Assert(err, IsNil)
+
+	sto := filesystem.NewStorage(fixtures.Basic().One().DotGit(), cache.NewObjectLRUDefault())
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name:     DefaultRemoteName,
+		URLs:     []string{fetchURL},
+		PushURLs: []string{pushURL},
+	})
+
+	err = r.Push(&PushOptions{RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"}})
+	c.Assert(err, IsNil)
+
+	AssertReferences(c, pushServer, map[string]string{
+		"refs/heads/master": "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+	})
+
+	_, err = fetchServer.Reference("refs/heads/master", false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RemoteSuite) TestPushToMultipleURLsWithError(c *C) {
+	url := c.MkDir()
+	server, err := PlainInit(url, true)
+	c.Assert(err, IsNil)
+
+	sto := filesystem.NewStorage(fixtures.Basic().One().DotGit(), cache.NewObjectLRUDefault())
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{filepath.Join(c.MkDir(), "non-existent"), url},
+	})
+
+	err = r.Push(&PushOptions{RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"}})
+	c.Assert(errors.Is(err, transport.ErrRepositoryNotFound), Equals, true)
+
+	AssertReferences(c, server, map[string]string{
+		"refs/heads/master": "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+	})
+}
+
 func (s *RemoteSuite) TestPushToEmptyRepository(c *C) {
 	url := c.MkDir()
 
@@ -600,6 +942,58 @@ func (s *RemoteSuite) TestPushToEmptyRepository(c *C) {
 
 }
 
+func (s *RemoteSuite) TestPushDryRun(c *C) {
+	url := c.MkDir()
+
+	server, err := PlainInit(url, true)
+	c.Assert(err, IsNil)
+
+	srcFs := fixtures.Basic().One().DotGit()
+	sto := filesystem.NewStorage(srcFs, cache.NewObjectLRUDefault())
+
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{url},
+	})
+
+	rs := config.RefSpec("refs/heads/master:refs/heads/master")
+	dr, err := r.PushDryRun(&PushOptions{
+		RefSpecs: []config.RefSpec{rs},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(dr.Commands, HasLen, 1)
+	c.Assert(dr.Commands[0].Name, Equals, plumbing.Master)
+	c.Assert(dr.Commands[0].Old, Equals, plumbing.ZeroHash)
+	c.Assert(dr.Commands[0].New, Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(dr.Objects, Equals, 28)
+	c.Assert(dr.PackSize > 0, Equals, true)
+
+	err = r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{rs},
+		DryRun:   true,
+	})
+	c.Assert(err, IsNil)
+
+	_, err = server.Reference(plumbing.Master, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	objects, err := server.Storer.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+	c.Assert(objects.ForEach(func(plumbing.EncodedObject) error {
+		return fmt.Errorf("unexpected object")
+	}), IsNil)
+
+	err = r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{rs},
+	})
+	c.Assert(err, IsNil)
+
+	_, err = r.PushDryRun(&PushOptions{
+		RefSpecs: []config.RefSpec{rs},
+	})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+}
+
 func (s *RemoteSuite) TestPushContext(c *C) {
 	url := c.MkDir()
 
@@ -1038,6 +1432,59 @@ func (s *RemoteSuite) TestPushForceWithLease_failure(c *C) {
 	}
 }
 
+func (s *RemoteSuite) TestPushMirror(c *C) {
+	url := c.MkDir()
+	server, err := PlainClone(url, true, &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	stale := plumbing.NewHashReference("refs/heads/stale", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(server.Storer.SetReference(stale), IsNil)
+
+	// Not a fast-forward of the local branch.
+	branch := plumbing.NewHashReference("refs/heads/branch", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(server.Storer.SetReference(branch), IsNil)
+
+	sto := filesystem.NewStorage(fixtures.Basic().One().DotGit(), cache.NewObjectLRUDefault())
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{url},
+	})
+
+	err = r.Push(&PushOptions{Mirror: true})
+	c.Assert(err, IsNil)
+
+	expected := make(map[string]string)
+	iter, err := sto.IterReferences()
+	c.Assert(err, IsNil)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			expected[ref.Name().String()] = ref.Hash().String()
+		}
+
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	AssertReferences(c, server, expected)
+
+	_, err = server.Reference(stale.Name(), false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	err = r.Push(&PushOptions{Mirror: true})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+}
+
+func (s *RemoteSuite) TestPushMirrorWithRefSpecs(c *C) {
+	r := NewRemote(nil, &config.RemoteConfig{Name: DefaultRemoteName, URLs: []string{"some-url"}})
+	err := r.Push(&PushOptions{
+		Mirror:   true,
+		RefSpecs: []config.RefSpec{"refs/heads/*:refs/heads/*"},
+	})
+	c.Assert(err, Equals, ErrMirrorRefSpecs)
+}
+
 func (s *RemoteSuite) TestPushPrune(c *C) {
 	fs := fixtures.Basic().One().DotGit()
 
@@ -1099,6 +1546,50 @@ func (s *RemoteSuite) TestPushPrune(c *C) {
 	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
 }
 
+func (s *RemoteSuite) TestPushNegativeRefSpec(c *C) {
+	url := c.MkDir()
+
+	server, err := PlainInit(url, true)
+	c.Assert(err, IsNil)
+
+	srcFs := fixtures.Basic().One().DotGit()
+	sto := filesystem.NewStorage(srcFs, cache.NewObjectLRUDefault())
+
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{url},
+	})
+
+	err = r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{
+			"refs/heads/*:refs/heads/*",
+			"^refs/heads/branch",
+		},
+	})
+	c.Assert(err, IsNil)
+
+	AssertReferences(c, server, map[string]string{
+		"refs/heads/master": "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+	})
+
+	_, err = server.Reference("refs/heads/branch", false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	err = r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{
+			"refs/heads/*:refs/heads/*",
+			"^refs/heads/master",
+		},
+		Prune: true,
+	})
+	c.Assert(err, IsNil)
+
+	AssertReferences(c, server, map[string]string{
+		"refs/heads/master": "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"refs/heads/branch": "e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	})
+}
+
 func (s *RemoteSuite) TestPushNewReference(c *C) {
 	fs := fixtures.Basic().One().DotGit()
 
@@ -1203,7 +1694,7 @@ func (s *RemoteSuite) TestPushInvalidSchemaEndpoint(c *C) {
 
 func (s *RemoteSuite) TestPushInvalidFetchOptions(c *C) {
 	r := NewRemote(nil, &config.RemoteConfig{Name: "foo", URLs: []string{"qux://foo"}})
-	invalid := config.RefSpec("^*$ñ")
+	invalid := config.RefSpec("*$ñ")
 	err := r.Push(&PushOptions{RefSpecs: []config.RefSpec{invalid}})
 	c.Assert(err, Equals, config.ErrRefSpecMalformedSeparator)
 }
@@ -1214,7 +1705,7 @@ func (s *RemoteSuite) TestPushInvalidRefSpec(c *C) {
 		URLs: []string{"some-url"},
 	})
 
-	rs := config.RefSpec("^*$**")
+	rs := config.RefSpec("*$**")
 	err := r.Push(&PushOptions{
 		RefSpecs: []config.RefSpec{rs},
 	})
@@ -1291,6 +1782,35 @@ func (s *RemoteSuite) TestList(c *C) {
 	}
 }
 
+func (s *RemoteSuite) TestHead(c *C) {
+	url := c.MkDir()
+	server, err := PlainClone(url, true, &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	remote := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{url},
+	})
+
+	head, err := remote.Head(&ListOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(head, Equals, plumbing.Master)
+
+	branch := plumbing.NewHashReference("refs/heads/branch", plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+	c.Assert(server.Storer.SetReference(branch), IsNil)
+	c.Assert(server.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch.Name())), IsNil)
+	head, err = remote.HeadContext(context.Background(), &ListOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(head, Equals, branch.Name())
+
+	// HEAD isn't advertised when it points to an unborn branch.
+	c.Assert(server.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/unborn")), IsNil)
+	_, err = remote.Head(&ListOptions{})
+	c.Assert(err, Equals, ErrRemoteHeadNotFound)
+}
+
 func (s *RemoteSuite) TestListPeeling(c *C) {
 	remote := NewRemote(memory.NewStorage(), &config.RemoteConfig{
 		Name: DefaultRemoteName,
@@ -1326,6 +1846,72 @@ func (s *RemoteSuite) TestListPeeling(c *C) {
 	}
 }
 
+func (s *RemoteSuite) TestListPatterns(c *C) {
+	remote := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{s.GetLocalRepositoryURL(fixtures.ByTag("tags").One())},
+	})
+
+	refs, err := remote.List(&ListOptions{
+		Patterns:      []string{"master", "annotated-*"},
+		PeelingOption: AppendPeeled,
+	})
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, ref := range refs {
+		names = append(names, ref.Name().String())
+	}
+
+	sort.Strings(names)
+	c.Assert(names, DeepEquals, []string{
+		"refs/heads/master",
+		"refs/remotes/origin/master",
+		"refs/tags/annotated-tag",
+		"refs/tags/annotated-tag^{}",
+	})
+}
+
+func (s *RemoteSuite) TestListReferences(c *C) {
+	remote := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{s.GetLocalRepositoryURL(fixtures.ByTag("tags").One())},
+	})
+
+	refs, err := remote.ListReferences(&ListOptions{
+		Patterns: []string{"HEAD", "refs/heads/*", "*-tag"},
+	})
+	c.Assert(err, IsNil)
+
+	head := plumbing.NewHash("f7b877701fbf855b44c0a9e86f3fdce2c298b07f")
+	c.Assert(refs, DeepEquals, []*RemoteReference{
+		{Name: plumbing.HEAD, Hash: head, Target: "refs/heads/master"},
+		{Name: "refs/heads/master", Hash: head},
+		{Name: "refs/remotes/origin/HEAD", Hash: head},
+		{
+			Name:   "refs/tags/annotated-tag",
+			Hash:   plumbing.NewHash("b742a2a9fa0afcfa9a6fad080980fbc26b007c69"),
+			Peeled: head,
+		},
+		{
+			Name:   "refs/tags/blob-tag",
+			Hash:   plumbing.NewHash("fe6cb94756faa81e5ed9240f9191b833db5f40ae"),
+			Peeled: plumbing.NewHash("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"),
+		},
+		{
+			Name:   "refs/tags/commit-tag",
+			Hash:   plumbing.NewHash("ad7897c0fb8e7d9a9ba41fa66072cf06095a6cfc"),
+			Peeled: head,
+		},
+		{Name: "refs/tags/lightweight-tag", Hash: head},
+		{
+			Name:   "refs/tags/tree-tag",
+			Hash:   plumbing.NewHash("152175bf7e5580299fa1f0ba41ef6474cc043b70"),
+			Peeled: plumbing.NewHash("70846e9a10ef7b41064b40f07713d5b8b9a8fc73"),
+		},
+	})
+}
+
 func (s *RemoteSuite) TestListTimeout(c *C) {
 	remote := NewRemote(memory.NewStorage(), &config.RemoteConfig{
 		Name: DefaultRemoteName,
@@ -1574,6 +2160,87 @@ func (s *RemoteSuite) TestFetchPruneTags(c *C) {
 	c.Assert(err, ErrorMatches, "reference not found")
 }
 
+func (s *RemoteSuite) TestFetchPruneTagsOption(c *C) {
+	url := c.MkDir()
+	server, err := PlainClone(url, true, &CloneOptions{
+		URL: fixtures.Basic().One().DotGit().Root(),
+	})
+	c.Assert(err, IsNil)
+
+	head, err := server.Head()
+	c.Assert(err, IsNil)
+
+	_, err = server.CreateTag("v1", head.Hash(), nil)
+	c.Assert(err, IsNil)
+
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL: url,
+	})
+	c.Assert(err, IsNil)
+
+	AssertReferences(c, r, map[string]string{
+		"refs/tags/v1": head.Hash().String(),
+	})
+
+	c.Assert(server.DeleteTag("v1"), IsNil)
+
+	err = r.Fetch(&FetchOptions{PruneTags: true, Tags: NoTags})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+
+	AssertReferences(c, r, map[string]string{
+		"refs/tags/v1": head.Hash().String(),
+	})
+
+	err = r.Fetch(&FetchOptions{PruneTags: true})
+	c.Assert(err, IsNil)
+
+	_, err = r.Reference("refs/tags/v1", true)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	AssertReferences(c, r, map[string]string{
+		"refs/remotes/origin/master": head.Hash().String(),
+	})
+}
+
+func (s *RemoteSuite) TestFetchPruneNegativeRefSpec(c *C) {
+	url := c.MkDir()
+	server, err := PlainClone(url, true, &CloneOptions{
+		URL: fixtures.Basic().One().DotGit().Root(),
+	})
+	c.Assert(err, IsNil)
+
+	head, err := server.Head()
+	c.Assert(err, IsNil)
+
+	branch := plumbing.NewHashReference("refs/heads/branch", head.Hash())
+	c.Assert(server.Storer.SetReference(branch), IsNil)
+
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL: url,
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(server.Storer.RemoveReference(branch.Name()), IsNil)
+
+	specs := []config.RefSpec{
+		"+refs/heads/*:refs/remotes/origin/*",
+		"^refs/heads/branch",
+	}
+
+	err = r.Fetch(&FetchOptions{Prune: true, RefSpecs: specs})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+
+	AssertReferences(c, r, map[string]string{
+		"refs/remotes/origin/branch": branch.Hash().String(),
+	})
+
+	err = r.Fetch(&FetchOptions{Prune: true, RefSpecs: specs[:1]})
+	c.Assert(err, IsNil)
+
+	_, err = r.Reference("refs/remotes/origin/branch", false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
 func (s *RemoteSuite) TestCanPushShasToReference(c *C) {
 	d := c.MkDir()
 	d, err := os.MkdirTemp(d, "TestCanPushShasToReference")
@@ -1666,6 +2333,7 @@ func (s *RemoteSuite) TestFetchAfterShallowClone(c *C) {
 		plumbing.NewReferenceFromStrings("refs/heads/master", sha4.String()),
 		plumbing.NewReferenceFromStrings("refs/remotes/origin/master", sha4.String()),
 		plumbing.NewSymbolicReference("HEAD", "refs/heads/master"),
+		plumbing.NewSymbolicReference("refs/remotes/origin/HEAD", "refs/remotes/origin/master"),
 	})
 
 	// Add another commit to the origin
@@ -1686,6 +2354,7 @@ func (s *RemoteSuite) TestFetchAfterShallowClone(c *C) {
 		plumbing.NewReferenceFromStrings("refs/heads/master", sha5.String()),
 		plumbing.NewReferenceFromStrings("refs/remotes/origin/master", sha5.String()),
 		plumbing.NewSymbolicReference("HEAD", "refs/heads/master"),
+		plumbing.NewSymbolicReference("refs/remotes/origin/HEAD", "refs/remotes/origin/master"),
 	})
 }
 
@@ -1825,3 +2494,201 @@ func writeCommitToRef(t *testing.T, repo *Repository, refName string, treeID plu
 
 	return commitID
 }
+
+// newLargeFileRepository returns a repository with a commit for each version
+// of a large text file, each changing a few of its lines.
+func newLargeFileRepository(c *C, dir string, versions int) (*Repository, []plumbing.Hash) {
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	lines := make([]string, 10000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d %x\n", i, i*7919*104729)
+	}
+
+	var commits []plumbing.Hash
+	for v := 0; v < versions; v++ {
+		for k := 0; k < 10; k++ {
+			lines[(v*1031+k*613)%len(lines)] = fmt.Sprintf("version %d change %d\n", v, k)
+		}
+
+		err := util.WriteFile(w.Filesystem, "dir/large.txt", []byte(strings.Join(lines, "")), 0o644)
+		c.Assert(err, IsNil)
+		_, err = w.Add("dir/large.txt")
+		c.Assert(err, IsNil)
+
+		h, err := w.Commit(fmt.Sprintf("version %d", v), &CommitOptions{
+			Author: &object.Signature{Name: "foo", Email: "foo@foo.foo", When: time.Unix(int64(v), 0)},
+		})
+		c.Assert(err, IsNil)
+		commits = append(commits, h)
+	}
+
+	return r, commits
+}
+
+// encodePushPackfile encodes the packfile of a push of objs to a remote
+// having haves.
+func encodePushPackfile(c *C, r *Repository, objs, haves []plumbing.Hash, thin bool) []byte {
+	hs, err := revlist.Objects(r.Storer, objs, haves)
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	e := packfile.NewEncoder(&buf, r.Storer, false)
+	window, err := setPushDeltaOptions(e, r.Storer, cfg, hs, thin)
+	c.Assert(err, IsNil)
+	_, err = e.Encode(hs, window)
+	c.Assert(err, IsNil)
+	return buf.Bytes()
+}
+
+func (s *RemoteSuite) TestPushPackfileDeltas(c *C) {
+	r, commits := newLargeFileRepository(c, c.MkDir(), 5)
+	last := commits[len(commits)-1]
+
+	full := encodePushPackfile(c, r, []plumbing.Hash{last}, nil, true)
+	one := encodePushPackfile(c, r, commits[:1], nil, true)
+
+	// The versions of the file are deltas against each other.
+	c.Assert(len(full) < 2*len(one), Equals, true)
+
+	// The last version of the file is a delta against the previous one the
+	// remote has.
+	thin := encodePushPackfile(c, r, []plumbing.Hash{last}, commits[len(commits)-2:len(commits)-1], true)
+	c.Assert(len(thin) < len(one)/10, Equals, true)
+
+	// The thin packfile is readable having its bases.
+	sto := memory.NewStorage()
+	for _, h := range commits[:len(commits)-1] {
+		hs, err := revlist.Objects(r.Storer, []plumbing.Hash{h}, nil)
+		c.Assert(err, IsNil)
+		for _, h := range hs {
+			o, err := r.Storer.EncodedObject(plumbing.AnyObject, h)
+			c.Assert(err, IsNil)
+			_, err = sto.SetEncodedObject(o)
+			c.Assert(err, IsNil)
+		}
+	}
+
+	c.Assert(packfile.UpdateObjectStorage(sto, bytes.NewReader(thin)), IsNil)
+	commit, err := object.GetCommit(sto, last)
+	c.Assert(err, IsNil)
+	f, err := commit.File("dir/large.txt")
+	c.Assert(err, IsNil)
+	expected, err := r.CommitObject(last)
+	c.Assert(err, IsNil)
+	ef, err := expected.File("dir/large.txt")
+	c.Assert(err, IsNil)
+	c.Assert(f.Hash, Equals, ef.Hash)
+	_, err = f.Contents()
+	c.Assert(err, IsNil)
+
+	// Without thin packfiles, the file is sent whole.
+	notThin := encodePushPackfile(c, r, []plumbing.Hash{last}, commits[len(commits)-2:len(commits)-1], false)
+	c.Assert(len(notThin) > len(one)/2, Equals, true)
+	c.Assert(packfile.UpdateObjectStorage(memory.NewStorage(), bytes.NewReader(notThin)), IsNil)
+}
+
+func (s *RemoteSuite) TestPushPackfileSizeAsGit(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	dir := c.MkDir()
+	r, commits := newLargeFileRepository(c, dir, 5)
+	last := commits[len(commits)-1]
+
+	gitSize := func(revs string) int {
+		cmd := exec.Command("git", "pack-objects", "--revs", "--thin", "--stdout")
+		cmd.Dir = dir
+		cmd.Stdin = strings.NewReader(revs)
+		out, err := cmd.Output()
+		c.Assert(err, IsNil)
+		return len(out)
+	}
+
+	full := encodePushPackfile(c, r, []plumbing.Hash{last}, nil, true)
+	c.Assert(len(full) < 2*gitSize(last.String()+"\n"), Equals, true)
+
+	incremental := encodePushPackfile(c, r, []plumbing.Hash{last}, commits[len(commits)-2:len(commits)-1], true)
+	expected := gitSize(fmt.Sprintf("%s\n^%s\n", last, commits[len(commits)-2]))
+	c.Assert(len(incremental) < 4*expected, Equals, true, Commentf("%d, git %d", len(incremental), expected))
+
+	// git receives the thin packfile.
+	url := c.MkDir()
+	_, err := PlainInit(url, true)
+	c.Assert(err, IsNil)
+
+	remote, err := r.CreateRemote(&config.RemoteConfig{Name: "server", URLs: []string{url}})
+	c.Assert(err, IsNil)
+
+	for _, h := range commits[len(commits)-2:] {
+		err := remote.Push(&PushOptions{
+			RemoteName: "server",
+			RefSpecs:   []config.RefSpec{config.RefSpec(h.String() + ":refs/heads/master")},
+		})
+		c.Assert(err, IsNil)
+	}
+
+	out, err := exec.Command("git", "--git-dir", url, "fsck").CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+}
+
+// abortingTransport is a transport whose servers abort while sending the
+// packfile, with the given message on the error channel of the sideband.
+type abortingTransport struct {
+	transport.Transport
+	msg string
+}
+
+func (t *abortingTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	s, err := t.Transport.NewUploadPackSession(ep, auth)
+	return &abortingSession{UploadPackSession: s, msg: t.msg}, err
+}
+
+type abortingSession struct {
+	transport.UploadPackSession
+	msg string
+}
+
+func (s *abortingSession) UploadPack(_ context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	var buf bytes.Buffer
+	e := pktline.NewEncoder(&buf)
+	if err := e.Encode(sideband.PackData.WithPayload([]byte("PACK\x00\x00\x00\x02\x00\x00\x00\x1c"))); err != nil {
+		return nil, err
+	}
+
+	if err := e.Encode(sideband.ErrorMessage.WithPayload([]byte(s.msg + "\n"))); err != nil {
+		return nil, err
+	}
+
+	return packp.NewUploadPackResponseWithPackfile(req, io.NopCloser(&buf)), nil
+}
+
+func (s *RemoteSuite) TestFetchServerErrorDuringPack(c *C) {
+	client.InstallProtocol("aborting", &abortingTransport{
+		Transport: file.DefaultClient,
+		msg:       "fatal: pack-objects died of signal 9",
+	})
+	defer client.InstallProtocol("aborting", nil)
+
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{"aborting://" + s.GetBasicLocalRepositoryURL()},
+	})
+
+	err := r.Fetch(&FetchOptions{
+		RefSpecs: []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+	})
+
+	var perr *transport.ProtocolError
+	c.Assert(errors.As(err, &perr), Equals, true, Commentf("%v", err))
+	c.Assert(perr.Message, Equals, "fatal: pack-objects died of signal 9")
+	c.Assert(err, ErrorMatches, "remote error: fatal: pack-objects died of signal 9")
+}
@@ -0,0 +1,434 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/midx"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// ErrMultiPackIndexNotSupported is returned by Repack when a multi-pack-index
+// is requested and the storage does not support them.
+var ErrMultiPackIndexNotSupported = errors.New("multi-pack-index files not supported by the storage")
+
+var packDir = path.Join("objects", "pack")
+
+// Repack writes all the objects reachable from the references to a new
+// packfile, then removes the packfiles it supersedes, as `git repack -a`
+// does, and packs the references. The objects of the removed packfiles not
+// reachable anymore are lost, unless they are in a packfile with a .keep
+// file, which are never removed, or in a packfile written recently enough to
+// be kept, as set with RepackOptions.OnlyDeleteOlderThan.
+//
+// The new packfile is written before any file is removed, as git does, so
+// the objects can be read at any time by another process.
+func (r *Repository) Repack(o RepackOptions) error {
+	return r.RepackContext(context.Background(), o)
+}
+
+// RepackContext repacks the objects as Repack does. The context is checked
+// for each object walked and written: if it is cancelled before the new
+// packfile is complete, it is removed and no file is removed, otherwise the
+// repack stops after any packfile or loose object it removes, which are all
+// in the new packfile.
+func (r *Repository) RepackContext(ctx context.Context, o RepackOptions) error {
+	return r.repack(ctx, &o, time.Time{})
+}
+
+// GC packs the references and the objects reachable from them, expires the
+// reflogs as ExpireReflog does with the gc config, then removes the
+// unreachable objects written before GCOptions.PruneOlderThan, as `git gc`
+// does. The unreachable objects of the removed packfiles written afterwards
+// are kept as loose objects.
+func (r *Repository) GC(o GCOptions) error {
+	return r.GCContext(context.Background(), o)
+}
+
+// GCContext packs the references and the objects, then removes the
+// unreachable objects as GC does, the context being checked as in
+// RepackContext and PruneContext.
+func (r *Repository) GCContext(ctx context.Context, o GCOptions) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	ro := &RepackOptions{DeleteLooseObjects: true}
+	if o.Aggressive {
+		ro.Window, ro.Depth = aggressiveGCWindow, aggressiveGCDepth
+	}
+
+	if err := r.repack(ctx, ro, o.PruneOlderThan); err != nil {
+		return err
+	}
+
+	// The reflogs are expired before pruning, as they keep objects.
+	err := r.ExpireReflog(&ExpireReflogOptions{All: true})
+	if err != nil && !errors.Is(err, storer.ErrReflogNotSupported) {
+		return err
+	}
+
+	return r.PruneContext(ctx, PruneOptions{
+		OnlyObjectsOlderThan: o.PruneOlderThan,
+		Handler:              r.DeleteObject,
+	})
+}
+
+// repack implements Repack, if unpackAfter is not zero, the unreachable
+// objects of the removed packfiles modified after it are written as loose
+// objects before removing them, as `git repack -A` does.
+func (r *Repository) repack(ctx context.Context, o *RepackOptions, unpackAfter time.Time) error {
+	if err := o.Validate(r); err != nil {
+		return err
+	}
+
+	pos, ok := r.Storer.(storer.PackedObjectStorer)
+	if !ok {
+		return ErrPackedObjectsNotSupported
+	}
+
+	fs, hasFS := storageFilesystem(r.Storer)
+	if o.WriteIndex && !hasFS {
+		return ErrMultiPackIndexNotSupported
+	}
+
+	// The packfiles are listed before walking the references, so the ones
+	// written concurrently afterwards are not removed.
+	packs, err := pos.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	kept, err := keptObjectPacks(fs, packs)
+	if err != nil {
+		return err
+	}
+
+	ow := newObjectWalker(ctx, r.Storer)
+	if err := ow.walkAllRefs(); err != nil {
+		return err
+	}
+
+	objs := make([]plumbing.Hash, 0, len(ow.seen))
+	for h := range ow.seen {
+		if !o.PackKeptObjects && inAnyIndex(kept, h) {
+			continue
+		}
+
+		objs = append(objs, h)
+	}
+
+	remaining := make([]plumbing.Hash, 0, len(kept)+1)
+	for h := range kept {
+		remaining = append(remaining, h)
+	}
+
+	var newPack plumbing.Hash
+	if len(objs) > 0 {
+		if newPack, err = r.writeRepackedPack(ctx, objs, o); err != nil {
+			return err
+		}
+
+		remaining = append(remaining, newPack)
+	}
+
+	var superseded []plumbing.Hash
+	for _, h := range packs {
+		if _, ok := kept[h]; !ok && h != newPack {
+			superseded = append(superseded, h)
+		}
+	}
+
+	if !unpackAfter.IsZero() && hasFS {
+		if err := r.unpackUnreachable(ctx, fs, superseded, ow, unpackAfter); err != nil {
+			return err
+		}
+	}
+
+	if hasFS {
+		if err := updateMultiPackIndex(fs, remaining, o.WriteIndex); err != nil {
+			return err
+		}
+	}
+
+	for _, h := range superseded {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := pos.DeleteOldObjectPackAndIndex(h, o.OnlyDeleteOlderThan); err != nil {
+			return err
+		}
+	}
+
+	if o.DeleteLooseObjects {
+		if err := r.deletePackedLooseObjects(ctx, ow); err != nil {
+			return err
+		}
+	}
+
+	if ri, ok := r.Storer.(interface{ Reindex() }); ok {
+		ri.Reindex()
+	}
+
+	return r.PackRefs(PackRefsOptions{All: true, Prune: true})
+}
+
+// PackRefs packs the loose references into the packed-refs file, as
+// `git pack-refs` does. The objects pointed to by the annotated tags are
+// written to it too, if the storage supports it. Symbolic references are
+// never packed.
+//
+// The storers that aren't a storer.ReferencePacker pack the references as
+// their PackRefs method does, regardless of the options.
+func (r *Repository) PackRefs(o PackRefsOptions) error {
+	if rp, ok := r.Storer.(storer.ReferencePacker); ok {
+		return rp.PackReferences(o.All, o.Prune)
+	}
+
+	return r.Storer.PackRefs()
+}
+
+func (r *Repository) writeRepackedPack(ctx context.Context, objs []plumbing.Hash, o *RepackOptions) (h plumbing.Hash, err error) {
+	pfw, ok := r.Storer.(storer.PackfileWriter)
+	if !ok {
+		return h, fmt.Errorf("Repository storer is not a storer.PackfileWriter")
+	}
+
+	wc, err := pfw.PackfileWriter()
+	if err != nil {
+		return h, err
+	}
+
+	// The packfile is only in place, along with its idx file, once closed.
+	// An incomplete one, once the context is cancelled, is removed.
+	defer ioutil.CheckClose(wc, &err)
+
+	enc := packfile.NewEncoder(&contextWriter{ctx: ctx, w: wc}, r.Storer, false)
+	enc.SetMaxDeltaDepth(o.Depth)
+	return enc.Encode(objs, o.Window)
+}
+
+// deletePackedLooseObjects deletes the loose objects reachable from the
+// references, which are all packed.
+func (r *Repository) deletePackedLooseObjects(ctx context.Context, ow *objectWalker) error {
+	los, ok := r.Storer.(storer.LooseObjectStorer)
+	if !ok {
+		return nil
+	}
+
+	return los.ForEachObjectHash(func(h plumbing.Hash) error {
+		if !ow.isSeen(h) {
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return los.DeleteLooseObject(h)
+	})
+}
+
+// unpackUnreachable writes as loose objects the objects of the packfiles
+// modified after the given time not reachable from the references.
+func (r *Repository) unpackUnreachable(ctx context.Context, fs billy.Filesystem, packs []plumbing.Hash,
+	ow *objectWalker, after time.Time) error {
+
+	los, ok := r.Storer.(storer.LooseObjectStorer)
+	if !ok {
+		return nil
+	}
+
+	for _, pack := range packs {
+		fi, err := fs.Stat(path.Join(packDir, fmt.Sprintf("pack-%s.pack", pack)))
+		if err != nil {
+			return err
+		}
+
+		if !fi.ModTime().After(after) {
+			continue
+		}
+
+		idx, err := readPackIndex(fs, pack)
+		if err != nil {
+			return err
+		}
+
+		err = forEachIndexEntry(idx, func(h plumbing.Hash) error {
+			if ow.isSeen(h) {
+				return nil
+			}
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if _, err := los.LooseObjectTime(h); err == nil {
+				return nil
+			}
+
+			obj, err := r.Storer.EncodedObject(plumbing.AnyObject, h)
+			if err != nil {
+				return err
+			}
+
+			_, err = r.Storer.SetEncodedObject(obj)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// contextWriter is a writer failing once its context is cancelled. Unlike
+// ioutil.NewContextWriter, the writes are synchronous, so the writer can be
+// closed as soon as one fails.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return w.w.Write(p)
+}
+
+// keptObjectPacks returns the indexes of the packfiles with a .keep file.
+func keptObjectPacks(fs billy.Filesystem, packs []plumbing.Hash) (map[plumbing.Hash]idxfile.Index, error) {
+	kept := make(map[plumbing.Hash]idxfile.Index)
+	if fs == nil {
+		return kept, nil
+	}
+
+	for _, h := range packs {
+		_, err := fs.Stat(path.Join(packDir, fmt.Sprintf("pack-%s.keep", h)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if kept[h], err = readPackIndex(fs, h); err != nil {
+			return nil, err
+		}
+	}
+
+	return kept, nil
+}
+
+func inAnyIndex(indexes map[plumbing.Hash]idxfile.Index, h plumbing.Hash) bool {
+	for _, idx := range indexes {
+		if ok, _ := idx.Contains(h); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateMultiPackIndex removes the multi-pack-index, before the packfiles it
+// covers are removed, or if write is true replaces it with one of the given
+// packfiles.
+func updateMultiPackIndex(fs billy.Filesystem, packs []plumbing.Hash, write bool) error {
+	name := path.Join(packDir, "multi-pack-index")
+	if !write || len(packs) == 0 {
+		err := fs.Remove(name)
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	names := make([]string, 0, len(packs))
+	indexes := make([]idxfile.Index, 0, len(packs))
+	for _, h := range packs {
+		idx, err := readPackIndex(fs, h)
+		if err != nil {
+			return err
+		}
+
+		names = append(names, fmt.Sprintf("pack-%s.idx", h))
+		indexes = append(indexes, idx)
+	}
+
+	idx, err := midx.BuildMemoryIndex(names, indexes)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.TempFile(packDir, "tmp_midx_")
+	if err != nil {
+		return err
+	}
+
+	if err := midx.NewEncoder(f).Encode(idx); err != nil {
+		_ = f.Close()
+		_ = fs.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = fs.Remove(f.Name())
+		return err
+	}
+
+	return fs.Rename(f.Name(), name)
+}
+
+func readPackIndex(fs billy.Filesystem, pack plumbing.Hash) (idx *idxfile.MemoryIndex, err error) {
+	f, err := fs.Open(path.Join(packDir, fmt.Sprintf("pack-%s.idx", pack)))
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	idx = idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(f).Decode(idx); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+func forEachIndexEntry(idx idxfile.Index, cb func(plumbing.Hash) error) error {
+	iter, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	defer iter.Close()
+	for {
+		e, err := iter.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := cb(e.Hash); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package git
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/midx"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type RepackSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&RepackSuite{})
+
+func (s *RepackSuite) open(c *C, fs billy.Filesystem) (*Repository, *filesystem.Storage) {
+	sto := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	r, err := Open(sto, nil)
+	c.Assert(err, IsNil)
+	return r, sto
+}
+
+// reachableObjects returns the count of the objects reachable from the
+// references, reading them from a new storage.
+func (s *RepackSuite) reachableObjects(c *C, fs billy.Filesystem) int {
+	_, sto := s.open(c, fs)
+	ow := newObjectWalker(context.Background(), sto)
+	c.Assert(ow.walkAllRefs(), IsNil)
+	return len(ow.seen)
+}
+
+func (s *RepackSuite) looseObjects(c *C, sto storer.LooseObjectStorer) []plumbing.Hash {
+	var hashes []plumbing.Hash
+	err := sto.ForEachObjectHash(func(h plumbing.Hash) error {
+		hashes = append(hashes, h)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	return hashes
+}
+
+func (s *RepackSuite) fsck(c *C, fs billy.Filesystem) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return
+	}
+
+	out, err := exec.Command("git", "--git-dir", fs.Root(), "fsck", "--no-dangling", "--no-reflogs").CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+}
+
+func (s *RepackSuite) TestRepack(c *C) {
+	fs := fixtures.ByTag(".git").ByTag("multi-packfile").One().DotGit()
+	expected := s.reachableObjects(c, fs)
+
+	r, sto := s.open(c, fs)
+	packs, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 2)
+
+	c.Assert(r.Repack(RepackOptions{}), IsNil)
+
+	packs, err = sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+	c.Assert(s.reachableObjects(c, fs), Equals, expected)
+
+	// The references are packed.
+	_, err = fs.Stat("packed-refs")
+	c.Assert(err, IsNil)
+	s.fsck(c, fs)
+
+	// Repacking again replaces the packfile.
+	c.Assert(r.Repack(RepackOptions{}), IsNil)
+	packs, err = sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+	c.Assert(s.reachableObjects(c, fs), Equals, expected)
+}
+
+func (s *RepackSuite) TestRepackDeleteLooseObjects(c *C) {
+	fs := fixtures.ByTag("unpacked").One().DotGit()
+	r, sto := s.open(c, fs)
+	c.Assert(s.looseObjects(c, sto), Not(HasLen), 0)
+	expected := s.reachableObjects(c, fs)
+
+	c.Assert(r.Repack(RepackOptions{DeleteLooseObjects: true}), IsNil)
+
+	packs, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+
+	ow := newObjectWalker(context.Background(), sto)
+	c.Assert(ow.walkAllRefs(), IsNil)
+	for _, h := range s.looseObjects(c, sto) {
+		c.Assert(ow.isSeen(h), Equals, false)
+	}
+
+	c.Assert(s.reachableObjects(c, fs), Equals, expected)
+	s.fsck(c, fs)
+}
+
+func (s *RepackSuite) TestRepackKeptPacks(c *C) {
+	fs := fixtures.ByTag(".git").ByTag("multi-packfile").One().DotGit()
+	expected := s.reachableObjects(c, fs)
+	r, sto := s.open(c, fs)
+
+	packs, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	keep := fs.Join("objects", "pack", "pack-"+packs[0].String()+".keep")
+	c.Assert(util.WriteFile(fs, keep, nil, 0o644), IsNil)
+
+	c.Assert(r.Repack(RepackOptions{}), IsNil)
+
+	after, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(after, HasLen, 2)
+	c.Assert(after, Not(DeepEquals), packs)
+	c.Assert(after[0] == packs[0] || after[1] == packs[0], Equals, true)
+
+	// The objects of the kept packfile are not written to the new one.
+	keptIdx, err := readPackIndex(fs, packs[0])
+	c.Assert(err, IsNil)
+	for _, h := range after {
+		if h == packs[0] {
+			continue
+		}
+
+		idx, err := readPackIndex(fs, h)
+		c.Assert(err, IsNil)
+		err = forEachIndexEntry(idx, func(h plumbing.Hash) error {
+			ok, err := keptIdx.Contains(h)
+			c.Assert(err, IsNil)
+			c.Assert(ok, Equals, false)
+			return nil
+		})
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(s.reachableObjects(c, fs), Equals, expected)
+
+	// With PackKeptObjects, all the objects are in the new packfile, but the
+	// kept one is still not removed.
+	c.Assert(r.Repack(RepackOptions{PackKeptObjects: true}), IsNil)
+
+	after, err = sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(after, HasLen, 2)
+	for _, h := range after {
+		if h == packs[0] {
+			continue
+		}
+
+		idx, err := readPackIndex(fs, h)
+		c.Assert(err, IsNil)
+		count, err := idx.Count()
+		c.Assert(err, IsNil)
+		c.Assert(count, Equals, int64(expected))
+	}
+}
+
+func (s *RepackSuite) TestRepackWriteIndex(c *C) {
+	fs := fixtures.ByTag(".git").ByTag("multi-packfile").One().DotGit()
+	expected := s.reachableObjects(c, fs)
+	r, sto := s.open(c, fs)
+
+	packs, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	keep := fs.Join("objects", "pack", "pack-"+packs[0].String()+".keep")
+	c.Assert(util.WriteFile(fs, keep, nil, 0o644), IsNil)
+
+	c.Assert(r.Repack(RepackOptions{WriteIndex: true}), IsNil)
+
+	f, err := fs.Open(fs.Join("objects", "pack", "multi-pack-index"))
+	c.Assert(err, IsNil)
+	idx := midx.NewMemoryIndex()
+	c.Assert(midx.NewDecoder(f).Decode(idx), IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(idx.PackNames, HasLen, 2)
+	c.Assert(s.reachableObjects(c, fs), Equals, expected)
+
+	if _, err := exec.LookPath("git"); err == nil {
+		out, err := exec.Command("git", "--git-dir", fs.Root(), "multi-pack-index", "verify").CombinedOutput()
+		c.Assert(err, IsNil, Commentf("%s", out))
+	}
+
+	// The multi-pack-index is removed, as it covers a removed packfile.
+	c.Assert(fs.Remove(keep), IsNil)
+	c.Assert(r.Repack(RepackOptions{}), IsNil)
+	_, err = fs.Stat(fs.Join("objects", "pack", "multi-pack-index"))
+	c.Assert(err, NotNil)
+	c.Assert(s.reachableObjects(c, fs), Equals, expected)
+}
+
+func (s *RepackSuite) TestRepackOnlyDeleteOlderThan(c *C) {
+	fs := fixtures.ByTag(".git").ByTag("multi-packfile").One().DotGit()
+	r, sto := s.open(c, fs)
+
+	c.Assert(r.Repack(RepackOptions{OnlyDeleteOlderThan: time.Unix(0, 1)}), IsNil)
+
+	packs, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 3)
+}
+
+func (s *RepackSuite) TestRepackWriteIndexNotSupported(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.Repack(RepackOptions{WriteIndex: true}), Equals, ErrMultiPackIndexNotSupported)
+}
+
+// countdownContext is a context cancelled once its error is checked n times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.n <= 0 {
+		return context.Canceled
+	}
+
+	ctx.n--
+	return nil
+}
+
+func (s *RepackSuite) TestRepackContextCancelled(c *C) {
+	fs := fixtures.ByTag(".git").ByTag("multi-packfile").One().DotGit()
+	expected := s.reachableObjects(c, fs)
+	r, sto := s.open(c, fs)
+
+	// The objects are all walked, the packfile is cancelled while written.
+	ctx := &countdownContext{Context: context.Background(), n: expected + 10}
+	c.Assert(r.RepackContext(ctx, RepackOptions{}), Equals, context.Canceled)
+
+	packs, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 2)
+
+	files, err := fs.ReadDir(fs.Join("objects", "pack"))
+	c.Assert(err, IsNil)
+	for _, f := range files {
+		c.Assert(strings.HasPrefix(f.Name(), "tmp_pack_"), Equals, false)
+	}
+
+	c.Assert(r.Repack(RepackOptions{}), IsNil)
+	c.Assert(s.reachableObjects(c, fs), Equals, expected)
+}
+
+func (s *RepackSuite) testGC(c *C, pruneOlderThan time.Time) (*filesystem.Storage, plumbing.Hash) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	r, sto := s.open(c, fs)
+
+	branch := plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	c.Assert(sto.RemoveReference("refs/heads/branch"), IsNil)
+	c.Assert(sto.RemoveReference("refs/remotes/origin/branch"), IsNil)
+	expected := s.reachableObjects(c, fs)
+
+	c.Assert(r.GC(GCOptions{PruneOlderThan: pruneOlderThan}), IsNil)
+
+	packs, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+	c.Assert(s.reachableObjects(c, fs), Equals, expected)
+	s.fsck(c, fs)
+
+	_, sto = s.open(c, fs)
+	return sto, branch
+}
+
+func (s *RepackSuite) TestGC(c *C) {
+	// The packfile is recent, its unreachable objects are kept as loose
+	// objects.
+	sto, branch := s.testGC(c, time.Time{})
+	// The commit, tree and blob only reachable from the removed branch.
+	c.Assert(s.looseObjects(c, sto), HasLen, 3)
+	c.Assert(sto.HasEncodedObject(branch), IsNil)
+}
+
+func (s *RepackSuite) TestGCPrune(c *C) {
+	sto, branch := s.testGC(c, time.Now().Add(time.Hour))
+	c.Assert(s.looseObjects(c, sto), HasLen, 0)
+	c.Assert(sto.HasEncodedObject(branch), Equals, plumbing.ErrObjectNotFound)
+}
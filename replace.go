@@ -0,0 +1,290 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+const (
+	replaceRefPrefix = "refs/replace/"
+	graftsPath       = "info/grafts"
+
+	// maxReplaceDepth is the number of replacements followed for an object,
+	// as git does.
+	maxReplaceDepth = 5
+)
+
+var (
+	// ErrReplaceTypeMismatch is returned by Replace when the object and its
+	// replacement are not of the same type.
+	ErrReplaceTypeMismatch = errors.New("objects must be of the same type")
+	// ErrReplaceSameObject is returned by Replace when an object is replaced
+	// by itself.
+	ErrReplaceSameObject = errors.New("object cannot be replaced by itself")
+)
+
+// Replace replaces an object by another one of the same type, as
+// `git replace` does, creating the refs/replace/<object> reference. The
+// objects read through the repository then have the content of the
+// replacement, but keep their hash, so the history of a commit can be changed
+// without rewriting its descendants. An existing replacement of the object is
+// overwritten.
+func (r *Repository) Replace(original, replacement plumbing.Hash) error {
+	if original == replacement {
+		return ErrReplaceSameObject
+	}
+
+	obj, err := r.Storer.EncodedObject(plumbing.AnyObject, original)
+	if err != nil {
+		return err
+	}
+
+	repl, err := r.Storer.EncodedObject(plumbing.AnyObject, replacement)
+	if err != nil {
+		return err
+	}
+
+	if obj.Type() != repl.Type() {
+		return fmt.Errorf("%w: %s is a %s, %s is a %s", ErrReplaceTypeMismatch,
+			original, obj.Type(), replacement, repl.Type())
+	}
+
+	name := plumbing.ReferenceName(replaceRefPrefix + original.String())
+	if err := r.Storer.SetReference(plumbing.NewHashReference(name, replacement)); err != nil {
+		return err
+	}
+
+	r.resetReplacements()
+	return nil
+}
+
+// DeleteReplace removes the replacement of an object, as
+// `git replace --delete` does. plumbing.ErrReferenceNotFound is returned if
+// the object isn't replaced.
+func (r *Repository) DeleteReplace(original plumbing.Hash) error {
+	name := plumbing.ReferenceName(replaceRefPrefix + original.String())
+	if _, err := r.Storer.Reference(name); err != nil {
+		return err
+	}
+
+	if err := r.Storer.RemoveReference(name); err != nil {
+		return err
+	}
+
+	r.resetReplacements()
+	return nil
+}
+
+func (r *Repository) resetReplacements() {
+	r.replaceMu.Lock()
+	defer r.replaceMu.Unlock()
+	r.replaceLoaded = false
+	r.replaceStorer = nil
+}
+
+// objectStorer returns the storer the objects of the history are read from,
+// which replaces them as the refs/replace references and the info/grafts file
+// say, or the storer of the repository if nothing is replaced.
+//
+// The replacements are read once, as git does for a command, and again after
+// Replace and DeleteReplace. They are ignored if core.useReplaceRefs is
+// false. The grafts, deprecated by git for the replace references, are read
+// in the repositories using them yet.
+func (r *Repository) objectStorer() (storage.Storer, error) {
+	s, err := r.replacements()
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		return r.Storer, nil
+	}
+
+	return s, nil
+}
+
+// replacements returns the storer replacing the objects, or nil if nothing is
+// replaced.
+func (r *Repository) replacements() (*replaceStorer, error) {
+	r.replaceMu.Lock()
+	defer r.replaceMu.Unlock()
+	if !r.replaceLoaded {
+		s, err := r.loadReplacements()
+		if err != nil {
+			return nil, err
+		}
+
+		r.replaceStorer = s
+		r.replaceLoaded = true
+	}
+
+	return r.replaceStorer, nil
+}
+
+func (r *Repository) loadReplacements() (*replaceStorer, error) {
+	s := &replaceStorer{
+		Storer:  r.Storer,
+		replace: make(map[plumbing.Hash]plumbing.Hash),
+		grafts:  make(map[plumbing.Hash][]plumbing.Hash),
+	}
+
+	cfg, err := r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return nil, err
+	}
+
+	use := true
+	if cfg.GetString("core.useReplaceRefs") != "" {
+		if use, err = cfg.GetBool("core.useReplaceRefs"); err != nil {
+			return nil, err
+		}
+	}
+
+	if use {
+		if err := s.readReplaceRefs(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.readGrafts(); err != nil {
+		return nil, err
+	}
+
+	if len(s.replace) == 0 && len(s.grafts) == 0 {
+		return nil, nil
+	}
+
+	return s, nil
+}
+
+// replaceStorer is a storer whose objects are replaced by the ones of the
+// replace references, and whose commits have the parents of the grafts.
+type replaceStorer struct {
+	storage.Storer
+	replace map[plumbing.Hash]plumbing.Hash
+	grafts  map[plumbing.Hash][]plumbing.Hash
+}
+
+func (s *replaceStorer) readReplaceRefs() error {
+	iter, err := s.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	return iter.ForEach(func(ref *plumbing.Reference) error {
+		id, ok := strings.CutPrefix(ref.Name().String(), replaceRefPrefix)
+		if !ok || ref.Type() != plumbing.HashReference || !plumbing.IsHash(id) {
+			return nil
+		}
+
+		s.replace[plumbing.NewHash(id)] = ref.Hash()
+		return nil
+	})
+}
+
+// readGrafts reads the info/grafts file, with a line per commit, its hash
+// followed by the ones of its parents.
+func (s *replaceStorer) readGrafts() error {
+	fs, ok := storageFilesystem(s.Storer)
+	if !ok {
+		return nil
+	}
+
+	f, err := fs.Open(graftsPath)
+	if err != nil {
+		return nil
+	}
+
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		parents := make([]plumbing.Hash, 0, len(fields)-1)
+		for _, field := range fields {
+			if !plumbing.IsHash(field) {
+				return fmt.Errorf("bad graft data: %s", line)
+			}
+
+			parents = append(parents, plumbing.NewHash(field))
+		}
+
+		s.grafts[parents[0]] = parents[1:]
+	}
+
+	return sc.Err()
+}
+
+// EncodedObject returns the object with the given hash, or its replacement
+// with the hash of the object.
+func (s *replaceStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	target := h
+	for i := 0; ; i++ {
+		repl, ok := s.replace[target]
+		if !ok {
+			break
+		}
+
+		if i == maxReplaceDepth {
+			return nil, fmt.Errorf("replace depth too high for object %s", h)
+		}
+
+		target = repl
+	}
+
+	obj, err := s.Storer.EncodedObject(t, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if parents, ok := s.grafts[h]; ok && obj.Type() == plumbing.CommitObject {
+		if obj, err = s.graft(obj, parents); err != nil {
+			return nil, err
+		}
+	}
+
+	if obj.Hash() == h {
+		return obj, nil
+	}
+
+	return &replacedObject{EncodedObject: obj, hash: h}, nil
+}
+
+// graft returns the commit with the given parents.
+func (s *replaceStorer) graft(obj plumbing.EncodedObject, parents []plumbing.Hash) (plumbing.EncodedObject, error) {
+	c, err := object.DecodeCommit(s.Storer, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	c.ParentHashes = parents
+	grafted := s.Storer.NewEncodedObject()
+	if err := c.Encode(grafted); err != nil {
+		return nil, err
+	}
+
+	return grafted, nil
+}
+
+// replacedObject is the replacement of an object, with the hash of the
+// object.
+type replacedObject struct {
+	plumbing.EncodedObject
+	hash plumbing.Hash
+}
+
+func (o *replacedObject) Hash() plumbing.Hash {
+	return o.hash
+}
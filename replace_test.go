@@ -0,0 +1,197 @@
+package git
+
+import (
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type ReplaceSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ReplaceSuite{})
+
+var (
+	// replacedCommit is the "some json" commit of the basic fixture, whose
+	// first parent is a merge.
+	replacedCommit = plumbing.NewHash("af2d6a6954d532f8ffb47615169c8fdf9d383a1a")
+	// replacedParent is the first parent of the merge.
+	replacedParent = plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9")
+)
+
+// replacement stores a copy of the commit, with the given parents.
+func replacement(c *C, r *Repository, h plumbing.Hash, parents ...plumbing.Hash) plumbing.Hash {
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+
+	commit.ParentHashes = parents
+	obj := r.Storer.NewEncodedObject()
+	c.Assert(commit.Encode(obj), IsNil)
+	repl, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+	return repl
+}
+
+// sorted returns the lines of logParents or revList sorted, as the order of
+// the commits of the same date differs.
+func sorted(lines []string) []string {
+	sort.Strings(lines)
+	return lines
+}
+
+func (s *ReplaceSuite) TestReplace(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	fs := fixtures.Basic().One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	original := sorted(logParents(c, r, &LogOptions{}))
+	repl := replacement(c, r, replacedCommit, replacedParent)
+	c.Assert(r.Replace(replacedCommit, repl), IsNil)
+
+	ref, err := r.Reference(plumbing.ReferenceName("refs/replace/"+replacedCommit.String()), false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, repl)
+
+	commit, err := r.CommitObject(replacedCommit)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Hash, Equals, replacedCommit)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{replacedParent})
+
+	// The history goes through the replacement.
+	expected := sorted(revList(c, fs.Root(), "--parents", "HEAD"))
+	all := sorted(revList(c, fs.Root(), "--parents", "--all"))
+	c.Assert(sorted(logParents(c, r, &LogOptions{})), DeepEquals, expected)
+	c.Assert(sorted(logParents(c, r, &LogOptions{All: true})), DeepEquals, all)
+	c.Assert(sorted(logParents(c, r, &LogOptions{Order: LogOrderDFSPost})), DeepEquals, expected)
+	c.Assert(sorted(logParents(c, r, &LogOptions{Order: LogOrderTopo})), DeepEquals, expected)
+
+	head, err := r.ResolveRevision("HEAD~3")
+	c.Assert(err, IsNil)
+	c.Assert(*head, Equals, replacedParent)
+
+	// The merge base of the second parent of the merge is now the root.
+	tip, err := r.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+	changelog, err := r.CommitObject(plumbing.NewHash("b8e471f58bcbca63b07bda20e428190409c2db47"))
+	c.Assert(err, IsNil)
+
+	bases, err := r.MergeBase(tip, changelog)
+	c.Assert(err, IsNil)
+	c.Assert(bases, HasLen, 1)
+
+	out, err := exec.Command("git", "--git-dir", fs.Root(), "merge-base", tip.Hash.String(), changelog.Hash.String()).Output()
+	c.Assert(err, IsNil)
+	c.Assert(bases[0].Hash.String(), Equals, strings.TrimSpace(string(out)))
+
+	// The changelog of the merged branch is now added by the replaced
+	// commit.
+	blame, err := r.Blame(tip, "CHANGELOG", nil)
+	c.Assert(err, IsNil)
+
+	out, err = exec.Command("git", "--git-dir", fs.Root(), "blame", "-l", "-s", tip.Hash.String(), "--", "CHANGELOG").Output()
+	c.Assert(err, IsNil)
+
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	c.Assert(blame.Lines, HasLen, len(lines))
+	for i, line := range lines {
+		c.Assert(blame.Lines[i].Hash.String(), Equals, line[:40])
+	}
+
+	c.Assert(blame.Lines[0].Hash, Equals, replacedCommit)
+
+	c.Assert(r.DeleteReplace(replacedCommit), IsNil)
+	c.Assert(sorted(logParents(c, r, &LogOptions{})), DeepEquals, original)
+	c.Assert(r.DeleteReplace(replacedCommit), Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *ReplaceSuite) TestReplaceChain(c *C) {
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	first := replacement(c, r, replacedCommit, replacedParent)
+	c.Assert(r.Replace(replacedCommit, first), IsNil)
+
+	// The replacements of the replacements are followed.
+	root := plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+	second := replacement(c, r, first, root)
+	c.Assert(r.Replace(first, second), IsNil)
+
+	commit, err := r.CommitObject(replacedCommit)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Hash, Equals, replacedCommit)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{root})
+
+	obj, err := r.Object(plumbing.AnyObject, first)
+	c.Assert(err, IsNil)
+	c.Assert(obj.(*object.Commit).ParentHashes, DeepEquals, []plumbing.Hash{root})
+}
+
+func (s *ReplaceSuite) TestReplaceErrors(c *C) {
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.Replace(replacedCommit, replacedCommit), Equals, ErrReplaceSameObject)
+
+	commit, err := r.CommitObject(replacedCommit)
+	c.Assert(err, IsNil)
+	err = r.Replace(replacedCommit, commit.TreeHash)
+	c.Assert(err, ErrorMatches, ErrReplaceTypeMismatch.Error()+".*")
+
+	err = r.Replace(replacedCommit, plumbing.NewHash("0000000000000000000000000000000000000001"))
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *ReplaceSuite) TestReplaceDisabled(c *C) {
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	original := sorted(logParents(c, r, &LogOptions{}))
+	c.Assert(r.SetConfigValue(config.LocalScope, "core.useReplaceRefs", "false"), IsNil)
+	c.Assert(r.Replace(replacedCommit, replacement(c, r, replacedCommit, replacedParent)), IsNil)
+	c.Assert(sorted(logParents(c, r, &LogOptions{})), DeepEquals, original)
+
+	commit, err := r.CommitObject(replacedCommit)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, HasLen, 1)
+	c.Assert(commit.ParentHashes[0], Not(Equals), replacedParent)
+}
+
+func (s *ReplaceSuite) TestGrafts(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	fs := fixtures.Basic().One().DotGit()
+	grafts := replacedCommit.String() + " " + replacedParent.String() + "\n" +
+		"# the root of the branch\n" +
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881\n"
+	c.Assert(util.WriteFile(fs, "info/grafts", []byte(grafts), 0o644), IsNil)
+
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(replacedCommit)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Hash, Equals, replacedCommit)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{replacedParent})
+
+	c.Assert(sorted(logParents(c, r, &LogOptions{All: true})), DeepEquals,
+		sorted(revList(c, fs.Root(), "--parents", "--all")))
+}
@@ -11,11 +11,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"dario.cat/mergo"
-	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-billy/v5/util"
@@ -26,13 +27,17 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	formatcfg "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/reflog"
 	"github.com/go-git/go-git/v5/plumbing/hash"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	"github.com/go-git/go-git/v5/storage/namespace"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 )
 
@@ -44,6 +49,10 @@ var (
 	ErrBranchExists = errors.New("branch already exists")
 	// ErrBranchNotFound an error stating the specified branch does not exist
 	ErrBranchNotFound = errors.New("branch not found")
+	// ErrBranchCheckedOut an error stating the branch is the current one
+	ErrBranchCheckedOut = errors.New("branch is checked out")
+	// ErrBranchNotMerged an error stating the branch to delete is not merged
+	ErrBranchNotMerged = errors.New("branch is not fully merged")
 	// ErrTagExists an error stating the specified tag already exists
 	ErrTagExists = errors.New("tag already exists")
 	// ErrTagNotFound an error stating the specified tag does not exist
@@ -65,15 +74,73 @@ var (
 	ErrSHA256NotSupported          = errors.New("go-git was not compiled with SHA256 support")
 	ErrAlternatePathNotSupported   = errors.New("alternate path must use the file scheme")
 	ErrUnsupportedMergeStrategy    = errors.New("unsupported merge strategy")
+	ErrWorktreeConfigDisabled      = errors.New("extensions.worktreeConfig is not enabled")
 	ErrFastForwardMergeNotPossible = errors.New("not possible to fast-forward merge changes")
+	ErrCommitGraphNotSupported     = errors.New("commit-graph files not supported by the storage")
+	// ErrReflogNotFound is returned by ResolveRevision when the reference of
+	// a reflog statement, such as master@{1}, has no reflog.
+	ErrReflogNotFound = errors.New("reflog not found")
+	// ErrReflogTooShort is returned by ResolveRevision when the reflog has
+	// fewer entries than the ones of a reflog statement, or HEAD fewer
+	// checkouts than the ones of @{-n}.
+	ErrReflogTooShort = errors.New("reflog too short")
+	// ErrNoUpstream is returned by ResolveRevision when the branch of an
+	// @{upstream} or @{push} statement has no upstream or push destination,
+	// and by Push, without refspecs, when the current branch has none.
+	ErrNoUpstream = errors.New("no upstream configured")
+	// ErrFetchRefSpecNotFound is returned by RemoveFetchRefSpec when the
+	// refspec isn't one of the fetch refspecs of the remote.
+	ErrFetchRefSpecNotFound = errors.New("fetch refspec not found")
 )
 
+// PathNotFoundError is returned by ResolveRevision when the path of a
+// <rev>:<path>, :<path> or :<n>:<path> statement doesn't exist in the tree
+// of the revision or in the index.
+type PathNotFoundError struct {
+	Path string
+	// Revision the path was looked up in, empty for the index.
+	Revision string
+	// Stage of the index the path was looked up in.
+	Stage int
+}
+
+func (e *PathNotFoundError) Error() string {
+	switch {
+	case e.Revision != "":
+		return fmt.Sprintf("path '%s' does not exist in '%s'", e.Path, e.Revision)
+	case e.Stage != 0:
+		return fmt.Sprintf("path '%s' does not exist in the index at stage %d", e.Path, e.Stage)
+	}
+
+	return fmt.Sprintf("path '%s' does not exist in the index", e.Path)
+}
+
+// NoMatchError is returned by ResolveRevision when no commit reachable from
+// a ^{/<regexp>} or :/<regexp> statement has a message matching it.
+type NoMatchError struct {
+	Regexp string
+}
+
+func (e *NoMatchError) Error() string {
+	return fmt.Sprintf("no commit message match regexp: %q", e.Regexp)
+}
+
 // Repository represents a git repository
+//
+// A Repository can be shared by goroutines reading objects and updating
+// references as long as its Storer is safe for concurrent use, as the
+// filesystem storage is. Its Worktree can not be shared.
 type Repository struct {
 	Storer storage.Storer
 
 	r  map[string]*Remote
 	wt billy.Filesystem
+
+	paths *RepositoryPaths
+
+	replaceMu     sync.Mutex
+	replaceLoaded bool
+	replaceStorer *replaceStorer
 }
 
 type InitOptions struct {
@@ -231,7 +298,7 @@ func Clone(s storage.Storer, worktree billy.Filesystem, o *CloneOptions) (*Repos
 func CloneContext(
 	ctx context.Context, s storage.Storer, worktree billy.Filesystem, o *CloneOptions,
 ) (*Repository, error) {
-	r, err := Init(s, worktree)
+	r, err := InitWithOptions(s, worktree, InitOptions{DefaultBranch: o.InitialBranch})
 	if err != nil {
 		return nil, err
 	}
@@ -248,23 +315,69 @@ func PlainInit(path string, isBare bool) (*Repository, error) {
 	})
 }
 
+// PlainInitWithOptions creates an empty git repository at the given path, as
+// PlainInit does, with the given options. If the git directory isn't empty
+// ErrRepositoryAlreadyExists is returned.
 func PlainInitWithOptions(path string, opts *PlainInitOptions) (*Repository, error) {
 	if opts == nil {
 		opts = &PlainInitOptions{}
 	}
 
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
 	var wt, dot billy.Filesystem
 
-	if opts.Bare {
+	paths := &RepositoryPaths{GitDir: path}
+	switch {
+	case opts.Bare:
 		dot = osfs.New(path)
-	} else {
+	case opts.SeparateGitDir != "":
+		wt = osfs.New(path)
+		dot = osfs.New(opts.SeparateGitDir)
+		paths.GitDir = dot.Root()
+		paths.Worktree = wt.Root()
+	default:
 		wt = osfs.New(path)
 		dot, _ = wt.Chroot(GitDirName)
+		paths.GitDir = dot.Root()
+		paths.Worktree = wt.Root()
+	}
+
+	paths.CommonDir = paths.GitDir
+
+	// The templates are only copied into new repositories, before the files
+	// of go-git are written, so their config is the base of the new one.
+	if _, err := dot.Stat("HEAD"); err == nil {
+		return nil, ErrRepositoryAlreadyExists
+	}
+
+	initOpts, templateDir := opts.InitOptions, opts.TemplateDir
+	if initOpts.DefaultBranch == "" {
+		initOpts.DefaultBranch = opts.InitialBranch
+	}
+
+	// As git init does, the defaults are read from the global and system
+	// config, ignoring the files which can't be read.
+	if initOpts.DefaultBranch == "" || templateDir == "" {
+		cfg := initConfig()
+		if initOpts.DefaultBranch == "" {
+			initOpts.DefaultBranch = initDefaultBranch(cfg)
+		}
+
+		if templateDir == "" {
+			templateDir = expandHome(cfg.Init.TemplateDir)
+		}
+	}
+
+	if err := copyTemplate(dot, templateDir); err != nil {
+		return nil, err
 	}
 
 	s := filesystem.NewStorage(dot, cache.NewObjectLRUDefault())
 
-	r, err := InitWithOptions(s, wt, opts.InitOptions)
+	r, err := InitWithOptions(s, wt, initOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -283,11 +396,18 @@ func PlainInitWithOptions(path string, opts *PlainInitOptions) (*Repository, err
 		cfg.Extensions.ObjectFormat = opts.ObjectFormat
 	}
 
+	// As git does, the config file, already written, is looked up with
+	// another case to tell if the filesystem is case-insensitive.
+	if _, err := dot.Stat("CoNfIg"); err == nil {
+		cfg.Core.IgnoreCase = true
+	}
+
 	err = r.Storer.SetConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	r.paths = paths
 	return r, err
 }
 
@@ -301,11 +421,12 @@ func PlainOpen(path string) (*Repository, error) {
 // PlainOpenWithOptions opens a git repository from the given path with specific
 // options. See PlainOpen for more info.
 func PlainOpenWithOptions(path string, o *PlainOpenOptions) (*Repository, error) {
-	dot, wt, err := dotGitToOSFilesystems(path, o.DetectDotGit)
+	paths, err := discoverRepository(path, o)
 	if err != nil {
 		return nil, err
 	}
 
+	dot := osfs.New(paths.GitDir)
 	if _, err := dot.Stat(""); err != nil {
 		if os.IsNotExist(err) {
 			return nil, ErrRepositoryNotExists
@@ -314,140 +435,243 @@ func PlainOpenWithOptions(path string, o *PlainOpenOptions) (*Repository, error)
 		return nil, err
 	}
 
-	var repositoryFs billy.Filesystem
+	var wt billy.Filesystem
+	if paths.Worktree != "" {
+		wt = osfs.New(paths.Worktree)
+	}
 
-	if o.EnableDotGitCommonDir {
-		dotGitCommon, err := dotGitCommonDirectory(dot)
-		if err != nil {
-			return nil, err
-		}
-		repositoryFs = dotgit.NewRepositoryFilesystem(dot, dotGitCommon)
-	} else {
-		repositoryFs = dot
+	repositoryFs := dot
+	if paths.CommonDir != paths.GitDir {
+		repositoryFs = dotgit.NewRepositoryFilesystem(dot, osfs.New(paths.CommonDir))
 	}
 
 	s := filesystem.NewStorage(repositoryFs, cache.NewObjectLRUDefault())
 
-	return Open(s, wt)
+	r, err := Open(s, wt)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Namespace != "" {
+		r = newRepository(namespace.NewStorage(s, o.Namespace), wt)
+	}
+
+	r.paths = paths
+	return r, nil
+}
+
+// RepositoryPaths are the paths of a repository opened or initialized from
+// the filesystem.
+type RepositoryPaths struct {
+	// GitDir is the git directory of the repository: the .git directory of
+	// the worktree, the one a .git file points to, or the bare repository.
+	GitDir string
+	// CommonDir is the directory holding the objects and the references
+	// shared by the linked worktrees, as pointed to by the commondir file of
+	// GitDir. It's GitDir for the main worktree.
+	CommonDir string
+	// Worktree is the root of the worktree, empty for a bare repository.
+	Worktree string
+}
+
+// Paths returns the paths of the repository, as found by PlainOpen, or nil
+// if the repository wasn't opened nor initialized from a path.
+func (r *Repository) Paths() *RepositoryPaths {
+	return r.paths
 }
 
-func dotGitToOSFilesystems(path string, detect bool) (dot, wt billy.Filesystem, err error) {
-	path, err = path_util.ReplaceTildeWithHome(path)
+// discoverRepository returns the paths of the repository at path. With
+// DetectDotGit, the parent directories are walked up until a repository is
+// found, as git does, up to the ceiling directories and the filesystem
+// boundary. The symbolic links are resolved first, so the walk follows the
+// physical directories.
+func discoverRepository(path string, o *PlainOpenOptions) (*RepositoryPaths, error) {
+	path, err := path_util.ReplaceTildeWithHome(path)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	if path, err = filepath.Abs(path); err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+
+	path = evalSymlinks(path)
+	if o.DetectDotGit {
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			path = filepath.Dir(path)
+		}
+	}
+
+	ceilings := make(map[string]bool, len(o.CeilingDirectories))
+	for _, dir := range o.CeilingDirectories {
+		if dir, err := filepath.Abs(dir); err == nil {
+			ceilings[evalSymlinks(dir)] = true
+		}
 	}
 
-	var fs billy.Filesystem
-	var fi os.FileInfo
+	dir := path
 	for {
-		fs = osfs.New(path)
+		paths, err := repositoryPathsAt(dir, o.DetectDotGit)
+		if err != nil {
+			return nil, err
+		}
 
-		pathinfo, err := fs.Stat("/")
-		if !os.IsNotExist(err) {
-			if pathinfo == nil {
-				return nil, nil, err
-			}
-			if !pathinfo.IsDir() && detect {
-				fs = osfs.New(filepath.Dir(path))
-			}
+		if paths == nil && !o.DetectDotGit {
+			// The path itself is the git directory, if it's a repository.
+			paths = &RepositoryPaths{GitDir: dir}
 		}
 
-		fi, err = fs.Stat(GitDirName)
-		if err == nil {
-			// no error; stop
-			break
-		}
-		if !os.IsNotExist(err) {
-			// unknown error; stop
-			return nil, nil, err
-		}
-		if detect {
-			// try its parent as long as we haven't reached
-			// the root dir
-			if dir := filepath.Dir(path); dir != path {
-				path = dir
-				continue
+		if paths != nil {
+			paths.CommonDir, err = commonDirectory(paths.GitDir)
+			if err != nil {
+				return nil, err
 			}
+
+			return paths, nil
 		}
-		// not detecting via parent dirs and the dir does not exist;
-		// stop
-		return fs, nil, nil
-	}
 
-	if fi.IsDir() {
-		dot, err = fs.Chroot(GitDirName)
-		return dot, fs, err
-	}
+		parent := filepath.Dir(dir)
+		if parent == dir || ceilings[parent] {
+			return nil, ErrRepositoryNotExists
+		}
 
-	dot, err = dotGitFileToOSFilesystem(path, fs)
-	if err != nil {
-		return nil, nil, err
-	}
+		if !o.DiscoveryAcrossFilesystem && !sameFilesystem(dir, parent) {
+			return nil, ErrRepositoryNotExists
+		}
 
-	return dot, fs, nil
+		dir = parent
+	}
 }
 
-func dotGitFileToOSFilesystem(path string, fs billy.Filesystem) (bfs billy.Filesystem, err error) {
-	f, err := fs.Open(GitDirName)
-	if err != nil {
+// repositoryPathsAt returns the paths of the repository whose worktree is
+// dir, following its .git file if any, or nil if there is none. If bare,
+// dir itself may be the git directory of a bare repository.
+func repositoryPathsAt(dir string, bare bool) (*RepositoryPaths, error) {
+	dotGit := filepath.Join(dir, GitDirName)
+	fi, err := os.Stat(dotGit)
+	switch {
+	case err == nil && fi.IsDir():
+		return &RepositoryPaths{GitDir: dotGit, Worktree: dir}, nil
+	case err == nil:
+		gitDir, err := readGitFile(dotGit)
+		if err != nil {
+			return nil, err
+		}
+
+		return &RepositoryPaths{GitDir: gitDir, Worktree: dir}, nil
+	case !os.IsNotExist(err):
 		return nil, err
+	case bare && isGitDir(dir):
+		return &RepositoryPaths{GitDir: dir}, nil
 	}
-	defer ioutil.CheckClose(f, &err)
 
-	b, err := io.ReadAll(f)
+	return nil, nil
+}
+
+// readGitFile returns the git directory a .git file points to, as written by
+// `git worktree add` and `git submodule`. A relative path is relative to the
+// directory of the .git file.
+func readGitFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	line := string(b)
-	const prefix = "gitdir: "
+	const prefix = "gitdir:"
 	if !strings.HasPrefix(line, prefix) {
-		return nil, fmt.Errorf(".git file has no %s prefix", prefix)
+		return "", fmt.Errorf(".git file has no %s prefix", prefix)
+	}
+
+	gitDir := strings.SplitN(line[len(prefix):], "\n", 2)[0]
+	gitDir = strings.TrimSpace(gitDir)
+	if gitDir == "" {
+		return "", fmt.Errorf(".git file has an empty gitdir")
 	}
 
-	gitdir := strings.Split(line[len(prefix):], "\n")[0]
-	gitdir = strings.TrimSpace(gitdir)
-	if filepath.IsAbs(gitdir) {
-		return osfs.New(gitdir), nil
+	gitDir = filepath.FromSlash(gitDir)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
 	}
 
-	return osfs.New(fs.Join(path, gitdir)), nil
+	return evalSymlinks(gitDir), nil
 }
 
-func dotGitCommonDirectory(fs billy.Filesystem) (commonDir billy.Filesystem, err error) {
-	f, err := fs.Open("commondir")
+// commonDirectory returns the directory the commondir file of the git
+// directory of a linked worktree points to, or gitDir if there is none.
+func commonDirectory(gitDir string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
 	if os.IsNotExist(err) {
-		return nil, nil
+		return gitDir, nil
 	}
+
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	b, err := io.ReadAll(f)
-	if err != nil {
-		return nil, err
+	path := filepath.FromSlash(strings.TrimSpace(string(b)))
+	if path == "" {
+		return gitDir, nil
 	}
-	if len(b) > 0 {
-		path := strings.TrimSpace(string(b))
-		if filepath.IsAbs(path) {
-			commonDir = osfs.New(path)
-		} else {
-			commonDir = osfs.New(filepath.Join(fs.Root(), path))
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(gitDir, path)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrRepositoryIncomplete
 		}
-		if _, err := commonDir.Stat(""); err != nil {
-			if os.IsNotExist(err) {
-				return nil, ErrRepositoryIncomplete
-			}
 
-			return nil, err
+		return "", err
+	}
+
+	return evalSymlinks(path), nil
+}
+
+// isGitDir tells whether dir looks like a git directory, as git checks it
+// while discovering bare repositories.
+func isGitDir(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
 		}
 	}
 
-	return commonDir, nil
+	return true
+}
+
+// sameFilesystem tells whether the directories a and b are on the same
+// device, when the platform reports it.
+func sameFilesystem(a, b string) bool {
+	if fillSystemInfo == nil {
+		return true
+	}
+
+	ai, err := os.Stat(a)
+	if err != nil {
+		return true
+	}
+
+	bi, err := os.Stat(b)
+	if err != nil {
+		return true
+	}
+
+	var ae, be index.Entry
+	fillSystemInfo(&ae, ai.Sys())
+	fillSystemInfo(&be, bi.Sys())
+	return ae.Dev == be.Dev
+}
+
+// evalSymlinks returns path with its symbolic links resolved, or path if it
+// can't be resolved, such as when it doesn't exist.
+func evalSymlinks(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+
+	return path
 }
 
 // PlainClone a repository into the path with the given options, isBare defines
@@ -478,7 +702,10 @@ func PlainCloneContext(ctx context.Context, path string, isBare bool, o *CloneOp
 	if o.Mirror {
 		isBare = true
 	}
-	r, err := PlainInit(path, isBare)
+	r, err := PlainInitWithOptions(path, &PlainInitOptions{
+		InitOptions: InitOptions{DefaultBranch: o.InitialBranch},
+		Bare:        isBare,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -562,35 +789,138 @@ func (r *Repository) SetConfig(cfg *config.Config) error {
 
 // ConfigScoped returns the repository config, merged with requested scope and
 // lower. For example if, config.GlobalScope is given the local and global config
-// are returned merged in one config value.
+// are returned merged in one config value, the local one taking precedence.
+// The include.path and includeIf directives of the config files are followed.
 func (r *Repository) ConfigScoped(scope config.Scope) (*config.Config, error) {
 	// TODO(mcuadros): v6, add this as ConfigOptions.Scoped
 
-	var err error
-	system := config.NewConfig()
-	if scope >= config.SystemScope {
-		system, err = config.LoadConfig(config.SystemScope)
-		if err != nil {
+	local, err := r.Storer.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var opts config.IncludeOptions
+	if fs, ok := r.Storer.(interface{ Filesystem() billy.Filesystem }); ok {
+		if opts.GitDir, err = filepath.Abs(fs.Filesystem().Root()); err != nil {
 			return nil, err
 		}
 	}
 
-	global := config.NewConfig()
-	if scope >= config.GlobalScope {
-		global, err = config.LoadConfig(config.GlobalScope)
-		if err != nil {
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err == nil && head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		opts.Branch = head.Target().Short()
+	}
+
+	if s, ok := r.enabledWorktreeConfig(local); ok {
+		if opts.Worktree, err = s.WorktreeConfig(); err != nil {
 			return nil, err
 		}
 	}
 
-	local, err := r.Storer.Config()
+	return config.LoadMergedConfig(scope, local, opts)
+}
+
+// worktreeConfigStorer is implemented by the storers supporting the
+// config.worktree file of the worktree.
+type worktreeConfigStorer interface {
+	WorktreeConfig() (*config.Config, error)
+	SetWorktreeConfig(*config.Config) error
+}
+
+// enabledWorktreeConfig returns the storer of the config.worktree file, if
+// extensions.worktreeConfig is enabled in the local config and the storer
+// supports it.
+func (r *Repository) enabledWorktreeConfig(local *config.Config) (worktreeConfigStorer, bool) {
+	if enabled, _ := local.GetBool("extensions.worktreeConfig"); !enabled {
+		return nil, false
+	}
+
+	s, ok := r.Storer.(worktreeConfigStorer)
+	return s, ok
+}
+
+// SetConfigScoped writes the config of the given scope, the `.git/config` for
+// config.LocalScope as SetConfig, the config.worktree file of the worktree
+// for config.WorktreeScope, or the global or system config files. It should
+// be called with the result of `config.LoadConfig` for the scope, and never
+// with the output of `Repository.ConfigScoped`.
+//
+// The config.WorktreeScope can only be written if extensions.worktreeConfig
+// is enabled, ErrWorktreeConfigDisabled being returned otherwise.
+func (r *Repository) SetConfigScoped(scope config.Scope, cfg *config.Config) error {
+	switch scope {
+	case config.LocalScope:
+		return r.SetConfig(cfg)
+	case config.WorktreeScope:
+		s, err := r.writableWorktreeConfig()
+		if err != nil {
+			return err
+		}
+
+		return s.SetWorktreeConfig(cfg)
+	}
+
+	return config.SaveConfig(scope, cfg)
+}
+
+// writableWorktreeConfig returns the storer of the config.worktree file, or
+// ErrWorktreeConfigDisabled if it is not enabled.
+func (r *Repository) writableWorktreeConfig() (worktreeConfigStorer, error) {
+	local, err := r.Config()
 	if err != nil {
 		return nil, err
 	}
 
-	_ = mergo.Merge(global, system)
-	_ = mergo.Merge(local, global)
-	return local, nil
+	s, ok := r.enabledWorktreeConfig(local)
+	if !ok {
+		return nil, ErrWorktreeConfigDisabled
+	}
+
+	return s, nil
+}
+
+// SetConfigValue sets the value of the given key, such as "core.autocrlf" or
+// "remote.origin.url", in the config of the given scope, replacing all its
+// values. The rest of the config file, comments and formatting included, is
+// kept as it is.
+func (r *Repository) SetConfigValue(scope config.Scope, key, value string) error {
+	return r.editConfig(scope, func(cfg *config.Config) error {
+		return cfg.Set(key, value)
+	})
+}
+
+// UnsetConfigValue removes all the values of the given key from the config
+// of the given scope, as SetConfigValue does.
+func (r *Repository) UnsetConfigValue(scope config.Scope, key string) error {
+	return r.editConfig(scope, func(cfg *config.Config) error {
+		return cfg.Unset(key)
+	})
+}
+
+func (r *Repository) editConfig(scope config.Scope, f func(cfg *config.Config) error) error {
+	var cfg *config.Config
+	var err error
+	switch scope {
+	case config.LocalScope:
+		cfg, err = r.Config()
+	case config.WorktreeScope:
+		var s worktreeConfigStorer
+		if s, err = r.writableWorktreeConfig(); err == nil {
+			cfg, err = s.WorktreeConfig()
+		}
+	default:
+		cfg, err = config.LoadConfig(scope)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := f(cfg); err != nil {
+		return err
+	}
+
+	return r.SetConfigScoped(scope, cfg)
 }
 
 // Remote return a remote if exists
@@ -678,6 +1008,148 @@ func (r *Repository) DeleteRemote(name string) error {
 	return r.Storer.SetConfig(cfg)
 }
 
+// AddFetchRefSpec adds the given refspec to the fetch refspecs of the given
+// remote, as `git config --add remote.<name>.fetch <spec>` does. Nothing is
+// done if the remote already has it.
+func (r *Repository) AddFetchRefSpec(name string, spec config.RefSpec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	return r.updateRemote(name, func(c *config.RemoteConfig) error {
+		for _, s := range c.Fetch {
+			if s == spec {
+				return nil
+			}
+		}
+
+		c.Fetch = append(c.Fetch, spec)
+		return nil
+	})
+}
+
+// RemoveFetchRefSpec removes the given refspec from the fetch refspecs of
+// the given remote. ErrFetchRefSpecNotFound is returned if the remote
+// doesn't have it.
+func (r *Repository) RemoveFetchRefSpec(name string, spec config.RefSpec) error {
+	return r.updateRemote(name, func(c *config.RemoteConfig) error {
+		fetch := make([]config.RefSpec, 0, len(c.Fetch))
+		for _, s := range c.Fetch {
+			if s != spec {
+				fetch = append(fetch, s)
+			}
+		}
+
+		if len(fetch) == len(c.Fetch) {
+			return fmt.Errorf("%w: %s", ErrFetchRefSpecNotFound, spec)
+		}
+
+		c.Fetch = fetch
+		return nil
+	})
+}
+
+// SetFetchAllBranches makes the given remote fetch all its branches, as
+// `git remote set-branches <name> '*'` does. The fetch refspecs of single
+// branches, such as the one of a single branch clone, are replaced by the
+// default one, +refs/heads/*:refs/remotes/<name>/*. The other refspecs are
+// kept.
+func (r *Repository) SetFetchAllBranches(name string) error {
+	return r.updateRemote(name, func(c *config.RemoteConfig) error {
+		all := config.RefSpec(fmt.Sprintf(config.DefaultFetchRefSpec, name))
+
+		fetch := make([]config.RefSpec, 0, len(c.Fetch)+1)
+		for _, s := range c.Fetch {
+			if s != all && !isBranchRefSpec(s) {
+				fetch = append(fetch, s)
+			}
+		}
+
+		c.Fetch = append(fetch, all)
+		return nil
+	})
+}
+
+// updateRemote calls f with the configuration of the given remote, and
+// stores it if f succeeds.
+func (r *Repository) updateRemote(name string, f func(*config.RemoteConfig) error) error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	c, ok := cfg.Remotes[name]
+	if !ok {
+		return ErrRemoteNotFound
+	}
+
+	if err := f(c); err != nil {
+		return err
+	}
+
+	return r.Storer.SetConfig(cfg)
+}
+
+// SetRemoteHead sets the HEAD of the given remote, refs/remotes/<name>/HEAD,
+// to the remote-tracking branch of the given branch, as
+// `git remote set-head <name> <branch>` does. The branch is given by its
+// name, as "main" or "refs/heads/main", and must have been fetched.
+func (r *Repository) SetRemoteHead(name string, branch plumbing.ReferenceName) error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := cfg.Remotes[name]; !ok {
+		return ErrRemoteNotFound
+	}
+
+	tracking := plumbing.NewRemoteReferenceName(name, branch.Short())
+	if _, err := r.Storer.Reference(tracking); err != nil {
+		if err == plumbing.ErrReferenceNotFound {
+			return fmt.Errorf("%w: %s", err, tracking)
+		}
+
+		return err
+	}
+
+	head := plumbing.NewRemoteHEADReferenceName(name)
+	return r.Storer.SetReference(plumbing.NewSymbolicReference(head, tracking))
+}
+
+// SetRemoteHeadAuto sets the HEAD of the given remote to the remote-tracking
+// branch of its default branch, queried from the remote, as
+// `git remote set-head <name> --auto` does. It returns the branch of the
+// remote HEAD points to.
+func (r *Repository) SetRemoteHeadAuto(name string, o *ListOptions) (plumbing.ReferenceName, error) {
+	ctx, cancel, err := o.timeoutContext()
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+	return r.SetRemoteHeadAutoContext(ctx, name, o)
+}
+
+// SetRemoteHeadAutoContext sets the HEAD of the given remote to the
+// remote-tracking branch of its default branch, as SetRemoteHeadAuto does.
+//
+// The provided Context must be non-nil. If the context expires before the
+// operation is complete, an error is returned. The context only affects the
+// transport operations.
+func (r *Repository) SetRemoteHeadAutoContext(ctx context.Context, name string, o *ListOptions) (plumbing.ReferenceName, error) {
+	remote, err := r.Remote(name)
+	if err != nil {
+		return "", err
+	}
+
+	branch, err := remote.HeadContext(ctx, o)
+	if err != nil {
+		return "", err
+	}
+
+	return branch, r.SetRemoteHead(name, branch)
+}
+
 // Branch return a Branch if exists
 func (r *Repository) Branch(name string) (*config.Branch, error) {
 	cfg, err := r.Config()
@@ -727,8 +1199,383 @@ func (r *Repository) DeleteBranch(name string) error {
 	return r.Storer.SetConfig(cfg)
 }
 
-// CreateTag creates a tag. If opts is included, the tag is an annotated tag,
-// otherwise a lightweight tag is created.
+// SetUpstream sets the upstream of the given local branch, or of the current
+// branch if the name is empty, to the merge reference of the remote, as git
+// branch --set-upstream-to does. The remote "." makes the branch track
+// another local branch.
+func (r *Repository) SetUpstream(branch, remote string, merge plumbing.ReferenceName) error {
+	name, err := r.branchName(branch)
+	if err != nil {
+		return err
+	}
+
+	if !merge.IsBranch() {
+		return fmt.Errorf("invalid upstream branch %q", merge)
+	}
+
+	return r.editConfig(config.LocalScope, func(cfg *config.Config) error {
+		if _, ok := cfg.Remotes[remote]; !ok && remote != "." {
+			return ErrRemoteNotFound
+		}
+
+		if err := cfg.Set("branch."+name+".remote", remote); err != nil {
+			return err
+		}
+
+		return cfg.Set("branch."+name+".merge", merge.String())
+	})
+}
+
+// UnsetUpstream removes the upstream of the given local branch, or of the
+// current branch if the name is empty, as git branch --unset-upstream does.
+func (r *Repository) UnsetUpstream(branch string) error {
+	name, err := r.branchName(branch)
+	if err != nil {
+		return err
+	}
+
+	return r.editConfig(config.LocalScope, func(cfg *config.Config) error {
+		if err := cfg.Unset("branch." + name + ".remote"); err != nil {
+			return err
+		}
+
+		return cfg.Unset("branch." + name + ".merge")
+	})
+}
+
+// DeleteBranchRef deletes the given local branch, its config and its reflog,
+// as git branch -d does. Unless force is set, the branch must be merged into
+// its upstream, or into HEAD if it has none. The current branch can't be
+// deleted.
+func (r *Repository) DeleteBranchRef(name string, force bool) error {
+	name = strings.TrimPrefix(name, "refs/heads/")
+	ref, err := r.Storer.Reference(plumbing.NewBranchReferenceName(name))
+	if err == plumbing.ErrReferenceNotFound {
+		return fmt.Errorf("branch '%s' not found: %w", name, ErrBranchNotFound)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+
+	if head.Type() == plumbing.SymbolicReference && head.Target() == ref.Name() {
+		return fmt.Errorf("cannot delete branch '%s': %w", name, ErrBranchCheckedOut)
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		if err := r.checkBranchMerged(cfg, ref); err != nil {
+			return err
+		}
+	}
+
+	if err := r.Storer.RemoveReference(ref.Name()); err != nil {
+		return err
+	}
+
+	if _, ok := cfg.Branches[name]; ok {
+		delete(cfg.Branches, name)
+		if err := r.Storer.SetConfig(cfg); err != nil {
+			return err
+		}
+	}
+
+	return r.removeReflog(ref.Name())
+}
+
+// checkBranchMerged returns ErrBranchNotMerged if the branch isn't merged into
+// its upstream, or into HEAD if it has none.
+func (r *Repository) checkBranchMerged(cfg *config.Config, ref *plumbing.Reference) error {
+	into := plumbing.HEAD
+	if upstream, err := upstreamTrackingName(cfg, ref.Name().Short()); err == nil {
+		if _, err := r.Reference(upstream, true); err == nil {
+			into = upstream
+		}
+	}
+
+	target, err := r.Reference(into, true)
+	if err != nil {
+		return err
+	}
+
+	branch, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return err
+	}
+
+	c, err := r.CommitObject(target.Hash())
+	if err != nil {
+		return err
+	}
+
+	merged, err := branch.IsAncestor(c)
+	if err != nil {
+		return err
+	}
+
+	if !merged {
+		return fmt.Errorf("branch '%s' is not merged into %s: %w", ref.Name().Short(), into.Short(), ErrBranchNotMerged)
+	}
+
+	return nil
+}
+
+// RenameBranch renames the given local branch, with its config and its
+// reflog, and updates HEAD if it is the current branch, as git branch -m
+// does. Unless force is set, a branch with the new name must not exist.
+func (r *Repository) RenameBranch(oldName, newName string, force bool) error {
+	oldName, newName = strings.TrimPrefix(oldName, "refs/heads/"), strings.TrimPrefix(newName, "refs/heads/")
+	src, dst := plumbing.NewBranchReferenceName(oldName), plumbing.NewBranchReferenceName(newName)
+	if err := dst.Validate(); err != nil {
+		return err
+	}
+
+	ref, err := r.Storer.Reference(src)
+	if err == plumbing.ErrReferenceNotFound {
+		return fmt.Errorf("branch '%s' not found: %w", oldName, ErrBranchNotFound)
+	}
+
+	if err != nil || src == dst {
+		return err
+	}
+
+	_, err = r.Storer.Reference(dst)
+	if err == nil && !force {
+		return fmt.Errorf("a branch named '%s' already exists: %w", newName, ErrBranchExists)
+	}
+
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+
+	// The branch is removed first, so it can be moved into a directory of
+	// its own name, such as a to a/b, and restored if it can't be moved.
+	if err := r.Storer.RemoveReference(src); err != nil {
+		return err
+	}
+
+	if err := r.Storer.SetReference(plumbing.NewHashReference(dst, ref.Hash())); err != nil {
+		_ = r.Storer.SetReference(ref)
+		return err
+	}
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+
+	if head.Type() == plumbing.SymbolicReference && head.Target() == src {
+		if err := r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, dst)); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	// The config of a replaced branch goes with it.
+	_, replaced := cfg.Branches[newName]
+	delete(cfg.Branches, newName)
+	b, ok := cfg.Branches[oldName]
+	if ok {
+		delete(cfg.Branches, oldName)
+		b.Name = newName
+		cfg.Branches[newName] = b
+	}
+
+	if ok || replaced {
+		if err := r.Storer.SetConfig(cfg); err != nil {
+			return err
+		}
+	}
+
+	return r.renameReflog(src, dst)
+}
+
+// removeReflog removes the reflog of the given reference, if the storer has
+// reflogs.
+func (r *Repository) removeReflog(name plumbing.ReferenceName) error {
+	m, ok := r.Storer.(storer.ReflogMover)
+	if !ok {
+		return nil
+	}
+
+	return m.RemoveReflog(name)
+}
+
+// renameReflog moves the reflog of the given reference to the new name, if
+// the storer has reflogs, replacing the existing one.
+func (r *Repository) renameReflog(oldName, newName plumbing.ReferenceName) error {
+	m, ok := r.Storer.(storer.ReflogMover)
+	if !ok {
+		return nil
+	}
+
+	return m.RenameReflog(oldName, newName)
+}
+
+// BranchStatus is the state of a local branch compared to its upstream.
+type BranchStatus struct {
+	// Name is the short name of the branch.
+	Name string
+	// Upstream is the name of the reference tracking the upstream of the
+	// branch, empty if it has none.
+	Upstream plumbing.ReferenceName
+	// Gone is true if the upstream is configured but the reference tracking
+	// it doesn't exist, usually because it was deleted from the remote.
+	Gone bool
+	// Ahead is the number of commits of the branch not in its upstream.
+	Ahead int
+	// Behind is the number of commits of the upstream not in the branch.
+	Behind int
+}
+
+// BranchStatus returns the state of the given local branch, or of the current
+// branch if the name is empty, compared to its upstream, as git status shows
+// it.
+func (r *Repository) BranchStatus(branch string) (*BranchStatus, error) {
+	name, err := r.branchName(branch)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &BranchStatus{Name: name}
+	upstream, err := upstreamTrackingName(cfg, name)
+	if errors.Is(err, ErrNoUpstream) {
+		return status, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	status.Upstream = upstream
+	theirs, err := r.Reference(upstream, true)
+	if err == plumbing.ErrReferenceNotFound {
+		status.Gone = true
+		return status, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ours, err := r.Reference(plumbing.NewBranchReferenceName(name), true)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Ahead, status.Behind, err = r.AheadBehind(ours.Hash(), theirs.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// setupBranchTracking sets the upstream of a new branch started at the given
+// reference, following branch.autoSetupMerge as git does: "true", the
+// default, tracks a remote-tracking start point, "always" a local branch too,
+// "simple" only a remote-tracking branch of the same name, "inherit" copies
+// the upstream of the start point and "false" never tracks. The branch is set
+// to rebase on pull as branch.autoSetupRebase says.
+func (r *Repository) setupBranchTracking(branch string, start plumbing.ReferenceName) error {
+	cfg, err := r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return err
+	}
+
+	var remote string
+	var merge plumbing.ReferenceName
+	switch mode := strings.ToLower(cfg.GetString("branch.autoSetupMerge")); mode {
+	case "false":
+		return nil
+	case "inherit":
+		if b, ok := cfg.Branches[start.Short()]; ok && start.IsBranch() {
+			remote, merge = b.Remote, b.Merge
+		}
+	case "", "true", "always", "simple":
+		remote, merge = trackedBranch(cfg, start)
+		if remote == "" && mode == "always" && start.IsBranch() {
+			remote, merge = ".", start
+		}
+
+		if mode == "simple" && merge != plumbing.NewBranchReferenceName(branch) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("malformed value for branch.autoSetupMerge: %q", mode)
+	}
+
+	if remote == "" || merge == "" {
+		return nil
+	}
+
+	if err := r.SetUpstream(branch, remote, merge); err != nil {
+		return err
+	}
+
+	rebase := strings.ToLower(cfg.GetString("branch.autoSetupRebase"))
+	if rebase == "always" || rebase == "local" && remote == "." || rebase == "remote" && remote != "." {
+		return r.SetConfigValue(config.LocalScope, "branch."+branch+".rebase", "true")
+	}
+
+	return nil
+}
+
+// trackedBranch returns the remote and the name on the remote of the branch
+// tracked by the given remote-tracking branch, from the fetch refspecs of the
+// remotes, or an empty remote if it doesn't track any.
+func trackedBranch(cfg *config.Config, name plumbing.ReferenceName) (string, plumbing.ReferenceName) {
+	if !name.IsRemote() {
+		return "", ""
+	}
+
+	names := make([]string, 0, len(cfg.Remotes))
+	for name := range cfg.Remotes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, remote := range names {
+		rc := cfg.Remotes[remote]
+		for _, rs := range rc.Fetch {
+			if rs.IsNegative() || rs.IsExactSHA1() {
+				continue
+			}
+
+			reverse := rs.Reverse()
+			if !reverse.Match(name) {
+				continue
+			}
+
+			if merge := reverse.Dst(name); merge.IsBranch() && !config.Excluded(rc.Fetch, merge) {
+				return remote, merge
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// CreateTag creates a tag. If opts is included, the tag is an annotated tag,
+// otherwise a lightweight tag is created.
 func (r *Repository) CreateTag(name string, hash plumbing.Hash, opts *CreateTagOptions) (*plumbing.Reference, error) {
 	rname := plumbing.NewTagReferenceName(name)
 	if err := rname.Validate(); err != nil {
@@ -783,13 +1630,18 @@ func (r *Repository) createTagObject(name string, hash plumbing.Hash, opts *Crea
 		Target:     hash,
 	}
 
-	if opts.SignKey != nil {
-		sig, err := r.buildTagSignature(tag, opts.SignKey)
+	// Convert SignKey into a Signer if set. Existing Signer should take priority.
+	signer := opts.Signer
+	if signer == nil && opts.SignKey != nil {
+		signer = &gpgSigner{key: opts.SignKey}
+	}
+	if signer != nil {
+		sig, err := signObject(signer, tag)
 		if err != nil {
 			return plumbing.ZeroHash, err
 		}
 
-		tag.PGPSignature = sig
+		tag.PGPSignature = string(sig)
 	}
 
 	obj := r.Storer.NewEncodedObject()
@@ -800,25 +1652,6 @@ func (r *Repository) createTagObject(name string, hash plumbing.Hash, opts *Crea
 	return r.Storer.SetEncodedObject(obj)
 }
 
-func (r *Repository) buildTagSignature(tag *object.Tag, signKey *openpgp.Entity) (string, error) {
-	encoded := &plumbing.MemoryObject{}
-	if err := tag.Encode(encoded); err != nil {
-		return "", err
-	}
-
-	rdr, err := encoded.Reader()
-	if err != nil {
-		return "", err
-	}
-
-	var b bytes.Buffer
-	if err := openpgp.ArmoredDetachSign(&b, signKey, rdr, nil); err != nil {
-		return "", err
-	}
-
-	return b.String(), nil
-}
-
 // Tag returns a tag from the repository.
 //
 // If you want to check to see if the tag is an annotated tag, you can call
@@ -905,20 +1738,14 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 		if !url.IsLocalEndpoint(o.URL) {
 			return ErrAlternatePathNotSupported
 		}
-		altpath := o.URL
-		remoteRepo, err := PlainOpen(o.URL)
-		if err != nil {
-			return fmt.Errorf("failed to open remote repository: %w", err)
-		}
-		conf, err := remoteRepo.Config()
-		if err != nil {
-			return fmt.Errorf("failed to read remote repository configuration: %w", err)
-		}
-		if !conf.Core.IsBare {
-			altpath = path.Join(altpath, GitDirName)
+		if err := r.AddAlternate(o.URL); err != nil {
+			return err
 		}
-		if err := r.Storer.AddAlternate(altpath); err != nil {
-			return fmt.Errorf("failed to add alternate file to git objects dir: %w", err)
+	}
+
+	if o.Reference != "" {
+		if err := r.AddAlternate(o.Reference); err != nil {
+			return err
 		}
 	}
 
@@ -927,17 +1754,29 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 		Depth:           o.Depth,
 		Auth:            o.Auth,
 		Progress:        o.Progress,
+		ProgressHandler: o.ProgressHandler,
 		Tags:            o.Tags,
 		RemoteName:      o.RemoteName,
 		InsecureSkipTLS: o.InsecureSkipTLS,
 		CABundle:        o.CABundle,
 		ProxyOptions:    o.ProxyOptions,
+		Timeouts:        o.Timeouts,
+		Resume:          o.Resume,
 	}, o.ReferenceName)
 	if err != nil {
 		return err
 	}
 
-	if r.wt != nil && !o.NoCheckout {
+	if o.Dissociate {
+		if err := r.dissociate(); err != nil {
+			return err
+		}
+	}
+
+	// The HEAD of an empty repository points to an unborn branch, there is
+	// nothing to check out.
+	unborn := ref.Type() == plumbing.SymbolicReference
+	if r.wt != nil && !o.NoCheckout && !unborn {
 		w, err := r.Worktree()
 		if err != nil {
 			return err
@@ -948,10 +1787,11 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 			return err
 		}
 
-		if err := w.Reset(&ResetOptions{
-			Mode:   MergeReset,
-			Commit: head.Hash(),
-		}); err != nil {
+		if err := w.reset(ctx, &ResetOptions{
+			Mode:            MergeReset,
+			Commit:          head.Hash(),
+			ProgressHandler: o.ProgressHandler,
+		}, nil); err != nil {
 			return err
 		}
 
@@ -964,7 +1804,10 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 					}
 					return 0
 				}(),
-				Auth: o.Auth,
+				Auth:            o.Auth,
+				Jobs:            o.SubmoduleJobs,
+				Progress:        o.SubmoduleProgress,
+				ProgressHandler: o.SubmoduleProgressHandler,
 			}); err != nil {
 				return err
 			}
@@ -975,8 +1818,12 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 		return err
 	}
 
-	if !o.Mirror && ref.Name().IsBranch() {
-		branchRef := ref.Name()
+	branchRef := ref.Name()
+	if unborn {
+		branchRef = ref.Target()
+	}
+
+	if !o.Mirror && branchRef.IsBranch() {
 		branchName := strings.Split(string(branchRef), "refs/heads/")[1]
 
 		b := &config.Branch{
@@ -999,15 +1846,17 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 }
 
 const (
+	refspecMirror           = "+refs/*:refs/*"
 	refspecTag              = "+refs/tags/%s:refs/tags/%[1]s"
 	refspecSingleBranch     = "+refs/heads/%s:refs/remotes/%s/%[1]s"
 	refspecSingleBranchHEAD = "+HEAD:refs/remotes/%s/HEAD"
+	refspecAllBranches      = "refs/heads/*"
 )
 
 func (r *Repository) cloneRefSpec(o *CloneOptions) []config.RefSpec {
 	switch {
 	case o.Mirror:
-		return []config.RefSpec{"+refs/*:refs/*"}
+		return []config.RefSpec{refspecMirror}
 	case o.ReferenceName.IsTag():
 		return []config.RefSpec{
 			config.RefSpec(fmt.Sprintf(refspecTag, o.ReferenceName.Short())),
@@ -1077,6 +1926,17 @@ func (r *Repository) fetchAndUpdateReferences(
 	}
 
 	resolvedRef, err := expand_ref(remoteRefs, ref)
+	if err == plumbing.ErrReferenceNotFound && ref == plumbing.HEAD {
+		empty, eerr := isEmptyRemote(remoteRefs)
+		if eerr != nil {
+			return nil, eerr
+		}
+
+		if empty {
+			return r.setUnbornHead(remoteRefs)
+		}
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -1086,6 +1946,10 @@ func (r *Repository) fetchAndUpdateReferences(
 		return nil, err
 	}
 
+	if err := r.setClonedRemoteHead(remote.c, remoteRefs); err != nil {
+		return nil, err
+	}
+
 	if !objsUpdated && !refsUpdated {
 		return nil, NoErrAlreadyUpToDate
 	}
@@ -1093,17 +1957,55 @@ func (r *Repository) fetchAndUpdateReferences(
 	return resolvedRef, nil
 }
 
-func (r *Repository) updateReferences(spec []config.RefSpec,
-	resolvedRef *plumbing.Reference) (updated bool, err error) {
+// isEmptyRemote returns true if the references of a remote don't point to
+// any commit, as the ones of an empty repository.
+func isEmptyRemote(remoteRefs storer.ReferenceStorer) (bool, error) {
+	iter, err := remoteRefs.IterReferences()
+	if err != nil {
+		return false, err
+	}
 
-	if !resolvedRef.Name().IsBranch() {
-		// Detached HEAD mode
-		h, err := r.resolveToCommitHash(resolvedRef.Hash())
-		if err != nil {
-			return false, err
+	empty := true
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			empty = false
+			return storer.ErrStop
 		}
-		head := plumbing.NewHashReference(plumbing.HEAD, h)
-		return updateReferenceStorerIfNeeded(r.Storer, head)
+
+		return nil
+	})
+
+	return empty, err
+}
+
+// setUnbornHead points HEAD to the unborn branch HEAD of an empty remote
+// points to, as git clone does, and returns it. HEAD is kept as set by Init
+// if the remote doesn't advertise its branch.
+func (r *Repository) setUnbornHead(remoteRefs storer.ReferenceStorer) (*plumbing.Reference, error) {
+	remoteHead, err := remoteRefs.Reference(plumbing.HEAD)
+	if err == nil && remoteHead.Type() == plumbing.SymbolicReference && remoteHead.Target().IsBranch() {
+		head := plumbing.NewSymbolicReference(plumbing.HEAD, remoteHead.Target())
+		return head, r.Storer.SetReference(head)
+	}
+
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return nil, err
+	}
+
+	return r.Storer.Reference(plumbing.HEAD)
+}
+
+func (r *Repository) updateReferences(spec []config.RefSpec,
+	resolvedRef *plumbing.Reference) (updated bool, err error) {
+
+	if !resolvedRef.Name().IsBranch() {
+		// Detached HEAD mode
+		h, err := r.resolveToCommitHash(resolvedRef.Hash())
+		if err != nil {
+			return false, err
+		}
+		head := plumbing.NewHashReference(plumbing.HEAD, h)
+		return updateReferenceStorerIfNeeded(r.Storer, head)
 	}
 
 	refs := []*plumbing.Reference{
@@ -1129,6 +2031,54 @@ func (r *Repository) updateReferences(spec []config.RefSpec,
 	return
 }
 
+// setClonedRemoteHead sets the HEAD of the cloned remote to the
+// remote-tracking branch of its default branch, as git clone does, when the
+// branch was fetched and HEAD itself wasn't. A mirror has no remote-tracking
+// branches, its HEAD already points to the default branch.
+func (r *Repository) setClonedRemoteHead(c *config.RemoteConfig, remoteRefs storer.ReferenceStorer) error {
+	if c.Mirror {
+		return nil
+	}
+
+	branch, err := remoteHead(remoteRefs)
+	if err == ErrRemoteHeadNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// HEAD is fetched by the single branch clones of HEAD.
+	head := plumbing.NewRemoteHEADReferenceName(c.Name)
+	if _, err := r.Storer.Reference(head); err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+
+	for _, rs := range c.Fetch {
+		if !rs.Match(branch) || config.Excluded(c.Fetch, branch) {
+			continue
+		}
+
+		tracking := rs.Dst(branch)
+		if !tracking.IsRemote() {
+			continue
+		}
+
+		if _, err := r.Storer.Reference(tracking); err != nil {
+			if err == plumbing.ErrReferenceNotFound {
+				continue
+			}
+
+			return err
+		}
+
+		return r.Storer.SetReference(plumbing.NewSymbolicReference(head, tracking))
+	}
+
+	return nil
+}
+
 func (r *Repository) calculateRemoteHeadReference(spec []config.RefSpec,
 	resolvedHead *plumbing.Reference) []*plumbing.Reference {
 
@@ -1138,7 +2088,7 @@ func (r *Repository) calculateRemoteHeadReference(spec []config.RefSpec,
 	// exist. This is needed when using single branch and HEAD.
 	for _, rs := range spec {
 		name := resolvedHead.Name()
-		if !rs.Match(name) {
+		if !rs.Match(name) || config.Excluded(spec, name) {
 			continue
 		}
 
@@ -1181,7 +2131,7 @@ func updateReferenceStorerIfNeeded(
 // their histories, from the remote named as FetchOptions.RemoteName.
 //
 // Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
-// no changes to be fetched, or an error.
+// no changes to be fetched, as for an empty remote repository, or an error.
 func (r *Repository) Fetch(o *FetchOptions) error {
 	return r.FetchContext(context.Background(), o)
 }
@@ -1190,14 +2140,17 @@ func (r *Repository) Fetch(o *FetchOptions) error {
 // their histories, from the remote named as FetchOptions.RemoteName.
 //
 // Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
-// no changes to be fetched, or an error.
+// no changes to be fetched, as for an empty remote repository, or an error.
+// See Remote.FetchContext.
 //
 // The provided Context must be non-nil. If the context expires before the
 // operation is complete, an error is returned. The context only affects the
 // transport operations.
 func (r *Repository) FetchContext(ctx context.Context, o *FetchOptions) error {
-	if err := o.Validate(); err != nil {
-		return err
+	// The options are validated by the remote, once the defaults set by its
+	// config, such as remote.<name>.tagOpt, are applied.
+	if o.RemoteName == "" {
+		o.RemoteName = DefaultRemoteName
 	}
 
 	remote, err := r.Remote(o.RemoteName)
@@ -1219,26 +2172,194 @@ func (r *Repository) Push(o *PushOptions) error {
 // the remote was already up-to-date, from the remote named as
 // FetchOptions.RemoteName.
 //
+// As git push does, without a RemoteName the current branch is pushed to its
+// branch.<name>.pushRemote, remote.pushDefault or branch.<name>.remote, and
+// without RefSpecs the remote.<name>.push refspecs are used, or the ones
+// given by push.default: nothing, matching, current, upstream or simple, the
+// default.
+//
 // The provided Context must be non-nil. If the context expires before the
 // operation is complete, an error is returned. The context only affects the
 // transport operations.
 func (r *Repository) PushContext(ctx context.Context, o *PushOptions) error {
-	if err := o.Validate(); err != nil {
+	cfg, err := r.Config()
+	if err != nil {
 		return err
 	}
 
+	branch, err := r.branchName("")
+	if err != nil {
+		branch = ""
+	}
+
+	if o.RemoteName == "" && branch != "" {
+		o.RemoteName = pushRemoteName(cfg, branch)
+	}
+
+	if o.RemoteName == "" {
+		o.RemoteName = DefaultRemoteName
+	}
+
 	remote, err := r.Remote(o.RemoteName)
 	if err != nil {
 		return err
 	}
 
-	return remote.PushContext(ctx, o)
+	autoSetUpstream := false
+	if len(o.RefSpecs) == 0 && !o.Mirror {
+		if o.RefSpecs, autoSetUpstream, err = r.pushRefSpecs(ctx, cfg, remote, branch, o); err != nil {
+			return err
+		}
+
+		if len(o.RefSpecs) == 0 {
+			return NoErrAlreadyUpToDate
+		}
+	}
+
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	err = remote.PushContext(ctx, o)
+	if err != nil && err != NoErrAlreadyUpToDate {
+		return err
+	}
+
+	if (o.SetUpstream || autoSetUpstream) && !o.DryRun {
+		if err := r.setPushUpstream(o.RemoteName, o.RefSpecs); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// pushRefSpecs returns the refspecs of a push without refspecs from the
+// remote.<name>.push config or push.default, and whether the upstream of the
+// current branch has to be set up, because of push.autoSetupRemote.
+func (r *Repository) pushRefSpecs(ctx context.Context, cfg *config.Config, remote *Remote, branch string, o *PushOptions) ([]config.RefSpec, bool, error) {
+	var specs []config.RefSpec
+	for _, s := range cfg.GetAll("remote." + o.RemoteName + ".push") {
+		specs = append(specs, config.RefSpec(s))
+	}
+
+	if len(specs) > 0 {
+		return specs, false, nil
+	}
+
+	mode := strings.ToLower(cfg.GetString("push.default"))
+	if mode == "matching" {
+		specs, err := r.matchingRefSpecs(ctx, remote, o)
+		return specs, false, err
+	}
+
+	if branch == "" {
+		if mode == "nothing" {
+			return nil, false, fmt.Errorf("push has no destination (push.default is 'nothing'): %w", ErrNoUpstream)
+		}
+
+		return nil, false, fmt.Errorf("HEAD does not point to a branch: %w", ErrNoUpstream)
+	}
+
+	local := plumbing.NewBranchReferenceName(branch)
+
+	// push.autoSetupRemote pushes a branch without upstream to the branch of
+	// the same name, setting it up as its upstream.
+	if b, ok := cfg.Branches[branch]; (!ok || b.Merge == "") && mode != "nothing" {
+		if auto, _ := cfg.GetBool("push.autoSetupRemote"); auto {
+			return []config.RefSpec{config.RefSpec(local + ":" + local)}, true, nil
+		}
+	}
+
+	dst, err := pushDestination(cfg, branch, o.RemoteName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return []config.RefSpec{config.RefSpec(local + ":" + dst)}, false, nil
+}
+
+// matchingRefSpecs returns the refspecs pushing the local branches to the
+// branches of the same name of the remote, as push.default=matching does.
+func (r *Repository) matchingRefSpecs(ctx context.Context, remote *Remote, o *PushOptions) ([]config.RefSpec, error) {
+	if o.RemoteURL != "" {
+		remote = NewRemote(r.Storer, &config.RemoteConfig{Name: o.RemoteName, URLs: []string{o.RemoteURL}})
+	}
+
+	refs, err := remote.ListContext(ctx, &ListOptions{
+		Auth:            o.Auth,
+		InsecureSkipTLS: o.InsecureSkipTLS,
+		CABundle:        o.CABundle,
+		ProxyOptions:    o.ProxyOptions,
+	})
+	if err != nil && err != transport.ErrEmptyRemoteRepository {
+		return nil, err
+	}
+
+	var specs []config.RefSpec
+	for _, ref := range refs {
+		if !ref.Name().IsBranch() {
+			continue
+		}
+
+		if _, err := r.Storer.Reference(ref.Name()); err != nil {
+			continue
+		}
+
+		specs = append(specs, config.RefSpec(ref.Name()+":"+ref.Name()))
+	}
+
+	return specs, nil
+}
+
+// setPushUpstream sets the remote branches the local branches are pushed to
+// by the refspecs as their upstream, as git push --set-upstream does.
+func (r *Repository) setPushUpstream(remote string, specs []config.RefSpec) error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	for _, rs := range specs {
+		if rs.IsWildcard() || rs.IsDelete() {
+			continue
+		}
+
+		src, dst := plumbing.ReferenceName(rs.Src()), rs.Dst("")
+		if !src.IsBranch() || !dst.IsBranch() {
+			continue
+		}
+
+		name := src.Short()
+		b, ok := cfg.Branches[name]
+		if !ok {
+			b = &config.Branch{Name: name}
+			cfg.Branches[name] = b
+		}
+
+		b.Remote, b.Merge, changed = remote, dst, true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return r.SetConfig(cfg)
 }
 
 // Log returns the commit history from the given LogOptions.
 func (r *Repository) Log(o *LogOptions) (object.CommitIter, error) {
+	return r.LogContext(context.Background(), o)
+}
+
+// LogContext returns the commit history from the given LogOptions, as Log
+// does. The context is checked for each commit returned by the iterator,
+// which returns its error once it is cancelled, and for each commit read by
+// LogOptions.Reverse.
+func (r *Repository) LogContext(ctx context.Context, o *LogOptions) (object.CommitIter, error) {
 	fn := commitIterFunc(o.Order)
-	if fn == nil {
+	if fn == nil && !o.Order.topological() {
 		return nil, fmt.Errorf("invalid Order=%v", o.Order)
 	}
 
@@ -1246,22 +2367,45 @@ func (r *Repository) Log(o *LogOptions) (object.CommitIter, error) {
 		it  object.CommitIter
 		err error
 	)
-	if o.All {
+	graph := r.logCommitGraphFor(o)
+	switch {
+	case o.Order.topological():
+		it, err = r.logTopological(graph, o)
+	case o.All:
 		it, err = r.logAll(fn)
-	} else {
+	case o.Order == LogOrderCommitterTime && graph != nil:
+		it, err = r.logCommitGraph(graph, o.From)
+	default:
 		it, err = r.log(o.From, fn)
 	}
 
 	if err != nil {
+		if graph != nil {
+			_ = graph.Close()
+		}
+
 		return nil, err
 	}
 
-	if o.FileName != nil {
-		// for `git log --all` also check parent (if the next commit comes from the real parent)
-		it = r.logWithFile(*o.FileName, it, o.All)
-	}
-	if o.PathFilter != nil {
-		it = r.logWithPathFilter(o.PathFilter, it, o.All)
+	// for `git log --all`, and the topological orders, also check parent (if
+	// the next commit comes from the real parent)
+	checkParent := o.All || o.Order.topological()
+	if o.simplifiesHistory() {
+		it, err = r.logWithHistorySimplification(it, o)
+		if err != nil {
+			if graph != nil {
+				_ = graph.Close()
+			}
+
+			return nil, err
+		}
+	} else {
+		if o.FileName != nil {
+			it = r.logWithFile(*o.FileName, it, checkParent, changedPathsHint(graph, []string{*o.FileName}))
+		}
+		if o.PathFilter != nil || len(o.Paths) > 0 {
+			it = r.logWithPathFilter(logPathFilter(o.Paths, o.PathFilter), it, checkParent, changedPathsHint(graph, o.Paths))
+		}
 	}
 
 	if o.Since != nil || o.Until != nil {
@@ -1269,18 +2413,35 @@ func (r *Repository) Log(o *LogOptions) (object.CommitIter, error) {
 		it = r.logWithLimit(it, limitOptions)
 	}
 
-	return it, nil
-}
+	if graph != nil {
+		it = &commitGraphIter{CommitIter: it, graph: graph}
+	}
 
-func (r *Repository) log(from plumbing.Hash, commitIterFunc func(*object.Commit) object.CommitIter) (object.CommitIter, error) {
-	h := from
-	if from == plumbing.ZeroHash {
-		head, err := r.Head()
+	if ctx.Done() != nil {
+		it = &contextCommitIter{CommitIter: it, ctx: ctx}
+	}
+
+	if o.UseMailmap {
+		m, err := r.Mailmap()
 		if err != nil {
+			it.Close()
 			return nil, err
 		}
 
-		h = head.Hash()
+		it = &mailmapCommitIter{CommitIter: it, mailmap: m}
+	}
+
+	if o.Reverse {
+		return logReverse(it)
+	}
+
+	return it, nil
+}
+
+func (r *Repository) log(from plumbing.Hash, commitIterFunc func(*object.Commit) object.CommitIter) (object.CommitIter, error) {
+	h, err := r.logStart(from)
+	if err != nil {
+		return nil, err
 	}
 
 	commit, err := r.CommitObject(h)
@@ -1290,32 +2451,164 @@ func (r *Repository) log(from plumbing.Hash, commitIterFunc func(*object.Commit)
 	return commitIterFunc(commit), nil
 }
 
+// logStart returns the commit the history starts from, HEAD unless from is
+// given.
+func (r *Repository) logStart(from plumbing.Hash) (plumbing.Hash, error) {
+	if from != plumbing.ZeroHash {
+		return from, nil
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return head.Hash(), nil
+}
+
 func (r *Repository) logAll(commitIterFunc func(*object.Commit) object.CommitIter) (object.CommitIter, error) {
-	return object.NewCommitAllIter(r.Storer, commitIterFunc)
+	s, err := r.objectStorer()
+	if err != nil {
+		return nil, err
+	}
+
+	return object.NewCommitAllIter(s, commitIterFunc)
 }
 
-func (*Repository) logWithFile(fileName string, commitIter object.CommitIter, checkParent bool) object.CommitIter {
-	return object.NewCommitPathIterFromIter(
+func (*Repository) logWithFile(fileName string, commitIter object.CommitIter, checkParent bool, mayChange func(*object.Commit) bool) object.CommitIter {
+	return object.NewCommitPathIterFromIterWithHint(
 		func(path string) bool {
 			return path == fileName
 		},
+		mayChange,
 		commitIter,
 		checkParent,
 	)
 }
 
-func (*Repository) logWithPathFilter(pathFilter func(string) bool, commitIter object.CommitIter, checkParent bool) object.CommitIter {
-	return object.NewCommitPathIterFromIter(
+func (*Repository) logWithPathFilter(pathFilter func(string) bool, commitIter object.CommitIter, checkParent bool, mayChange func(*object.Commit) bool) object.CommitIter {
+	return object.NewCommitPathIterFromIterWithHint(
 		pathFilter,
+		mayChange,
 		commitIter,
 		checkParent,
 	)
 }
 
+// logPathFilter returns the filter matching the files at or under one of the
+// paths, if any, and matching filter, if any.
+func logPathFilter(paths []string, filter func(string) bool) func(string) bool {
+	if len(paths) == 0 {
+		return filter
+	}
+
+	return func(path string) bool {
+		if filter != nil && !filter(path) {
+			return false
+		}
+
+		for _, p := range paths {
+			p = strings.TrimSuffix(p, "/")
+			if path == p || strings.HasPrefix(path, p+"/") {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
 func (*Repository) logWithLimit(commitIter object.CommitIter, limitOptions object.LogLimitOptions) object.CommitIter {
 	return object.NewCommitLimitIterFromIter(commitIter, limitOptions)
 }
 
+// contextCommitIter is a commit iterator stopping once its context is
+// cancelled.
+type contextCommitIter struct {
+	object.CommitIter
+	ctx context.Context
+}
+
+func (i *contextCommitIter) Next() (*object.Commit, error) {
+	if err := i.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return i.CommitIter.Next()
+}
+
+func (i *contextCommitIter) ForEach(cb func(*object.Commit) error) error {
+	return i.CommitIter.ForEach(func(c *object.Commit) error {
+		if err := i.ctx.Err(); err != nil {
+			return err
+		}
+
+		return cb(c)
+	})
+}
+
+// logReverse returns the commits of the iterator in reverse order,
+// reading all of them first.
+func logReverse(it object.CommitIter) (object.CommitIter, error) {
+	var commits []*object.Commit
+	err := it.ForEach(func(c *object.Commit) error {
+		commits = append(commits, c)
+		return nil
+	})
+	it.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+
+	return &commitSliceIter{commits: commits}, nil
+}
+
+// commitSliceIter is an object.CommitIter over a slice of commits.
+type commitSliceIter struct {
+	commits []*object.Commit
+}
+
+func (i *commitSliceIter) Next() (*object.Commit, error) {
+	if len(i.commits) == 0 {
+		return nil, io.EOF
+	}
+
+	c := i.commits[0]
+	i.commits = i.commits[1:]
+	return c, nil
+}
+
+func (i *commitSliceIter) ForEach(cb func(*object.Commit) error) error {
+	for {
+		c, err := i.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err := cb(c); err != nil {
+			if err == storer.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+func (i *commitSliceIter) Close() {
+	i.commits = nil
+}
+
+// topological returns whether the order shows no parent before all its
+// children, which requires walking the commit graph.
+func (o LogOrder) topological() bool {
+	return o == LogOrderTopo || o == LogOrderAuthorDate
+}
+
 func commitIterFunc(order LogOrder) func(c *object.Commit) object.CommitIter {
 	switch order {
 	case LogOrderDefault:
@@ -1408,7 +2701,12 @@ func (r *Repository) Notes() (storer.ReferenceIter, error) {
 // TreeObject return a Tree with the given hash. If not found
 // plumbing.ErrObjectNotFound is returned
 func (r *Repository) TreeObject(h plumbing.Hash) (*object.Tree, error) {
-	return object.GetTree(r.Storer, h)
+	s, err := r.objectStorer()
+	if err != nil {
+		return nil, err
+	}
+
+	return object.GetTree(s, h)
 }
 
 // TreeObjects returns an unsorted TreeIter with all the trees in the repository
@@ -1424,7 +2722,12 @@ func (r *Repository) TreeObjects() (*object.TreeIter, error) {
 // CommitObject return a Commit with the given hash. If not found
 // plumbing.ErrObjectNotFound is returned.
 func (r *Repository) CommitObject(h plumbing.Hash) (*object.Commit, error) {
-	return object.GetCommit(r.Storer, h)
+	s, err := r.objectStorer()
+	if err != nil {
+		return nil, err
+	}
+
+	return object.GetCommit(s, h)
 }
 
 // CommitObjects returns an unsorted CommitIter with all the commits in the repository.
@@ -1440,8 +2743,13 @@ func (r *Repository) CommitObjects() (object.CommitIter, error) {
 // BlobObject returns a Blob with the given hash. If not found
 // plumbing.ErrObjectNotFound is returned.
 func (r *Repository) BlobObject(h plumbing.Hash) (*object.Blob, error) {
-	return object.GetBlob(r.Storer, h)
-}
+	s, err := r.objectStorer()
+	if err != nil {
+		return nil, err
+	}
+
+	return object.GetBlob(s, h)
+}
 
 // BlobObjects returns an unsorted BlobIter with all the blobs in the repository.
 func (r *Repository) BlobObjects() (*object.BlobIter, error) {
@@ -1457,7 +2765,12 @@ func (r *Repository) BlobObjects() (*object.BlobIter, error) {
 // plumbing.ErrObjectNotFound is returned. This method only returns
 // annotated Tags, no lightweight Tags.
 func (r *Repository) TagObject(h plumbing.Hash) (*object.Tag, error) {
-	return object.GetTag(r.Storer, h)
+	s, err := r.objectStorer()
+	if err != nil {
+		return nil, err
+	}
+
+	return object.GetTag(s, h)
 }
 
 // TagObjects returns a unsorted TagIter that can step through all of the annotated
@@ -1474,12 +2787,17 @@ func (r *Repository) TagObjects() (*object.TagIter, error) {
 // Object returns an Object with the given hash. If not found
 // plumbing.ErrObjectNotFound is returned.
 func (r *Repository) Object(t plumbing.ObjectType, h plumbing.Hash) (object.Object, error) {
-	obj, err := r.Storer.EncodedObject(t, h)
+	s, err := r.objectStorer()
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.EncodedObject(t, h)
 	if err != nil {
 		return nil, err
 	}
 
-	return object.DecodeObject(r.Storer, obj)
+	return object.DecodeObject(s, obj)
 }
 
 // Objects returns an unsorted ObjectIter with all the objects in the repository.
@@ -1497,6 +2815,134 @@ func (r *Repository) Head() (*plumbing.Reference, error) {
 	return storer.ResolveReference(r.Storer, plumbing.HEAD)
 }
 
+// HeadDescription describes the state of HEAD, as the first line of git
+// status does.
+type HeadDescription struct {
+	// Branch is the branch HEAD points to, empty if HEAD is detached.
+	Branch plumbing.ReferenceName
+	// Hash is the commit of HEAD, zero if Branch is unborn.
+	Hash plumbing.Hash
+	// Detached is true if HEAD points to a commit instead of a branch.
+	Detached bool
+	// DetachedAt is what HEAD was detached at: the target of the last
+	// checkout of the HEAD reflog, or else a tag pointing to its commit, or
+	// else its abbreviated hash.
+	DetachedAt string
+	// Moved is true if HEAD moved from the commit of DetachedAt since it was
+	// detached, such as by a commit.
+	Moved bool
+}
+
+// String returns the description as git status does, such as
+// "On branch master", "HEAD detached at v1.0" or "HEAD detached from
+// 6ecf0ef".
+func (d *HeadDescription) String() string {
+	switch {
+	case !d.Detached:
+		return fmt.Sprintf("On branch %s", d.Branch.Short())
+	case d.Moved:
+		return fmt.Sprintf("HEAD detached from %s", d.DetachedAt)
+	}
+
+	return fmt.Sprintf("HEAD detached at %s", d.DetachedAt)
+}
+
+// DescribeHead returns the description of the state of HEAD.
+func (r *Repository) DescribeHead() (*HeadDescription, error) {
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return nil, err
+	}
+
+	if head.Type() == plumbing.SymbolicReference {
+		d := &HeadDescription{Branch: head.Target()}
+		ref, err := storer.ResolveReference(r.Storer, plumbing.HEAD)
+		switch err {
+		case nil:
+			d.Hash = ref.Hash()
+		case plumbing.ErrReferenceNotFound:
+			// The branch is unborn.
+		default:
+			return nil, err
+		}
+
+		return d, nil
+	}
+
+	d := &HeadDescription{Hash: head.Hash(), Detached: true}
+	at, moved, err := r.lastDetachedAt(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	if at == "" {
+		if at, err = r.tagPointingTo(head.Hash()); err != nil {
+			return nil, err
+		}
+	}
+
+	if at == "" {
+		at = head.Hash().String()[:7]
+	}
+
+	d.DetachedAt, d.Moved = at, moved
+	return d, nil
+}
+
+// lastDetachedAt returns the target of the last checkout of the HEAD reflog,
+// and whether HEAD moved from it since, or an empty name without reflog.
+func (r *Repository) lastDetachedAt(head plumbing.Hash) (string, bool, error) {
+	entries, _, err := r.reflog(plumbing.HEAD.String())
+	if errors.Is(err, ErrReflogNotFound) {
+		return "", false, nil
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg, ok := strings.CutPrefix(entries[i].Message, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+
+		_, to, ok := strings.Cut(msg, " to ")
+		if !ok {
+			continue
+		}
+
+		return to, entries[i].New != head, nil
+	}
+
+	return "", false, nil
+}
+
+// tagPointingTo returns the short name of the first tag pointing to the
+// given commit, in alphabetical order, or an empty name if none does.
+func (r *Repository) tagPointingTo(commit plumbing.Hash) (string, error) {
+	iter, err := r.Tags()
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		h, err := r.ResolveRevision(plumbing.Revision(ref.Name().String() + "^{commit}"))
+		if err == nil && *h == commit {
+			names = append(names, ref.Name().Short())
+		}
+
+		return nil
+	})
+	if err != nil || len(names) == 0 {
+		return "", err
+	}
+
+	sort.Strings(names)
+	return names[0], nil
+}
+
 // Reference returns the reference for a given reference name. If resolved is
 // true, any symbolic reference will be resolved.
 func (r *Repository) Reference(name plumbing.ReferenceName, resolved bool) (
@@ -1509,6 +2955,98 @@ func (r *Repository) Reference(name plumbing.ReferenceName, resolved bool) (
 	return r.Storer.Reference(name)
 }
 
+// RefUpdate is an update of a reference made by Repository.UpdateRefs.
+type RefUpdate struct {
+	// Name is the name of the reference.
+	Name plumbing.ReferenceName
+	// New is the new hash of the reference, plumbing.ZeroHash deletes it.
+	New plumbing.Hash
+	// Old is the hash the reference must point to before the update, if it
+	// is not plumbing.ZeroHash.
+	Old plumbing.Hash
+	// Create requires the reference to not exist before the update.
+	Create bool
+}
+
+// UpdateRefs updates the given references all at once. If any of them can't
+// be updated, e.g. because it doesn't point to its Old hash anymore, none is
+// and a *storer.ReferenceTransactionError, with the error of each one of
+// them, is returned.
+//
+// The storers that aren't a storer.ReferenceTransactioner check all the
+// references first, and then update them one by one, restoring the ones
+// already updated if one of them fails.
+func (r *Repository) UpdateRefs(updates []RefUpdate) error {
+	var tx storer.ReferenceTransaction
+	if rt, ok := r.Storer.(storer.ReferenceTransactioner); ok {
+		tx = rt.BeginReferenceTransaction()
+	} else {
+		tx = storer.NewReferenceTransaction(r.updateRefsOneByOne)
+	}
+
+	for _, u := range updates {
+		switch {
+		case u.Create:
+			tx.Create(u.Name, u.New)
+		case u.New.IsZero():
+			tx.Delete(u.Name, u.Old)
+		default:
+			tx.Update(u.Name, u.New, u.Old)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) updateRefsOneByOne(updates []storer.ReferenceUpdate) error {
+	errs := make(map[plumbing.ReferenceName]error)
+	current := make([]*plumbing.Reference, len(updates))
+	for i, u := range updates {
+		ref, err := r.Storer.Reference(u.Name)
+		if err != nil && err != plumbing.ErrReferenceNotFound {
+			errs[u.Name] = err
+			continue
+		}
+
+		if !u.Matches(ref) {
+			errs[u.Name] = storage.ErrReferenceHasChanged
+			continue
+		}
+
+		current[i] = ref
+	}
+
+	if len(errs) != 0 {
+		return &storer.ReferenceTransactionError{Errors: errs}
+	}
+
+	for i, u := range updates {
+		var err error
+		if u.New.IsZero() {
+			err = r.Storer.RemoveReference(u.Name)
+		} else {
+			err = r.Storer.CheckAndSetReference(plumbing.NewHashReference(u.Name, u.New), current[i])
+		}
+
+		if err == nil {
+			continue
+		}
+
+		errs[u.Name] = err
+		for j := i - 1; j >= 0; j-- {
+			if current[j] != nil {
+				_ = r.Storer.SetReference(current[j])
+			} else {
+				_ = r.Storer.RemoveReference(updates[j].Name)
+			}
+		}
+
+		return &storer.ReferenceTransactionError{Errors: errs}
+	}
+
+	return nil
+}
+
 // References returns an unsorted ReferenceIter for all references.
 func (r *Repository) References() (storer.ReferenceIter, error) {
 	return r.Storer.IterReferences()
@@ -1525,10 +3063,29 @@ func (r *Repository) Worktree() (*Worktree, error) {
 }
 
 func expand_ref(s storer.ReferenceStorer, ref plumbing.ReferenceName) (*plumbing.Reference, error) {
+	if ref == plumbing.FetchHead {
+		if fs, ok := s.(storer.FetchHeadStorer); ok {
+			heads, err := fs.FetchHead()
+			if err != nil {
+				return nil, err
+			}
+
+			if len(heads) > 0 {
+				return plumbing.NewHashReference(ref, heads[0].Hash), nil
+			}
+		}
+	}
+
 	// For improving troubleshooting, this preserves the error for the provided `ref`,
 	// and returns the error for that specific ref in case all parse rules fails.
 	var ret error
-	for _, rule := range plumbing.RefRevParseRules {
+	for i, rule := range plumbing.RefRevParseRules {
+		// As git does, only the names of pseudo-references, such as
+		// ORIG_HEAD, are read at the root of the git directory.
+		if i == 0 && !strings.HasPrefix(ref.String(), "refs/") && !isPseudoRefName(ref.String()) {
+			continue
+		}
+
 		resolvedRef, err := storer.ResolveReference(s, plumbing.ReferenceName(fmt.Sprintf(rule, ref)))
 
 		if err == nil {
@@ -1541,11 +3098,34 @@ func expand_ref(s storer.ReferenceStorer, ref plumbing.ReferenceName) (*plumbing
 	return nil, ret
 }
 
-// ResolveRevision resolves revision to corresponding hash. It will always
-// resolve to a commit hash, not a tree or annotated tag.
+// isPseudoRefName returns true if the name is made of upper case letters and
+// underscores, such as HEAD or FETCH_HEAD.
+func isPseudoRefName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		if (r < 'A' || r > 'Z') && r != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ResolveRevision resolves revision to corresponding hash. It resolves to a
+// commit hash, not an annotated tag, unless a path is given, in which case it
+// resolves to the hash of the blob or tree at that path.
+//
+// Implemented resolvers : HEAD, pseudo-references (FETCH_HEAD, ORIG_HEAD, MERGE_HEAD, ...), branch, tag, heads/branch, refs/heads/branch,
+// refs/tags/tag, refs/remotes/origin/branch, refs/remotes/origin/HEAD, tilde and caret (HEAD~1, master~^, tag~2, ref/heads/master~1, ...), selection by text (HEAD^{/fix nasty bug}, :/fix nasty bug), hash (prefix and full)
+// and, from the reflogs, nth prior value (HEAD@{1}, master@{2}, @{1}), value at a date (master@{yesterday}, HEAD@{2.weeks.ago}, @{2016-12-16}) and nth previous branch (@{-1}),
+// upstream and push destination of a branch (master@{upstream}, @{u}, @{push}), path in a revision (HEAD:README, master~2:dir) and path in the index (:README, :2:README)
 //
-// Implemented resolvers : HEAD, branch, tag, heads/branch, refs/heads/branch,
-// refs/tags/tag, refs/remotes/origin/branch, refs/remotes/origin/HEAD, tilde and caret (HEAD~1, master~^, tag~2, ref/heads/master~1, ...), selection by text (HEAD^{/fix nasty bug}), hash (prefix and full)
+// The errors tell which part failed to resolve: plumbing.ErrReferenceNotFound
+// for the reference, *PathNotFoundError for the path and *NoMatchError for
+// the selection by text.
 func (r *Repository) ResolveRevision(in plumbing.Revision) (*plumbing.Hash, error) {
 	rev := in.String()
 	if rev == "" {
@@ -1561,6 +3141,23 @@ func (r *Repository) ResolveRevision(in plumbing.Revision) (*plumbing.Hash, erro
 
 	var commit *object.Commit
 
+	// The @{...} statements are resolved along with the reference before
+	// them, which is read from the reflog or the config, even if it doesn't
+	// resolve anymore.
+	h, n, err := r.resolveAtRevision(items)
+	if err != nil {
+		return &plumbing.ZeroHash, err
+	}
+
+	if n > 0 {
+		commit, err = r.CommitObject(h)
+		if err != nil {
+			return &plumbing.ZeroHash, err
+		}
+
+		items = items[n:]
+	}
+
 	for _, item := range items {
 		switch item := item.(type) {
 		case revision.Ref:
@@ -1647,35 +3244,32 @@ func (r *Repository) ResolveRevision(in plumbing.Revision) (*plumbing.Hash, erro
 				commit = c
 			}
 		case revision.CaretReg:
-			history := object.NewCommitPreorderIter(commit, nil, nil)
-
-			re := item.Regexp
-			negate := item.Negate
-
-			var c *object.Commit
-
-			err := history.ForEach(func(hc *object.Commit) error {
-				if !negate && re.MatchString(hc.Message) {
-					c = hc
-					return storer.ErrStop
-				}
-
-				if negate && !re.MatchString(hc.Message) {
-					c = hc
-					return storer.ErrStop
-				}
+			c, err := matchCommit([]*object.Commit{commit}, item.Regexp, item.Negate)
+			if err != nil {
+				return &plumbing.ZeroHash, err
+			}
 
-				return nil
-			})
+			commit = c
+		case revision.ColonReg:
+			commits, err := r.refCommits()
 			if err != nil {
 				return &plumbing.ZeroHash, err
 			}
 
-			if c == nil {
-				return &plumbing.ZeroHash, fmt.Errorf("no commit message match regexp: %q", re.String())
+			c, err := matchCommit(commits, item.Regexp, item.Negate)
+			if err != nil {
+				return &plumbing.ZeroHash, err
 			}
 
 			commit = c
+		case revision.ColonPath:
+			if commit == nil {
+				return r.resolveIndexPath(item.Path, 0)
+			}
+
+			return resolveTreePath(commit, item.Path, rev[:len(rev)-len(item.Path)-1])
+		case revision.ColonStagePath:
+			return r.resolveIndexPath(item.Path, item.Stage)
 		}
 	}
 
@@ -1686,6 +3280,488 @@ func (r *Repository) ResolveRevision(in plumbing.Revision) (*plumbing.Hash, erro
 	return &commit.Hash, nil
 }
 
+// resolveAtRevision resolves the @{...} statement at the beginning of the
+// given items, if any, and returns the hash it resolves to and the number of
+// items it used.
+func (r *Repository) resolveAtRevision(items []revision.Revisioner) (plumbing.Hash, int, error) {
+	var name string
+	var n int
+	if len(items) > 1 {
+		if ref, ok := items[0].(revision.Ref); ok {
+			name, n = string(ref), 1
+		}
+	}
+
+	if len(items) <= n {
+		return plumbing.ZeroHash, 0, nil
+	}
+
+	switch item := items[n].(type) {
+	case revision.AtReflog:
+		entries, refName, err := r.reflog(name)
+		if err != nil {
+			return plumbing.ZeroHash, 0, err
+		}
+
+		// @{0} is the newest entry, and the one after the oldest entry
+		// is the value before it, if there was one.
+		switch {
+		case item.Depth < len(entries):
+			return entries[len(entries)-1-item.Depth].New, n + 1, nil
+		case item.Depth == len(entries) && !entries[0].Old.IsZero():
+			return entries[0].Old, n + 1, nil
+		}
+
+		return plumbing.ZeroHash, 0, fmt.Errorf("log for '%s' only has %d entries: %w",
+			refName.Short(), len(entries), ErrReflogTooShort)
+	case revision.AtDate:
+		entries, _, err := r.reflog(name)
+		if err != nil {
+			return plumbing.ZeroHash, 0, err
+		}
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			if !entries[i].When.After(item.Date) {
+				return entries[i].New, n + 1, nil
+			}
+		}
+
+		// As git does, the date before the reflog resolves to the oldest
+		// known value.
+		if !entries[0].Old.IsZero() {
+			return entries[0].Old, n + 1, nil
+		}
+
+		return entries[0].New, n + 1, nil
+	case revision.AtCheckout:
+		branch, err := r.previousCheckout(item.Depth)
+		if err != nil {
+			return plumbing.ZeroHash, 0, err
+		}
+
+		if ref, err := r.Reference(plumbing.NewBranchReferenceName(branch), true); err == nil {
+			return ref.Hash(), n + 1, nil
+		}
+
+		h, err := r.ResolveRevision(plumbing.Revision(branch))
+		if err != nil {
+			return plumbing.ZeroHash, 0, err
+		}
+
+		return *h, n + 1, nil
+	case revision.AtUpstream, revision.AtPush:
+		branch, err := r.branchName(name)
+		if err != nil {
+			return plumbing.ZeroHash, 0, err
+		}
+
+		cfg, err := r.Config()
+		if err != nil {
+			return plumbing.ZeroHash, 0, err
+		}
+
+		var tracking plumbing.ReferenceName
+		if _, ok := item.(revision.AtPush); ok {
+			tracking, err = pushTrackingName(cfg, branch)
+		} else {
+			tracking, err = upstreamTrackingName(cfg, branch)
+		}
+
+		if err != nil {
+			return plumbing.ZeroHash, 0, err
+		}
+
+		ref, err := r.Reference(tracking, true)
+		if err != nil {
+			return plumbing.ZeroHash, 0, fmt.Errorf("%s: %w", tracking, err)
+		}
+
+		return ref.Hash(), n + 1, nil
+	}
+
+	return plumbing.ZeroHash, 0, nil
+}
+
+// reflog returns the non-empty reflog of the reference with the given name,
+// expanded as git does, or of the current branch if the name is empty.
+func (r *Repository) reflog(name string) ([]*reflog.Entry, plumbing.ReferenceName, error) {
+	var candidates []plumbing.ReferenceName
+	if name == "" {
+		head, err := r.Storer.Reference(plumbing.HEAD)
+		if err != nil {
+			return nil, "", err
+		}
+
+		name = plumbing.HEAD.String()
+		if head.Type() == plumbing.SymbolicReference {
+			name = head.Target().String()
+		}
+
+		candidates = append(candidates, plumbing.ReferenceName(name))
+	} else {
+		for _, rule := range plumbing.RefRevParseRules {
+			candidates = append(candidates, plumbing.ReferenceName(fmt.Sprintf(rule, name)))
+		}
+	}
+
+	if rr, ok := r.Storer.(storer.ReflogReader); ok {
+		for _, candidate := range candidates {
+			entries, err := rr.Reflog(candidate)
+			if err != nil {
+				return nil, "", err
+			}
+
+			if len(entries) > 0 {
+				return entries, candidate, nil
+			}
+		}
+	}
+
+	return nil, "", fmt.Errorf("no log for '%s': %w", name, ErrReflogNotFound)
+}
+
+// previousCheckout returns the branch, or the hash, checked out before the
+// nth previous checkout, read from the HEAD reflog.
+func (r *Repository) previousCheckout(n int) (string, error) {
+	entries, _, err := r.reflog(plumbing.HEAD.String())
+	if err != nil {
+		return "", err
+	}
+
+	found := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg, ok := strings.CutPrefix(entries[i].Message, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+
+		from, _, ok := strings.Cut(msg, " to ")
+		if !ok {
+			continue
+		}
+
+		found++
+		if found == n {
+			return from, nil
+		}
+	}
+
+	return "", fmt.Errorf("HEAD only has %d checkouts in its log: %w", found, ErrReflogTooShort)
+}
+
+// branchName returns the short name of the branch with the given name, or of
+// the current branch if the name is empty or HEAD.
+func (r *Repository) branchName(name string) (string, error) {
+	if name == "" || name == plumbing.HEAD.String() {
+		head, err := r.Storer.Reference(plumbing.HEAD)
+		if err != nil {
+			return "", err
+		}
+
+		if head.Type() != plumbing.SymbolicReference || !head.Target().IsBranch() {
+			return "", fmt.Errorf("HEAD does not point to a branch: %w", ErrNoUpstream)
+		}
+
+		return head.Target().Short(), nil
+	}
+
+	name = strings.TrimPrefix(name, "refs/heads/")
+	if _, err := r.Storer.Reference(plumbing.NewBranchReferenceName(name)); err != nil {
+		return "", fmt.Errorf("no such branch: '%s': %w", name, err)
+	}
+
+	return name, nil
+}
+
+// upstreamTrackingName returns the name of the reference tracking the
+// upstream of the given branch, from its branch.<name>.remote and
+// branch.<name>.merge config.
+func upstreamTrackingName(cfg *config.Config, branch string) (plumbing.ReferenceName, error) {
+	b, ok := cfg.Branches[branch]
+	if !ok || b.Remote == "" || b.Merge == "" {
+		return "", fmt.Errorf("no upstream configured for branch '%s': %w", branch, ErrNoUpstream)
+	}
+
+	// A branch can track another local branch.
+	if b.Remote == "." {
+		return b.Merge, nil
+	}
+
+	if name := remoteTrackingName(cfg, b.Remote, b.Merge); name != "" {
+		return name, nil
+	}
+
+	return "", fmt.Errorf("upstream branch '%s' not stored as a remote-tracking branch: %w",
+		b.Merge, plumbing.ErrReferenceNotFound)
+}
+
+// pushTrackingName returns the name of the reference tracking the
+// destination of a push of the given branch, following push.default as git
+// does, "simple" by default.
+func pushTrackingName(cfg *config.Config, branch string) (plumbing.ReferenceName, error) {
+	remote := pushRemoteName(cfg, branch)
+
+	dst := plumbing.NewBranchReferenceName(branch)
+	if rc, ok := cfg.Remotes[remote]; !ok || !rc.Mirror {
+		var err error
+		if dst, err = pushDestination(cfg, branch, remote); err != nil {
+			return "", err
+		}
+	}
+
+	// A branch can be pushed to another local branch.
+	if remote == "." {
+		return dst, nil
+	}
+
+	if name := remoteTrackingName(cfg, remote, dst); name != "" {
+		return name, nil
+	}
+
+	return "", fmt.Errorf("push destination '%s' on remote '%s' has no local tracking branch: %w",
+		dst, remote, plumbing.ErrReferenceNotFound)
+}
+
+// pushRemoteName returns the name of the remote the given branch is pushed
+// to by default: its branch.<name>.pushRemote, remote.pushDefault or
+// branch.<name>.remote, and origin otherwise.
+func pushRemoteName(cfg *config.Config, branch string) string {
+	if remote := rawOption(cfg, "branch", branch, "pushRemote"); remote != "" {
+		return remote
+	}
+
+	if remote := rawOption(cfg, "remote", formatcfg.NoSubsection, "pushDefault"); remote != "" {
+		return remote
+	}
+
+	if b, ok := cfg.Branches[branch]; ok && b.Remote != "" {
+		return b.Remote
+	}
+
+	return DefaultRemoteName
+}
+
+// pushDestination returns the reference of the remote the given branch is
+// pushed to when no refspecs are given, following push.default as git does,
+// "simple" by default. With "matching" the branch is pushed to the branch of
+// the same name.
+func pushDestination(cfg *config.Config, branch, remote string) (plumbing.ReferenceName, error) {
+	local := plumbing.NewBranchReferenceName(branch)
+
+	upstreamRemote := DefaultRemoteName
+	b, ok := cfg.Branches[branch]
+	if ok && b.Remote != "" {
+		upstreamRemote = b.Remote
+	}
+
+	upstream := func() (plumbing.ReferenceName, error) {
+		if !ok || b.Remote == "" || b.Merge == "" {
+			return "", fmt.Errorf("the current branch '%s' has no upstream branch: %w", branch, ErrNoUpstream)
+		}
+
+		if remote != upstreamRemote {
+			return "", fmt.Errorf("pushing to remote '%s', which is not the upstream of the branch '%s', "+
+				"without a refspec: %w", remote, branch, ErrNoUpstream)
+		}
+
+		return b.Merge, nil
+	}
+
+	mode := strings.ToLower(rawOption(cfg, "push", formatcfg.NoSubsection, "default"))
+	switch mode {
+	case "nothing":
+		return "", fmt.Errorf("push has no destination (push.default is 'nothing'): %w", ErrNoUpstream)
+	case "matching", "current":
+		return local, nil
+	case "upstream", "tracking":
+		return upstream()
+	case "", "simple":
+	default:
+		return "", fmt.Errorf("malformed value for push.default: '%s'", mode)
+	}
+
+	// In a triangular workflow, pushing to another remote than the upstream
+	// one, simple pushes to the branch of the same name, as current does.
+	if remote != upstreamRemote {
+		return local, nil
+	}
+
+	dst, err := upstream()
+	if err != nil {
+		return "", err
+	}
+
+	if dst != local {
+		return "", fmt.Errorf("the upstream branch '%s' of the current branch '%s' does not match its name, "+
+			"use the refspec '%s:%s' to push to the upstream branch, or '%s:%s' to push to the branch of "+
+			"the same name: %w", dst.Short(), branch, local, dst, local, local, ErrNoUpstream)
+	}
+
+	return dst, nil
+}
+
+// rawOption returns the value of the given option of the raw config, without
+// adding the section or subsection if missing.
+func rawOption(cfg *config.Config, section, subsection, key string) string {
+	if !cfg.Raw.HasSection(section) {
+		return ""
+	}
+
+	sec := cfg.Raw.Section(section)
+	if subsection == formatcfg.NoSubsection {
+		return sec.Option(key)
+	}
+
+	if !sec.HasSubsection(subsection) {
+		return ""
+	}
+
+	return sec.Subsection(subsection).Option(key)
+}
+
+// remoteTrackingName returns the name of the reference tracking the given
+// reference of the remote, from the fetch refspecs of the remote, or an empty
+// name if there is none.
+func remoteTrackingName(cfg *config.Config, remote string, name plumbing.ReferenceName) plumbing.ReferenceName {
+	rc, ok := cfg.Remotes[remote]
+	if !ok {
+		return ""
+	}
+
+	for _, rs := range rc.Fetch {
+		if rs.Excludes(name) {
+			return ""
+		}
+	}
+
+	for _, rs := range rc.Fetch {
+		if rs.Match(name) {
+			return rs.Dst(name)
+		}
+	}
+
+	return ""
+}
+
+// refCommits returns the commits the references and HEAD point to, peeling
+// the annotated tags, as the starting points of a :/<regexp> search.
+func (r *Repository) refCommits() ([]*object.Commit, error) {
+	iter, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []*object.Commit
+	seen := make(map[plumbing.Hash]bool)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference && ref.Name() != plumbing.HEAD {
+			return nil
+		}
+
+		resolved, err := storer.ResolveReference(r.Storer, ref.Name())
+		if err != nil {
+			// HEAD of an unborn branch.
+			return nil
+		}
+
+		obj, err := r.Object(plumbing.AnyObject, resolved.Hash())
+		if err != nil {
+			return nil
+		}
+
+		for {
+			tag, ok := obj.(*object.Tag)
+			if !ok {
+				break
+			}
+
+			if obj, err = tag.Object(); err != nil {
+				return nil
+			}
+		}
+
+		if c, ok := obj.(*object.Commit); ok && !seen[c.Hash] {
+			seen[c.Hash] = true
+			commits = append(commits, c)
+		}
+
+		return nil
+	})
+
+	return commits, err
+}
+
+// matchCommit returns the youngest commit reachable from the given ones
+// whose message matches, or doesn't if negate is set, the regexp.
+func matchCommit(commits []*object.Commit, re *regexp.Regexp, negate bool) (*object.Commit, error) {
+	var match *object.Commit
+	for _, c := range commits {
+		err := object.NewCommitIterCTime(c, nil, nil).ForEach(func(hc *object.Commit) error {
+			if match != nil && !hc.Committer.When.After(match.Committer.When) {
+				return storer.ErrStop
+			}
+
+			if re.MatchString(hc.Message) != negate {
+				match = hc
+				return storer.ErrStop
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if match == nil {
+		return nil, &NoMatchError{Regexp: re.String()}
+	}
+
+	return match, nil
+}
+
+// resolveTreePath returns the hash of the blob or tree at the given path of
+// the tree of the commit, the root tree for an empty path.
+func resolveTreePath(commit *object.Commit, p string, rev string) (*plumbing.Hash, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return &plumbing.ZeroHash, err
+	}
+
+	clean := path.Clean(p)
+	if clean == "." {
+		return &tree.Hash, nil
+	}
+
+	entry, err := tree.FindEntry(clean)
+	switch err {
+	case nil:
+		return &entry.Hash, nil
+	case object.ErrEntryNotFound, object.ErrDirectoryNotFound:
+		return &plumbing.ZeroHash, &PathNotFoundError{Path: p, Revision: rev}
+	}
+
+	return &plumbing.ZeroHash, err
+}
+
+// resolveIndexPath returns the hash of the blob at the given path and stage
+// of the index.
+func (r *Repository) resolveIndexPath(p string, stage int) (*plumbing.Hash, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return &plumbing.ZeroHash, err
+	}
+
+	clean := path.Clean(p)
+	for _, e := range idx.Entries {
+		if e.Name == clean && int(e.Stage) == stage {
+			return &e.Hash, nil
+		}
+	}
+
+	return &plumbing.ZeroHash, &PathNotFoundError{Path: p, Stage: stage}
+}
+
 // resolveHashPrefix returns a list of potential hashes that the given string
 // is a prefix of. It quietly swallows errors, returning nil.
 func (r *Repository) resolveHashPrefix(hashStr string) []plumbing.Hash {
@@ -1729,6 +3805,58 @@ func (r *Repository) resolveHashPrefix(hashStr string) []plumbing.Hash {
 	return hashes
 }
 
+// AddAlternate adds the objects directory of the local repository at the
+// given path to the objects/info/alternates file of the repository. The
+// objects of that repository are then available without being copied, and
+// aren't fetched from the remotes anymore.
+func (r *Repository) AddAlternate(path string) error {
+	if !filepath.IsAbs(path) {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+
+		path = abs
+	}
+
+	alt, err := PlainOpen(path)
+	if err != nil {
+		return fmt.Errorf("failed to open alternate repository: %w", err)
+	}
+
+	// The git directory isn't always the .git directory of the worktree.
+	st, ok := alt.Storer.(*filesystem.Storage)
+	if !ok {
+		return ErrAlternatePathNotSupported
+	}
+
+	if err := r.Storer.AddAlternate(st.Filesystem().Root()); err != nil {
+		return fmt.Errorf("failed to add alternate file to git objects dir: %w", err)
+	}
+
+	return nil
+}
+
+// dissociate copies the objects borrowed from the alternates into the
+// repository, repacking all the reachable objects, and removes the
+// alternates, as git clone --dissociate does.
+func (r *Repository) dissociate() error {
+	type alternatesRemover interface {
+		RemoveAlternates() error
+	}
+
+	ar, ok := r.Storer.(alternatesRemover)
+	if !ok {
+		return nil
+	}
+
+	if err := r.RepackObjects(&RepackConfig{}); err != nil {
+		return fmt.Errorf("failed to copy the objects of the alternates: %w", err)
+	}
+
+	return ar.RemoveAlternates()
+}
+
 type RepackConfig struct {
 	// UseRefDeltas configures whether packfile encoder will use reference deltas.
 	// By default OFSDeltaObject is used.
@@ -1773,7 +3901,8 @@ func (r *Repository) RepackObjects(cfg *RepackConfig) (err error) {
 
 // Merge merges the reference branch into the current branch.
 //
-// If the merge is not possible (or supported) returns an error without changing
+// The previous commit of the current branch is saved as ORIG_HEAD. If the
+// merge is not possible (or supported) returns an error without changing
 // the HEAD for the current branch. Possible errors include:
 //   - The merge strategy is not supported.
 //   - The specific strategy cannot be used (e.g. using FastForwardMerge when one is not possible).
@@ -1803,14 +3932,22 @@ func (r *Repository) Merge(ref plumbing.Reference, opts MergeOptions) error {
 		return ErrFastForwardMergeNotPossible
 	}
 
-	return r.Storer.SetReference(plumbing.NewHashReference(head.Name(), ref.Hash()))
+	if err := r.Storer.SetReference(plumbing.NewHashReference(origHeadRef, head.Hash())); err != nil {
+		return err
+	}
+
+	if err := r.Storer.SetReference(plumbing.NewHashReference(head.Name(), ref.Hash())); err != nil {
+		return err
+	}
+
+	return r.runPostMergeHook(context.Background(), opts.Hooks)
 }
 
 // createNewObjectPack is a helper for RepackObjects taking care
 // of creating a new pack. It is used so the PackfileWriter
 // deferred close has the right scope.
 func (r *Repository) createNewObjectPack(cfg *RepackConfig) (h plumbing.Hash, err error) {
-	ow := newObjectWalker(r.Storer)
+	ow := newObjectWalker(context.Background(), r.Storer)
 	err = ow.walkAllRefs()
 	if err != nil {
 		return h, err
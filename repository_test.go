@@ -6,12 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"os/user"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -23,16 +26,21 @@ import (
 	openpgperr "github.com/ProtonMail/go-crypto/openpgp/errors"
 
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/internal/test"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/reftable"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
 	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/go-git/go-git/v5/storage/memory"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/helper/chroot"
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-billy/v5/util"
@@ -275,6 +283,372 @@ func (s *RepositorySuite) TestCloneMirror(c *C) {
 	c.Assert(cfg.Remotes[DefaultRemoteName].Mirror, Equals, true)
 }
 
+func (s *RepositorySuite) TestCloneRemoteHead(c *C) {
+	url := s.GetBasicLocalRepositoryURL()
+	for _, bare := range []bool{false, true} {
+		r, err := PlainClone(c.MkDir(), bare, &CloneOptions{URL: url})
+		c.Assert(err, IsNil)
+
+		head, err := r.Reference("refs/remotes/origin/HEAD", false)
+		c.Assert(err, IsNil)
+		c.Assert(head.Type(), Equals, plumbing.SymbolicReference)
+		c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/remotes/origin/master"))
+	}
+
+	// The references of a mirror are the ones of the remote.
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{URL: url, Mirror: true})
+	c.Assert(err, IsNil)
+
+	head, err := r.Reference("refs/remotes/origin/HEAD", false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Type(), Equals, plumbing.HashReference)
+}
+
+func (s *RepositorySuite) TestCloneEmptyRepository(c *C) {
+	path := c.MkDir()
+	_, err := PlainInit(path, true)
+	c.Assert(err, IsNil)
+
+	s.testCloneEmptyRepository(c, path, path)
+}
+
+func (s *RepositorySuite) TestCloneEmptyRepositoryGitProtocol(c *C) {
+	base := c.MkDir()
+	path := filepath.Join(base, "empty.git")
+	_, err := PlainInit(path, true)
+	c.Assert(err, IsNil)
+
+	url, stop := gitDaemon(c, base)
+	defer stop()
+
+	s.testCloneEmptyRepository(c, path, url+"/empty.git")
+}
+
+// testCloneEmptyRepository clones the empty repository at path, served at
+// url, then pushes its first commit.
+func (s *RepositorySuite) testCloneEmptyRepository(c *C, path, url string) {
+	dir := c.MkDir()
+	r, err := PlainClone(dir, false, &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+
+	// git-upload-pack doesn't advertise the unborn branch of HEAD, HEAD is
+	// kept as set by Init.
+	head, err := r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.Master)
+
+	_, err = r.Head()
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Branches[plumbing.Master.Short()].Remote, Equals, DefaultRemoteName)
+	c.Assert(cfg.Branches[plumbing.Master.Short()].Merge, Equals, plumbing.Master)
+
+	c.Assert(r.Fetch(&FetchOptions{}), Equals, NoErrAlreadyUpToDate)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	c.Assert(w.Pull(&PullOptions{}), Equals, transport.ErrEmptyRemoteRepository)
+
+	// The first push creates the current branch.
+	c.Assert(util.WriteFile(w.Filesystem, "foo", []byte("foo"), 0o644), IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+	hash, err := w.Commit("foo", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.Push(&PushOptions{}), IsNil)
+
+	server, err := PlainOpen(path)
+	c.Assert(err, IsNil)
+	ref, err := server.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, hash)
+}
+
+// gitDaemon serves the repositories in base with `git daemon`, returning the
+// git:// URL of base, and a function stopping the daemon.
+func gitDaemon(c *C, base string) (string, func()) {
+	if runtime.GOOS == "windows" {
+		c.Skip("git for windows has issues with the git:// protocol")
+	}
+
+	out, err := exec.Command("git", "daemon", "--help").CombinedOutput()
+	if err != nil && bytes.Contains(out, []byte("'daemon' is not a git command")) {
+		c.Skip("git daemon not found")
+	}
+
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+	addr := l.Addr().String()
+	c.Assert(l.Close(), IsNil)
+
+	_, port, err := net.SplitHostPort(addr)
+	c.Assert(err, IsNil)
+
+	cmd := exec.Command("git", "daemon",
+		"--base-path="+base,
+		"--export-all",
+		"--enable=receive-pack",
+		"--reuseaddr",
+		"--listen=localhost",
+		"--port="+port,
+	)
+	c.Assert(cmd.Start(), IsNil)
+	stop := func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}
+
+	// Wait for the daemon to accept the connections.
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			break
+		}
+
+		if i == 50 {
+			stop()
+			c.Fatalf("git daemon not listening: %s", err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return "git://" + addr, stop
+}
+
+func (s *RepositorySuite) TestCloneEmptyRepositoryInitialBranch(c *C) {
+	url := c.MkDir()
+	_, err := PlainInit(url, true)
+	c.Assert(err, IsNil)
+
+	s.testCloneEmptyRepositoryInitialBranch(c, url)
+}
+
+func (s *RepositorySuite) TestCloneEmptyRepositoryInitialBranchGitProtocol(c *C) {
+	base := c.MkDir()
+	_, err := PlainInit(filepath.Join(base, "empty.git"), true)
+	c.Assert(err, IsNil)
+
+	url, stop := gitDaemon(c, base)
+	defer stop()
+
+	s.testCloneEmptyRepositoryInitialBranch(c, url+"/empty.git")
+}
+
+func (s *RepositorySuite) testCloneEmptyRepositoryInitialBranch(c *C, url string) {
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{
+		URL:           url,
+		InitialBranch: "refs/heads/trunk",
+	})
+	c.Assert(err, IsNil)
+
+	head, err := r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/trunk"))
+
+	r, err = Clone(memory.NewStorage(), memfs.New(), &CloneOptions{
+		URL:           url,
+		InitialBranch: "refs/heads/trunk",
+	})
+	c.Assert(err, IsNil)
+
+	head, err = r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/trunk"))
+}
+
+func (s *RepositorySuite) TestCloneEmptyRepositoryUnbornHead(c *C) {
+	base := c.MkDir()
+	_, err := PlainInitWithOptions(filepath.Join(base, "empty.git"), &PlainInitOptions{
+		InitOptions: InitOptions{DefaultBranch: "refs/heads/trunk"},
+		Bare:        true,
+	})
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(githttp.NewHandler(server.NewFilesystemLoader(osfs.New(base)),
+		&githttp.HandlerOptions{EnableReceivePack: true}))
+	defer srv.Close()
+
+	url := srv.URL + "/empty.git"
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+
+	trunk := plumbing.NewBranchReferenceName("trunk")
+	head, err := r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, trunk)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Branches["trunk"].Merge, Equals, trunk)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "foo", []byte("foo"), 0o644), IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+	hash, err := w.Commit("foo", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.Push(&PushOptions{}), IsNil)
+
+	// The pushed branch is the one of the next clones.
+	r, err = Clone(memory.NewStorage(), nil, &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+	ref, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(ref.Name(), Equals, trunk)
+	c.Assert(ref.Hash(), Equals, hash)
+}
+
+func (s *RepositorySuite) TestSetRemoteHead(c *C) {
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.SetRemoteHead(DefaultRemoteName, "branch"), IsNil)
+	head, err := r.Reference("refs/remotes/origin/HEAD", false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/remotes/origin/branch"))
+
+	c.Assert(r.SetRemoteHead(DefaultRemoteName, plumbing.Master), IsNil)
+	head, err = r.Reference("refs/remotes/origin/HEAD", false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/remotes/origin/master"))
+
+	err = r.SetRemoteHead(DefaultRemoteName, "missing")
+	c.Assert(errors.Is(err, plumbing.ErrReferenceNotFound), Equals, true)
+
+	err = r.SetRemoteHead("upstream", plumbing.Master)
+	c.Assert(err, Equals, ErrRemoteNotFound)
+}
+
+func (s *RepositorySuite) TestAddFetchRefSpec(c *C) {
+	r, _ := Init(memory.NewStorage(), nil)
+	_, err := r.CreateRemote(&config.RemoteConfig{
+		Name: "foo",
+		URLs: []string{"http://foo/foo.git"},
+	})
+	c.Assert(err, IsNil)
+
+	pulls := config.RefSpec("+refs/pull/*/head:refs/remotes/foo/pull/*")
+	c.Assert(r.AddFetchRefSpec("foo", pulls), IsNil)
+	c.Assert(r.AddFetchRefSpec("foo", pulls), IsNil)
+
+	remote, err := r.Remote("foo")
+	c.Assert(err, IsNil)
+	c.Assert(remote.Config().Fetch, DeepEquals, []config.RefSpec{
+		"+refs/heads/*:refs/remotes/foo/*",
+		pulls,
+	})
+
+	err = r.AddFetchRefSpec("foo", "refs/heads/*:refs/remotes/foo/*/*")
+	c.Assert(err, Equals, config.ErrRefSpecMalformedWildcard)
+
+	err = r.AddFetchRefSpec("bar", pulls)
+	c.Assert(err, Equals, ErrRemoteNotFound)
+}
+
+func (s *RepositorySuite) TestRemoveFetchRefSpec(c *C) {
+	r, _ := Init(memory.NewStorage(), nil)
+	_, err := r.CreateRemote(&config.RemoteConfig{
+		Name:  "foo",
+		URLs:  []string{"http://foo/foo.git"},
+		Fetch: []config.RefSpec{"+refs/heads/*:refs/remotes/foo/*", "+refs/notes/*:refs/notes/*"},
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.RemoveFetchRefSpec("foo", "+refs/notes/*:refs/notes/*"), IsNil)
+
+	remote, err := r.Remote("foo")
+	c.Assert(err, IsNil)
+	c.Assert(remote.Config().Fetch, DeepEquals, []config.RefSpec{"+refs/heads/*:refs/remotes/foo/*"})
+
+	err = r.RemoveFetchRefSpec("foo", "+refs/notes/*:refs/notes/*")
+	c.Assert(errors.Is(err, ErrFetchRefSpecNotFound), Equals, true)
+
+	err = r.RemoveFetchRefSpec("bar", "+refs/notes/*:refs/notes/*")
+	c.Assert(err, Equals, ErrRemoteNotFound)
+}
+
+func (s *RepositorySuite) TestSetFetchAllBranches(c *C) {
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:          s.GetBasicLocalRepositoryURL(),
+		SingleBranch: true,
+	})
+	c.Assert(err, IsNil)
+
+	remote, err := r.Remote(DefaultRemoteName)
+	c.Assert(err, IsNil)
+	c.Assert(remote.IsSingleBranch(), Equals, true)
+
+	// Explicit refspecs are fetched even if they aren't configured.
+	err = r.Fetch(&FetchOptions{
+		RefSpecs: []config.RefSpec{"+refs/heads/branch:refs/remotes/origin/branch"},
+	})
+	c.Assert(err, IsNil)
+
+	_, err = r.Reference("refs/remotes/origin/branch", false)
+	c.Assert(err, IsNil)
+
+	pulls := config.RefSpec("+refs/pull/*/head:refs/remotes/origin/pull/*")
+	c.Assert(r.AddFetchRefSpec(DefaultRemoteName, pulls), IsNil)
+	c.Assert(r.SetFetchAllBranches(DefaultRemoteName), IsNil)
+
+	remote, err = r.Remote(DefaultRemoteName)
+	c.Assert(err, IsNil)
+	c.Assert(remote.IsSingleBranch(), Equals, false)
+	c.Assert(remote.Config().Fetch, DeepEquals, []config.RefSpec{
+		pulls,
+		"+refs/heads/*:refs/remotes/origin/*",
+	})
+
+	err = r.SetFetchAllBranches("upstream")
+	c.Assert(err, Equals, ErrRemoteNotFound)
+}
+
+func (s *RepositorySuite) TestSetRemoteHeadAuto(c *C) {
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+	c.Assert(r.Storer.RemoveReference("refs/remotes/origin/HEAD"), IsNil)
+
+	branch, err := r.SetRemoteHeadAuto(DefaultRemoteName, &ListOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(branch, Equals, plumbing.Master)
+
+	head, err := r.Reference("refs/remotes/origin/HEAD", false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/remotes/origin/master"))
+}
+
+func (s *RepositorySuite) TestPlainCloneMirror(c *C) {
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{
+		URL:    s.GetBasicLocalRepositoryURL(),
+		Mirror: true,
+	})
+	c.Assert(err, IsNil)
+
+	_, err = r.Worktree()
+	c.Assert(err, Equals, ErrIsBareRepository)
+
+	AssertReferences(c, r, map[string]string{
+		"refs/heads/master":          "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"refs/heads/branch":          "e8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"refs/remotes/origin/master": "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"refs/remotes/origin/branch": "e8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"refs/tags/v1.0.0":           "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+	})
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.IsBare, Equals, true)
+	c.Assert(cfg.Remotes[DefaultRemoteName].Mirror, Equals, true)
+	c.Assert(cfg.Remotes[DefaultRemoteName].Fetch, DeepEquals, []config.RefSpec{"+refs/*:refs/*"})
+}
+
 func (s *RepositorySuite) TestCloneWithTags(c *C) {
 	url := s.GetLocalRepositoryURL(
 		fixtures.ByURL("https://github.com/git-fixtures/tags.git").One(),
@@ -293,7 +667,7 @@ func (s *RepositorySuite) TestCloneWithTags(c *C) {
 	var count int
 	i.ForEach(func(r *plumbing.Reference) error { count++; return nil })
 
-	c.Assert(count, Equals, 3)
+	c.Assert(count, Equals, 4)
 }
 
 func (s *RepositorySuite) TestCloneSparse(c *C) {
@@ -485,6 +859,10 @@ func (s *RepositorySuite) TestMergeFF(c *C) {
 	head, err = r.Head()
 	c.Assert(err, IsNil)
 	c.Assert(head.Hash(), Equals, fooHash)
+
+	orig, err := r.ResolveRevision("ORIG_HEAD")
+	c.Assert(err, IsNil)
+	c.Assert(*orig, Equals, lastCommit)
 }
 
 func (s *RepositorySuite) TestMergeFF_Invalid(c *C) {
@@ -554,12 +932,12 @@ func (s *RepositorySuite) TestCreateBranchUnmarshal(c *C) {
 [remote "foo"]
 	url = http://foo/foo.git
 	fetch = +refs/heads/*:refs/remotes/foo/*
-[branch "foo"]
-	remote = origin
-	merge = refs/heads/foo
 [branch "master"]
 	remote = origin
 	merge = refs/heads/master
+[branch "foo"]
+	remote = origin
+	merge = refs/heads/foo
 `)
 
 	_, err := r.CreateRemote(&config.RemoteConfig{
@@ -625,50 +1003,460 @@ func (s *RepositorySuite) TestDeleteBranch(c *C) {
 
 	c.Assert(err, IsNil)
 
-	err = r.DeleteBranch("foo")
+	err = r.DeleteBranch("foo")
+	c.Assert(err, IsNil)
+
+	b, err := r.Branch("foo")
+	c.Assert(err, Equals, ErrBranchNotFound)
+	c.Assert(b, IsNil)
+
+	err = r.DeleteBranch("foo")
+	c.Assert(err, Equals, ErrBranchNotFound)
+}
+
+func (s *RepositorySuite) TestDeleteBranchRef(c *C) {
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	for name, hash := range map[string]string{
+		"merged":   "918c48b83bd081e863dbe1b80f8998f058cd8294",
+		"unmerged": "e8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"tracking": "e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	} {
+		ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(name), plumbing.NewHash(hash))
+		c.Assert(r.Storer.SetReference(ref), IsNil)
+	}
+
+	c.Assert(r.SetUpstream("merged", "origin", "refs/heads/master"), IsNil)
+	c.Assert(r.SetUpstream("tracking", "origin", "refs/heads/branch"), IsNil)
+
+	fs := r.Storer.(*filesystem.Storage).Filesystem()
+	c.Assert(util.WriteFile(fs, "logs/refs/heads/merged", []byte("log"), 0o644), IsNil)
+
+	err = r.DeleteBranchRef("master", false)
+	c.Assert(errors.Is(err, ErrBranchCheckedOut), Equals, true)
+
+	err = r.DeleteBranchRef("missing", false)
+	c.Assert(errors.Is(err, ErrBranchNotFound), Equals, true)
+
+	err = r.DeleteBranchRef("unmerged", false)
+	c.Assert(errors.Is(err, ErrBranchNotMerged), Equals, true)
+
+	err = r.DeleteBranchRef("unmerged", true)
+	c.Assert(err, IsNil)
+
+	// Merged into its upstream, but not into HEAD.
+	err = r.DeleteBranchRef("tracking", false)
+	c.Assert(err, IsNil)
+
+	err = r.DeleteBranchRef("refs/heads/merged", false)
+	c.Assert(err, IsNil)
+
+	for _, name := range []string{"merged", "unmerged", "tracking"} {
+		_, err = r.Reference(plumbing.NewBranchReferenceName(name), false)
+		c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+		_, err = r.Branch(name)
+		c.Assert(err, Equals, ErrBranchNotFound)
+	}
+
+	_, err = fs.Stat("logs/refs/heads/merged")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *RepositorySuite) TestRenameBranch(c *C) {
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	fs := r.Storer.(*filesystem.Storage).Filesystem()
+	c.Assert(util.WriteFile(fs, "logs/refs/heads/master", []byte("log"), 0o644), IsNil)
+
+	err = r.RenameBranch("master", "main", false)
+	c.Assert(err, IsNil)
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/main"))
+
+	ref, err := r.Reference("refs/heads/main", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	_, err = r.Reference(plumbing.Master, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	b, err := r.Branch("main")
+	c.Assert(err, IsNil)
+	c.Assert(b.Remote, Equals, "origin")
+	c.Assert(b.Merge, Equals, plumbing.Master)
+
+	_, err = r.Branch("master")
+	c.Assert(err, Equals, ErrBranchNotFound)
+
+	_, err = fs.Stat("logs/refs/heads/main")
+	c.Assert(err, IsNil)
+
+	ref = plumbing.NewHashReference("refs/heads/other", plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+	c.Assert(r.Storer.SetReference(ref), IsNil)
+
+	err = r.RenameBranch("other", "main", false)
+	c.Assert(errors.Is(err, ErrBranchExists), Equals, true)
+
+	err = r.RenameBranch("other", "main", true)
+	c.Assert(err, IsNil)
+
+	ref, err = r.Reference("refs/heads/main", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+
+	_, err = r.Branch("main")
+	c.Assert(err, Equals, ErrBranchNotFound)
+
+	err = r.RenameBranch("main", "in..valid", false)
+	c.Assert(err, NotNil)
+
+	err = r.RenameBranch("missing", "foo", false)
+	c.Assert(errors.Is(err, ErrBranchNotFound), Equals, true)
+}
+
+func (s *RepositorySuite) TestRenameBranchIntoDirectory(c *C) {
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	fs := r.Storer.(*filesystem.Storage).Filesystem()
+	c.Assert(util.WriteFile(fs, "logs/refs/heads/master", []byte("log"), 0o644), IsNil)
+
+	err = r.RenameBranch("master", "master/foo", false)
+	c.Assert(err, IsNil)
+
+	ref, err := r.Reference("refs/heads/master/foo", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	_, err = r.Reference(plumbing.Master, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	content, err := util.ReadFile(fs, "logs/refs/heads/master/foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "log")
+}
+
+func (s *RepositorySuite) TestRenameBranchReftable(c *C) {
+	fs := memfs.New()
+	err := util.WriteFile(fs, "config", []byte(
+		"[core]\n\trepositoryformatversion = 1\n\tbare = true\n[extensions]\n\trefstorage = reftable\n"), 0o644)
+	c.Assert(err, IsNil)
+
+	sto := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	hash := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	c.Assert(sto.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/other")), IsNil)
+	c.Assert(sto.SetReference(plumbing.NewHashReference("refs/heads/foo", hash)), IsNil)
+
+	stack := reftable.NewStack(chroot.New(fs, "reftable"), reftable.StackOptions{})
+	a, err := stack.NewAddition()
+	c.Assert(err, IsNil)
+	a.AddLog(&reftable.LogRecord{Name: "refs/heads/foo", New: hash, Committer: "foo", Email: "foo@example.com", Message: "branch: Created\n"})
+	c.Assert(a.Commit(), IsNil)
+
+	r, err := Open(sto, nil)
+	c.Assert(err, IsNil)
+
+	c.Assert(r.RenameBranch("foo", "foo/bar", false), IsNil)
+
+	entries, err := sto.Reflog("refs/heads/foo")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	entries, err = sto.Reflog("refs/heads/foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Message, Equals, "branch: Created")
+
+	c.Assert(r.DeleteBranchRef("foo/bar", true), IsNil)
+
+	entries, err = sto.Reflog("refs/heads/foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func (s *RepositorySuite) TestSetUpstream(c *C) {
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	err = r.SetUpstream("master", "origin", "refs/heads/branch")
+	c.Assert(err, IsNil)
+
+	b, err := r.Branch("master")
+	c.Assert(err, IsNil)
+	c.Assert(b.Remote, Equals, "origin")
+	c.Assert(b.Merge, Equals, plumbing.ReferenceName("refs/heads/branch"))
+
+	err = r.SetUpstream("", "missing", "refs/heads/branch")
+	c.Assert(err, Equals, ErrRemoteNotFound)
+
+	err = r.SetUpstream("", ".", "branch")
+	c.Assert(err, NotNil)
+
+	err = r.SetUpstream("foo", "origin", "refs/heads/branch")
+	c.Assert(errors.Is(err, plumbing.ErrReferenceNotFound), Equals, true)
+
+	err = r.UnsetUpstream("")
+	c.Assert(err, IsNil)
+
+	_, err = r.Branch("master")
+	c.Assert(err, Equals, ErrBranchNotFound)
+}
+
+func (s *RepositorySuite) TestBranchStatus(c *C) {
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	status, err := r.BranchStatus("")
+	c.Assert(err, IsNil)
+	c.Assert(status, DeepEquals, &BranchStatus{Name: "master", Upstream: "refs/remotes/origin/master"})
+
+	CommitNewFile(c, r, "foo")
+	CommitNewFile(c, r, "bar")
+
+	status, err = r.BranchStatus("master")
+	c.Assert(err, IsNil)
+	c.Assert(status.Ahead, Equals, 2)
+	c.Assert(status.Behind, Equals, 0)
+
+	err = r.SetUpstream("master", "origin", "refs/heads/branch")
+	c.Assert(err, IsNil)
+
+	status, err = r.BranchStatus("master")
+	c.Assert(err, IsNil)
+	c.Assert(status.Upstream, Equals, plumbing.ReferenceName("refs/remotes/origin/branch"))
+	c.Assert(status.Ahead, Equals, 3)
+	c.Assert(status.Behind, Equals, 1)
+
+	err = r.Storer.RemoveReference("refs/remotes/origin/branch")
+	c.Assert(err, IsNil)
+
+	status, err = r.BranchStatus("master")
+	c.Assert(err, IsNil)
+	c.Assert(status.Gone, Equals, true)
+
+	err = r.UnsetUpstream("master")
+	c.Assert(err, IsNil)
+
+	status, err = r.BranchStatus("master")
+	c.Assert(err, IsNil)
+	c.Assert(status, DeepEquals, &BranchStatus{Name: "master"})
+}
+
+func (s *RepositorySuite) TestPlainInit(c *C) {
+	dir := c.MkDir()
+
+	r, err := PlainInit(dir, true)
+	c.Assert(err, IsNil)
+	c.Assert(r, NotNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.IsBare, Equals, true)
+}
+
+func (s *RepositorySuite) TestPlainInitWithOptions(c *C) {
+	dir := c.MkDir()
+
+	r, err := PlainInitWithOptions(dir, &PlainInitOptions{
+		InitOptions: InitOptions{
+			DefaultBranch: "refs/heads/foo",
+		},
+		Bare: false,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(r, NotNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.IsBare, Equals, false)
+
+	createCommit(c, r)
+
+	ref, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(ref.Name().String(), Equals, "refs/heads/foo")
+}
+
+// setHome sets an empty home directory, without global config, and no system
+// config, until the returned function is called.
+func setHome(c *C) (home string, restore func()) {
+	home = c.MkDir()
+	return home, test.SetEnv(map[string]string{
+		"HOME":                home,
+		"XDG_CONFIG_HOME":     "",
+		"GIT_CONFIG_GLOBAL":   "",
+		"GIT_CONFIG_NOSYSTEM": "true",
+	})
+}
+
+// setGlobalConfig sets the given global config, and no system one, until
+// the returned function is called.
+func setGlobalConfig(c *C, content string) (restore func()) {
+	home, restore := setHome(c)
+
+	err := util.WriteFile(osfs.Default, filepath.Join(home, ".gitconfig"), []byte(content), 0o644)
+	c.Assert(err, IsNil)
+
+	return restore
+}
+
+func (s *RepositorySuite) TestPlainInitDefaultBranchConfig(c *C) {
+	defer setGlobalConfig(c, "[init]\n\tdefaultBranch = trunk\n")()
+
+	r, err := PlainInit(c.MkDir(), false)
+	c.Assert(err, IsNil)
+
+	head, err := r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/trunk"))
+
+	// The option takes precedence over the config.
+	r, err = PlainInitWithOptions(c.MkDir(), &PlainInitOptions{
+		InitOptions: InitOptions{DefaultBranch: "refs/heads/foo"},
+	})
+	c.Assert(err, IsNil)
+
+	head, err = r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/foo"))
+}
+
+func (s *RepositorySuite) TestPlainInitInitialBranch(c *C) {
+	defer setGlobalConfig(c, "[init]\n\tdefaultBranch = trunk\n")()
+
+	r, err := PlainInitWithOptions(c.MkDir(), &PlainInitOptions{
+		InitialBranch: "refs/heads/foo",
+	})
+	c.Assert(err, IsNil)
+
+	head, err := r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/foo"))
+
+	// DefaultBranch takes precedence over its alias.
+	r, err = PlainInitWithOptions(c.MkDir(), &PlainInitOptions{
+		InitOptions:   InitOptions{DefaultBranch: "refs/heads/bar"},
+		InitialBranch: "refs/heads/foo",
+	})
+	c.Assert(err, IsNil)
+
+	head, err = r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/bar"))
+}
+
+func (s *RepositorySuite) TestPlainInitInvalidGlobalConfig(c *C) {
+	defer setGlobalConfig(c, "[init\n\tdefaultBranch = trunk\n")()
+
+	r, err := PlainInit(c.MkDir(), false)
+	c.Assert(err, IsNil)
+
+	head, err := r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.Master)
+}
+
+func (s *RepositorySuite) TestInitIgnoresGlobalConfig(c *C) {
+	defer setGlobalConfig(c, "[init]\n\tdefaultBranch = trunk\n")()
+
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	head, err := r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.Master)
+
+	r, err = InitWithOptions(memory.NewStorage(), memfs.New(), InitOptions{})
+	c.Assert(err, IsNil)
+
+	head, err = r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.Master)
+}
+
+// writeTemplate writes a template directory, with a hook, an exclude file
+// and a config.
+func writeTemplate(c *C) string {
+	dir := c.MkDir()
+	c.Assert(util.WriteFile(osfs.Default, filepath.Join(dir, "hooks", "pre-push"),
+		[]byte("#!/bin/sh\n"), 0o755), IsNil)
+	c.Assert(util.WriteFile(osfs.Default, filepath.Join(dir, "info", "exclude"),
+		[]byte("*.log\n"), 0o644), IsNil)
+	c.Assert(util.WriteFile(osfs.Default, filepath.Join(dir, "config"),
+		[]byte("[user]\n\tname = Template\n"), 0o644), IsNil)
+
+	return dir
+}
+
+func (s *RepositorySuite) TestPlainInitWithOptionsTemplateDir(c *C) {
+	dir := c.MkDir()
+	r, err := PlainInitWithOptions(dir, &PlainInitOptions{TemplateDir: writeTemplate(c)})
+	c.Assert(err, IsNil)
+
+	gitDir := filepath.Join(dir, GitDirName)
+	b, err := os.ReadFile(filepath.Join(gitDir, "info", "exclude"))
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "*.log\n")
+
+	fi, err := os.Stat(filepath.Join(gitDir, "hooks", "pre-push"))
+	c.Assert(err, IsNil)
+	if runtime.GOOS != "windows" {
+		c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0o755))
+	}
+
+	// The config of the template is the base of the one of the repository.
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Name, Equals, "Template")
+	c.Assert(cfg.Core.IsBare, Equals, false)
+
+	head, err := r.Reference(plumbing.HEAD, false)
 	c.Assert(err, IsNil)
-
-	b, err := r.Branch("foo")
-	c.Assert(err, Equals, ErrBranchNotFound)
-	c.Assert(b, IsNil)
-
-	err = r.DeleteBranch("foo")
-	c.Assert(err, Equals, ErrBranchNotFound)
+	c.Assert(head.Target(), Equals, plumbing.Master)
 }
 
-func (s *RepositorySuite) TestPlainInit(c *C) {
-	dir := c.MkDir()
+func (s *RepositorySuite) TestPlainInitTemplateDirConfig(c *C) {
+	template := writeTemplate(c)
+	defer setGlobalConfig(c, fmt.Sprintf("[init]\n\ttemplateDir = %s\n", filepath.ToSlash(template)))()
 
-	r, err := PlainInit(dir, true)
+	dir := c.MkDir()
+	_, err := PlainInit(dir, true)
 	c.Assert(err, IsNil)
-	c.Assert(r, NotNil)
 
-	cfg, err := r.Config()
+	_, err = os.Stat(filepath.Join(dir, "info", "exclude"))
 	c.Assert(err, IsNil)
-	c.Assert(cfg.Core.IsBare, Equals, true)
 }
 
-func (s *RepositorySuite) TestPlainInitWithOptions(c *C) {
+func (s *RepositorySuite) TestPlainInitWithOptionsSeparateGitDir(c *C) {
 	dir := c.MkDir()
+	gitDir := filepath.Join(c.MkDir(), "repo.git")
 
-	r, err := PlainInitWithOptions(dir, &PlainInitOptions{
-		InitOptions: InitOptions{
-			DefaultBranch: "refs/heads/foo",
-		},
-		Bare: false,
-	})
+	r, err := PlainInitWithOptions(dir, &PlainInitOptions{SeparateGitDir: gitDir})
 	c.Assert(err, IsNil)
-	c.Assert(r, NotNil)
 
-	cfg, err := r.Config()
+	_, err = os.Stat(filepath.Join(gitDir, "HEAD"))
 	c.Assert(err, IsNil)
-	c.Assert(cfg.Core.IsBare, Equals, false)
+
+	fi, err := os.Stat(filepath.Join(dir, GitDirName))
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, false)
 
 	createCommit(c, r)
 
-	ref, err := r.Head()
+	r, err = PlainOpen(dir)
 	c.Assert(err, IsNil)
-	c.Assert(ref.Name().String(), Equals, "refs/heads/foo")
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+
+	_, err = PlainInitWithOptions(c.MkDir(), &PlainInitOptions{Bare: true, SeparateGitDir: gitDir})
+	c.Assert(err, Equals, ErrSeparateGitDirBare)
 }
 
 func (s *RepositorySuite) TestPlainInitAlreadyExists(c *C) {
@@ -730,6 +1518,37 @@ func (s *RepositorySuite) TestPlainOpenBare(c *C) {
 	c.Assert(r, NotNil)
 }
 
+func (s *RepositorySuite) TestPlainOpenNamespace(c *C) {
+	dir := c.MkDir()
+
+	_, err := PlainInit(dir, true)
+	c.Assert(err, IsNil)
+
+	r, err := PlainOpenWithOptions(dir, &PlainOpenOptions{Namespace: "foo"})
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(r.Fetch(&FetchOptions{}), IsNil)
+
+	ref, err := r.Reference("refs/remotes/origin/master", false)
+	c.Assert(err, IsNil)
+
+	// The references are stored in the namespace.
+	r, err = PlainOpen(dir)
+	c.Assert(err, IsNil)
+
+	_, err = r.Reference("refs/remotes/origin/master", false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	nsRef, err := r.Reference("refs/namespaces/foo/refs/remotes/origin/master", false)
+	c.Assert(err, IsNil)
+	c.Assert(nsRef.Hash(), Equals, ref.Hash())
+}
+
 func (s *RepositorySuite) TestPlainOpenNotBare(c *C) {
 	dir := c.MkDir()
 
@@ -892,6 +1711,175 @@ func (s *RepositorySuite) TestPlainOpenNotExistsDetectDotGit(c *C) {
 	c.Assert(r, IsNil)
 }
 
+func (s *RepositorySuite) TestPlainOpenPaths(c *C) {
+	dir, err := filepath.EvalSymlinks(c.MkDir())
+	c.Assert(err, IsNil)
+
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+	c.Assert(r.Paths(), DeepEquals, &RepositoryPaths{
+		GitDir:    filepath.Join(dir, GitDirName),
+		CommonDir: filepath.Join(dir, GitDirName),
+		Worktree:  dir,
+	})
+
+	subdir := filepath.Join(dir, "a", "b")
+	c.Assert(os.MkdirAll(subdir, 0755), IsNil)
+
+	r, err = PlainOpenWithOptions(subdir, &PlainOpenOptions{DetectDotGit: true})
+	c.Assert(err, IsNil)
+	c.Assert(r.Paths(), DeepEquals, &RepositoryPaths{
+		GitDir:    filepath.Join(dir, GitDirName),
+		CommonDir: filepath.Join(dir, GitDirName),
+		Worktree:  dir,
+	})
+
+	r, err = Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.Paths(), IsNil)
+}
+
+func (s *RepositorySuite) TestPlainOpenDetectBare(c *C) {
+	dir, err := filepath.EvalSymlinks(c.MkDir())
+	c.Assert(err, IsNil)
+
+	bare := filepath.Join(dir, "bare.git")
+	_, err = PlainInit(bare, true)
+	c.Assert(err, IsNil)
+
+	r, err := PlainOpenWithOptions(filepath.Join(bare, "refs", "heads"), &PlainOpenOptions{DetectDotGit: true})
+	c.Assert(err, IsNil)
+	c.Assert(r.Paths(), DeepEquals, &RepositoryPaths{GitDir: bare, CommonDir: bare})
+
+	_, err = r.Worktree()
+	c.Assert(err, Equals, ErrIsBareRepository)
+}
+
+func (s *RepositorySuite) TestPlainOpenGitFileVariations(c *C) {
+	dir, err := filepath.EvalSymlinks(c.MkDir())
+	c.Assert(err, IsNil)
+
+	gitDir := filepath.Join(dir, "repo.git")
+	_, err = PlainInit(gitDir, true)
+	c.Assert(err, IsNil)
+
+	wt := filepath.Join(dir, "wt")
+	c.Assert(os.MkdirAll(filepath.Join(wt, "sub"), 0755), IsNil)
+
+	for _, content := range []string{
+		"gitdir: ../repo.git\n",
+		"gitdir: ../repo.git\r\n",
+		"gitdir:  ../repo.git/  \n",
+		"gitdir: " + gitDir,
+	} {
+		c.Assert(os.WriteFile(filepath.Join(wt, GitDirName), []byte(content), 0644), IsNil)
+
+		r, err := PlainOpenWithOptions(filepath.Join(wt, "sub"), &PlainOpenOptions{DetectDotGit: true})
+		c.Assert(err, IsNil, Commentf("%q", content))
+		c.Assert(r.Paths(), DeepEquals, &RepositoryPaths{
+			GitDir:    gitDir,
+			CommonDir: gitDir,
+			Worktree:  wt,
+		}, Commentf("%q", content))
+	}
+
+	c.Assert(os.WriteFile(filepath.Join(wt, GitDirName), []byte("gitdir: \n"), 0644), IsNil)
+	_, err = PlainOpen(wt)
+	c.Assert(err, ErrorMatches, ".*empty gitdir.*")
+}
+
+func (s *RepositorySuite) TestPlainOpenSubmoduleGitFile(c *C) {
+	dir, err := filepath.EvalSymlinks(c.MkDir())
+	c.Assert(err, IsNil)
+
+	_, err = PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	// The git directory of a submodule is in the modules of its parent.
+	modules := filepath.Join(dir, GitDirName, "modules", "sub")
+	_, err = PlainInit(modules, true)
+	c.Assert(err, IsNil)
+
+	sub := filepath.Join(dir, "sub")
+	c.Assert(os.MkdirAll(filepath.Join(sub, "dir"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(sub, GitDirName), []byte("gitdir: ../.git/modules/sub\n"), 0644), IsNil)
+
+	r, err := PlainOpenWithOptions(filepath.Join(sub, "dir"), &PlainOpenOptions{DetectDotGit: true})
+	c.Assert(err, IsNil)
+	c.Assert(r.Paths(), DeepEquals, &RepositoryPaths{
+		GitDir:    modules,
+		CommonDir: modules,
+		Worktree:  sub,
+	})
+}
+
+func (s *RepositorySuite) TestPlainOpenLinkedWorktreeCommonDir(c *C) {
+	fs := fixtures.ByTag("linked-worktree").One().Worktree()
+	root, err := filepath.EvalSymlinks(fs.Root())
+	c.Assert(err, IsNil)
+
+	r, err := PlainOpen(filepath.Join(root, "linked-worktree-1"))
+	c.Assert(err, IsNil)
+	c.Assert(r.Paths().Worktree, Equals, filepath.Join(root, "linked-worktree-1"))
+	c.Assert(r.Paths().CommonDir, Equals, filepath.Join(root, "main", GitDirName))
+	c.Assert(r.Paths().GitDir, Not(Equals), r.Paths().CommonDir)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name().String(), Equals, "refs/heads/linked-worktree-1")
+}
+
+func (s *RepositorySuite) TestPlainOpenSymlinkedParent(c *C) {
+	dir, err := filepath.EvalSymlinks(c.MkDir())
+	c.Assert(err, IsNil)
+
+	repo := filepath.Join(dir, "repo")
+	_, err = PlainInit(repo, false)
+	c.Assert(err, IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(repo, "a", "b"), 0755), IsNil)
+
+	link := filepath.Join(dir, "other", "link")
+	c.Assert(os.MkdirAll(filepath.Dir(link), 0755), IsNil)
+	if err := os.Symlink(repo, link); err != nil {
+		c.Skip("symlinks not supported")
+	}
+
+	r, err := PlainOpenWithOptions(filepath.Join(link, "a", "b"), &PlainOpenOptions{DetectDotGit: true})
+	c.Assert(err, IsNil)
+	c.Assert(r.Paths().Worktree, Equals, repo)
+	c.Assert(r.Paths().GitDir, Equals, filepath.Join(repo, GitDirName))
+}
+
+func (s *RepositorySuite) TestPlainOpenCeilingDirectories(c *C) {
+	dir, err := filepath.EvalSymlinks(c.MkDir())
+	c.Assert(err, IsNil)
+
+	_, err = PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	subdir := filepath.Join(dir, "a", "b")
+	c.Assert(os.MkdirAll(subdir, 0755), IsNil)
+
+	_, err = PlainOpenWithOptions(subdir, &PlainOpenOptions{
+		DetectDotGit:       true,
+		CeilingDirectories: []string{filepath.Join(dir, "a")},
+	})
+	c.Assert(err, Equals, ErrRepositoryNotExists)
+
+	// The path itself is looked up, even if it's a ceiling directory.
+	_, err = PlainOpenWithOptions(dir, &PlainOpenOptions{
+		DetectDotGit:       true,
+		CeilingDirectories: []string{dir},
+	})
+	c.Assert(err, IsNil)
+
+	_, err = PlainOpenWithOptions(subdir, &PlainOpenOptions{
+		DetectDotGit:       true,
+		CeilingDirectories: []string{filepath.Dir(dir)},
+	})
+	c.Assert(err, IsNil)
+}
+
 func (s *RepositorySuite) TestPlainClone(c *C) {
 	dir := c.MkDir()
 
@@ -928,7 +1916,7 @@ func (s *RepositorySuite) TestPlainCloneBareAndShared(c *C) {
 	data, err := os.ReadFile(altpath)
 	c.Assert(err, IsNil)
 
-	line := path.Join(remote, GitDirName, "objects") + "\n"
+	line := path.Join(remote, "objects") + "\n"
 	c.Assert(string(data), Equals, line)
 
 	cfg, err := r.Config()
@@ -955,7 +1943,7 @@ func (s *RepositorySuite) TestPlainCloneShared(c *C) {
 	data, err := os.ReadFile(altpath)
 	c.Assert(err, IsNil)
 
-	line := path.Join(remote, GitDirName, "objects") + "\n"
+	line := path.Join(remote, "objects") + "\n"
 	c.Assert(string(data), Equals, line)
 
 	cfg, err := r.Config()
@@ -964,6 +1952,101 @@ func (s *RepositorySuite) TestPlainCloneShared(c *C) {
 	c.Assert(cfg.Branches["master"].Name, Equals, "master")
 }
 
+func (s *RepositorySuite) TestPlainCloneReference(c *C) {
+	dir := c.MkDir()
+
+	remote := s.GetBasicLocalRepositoryURL()
+
+	r, err := PlainClone(dir, false, &CloneOptions{
+		URL:       remote,
+		Reference: remote,
+	})
+	c.Assert(err, IsNil)
+
+	data, err := os.ReadFile(filepath.Join(dir, GitDirName, "objects", "info", "alternates"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, path.Join(remote, "objects")+"\n")
+
+	// All the objects are in the reference, none is fetched.
+	packs, err := os.ReadDir(filepath.Join(dir, GitDirName, "objects", "pack"))
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 0)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.Hash, Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *RepositorySuite) TestPlainCloneReferenceDissociate(c *C) {
+	dir := c.MkDir()
+
+	remote := s.GetBasicLocalRepositoryURL()
+
+	r, err := PlainClone(dir, true, &CloneOptions{
+		URL:        remote,
+		Reference:  remote,
+		Dissociate: true,
+	})
+	c.Assert(err, IsNil)
+
+	_, err = os.Stat(filepath.Join(dir, "objects", "info", "alternates"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	packs, err := os.ReadDir(filepath.Join(dir, "objects", "pack"))
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 2)
+
+	iter, err := r.CommitObjects()
+	c.Assert(err, IsNil)
+
+	count := 0
+	err = iter.ForEach(func(*object.Commit) error {
+		count++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 9)
+}
+
+func (s *RepositorySuite) TestAddAlternate(c *C) {
+	r, err := PlainInit(c.MkDir(), true)
+	c.Assert(err, IsNil)
+
+	remote := s.GetBasicLocalRepositoryURL()
+	c.Assert(r.AddAlternate(remote), IsNil)
+
+	commit, err := r.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, "vendor stuff\n")
+
+	err = r.AddAlternate(c.MkDir())
+	c.Assert(err, ErrorMatches, "failed to open alternate repository: .*")
+
+	// The objects in the alternate aren't fetched.
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{remote},
+	})
+	c.Assert(err, IsNil)
+
+	err = r.Fetch(&FetchOptions{})
+	c.Assert(err, IsNil)
+
+	packs, err := os.ReadDir(filepath.Join(r.Storer.(*filesystem.Storage).Filesystem().Root(), "objects", "pack"))
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 0)
+}
+
 func (s *RepositorySuite) TestPlainCloneSharedHttpShouldReturnError(c *C) {
 	dir := c.MkDir()
 
@@ -1056,7 +2139,7 @@ func (s *RepositorySuite) TestPlainCloneContextNonExistentWithExistentDir(c *C)
 		URL: "incorrectOnPurpose",
 	})
 	c.Assert(r, NotNil)
-	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+	c.Assert(errors.Is(err, transport.ErrRepositoryNotFound), Equals, true)
 
 	_, err = fs.Stat(dir)
 	c.Assert(os.IsNotExist(err), Equals, false)
@@ -1081,7 +2164,11 @@ func (s *RepositorySuite) TestPlainCloneContextNonExistentWithNonExistentDir(c *
 		URL: "incorrectOnPurpose",
 	})
 	c.Assert(r, NotNil)
-	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+	c.Assert(errors.Is(err, transport.ErrRepositoryNotFound), Equals, true)
+
+	var notFound *transport.RepositoryNotFoundError
+	c.Assert(errors.As(err, &notFound), Equals, true)
+	c.Assert(notFound.Endpoint.Path, Matches, ".*incorrectOnPurpose")
 
 	_, err = fs.Stat(repoDir)
 	c.Assert(os.IsNotExist(err), Equals, true)
@@ -1134,7 +2221,7 @@ func (s *RepositorySuite) TestPlainCloneContextNonExistentWithNotEmptyDir(c *C)
 		URL: "incorrectOnPurpose",
 	})
 	c.Assert(r, NotNil)
-	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+	c.Assert(errors.Is(err, transport.ErrRepositoryNotFound), Equals, true)
 
 	_, err = fs.Stat(dummyFile)
 	c.Assert(err, IsNil)
@@ -1247,16 +2334,110 @@ func (s *RepositorySuite) TestFetch(c *C) {
 
 	remotes, err := r.Remotes()
 	c.Assert(err, IsNil)
-	c.Assert(remotes, HasLen, 1)
+	c.Assert(remotes, HasLen, 1)
+
+	_, err = r.Head()
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	branch, err := r.Reference("refs/remotes/origin/master", false)
+	c.Assert(err, IsNil)
+	c.Assert(branch, NotNil)
+	c.Assert(branch.Type(), Equals, plumbing.HashReference)
+	c.Assert(branch.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+}
+
+func (s *RepositorySuite) TestFetchHead(c *C) {
+	url := s.GetBasicLocalRepositoryURL()
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{Name: DefaultRemoteName, URLs: []string{url}})
+	c.Assert(err, IsNil)
+	err = r.CreateBranch(&config.Branch{Name: "master", Remote: DefaultRemoteName, Merge: plumbing.Master})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.Fetch(&FetchOptions{}), IsNil)
+
+	desc := func(kind, name string) string {
+		return fmt.Sprintf("%s '%s' of %s", kind, name, strings.TrimSuffix(url, ".git"))
+	}
+
+	heads, err := r.Storer.(storer.FetchHeadStorer).FetchHead()
+	c.Assert(err, IsNil)
+	c.Assert(heads, DeepEquals, []storer.FetchHead{
+		{Hash: plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"), Description: desc("branch", "master")},
+		{Hash: plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"), NotForMerge: true, Description: desc("branch", "branch")},
+	})
+
+	b, err := os.ReadFile(filepath.Join(dir, GitDirName, "FETCH_HEAD"))
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5\t\t"+desc("branch", "master")+"\n"+
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881\tnot-for-merge\t"+desc("branch", "branch")+"\n")
+
+	h, err := r.ResolveRevision("FETCH_HEAD")
+	c.Assert(err, IsNil)
+	c.Assert(h.String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	if _, err := exec.LookPath("git"); err == nil {
+		cmd := exec.Command("git", "rev-parse", "FETCH_HEAD")
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		c.Assert(err, IsNil, Commentf("%s", out))
+		c.Assert(strings.TrimSpace(string(out)), Equals, h.String())
+	}
+
+	err = r.Fetch(&FetchOptions{RefSpecs: []config.RefSpec{"refs/heads/branch:refs/remotes/origin/branch"}})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+
+	heads, err = r.Storer.(storer.FetchHeadStorer).FetchHead()
+	c.Assert(err, IsNil)
+	c.Assert(heads, DeepEquals, []storer.FetchHead{
+		{Hash: plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"), Description: desc("branch", "branch")},
+	})
+}
+
+func (s *RepositorySuite) TestResolveRevisionPseudoRefs(c *C) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+	commit := createCommit(c, r)
+
+	for _, name := range []plumbing.ReferenceName{plumbing.OrigHead, plumbing.MergeHead, plumbing.CherryPickHead} {
+		_, err := r.ResolveRevision(plumbing.Revision(name))
+		c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+		err = os.WriteFile(filepath.Join(dir, GitDirName, name.String()), []byte(commit.String()+"\n"), 0o644)
+		c.Assert(err, IsNil)
+
+		h, err := r.ResolveRevision(plumbing.Revision(name))
+		c.Assert(err, IsNil)
+		c.Assert(*h, Equals, commit)
+	}
+
+	// Only the names of pseudo-references are read at the root of the git
+	// directory.
+	err = os.WriteFile(filepath.Join(dir, GitDirName, "other"), []byte(commit.String()+"\n"), 0o644)
+	c.Assert(err, IsNil)
+	_, err = r.ResolveRevision("other")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RepositorySuite) TestFetchWithTagOptConfig(c *C) {
+	r, _ := Init(memory.NewStorage(), nil)
+	_, err := r.CreateRemote(&config.RemoteConfig{
+		Name:   DefaultRemoteName,
+		URLs:   []string{s.GetBasicLocalRepositoryURL()},
+		TagOpt: "--no-tags",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(r.Fetch(&FetchOptions{}), IsNil)
+
+	_, err = r.Reference("refs/remotes/origin/master", false)
+	c.Assert(err, IsNil)
 
-	_, err = r.Head()
+	_, err = r.Reference("refs/tags/v1.0.0", false)
 	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
-
-	branch, err := r.Reference("refs/remotes/origin/master", false)
-	c.Assert(err, IsNil)
-	c.Assert(branch, NotNil)
-	c.Assert(branch.Type(), Equals, plumbing.HashReference)
-	c.Assert(branch.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
 }
 
 func (s *RepositorySuite) TestFetchContext(c *C) {
@@ -1625,6 +2806,7 @@ func (s *RepositorySuite) TestPush(c *C) {
 
 	err = s.Repository.Push(&PushOptions{
 		RemoteName: "test",
+		RefSpecs:   []config.RefSpec{config.DefaultPushRefSpec},
 	})
 	c.Assert(err, IsNil)
 
@@ -1639,6 +2821,146 @@ func (s *RepositorySuite) TestPush(c *C) {
 	})
 }
 
+// pushDefaultRepository returns a clone of a bare server, with a new commit
+// on master and on a feature branch tracking master, the current one.
+func (s *RepositorySuite) pushDefaultRepository(c *C) (r, server *Repository) {
+	url := c.MkDir()
+	server, err := PlainClone(url, true, &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	r, err = PlainClone(c.MkDir(), false, &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+
+	CommitNewFile(c, r, "master")
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	err = w.Checkout(&CheckoutOptions{Branch: "refs/heads/feature", Create: true})
+	c.Assert(err, IsNil)
+
+	CommitNewFile(c, r, "feature")
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Branches["feature"] = &config.Branch{Name: "feature", Remote: "origin", Merge: plumbing.Master}
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	return r, server
+}
+
+func (s *RepositorySuite) TestPushDefault(c *C) {
+	for _, mode := range []string{"", "simple", "upstream", "current", "matching", "nothing"} {
+		r, server := s.pushDefaultRepository(c)
+		if mode != "" {
+			c.Assert(r.SetConfigValue(config.LocalScope, "push.default", mode), IsNil)
+		}
+
+		master, err := r.Reference(plumbing.Master, false)
+		c.Assert(err, IsNil)
+		feature, err := r.Reference("refs/heads/feature", false)
+		c.Assert(err, IsNil)
+
+		err = r.Push(&PushOptions{})
+		switch mode {
+		case "", "simple":
+			c.Assert(errors.Is(err, ErrNoUpstream), Equals, true, Commentf("mode %q", mode))
+			c.Assert(err, ErrorMatches, "the upstream branch 'master' of the current branch 'feature' does not match its name.*")
+		case "nothing":
+			c.Assert(errors.Is(err, ErrNoUpstream), Equals, true, Commentf("mode %q", mode))
+		default:
+			c.Assert(err, IsNil, Commentf("mode %q", mode))
+		}
+
+		expected := map[string]string{
+			"upstream": feature.Hash().String(),
+			"matching": master.Hash().String(),
+		}[mode]
+		if expected == "" {
+			expected = "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"
+		}
+
+		AssertReferences(c, server, map[string]string{"refs/heads/master": expected})
+
+		_, err = server.Reference("refs/heads/feature", false)
+		if mode == "current" {
+			c.Assert(err, IsNil)
+		} else {
+			c.Assert(err, Equals, plumbing.ErrReferenceNotFound, Commentf("mode %q", mode))
+		}
+	}
+}
+
+func (s *RepositorySuite) TestPushDefaultSimple(c *C) {
+	r, server := s.pushDefaultRepository(c)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	c.Assert(w.Checkout(&CheckoutOptions{Branch: plumbing.Master}), IsNil)
+
+	master, err := r.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+
+	err = r.Push(&PushOptions{})
+	c.Assert(err, IsNil)
+
+	AssertReferences(c, server, map[string]string{"refs/heads/master": master.Hash().String()})
+	AssertReferencesMissing(c, server, []string{"refs/heads/feature"})
+}
+
+func (s *RepositorySuite) TestPushRemote(c *C) {
+	r, server := s.pushDefaultRepository(c)
+
+	url := c.MkDir()
+	other, err := PlainInit(url, true)
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{Name: "other", URLs: []string{url}})
+	c.Assert(err, IsNil)
+	c.Assert(r.SetConfigValue(config.LocalScope, "branch.feature.pushRemote", "other"), IsNil)
+
+	feature, err := r.Reference("refs/heads/feature", false)
+	c.Assert(err, IsNil)
+
+	// Simple pushes to the branch of the same name on another remote than
+	// the upstream one.
+	err = r.Push(&PushOptions{})
+	c.Assert(err, IsNil)
+
+	AssertReferences(c, other, map[string]string{"refs/heads/feature": feature.Hash().String()})
+	AssertReferencesMissing(c, server, []string{"refs/heads/feature"})
+}
+
+func (s *RepositorySuite) TestPushSetUpstream(c *C) {
+	r, server := s.pushDefaultRepository(c)
+
+	err := r.Push(&PushOptions{
+		RefSpecs:    []config.RefSpec{"refs/heads/feature:refs/heads/feature"},
+		SetUpstream: true,
+	})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Branches["feature"].Remote, Equals, "origin")
+	c.Assert(cfg.Branches["feature"].Merge, Equals, plumbing.ReferenceName("refs/heads/feature"))
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	err = w.Checkout(&CheckoutOptions{Branch: "refs/heads/auto", Create: true})
+	c.Assert(err, IsNil)
+	c.Assert(r.SetConfigValue(config.LocalScope, "push.autoSetupRemote", "true"), IsNil)
+
+	err = r.Push(&PushOptions{})
+	c.Assert(err, IsNil)
+
+	_, err = server.Reference("refs/heads/auto", false)
+	c.Assert(err, IsNil)
+
+	cfg, err = r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Branches["auto"].Merge, Equals, plumbing.ReferenceName("refs/heads/auto"))
+}
+
 func (s *RepositorySuite) TestPushContext(c *C) {
 	url := c.MkDir()
 
@@ -1695,6 +3017,7 @@ func (s *RepositorySuite) TestPushWithProgress(c *C) {
 	var p bytes.Buffer
 	err = s.Repository.Push(&PushOptions{
 		RemoteName: "bar",
+		RefSpecs:   []config.RefSpec{config.DefaultPushRefSpec},
 		Progress:   &p,
 	})
 	c.Assert(err, IsNil)
@@ -1788,6 +3111,92 @@ func (s *RepositorySuite) TestLog(c *C) {
 	c.Assert(err, Equals, io.EOF)
 }
 
+// mailmapRepository returns a repository with a commit of .mailmap by an
+// author mapped by it.
+func mailmapRepository(c *C) *Repository {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, ".mailmap", []byte("Jane Doe <jane@example.com>\n"), 0o644), IsNil)
+	_, err = w.Add(".mailmap")
+	c.Assert(err, IsNil)
+
+	sig := &object.Signature{Name: "jane", Email: "JANE@example.com", When: time.Now()}
+	_, err = w.Commit("mailmap\n", &CommitOptions{Author: sig, Committer: sig})
+	c.Assert(err, IsNil)
+
+	return r
+}
+
+func (s *RepositorySuite) TestMailmap(c *C) {
+	r := mailmapRepository(c)
+
+	m, err := r.Mailmap()
+	c.Assert(err, IsNil)
+	name, email := m.Lookup("jane", "jane@example.com")
+	c.Assert(name, Equals, "Jane Doe")
+	c.Assert(email, Equals, "jane@example.com")
+
+	file := filepath.Join(c.MkDir(), "mailmap")
+	c.Assert(os.WriteFile(file, []byte("<jane.doe@example.com> <jane@example.com>\n"), 0o644), IsNil)
+	c.Assert(r.SetConfigValue(config.LocalScope, "mailmap.file", file), IsNil)
+	c.Assert(r.SetConfigValue(config.LocalScope, "mailmap.blob", "HEAD:missing"), IsNil)
+
+	iter, err := r.Log(&LogOptions{UseMailmap: true})
+	c.Assert(err, IsNil)
+	commit, err := iter.Next()
+	c.Assert(err, IsNil)
+	c.Assert(commit.Author.Name, Equals, "Jane Doe")
+	c.Assert(commit.Author.Email, Equals, "jane.doe@example.com")
+	c.Assert(commit.Committer.Name, Equals, "Jane Doe")
+	iter.Close()
+
+	iter, err = r.Log(&LogOptions{})
+	c.Assert(err, IsNil)
+	err = iter.ForEach(func(commit *object.Commit) error {
+		c.Assert(commit.Author.Name, Equals, "jane")
+		c.Assert(commit.Author.Email, Equals, "JANE@example.com")
+		return nil
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *RepositorySuite) TestLogContextCancelled(c *C) {
+	r, _ := Init(memory.NewStorage(), nil)
+	err := r.clone(context.Background(), &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	iter, err := r.LogContext(ctx, &LogOptions{})
+	c.Assert(err, IsNil)
+
+	count := 0
+	err = iter.ForEach(func(*object.Commit) error {
+		count++
+		if count == 2 {
+			cancel()
+		}
+
+		return nil
+	})
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(count, Equals, 2)
+
+	_, err = iter.Next()
+	c.Assert(err, Equals, context.Canceled)
+
+	_, err = r.LogContext(ctx, &LogOptions{Reverse: true})
+	c.Assert(err, Equals, context.Canceled)
+}
+
 func (s *RepositorySuite) TestLogAll(c *C) {
 	r, _ := Init(memory.NewStorage(), nil)
 	err := r.clone(context.Background(), &CloneOptions{
@@ -1804,7 +3213,7 @@ func (s *RepositorySuite) TestLogAll(c *C) {
 		return nil
 	})
 	c.Assert(err, IsNil)
-	c.Assert(refCount, Equals, 5)
+	c.Assert(refCount, Equals, 6)
 
 	cIter, err := r.Log(&LogOptions{
 		All: true,
@@ -1851,7 +3260,7 @@ func (s *RepositorySuite) TestLogAllMissingReferences(c *C) {
 		return nil
 	})
 	c.Assert(err, IsNil)
-	c.Assert(refCount, Equals, 4)
+	c.Assert(refCount, Equals, 5)
 
 	err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName("DUMMY"), plumbing.NewHash("DUMMY")))
 	c.Assert(err, IsNil)
@@ -1865,7 +3274,7 @@ func (s *RepositorySuite) TestLogAllMissingReferences(c *C) {
 		return nil
 	})
 	c.Assert(err, IsNil)
-	c.Assert(refCount, Equals, 5)
+	c.Assert(refCount, Equals, 6)
 
 	cIter, err := r.Log(&LogOptions{
 		All: true,
@@ -1921,6 +3330,91 @@ func (s *RepositorySuite) TestLogAllOrderByTime(c *C) {
 	cIter.Close()
 }
 
+func (s *RepositorySuite) TestLogTopologicalOrders(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	for _, url := range []string{
+		"https://github.com/src-d/go-git.git",
+		"https://github.com/git-fixtures/root-references.git",
+	} {
+		dotgit := fixtures.ByURL(url).One().DotGit()
+		r, err := Open(filesystem.NewStorage(dotgit, cache.NewObjectLRUDefault()), nil)
+		c.Assert(err, IsNil)
+
+		for _, t := range []struct {
+			order LogOrder
+			all   bool
+			args  []string
+		}{
+			{LogOrderTopo, false, []string{"--topo-order"}},
+			{LogOrderTopo, true, []string{"--topo-order", "--all"}},
+			{LogOrderAuthorDate, false, []string{"--author-date-order"}},
+			{LogOrderAuthorDate, true, []string{"--author-date-order", "--all"}},
+		} {
+			for _, reverse := range []bool{false, true} {
+				args := append([]string{"--git-dir", dotgit.Root(), "log", "--format=%H"}, t.args...)
+				if reverse {
+					args = append(args, "--reverse")
+				}
+
+				out, err := exec.Command("git", args...).Output()
+				c.Assert(err, IsNil)
+
+				iter, err := r.Log(&LogOptions{Order: t.order, All: t.all, Reverse: reverse})
+				c.Assert(err, IsNil)
+
+				var hashes []string
+				c.Assert(iter.ForEach(func(commit *object.Commit) error {
+					hashes = append(hashes, commit.Hash.String())
+					return nil
+				}), IsNil)
+
+				c.Assert(hashes, DeepEquals, strings.Fields(string(out)), Commentf("%s %v", url, args))
+			}
+		}
+	}
+}
+
+func (s *RepositorySuite) TestLogReverse(c *C) {
+	r, _ := Init(memory.NewStorage(), nil)
+	err := r.clone(context.Background(), &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	since := time.Date(2015, 3, 31, 11, 46, 0, 0, time.UTC)
+	for _, o := range []LogOptions{
+		{},
+		{Order: LogOrderTopo},
+		{Order: LogOrderCommitterTime, Since: &since},
+		{Order: LogOrderTopo, Paths: []string{"go", "json", "php"}},
+	} {
+		var expected []plumbing.Hash
+		iter, err := r.Log(&o)
+		c.Assert(err, IsNil)
+		c.Assert(iter.ForEach(func(commit *object.Commit) error {
+			expected = append([]plumbing.Hash{commit.Hash}, expected...)
+			return nil
+		}), IsNil)
+		c.Assert(len(expected) > 1, Equals, true)
+
+		o.Reverse = true
+		iter, err = r.Log(&o)
+		c.Assert(err, IsNil)
+
+		for _, h := range expected {
+			commit, err := iter.Next()
+			c.Assert(err, IsNil)
+			c.Assert(commit.Hash, Equals, h)
+		}
+
+		_, err = iter.Next()
+		c.Assert(err, Equals, io.EOF)
+	}
+}
+
 func (s *RepositorySuite) TestLogHead(c *C) {
 	r, _ := Init(memory.NewStorage(), nil)
 	err := r.clone(context.Background(), &CloneOptions{
@@ -2361,30 +3855,221 @@ func (s *RepositorySuite) TestLogLimitWithOtherParamsPass(c *C) {
 		From:  plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9"),
 	})
 	c.Assert(err, IsNil)
-	defer cIter.Close()
+	defer cIter.Close()
+
+	commitVal, iterErr := cIter.Next()
+	c.Assert(iterErr, Equals, nil)
+	c.Assert(commitVal.Hash.String(), Equals, "b029517f6300c2da0f4b651b8642506cd6aaf45d")
+
+	_, iterErr = cIter.Next()
+	c.Assert(iterErr, Equals, io.EOF)
+}
+
+func (s *RepositorySuite) TestConfigScoped(c *C) {
+	r, _ := Init(memory.NewStorage(), nil)
+	err := r.clone(context.Background(), &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.ConfigScoped(config.LocalScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Email, Equals, "")
+
+	cfg, err = r.ConfigScoped(config.SystemScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Email, Not(Equals), "")
+}
+
+func (s *RepositorySuite) TestConfigScopedIncludes(c *C) {
+	home, restore := setHome(c)
+	defer restore()
+
+	err := util.WriteFile(osfs.Default, filepath.Join(home, ".gitconfig"), []byte(
+		"[user]\n\tname = Global\n\temail = global@example.com\n"+
+			"[includeIf \"onbranch:master\"]\n\tpath = master.inc\n"), 0o644)
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(osfs.Default, filepath.Join(home, "master.inc"), []byte(
+		"[user]\n\temail = master@example.com\n"), 0o644)
+	c.Assert(err, IsNil)
+
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(osfs.Default, filepath.Join(dir, GitDirName, "local.inc"), []byte(
+		"[user]\n\tname = Local\n"), 0o644)
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("include").SetOption("path", "local.inc")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	cfg, err = r.ConfigScoped(config.GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Name, Equals, "Local")
+	c.Assert(cfg.User.Email, Equals, "master@example.com")
+
+	cfg, err = r.ConfigScoped(config.LocalScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Name, Equals, "Local")
+	c.Assert(cfg.User.Email, Equals, "")
+
+	global, err := config.LoadConfig(config.GlobalScope)
+	c.Assert(err, IsNil)
+	global.User.Name = "Changed"
+	c.Assert(r.SetConfigScoped(config.GlobalScope, global), IsNil)
+
+	err = r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/other"))
+	c.Assert(err, IsNil)
+
+	cfg, err = r.ConfigScoped(config.GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Email, Equals, "global@example.com")
+
+	global, err = config.LoadConfig(config.GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(global.User.Name, Equals, "Changed")
+	c.Assert(global.Raw.Section("includeIf").Subsection("onbranch:master").Option("path"), Equals, "master.inc")
+}
+
+func (s *RepositorySuite) TestConfigScopedWorktree(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	_, restore := setHome(c)
+	defer restore()
+
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=foo", "-c", "user.email=foo@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		c.Assert(err, IsNil, Commentf("git %s: %s", strings.Join(args, " "), out))
+		return strings.TrimSpace(string(out))
+	}
+
+	dir := c.MkDir()
+	main := filepath.Join(dir, "main")
+	linked := filepath.Join(dir, "linked")
+	git(dir, "init", "-q", main)
+	git(main, "commit", "-q", "--allow-empty", "-m", "initial")
+	git(main, "worktree", "add", "-q", linked)
+	git(main, "config", "extensions.worktreeConfig", "true")
+	git(main, "config", "user.name", "Local")
+	git(main, "config", "user.email", "local@example.com")
+	git(main, "config", "--worktree", "user.name", "Main")
+	git(linked, "config", "--worktree", "user.name", "Linked")
+
+	mr, err := PlainOpen(main)
+	c.Assert(err, IsNil)
+	lr, err := PlainOpenWithOptions(linked, &PlainOpenOptions{EnableDotGitCommonDir: true})
+	c.Assert(err, IsNil)
+
+	for r, name := range map[*Repository]string{mr: "Main", lr: "Linked"} {
+		cfg, err := r.ConfigScoped(config.LocalScope)
+		c.Assert(err, IsNil)
+		c.Assert(cfg.User.Name, Equals, name)
+		c.Assert(cfg.User.Email, Equals, "local@example.com")
+
+		cfg, err = r.Config()
+		c.Assert(err, IsNil)
+		c.Assert(cfg.User.Name, Equals, "Local")
+	}
+
+	c.Assert(lr.SetConfigValue(config.WorktreeScope, "core.sparseCheckout", "true"), IsNil)
+	c.Assert(git(linked, "config", "--worktree", "core.sparseCheckout"), Equals, "true")
+	c.Assert(git(linked, "config", "--worktree", "user.name"), Equals, "Linked")
+	c.Assert(git(linked, "config", "--worktree", "--list"), Not(Matches), "(?s).*bare.*")
+
+	cfg, err := mr.ConfigScoped(config.LocalScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.GetString("core.sparseCheckout"), Equals, "")
+
+	git(main, "config", "extensions.worktreeConfig", "false")
+	cfg, err = lr.ConfigScoped(config.LocalScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Name, Equals, "Local")
+
+	err = lr.SetConfigValue(config.WorktreeScope, "user.name", "Other")
+	c.Assert(err, Equals, ErrWorktreeConfigDisabled)
+}
+
+func (s *RepositorySuite) TestSetConfigValue(c *C) {
+	home, restore := setHome(c)
+	defer restore()
+
+	global := filepath.Join(home, ".gitconfig")
+	err := util.WriteFile(osfs.Default, global, []byte(
+		"# identity\n[user]\n\tname = Foo ; nickname\n\temail = foo@example.com\n"), 0o644)
+	c.Assert(err, IsNil)
+
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	c.Assert(r.SetConfigValue(config.GlobalScope, "user.email", "bar@example.com"), IsNil)
+	c.Assert(r.SetConfigValue(config.GlobalScope, "url.git@example.com:.insteadOf", "https://example.com/"), IsNil)
+
+	b, err := util.ReadFile(osfs.Default, global)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "# identity\n[user]\n\tname = Foo ; nickname\n\temail = bar@example.com\n"+
+		"[url \"git@example.com:\"]\n\tinsteadOf = https://example.com/\n")
+
+	c.Assert(r.SetConfigValue(config.LocalScope, "remote.origin.url", "https://example.com/repo"), IsNil)
+	c.Assert(r.SetConfigValue(config.LocalScope, "core.autocrlf", "input"), IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Remotes["origin"].URLs, DeepEquals, []string{"https://example.com/repo"})
+	c.Assert(cfg.GetString("core.autocrlf"), Equals, "input")
+
+	cfg, err = r.ConfigScoped(config.GlobalScope)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.User.Email, Equals, "bar@example.com")
+	c.Assert(cfg.GetString("url.git@example.com:.insteadof"), Equals, "https://example.com/")
+
+	c.Assert(r.UnsetConfigValue(config.LocalScope, "core.autocrlf"), IsNil)
+	c.Assert(r.UnsetConfigValue(config.GlobalScope, "user.email"), IsNil)
+
+	cfg, err = r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Raw.Section("core").HasOption("autocrlf"), Equals, false)
+	c.Assert(cfg.Remotes, HasLen, 1)
 
-	commitVal, iterErr := cIter.Next()
-	c.Assert(iterErr, Equals, nil)
-	c.Assert(commitVal.Hash.String(), Equals, "b029517f6300c2da0f4b651b8642506cd6aaf45d")
+	b, err = util.ReadFile(osfs.Default, global)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "# identity\n[user]\n\tname = Foo ; nickname\n"+
+		"[url \"git@example.com:\"]\n\tinsteadOf = https://example.com/\n")
 
-	_, iterErr = cIter.Next()
-	c.Assert(iterErr, Equals, io.EOF)
+	err = r.SetConfigValue(config.LocalScope, "core", "value")
+	c.Assert(errors.Is(err, config.ErrInvalidKey), Equals, true)
 }
 
-func (s *RepositorySuite) TestConfigScoped(c *C) {
-	r, _ := Init(memory.NewStorage(), nil)
-	err := r.clone(context.Background(), &CloneOptions{
-		URL: s.GetBasicLocalRepositoryURL(),
-	})
+func (s *RepositorySuite) TestSetConfigValueKeepsOtherKeys(c *C) {
+	dir := c.MkDir()
+	_, err := PlainInit(dir, true)
 	c.Assert(err, IsNil)
 
-	cfg, err := r.ConfigScoped(config.LocalScope)
+	file := filepath.Join(dir, "config")
+	err = util.WriteFile(osfs.Default, file, []byte(
+		"[core]\n\tbare\n\trepositoryformatversion = 0\n"), 0o644)
 	c.Assert(err, IsNil)
-	c.Assert(cfg.User.Email, Equals, "")
 
-	cfg, err = r.ConfigScoped(config.SystemScope)
+	r, err := PlainOpen(dir)
 	c.Assert(err, IsNil)
-	c.Assert(cfg.User.Email, Not(Equals), "")
+	c.Assert(r.SetConfigValue(config.LocalScope, "user.email", "foo@example.com"), IsNil)
+
+	b, err := util.ReadFile(osfs.Default, file)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "[core]\n\tbare\n\trepositoryformatversion = 0\n"+
+		"[user]\n\temail = foo@example.com\n")
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.IsBare, Equals, true)
 }
 
 func (s *RepositorySuite) TestCommit(c *C) {
@@ -2733,6 +4418,173 @@ func (s *RepositorySuite) TestCreateTagCanonicalize(c *C) {
 	c.Assert(actual.PrimaryKey, DeepEquals, key.PrimaryKey)
 }
 
+// armoredSigner signs with b64signer, wrapping the signature in the armor of
+// an SSH signature so it is told apart from the message of a tag.
+type armoredSigner struct{}
+
+func (armoredSigner) Sign(message io.Reader) ([]byte, error) {
+	sig, err := b64signer{}.Sign(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte("-----BEGIN SSH SIGNATURE-----\n" + string(sig) + "\n-----END SSH SIGNATURE-----\n"), nil
+}
+
+func (s *RepositorySuite) TestCreateTagSigner(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+	h := createCommit(c, r)
+
+	_, err = r.CreateTag("foobar", h, &CreateTagOptions{
+		Tagger:  defaultSignature(),
+		Message: "foo bar baz qux",
+		Signer:  armoredSigner{},
+		SignKey: commitSignKey(c, true),
+	})
+	c.Assert(err, IsNil)
+
+	ref, err := r.Tag("foobar")
+	c.Assert(err, IsNil)
+
+	tag, err := r.TagObject(ref.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(tag.Message, Equals, "foo bar baz qux\n")
+
+	// The Signer takes precedence over the SignKey.
+	payload := &plumbing.MemoryObject{}
+	c.Assert(tag.EncodeWithoutSignature(payload), IsNil)
+	rd, err := payload.Reader()
+	c.Assert(err, IsNil)
+	expected, err := armoredSigner{}.Sign(rd)
+	c.Assert(err, IsNil)
+	c.Assert(tag.PGPSignature, Equals, string(expected))
+}
+
+func (s *RepositorySuite) TestCreateTagTreeAndBlob(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(createCommit(c, r))
+	c.Assert(err, IsNil)
+
+	tree, err := commit.Tree()
+	c.Assert(err, IsNil)
+
+	for _, target := range []struct {
+		hash plumbing.Hash
+		typ  plumbing.ObjectType
+	}{
+		{tree.Hash, plumbing.TreeObject},
+		{tree.Entries[0].Hash, plumbing.BlobObject},
+	} {
+		name := target.typ.String()
+		ref, err := r.CreateTag(name, target.hash, &CreateTagOptions{
+			Tagger:  defaultSignature(),
+			Message: name,
+		})
+		c.Assert(err, IsNil)
+
+		tag, err := r.TagObject(ref.Hash())
+		c.Assert(err, IsNil)
+		c.Assert(tag.Target, Equals, target.hash)
+		c.Assert(tag.TargetType, Equals, target.typ)
+
+		obj, err := tag.Object()
+		c.Assert(err, IsNil)
+		c.Assert(obj.ID(), Equals, target.hash)
+	}
+}
+
+func (s *RepositorySuite) TestCreateTagSignedGitVerify(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	if _, err := exec.LookPath("gpg"); err != nil {
+		c.Skip("gpg not found")
+	}
+
+	home := c.MkDir()
+	key := commitSignKey(c, true)
+	pks := new(bytes.Buffer)
+	pkw, err := armor.Encode(pks, openpgp.PublicKeyType, nil)
+	c.Assert(err, IsNil)
+	c.Assert(key.Serialize(pkw), IsNil)
+	c.Assert(pkw.Close(), IsNil)
+
+	cmd := exec.Command("gpg", "--homedir", home, "--batch", "--import")
+	cmd.Stdin = pks
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateTag("v1", createCommit(c, r), &CreateTagOptions{
+		Tagger:  defaultSignature(),
+		Message: "foo bar baz qux",
+		SignKey: key,
+	})
+	c.Assert(err, IsNil)
+
+	cmd = exec.Command("git", "tag", "-v", "v1")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GNUPGHOME="+home)
+	out, err = cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	c.Assert(string(out), Matches, `(?s).*Good signature from "foo bar <foo@foo.foo>".*`)
+}
+
+// sshKeygenSigner signs with ssh-keygen, as git does when gpg.format is ssh.
+type sshKeygenSigner struct {
+	key string
+}
+
+func (s sshKeygenSigner) Sign(message io.Reader) ([]byte, error) {
+	cmd := exec.Command("ssh-keygen", "-Y", "sign", "-n", "git", "-f", s.key)
+	cmd.Stdin = message
+	return cmd.Output()
+}
+
+func (s *RepositorySuite) TestCreateTagSSHSignerGitVerify(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		c.Skip("ssh-keygen not found")
+	}
+
+	keys := c.MkDir()
+	key := filepath.Join(keys, "id_ed25519")
+	out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	pub, err := os.ReadFile(key + ".pub")
+	c.Assert(err, IsNil)
+	allowed := filepath.Join(keys, "allowed_signers")
+	c.Assert(os.WriteFile(allowed, append([]byte("foo@foo.foo "), pub...), 0o644), IsNil)
+
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateTag("v1", createCommit(c, r), &CreateTagOptions{
+		Tagger:  defaultSignature(),
+		Message: "foo bar baz qux",
+		Signer:  sshKeygenSigner{key: key},
+	})
+	c.Assert(err, IsNil)
+
+	cmd := exec.Command("git", "-c", "gpg.format=ssh", "-c", "gpg.ssh.allowedSignersFile="+allowed, "verify-tag", "v1")
+	cmd.Dir = dir
+	out, err = cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	c.Assert(string(out), Matches, `(?s).*Good "git" signature for foo@foo.foo.*`)
+}
+
 func (s *RepositorySuite) TestTagLightweight(c *C) {
 	url := s.GetLocalRepositoryURL(
 		fixtures.ByURL("https://github.com/git-fixtures/tags.git").One(),
@@ -2781,6 +4633,67 @@ func (s *RepositorySuite) TestDeleteTag(c *C) {
 	c.Assert(err, Equals, ErrTagNotFound)
 }
 
+func (s *RepositorySuite) TestUpdateRefs(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+	testUpdateRefs(c, r)
+}
+
+// refsOnlyStorer hides the optional interfaces of the storer.
+type refsOnlyStorer struct {
+	storage.Storer
+}
+
+func (s *RepositorySuite) TestUpdateRefsOneByOne(c *C) {
+	r, err := Init(refsOnlyStorer{memory.NewStorage()}, nil)
+	c.Assert(err, IsNil)
+	testUpdateRefs(c, r)
+}
+
+func testUpdateRefs(c *C, r *Repository) {
+	foo := plumbing.NewReferenceFromStrings("refs/heads/foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa")
+	bar := plumbing.NewReferenceFromStrings("refs/heads/bar", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+	c.Assert(r.Storer.SetReference(foo), IsNil)
+	c.Assert(r.Storer.SetReference(bar), IsNil)
+
+	newHash := plumbing.NewHash("c3f4688a08fd86f1bf8e055724c84b7a40a09733")
+	err := r.UpdateRefs([]RefUpdate{
+		{Name: foo.Name(), New: newHash, Old: foo.Hash()},
+		{Name: bar.Name(), Old: newHash},
+		{Name: "refs/heads/qux", New: newHash, Create: true},
+	})
+
+	var txErr *storer.ReferenceTransactionError
+	c.Assert(errors.As(err, &txErr), Equals, true)
+	c.Assert(txErr.Errors, HasLen, 1)
+	c.Assert(txErr.Errors[bar.Name()], Equals, storage.ErrReferenceHasChanged)
+
+	ref, err := r.Reference(foo.Name(), false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, foo.Hash())
+
+	_, err = r.Reference("refs/heads/qux", false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	err = r.UpdateRefs([]RefUpdate{
+		{Name: foo.Name(), New: newHash, Old: foo.Hash()},
+		{Name: bar.Name(), Old: bar.Hash()},
+		{Name: "refs/heads/qux", New: newHash, Create: true},
+	})
+	c.Assert(err, IsNil)
+
+	ref, err = r.Reference(foo.Name(), false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, newHash)
+
+	_, err = r.Reference(bar.Name(), false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	ref, err = r.Reference("refs/heads/qux", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, newHash)
+}
+
 func (s *RepositorySuite) TestDeleteTagMissingTag(c *C) {
 	url := s.GetLocalRepositoryURL(
 		fixtures.ByURL("https://github.com/git-fixtures/tags.git").One(),
@@ -2883,8 +4796,8 @@ func (s *RepositorySuite) TestDeleteTagAnnotatedUnpacked(c *C) {
 	c.Assert(err, Equals, ErrTagNotFound)
 
 	// As mentioned, only run a prune. We are not testing for packed objects
-	// here.
-	err = r.Prune(PruneOptions{Handler: r.DeleteObject})
+	// here. The tag object was just written, so it must not be expired.
+	err = r.Prune(PruneOptions{OnlyObjectsOlderThan: time.Now(), Handler: r.DeleteObject})
 	c.Assert(err, IsNil)
 
 	// Now check to see if the GC was effective in removing the tag object.
@@ -2893,6 +4806,46 @@ func (s *RepositorySuite) TestDeleteTagAnnotatedUnpacked(c *C) {
 	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
 }
 
+func (s *RepositorySuite) TestPackRefs(c *C) {
+	url := s.GetLocalRepositoryURL(
+		fixtures.ByURL("https://github.com/git-fixtures/tags.git").One(),
+	)
+
+	fs := s.TemporalFilesystem(c)
+
+	fss := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+
+	r, _ := Init(fss, nil)
+	err := r.clone(context.Background(), &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+
+	err = r.PackRefs(PackRefsOptions{All: true, Prune: true})
+	c.Assert(err, IsNil)
+
+	loose, err := fs.ReadDir("refs/tags")
+	c.Assert(err, IsNil)
+	c.Assert(loose, HasLen, 0)
+
+	ref, err := r.Tag("annotated-tag")
+	c.Assert(err, IsNil)
+
+	tag, err := r.TagObject(ref.Hash())
+	c.Assert(err, IsNil)
+
+	content, err := util.ReadFile(fs, "packed-refs")
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(content), "# pack-refs with: peeled fully-peeled sorted \n"), Equals, true)
+	c.Assert(strings.Contains(string(content), fmt.Sprintf(
+		"%s refs/tags/annotated-tag\n^%s\n", ref.Hash(), tag.Target,
+	)), Equals, true)
+
+	ref, err = r.Tag("lightweight-tag")
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(content), fmt.Sprintf(
+		"%s refs/tags/lightweight-tag\n^", ref.Hash(),
+	)), Equals, false)
+}
+
 func (s *RepositorySuite) TestInvalidTagName(c *C) {
 	r, err := Init(memory.NewStorage(), nil)
 	c.Assert(err, IsNil)
@@ -3211,6 +5164,169 @@ func (s *RepositorySuite) TestResolveRevisionWithErrors(c *C) {
 	}
 }
 
+func (s *RepositorySuite) TestResolveRevisionReflog(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+
+	const (
+		zero    = "0000000000000000000000000000000000000000"
+		initial = "b029517f6300c2da0f4b651b8642506cd6aaf45d"
+		second  = "35e85108805c84807bc66a02d91535e1e24b38b9"
+		branch  = "e8d3ffab552895c19b9fcf7aa264d277cde33881"
+		master  = "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"
+	)
+
+	entry := func(old, new string, secs int, msg string) string {
+		return fmt.Sprintf("%s %s John Doe <john@example.com> %d +0000\t%s\n", old, new, 1427806800+secs, msg)
+	}
+
+	err := util.WriteFile(fs, "logs/refs/heads/master", []byte(
+		entry(zero, initial, 0, "commit (initial): foo")+
+			entry(initial, second, 100, "commit: bar")+
+			entry(second, master, 200, "commit: baz"),
+	), 0o644)
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(fs, "logs/HEAD", []byte(
+		entry(zero, initial, 0, "commit (initial): foo")+
+			entry(initial, second, 100, "commit: bar")+
+			entry(second, branch, 150, "checkout: moving from master to "+branch)+
+			entry(branch, master, 200, "checkout: moving from "+branch+" to master"),
+	), 0o644)
+	c.Assert(err, IsNil)
+
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	datas := map[string]string{
+		"master@{0}":                    master,
+		"master@{1}":                    second,
+		"refs/heads/master@{2}":         initial,
+		"@{1}":                          second,
+		"HEAD@{1}":                      branch,
+		"HEAD@{1}~1":                    "918c48b83bd081e863dbe1b80f8998f058cd8294",
+		"HEAD@{2}^":                     initial,
+		"master@{2015-03-31T13:02:30Z}": second,
+		"master@{2015-03-31T12:00:00Z}": initial,
+		"master@{yesterday}":            master,
+		"@{-1}":                         branch,
+		"@{-2}":                         master,
+	}
+
+	for rev, hash := range datas {
+		h, err := r.ResolveRevision(plumbing.Revision(rev))
+		c.Assert(err, IsNil, Commentf("while checking %s", rev))
+		c.Assert(h.String(), Equals, hash, Commentf("while checking %s", rev))
+	}
+
+	errs := map[string]error{
+		"master@{3}":  ErrReflogTooShort,
+		"HEAD@{5}":    ErrReflogTooShort,
+		"@{-3}":       ErrReflogTooShort,
+		"foo@{1}":     ErrReflogNotFound,
+		"foo@{1.day}": ErrReflogNotFound,
+	}
+
+	for rev, expected := range errs {
+		_, err := r.ResolveRevision(plumbing.Revision(rev))
+		c.Assert(errors.Is(err, expected), Equals, true, Commentf("while checking %s: %v", rev, err))
+	}
+
+	_, err = r.ResolveRevision("master@{3}")
+	c.Assert(err, ErrorMatches, "log for 'master' only has 3 entries: reflog too short")
+}
+
+func (s *RepositorySuite) TestResolveRevisionPath(c *C) {
+	f := fixtures.Basic().One()
+	sto := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+	r, err := Open(sto, f.DotGit())
+	c.Assert(err, IsNil)
+
+	datas := map[string]string{
+		"HEAD:":              "a8d315b2b1c615d43042c3a62402b8a54288cf5c",
+		"HEAD:CHANGELOG":     "d3ff53e0564a9f87d8e84b6e28e5060e517008aa",
+		"HEAD:go":            "a39771a7651f97faf5c72e08224d857fc35133db",
+		"HEAD:go/example.go": "880cd14280f4b9b6ed3986d6671f907d7cc2a198",
+		"master~2:json/":     "5a877e6a906a2743ad6e45d99c1793642aaf8eda",
+		"branch:./CHANGELOG": "d3ff53e0564a9f87d8e84b6e28e5060e517008aa",
+		"v1.0.0:CHANGELOG":   "d3ff53e0564a9f87d8e84b6e28e5060e517008aa",
+		":CHANGELOG":         "d3ff53e0564a9f87d8e84b6e28e5060e517008aa",
+		":0:go/example.go":   "880cd14280f4b9b6ed3986d6671f907d7cc2a198",
+		":/binary file":      "35e85108805c84807bc66a02d91535e1e24b38b9",
+		":/!-some":           "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		":/branch":           "e8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"HEAD^{/vendor}":     "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+	}
+
+	for rev, hash := range datas {
+		h, err := r.ResolveRevision(plumbing.Revision(rev))
+		c.Assert(err, IsNil, Commentf("while checking %s", rev))
+		c.Check(h.String(), Equals, hash, Commentf("while checking %s", rev))
+	}
+
+	_, err = r.ResolveRevision("HEAD~1:nope")
+	c.Assert(err, DeepEquals, &PathNotFoundError{Path: "nope", Revision: "HEAD~1"})
+	c.Assert(err, ErrorMatches, "path 'nope' does not exist in 'HEAD~1'")
+
+	_, err = r.ResolveRevision(":1:CHANGELOG")
+	c.Assert(err, DeepEquals, &PathNotFoundError{Path: "CHANGELOG", Stage: 1})
+
+	_, err = r.ResolveRevision(":/nothing matches")
+	c.Assert(err, DeepEquals, &NoMatchError{Regexp: "nothing matches"})
+
+	_, err = r.ResolveRevision("nope:CHANGELOG")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RepositorySuite) TestResolveRevisionUpstream(c *C) {
+	f := fixtures.Basic().One()
+	sto := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+	r, err := Open(sto, f.DotGit())
+	c.Assert(err, IsNil)
+
+	datas := map[string]string{
+		"master@{u}":          "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"@{upstream}":         "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"branch@{upstream}~1": "918c48b83bd081e863dbe1b80f8998f058cd8294",
+		"branch@{push}":       "e8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"@{push}":             "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+	}
+
+	for rev, hash := range datas {
+		h, err := r.ResolveRevision(plumbing.Revision(rev))
+		c.Assert(err, IsNil, Commentf("while checking %s", rev))
+		c.Check(h.String(), Equals, hash, Commentf("while checking %s", rev))
+	}
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Branches["branch"].Merge = "refs/heads/missing"
+	cfg.Raw.Section("push").SetOption("default", "current")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	h, err := r.ResolveRevision("branch@{push}")
+	c.Assert(err, IsNil)
+	c.Assert(h.String(), Equals, "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+
+	_, err = r.ResolveRevision("branch@{u}")
+	c.Assert(errors.Is(err, plumbing.ErrReferenceNotFound), Equals, true, Commentf("%v", err))
+
+	cfg.Raw.Section("push").SetOption("default", "nothing")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	_, err = r.ResolveRevision("master@{push}")
+	c.Assert(errors.Is(err, ErrNoUpstream), Equals, true, Commentf("%v", err))
+
+	_, err = r.ResolveRevision("v1.0.0@{u}")
+	c.Assert(err, NotNil)
+
+	err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD,
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")))
+	c.Assert(err, IsNil)
+
+	_, err = r.ResolveRevision("@{u}")
+	c.Assert(errors.Is(err, ErrNoUpstream), Equals, true, Commentf("%v", err))
+}
+
 func (s *RepositorySuite) testRepackObjects(
 	c *C, deleteTime time.Time, expectedPacks int) {
 	srcFs := fixtures.ByTag("unpacked").One().DotGit()
@@ -3361,8 +5477,8 @@ func (s *RepositorySuite) TestBrokenMultipleShallowFetch(c *C) {
 	c.Assert(err, IsNil)
 }
 
-func (s *RepositorySuite) TestDotGitToOSFilesystemsInvalidPath(c *C) {
-	_, _, err := dotGitToOSFilesystems("\000", false)
+func (s *RepositorySuite) TestDiscoverRepositoryInvalidPath(c *C) {
+	_, err := discoverRepository("\000", &PlainOpenOptions{})
 	c.Assert(err, NotNil)
 }
 
@@ -3420,6 +5536,47 @@ func BenchmarkObjects(b *testing.B) {
 	}
 }
 
+func BenchmarkLog(b *testing.B) {
+	defer fixtures.Clean()
+
+	f := fixtures.ByTag(".git").ByTag("multi-packfile").One()
+	fileName := "repository.go"
+
+	for _, size := range []cache.FileSize{cache.MiByte, cache.DefaultMaxSize} {
+		for _, o := range []*LogOptions{{}, {FileName: &fileName}} {
+			name := fmt.Sprintf("cache=%dMiB", size/cache.MiByte)
+			if o.FileName != nil {
+				name += "/file"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				objects := cache.NewObjectLRU(size)
+				repo, err := Open(filesystem.NewStorage(f.DotGit(), objects), nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					iter, err := repo.Log(o)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					if err := iter.ForEach(func(*object.Commit) error { return nil }); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				stats := objects.Stats()
+				b.ReportMetric(float64(stats.Hits)/float64(b.N), "hits/op")
+				b.ReportMetric(float64(stats.Misses)/float64(b.N), "misses/op")
+			})
+		}
+	}
+}
+
 func BenchmarkPlainClone(b *testing.B) {
 	b.StopTimer()
 	clone := func(b *testing.B) {
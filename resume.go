@@ -0,0 +1,306 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const (
+	defaultResumeRetries = 3
+	// infiniteDepth is the depth requested to unshallow commits, same as
+	// git fetch --unshallow.
+	infiniteDepth = 0x7fffffff
+)
+
+// fetchResume keeps the packfile being received by a fetch, so the objects
+// fully received are recovered if the transfer is interrupted.
+//
+// The commits recovered along with their full tree are sent on the retries as
+// haves, declared as shallow and asking for them to be unshallowed, so the
+// server sends their history but not their trees.
+type fetchResume struct {
+	fs   billy.Filesystem
+	file billy.File
+	// interrupted is whether the last attempt failed while receiving the
+	// packfile.
+	interrupted bool
+	// recovered are the objects stored from the interrupted attempts.
+	recovered map[plumbing.Hash]bool
+	// commits are the recovered commits, haves the ones with a full tree.
+	commits []plumbing.Hash
+	haves   []plumbing.Hash
+}
+
+func newFetchResume(o *ResumeOptions) *fetchResume {
+	fs := o.Filesystem
+	if fs == nil {
+		fs = osfs.New(os.TempDir())
+	}
+
+	return &fetchResume{
+		fs:        fs,
+		recovered: make(map[plumbing.Hash]bool),
+	}
+}
+
+// fetchResuming fetches retrying as set by o.Resume.
+func (r *Remote) fetchResuming(ctx context.Context, o *FetchOptions) (sto storer.ReferenceStorer, err error) {
+	fr := newFetchResume(o.Resume)
+	defer func() {
+		if cerr := fr.close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	retries := o.Resume.Retries
+	if retries <= 0 {
+		retries = defaultResumeRetries
+	}
+
+	for i := 0; ; i++ {
+		fr.interrupted = false
+		sto, err = r.fetchOnce(ctx, o, fr)
+		if err == nil || !fr.interrupted || i == retries || ctx.Err() != nil {
+			return sto, err
+		}
+
+		if rerr := fr.recover(r.s); rerr != nil {
+			return nil, rerr
+		}
+	}
+}
+
+// prepare adds to the request the haves recovered so far, along with the
+// wants present only because they were recovered, as their history may be
+// incomplete.
+func (f *fetchResume) prepare(req *packp.UploadPackRequest, adv *capability.List,
+	refs memory.ReferenceStorage) error {
+
+	for _, ref := range refs {
+		h := ref.Hash()
+		if f.recovered[h] && !slices.Contains(req.Wants, h) {
+			req.Wants = append(req.Wants, h)
+		}
+	}
+
+	if len(f.haves) == 0 || !req.Depth.IsZero() || !adv.Supports(capability.Shallow) {
+		return nil
+	}
+
+	if err := req.Capabilities.Set(capability.Shallow); err != nil {
+		return err
+	}
+
+	req.Depth = packp.DepthCommits(infiniteDepth)
+	req.Haves = append(req.Haves, f.haves...)
+	req.Shallows = append(req.Shallows, f.haves...)
+	return nil
+}
+
+// begin returns the writer the packfile being received must be copied to.
+func (f *fetchResume) begin() (io.Writer, error) {
+	if f.file != nil {
+		if err := f.file.Truncate(0); err != nil {
+			return nil, err
+		}
+
+		_, err := f.file.Seek(0, io.SeekStart)
+		return f.file, err
+	}
+
+	var err error
+	f.file, err = f.fs.TempFile("", "go-git-fetch-")
+	return f.file, err
+}
+
+// recover stores the objects fully received by the last attempt, and updates
+// the haves of the next one.
+func (f *fetchResume) recover(s storage.Storer) error {
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	sc := packfile.NewScanner(f.file)
+	if _, _, err := sc.Header(); err != nil {
+		// Nothing received.
+		return nil
+	}
+
+	offsets := make(map[int64]plumbing.Hash)
+	for {
+		h, err := sc.NextObjectHeader()
+		if err != nil {
+			break
+		}
+
+		buf := bytes.NewBuffer(nil)
+		if _, _, err := sc.NextObject(buf); err != nil || int64(buf.Len()) != h.Length {
+			// The object being received when the transfer was interrupted.
+			break
+		}
+
+		obj, err := recoverObject(s, h, buf.Bytes(), offsets)
+		if err != nil {
+			return err
+		}
+
+		if obj == nil {
+			continue
+		}
+
+		hash, err := s.SetEncodedObject(obj)
+		if err != nil {
+			return err
+		}
+
+		offsets[h.Offset] = hash
+		f.recovered[hash] = true
+		if obj.Type() == plumbing.CommitObject {
+			f.commits = append(f.commits, hash)
+		}
+	}
+
+	return f.updateHaves(s)
+}
+
+// recoverObject builds the object of a packfile entry, resolving the deltas.
+// It returns nil if the base of a delta is missing.
+func recoverObject(s storage.Storer, h *packfile.ObjectHeader, content []byte,
+	offsets map[int64]plumbing.Hash) (plumbing.EncodedObject, error) {
+
+	obj := s.NewEncodedObject()
+	var base plumbing.Hash
+	switch h.Type {
+	case plumbing.OFSDeltaObject:
+		var ok bool
+		if base, ok = offsets[h.OffsetReference]; !ok {
+			return nil, nil
+		}
+	case plumbing.REFDeltaObject:
+		base = h.Reference
+	default:
+		obj.SetType(h.Type)
+		obj.SetSize(h.Length)
+		w, err := obj.Writer()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := w.Write(content); err != nil {
+			return nil, err
+		}
+
+		return obj, w.Close()
+	}
+
+	b, err := s.EncodedObject(plumbing.AnyObject, base)
+	if err == plumbing.ErrObjectNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	obj.SetType(b.Type())
+	return obj, packfile.ApplyDelta(obj, b, content)
+}
+
+// updateHaves adds to the haves the recovered commits whose tree is complete.
+func (f *fetchResume) updateHaves(s storage.Storer) error {
+	complete := make(map[plumbing.Hash]bool)
+	var pending []plumbing.Hash
+	for _, h := range f.commits {
+		c, err := object.GetCommit(s, h)
+		if err != nil {
+			return err
+		}
+
+		ok, err := isTreeComplete(s, c.TreeHash, complete)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			f.haves = append(f.haves, h)
+		} else {
+			pending = append(pending, h)
+		}
+	}
+
+	f.commits = pending
+	return nil
+}
+
+// isTreeComplete returns whether all the objects of the tree h are present.
+func isTreeComplete(s storage.Storer, h plumbing.Hash, complete map[plumbing.Hash]bool) (bool, error) {
+	if ok, seen := complete[h]; seen {
+		return ok, nil
+	}
+
+	t, err := object.GetTree(s, h)
+	if err == plumbing.ErrObjectNotFound {
+		complete[h] = false
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	for _, e := range t.Entries {
+		switch e.Mode {
+		case filemode.Submodule:
+			continue
+		case filemode.Dir:
+			ok, err = isTreeComplete(s, e.Hash, complete)
+			if err != nil {
+				return false, err
+			}
+		default:
+			err = s.HasEncodedObject(e.Hash)
+			if err != nil && err != plumbing.ErrObjectNotFound {
+				return false, err
+			}
+
+			ok = err == nil
+		}
+
+		if !ok {
+			break
+		}
+	}
+
+	complete[h] = ok
+	return ok, nil
+}
+
+// close removes the packfile kept.
+func (f *fetchResume) close() error {
+	if f.file == nil {
+		return nil
+	}
+
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	return f.fs.Remove(f.file.Name())
+}
@@ -0,0 +1,176 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+var errConnectionDropped = errors.New("connection dropped")
+
+// flakyTransport drops the connection of the upload-pack requests, once the
+// number of bytes given in limits is received.
+type flakyTransport struct {
+	transport.Transport
+	limits   []int
+	received []int
+}
+
+func (t *flakyTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (
+	transport.UploadPackSession, error) {
+
+	s, err := t.Transport.NewUploadPackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &flakySession{UploadPackSession: s, t: t}, nil
+}
+
+type flakySession struct {
+	transport.UploadPackSession
+	t *flakyTransport
+}
+
+func (s *flakySession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (
+	*packp.UploadPackResponse, error) {
+
+	resp, err := s.UploadPackSession.UploadPack(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := -1
+	if i := len(s.t.received); i < len(s.t.limits) {
+		limit = s.t.limits[i]
+	}
+
+	s.t.received = append(s.t.received, 0)
+	return packp.NewUploadPackResponseWithPackfile(req, &flakyReader{
+		ReadCloser: resp,
+		t:          s.t,
+		i:          len(s.t.received) - 1,
+		limit:      limit,
+	}), nil
+}
+
+// flakyReader counts the bytes read, failing once limit is reached if not
+// negative. The rest of the response is then discarded, so the server exits.
+type flakyReader struct {
+	io.ReadCloser
+	t     *flakyTransport
+	i     int
+	limit int
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	received := r.t.received[r.i]
+	if r.limit >= 0 && received >= r.limit {
+		go io.Copy(io.Discard, r.ReadCloser)
+		return 0, errConnectionDropped
+	}
+
+	if r.limit >= 0 && len(p) > r.limit-received {
+		p = p[:r.limit-received]
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.t.received[r.i] += n
+	return n, err
+}
+
+type ResumeSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ResumeSuite{})
+
+// newServer returns the URL of a repository whose history rewrites a file
+// with random content on each commit, so its packfile can not be compressed.
+func (s *ResumeSuite) newServer(c *C) string {
+	url := c.MkDir()
+	r, err := PlainInit(url, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 10; i++ {
+		content := make([]byte, 16*1024)
+		rnd.Read(content)
+
+		c.Assert(util.WriteFile(w.Filesystem, "file", content, 0644), IsNil)
+		_, err = w.Add("file")
+		c.Assert(err, IsNil)
+		_, err = w.Commit(fmt.Sprintf("version %d\n", i), &CommitOptions{Author: defaultSignature()})
+		c.Assert(err, IsNil)
+	}
+
+	return url
+}
+
+func (s *ResumeSuite) clone(c *C, url string, t *flakyTransport, o *ResumeOptions) (*Repository, error) {
+	client.InstallProtocol("flaky", t)
+	defer client.InstallProtocol("flaky", nil)
+
+	return Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:    "flaky://" + url,
+		Resume: o,
+	})
+}
+
+func (s *ResumeSuite) TestCloneResume(c *C) {
+	url := s.newServer(c)
+	full := &flakyTransport{Transport: file.DefaultClient}
+	_, err := s.clone(c, url, full, nil)
+	c.Assert(err, IsNil)
+	c.Assert(full.received, HasLen, 1)
+	size := full.received[0]
+
+	t := &flakyTransport{Transport: file.DefaultClient, limits: []int{size * 3 / 4}}
+	fs := memfs.New()
+	r, err := s.clone(c, url, t, &ResumeOptions{Filesystem: fs})
+	c.Assert(err, IsNil)
+	c.Assert(t.received, HasLen, 2)
+	c.Assert(t.received[1] < size/2, Equals, true)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	iter, err := r.Log(&LogOptions{From: head.Hash()})
+	c.Assert(err, IsNil)
+	var commits int
+	err = iter.ForEach(func(commit *object.Commit) error {
+		commits++
+		_, err := commit.Files()
+		return err
+	})
+	c.Assert(err, IsNil)
+	c.Assert(commits, Equals, 10)
+
+	files, err := fs.ReadDir("")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+}
+
+func (s *ResumeSuite) TestCloneResumeRetries(c *C) {
+	url := s.newServer(c)
+	t := &flakyTransport{Transport: file.DefaultClient, limits: []int{1000, 1000, 1000}}
+	_, err := s.clone(c, url, t, &ResumeOptions{Retries: 1, Filesystem: memfs.New()})
+	c.Assert(errors.Is(err, errConnectionDropped), Equals, true)
+	c.Assert(t.received, HasLen, 2)
+}
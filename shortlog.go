@@ -0,0 +1,189 @@
+package git
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ShortlogEntry is the summary of the commits of an author, or committer, in
+// a shortlog.
+type ShortlogEntry struct {
+	// Name is the name of the author.
+	Name string
+	// Email is the email of the author, only set when the commits are
+	// grouped by email.
+	Email string
+	// Count is the number of commits of the author.
+	Count int
+	// Subjects are the subjects of the commits, oldest first, unless only
+	// the summary is requested.
+	Subjects []string
+}
+
+// Shortlog summarizes the commits selected by the options by author, as git
+// shortlog does. The entries are sorted by name, or by decreasing number of
+// commits if requested.
+func (r *Repository) Shortlog(o *ShortlogOptions) ([]*ShortlogEntry, error) {
+	if o == nil {
+		o = &ShortlogOptions{}
+	}
+
+	lo := o.LogOptions
+	var excluded map[plumbing.Hash]bool
+	if o.Range != "" {
+		from, exclude, err := r.resolveRange(o.Range)
+		if err != nil {
+			return nil, err
+		}
+
+		lo.From = from
+		if !exclude.IsZero() {
+			excluded, err = r.ancestors(exclude)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	iter, err := r.Log(&lo)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*ShortlogEntry)
+	err = iter.ForEach(func(c *object.Commit) error {
+		if excluded[c.Hash] {
+			return nil
+		}
+
+		sig := c.Author
+		if o.GroupByCommitter {
+			sig = c.Committer
+		}
+
+		key := sig.Name
+		if o.Email {
+			key += " <" + sig.Email + ">"
+		}
+
+		e, ok := entries[key]
+		if !ok {
+			e = &ShortlogEntry{Name: sig.Name}
+			if o.Email {
+				e.Email = sig.Email
+			}
+
+			entries[key] = e
+		}
+
+		e.Count++
+		if !o.SummaryOnly {
+			e.Subjects = append(e.Subjects, shortlogSubject(c.Message))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*ShortlogEntry, 0, len(entries))
+	for _, e := range entries {
+		// The commits are walked newest first.
+		for i, j := 0, len(e.Subjects)-1; i < j; i, j = i+1, j-1 {
+			e.Subjects[i], e.Subjects[j] = e.Subjects[j], e.Subjects[i]
+		}
+
+		result = append(result, e)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if o.Numbered && result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+
+		return result[i].Email < result[j].Email
+	})
+
+	return result, nil
+}
+
+// resolveRange resolves a range of commits such as "v1.0..HEAD" to the
+// commit the history starts from and the one whose history is excluded, zero
+// if the range is a single revision.
+func (r *Repository) resolveRange(rng string) (from, exclude plumbing.Hash, err error) {
+	left, right, ok := strings.Cut(rng, "..")
+	if !ok {
+		left, right = "", rng
+	}
+
+	if right == "" {
+		right = "HEAD"
+	}
+
+	h, err := r.ResolveRevision(plumbing.Revision(right))
+	if err != nil {
+		return plumbing.ZeroHash, plumbing.ZeroHash, err
+	}
+
+	from = *h
+	if !ok {
+		return from, plumbing.ZeroHash, nil
+	}
+
+	if left == "" {
+		left = "HEAD"
+	}
+
+	h, err = r.ResolveRevision(plumbing.Revision(left))
+	if err != nil {
+		return plumbing.ZeroHash, plumbing.ZeroHash, err
+	}
+
+	return from, *h, nil
+}
+
+// ancestors returns the commit and all its ancestors.
+func (r *Repository) ancestors(h plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	c, err := r.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+	err = object.NewCommitPreorderIter(c, nil, nil).ForEach(func(c *object.Commit) error {
+		seen[c.Hash] = true
+		return nil
+	})
+
+	return seen, err
+}
+
+// shortlogSubject returns the subject of a commit message, its first
+// paragraph on one line, without the "[PATCH]" prefix of the patches
+// applied from emails, as git shortlog does.
+func shortlogSubject(msg string) string {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimLeft(msg, "\n"), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			break
+		}
+
+		lines = append(lines, l)
+	}
+
+	subject := strings.Join(lines, " ")
+	if strings.HasPrefix(subject, "[PATCH]") {
+		subject = strings.TrimSpace(subject[len("[PATCH]"):])
+	}
+
+	return subject
+}
@@ -0,0 +1,111 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type ShortlogSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ShortlogSuite{})
+
+// shortlogRepository returns a repository with commits by several authors,
+// and the v1 tag pointing to the first one.
+func (s *ShortlogSuite) shortlogRepository(c *C) *Repository {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	when := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, commit := range []struct{ name, email, msg string }{
+		{"bob", "b@example.com", "b0"},
+		{"bob", "b@example.com", "b1"},
+		{"Alice", "a@example.com", "a1\n\nbody"},
+		{"bob", "b@example.com", "b2"},
+		{"carol", "c@example.com", "c1\nsecond line"},
+		{"Alice", "a@example.com", "a2"},
+		{"bob", "bob@example.com", "[PATCH] b3"},
+	} {
+		author := &object.Signature{Name: commit.name, Email: commit.email, When: when.Add(time.Duration(i) * time.Hour)}
+		committer := &object.Signature{Name: "ci", Email: "ci@example.com", When: author.When}
+		h, err := w.Commit(commit.msg, &CommitOptions{Author: author, Committer: committer, AllowEmptyCommits: true})
+		c.Assert(err, IsNil)
+
+		if i == 0 {
+			_, err = r.CreateTag("v1", h, nil)
+			c.Assert(err, IsNil)
+		}
+	}
+
+	return r
+}
+
+func (s *ShortlogSuite) TestShortlog(c *C) {
+	r := s.shortlogRepository(c)
+
+	entries, err := r.Shortlog(&ShortlogOptions{Range: "v1..HEAD"})
+	c.Assert(err, IsNil)
+	c.Assert(entries, DeepEquals, []*ShortlogEntry{
+		{Name: "Alice", Count: 2, Subjects: []string{"a1", "a2"}},
+		{Name: "bob", Count: 3, Subjects: []string{"b1", "b2", "b3"}},
+		{Name: "carol", Count: 1, Subjects: []string{"c1 second line"}},
+	})
+}
+
+func (s *ShortlogSuite) TestShortlogSummary(c *C) {
+	r := s.shortlogRepository(c)
+
+	entries, err := r.Shortlog(&ShortlogOptions{SummaryOnly: true, Numbered: true})
+	c.Assert(err, IsNil)
+	c.Assert(entries, DeepEquals, []*ShortlogEntry{
+		{Name: "bob", Count: 4},
+		{Name: "Alice", Count: 2},
+		{Name: "carol", Count: 1},
+	})
+
+	entries, err = r.Shortlog(&ShortlogOptions{Range: "v1..", SummaryOnly: true, Email: true})
+	c.Assert(err, IsNil)
+	c.Assert(entries, DeepEquals, []*ShortlogEntry{
+		{Name: "Alice", Email: "a@example.com", Count: 2},
+		{Name: "bob", Email: "b@example.com", Count: 2},
+		{Name: "bob", Email: "bob@example.com", Count: 1},
+		{Name: "carol", Email: "c@example.com", Count: 1},
+	})
+
+	entries, err = r.Shortlog(&ShortlogOptions{GroupByCommitter: true, SummaryOnly: true})
+	c.Assert(err, IsNil)
+	c.Assert(entries, DeepEquals, []*ShortlogEntry{{Name: "ci", Count: 7}})
+}
+
+func (s *ShortlogSuite) TestShortlogLogOptions(c *C) {
+	r := s.shortlogRepository(c)
+
+	file := filepath.Join(c.MkDir(), "mailmap")
+	c.Assert(os.WriteFile(file, []byte("Bob <b@example.com> <bob@example.com>\n"), 0o644), IsNil)
+	c.Assert(r.SetConfigValue(config.LocalScope, "mailmap.file", file), IsNil)
+
+	since := time.Date(2020, 1, 1, 4, 0, 0, 0, time.UTC)
+	entries, err := r.Shortlog(&ShortlogOptions{
+		LogOptions:  LogOptions{Since: &since, UseMailmap: true},
+		SummaryOnly: true,
+		Email:       true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(entries, DeepEquals, []*ShortlogEntry{
+		{Name: "Alice", Email: "a@example.com", Count: 1},
+		{Name: "Bob", Email: "b@example.com", Count: 1},
+		{Name: "carol", Email: "c@example.com", Count: 1},
+	})
+}
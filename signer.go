@@ -1,11 +1,31 @@
 package git
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgped25519 "github.com/ProtonMail/go-crypto/openpgp/ed25519"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh"
 )
 
+// ErrUnsupportedSigningKey is returned when a signer is created with a key
+// whose algorithm is not supported by the signature format.
+var ErrUnsupportedSigningKey = errors.New("unsupported signing key")
+
 // signableObject is an object which can be signed.
 type signableObject interface {
 	EncodeWithoutSignature(o plumbing.EncodedObject) error
@@ -15,10 +35,50 @@ type signableObject interface {
 // message is a reader containing the encoded object to be signed.
 // Implementors should return the encoded signature and an error if any.
 // See https://git-scm.com/docs/gitformat-signature for more information.
+//
+// The whole message is given to the signer, which hashes it as its format
+// requires. A key only able to sign digests, such as one kept in a KMS or an
+// HSM, can be used through a crypto.Signer with NewOpenPGPSigner or
+// NewSSHSigner.
 type Signer interface {
 	Sign(message io.Reader) ([]byte, error)
 }
 
+// SignatureFormat is the format of the signatures of a Signer, as the
+// gpg.format option of git.
+type SignatureFormat string
+
+const (
+	// SignatureFormatOpenPGP is an OpenPGP signature, as made by gpg.
+	SignatureFormatOpenPGP SignatureFormat = "openpgp"
+	// SignatureFormatSSH is an SSH signature, as made by ssh-keygen -Y sign.
+	SignatureFormatSSH SignatureFormat = "ssh"
+	// SignatureFormatX509 is a CMS signature, as made by gpgsm.
+	SignatureFormatX509 SignatureFormat = "x509"
+)
+
+// armorType returns the type of the armor of the signatures of the format.
+func (f SignatureFormat) armorType() string {
+	switch f {
+	case SignatureFormatOpenPGP:
+		return openpgp.SignatureType
+	case SignatureFormatSSH:
+		return "SSH SIGNATURE"
+	case SignatureFormatX509:
+		return "SIGNED MESSAGE"
+	}
+
+	return ""
+}
+
+// FormatSigner is a Signer declaring the format of its signatures. A
+// FormatSigner may return a binary signature, which is then armored as its
+// format requires before being written in the object.
+type FormatSigner interface {
+	Signer
+	SignatureFormat() SignatureFormat
+}
+
 func signObject(signer Signer, obj signableObject) ([]byte, error) {
 	encoded := &plumbing.MemoryObject{}
 	if err := obj.EncodeWithoutSignature(encoded); err != nil {
@@ -29,5 +89,189 @@ func signObject(signer Signer, obj signableObject) ([]byte, error) {
 		return nil, err
 	}
 
-	return signer.Sign(r)
+	sig, err := signer.Sign(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, ok := signer.(FormatSigner)
+	if !ok {
+		return sig, nil
+	}
+
+	return armorSignature(sig, fs.SignatureFormat())
+}
+
+// armorSignature armors the given signature as the format requires, unless
+// it is armored already.
+func armorSignature(sig []byte, format SignatureFormat) ([]byte, error) {
+	typ := format.armorType()
+	if typ == "" {
+		return nil, fmt.Errorf("unknown signature format %q", format)
+	}
+
+	if bytes.HasPrefix(sig, []byte("-----BEGIN ")) {
+		return sig, nil
+	}
+
+	if format != SignatureFormatOpenPGP {
+		return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: sig}), nil
+	}
+
+	var b bytes.Buffer
+	w, err := armor.Encode(&b, typ, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(sig); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// NewOpenPGPSigner returns a Signer making OpenPGP signatures with the given
+// RSA key, which only has to sign digests, or Ed25519 private key.
+// created is the creation time of the OpenPGP key, part of its fingerprint,
+// so the signatures match the public key known by the verifiers. If config
+// is nil, the signatures use SHA-256.
+func NewOpenPGPSigner(key crypto.Signer, created time.Time, config *packet.Config) (FormatSigner, error) {
+	var pk *packet.PrivateKey
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		pk = &packet.PrivateKey{PublicKey: *packet.NewRSAPublicKey(created, pub), PrivateKey: key}
+	case ed25519.PublicKey:
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: Ed25519 keys must be an ed25519.PrivateKey", ErrUnsupportedSigningKey)
+		}
+
+		pk = packet.NewSignerPrivateKey(created, &pgped25519.PrivateKey{
+			PublicKey: pgped25519.PublicKey{Point: pub},
+			Key:       priv,
+		})
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedSigningKey, pub)
+	}
+
+	if config == nil {
+		config = &packet.Config{DefaultHash: crypto.SHA256}
+	}
+
+	return &openPGPSigner{key: pk, config: config}, nil
+}
+
+type openPGPSigner struct {
+	key    *packet.PrivateKey
+	config *packet.Config
+}
+
+func (s *openPGPSigner) SignatureFormat() SignatureFormat {
+	return SignatureFormatOpenPGP
+}
+
+// Sign returns the binary OpenPGP signature of the message, armored when
+// written in the object.
+func (s *openPGPSigner) Sign(message io.Reader) ([]byte, error) {
+	sig := &packet.Signature{
+		Version:           s.key.Version,
+		SigType:           packet.SigTypeBinary,
+		PubKeyAlgo:        s.key.PubKeyAlgo,
+		Hash:              s.config.Hash(),
+		CreationTime:      s.config.Now(),
+		IssuerKeyId:       &s.key.KeyId,
+		IssuerFingerprint: s.key.Fingerprint,
+	}
+
+	h, err := sig.PrepareSign(s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, err
+	}
+
+	if err := sig.Sign(h, s.key, s.config); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := sig.Serialize(&b); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
 }
+
+// sshSignatureNamespace is the namespace of the SSH signatures of git.
+const sshSignatureNamespace = "git"
+
+// NewSSHSigner returns a Signer making SSH signatures with the given key,
+// as git does with gpg.format set to ssh. The keys only signing digests are
+// supported, except Ed25519 ones, which sign the whole message.
+func NewSSHSigner(key crypto.Signer) (FormatSigner, error) {
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSigningKey, err)
+	}
+
+	return &sshSigner{signer: signer}, nil
+}
+
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func (s *sshSigner) SignatureFormat() SignatureFormat {
+	return SignatureFormatSSH
+}
+
+// Sign returns the SSH signature of the message, in the format of
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig,
+// armored when written in the object.
+func (s *sshSigner) Sign(message io.Reader) ([]byte, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, err
+	}
+
+	signed := ssh.Marshal(struct {
+		Magic     [6]byte
+		Namespace string
+		Reserved  string
+		Hash      string
+		Digest    []byte
+	}{sshSigMagic, sshSignatureNamespace, "", "sha512", h.Sum(nil)})
+
+	var sig *ssh.Signature
+	var err error
+	pub := s.signer.PublicKey()
+	if as, ok := s.signer.(ssh.AlgorithmSigner); ok && pub.Type() == ssh.KeyAlgoRSA {
+		// The SHA-1 signatures of ssh-rsa are not accepted by ssh-keygen.
+		sig, err = as.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = s.signer.Sign(rand.Reader, signed)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.Marshal(struct {
+		Magic     [6]byte
+		Version   uint32
+		PublicKey []byte
+		Namespace string
+		Reserved  string
+		Hash      string
+		Signature []byte
+	}{sshSigMagic, 1, pub.Marshal(), sshSignatureNamespace, "", "sha512", ssh.Marshal(sig)}), nil
+}
+
+var sshSigMagic = [6]byte{'S', 'S', 'H', 'S', 'I', 'G'}
@@ -1,16 +1,37 @@
 package git
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
 	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"time"
 
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgped25519 "github.com/ProtonMail/go-crypto/openpgp/ed25519"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh"
+	. "gopkg.in/check.v1"
 )
 
+type SignerSuite struct{}
+
+var _ = Suite(&SignerSuite{})
+
 type b64signer struct{}
 
 // This is not secure, and is only used as an example for testing purposes.
@@ -54,3 +75,201 @@ func ExampleSigner() {
 	fmt.Println(obj.PGPSignature)
 	// Output: dHJlZSA0YjgyNWRjNjQyY2I2ZWI5YTA2MGU1NGJmOGQ2OTI4OGZiZWU0OTA0CmF1dGhvciBKb2huIERvZSA8am9obkBleGFtcGxlLmNvbT4gMTIzNCArMDAwMApjb21taXR0ZXIgSm9obiBEb2UgPGpvaG5AZXhhbXBsZS5jb20+IDEyMzQgKzAwMDAKCmV4YW1wbGUgY29tbWl0
 }
+
+// kmsSigner is a crypto.Signer only signing digests, as the keys kept in a
+// KMS do.
+type kmsSigner struct {
+	key crypto.Signer
+}
+
+func (s kmsSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s kmsSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() == 0 || len(digest) != opts.HashFunc().Size() {
+		return nil, errors.New("only digests can be signed")
+	}
+
+	return s.key.Sign(rand, digest, opts)
+}
+
+// signedCommit commits in a new repository with the given signer, returning
+// the commit and its content without the signature.
+func signedCommit(c *C, signer Signer) (*object.Commit, []byte) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	h, err := w.Commit("foo\n", &CommitOptions{
+		Author:            defaultSignature(),
+		Signer:            signer,
+		AllowEmptyCommits: true,
+	})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+
+	encoded := &plumbing.MemoryObject{}
+	c.Assert(commit.EncodeWithoutSignature(encoded), IsNil)
+	rd, err := encoded.Reader()
+	c.Assert(err, IsNil)
+	message, err := io.ReadAll(rd)
+	c.Assert(err, IsNil)
+
+	return commit, message
+}
+
+// verifyOpenPGP verifies the armored OpenPGP signature of the message.
+func verifyOpenPGP(c *C, pub *packet.PublicKey, signature string, message []byte) {
+	block, err := armor.Decode(bytes.NewBufferString(signature))
+	c.Assert(err, IsNil)
+	c.Assert(block.Type, Equals, "PGP SIGNATURE")
+
+	p, err := packet.Read(block.Body)
+	c.Assert(err, IsNil)
+	sig, ok := p.(*packet.Signature)
+	c.Assert(ok, Equals, true)
+	c.Assert(*sig.IssuerKeyId, Equals, pub.KeyId)
+
+	h, err := sig.PrepareVerify()
+	c.Assert(err, IsNil)
+	h.Write(message)
+	c.Assert(pub.VerifySignature(h, sig), IsNil)
+}
+
+// verifySSH verifies the armored SSH signature of the message, as
+// ssh-keygen -Y verify does.
+func verifySSH(c *C, pub crypto.PublicKey, signature string, message []byte) {
+	block, rest := pem.Decode([]byte(signature))
+	c.Assert(block, NotNil)
+	c.Assert(block.Type, Equals, "SSH SIGNATURE")
+	c.Assert(rest, HasLen, 0)
+
+	var sig struct {
+		Magic     [6]byte
+		Version   uint32
+		PublicKey []byte
+		Namespace string
+		Reserved  string
+		Hash      string
+		Signature []byte
+	}
+	c.Assert(ssh.Unmarshal(block.Bytes, &sig), IsNil)
+	c.Assert(string(sig.Magic[:]), Equals, "SSHSIG")
+	c.Assert(sig.Namespace, Equals, "git")
+	c.Assert(sig.Hash, Equals, "sha512")
+
+	expected, err := ssh.NewPublicKey(pub)
+	c.Assert(err, IsNil)
+	key, err := ssh.ParsePublicKey(sig.PublicKey)
+	c.Assert(err, IsNil)
+	c.Assert(key.Marshal(), DeepEquals, expected.Marshal())
+
+	var s ssh.Signature
+	c.Assert(ssh.Unmarshal(sig.Signature, &s), IsNil)
+
+	digest := sha512.Sum512(message)
+	signed := ssh.Marshal(struct {
+		Magic     [6]byte
+		Namespace string
+		Reserved  string
+		Hash      string
+		Digest    []byte
+	}{sig.Magic, sig.Namespace, "", sig.Hash, digest[:]})
+	c.Assert(key.Verify(signed, &s), IsNil)
+}
+
+func (s *SignerSuite) TestOpenPGPSignerKMS(c *C) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+
+	created := time.Unix(1700000000, 0)
+	signer, err := NewOpenPGPSigner(kmsSigner{key}, created, nil)
+	c.Assert(err, IsNil)
+	c.Assert(signer.SignatureFormat(), Equals, SignatureFormatOpenPGP)
+
+	commit, message := signedCommit(c, signer)
+	verifyOpenPGP(c, packet.NewRSAPublicKey(created, &key.PublicKey), commit.PGPSignature, message)
+}
+
+func (s *SignerSuite) TestOpenPGPSignerEd25519(c *C) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+
+	created := time.Unix(1700000000, 0)
+	signer, err := NewOpenPGPSigner(key, created, nil)
+	c.Assert(err, IsNil)
+
+	commit, message := signedCommit(c, signer)
+	pk := packet.NewEd25519PublicKey(created, &pgped25519.PublicKey{Point: pub})
+	verifyOpenPGP(c, pk, commit.PGPSignature, message)
+}
+
+func (s *SignerSuite) TestOpenPGPSignerUnsupportedKey(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	_, err = NewOpenPGPSigner(kmsSigner{key}, time.Now(), nil)
+	c.Assert(errors.Is(err, ErrUnsupportedSigningKey), Equals, true)
+}
+
+func (s *SignerSuite) TestSSHSignerKMS(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	signer, err := NewSSHSigner(kmsSigner{key})
+	c.Assert(err, IsNil)
+	c.Assert(signer.SignatureFormat(), Equals, SignatureFormatSSH)
+
+	commit, message := signedCommit(c, signer)
+	verifySSH(c, key.Public(), commit.PGPSignature, message)
+}
+
+func (s *SignerSuite) TestSSHSignerRSA(c *C) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+
+	signer, err := NewSSHSigner(kmsSigner{key})
+	c.Assert(err, IsNil)
+
+	commit, message := signedCommit(c, signer)
+	verifySSH(c, key.Public(), commit.PGPSignature, message)
+}
+
+func (s *SignerSuite) TestSSHSignerTag(c *C) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+
+	signer, err := NewSSHSigner(key)
+	c.Assert(err, IsNil)
+
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	h, err := w.Commit("foo\n", &CommitOptions{Author: defaultSignature(), AllowEmptyCommits: true})
+	c.Assert(err, IsNil)
+
+	ref, err := r.CreateTag("v1", h, &CreateTagOptions{
+		Tagger:  defaultSignature(),
+		Message: "v1",
+		Signer:  signer,
+	})
+	c.Assert(err, IsNil)
+
+	tag, err := r.TagObject(ref.Hash())
+	c.Assert(err, IsNil)
+
+	encoded := &plumbing.MemoryObject{}
+	c.Assert(tag.EncodeWithoutSignature(encoded), IsNil)
+	rd, err := encoded.Reader()
+	c.Assert(err, IsNil)
+	message, err := io.ReadAll(rd)
+	c.Assert(err, IsNil)
+
+	verifySSH(c, key.Public(), tag.PGPSignature, message)
+}
@@ -0,0 +1,268 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+)
+
+// ObjectSizes are the number and sizes of a set of objects.
+type ObjectSizes struct {
+	// Count is the number of objects.
+	Count int
+	// Size is the uncompressed size of the objects, in bytes.
+	Size int64
+	// DiskSize is the size the objects take in the packfiles or as loose
+	// objects, in bytes, compressed and possibly deltified. It is zero for
+	// the storages which are not a filesystem.
+	DiskSize int64
+}
+
+func (s *ObjectSizes) add(size, diskSize int64) {
+	s.Count++
+	s.Size += size
+	s.DiskSize += diskSize
+}
+
+// LargeBlob is one of the largest blobs of a repository.
+type LargeBlob struct {
+	// Hash is the hash of the blob.
+	Hash plumbing.Hash
+	// Size is the uncompressed size of the blob, in bytes.
+	Size int64
+	// DiskSize is the size the blob takes on disk, in bytes.
+	DiskSize int64
+	// Path is a path the blob is found at.
+	Path string
+	// Commit is a commit having the blob at Path, the most recent one found
+	// walking the history.
+	Commit plumbing.Hash
+}
+
+// SizeReport is the size of the objects reachable from the references of a
+// repository.
+type SizeReport struct {
+	// Total are the sizes of all the objects.
+	Total ObjectSizes
+	// Types are the sizes of the objects of each type.
+	Types map[plumbing.ObjectType]*ObjectSizes
+	// Largest are the largest blobs, the largest first.
+	Largest []LargeBlob
+	// Paths are the sizes of the blobs under each directory, the root being
+	// "", if SizeOptions.ByPath is set. A blob found at several paths is only
+	// counted at the first one found.
+	Paths map[string]*ObjectSizes
+}
+
+// SizeReport walks the objects reachable from the references of the
+// repository, as git-sizer does, to find what makes it large. Each object is
+// read once, but the blobs are not: their uncompressed size is read from the
+// header of their object, and their size on disk from the offsets in the
+// indexes of the packfiles.
+func (r *Repository) SizeReport(o *SizeOptions) (*SizeReport, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	report := &SizeReport{Types: make(map[plumbing.ObjectType]*ObjectSizes)}
+	if o.ByPath {
+		report.Paths = make(map[string]*ObjectSizes)
+	}
+
+	tips, err := r.refTips()
+	if err != nil || len(tips) == 0 {
+		return report, err
+	}
+
+	var diskSizes map[plumbing.Hash]int64
+	if fs, ok := storageFilesystem(r.Storer); ok {
+		if diskSizes, err = objectDiskSizes(fs); err != nil {
+			return nil, err
+		}
+	}
+
+	iter, err := r.WalkObjects(&WalkOptions{Include: tips})
+	if err != nil {
+		return nil, err
+	}
+
+	var commit plumbing.Hash
+	err = iter.ForEach(func(obj *WalkedObject) error {
+		size, err := r.Storer.EncodedObjectSize(obj.Hash)
+		if err != nil {
+			return err
+		}
+
+		diskSize := diskSizes[obj.Hash]
+		report.Total.add(size, diskSize)
+		if report.Types[obj.Type] == nil {
+			report.Types[obj.Type] = &ObjectSizes{}
+		}
+
+		report.Types[obj.Type].add(size, diskSize)
+		switch obj.Type {
+		case plumbing.CommitObject:
+			commit = obj.Hash
+		case plumbing.BlobObject:
+			if o.ByPath {
+				report.addPath(obj.Path, size, diskSize)
+			}
+
+			report.addLargest(o.TopN, LargeBlob{
+				Hash:     obj.Hash,
+				Size:     size,
+				DiskSize: diskSize,
+				Path:     obj.Path,
+				Commit:   commit,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// addPath adds the sizes of a blob to the directories it is under.
+func (r *SizeReport) addPath(name string, size, diskSize int64) {
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+
+		if r.Paths[dir] == nil {
+			r.Paths[dir] = &ObjectSizes{}
+		}
+
+		r.Paths[dir].add(size, diskSize)
+		if dir == "" {
+			return
+		}
+	}
+}
+
+// addLargest adds the blob to the n largest ones, if it is one of them.
+func (r *SizeReport) addLargest(n int, b LargeBlob) {
+	i := sort.Search(len(r.Largest), func(i int) bool {
+		return r.Largest[i].Size < b.Size
+	})
+
+	if i >= n {
+		return
+	}
+
+	r.Largest = append(r.Largest, LargeBlob{})
+	copy(r.Largest[i+1:], r.Largest[i:])
+	r.Largest[i] = b
+	if len(r.Largest) > n {
+		r.Largest = r.Largest[:n]
+	}
+}
+
+// refTips returns the objects the references and HEAD point to, without
+// duplicates.
+func (r *Repository) refTips() ([]plumbing.Hash, error) {
+	iter, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []plumbing.Hash
+	seen := make(map[plumbing.Hash]bool)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || seen[ref.Hash()] {
+			return nil
+		}
+
+		seen[ref.Hash()] = true
+		tips = append(tips, ref.Hash())
+		return nil
+	})
+
+	return tips, err
+}
+
+// objectDiskSizes returns the size on disk of the objects of the packfiles,
+// the distance between their offset and the next one, and of the loose
+// objects which are not packed.
+func objectDiskSizes(fs billy.Filesystem) (map[plumbing.Hash]int64, error) {
+	count := &ObjectCount{}
+	if err := countPackfiles(fs, count); err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[plumbing.Hash]int64, count.InPack)
+	for _, p := range count.Packfiles {
+		idx, err := readPackIndex(fs, p.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		iter, err := idx.EntriesByOffset()
+		if err != nil {
+			return nil, err
+		}
+
+		// The last object is followed by the checksum of the packfile.
+		var prev plumbing.Hash
+		var prevOffset int64
+		for {
+			e, err := iter.Next()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				iter.Close()
+				return nil, err
+			}
+
+			if !prev.IsZero() {
+				sizes[prev] = int64(e.Offset) - prevOffset
+			}
+
+			prev, prevOffset = e.Hash, int64(e.Offset)
+		}
+
+		iter.Close()
+		if !prev.IsZero() {
+			sizes[prev] = p.Size - hash.Size - prevOffset
+		}
+	}
+
+	for i := 0; i < 256; i++ {
+		dir := path.Join("objects", fmt.Sprintf("%02x", i))
+		files, err := fs.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			name := dir[len(dir)-2:] + f.Name()
+			if f.IsDir() || len(name) != hash.HexSize || !plumbing.IsHash(name) {
+				continue
+			}
+
+			h := plumbing.NewHash(name)
+			if _, ok := sizes[h]; !ok {
+				sizes[h] = f.Size()
+			}
+		}
+	}
+
+	return sizes, nil
+}
@@ -0,0 +1,70 @@
+package git
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type SizeReportSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&SizeReportSuite{})
+
+func (s *SizeReportSuite) TestSizeReport(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+
+	report, err := r.SizeReport(&SizeOptions{TopN: 3, ByPath: true})
+	c.Assert(err, IsNil)
+	c.Assert(report.Total.Count, Equals, 31)
+	c.Assert(report.Types[plumbing.CommitObject].Count, Equals, 9)
+	c.Assert(report.Largest, HasLen, 3)
+	c.Assert(report.Largest[0].Hash, Equals, plumbing.NewHash("49c6bb89b17060d7b4deacb7b338fcc6ea2352a9"))
+	c.Assert(report.Largest[0].Path, Equals, "json/long.json")
+	c.Assert(report.Largest[0].Commit.IsZero(), Equals, false)
+	for i := 1; i < len(report.Largest); i++ {
+		c.Assert(report.Largest[i-1].Size >= report.Largest[i].Size, Equals, true)
+	}
+
+	blobs := report.Types[plumbing.BlobObject]
+	c.Assert(*report.Paths[""], DeepEquals, *blobs)
+	c.Assert(report.Paths["go"].Count, Equals, 1)
+
+	if _, err := exec.LookPath("git"); err != nil {
+		return
+	}
+
+	for _, b := range report.Largest {
+		cmd := exec.Command("git", "--git-dir", fs.Root(), "cat-file",
+			"--batch-check=%(objectsize) %(objectsize:disk)")
+		cmd.Stdin = strings.NewReader(b.Hash.String() + "\n")
+		out, err := cmd.Output()
+		c.Assert(err, IsNil, Commentf("%s", out))
+
+		fields := strings.Fields(string(out))
+		c.Assert(fields, HasLen, 2)
+		c.Assert(strconv.FormatInt(b.Size, 10), Equals, fields[0])
+		c.Assert(strconv.FormatInt(b.DiskSize, 10), Equals, fields[1])
+	}
+}
+
+func (s *SizeReportSuite) TestSizeReportEmpty(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	report, err := r.SizeReport(&SizeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(report.Total, DeepEquals, ObjectSizes{})
+	c.Assert(report.Largest, HasLen, 0)
+}
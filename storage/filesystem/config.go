@@ -1,8 +1,10 @@
 package filesystem
 
 import (
+	"io"
 	"os"
 
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
 	"github.com/go-git/go-git/v5/utils/ioutil"
@@ -12,8 +14,27 @@ type ConfigStorage struct {
 	dir *dotgit.DotGit
 }
 
-func (c *ConfigStorage) Config() (conf *config.Config, err error) {
-	f, err := c.dir.Config()
+func (c *ConfigStorage) Config() (*config.Config, error) {
+	return readConfig(c.dir.Config)
+}
+
+func (c *ConfigStorage) SetConfig(cfg *config.Config) error {
+	return writeConfig(cfg, cfg.MarshalPreserving, c.dir.Config, c.dir.ConfigWriter)
+}
+
+// WorktreeConfig returns the config read from the config.worktree file of the
+// worktree, used when the extensions.worktreeConfig is enabled.
+func (c *ConfigStorage) WorktreeConfig() (*config.Config, error) {
+	return readConfig(c.dir.WorktreeConfig)
+}
+
+// SetWorktreeConfig writes the config.worktree file of the worktree.
+func (c *ConfigStorage) SetWorktreeConfig(cfg *config.Config) error {
+	return writeConfig(cfg, cfg.MarshalScopedPreserving, c.dir.WorktreeConfig, c.dir.WorktreeConfigWriter)
+}
+
+func readConfig(open func() (billy.File, error)) (conf *config.Config, err error) {
+	f, err := open()
 	if err != nil {
 		if os.IsNotExist(err) {
 			return config.NewConfig(), nil
@@ -26,23 +47,46 @@ func (c *ConfigStorage) Config() (conf *config.Config, err error) {
 	return config.ReadConfig(f)
 }
 
-func (c *ConfigStorage) SetConfig(cfg *config.Config) (err error) {
+func writeConfig(
+	cfg *config.Config, marshal func(src []byte) ([]byte, error), open, create func() (billy.File, error),
+) (err error) {
 	if err = cfg.Validate(); err != nil {
 		return err
 	}
 
-	f, err := c.dir.ConfigWriter()
+	src, err := source(open)
 	if err != nil {
 		return err
 	}
 
-	defer ioutil.CheckClose(f, &err)
+	b, err := marshal(src)
+	if err != nil {
+		return err
+	}
 
-	b, err := cfg.Marshal()
+	f, err := create()
 	if err != nil {
 		return err
 	}
 
+	defer ioutil.CheckClose(f, &err)
+
 	_, err = f.Write(b)
 	return err
 }
+
+// source returns the content of the config file being replaced, to keep its
+// comments and formatting.
+func source(open func() (billy.File, error)) (b []byte, err error) {
+	f, err := open()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return io.ReadAll(f)
+}
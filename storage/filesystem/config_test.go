@@ -43,6 +43,24 @@ func (s *ConfigSuite) TestRemotes(c *C) {
 	c.Assert(remote.Fetch, DeepEquals, []config.RefSpec{config.RefSpec("+refs/heads/*:refs/remotes/origin/*")})
 }
 
+func (s *ConfigSuite) TestSetConfigPreservesComments(c *C) {
+	content := "# repository settings\n[core]\n\tbare = false ; not bare\n\trepositoryformatversion = 0\n[custom]\n\tkey = value # unknown\n"
+	err := util.WriteFile(s.dir.Fs(), "config", []byte(content), 0o644)
+	c.Assert(err, IsNil)
+
+	storer := &ConfigStorage{s.dir}
+	cfg, err := storer.Config()
+	c.Assert(err, IsNil)
+
+	cfg.User.Name = "foo"
+	err = storer.SetConfig(cfg)
+	c.Assert(err, IsNil)
+
+	b, err := util.ReadFile(s.dir.Fs(), "config")
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, content+"[user]\n\tname = foo\n")
+}
+
 func (s *ConfigSuite) TearDownTest(c *C) {
 	defer os.RemoveAll(s.path)
 }
@@ -10,44 +10,54 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"reflect"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/hash"
-	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 
 	"github.com/go-git/go-billy/v5"
-	"github.com/go-git/go-billy/v5/helper/chroot"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
 )
 
 const (
-	suffix         = ".git"
-	packedRefsPath = "packed-refs"
-	configPath     = "config"
-	indexPath      = "index"
-	shallowPath    = "shallow"
-	modulePath     = "modules"
-	objectsPath    = "objects"
-	packPath       = "pack"
-	refsPath       = "refs"
-	branchesPath   = "branches"
-	hooksPath      = "hooks"
-	infoPath       = "info"
-	remotesPath    = "remotes"
-	logsPath       = "logs"
-	worktreesPath  = "worktrees"
-	alternatesPath = "alternates"
+	suffix             = ".git"
+	packedRefsPath     = "packed-refs"
+	configPath         = "config"
+	worktreeConfigPath = "config.worktree"
+	indexPath          = "index"
+	shallowPath        = "shallow"
+	fetchHeadPath      = "FETCH_HEAD"
+	modulePath         = "modules"
+	objectsPath        = "objects"
+	packPath           = "pack"
+	refsPath           = "refs"
+	branchesPath       = "branches"
+	hooksPath          = "hooks"
+	infoPath           = "info"
+	remotesPath        = "remotes"
+	logsPath           = "logs"
+	worktreesPath      = "worktrees"
+	alternatesPath     = "alternates"
+
+	multiPackIndexPath = "multi-pack-index"
+
+	// maxAlternatesDepth is the depth of the nested alternates followed, the
+	// deeper ones are ignored as git does.
+	maxAlternatesDepth = 5
 
 	tmpPackedRefsPrefix = "._packed-refs"
 
 	packPrefix = "pack-"
 	packExt    = ".pack"
 	idxExt     = ".idx"
+	keepExt    = ".keep"
 )
 
 var (
@@ -57,6 +67,12 @@ var (
 	ErrIdxNotFound = errors.New("idx file not found")
 	// ErrPackfileNotFound is returned by Packfile when the packfile is not found
 	ErrPackfileNotFound = errors.New("packfile not found")
+	// ErrMultiPackIndexNotFound is returned by MultiPackIndex when there is
+	// no multi-pack-index
+	ErrMultiPackIndexNotFound = errors.New("multi-pack-index not found")
+	// ErrPackBitmapNotFound is returned by ObjectPackBitmap when the packfile
+	// has no bitmap file
+	ErrPackBitmapNotFound = errors.New("pack bitmap not found")
 	// ErrConfigNotFound is returned by Config when the config is not found
 	ErrConfigNotFound = errors.New("config file not found")
 	// ErrPackedRefsDuplicatedRef is returned when a duplicated reference is
@@ -75,6 +91,10 @@ var (
 	// ErrEmptyRefFile is returned when a reference file is attempted to be read,
 	// but the file is empty
 	ErrEmptyRefFile = errors.New("ref file is empty")
+	// ErrLocked is returned when a lock file can not be created before the
+	// lock timeout, because another process is updating the same file or a
+	// stale lock file was left behind.
+	ErrLocked = errors.New("file is locked")
 )
 
 // Options holds configuration for the storage.
@@ -85,18 +105,38 @@ type Options struct {
 	// KeepDescriptors makes the file descriptors to be reused but they will
 	// need to be manually closed calling Close().
 	KeepDescriptors bool
+	// LargeObjectThreshold is the size, in bytes, above which the objects
+	// aren't held in memory while indexing the received packfiles. If left
+	// unset or set to 0 there is no limit.
+	LargeObjectThreshold int64
 	// AlternatesFS provides the billy filesystem to be used for Git Alternates.
 	// If none is provided, it falls back to using the underlying instance used for
-	// DotGit.
+	// DotGit, and for a DotGit on the OS filesystem, to the OS filesystem for
+	// the object directories outside of it.
 	AlternatesFS billy.Filesystem
+	// LockTimeout is how long to keep trying to create a lock file held by
+	// another process. If left unset or set to 0, DefaultLockTimeout is used.
+	LockTimeout time.Duration
 }
 
 // The DotGit type represents a local git repository on disk. This
 // type is not zero-value-safe, use the New function to initialize it.
+//
+// A DotGit is safe for concurrent use by multiple goroutines. The updates of
+// the references are serialized, and are also locked against other processes
+// with lock files, the same way git does.
 type DotGit struct {
 	options Options
 	fs      billy.Filesystem
 
+	// refsMu serializes the updates of the references, the readers of the
+	// references share it so they don't see a reference being rewritten.
+	refsMu sync.RWMutex
+
+	// mu guards the lazily built lists of objects and packfiles, the
+	// incoming object directory and the open packfiles.
+	mu sync.Mutex
+
 	// incoming object directory information
 	incomingChecked bool
 	incomingDirName string
@@ -154,6 +194,9 @@ func (d *DotGit) Initialize() error {
 
 // Close closes all opened files.
 func (d *DotGit) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	var firstError error
 	if d.files != nil {
 		for _, f := range d.files {
@@ -184,6 +227,18 @@ func (d *DotGit) Config() (billy.File, error) {
 	return d.fs.Open(configPath)
 }
 
+// WorktreeConfigWriter returns a file pointer for write to the
+// config.worktree file of the worktree
+func (d *DotGit) WorktreeConfigWriter() (billy.File, error) {
+	return d.fs.Create(worktreeConfigPath)
+}
+
+// WorktreeConfig returns a file pointer for read to the config.worktree file
+// of the worktree
+func (d *DotGit) WorktreeConfig() (billy.File, error) {
+	return d.fs.Open(worktreeConfigPath)
+}
+
 // IndexWriter returns a file pointer for write to the index file
 func (d *DotGit) IndexWriter() (billy.File, error) {
 	return d.fs.Create(indexPath)
@@ -213,11 +268,31 @@ func (d *DotGit) Shallow() (billy.File, error) {
 	return f, nil
 }
 
+// FetchHeadWriter returns a file pointer for write to the FETCH_HEAD file
+func (d *DotGit) FetchHeadWriter() (billy.File, error) {
+	return d.fs.Create(fetchHeadPath)
+}
+
+// FetchHead returns a file pointer for read to the FETCH_HEAD file, nil if it
+// doesn't exist
+func (d *DotGit) FetchHead() (billy.File, error) {
+	f, err := d.fs.Open(fetchHeadPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
 // NewObjectPack return a writer for a new packfile, it saves the packfile to
 // disk and also generates and save the index for the given packfile.
 func (d *DotGit) NewObjectPack() (*PackWriter, error) {
 	d.cleanPackList()
-	return newPackWrite(d.fs)
+	return newPackWrite(d.fs, d.fs.Join(objectsPath, packPath), d.options.LargeObjectThreshold)
 }
 
 // ObjectPacks returns the list of availables packfiles
@@ -226,6 +301,9 @@ func (d *DotGit) ObjectPacks() ([]plumbing.Hash, error) {
 		return d.objectPacks()
 	}
 
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	err := d.genPackList()
 	if err != nil {
 		return nil, err
@@ -235,8 +313,12 @@ func (d *DotGit) ObjectPacks() ([]plumbing.Hash, error) {
 }
 
 func (d *DotGit) objectPacks() ([]plumbing.Hash, error) {
-	packDir := d.fs.Join(objectsPath, packPath)
-	files, err := d.fs.ReadDir(packDir)
+	return listObjectPacks(d.fs, d.fs.Join(objectsPath, packPath))
+}
+
+// listObjectPacks returns the packfiles of the given directory.
+func listObjectPacks(fs billy.Filesystem, packDir string) ([]plumbing.Hash, error) {
+	files, err := fs.ReadDir(packDir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -268,12 +350,11 @@ func (d *DotGit) objectPackPath(hash plumbing.Hash, extension string) string {
 }
 
 func (d *DotGit) objectPackOpen(hash plumbing.Hash, extension string) (billy.File, error) {
-	if d.options.KeepDescriptors && extension == "pack" {
-		if d.files == nil {
-			d.files = make(map[plumbing.Hash]billy.File)
-		}
-
+	keep := d.options.KeepDescriptors && extension == "pack"
+	if keep {
+		d.mu.Lock()
 		f, ok := d.files[hash]
+		d.mu.Unlock()
 		if ok {
 			return f, nil
 		}
@@ -294,7 +375,19 @@ func (d *DotGit) objectPackOpen(hash plumbing.Hash, extension string) (billy.Fil
 		return nil, err
 	}
 
-	if d.options.KeepDescriptors && extension == "pack" {
+	if keep {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		// Another goroutine may have opened it meanwhile.
+		if f, ok := d.files[hash]; ok {
+			return f, pack.Close()
+		}
+
+		if d.files == nil {
+			d.files = make(map[plumbing.Hash]billy.File)
+		}
+
 		d.files[hash] = pack
 	}
 
@@ -321,9 +414,41 @@ func (d *DotGit) ObjectPackIdx(hash plumbing.Hash) (billy.File, error) {
 	return d.objectPackOpen(hash, `idx`)
 }
 
+// ObjectPackBitmap returns a fs.File of the bitmap file for a given packfile
+func (d *DotGit) ObjectPackBitmap(hash plumbing.Hash) (billy.File, error) {
+	err := d.hasPack(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := d.fs.Open(d.objectPackPath(hash, `bitmap`))
+	if os.IsNotExist(err) {
+		return nil, ErrPackBitmapNotFound
+	}
+
+	return f, err
+}
+
+// MultiPackIndex returns a fs.File of the multi-pack-index of the packfiles
+func (d *DotGit) MultiPackIndex() (billy.File, error) {
+	f, err := d.fs.Open(d.fs.Join(objectsPath, packPath, multiPackIndexPath))
+	if os.IsNotExist(err) {
+		return nil, ErrMultiPackIndexNotFound
+	}
+
+	return f, err
+}
+
+// DeleteOldObjectPackAndIndex deletes the given packfile and its index, if
+// the packfile was modified before t or t is zero. The packfiles with a .keep
+// file are not deleted.
 func (d *DotGit) DeleteOldObjectPackAndIndex(hash plumbing.Hash, t time.Time) error {
 	d.cleanPackList()
 
+	if kept, err := d.ObjectPackKept(hash); err != nil || kept {
+		return err
+	}
+
 	path := d.objectPackPath(hash, `pack`)
 	if !t.IsZero() {
 		fi, err := d.fs.Stat(path)
@@ -339,7 +464,42 @@ func (d *DotGit) DeleteOldObjectPackAndIndex(hash plumbing.Hash, t time.Time) er
 	if err != nil {
 		return err
 	}
-	return d.fs.Remove(d.objectPackPath(hash, `idx`))
+	err = d.fs.Remove(d.objectPackPath(hash, `idx`))
+	if err != nil {
+		return err
+	}
+	// A bitmap file is useless without its packfile.
+	err = d.fs.Remove(d.objectPackPath(hash, `bitmap`))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// KeepObjectPack writes the .keep file of the given packfile, with the given
+// reason, so it isn't deleted by a repack.
+func (d *DotGit) KeepObjectPack(hash plumbing.Hash, reason string) error {
+	return util.WriteFile(d.fs, d.objectPackPath(hash, "keep"), []byte(reason+"\n"), 0o644)
+}
+
+// UnkeepObjectPack removes the .keep file of the given packfile, if any.
+func (d *DotGit) UnkeepObjectPack(hash plumbing.Hash) error {
+	err := d.fs.Remove(d.objectPackPath(hash, "keep"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// ObjectPackKept returns whether the given packfile has a .keep file.
+func (d *DotGit) ObjectPackKept(hash plumbing.Hash) (bool, error) {
+	_, err := d.fs.Stat(d.objectPackPath(hash, "keep"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
 }
 
 // NewObject return a writer for a new object file.
@@ -359,6 +519,9 @@ func (d *DotGit) ObjectsWithPrefix(prefix []byte) ([]plumbing.Hash, error) {
 	}
 
 	if d.options.ExclusiveAccess {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
 		err := d.genObjectList()
 		if err != nil {
 			return nil, err
@@ -400,6 +563,9 @@ func (d *DotGit) ObjectsWithPrefix(prefix []byte) ([]plumbing.Hash, error) {
 // .git/objects/ directory.
 func (d *DotGit) Objects() ([]plumbing.Hash, error) {
 	if d.options.ExclusiveAccess {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
 		err := d.genObjectList()
 		if err != nil {
 			return nil, err
@@ -426,12 +592,15 @@ func (d *DotGit) ForEachObjectHash(fun func(plumbing.Hash) error) error {
 		return d.forEachObjectHash(fun)
 	}
 
+	d.mu.Lock()
 	err := d.genObjectList()
+	objects := d.objectList
+	d.mu.Unlock()
 	if err != nil {
 		return err
 	}
 
-	for _, h := range d.objectList {
+	for _, h := range objects {
 		err := fun(h)
 		if err != nil {
 			return err
@@ -477,10 +646,15 @@ func (d *DotGit) forEachObjectHash(fun func(plumbing.Hash) error) error {
 }
 
 func (d *DotGit) cleanObjectList() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	d.objectMap = nil
 	d.objectList = nil
 }
 
+// genObjectList builds the list of objects, if needed. It must be called with
+// d.mu held.
 func (d *DotGit) genObjectList() error {
 	if d.objectMap != nil {
 		return nil
@@ -505,6 +679,9 @@ func (d *DotGit) hasObject(h plumbing.Hash) error {
 		return nil
 	}
 
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	err := d.genObjectList()
 	if err != nil {
 		return err
@@ -519,10 +696,15 @@ func (d *DotGit) hasObject(h plumbing.Hash) error {
 }
 
 func (d *DotGit) cleanPackList() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	d.packMap = nil
 	d.packList = nil
 }
 
+// genPackList builds the list of packfiles, if needed. It must be called with
+// d.mu held.
 func (d *DotGit) genPackList() error {
 	if d.packMap != nil {
 		return nil
@@ -549,6 +731,9 @@ func (d *DotGit) hasPack(h plumbing.Hash) error {
 		return nil
 	}
 
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	err := d.genPackList()
 	if err != nil {
 		return err
@@ -589,6 +774,9 @@ func (d *DotGit) incomingObjectPath(h plumbing.Hash) string {
 // hasIncomingObjects searches for an incoming directory and keeps its name
 // so it doesn't have to be found each time an object is accessed.
 func (d *DotGit) hasIncomingObjects() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if !d.incomingChecked {
 		directoryContents, err := d.fs.ReadDir(objectsPath)
 		if err == nil {
@@ -668,56 +856,20 @@ func (d *DotGit) readReferenceFrom(rd io.Reader, name string) (ref *plumbing.Ref
 		return nil, ErrEmptyRefFile
 	}
 
-	line := strings.TrimSpace(string(b))
+	// Pseudo-references such as FETCH_HEAD may have several lines, their
+	// first hash being followed by a tab and a description.
+	line, _, _ := strings.Cut(string(b), "\n")
+	line, _, _ = strings.Cut(line, "\t")
+	line = strings.TrimSpace(line)
 	return plumbing.NewReferenceFromStrings(name, line), nil
 }
 
-// checkReferenceAndTruncate reads the reference from the given file, or the `pack-refs` file if
-// the file was empty. Then it checks that the old reference matches the stored reference and
-// truncates the file.
-func (d *DotGit) checkReferenceAndTruncate(f billy.File, old *plumbing.Reference) error {
-	if old == nil {
-		return nil
-	}
-
-	ref, err := d.readReferenceFrom(f, old.Name().String())
-	if errors.Is(err, ErrEmptyRefFile) {
-		// This may happen if the reference is being read from a newly created file.
-		// In that case, try getting the reference from the packed refs file.
-		ref, err = d.packedRef(old.Name())
-	}
-
-	if err != nil {
-		return err
-	}
-
-	if ref.Hash() != old.Hash() {
-		return storage.ErrReferenceHasChanged
-	}
-	_, err = f.Seek(0, io.SeekStart)
-	if err != nil {
-		return err
-	}
-	return f.Truncate(0)
-}
-
-func (d *DotGit) SetRef(r, old *plumbing.Reference) error {
-	var content string
-	switch r.Type() {
-	case plumbing.SymbolicReference:
-		content = fmt.Sprintf("ref: %s\n", r.Target())
-	case plumbing.HashReference:
-		content = fmt.Sprintln(r.Hash().String())
-	}
-
-	fileName := r.Name().String()
-
-	return d.setRef(fileName, content, old)
-}
-
 // Refs scans the git directory collecting references, which it returns.
 // Symbolic references are resolved and included in the output.
 func (d *DotGit) Refs() ([]*plumbing.Reference, error) {
+	d.refsMu.RLock()
+	defer d.refsMu.RUnlock()
+
 	var refs []*plumbing.Reference
 	seen := make(map[plumbing.ReferenceName]bool)
 	if err := d.addRefFromHEAD(&refs); err != nil {
@@ -737,6 +889,13 @@ func (d *DotGit) Refs() ([]*plumbing.Reference, error) {
 
 // Ref returns the reference for a given reference name.
 func (d *DotGit) Ref(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	d.refsMu.RLock()
+	defer d.refsMu.RUnlock()
+
+	return d.ref(name)
+}
+
+func (d *DotGit) ref(name plumbing.ReferenceName) (*plumbing.Reference, error) {
 	ref, err := d.readReferenceFile(".", name.String())
 	if err == nil {
 		return ref, nil
@@ -799,19 +958,28 @@ func (d *DotGit) packedRef(name plumbing.ReferenceName) (*plumbing.Reference, er
 }
 
 // RemoveRef removes a reference by name.
-func (d *DotGit) RemoveRef(name plumbing.ReferenceName) error {
-	path := d.fs.Join(".", name.String())
-	_, err := d.fs.Stat(path)
-	if err == nil {
-		err = d.fs.Remove(path)
-		// Drop down to remove it from the packed refs file, too.
+func (d *DotGit) RemoveRef(name plumbing.ReferenceName) (err error) {
+	d.refsMu.Lock()
+	defer d.refsMu.Unlock()
+
+	lock, err := d.lockFile(name.String())
+	if err != nil {
+		return err
 	}
+	defer ioutil.CheckClose(lock, &err)
 
+	// The reference is removed from the packed-refs file first, otherwise
+	// its packed value would be resurrected if that fails.
+	if err := d.rewritePackedRefsWithoutRef(name); err != nil {
+		return err
+	}
+
+	err = d.fs.Remove(d.fs.Join(".", name.String()))
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
-	return d.rewritePackedRefsWithoutRef(name)
+	return nil
 }
 
 func refsRecvFunc(refs *[]*plumbing.Reference, seen map[plumbing.ReferenceName]bool) refsRecv {
@@ -828,10 +996,6 @@ func (d *DotGit) addRefsFromPackedRefs(refs *[]*plumbing.Reference, seen map[plu
 	return d.findPackedRefs(refsRecvFunc(refs, seen))
 }
 
-func (d *DotGit) addRefsFromPackedRefsFile(refs *[]*plumbing.Reference, f billy.File, seen map[plumbing.ReferenceName]bool) (err error) {
-	return d.findPackedRefsInFile(f, refsRecvFunc(refs, seen))
-}
-
 func (d *DotGit) openAndLockPackedRefs(doCreate bool) (
 	pr billy.File, err error,
 ) {
@@ -888,7 +1052,20 @@ func (d *DotGit) openAndLockPackedRefs(doCreate bool) (
 	return f, nil
 }
 
-func (d *DotGit) rewritePackedRefsWithoutRef(name plumbing.ReferenceName) (err error) {
+// rewritePackedRefsWithoutRef removes the given references from the
+// packed-refs file, if they are there.
+func (d *DotGit) rewritePackedRefsWithoutRef(names ...plumbing.ReferenceName) (err error) {
+	remove := make(map[plumbing.ReferenceName]bool, len(names))
+	for _, name := range names {
+		remove[name] = true
+	}
+
+	lock, err := d.lockFile(packedRefsPath)
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(lock, &err)
+
 	pr, err := d.openAndLockPackedRefs(false)
 	if err != nil {
 		return err
@@ -911,15 +1088,21 @@ func (d *DotGit) rewritePackedRefsWithoutRef(name plumbing.ReferenceName) (err e
 	}()
 
 	s := bufio.NewScanner(pr)
-	found := false
+	found, removed := false, false
 	for s.Scan() {
 		line := s.Text()
+		if removed && strings.HasPrefix(line, "^") {
+			// the peeled value of the removed reference
+			continue
+		}
+
 		ref, err := d.processLine(line)
 		if err != nil {
 			return err
 		}
 
-		if ref != nil && ref.Name() == name {
+		removed = ref != nil && remove[ref.Name()]
+		if removed {
 			found = true
 			continue
 		}
@@ -977,6 +1160,11 @@ func (d *DotGit) walkReferencesTree(refs *[]*plumbing.Reference, relPath []strin
 	}
 
 	for _, f := range files {
+		if strings.HasSuffix(f.Name(), lockFileSuffix) {
+			// a reference being updated by someone else
+			continue
+		}
+
 		newRelPath := append(append([]string(nil), relPath...), f.Name())
 		if f.IsDir() {
 			if err = d.walkReferencesTree(refs, newRelPath, seen); err != nil {
@@ -1038,6 +1226,9 @@ func (d *DotGit) readReferenceFile(path, name string) (ref *plumbing.Reference,
 }
 
 func (d *DotGit) CountLooseRefs() (int, error) {
+	d.refsMu.RLock()
+	defer d.refsMu.RUnlock()
+
 	var refs []*plumbing.Reference
 	seen := make(map[plumbing.ReferenceName]bool)
 	if err := d.addRefsFromRefDir(&refs, seen); err != nil {
@@ -1047,84 +1238,10 @@ func (d *DotGit) CountLooseRefs() (int, error) {
 	return len(refs), nil
 }
 
-// PackRefs packs all loose refs into the packed-refs file.
-//
-// This implementation only works under the assumption that the view
-// of the file system won't be updated during this operation.  This
-// strategy would not work on a general file system though, without
-// locking each loose reference and checking it again before deleting
-// the file, because otherwise an updated reference could sneak in and
-// then be deleted by the packed-refs process.  Alternatively, every
-// ref update could also lock packed-refs, so only one lock is
-// required during ref-packing.  But that would worsen performance in
-// the common case.
-//
-// TODO: add an "all" boolean like the `git pack-refs --all` flag.
-// When `all` is false, it would only pack refs that have already been
-// packed, plus all tags.
-func (d *DotGit) PackRefs() (err error) {
-	// Lock packed-refs, and create it if it doesn't exist yet.
-	f, err := d.openAndLockPackedRefs(true)
-	if err != nil {
-		return err
-	}
-	defer ioutil.CheckClose(f, &err)
-
-	// Gather all refs using addRefsFromRefDir and addRefsFromPackedRefs.
-	var refs []*plumbing.Reference
-	seen := make(map[plumbing.ReferenceName]bool)
-	if err = d.addRefsFromRefDir(&refs, seen); err != nil {
-		return err
-	}
-	if len(refs) == 0 {
-		// Nothing to do!
-		return nil
-	}
-	numLooseRefs := len(refs)
-	if err = d.addRefsFromPackedRefsFile(&refs, f, seen); err != nil {
-		return err
-	}
-
-	// Write them all to a new temp packed-refs file.
-	tmp, err := d.fs.TempFile("", tmpPackedRefsPrefix)
-	if err != nil {
-		return err
-	}
-	tmpName := tmp.Name()
-	defer func() {
-		ioutil.CheckClose(tmp, &err)
-		_ = d.fs.Remove(tmpName) // don't check err, we might have renamed it
-	}()
-
-	w := bufio.NewWriter(tmp)
-	for _, ref := range refs {
-		_, err = w.WriteString(ref.String() + "\n")
-		if err != nil {
-			return err
-		}
-	}
-	err = w.Flush()
-	if err != nil {
-		return err
-	}
-
-	// Rename the temp packed-refs file.
-	err = d.rewritePackedRefsWhileLocked(tmp, f)
-	if err != nil {
-		return err
-	}
-
-	// Delete all the loose refs, while still holding the packed-refs
-	// lock.
-	for _, ref := range refs[:numLooseRefs] {
-		path := d.fs.Join(".", ref.Name().String())
-		err = d.fs.Remove(path)
-		if err != nil && !os.IsNotExist(err) {
-			return err
-		}
-	}
-
-	return nil
+// PackRefs packs all loose refs into the packed-refs file, and removes
+// them, as PackRefsWithOptions does with All and Prune.
+func (d *DotGit) PackRefs() error {
+	return d.PackRefsWithOptions(PackRefsOptions{All: true, Prune: true})
 }
 
 // Module return a billy.Filesystem pointing to the module folder
@@ -1160,72 +1277,171 @@ func (d *DotGit) AddAlternate(remote string) error {
 	return nil
 }
 
+// RemoveAlternates removes the objects/info/alternates file, so the
+// repository stops borrowing the objects of other repositories.
+func (d *DotGit) RemoveAlternates() error {
+	err := d.fs.Remove(d.fs.Join(objectsPath, infoPath, alternatesPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
 // Alternates returns DotGit(s) based off paths in objects/info/alternates if
 // available. This can be used to checks if it's a shared repository.
+//
+// As git does, blank lines and lines starting with # are ignored, the paths
+// can be quoted, relative paths are relative to the objects directory, and
+// the alternates of the alternates are followed, up to maxAlternatesDepth.
 func (d *DotGit) Alternates() ([]*DotGit, error) {
-	altpath := d.fs.Join(objectsPath, infoPath, alternatesPath)
-	f, err := d.fs.Open(altpath)
+	seen := map[string]struct{}{
+		filepath.Join(d.fs.Root(), objectsPath): {},
+	}
+
+	return d.alternates(seen, 0)
+}
+
+func (d *DotGit) alternates(seen map[string]struct{}, depth int) ([]*DotGit, error) {
+	paths, err := d.readAlternates()
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	fs := d.options.AlternatesFS
-	if fs == nil {
-		fs = d.fs
+	var alternates []*DotGit
+	for _, p := range paths {
+		alt, abs, err := d.alternate(p)
+		if err != nil {
+			return nil, err
+		}
+
+		// Avoid creating multiple dotgits for the same alternative path,
+		// and the cycles between alternates.
+		if _, ok := seen[abs]; ok {
+			continue
+		}
+
+		seen[abs] = struct{}{}
+		alternates = append(alternates, alt)
+
+		if depth >= maxAlternatesDepth {
+			continue
+		}
+
+		nested, err := alt.alternates(seen, depth+1)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		alternates = append(alternates, nested...)
 	}
 
-	var alternates []*DotGit
-	seen := make(map[string]struct{})
+	return alternates, nil
+}
+
+// readAlternates returns the paths in the objects/info/alternates file.
+func (d *DotGit) readAlternates() ([]string, error) {
+	f, err := d.fs.Open(d.fs.Join(objectsPath, infoPath, alternatesPath))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-	// Read alternate paths line-by-line and create DotGit objects.
+	var paths []string
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
-		path := scanner.Text()
-
-		// Avoid creating multiple dotgits for the same alternative path.
-		if _, ok := seen[path]; ok {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
 			continue
 		}
 
-		seen[path] = struct{}{}
-
-		if filepath.IsAbs(path) {
-			// Handling absolute paths should be straight-forward. However, the default osfs (Chroot)
-			// tries to concatenate an abs path with the root path in some operations (e.g. Stat),
-			// which leads to unexpected errors. Therefore, make the path relative to the current FS instead.
-			if reflect.TypeOf(fs) == reflect.TypeOf(&chroot.ChrootHelper{}) {
-				path, err = filepath.Rel(fs.Root(), path)
-				if err != nil {
-					return nil, fmt.Errorf("cannot make path %q relative: %w", path, err)
-				}
+		if line[0] == '"' {
+			if unquoted, err := strconv.Unquote(line); err == nil {
+				line = unquoted
 			}
-		} else {
-			// By Git conventions, relative paths should be based on the object database (.git/objects/info)
-			// location as per: https://www.kernel.org/pub/software/scm/git/docs/gitrepository-layout.html
-			// However, due to the nature of go-git and its filesystem handling via Billy, paths cannot
-			// cross its "chroot boundaries". Therefore, ignore any "../" and treat the path from the
-			// fs root. If this is not correct based on the dotgit fs, set a different one via AlternatesFS.
-			abs := filepath.Join(string(filepath.Separator), filepath.ToSlash(path))
-			path = filepath.FromSlash(abs)
 		}
 
-		// Aligns with upstream behavior: exit if target path is not a valid directory.
-		if fi, err := fs.Stat(path); err != nil || !fi.IsDir() {
-			return nil, fmt.Errorf("invalid object directory %q: %w", path, err)
-		}
-		afs, err := fs.Chroot(filepath.Dir(path))
-		if err != nil {
-			return nil, fmt.Errorf("cannot chroot %q: %w", path, err)
-		}
-		alternates = append(alternates, New(afs))
+		paths = append(paths, line)
 	}
 
 	if err = scanner.Err(); err != nil {
 		return nil, err
 	}
 
-	return alternates, nil
+	return paths, nil
+}
+
+// alternate returns the DotGit of the object directory at the given path of
+// the alternates file, and the absolute path of the object directory.
+func (d *DotGit) alternate(p string) (*DotGit, string, error) {
+	fs := d.options.AlternatesFS
+	if fs == nil {
+		fs = d.fs
+	}
+
+	// By Git conventions, relative paths are based on the object database
+	// location as per: https://www.kernel.org/pub/software/scm/git/docs/gitrepository-layout.html
+	abs := filepath.Clean(p)
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(d.fs.Root(), objectsPath, abs)
+	}
+
+	// The paths are made relative to the alternates FS, as the default osfs
+	// (Chroot) tries to concatenate an abs path with the root path in some
+	// operations (e.g. Stat), which leads to unexpected errors.
+	rel, err := filepath.Rel(fs.Root(), abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// The paths cannot cross the "chroot boundaries" of the alternates
+		// FS, but when it's a directory of the OS and no other one was set,
+		// the object directory can be opened on its own.
+		if d.options.AlternatesFS != nil || !isOSFilesystem(fs) {
+			return nil, "", fmt.Errorf("invalid object directory %q: %w", p, billy.ErrCrossedBoundary)
+		}
+
+		var opts []osfs.Option
+		if _, ok := fs.(*osfs.BoundOS); ok {
+			opts = append(opts, osfs.WithBoundOS())
+		}
+
+		fs = osfs.New(filepath.Dir(abs), opts...)
+		rel = filepath.Base(abs)
+	}
+
+	// Aligns with upstream behavior: exit if target path is not a valid directory.
+	if fi, err := fs.Stat(rel); err != nil || !fi.IsDir() {
+		return nil, "", fmt.Errorf("invalid object directory %q: %w", p, err)
+	}
+
+	afs, err := fs.Chroot(filepath.Dir(rel))
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot chroot %q: %w", p, err)
+	}
+
+	return NewWithOptions(afs, Options{AlternatesFS: d.options.AlternatesFS}), abs, nil
+}
+
+// isOSFilesystem returns whether the given filesystem is a directory of the
+// OS filesystem.
+func isOSFilesystem(fs billy.Filesystem) bool {
+	if _, ok := fs.(*osfs.BoundOS); ok {
+		return true
+	}
+
+	type underlying interface {
+		Underlying() billy.Basic
+	}
+
+	var basic billy.Basic = fs
+	for {
+		switch b := basic.(type) {
+		case *osfs.ChrootOS:
+			return true
+		case underlying:
+			basic = b.Underlying()
+		default:
+			return false
+		}
+	}
 }
 
 // Fs returns the underlying filesystem of the DotGit folder.
@@ -0,0 +1,121 @@
+package dotgit
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+const (
+	// DefaultLockTimeout is how long a lock file held by another process
+	// is waited for by default, the same as git's core.packedRefsTimeout.
+	DefaultLockTimeout = time.Second
+
+	lockFileSuffix = ".lock"
+	maxLockBackoff = 100 * time.Millisecond
+)
+
+// lockFile is a <name>.lock file, created exclusively to lock the file
+// <name>. The new content of the file is written to the lock file, which is
+// then renamed over the file. Closing it removes it, releasing the lock.
+type lockFile struct {
+	fs      billy.Filesystem
+	f       billy.File
+	target  string
+	name    string
+	content []byte
+	renamed bool
+}
+
+// lockFile creates the lock file of the given file, waiting for up to the
+// lock timeout if it already exists. ErrLocked is returned when the lock file
+// still exists after the timeout.
+func (d *DotGit) lockFile(name string) (*lockFile, error) {
+	timeout := d.options.LockTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	lockName := name + lockFileSuffix
+	deadline := time.Now().Add(timeout)
+	backoff := time.Millisecond
+	for {
+		f, err := d.fs.OpenFile(lockName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			return &lockFile{fs: d.fs, f: f, target: name, name: lockName}, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, lockName)
+		}
+
+		time.Sleep(backoff)
+		if backoff < maxLockBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// write writes the new content of the locked file to the lock file.
+func (l *lockFile) write(content []byte) error {
+	if _, err := l.f.Write(content); err != nil {
+		return err
+	}
+
+	l.content = content
+	f := l.f
+	l.f = nil
+	return f.Close()
+}
+
+// commit renames the lock file, with the content given to write, over the
+// locked file. The lock is released once it is closed.
+func (l *lockFile) commit() error {
+	err := l.fs.Rename(l.name, l.target)
+	if err == billy.ErrNotSupported {
+		// If the filesystem does not support rename (e.g. sivafs) the
+		// file is written in place.
+		return writeFile(l.fs, l.target, l.content)
+	}
+
+	if err == nil {
+		l.renamed = true
+	}
+
+	return err
+}
+
+// Close removes the lock file, unless it was renamed over the locked file.
+func (l *lockFile) Close() error {
+	if l.f != nil {
+		if err := l.f.Close(); err != nil {
+			return err
+		}
+	}
+
+	if l.renamed {
+		return nil
+	}
+
+	return l.fs.Remove(l.name)
+}
+
+func writeFile(fs billy.Filesystem, name string, content []byte) (err error) {
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	_, err = f.Write(content)
+	return err
+}
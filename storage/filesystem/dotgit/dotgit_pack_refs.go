@@ -0,0 +1,237 @@
+package dotgit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+const (
+	packedRefsHeader       = "# pack-refs with: sorted \n"
+	packedRefsPeeledHeader = "# pack-refs with: peeled fully-peeled sorted \n"
+)
+
+// PackRefsOptions describes how the references are packed.
+type PackRefsOptions struct {
+	// All packs all the references, otherwise only the tags and the
+	// references already packed are, as `git pack-refs` does.
+	All bool
+	// Prune removes the loose references once packed.
+	Prune bool
+	// Peel, if set, returns the object pointed to by an annotated tag,
+	// following the nested tags, or plumbing.ZeroHash if the object isn't
+	// a tag. The peeled objects are written to the packed-refs file.
+	Peel func(plumbing.Hash) (plumbing.Hash, error)
+}
+
+type packedRef struct {
+	ref    *plumbing.Reference
+	peeled plumbing.Hash
+}
+
+// PackRefsWithOptions packs the loose references into the packed-refs file.
+// Symbolic references are never packed.
+//
+// The packed-refs file is locked while it is rewritten, and so is every loose
+// reference while it is pruned, which is kept if it was updated meanwhile.
+func (d *DotGit) PackRefsWithOptions(o PackRefsOptions) (err error) {
+	d.refsMu.Lock()
+	defer d.refsMu.Unlock()
+
+	lock, err := d.lockFile(packedRefsPath)
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(lock, &err)
+
+	// Lock packed-refs, and create it if it doesn't exist yet.
+	f, err := d.openAndLockPackedRefs(true)
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(f, &err)
+
+	packed, err := d.readPackedRefs(f)
+	if err != nil {
+		return err
+	}
+
+	var loose []*plumbing.Reference
+	seen := make(map[plumbing.ReferenceName]bool)
+	if err = d.addRefsFromRefDir(&loose, seen); err != nil {
+		return err
+	}
+
+	var toPack []*plumbing.Reference
+	for _, ref := range loose {
+		if ref.Type() != plumbing.HashReference {
+			continue
+		}
+
+		_, isPacked := packed[ref.Name()]
+		if o.All || isPacked || ref.Name().IsTag() {
+			toPack = append(toPack, ref)
+		}
+	}
+
+	if len(toPack) == 0 {
+		// Nothing to do!
+		return nil
+	}
+
+	for _, ref := range toPack {
+		p := &packedRef{ref: ref}
+		if old, ok := packed[ref.Name()]; ok && old.ref.Hash() == ref.Hash() {
+			p.peeled = old.peeled
+		} else if o.Peel != nil {
+			if p.peeled, err = o.Peel(ref.Hash()); err != nil {
+				return err
+			}
+		}
+
+		packed[ref.Name()] = p
+	}
+
+	if err = d.writePackedRefs(f, packed, o.Peel != nil); err != nil {
+		return err
+	}
+
+	if !o.Prune {
+		return nil
+	}
+
+	// Delete the packed loose refs, while still holding the packed-refs
+	// lock.
+	for _, ref := range toPack {
+		if err = d.pruneLooseRef(ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readPackedRefs reads the references of the packed-refs file, with their
+// peeled values.
+func (d *DotGit) readPackedRefs(f io.Reader) (map[plumbing.ReferenceName]*packedRef, error) {
+	packed := make(map[plumbing.ReferenceName]*packedRef)
+
+	var last *packedRef
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "^") {
+			if last == nil {
+				return nil, ErrPackedRefsBadFormat
+			}
+
+			last.peeled = plumbing.NewHash(line[1:])
+			continue
+		}
+
+		ref, err := d.processLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		if ref == nil {
+			continue
+		}
+
+		last = &packedRef{ref: ref}
+		packed[ref.Name()] = last
+	}
+
+	return packed, s.Err()
+}
+
+// writePackedRefs writes the references, sorted, to a temporary file which
+// is then renamed over the locked packed-refs file.
+func (d *DotGit) writePackedRefs(pr billy.File, packed map[plumbing.ReferenceName]*packedRef, peeled bool) (err error) {
+	refs := make([]*packedRef, 0, len(packed))
+	for _, p := range packed {
+		refs = append(refs, p)
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].ref.Name() < refs[j].ref.Name()
+	})
+
+	// Write them all to a new temp packed-refs file.
+	tmp, err := d.fs.TempFile("", tmpPackedRefsPrefix)
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		ioutil.CheckClose(tmp, &err)
+		_ = d.fs.Remove(tmpName) // don't check err, we might have renamed it
+	}()
+
+	header := packedRefsHeader
+	if peeled {
+		header = packedRefsPeeledHeader
+	}
+
+	w := bufio.NewWriter(tmp)
+	if _, err = w.WriteString(header); err != nil {
+		return err
+	}
+
+	for _, p := range refs {
+		if _, err = fmt.Fprintf(w, "%s %s\n", p.ref.Hash(), p.ref.Name()); err != nil {
+			return err
+		}
+
+		if p.peeled.IsZero() {
+			continue
+		}
+
+		if _, err = fmt.Fprintf(w, "^%s\n", p.peeled); err != nil {
+			return err
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	// Rename the temp packed-refs file.
+	return d.rewritePackedRefsWhileLocked(tmp, pr)
+}
+
+// pruneLooseRef removes the loose reference, if it is still the given one.
+// References locked by another process are kept.
+func (d *DotGit) pruneLooseRef(ref *plumbing.Reference) (err error) {
+	lock, err := d.lockFile(ref.Name().String())
+	if errors.Is(err, ErrLocked) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(lock, &err)
+
+	current, err := d.readReferenceFile(".", ref.Name().String())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.Hash() != ref.Hash() {
+		return nil
+	}
+
+	return d.fs.Remove(d.fs.Join(".", ref.Name().String()))
+}
@@ -0,0 +1,109 @@
+package dotgit
+
+import (
+	"bytes"
+	"os"
+	"path"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/reflog"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// Reflog returns the entries of the reflog of the given reference in the
+// logs directory, oldest first, or none if it has no reflog.
+func (d *DotGit) Reflog(name plumbing.ReferenceName) (entries []*reflog.Entry, err error) {
+	f, err := d.fs.Open(d.fs.Join(logsPath, name.String()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer ioutil.CheckClose(f, &err)
+
+	return reflog.Decode(f)
+}
+
+// SetReflog replaces the reflog of the given reference in the logs
+// directory with the given entries, oldest first. The new reflog is written
+// to a lock file renamed over the old one, as git does.
+func (d *DotGit) SetReflog(name plumbing.ReferenceName, entries []*reflog.Entry) error {
+	var buf bytes.Buffer
+	if err := reflog.Encode(&buf, entries); err != nil {
+		return err
+	}
+
+	return d.writeReflog(name, buf.Bytes())
+}
+
+func (d *DotGit) writeReflog(name plumbing.ReferenceName, data []byte) (err error) {
+	file := path.Join(logsPath, name.String())
+	if err := d.fs.MkdirAll(path.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	lock, err := d.lockFile(file)
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(lock, &err)
+
+	if err := lock.write(data); err != nil {
+		return err
+	}
+
+	return lock.commit()
+}
+
+// RenameReflog moves the reflog of the reference oldName in the logs
+// directory to newName, replacing the one of newName. The reflog is read
+// and removed before being written again, so a reference can be moved into
+// a directory of its own name, such as refs/heads/a to refs/heads/a/b.
+func (d *DotGit) RenameReflog(oldName, newName plumbing.ReferenceName) error {
+	src := path.Join(logsPath, oldName.String())
+	data, err := util.ReadFile(d.fs, src)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	exists := err == nil
+	if err := d.RemoveReflog(oldName); err != nil {
+		return err
+	}
+
+	if err := d.RemoveReflog(newName); err != nil {
+		return err
+	}
+
+	if !exists {
+		return nil
+	}
+
+	return d.writeReflog(newName, data)
+}
+
+// RemoveReflog removes the reflog of the given reference from the logs
+// directory, and the directories it leaves empty, as git does.
+func (d *DotGit) RemoveReflog(name plumbing.ReferenceName) error {
+	file := path.Join(logsPath, name.String())
+	if err := d.fs.Remove(file); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	stop := path.Join(logsPath, refsPath)
+	for dir := path.Dir(file); dir != stop && dir != logsPath && dir != "."; dir = path.Dir(dir) {
+		if d.fs.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
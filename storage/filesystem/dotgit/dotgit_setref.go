@@ -3,88 +3,210 @@ package dotgit
 import (
 	"fmt"
 	"os"
+	"sort"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 
-	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
 )
 
-func (d *DotGit) setRef(fileName, content string, old *plumbing.Reference) (err error) {
-	if billy.CapabilityCheck(d.fs, billy.ReadAndWriteCapability) {
-		return d.setRefRwfs(fileName, content, old)
+// SetRef stores the reference, if old is not nil it is only stored if the
+// current reference matches old. The reference is locked with a lock file,
+// the new value is written to it and then renamed over the reference.
+func (d *DotGit) SetRef(r, old *plumbing.Reference) (err error) {
+	d.refsMu.Lock()
+	defer d.refsMu.Unlock()
+
+	lock, err := d.lockFile(r.Name().String())
+	if err != nil {
+		return err
 	}
+	defer ioutil.CheckClose(lock, &err)
 
-	return d.setRefNorwfs(fileName, content, old)
-}
+	if old != nil {
+		ref, err := d.ref(old.Name())
+		if err != nil {
+			return err
+		}
 
-func (d *DotGit) setRefRwfs(fileName, content string, old *plumbing.Reference) (err error) {
-	// If we are not checking an old ref, just truncate the file.
-	mode := os.O_RDWR | os.O_CREATE
-	if old == nil {
-		mode |= os.O_TRUNC
+		if ref.Hash() != old.Hash() {
+			return storage.ErrReferenceHasChanged
+		}
 	}
 
-	f, err := d.fs.OpenFile(fileName, mode, 0666)
-	if err != nil {
+	if err := lock.write(refContent(r)); err != nil {
 		return err
 	}
 
-	defer ioutil.CheckClose(f, &err)
+	return lock.commit()
+}
 
-	// Lock is unlocked by the deferred Close above. This is because Unlock
-	// does not imply a fsync and thus there would be a race between
-	// Unlock+Close and other concurrent writers. Adding Sync to go-billy
-	// could work, but this is better (and avoids superfluous syncs).
-	err = f.Lock()
-	if err != nil {
-		return err
+func refContent(r *plumbing.Reference) []byte {
+	switch r.Type() {
+	case plumbing.SymbolicReference:
+		return []byte(fmt.Sprintf("ref: %s\n", r.Target()))
+	case plumbing.HashReference:
+		return []byte(fmt.Sprintln(r.Hash().String()))
+	}
+
+	return nil
+}
+
+// UpdateRefs applies the given updates all at once. Every reference is locked
+// with a lock file and its current value is checked before any of them is
+// updated, when some of them can't be applied none is, and a
+// *storer.ReferenceTransactionError is returned.
+func (d *DotGit) UpdateRefs(updates []storer.ReferenceUpdate) (err error) {
+	d.refsMu.Lock()
+	defer d.refsMu.Unlock()
+
+	// The references are locked in order, so two transactions don't wait
+	// for each other.
+	updates = append([]storer.ReferenceUpdate(nil), updates...)
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].Name < updates[j].Name
+	})
+
+	locks := make([]*lockFile, len(updates))
+	defer func() {
+		for _, lock := range locks {
+			if lock != nil {
+				ioutil.CheckClose(lock, &err)
+			}
+		}
+	}()
+
+	errs := make(map[plumbing.ReferenceName]error)
+	for i, u := range updates {
+		lock, err := d.lockFile(u.Name.String())
+		if err != nil {
+			errs[u.Name] = err
+			continue
+		}
+
+		locks[i] = lock
+		if err := d.checkRefUpdate(u); err != nil {
+			errs[u.Name] = err
+			continue
+		}
+
+		if !u.New.IsZero() {
+			content := refContent(plumbing.NewHashReference(u.Name, u.New))
+			if err := lock.write(content); err != nil {
+				errs[u.Name] = err
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		return &storer.ReferenceTransactionError{Errors: errs}
+	}
+
+	return d.commitRefUpdates(updates, locks)
+}
+
+func (d *DotGit) checkRefUpdate(u storer.ReferenceUpdate) error {
+	ref, err := d.ref(u.Name)
+	if err == plumbing.ErrReferenceNotFound {
+		ref, err = nil, nil
 	}
 
-	// this is a no-op to call even when old is nil.
-	err = d.checkReferenceAndTruncate(f, old)
 	if err != nil {
 		return err
 	}
 
-	_, err = f.Write([]byte(content))
-	return err
+	if !u.Matches(ref) {
+		return storage.ErrReferenceHasChanged
+	}
+
+	return nil
 }
 
-// There are some filesystems that don't support opening files in RDWD mode.
-// In these filesystems the standard SetRef function can not be used as it
-// reads the reference file to check that it's not modified before updating it.
-//
-// This version of the function writes the reference without extra checks
-// making it compatible with these simple filesystems. This is usually not
-// a problem as they should be accessed by only one process at a time.
-func (d *DotGit) setRefNorwfs(fileName, content string, old *plumbing.Reference) error {
-	_, err := d.fs.Stat(fileName)
-	if err == nil && old != nil {
-		fRead, err := d.fs.Open(fileName)
-		if err != nil {
-			return err
+// commitRefUpdates renames the lock files of the updated references over
+// them, and then removes the deleted ones. If the references can't be
+// updated, or removed from the packed-refs file, the ones already updated
+// are restored.
+func (d *DotGit) commitRefUpdates(updates []storer.ReferenceUpdate, locks []*lockFile) error {
+	var (
+		deleted  []plumbing.ReferenceName
+		restores []func() error
+		errs     = make(map[plumbing.ReferenceName]error)
+	)
+
+	rollback := func() error {
+		for _, restore := range restores {
+			_ = restore()
 		}
 
-		ref, err := d.readReferenceFrom(fRead, old.Name().String())
-		fRead.Close()
+		return &storer.ReferenceTransactionError{Errors: errs}
+	}
+
+	for i, u := range updates {
+		if u.New.IsZero() {
+			deleted = append(deleted, u.Name)
+			continue
+		}
+
+		restore, err := d.looseRefRestorer(u.Name)
+		if err == nil {
+			err = locks[i].commit()
+		}
 
 		if err != nil {
-			return err
+			errs[u.Name] = err
+			return rollback()
 		}
 
-		if ref.Hash() != old.Hash() {
-			return fmt.Errorf("reference has changed concurrently")
+		restores = append(restores, restore)
+	}
+
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	if err := d.rewritePackedRefsWithoutRef(deleted...); err != nil {
+		for _, name := range deleted {
+			errs[name] = err
 		}
+
+		return rollback()
 	}
 
-	f, err := d.fs.Create(fileName)
-	if err != nil {
-		return err
+	for _, name := range deleted {
+		err := d.fs.Remove(d.fs.Join(".", name.String()))
+		if err != nil && !os.IsNotExist(err) {
+			errs[name] = err
+		}
+	}
+
+	if len(errs) != 0 {
+		// The packed-refs file was already rewritten, the deletions can't
+		// be rolled back.
+		return &storer.ReferenceTransactionError{Errors: errs}
 	}
 
-	defer f.Close()
+	return nil
+}
+
+// looseRefRestorer returns a function restoring the current content of the
+// loose reference, or removing it if there is none.
+func (d *DotGit) looseRefRestorer(name plumbing.ReferenceName) (func() error, error) {
+	path := d.fs.Join(".", name.String())
+	content, err := util.ReadFile(d.fs, path)
+	if os.IsNotExist(err) {
+		return func() error {
+			return d.fs.Remove(path)
+		}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
 
-	_, err = f.Write([]byte(content))
-	return err
+	return func() error {
+		return writeFile(d.fs, path, content)
+	}, nil
 }
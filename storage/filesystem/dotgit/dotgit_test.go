@@ -3,19 +3,26 @@ package dotgit
 import (
 	"bufio"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-billy/v5/util"
 	fixtures "github.com/go-git/go-git-fixtures/v4"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/storage"
 	"github.com/stretchr/testify/assert"
 	. "gopkg.in/check.v1"
@@ -170,6 +177,81 @@ func testSetRefs(c *C, dir *DotGit) {
 	c.Assert(err, NotNil)
 }
 
+func (s *SuiteDotGit) TestReflog(c *C) {
+	fs := memfs.New()
+	dir := New(fs)
+
+	err := util.WriteFile(fs, "logs/refs/heads/master", []byte(
+		"0000000000000000000000000000000000000000 b029517f6300c2da0f4b651b8642506cd6aaf45d John Doe <john@example.com> 1427806800 +0200\tbranch: Created from HEAD\n"+
+			"b029517f6300c2da0f4b651b8642506cd6aaf45d 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 John Doe <john@example.com> 1427806900 +0200\tcommit: foo\n",
+	), 0o644)
+	c.Assert(err, IsNil)
+
+	entries, err := dir.Reflog("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[1].New, Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(entries[1].Message, Equals, "commit: foo")
+
+	entries, err = dir.Reflog("refs/heads/foo")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func (s *SuiteDotGit) TestSetReflog(c *C) {
+	fs := memfs.New()
+	dir := New(fs)
+
+	err := util.WriteFile(fs, "logs/refs/heads/master", []byte(
+		"0000000000000000000000000000000000000000 b029517f6300c2da0f4b651b8642506cd6aaf45d John Doe <john@example.com> 1427806800 +0200\tbranch: Created from HEAD\n"+
+			"b029517f6300c2da0f4b651b8642506cd6aaf45d 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 John Doe <john@example.com> 1427806900 +0200\tcommit: foo\n",
+	), 0o644)
+	c.Assert(err, IsNil)
+
+	entries, err := dir.Reflog("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(dir.SetReflog("refs/heads/master", entries[1:]), IsNil)
+
+	content, err := util.ReadFile(fs, "logs/refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "b029517f6300c2da0f4b651b8642506cd6aaf45d 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 John Doe <john@example.com> 1427806900 +0200\tcommit: foo\n")
+
+	// The lock file is renamed over the reflog.
+	_, err = fs.Stat("logs/refs/heads/master.lock")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(dir.SetReflog("refs/heads/foo/bar", entries), IsNil)
+	entries, err = dir.Reflog("refs/heads/foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+}
+
+func (s *SuiteDotGit) TestRenameReflog(c *C) {
+	fs := memfs.New()
+	dir := New(fs)
+
+	content := []byte("0000000000000000000000000000000000000000 b029517f6300c2da0f4b651b8642506cd6aaf45d John Doe <john@example.com> 1427806800 +0200\tbranch: Created from HEAD\n")
+	c.Assert(util.WriteFile(fs, "logs/refs/heads/foo", content, 0o644), IsNil)
+
+	// The reflog is moved into a directory of its own name, and back.
+	c.Assert(dir.RenameReflog("refs/heads/foo", "refs/heads/foo/bar"), IsNil)
+	data, err := util.ReadFile(fs, "logs/refs/heads/foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, content)
+
+	c.Assert(dir.RenameReflog("refs/heads/foo/bar", "refs/heads/foo"), IsNil)
+	data, err = util.ReadFile(fs, "logs/refs/heads/foo")
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, content)
+
+	// Renaming a reference without reflog removes the one of the new name.
+	c.Assert(dir.RenameReflog("refs/heads/missing", "refs/heads/foo"), IsNil)
+	_, err = fs.Stat("logs/refs/heads/foo")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(dir.RemoveReflog("refs/heads/missing"), IsNil)
+}
+
 func (s *SuiteDotGit) TestRefsFromPackedRefs(c *C) {
 	fs := fixtures.Basic().ByTag(".git").One().DotGit()
 	dir := New(fs)
@@ -808,6 +890,164 @@ func (s *SuiteDotGit) TestPackRefs(c *C) {
 	c.Assert(ref.Hash().String(), Equals, "b8d3ffab552895c19b9fcf7aa264d277cde33881")
 }
 
+func (s *SuiteDotGit) TestPackRefsSymbolic(c *C) {
+	fs := s.TemporalFilesystem(c)
+
+	dir := New(fs)
+
+	err := dir.SetRef(plumbing.NewReferenceFromStrings(
+		"refs/heads/foo",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	), nil)
+	c.Assert(err, IsNil)
+	err = dir.SetRef(plumbing.NewSymbolicReference(
+		"refs/remotes/origin/HEAD",
+		"refs/heads/foo",
+	), nil)
+	c.Assert(err, IsNil)
+
+	err = dir.PackRefs()
+	c.Assert(err, IsNil)
+
+	// The symbolic ref is kept as a loose ref.
+	looseCount, err := dir.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(looseCount, Equals, 1)
+
+	refs, err := dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 2)
+
+	ref, err := dir.Ref("refs/remotes/origin/HEAD")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Type(), Equals, plumbing.SymbolicReference)
+	c.Assert(ref.Target().String(), Equals, "refs/heads/foo")
+}
+
+func (s *SuiteDotGit) TestPackRefsWithOptions(c *C) {
+	fs := s.TemporalFilesystem(c)
+
+	dir := New(fs)
+
+	for _, ref := range []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+		plumbing.NewReferenceFromStrings("refs/tags/v1.0.0", "a8d3ffab552895c19b9fcf7aa264d277cde33881"),
+	} {
+		err := dir.SetRef(ref, nil)
+		c.Assert(err, IsNil)
+	}
+
+	// Only the tags are packed, and the loose refs are kept.
+	err := dir.PackRefsWithOptions(PackRefsOptions{})
+	c.Assert(err, IsNil)
+
+	looseCount, err := dir.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(looseCount, Equals, 2)
+
+	content, err := util.ReadFile(fs, packedRefsPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, ""+
+		"# pack-refs with: sorted \n"+
+		"a8d3ffab552895c19b9fcf7aa264d277cde33881 refs/tags/v1.0.0\n")
+
+	// The tags are pruned once packed, the branch isn't packed yet.
+	err = dir.PackRefsWithOptions(PackRefsOptions{Prune: true})
+	c.Assert(err, IsNil)
+
+	looseCount, err = dir.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(looseCount, Equals, 1)
+
+	_, err = fs.Stat("refs/heads/foo")
+	c.Assert(err, IsNil)
+
+	refs, err := dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 2)
+}
+
+func (s *SuiteDotGit) TestPackRefsPeeled(c *C) {
+	fs := s.TemporalFilesystem(c)
+
+	dir := New(fs)
+
+	tag := plumbing.NewHash("a8d3ffab552895c19b9fcf7aa264d277cde33881")
+	commit := plumbing.NewHash("b8d3ffab552895c19b9fcf7aa264d277cde33881")
+	for _, ref := range []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+		plumbing.NewHashReference("refs/tags/v1.0.0", tag),
+	} {
+		err := dir.SetRef(ref, nil)
+		c.Assert(err, IsNil)
+	}
+
+	err := dir.PackRefsWithOptions(PackRefsOptions{
+		All:   true,
+		Prune: true,
+		Peel: func(h plumbing.Hash) (plumbing.Hash, error) {
+			if h == tag {
+				return commit, nil
+			}
+
+			return plumbing.ZeroHash, nil
+		},
+	})
+	c.Assert(err, IsNil)
+
+	content, err := util.ReadFile(fs, packedRefsPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, ""+
+		"# pack-refs with: peeled fully-peeled sorted \n"+
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881 refs/heads/foo\n"+
+		"a8d3ffab552895c19b9fcf7aa264d277cde33881 refs/tags/v1.0.0\n"+
+		"^b8d3ffab552895c19b9fcf7aa264d277cde33881\n")
+
+	ref, err := dir.Ref("refs/tags/v1.0.0")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, tag)
+
+	// The peeled value is removed along with the reference.
+	err = dir.RemoveRef("refs/tags/v1.0.0")
+	c.Assert(err, IsNil)
+
+	content, err = util.ReadFile(fs, packedRefsPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, ""+
+		"# pack-refs with: peeled fully-peeled sorted \n"+
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881 refs/heads/foo\n")
+}
+
+func (s *SuiteDotGit) TestPackRefsKeepsUpdatedRef(c *C) {
+	fs := s.TemporalFilesystem(c)
+
+	dir := New(fs)
+
+	err := dir.SetRef(plumbing.NewReferenceFromStrings(
+		"refs/heads/foo",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	), nil)
+	c.Assert(err, IsNil)
+
+	// A ref updated between packing and pruning isn't removed.
+	updated := plumbing.NewReferenceFromStrings(
+		"refs/heads/foo",
+		"b8d3ffab552895c19b9fcf7aa264d277cde33881",
+	)
+	err = util.WriteFile(fs, "refs/heads/foo", refContent(updated), 0644)
+	c.Assert(err, IsNil)
+
+	err = dir.pruneLooseRef(plumbing.NewReferenceFromStrings(
+		"refs/heads/foo",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	))
+	c.Assert(err, IsNil)
+
+	ref, err := dir.Ref("refs/heads/foo")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, updated.Hash())
+}
+
 func TestAlternatesDefault(t *testing.T) {
 	// Create a new dotgit object.
 	dotFS := osfs.New(t.TempDir())
@@ -832,6 +1072,13 @@ func TestAlternatesWithBoundOS(t *testing.T) {
 }
 
 func testAlternates(t *testing.T, dotFS, altFS billy.Filesystem) {
+	// Relative paths are relative to the objects directory.
+	rel, err := filepath.Rel(
+		filepath.Join(dotFS.Root(), "objects"),
+		filepath.Join(altFS.Root(), "repo3", ".git", "objects"),
+	)
+	assert.NoError(t, err)
+
 	tests := []struct {
 		name      string
 		in        []string
@@ -855,8 +1102,18 @@ func testAlternates(t *testing.T, dotFS, altFS billy.Filesystem) {
 		},
 		{
 			name:      "rel path",
-			in:        []string{"../../../repo3//.git/objects"},
-			inWindows: []string{"..\\..\\..\\repo3\\.git\\objects"},
+			in:        []string{rel},
+			inWindows: []string{rel},
+			setup: func() {
+				err := altFS.MkdirAll(filepath.Join("repo3", ".git", "objects"), 0o700)
+				assert.NoError(t, err)
+			},
+			wantRoots: []string{filepath.Join("repo3", ".git")},
+		},
+		{
+			name:      "comments and quoted path",
+			in:        []string{"# comment", "", strconv.Quote(rel)},
+			inWindows: []string{"# comment", "", strconv.Quote(rel)},
 			setup: func() {
 				err := altFS.MkdirAll(filepath.Join("repo3", ".git", "objects"), 0o700)
 				assert.NoError(t, err)
@@ -943,6 +1200,68 @@ func TestAlternatesDupes(t *testing.T) {
 	assert.Len(t, dotgits, 1)
 }
 
+func TestAlternatesNested(t *testing.T) {
+	root := t.TempDir()
+	writeAlternates := func(repo string, paths ...string) {
+		objects := filepath.Join(root, repo, "objects")
+		err := os.MkdirAll(filepath.Join(objects, "info"), 0o700)
+		assert.NoError(t, err)
+
+		content := strings.Join(paths, "\n") + "\n"
+		err = os.WriteFile(filepath.Join(objects, "info", "alternates"), []byte(content), 0o600)
+		assert.NoError(t, err)
+	}
+
+	// repo0 borrows from repo1, which borrows from repo2 and so on, and
+	// repo7 borrows back from repo0.
+	for i := 0; i < 8; i++ {
+		next := fmt.Sprintf("../../repo%d/objects", (i+1)%8)
+		writeAlternates(fmt.Sprintf("repo%d", i), next)
+	}
+
+	// The alternates outside of the DotGit filesystem are opened on their
+	// own, when no AlternatesFS is set.
+	dir := New(osfs.New(filepath.Join(root, "repo0")))
+	dotgits, err := dir.Alternates()
+	assert.NoError(t, err)
+	assert.Len(t, dotgits, 6)
+
+	for i, d := range dotgits {
+		assert.Equal(t, filepath.Join(root, fmt.Sprintf("repo%d", i+1)), d.fs.Root())
+	}
+
+	// The cycle back to the repository itself is ignored.
+	writeAlternates("repo2", "../../repo0/objects", "../../repo1/objects")
+	dotgits, err = dir.Alternates()
+	assert.NoError(t, err)
+	assert.Len(t, dotgits, 2)
+
+	// But the alternates FS limits where the alternates can be.
+	dir = NewWithOptions(osfs.New(filepath.Join(root, "repo0")), Options{
+		AlternatesFS: osfs.New(filepath.Join(root, "repo0")),
+	})
+	_, err = dir.Alternates()
+	assert.ErrorIs(t, err, billy.ErrCrossedBoundary)
+}
+
+func TestRemoveAlternates(t *testing.T) {
+	fs := memfs.New()
+	dir := New(fs)
+	assert.NoError(t, dir.Initialize())
+	assert.NoError(t, fs.MkdirAll("alt/objects", 0o700))
+	assert.NoError(t, dir.AddAlternate("/alt"))
+
+	dotgits, err := dir.Alternates()
+	assert.NoError(t, err)
+	assert.Len(t, dotgits, 1)
+
+	assert.NoError(t, dir.RemoveAlternates())
+	assert.NoError(t, dir.RemoveAlternates())
+
+	_, err = dir.Alternates()
+	assert.True(t, os.IsNotExist(err))
+}
+
 type norwfs struct {
 	billy.Filesystem
 }
@@ -1094,3 +1413,160 @@ func (s *SuiteDotGit) TestSetPackedRef(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(looseCount, Equals, 1)
 }
+
+func (s *SuiteDotGit) TestSetRefLocked(c *C) {
+	fs := s.TemporalFilesystem(c)
+
+	dir := NewWithOptions(fs, Options{LockTimeout: 10 * time.Millisecond})
+
+	err := util.WriteFile(fs, "refs/heads/foo.lock", nil, 0644)
+	c.Assert(err, IsNil)
+
+	err = dir.SetRef(plumbing.NewReferenceFromStrings(
+		"refs/heads/foo",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	), nil)
+	c.Assert(errors.Is(err, ErrLocked), Equals, true)
+
+	// The lock file isn't a reference.
+	refs, err := dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 0)
+
+	err = fs.Remove("refs/heads/foo.lock")
+	c.Assert(err, IsNil)
+
+	err = dir.SetRef(plumbing.NewReferenceFromStrings(
+		"refs/heads/foo",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	), nil)
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat("refs/heads/foo.lock")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SuiteDotGit) TestPackRefsLocked(c *C) {
+	fs := s.TemporalFilesystem(c)
+
+	dir := NewWithOptions(fs, Options{LockTimeout: 10 * time.Millisecond})
+
+	err := dir.SetRef(plumbing.NewReferenceFromStrings(
+		"refs/heads/foo",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	), nil)
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(fs, "packed-refs.lock", nil, 0644)
+	c.Assert(err, IsNil)
+
+	err = dir.PackRefs()
+	c.Assert(errors.Is(err, ErrLocked), Equals, true)
+
+	looseCount, err := dir.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(looseCount, Equals, 1)
+}
+
+func (s *SuiteDotGit) TestSetRefConcurrent(c *C) {
+	fs := s.TemporalFilesystem(c)
+
+	dir := New(fs)
+
+	name := plumbing.ReferenceName("refs/heads/foo")
+	hashes := []string{
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"b8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"a8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"98d3ffab552895c19b9fcf7aa264d277cde33881",
+	}
+
+	err := dir.SetRef(plumbing.NewReferenceFromStrings(name.String(), hashes[0]), nil)
+	c.Assert(err, IsNil)
+
+	// Each goroutine moves the reference from a hash to the next one, all
+	// of them must succeed and see the value set by the previous one.
+	var wg sync.WaitGroup
+	errs := make([]error, len(hashes)-1)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				old, err := dir.Ref(name)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+
+				if old.Hash().String() != hashes[i] {
+					runtime.Gosched()
+					continue
+				}
+
+				errs[i] = dir.SetRef(plumbing.NewReferenceFromStrings(name.String(), hashes[i+1]), old)
+				return
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		c.Assert(err, IsNil)
+	}
+
+	ref, err := dir.Ref(name)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, hashes[len(hashes)-1])
+}
+
+func (s *SuiteDotGit) TestUpdateRefs(c *C) {
+	fs := s.TemporalFilesystem(c)
+
+	dir := NewWithOptions(fs, Options{LockTimeout: 10 * time.Millisecond})
+
+	foo := plumbing.NewReferenceFromStrings("refs/heads/foo", "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	bar := plumbing.NewReferenceFromStrings("refs/heads/bar", "a8d3ffab552895c19b9fcf7aa264d277cde33881")
+	c.Assert(dir.SetRef(foo, nil), IsNil)
+	c.Assert(dir.SetRef(bar, nil), IsNil)
+	c.Assert(dir.PackRefs(), IsNil)
+
+	newHash := plumbing.NewHash("b8d3ffab552895c19b9fcf7aa264d277cde33881")
+	updates := []storer.ReferenceUpdate{
+		{Name: foo.Name(), New: newHash, Old: foo.Hash()},
+		{Name: bar.Name(), Old: bar.Hash()},
+	}
+
+	// A reference locked by someone else fails the whole transaction.
+	err := util.WriteFile(fs, "refs/heads/foo.lock", nil, 0644)
+	c.Assert(err, IsNil)
+
+	err = dir.UpdateRefs(updates)
+	var txErr *storer.ReferenceTransactionError
+	c.Assert(errors.As(err, &txErr), Equals, true)
+	c.Assert(txErr.Errors, HasLen, 1)
+	c.Assert(errors.Is(txErr.Errors[foo.Name()], ErrLocked), Equals, true)
+
+	refs, err := dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 2)
+
+	c.Assert(fs.Remove("refs/heads/foo.lock"), IsNil)
+
+	err = dir.UpdateRefs(updates)
+	c.Assert(err, IsNil)
+
+	ref, err := dir.Ref(foo.Name())
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, newHash)
+
+	_, err = dir.Ref(bar.Name())
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	packed, err := util.ReadFile(fs, packedRefsPath)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(packed), bar.Name().String()), Equals, false)
+
+	_, err = fs.Stat("refs/heads/bar.lock")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,123 @@
+package dotgit
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// quarantinePrefix is the prefix of the name of the quarantine directories,
+// the one used by git since 2.35.
+const quarantinePrefix = "tmp_objdir-incoming-"
+
+// Quarantine is a directory of the objects directory holding new packfiles
+// apart from the ones of the repository, until they are migrated to it or
+// removed, as the quarantine directory of `git receive-pack`.
+type Quarantine struct {
+	d   *DotGit
+	dir string
+}
+
+// NewQuarantine creates a new quarantine directory, named after the
+// tmp_objdir-incoming- prefix.
+func (d *DotGit) NewQuarantine() (*Quarantine, error) {
+	if err := d.fs.MkdirAll(objectsPath, 0o755); err != nil {
+		return nil, err
+	}
+
+	dir, err := util.TempDir(d.fs, objectsPath, quarantinePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.fs.MkdirAll(d.fs.Join(dir, packPath), 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Quarantine{d: d, dir: dir}, nil
+}
+
+// Path returns the path of the quarantine directory, relative to the root of
+// the DotGit.
+func (q *Quarantine) Path() string {
+	return q.dir
+}
+
+func (q *Quarantine) packDir() string {
+	return q.d.fs.Join(q.dir, packPath)
+}
+
+// NewObjectPack returns a writer of a packfile written to the quarantine.
+func (q *Quarantine) NewObjectPack() (*PackWriter, error) {
+	return newPackWrite(q.d.fs, q.packDir(), q.d.options.LargeObjectThreshold)
+}
+
+// ObjectPacks returns the packfiles of the quarantine.
+func (q *Quarantine) ObjectPacks() ([]plumbing.Hash, error) {
+	return listObjectPacks(q.d.fs, q.packDir())
+}
+
+// ObjectPack returns the given packfile of the quarantine.
+func (q *Quarantine) ObjectPack(hash plumbing.Hash) (billy.File, error) {
+	return q.open(hash, packExt)
+}
+
+// ObjectPackIdx returns the index of the given packfile of the quarantine.
+func (q *Quarantine) ObjectPackIdx(hash plumbing.Hash) (billy.File, error) {
+	return q.open(hash, idxExt)
+}
+
+func (q *Quarantine) open(hash plumbing.Hash, ext string) (billy.File, error) {
+	f, err := q.d.fs.Open(q.d.fs.Join(q.packDir(), packPrefix+hash.String()+ext))
+	if os.IsNotExist(err) {
+		return nil, ErrPackfileNotFound
+	}
+
+	return f, err
+}
+
+// Migrate moves the packfiles of the quarantine to the objects directory of
+// the repository, along with their .keep files, and removes the quarantine.
+// The indexes are moved last, so the packfiles aren't used before being
+// complete.
+func (q *Quarantine) Migrate() error {
+	files, err := q.d.fs.ReadDir(q.packDir())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var names []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), packPrefix) {
+			names = append(names, f.Name())
+		}
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		return !strings.HasSuffix(names[i], idxExt) && strings.HasSuffix(names[j], idxExt)
+	})
+
+	dst := q.d.fs.Join(objectsPath, packPath)
+	if err := q.d.fs.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+
+	q.d.cleanPackList()
+	for _, name := range names {
+		if err := q.d.fs.Rename(q.d.fs.Join(q.packDir(), name), q.d.fs.Join(dst, name)); err != nil {
+			return fmt.Errorf("migrating %s: %w", name, err)
+		}
+	}
+
+	return q.Remove()
+}
+
+// Remove removes the quarantine directory and the objects it holds.
+func (q *Quarantine) Remove() error {
+	return util.RemoveAll(q.d.fs, q.dir)
+}
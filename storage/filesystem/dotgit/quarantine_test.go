@@ -0,0 +1,120 @@
+package dotgit
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+func (s *SuiteDotGit) TestKeptObjectPack(c *C) {
+	f := fixtures.Basic().One()
+	fs := s.TemporalFilesystem(c)
+	dot := New(fs)
+
+	w, err := dot.NewObjectPack()
+	c.Assert(err, IsNil)
+	w.Keep = "receive-pack"
+
+	_, err = io.Copy(w, f.Packfile())
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	h := plumbing.NewHash(f.PackfileHash)
+	c.Assert(w.Checksum(), Equals, h)
+
+	keep, err := util.ReadFile(fs, fmt.Sprintf("objects/pack/pack-%s.keep", h))
+	c.Assert(err, IsNil)
+	c.Assert(string(keep), Equals, "receive-pack\n")
+
+	kept, err := dot.ObjectPackKept(h)
+	c.Assert(err, IsNil)
+	c.Assert(kept, Equals, true)
+
+	c.Assert(dot.DeleteOldObjectPackAndIndex(h, time.Time{}), IsNil)
+	packs, err := dot.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, DeepEquals, []plumbing.Hash{h})
+
+	c.Assert(dot.UnkeepObjectPack(h), IsNil)
+	c.Assert(dot.UnkeepObjectPack(h), IsNil)
+	kept, err = dot.ObjectPackKept(h)
+	c.Assert(err, IsNil)
+	c.Assert(kept, Equals, false)
+
+	c.Assert(dot.DeleteOldObjectPackAndIndex(h, time.Time{}), IsNil)
+	packs, err = dot.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 0)
+}
+
+func (s *SuiteDotGit) TestQuarantine(c *C) {
+	f := fixtures.Basic().One()
+	fs := s.TemporalFilesystem(c)
+	dot := New(fs)
+
+	q, err := dot.NewQuarantine()
+	c.Assert(err, IsNil)
+	c.Assert(q.Path(), Matches, "objects/tmp_objdir-incoming-.*")
+
+	w, err := q.NewObjectPack()
+	c.Assert(err, IsNil)
+	w.Keep = "receive-pack"
+
+	_, err = io.Copy(w, f.Packfile())
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	h := plumbing.NewHash(f.PackfileHash)
+	packs, err := q.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, DeepEquals, []plumbing.Hash{h})
+
+	idx, err := q.ObjectPackIdx(h)
+	c.Assert(err, IsNil)
+	c.Assert(idx.Close(), IsNil)
+
+	packs, err = dot.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 0)
+
+	c.Assert(q.Migrate(), IsNil)
+	packs, err = dot.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, DeepEquals, []plumbing.Hash{h})
+
+	kept, err := dot.ObjectPackKept(h)
+	c.Assert(err, IsNil)
+	c.Assert(kept, Equals, true)
+
+	_, err = fs.Stat(q.Path())
+	c.Assert(err, NotNil)
+}
+
+func (s *SuiteDotGit) TestQuarantineRemove(c *C) {
+	f := fixtures.Basic().One()
+	fs := s.TemporalFilesystem(c)
+	dot := New(fs)
+
+	q, err := dot.NewQuarantine()
+	c.Assert(err, IsNil)
+
+	w, err := q.NewObjectPack()
+	c.Assert(err, IsNil)
+	_, err = io.Copy(w, f.Packfile())
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	c.Assert(q.Remove(), IsNil)
+	_, err = fs.Stat(q.Path())
+	c.Assert(err, NotNil)
+
+	packs, err := dot.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 0)
+}
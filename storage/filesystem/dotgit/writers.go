@@ -3,6 +3,7 @@ package dotgit
 import (
 	"fmt"
 	"io"
+	"sync"
 	"sync/atomic"
 
 	"github.com/go-git/go-git/v5/plumbing"
@@ -12,6 +13,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/hash"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
 )
 
 // PackWriter is a io.Writer that generates the packfile index simultaneously,
@@ -22,18 +24,27 @@ import (
 // location, if the PackWriter is not used, nothing is written
 type PackWriter struct {
 	Notify func(plumbing.Hash, *idxfile.Writer)
-
-	fs       billy.Filesystem
-	fr, fw   billy.File
-	synced   *syncedReader
-	checksum plumbing.Hash
-	parser   *packfile.Parser
-	writer   *idxfile.Writer
-	result   chan error
+	// Keep, if not empty, is the reason written to the .keep file of the
+	// packfile, which is written before the packfile is moved to its final
+	// location, as `git index-pack --keep` does. The packfile is then left
+	// alone by the repacks until the .keep file is removed.
+	Keep string
+
+	fs        billy.Filesystem
+	dir       string
+	threshold int64
+	fr, fw    billy.File
+	synced    *syncedReader
+	checksum  plumbing.Hash
+	parser    *packfile.Parser
+	writer    *idxfile.Writer
+	observers []packfile.Observer
+	start     sync.Once
+	result    chan error
 }
 
-func newPackWrite(fs billy.Filesystem) (*PackWriter, error) {
-	fw, err := fs.TempFile(fs.Join(objectsPath, packPath), "tmp_pack_")
+func newPackWrite(fs billy.Filesystem, dir string, threshold int64) (*PackWriter, error) {
+	fw, err := fs.TempFile(dir, "tmp_pack_")
 	if err != nil {
 		return nil, err
 	}
@@ -44,27 +55,44 @@ func newPackWrite(fs billy.Filesystem) (*PackWriter, error) {
 	}
 
 	writer := &PackWriter{
-		fs:     fs,
-		fw:     fw,
-		fr:     fr,
-		synced: newSyncedReader(fw, fr),
-		result: make(chan error),
+		fs:        fs,
+		dir:       dir,
+		threshold: threshold,
+		fw:        fw,
+		fr:        fr,
+		synced:    newSyncedReader(fw, fr),
+		result:    make(chan error),
 	}
 
-	go writer.buildIndex()
 	return writer, nil
 }
 
+// AddObserver adds an observer notified by the parser building the index of
+// the packfile, along with the index writer. It must be called before the
+// packfile is written.
+func (w *PackWriter) AddObserver(ob packfile.Observer) {
+	w.observers = append(w.observers, ob)
+}
+
+// startBuildIndex starts building the index, once the observers are known.
+func (w *PackWriter) startBuildIndex() {
+	w.start.Do(func() { go w.buildIndex() })
+}
+
 func (w *PackWriter) buildIndex() {
 	s := packfile.NewScanner(w.synced)
 	w.writer = new(idxfile.Writer)
 	var err error
-	w.parser, err = packfile.NewParser(s, w.writer)
+	w.parser, err = packfile.NewParser(s, append([]packfile.Observer{w.writer}, w.observers...)...)
 	if err != nil {
 		w.result <- err
 		return
 	}
 
+	if w.threshold > 0 {
+		w.parser.SetLargeObjectThreshold(w.threshold, w.fs, w.dir)
+	}
+
 	checksum, err := w.parser.Parse()
 	if err != nil {
 		w.result <- err
@@ -88,6 +116,7 @@ func (w *PackWriter) waitBuildIndex() error {
 }
 
 func (w *PackWriter) Write(p []byte) (int, error) {
+	w.startBuildIndex()
 	return w.synced.Write(p)
 }
 
@@ -102,11 +131,16 @@ func (w *PackWriter) Close() error {
 		close(w.result)
 	}()
 
+	w.startBuildIndex()
 	if err := w.synced.Close(); err != nil {
 		return err
 	}
 
 	if err := w.waitBuildIndex(); err != nil {
+		// The packfile is incomplete or corrupted, it is removed.
+		_ = w.fr.Close()
+		_ = w.fw.Close()
+		_ = w.clean()
 		return err
 	}
 
@@ -125,12 +159,24 @@ func (w *PackWriter) Close() error {
 	return w.save()
 }
 
+// Checksum returns the hash of the packfile written, once the PackWriter is
+// closed, or a zero hash if nothing was written.
+func (w *PackWriter) Checksum() plumbing.Hash {
+	return w.checksum
+}
+
 func (w *PackWriter) clean() error {
 	return w.fs.Remove(w.fw.Name())
 }
 
 func (w *PackWriter) save() error {
-	base := w.fs.Join(objectsPath, packPath, fmt.Sprintf("pack-%s", w.checksum))
+	base := w.fs.Join(w.dir, fmt.Sprintf("pack-%s", w.checksum))
+	if w.Keep != "" {
+		if err := util.WriteFile(w.fs, base+keepExt, []byte(w.Keep+"\n"), 0o644); err != nil {
+			return err
+		}
+	}
+
 	idx, err := w.fs.Create(fmt.Sprintf("%s.idx", base))
 	if err != nil {
 		return err
@@ -277,6 +323,17 @@ func (w *ObjectWriter) Close() error {
 	return w.save()
 }
 
+// Abort discards the object being written, removing its temporary file
+// instead of saving it.
+func (w *ObjectWriter) Abort() error {
+	_ = w.Writer.Close()
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+
+	return w.fs.Remove(w.f.Name())
+}
+
 func (w *ObjectWriter) save() error {
 	hex := w.Hash().String()
 	file := w.fs.Join(objectsPath, hex[0:2], hex[2:hash.HexSize])
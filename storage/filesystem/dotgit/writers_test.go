@@ -57,6 +57,34 @@ func (s *SuiteDotGit) TestNewObjectPack(c *C) {
 	c.Assert(pfs.Close(), IsNil)
 }
 
+func (s *SuiteDotGit) TestNewObjectPackLargeObjectThreshold(c *C) {
+	f := fixtures.Basic().One()
+
+	fs := s.TemporalFilesystem(c)
+
+	dot := NewWithOptions(fs, Options{LargeObjectThreshold: 64})
+
+	w, err := dot.NewObjectPack()
+	c.Assert(err, IsNil)
+
+	_, err = io.Copy(w, f.Packfile())
+	c.Assert(err, IsNil)
+
+	c.Assert(w.Close(), IsNil)
+
+	expected, err := io.ReadAll(f.Idx())
+	c.Assert(err, IsNil)
+
+	idx, err := util.ReadFile(fs, fmt.Sprintf("objects/pack/pack-%s.idx", f.PackfileHash))
+	c.Assert(err, IsNil)
+	c.Assert(idx, DeepEquals, expected)
+
+	// The temporary files are removed.
+	info, err := fs.ReadDir("objects/pack")
+	c.Assert(err, IsNil)
+	c.Assert(info, HasLen, 2)
+}
+
 func (s *SuiteDotGit) TestNewObjectPackUnused(c *C) {
 	fs := s.TemporalFilesystem(c)
 
@@ -126,7 +154,7 @@ func (s *SuiteDotGit) TestSyncedReader(c *C) {
 func (s *SuiteDotGit) TestPackWriterUnusedNotify(c *C) {
 	fs := s.TemporalFilesystem(c)
 
-	w, err := newPackWrite(fs)
+	w, err := newPackWrite(fs, fs.Join(objectsPath, packPath), 0)
 	c.Assert(err, IsNil)
 
 	w.Notify = func(h plumbing.Hash, idx *idxfile.Writer) {
@@ -0,0 +1,71 @@
+package filesystem
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+const notForMerge = "not-for-merge"
+
+// FetchHeadStorage where the references fetched by the last fetch are
+// stored, an internal to manipulate the FETCH_HEAD file
+type FetchHeadStorage struct {
+	dir *dotgit.DotGit
+}
+
+// SetFetchHead saves the references in the FETCH_HEAD file in the .git folder,
+// one per line, as the hash, the not-for-merge flag and the description
+// separated by tabs, as git does.
+func (s *FetchHeadStorage) SetFetchHead(heads []storer.FetchHead) (err error) {
+	f, err := s.dir.FetchHeadWriter()
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	for _, h := range heads {
+		var flag string
+		if h.NotForMerge {
+			flag = notForMerge
+		}
+
+		if _, err := fmt.Fprintf(f, "%s\t%s\t%s\n", h.Hash, flag, h.Description); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FetchHead returns the references fetched by the last fetch, reading the
+// FETCH_HEAD file from .git
+func (s *FetchHeadStorage) FetchHead() (heads []storer.FetchHead, err error) {
+	f, err := s.dir.FetchHead()
+	if f == nil || err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	scn := bufio.NewScanner(f)
+	for scn.Scan() {
+		fields := strings.SplitN(scn.Text(), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		heads = append(heads, storer.FetchHead{
+			Hash:        plumbing.NewHash(fields[0]),
+			NotForMerge: fields[1] == notForMerge,
+			Description: fields[2],
+		})
+	}
+
+	return heads, scn.Err()
+}
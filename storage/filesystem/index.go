@@ -9,38 +9,58 @@ import (
 	"github.com/go-git/go-git/v5/utils/ioutil"
 )
 
+// indexBufferSize is the size of the buffer the index is written with.
+const indexBufferSize = 256 * 1024
+
 type IndexStorage struct {
 	dir *dotgit.DotGit
 }
 
 func (s *IndexStorage) SetIndex(idx *index.Index) (err error) {
+	cfg, err := (&ConfigStorage{dir: s.dir}).Config()
+	if err != nil {
+		return err
+	}
+
+	// The extensions are written as git does, when enabled by the config
+	// or when the index already has them.
+	if cfg.Index.RecordEndOfIndexEntries && idx.EndOfIndexEntry == nil {
+		idx.EndOfIndexEntry = &index.EndOfIndexEntry{}
+	}
+
+	if cfg.Index.RecordOffsetTable && idx.EntryOffsetTable == nil {
+		idx.EntryOffsetTable = &index.EntryOffsetTable{}
+	}
+
 	f, err := s.dir.IndexWriter()
 	if err != nil {
 		return err
 	}
 
 	defer ioutil.CheckClose(f, &err)
-	bw := bufio.NewWriter(f)
-	defer func() {
-		if e := bw.Flush(); err == nil && e != nil {
-			err = e
-		}
-	}()
-
+	bw := bufio.NewWriterSize(f, indexBufferSize)
 	e := index.NewEncoder(bw)
-	err = e.Encode(idx)
+	if err = e.Encode(idx); err != nil {
+		return err
+	}
+
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+
+	// The index is synced once, when it is completely written.
+	if s, ok := f.(interface{ Sync() error }); ok {
+		err = s.Sync()
+	}
+
 	return err
 }
 
 func (s *IndexStorage) Index() (i *index.Index, err error) {
-	idx := &index.Index{
-		Version: 2,
-	}
-
 	f, err := s.dir.Index()
 	if err != nil {
 		if os.IsNotExist(err) {
-			return idx, nil
+			return s.newIndex()
 		}
 
 		return nil, err
@@ -48,7 +68,27 @@ func (s *IndexStorage) Index() (i *index.Index, err error) {
 
 	defer ioutil.CheckClose(f, &err)
 
+	idx := &index.Index{}
 	d := index.NewDecoder(f)
 	err = d.Decode(idx)
 	return idx, err
 }
+
+// newIndex returns an empty index, its version set by the index.version and
+// feature.manyFiles options.
+func (s *IndexStorage) newIndex() (*index.Index, error) {
+	cfg, err := (&ConfigStorage{dir: s.dir}).Config()
+	if err != nil {
+		return nil, err
+	}
+
+	version := uint32(cfg.Index.Version)
+	if version == 0 {
+		version = 2
+		if cfg.Feature.ManyFiles {
+			version = 4
+		}
+	}
+
+	return &index.Index{Version: version}, nil
+}
@@ -0,0 +1,71 @@
+package filesystem
+
+import (
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	. "gopkg.in/check.v1"
+)
+
+type IndexSuite struct{}
+
+var _ = Suite(&IndexSuite{})
+
+func (s *IndexSuite) TestIndexVersion(c *C) {
+	st := NewStorage(memfs.New(), cache.NewObjectLRUDefault())
+
+	idx, err := st.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Version, Equals, uint32(2))
+
+	cfg, err := st.Config()
+	c.Assert(err, IsNil)
+	cfg.Feature.ManyFiles = true
+	c.Assert(st.SetConfig(cfg), IsNil)
+
+	idx, err = st.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Version, Equals, uint32(4))
+
+	cfg.Index.Version = 3
+	c.Assert(st.SetConfig(cfg), IsNil)
+
+	idx, err = st.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Version, Equals, uint32(3))
+
+	// An existing index keeps its version.
+	c.Assert(st.SetIndex(&index.Index{Version: 2}), IsNil)
+	idx, err = st.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Version, Equals, uint32(2))
+}
+
+func (s *IndexSuite) TestSetIndexExtensions(c *C) {
+	st := NewStorage(memfs.New(), cache.NewObjectLRUDefault())
+
+	idx := &index.Index{Version: 4}
+	idx.Add("foo")
+	c.Assert(st.SetIndex(idx), IsNil)
+
+	idx, err := st.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.EndOfIndexEntry, IsNil)
+	c.Assert(idx.EntryOffsetTable, IsNil)
+
+	cfg, err := st.Config()
+	c.Assert(err, IsNil)
+	cfg.Index.RecordEndOfIndexEntries = true
+	cfg.Index.RecordOffsetTable = true
+	c.Assert(st.SetConfig(cfg), IsNil)
+
+	c.Assert(st.SetIndex(idx), IsNil)
+
+	idx, err = st.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.EndOfIndexEntry, NotNil)
+	c.Assert(idx.EntryOffsetTable, DeepEquals, &index.EntryOffsetTable{
+		Blocks: []index.EntryOffsetBlock{{Offset: 12, Count: 1}},
+	})
+}
@@ -2,14 +2,18 @@ package filesystem
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/bitmap"
 	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/midx"
 	"github.com/go-git/go-git/v5/plumbing/format/objfile"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
 	"github.com/go-git/go-git/v5/plumbing/storer"
@@ -26,9 +30,29 @@ type ObjectStorage struct {
 	// loaded loose objects
 	objectCache cache.Object
 
-	dir   *dotgit.DotGit
+	dir *dotgit.DotGit
+
+	// mu guards the indexes of the packfiles, which are loaded lazily and
+	// dropped by Reindex.
+	mu    sync.RWMutex
 	index map[plumbing.Hash]idxfile.Index
 
+	// midx is the multi-pack-index of the packfiles, if any, and midxPacks
+	// the packfiles it covers, by position. The idx files of these are only
+	// loaded once one of their objects is read.
+	midx        *midx.MemoryIndex
+	midxPacks   []plumbing.Hash
+	midxCovered map[plumbing.Hash]bool
+
+	// packBitmap are the reachability bitmaps of a packfile, if any, loaded
+	// on the first call to PackBitmap.
+	bitmapMu         sync.Mutex
+	packBitmap       *bitmap.PackBitmap
+	packBitmapLoaded bool
+
+	// packfilesMu guards the packfiles kept open, and serializes the reads
+	// from them, as a Packfile reads one object at a time.
+	packfilesMu sync.Mutex
 	packList    []plumbing.Hash
 	packListIdx int
 	packfiles   map[plumbing.Hash]*packfile.Packfile
@@ -39,8 +63,13 @@ func NewObjectStorage(dir *dotgit.DotGit, objectCache cache.Object) *ObjectStora
 	return NewObjectStorageWithOptions(dir, objectCache, Options{})
 }
 
-// NewObjectStorageWithOptions creates a new ObjectStorage with the given .git directory, cache and extra options
+// NewObjectStorageWithOptions creates a new ObjectStorage with the given .git directory, cache and extra options.
+// If objectCache is nil, a cache.ObjectLRU sized by the options is used.
 func NewObjectStorageWithOptions(dir *dotgit.DotGit, objectCache cache.Object, ops Options) *ObjectStorage {
+	if objectCache == nil {
+		objectCache = newObjectCache(ops)
+	}
+
 	return &ObjectStorage{
 		options:     ops,
 		objectCache: objectCache,
@@ -48,7 +77,56 @@ func NewObjectStorageWithOptions(dir *dotgit.DotGit, objectCache cache.Object, o
 	}
 }
 
+func newObjectCache(ops Options) *cache.ObjectLRU {
+	c := cache.NewObjectLRUDefault()
+	if ops.ObjectCacheSize > 0 {
+		c.MaxSize = ops.ObjectCacheSize
+	}
+
+	c.MaxObjectSize = ops.MaxCachedObjectSize
+	return c
+}
+
+// ObjectCache returns the object cache of the storage. When it is a
+// cache.ObjectLRU, its Stats can be used to size it.
+func (s *ObjectStorage) ObjectCache() cache.Object {
+	return s.objectCache
+}
+
 func (s *ObjectStorage) requireIndex() error {
+	if s.indexLoaded() {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requireIndexLocked()
+}
+
+func (s *ObjectStorage) indexLoaded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index != nil
+}
+
+// rlockIndex read-locks s.mu with the indexes loaded, loading them again if
+// they were dropped by Reindex meanwhile.
+func (s *ObjectStorage) rlockIndex() error {
+	for {
+		s.mu.RLock()
+		if s.index != nil {
+			return nil
+		}
+		s.mu.RUnlock()
+
+		if err := s.requireIndex(); err != nil {
+			return err
+		}
+	}
+}
+
+// requireIndexLocked is requireIndex, with s.mu held.
+func (s *ObjectStorage) requireIndexLocked() error {
 	if s.index != nil {
 		return nil
 	}
@@ -59,7 +137,15 @@ func (s *ObjectStorage) requireIndex() error {
 		return err
 	}
 
+	if err := s.loadMultiPackIndex(packs); err != nil {
+		return err
+	}
+
 	for _, h := range packs {
+		if s.midxCovered[h] {
+			continue
+		}
+
 		if err := s.loadIdxFile(h); err != nil {
 			return err
 		}
@@ -70,7 +156,82 @@ func (s *ObjectStorage) requireIndex() error {
 
 // Reindex indexes again all packfiles. Useful if git changed packfiles externally
 func (s *ObjectStorage) Reindex() {
+	s.mu.Lock()
 	s.index = nil
+	s.midx = nil
+	s.midxPacks = nil
+	s.midxCovered = nil
+	s.mu.Unlock()
+
+	s.bitmapMu.Lock()
+	s.packBitmap = nil
+	s.packBitmapLoaded = false
+	s.bitmapMu.Unlock()
+}
+
+// loadMultiPackIndex loads the multi-pack-index, if any. It is ignored if it
+// cannot be read, or if it covers packfiles removed since it was written, the
+// idx files of all the packfiles are loaded instead.
+func (s *ObjectStorage) loadMultiPackIndex(packs []plumbing.Hash) (err error) {
+	f, err := s.dir.MultiPackIndex()
+	if err == dotgit.ErrMultiPackIndexNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	idx := midx.NewMemoryIndex()
+	if err := midx.NewDecoder(f).Decode(idx); err != nil {
+		return nil
+	}
+
+	present := hashListAsMap(packs)
+	midxPacks := make([]plumbing.Hash, len(idx.PackNames))
+	covered := make(map[plumbing.Hash]bool, len(idx.PackNames))
+	for i, name := range idx.PackNames {
+		h := plumbing.NewHash(strings.TrimSuffix(strings.TrimPrefix(name, "pack-"), ".idx"))
+		if _, ok := present[h]; !ok {
+			return nil
+		}
+
+		midxPacks[i] = h
+		covered[h] = true
+	}
+
+	s.midx, s.midxPacks, s.midxCovered = idx, midxPacks, covered
+	return nil
+}
+
+// packIndex returns the index of the packfile, loading it if the packfile is
+// covered by the multi-pack-index.
+func (s *ObjectStorage) packIndex(pack plumbing.Hash) (idxfile.Index, error) {
+	s.mu.RLock()
+	idx, ok := s.index[pack]
+	s.mu.RUnlock()
+	if ok {
+		return idx, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The indexes may have been loaded, or dropped, meanwhile.
+	if err := s.requireIndexLocked(); err != nil {
+		return nil, err
+	}
+
+	if idx, ok := s.index[pack]; ok {
+		return idx, nil
+	}
+
+	if err := s.loadIdxFile(pack); err != nil {
+		return nil, err
+	}
+
+	return s.index[pack], nil
 }
 
 func (s *ObjectStorage) loadIdxFile(h plumbing.Hash) (err error) {
@@ -91,11 +252,101 @@ func (s *ObjectStorage) loadIdxFile(h plumbing.Hash) (err error) {
 	return err
 }
 
+// PackBitmap returns the reachability bitmaps of the first packfile with a
+// bitmap file, or nil if there is none. The bitmap files that cannot be read,
+// or that do not match their packfile, are ignored.
+func (s *ObjectStorage) PackBitmap() (*bitmap.PackBitmap, error) {
+	s.bitmapMu.Lock()
+	defer s.bitmapMu.Unlock()
+
+	if s.packBitmapLoaded {
+		return s.packBitmap, nil
+	}
+
+	if err := s.requireIndex(); err != nil {
+		return nil, err
+	}
+
+	packs, err := s.dir.ObjectPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range packs {
+		p, err := s.loadPackBitmap(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if p != nil {
+			s.packBitmap = p
+			break
+		}
+	}
+
+	s.packBitmapLoaded = true
+	return s.packBitmap, nil
+}
+
+func (s *ObjectStorage) loadPackBitmap(pack plumbing.Hash) (p *bitmap.PackBitmap, err error) {
+	f, err := s.dir.ObjectPackBitmap(pack)
+	if err == dotgit.ErrPackBitmapNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	b := bitmap.NewMemoryIndex()
+	if err := bitmap.NewDecoder(f).Decode(b); err != nil {
+		return nil, nil
+	}
+
+	idx, err := s.packIndex(pack)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err = bitmap.NewPackBitmap(b, idx)
+	if err != nil {
+		return nil, nil
+	}
+
+	return p, nil
+}
+
 func (s *ObjectStorage) NewEncodedObject() plumbing.EncodedObject {
 	return &plumbing.MemoryObject{}
 }
 
 func (s *ObjectStorage) PackfileWriter() (io.WriteCloser, error) {
+	return s.newPackWriter()
+}
+
+// KeptPackfileWriter returns a writer of a packfile, as PackfileWriter does,
+// the packfile being written along with a .keep file holding the given
+// reason. The returned function removes the .keep file.
+func (s *ObjectStorage) KeptPackfileWriter(reason string) (io.WriteCloser, func() error, error) {
+	w, err := s.newPackWriter()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.Keep = reason
+	unkeep := func() error {
+		if h := w.Checksum(); !h.IsZero() {
+			return s.dir.UnkeepObjectPack(h)
+		}
+
+		return nil
+	}
+
+	return w, unkeep, nil
+}
+
+func (s *ObjectStorage) newPackWriter() (*dotgit.PackWriter, error) {
 	if err := s.requireIndex(); err != nil {
 		return nil, err
 	}
@@ -108,7 +359,11 @@ func (s *ObjectStorage) PackfileWriter() (io.WriteCloser, error) {
 	w.Notify = func(h plumbing.Hash, writer *idxfile.Writer) {
 		index, err := writer.Index()
 		if err == nil {
-			s.index[h] = index
+			s.mu.Lock()
+			if s.index != nil {
+				s.index[h] = index
+			}
+			s.mu.Unlock()
 		}
 	}
 
@@ -146,6 +401,64 @@ func (s *ObjectStorage) SetEncodedObject(o plumbing.EncodedObject) (h plumbing.H
 	return o.Hash(), err
 }
 
+// SetEncodedObjectFromReader stores the object of the given type and size
+// whose content is read from r as a loose object. The content is hashed and
+// compressed as it is read, through a buffer of Options.StreamBufferSize
+// bytes, so large objects, such as the files added to a worktree, are never
+// held in memory. Nothing is stored if r doesn't hold size bytes.
+func (s *ObjectStorage) SetEncodedObjectFromReader(t plumbing.ObjectType, size int64, r io.Reader) (h plumbing.Hash, err error) {
+	if t == plumbing.OFSDeltaObject || t == plumbing.REFDeltaObject {
+		return plumbing.ZeroHash, plumbing.ErrInvalidType
+	}
+
+	ow, err := s.dir.NewObject()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	var n int64
+	if err = ow.WriteHeader(t, size); err == nil {
+		n, err = s.copyBuffer(ow, r)
+	}
+
+	if err == nil && n != size {
+		err = fmt.Errorf("%w: %d bytes read out of %d", io.ErrUnexpectedEOF, n, size)
+	}
+
+	if err != nil {
+		_ = ow.Abort()
+		return plumbing.ZeroHash, err
+	}
+
+	if err = ow.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return ow.Hash(), nil
+}
+
+// streamBufferSize returns Options.StreamBufferSize, or the default size if
+// unset.
+func (s *ObjectStorage) streamBufferSize() int64 {
+	if s.options.StreamBufferSize > 0 {
+		return int64(s.options.StreamBufferSize)
+	}
+
+	return defaultStreamBufferSize
+}
+
+// copyBuffer copies src to dst through a buffer of Options.StreamBufferSize
+// bytes, or one of the pool if unset.
+func (s *ObjectStorage) copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	if s.options.StreamBufferSize > 0 {
+		return io.CopyBuffer(dst, src, make([]byte, s.options.StreamBufferSize))
+	}
+
+	bufp := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(bufp)
+	return io.CopyBuffer(dst, src, *bufp)
+}
+
 // LazyWriter returns a lazy ObjectWriter that is bound to a DotGit file.
 // It first write the header passing on the object type and size, so
 // that the object contents can be written later, without the need to
@@ -161,7 +474,20 @@ func (s *ObjectStorage) LazyWriter() (w io.WriteCloser, wh func(typ plumbing.Obj
 
 // HasEncodedObject returns nil if the object exists, without actually
 // reading the object data from storage.
-func (s *ObjectStorage) HasEncodedObject(h plumbing.Hash) (err error) {
+func (s *ObjectStorage) HasEncodedObject(h plumbing.Hash) error {
+	err := s.hasEncodedObject(h)
+	if err == plumbing.ErrObjectNotFound {
+		for _, o := range s.alternates() {
+			if o.hasEncodedObject(h) == nil {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+func (s *ObjectStorage) hasEncodedObject(h plumbing.Hash) (err error) {
 	// Check unpacked objects
 	f, err := s.dir.Object(h)
 	if err != nil {
@@ -206,6 +532,14 @@ func (s *ObjectStorage) encodedObjectSizeFromUnpacked(h plumbing.Hash) (
 	return size, err
 }
 
+// sharedPackfiles tells whether the Packfiles are kept open and shared by the
+// reads, instead of being opened for each one.
+func (s *ObjectStorage) sharedPackfiles() bool {
+	return s.options.KeepDescriptors || s.options.MaxOpenDescriptors > 0
+}
+
+// packfile returns the Packfile of the given pack, when they are shared it
+// must be called with s.packfilesMu held.
 func (s *ObjectStorage) packfile(idx idxfile.Index, pack plumbing.Hash) (*packfile.Packfile, error) {
 	if p := s.packfileFromCache(pack); p != nil {
 		return p, nil
@@ -284,7 +618,11 @@ func (s *ObjectStorage) encodedObjectSizeFromPackfile(h plumbing.Hash) (
 		return 0, plumbing.ErrObjectNotFound
 	}
 
-	idx := s.index[pack]
+	idx, err := s.packIndex(pack)
+	if err != nil {
+		return 0, err
+	}
+
 	hash, err := idx.FindHash(offset)
 	if err == nil {
 		obj, ok := s.objectCache.Get(hash)
@@ -295,12 +633,17 @@ func (s *ObjectStorage) encodedObjectSizeFromPackfile(h plumbing.Hash) (
 		return 0, err
 	}
 
+	if s.sharedPackfiles() {
+		s.packfilesMu.Lock()
+		defer s.packfilesMu.Unlock()
+	}
+
 	p, err := s.packfile(idx, pack)
 	if err != nil {
 		return 0, err
 	}
 
-	if !s.options.KeepDescriptors && s.options.MaxOpenDescriptors == 0 {
+	if !s.sharedPackfiles() {
 		defer ioutil.CheckClose(p, &err)
 	}
 
@@ -309,7 +652,20 @@ func (s *ObjectStorage) encodedObjectSizeFromPackfile(h plumbing.Hash) (
 
 // EncodedObjectSize returns the plaintext size of the given object,
 // without actually reading the full object data from storage.
-func (s *ObjectStorage) EncodedObjectSize(h plumbing.Hash) (
+func (s *ObjectStorage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := s.encodedObjectSize(h)
+	if err == plumbing.ErrObjectNotFound {
+		for _, o := range s.alternates() {
+			if size, err := o.encodedObjectSize(h); err == nil {
+				return size, nil
+			}
+		}
+	}
+
+	return size, err
+}
+
+func (s *ObjectStorage) encodedObjectSize(h plumbing.Hash) (
 	size int64, err error) {
 	size, err = s.encodedObjectSizeFromUnpacked(h)
 	if err != nil && err != plumbing.ErrObjectNotFound {
@@ -324,10 +680,28 @@ func (s *ObjectStorage) EncodedObjectSize(h plumbing.Hash) (
 // EncodedObject returns the object with the given hash, by searching for it in
 // the packfile and the git object directories.
 func (s *ObjectStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.encodedObject(t, h)
+
+	// If the error is still object not found, check if it's a shared object
+	// repository.
+	if err == plumbing.ErrObjectNotFound {
+		for _, o := range s.alternates() {
+			if obj, err := o.encodedObject(t, h); err == nil {
+				return obj, nil
+			}
+		}
+	}
+
+	return obj, err
+}
+
+// encodedObject returns the object with the given hash, without looking for
+// it in the alternates.
+func (s *ObjectStorage) encodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
 	var obj plumbing.EncodedObject
 	var err error
 
-	if s.index != nil {
+	if s.indexLoaded() {
 		obj, err = s.getFromPackfile(h, false)
 		if err == plumbing.ErrObjectNotFound {
 			obj, err = s.getFromUnpacked(h)
@@ -339,24 +713,6 @@ func (s *ObjectStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (p
 		}
 	}
 
-	// If the error is still object not found, check if it's a shared object
-	// repository.
-	if err == plumbing.ErrObjectNotFound {
-		dotgits, e := s.dir.Alternates()
-		if e == nil {
-			// Create a new object storage with the DotGit(s) and check for the
-			// required hash object. Skip when not found.
-			for _, dg := range dotgits {
-				o := NewObjectStorage(dg, s.objectCache)
-				enobj, enerr := o.EncodedObject(t, h)
-				if enerr != nil {
-					continue
-				}
-				return enobj, nil
-			}
-		}
-	}
-
 	if err != nil {
 		return nil, err
 	}
@@ -368,6 +724,23 @@ func (s *ObjectStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (p
 	return obj, nil
 }
 
+// alternates returns the object storages of the object directories in
+// objects/info/alternates, including the nested ones. The object storages
+// share the object cache.
+func (s *ObjectStorage) alternates() []*ObjectStorage {
+	dotgits, err := s.dir.Alternates()
+	if err != nil {
+		return nil
+	}
+
+	storages := make([]*ObjectStorage, len(dotgits))
+	for i, dg := range dotgits {
+		storages[i] = NewObjectStorage(dg, s.objectCache)
+	}
+
+	return storages
+}
+
 // DeltaObject returns the object with the given hash, by searching for
 // it in the packfile and the git object directories.
 func (s *ObjectStorage) DeltaObject(t plumbing.ObjectType,
@@ -377,6 +750,16 @@ func (s *ObjectStorage) DeltaObject(t plumbing.ObjectType,
 		obj, err = s.getFromPackfile(h, true)
 	}
 
+	// The objects of the alternates are returned resolved, their delta base
+	// may not be in this object storage.
+	if err == plumbing.ErrObjectNotFound {
+		for _, o := range s.alternates() {
+			if obj, err := o.encodedObject(t, h); err == nil {
+				return obj, nil
+			}
+		}
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -415,7 +798,11 @@ func (s *ObjectStorage) getFromUnpacked(h plumbing.Hash) (obj plumbing.EncodedOb
 		return nil, err
 	}
 
-	if s.options.LargeObjectThreshold > 0 && size > s.options.LargeObjectThreshold {
+	if s.options.LargeObjectThreshold > 0 && size > s.options.LargeObjectThreshold ||
+		t == plumbing.BlobObject && size > s.streamBufferSize() {
+		// The blobs not fitting in the stream buffer are read from the disk
+		// when needed, so their content is streamed, such as when they are
+		// checked out to a worktree.
 		obj = dotgit.NewEncodedObject(s.dir, h, t, size)
 		return obj, nil
 	}
@@ -431,19 +818,20 @@ func (s *ObjectStorage) getFromUnpacked(h plumbing.Hash) (obj plumbing.EncodedOb
 
 	defer ioutil.CheckClose(w, &err)
 
-	bufp := copyBufferPool.Get().(*[]byte)
-	buf := *bufp
-	_, err = io.CopyBuffer(w, r, buf)
-	copyBufferPool.Put(bufp)
+	_, err = s.copyBuffer(w, r)
 
 	s.objectCache.Put(obj)
 
 	return obj, err
 }
 
+// defaultStreamBufferSize is the size of the stream buffers when
+// Options.StreamBufferSize is unset.
+const defaultStreamBufferSize = 32 * 1024
+
 var copyBufferPool = sync.Pool{
 	New: func() interface{} {
-		b := make([]byte, 32*1024)
+		b := make([]byte, defaultStreamBufferSize)
 		return &b
 	},
 }
@@ -462,13 +850,22 @@ func (s *ObjectStorage) getFromPackfile(h plumbing.Hash, canBeDelta bool) (
 		return nil, plumbing.ErrObjectNotFound
 	}
 
-	idx := s.index[pack]
+	idx, err := s.packIndex(pack)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.sharedPackfiles() {
+		s.packfilesMu.Lock()
+		defer s.packfilesMu.Unlock()
+	}
+
 	p, err := s.packfile(idx, pack)
 	if err != nil {
 		return nil, err
 	}
 
-	if !s.options.KeepDescriptors && s.options.MaxOpenDescriptors == 0 {
+	if !s.sharedPackfiles() {
 		defer ioutil.CheckClose(p, &err)
 	}
 
@@ -540,7 +937,22 @@ func (s *ObjectStorage) decodeDeltaObjectAt(
 }
 
 func (s *ObjectStorage) findObjectInPackfile(h plumbing.Hash) (plumbing.Hash, plumbing.Hash, int64) {
+	if err := s.rlockIndex(); err != nil {
+		return plumbing.ZeroHash, plumbing.ZeroHash, -1
+	}
+	defer s.mu.RUnlock()
+
+	if s.midx != nil {
+		if pack, offset, err := s.midx.FindOffset(h); err == nil {
+			return s.midxPacks[pack], h, offset
+		}
+	}
+
 	for packfile, index := range s.index {
+		if s.midxCovered[packfile] {
+			continue
+		}
+
 		offset, err := index.FindOffset(h)
 		if err == nil {
 			return packfile, h, offset
@@ -562,10 +974,23 @@ func (s *ObjectStorage) HashesWithPrefix(prefix []byte) ([]plumbing.Hash, error)
 
 	// TODO: This could be faster with some idxfile changes,
 	// or diving into the packfile.
-	if err := s.requireIndex(); err != nil {
+	if err := s.rlockIndex(); err != nil {
 		return nil, err
 	}
-	for _, index := range s.index {
+	defer s.mu.RUnlock()
+
+	if s.midx != nil {
+		for _, h := range s.midx.HashesWithPrefix(prefix) {
+			if _, ok := seen[h]; !ok {
+				hashes = append(hashes, h)
+			}
+		}
+	}
+	for packfile, index := range s.index {
+		if s.midxCovered[packfile] {
+			continue
+		}
+
 		ei, err := index.Entries()
 		if err != nil {
 			return nil, err
@@ -629,12 +1054,16 @@ func (s *ObjectStorage) buildPackfileIters(
 	return &lazyPackfilesIter{
 		hashes: packs,
 		open: func(h plumbing.Hash) (storer.EncodedObjectIter, error) {
+			idx, err := s.packIndex(h)
+			if err != nil {
+				return nil, err
+			}
 			pack, err := s.dir.ObjectPack(h)
 			if err != nil {
 				return nil, err
 			}
 			return newPackfileIter(
-				s.dir.Fs(), pack, t, seen, s.index[h],
+				s.dir.Fs(), pack, t, seen, idx,
 				s.objectCache, s.options.KeepDescriptors,
 				s.options.LargeObjectThreshold,
 			)
@@ -644,8 +1073,11 @@ func (s *ObjectStorage) buildPackfileIters(
 
 // Close closes all opened files.
 func (s *ObjectStorage) Close() error {
+	s.packfilesMu.Lock()
+	defer s.packfilesMu.Unlock()
+
 	var firstError error
-	if s.options.KeepDescriptors || s.options.MaxOpenDescriptors > 0 {
+	if s.sharedPackfiles() {
 		for _, packfile := range s.packfiles {
 			err := packfile.Close()
 			if firstError == nil && err != nil {
@@ -708,7 +1140,7 @@ func (it *lazyPackfilesIter) Close() {
 }
 
 type packfileIter struct {
-	pack billy.File
+	pack *packfile.Packfile
 	iter storer.EncodedObjectIter
 	seen map[plumbing.Hash]struct{}
 
@@ -764,7 +1196,7 @@ func newPackfileIter(
 	}
 
 	return &packfileIter{
-		pack:     f,
+		pack:     p,
 		iter:     iter,
 		seen:     seen,
 		keepPack: keepPack,
@@ -888,5 +1320,13 @@ func (s *ObjectStorage) ObjectPacks() ([]plumbing.Hash, error) {
 }
 
 func (s *ObjectStorage) DeleteOldObjectPackAndIndex(h plumbing.Hash, t time.Time) error {
+	s.mu.RLock()
+	covered := s.midxCovered[h]
+	s.mu.RUnlock()
+	if covered {
+		// The multi-pack-index is stale once one of its packfiles is removed.
+		s.Reindex()
+	}
+
 	return s.dir.DeleteOldObjectPackAndIndex(h, t)
 }
@@ -0,0 +1,141 @@
+package filesystem
+
+import (
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// ObjectBatchReader returns a reader of many objects, which keeps the
+// packfiles open, along with their decompression state, until it is closed,
+// whatever the options of the storage.
+func (s *ObjectStorage) ObjectBatchReader() (storer.ObjectBatchReader, error) {
+	if err := s.requireIndex(); err != nil {
+		return nil, err
+	}
+
+	return &objectBatchReader{s: s, packfiles: make(map[plumbing.Hash]*packfile.Packfile)}, nil
+}
+
+type objectBatchReader struct {
+	s         *ObjectStorage
+	packfiles map[plumbing.Hash]*packfile.Packfile
+}
+
+func (r *objectBatchReader) ObjectOffset(h plumbing.Hash) (plumbing.Hash, int64) {
+	pack, _, offset := r.s.findObjectInPackfile(h)
+	return pack, offset
+}
+
+func (r *objectBatchReader) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := r.packedObject(h)
+	if err == plumbing.ErrObjectNotFound {
+		return r.s.EncodedObject(t, h)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t != plumbing.AnyObject && obj.Type() != t {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	return obj, nil
+}
+
+func (r *objectBatchReader) ObjectHeader(h plumbing.Hash) (plumbing.ObjectType, int64, error) {
+	obj, err := r.packedObject(h)
+	if err == nil {
+		return obj.Type(), obj.Size(), nil
+	}
+
+	if err != plumbing.ErrObjectNotFound {
+		return plumbing.InvalidObject, 0, err
+	}
+
+	t, size, err := r.s.objectHeaderFromUnpacked(h)
+	if err != plumbing.ErrObjectNotFound {
+		return t, size, err
+	}
+
+	// The object may be in the alternates.
+	obj, err = r.s.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return plumbing.InvalidObject, 0, err
+	}
+
+	return obj.Type(), obj.Size(), nil
+}
+
+// packedObject returns the object if it is in a packfile, the packfile being
+// kept open afterwards.
+func (r *objectBatchReader) packedObject(h plumbing.Hash) (plumbing.EncodedObject, error) {
+	pack, _, offset := r.s.findObjectInPackfile(h)
+	if offset == -1 {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	p, ok := r.packfiles[pack]
+	if !ok {
+		idx, err := r.s.packIndex(pack)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := r.s.dir.ObjectPack(pack)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.s.objectCache != nil {
+			p = packfile.NewPackfileWithCache(idx, r.s.dir.Fs(), f, r.s.objectCache, r.s.options.LargeObjectThreshold)
+		} else {
+			p = packfile.NewPackfile(idx, r.s.dir.Fs(), f, r.s.options.LargeObjectThreshold)
+		}
+
+		r.packfiles[pack] = p
+	}
+
+	return r.s.decodeObjectAt(p, offset)
+}
+
+func (r *objectBatchReader) Close() error {
+	var firstErr error
+	for pack, p := range r.packfiles {
+		if err := p.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		delete(r.packfiles, pack)
+	}
+
+	return firstErr
+}
+
+// objectHeaderFromUnpacked returns the type and size of a loose object,
+// reading only its header.
+func (s *ObjectStorage) objectHeaderFromUnpacked(h plumbing.Hash) (t plumbing.ObjectType, size int64, err error) {
+	f, err := s.dir.Object(h)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return plumbing.InvalidObject, 0, plumbing.ErrObjectNotFound
+		}
+
+		return plumbing.InvalidObject, 0, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	r, err := objfile.NewReader(f)
+	if err != nil {
+		return plumbing.InvalidObject, 0, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	return r.Header()
+}
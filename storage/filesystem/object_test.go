@@ -2,16 +2,25 @@ package filesystem
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/internal/test"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
 	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
@@ -127,6 +136,66 @@ func (s *FsSuite) TestGetFromPackfileMaxOpenDescriptorsLargeObjectThreshold(c *C
 	c.Assert(err, IsNil)
 }
 
+func (s *FsSuite) TestObjectBatchReader(c *C) {
+	fixtures.Basic().ByTag(".git").Test(c, func(f *fixtures.Fixture) {
+		fs := f.DotGit()
+		o := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
+
+		blob := o.NewEncodedObject()
+		blob.SetType(plumbing.BlobObject)
+		w, err := blob.Writer()
+		c.Assert(err, IsNil)
+		_, err = w.Write([]byte("foo"))
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+		loose, err := o.SetEncodedObject(blob)
+		c.Assert(err, IsNil)
+
+		r, err := o.ObjectBatchReader()
+		c.Assert(err, IsNil)
+
+		_, offset := r.ObjectOffset(loose)
+		c.Assert(offset, Equals, int64(-1))
+
+		t, size, err := r.ObjectHeader(loose)
+		c.Assert(err, IsNil)
+		c.Assert(t, Equals, plumbing.BlobObject)
+		c.Assert(size, Equals, int64(3))
+
+		_, _, err = r.ObjectHeader(plumbing.NewHash("0000000000000000000000000000000000000001"))
+		c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+		c.Assert(r.Close(), IsNil)
+	})
+
+	fixtures.Basic().ByTag(".git").Test(c, func(f *fixtures.Fixture) {
+		fs := f.DotGit()
+		o := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
+
+		packs, err := o.ObjectPacks()
+		c.Assert(err, IsNil)
+
+		r, err := o.ObjectBatchReader()
+		c.Assert(err, IsNil)
+
+		packed := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+		pack, offset := r.ObjectOffset(packed)
+		c.Assert(pack, Equals, packs[0])
+		c.Assert(offset > 0, Equals, true)
+
+		obj, err := r.EncodedObject(plumbing.CommitObject, packed)
+		c.Assert(err, IsNil)
+		c.Assert(obj.Hash(), Equals, packed)
+
+		_, err = r.EncodedObject(plumbing.BlobObject, packed)
+		c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+
+		t, _, err := r.ObjectHeader(packed)
+		c.Assert(err, IsNil)
+		c.Assert(t, Equals, plumbing.CommitObject)
+		c.Assert(r.Close(), IsNil)
+	})
+}
+
 func (s *FsSuite) TestGetSizeOfObjectFile(c *C) {
 	fs := fixtures.ByTag(".git").ByTag("unpacked").One().DotGit()
 	o := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
@@ -195,6 +264,134 @@ func (s *FsSuite) TestGetFromPackfileMultiplePackfilesLargeObjectThreshold(c *C)
 	c.Assert(obj.Hash(), Equals, expected)
 }
 
+func (s *FsSuite) TestGetFromMultiPackIndex(c *C) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	midxData, err := os.ReadFile("../../plumbing/format/midx/testdata/multi-pack-index")
+	c.Assert(err, IsNil)
+	err = util.WriteFile(fs, "objects/pack/multi-pack-index", midxData, 0o644)
+	c.Assert(err, IsNil)
+
+	o := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
+	c.Assert(o.requireIndex(), IsNil)
+	c.Assert(o.midx, NotNil)
+	// The idx file is only loaded once one of its objects is read.
+	c.Assert(o.index, HasLen, 0)
+
+	expected := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	obj, err := o.EncodedObject(plumbing.AnyObject, expected)
+	c.Assert(err, IsNil)
+	c.Assert(obj.Hash(), Equals, expected)
+	c.Assert(o.index, HasLen, 1)
+
+	hashes, err := o.HashesWithPrefix(expected[:8])
+	c.Assert(err, IsNil)
+	c.Assert(hashes, DeepEquals, []plumbing.Hash{expected})
+
+	_, err = o.EncodedObject(plumbing.AnyObject, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e6"))
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *FsSuite) TestGetFromMultiPackIndexPartial(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	fs := fixtures.ByTag(".git").ByTag("multi-packfile").One().DotGit()
+	covered := "pack-f9041ae7a1a7f784d912dda760e3e515ecbff9d3.idx"
+	cmd := exec.Command("git", "--git-dir", fs.Root(), "multi-pack-index", "write", "--stdin-packs")
+	cmd.Stdin = strings.NewReader(covered + "\n")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	o := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
+	c.Assert(o.requireIndex(), IsNil)
+	c.Assert(o.midx, NotNil)
+	c.Assert(o.midx.PackNames, DeepEquals, []string{covered})
+	// Only the idx file of the packfile not covered is loaded.
+	c.Assert(o.index, HasLen, 1)
+
+	// All the objects are found, from the multi-pack-index or the idx file.
+	plain := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
+	iter, err := plain.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+	count := 0
+	err = iter.ForEach(func(expected plumbing.EncodedObject) error {
+		obj, err := o.EncodedObject(plumbing.AnyObject, expected.Hash())
+		c.Assert(err, IsNil)
+		c.Assert(obj.Hash(), Equals, expected.Hash())
+		count++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(count > 0, Equals, true)
+
+	hashes, err := o.HashesWithPrefix(nil)
+	c.Assert(err, IsNil)
+	c.Assert(hashes, HasLen, count)
+}
+
+func (s *FsSuite) TestMultiPackIndexIgnoredWhenStale(c *C) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	midxData, err := os.ReadFile("../../plumbing/format/midx/testdata/multi-pack-index")
+	c.Assert(err, IsNil)
+	err = util.WriteFile(fs, "objects/pack/multi-pack-index", midxData, 0o644)
+	c.Assert(err, IsNil)
+
+	// The packfile covered is replaced by another one.
+	pack := "objects/pack/pack-a3fed42da1e8189a077c0e6846c040dcf73fc9dd"
+	other := "objects/pack/pack-0000000000000000000000000000000000000001"
+	c.Assert(fs.Rename(pack+".pack", other+".pack"), IsNil)
+	c.Assert(fs.Rename(pack+".idx", other+".idx"), IsNil)
+
+	o := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
+	c.Assert(o.requireIndex(), IsNil)
+	c.Assert(o.midx, IsNil)
+	c.Assert(o.index, HasLen, 1)
+
+	expected := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	obj, err := o.EncodedObject(plumbing.AnyObject, expected)
+	c.Assert(err, IsNil)
+	c.Assert(obj.Hash(), Equals, expected)
+}
+
+func (s *FsSuite) TestPackBitmap(c *C) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	o := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
+	b, err := o.PackBitmap()
+	c.Assert(err, IsNil)
+	c.Assert(b, IsNil)
+
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	out, err := exec.Command("git", "--git-dir", fs.Root(), "repack", "-adb").CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	o.Reindex()
+	b, err = o.PackBitmap()
+	c.Assert(err, IsNil)
+	c.Assert(b, NotNil)
+	c.Assert(b.Count(), Equals, 31)
+
+	reachable, ok := b.Reachable(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(ok, Equals, true)
+	c.Assert(reachable.Count(), Equals, 28)
+
+	// A corrupted bitmap file is ignored.
+	packs, err := o.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+	path := fs.Join("objects", "pack", "pack-"+packs[0].String()+".bitmap")
+	c.Assert(os.Chmod(fs.Join(fs.Root(), path), 0o644), IsNil)
+	c.Assert(util.WriteFile(fs, path, []byte("BITM"), 0o644), IsNil)
+
+	o.Reindex()
+	b, err = o.PackBitmap()
+	c.Assert(err, IsNil)
+	c.Assert(b, IsNil)
+}
+
 func (s *FsSuite) TestIter(c *C) {
 	fixtures.ByTag(".git").ByTag("packfile").Test(c, func(f *fixtures.Fixture) {
 		fs := f.DotGit()
@@ -608,3 +805,135 @@ func (s *FsSuite) TestGetFromUnpackedDoesNotCacheLargeObjects(c *C) {
 	_, ok = objectCache.Get(hash)
 	c.Assert(ok, Equals, false)
 }
+
+func (s *FsSuite) TestObjectCacheFromOptions(c *C) {
+	fs := fixtures.ByTag(".git").ByTag("unpacked").One().DotGit()
+	objectStorage := NewObjectStorageWithOptions(dotgit.New(fs), nil, Options{
+		ObjectCacheSize:     cache.MiByte,
+		MaxCachedObjectSize: 1,
+	})
+
+	objectCache, ok := objectStorage.ObjectCache().(*cache.ObjectLRU)
+	c.Assert(ok, Equals, true)
+	c.Assert(objectCache.MaxSize, Equals, cache.MiByte)
+	c.Assert(objectCache.MaxObjectSize, Equals, cache.FileSize(1))
+
+	hash := plumbing.NewHash("f3dfe29d268303fc6e1bbce268605fc99573406e")
+	obj, err := objectStorage.EncodedObject(plumbing.AnyObject, hash)
+	c.Assert(err, IsNil)
+	c.Assert(obj.Hash(), Equals, hash)
+
+	// The object is larger than the max cached object size.
+	_, ok = objectCache.Get(hash)
+	c.Assert(ok, Equals, false)
+	c.Assert(objectCache.Stats().Misses, Not(Equals), uint64(0))
+}
+
+func (s *FsSuite) TestSetEncodedObjectFromReader(c *C) {
+	const size = 64 << 20
+	hasher := plumbing.NewHasher(plumbing.BlobObject, size)
+	_, err := io.Copy(hasher, io.LimitReader(test.ZeroReader{}, size))
+	c.Assert(err, IsNil)
+	expected := hasher.Sum()
+
+	fs := memfs.New()
+	o := NewObjectStorageWithOptions(dotgit.New(fs), cache.NewObjectLRUDefault(), Options{StreamBufferSize: 4096})
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	h, err := o.SetEncodedObjectFromReader(plumbing.BlobObject, size, io.LimitReader(test.ZeroReader{}, size))
+	runtime.ReadMemStats(&after)
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, expected)
+
+	// The content is never held in memory.
+	c.Assert(after.TotalAlloc-before.TotalAlloc < size/8, Equals, true,
+		Commentf("%d bytes allocated", after.TotalAlloc-before.TotalAlloc))
+
+	size2, err := o.EncodedObjectSize(h)
+	c.Assert(err, IsNil)
+	c.Assert(size2, Equals, int64(size))
+}
+
+func (s *FsSuite) TestSetEncodedObjectFromReaderSizeMismatch(c *C) {
+	fs := memfs.New()
+	o := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
+
+	_, err := o.SetEncodedObjectFromReader(plumbing.BlobObject, 4, strings.NewReader("foo"))
+	c.Assert(errors.Is(err, io.ErrUnexpectedEOF), Equals, true)
+
+	_, err = o.SetEncodedObjectFromReader(plumbing.BlobObject, 2, strings.NewReader("foo"))
+	c.Assert(err, Equals, objfile.ErrOverflow)
+
+	var files []string
+	err = util.Walk(fs, "objects", func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+
+	h, err := o.SetEncodedObjectFromReader(plumbing.BlobObject, 3, strings.NewReader("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, plumbing.ComputeHash(plumbing.BlobObject, []byte("foo")))
+	c.Assert(o.HasEncodedObject(h), IsNil)
+}
+
+func (s *FsSuite) TestIterEncodedObjectsConcurrent(c *C) {
+	for _, ops := range []Options{
+		{},
+		{KeepDescriptors: true},
+		{MaxOpenDescriptors: 1},
+	} {
+		fs := fixtures.ByTag(".git").ByTag("multi-packfile").One().DotGit()
+		o := NewObjectStorageWithOptions(
+			dotgit.NewWithOptions(fs, dotgit.Options{KeepDescriptors: ops.KeepDescriptors}),
+			cache.NewObjectLRUDefault(), ops,
+		)
+
+		var hashes []plumbing.Hash
+		iter, err := o.IterEncodedObjects(plumbing.AnyObject)
+		c.Assert(err, IsNil)
+		err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+			hashes = append(hashes, obj.Hash())
+			return nil
+		})
+		c.Assert(err, IsNil)
+
+		// Read all the objects from several goroutines, while the indexes
+		// are dropped and loaded again.
+		var wg sync.WaitGroup
+		errs := make(chan error, 4)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j, h := range hashes {
+					if i == 0 && j%10 == 0 {
+						o.Reindex()
+					}
+
+					obj, err := o.EncodedObject(plumbing.AnyObject, h)
+					if err == nil && obj.Hash() != h {
+						err = fmt.Errorf("got %s reading %s", obj.Hash(), h)
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			c.Assert(err, IsNil)
+		}
+
+		c.Assert(o.Close(), IsNil)
+	}
+}
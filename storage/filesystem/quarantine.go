@@ -0,0 +1,274 @@
+package filesystem
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// ErrQuarantineAlternate is returned when an alternate is added to a
+// Quarantine.
+var ErrQuarantineAlternate = errors.New("alternates cannot be added to a quarantine")
+
+// Quarantine is an object storage holding the objects written to it in a
+// quarantine directory, objects/tmp_objdir-incoming-<random>, apart from the
+// objects of the ObjectStorage it was created from, as `git receive-pack`
+// does before the hooks accept a push. The objects of the ObjectStorage are
+// readable through it.
+//
+// The objects are written as packfiles, which are migrated to the objects
+// directory by Migrate, or deleted by Remove.
+type Quarantine struct {
+	s *ObjectStorage
+	q *dotgit.Quarantine
+
+	mu    sync.Mutex
+	packs map[plumbing.Hash]*quarantinePack
+}
+
+// quarantinePack is a packfile of a Quarantine, along with its index.
+type quarantinePack struct {
+	idx  idxfile.Index
+	pack *packfile.Packfile
+}
+
+// Quarantine creates a new quarantine directory, returning the Quarantine
+// writing to it.
+func (s *ObjectStorage) Quarantine() (storer.Quarantine, error) {
+	q, err := s.dir.NewQuarantine()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Quarantine{
+		s:     s,
+		q:     q,
+		packs: make(map[plumbing.Hash]*quarantinePack),
+	}, nil
+}
+
+// Path returns the path of the quarantine directory, relative to the root of
+// the storage.
+func (q *Quarantine) Path() string {
+	return q.q.Path()
+}
+
+// PackfileWriter returns a writer of a packfile written to the quarantine.
+func (q *Quarantine) PackfileWriter() (io.WriteCloser, error) {
+	return q.newPackWriter()
+}
+
+// KeptPackfileWriter returns a writer of a packfile written to the
+// quarantine along with a .keep file holding the given reason, which is
+// migrated with it. The returned function removes the .keep file, once
+// migrated.
+func (q *Quarantine) KeptPackfileWriter(reason string) (io.WriteCloser, func() error, error) {
+	w, err := q.newPackWriter()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.Keep = reason
+	unkeep := func() error {
+		if h := w.Checksum(); !h.IsZero() {
+			return q.s.dir.UnkeepObjectPack(h)
+		}
+
+		return nil
+	}
+
+	return w, unkeep, nil
+}
+
+func (q *Quarantine) newPackWriter() (*dotgit.PackWriter, error) {
+	w, err := q.q.NewObjectPack()
+	if err != nil {
+		return nil, err
+	}
+
+	w.Notify = func(h plumbing.Hash, writer *idxfile.Writer) {
+		idx, err := writer.Index()
+		if err != nil {
+			return
+		}
+
+		f, err := q.q.ObjectPack(h)
+		if err != nil {
+			return
+		}
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.packs[h] = &quarantinePack{
+			idx:  idx,
+			pack: packfile.NewPackfile(idx, q.s.dir.Fs(), f, q.s.options.LargeObjectThreshold),
+		}
+	}
+
+	return w, nil
+}
+
+// Migrate moves the packfiles of the quarantine to the objects directory, and
+// removes the quarantine.
+func (q *Quarantine) Migrate() error {
+	if err := q.close(); err != nil {
+		return err
+	}
+
+	if err := q.q.Migrate(); err != nil {
+		return err
+	}
+
+	q.s.Reindex()
+	return nil
+}
+
+// Remove removes the quarantine and its objects.
+func (q *Quarantine) Remove() error {
+	if err := q.close(); err != nil {
+		return err
+	}
+
+	return q.q.Remove()
+}
+
+func (q *Quarantine) close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var firstErr error
+	for h, p := range q.packs {
+		if err := p.pack.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		delete(q.packs, h)
+	}
+
+	return firstErr
+}
+
+// find returns the packfile of the quarantine holding the given object, and
+// the offset of the object in it.
+func (q *Quarantine) find(h plumbing.Hash) (*quarantinePack, int64) {
+	for _, p := range q.packs {
+		offset, err := p.idx.FindOffset(h)
+		if err == nil {
+			return p, offset
+		}
+	}
+
+	return nil, 0
+}
+
+func (q *Quarantine) NewEncodedObject() plumbing.EncodedObject {
+	return &plumbing.MemoryObject{}
+}
+
+// SetEncodedObject writes the object to the quarantine, in a packfile of its
+// own.
+func (q *Quarantine) SetEncodedObject(obj plumbing.EncodedObject) (h plumbing.Hash, err error) {
+	if obj.Type() == plumbing.OFSDeltaObject || obj.Type() == plumbing.REFDeltaObject {
+		return plumbing.ZeroHash, plumbing.ErrInvalidType
+	}
+
+	mem := memory.NewStorage()
+	if _, err := mem.SetEncodedObject(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	w, err := q.newPackWriter()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	defer ioutil.CheckClose(w, &err)
+
+	if _, err = packfile.NewEncoder(w, mem, false).Encode([]plumbing.Hash{obj.Hash()}, 0); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return obj.Hash(), nil
+}
+
+func (q *Quarantine) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	q.mu.Lock()
+	p, _ := q.find(h)
+	if p == nil {
+		q.mu.Unlock()
+		return q.s.EncodedObject(t, h)
+	}
+
+	obj, err := p.pack.Get(h)
+	q.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if t != plumbing.AnyObject && obj.Type() != t {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	return obj, nil
+}
+
+func (q *Quarantine) HasEncodedObject(h plumbing.Hash) error {
+	q.mu.Lock()
+	p, _ := q.find(h)
+	q.mu.Unlock()
+	if p != nil {
+		return nil
+	}
+
+	return q.s.HasEncodedObject(h)
+}
+
+func (q *Quarantine) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	q.mu.Lock()
+	p, offset := q.find(h)
+	if p == nil {
+		q.mu.Unlock()
+		return q.s.EncodedObjectSize(h)
+	}
+
+	defer q.mu.Unlock()
+	return p.pack.GetSizeByOffset(offset)
+}
+
+// IterEncodedObjects returns an iterator over the objects of the quarantine,
+// followed by the ones of the storage.
+func (q *Quarantine) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	q.mu.Lock()
+	var iters []storer.EncodedObjectIter
+	for _, p := range q.packs {
+		iter, err := p.pack.GetByType(t)
+		if err != nil {
+			q.mu.Unlock()
+			return nil, err
+		}
+
+		iters = append(iters, iter)
+	}
+	q.mu.Unlock()
+
+	existing, err := q.s.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return storer.NewMultiEncodedObjectIter(append(iters, existing)), nil
+}
+
+// AddAlternate returns ErrQuarantineAlternate, the alternates are added to
+// the storage.
+func (q *Quarantine) AddAlternate(string) error {
+	return ErrQuarantineAlternate
+}
@@ -2,28 +2,45 @@ package filesystem
 
 import (
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/reflog"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
 )
 
+// refsBackend stores the references, as loose files and in the packed-refs
+// file with dotgit.DotGit, or in the reftable files with reftableRefs.
+type refsBackend interface {
+	SetRef(r, old *plumbing.Reference) error
+	Ref(name plumbing.ReferenceName) (*plumbing.Reference, error)
+	Refs() ([]*plumbing.Reference, error)
+	RemoveRef(name plumbing.ReferenceName) error
+	CountLooseRefs() (int, error)
+	PackRefs() error
+	PackRefsWithOptions(o dotgit.PackRefsOptions) error
+	UpdateRefs(updates []storer.ReferenceUpdate) error
+	Reflog(name plumbing.ReferenceName) ([]*reflog.Entry, error)
+	RenameReflog(oldName, newName plumbing.ReferenceName) error
+	RemoveReflog(name plumbing.ReferenceName) error
+}
+
 type ReferenceStorage struct {
-	dir *dotgit.DotGit
+	refs refsBackend
 }
 
 func (r *ReferenceStorage) SetReference(ref *plumbing.Reference) error {
-	return r.dir.SetRef(ref, nil)
+	return r.refs.SetRef(ref, nil)
 }
 
 func (r *ReferenceStorage) CheckAndSetReference(ref, old *plumbing.Reference) error {
-	return r.dir.SetRef(ref, old)
+	return r.refs.SetRef(ref, old)
 }
 
 func (r *ReferenceStorage) Reference(n plumbing.ReferenceName) (*plumbing.Reference, error) {
-	return r.dir.Ref(n)
+	return r.refs.Ref(n)
 }
 
 func (r *ReferenceStorage) IterReferences() (storer.ReferenceIter, error) {
-	refs, err := r.dir.Refs()
+	refs, err := r.refs.Refs()
 	if err != nil {
 		return nil, err
 	}
@@ -32,13 +49,48 @@ func (r *ReferenceStorage) IterReferences() (storer.ReferenceIter, error) {
 }
 
 func (r *ReferenceStorage) RemoveReference(n plumbing.ReferenceName) error {
-	return r.dir.RemoveRef(n)
+	return r.refs.RemoveRef(n)
 }
 
 func (r *ReferenceStorage) CountLooseRefs() (int, error) {
-	return r.dir.CountLooseRefs()
+	return r.refs.CountLooseRefs()
 }
 
 func (r *ReferenceStorage) PackRefs() error {
-	return r.dir.PackRefs()
+	return r.refs.PackRefs()
+}
+
+// Reflog returns the entries of the reflog of the given reference, oldest
+// first, or none if it has no reflog.
+func (r *ReferenceStorage) Reflog(n plumbing.ReferenceName) ([]*reflog.Entry, error) {
+	return r.refs.Reflog(n)
+}
+
+// SetReflog replaces the entries of the reflog of the given reference,
+// oldest first. storer.ErrReflogNotSupported is returned if the references
+// are stored in reftable files.
+func (r *ReferenceStorage) SetReflog(n plumbing.ReferenceName, entries []*reflog.Entry) error {
+	w, ok := r.refs.(storer.ReflogWriter)
+	if !ok {
+		return storer.ErrReflogNotSupported
+	}
+
+	return w.SetReflog(n, entries)
+}
+
+// RenameReflog moves the reflog of the reference oldName to newName,
+// replacing the one of newName, if any.
+func (r *ReferenceStorage) RenameReflog(oldName, newName plumbing.ReferenceName) error {
+	return r.refs.RenameReflog(oldName, newName)
+}
+
+// RemoveReflog removes the reflog of the given reference, if any.
+func (r *ReferenceStorage) RemoveReflog(n plumbing.ReferenceName) error {
+	return r.refs.RemoveReflog(n)
+}
+
+// BeginReferenceTransaction starts a reference transaction, its references
+// are locked with lock files while it is committed.
+func (r *ReferenceStorage) BeginReferenceTransaction() storer.ReferenceTransaction {
+	return storer.NewReferenceTransaction(r.refs.UpdateRefs)
 }
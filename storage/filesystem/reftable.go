@@ -0,0 +1,250 @@
+package filesystem
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/reflog"
+	"github.com/go-git/go-git/v5/plumbing/format/reftable"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"github.com/go-git/go-billy/v5/helper/chroot"
+)
+
+const reftableDir = "reftable"
+
+// reftableRefs stores the references in the reftable stack of the reftable
+// directory, as the repositories with the extensions.refStorage option set
+// to reftable, initialized with `git init --ref-format=reftable`, do.
+//
+// Each update adds a table to the stack, locking it the same way as git, so
+// both can update the references of a repository.
+type reftableRefs struct {
+	stack *reftable.Stack
+}
+
+func newReftableRefs(dir *dotgit.DotGit, ops Options) *reftableRefs {
+	fs := chroot.New(dir.Fs(), reftableDir)
+	return &reftableRefs{stack: reftable.NewStack(fs, reftable.StackOptions{
+		LockTimeout: ops.LockTimeout,
+	})}
+}
+
+func (r *reftableRefs) SetRef(ref, old *plumbing.Reference) (err error) {
+	a, err := r.stack.NewAddition()
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(a, &err)
+
+	if old != nil {
+		current, err := r.Ref(old.Name())
+		if err != nil {
+			return err
+		}
+
+		if current.Hash() != old.Hash() {
+			return storage.ErrReferenceHasChanged
+		}
+	}
+
+	a.AddRef(reftable.NewRefRecord(ref.Name(), ref, 0))
+	return a.Commit()
+}
+
+func (r *reftableRefs) Ref(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	rec, err := r.stack.Ref(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return rec.Reference(), nil
+}
+
+func (r *reftableRefs) Refs() ([]*plumbing.Reference, error) {
+	recs, err := r.stack.Refs()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]*plumbing.Reference, 0, len(recs))
+	for _, rec := range recs {
+		refs = append(refs, rec.Reference())
+	}
+
+	return refs, nil
+}
+
+func (r *reftableRefs) RemoveRef(name plumbing.ReferenceName) (err error) {
+	a, err := r.stack.NewAddition()
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(a, &err)
+
+	_, err = r.stack.Ref(name)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	a.AddRef(reftable.NewRefRecord(name, nil, 0))
+	return a.Commit()
+}
+
+// CountLooseRefs returns 0, the references in a reftable are never loose.
+func (r *reftableRefs) CountLooseRefs() (int, error) {
+	return 0, nil
+}
+
+// PackRefs compacts the stack into a single table.
+func (r *reftableRefs) PackRefs() error {
+	return r.stack.Compact()
+}
+
+// PackRefsWithOptions compacts the stack into a single table, as git
+// pack-refs does whatever the options.
+func (r *reftableRefs) PackRefsWithOptions(dotgit.PackRefsOptions) error {
+	return r.stack.Compact()
+}
+
+// UpdateRefs applies the updates in a single table, if none of them fail.
+func (r *reftableRefs) UpdateRefs(updates []storer.ReferenceUpdate) (err error) {
+	a, err := r.stack.NewAddition()
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(a, &err)
+
+	errs := make(map[plumbing.ReferenceName]error)
+	for _, u := range updates {
+		current, err := r.Ref(u.Name)
+		if err == plumbing.ErrReferenceNotFound {
+			current, err = nil, nil
+		}
+
+		if err == nil && !u.Matches(current) {
+			err = storage.ErrReferenceHasChanged
+		}
+
+		if err != nil {
+			errs[u.Name] = err
+			continue
+		}
+
+		var ref *plumbing.Reference
+		if !u.New.IsZero() {
+			ref = plumbing.NewHashReference(u.Name, u.New)
+		}
+
+		a.AddRef(reftable.NewRefRecord(u.Name, ref, 0))
+	}
+
+	if len(errs) != 0 {
+		return &storer.ReferenceTransactionError{Errors: errs}
+	}
+
+	return a.Commit()
+}
+
+// Reflog returns the log records of the reference in the stack, oldest
+// first.
+func (r *reftableRefs) Reflog(name plumbing.ReferenceName) ([]*reflog.Entry, error) {
+	logs, err := r.stack.Logs()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*reflog.Entry
+	for i := len(logs) - 1; i >= 0; i-- {
+		l := logs[i]
+		if l.Name != name {
+			continue
+		}
+
+		entries = append(entries, &reflog.Entry{
+			Old:       l.Old,
+			New:       l.New,
+			Committer: l.Committer,
+			Email:     l.Email,
+			When:      l.When,
+			Message:   strings.TrimSuffix(l.Message, "\n"),
+		})
+	}
+
+	return entries, nil
+}
+
+// RenameReflog copies the log records of the reference oldName to newName
+// with their update index, and deletes the ones of both, in a single table,
+// as git does.
+func (r *reftableRefs) RenameReflog(oldName, newName plumbing.ReferenceName) error {
+	return r.editLogs(func(logs []*reftable.LogRecord) []*reftable.LogRecord {
+		copied := make(map[uint64]bool)
+		var records []*reftable.LogRecord
+		for _, l := range logs {
+			if l.Name == oldName {
+				records = append(records, deletedLog(l))
+
+				c := *l
+				c.Name = newName
+				records = append(records, &c)
+				copied[l.UpdateIndex] = true
+			}
+		}
+
+		for _, l := range logs {
+			if l.Name == newName && !copied[l.UpdateIndex] {
+				records = append(records, deletedLog(l))
+			}
+		}
+
+		return records
+	})
+}
+
+// RemoveReflog deletes the log records of the reference.
+func (r *reftableRefs) RemoveReflog(name plumbing.ReferenceName) error {
+	return r.editLogs(func(logs []*reftable.LogRecord) []*reftable.LogRecord {
+		var records []*reftable.LogRecord
+		for _, l := range logs {
+			if l.Name == name {
+				records = append(records, deletedLog(l))
+			}
+		}
+
+		return records
+	})
+}
+
+// editLogs adds the log records returned by f, given the ones of the stack,
+// in a new table.
+func (r *reftableRefs) editLogs(f func(logs []*reftable.LogRecord) []*reftable.LogRecord) (err error) {
+	a, err := r.stack.NewAddition()
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(a, &err)
+
+	logs, err := r.stack.Logs()
+	if err != nil {
+		return err
+	}
+
+	for _, l := range f(logs) {
+		a.AddLogRecord(l)
+	}
+
+	return a.Commit()
+}
+
+// deletedLog returns the record deleting the given log record.
+func deletedLog(l *reftable.LogRecord) *reftable.LogRecord {
+	return &reftable.LogRecord{Name: l.Name, UpdateIndex: l.UpdateIndex, Deletion: true}
+}
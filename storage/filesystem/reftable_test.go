@@ -0,0 +1,141 @@
+package filesystem
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/reftable"
+	"github.com/go-git/go-git/v5/storage"
+
+	"github.com/go-git/go-billy/v5/helper/chroot"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	. "gopkg.in/check.v1"
+)
+
+const reftableConfig = `[core]
+	repositoryformatversion = 1
+	bare = true
+[extensions]
+	refstorage = reftable
+`
+
+type ReftableSuite struct{}
+
+var _ = Suite(&ReftableSuite{})
+
+func (s *ReftableSuite) TestReferences(c *C) {
+	fs := memfs.New()
+	err := util.WriteFile(fs, "config", []byte(reftableConfig), 0644)
+	c.Assert(err, IsNil)
+
+	// git keeps an invalid HEAD, for the older versions to not open the
+	// repository.
+	err = util.WriteFile(fs, "HEAD", []byte("ref: refs/heads/.invalid\n"), 0644)
+	c.Assert(err, IsNil)
+
+	sto := NewStorage(fs, cache.NewObjectLRUDefault())
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")
+	main := plumbing.NewReferenceFromStrings("refs/heads/main", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	c.Assert(sto.SetReference(head), IsNil)
+	c.Assert(sto.SetReference(main), IsNil)
+
+	ref, err := sto.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, head)
+
+	// The references are stored in the reftable only.
+	_, err = fs.Stat("refs/heads/main")
+	c.Assert(err, NotNil)
+
+	content, err := util.ReadFile(fs, "HEAD")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "ref: refs/heads/.invalid\n")
+
+	c.Assert(sto.RemoveReference("refs/heads/foo"), IsNil)
+	c.Assert(sto.CheckAndSetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/main", "e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+		plumbing.NewReferenceFromStrings("refs/heads/main", "e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+	), Equals, storage.ErrReferenceHasChanged)
+
+	c.Assert(sto.PackRefs(), IsNil)
+	content, err = util.ReadFile(fs, "reftable/tables.list")
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(content), "\n"), Equals, 1)
+
+	// A new storage reads the references of the stack.
+	sto = NewStorage(fs, cache.NewObjectLRUDefault())
+	ref, err = sto.Reference("refs/heads/main")
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, main)
+}
+
+func (s *ReftableSuite) TestReflog(c *C) {
+	fs := memfs.New()
+	err := util.WriteFile(fs, "config", []byte(reftableConfig), 0644)
+	c.Assert(err, IsNil)
+
+	first := plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+	second := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	when := time.Unix(1427806800, 0).In(time.FixedZone("", 3600))
+
+	stack := reftable.NewStack(chroot.New(fs, reftableDir), reftable.StackOptions{})
+	for i, h := range []plumbing.Hash{first, second} {
+		a, err := stack.NewAddition()
+		c.Assert(err, IsNil)
+
+		old := plumbing.ZeroHash
+		if i > 0 {
+			old = first
+		}
+
+		a.AddLog(&reftable.LogRecord{
+			Name:        "refs/heads/main",
+			UpdateIndex: a.UpdateIndex(),
+			Old:         old,
+			New:         h,
+			Committer:   "John Doe",
+			Email:       "john@example.com",
+			When:        when.Add(time.Duration(i) * time.Hour),
+			Message:     "commit: foo\n",
+		})
+		c.Assert(a.Commit(), IsNil)
+		c.Assert(a.Close(), IsNil)
+	}
+
+	sto := NewStorage(fs, cache.NewObjectLRUDefault())
+	entries, err := sto.Reflog("refs/heads/main")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Old, Equals, plumbing.ZeroHash)
+	c.Assert(entries[0].New, Equals, first)
+	c.Assert(entries[1].Old, Equals, first)
+	c.Assert(entries[1].New, Equals, second)
+	c.Assert(entries[1].When.Equal(when.Add(time.Hour)), Equals, true)
+	c.Assert(entries[1].Message, Equals, "commit: foo")
+
+	entries, err = sto.Reflog("refs/heads/foo")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	// The records are copied with their update index, even once compacted.
+	c.Assert(sto.RenameReflog("refs/heads/main", "refs/heads/main/foo"), IsNil)
+	c.Assert(sto.PackRefs(), IsNil)
+
+	entries, err = sto.Reflog("refs/heads/main")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	entries, err = sto.Reflog("refs/heads/main/foo")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].New, Equals, first)
+	c.Assert(entries[1].New, Equals, second)
+
+	c.Assert(sto.RemoveReflog("refs/heads/main/foo"), IsNil)
+	entries, err = sto.Reflog("refs/heads/main/foo")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
@@ -2,8 +2,18 @@
 package filesystem
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	formatcfg "github.com/go-git/go-git/v5/plumbing/format/config"
 	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	"github.com/go-git/go-git/v5/utils/ioutil"
 
 	"github.com/go-git/go-billy/v5"
 )
@@ -11,6 +21,12 @@ import (
 // Storage is an implementation of git.Storer that stores data on disk in the
 // standard git format (this is, the .git directory). Zero values of this type
 // are not safe to use, see the NewStorage function below.
+//
+// A Storage is safe for concurrent use by multiple goroutines, provided the
+// given cache.Object is too. The objects are read concurrently, but the reads
+// from the packfiles kept open by KeepDescriptors or MaxOpenDescriptors are
+// serialized. The updates of the references are serialized, and locked
+// against other processes with lock files the same way git does.
 type Storage struct {
 	fs  billy.Filesystem
 	dir *dotgit.DotGit
@@ -19,6 +35,7 @@ type Storage struct {
 	ReferenceStorage
 	IndexStorage
 	ShallowStorage
+	FetchHeadStorage
 	ConfigStorage
 	ModuleStorage
 }
@@ -35,12 +52,34 @@ type Options struct {
 	// open. If KeepDescriptors is true, all file descriptors will remain open.
 	MaxOpenDescriptors int
 	// LargeObjectThreshold maximum object size (in bytes) that will be read in to memory.
+	// The larger objects aren't cached either, and while indexing received
+	// packfiles they are spilled to temporary files in objects/pack. Their
+	// content is streamed from the disk instead, such as when they are
+	// checked out to a worktree.
 	// If left unset or set to 0 there is no limit
 	LargeObjectThreshold int64
+	// StreamBufferSize is the size, in bytes, of the buffer the content of
+	// the objects stored from a stream is copied through, such as the files
+	// added to a worktree. The loose blobs larger than it are streamed from
+	// the disk when read, such as when checked out, instead of being loaded
+	// in memory. If left unset or set to 0, 32 KiB are used.
+	StreamBufferSize int
+	// ObjectCacheSize is the maximum size, in bytes, of the objects held by
+	// the object cache created when no cache is given. If left unset or set
+	// to 0, cache.DefaultMaxSize is used.
+	ObjectCacheSize cache.FileSize
+	// MaxCachedObjectSize is the size, in bytes, above which the objects
+	// aren't held by the object cache created when no cache is given. If
+	// left unset or set to 0 there is no limit other than ObjectCacheSize.
+	MaxCachedObjectSize cache.FileSize
 	// AlternatesFS provides the billy filesystem to be used for Git Alternates.
 	// If none is provided, it falls back to using the underlying instance used for
 	// DotGit.
 	AlternatesFS billy.Filesystem
+	// LockTimeout is how long to keep trying to lock a reference, or the
+	// packed-refs file, locked by another process. If left unset or set to
+	// 0, dotgit.DefaultLockTimeout is used.
+	LockTimeout time.Duration
 }
 
 // NewStorage returns a new Storage backed by a given `fs.Filesystem` and cache.
@@ -49,11 +88,15 @@ func NewStorage(fs billy.Filesystem, cache cache.Object) *Storage {
 }
 
 // NewStorageWithOptions returns a new Storage with extra options,
-// backed by a given `fs.Filesystem` and cache.
+// backed by a given `fs.Filesystem` and cache. Any cache.Object
+// implementation can be given, if cache is nil a cache.ObjectLRU sized
+// by the options is used.
 func NewStorageWithOptions(fs billy.Filesystem, cache cache.Object, ops Options) *Storage {
 	dirOps := dotgit.Options{
-		ExclusiveAccess: ops.ExclusiveAccess,
-		AlternatesFS:    ops.AlternatesFS,
+		ExclusiveAccess:      ops.ExclusiveAccess,
+		LargeObjectThreshold: ops.LargeObjectThreshold,
+		AlternatesFS:         ops.AlternatesFS,
+		LockTimeout:          ops.LockTimeout,
 	}
 	dir := dotgit.NewWithOptions(fs, dirOps)
 
@@ -62,14 +105,27 @@ func NewStorageWithOptions(fs billy.Filesystem, cache cache.Object, ops Options)
 		dir: dir,
 
 		ObjectStorage:    *NewObjectStorageWithOptions(dir, cache, ops),
-		ReferenceStorage: ReferenceStorage{dir: dir},
+		ReferenceStorage: ReferenceStorage{refs: newRefsBackend(dir, ops)},
 		IndexStorage:     IndexStorage{dir: dir},
 		ShallowStorage:   ShallowStorage{dir: dir},
+		FetchHeadStorage: FetchHeadStorage{dir: dir},
 		ConfigStorage:    ConfigStorage{dir: dir},
 		ModuleStorage:    ModuleStorage{dir: dir},
 	}
 }
 
+// newRefsBackend returns where the references of the repository are stored,
+// in the reftable directory if its extensions.refStorage option is set to
+// reftable, or as loose files and in the packed-refs file.
+func newRefsBackend(dir *dotgit.DotGit, ops Options) refsBackend {
+	cfg, err := (&ConfigStorage{dir: dir}).Config()
+	if err == nil && cfg.Extensions.RefStorage == formatcfg.RefStorageReftable {
+		return newReftableRefs(dir, ops)
+	}
+
+	return dir
+}
+
 // Filesystem returns the underlying filesystem
 func (s *Storage) Filesystem() billy.Filesystem {
 	return s.fs
@@ -80,6 +136,95 @@ func (s *Storage) Init() error {
 	return s.dir.Initialize()
 }
 
+// PackReferences packs the loose references, writing the objects pointed to
+// by the annotated tags to the packed-refs file.
+func (s *Storage) PackReferences(all, prune bool) error {
+	return s.refs.PackRefsWithOptions(dotgit.PackRefsOptions{
+		All:   all,
+		Prune: prune,
+		Peel:  s.peel,
+	})
+}
+
+// peel returns the object pointed to by the annotated tag h, following the
+// nested tags, or plumbing.ZeroHash if h isn't a tag.
+func (s *Storage) peel(h plumbing.Hash) (plumbing.Hash, error) {
+	peeled := plumbing.ZeroHash
+	for {
+		obj, err := s.EncodedObject(plumbing.TagObject, h)
+		if err == plumbing.ErrObjectNotFound {
+			return peeled, nil
+		}
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if h, err = tagTarget(obj); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		peeled = h
+	}
+}
+
+// tagTarget reads the object header of the tag, the first line of it.
+func tagTarget(obj plumbing.EncodedObject) (h plumbing.Hash, err error) {
+	r, err := obj.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer ioutil.CheckClose(r, &err)
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return plumbing.ZeroHash, err
+	}
+
+	target, ok := strings.CutPrefix(strings.TrimSuffix(line, "\n"), "object ")
+	if !ok || !plumbing.IsHash(target) {
+		return plumbing.ZeroHash, fmt.Errorf("malformed tag %s", obj.Hash())
+	}
+
+	return plumbing.NewHash(target), nil
+}
+
 func (s *Storage) AddAlternate(remote string) error {
 	return s.dir.AddAlternate(remote)
 }
+
+// RemoveAlternates removes the objects/info/alternates file, the objects
+// borrowed from the alternates must have been copied in before.
+func (s *Storage) RemoveAlternates() error {
+	return s.dir.RemoveAlternates()
+}
+
+// AlternateReferences returns the hash references of the repositories of the
+// object directories in objects/info/alternates. Their objects are available
+// to this storage, so git uses them as haves when fetching.
+func (s *Storage) AlternateReferences() ([]*plumbing.Reference, error) {
+	dotgits, err := s.dir.Alternates()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var refs []*plumbing.Reference
+	for _, dg := range dotgits {
+		// The alternate may be an object directory without references.
+		alt, err := dg.Refs()
+		if err != nil {
+			continue
+		}
+
+		for _, ref := range alt {
+			if ref.Type() == plumbing.HashReference {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	return refs, nil
+}
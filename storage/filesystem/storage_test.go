@@ -80,3 +80,30 @@ func (s *StorageExclusiveSuite) SetUpTest(c *C) {
 
 	setUpTest(&s.StorageSuite, c, storage)
 }
+
+type StorageReftableSuite struct {
+	StorageSuite
+}
+
+var _ = Suite(&StorageReftableSuite{})
+
+func (s *StorageReftableSuite) SetUpTest(c *C) {
+	tmp, err := util.TempDir(osfs.Default, "", "go-git-filestystem-reftable")
+	c.Assert(err, IsNil)
+
+	s.dir = tmp
+	s.fs = osfs.New(s.dir)
+
+	err = util.WriteFile(s.fs, "config", []byte(reftableConfig), 0644)
+	c.Assert(err, IsNil)
+
+	storage := NewStorage(s.fs, cache.NewObjectLRUDefault())
+	setUpTest(&s.StorageSuite, c, storage)
+}
+
+func (s *StorageReftableSuite) TestNewStorageShouldNotAddAnyContentsToDir(c *C) {
+	fis, err := s.fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(fis, HasLen, 1)
+	c.Assert(fis[0].Name(), Equals, "config")
+}
@@ -0,0 +1,277 @@
+package memory
+
+import (
+	"io"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// ObjectUsage is the number and size of some objects.
+type ObjectUsage struct {
+	// Count is the number of objects.
+	Count int
+	// Size is the total size of the content of the objects, in bytes.
+	Size int64
+}
+
+func (u *ObjectUsage) add(size int64) {
+	u.Count++
+	u.Size += size
+}
+
+// Usage is the usage of a Storage by its encoded objects.
+type Usage struct {
+	// Memory is the usage of the objects kept in memory, by type.
+	Memory map[plumbing.ObjectType]ObjectUsage
+	// Spilled is the usage of the objects written to the secondary storer of
+	// a BoundedStorage, once its limit was reached.
+	Spilled ObjectUsage
+}
+
+// Usage returns the number and size of the objects kept in memory, by type.
+func (o *ObjectStorage) Usage() Usage {
+	u := Usage{Memory: make(map[plumbing.ObjectType]ObjectUsage)}
+	for _, obj := range o.Objects {
+		t := u.Memory[obj.Type()]
+		t.add(obj.Size())
+		u.Memory[obj.Type()] = t
+	}
+
+	return u
+}
+
+// SpillStorer is the storer the objects of a BoundedStorage are written to,
+// once its limit is reached.
+type SpillStorer interface {
+	SetEncodedObject(plumbing.EncodedObject) (plumbing.Hash, error)
+	EncodedObject(plumbing.ObjectType, plumbing.Hash) (plumbing.EncodedObject, error)
+	IterEncodedObjects(plumbing.ObjectType) (storer.EncodedObjectIter, error)
+	HasEncodedObject(plumbing.Hash) error
+	EncodedObjectSize(plumbing.Hash) (int64, error)
+	ForEachObjectHash(func(plumbing.Hash) error) error
+}
+
+// BoundedStorage is a Storage keeping the encoded objects in memory until
+// their size reaches a limit, the next ones being written to a secondary
+// storer, such as a temporary directory. It allows to clone a repository too
+// large to fit in memory for a short-lived use. The objects are read from
+// both, transparently.
+//
+// Close must be called once the storage isn't used anymore, to remove the
+// objects written to the filesystem.
+type BoundedStorage struct {
+	*Storage
+
+	limit   int64
+	used    int64
+	spill   SpillStorer
+	spilled ObjectUsage
+	cleanup func() error
+}
+
+// NewBoundedStorage returns a BoundedStorage keeping up to limit bytes of
+// objects in memory, the content of the next objects being written to the
+// given filesystem, or to a new temporary directory if it is nil. The objects
+// written are removed on Close.
+func NewBoundedStorage(limit int64, spill billy.Filesystem) (*BoundedStorage, error) {
+	var dir string
+	if spill == nil {
+		var err error
+		if dir, err = os.MkdirTemp("", "go-git-storage-"); err != nil {
+			return nil, err
+		}
+
+		spill = osfs.New(dir)
+	}
+
+	s := newFSSpillStorer(spill)
+	b := NewBoundedStorageWithStorer(limit, s)
+	b.cleanup = func() error {
+		if err := s.Close(); err != nil {
+			return err
+		}
+
+		if dir == "" {
+			return nil
+		}
+
+		return os.RemoveAll(dir)
+	}
+
+	return b, nil
+}
+
+// NewBoundedStorageWithStorer returns a BoundedStorage keeping up to limit
+// bytes of objects in memory, the next objects being written to the given
+// storer. The storer is closed on Close if it is an io.Closer.
+func NewBoundedStorageWithStorer(limit int64, spill SpillStorer) *BoundedStorage {
+	b := &BoundedStorage{
+		Storage: NewStorage(),
+		limit:   limit,
+		spill:   spill,
+	}
+
+	if c, ok := spill.(io.Closer); ok {
+		b.cleanup = c.Close
+	}
+
+	return b
+}
+
+// Usage returns the number and size of the objects kept in memory, by type,
+// and of the objects written to the secondary storer.
+func (s *BoundedStorage) Usage() Usage {
+	u := s.ObjectStorage.Usage()
+	u.Spilled = s.spilled
+	return u
+}
+
+// Close releases the secondary storer, removing the objects written to the
+// filesystem by a storage returned by NewBoundedStorage.
+func (s *BoundedStorage) Close() error {
+	if s.cleanup == nil {
+		return nil
+	}
+
+	cleanup := s.cleanup
+	s.cleanup = nil
+	return cleanup()
+}
+
+// SetEncodedObject stores the object in memory, or in the secondary storer
+// if its size would exceed the limit.
+func (s *BoundedStorage) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	h := obj.Hash()
+	if s.HasEncodedObject(h) == nil {
+		return h, nil
+	}
+
+	size := obj.Size()
+	if s.used+size <= s.limit {
+		if _, err := s.ObjectStorage.SetEncodedObject(obj); err != nil {
+			return h, err
+		}
+
+		s.used += size
+		return h, nil
+	}
+
+	switch obj.Type() {
+	case plumbing.CommitObject, plumbing.TreeObject, plumbing.BlobObject, plumbing.TagObject:
+	default:
+		return h, ErrUnsupportedObjectType
+	}
+
+	if _, err := s.spill.SetEncodedObject(obj); err != nil {
+		return h, err
+	}
+
+	s.spilled.add(size)
+	return h, nil
+}
+
+func (s *BoundedStorage) HasEncodedObject(h plumbing.Hash) error {
+	if err := s.ObjectStorage.HasEncodedObject(h); err != plumbing.ErrObjectNotFound {
+		return err
+	}
+
+	return s.spill.HasEncodedObject(h)
+}
+
+func (s *BoundedStorage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := s.ObjectStorage.EncodedObjectSize(h)
+	if err != plumbing.ErrObjectNotFound {
+		return size, err
+	}
+
+	return s.spill.EncodedObjectSize(h)
+}
+
+func (s *BoundedStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.ObjectStorage.EncodedObject(t, h)
+	if err != plumbing.ErrObjectNotFound {
+		return obj, err
+	}
+
+	return s.spill.EncodedObject(t, h)
+}
+
+func (s *BoundedStorage) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	mem, err := s.ObjectStorage.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	spilled, err := s.spill.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return storer.NewMultiEncodedObjectIter([]storer.EncodedObjectIter{mem, spilled}), nil
+}
+
+func (s *BoundedStorage) ForEachObjectHash(fun func(plumbing.Hash) error) error {
+	stopped := false
+	err := s.ObjectStorage.ForEachObjectHash(func(h plumbing.Hash) error {
+		err := fun(h)
+		if err == storer.ErrStop {
+			stopped = true
+		}
+
+		return err
+	})
+	if err != nil || stopped {
+		return err
+	}
+
+	return s.spill.ForEachObjectHash(fun)
+}
+
+func (s *BoundedStorage) Begin() storer.Transaction {
+	return &boundedTx{
+		s:       s,
+		objects: make(map[plumbing.Hash]plumbing.EncodedObject),
+	}
+}
+
+// boundedTx is a transaction of a BoundedStorage, whose objects are stored
+// with the limit on commit.
+type boundedTx struct {
+	s       *BoundedStorage
+	objects map[plumbing.Hash]plumbing.EncodedObject
+}
+
+func (tx *boundedTx) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	h := obj.Hash()
+	tx.objects[h] = obj
+	return h, nil
+}
+
+func (tx *boundedTx) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, ok := tx.objects[h]
+	if !ok || (plumbing.AnyObject != t && obj.Type() != t) {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	return obj, nil
+}
+
+func (tx *boundedTx) Commit() error {
+	for h, obj := range tx.objects {
+		delete(tx.objects, h)
+		if _, err := tx.s.SetEncodedObject(obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tx *boundedTx) Rollback() error {
+	tx.objects = make(map[plumbing.Hash]plumbing.EncodedObject)
+	return nil
+}
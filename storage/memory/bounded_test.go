@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/test"
+	. "gopkg.in/check.v1"
+)
+
+type BoundedStorageSuite struct {
+	test.BaseStorageSuite
+	s *BoundedStorage
+}
+
+var _ = Suite(&BoundedStorageSuite{})
+
+func (s *BoundedStorageSuite) SetUpTest(c *C) {
+	var err error
+	s.s, err = NewBoundedStorage(16, memfs.New())
+	c.Assert(err, IsNil)
+	s.BaseStorageSuite = test.NewBaseStorageSuite(s.s)
+}
+
+func (s *BoundedStorageSuite) TearDownTest(c *C) {
+	c.Assert(s.s.Close(), IsNil)
+}
+
+func newBlob(content string) plumbing.EncodedObject {
+	obj := &plumbing.MemoryObject{}
+	obj.SetType(plumbing.BlobObject)
+	obj.Write([]byte(content))
+	return obj
+}
+
+func (s *BoundedStorageSuite) TestSpill(c *C) {
+	small := newBlob("small")
+	large := newBlob("larger than the limit")
+
+	for _, obj := range []plumbing.EncodedObject{small, large, small} {
+		_, err := s.s.SetEncodedObject(obj)
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(s.s.ObjectStorage.Objects, HasLen, 1)
+	c.Assert(s.s.HasEncodedObject(large.Hash()), IsNil)
+
+	obj, err := s.s.EncodedObject(plumbing.BlobObject, large.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(obj.Hash(), Equals, large.Hash())
+
+	size, err := s.s.EncodedObjectSize(large.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, large.Size())
+
+	var hashes []plumbing.Hash
+	err = s.s.ForEachObjectHash(func(h plumbing.Hash) error {
+		hashes = append(hashes, h)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(hashes, HasLen, 2)
+
+	u := s.s.Usage()
+	c.Assert(u.Memory[plumbing.BlobObject], Equals, ObjectUsage{Count: 1, Size: small.Size()})
+	c.Assert(u.Spilled, Equals, ObjectUsage{Count: 1, Size: large.Size()})
+}
+
+func (s *BoundedStorageSuite) TestClose(c *C) {
+	fs := memfs.New()
+	b, err := NewBoundedStorage(0, fs)
+	c.Assert(err, IsNil)
+
+	_, err = b.SetEncodedObject(newBlob("content"))
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat("objects")
+	c.Assert(err, IsNil)
+
+	c.Assert(b.Close(), IsNil)
+	_, err = fs.Stat("objects")
+	c.Assert(err, NotNil)
+}
+
+func (s *BoundedStorageSuite) TestTemporaryDirectory(c *C) {
+	b, err := NewBoundedStorage(0, nil)
+	c.Assert(err, IsNil)
+
+	obj := newBlob("content")
+	_, err = b.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+	c.Assert(b.HasEncodedObject(obj.Hash()), IsNil)
+	c.Assert(b.Close(), IsNil)
+}
@@ -0,0 +1,179 @@
+package memory
+
+import (
+	"compress/zlib"
+	"io"
+	"path"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// spillObjectsPath is the directory the objects are written to by a
+// fsSpillStorer.
+const spillObjectsPath = "objects"
+
+// fsSpillStorer is a SpillStorer writing the content of the objects,
+// compressed, to a billy.Filesystem. The type and size of the objects are
+// kept in memory, only their content is read back from the filesystem.
+type fsSpillStorer struct {
+	fs      billy.Filesystem
+	objects map[plumbing.Hash]spilledObject
+}
+
+type spilledObject struct {
+	typ  plumbing.ObjectType
+	size int64
+}
+
+func newFSSpillStorer(fs billy.Filesystem) *fsSpillStorer {
+	return &fsSpillStorer{
+		fs:      fs,
+		objects: make(map[plumbing.Hash]spilledObject),
+	}
+}
+
+func (s *fsSpillStorer) objectPath(h plumbing.Hash) string {
+	hex := h.String()
+	return path.Join(spillObjectsPath, hex[:2], hex[2:])
+}
+
+func (s *fsSpillStorer) SetEncodedObject(obj plumbing.EncodedObject) (h plumbing.Hash, err error) {
+	h = obj.Hash()
+	if _, ok := s.objects[h]; ok {
+		return h, nil
+	}
+
+	r, err := obj.Reader()
+	if err != nil {
+		return h, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	f, err := s.fs.Create(s.objectPath(h))
+	if err != nil {
+		return h, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	zw := zlib.NewWriter(f)
+	if _, err = io.Copy(zw, r); err != nil {
+		return h, err
+	}
+
+	if err = zw.Close(); err != nil {
+		return h, err
+	}
+
+	s.objects[h] = spilledObject{typ: obj.Type(), size: obj.Size()}
+	return h, nil
+}
+
+func (s *fsSpillStorer) HasEncodedObject(h plumbing.Hash) error {
+	if _, ok := s.objects[h]; !ok {
+		return plumbing.ErrObjectNotFound
+	}
+
+	return nil
+}
+
+func (s *fsSpillStorer) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	o, ok := s.objects[h]
+	if !ok {
+		return 0, plumbing.ErrObjectNotFound
+	}
+
+	return o.size, nil
+}
+
+func (s *fsSpillStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (obj plumbing.EncodedObject, err error) {
+	o, ok := s.objects[h]
+	if !ok || (plumbing.AnyObject != t && o.typ != t) {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	f, err := s.fs.Open(s.objectPath(h))
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(zr, &err)
+
+	mo := &plumbing.MemoryObject{}
+	mo.SetType(o.typ)
+	if _, err = io.Copy(mo, zr); err != nil {
+		return nil, err
+	}
+
+	return mo, nil
+}
+
+func (s *fsSpillStorer) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	var hashes []plumbing.Hash
+	for h, o := range s.objects {
+		if t == plumbing.AnyObject || o.typ == t {
+			hashes = append(hashes, h)
+		}
+	}
+
+	return &spillIter{s: s, t: t, hashes: hashes}, nil
+}
+
+func (s *fsSpillStorer) ForEachObjectHash(fun func(plumbing.Hash) error) error {
+	for h := range s.objects {
+		if err := fun(h); err != nil {
+			if err == storer.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close removes the objects written to the filesystem.
+func (s *fsSpillStorer) Close() error {
+	s.objects = make(map[plumbing.Hash]spilledObject)
+	return util.RemoveAll(s.fs, spillObjectsPath)
+}
+
+// spillIter is an iterator over the objects of a fsSpillStorer, read one at
+// a time.
+type spillIter struct {
+	s      *fsSpillStorer
+	t      plumbing.ObjectType
+	hashes []plumbing.Hash
+	pos    int
+}
+
+func (it *spillIter) Next() (plumbing.EncodedObject, error) {
+	if it.pos >= len(it.hashes) {
+		return nil, io.EOF
+	}
+
+	h := it.hashes[it.pos]
+	it.pos++
+	return it.s.EncodedObject(it.t, h)
+}
+
+func (it *spillIter) ForEach(cb func(plumbing.EncodedObject) error) error {
+	return storer.ForEachIterator(it, cb)
+}
+
+func (it *spillIter) Close() {
+	it.pos = len(it.hashes)
+}
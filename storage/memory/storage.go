@@ -22,6 +22,7 @@ type Storage struct {
 	ConfigStorage
 	ObjectStorage
 	ShallowStorage
+	FetchHeadStorage
 	IndexStorage
 	ReferenceStorage
 	ModuleStorage
@@ -53,6 +54,12 @@ func (c *ConfigStorage) SetConfig(cfg *config.Config) error {
 		return err
 	}
 
+	// The fields are stored in the Raw content, as they are when the config
+	// is written to a file, since the config is edited through it.
+	if _, err := cfg.Marshal(); err != nil {
+		return err
+	}
+
 	c.config = cfg
 	return nil
 }
@@ -299,6 +306,34 @@ func (r ReferenceStorage) RemoveReference(n plumbing.ReferenceName) error {
 	return nil
 }
 
+func (r ReferenceStorage) BeginReferenceTransaction() storer.ReferenceTransaction {
+	return storer.NewReferenceTransaction(r.updateReferences)
+}
+
+func (r ReferenceStorage) updateReferences(updates []storer.ReferenceUpdate) error {
+	errs := make(map[plumbing.ReferenceName]error)
+	for _, u := range updates {
+		if !u.Matches(r[u.Name]) {
+			errs[u.Name] = storage.ErrReferenceHasChanged
+		}
+	}
+
+	if len(errs) != 0 {
+		return &storer.ReferenceTransactionError{Errors: errs}
+	}
+
+	for _, u := range updates {
+		if u.New.IsZero() {
+			delete(r, u.Name)
+			continue
+		}
+
+		r[u.Name] = plumbing.NewHashReference(u.Name, u.New)
+	}
+
+	return nil
+}
+
 type ShallowStorage []plumbing.Hash
 
 func (s *ShallowStorage) SetShallow(commits []plumbing.Hash) error {
@@ -310,6 +345,17 @@ func (s ShallowStorage) Shallow() ([]plumbing.Hash, error) {
 	return s, nil
 }
 
+type FetchHeadStorage []storer.FetchHead
+
+func (s *FetchHeadStorage) SetFetchHead(heads []storer.FetchHead) error {
+	*s = heads
+	return nil
+}
+
+func (s FetchHeadStorage) FetchHead() ([]storer.FetchHead, error) {
+	return s, nil
+}
+
 type ModuleStorage map[string]*Storage
 
 func (s ModuleStorage) Module(name string) (storage.Storer, error) {
@@ -0,0 +1,73 @@
+// Package namespace implements a storage scoped to a git ref namespace, as
+// GIT_NAMESPACE. Several logical repositories share the objects of one
+// repository, each one having its references under refs/namespaces/<name>/.
+package namespace
+
+import (
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// Storage is a storage.Storer whose references are stored in the namespace
+// of the base storage. The references are read and written without the
+// prefix of the namespace, the ones outside of it are hidden. The objects,
+// the configuration, the index and the shallow commits are the ones of the
+// base storage.
+type Storage struct {
+	storage.Storer
+	refs storer.ReferenceStorer
+}
+
+// packfileWriter implements storer.PackfileWriter over a Storage with a base
+// storage that supports it.
+type packfileWriter struct {
+	*Storage
+	pw storer.PackfileWriter
+}
+
+// NewStorage returns a new Storage of the given namespace of s.
+func NewStorage(s storage.Storer, namespace string) storage.Storer {
+	st := &Storage{
+		Storer: s,
+		refs:   storer.NewNamespacedReferenceStorer(s, namespace),
+	}
+
+	if pw, ok := s.(storer.PackfileWriter); ok {
+		return &packfileWriter{Storage: st, pw: pw}
+	}
+
+	return st
+}
+
+// SetReference honors the storer.ReferenceStorer interface.
+func (s *Storage) SetReference(ref *plumbing.Reference) error {
+	return s.refs.SetReference(ref)
+}
+
+// CheckAndSetReference honors the storer.ReferenceStorer interface.
+func (s *Storage) CheckAndSetReference(new, old *plumbing.Reference) error {
+	return s.refs.CheckAndSetReference(new, old)
+}
+
+// Reference honors the storer.ReferenceStorer interface.
+func (s *Storage) Reference(n plumbing.ReferenceName) (*plumbing.Reference, error) {
+	return s.refs.Reference(n)
+}
+
+// IterReferences honors the storer.ReferenceStorer interface.
+func (s *Storage) IterReferences() (storer.ReferenceIter, error) {
+	return s.refs.IterReferences()
+}
+
+// RemoveReference honors the storer.ReferenceStorer interface.
+func (s *Storage) RemoveReference(n plumbing.ReferenceName) error {
+	return s.refs.RemoveReference(n)
+}
+
+// PackfileWriter honors storer.PackfileWriter.
+func (s *packfileWriter) PackfileWriter() (io.WriteCloser, error) {
+	return s.pw.PackfileWriter()
+}
@@ -0,0 +1,76 @@
+package namespace
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type StorageSuite struct{}
+
+var _ = Suite(&StorageSuite{})
+
+func (s *StorageSuite) TestReferences(c *C) {
+	base := memory.NewStorage()
+	outside := plumbing.NewHashReference("refs/heads/master", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(base.SetReference(outside), IsNil)
+
+	st := NewStorage(base, "foo")
+	_, err := st.Reference("refs/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	h := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+	c.Assert(st.SetReference(plumbing.NewHashReference("refs/heads/master", h)), IsNil)
+	c.Assert(st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/master")), IsNil)
+
+	ref, err := base.Reference("refs/namespaces/foo/refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, h)
+
+	ref, err = base.Reference("refs/namespaces/foo/HEAD")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Target(), Equals, plumbing.ReferenceName("refs/namespaces/foo/refs/heads/master"))
+
+	ref, err = storer.ResolveReference(st, plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Name(), Equals, plumbing.ReferenceName("refs/heads/master"))
+	c.Assert(ref.Hash(), Equals, h)
+
+	iter, err := st.IterReferences()
+	c.Assert(err, IsNil)
+	var names []string
+	c.Assert(iter.ForEach(func(ref *plumbing.Reference) error {
+		names = append(names, ref.Name().String())
+		return nil
+	}), IsNil)
+	c.Assert(names, HasLen, 2)
+
+	c.Assert(st.CheckAndSetReference(
+		plumbing.NewHashReference("refs/heads/master", outside.Hash()),
+		plumbing.NewHashReference("refs/heads/master", h),
+	), IsNil)
+
+	c.Assert(st.RemoveReference("refs/heads/master"), IsNil)
+	_, err = base.Reference("refs/namespaces/foo/refs/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	ref, err = base.Reference("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, outside)
+}
+
+func (s *StorageSuite) TestNestedNamespace(c *C) {
+	base := memory.NewStorage()
+	st := NewStorage(base, "foo/bar")
+
+	h := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+	c.Assert(st.SetReference(plumbing.NewHashReference("refs/heads/master", h)), IsNil)
+
+	_, err := base.Reference("refs/namespaces/foo/refs/namespaces/bar/refs/heads/master")
+	c.Assert(err, IsNil)
+}
@@ -377,6 +377,78 @@ func (s *BaseStorageSuite) TestIterReferences(c *C) {
 	c.Assert(err, Equals, io.EOF)
 }
 
+func (s *BaseStorageSuite) TestReferenceTransaction(c *C) {
+	rt, ok := s.Storer.(storer.ReferenceTransactioner)
+	if !ok {
+		c.Skip("not a storer.ReferenceTransactioner")
+	}
+
+	foo := plumbing.NewReferenceFromStrings("refs/heads/foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa")
+	bar := plumbing.NewReferenceFromStrings("refs/heads/bar", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+	c.Assert(s.Storer.SetReference(foo), IsNil)
+	c.Assert(s.Storer.SetReference(bar), IsNil)
+
+	newHash := plumbing.NewHash("c3f4688a08fd86f1bf8e055724c84b7a40a09733")
+	tx := rt.BeginReferenceTransaction()
+	tx.Update(foo.Name(), newHash, foo.Hash())
+	tx.Delete(bar.Name(), bar.Hash())
+	tx.Create("refs/heads/qux", newHash)
+	c.Assert(tx.Commit(), IsNil)
+
+	r, err := s.Storer.Reference(foo.Name())
+	c.Assert(err, IsNil)
+	c.Assert(r.Hash(), Equals, newHash)
+
+	_, err = s.Storer.Reference(bar.Name())
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	r, err = s.Storer.Reference("refs/heads/qux")
+	c.Assert(err, IsNil)
+	c.Assert(r.Hash(), Equals, newHash)
+}
+
+func (s *BaseStorageSuite) TestReferenceTransactionError(c *C) {
+	rt, ok := s.Storer.(storer.ReferenceTransactioner)
+	if !ok {
+		c.Skip("not a storer.ReferenceTransactioner")
+	}
+
+	foo := plumbing.NewReferenceFromStrings("refs/heads/foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa")
+	bar := plumbing.NewReferenceFromStrings("refs/heads/bar", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+	c.Assert(s.Storer.SetReference(foo), IsNil)
+	c.Assert(s.Storer.SetReference(bar), IsNil)
+
+	newHash := plumbing.NewHash("c3f4688a08fd86f1bf8e055724c84b7a40a09733")
+	tx := rt.BeginReferenceTransaction()
+	tx.Update(foo.Name(), newHash, foo.Hash())
+	tx.Delete(bar.Name(), newHash)
+	tx.Create("refs/heads/qux", newHash)
+	err := tx.Commit()
+
+	var txErr *storer.ReferenceTransactionError
+	c.Assert(errors.As(err, &txErr), Equals, true)
+	c.Assert(txErr.Errors, HasLen, 1)
+	c.Assert(txErr.Errors[bar.Name()], Equals, storage.ErrReferenceHasChanged)
+
+	// None of the updates was applied.
+	r, err := s.Storer.Reference(foo.Name())
+	c.Assert(err, IsNil)
+	c.Assert(r.Hash(), Equals, foo.Hash())
+
+	r, err = s.Storer.Reference(bar.Name())
+	c.Assert(err, IsNil)
+	c.Assert(r.Hash(), Equals, bar.Hash())
+
+	_, err = s.Storer.Reference("refs/heads/qux")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	tx = rt.BeginReferenceTransaction()
+	tx.Create(foo.Name(), newHash)
+	err = tx.Commit()
+	c.Assert(errors.As(err, &txErr), Equals, true)
+	c.Assert(txErr.Errors[foo.Name()], Equals, storage.ErrReferenceHasChanged)
+}
+
 func (s *BaseStorageSuite) TestSetShallowAndShallow(c *C) {
 	expected := []plumbing.Hash{
 		plumbing.NewHash("b66c08ba28aa1f81eb06a1127aa3936ff77e5e2c"),
@@ -408,6 +480,7 @@ func (s *BaseStorageSuite) TestSetConfigAndConfig(c *C) {
 
 	c.Assert(cfg.Core.IsBare, DeepEquals, expected.Core.IsBare)
 	c.Assert(cfg.Remotes, DeepEquals, expected.Remotes)
+	c.Assert(cfg.Raw.Section("remote").Subsection("foo").Option("url"), Equals, "http://foo/bar.git")
 }
 
 func (s *BaseStorageSuite) TestIndex(c *C) {
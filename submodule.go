@@ -6,17 +6,25 @@ import (
 	"errors"
 	"fmt"
 	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 )
 
 var (
 	ErrSubmoduleAlreadyInitialized = errors.New("submodule already initialized")
 	ErrSubmoduleNotInitialized     = errors.New("submodule not initialized")
+	ErrSubmoduleAlreadyExists      = errors.New("submodule already exists")
+	ErrSubmoduleModified           = errors.New("submodule contains local modifications")
 )
 
 // Submodule a submodule allows you to keep another Git repository in a
@@ -27,6 +35,13 @@ type Submodule struct {
 
 	c *config.Submodule
 	w *Worktree
+
+	// ignore is the ignore setting of the .gitmodules file.
+	ignore string
+	// shallow is the shallow setting of the .gitmodules file.
+	shallow bool
+	// repository is the repository of the submodule, once opened.
+	repository *Repository
 }
 
 // Config returns the submodule config
@@ -53,6 +68,203 @@ func (s *Submodule) Init() error {
 	return s.w.r.Storer.SetConfig(cfg)
 }
 
+// Deinit unregisters the submodule, as git submodule deinit does: its
+// worktree is emptied and its config removed, while its repository is kept
+// in the git directory of the superproject, for a later Init and Update to
+// restore it. It returns ErrSubmoduleModified if the worktree of the
+// submodule has changes to tracked files, unless force is set.
+func (s *Submodule) Deinit(force bool) error {
+	if !s.initialized {
+		return ErrSubmoduleNotInitialized
+	}
+
+	r, err := s.existingRepository()
+	if err != nil {
+		return err
+	}
+
+	if r != nil && !force {
+		if err := checkSubmoduleClean(r); err != nil {
+			return err
+		}
+	}
+
+	if err := util.RemoveAll(s.w.Filesystem, s.c.Path); err != nil {
+		return err
+	}
+
+	s.repository = nil
+
+	if err := s.w.Filesystem.MkdirAll(s.c.Path, 0o755); err != nil {
+		return err
+	}
+
+	err = s.w.r.editConfig(config.LocalScope, func(cfg *config.Config) error {
+		delete(cfg.Submodules, s.c.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.initialized = false
+	return nil
+}
+
+// checkSubmoduleClean returns ErrSubmoduleModified if the worktree of the
+// repository has changes to tracked files.
+func checkSubmoduleClean(r *Repository) error {
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range status {
+		if file.Worktree != Untracked || file.Staging != Untracked {
+			return ErrSubmoduleModified
+		}
+	}
+
+	return nil
+}
+
+// existingRepository returns the repository of the submodule, nil if it
+// wasn't cloned yet.
+func (s *Submodule) existingRepository() (*Repository, error) {
+	if s.repository != nil {
+		return s.repository, nil
+	}
+
+	storer, err := s.w.r.Storer.Module(s.c.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := storer.Reference(plumbing.HEAD); err != nil {
+		if err == plumbing.ErrReferenceNotFound {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	worktree, err := s.w.Filesystem.Chroot(s.c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := Open(storer, worktree)
+	if err != nil {
+		return nil, err
+	}
+
+	s.repository = r
+	return r, nil
+}
+
+// SyncURL copies the URL of the submodule in the .gitmodules file to the
+// config, and to the origin remote of the submodule repository, rewritten by
+// the url.<base>.insteadOf rules of the superproject, as git submodule sync
+// does. Nothing is changed for a submodule not initialized.
+func (s *Submodule) SyncURL() error {
+	m, err := s.w.readGitmodulesFile()
+	if err != nil {
+		return err
+	}
+
+	if m == nil || m.Submodules[s.c.Name] == nil {
+		return ErrSubmoduleNotFound
+	}
+
+	if !s.initialized {
+		return nil
+	}
+
+	url, err := s.w.resolveSubmoduleURL(m.Submodules[s.c.Name].URL)
+	if err != nil {
+		return err
+	}
+
+	var cfg *config.Config
+	err = s.w.r.editConfig(config.LocalScope, func(c *config.Config) error {
+		cfg = c
+		if sub, ok := c.Submodules[s.c.Name]; ok {
+			sub.URL = url
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.c.URL = url
+
+	r, err := s.existingRepository()
+	if err != nil || r == nil {
+		return err
+	}
+
+	return r.editConfig(config.LocalScope, func(c *config.Config) error {
+		if remote, ok := c.Remotes[DefaultRemoteName]; ok {
+			remote.URLs = []string{cfg.ApplyInsteadOf(url)}
+		}
+
+		return nil
+	})
+}
+
+// SetURL sets the URL of the submodule in the .gitmodules file, keeping its
+// formatting, and synchronizes it as SyncURL does, as git submodule set-url
+// does. The .gitmodules file isn't staged.
+func (s *Submodule) SetURL(url string) error {
+	err := s.w.editGitmodules(func(m *config.Modules) error {
+		sub, ok := m.Submodules[s.c.Name]
+		if !ok {
+			return ErrSubmoduleNotFound
+		}
+
+		sub.URL = url
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !s.initialized {
+		s.c.URL = url
+	}
+
+	return s.SyncURL()
+}
+
+// SetBranch sets the branch tracked by the submodule in the .gitmodules file,
+// keeping its formatting, as git submodule set-branch does. An empty branch
+// removes it, for the remote HEAD to be tracked. The .gitmodules file isn't
+// staged.
+func (s *Submodule) SetBranch(branch string) error {
+	err := s.w.editGitmodules(func(m *config.Modules) error {
+		sub, ok := m.Submodules[s.c.Name]
+		if !ok {
+			return ErrSubmoduleNotFound
+		}
+
+		sub.Branch = branch
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.c.Branch = branch
+	return nil
+}
+
 // Status returns the status of the submodule.
 func (s *Submodule) Status() (*SubmoduleStatus, error) {
 	idx, err := s.w.r.Storer.Index()
@@ -63,6 +275,50 @@ func (s *Submodule) Status() (*SubmoduleStatus, error) {
 	return s.status(idx)
 }
 
+// StatusWithOptions returns the status of the submodule, as Status does,
+// along with the changes of the content of its worktree that are not
+// ignored, as git status does.
+func (s *Submodule) StatusWithOptions(o *SubmoduleStatusOptions) (*SubmoduleStatus, error) {
+	if o == nil {
+		o = &SubmoduleStatusOptions{}
+	}
+
+	status, err := s.Status()
+	if err != nil || !s.initialized {
+		return status, err
+	}
+
+	ignore := o.Ignore
+	if ignore == "" {
+		ignore = s.ignoreSetting()
+	}
+
+	if ignore == SubmoduleIgnoreDirty || ignore == SubmoduleIgnoreAll {
+		return status, nil
+	}
+
+	r, err := s.existingRepository()
+	if err != nil || r == nil {
+		return status, err
+	}
+
+	return status, status.readContent(r, ignore, o.Recursive)
+}
+
+// ignoreSetting returns the ignore setting of the submodule, the one of the
+// config taking precedence over the one of the .gitmodules file.
+func (s *Submodule) ignoreSetting() SubmoduleIgnore {
+	if s.initialized && s.c.Ignore != "" {
+		return SubmoduleIgnore(s.c.Ignore)
+	}
+
+	if s.ignore != "" {
+		return SubmoduleIgnore(s.ignore)
+	}
+
+	return SubmoduleIgnoreNone
+}
+
 func (s *Submodule) status(idx *index.Index) (*SubmoduleStatus, error) {
 	status := &SubmoduleStatus{
 		Path: s.c.Path,
@@ -77,6 +333,7 @@ func (s *Submodule) status(idx *index.Index) (*SubmoduleStatus, error) {
 		status.Expected = e.Hash
 	}
 
+	status.Initialized = s.initialized
 	if !s.initialized {
 		return status, nil
 	}
@@ -104,30 +361,20 @@ func (s *Submodule) Repository() (*Repository, error) {
 		return nil, ErrSubmoduleNotInitialized
 	}
 
-	storer, err := s.w.r.Storer.Module(s.c.Name)
-	if err != nil {
-		return nil, err
+	if r, err := s.existingRepository(); err != nil || r != nil {
+		return r, err
 	}
 
-	_, err = storer.Reference(plumbing.HEAD)
-	if err != nil && err != plumbing.ErrReferenceNotFound {
+	storer, err := s.w.r.Storer.Module(s.c.Name)
+	if err != nil {
 		return nil, err
 	}
 
-	var exists bool
-	if err == nil {
-		exists = true
-	}
-
 	var worktree billy.Filesystem
 	if worktree, err = s.w.Filesystem.Chroot(s.c.Path); err != nil {
 		return nil, err
 	}
 
-	if exists {
-		return Open(storer, worktree)
-	}
-
 	r, err := Init(storer, worktree)
 	if err != nil {
 		return nil, err
@@ -157,8 +404,12 @@ func (s *Submodule) Repository() (*Repository, error) {
 		Name: DefaultRemoteName,
 		URLs: []string{moduleEndpoint.String()},
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return r, err
+	s.repository = r
+	return r, nil
 }
 
 // Update the registered submodule to match what the superproject expects, the
@@ -180,26 +431,37 @@ func (s *Submodule) UpdateContext(ctx context.Context, o *SubmoduleUpdateOptions
 }
 
 func (s *Submodule) update(ctx context.Context, o *SubmoduleUpdateOptions, forceHash plumbing.Hash) error {
+	r, hash, err := s.prepareUpdate(o, forceHash)
+	if err != nil {
+		return err
+	}
+
+	return s.runUpdate(ctx, r, o, hash)
+}
+
+// prepareUpdate initializes the submodule if requested and opens its
+// repository, returning it along with the commit to check out.
+func (s *Submodule) prepareUpdate(o *SubmoduleUpdateOptions, forceHash plumbing.Hash) (*Repository, plumbing.Hash, error) {
 	if !s.initialized && !o.Init {
-		return ErrSubmoduleNotInitialized
+		return nil, plumbing.ZeroHash, ErrSubmoduleNotInitialized
 	}
 
 	if !s.initialized && o.Init {
 		if err := s.Init(); err != nil {
-			return err
+			return nil, plumbing.ZeroHash, err
 		}
 	}
 
 	idx, err := s.w.r.Storer.Index()
 	if err != nil {
-		return err
+		return nil, plumbing.ZeroHash, err
 	}
 
 	hash := forceHash
 	if hash.IsZero() {
 		e, err := idx.Entry(s.c.Path)
 		if err != nil {
-			return err
+			return nil, plumbing.ZeroHash, err
 		}
 
 		hash = e.Hash
@@ -207,9 +469,13 @@ func (s *Submodule) update(ctx context.Context, o *SubmoduleUpdateOptions, force
 
 	r, err := s.Repository()
 	if err != nil {
-		return err
+		return nil, plumbing.ZeroHash, err
 	}
 
+	return r, hash, nil
+}
+
+func (s *Submodule) runUpdate(ctx context.Context, r *Repository, o *SubmoduleUpdateOptions, hash plumbing.Hash) error {
 	if err := s.fetchAndCheckout(ctx, r, o, hash); err != nil {
 		return err
 	}
@@ -236,14 +502,53 @@ func (s *Submodule) doRecursiveUpdate(ctx context.Context, r *Repository, o *Sub
 	*new = *o
 
 	new.RecurseSubmodules--
+	if o.Progress != nil {
+		new.Progress = func(p string) sideband.Progress {
+			return o.Progress(path.Join(s.c.Path, p))
+		}
+	}
+
+	if o.ProgressHandler != nil {
+		new.ProgressHandler = func(p string) ProgressHandler {
+			return o.ProgressHandler(path.Join(s.c.Path, p))
+		}
+	}
+
 	return l.UpdateContext(ctx, new)
 }
 
 func (s *Submodule) fetchAndCheckout(
 	ctx context.Context, r *Repository, o *SubmoduleUpdateOptions, hash plumbing.Hash,
 ) error {
+	depth := o.Depth
+	if depth == 0 && (s.shallow || s.c.Shallow) {
+		depth = 1
+	}
+
+	var progress sideband.Progress
+	if o.Progress != nil {
+		progress = o.Progress(s.c.Path)
+	}
+
+	var handler ProgressHandler
+	if o.ProgressHandler != nil {
+		handler = o.ProgressHandler(s.c.Path)
+	}
+
+	var remoteURL string
 	if !o.NoFetch {
-		err := r.FetchContext(ctx, &FetchOptions{Auth: o.Auth, Depth: o.Depth})
+		var err error
+		if remoteURL, err = s.fetchURL(r); err != nil {
+			return err
+		}
+
+		err = r.FetchContext(ctx, &FetchOptions{
+			Auth:            o.Auth,
+			Depth:           depth,
+			RemoteURL:       remoteURL,
+			Progress:        progress,
+			ProgressHandler: handler,
+		})
 		if err != nil && err != NoErrAlreadyUpToDate {
 			return err
 		}
@@ -263,9 +568,12 @@ func (s *Submodule) fetchAndCheckout(
 			refSpec := config.RefSpec("+" + hash.String() + ":" + hash.String())
 
 			err := r.FetchContext(ctx, &FetchOptions{
-				Auth:     o.Auth,
-				RefSpecs: []config.RefSpec{refSpec},
-				Depth:    o.Depth,
+				Auth:            o.Auth,
+				RemoteURL:       remoteURL,
+				RefSpecs:        []config.RefSpec{refSpec},
+				Depth:           depth,
+				Progress:        progress,
+				ProgressHandler: handler,
 			})
 			if err != nil && err != NoErrAlreadyUpToDate && err != ErrExactSHA1NotSupported {
 				return err
@@ -273,7 +581,7 @@ func (s *Submodule) fetchAndCheckout(
 		}
 	}
 
-	if err := w.Checkout(&CheckoutOptions{Hash: hash}); err != nil {
+	if err := w.CheckoutContext(ctx, &CheckoutOptions{Hash: hash, ProgressHandler: handler}); err != nil {
 		return err
 	}
 
@@ -281,6 +589,22 @@ func (s *Submodule) fetchAndCheckout(
 	return r.Storer.SetReference(head)
 }
 
+// fetchURL returns the url the submodule repository is fetched from, rewritten
+// by the url.<base>.insteadOf rules of the superproject.
+func (s *Submodule) fetchURL(r *Repository) (string, error) {
+	remote, err := r.Remote(DefaultRemoteName)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := s.w.r.Config()
+	if err != nil {
+		return "", err
+	}
+
+	return cfg.ApplyInsteadOf(remote.c.URLs[0]), nil
+}
+
 // Submodules list of several submodules from the same repository.
 type Submodules []*Submodule
 
@@ -305,16 +629,133 @@ func (s Submodules) Update(o *SubmoduleUpdateOptions) error {
 // The provided Context must be non-nil. If the context expires before the
 // operation is complete, an error is returned. The context only affects the
 // transport operations.
+//
+// Up to SubmoduleUpdateOptions.Jobs submodules are fetched and checked out
+// concurrently. A failure to update a submodule doesn't stop the update of
+// the others, the errors being returned as a *SubmodulesUpdateError.
 func (s Submodules) UpdateContext(ctx context.Context, o *SubmoduleUpdateOptions) error {
+	if len(s) == 0 {
+		return nil
+	}
+
+	jobs, err := s.updateJobs(o)
+	if err != nil {
+		return err
+	}
+
+	type update struct {
+		sub  *Submodule
+		r    *Repository
+		hash plumbing.Hash
+	}
+
+	// The config of the superproject and the storers of the submodules are
+	// changed by a single submodule at a time, only the fetches and the
+	// checkouts running concurrently.
+	errs := make(map[string]error)
+	var updates []update
 	for _, sub := range s {
-		if err := sub.UpdateContext(ctx, o); err != nil {
-			return err
+		r, hash, err := sub.prepareUpdate(o, plumbing.ZeroHash)
+		if err != nil {
+			errs[sub.c.Path] = err
+			continue
 		}
+
+		updates = append(updates, update{sub: sub, r: r, hash: hash})
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, jobs)
+	)
+
+	for _, u := range updates {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(u update) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := u.sub.runUpdate(ctx, u.r, o, u.hash); err != nil {
+				mu.Lock()
+				errs[u.sub.c.Path] = err
+				mu.Unlock()
+			}
+		}(u)
+	}
+
+	wg.Wait()
+
+	if len(errs) != 0 {
+		return &SubmodulesUpdateError{Errors: errs}
 	}
 
 	return nil
 }
 
+// updateJobs returns the number of submodules to update concurrently.
+func (s Submodules) updateJobs(o *SubmoduleUpdateOptions) (int, error) {
+	if o.Jobs > 0 {
+		return o.Jobs, nil
+	}
+
+	cfg, err := s[0].w.r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return 0, err
+	}
+
+	if cfg.GetString("submodule.fetchJobs") == "" {
+		return 1, nil
+	}
+
+	jobs, err := cfg.GetInt("submodule.fetchJobs")
+	if err != nil {
+		return 0, err
+	}
+
+	// As git does, zero means as many jobs as CPUs.
+	if jobs <= 0 {
+		return runtime.NumCPU(), nil
+	}
+
+	return int(jobs), nil
+}
+
+// SubmodulesUpdateError is returned when the update of some submodules
+// failed, the other submodules being updated anyway.
+type SubmodulesUpdateError struct {
+	// Errors are the errors of the submodules that failed, by path.
+	Errors map[string]error
+}
+
+func (e *SubmodulesUpdateError) Error() string {
+	paths := make([]string, 0, len(e.Errors))
+	for p := range e.Errors {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+	msgs := make([]string, len(paths))
+	for i, p := range paths {
+		msgs[i] = fmt.Sprintf("submodule %s: %s", p, e.Errors[p])
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors of the submodules, for errors.Is and errors.As.
+func (e *SubmodulesUpdateError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
 // Status returns the status of the submodules.
 func (s Submodules) Status() (SubmodulesStatus, error) {
 	var list SubmodulesStatus
@@ -360,6 +801,17 @@ type SubmoduleStatus struct {
 	Current  plumbing.Hash
 	Expected plumbing.Hash
 	Branch   plumbing.ReferenceName
+
+	// Initialized is true if the submodule is initialized.
+	Initialized bool
+	// ModifiedContent is true if the worktree of the submodule has changes
+	// to tracked files, or if a nested submodule has changes.
+	ModifiedContent bool
+	// UntrackedContent is true if the worktree of the submodule has
+	// untracked files, or if a nested submodule only has untracked files.
+	UntrackedContent bool
+	// Submodules is the status of the nested submodules, when requested.
+	Submodules SubmodulesStatus
 }
 
 // IsClean is the HEAD of the submodule is equals to the expected commit
@@ -367,6 +819,68 @@ func (s *SubmoduleStatus) IsClean() bool {
 	return s.Current == s.Expected
 }
 
+// HasNewCommits returns true if the submodule is checked out at another
+// commit than the expected one.
+func (s *SubmoduleStatus) HasNewCommits() bool {
+	return !s.Current.IsZero() && s.Current != s.Expected
+}
+
+// readContent reads the changes of the worktree of the submodule repository,
+// and the status of the nested submodules if recursive is set.
+func (s *SubmoduleStatus) readContent(r *Repository, ignore SubmoduleIgnore, recursive bool) error {
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return err
+	}
+
+	subs, err := w.Submodules()
+	if err != nil {
+		return err
+	}
+
+	ignored := make(map[string]bool)
+	for _, sub := range subs {
+		ignored[sub.c.Path] = sub.ignoreSetting() == SubmoduleIgnoreAll
+	}
+
+	for path, file := range status {
+		if file.Worktree == Untracked && file.Staging == Untracked {
+			s.UntrackedContent = s.UntrackedContent || ignore != SubmoduleIgnoreUntracked
+			continue
+		}
+
+		if !ignored[path] || file.Staging != Unmodified {
+			s.ModifiedContent = true
+		}
+	}
+
+	if !recursive {
+		return nil
+	}
+
+	for _, sub := range subs {
+		nested, err := sub.StatusWithOptions(&SubmoduleStatusOptions{Recursive: true})
+		if err != nil {
+			return err
+		}
+
+		s.Submodules = append(s.Submodules, nested)
+		switch {
+		case nested.ModifiedContent:
+			s.ModifiedContent = true
+		case nested.UntrackedContent && ignore != SubmoduleIgnoreUntracked:
+			s.UntrackedContent = true
+		}
+	}
+
+	return nil
+}
+
 // String is equivalent to `git submodule status <submodule>`
 //
 // This will print the SHA-1 of the currently checked out commit for a
@@ -2,12 +2,18 @@ package git
 
 import (
 	"context"
+	"errors"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/go-git/go-git/v5/storage/memory"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
@@ -84,6 +90,37 @@ func (s *SubmoduleSuite) TestUpdate(c *C) {
 	c.Assert(status.IsClean(), Equals, true)
 }
 
+func (s *SubmoduleSuite) TestUpdateWithInsteadOf(c *C) {
+	cfg, err := s.Repository.Config()
+	c.Assert(err, IsNil)
+
+	basic := fixtures.Basic().One().DotGit().Root()
+	cfg.URLs[basic] = &config.URL{
+		Name:      basic,
+		InsteadOf: "https://github.com/git-fixtures/basic.git",
+	}
+	c.Assert(s.Repository.SetConfig(cfg), IsNil)
+
+	sm, err := s.Worktree.Submodule("basic")
+	c.Assert(err, IsNil)
+
+	err = sm.Update(&SubmoduleUpdateOptions{
+		Init: true,
+	})
+	c.Assert(err, IsNil)
+
+	r, err := sm.Repository()
+	c.Assert(err, IsNil)
+
+	remote, err := r.Remote(DefaultRemoteName)
+	c.Assert(err, IsNil)
+	c.Assert(remote.Config().URLs, DeepEquals, []string{"https://github.com/git-fixtures/basic.git"})
+
+	ref, err := r.Reference(plumbing.HEAD, true)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+}
+
 func (s *SubmoduleSuite) TestRepositoryWithoutInit(c *C) {
 	sm, err := s.Worktree.Submodule("basic")
 	c.Assert(err, IsNil)
@@ -278,3 +315,388 @@ func (s *SubmoduleSuite) TestSubmoduleParseScp(c *C) {
 	_, err := submodule.Repository()
 	c.Assert(err, IsNil)
 }
+
+// addSubmodule returns a new repository, in dir, with the basic repository
+// added as the lib/basic submodule, and the URL of the basic repository.
+func (s *SubmoduleSuite) addSubmodule(c *C, dir string) (*Repository, *Submodule, string) {
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	url := s.GetBasicLocalRepositoryURL()
+	sm, err := w.AddSubmodule(&SubmoduleAddOptions{
+		URL:  url,
+		Path: "lib/basic",
+	})
+	c.Assert(err, IsNil)
+
+	return r, sm, url
+}
+
+// gitSubmoduleStatus returns the output of git submodule status in dir.
+func gitSubmoduleStatus(c *C, dir string) string {
+	cmd := exec.Command("git", "submodule", "status")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	return string(out)
+}
+
+func (s *SubmoduleSuite) TestAddSubmodule(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	dir := c.MkDir()
+	r, sm, url := s.addSubmodule(c, dir)
+	c.Assert(sm.Config().Name, Equals, "lib/basic")
+	c.Assert(sm.Config().URL, Equals, url)
+
+	gitdir, err := os.ReadFile(filepath.Join(dir, "lib", "basic", ".git"))
+	c.Assert(err, IsNil)
+	c.Assert(string(gitdir), Equals, "gitdir: ../../.git/modules/lib/basic\n")
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File(".gitmodules").Staging, Equals, Added)
+	c.Assert(status.File("lib/basic").Staging, Equals, Added)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Submodules["lib/basic"].URL, Equals, url)
+
+	c.Assert(gitSubmoduleStatus(c, dir), Matches,
+		" 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 lib/basic .*\n")
+
+	_, err = w.AddSubmodule(&SubmoduleAddOptions{URL: url, Path: "lib/basic"})
+	c.Assert(errors.Is(err, ErrSubmoduleAlreadyExists), Equals, true)
+
+	_, err = w.AddSubmodule(&SubmoduleAddOptions{URL: url, Path: "lib"})
+	c.Assert(errors.Is(err, ErrSubmoduleAlreadyExists), Equals, true)
+}
+
+func (s *SubmoduleSuite) TestSubmoduleDeinit(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	dir := c.MkDir()
+	r, sm, _ := s.addSubmodule(c, dir)
+
+	changelog := filepath.Join(dir, "lib", "basic", "CHANGELOG")
+	c.Assert(os.WriteFile(changelog, []byte("changed\n"), 0o644), IsNil)
+	c.Assert(sm.Deinit(false), Equals, ErrSubmoduleModified)
+
+	c.Assert(sm.Deinit(true), IsNil)
+	c.Assert(sm.Deinit(true), Equals, ErrSubmoduleNotInitialized)
+
+	fis, err := os.ReadDir(filepath.Join(dir, "lib", "basic"))
+	c.Assert(err, IsNil)
+	c.Assert(fis, HasLen, 0)
+
+	_, err = os.Stat(filepath.Join(dir, ".git", "modules", "lib", "basic", "HEAD"))
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Submodules, HasLen, 0)
+
+	c.Assert(gitSubmoduleStatus(c, dir), Equals,
+		"-6ecf0ef2c2dffb796033e5a02219af86ec6584e5 lib/basic\n")
+}
+
+func (s *SubmoduleSuite) TestSubmoduleSetURL(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	dir := c.MkDir()
+	r, sm, _ := s.addSubmodule(c, dir)
+
+	gitmodules := filepath.Join(dir, ".gitmodules")
+	content, err := os.ReadFile(gitmodules)
+	c.Assert(err, IsNil)
+	c.Assert(os.WriteFile(gitmodules, append([]byte("# vendored\n"), content...), 0o644), IsNil)
+
+	c.Assert(sm.SetURL("../other"), IsNil)
+
+	content, err = os.ReadFile(gitmodules)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals,
+		"# vendored\n[submodule \"lib/basic\"]\n\tpath = lib/basic\n\turl = ../other\n")
+
+	url := filepath.ToSlash(filepath.Join(filepath.Dir(dir), "other"))
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Submodules["lib/basic"].URL, Equals, url)
+
+	sr, err := sm.Repository()
+	c.Assert(err, IsNil)
+	remote, err := sr.Remote(DefaultRemoteName)
+	c.Assert(err, IsNil)
+	c.Assert(remote.Config().URLs, DeepEquals, []string{url})
+
+	// The URL of the submodule remote is rewritten by the superproject.
+	c.Assert(r.SetConfigValue(config.LocalScope, "url.https://example.com/.insteadOf", "ex:"), IsNil)
+	c.Assert(sm.SetURL("ex:basic.git"), IsNil)
+
+	cfg, err = r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Submodules["lib/basic"].URL, Equals, "ex:basic.git")
+
+	remote, err = sr.Remote(DefaultRemoteName)
+	c.Assert(err, IsNil)
+	c.Assert(remote.Config().URLs, DeepEquals, []string{"https://example.com/basic.git"})
+
+	c.Assert(gitSubmoduleStatus(c, dir), Matches,
+		" 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 lib/basic .*\n")
+}
+
+func (s *SubmoduleSuite) TestSubmoduleSyncURL(c *C) {
+	dir := c.MkDir()
+	r, sm, url := s.addSubmodule(c, dir)
+
+	c.Assert(r.SetConfigValue(config.LocalScope, "submodule.lib/basic.url", "https://example.com/stale.git"), IsNil)
+	c.Assert(sm.SyncURL(), IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Submodules["lib/basic"].URL, Equals, url)
+}
+
+func (s *SubmoduleSuite) TestSubmoduleSetBranch(c *C) {
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	dir := c.MkDir()
+	_, sm, _ := s.addSubmodule(c, dir)
+
+	c.Assert(sm.SetBranch("dev"), IsNil)
+	c.Assert(sm.Config().Branch, Equals, "dev")
+
+	cmd := exec.Command("git", "config", "--file", ".gitmodules", "submodule.lib/basic.branch")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "dev\n")
+
+	c.Assert(sm.SetBranch(""), IsNil)
+	content, err := os.ReadFile(filepath.Join(dir, ".gitmodules"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(content), "branch"), Equals, false)
+
+	c.Assert(gitSubmoduleStatus(c, dir), Matches,
+		" 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 lib/basic .*\n")
+}
+
+func (s *SubmoduleSuite) TestSubmoduleStatusContent(c *C) {
+	dir := c.MkDir()
+	r, sm, _ := s.addSubmodule(c, dir)
+
+	status, err := sm.StatusWithOptions(nil)
+	c.Assert(err, IsNil)
+	c.Assert(status.Initialized, Equals, true)
+	c.Assert(status.HasNewCommits(), Equals, false)
+	c.Assert(status.ModifiedContent, Equals, false)
+	c.Assert(status.UntrackedContent, Equals, false)
+
+	c.Assert(os.WriteFile(filepath.Join(dir, "lib", "basic", "new"), nil, 0o644), IsNil)
+	status, err = sm.StatusWithOptions(nil)
+	c.Assert(err, IsNil)
+	c.Assert(status.ModifiedContent, Equals, false)
+	c.Assert(status.UntrackedContent, Equals, true)
+
+	status, err = sm.StatusWithOptions(&SubmoduleStatusOptions{Ignore: SubmoduleIgnoreUntracked})
+	c.Assert(err, IsNil)
+	c.Assert(status.UntrackedContent, Equals, false)
+
+	c.Assert(os.WriteFile(filepath.Join(dir, "lib", "basic", "CHANGELOG"), []byte("changed\n"), 0o644), IsNil)
+	status, err = sm.StatusWithOptions(nil)
+	c.Assert(err, IsNil)
+	c.Assert(status.ModifiedContent, Equals, true)
+
+	// The status of the superproject reports the submodule as modified.
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	ws, err := w.StatusWithOptions(StatusOptions{Submodules: true})
+	c.Assert(err, IsNil)
+	c.Assert(ws.File("lib/basic").Staging, Equals, Added)
+	c.Assert(ws.File("lib/basic").Worktree, Equals, Modified)
+	c.Assert(ws.File("lib/basic").Extra, Equals, "modified content, untracked content")
+
+	ws, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(ws.File("lib/basic").Worktree, Equals, Unmodified)
+
+	sr, err := sm.Repository()
+	c.Assert(err, IsNil)
+	sw, err := sr.Worktree()
+	c.Assert(err, IsNil)
+	err = sw.Checkout(&CheckoutOptions{
+		Hash:  plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+		Force: true,
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.SetConfigValue(config.LocalScope, "submodule.lib/basic.ignore", "dirty"), IsNil)
+	ws, err = w.StatusWithOptions(StatusOptions{Submodules: true})
+	c.Assert(err, IsNil)
+	c.Assert(ws.File("lib/basic").Worktree, Equals, Modified)
+	c.Assert(ws.File("lib/basic").Extra, Equals, "new commits")
+
+	c.Assert(r.SetConfigValue(config.LocalScope, "submodule.lib/basic.ignore", "all"), IsNil)
+	ws, err = w.StatusWithOptions(StatusOptions{Submodules: true})
+	c.Assert(err, IsNil)
+	c.Assert(ws.File("lib/basic").Staging, Equals, Added)
+	c.Assert(ws.File("lib/basic").Worktree, Equals, Unmodified)
+}
+
+func (s *SubmoduleSuite) TestSubmoduleStatusRecursive(c *C) {
+	middle := c.MkDir()
+	mr, _, _ := s.addSubmodule(c, middle)
+	mw, err := mr.Worktree()
+	c.Assert(err, IsNil)
+	_, err = mw.Commit("add basic\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	sm, err := w.AddSubmodule(&SubmoduleAddOptions{URL: middle, Path: "middle"})
+	c.Assert(err, IsNil)
+
+	sr, err := sm.Repository()
+	c.Assert(err, IsNil)
+	sw, err := sr.Worktree()
+	c.Assert(err, IsNil)
+	nested, err := sw.Submodules()
+	c.Assert(err, IsNil)
+	c.Assert(nested.Update(&SubmoduleUpdateOptions{Init: true}), IsNil)
+
+	changelog := filepath.Join(dir, "middle", "lib", "basic", "CHANGELOG")
+	c.Assert(os.WriteFile(changelog, []byte("changed\n"), 0o644), IsNil)
+
+	status, err := sm.StatusWithOptions(nil)
+	c.Assert(err, IsNil)
+	c.Assert(status.ModifiedContent, Equals, false)
+	c.Assert(status.Submodules, HasLen, 0)
+
+	status, err = sm.StatusWithOptions(&SubmoduleStatusOptions{Recursive: true})
+	c.Assert(err, IsNil)
+	c.Assert(status.ModifiedContent, Equals, true)
+	c.Assert(status.Submodules, HasLen, 1)
+	c.Assert(status.Submodules[0].Path, Equals, "lib/basic")
+	c.Assert(status.Submodules[0].ModifiedContent, Equals, true)
+
+	ws, err := w.StatusWithOptions(StatusOptions{Submodules: true})
+	c.Assert(err, IsNil)
+	c.Assert(ws.File("middle").Extra, Equals, "modified content")
+}
+
+// superproject returns the path of a repository with a commit adding a
+// submodule of the basic fixture at each of the given paths, the first one
+// being shallow.
+func (s *SubmoduleSuite) superproject(c *C, paths ...string) string {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	url := s.GetBasicLocalRepositoryURL()
+	for _, p := range paths {
+		_, err := w.AddSubmodule(&SubmoduleAddOptions{URL: url, Path: p})
+		c.Assert(err, IsNil)
+	}
+
+	err = w.editGitmodules(func(m *config.Modules) error {
+		m.Submodules[paths[0]].Shallow = true
+		return nil
+	})
+	c.Assert(err, IsNil)
+	_, err = w.Add(".gitmodules")
+	c.Assert(err, IsNil)
+
+	_, err = w.Commit("add submodules\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	return dir
+}
+
+func (s *SubmoduleSuite) TestCloneSubmoduleJobs(c *C) {
+	dir := s.superproject(c, "a", "b", "c")
+
+	var mu sync.Mutex
+	progress := make(map[string]bool)
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{
+		URL:               dir,
+		RecurseSubmodules: DefaultSubmoduleRecursionDepth,
+		SubmoduleJobs:     2,
+		SubmoduleProgress: func(path string) sideband.Progress {
+			mu.Lock()
+			defer mu.Unlock()
+			progress[path] = true
+			return nil
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(progress, DeepEquals, map[string]bool{"a": true, "b": true, "c": true})
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	subs, err := w.Submodules()
+	c.Assert(err, IsNil)
+	c.Assert(subs, HasLen, 3)
+
+	for _, sub := range subs {
+		status, err := sub.Status()
+		c.Assert(err, IsNil)
+		c.Assert(status.IsClean(), Equals, true, Commentf("%s", sub.Config().Path))
+
+		sr, err := sub.Repository()
+		c.Assert(err, IsNil)
+		shallows, err := sr.Storer.Shallow()
+		c.Assert(err, IsNil)
+		c.Assert(len(shallows) != 0, Equals, sub.Config().Path == "a", Commentf("%s", sub.Config().Path))
+	}
+}
+
+func (s *SubmoduleSuite) TestUpdateErrors(c *C) {
+	dir := s.superproject(c, "a", "b")
+
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{URL: dir})
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	err = w.editGitmodules(func(m *config.Modules) error {
+		m.Submodules["a"].URL = filepath.Join(c.MkDir(), "missing")
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	subs, err := w.Submodules()
+	c.Assert(err, IsNil)
+
+	err = subs.Update(&SubmoduleUpdateOptions{Init: true, Jobs: 2})
+	var updateErr *SubmodulesUpdateError
+	c.Assert(errors.As(err, &updateErr), Equals, true)
+	c.Assert(updateErr.Errors, HasLen, 1)
+	c.Assert(updateErr.Errors["a"], NotNil)
+	c.Assert(err, ErrorMatches, "submodule a: .*")
+
+	statuses, err := subs.Status()
+	c.Assert(err, IsNil)
+	c.Assert(statuses, HasLen, 2)
+	for _, status := range statuses {
+		c.Assert(status.IsClean(), Equals, status.Path == "b", Commentf("%s", status.Path))
+	}
+}
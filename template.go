@@ -0,0 +1,104 @@
+package git
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// initConfig returns the system and global config, the defaults of the
+// repositories created by PlainInitWithOptions are read from. An empty config
+// is returned if it can't be loaded.
+func initConfig() *config.Config {
+	cfg, err := config.LoadMergedConfig(config.SystemScope, config.NewConfig(), config.IncludeOptions{})
+	if err != nil {
+		return config.NewConfig()
+	}
+
+	return cfg
+}
+
+// initDefaultBranch returns the branch HEAD points to in a new repository,
+// the one of the init.defaultBranch config, or master.
+func initDefaultBranch(cfg *config.Config) plumbing.ReferenceName {
+	if cfg.Init.DefaultBranch == "" {
+		return plumbing.Master
+	}
+
+	return plumbing.NewBranchReferenceName(cfg.Init.DefaultBranch)
+}
+
+// copyTemplate copies the files of the given template directory into the
+// git directory of a new repository, keeping the existing ones, as git
+// init does. Nothing is copied if the template directory doesn't exist.
+func copyTemplate(dot billy.Filesystem, dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		if _, err := dot.Lstat(rel); err == nil {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return dot.MkdirAll(rel, fi.Mode().Perm())
+		case fi.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			return dot.Symlink(target, rel)
+		case fi.Mode().IsRegular():
+			return copyTemplateFile(dot, path, rel, fi.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+}
+
+func copyTemplateFile(dot billy.Filesystem, src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := dot.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	return out.Close()
+}
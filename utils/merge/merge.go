@@ -0,0 +1,208 @@
+// Package merge implements line oriented three-way merges of texts, similar
+// to the merge done by git for the files changed on both sides of a merge.
+package merge
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5/utils/diff"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	oursMarker   = "<<<<<<<"
+	sepMarker    = "======="
+	theirsMarker = ">>>>>>>"
+)
+
+// Labels are the names of the sides of a merge written in the conflict
+// markers, usually the names of the merged commits.
+type Labels struct {
+	Ours   string
+	Theirs string
+}
+
+// Text merges the changes from base to ours and from base to theirs, line by
+// line. The changes to distinct lines are all kept, and the changes to the
+// same lines are written between conflict markers, as git does. It returns
+// the merged text and whether it contains conflicts.
+func Text(base, ours, theirs string, labels Labels) (string, bool) {
+	lines := splitLines(base)
+	oh, th := hunks(base, ours), hunks(base, theirs)
+
+	var b strings.Builder
+	var conflict bool
+	var pos int
+	for len(oh) > 0 || len(th) > 0 {
+		start := len(lines)
+		if len(oh) > 0 {
+			start = oh[0].start
+		}
+
+		if len(th) > 0 && th[0].start < start {
+			start = th[0].start
+		}
+
+		// The group of hunks changing the same lines, on both sides.
+		end := start
+		var og, tg []hunk
+		for grown := true; grown; {
+			grown = false
+			if len(oh) > 0 && (oh[0].start < end || oh[0].start == start) {
+				og, end, oh, grown = append(og, oh[0]), max(end, oh[0].end), oh[1:], true
+			}
+
+			if len(th) > 0 && (th[0].start < end || th[0].start == start) {
+				tg, end, th, grown = append(tg, th[0]), max(end, th[0].end), th[1:], true
+			}
+		}
+
+		writeLines(&b, lines[pos:start])
+		pos = end
+
+		o, t := apply(lines, start, end, og), apply(lines, start, end, tg)
+		switch {
+		case len(tg) == 0:
+			writeLines(&b, o)
+		case len(og) == 0, equalLines(o, t):
+			writeLines(&b, t)
+		default:
+			conflict = true
+			writeConflict(&b, o, t, labels)
+		}
+	}
+
+	writeLines(&b, lines[pos:])
+	return b.String(), conflict
+}
+
+// hunk is a change of the lines from start to end of the base text.
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// hunks returns the changes from base to other, in order.
+func hunks(base, other string) []hunk {
+	var hs []hunk
+	var current *hunk
+	var pos int
+	for _, d := range diff.Do(base, other) {
+		lines := splitLines(d.Text)
+		if d.Type == diffmatchpatch.DiffEqual {
+			if current != nil {
+				hs = append(hs, *current)
+				current = nil
+			}
+
+			pos += len(lines)
+			continue
+		}
+
+		if current == nil {
+			current = &hunk{start: pos, end: pos}
+		}
+
+		if d.Type == diffmatchpatch.DiffDelete {
+			pos += len(lines)
+			current.end = pos
+			continue
+		}
+
+		current.lines = append(current.lines, lines...)
+	}
+
+	if current != nil {
+		hs = append(hs, *current)
+	}
+
+	return hs
+}
+
+// apply returns the lines from start to end of the base changed by the
+// given hunks.
+func apply(base []string, start, end int, hs []hunk) []string {
+	var result []string
+	pos := start
+	for _, h := range hs {
+		result = append(result, base[pos:h.start]...)
+		result = append(result, h.lines...)
+		pos = h.end
+	}
+
+	return append(result, base[pos:end]...)
+}
+
+// writeConflict writes the conflicting lines of both sides between conflict
+// markers, leaving out the lines they start and end with in common.
+func writeConflict(b *strings.Builder, ours, theirs []string, labels Labels) {
+	var prefix int
+	for prefix < len(ours) && prefix < len(theirs) && ours[prefix] == theirs[prefix] {
+		prefix++
+	}
+
+	var suffix int
+	for suffix < len(ours)-prefix && suffix < len(theirs)-prefix &&
+		ours[len(ours)-1-suffix] == theirs[len(theirs)-1-suffix] {
+		suffix++
+	}
+
+	writeLines(b, ours[:prefix])
+	writeMarker(b, oursMarker, labels.Ours)
+	writeLines(b, ours[prefix:len(ours)-suffix])
+	terminate(b)
+	writeMarker(b, sepMarker, "")
+	writeLines(b, theirs[prefix:len(theirs)-suffix])
+	terminate(b)
+	writeMarker(b, theirsMarker, labels.Theirs)
+	writeLines(b, ours[len(ours)-suffix:])
+}
+
+func writeMarker(b *strings.Builder, marker, label string) {
+	b.WriteString(marker)
+	if label != "" {
+		b.WriteString(" " + label)
+	}
+
+	b.WriteByte('\n')
+}
+
+// terminate ends the text with a newline, if it doesn't, for the next
+// marker to start on its own line.
+func terminate(b *strings.Builder) {
+	s := b.String()
+	if len(s) > 0 && s[len(s)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+}
+
+func writeLines(b *strings.Builder, lines []string) {
+	for _, l := range lines {
+		b.WriteString(l)
+	}
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// splitLines splits the text in lines, keeping their line endings.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
@@ -0,0 +1,51 @@
+package merge_test
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/utils/merge"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MergeSuite struct{}
+
+var _ = Suite(&MergeSuite{})
+
+var labels = merge.Labels{Ours: "HEAD", Theirs: "feature"}
+
+func (s *MergeSuite) TestText(c *C) {
+	for i, t := range []struct {
+		base, ours, theirs string
+		expected           string
+		conflict           bool
+	}{
+		{"a\nb\nc\n", "a\nb\nc\n", "a\nb\nc\n", "a\nb\nc\n", false},
+		{"a\nb\nc\n", "A\nb\nc\n", "a\nb\nc\n", "A\nb\nc\n", false},
+		{"a\nb\nc\n", "a\nb\nc\n", "a\nb\nC\n", "a\nb\nC\n", false},
+		{"a\nb\nc\n", "A\nb\nc\n", "a\nb\nC\n", "A\nb\nC\n", false},
+		{"a\nb\nc\n", "b\nc\n", "a\nb\nc\nd\n", "b\nc\nd\n", false},
+		{"a\nb\nc\n", "a\nB\nc\n", "a\nB\nc\n", "a\nB\nc\n", false},
+		{"", "a\n", "", "a\n", false},
+		{"a\nb\nc\n", "a\nB\nc\n", "a\nX\nc\n",
+			"a\n<<<<<<< HEAD\nB\n=======\nX\n>>>>>>> feature\nc\n", true},
+		{"a\n", "a\nb\nc\n", "a\nb\nd\n",
+			"a\nb\n<<<<<<< HEAD\nc\n=======\nd\n>>>>>>> feature\n", true},
+		{"a\nb\n", "a\n", "a\nB\n",
+			"a\n<<<<<<< HEAD\n=======\nB\n>>>>>>> feature\n", true},
+		{"a\nb", "a\nB", "a\nX",
+			"a\n<<<<<<< HEAD\nB\n=======\nX\n>>>>>>> feature\n", true},
+	} {
+		merged, conflict := merge.Text(t.base, t.ours, t.theirs, labels)
+		c.Assert(merged, Equals, t.expected, Commentf("case %d", i))
+		c.Assert(conflict, Equals, t.conflict, Commentf("case %d", i))
+	}
+}
+
+func (s *MergeSuite) TestTextNoLabels(c *C) {
+	merged, conflict := merge.Text("a\n", "b\n", "c\n", merge.Labels{})
+	c.Assert(conflict, Equals, true)
+	c.Assert(merged, Equals, "<<<<<<<\nb\n=======\nc\n>>>>>>>\n")
+}
@@ -24,6 +24,7 @@ var ignore = map[string]bool{
 type node struct {
 	fs         billy.Filesystem
 	submodules map[string]plumbing.Hash
+	options    *Options
 
 	path     string
 	hash     []byte
@@ -42,7 +43,32 @@ func NewRootNode(
 	fs billy.Filesystem,
 	submodules map[string]plumbing.Hash,
 ) noder.Noder {
-	return &node{fs: fs, submodules: submodules, isDir: true}
+	return &node{fs: fs, submodules: submodules, options: &Options{}, isDir: true}
+}
+
+// Options are the options of NewRootNodeWithOptions.
+type Options struct {
+	// Submodules maps the paths of the submodules to the commit of their
+	// HEAD, to provide their hash status.
+	Submodules map[string]plumbing.Hash
+	// ReadDir, if set, is used instead of the ReadDir method of the
+	// filesystem to list the directories, so they may come from a cache.
+	ReadDir func(path string) ([]os.FileInfo, error)
+	// Hash, if set, returns the hash of the file at path, as a blob, when it
+	// is known without reading the file.
+	Hash func(path string) (plumbing.Hash, bool)
+	// Mode, if set, returns the mode the file at path is hashed with, given
+	// its mode in the filesystem. It allows the modes the filesystem can't
+	// represent to be kept, such as the executable bit with core.fileMode
+	// set to false, or a symlink checked out as a plain file with
+	// core.symlinks set to false.
+	Mode func(path string, mode filemode.FileMode) filemode.FileMode
+}
+
+// NewRootNodeWithOptions returns the root node based on a given
+// billy.Filesystem and options.
+func NewRootNodeWithOptions(fs billy.Filesystem, o Options) noder.Noder {
+	return &node{fs: fs, submodules: o.Submodules, options: &o, isDir: true}
 }
 
 // Hash the hash of a filesystem is the result of concatenating the computed
@@ -99,7 +125,12 @@ func (n *node) calculateChildren() error {
 		return nil
 	}
 
-	files, err := n.fs.ReadDir(n.path)
+	readDir := n.fs.ReadDir
+	if n.options.ReadDir != nil {
+		readDir = n.options.ReadDir
+	}
+
+	files, err := readDir(n.path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -133,6 +164,7 @@ func (n *node) newChildNode(file os.FileInfo) (*node, error) {
 	node := &node{
 		fs:         n.fs,
 		submodules: n.submodules,
+		options:    n.options,
 
 		path:  path,
 		isDir: file.IsDir(),
@@ -157,14 +189,24 @@ func (n *node) calculateHash() {
 		n.hash = plumbing.ZeroHash[:]
 		return
 	}
+	if n.options.Mode != nil {
+		mode = n.options.Mode(n.path, mode)
+	}
 	if submoduleHash, isSubmodule := n.submodules[n.path]; isSubmodule {
 		n.hash = append(submoduleHash[:], filemode.Submodule.Bytes()...)
 		return
 	}
 	var hash plumbing.Hash
-	if n.mode&os.ModeSymlink != 0 {
+	var known bool
+	if n.options.Hash != nil {
+		hash, known = n.options.Hash(n.path)
+	}
+
+	switch {
+	case known:
+	case n.mode&os.ModeSymlink != 0:
 		hash = n.doCalculateHashForSymlink()
-	} else {
+	default:
 		hash = n.doCalculateHashForRegular()
 	}
 	n.hash = append(hash[:], mode.Bytes()...)
@@ -221,6 +221,48 @@ func (s *NoderSuite) TestSocket(c *C) {
 	c.Assert(childs, HasLen, 1)
 }
 
+func (s *NoderSuite) TestOptions(c *C) {
+	fsA := memfs.New()
+	WriteFile(fsA, "foo", []byte("foo"), 0644)
+	WriteFile(fsA, "qux/bar", []byte("foo"), 0644)
+	WriteFile(fsA, "qux/qux", []byte("foo"), 0644)
+
+	fsB := memfs.New()
+	WriteFile(fsB, "foo", []byte("bar"), 0644)
+	WriteFile(fsB, "qux/bar", []byte("foo"), 0644)
+	WriteFile(fsB, "qux/qux", []byte("foo"), 0644)
+	WriteFile(fsB, "qux/baz", []byte("foo"), 0644)
+
+	var read []string
+	to := NewRootNodeWithOptions(fsB, Options{
+		ReadDir: func(path string) ([]os.FileInfo, error) {
+			read = append(read, path)
+			files, err := fsB.ReadDir(path)
+			if path != "qux" {
+				return files, err
+			}
+
+			// the listing of qux is outdated
+			var cached []os.FileInfo
+			for _, f := range files {
+				if f.Name() != "baz" {
+					cached = append(cached, f)
+				}
+			}
+
+			return cached, err
+		},
+		Hash: func(path string) (plumbing.Hash, bool) {
+			return plumbing.ComputeHash(plumbing.BlobObject, []byte("foo")), path == "foo"
+		},
+	})
+
+	ch, err := merkletrie.DiffTree(NewRootNode(fsA, nil), to, IsEquals)
+	c.Assert(err, IsNil)
+	c.Assert(ch, HasLen, 0)
+	c.Assert(read, DeepEquals, []string{"", "qux"})
+}
+
 func WriteFile(fs billy.Filesystem, filename string, data []byte, perm os.FileMode) error {
 	f, err := fs.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
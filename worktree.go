@@ -1,24 +1,32 @@
 package git
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/internal/pathspec"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/go-git/go-git/v5/plumbing/format/index"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/utils/binary"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 	"github.com/go-git/go-git/v5/utils/merkletrie"
 	"github.com/go-git/go-git/v5/utils/sync"
@@ -31,6 +39,7 @@ var (
 	ErrGitModulesSymlink               = errors.New(gitmodulesFile + " is a symlink")
 	ErrNonFastForwardUpdate            = errors.New("non-fast-forward update")
 	ErrRestoreWorktreeOnlyNotSupported = errors.New("worktree only is not supported")
+	ErrCaseCollision                   = errors.New("paths only differing in case collide on a case-insensitive filesystem")
 )
 
 // Worktree represents a git worktree.
@@ -39,13 +48,17 @@ type Worktree struct {
 	Filesystem billy.Filesystem
 	// External excludes not found in the repository .gitignore
 	Excludes []gitignore.Pattern
+	// FSMonitor, if set, reports the changes in the worktree, so Status
+	// only reads the files changed since its last call.
+	FSMonitor FSMonitor
 
 	r *Repository
 }
 
 // Pull incorporates changes from a remote repository into the current branch.
 // Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
-// no changes to be fetched, or an error.
+// no changes to be fetched, transport.ErrEmptyRemoteRepository if the remote
+// repository is empty, or an error.
 //
 // Pull only supports merges where the can be resolved as a fast-forward.
 func (w *Worktree) Pull(o *PullOptions) error {
@@ -54,9 +67,14 @@ func (w *Worktree) Pull(o *PullOptions) error {
 
 // PullContext incorporates changes from a remote repository into the current
 // branch. Returns nil if the operation is successful, NoErrAlreadyUpToDate if
-// there are no changes to be fetched, or an error.
+// there are no changes to be fetched, transport.ErrEmptyRemoteRepository if
+// the remote repository is empty, or an error.
 //
-// Pull only supports merges where the can be resolved as a fast-forward.
+// When the current branch can't be fast-forwarded to the fetched head, it is
+// merged with a merge commit, or the local commits are rebased on top of it,
+// following the options and the pull.ff, pull.rebase and branch.<name>.rebase
+// config. On conflicts, ErrMergeConflict is returned and the repository is
+// left in the middle of the merge, or of the rebase, as git does.
 //
 // The provided Context must be non-nil. If the context expires before the
 // operation is complete, an error is returned. The context only affects the
@@ -71,17 +89,21 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 		return err
 	}
 
-	fetchHead, err := remote.fetch(ctx, &FetchOptions{
+	fo := &FetchOptions{
 		RemoteName:      o.RemoteName,
 		RemoteURL:       o.RemoteURL,
 		Depth:           o.Depth,
 		Auth:            o.Auth,
 		Progress:        o.Progress,
+		ProgressHandler: o.ProgressHandler,
 		Force:           o.Force,
 		InsecureSkipTLS: o.InsecureSkipTLS,
 		CABundle:        o.CABundle,
 		ProxyOptions:    o.ProxyOptions,
-	})
+		Timeouts:        o.Timeouts,
+	}
+
+	fetchHead, err := remote.fetch(ctx, fo)
 
 	updated := true
 	if err == NoErrAlreadyUpToDate {
@@ -91,6 +113,25 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 	}
 
 	ref, err := storer.ResolveReference(fetchHead, o.ReferenceName)
+	if err == plumbing.ErrReferenceNotFound {
+		// Nothing can be merged from an empty repository.
+		empty, eerr := isEmptyRemote(fetchHead)
+		if eerr != nil {
+			return eerr
+		}
+
+		if empty {
+			return transport.ErrEmptyRemoteRepository
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = remote.writeFetchHead(fo, fetchHead, func(_ int, name plumbing.ReferenceName) bool {
+		return name == ref.Name()
+	})
 	if err != nil {
 		return err
 	}
@@ -119,26 +160,64 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 			return err
 		}
 
-		if !ff {
+		if !ff && headAheadOfRef {
+			return nil
+		}
+
+		m, err := w.pullMode(o, head)
+		if err != nil {
+			return err
+		}
+
+		if !ff && m.ffOnly {
 			return ErrNonFastForwardUpdate
 		}
+
+		if !ff || m.noFF && !m.rebase && head.Hash() != ref.Hash() {
+			if err := w.pullIntegrate(o, m, remote, head, ref); err != nil {
+				return err
+			}
+
+			if !m.rebase {
+				if err := w.r.runPostMergeHook(ctx, o.Hooks); err != nil {
+					return err
+				}
+			}
+
+			return w.pullSubmodules(ctx, o)
+		}
 	}
 
 	if err != nil && err != plumbing.ErrReferenceNotFound {
 		return err
 	}
 
+	if head != nil {
+		if err := w.r.Storer.SetReference(plumbing.NewHashReference(origHeadRef, head.Hash())); err != nil {
+			return err
+		}
+	}
+
 	if err := w.updateHEAD(ref.Hash()); err != nil {
 		return err
 	}
 
-	if err := w.Reset(&ResetOptions{
-		Mode:   MergeReset,
-		Commit: ref.Hash(),
-	}); err != nil {
+	if err := w.reset(ctx, &ResetOptions{
+		Mode:            MergeReset,
+		Commit:          ref.Hash(),
+		ProgressHandler: o.ProgressHandler,
+	}, nil); err != nil {
+		return err
+	}
+
+	if err := w.r.runPostMergeHook(ctx, o.Hooks); err != nil {
 		return err
 	}
 
+	return w.pullSubmodules(ctx, o)
+}
+
+func (w *Worktree) pullSubmodules(ctx context.Context, o *PullOptions) error {
 	if o.RecurseSubmodules != NoRecurseSubmodules {
 		return w.updateSubmodules(ctx, &SubmoduleUpdateOptions{
 			RecurseSubmodules: o.RecurseSubmodules,
@@ -160,12 +239,60 @@ func (w *Worktree) updateSubmodules(ctx context.Context, o *SubmoduleUpdateOptio
 
 // Checkout switch branches or restore working tree files.
 func (w *Worktree) Checkout(opts *CheckoutOptions) error {
+	return w.CheckoutContext(context.Background(), opts)
+}
+
+// CheckoutContext switch branches or restore working tree files, as Checkout
+// does. The context is checked for each file compared or written: if it is
+// cancelled while the files are written, a *CheckoutInterruptedError lists
+// the files written, the index being updated for them.
+//
+// If opts.Hooks is set, the post-checkout hook is run once checked out.
+func (w *Worktree) CheckoutContext(ctx context.Context, opts *CheckoutOptions) error {
 	if err := opts.Validate(); err != nil {
 		return err
 	}
 
+	if opts.Hooks == nil {
+		return w.checkout(ctx, opts)
+	}
+
+	var from plumbing.Hash
+	if head, err := w.r.Head(); err == nil {
+		from = head.Hash()
+	}
+
+	if err := w.checkout(ctx, opts); err != nil {
+		return err
+	}
+
+	to := from
+	if head, err := w.r.Head(); err == nil {
+		to = head.Hash()
+	}
+
+	return w.runPostCheckoutHook(ctx, opts.Hooks, from, to, len(opts.Pathspec) == 0)
+}
+
+func (w *Worktree) checkout(ctx context.Context, opts *CheckoutOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(opts.Pathspec) != 0 {
+		return w.checkoutPaths(ctx, opts)
+	}
+
+	if !opts.Create && opts.Hash.IsZero() {
+		if err := w.guessRemoteBranch(opts); err != nil {
+			return err
+		}
+	}
+
+	var start plumbing.ReferenceName
 	if opts.Create {
-		if err := w.createBranch(opts); err != nil {
+		var err error
+		if start, err = w.resolveBranchStart(opts); err != nil {
 			return err
 		}
 	}
@@ -175,15 +302,31 @@ func (w *Worktree) Checkout(opts *CheckoutOptions) error {
 		return err
 	}
 
-	ro := &ResetOptions{Commit: c, Mode: MergeReset}
-	if opts.Force {
-		ro.Mode = HardReset
-	} else if opts.Keep {
-		ro.Mode = SoftReset
+	if !opts.AllowCaseCollisions && w.ignoreCase() {
+		if err := w.checkCaseCollisions(c); err != nil {
+			return err
+		}
+	}
+
+	// Unless forced or keeping the changes, only the files differing between
+	// the index and the commit are updated, once checked that no local change
+	// would be lost.
+	var update map[string]bool
+	if !opts.Force && !opts.Keep {
+		update, err = w.checkoutUpdates(ctx, c, opts.ConflictHandler)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Create {
+		if err := w.createBranch(opts, start); err != nil {
+			return err
+		}
 	}
 
-	if !opts.Hash.IsZero() && !opts.Create {
-		err = w.setHEADToCommit(opts.Hash)
+	if (!opts.Hash.IsZero() || opts.Detach) && !opts.Create {
+		err = w.setHEADToCommit(c)
 	} else {
 		err = w.setHEADToBranch(opts.Branch, c)
 	}
@@ -192,69 +335,264 @@ func (w *Worktree) Checkout(opts *CheckoutOptions) error {
 		return err
 	}
 
-	if len(opts.SparseCheckoutDirectories) > 0 {
-		return w.ResetSparsely(ro, opts.SparseCheckoutDirectories)
+	if update != nil {
+		if err := w.setHEADCommit(c); err != nil {
+			return err
+		}
+
+		match := func(name string) bool {
+			return update[name]
+		}
+
+		t, err := w.r.getTreeFromCommitHash(c)
+		if err != nil {
+			return err
+		}
+
+		return w.updateMatching(ctx, t, opts.SparseCheckoutDirectories, match, opts.ProgressHandler)
+	}
+
+	ro := &ResetOptions{Commit: c, Mode: SoftReset, ProgressHandler: opts.ProgressHandler}
+	if opts.Force {
+		ro.Mode = HardReset
 	}
 
-	return w.Reset(ro)
+	return w.reset(ctx, ro, opts.SparseCheckoutDirectories)
 }
 
-func (w *Worktree) createBranch(opts *CheckoutOptions) error {
-	if err := opts.Branch.Validate(); err != nil {
+// checkoutPaths checks out the paths matching opts.Pathspec from the tree-ish
+// of opts, updating the index and the worktree but not HEAD.
+func (w *Worktree) checkoutPaths(ctx context.Context, opts *CheckoutOptions) error {
+	ps, err := pathspec.Parse(opts.Pathspec...)
+	if err != nil {
+		return err
+	}
+
+	t, err := w.getTreeFromCheckoutOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	return w.updateMatching(ctx, t, nil, ps.Match, opts.ProgressHandler)
+}
+
+// updateMatching updates the index and the worktree to the given tree, only
+// for the paths matched by match, without updating HEAD.
+func (w *Worktree) updateMatching(ctx context.Context, t *object.Tree, dirs []string, match func(string) bool, progress ProgressHandler) error {
+	prev, err := w.indexEntries()
+	if err != nil {
+		return err
+	}
+
+	if err := w.resetIndex(ctx, t, dirs, match); err != nil {
 		return err
 	}
 
+	return w.resetWorktree(ctx, t, match, prev, progress)
+}
+
+// resolveBranchStart checks the branch of opts can be created, and sets
+// opts.Hash to the commit it starts at. It returns the reference the branch
+// should track, if any.
+func (w *Worktree) resolveBranchStart(opts *CheckoutOptions) (plumbing.ReferenceName, error) {
+	if err := opts.Branch.Validate(); err != nil {
+		return "", err
+	}
+
 	_, err := w.r.Storer.Reference(opts.Branch)
 	if err == nil {
-		return fmt.Errorf("a branch named %q already exists", opts.Branch)
+		return "", fmt.Errorf("a branch named %q already exists", opts.Branch)
 	}
 
 	if err != plumbing.ErrReferenceNotFound {
-		return err
+		return "", err
 	}
 
-	if opts.Hash.IsZero() {
+	start := opts.StartPoint
+	switch {
+	case start != "":
+		ref, err := w.r.Reference(start, true)
+		if err != nil {
+			return "", err
+		}
+
+		opts.Hash = ref.Hash()
+	case opts.Hash.IsZero():
 		ref, err := w.r.Head()
 		if err != nil {
-			return err
+			return "", err
 		}
 
 		opts.Hash = ref.Hash()
+		if head, err := w.r.Storer.Reference(plumbing.HEAD); err == nil && head.Type() == plumbing.SymbolicReference {
+			start = head.Target()
+		}
 	}
 
-	return w.r.Storer.SetReference(
+	return start, nil
+}
+
+// createBranch creates the branch of opts at opts.Hash, tracking start if
+// not empty.
+func (w *Worktree) createBranch(opts *CheckoutOptions, start plumbing.ReferenceName) error {
+	err := w.r.Storer.SetReference(
 		plumbing.NewHashReference(opts.Branch, opts.Hash),
 	)
+	if err != nil || start == "" {
+		return err
+	}
+
+	return w.r.setupBranchTracking(opts.Branch.Short(), start)
+}
+
+// guessRemoteBranch turns the checkout of a missing branch into its creation
+// from the remote-tracking branch of the same name, tracking it, when a single
+// remote has one, as git checkout does. checkout.defaultRemote picks the
+// remote when several have one, and checkout.guess set to false disables it.
+func (w *Worktree) guessRemoteBranch(opts *CheckoutOptions) error {
+	if !opts.Branch.IsBranch() {
+		return nil
+	}
+
+	_, err := w.r.Storer.Reference(opts.Branch)
+	if err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+
+	cfg, err := w.r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return err
+	}
+
+	if cfg.GetString("checkout.guess") != "" {
+		if guess, err := cfg.GetBool("checkout.guess"); err != nil || !guess {
+			return err
+		}
+	}
+
+	defaultRemote := cfg.GetString("checkout.defaultRemote")
+	var candidates []plumbing.ReferenceName
+	for name := range cfg.Remotes {
+		tracking := remoteTrackingName(cfg, name, opts.Branch)
+		if tracking == "" {
+			continue
+		}
+
+		if _, err := w.r.Storer.Reference(tracking); err != nil {
+			continue
+		}
+
+		if name == defaultRemote {
+			candidates = []plumbing.ReferenceName{tracking}
+			break
+		}
+
+		candidates = append(candidates, tracking)
+	}
+
+	if len(candidates) == 1 {
+		opts.Create, opts.StartPoint = true, candidates[0]
+	}
+
+	return nil
+}
+
+// checkCaseCollisions returns ErrCaseCollision if the tree of the commit has
+// paths only differing in case, which can't be checked out together on a
+// case-insensitive filesystem.
+func (w *Worktree) checkCaseCollisions(commit plumbing.Hash) error {
+	c, err := w.r.CommitObject(commit)
+	if err != nil {
+		return err
+	}
+
+	t, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]string)
+	walker := object.NewTreeWalker(t, true, nil)
+	defer walker.Close()
+
+	for {
+		name, e, err := walker.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if e.Mode == filemode.Dir {
+			continue
+		}
+
+		folded := strings.ToLower(name)
+		if other, ok := seen[folded]; ok {
+			return fmt.Errorf("%w: %q and %q", ErrCaseCollision, other, name)
+		}
+
+		seen[folded] = name
+	}
 }
 
 func (w *Worktree) getCommitFromCheckoutOptions(opts *CheckoutOptions) (plumbing.Hash, error) {
+	o, err := w.checkoutObject(opts)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if c, ok := o.(*object.Commit); ok {
+		return c.Hash, nil
+	}
+
+	return plumbing.ZeroHash, fmt.Errorf("%w: %q", object.ErrUnsupportedObject, o.Type())
+}
+
+// getTreeFromCheckoutOptions returns the tree of the tree-ish of opts: the
+// one of a commit, or a tree.
+func (w *Worktree) getTreeFromCheckoutOptions(opts *CheckoutOptions) (*object.Tree, error) {
+	o, err := w.checkoutObject(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o := o.(type) {
+	case *object.Commit:
+		return o.Tree()
+	case *object.Tree:
+		return o, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", object.ErrUnsupportedObject, o.Type())
+}
+
+// checkoutObject returns the object of opts.Hash, or of the commit of
+// opts.Branch, with the annotated tags peeled.
+func (w *Worktree) checkoutObject(opts *CheckoutOptions) (object.Object, error) {
 	hash := opts.Hash
 	if hash.IsZero() {
 		b, err := w.r.Reference(opts.Branch, true)
 		if err != nil {
-			return plumbing.ZeroHash, err
+			return nil, err
 		}
 
 		hash = b.Hash()
 	}
 
 	o, err := w.r.Object(plumbing.AnyObject, hash)
-	if err != nil {
-		return plumbing.ZeroHash, err
-	}
-
-	switch o := o.(type) {
-	case *object.Tag:
-		if o.TargetType != plumbing.CommitObject {
-			return plumbing.ZeroHash, fmt.Errorf("%w: tag target %q", object.ErrUnsupportedObject, o.TargetType)
+	for err == nil {
+		tag, ok := o.(*object.Tag)
+		if !ok {
+			return o, nil
 		}
 
-		return o.Target, nil
-	case *object.Commit:
-		return o.Hash, nil
+		o, err = tag.Object()
 	}
 
-	return plumbing.ZeroHash, fmt.Errorf("%w: %q", object.ErrUnsupportedObject, o.Type())
+	return nil, err
 }
 
 func (w *Worktree) setHEADToCommit(commit plumbing.Hash) error {
@@ -278,13 +616,44 @@ func (w *Worktree) setHEADToBranch(branch plumbing.ReferenceName, commit plumbin
 	return w.r.Storer.SetReference(head)
 }
 
+// ResetSparsely resets the worktree as Reset does, only checking out the
+// given directories.
 func (w *Worktree) ResetSparsely(opts *ResetOptions, dirs []string) error {
+	return w.resetSparsely(context.Background(), opts, dirs)
+}
+
+func (w *Worktree) resetSparsely(ctx context.Context, opts *ResetOptions, dirs []string) error {
+	if len(opts.Files) != 0 {
+		return w.reset(ctx, opts, dirs)
+	}
+
+	head, err := w.r.Head()
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+
+	if err := w.reset(ctx, opts, dirs); err != nil {
+		return err
+	}
+
+	if head != nil {
+		if err := w.r.Storer.SetReference(plumbing.NewHashReference(plumbing.OrigHead, head.Hash())); err != nil {
+			return err
+		}
+	}
+
+	return w.r.removeMergeState()
+}
+
+// reset resets the worktree, without saving ORIG_HEAD, for the operations
+// other than reset using it.
+func (w *Worktree) reset(ctx context.Context, opts *ResetOptions, dirs []string) error {
 	if err := opts.Validate(w.r); err != nil {
 		return err
 	}
 
 	if opts.Mode == MergeReset {
-		unstaged, err := w.containsUnstagedChanges()
+		unstaged, err := w.containsUnstagedChanges(ctx)
 		if err != nil {
 			return err
 		}
@@ -307,14 +676,28 @@ func (w *Worktree) ResetSparsely(opts *ResetOptions, dirs []string) error {
 		return err
 	}
 
+	var match func(string) bool
+	if len(opts.Files) > 0 {
+		match = func(name string) bool {
+			return inFiles(opts.Files, name)
+		}
+	}
+
+	var prev map[string]*index.Entry
+	if opts.Mode == MergeReset || opts.Mode == HardReset {
+		if prev, err = w.indexEntries(); err != nil {
+			return err
+		}
+	}
+
 	if opts.Mode == MixedReset || opts.Mode == MergeReset || opts.Mode == HardReset {
-		if err := w.resetIndex(t, dirs, opts.Files); err != nil {
+		if err := w.resetIndex(ctx, t, dirs, match); err != nil {
 			return err
 		}
 	}
 
 	if opts.Mode == MergeReset || opts.Mode == HardReset {
-		if err := w.resetWorktree(t, opts.Files); err != nil {
+		if err := w.resetWorktree(ctx, t, match, prev, opts.ProgressHandler); err != nil {
 			return err
 		}
 	}
@@ -351,18 +734,35 @@ func (w *Worktree) Restore(o *RestoreOptions) error {
 			opts.Mode = MixedReset
 		}
 
-		return w.Reset(opts)
+		return w.reset(context.Background(), opts, nil)
 	}
 
 	return ErrRestoreWorktreeOnlyNotSupported
 }
 
 // Reset the worktree to a specified state.
+//
+// Unless only some files are reset, the previous HEAD is saved as ORIG_HEAD,
+// and a merge or a cherry-pick stopped on conflicts is abandoned, as git
+// reset does.
 func (w *Worktree) Reset(opts *ResetOptions) error {
 	return w.ResetSparsely(opts, nil)
 }
 
-func (w *Worktree) resetIndex(t *object.Tree, dirs []string, files []string) error {
+// ResetContext resets the worktree to a specified state, as Reset does. The
+// context is checked for each file compared or written, as in
+// CheckoutContext.
+func (w *Worktree) ResetContext(ctx context.Context, opts *ResetOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return w.resetSparsely(ctx, opts, nil)
+}
+
+// resetIndex resets the entries of the index to the given tree, only the
+// ones whose path is matched by match if not nil.
+func (w *Worktree) resetIndex(ctx context.Context, t *object.Tree, dirs []string, match func(string) bool) error {
 	idx, err := w.r.Storer.Index()
 	if err != nil {
 		return err
@@ -370,7 +770,7 @@ func (w *Worktree) resetIndex(t *object.Tree, dirs []string, files []string) err
 
 	b := newIndexBuilder(idx)
 
-	changes, err := w.diffTreeWithStaging(t, true)
+	changes, err := w.diffTreeWithStaging(ctx, t, true)
 	if err != nil {
 		return err
 	}
@@ -395,11 +795,8 @@ func (w *Worktree) resetIndex(t *object.Tree, dirs []string, files []string) err
 			name = ch.From.String()
 		}
 
-		if len(files) > 0 {
-			contains := inFiles(files, name)
-			if !contains {
-				continue
-			}
+		if match != nil && !match(name) {
+			continue
 		}
 
 		b.Remove(name)
@@ -424,35 +821,95 @@ func (w *Worktree) resetIndex(t *object.Tree, dirs []string, files []string) err
 	return w.r.Storer.SetIndex(idx)
 }
 
-func inFiles(files []string, v string) bool {
-	v = filepath.Clean(v)
-	for _, s := range files {
-		if filepath.Clean(s) == v {
-			return true
+// writeInterruptedIndex writes the index of a checkout stopped before the
+// given changes were written to the worktree: their entries are set back to
+// the ones of prev if not nil, so the index describes the worktree.
+func (w *Worktree) writeInterruptedIndex(idx *index.Index, b *indexBuilder, prev map[string]*index.Entry, pending merkletrie.Changes) error {
+	if prev != nil {
+		for _, ch := range pending {
+			name := changePath(ch)
+			if e, ok := prev[name]; ok {
+				b.Add(e)
+			} else {
+				b.Remove(name)
+			}
 		}
 	}
 
-	return false
+	b.Write(idx)
+	return w.r.Storer.SetIndex(idx)
 }
 
-func (w *Worktree) resetWorktree(t *object.Tree, files []string) error {
-	changes, err := w.diffStagingWithWorktree(true, false)
-	if err != nil {
-		return err
-	}
-
+// indexEntries returns a copy of the entries of the index, by path.
+func (w *Worktree) indexEntries() (map[string]*index.Entry, error) {
 	idx, err := w.r.Storer.Index()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	b := newIndexBuilder(idx)
 
+	entries := make(map[string]*index.Entry, len(idx.Entries))
+	for _, e := range idx.Entries {
+		cp := *e
+		entries[e.Name] = &cp
+	}
+
+	return entries, nil
+}
+
+// changePath returns the path of the file of a change.
+func changePath(ch merkletrie.Change) string {
+	if ch.To != nil {
+		return ch.To.String()
+	}
+
+	if ch.From != nil {
+		return ch.From.String()
+	}
+
+	return ""
+}
+
+func inFiles(files []string, v string) bool {
+	v = filepath.Clean(v)
+	for _, s := range files {
+		if filepath.Clean(s) == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resetWorktree writes the files of the worktree differing from the index,
+// only the ones whose path is matched by match if not nil, reporting the
+// progress to the handler if not nil. If the context is cancelled, the index
+// is written for the files already written, which are returned in a
+// *CheckoutInterruptedError. If the context is cancelled or a file fails to
+// be written, the entries of the files not written are set back to the ones
+// of prev, the index before it was reset, if not nil, so the index still
+// describes the worktree.
+func (w *Worktree) resetWorktree(ctx context.Context, t *object.Tree, match func(string) bool, prev map[string]*index.Entry, progress ProgressHandler) error {
+	changes, err := w.diffStagingWithWorktree(ctx, true, false)
+	if err != nil {
+		return err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+	b := newIndexBuilder(idx)
+
+	var selected merkletrie.Changes
 	for _, ch := range changes {
+		// The paths which can't be written safely, from trees created
+		// elsewhere, are left out of the worktree, so the other files are
+		// still checked out. They are reported as deleted by Status.
 		if err := w.validChange(ch); err != nil {
-			return err
+			continue
 		}
 
-		if len(files) > 0 {
+		if match != nil {
 			file := ""
 			if ch.From != nil {
 				file = ch.From.String()
@@ -460,25 +917,64 @@ func (w *Worktree) resetWorktree(t *object.Tree, files []string) error {
 				file = ch.To.String()
 			}
 
-			if file == "" {
+			if file == "" || !match(file) {
 				continue
 			}
+		}
 
-			contains := inFiles(files, file)
-			if !contains {
-				continue
+		selected = append(selected, ch)
+	}
+
+	var updated []string
+	for i, ch := range selected {
+		if err := ctx.Err(); err != nil {
+			if serr := w.writeInterruptedIndex(idx, b, prev, selected[i:]); serr != nil {
+				return serr
 			}
+
+			return &CheckoutInterruptedError{Updated: updated, Err: err}
 		}
 
 		if err := w.checkoutChange(ch, t, b); err != nil {
+			if serr := w.writeInterruptedIndex(idx, b, prev, selected[i:]); serr != nil {
+				return serr
+			}
+
 			return err
 		}
+
+		path := changePath(ch)
+		updated = append(updated, path)
+		if progress != nil {
+			progress(ProgressEvent{Phase: ProgressUpdatingFiles, Done: i + 1, Total: len(selected), Path: path})
+		}
 	}
 
 	b.Write(idx)
 	return w.r.Storer.SetIndex(idx)
 }
 
+// CheckoutInterruptedError is returned when the context of a checkout, a
+// reset or a pull is cancelled while the files of the worktree are written.
+// HEAD and the index are already updated, and the index is written for the
+// files written before, so the remaining ones appear as modified in the
+// status, until the operation is run again.
+type CheckoutInterruptedError struct {
+	// Updated are the paths of the files written or removed.
+	Updated []string
+	// Err is the error of the context.
+	Err error
+}
+
+func (e *CheckoutInterruptedError) Error() string {
+	return fmt.Sprintf("checkout interrupted after updating %d files: %s", len(e.Updated), e.Err)
+}
+
+// Unwrap returns the error of the context, for errors.Is and errors.As.
+func (e *CheckoutInterruptedError) Unwrap() error {
+	return e.Err
+}
+
 // worktreeDeny is a list of paths that are not allowed
 // to be used when resetting the worktree.
 var worktreeDeny = map[string]struct{}{
@@ -611,8 +1107,8 @@ func (w *Worktree) checkoutChange(ch merkletrie.Change, t *object.Tree, idx *ind
 	return w.checkoutChangeRegularFile(name, a, t, e, idx)
 }
 
-func (w *Worktree) containsUnstagedChanges() (bool, error) {
-	ch, err := w.diffStagingWithWorktree(false, true)
+func (w *Worktree) containsUnstagedChanges(ctx context.Context) (bool, error) {
+	ch, err := w.diffStagingWithWorktree(ctx, false, true)
 	if err != nil {
 		return false, err
 	}
@@ -770,14 +1266,15 @@ func (w *Worktree) checkoutFileSymlink(f *object.File) (err error) {
 		return
 	}
 
-	err = w.Filesystem.Symlink(string(bytes), f.Name)
-
-	// On windows, this might fail.
-	// Follow Git on Windows behavior by writing the link as it is.
-	if err != nil && isSymlinkWindowsNonAdmin(err) {
-		mode, _ := f.Mode.ToOSFileMode()
+	noSymlinks := w.noSymlinks()
+	if !noSymlinks {
+		err = w.Filesystem.Symlink(string(bytes), f.Name)
+	}
 
-		to, err := w.Filesystem.OpenFile(f.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	// With core.symlinks set to false, or on windows without the privilege
+	// to create symlinks, the link is written as a plain file, as git does.
+	if noSymlinks || err != nil && isSymlinkWindowsNonAdmin(err) {
+		to, err := w.Filesystem.OpenFile(f.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
 		if err != nil {
 			return err
 		}
@@ -873,8 +1370,157 @@ func (w *Worktree) Submodules() (Submodules, error) {
 	return l, nil
 }
 
+// AddSubmodule adds a submodule to the worktree, as git submodule add does.
+// The repository of the submodule is cloned in its path, its git directory
+// being .git/modules/<name> if the storage is file based. The submodule is
+// recorded in the .gitmodules file and in the config, and the .gitmodules file
+// and the commit checked out in the submodule are staged.
+func (w *Worktree) AddSubmodule(o *SubmoduleAddOptions) (*Submodule, error) {
+	return w.AddSubmoduleContext(context.Background(), o)
+}
+
+// AddSubmoduleContext adds a submodule to the worktree, as AddSubmodule does.
+//
+// The provided Context must be non-nil. If the context expires before the
+// operation is complete, an error is returned. The context only affects the
+// transport operations.
+func (w *Worktree) AddSubmoduleContext(ctx context.Context, o *SubmoduleAddOptions) (*Submodule, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &config.Submodule{Name: o.Name, Path: o.Path, URL: o.URL, Branch: o.Branch}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := w.checkNewSubmodule(c); err != nil {
+		return nil, err
+	}
+
+	url, err := w.resolveSubmoduleURL(o.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := w.r.Storer.Module(o.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, err := w.Filesystem.Chroot(o.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ref plumbing.ReferenceName
+	if o.Branch != "" {
+		ref = plumbing.NewBranchReferenceName(o.Branch)
+	}
+
+	r, err := CloneContext(ctx, s, fs, &CloneOptions{
+		URL:           url,
+		ReferenceName: ref,
+		Auth:          o.Auth,
+		Depth:         o.Depth,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.editGitmodules(func(m *config.Modules) error {
+		m.Submodules[c.Name] = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &Submodule{
+		initialized: true,
+		c:           &config.Submodule{Name: c.Name, Path: c.Path, URL: url, Branch: c.Branch},
+		w:           w,
+	}
+
+	err = w.r.editConfig(config.LocalScope, func(cfg *config.Config) error {
+		cfg.Submodules[c.Name] = &config.Submodule{Name: c.Name, URL: url}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	e := idx.Add(c.Path)
+	e.Mode = filemode.Submodule
+	e.Hash = head.Hash()
+	e.ModifiedAt = time.Now()
+	if err := w.r.Storer.SetIndex(idx); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Add(gitmodulesFile); err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// checkNewSubmodule returns ErrSubmoduleAlreadyExists if the name or the path
+// of the submodule is already used, or if its path isn't an empty directory.
+func (w *Worktree) checkNewSubmodule(c *config.Submodule) error {
+	m, err := w.readGitmodulesFile()
+	if err != nil {
+		return err
+	}
+
+	if m != nil {
+		for _, sub := range m.Submodules {
+			if sub.Name == c.Name || sub.Path == c.Path {
+				return fmt.Errorf("%w: %s", ErrSubmoduleAlreadyExists, c.Name)
+			}
+		}
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	if _, err := idx.Entry(c.Path); err == nil {
+		return fmt.Errorf("%w: %s is in the index", ErrSubmoduleAlreadyExists, c.Path)
+	}
+
+	fi, err := w.Filesystem.Lstat(c.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		fis, err := w.Filesystem.ReadDir(c.Path)
+		if err != nil || len(fis) == 0 {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w: %s already exists in the worktree", ErrSubmoduleAlreadyExists, c.Path)
+}
+
 func (w *Worktree) newSubmodule(fromModules, fromConfig *config.Submodule) *Submodule {
-	m := &Submodule{w: w}
+	m := &Submodule{w: w, ignore: fromModules.Ignore, shallow: fromModules.Shallow}
 	m.initialized = fromConfig != nil
 
 	if !m.initialized {
@@ -922,58 +1568,279 @@ func (w *Worktree) readGitmodulesFile() (*config.Modules, error) {
 	return m, nil
 }
 
+// editGitmodules changes the .gitmodules file with the given function,
+// keeping the comments and formatting of the submodules left unchanged.
+func (w *Worktree) editGitmodules(f func(m *config.Modules) error) error {
+	if w.isSymlink(gitmodulesFile) {
+		return ErrGitModulesSymlink
+	}
+
+	src, err := util.ReadFile(w.Filesystem, gitmodulesFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	m := config.NewModules()
+	if err := m.Unmarshal(src); err != nil {
+		return err
+	}
+
+	if err := f(m); err != nil {
+		return err
+	}
+
+	b, err := m.MarshalPreserving(src)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFile(w.Filesystem, gitmodulesFile, b, 0o644)
+}
+
+// resolveSubmoduleURL returns the URL of a submodule relative to the URL of
+// the origin remote, or to the worktree if there is no origin remote, if it
+// starts with "./" or "../", as git does.
+func (w *Worktree) resolveSubmoduleURL(url string) (string, error) {
+	if !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url, nil
+	}
+
+	base := filepath.ToSlash(w.Filesystem.Root())
+	if remote, err := w.r.Remote(DefaultRemoteName); err == nil && len(remote.c.URLs) > 0 {
+		base = remote.c.URLs[0]
+	}
+
+	ep, err := transport.NewEndpoint(base)
+	if err != nil {
+		return "", err
+	}
+
+	if ep.Protocol == "file" && !strings.HasPrefix(base, "file://") {
+		return path.Join(base, url), nil
+	}
+
+	ep.Path = path.Join(ep.Path, url)
+	return ep.String(), nil
+}
+
 // Clean the worktree by removing untracked files.
 // An empty dir could be removed - this is what  `git clean -f -d .` does.
 func (w *Worktree) Clean(opts *CleanOptions) error {
-	s, err := w.Status()
+	_, err := w.CleanContext(context.Background(), opts)
+	return err
+}
+
+// CleanContext cleans the worktree as Clean does, and returns the paths
+// removed, or the ones which would be removed if opts.DryRun is set. The
+// directories removed are reported with a trailing slash, instead of their
+// content. Untracked nested repositories are never removed unless opts.Force
+// is set, and the directories left empty by the clean are removed.
+func (w *Worktree) CleanContext(ctx context.Context, opts *CleanOptions) ([]string, error) {
+	if opts == nil {
+		opts = &CleanOptions{}
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	idx, err := w.r.Storer.Index()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	root := ""
-	files, err := w.Filesystem.ReadDir(root)
+	patterns, err := w.ignorePatterns()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return w.doClean(s, opts, root, files)
+
+	c := &cleaner{
+		ctx:         ctx,
+		fs:          w.Filesystem,
+		opts:        opts,
+		tracked:     make(map[string]bool, len(idx.Entries)),
+		trackedDirs: make(map[string]bool),
+	}
+
+	if len(patterns) != 0 {
+		c.m = gitignore.NewMatcher(patterns)
+	}
+
+	for _, e := range idx.Entries {
+		c.tracked[e.Name] = true
+		for dir := path.Dir(e.Name); dir != "." && !c.trackedDirs[dir]; dir = path.Dir(dir) {
+			c.trackedDirs[dir] = true
+		}
+	}
+
+	for _, p := range opts.Paths {
+		p = path.Clean(filepath.ToSlash(p))
+		if p == "." {
+			c.paths = nil
+			break
+		}
+
+		c.paths = append(c.paths, strings.TrimPrefix(p, "/"))
+	}
+
+	removed, _, err := c.clean("")
+	return removed, err
+}
+
+type cleaner struct {
+	ctx         context.Context
+	fs          billy.Filesystem
+	opts        *CleanOptions
+	m           gitignore.Matcher
+	paths       []string
+	tracked     map[string]bool
+	trackedDirs map[string]bool
 }
 
-func (w *Worktree) doClean(status Status, opts *CleanOptions, dir string, files []os.FileInfo) error {
+// clean cleans the given directory, and returns the paths removed under it,
+// and whether the directory itself was removed.
+func (c *cleaner) clean(dir string) ([]string, bool, error) {
+	files, err := c.fs.ReadDir(dir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var removed []string
+	left := len(files)
 	for _, fi := range files {
+		if err := c.ctx.Err(); err != nil {
+			return removed, false, err
+		}
+
 		if fi.Name() == GitDirName {
 			continue
 		}
 
 		// relative path under the root
-		path := filepath.Join(dir, fi.Name())
-		if fi.IsDir() {
-			if !opts.Dir {
+		name := path.Join(dir, fi.Name())
+		if c.tracked[name] {
+			continue
+		}
+
+		if !fi.IsDir() {
+			if !c.inPaths(name) || !c.selected(name, false) {
 				continue
 			}
 
-			subfiles, err := w.Filesystem.ReadDir(path)
-			if err != nil {
-				return err
+			if !c.opts.DryRun {
+				if err := c.fs.Remove(name); err != nil {
+					return removed, false, err
+				}
 			}
-			err = w.doClean(status, opts, path, subfiles)
-			if err != nil {
-				return err
+
+			removed = append(removed, name)
+			left--
+			continue
+		}
+
+		tracked := c.trackedDirs[name]
+		if (!tracked && !c.opts.Dir) || !c.mayContainPaths(name) {
+			continue
+		}
+
+		if !tracked && c.isRepository(name) {
+			if !c.opts.Force || !c.inPaths(name) || !c.selected(name, true) {
+				continue
 			}
-		} else {
-			if status.IsUntracked(path) {
-				if err := w.Filesystem.Remove(path); err != nil {
-					return err
+
+			if !c.opts.DryRun {
+				if err := util.RemoveAll(c.fs, name); err != nil {
+					return removed, false, err
 				}
 			}
+
+			removed = append(removed, name+"/")
+			left--
+			continue
+		}
+
+		sub, dirRemoved, err := c.clean(name)
+		if err != nil {
+			return append(removed, sub...), false, err
+		}
+
+		if dirRemoved {
+			removed = append(removed, name+"/")
+			left--
+			continue
 		}
+
+		removed = append(removed, sub...)
 	}
 
-	if opts.Dir && dir != "" {
-		_, err := removeDirIfEmpty(w.Filesystem, dir)
-		return err
+	if dir == "" || left != 0 {
+		return removed, false, nil
 	}
 
-	return nil
+	// A directory already empty is only removed with Dir, the ones emptied
+	// by the clean are always removed.
+	if len(files) == 0 && (!c.opts.Dir || !c.inPaths(dir) || !c.selected(dir, true)) {
+		return removed, false, nil
+	}
+
+	if c.opts.DryRun {
+		return removed, true, nil
+	}
+
+	ok, err := removeDirIfEmpty(c.fs, dir)
+	return removed, ok, err
+}
+
+// selected returns whether the given untracked path should be removed,
+// depending on whether it is ignored.
+func (c *cleaner) selected(name string, isDir bool) bool {
+	ignored := c.m != nil && c.m.Match(strings.Split(name, "/"), isDir)
+	switch {
+	case c.opts.OnlyIgnored:
+		return ignored
+	case c.opts.RemoveIgnored:
+		return true
+	}
+
+	return !ignored
+}
+
+// inPaths returns whether the given path is matched by the paths to clean.
+func (c *cleaner) inPaths(name string) bool {
+	if len(c.paths) == 0 {
+		return true
+	}
+
+	for _, p := range c.paths {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mayContainPaths returns whether the given directory is, or contains, any
+// of the paths to clean.
+func (c *cleaner) mayContainPaths(dir string) bool {
+	if c.inPaths(dir) {
+		return true
+	}
+
+	for _, p := range c.paths {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRepository returns whether the given directory is the worktree of a
+// nested repository.
+func (c *cleaner) isRepository(dir string) bool {
+	_, err := c.fs.Lstat(path.Join(dir, GitDirName))
+	return err == nil
 }
 
 // GrepResult is structure of a grep result.
@@ -985,20 +1852,72 @@ type GrepResult struct {
 	// Content is the content of the file at the matching line.
 	Content string
 	// TreeName is the name of the tree (reference name/commit hash) at
-	// which the match was performed.
+	// which the match was performed. It is empty when grepping the worktree
+	// or the index.
 	TreeName string
+	// IsContext is true if the line does not match, but is a context line
+	// of a match, as requested by GrepOptions.ContextBefore and
+	// GrepOptions.ContextAfter.
+	IsContext bool
+	// Binary is true if the file is binary and matches. No line is reported
+	// for binary files, only a single result with an empty Content.
+	Binary bool
 }
 
 func (gr GrepResult) String() string {
-	return fmt.Sprintf("%s:%s:%d:%s", gr.TreeName, gr.FileName, gr.LineNumber, gr.Content)
+	name := gr.FileName
+	if gr.TreeName != "" {
+		name = gr.TreeName + ":" + name
+	}
+
+	if gr.Binary {
+		return fmt.Sprintf("Binary file %s matches", name)
+	}
+
+	sep := ":"
+	if gr.IsContext {
+		sep = "-"
+	}
+
+	return fmt.Sprintf("%s%s%d%s%s", name, sep, gr.LineNumber, sep, gr.Content)
 }
 
 // Grep performs grep on a repository.
 func (r *Repository) Grep(opts *GrepOptions) ([]GrepResult, error) {
+	var w *Worktree
+	if opts.Source == GrepWorktree {
+		var err error
+		w, err = r.Worktree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r.grep(w, opts)
+}
+
+// Grep performs grep on a worktree.
+func (w *Worktree) Grep(opts *GrepOptions) ([]GrepResult, error) {
+	return w.r.grep(w, opts)
+}
+
+func (r *Repository) grep(w *Worktree, opts *GrepOptions) ([]GrepResult, error) {
 	if err := opts.validate(r); err != nil {
 		return nil, err
 	}
 
+	g, err := newGrepper(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Source {
+	case GrepWorktree:
+		return g.grepIndex(r, w.Filesystem)
+	case GrepIndex:
+		return g.grepIndex(r, nil)
+	}
+
 	// Obtain commit hash from options (CommitHash or ReferenceName).
 	var commitHash plumbing.Hash
 	// treeName contains the value of TreeName in GrepResult.
@@ -1024,47 +1943,88 @@ func (r *Repository) Grep(opts *GrepOptions) ([]GrepResult, error) {
 	}
 	fileiter := tree.Files()
 
-	return findMatchInFiles(fileiter, treeName, opts)
+	return g.grepFiles(fileiter, treeName)
 }
 
-// Grep performs grep on a worktree.
-func (w *Worktree) Grep(opts *GrepOptions) ([]GrepResult, error) {
-	return w.r.Grep(opts)
+// grepper matches the content of files, as described by GrepOptions.
+type grepper struct {
+	opts     *GrepOptions
+	patterns []*regexp.Regexp
 }
 
-// findMatchInFiles takes a FileIter, worktree name and GrepOptions, and
-// returns a slice of GrepResult containing the result of regex pattern matching
-// in content of all the files.
-func findMatchInFiles(fileiter *object.FileIter, treeName string, opts *GrepOptions) ([]GrepResult, error) {
-	var results []GrepResult
+func newGrepper(opts *GrepOptions) (*grepper, error) {
+	g := &grepper{opts: opts}
+	for _, pattern := range opts.Patterns {
+		if pattern == nil {
+			continue
+		}
 
-	err := fileiter.ForEach(func(file *object.File) error {
-		var fileInPathSpec bool
+		if !opts.IgnoreCase && !opts.WordRegexp {
+			g.patterns = append(g.patterns, pattern)
+			continue
+		}
 
-		// When no pathspecs are provided, search all the files.
-		if len(opts.PathSpecs) == 0 {
-			fileInPathSpec = true
+		expr := pattern.String()
+		if opts.WordRegexp {
+			expr = `\b(?:` + expr + `)\b`
 		}
 
-		// Check if the file name matches with the pathspec. Break out of the
-		// loop once a match is found.
-		for _, pathSpec := range opts.PathSpecs {
-			if pathSpec != nil && pathSpec.MatchString(file.Name) {
-				fileInPathSpec = true
-				break
-			}
+		if opts.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+
+		p, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		g.patterns = append(g.patterns, p)
+	}
+
+	return g, nil
+}
+
+// inPathSpecs returns whether the file name matches any of the pathspecs, and
+// none of the excluded ones.
+func (g *grepper) inPathSpecs(name string) bool {
+	for _, pathSpec := range g.opts.ExcludePathSpecs {
+		if pathSpec != nil && pathSpec.MatchString(name) {
+			return false
+		}
+	}
+
+	// When no pathspecs are provided, search all the files.
+	if len(g.opts.PathSpecs) == 0 {
+		return true
+	}
+
+	// Check if the file name matches with the pathspec.
+	for _, pathSpec := range g.opts.PathSpecs {
+		if pathSpec != nil && pathSpec.MatchString(name) {
+			return true
 		}
+	}
+
+	return false
+}
+
+// grepFiles returns the result of regex pattern matching in content of all
+// the files of the given FileIter.
+func (g *grepper) grepFiles(fileiter *object.FileIter, treeName string) ([]GrepResult, error) {
+	var results []GrepResult
 
+	err := fileiter.ForEach(func(file *object.File) error {
 		// If the file does not match with any of the pathspec, skip it.
-		if !fileInPathSpec {
+		if !g.inPathSpecs(file.Name) || file.Mode == filemode.Submodule {
 			return nil
 		}
 
-		grepResults, err := findMatchInFile(file, treeName, opts)
+		content, isBinary, err := readBlob(&file.Blob)
 		if err != nil {
 			return err
 		}
-		results = append(results, grepResults...)
+
+		results = append(results, g.grepContent(file.Name, treeName, content, isBinary)...)
 
 		return nil
 	})
@@ -1072,50 +2032,148 @@ func findMatchInFiles(fileiter *object.FileIter, treeName string, opts *GrepOpti
 	return results, err
 }
 
-// findMatchInFile takes a single File, worktree name and GrepOptions,
-// and returns a slice of GrepResult containing the result of regex pattern
-// matching in the given file.
-func findMatchInFile(file *object.File, treeName string, opts *GrepOptions) ([]GrepResult, error) {
-	var grepResults []GrepResult
+// grepIndex returns the result of regex pattern matching in content of all
+// the files in the index. If fs is not nil, the content is read from the
+// files in fs instead of the staged blobs, skipping the missing ones.
+func (g *grepper) grepIndex(r *Repository, fs billy.Filesystem) ([]GrepResult, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
 
-	content, err := file.Contents()
+	// The entries are only sorted when the index is written.
+	entries := make([]*index.Entry, len(idx.Entries))
+	copy(entries, idx.Entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	var results []GrepResult
+	for _, e := range entries {
+		if e.Stage != 0 || e.Mode == filemode.Submodule || !g.inPathSpecs(e.Name) {
+			continue
+		}
+
+		var content string
+		var isBinary bool
+		if fs != nil {
+			content, isBinary, err = readWorktreeFile(fs, e.Name, e.Mode)
+			if os.IsNotExist(err) {
+				continue
+			}
+		} else {
+			var blob *object.Blob
+			blob, err = r.BlobObject(e.Hash)
+			if err == nil {
+				content, isBinary, err = readBlob(blob)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, g.grepContent(e.Name, "", content, isBinary)...)
+	}
+
+	return results, nil
+}
+
+// readWorktreeFile returns the content of a file in the worktree, or the
+// target of the symlink if mode is a symlink, as git stores it.
+func readWorktreeFile(fs billy.Filesystem, name string, mode filemode.FileMode) (content string, isBinary bool, err error) {
+	if mode == filemode.Symlink {
+		target, err := fs.Readlink(name)
+		return target, false, err
+	}
+
+	data, err := util.ReadFile(fs, name)
 	if err != nil {
-		return grepResults, err
+		return "", false, err
+	}
+
+	isBinary, err = binary.IsBinary(bytes.NewReader(data))
+	return string(data), isBinary, err
+}
+
+// grepContent returns the result of regex pattern matching in the given
+// content, along with the context lines requested.
+func (g *grepper) grepContent(name, treeName, content string, isBinary bool) []GrepResult {
+	if content == "" {
+		return nil
 	}
 
 	// Split the file content and parse line-by-line.
-	contentByLine := strings.Split(content, "\n")
-	for lineNum, cnt := range contentByLine {
-		addToResult := false
-
-		// Match the patterns and content. Break out of the loop once a
-		// match is found.
-		for _, pattern := range opts.Patterns {
-			if pattern != nil && pattern.MatchString(cnt) {
-				// Add to result only if invert match is not enabled.
-				if !opts.InvertMatch {
-					addToResult = true
-					break
-				}
-			} else if opts.InvertMatch {
-				// If matching fails, and invert match is enabled, add to
-				// results.
-				addToResult = true
-				break
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	selected := make([]bool, len(lines))
+	var found bool
+	for lineNum, cnt := range lines {
+		selected[lineNum] = g.selects(cnt)
+		found = found || selected[lineNum]
+	}
+
+	if !found {
+		return nil
+	}
+
+	if isBinary {
+		return []GrepResult{{FileName: name, TreeName: treeName, Binary: true}}
+	}
+
+	before := max(g.opts.ContextBefore, 0)
+	after := max(g.opts.ContextAfter, 0)
+
+	var grepResults []GrepResult
+	// next is the index of the first line not yet reported, and last the
+	// index of the last context line to report after a match.
+	next, last := 0, -1
+	for lineNum := range lines {
+		if selected[lineNum] {
+			for i := max(lineNum-before, next); i < lineNum; i++ {
+				grepResults = append(grepResults, newGrepResult(name, treeName, lines, i, true))
 			}
+
+			grepResults = append(grepResults, newGrepResult(name, treeName, lines, lineNum, false))
+			next, last = lineNum+1, lineNum+after
+			continue
 		}
 
-		if addToResult {
-			grepResults = append(grepResults, GrepResult{
-				FileName:   file.Name,
-				LineNumber: lineNum + 1,
-				Content:    cnt,
-				TreeName:   treeName,
-			})
+		if lineNum <= last {
+			grepResults = append(grepResults, newGrepResult(name, treeName, lines, lineNum, true))
+			next = lineNum + 1
 		}
 	}
 
-	return grepResults, nil
+	return grepResults
+}
+
+func newGrepResult(name, treeName string, lines []string, i int, isContext bool) GrepResult {
+	return GrepResult{
+		FileName:   name,
+		LineNumber: i + 1,
+		Content:    lines[i],
+		TreeName:   treeName,
+		IsContext:  isContext,
+	}
+}
+
+// selects returns whether the given line is selected by the patterns.
+func (g *grepper) selects(line string) bool {
+	// Match the patterns and content. Break out of the loop once a match is
+	// found.
+	for _, pattern := range g.patterns {
+		if pattern.MatchString(line) {
+			// Select only if invert match is not enabled.
+			if !g.opts.InvertMatch {
+				return true
+			}
+		} else if g.opts.InvertMatch {
+			// If matching fails, and invert match is enabled, select it.
+			return true
+		}
+	}
+
+	return false
 }
 
 // will walk up the directory tree removing all encountered empty
@@ -0,0 +1,140 @@
+package git
+
+import (
+	"os"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+)
+
+const gitattributesFile = ".gitattributes"
+
+// CheckAttributesResult holds the gitattributes of a path, as git check-attr
+// does.
+type CheckAttributesResult struct {
+	// Path is the checked path.
+	Path string
+	// Attributes are the attributes set, unset or set to a value for the
+	// path, by name. The unspecified attributes are left out.
+	Attributes map[string]gitattributes.Attribute
+}
+
+// CheckAttributes returns the gitattributes of the given path, relative to
+// the root of the worktree or absolute, as git check-attr does. Only the given
+// attributes are returned, or all of them if none is given. The attributes
+// missing from the result are unspecified for the path.
+//
+// The attributes are read from, in increasing order of priority, the
+// /etc/gitattributes file, the file declared by the core.attributesFile
+// property or $XDG_CONFIG_HOME/git/attributes, the .gitattributes files from
+// the root of the worktree down to the directory of the path, and the
+// $GIT_DIR/info/attributes file. The macros, such as the built-in binary
+// one, are expanded.
+func (w *Worktree) CheckAttributes(path string, attrs ...string) (map[string]gitattributes.Attribute, error) {
+	results, err := w.CheckAttributesPaths([]string{path}, attrs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return results[0].Attributes, nil
+}
+
+// CheckAttributesPaths returns the gitattributes of each of the given paths,
+// as CheckAttributes does, reading every gitattributes file once.
+func (w *Worktree) CheckAttributesPaths(paths []string, attrs ...string) ([]CheckAttributesResult, error) {
+	global, err := w.globalAttributes()
+	if err != nil {
+		return nil, err
+	}
+
+	var info []gitattributes.MatchAttribute
+	if s, ok := w.r.Storer.(interface{ Filesystem() billy.Filesystem }); ok {
+		info, err = gitattributes.LoadInfoPatterns(s.Filesystem())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dirs := make(map[string][]gitattributes.MatchAttribute)
+	results := make([]CheckAttributesResult, len(paths))
+	for i, p := range paths {
+		results[i].Path = p
+		results[i].Attributes = make(map[string]gitattributes.Attribute)
+
+		name, _, err := w.worktreePath(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" {
+			continue
+		}
+
+		parts := strings.Split(name, "/")
+		stack := append([]gitattributes.MatchAttribute(nil), global...)
+		for j := range parts {
+			key := strings.Join(parts[:j], "/")
+			ps, ok := dirs[key]
+			if !ok {
+				dir := append([]string(nil), parts[:j]...)
+				ps, err = gitattributes.ReadAttributesFile(w.Filesystem, dir, gitattributesFile, j == 0)
+				if err != nil {
+					return nil, err
+				}
+
+				dirs[key] = ps
+			}
+
+			stack = append(stack, ps...)
+		}
+
+		stack = append(stack, info...)
+		attributes, _ := gitattributes.NewMatcher(stack).Match(parts, attrs)
+		for n, a := range attributes {
+			if !a.IsUnspecified() {
+				results[i].Attributes[n] = a
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// globalAttributes returns the gitattributes of the system and of the user,
+// in increasing order of priority.
+func (w *Worktree) globalAttributes() ([]gitattributes.MatchAttribute, error) {
+	cfg, err := w.r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := osfs.New("")
+
+	var attributes []gitattributes.MatchAttribute
+	if os.Getenv("GIT_ATTR_NOSYSTEM") == "" {
+		attributes, err = gitattributes.LoadAttributesFile(fs, gitattributes.SystemAttributesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file := cfg.GetString("core.attributesFile")
+	if file == "" {
+		file = gitattributes.DefaultAttributesFile()
+	}
+
+	if file == "" {
+		return attributes, nil
+	}
+
+	ps, err := gitattributes.LoadAttributesFile(fs, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(attributes, ps...), nil
+}
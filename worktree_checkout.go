@@ -0,0 +1,270 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// CheckoutConflict is a local change that would be overwritten by a
+// checkout.
+type CheckoutConflict struct {
+	// Path is the path of the file.
+	Path string
+	// Untracked is whether the file is untracked, instead of being a staged
+	// or unstaged change of a tracked file.
+	Untracked bool
+}
+
+// CheckoutResolution is the resolution of a CheckoutConflict.
+type CheckoutResolution int8
+
+const (
+	// CheckoutAbort aborts the checkout, with a *CheckoutConflictError,
+	// before anything is changed. It is the default.
+	CheckoutAbort CheckoutResolution = iota
+	// CheckoutOverwrite overwrites the local change with the file of the
+	// commit checked out.
+	CheckoutOverwrite
+	// CheckoutKeep keeps the local change, the file being left as it is in
+	// the index and the worktree.
+	CheckoutKeep
+)
+
+// CheckoutConflictHandler is called for each local change that would be
+// overwritten by a checkout, returning how to resolve it.
+type CheckoutConflictHandler func(CheckoutConflict) CheckoutResolution
+
+// CheckoutConflictError is returned when a checkout is aborted because it
+// would overwrite local changes. Nothing is changed, not even HEAD. It
+// matches ErrUnstagedChanges with errors.Is.
+type CheckoutConflictError struct {
+	// Modified are the paths of the tracked files with local changes, staged
+	// or not.
+	Modified []string
+	// Untracked are the paths of the untracked files.
+	Untracked []string
+}
+
+func (e *CheckoutConflictError) Error() string {
+	var msgs []string
+	if len(e.Modified) != 0 {
+		msgs = append(msgs, fmt.Sprintf(
+			"local changes to the following files would be overwritten by checkout: %s",
+			strings.Join(e.Modified, ", "),
+		))
+	}
+
+	if len(e.Untracked) != 0 {
+		msgs = append(msgs, fmt.Sprintf(
+			"untracked working tree files would be overwritten by checkout: %s",
+			strings.Join(e.Untracked, ", "),
+		))
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Is returns whether target is ErrUnstagedChanges.
+func (e *CheckoutConflictError) Is(target error) bool {
+	return target == ErrUnstagedChanges
+}
+
+// checkoutUpdates returns the paths to update to check out the given commit,
+// the ones differing between the index and the commit, except the staged
+// changes of files unchanged between HEAD and the commit, which are kept.
+// The local changes which would be overwritten are resolved with handler, if
+// not nil, or returned in a *CheckoutConflictError.
+func (w *Worktree) checkoutUpdates(ctx context.Context, commit plumbing.Hash, handler CheckoutConflictHandler) (map[string]bool, error) {
+	target, err := w.commitFiles(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	staged := make(map[string]mergeEntry, len(idx.Entries))
+	for _, e := range idx.Entries {
+		staged[e.Name] = mergeEntry{e.Hash, e.Mode}
+	}
+
+	// With an empty index, as after a clone without checkout, nothing is
+	// checked out yet.
+	head := map[string]mergeEntry{}
+	if len(idx.Entries) != 0 {
+		ref, err := w.r.Head()
+		switch err {
+		case nil:
+			// HEAD may point to an annotated tag when detached.
+			commit, err := w.getCommitFromCheckoutOptions(&CheckoutOptions{Hash: ref.Hash()})
+			if err != nil {
+				return nil, err
+			}
+
+			if head, err = w.commitFiles(commit); err != nil {
+				return nil, err
+			}
+		case plumbing.ErrReferenceNotFound:
+		default:
+			return nil, err
+		}
+	}
+
+	changes, err := w.diffStagingWithWorktree(ctx, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	local := make(map[string]merkletrie.Action, len(changes))
+	for _, ch := range changes {
+		a, err := ch.Action()
+		if err != nil {
+			return nil, err
+		}
+
+		local[nameFromAction(&ch)] = a
+	}
+
+	paths := make(map[string]bool, len(target)+len(staged))
+	for name := range target {
+		paths[name] = true
+	}
+
+	for name := range staged {
+		paths[name] = true
+	}
+
+	update := make(map[string]bool)
+	conflicts := &CheckoutConflictError{}
+	for name := range paths {
+		h, i, m := entryOf(head, name), entryOf(staged, name), entryOf(target, name)
+		if sameEntry(i, m) {
+			continue
+		}
+
+		if !sameEntry(i, h) && sameEntry(h, m) {
+			continue
+		}
+
+		conflict, err := w.checkoutConflict(name, h, i, m, local[name])
+		if err != nil {
+			return nil, err
+		}
+
+		if conflict == nil {
+			update[name] = true
+			continue
+		}
+
+		resolution := CheckoutAbort
+		if handler != nil {
+			resolution = handler(*conflict)
+		}
+
+		switch resolution {
+		case CheckoutOverwrite:
+			update[name] = true
+		case CheckoutKeep:
+		default:
+			if conflict.Untracked {
+				conflicts.Untracked = append(conflicts.Untracked, name)
+			} else {
+				conflicts.Modified = append(conflicts.Modified, name)
+			}
+		}
+	}
+
+	if len(conflicts.Modified) != 0 || len(conflicts.Untracked) != 0 {
+		sort.Strings(conflicts.Modified)
+		sort.Strings(conflicts.Untracked)
+		return nil, conflicts
+	}
+
+	return update, nil
+}
+
+// checkoutConflict returns the conflict of updating the given path, from
+// its HEAD entry h and index entry i to the target entry m, given its local
+// change, or nil if no local change would be lost. A missing entry is nil.
+func (w *Worktree) checkoutConflict(name string, h, i, m *mergeEntry, change merkletrie.Action) (*CheckoutConflict, error) {
+	switch {
+	case !sameEntry(i, h):
+		return &CheckoutConflict{Path: name}, nil
+	case i != nil:
+		// A deleted file is not a conflict, as git does.
+		if change == merkletrie.Modify {
+			return &CheckoutConflict{Path: name}, nil
+		}
+
+		return nil, nil
+	case change != merkletrie.Insert || m == nil:
+		return nil, nil
+	}
+
+	hash, err := w.worktreeFileHash(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if hash == m.hash {
+		return nil, nil
+	}
+
+	return &CheckoutConflict{Path: name, Untracked: true}, nil
+}
+
+// worktreeFileHash returns the hash of the blob of the given file of the
+// worktree, or of the target of a symlink.
+func (w *Worktree) worktreeFileHash(name string) (plumbing.Hash, error) {
+	fi, err := w.Filesystem.Lstat(name)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	var content []byte
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := w.Filesystem.Readlink(name)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		content = []byte(target)
+	} else if content, err = util.ReadFile(w.Filesystem, name); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return plumbing.ComputeHash(plumbing.BlobObject, content), nil
+}
+
+// commitFiles returns the files of the tree of the given commit, by path.
+func (w *Worktree) commitFiles(commit plumbing.Hash) (map[string]mergeEntry, error) {
+	c, err := w.r.CommitObject(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	return treeFiles(t)
+}
+
+func entryOf(files map[string]mergeEntry, name string) *mergeEntry {
+	e, ok := files[name]
+	if !ok {
+		return nil
+	}
+
+	return &e
+}
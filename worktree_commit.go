@@ -2,9 +2,10 @@ package git
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
-	"path"
 	"regexp"
 	"sort"
 	"strings"
@@ -12,6 +13,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/format/index"
+	objfsck "github.com/go-git/go-git/v5/plumbing/fsck"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/storage"
 
@@ -25,6 +27,20 @@ var (
 	// working tree, with no changes to be committed.
 	ErrEmptyCommit = errors.New("cannot create empty commit: clean working tree")
 
+	// ErrIntentToAddNotStaged occurs when a commit is attempted with an
+	// intent-to-add entry in the index, whose content was never added.
+	ErrIntentToAddNotStaged = errors.New("intent-to-add path not added yet")
+
+	// ErrInvalidPath occurs when a path of the index can't be written in a
+	// tree, as git fsck would reject it, such as a path with a ".." or ".git"
+	// component, or a file which is also a directory.
+	ErrInvalidPath = errors.New("invalid path")
+
+	// ErrDetachedHEAD occurs when a commit is attempted with HEAD detached at
+	// an object other than a commit, such as an annotated tag, which can't
+	// be the parent of the commit.
+	ErrDetachedHEAD = errors.New("HEAD is detached at an object that is not a commit")
+
 	// characters to be removed from user name and/or email before using them to build a commit object
 	// See https://git-scm.com/docs/git-commit#_commit_information
 	invalidCharactersRe = regexp.MustCompile(`[<>\n]`)
@@ -33,12 +49,26 @@ var (
 // Commit stores the current contents of the index in a new commit along with
 // a log message from the user describing the changes.
 func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error) {
+	return w.CommitContext(context.Background(), msg, opts)
+}
+
+// CommitContext stores the current contents of the index in a new commit, as
+// Commit does. The context is checked for each file added with
+// CommitOptions.All, and before the commit is written: HEAD is only updated
+// if the commit is complete.
+func (w *Worktree) CommitContext(ctx context.Context, msg string, opts *CommitOptions) (plumbing.Hash, error) {
+	if len(opts.Parents) == 0 {
+		if err := w.checkDetachedHEAD(); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
 	if err := opts.Validate(w.r); err != nil {
 		return plumbing.ZeroHash, err
 	}
 
 	if opts.All {
-		if err := w.autoAddModifiedAndDeleted(); err != nil {
+		if err := w.autoAddModifiedAndDeleted(ctx); err != nil {
 			return plumbing.ZeroHash, err
 		}
 	}
@@ -64,14 +94,21 @@ func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error
 		return plumbing.ZeroHash, err
 	}
 
+	for _, e := range idx.Entries {
+		if e.IntentToAdd {
+			return plumbing.ZeroHash, fmt.Errorf("%w: %s", ErrIntentToAddNotStaged, e.Name)
+		}
+	}
+
 	// First handle the case of the first commit in the repository being empty.
 	if len(opts.Parents) == 0 && len(idx.Entries) == 0 && !opts.AllowEmptyCommits {
 		return plumbing.ZeroHash, ErrEmptyCommit
 	}
 
 	h := &buildTreeHelper{
-		fs: w.Filesystem,
-		s:  w.r.Storer,
+		fs:       w.Filesystem,
+		s:        w.r.Storer,
+		validate: !opts.AllowInvalidPaths,
 	}
 
 	treeHash, err := h.BuildTree(idx, opts)
@@ -92,6 +129,10 @@ func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error
 		return plumbing.ZeroHash, ErrEmptyCommit
 	}
 
+	if err := ctx.Err(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
 	commit, err := w.buildCommitObject(msg, opts, treeHash)
 	if err != nil {
 		return plumbing.ZeroHash, err
@@ -100,8 +141,25 @@ func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error
 	return commit, w.updateHEAD(commit)
 }
 
-func (w *Worktree) autoAddModifiedAndDeleted() error {
-	s, err := w.Status()
+// checkDetachedHEAD returns ErrDetachedHEAD if HEAD is detached at an object
+// other than a commit, as the checkouts of annotated tags used to leave it.
+func (w *Worktree) checkDetachedHEAD() error {
+	head, err := w.r.Storer.Reference(plumbing.HEAD)
+	if err != nil || head.Type() != plumbing.HashReference {
+		return nil
+	}
+
+	obj, err := w.r.Storer.EncodedObject(plumbing.AnyObject, head.Hash())
+	if err != nil || obj.Type() == plumbing.CommitObject {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s %s, check out a branch or the commit it points to",
+		ErrDetachedHEAD, obj.Type(), head.Hash())
+}
+
+func (w *Worktree) autoAddModifiedAndDeleted(ctx context.Context) error {
+	s, err := w.StatusContext(ctx, StatusOptions{Strategy: defaultStatusStrategy})
 	if err != nil {
 		return err
 	}
@@ -112,10 +170,15 @@ func (w *Worktree) autoAddModifiedAndDeleted() error {
 	}
 
 	for path, fs := range s {
-		if fs.Worktree != Modified && fs.Worktree != Deleted {
+		// Only intent-to-add files are reported as added in the worktree.
+		if fs.Worktree != Modified && fs.Worktree != Deleted && fs.Worktree != Added {
 			continue
 		}
 
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		if _, _, err := w.doAddFile(idx, s, path, nil); err != nil {
 			return err
 		}
@@ -147,6 +210,7 @@ func (w *Worktree) buildCommitObject(msg string, opts *CommitOptions, tree plumb
 		Message:      msg,
 		TreeHash:     tree,
 		ParentHashes: opts.Parents,
+		Encoding:     opts.Encoding,
 	}
 
 	// Convert SignKey into a Signer if set. Existing Signer should take priority.
@@ -196,6 +260,9 @@ func (s *gpgSigner) Sign(message io.Reader) ([]byte, error) {
 type buildTreeHelper struct {
 	fs billy.Filesystem
 	s  storage.Storer
+	// validate is whether the paths of the entries are checked to be valid,
+	// and unique.
+	validate bool
 
 	trees   map[string]*object.Tree
 	entries map[string]*object.TreeEntry
@@ -218,39 +285,85 @@ func (h *buildTreeHelper) BuildTree(idx *index.Index, opts *CommitOptions) (plum
 }
 
 func (h *buildTreeHelper) commitIndexEntry(e *index.Entry) error {
+	if h.validate {
+		if err := validIndexPath(e.Name); err != nil {
+			return err
+		}
+	}
+
 	parts := strings.Split(e.Name, "/")
 
 	var fullpath string
 	for _, part := range parts {
 		parent := fullpath
-		fullpath = path.Join(fullpath, part)
+		fullpath = joinTreePath(fullpath, part)
 
-		h.doBuildTree(e, parent, fullpath)
+		if err := h.doBuildTree(e, parent, fullpath); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (h *buildTreeHelper) doBuildTree(e *index.Entry, parent, fullpath string) {
+func (h *buildTreeHelper) doBuildTree(e *index.Entry, parent, fullpath string) error {
 	if _, ok := h.trees[fullpath]; ok {
-		return
+		if h.validate && fullpath == e.Name {
+			return fmt.Errorf("%w %q: file is also a directory", ErrInvalidPath, e.Name)
+		}
+
+		return nil
 	}
 
 	if _, ok := h.entries[fullpath]; ok {
-		return
+		if h.validate {
+			return fmt.Errorf("%w %q: duplicate entry %q", ErrInvalidPath, e.Name, fullpath)
+		}
+
+		return nil
 	}
 
-	te := object.TreeEntry{Name: path.Base(fullpath)}
+	te := object.TreeEntry{Name: fullpath[strings.LastIndexByte(fullpath, '/')+1:]}
 
 	if fullpath == e.Name {
 		te.Mode = e.Mode
 		te.Hash = e.Hash
+		h.entries[fullpath] = &te
 	} else {
 		te.Mode = filemode.Dir
 		h.trees[fullpath] = &object.Tree{}
 	}
 
 	h.trees[parent].Entries = append(h.trees[parent].Entries, te)
+	return nil
+}
+
+// joinTreePath joins the path of a tree and the name of one of its entries,
+// without cleaning them, as the names may be invalid.
+func joinTreePath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+
+	return parent + "/" + name
+}
+
+// validIndexPath returns an ErrInvalidPath error if the given path of the
+// index has a component git fsck rejects, or a backslash, which git only
+// accepts when core.protectNTFS is false.
+func validIndexPath(name string) error {
+	for _, part := range strings.Split(name, "/") {
+		_, msg := objfsck.CheckEntryName(part)
+		if msg == "" && strings.IndexByte(part, '\\') >= 0 {
+			msg = fmt.Sprintf("backslash in %q", part)
+		}
+
+		if msg != "" {
+			return fmt.Errorf("%w %q: %s", ErrInvalidPath, name, msg)
+		}
+	}
+
+	return nil
 }
 
 type sortableEntries []object.TreeEntry
@@ -272,7 +385,7 @@ func (h *buildTreeHelper) copyTreeToStorageRecursive(parent string, t *object.Tr
 			continue
 		}
 
-		path := path.Join(parent, e.Name)
+		path := joinTreePath(parent, e.Name)
 
 		var err error
 		e.Hash, err = h.copyTreeToStorageRecursive(path, h.trees[path])
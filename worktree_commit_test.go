@@ -2,6 +2,7 @@ package git
 
 import (
 	"bytes"
+	"context"
 	"log"
 	"os"
 	"os/exec"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -514,6 +516,41 @@ func (s *WorktreeSuite) TestCommitSignBadKey(c *C) {
 	c.Assert(err, Equals, errors.InvalidArgumentError("signing key is encrypted"))
 }
 
+func (s *WorktreeSuite) TestCommitEncoding(c *C) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	hash, err := w.Commit("caf\xe9\n", &CommitOptions{
+		Author:            defaultSignature(),
+		Encoding:          "ISO-8859-1",
+		AllowEmptyCommits: true,
+	})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(hash)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Encoding, Equals, object.MessageEncoding("ISO-8859-1"))
+	c.Assert(commit.Message, Equals, "caf\xe9\n")
+
+	msg, err := commit.UTF8Message()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "café\n")
+
+	if _, err := exec.LookPath("git"); err != nil {
+		c.Skip("git not found")
+	}
+
+	cmd := exec.Command("git", "log", "-1", "--format=%s")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	c.Assert(string(out), Equals, "café\n")
+}
+
 func (s *WorktreeSuite) TestCommitTreeSort(c *C) {
 	fs := s.TemporalFilesystem(c)
 
@@ -545,7 +582,7 @@ func (s *WorktreeSuite) TestCommitTreeSort(c *C) {
 	})
 	c.Assert(err, IsNil)
 
-	err = r.Push(&PushOptions{})
+	err = r.Push(&PushOptions{RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"}})
 	c.Assert(err, IsNil)
 
 	cmd := exec.Command("git", "fsck")
@@ -687,6 +724,19 @@ func lenIterEncodedObjects(iter storer.EncodedObjectIter) int {
 	return count
 }
 
+func (s *WorktreeSuite) TestCommitDetachedAtTag(c *C) {
+	f := fixtures.ByTag("tags").One()
+	r := s.NewRepositoryWithEmptyWorktree(f)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	tag := plumbing.NewHash("b742a2a9fa0afcfa9a6fad080980fbc26b007c69")
+	c.Assert(r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, tag)), IsNil)
+
+	_, err = w.Commit("foo", &CommitOptions{Author: defaultSignature(), AllowEmptyCommits: true})
+	c.Assert(err, ErrorMatches, "HEAD is detached at an object that is not a commit: tag b742a2a9fa0afcfa9a6fad080980fbc26b007c69.*")
+}
+
 func defaultSignature() *object.Signature {
 	when, _ := time.Parse(object.DateFormat, "Thu May 04 00:03:43 2017 +0200")
 	return &object.Signature{
@@ -784,3 +834,32 @@ CgLH6twOqdSFWqB/4ASDMsNeLeKX3WOYKYYMlE01cj3T1m6dpRUO
 `
 
 const keyPassphrase = "abcdef0123456789"
+
+func (s *WorktreeSuite) TestCommitContextCancelled(c *C) {
+	fs := memfs.New()
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: fs,
+	}
+
+	err := w.Checkout(&CheckoutOptions{})
+	c.Assert(err, IsNil)
+
+	head, err := w.r.Head()
+	c.Assert(err, IsNil)
+
+	util.WriteFile(fs, "LICENSE", []byte("foo"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = w.CommitContext(ctx, "foo\n", &CommitOptions{
+		All:    true,
+		Author: defaultSignature(),
+	})
+	c.Assert(err, Equals, context.Canceled)
+
+	after, err := w.r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(after.Hash(), Equals, head.Hash())
+}
@@ -0,0 +1,828 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/binary"
+	"github.com/go-git/go-git/v5/utils/merge"
+)
+
+var (
+	// ErrMergeConflict is returned when a merge, or a rebase, stops because
+	// of conflicts. They are left in the index and the worktree, to be
+	// resolved, as git does.
+	ErrMergeConflict = errors.New("merge conflict")
+	// ErrUnrelatedHistories is returned when merging commits without a
+	// common ancestor.
+	ErrUnrelatedHistories = errors.New("refusing to merge unrelated histories")
+)
+
+const (
+	mergeHeadRef  plumbing.ReferenceName = plumbing.MergeHead
+	origHeadRef   plumbing.ReferenceName = plumbing.OrigHead
+	rebaseHeadRef plumbing.ReferenceName = "REBASE_HEAD"
+
+	mergeMsgFile   = "MERGE_MSG"
+	mergeModeFile  = "MERGE_MODE"
+	rebaseMergeDir = "rebase-merge"
+)
+
+// pullMode is how Pull integrates the fetched head in the current branch.
+type pullMode struct {
+	ffOnly bool
+	noFF   bool
+	rebase bool
+}
+
+// pullMode returns the pull mode from the options, and from the pull.ff,
+// pull.rebase and branch.<name>.rebase config when not set in the options.
+func (w *Worktree) pullMode(o *PullOptions, head *plumbing.Reference) (pullMode, error) {
+	m := pullMode{ffOnly: o.FFOnly, noFF: o.NoFF, rebase: o.Rebase}
+
+	cfg, err := w.r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return m, err
+	}
+
+	if !o.FFOnly && !o.NoFF {
+		switch strings.ToLower(cfg.GetString("pull.ff")) {
+		case "only":
+			m.ffOnly = true
+		case "false":
+			m.noFF = true
+		}
+	}
+
+	if !o.Rebase {
+		rebase := cfg.GetString("pull.rebase")
+		if head.Name().IsBranch() {
+			if v := cfg.GetAll("branch." + head.Name().Short() + ".rebase"); len(v) > 0 {
+				rebase = v[len(v)-1]
+			}
+		}
+
+		// Any value other than false, such as merges or interactive, rebases.
+		switch strings.ToLower(rebase) {
+		case "", "false", "no", "off", "0":
+		default:
+			m.rebase = true
+		}
+	}
+
+	return m, nil
+}
+
+// pullIntegrate integrates the fetched head in the current branch, by
+// rebasing or merging.
+func (w *Worktree) pullIntegrate(o *PullOptions, m pullMode, remote *Remote, head, ref *plumbing.Reference) error {
+	if m.rebase {
+		return w.pullRebase(o, head, ref)
+	}
+
+	url := o.RemoteURL
+	if url == "" && len(remote.c.URLs) > 0 {
+		url = remote.c.URLs[0]
+	}
+
+	return w.pullMerge(o, m, url, head, ref)
+}
+
+// pullMerge merges the fetched head in the current branch, creating a merge
+// commit. On conflicts, MERGE_HEAD and MERGE_MSG are written, for the merge
+// to be concluded with a commit, as git does.
+func (w *Worktree) pullMerge(o *PullOptions, m pullMode, url string, head, ref *plumbing.Reference) error {
+	if err := w.checkMergeClean(); err != nil {
+		return err
+	}
+
+	opts := &CommitOptions{
+		Author:    o.Author,
+		Committer: o.Committer,
+		SignKey:   o.SignKey,
+		Signer:    o.Signer,
+		Parents:   []plumbing.Hash{head.Hash(), ref.Hash()},
+	}
+
+	if err := opts.Validate(w.r); err != nil {
+		return err
+	}
+
+	ours, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	theirs, err := w.r.CommitObject(ref.Hash())
+	if err != nil {
+		return err
+	}
+
+	result, err := w.r.mergeCommits(ours, theirs, merge.Labels{Ours: "HEAD", Theirs: ref.Hash().String()})
+	if err != nil {
+		return err
+	}
+
+	if err := w.r.Storer.SetReference(plumbing.NewHashReference(origHeadRef, head.Hash())); err != nil {
+		return err
+	}
+
+	if err := w.applyMerge(result); err != nil {
+		return err
+	}
+
+	msg := mergeMessage(ref.Name(), url)
+	if len(result.conflicts) > 0 {
+		var mode string
+		if m.noFF {
+			mode = "no-ff"
+		}
+
+		if err := w.r.Storer.SetReference(plumbing.NewHashReference(mergeHeadRef, ref.Hash())); err != nil {
+			return err
+		}
+
+		if err := w.r.writeGitDirFile(mergeMsgFile, msg+conflictsComment(result)); err != nil {
+			return err
+		}
+
+		if err := w.r.writeGitDirFile(mergeModeFile, mode); err != nil {
+			return err
+		}
+
+		return result.err()
+	}
+
+	commit, err := w.buildCommitObject(msg, opts, result.tree.Hash)
+	if err != nil {
+		return err
+	}
+
+	return w.updateHEAD(commit)
+}
+
+// pullRebase rebases the commits of the current branch missing from the
+// fetched head on top of it. On conflicts, the rebase stops with HEAD
+// detached and the rebase-merge state written, for it to be continued, as
+// git does.
+func (w *Worktree) pullRebase(o *PullOptions, head, onto *plumbing.Reference) error {
+	if err := w.checkMergeClean(); err != nil {
+		return err
+	}
+
+	// The rebased commits keep their author, only the committer is needed.
+	committer := o.Committer
+	if committer == nil {
+		committer = o.Author
+	}
+
+	opts := &CommitOptions{
+		Author:    committer,
+		Committer: committer,
+		SignKey:   o.SignKey,
+		Signer:    o.Signer,
+	}
+
+	if err := opts.Validate(w.r); err != nil {
+		return err
+	}
+
+	commits, err := w.r.rebaseCommits(head.Hash(), onto.Hash())
+	if err != nil {
+		return err
+	}
+
+	current, err := w.r.CommitObject(onto.Hash())
+	if err != nil {
+		return err
+	}
+
+	currentTree, err := current.Tree()
+	if err != nil {
+		return err
+	}
+
+	if err := w.r.Storer.SetReference(plumbing.NewHashReference(origHeadRef, head.Hash())); err != nil {
+		return err
+	}
+
+	if err := w.setHEADToCommit(current.Hash); err != nil {
+		return err
+	}
+
+	if err := w.applyMerge(&treeMerge{tree: currentTree}); err != nil {
+		return err
+	}
+
+	for i, c := range commits {
+		var base plumbing.Hash
+		if c.NumParents() > 0 {
+			parent, err := c.Parent(0)
+			if err != nil {
+				return err
+			}
+
+			base = parent.TreeHash
+		}
+
+		result, err := w.r.mergeTrees(base, current.TreeHash, c.TreeHash, merge.Labels{
+			Ours:   "HEAD",
+			Theirs: fmt.Sprintf("%s (%s)", c.Hash.String()[:7], subject(c.Message)),
+		})
+		if err != nil {
+			return err
+		}
+
+		// The commits whose changes are already upstream are dropped.
+		if len(result.conflicts) == 0 && result.tree.Hash == current.TreeHash {
+			continue
+		}
+
+		if err := w.applyMerge(result); err != nil {
+			return err
+		}
+
+		if len(result.conflicts) > 0 {
+			if err := w.writeRebaseState(head, onto.Hash(), commits, i); err != nil {
+				return err
+			}
+
+			return result.err()
+		}
+
+		author := c.Author
+		opts.Author = &author
+		opts.Parents = []plumbing.Hash{current.Hash}
+		h, err := w.buildCommitObject(c.Message, opts, result.tree.Hash)
+		if err != nil {
+			return err
+		}
+
+		if err := w.setHEADToCommit(h); err != nil {
+			return err
+		}
+
+		if current, err = w.r.CommitObject(h); err != nil {
+			return err
+		}
+	}
+
+	if !head.Name().IsBranch() {
+		return nil
+	}
+
+	if err := w.r.Storer.SetReference(plumbing.NewHashReference(head.Name(), current.Hash)); err != nil {
+		return err
+	}
+
+	return w.r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, head.Name()))
+}
+
+// writeRebaseState writes the rebase-merge directory of a rebase stopped on
+// the commit n of the given ones, and REBASE_HEAD.
+func (w *Worktree) writeRebaseState(head *plumbing.Reference, onto plumbing.Hash, commits []*object.Commit, n int) error {
+	c := commits[n]
+	if err := w.r.Storer.SetReference(plumbing.NewHashReference(rebaseHeadRef, c.Hash)); err != nil {
+		return err
+	}
+
+	headName := "detached HEAD"
+	if head.Name().IsBranch() {
+		headName = head.Name().String()
+	}
+
+	pick := func(commits []*object.Commit) string {
+		var b strings.Builder
+		for _, c := range commits {
+			fmt.Fprintf(&b, "pick %s %s\n", c.Hash, subject(c.Message))
+		}
+
+		return b.String()
+	}
+
+	for name, content := range map[string]string{
+		"head-name":       headName + "\n",
+		"onto":            onto.String() + "\n",
+		"orig-head":       head.Hash().String() + "\n",
+		"done":            pick(commits[:n+1]),
+		"git-rebase-todo": pick(commits[n+1:]),
+		"msgnum":          fmt.Sprintf("%d\n", n+1),
+		"end":             fmt.Sprintf("%d\n", len(commits)),
+		"stopped-sha":     c.Hash.String() + "\n",
+		"message":         c.Message,
+		"author-script": fmt.Sprintf("GIT_AUTHOR_NAME=%s\nGIT_AUTHOR_EMAIL=%s\nGIT_AUTHOR_DATE=%s\n",
+			shellQuote(c.Author.Name), shellQuote(c.Author.Email),
+			shellQuote(fmt.Sprintf("@%d %s", c.Author.When.Unix(), c.Author.When.Format("-0700")))),
+	} {
+		if err := w.r.writeGitDirFile(path.Join(rebaseMergeDir, name), content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebaseCommits returns the commits reachable from head but not from onto,
+// without the merges, parents first, as git rebase picks them.
+func (r *Repository) rebaseCommits(head, onto plumbing.Hash) ([]*object.Commit, error) {
+	upstream, err := r.CommitObject(onto)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+	err = object.NewCommitPreorderIter(upstream, nil, nil).ForEach(func(c *object.Commit) error {
+		seen[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := r.CommitObject(head)
+	if err != nil {
+		return nil, err
+	}
+
+	// Iterative depth-first walk, each commit added after its parents.
+	type frame struct {
+		commit  *object.Commit
+		visited bool
+	}
+
+	var commits []*object.Commit
+	stack := []frame{{commit: start}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if f.visited {
+			if f.commit.NumParents() <= 1 {
+				commits = append(commits, f.commit)
+			}
+
+			continue
+		}
+
+		if seen[f.commit.Hash] {
+			continue
+		}
+
+		seen[f.commit.Hash] = true
+		stack = append(stack, frame{commit: f.commit, visited: true})
+		for i := f.commit.NumParents() - 1; i >= 0; i-- {
+			p, err := f.commit.Parent(i)
+			if err != nil {
+				return nil, err
+			}
+
+			stack = append(stack, frame{commit: p})
+		}
+	}
+
+	return commits, nil
+}
+
+// mergeEntry is a file of a tree, or of the merge of trees.
+type mergeEntry struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+}
+
+// mergeConflict is a conflicting file of a merge, with its base, ours and
+// theirs versions, nil when missing.
+type mergeConflict struct {
+	path   string
+	stages [3]*mergeEntry
+}
+
+// treeMerge is the result of the merge of trees.
+type treeMerge struct {
+	// tree is the merged tree, with the conflicting files as they are
+	// written in the worktree.
+	tree      *object.Tree
+	conflicts []mergeConflict
+}
+
+func (m *treeMerge) err() error {
+	paths := make([]string, len(m.conflicts))
+	for i, c := range m.conflicts {
+		paths[i] = c.path
+	}
+
+	return fmt.Errorf("%w in %s", ErrMergeConflict, strings.Join(paths, ", "))
+}
+
+// mergeCommits merges the trees of the commits, from their merge base.
+func (r *Repository) mergeCommits(ours, theirs *object.Commit, labels merge.Labels) (*treeMerge, error) {
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bases) == 0 {
+		return nil, ErrUnrelatedHistories
+	}
+
+	return r.mergeTrees(bases[0].TreeHash, ours.TreeHash, theirs.TreeHash, labels)
+}
+
+// mergeTrees merges the changes from the base to the theirs tree into the
+// ours tree, a zero hash being an empty tree. The files changed on both
+// sides are merged line by line, and the ones that can't be merged are
+// returned as conflicts.
+func (r *Repository) mergeTrees(base, ours, theirs plumbing.Hash, labels merge.Labels) (*treeMerge, error) {
+	var files [3]map[string]mergeEntry
+	paths := make(map[string]bool)
+	for i, h := range []plumbing.Hash{base, ours, theirs} {
+		files[i] = make(map[string]mergeEntry)
+		if h.IsZero() {
+			continue
+		}
+
+		t, err := r.TreeObject(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if files[i], err = treeFiles(t); err != nil {
+			return nil, err
+		}
+
+		for p := range files[i] {
+			paths[p] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+
+	sort.Strings(sorted)
+
+	result := &treeMerge{}
+	merged := make(map[string]mergeEntry)
+	for _, p := range sorted {
+		var entries [3]*mergeEntry
+		for i := range files {
+			if e, ok := files[i][p]; ok {
+				entries[i] = &e
+			}
+		}
+
+		b, o, t := entries[0], entries[1], entries[2]
+
+		var e *mergeEntry
+		clean := true
+		switch {
+		case sameEntry(o, t), sameEntry(b, t):
+			e = o
+		case sameEntry(b, o):
+			e = t
+		default:
+			var err error
+			if e, clean, err = r.mergeFile(b, o, t, labels); err != nil {
+				return nil, err
+			}
+		}
+
+		if e != nil {
+			merged[p] = *e
+		}
+
+		if !clean {
+			result.conflicts = append(result.conflicts, mergeConflict{path: p, stages: entries})
+		}
+	}
+
+	for p := range merged {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if _, ok := merged[dir]; ok {
+				return nil, fmt.Errorf("%w: %s is both a file and a directory", ErrMergeConflict, dir)
+			}
+		}
+	}
+
+	var err error
+	result.tree, err = r.buildMergeTree(merged)
+	return result, err
+}
+
+// mergeFile merges the changes of a file changed on both sides. It returns
+// the merged file, or the one to write in the worktree on conflicts, and
+// whether the merge is clean.
+func (r *Repository) mergeFile(b, o, t *mergeEntry, labels merge.Labels) (*mergeEntry, bool, error) {
+	// Modified on a side and deleted on the other one.
+	if o == nil || t == nil {
+		if o == nil {
+			return t, false, nil
+		}
+
+		return o, false, nil
+	}
+
+	if !o.mode.IsRegular() || !t.mode.IsRegular() {
+		return o, false, nil
+	}
+
+	mode, clean := o.mode, true
+	switch {
+	case o.mode == t.mode:
+	case b != nil && b.mode == o.mode:
+		mode = t.mode
+	case b != nil && b.mode == t.mode:
+	default:
+		clean = false
+	}
+
+	if o.hash == t.hash {
+		return &mergeEntry{o.hash, mode}, clean, nil
+	}
+
+	contents := make([]string, 3)
+	for i, e := range []*mergeEntry{b, o, t} {
+		if e == nil {
+			continue
+		}
+
+		blob, err := r.BlobObject(e.hash)
+		if err != nil {
+			return nil, false, err
+		}
+
+		content, isBinary, err := readBlob(blob)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if isBinary {
+			return o, false, nil
+		}
+
+		contents[i] = content
+	}
+
+	text, conflict := merge.Text(contents[0], contents[1], contents[2], labels)
+
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(text)))
+
+	writer, err := obj.Writer()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if _, err := io.WriteString(writer, text); err != nil {
+		return nil, false, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, false, err
+	}
+
+	h, err := r.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &mergeEntry{h, mode}, clean && !conflict, nil
+}
+
+func (r *Repository) buildMergeTree(files map[string]mergeEntry) (*object.Tree, error) {
+	idx := &index.Index{}
+	for p, e := range files {
+		idx.Entries = append(idx.Entries, &index.Entry{Name: p, Hash: e.hash, Mode: e.mode})
+	}
+
+	sort.Slice(idx.Entries, func(i, j int) bool {
+		return idx.Entries[i].Name < idx.Entries[j].Name
+	})
+
+	h := &buildTreeHelper{s: r.Storer}
+	hash, err := h.BuildTree(idx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.TreeObject(hash)
+}
+
+// applyMerge updates the index and the worktree to the merged tree, with the
+// conflicting files in the index at their base, ours and theirs stages.
+func (w *Worktree) applyMerge(m *treeMerge) error {
+	prev, err := w.indexEntries()
+	if err != nil {
+		return err
+	}
+
+	if err := w.resetIndex(context.Background(), m.tree, nil, nil); err != nil {
+		return err
+	}
+
+	if err := w.resetWorktree(context.Background(), m.tree, nil, prev, nil); err != nil {
+		return err
+	}
+
+	if len(m.conflicts) == 0 {
+		return nil
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range m.conflicts {
+		if _, err := idx.Remove(c.path); err != nil && err != index.ErrEntryNotFound {
+			return err
+		}
+
+		for i, e := range c.stages {
+			if e == nil {
+				continue
+			}
+
+			idx.Entries = append(idx.Entries, &index.Entry{
+				Name:  c.path,
+				Hash:  e.hash,
+				Mode:  e.mode,
+				Stage: index.Stage(i + 1),
+			})
+		}
+	}
+
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		a, b := idx.Entries[i], idx.Entries[j]
+		return a.Name < b.Name || a.Name == b.Name && a.Stage < b.Stage
+	})
+
+	return w.r.Storer.SetIndex(idx)
+}
+
+// checkMergeClean returns ErrWorktreeNotClean if the index or the worktree
+// have changes to tracked files, that a merge would overwrite.
+func (w *Worktree) checkMergeClean() error {
+	s, err := w.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, fs := range s {
+		if fs.Staging == Untracked && fs.Worktree == Untracked {
+			continue
+		}
+
+		if fs.Staging != Unmodified || fs.Worktree != Unmodified {
+			return ErrWorktreeNotClean
+		}
+	}
+
+	return nil
+}
+
+// writeGitDirFile writes a file in the git directory, if the repository is
+// stored in a filesystem.
+func (r *Repository) writeGitDirFile(name, content string) error {
+	fs, ok := r.Storer.(interface{ Filesystem() billy.Filesystem })
+	if !ok {
+		return nil
+	}
+
+	return util.WriteFile(fs.Filesystem(), name, []byte(content), 0o644)
+}
+
+// removeGitDirFile removes a file of the git directory, if the repository is
+// stored in a filesystem and the file exists.
+func (r *Repository) removeGitDirFile(name string) error {
+	fs, ok := r.Storer.(interface{ Filesystem() billy.Filesystem })
+	if !ok {
+		return nil
+	}
+
+	err := fs.Filesystem().Remove(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// removeMergeState removes the state of a merge or a cherry-pick stopped on
+// conflicts.
+func (r *Repository) removeMergeState() error {
+	for _, name := range []plumbing.ReferenceName{mergeHeadRef, plumbing.CherryPickHead} {
+		if _, err := r.Storer.Reference(name); err != nil {
+			continue
+		}
+
+		if err := r.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range []string{mergeMsgFile, mergeModeFile} {
+		if err := r.removeGitDirFile(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// treeFiles returns the files of the tree, by path.
+func treeFiles(t *object.Tree) (map[string]mergeEntry, error) {
+	files := make(map[string]mergeEntry)
+	walker := object.NewTreeWalker(t, true, nil)
+	defer walker.Close()
+
+	for {
+		name, e, err := walker.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if e.Mode != filemode.Dir {
+			files[name] = mergeEntry{e.Hash, e.Mode}
+		}
+	}
+}
+
+func sameEntry(a, b *mergeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+func readBlob(b *object.Blob) (content string, isBinary bool, err error) {
+	reader, err := b.Reader()
+	if err != nil {
+		return "", false, err
+	}
+
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", false, err
+	}
+
+	isBinary, err = binary.IsBinary(bytes.NewReader(data))
+	return string(data), isBinary, err
+}
+
+// mergeMessage returns the message of the merge commit of a pull, as git
+// writes it.
+func mergeMessage(name plumbing.ReferenceName, url string) string {
+	var what string
+	switch {
+	case name.IsBranch():
+		what = fmt.Sprintf("branch '%s'", name.Short())
+	case name.IsTag():
+		what = fmt.Sprintf("tag '%s'", name.Short())
+	default:
+		what = fmt.Sprintf("'%s'", name.String())
+	}
+
+	return fmt.Sprintf("Merge %s of %s\n", what, url)
+}
+
+func conflictsComment(m *treeMerge) string {
+	var b strings.Builder
+	b.WriteString("\n# Conflicts:\n")
+	for _, c := range m.conflicts {
+		fmt.Fprintf(&b, "#\t%s\n", c.path)
+	}
+
+	return b.String()
+}
+
+// subject returns the first line of a commit message.
+func subject(msg string) string {
+	line, _, _ := strings.Cut(strings.TrimLeft(msg, "\n"), "\n")
+	return line
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -2,19 +2,25 @@ package git
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/internal/pathspec"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/go-git/go-git/v5/plumbing/format/index"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 	"github.com/go-git/go-git/v5/utils/merkletrie"
 	"github.com/go-git/go-git/v5/utils/merkletrie/filesystem"
@@ -32,6 +38,11 @@ var (
 	// ErrUnsupportedStatusStrategy occurs when an invalid StatusStrategy is used
 	// when processing the Worktree status.
 	ErrUnsupportedStatusStrategy = errors.New("unsupported status strategy")
+	// ErrPathOutsideWorktree occurs when a path is not in the worktree.
+	ErrPathOutsideWorktree = errors.New("path is outside the worktree")
+	// ErrNotRegularFile occurs when a Chmod is done on a symlink or a
+	// submodule.
+	ErrNotRegularFile = errors.New("not a regular file")
 )
 
 // Status returns the working tree status.
@@ -42,12 +53,32 @@ func (w *Worktree) Status() (Status, error) {
 // StatusOptions defines the options for Worktree.StatusWithOptions().
 type StatusOptions struct {
 	Strategy StatusStrategy
+	// Submodules reports the submodules with modified or untracked content
+	// as modified too, following their ignore setting and recursing into
+	// the nested submodules, as git status does. The changes of each
+	// submodule, such as "new commits, modified content", are described in
+	// the Extra field of its FileStatus.
+	Submodules bool
+	// Pathspec limits the status to the paths matching the given git
+	// pathspecs, such as "dir", "*.go" or ":(exclude)vendor".
+	Pathspec []string
 }
 
 // StatusWithOptions returns the working tree status.
 func (w *Worktree) StatusWithOptions(o StatusOptions) (Status, error) {
+	return w.StatusContext(context.Background(), o)
+}
+
+// StatusContext returns the working tree status, as StatusWithOptions does.
+// The context is checked for each file compared, and for each submodule.
+func (w *Worktree) StatusContext(ctx context.Context, o StatusOptions) (Status, error) {
 	var hash plumbing.Hash
 
+	ps, err := pathspec.Parse(o.Pathspec...)
+	if err != nil {
+		return nil, err
+	}
+
 	ref, err := w.r.Head()
 	if err != nil && err != plumbing.ErrReferenceNotFound {
 		return nil, err
@@ -57,16 +88,95 @@ func (w *Worktree) StatusWithOptions(o StatusOptions) (Status, error) {
 		hash = ref.Hash()
 	}
 
-	return w.status(o.Strategy, hash)
+	s, err := w.status(ctx, o.Strategy, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Submodules {
+		if err := w.addSubmodulesStatus(ctx, s); err != nil {
+			return s, err
+		}
+	}
+
+	if len(o.Pathspec) != 0 {
+		for name := range s {
+			if !ps.Match(name) {
+				delete(s, name)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// addSubmodulesStatus reports the submodules with changes as modified in the
+// worktree, as git status does, unless their ignore setting says otherwise.
+func (w *Worktree) addSubmodulesStatus(ctx context.Context, s Status) error {
+	subs, err := w.Submodules()
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		path := sub.c.Path
+		if sub.ignoreSetting() == SubmoduleIgnoreAll {
+			if file, ok := s[path]; ok {
+				file.Worktree = Unmodified
+				if file.Staging == Unmodified {
+					delete(s, path)
+				}
+			}
+
+			continue
+		}
+
+		status, err := sub.StatusWithOptions(&SubmoduleStatusOptions{Recursive: true})
+		if err != nil {
+			return err
+		}
+
+		var changes []string
+		if status.HasNewCommits() {
+			changes = append(changes, "new commits")
+		}
+
+		if status.ModifiedContent {
+			changes = append(changes, "modified content")
+		}
+
+		if status.UntrackedContent {
+			changes = append(changes, "untracked content")
+		}
+
+		if len(changes) == 0 {
+			continue
+		}
+
+		file, ok := s[path]
+		if !ok {
+			file = &FileStatus{Staging: Unmodified}
+			s[path] = file
+		}
+
+		file.Worktree = Modified
+		file.Extra = strings.Join(changes, ", ")
+	}
+
+	return nil
 }
 
-func (w *Worktree) status(ss StatusStrategy, commit plumbing.Hash) (Status, error) {
+func (w *Worktree) status(ctx context.Context, ss StatusStrategy, commit plumbing.Hash) (Status, error) {
 	s, err := ss.new(w)
 	if err != nil {
 		return nil, err
 	}
 
-	left, err := w.diffCommitWithStaging(commit, false)
+	left, err := w.diffCommitWithStaging(ctx, commit, false)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +200,7 @@ func (w *Worktree) status(ss StatusStrategy, commit plumbing.Hash) (Status, erro
 		}
 	}
 
-	right, err := w.diffStagingWithWorktree(false, true)
+	right, err := w.statusStagingWithWorktree(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +227,33 @@ func (w *Worktree) status(ss StatusStrategy, commit plumbing.Hash) (Status, erro
 		}
 	}
 
-	return s, nil
+	return s, w.statusIntentToAdd(s)
+}
+
+// statusIntentToAdd reports the intent-to-add entries of the index as added
+// in the worktree, instead of in the staging area, as git does.
+func (w *Worktree) statusIntentToAdd(s Status) error {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range idx.Entries {
+		if !e.IntentToAdd {
+			continue
+		}
+
+		fs := s.File(e.Name)
+		if fs.Staging == Added {
+			fs.Staging = Unmodified
+		}
+
+		if fs.Worktree != Deleted {
+			fs.Worktree = Added
+		}
+	}
+
+	return nil
 }
 
 func nameFromAction(ch *merkletrie.Change) string {
@@ -129,45 +265,314 @@ func nameFromAction(ch *merkletrie.Change) string {
 	return name
 }
 
-func (w *Worktree) diffStagingWithWorktree(reverse, excludeIgnoredChanges bool) (merkletrie.Changes, error) {
+// statusStagingWithWorktree returns the changes between the staging area and
+// the worktree, using and updating the untracked cache and the file system
+// monitor data of the index, when enabled.
+func (w *Worktree) statusStagingWithWorktree(ctx context.Context) (merkletrie.Changes, error) {
 	idx, err := w.r.Storer.Index()
 	if err != nil {
 		return nil, err
 	}
 
+	cache, err := newStatusCache(w, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := w.diffIndexWithWorktree(ctx, idx, cache, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != nil && cache.update(changes) {
+		if err := w.r.Storer.SetIndex(idx); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+
+func (w *Worktree) diffStagingWithWorktree(ctx context.Context, reverse, excludeIgnoredChanges bool) (merkletrie.Changes, error) {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	return w.diffIndexWithWorktree(ctx, idx, nil, reverse, excludeIgnoredChanges)
+}
+
+func (w *Worktree) diffIndexWithWorktree(ctx context.Context, idx *index.Index, cache *statusCache, reverse, excludeIgnoredChanges bool) (merkletrie.Changes, error) {
 	from := mindex.NewRootNode(idx)
 	submodules, err := w.getSubmodulesStatus()
 	if err != nil {
 		return nil, err
 	}
 
-	to := filesystem.NewRootNode(w.Filesystem, submodules)
+	opts := filesystem.Options{Submodules: submodules}
+	if cache != nil {
+		opts.ReadDir = cache.readDir
+		opts.Hash = cache.hash
+	}
+
+	if noSymlinks, noFileMode := w.noSymlinks(), w.noFileMode(); noSymlinks || noFileMode {
+		staged := make(map[string]filemode.FileMode, len(idx.Entries))
+		for _, e := range idx.Entries {
+			staged[e.Name] = e.Mode
+		}
+
+		opts.Mode = func(path string, mode filemode.FileMode) filemode.FileMode {
+			return worktreeFileMode(mode, staged[path], noSymlinks, noFileMode)
+		}
+	}
+
+	to := filesystem.NewRootNodeWithOptions(w.Filesystem, opts)
 
 	var c merkletrie.Changes
 	if reverse {
-		c, err = merkletrie.DiffTree(to, from, diffTreeIsEquals)
+		c, err = diffTreeContext(ctx, to, from)
 	} else {
-		c, err = merkletrie.DiffTree(from, to, diffTreeIsEquals)
+		c, err = diffTreeContext(ctx, from, to)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
+	if w.ignoreCase() {
+		c = foldCaseChanges(c)
+	}
+
 	if excludeIgnoredChanges {
 		return w.excludeIgnoredChanges(c), nil
 	}
 	return c, nil
 }
 
+// ignoreCase returns true if core.ignoreCase is set, the worktree being on a
+// case-insensitive filesystem.
+func (w *Worktree) ignoreCase() bool {
+	cfg, err := w.r.Config()
+	return err == nil && cfg.Core.IgnoreCase
+}
+
+// noSymlinks returns true if core.symlinks is false, the symlinks being
+// checked out as plain files.
+func (w *Worktree) noSymlinks() bool {
+	cfg, err := w.r.Config()
+	return err == nil && cfg.Core.NoSymlinks
+}
+
+// noFileMode returns true if core.fileMode is false, the executable bit of
+// the files being ignored.
+func (w *Worktree) noFileMode() bool {
+	cfg, err := w.r.Config()
+	return err == nil && cfg.Core.NoFileMode
+}
+
+// worktreeFileMode returns the mode of a file of the worktree with the given
+// mode in the filesystem, whose entry in the index has the mode staged, zero
+// if none, as git does: without symlinks, a symlink checked out as a plain
+// file stays a symlink, and without the executable bit the mode of the index
+// is kept, new files being regular.
+func worktreeFileMode(mode, staged filemode.FileMode, noSymlinks, noFileMode bool) filemode.FileMode {
+	isFile := mode == filemode.Regular || mode == filemode.Executable
+	switch {
+	case !isFile:
+		return mode
+	case noSymlinks && staged == filemode.Symlink:
+		return staged
+	case !noFileMode:
+		return mode
+	case staged == filemode.Regular || staged == filemode.Executable:
+		return staged
+	}
+
+	return filemode.Regular
+}
+
+// foldCaseChanges merges the deletion and the insertion of the paths only
+// differing in case, as the same file renamed on a case-insensitive
+// filesystem. They are dropped if the file didn't change, and turned in a
+// modification otherwise.
+func foldCaseChanges(changes merkletrie.Changes) merkletrie.Changes {
+	deleted := make(map[string]int)
+	for i, ch := range changes {
+		if ch.To == nil {
+			deleted[strings.ToLower(ch.From.String())] = i
+		}
+	}
+
+	if len(deleted) == 0 {
+		return changes
+	}
+
+	merged := make(map[int]bool)
+	for i, ch := range changes {
+		if ch.From != nil {
+			continue
+		}
+
+		j, ok := deleted[strings.ToLower(ch.To.String())]
+		if !ok || merged[j] {
+			continue
+		}
+
+		merged[i], merged[j] = true, true
+		if !diffTreeIsEquals(changes[j].From, ch.To) {
+			changes[i] = merkletrie.Change{From: changes[j].From, To: ch.To}
+			merged[i] = false
+		}
+	}
+
+	result := make(merkletrie.Changes, 0, len(changes))
+	for i, ch := range changes {
+		if !merged[i] {
+			result = append(result, ch)
+		}
+	}
+
+	return result
+}
+
+// ignorePatterns returns the patterns of the ignored files, in increasing
+// priority as git reads them: the ones of core.excludesFile, or of
+// $XDG_CONFIG_HOME/git/ignore if it isn't set, of .git/info/exclude, of the
+// .gitignore files from the root of the worktree down, and Excludes.
+func (w *Worktree) ignorePatterns() ([]gitignore.Pattern, error) {
+	cfg, err := w.r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []gitignore.Pattern
+	file := cfg.GetString("core.excludesFile")
+	if file == "" {
+		file = gitignore.DefaultExcludesFile()
+	}
+
+	if file != "" {
+		patterns, err = gitignore.LoadExcludesFile(osfs.New(""), file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ps, err := gitignore.ReadPatterns(w.Filesystem, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns = append(patterns, ps...)
+	return append(patterns, w.Excludes...), nil
+}
+
+// CheckIgnoreResult tells whether a path is ignored and why, as git
+// check-ignore -v does.
+type CheckIgnoreResult struct {
+	// Path is the checked path.
+	Path string
+	// Ignored is true if the path is ignored.
+	Ignored bool
+	// Pattern is the pattern deciding whether the path is ignored, as
+	// written, a negated one if it isn't ignored. It is empty if no pattern
+	// matches the path, or if the path is tracked.
+	Pattern string
+	// Source is the path of the file the pattern is read from, relative to
+	// the root of the worktree for the files in it, empty for the patterns
+	// of Worktree.Excludes.
+	Source string
+	// Line is the line number of the pattern in its source file, from 1.
+	Line int
+}
+
+// CheckIgnore tells for each of the given paths, relative to the root of the
+// worktree or absolute, whether it is ignored and by which pattern, as git
+// check-ignore -v does. A path ending with a slash, or being a directory in
+// the worktree, is checked as a directory. The tracked paths are never
+// ignored. It returns ErrPathOutsideWorktree if a path is not in the worktree.
+func (w *Worktree) CheckIgnore(paths []string) ([]CheckIgnoreResult, error) {
+	patterns, err := w.ignorePatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	m := gitignore.NewMatcher(patterns).(gitignore.PatternMatcher)
+	results := make([]CheckIgnoreResult, len(paths))
+	for i, p := range paths {
+		results[i].Path = p
+
+		name, isDir, err := w.worktreePath(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" {
+			continue
+		}
+
+		if _, err := idx.Entry(name); err == nil {
+			continue
+		}
+
+		if !isDir {
+			fi, err := w.Filesystem.Lstat(name)
+			isDir = err == nil && fi.IsDir()
+		}
+
+		match, pattern := m.MatchPattern(strings.Split(name, "/"), isDir)
+		if pattern == nil {
+			continue
+		}
+
+		origin := gitignore.PatternOrigin(pattern)
+		results[i].Ignored = match == gitignore.Exclude
+		results[i].Pattern = origin.Text
+		results[i].Source = origin.Source
+		results[i].Line = origin.Line
+	}
+
+	return results, nil
+}
+
+// worktreePath returns the given path relative to the root of the worktree,
+// with slashes, and true if it ends with a slash. It returns
+// ErrPathOutsideWorktree if the path is not in the worktree.
+func (w *Worktree) worktreePath(p string) (string, bool, error) {
+	isDir := strings.HasSuffix(p, "/") || strings.HasSuffix(p, string(filepath.Separator))
+	name := filepath.Clean(p)
+	if filepath.IsAbs(name) {
+		rel, err := filepath.Rel(w.Filesystem.Root(), name)
+		if err != nil {
+			return "", false, fmt.Errorf("%w: %s", ErrPathOutsideWorktree, p)
+		}
+
+		name = rel
+	}
+
+	name = filepath.ToSlash(name)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", false, fmt.Errorf("%w: %s", ErrPathOutsideWorktree, p)
+	}
+
+	if name == "." {
+		name = ""
+	}
+
+	return name, isDir, nil
+}
+
 func (w *Worktree) excludeIgnoredChanges(changes merkletrie.Changes) merkletrie.Changes {
-	patterns, err := gitignore.ReadPatterns(w.Filesystem, nil)
+	patterns, err := w.ignorePatterns()
 	if err != nil {
 		return changes
 	}
 
-	patterns = append(patterns, w.Excludes...)
-
 	if len(patterns) == 0 {
 		return changes
 	}
@@ -223,7 +628,7 @@ func (w *Worktree) getSubmodulesStatus() (map[string]plumbing.Hash, error) {
 	return o, nil
 }
 
-func (w *Worktree) diffCommitWithStaging(commit plumbing.Hash, reverse bool) (merkletrie.Changes, error) {
+func (w *Worktree) diffCommitWithStaging(ctx context.Context, commit plumbing.Hash, reverse bool) (merkletrie.Changes, error) {
 	var t *object.Tree
 	if !commit.IsZero() {
 		c, err := w.r.CommitObject(commit)
@@ -237,10 +642,10 @@ func (w *Worktree) diffCommitWithStaging(commit plumbing.Hash, reverse bool) (me
 		}
 	}
 
-	return w.diffTreeWithStaging(t, reverse)
+	return w.diffTreeWithStaging(ctx, t, reverse)
 }
 
-func (w *Worktree) diffTreeWithStaging(t *object.Tree, reverse bool) (merkletrie.Changes, error) {
+func (w *Worktree) diffTreeWithStaging(ctx context.Context, t *object.Tree, reverse bool) (merkletrie.Changes, error) {
 	var from noder.Noder
 	if t != nil {
 		from = object.NewTreeRootNode(t)
@@ -254,10 +659,21 @@ func (w *Worktree) diffTreeWithStaging(t *object.Tree, reverse bool) (merkletrie
 	to := mindex.NewRootNode(idx)
 
 	if reverse {
-		return merkletrie.DiffTree(to, from, diffTreeIsEquals)
+		return diffTreeContext(ctx, to, from)
 	}
 
-	return merkletrie.DiffTree(from, to, diffTreeIsEquals)
+	return diffTreeContext(ctx, from, to)
+}
+
+// diffTreeContext returns the changes between two noders, comparing them with
+// diffTreeIsEquals, or the error of the context once it is cancelled.
+func diffTreeContext(ctx context.Context, from, to noder.Noder) (merkletrie.Changes, error) {
+	c, err := merkletrie.DiffTreeContext(ctx, from, to, diffTreeIsEquals)
+	if err == merkletrie.ErrCanceled {
+		return nil, ctx.Err()
+	}
+
+	return c, err
 }
 
 var emptyNoderHash = make([]byte, 24)
@@ -287,10 +703,10 @@ func diffTreeIsEquals(a, b noder.Hasher) bool {
 // no error is returned. When path is a file, the blob.Hash is returned.
 func (w *Worktree) Add(path string) (plumbing.Hash, error) {
 	// TODO(mcuadros): deprecate in favor of AddWithOption in v6.
-	return w.doAdd(path, make([]gitignore.Pattern, 0), false)
+	return w.doAdd(context.Background(), path, make([]gitignore.Pattern, 0), false)
 }
 
-func (w *Worktree) doAddDirectory(idx *index.Index, s Status, directory string, ignorePattern []gitignore.Pattern) (added bool, err error) {
+func (w *Worktree) doAddDirectory(ctx context.Context, idx *index.Index, s Status, directory string, ignorePattern []gitignore.Pattern) (added bool, err error) {
 	if len(ignorePattern) > 0 {
 		m := gitignore.NewMatcher(ignorePattern)
 		matchPath := strings.Split(directory, string(os.PathSeparator))
@@ -307,6 +723,10 @@ func (w *Worktree) doAddDirectory(idx *index.Index, s Status, directory string,
 			continue
 		}
 
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
 		var a bool
 		a, _, err = w.doAddFile(idx, s, name, ignorePattern)
 		if err != nil {
@@ -332,24 +752,36 @@ func isPathInDirectory(path, directory string) bool {
 // made to the working tree files applied, or remove paths that do not exist in
 // the working tree anymore.
 func (w *Worktree) AddWithOptions(opts *AddOptions) error {
+	return w.AddContext(context.Background(), opts)
+}
+
+// AddContext adds file contents to the index, as AddWithOptions does. The
+// context is checked for each file added, the index being written only once
+// all the files are added: if the context is cancelled, the index is left
+// untouched, the blobs already written being unreachable objects.
+func (w *Worktree) AddContext(ctx context.Context, opts *AddOptions) error {
 	if err := opts.Validate(w.r); err != nil {
 		return err
 	}
 
+	if opts.IntentToAdd || opts.Update || len(opts.Pathspec) != 0 {
+		return w.addMatching(ctx, opts)
+	}
+
 	if opts.All {
-		_, err := w.doAdd(".", w.Excludes, false)
+		_, err := w.doAdd(ctx, ".", w.Excludes, false)
 		return err
 	}
 
 	if opts.Glob != "" {
-		return w.AddGlob(opts.Glob)
+		return w.addGlob(ctx, opts.Glob)
 	}
 
-	_, err := w.doAdd(opts.Path, make([]gitignore.Pattern, 0), opts.SkipStatus)
+	_, err := w.doAdd(ctx, opts.Path, make([]gitignore.Pattern, 0), opts.SkipStatus)
 	return err
 }
 
-func (w *Worktree) doAdd(path string, ignorePattern []gitignore.Pattern, skipStatus bool) (plumbing.Hash, error) {
+func (w *Worktree) doAdd(ctx context.Context, path string, ignorePattern []gitignore.Pattern, skipStatus bool) (plumbing.Hash, error) {
 	idx, err := w.r.Storer.Index()
 	if err != nil {
 		return plumbing.ZeroHash, err
@@ -364,7 +796,7 @@ func (w *Worktree) doAdd(path string, ignorePattern []gitignore.Pattern, skipSta
 	var s Status
 	var err2 error
 	if !skipStatus || fi == nil || fi.IsDir() {
-		s, err2 = w.Status()
+		s, err2 = w.StatusContext(ctx, StatusOptions{Strategy: defaultStatusStrategy})
 		if err2 != nil {
 			return plumbing.ZeroHash, err2
 		}
@@ -375,7 +807,7 @@ func (w *Worktree) doAdd(path string, ignorePattern []gitignore.Pattern, skipSta
 	if err != nil || !fi.IsDir() {
 		added, h, err = w.doAddFile(idx, s, path, ignorePattern)
 	} else {
-		added, err = w.doAddDirectory(idx, s, path, ignorePattern)
+		added, err = w.doAddDirectory(ctx, idx, s, path, ignorePattern)
 	}
 
 	if err != nil {
@@ -394,6 +826,10 @@ func (w *Worktree) doAdd(path string, ignorePattern []gitignore.Pattern, skipSta
 // error is returned if all matching paths are already staged in index.
 func (w *Worktree) AddGlob(pattern string) error {
 	// TODO(mcuadros): deprecate in favor of AddWithOption in v6.
+	return w.addGlob(context.Background(), pattern)
+}
+
+func (w *Worktree) addGlob(ctx context.Context, pattern string) error {
 	files, err := util.Glob(w.Filesystem, pattern)
 	if err != nil {
 		return err
@@ -403,7 +839,7 @@ func (w *Worktree) AddGlob(pattern string) error {
 		return ErrGlobNoMatches
 	}
 
-	s, err := w.Status()
+	s, err := w.StatusContext(ctx, StatusOptions{Strategy: defaultStatusStrategy})
 	if err != nil {
 		return err
 	}
@@ -415,6 +851,10 @@ func (w *Worktree) AddGlob(pattern string) error {
 
 	var saveIndex bool
 	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		fi, err := w.Filesystem.Lstat(file)
 		if err != nil {
 			return err
@@ -422,7 +862,7 @@ func (w *Worktree) AddGlob(pattern string) error {
 
 		var added bool
 		if fi.IsDir() {
-			added, err = w.doAddDirectory(idx, s, file, make([]gitignore.Pattern, 0))
+			added, err = w.doAddDirectory(ctx, idx, s, file, make([]gitignore.Pattern, 0))
 		} else {
 			added, _, err = w.doAddFile(idx, s, file, make([]gitignore.Pattern, 0))
 		}
@@ -443,6 +883,114 @@ func (w *Worktree) AddGlob(pattern string) error {
 	return nil
 }
 
+// addMatching adds the changes of the paths matching opts, their content or
+// an intent-to-add entry for the untracked ones, as `git add -N` does. With
+// opts.Update, the untracked paths are skipped, as `git add -u` does.
+func (w *Worktree) addMatching(ctx context.Context, opts *AddOptions) error {
+	ps, err := w.addPathspec(opts)
+	if err != nil {
+		return err
+	}
+
+	s, err := w.StatusContext(ctx, StatusOptions{Strategy: defaultStatusStrategy})
+	if err != nil {
+		return err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	// h is the hash of the empty blob of the intent-to-add entries, stored
+	// on first use.
+	var h plumbing.Hash
+	var added bool
+	for name, fs := range s {
+		if fs.Worktree == Unmodified || !ps.Match(name) {
+			continue
+		}
+
+		untracked := fs.Worktree == Untracked
+		if (opts.Update && untracked) || (opts.IntentToAdd && !untracked) {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !opts.IntentToAdd {
+			a, _, err := w.doAddFile(idx, s, name, nil)
+			if err != nil {
+				return err
+			}
+
+			added = added || a
+			continue
+		}
+
+		// The entries hold the hash of an empty blob, as git does.
+		if h.IsZero() {
+			h, err = w.storeEmptyBlob()
+			if err != nil {
+				return err
+			}
+		}
+
+		e := idx.Add(name)
+		if err := w.doUpdateFileToIndex(e, name, h); err != nil {
+			return err
+		}
+
+		e.IntentToAdd = true
+		added = true
+	}
+
+	if !added {
+		return nil
+	}
+
+	return w.r.Storer.SetIndex(idx)
+}
+
+// addPathspec returns the pathspec of the paths to add: opts.Pathspec, the
+// files matching opts.Glob or opts.Path, or every path if opts.All is set.
+func (w *Worktree) addPathspec(opts *AddOptions) (*pathspec.Pathspec, error) {
+	switch {
+	case len(opts.Pathspec) != 0:
+		return pathspec.Parse(opts.Pathspec...)
+	case opts.Glob != "":
+		files, err := util.Glob(w.Filesystem, opts.Glob)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(files) == 0 {
+			return nil, ErrGlobNoMatches
+		}
+
+		specs := make([]string, len(files))
+		for i, file := range files {
+			specs[i] = ":(literal)" + filepath.ToSlash(file)
+		}
+
+		return pathspec.Parse(specs...)
+	case opts.Path != "" && !opts.All:
+		return pathspec.Parse(":(literal)" + filepath.ToSlash(opts.Path))
+	}
+
+	return pathspec.Parse()
+}
+
+func (w *Worktree) storeEmptyBlob() (plumbing.Hash, error) {
+	obj := w.r.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(0)
+
+	return w.r.Storer.SetEncodedObject(obj)
+}
+
 // doAddFile create a new blob from path and update the index, added is true if
 // the file added is different from the index.
 // if s status is nil will skip the status check and update the index anyway
@@ -482,6 +1030,10 @@ func (w *Worktree) copyFileToStorage(path string) (hash plumbing.Hash, err error
 		return plumbing.ZeroHash, err
 	}
 
+	if ss, ok := w.r.Storer.(storer.StreamObjectStorer); ok && fi.Mode().IsRegular() {
+		return w.streamFileToStorage(ss, path, fi)
+	}
+
 	obj := w.r.Storer.NewEncodedObject()
 	obj.SetType(plumbing.BlobObject)
 	obj.SetSize(fi.Size())
@@ -506,6 +1058,18 @@ func (w *Worktree) copyFileToStorage(path string) (hash plumbing.Hash, err error
 	return w.r.Storer.SetEncodedObject(obj)
 }
 
+// streamFileToStorage stores the file as a blob read from the file as it is
+// written, so large files are never held in memory.
+func (w *Worktree) streamFileToStorage(ss storer.StreamObjectStorer, path string, fi os.FileInfo) (h plumbing.Hash, err error) {
+	src, err := w.Filesystem.Open(path)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	defer ioutil.CheckClose(src, &err)
+	return ss.SetEncodedObjectFromReader(plumbing.BlobObject, fi.Size(), src)
+}
+
 func (w *Worktree) fillEncodedObjectFromFile(dst io.Writer, path string, _ os.FileInfo) (err error) {
 	src, err := w.Filesystem.Open(path)
 	if err != nil {
@@ -538,13 +1102,40 @@ func (w *Worktree) addOrUpdateFileToIndex(idx *index.Index, filename string, h p
 	}
 
 	if err == index.ErrEntryNotFound {
+		if e := w.caseFoldedEntry(idx, filename); e != nil {
+			e.Name = filename
+			return w.doUpdateFileToIndex(e, filename, h)
+		}
+
 		return w.doAddFileToIndex(idx, filename, h)
 	}
 
 	return w.doUpdateFileToIndex(e, filename, h)
 }
 
+// caseFoldedEntry returns the entry of the index whose path only differs in
+// case from the given one, if core.ignoreCase is set, as the file was renamed
+// on a case-insensitive filesystem. It returns nil if there is none.
+func (w *Worktree) caseFoldedEntry(idx *index.Index, filename string) *index.Entry {
+	if !w.ignoreCase() {
+		return nil
+	}
+
+	filename = filepath.ToSlash(filename)
+	for _, e := range idx.Entries {
+		if strings.EqualFold(e.Name, filename) {
+			return e
+		}
+	}
+
+	return nil
+}
+
 func (w *Worktree) doAddFileToIndex(idx *index.Index, filename string, h plumbing.Hash) error {
+	if err := validIndexPath(filepath.ToSlash(filename)); err != nil {
+		return err
+	}
+
 	return w.doUpdateFileToIndex(idx.Add(filename), filename, h)
 }
 
@@ -554,13 +1145,16 @@ func (w *Worktree) doUpdateFileToIndex(e *index.Entry, filename string, h plumbi
 		return err
 	}
 
-	e.Hash = h
-	e.ModifiedAt = info.ModTime()
-	e.Mode, err = filemode.NewFromOSFileMode(info.Mode())
+	mode, err := filemode.NewFromOSFileMode(info.Mode())
 	if err != nil {
 		return err
 	}
 
+	e.Mode = worktreeFileMode(mode, e.Mode, w.noSymlinks(), w.noFileMode())
+	e.Hash = h
+	e.IntentToAdd = false
+	e.ModifiedAt = info.ModTime()
+
 	// The entry size must always reflect the current state, otherwise
 	// it will cause go-git's Worktree.Status() to divert from "git status".
 	// The size of a symlink is the length of the path to the target.
@@ -699,6 +1293,32 @@ func (w *Worktree) RemoveGlob(pattern string) error {
 	return w.r.Storer.SetIndex(idx)
 }
 
+// Chmod sets or clears the executable bit of the given file in the index,
+// leaving the worktree unchanged, as `git update-index --chmod` does. It is
+// the way to change the mode of a file with core.fileMode set to false.
+func (w *Worktree) Chmod(path string, executable bool) error {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	e, err := idx.Entry(filepath.ToSlash(filepath.Clean(path)))
+	if err != nil {
+		return err
+	}
+
+	if e.Mode != filemode.Regular && e.Mode != filemode.Executable {
+		return fmt.Errorf("%w: %s", ErrNotRegularFile, path)
+	}
+
+	e.Mode = filemode.Regular
+	if executable {
+		e.Mode = filemode.Executable
+	}
+
+	return w.r.Storer.SetIndex(idx)
+}
+
 // Move moves or rename a file in the worktree and the index, directories are
 // not supported.
 func (w *Worktree) Move(from, to string) (plumbing.Hash, error) {
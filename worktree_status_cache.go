@@ -0,0 +1,460 @@
+package git
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/hash"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// FSMonitor is a file system monitor, such as watchman or one built with
+// fsnotify, reporting the paths changed in the worktree. When a Worktree has
+// one, Status only reads the files it reports as changed since its last call,
+// the token of the monitor being kept in the index.
+type FSMonitor interface {
+	// Changes returns the changes in the worktree since the time identified
+	// by token, which is empty when there is no previous token. When the
+	// changes since token are unknown, it returns All set.
+	Changes(token string) (*FSMonitorChanges, error)
+}
+
+// FSMonitorChanges are the changes reported by a FSMonitor.
+type FSMonitorChanges struct {
+	// Token identifies the time of the changes, it is the token of the next
+	// call to Changes.
+	Token string
+	// All is set when any path may have changed, as when the token is not
+	// known to the monitor.
+	All bool
+	// Paths are the paths changed, slash separated and relative to the root
+	// of the worktree. A directory means that any path under it may have
+	// changed.
+	Paths []string
+}
+
+const (
+	untrackedCacheLocation = "go-git location %s"
+	untrackedCacheEntries  = "go-git entries %s"
+)
+
+// statusCache lets Status skip the parts of the worktree known to be
+// unchanged. The entries of the index the file system monitor reports no
+// changes for are not read again, and the directories with the same stat data
+// as in the untracked cache are not listed again.
+//
+// Any invalid state, such as an error of the monitor or an untracked cache
+// written elsewhere, falls back to reading the whole worktree.
+type statusCache struct {
+	fs    billy.Filesystem
+	idx   *index.Index
+	start time.Time
+
+	// monitor is set when the worktree has a file system monitor, token
+	// is then its new token, and valid the entries it reports no changes for.
+	monitor     bool
+	token       string
+	changed     map[string]bool
+	changedDirs map[string]bool
+	valid       map[string]*index.Entry
+
+	// untracked is set when the untracked cache is enabled, dirs are then the
+	// valid directories of the cache, files the names of the entries of each
+	// directory and listed the directories listed by the status.
+	untracked    bool
+	environments []string
+	dirs         map[string]*index.UntrackedCacheDir
+	files        map[string]map[string]bool
+	listed       map[string]*untrackedCacheListing
+	modified     bool
+}
+
+type untrackedCacheListing struct {
+	dir     *index.UntrackedCacheDir
+	subdirs []string
+}
+
+// newStatusCache returns the cache for the status of idx, or nil if neither
+// the file system monitor or the untracked cache are enabled.
+func newStatusCache(w *Worktree, idx *index.Index) (*statusCache, error) {
+	cfg, err := w.r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	if w.FSMonitor == nil && !cfg.Core.UntrackedCache {
+		return nil, nil
+	}
+
+	c := &statusCache{fs: w.Filesystem, idx: idx, start: time.Now()}
+	if w.FSMonitor != nil {
+		c.loadMonitor(w.FSMonitor)
+	}
+
+	if cfg.Core.UntrackedCache {
+		c.loadUntrackedCache()
+	}
+
+	return c, nil
+}
+
+func (c *statusCache) loadMonitor(m FSMonitor) {
+	c.monitor = true
+	c.valid = make(map[string]*index.Entry)
+
+	var token string
+	if c.idx.FSMonitor != nil {
+		token = c.idx.FSMonitor.Token
+	}
+
+	changes, err := m.Changes(token)
+	if err != nil {
+		return
+	}
+
+	c.token = changes.Token
+	if changes.All || c.idx.FSMonitor == nil {
+		return
+	}
+
+	c.changed = make(map[string]bool, len(changes.Paths))
+	c.changedDirs = make(map[string]bool)
+	for _, p := range changes.Paths {
+		p = strings.Trim(path.Clean(p), "/")
+		if p == "." || p == "" {
+			c.changed, c.changedDirs = nil, nil
+			return
+		}
+
+		dir := path.Dir(p)
+		if dir == "." {
+			dir = ""
+		}
+
+		c.changed[p] = true
+		c.changedDirs[dir] = true
+	}
+
+	for _, e := range c.idx.Entries {
+		if e.FSMonitorValid && !c.isChanged(e.Name) {
+			c.valid[e.Name] = e
+		}
+	}
+}
+
+// isChanged returns whether the monitor reported p or one of its parent
+// directories as changed.
+func (c *statusCache) isChanged(p string) bool {
+	for ; p != "."; p = path.Dir(p) {
+		if c.changed[p] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *statusCache) loadUntrackedCache() {
+	c.untracked = true
+	c.dirs = make(map[string]*index.UntrackedCacheDir)
+	c.files = make(map[string]map[string]bool)
+	c.listed = make(map[string]*untrackedCacheListing)
+
+	names := make([]string, 0, len(c.idx.Entries))
+	for _, e := range c.idx.Entries {
+		names = append(names, e.Name)
+
+		dir, name := path.Split(e.Name)
+		dir = strings.TrimSuffix(dir, "/")
+		if c.files[dir] == nil {
+			c.files[dir] = make(map[string]bool)
+		}
+
+		c.files[dir][name] = true
+	}
+
+	// The cache only holds the untracked files, so it is only valid for the
+	// entries it was built with.
+	sort.Strings(names)
+	h := hash.New(hash.CryptoType)
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+	}
+
+	c.environments = []string{
+		fmt.Sprintf(untrackedCacheLocation, c.fs.Root()),
+		fmt.Sprintf(untrackedCacheEntries, hex.EncodeToString(h.Sum(nil))),
+	}
+
+	uc := c.idx.UntrackedCache
+	if uc == nil || uc.Root == nil || !equalStrings(uc.Environments, c.environments) {
+		c.modified = true
+		return
+	}
+
+	var walk func(dir string, d *index.UntrackedCacheDir)
+	walk = func(dir string, d *index.UntrackedCacheDir) {
+		if d.Valid {
+			c.dirs[dir] = d
+		}
+
+		for _, sub := range d.Dirs {
+			walk(path.Join(dir, sub.Name), sub)
+		}
+	}
+
+	walk("", uc.Root)
+}
+
+// hash returns the hash of the entry of the file at p, if the monitor
+// reported no changes for it.
+func (c *statusCache) hash(p string) (plumbing.Hash, bool) {
+	e, ok := c.valid[p]
+	if !ok {
+		return plumbing.ZeroHash, false
+	}
+
+	return e.Hash, true
+}
+
+// readDir lists the directory from the untracked cache if it is unchanged,
+// or from the filesystem otherwise.
+func (c *statusCache) readDir(dir string) ([]os.FileInfo, error) {
+	if !c.untracked {
+		return c.fs.ReadDir(dir)
+	}
+
+	if files, ok := c.readCachedDir(dir); ok {
+		return files, nil
+	}
+
+	c.modified = true
+
+	// The stat data is read first, any later change is then detected.
+	fi, err := c.fs.Lstat(dir)
+	if err != nil {
+		return c.fs.ReadDir(dir)
+	}
+
+	files, err := c.fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &untrackedCacheListing{dir: &index.UntrackedCacheDir{Name: path.Base(dir)}}
+	if dir == "" {
+		l.dir.Name = ""
+	}
+
+	// A directory changed in the current second may change again without a
+	// different modification time.
+	mtime := fi.ModTime()
+	if !mtime.IsZero() && mtime.Before(c.start.Truncate(time.Second)) {
+		l.dir.Valid = true
+		l.dir.Stat = index.StatData{ModifiedAt: mtime, Size: uint32(fi.Size())}
+	}
+
+	for _, f := range files {
+		switch {
+		case f.Name() == GitDirName:
+		case f.IsDir():
+			l.subdirs = append(l.subdirs, f.Name())
+		case !c.files[dir][f.Name()]:
+			l.dir.Untracked = append(l.dir.Untracked, f.Name())
+		}
+	}
+
+	c.listed[dir] = l
+	return files, nil
+}
+
+func (c *statusCache) readCachedDir(dir string) ([]os.FileInfo, bool) {
+	d, ok := c.dirs[dir]
+	if !ok || c.changedDirs[dir] || c.isChanged(dir) {
+		return nil, false
+	}
+
+	fi, err := c.fs.Lstat(dir)
+	if err != nil || !fi.ModTime().Equal(d.Stat.ModifiedAt) || uint32(fi.Size()) != d.Stat.Size {
+		return nil, false
+	}
+
+	seen := make(map[string]bool)
+	var files []os.FileInfo
+	l := &untrackedCacheListing{dir: d}
+	for _, sub := range d.Dirs {
+		seen[sub.Name] = true
+		l.subdirs = append(l.subdirs, sub.Name)
+		files = append(files, &dirFileInfo{name: sub.Name})
+	}
+
+	for name := range c.files[dir] {
+		if seen[name] {
+			continue
+		}
+
+		p := path.Join(dir, name)
+		if e, ok := c.valid[p]; ok {
+			files = append(files, &entryFileInfo{name: name, e: e})
+			continue
+		}
+
+		fi, err := c.fs.Lstat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, false
+		}
+
+		files = append(files, fi)
+	}
+
+	for _, name := range d.Untracked {
+		if c.files[dir][name] {
+			continue
+		}
+
+		fi, err := c.fs.Lstat(path.Join(dir, name))
+		if err != nil {
+			return nil, false
+		}
+
+		files = append(files, fi)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	c.listed[dir] = l
+	return files, true
+}
+
+// update updates the extensions of the index after the status of the
+// worktree, given the changes from the index, and returns whether they
+// changed.
+func (c *statusCache) update(changes merkletrie.Changes) bool {
+	var modified bool
+	if c.monitor {
+		modified = c.updateMonitor(changes)
+	}
+
+	if c.untracked && c.modified {
+		c.idx.UntrackedCache = &index.UntrackedCache{
+			Environments:  c.environments,
+			ExcludePerDir: ".gitignore",
+			Root:          c.untrackedCacheDir("", ""),
+		}
+
+		modified = true
+	}
+
+	return modified
+}
+
+func (c *statusCache) updateMonitor(changes merkletrie.Changes) bool {
+	if c.token == "" {
+		modified := c.idx.FSMonitor != nil
+		c.idx.FSMonitor = nil
+		return modified
+	}
+
+	modified := c.idx.FSMonitor == nil || c.idx.FSMonitor.Token != c.token
+	c.idx.FSMonitor = &index.FSMonitor{Token: c.token}
+
+	dirty := make(map[string]bool)
+	for _, ch := range changes {
+		if len(ch.From) != 0 {
+			dirty[ch.From.String()] = true
+		}
+	}
+
+	for _, e := range c.idx.Entries {
+		valid := !dirty[e.Name]
+		modified = modified || e.FSMonitorValid != valid
+		e.FSMonitorValid = valid
+	}
+
+	return modified
+}
+
+// untrackedCacheDir returns the directory of the untracked cache for dir, not
+// valid if it was not listed.
+func (c *statusCache) untrackedCacheDir(dir, name string) *index.UntrackedCacheDir {
+	l, ok := c.listed[dir]
+	if !ok {
+		return &index.UntrackedCacheDir{Name: name}
+	}
+
+	d := &index.UntrackedCacheDir{
+		Name:      name,
+		Untracked: l.dir.Untracked,
+		Valid:     l.dir.Valid,
+		Stat:      l.dir.Stat,
+	}
+
+	sort.Strings(l.subdirs)
+	for _, sub := range l.subdirs {
+		d.Dirs = append(d.Dirs, c.untrackedCacheDir(path.Join(dir, sub), sub))
+	}
+
+	return d
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dirFileInfo is the os.FileInfo of a directory of the untracked cache.
+type dirFileInfo struct {
+	name string
+}
+
+func (fi *dirFileInfo) Name() string       { return fi.name }
+func (fi *dirFileInfo) Size() int64        { return 0 }
+func (fi *dirFileInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (fi *dirFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *dirFileInfo) IsDir() bool        { return true }
+func (fi *dirFileInfo) Sys() interface{}   { return nil }
+
+// entryFileInfo is the os.FileInfo of a file matching its index entry.
+type entryFileInfo struct {
+	name string
+	e    *index.Entry
+}
+
+func (fi *entryFileInfo) Name() string       { return fi.name }
+func (fi *entryFileInfo) Size() int64        { return int64(fi.e.Size) }
+func (fi *entryFileInfo) ModTime() time.Time { return fi.e.ModifiedAt }
+func (fi *entryFileInfo) IsDir() bool        { return false }
+func (fi *entryFileInfo) Sys() interface{}   { return nil }
+
+func (fi *entryFileInfo) Mode() os.FileMode {
+	m, err := fi.e.Mode.ToOSFileMode()
+	if err != nil {
+		return 0644
+	}
+
+	return m
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -15,6 +16,7 @@ import (
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/internal/test"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/filemode"
@@ -138,10 +140,265 @@ func (s *WorktreeSuite) TestPullNonFastForward(c *C) {
 	_, err = w.Commit("bar", &CommitOptions{Author: defaultSignature()})
 	c.Assert(err, IsNil)
 
-	err = w.Pull(&PullOptions{})
+	err = w.Pull(&PullOptions{FFOnly: true})
+	c.Assert(err, Equals, ErrNonFastForwardUpdate)
+}
+
+// divergedClone returns a clone of a server repository, with a commit writing
+// the local files on the clone, and another one writing the server files
+// on the server, if any.
+func (s *WorktreeSuite) divergedClone(c *C, local, server map[string]string) (*Repository, string) {
+	url := c.MkDir()
+	path := fixtures.Basic().ByTag("worktree").One().Worktree().Root()
+	sr, err := PlainClone(url, false, &CloneOptions{URL: path})
+	c.Assert(err, IsNil)
+
+	dir := c.MkDir()
+	r, err := PlainClone(dir, false, &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+
+	for _, side := range []struct {
+		r     *Repository
+		files map[string]string
+		msg   string
+	}{{sr, server, "server"}, {r, local, "local"}} {
+		if len(side.files) == 0 {
+			continue
+		}
+
+		w, err := side.r.Worktree()
+		c.Assert(err, IsNil)
+
+		for name, content := range side.files {
+			err = util.WriteFile(w.Filesystem, name, []byte(content), 0o644)
+			c.Assert(err, IsNil)
+			_, err = w.Add(name)
+			c.Assert(err, IsNil)
+		}
+
+		_, err = w.Commit(side.msg, &CommitOptions{Author: defaultSignature()})
+		c.Assert(err, IsNil)
+	}
+
+	return r, url
+}
+
+func (s *WorktreeSuite) TestPullMerge(c *C) {
+	r, url := s.divergedClone(c,
+		map[string]string{"bar": "bar", "CHANGELOG": "local\nInitial changelog\n"},
+		map[string]string{"foo": "foo", "CHANGELOG": "Initial changelog\nserver\n"},
+	)
+
+	orig, err := r.Head()
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	err = w.Pull(&PullOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.NumParents(), Equals, 2)
+	c.Assert(commit.ParentHashes[0], Equals, orig.Hash())
+	c.Assert(commit.Message, Equals, "Merge branch 'master' of "+url+"\n")
+
+	for name, content := range map[string]string{
+		"foo":       "foo",
+		"bar":       "bar",
+		"CHANGELOG": "local\nInitial changelog\nserver\n",
+	} {
+		f, err := commit.File(name)
+		c.Assert(err, IsNil)
+		text, err := f.Contents()
+		c.Assert(err, IsNil)
+		c.Assert(text, Equals, content)
+	}
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	ref, err := r.Reference(origHeadRef, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, orig.Hash())
+}
+
+func (s *WorktreeSuite) TestPullMergeConflict(c *C) {
+	r, _ := s.divergedClone(c,
+		map[string]string{"CHANGELOG": "local\n"},
+		map[string]string{"CHANGELOG": "server\n"},
+	)
+
+	orig, err := r.Head()
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	err = w.Pull(&PullOptions{Author: defaultSignature()})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, orig.Hash())
+
+	remote, err := r.Reference(plumbing.NewRemoteReferenceName(DefaultRemoteName, "master"), false)
+	c.Assert(err, IsNil)
+
+	mergeHead, err := r.Reference(mergeHeadRef, false)
+	c.Assert(err, IsNil)
+	c.Assert(mergeHead.Hash(), Equals, remote.Hash())
+
+	content, err := util.ReadFile(w.Filesystem, "CHANGELOG")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "<<<<<<< HEAD\nlocal\n=======\nserver\n>>>>>>> "+remote.Hash().String()+"\n")
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+
+	var stages []index.Stage
+	for _, e := range idx.Entries {
+		if e.Name == "CHANGELOG" {
+			stages = append(stages, e.Stage)
+		}
+	}
+
+	c.Assert(stages, DeepEquals, []index.Stage{index.AncestorMode, index.OurMode, index.TheirMode})
+
+	fs := r.Storer.(*filesystem.Storage).Filesystem()
+	msg, err := util.ReadFile(fs, mergeMsgFile)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasSuffix(string(msg), "# Conflicts:\n#\tCHANGELOG\n"), Equals, true)
+}
+
+func (s *WorktreeSuite) TestPullNoFF(c *C) {
+	r, _ := s.divergedClone(c, nil, map[string]string{"foo": "foo"})
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	err = w.Pull(&PullOptions{NoFF: true, Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.NumParents(), Equals, 2)
+	c.Assert(commit.ParentHashes[0].String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	_, err = commit.File("foo")
+	c.Assert(err, IsNil)
+}
+
+func (s *WorktreeSuite) TestPullFFOnlyConfig(c *C) {
+	r, _ := s.divergedClone(c, map[string]string{"bar": "bar"}, map[string]string{"foo": "foo"})
+
+	err := r.SetConfigValue(config.LocalScope, "pull.ff", "only")
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	err = w.Pull(&PullOptions{Author: defaultSignature()})
 	c.Assert(err, Equals, ErrNonFastForwardUpdate)
 }
 
+func (s *WorktreeSuite) TestPullRebase(c *C) {
+	r, _ := s.divergedClone(c, map[string]string{"bar": "bar"}, map[string]string{"foo": "foo"})
+
+	err := r.SetConfigValue(config.LocalScope, "branch.master.rebase", "true")
+	c.Assert(err, IsNil)
+
+	orig, err := r.Head()
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	committer := &object.Signature{Name: "bar", Email: "bar@bar.bar", When: time.Now()}
+	err = w.Pull(&PullOptions{Committer: committer})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+
+	remote, err := r.Reference(plumbing.NewRemoteReferenceName(DefaultRemoteName, "master"), false)
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, "local")
+	c.Assert(commit.Author.Name, Equals, "foo")
+	c.Assert(commit.Committer.Name, Equals, "bar")
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{remote.Hash()})
+
+	for _, name := range []string{"foo", "bar"} {
+		_, err := commit.File(name)
+		c.Assert(err, IsNil)
+	}
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	ref, err := r.Reference(origHeadRef, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, orig.Hash())
+}
+
+func (s *WorktreeSuite) TestPullRebaseConflict(c *C) {
+	r, _ := s.divergedClone(c,
+		map[string]string{"CHANGELOG": "local\n"},
+		map[string]string{"CHANGELOG": "server\n"},
+	)
+
+	orig, err := r.Head()
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	err = w.Pull(&PullOptions{Rebase: true, Author: defaultSignature()})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	remote, err := r.Reference(plumbing.NewRemoteReferenceName(DefaultRemoteName, "master"), false)
+	c.Assert(err, IsNil)
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Type(), Equals, plumbing.HashReference)
+	c.Assert(head.Hash(), Equals, remote.Hash())
+
+	branch, err := r.Reference(plumbing.Master, false)
+	c.Assert(err, IsNil)
+	c.Assert(branch.Hash(), Equals, orig.Hash())
+
+	rebaseHead, err := r.Reference(rebaseHeadRef, false)
+	c.Assert(err, IsNil)
+	c.Assert(rebaseHead.Hash(), Equals, orig.Hash())
+
+	fs := r.Storer.(*filesystem.Storage).Filesystem()
+	for name, expected := range map[string]string{
+		"head-name": "refs/heads/master\n",
+		"onto":      remote.Hash().String() + "\n",
+		"orig-head": orig.Hash().String() + "\n",
+		"done":      "pick " + orig.Hash().String() + " local\n",
+		"msgnum":    "1\n",
+		"end":       "1\n",
+	} {
+		content, err := util.ReadFile(fs, fs.Join(rebaseMergeDir, name))
+		c.Assert(err, IsNil)
+		c.Assert(string(content), Equals, expected, Commentf("file %s", name))
+	}
+
+	content, err := util.ReadFile(w.Filesystem, "CHANGELOG")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "<<<<<<< HEAD\nserver\n=======\nlocal\n>>>>>>> "+
+		orig.Hash().String()[:7]+" (local)\n")
+}
+
 func (s *WorktreeSuite) TestPullUpdateReferencesIfNeeded(c *C) {
 	r, _ := Init(memory.NewStorage(), memfs.New())
 	r.CreateRemote(&config.RemoteConfig{
@@ -475,140 +732,557 @@ func (s *WorktreeSuite) TestCheckoutSymlink(c *C) {
 	c.Assert(err, IsNil)
 }
 
-func (s *WorktreeSuite) TestCheckoutSparse(c *C) {
+func (s *WorktreeSuite) TestCheckoutNoSymlinks(c *C) {
 	fs := memfs.New()
-	r, err := Clone(memory.NewStorage(), fs, &CloneOptions{
-		URL:        s.GetBasicLocalRepositoryURL(),
-		NoCheckout: true,
-	})
+	r, err := Init(memory.NewStorage(), fs)
 	c.Assert(err, IsNil)
 
 	w, err := r.Worktree()
 	c.Assert(err, IsNil)
 
-	sparseCheckoutDirectories := []string{"go", "json", "php"}
-	c.Assert(w.Checkout(&CheckoutOptions{
-		SparseCheckoutDirectories: sparseCheckoutDirectories,
-	}), IsNil)
-
-	fis, err := fs.ReadDir("/")
+	c.Assert(fs.Symlink("target", "link"), IsNil)
+	_, err = w.Add("link")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
 	c.Assert(err, IsNil)
 
-	for _, fi := range fis {
-		c.Assert(fi.IsDir(), Equals, true)
-		var oneOfSparseCheckoutDirs bool
-
-		for _, sparseCheckoutDirectory := range sparseCheckoutDirectories {
-			if strings.HasPrefix(fi.Name(), sparseCheckoutDirectory) {
-				oneOfSparseCheckoutDirs = true
-			}
-		}
-		c.Assert(oneOfSparseCheckoutDirs, Equals, true)
-	}
-}
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Core.NoSymlinks = true
+	c.Assert(r.SetConfig(cfg), IsNil)
 
-func (s *WorktreeSuite) TestFilenameNormalization(c *C) {
-	if runtime.GOOS == "windows" {
-		c.Skip("windows paths may contain non utf-8 sequences")
-	}
+	r.Storer.SetIndex(&index.Index{Version: 2})
+	w.Filesystem = memfs.New()
+	c.Assert(w.Checkout(&CheckoutOptions{}), IsNil)
 
-	url := c.MkDir()
+	fi, err := w.Filesystem.Lstat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().IsRegular(), Equals, true)
 
-	path := fixtures.Basic().ByTag("worktree").One().Worktree().Root()
+	content, err := util.ReadFile(w.Filesystem, "link")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "target")
 
-	server, err := PlainClone(url, false, &CloneOptions{
-		URL: path,
-	})
+	status, err := w.Status()
 	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
 
-	filename := "페"
+	// Adding the file keeps it a symlink.
+	c.Assert(util.WriteFile(w.Filesystem, "link", []byte("other"), 0o644), IsNil)
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("link").Worktree, Equals, Modified)
 
-	w, err := server.Worktree()
+	_, err = w.Add("link")
+	c.Assert(err, IsNil)
+	h, err := w.Commit("bar\n", &CommitOptions{Author: defaultSignature()})
 	c.Assert(err, IsNil)
 
-	writeFile := func(path string) {
-		err := util.WriteFile(w.Filesystem, path, []byte("foo"), 0755)
-		c.Assert(err, IsNil)
-	}
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
 
-	writeFile(filename)
-	origHash, err := w.Add(filename)
+	commit, err := r.CommitObject(h)
 	c.Assert(err, IsNil)
-	_, err = w.Commit("foo", &CommitOptions{Author: defaultSignature()})
+	tree, err := commit.Tree()
+	c.Assert(err, IsNil)
+	entry, err := tree.FindEntry("link")
 	c.Assert(err, IsNil)
+	c.Assert(entry.Mode, Equals, filemode.Symlink)
+}
 
-	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{
-		URL: url,
-	})
+func (s *WorktreeSuite) TestStatusNoFileMode(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
 	c.Assert(err, IsNil)
 
-	w, err = r.Worktree()
+	w, err := r.Worktree()
 	c.Assert(err, IsNil)
 
-	status, err := w.Status()
+	c.Assert(util.WriteFile(fs, "run", []byte("foo"), 0o755), IsNil)
+	_, err = w.Add("run")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
 	c.Assert(err, IsNil)
-	c.Assert(status.IsClean(), Equals, true)
 
-	err = w.Filesystem.Remove(filename)
+	cfg, err := r.Config()
 	c.Assert(err, IsNil)
+	cfg.Core.NoFileMode = true
+	c.Assert(r.SetConfig(cfg), IsNil)
 
-	modFilename := norm.NFKD.String(filename)
-	writeFile(modFilename)
+	// The executable bit lost in the worktree is not a change.
+	c.Assert(fs.Remove("run"), IsNil)
+	c.Assert(util.WriteFile(fs, "run", []byte("foo"), 0o644), IsNil)
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
 
-	_, err = w.Add(filename)
+	c.Assert(util.WriteFile(fs, "run", []byte("bar"), 0o644), IsNil)
+	c.Assert(util.WriteFile(fs, "new", []byte("qux"), 0o755), IsNil)
+	_, err = w.Add(".")
 	c.Assert(err, IsNil)
-	modHash, err := w.Add(modFilename)
+	h, err := w.Commit("bar\n", &CommitOptions{Author: defaultSignature()})
 	c.Assert(err, IsNil)
-	// At this point we've got two files with the same content.
-	// Hence their hashes must be the same.
-	c.Assert(origHash == modHash, Equals, true)
 
 	status, err = w.Status()
 	c.Assert(err, IsNil)
-	// However, their names are different and the work tree is still dirty.
-	c.Assert(status.IsClean(), Equals, false)
+	c.Assert(status.IsClean(), Equals, true)
 
-	// Revert back the deletion of the first file.
-	writeFile(filename)
-	_, err = w.Add(filename)
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	tree, err := commit.Tree()
+	c.Assert(err, IsNil)
+	entry, err := tree.FindEntry("run")
+	c.Assert(err, IsNil)
+	c.Assert(entry.Mode, Equals, filemode.Executable)
+	entry, err = tree.FindEntry("new")
 	c.Assert(err, IsNil)
+	c.Assert(entry.Mode, Equals, filemode.Regular)
+}
 
-	status, err = w.Status()
+func (s *WorktreeSuite) TestChmod(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
 	c.Assert(err, IsNil)
-	// Still dirty - the second file is added.
-	c.Assert(status.IsClean(), Equals, false)
 
-	_, err = w.Remove(modFilename)
+	w, err := r.Worktree()
 	c.Assert(err, IsNil)
 
-	status, err = w.Status()
+	c.Assert(util.WriteFile(fs, "run", []byte("foo"), 0o644), IsNil)
+	c.Assert(fs.Symlink("run", "link"), IsNil)
+	_, err = w.Add(".")
 	c.Assert(err, IsNil)
-	c.Assert(status.IsClean(), Equals, true)
-}
 
-func (s *WorktreeSuite) TestCheckoutSubmodule(c *C) {
-	url := "https://github.com/git-fixtures/submodule.git"
-	r := s.NewRepositoryWithEmptyWorktree(fixtures.ByURL(url).One())
+	c.Assert(w.Chmod("run", true), IsNil)
+	c.Assert(w.Chmod("missing", true), Equals, index.ErrEntryNotFound)
+	c.Assert(errors.Is(w.Chmod("link", true), ErrNotRegularFile), Equals, true)
 
-	w, err := r.Worktree()
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+	e, err := idx.Entry("run")
 	c.Assert(err, IsNil)
+	c.Assert(e.Mode, Equals, filemode.Executable)
 
-	err = w.Checkout(&CheckoutOptions{})
+	// The worktree is left unchanged.
+	fi, err := fs.Lstat("run")
 	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0o644))
 
-	status, err := w.Status()
+	c.Assert(w.Chmod("run", false), IsNil)
+	idx, err = r.Storer.Index()
 	c.Assert(err, IsNil)
-	c.Assert(status.IsClean(), Equals, true)
+	e, err = idx.Entry("run")
+	c.Assert(err, IsNil)
+	c.Assert(e.Mode, Equals, filemode.Regular)
 }
 
-func (s *WorktreeSuite) TestCheckoutSubmoduleInitialized(c *C) {
-	url := "https://github.com/git-fixtures/submodule.git"
-	r := s.NewRepository(fixtures.ByURL(url).One())
+// newInvalidPathRepository returns a repository whose index has the file
+// foo, and an entry of the same blob for each of the given names.
+func (s *WorktreeSuite) newInvalidPathRepository(c *C, names ...string) (*Repository, *Worktree) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
 
 	w, err := r.Worktree()
 	c.Assert(err, IsNil)
 
-	sub, err := w.Submodules()
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0o644), IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+	foo, err := idx.Entry("foo")
+	c.Assert(err, IsNil)
+
+	for _, name := range names {
+		e := *foo
+		e.Name = name
+		idx.Entries = append(idx.Entries, &e)
+	}
+
+	c.Assert(r.Storer.SetIndex(idx), IsNil)
+	return r, w
+}
+
+func (s *WorktreeSuite) TestCommitInvalidPaths(c *C) {
+	for _, name := range []string{
+		".git/config", ".GIT", "a/../b", "a/./b", "a//b", "a\\b",
+		".git\u200c", ".Git. .", ".git::$INDEX_ALLOCATION", "GIT~1",
+		"foo", "foo/bar",
+	} {
+		_, w := s.newInvalidPathRepository(c, name)
+		_, err := w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+		c.Assert(errors.Is(err, ErrInvalidPath), Equals, true, Commentf("%q: %v", name, err))
+	}
+
+	_, w := s.newInvalidPathRepository(c, ".gitignore", "a/b.git", "git~2")
+	_, err := w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(w.Filesystem, ".git\u200d", []byte("foo"), 0o644), IsNil)
+	_, err = w.Add(".git\u200d")
+	c.Assert(errors.Is(err, ErrInvalidPath), Equals, true)
+}
+
+func (s *WorktreeSuite) TestCommitAllowInvalidPaths(c *C) {
+	r, w := s.newInvalidPathRepository(c, ".GIT/config", "a/../b")
+	h, err := w.Commit("foo\n", &CommitOptions{
+		Author:            defaultSignature(),
+		AllowInvalidPaths: true,
+	})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	tree, err := commit.Tree()
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, e := range tree.Entries {
+		names = append(names, e.Name)
+	}
+
+	c.Assert(names, DeepEquals, []string{".GIT", "a", "foo"})
+
+	_, err = tree.FindEntry("a/../b")
+	c.Assert(err, IsNil)
+}
+
+func (s *WorktreeSuite) TestCheckoutInvalidPaths(c *C) {
+	r, w := s.newInvalidPathRepository(c, ".GIT/config")
+	h, err := w.Commit("foo\n", &CommitOptions{
+		Author:            defaultSignature(),
+		AllowInvalidPaths: true,
+	})
+	c.Assert(err, IsNil)
+
+	// The path is left out of the worktree, the other files being checked
+	// out.
+	c.Assert(r.Storer.SetIndex(&index.Index{Version: 2}), IsNil)
+	w.Filesystem = memfs.New()
+	c.Assert(w.Checkout(&CheckoutOptions{Hash: h}), IsNil)
+
+	_, err = w.Filesystem.Lstat(".GIT")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	content, err := util.ReadFile(w.Filesystem, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File(".GIT/config").Staging, Equals, Unmodified)
+	c.Assert(status.File(".GIT/config").Worktree, Equals, Deleted)
+}
+
+// newCheckoutConflictRepository returns a repository on master, with the
+// files foo and bar, and a branch other where foo is changed and qux added.
+func (s *WorktreeSuite) newCheckoutConflictRepository(c *C) (*Repository, *Worktree) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "bar", []byte("bar"), 0644), IsNil)
+	c.Assert(w.AddWithOptions(&AddOptions{All: true}), IsNil)
+	_, err = w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(w.Checkout(&CheckoutOptions{Create: true, Branch: "refs/heads/other"}), IsNil)
+	c.Assert(util.WriteFile(fs, "foo", []byte("other"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "qux", []byte("qux"), 0644), IsNil)
+	c.Assert(w.AddWithOptions(&AddOptions{All: true}), IsNil)
+	_, err = w.Commit("other\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(w.Checkout(&CheckoutOptions{Branch: plumbing.Master}), IsNil)
+	return r, w
+}
+
+func (s *WorktreeSuite) TestCheckoutConflict(c *C) {
+	r, w := s.newCheckoutConflictRepository(c)
+
+	c.Assert(util.WriteFile(w.Filesystem, "foo", []byte("local"), 0644), IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "qux", []byte("local"), 0644), IsNil)
+
+	err := w.Checkout(&CheckoutOptions{Branch: "refs/heads/other"})
+	c.Assert(errors.Is(err, ErrUnstagedChanges), Equals, true)
+
+	var conflict *CheckoutConflictError
+	c.Assert(errors.As(err, &conflict), Equals, true)
+	c.Assert(conflict.Modified, DeepEquals, []string{"foo"})
+	c.Assert(conflict.Untracked, DeepEquals, []string{"qux"})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+
+	content, err := util.ReadFile(w.Filesystem, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "local")
+}
+
+func (s *WorktreeSuite) TestCheckoutKeepsLocalChanges(c *C) {
+	r, w := s.newCheckoutConflictRepository(c)
+
+	c.Assert(util.WriteFile(w.Filesystem, "bar", []byte("local"), 0644), IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "baz", []byte("local"), 0644), IsNil)
+	_, err := w.Add("baz")
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{Branch: "refs/heads/other"})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.ReferenceName("refs/heads/other"))
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 2)
+	c.Assert(status.File("bar").Worktree, Equals, Modified)
+	c.Assert(status.File("baz").Staging, Equals, Added)
+
+	content, err := util.ReadFile(w.Filesystem, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "other")
+}
+
+func (s *WorktreeSuite) TestCheckoutConflictHandler(c *C) {
+	_, w := s.newCheckoutConflictRepository(c)
+
+	c.Assert(util.WriteFile(w.Filesystem, "foo", []byte("local"), 0644), IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "qux", []byte("local"), 0644), IsNil)
+
+	var conflicts []CheckoutConflict
+	err := w.Checkout(&CheckoutOptions{
+		Branch: "refs/heads/other",
+		ConflictHandler: func(conflict CheckoutConflict) CheckoutResolution {
+			conflicts = append(conflicts, conflict)
+			if conflict.Untracked {
+				return CheckoutOverwrite
+			}
+
+			return CheckoutKeep
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(conflicts, HasLen, 2)
+
+	content, err := util.ReadFile(w.Filesystem, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "local")
+
+	content, err = util.ReadFile(w.Filesystem, "qux")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "qux")
+}
+
+func (s *WorktreeSuite) newPathspecRepository(c *C) (*Repository, *Worktree, plumbing.Hash) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	for _, name := range []string{"foo.go", "bar.txt", "dir/baz.go", "dir/sub/qux.js"} {
+		c.Assert(util.WriteFile(fs, name, []byte("foo"), 0644), IsNil)
+	}
+
+	err = w.AddWithOptions(&AddOptions{All: true})
+	c.Assert(err, IsNil)
+
+	h, err := w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	return r, w, h
+}
+
+func (s *WorktreeSuite) TestCheckoutPathspec(c *C) {
+	r, w, first := s.newPathspecRepository(c)
+
+	for _, name := range []string{"foo.go", "bar.txt", "dir/baz.go"} {
+		c.Assert(util.WriteFile(w.Filesystem, name, []byte("bar"), 0644), IsNil)
+	}
+
+	err := w.AddWithOptions(&AddOptions{All: true})
+	c.Assert(err, IsNil)
+	second, err := w.Commit("bar\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(w.Filesystem, "foo.go", []byte("qux"), 0644), IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "bar.txt", []byte("qux"), 0644), IsNil)
+
+	err = w.Checkout(&CheckoutOptions{Pathspec: []string{"*.go"}})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("bar.txt").Worktree, Equals, Modified)
+
+	err = w.Checkout(&CheckoutOptions{Hash: first, Pathspec: []string{":(glob)dir/**"}})
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 2)
+	c.Assert(status.File("dir/baz.go").Staging, Equals, Modified)
+	c.Assert(status.File("dir/baz.go").Worktree, Equals, Unmodified)
+
+	content, err := util.ReadFile(w.Filesystem, "dir/baz.go")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo")
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+	c.Assert(head.Hash(), Equals, second)
+
+	err = w.Checkout(&CheckoutOptions{Create: true, Branch: "foo", Pathspec: []string{"dir"}})
+	c.Assert(err, Equals, ErrPathspecWithCreate)
+}
+
+func (s *WorktreeSuite) TestCheckoutSparse(c *C) {
+	fs := memfs.New()
+	r, err := Clone(memory.NewStorage(), fs, &CloneOptions{
+		URL:        s.GetBasicLocalRepositoryURL(),
+		NoCheckout: true,
+	})
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	sparseCheckoutDirectories := []string{"go", "json", "php"}
+	c.Assert(w.Checkout(&CheckoutOptions{
+		SparseCheckoutDirectories: sparseCheckoutDirectories,
+	}), IsNil)
+
+	fis, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+
+	for _, fi := range fis {
+		c.Assert(fi.IsDir(), Equals, true)
+		var oneOfSparseCheckoutDirs bool
+
+		for _, sparseCheckoutDirectory := range sparseCheckoutDirectories {
+			if strings.HasPrefix(fi.Name(), sparseCheckoutDirectory) {
+				oneOfSparseCheckoutDirs = true
+			}
+		}
+		c.Assert(oneOfSparseCheckoutDirs, Equals, true)
+	}
+}
+
+func (s *WorktreeSuite) TestFilenameNormalization(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("windows paths may contain non utf-8 sequences")
+	}
+
+	url := c.MkDir()
+
+	path := fixtures.Basic().ByTag("worktree").One().Worktree().Root()
+
+	server, err := PlainClone(url, false, &CloneOptions{
+		URL: path,
+	})
+	c.Assert(err, IsNil)
+
+	filename := "페"
+
+	w, err := server.Worktree()
+	c.Assert(err, IsNil)
+
+	writeFile := func(path string) {
+		err := util.WriteFile(w.Filesystem, path, []byte("foo"), 0755)
+		c.Assert(err, IsNil)
+	}
+
+	writeFile(filename)
+	origHash, err := w.Add(filename)
+	c.Assert(err, IsNil)
+	_, err = w.Commit("foo", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{
+		URL: url,
+	})
+	c.Assert(err, IsNil)
+
+	w, err = r.Worktree()
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	err = w.Filesystem.Remove(filename)
+	c.Assert(err, IsNil)
+
+	modFilename := norm.NFKD.String(filename)
+	writeFile(modFilename)
+
+	_, err = w.Add(filename)
+	c.Assert(err, IsNil)
+	modHash, err := w.Add(modFilename)
+	c.Assert(err, IsNil)
+	// At this point we've got two files with the same content.
+	// Hence their hashes must be the same.
+	c.Assert(origHash == modHash, Equals, true)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	// However, their names are different and the work tree is still dirty.
+	c.Assert(status.IsClean(), Equals, false)
+
+	// Revert back the deletion of the first file.
+	writeFile(filename)
+	_, err = w.Add(filename)
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	// Still dirty - the second file is added.
+	c.Assert(status.IsClean(), Equals, false)
+
+	_, err = w.Remove(modFilename)
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestCheckoutSubmodule(c *C) {
+	url := "https://github.com/git-fixtures/submodule.git"
+	r := s.NewRepositoryWithEmptyWorktree(fixtures.ByURL(url).One())
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestCheckoutSubmoduleInitialized(c *C) {
+	url := "https://github.com/git-fixtures/submodule.git"
+	r := s.NewRepository(fixtures.ByURL(url).One())
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	sub, err := w.Submodules()
 	c.Assert(err, IsNil)
 
 	err = sub.Update(&SubmoduleUpdateOptions{Init: true})
@@ -797,6 +1471,118 @@ func (s *WorktreeSuite) TestCheckoutCreate(c *C) {
 	c.Assert(status.IsClean(), Equals, true)
 }
 
+func (s *WorktreeSuite) TestCheckoutCreateTracking(c *C) {
+	for _, t := range []struct {
+		mode, branch, start string
+		remote, merge       string
+	}{
+		{"", "foo", "refs/remotes/origin/branch", "origin", "refs/heads/branch"},
+		{"", "foo", "refs/heads/master", "", ""},
+		{"true", "foo", "refs/remotes/origin/branch", "origin", "refs/heads/branch"},
+		{"always", "foo", "refs/heads/master", ".", "refs/heads/master"},
+		{"simple", "foo", "refs/remotes/origin/branch", "", ""},
+		{"simple", "branch", "refs/remotes/origin/branch", "origin", "refs/heads/branch"},
+		{"inherit", "foo", "refs/heads/master", "origin", "refs/heads/master"},
+		{"false", "foo", "refs/remotes/origin/branch", "", ""},
+	} {
+		r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+		c.Assert(err, IsNil)
+
+		if t.mode != "" {
+			c.Assert(r.SetConfigValue(config.LocalScope, "branch.autoSetupMerge", t.mode), IsNil)
+		}
+
+		w, err := r.Worktree()
+		c.Assert(err, IsNil)
+
+		err = w.Checkout(&CheckoutOptions{
+			Create:     true,
+			Branch:     plumbing.NewBranchReferenceName(t.branch),
+			StartPoint: plumbing.ReferenceName(t.start),
+		})
+		c.Assert(err, IsNil)
+
+		start, err := r.Reference(plumbing.ReferenceName(t.start), true)
+		c.Assert(err, IsNil)
+		head, err := r.Head()
+		c.Assert(err, IsNil)
+		c.Assert(head.Hash(), Equals, start.Hash())
+
+		cfg, err := r.Config()
+		c.Assert(err, IsNil)
+
+		b, ok := cfg.Branches[t.branch]
+		if t.remote == "" {
+			c.Assert(ok, Equals, false, Commentf("mode %q from %s", t.mode, t.start))
+			continue
+		}
+
+		c.Assert(ok, Equals, true, Commentf("mode %q from %s", t.mode, t.start))
+		c.Assert(b.Remote, Equals, t.remote)
+		c.Assert(b.Merge, Equals, plumbing.ReferenceName(t.merge))
+	}
+}
+
+func (s *WorktreeSuite) TestCheckoutCreateTrackingRebase(c *C) {
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+	c.Assert(r.SetConfigValue(config.LocalScope, "branch.autoSetupRebase", "remote"), IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{Create: true, Branch: "refs/heads/foo", StartPoint: "refs/remotes/origin/branch"})
+	c.Assert(err, IsNil)
+
+	b, err := r.Branch("foo")
+	c.Assert(err, IsNil)
+	c.Assert(b.Rebase, Equals, "true")
+}
+
+func (s *WorktreeSuite) TestCheckoutGuessRemoteBranch(c *C) {
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{Branch: "refs/heads/branch"})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.ReferenceName("refs/heads/branch"))
+	c.Assert(head.Hash(), Equals, plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+
+	b, err := r.Branch("branch")
+	c.Assert(err, IsNil)
+	c.Assert(b.Remote, Equals, "origin")
+	c.Assert(b.Merge, Equals, plumbing.ReferenceName("refs/heads/branch"))
+
+	err = w.Checkout(&CheckoutOptions{Branch: "refs/heads/missing"})
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *WorktreeSuite) TestCheckoutGuessRemoteBranchDisabled(c *C) {
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+	c.Assert(r.SetConfigValue(config.LocalScope, "checkout.guess", "false"), IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{Branch: "refs/heads/branch"})
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *WorktreeSuite) TestCheckoutStartPointWithoutCreate(c *C) {
+	w, err := s.Repository.Worktree()
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{Branch: "refs/heads/foo", StartPoint: "refs/remotes/origin/branch"})
+	c.Assert(err, Equals, ErrStartPointRequiresCreate)
+}
+
 func (s *WorktreeSuite) TestCheckoutBranchAndHash(c *C) {
 	w := &Worktree{
 		r:          s.Repository,
@@ -920,6 +1706,95 @@ func (s *WorktreeSuite) TestCheckoutTagHash(c *C) {
 	}
 }
 
+func (s *WorktreeSuite) TestCheckoutAnnotatedTagHash(c *C) {
+	f := fixtures.ByTag("tags").One()
+	r := s.NewRepositoryWithEmptyWorktree(f)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	// HEAD is detached at the commit of the tag, not at the tag itself.
+	err = w.Checkout(&CheckoutOptions{Hash: plumbing.NewHash("b742a2a9fa0afcfa9a6fad080980fbc26b007c69")})
+	c.Assert(err, IsNil)
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Type(), Equals, plumbing.HashReference)
+	c.Assert(head.Hash().String(), Equals, "f7b877701fbf855b44c0a9e86f3fdce2c298b07f")
+
+	d, err := r.DescribeHead()
+	c.Assert(err, IsNil)
+	c.Assert(d.String(), Equals, "HEAD detached at annotated-tag")
+
+	hash, err := w.Commit("foo", &CommitOptions{Author: defaultSignature(), AllowEmptyCommits: true})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(hash)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{head.Hash()})
+
+	d, err = r.DescribeHead()
+	c.Assert(err, IsNil)
+	c.Assert(d.String(), Equals, "HEAD detached at "+hash.String()[:7])
+}
+
+func (s *WorktreeSuite) TestCheckoutDetach(c *C) {
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: memfs.New(),
+	}
+
+	err := w.Checkout(&CheckoutOptions{Branch: "refs/heads/branch", Detach: true})
+	c.Assert(err, IsNil)
+
+	head, err := w.r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Type(), Equals, plumbing.HashReference)
+	c.Assert(head.Hash().String(), Equals, "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+
+	err = w.Checkout(&CheckoutOptions{Branch: plumbing.Master})
+	c.Assert(err, IsNil)
+
+	d, err := w.r.DescribeHead()
+	c.Assert(err, IsNil)
+	c.Assert(d.String(), Equals, "On branch master")
+
+	// Without a branch, HEAD is detached at its commit.
+	err = w.Checkout(&CheckoutOptions{Detach: true})
+	c.Assert(err, IsNil)
+
+	d, err = w.r.DescribeHead()
+	c.Assert(err, IsNil)
+	c.Assert(d.Detached, Equals, true)
+	c.Assert(d.Hash.String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	err = w.Checkout(&CheckoutOptions{Branch: "refs/heads/foo", Create: true, Detach: true})
+	c.Assert(err, Equals, ErrDetachWithCreate)
+}
+
+func (s *WorktreeSuite) TestCheckoutPathspecTree(c *C) {
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: memfs.New(),
+	}
+
+	commit, err := s.Repository.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+	tree, err := commit.Tree()
+	c.Assert(err, IsNil)
+	sub, err := tree.Tree("go")
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{Hash: sub.Hash, Pathspec: []string{"example.go"}})
+	c.Assert(err, IsNil)
+
+	_, err = w.Filesystem.Stat("example.go")
+	c.Assert(err, IsNil)
+
+	// A tree can't be checked out without paths.
+	err = w.Checkout(&CheckoutOptions{Hash: sub.Hash})
+	c.Assert(errors.Is(err, object.ErrUnsupportedObject), Equals, true)
+}
+
 func (s *WorktreeSuite) TestCheckoutBisect(c *C) {
 	if testing.Short() {
 		c.Skip("skipping test in short mode.")
@@ -963,11 +1838,34 @@ func (s *WorktreeSuite) TestStatus(c *C) {
 		Filesystem: fs,
 	}
 
-	status, err := w.Status()
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+
+	c.Assert(status.IsClean(), Equals, false)
+	c.Assert(status, HasLen, 9)
+}
+
+func (s *WorktreeSuite) TestStatusPathspec(c *C) {
+	_, w, _ := s.newPathspecRepository(c)
+
+	for _, name := range []string{"foo.go", "bar.txt", "dir/baz.go", "dir/new.go"} {
+		c.Assert(util.WriteFile(w.Filesystem, name, []byte("bar"), 0644), IsNil)
+	}
+
+	status, err := w.StatusWithOptions(StatusOptions{Pathspec: []string{"dir", "*.txt"}})
 	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 3)
+	c.Assert(status.File("bar.txt").Worktree, Equals, Modified)
+	c.Assert(status.File("dir/baz.go").Worktree, Equals, Modified)
+	c.Assert(status.File("dir/new.go").Worktree, Equals, Untracked)
 
-	c.Assert(status.IsClean(), Equals, false)
-	c.Assert(status, HasLen, 9)
+	status, err = w.StatusWithOptions(StatusOptions{Pathspec: []string{":!dir"}})
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 2)
+	c.Assert(status.File("foo.go").Worktree, Equals, Modified)
+
+	_, err = w.StatusWithOptions(StatusOptions{Pathspec: []string{":(foo)bar"}})
+	c.Assert(err, NotNil)
 }
 
 func (s *WorktreeSuite) TestStatusEmpty(c *C) {
@@ -1106,6 +2004,40 @@ func (s *WorktreeSuite) TestReset(c *C) {
 	c.Assert(status.IsClean(), Equals, true)
 }
 
+func (s *WorktreeSuite) TestResetOrigHead(c *C) {
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{URL: s.GetBasicLocalRepositoryURL()})
+	c.Assert(err, IsNil)
+
+	_, err = r.Reference(plumbing.OrigHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	head := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	commit := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+	err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.MergeHead, commit))
+	c.Assert(err, IsNil)
+
+	err = w.Reset(&ResetOptions{Mode: HardReset, Commit: commit})
+	c.Assert(err, IsNil)
+
+	orig, err := r.Reference(plumbing.OrigHead, false)
+	c.Assert(err, IsNil)
+	c.Assert(orig.Hash(), Equals, head)
+
+	_, err = r.Reference(plumbing.MergeHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	// Resetting files doesn't move HEAD, nor ORIG_HEAD.
+	err = w.Reset(&ResetOptions{Commit: head, Files: []string{"CHANGELOG"}})
+	c.Assert(err, IsNil)
+
+	orig, err = r.Reference(plumbing.OrigHead, false)
+	c.Assert(err, IsNil)
+	c.Assert(orig.Hash(), Equals, head)
+}
+
 func (s *WorktreeSuite) TestResetWithUntracked(c *C) {
 	fs := memfs.New()
 	w := &Worktree{
@@ -1313,44 +2245,407 @@ func (s *WorktreeSuite) TestResetHardWithGitIgnore(c *C) {
 
 	status, err := w.Status()
 	c.Assert(err, IsNil)
-	c.Assert(status.IsClean(), Equals, false)
+	c.Assert(status.IsClean(), Equals, false)
+
+	err = w.Reset(&ResetOptions{Mode: HardReset})
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestResetSparsely(c *C) {
+	fs := memfs.New()
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: fs,
+	}
+
+	sparseResetDirs := []string{"php"}
+
+	err := w.ResetSparsely(&ResetOptions{Mode: HardReset}, sparseResetDirs)
+	c.Assert(err, IsNil)
+
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+	c.Assert(files[0].Name(), Equals, "php")
+
+	files, err = fs.ReadDir("/php")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+	c.Assert(files[0].Name(), Equals, "crappy.php")
+}
+
+func (s *WorktreeSuite) TestStatusAfterCheckout(c *C) {
+	fs := memfs.New()
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: fs,
+	}
+
+	err := w.Checkout(&CheckoutOptions{Force: true})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+}
+
+func (s *WorktreeSuite) TestStatusModified(c *C) {
+	fs := s.TemporalFilesystem(c)
+
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: fs,
+	}
+
+	err := w.Checkout(&CheckoutOptions{})
+	c.Assert(err, IsNil)
+
+	f, err := fs.Create(".gitignore")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	err = f.Close()
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, false)
+	c.Assert(status.File(".gitignore").Worktree, Equals, Modified)
+}
+
+func (s *WorktreeSuite) TestStatusIgnored(c *C) {
+	fs := memfs.New()
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: fs,
+	}
+
+	w.Checkout(&CheckoutOptions{})
+
+	fs.MkdirAll("another", os.ModePerm)
+	f, _ := fs.Create("another/file")
+	f.Close()
+	fs.MkdirAll("vendor/github.com", os.ModePerm)
+	f, _ = fs.Create("vendor/github.com/file")
+	f.Close()
+	fs.MkdirAll("vendor/gopkg.in", os.ModePerm)
+	f, _ = fs.Create("vendor/gopkg.in/file")
+	f.Close()
+
+	status, _ := w.Status()
+	c.Assert(len(status), Equals, 3)
+	_, ok := status["another/file"]
+	c.Assert(ok, Equals, true)
+	_, ok = status["vendor/github.com/file"]
+	c.Assert(ok, Equals, true)
+	_, ok = status["vendor/gopkg.in/file"]
+	c.Assert(ok, Equals, true)
+
+	f, _ = fs.Create(".gitignore")
+	f.Write([]byte("vendor/g*/"))
+	f.Close()
+	f, _ = fs.Create("vendor/.gitignore")
+	f.Write([]byte("!github.com/\n"))
+	f.Close()
+
+	status, _ = w.Status()
+	c.Assert(len(status), Equals, 4)
+	_, ok = status[".gitignore"]
+	c.Assert(ok, Equals, true)
+	_, ok = status["another/file"]
+	c.Assert(ok, Equals, true)
+	_, ok = status["vendor/.gitignore"]
+	c.Assert(ok, Equals, true)
+	_, ok = status["vendor/github.com/file"]
+	c.Assert(ok, Equals, true)
+}
+
+func (s *WorktreeSuite) TestStatusIgnoredGlobalExcludes(c *C) {
+	xdg := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(xdg, "git"), 0o755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(xdg, "git", "ignore"), []byte("*.xdg\n"), 0o644), IsNil)
+
+	excludes := filepath.Join(c.MkDir(), "excludes")
+	c.Assert(os.WriteFile(excludes, []byte("*.excluded\n"), 0o644), IsNil)
+
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", xdg)
+
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	for _, name := range []string{"foo.xdg", "foo.excluded", "keep.excluded", "foo.local"} {
+		c.Assert(util.WriteFile(fs, name, nil, 0o644), IsNil)
+	}
+
+	c.Assert(util.WriteFile(fs, ".git/info/exclude", []byte("*.local\n!keep.*\n"), 0o644), IsNil)
+	c.Assert(util.WriteFile(fs, ".gitignore", []byte("!*.xdg\n"), 0o644), IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsUntracked("foo.xdg"), Equals, true)
+	c.Assert(status.IsUntracked("foo.excluded"), Equals, true)
+	c.Assert(status.IsUntracked("foo.local"), Equals, false)
+
+	c.Assert(util.WriteFile(fs, ".gitignore", nil, 0o644), IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsUntracked("foo.xdg"), Equals, false)
+	c.Assert(status.IsUntracked("foo.excluded"), Equals, true)
+
+	// core.excludesFile replaces the default one.
+	c.Assert(r.SetConfigValue(config.LocalScope, "core.excludesFile", excludes), IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsUntracked("foo.xdg"), Equals, true)
+	c.Assert(status.IsUntracked("foo.excluded"), Equals, false)
+	c.Assert(status.IsUntracked("keep.excluded"), Equals, true)
+}
+
+func (s *WorktreeSuite) TestCheckIgnore(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	w.Excludes = []gitignore.Pattern{gitignore.ParsePattern("*.api", nil)}
+
+	c.Assert(util.WriteFile(fs, "tracked.log", nil, 0o644), IsNil)
+	_, err = w.Add("tracked.log")
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, ".gitignore", []byte("*.log\n!keep.log\n\nbuild/\n"), 0o644), IsNil)
+	c.Assert(util.WriteFile(fs, ".git/info/exclude", []byte("# comment\n*.tmp\n"), 0o644), IsNil)
+	c.Assert(fs.MkdirAll("build", 0o755), IsNil)
+
+	results, err := w.CheckIgnore([]string{
+		"a.log", "keep.log", "build", "build/", "build/x", "x.tmp", "a.api", "tracked.log", "other", "/sub/b.log", ".",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(results, DeepEquals, []CheckIgnoreResult{
+		{Path: "a.log", Ignored: true, Pattern: "*.log", Source: ".gitignore", Line: 1},
+		{Path: "keep.log", Pattern: "!keep.log", Source: ".gitignore", Line: 2},
+		{Path: "build", Ignored: true, Pattern: "build/", Source: ".gitignore", Line: 4},
+		{Path: "build/", Ignored: true, Pattern: "build/", Source: ".gitignore", Line: 4},
+		{Path: "build/x", Ignored: true, Pattern: "build/", Source: ".gitignore", Line: 4},
+		{Path: "x.tmp", Ignored: true, Pattern: "*.tmp", Source: ".git/info/exclude", Line: 2},
+		{Path: "a.api", Ignored: true, Pattern: "*.api"},
+		{Path: "tracked.log"},
+		{Path: "other"},
+		{Path: "/sub/b.log", Ignored: true, Pattern: "*.log", Source: ".gitignore", Line: 1},
+		{Path: "."},
+	})
+
+	_, err = w.CheckIgnore([]string{"../outside"})
+	c.Assert(errors.Is(err, ErrPathOutsideWorktree), Equals, true)
+}
+
+func (s *WorktreeSuite) TestCheckAttributes(c *C) {
+	xdg := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(xdg, "git"), 0o755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(xdg, "git", "attributes"), []byte("*.txt text eol=lf\n*.bin diff\n"), 0o644), IsNil)
+
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", xdg)
+
+	r, err := PlainInit(c.MkDir(), false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	fs := w.Filesystem
+	c.Assert(util.WriteFile(fs, ".gitattributes", []byte(
+		"[attr]doc text diff=markdown\n*.md doc\n*.bin binary\n*.txt !eol\nsub/*.txt -text\n"), 0o644), IsNil)
+	c.Assert(util.WriteFile(fs, "sub/.gitattributes", []byte("*.txt text=auto\n"), 0o644), IsNil)
+	c.Assert(util.WriteFile(fs, ".git/info/attributes", []byte("secret.* -diff\n"), 0o644), IsNil)
+
+	attrs, err := w.CheckAttributes("a.txt")
+	c.Assert(err, IsNil)
+	c.Assert(attrs, HasLen, 1)
+	c.Assert(attrs["text"].IsSet(), Equals, true)
+
+	attrs, err = w.CheckAttributes("sub/b.txt", "text", "eol")
+	c.Assert(err, IsNil)
+	c.Assert(attrs, HasLen, 1)
+	c.Assert(attrs["text"].Value(), Equals, "auto")
+
+	results, err := w.CheckAttributesPaths([]string{"x.bin", "README.md", "sub/secret.md"}, "binary", "diff", "merge", "text")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 3)
+	c.Assert(results[0].Path, Equals, "x.bin")
+	c.Assert(results[0].Attributes, HasLen, 4)
+	c.Assert(results[0].Attributes["binary"].IsSet(), Equals, true)
+	c.Assert(results[0].Attributes["diff"].IsUnset(), Equals, true)
+	c.Assert(results[0].Attributes["merge"].IsUnset(), Equals, true)
+	c.Assert(results[0].Attributes["text"].IsUnset(), Equals, true)
+	c.Assert(results[1].Attributes, HasLen, 2)
+	c.Assert(results[1].Attributes["diff"].Value(), Equals, "markdown")
+	c.Assert(results[1].Attributes["text"].IsSet(), Equals, true)
+	c.Assert(results[2].Attributes, HasLen, 2)
+	c.Assert(results[2].Attributes["diff"].IsUnset(), Equals, true)
+	c.Assert(results[2].Attributes["text"].IsSet(), Equals, true)
+
+	_, err = w.CheckAttributes("../outside")
+	c.Assert(errors.Is(err, ErrPathOutsideWorktree), Equals, true)
+}
+
+func (s *WorktreeSuite) TestStatusUntracked(c *C) {
+	fs := memfs.New()
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: fs,
+	}
+
+	err := w.Checkout(&CheckoutOptions{Force: true})
+	c.Assert(err, IsNil)
+
+	f, err := w.Filesystem.Create("foo")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, Untracked)
+	c.Assert(status.File("foo").Worktree, Equals, Untracked)
+}
+
+func (s *WorktreeSuite) TestStatusDeleted(c *C) {
+	fs := s.TemporalFilesystem(c)
+
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: fs,
+	}
+
+	err := w.Checkout(&CheckoutOptions{})
+	c.Assert(err, IsNil)
+
+	err = fs.Remove(".gitignore")
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, false)
+	c.Assert(status.File(".gitignore").Worktree, Equals, Deleted)
+}
+
+// ignoreCaseWorktree returns the worktree of a new repository with core.ignoreCase
+// set, and a committed Foo.go file renamed to foo.go, as on a case-insensitive
+// filesystem.
+func ignoreCaseWorktree(c *C) (*Repository, *Worktree) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+	c.Assert(r.SetConfigValue(config.LocalScope, "core.ignorecase", "true"), IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "Foo.go", []byte("package foo\n"), 0o644), IsNil)
+	_, err = w.Add("Foo.go")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(fs.Rename("Foo.go", "foo.go"), IsNil)
+	return r, w
+}
+
+func (s *WorktreeSuite) TestStatusIgnoreCase(c *C) {
+	_, w := ignoreCaseWorktree(c)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
 
-	err = w.Reset(&ResetOptions{Mode: HardReset})
-	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "foo.go", []byte("package bar\n"), 0o644), IsNil)
 
 	status, err = w.Status()
 	c.Assert(err, IsNil)
-	c.Assert(status.IsClean(), Equals, true)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("foo.go").Worktree, Equals, Modified)
 }
 
-func (s *WorktreeSuite) TestResetSparsely(c *C) {
-	fs := memfs.New()
-	w := &Worktree{
-		r:          s.Repository,
-		Filesystem: fs,
-	}
+func (s *WorktreeSuite) TestAddIgnoreCase(c *C) {
+	r, w := ignoreCaseWorktree(c)
 
-	sparseResetDirs := []string{"php"}
+	_, err := w.Add("foo.go")
+	c.Assert(err, IsNil)
 
-	err := w.ResetSparsely(&ResetOptions{Mode: HardReset}, sparseResetDirs)
+	idx, err := r.Storer.Index()
 	c.Assert(err, IsNil)
+	c.Assert(idx.Entries, HasLen, 1)
+	c.Assert(idx.Entries[0].Name, Equals, "foo.go")
 
-	files, err := fs.ReadDir("/")
+	status, err := w.Status()
 	c.Assert(err, IsNil)
-	c.Assert(files, HasLen, 1)
-	c.Assert(files[0].Name(), Equals, "php")
+	c.Assert(status, HasLen, 2)
+	c.Assert(status.File("foo.go").Staging, Equals, Added)
+	c.Assert(status.File("Foo.go").Staging, Equals, Deleted)
+}
 
-	files, err = fs.ReadDir("/php")
+func (s *WorktreeSuite) TestCheckoutCaseCollision(c *C) {
+	r, w := ignoreCaseWorktree(c)
+
+	c.Assert(r.SetConfigValue(config.LocalScope, "core.ignorecase", "false"), IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "Foo.go", []byte("package foo\n"), 0o644), IsNil)
+	_, err := w.Add("foo.go")
+	c.Assert(err, IsNil)
+	hash, err := w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.SetConfigValue(config.LocalScope, "core.ignorecase", "true"), IsNil)
+
+	err = w.Checkout(&CheckoutOptions{Hash: hash})
+	c.Assert(errors.Is(err, ErrCaseCollision), Equals, true)
+	c.Assert(err, ErrorMatches, `.*"Foo.go" and "foo.go"`)
+
+	err = w.Checkout(&CheckoutOptions{Hash: hash, AllowCaseCollisions: true})
 	c.Assert(err, IsNil)
-	c.Assert(files, HasLen, 1)
-	c.Assert(files[0].Name(), Equals, "crappy.php")
 }
 
-func (s *WorktreeSuite) TestStatusAfterCheckout(c *C) {
+type fakeFSMonitor struct {
+	tokens  []string
+	changes []string
+	err     error
+}
+
+func (m *fakeFSMonitor) Changes(token string) (*FSMonitorChanges, error) {
+	m.tokens = append(m.tokens, token)
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	ch := &FSMonitorChanges{
+		Token: fmt.Sprintf("%d", len(m.tokens)),
+		All:   token == "",
+		Paths: m.changes,
+	}
+
+	m.changes = nil
+	return ch, nil
+}
+
+func (s *WorktreeSuite) TestStatusFSMonitor(c *C) {
 	fs := memfs.New()
+	m := &fakeFSMonitor{}
 	w := &Worktree{
 		r:          s.Repository,
 		Filesystem: fs,
+		FSMonitor:  m,
 	}
 
 	err := w.Checkout(&CheckoutOptions{Force: true})
@@ -1360,117 +2655,133 @@ func (s *WorktreeSuite) TestStatusAfterCheckout(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(status.IsClean(), Equals, true)
 
-}
+	idx, err := s.Repository.Storer.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.FSMonitor, DeepEquals, &index.FSMonitor{Token: "1"})
+	for _, e := range idx.Entries {
+		c.Assert(e.FSMonitorValid, Equals, true)
+	}
 
-func (s *WorktreeSuite) TestStatusModified(c *C) {
-	fs := s.TemporalFilesystem(c)
+	// The changes not reported by the monitor are not seen.
+	err = util.WriteFile(fs, "CHANGELOG", []byte("foo"), 0644)
+	c.Assert(err, IsNil)
 
-	w := &Worktree{
-		r:          s.Repository,
-		Filesystem: fs,
-	}
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
 
-	err := w.Checkout(&CheckoutOptions{})
+	m.changes = []string{"CHANGELOG"}
+	status, err = w.Status()
 	c.Assert(err, IsNil)
+	c.Assert(status.File("CHANGELOG").Worktree, Equals, Modified)
+	c.Assert(status, HasLen, 1)
 
-	f, err := fs.Create(".gitignore")
+	idx, err = s.Repository.Storer.Index()
 	c.Assert(err, IsNil)
-	_, err = f.Write([]byte("foo"))
+	c.Assert(idx.FSMonitor, DeepEquals, &index.FSMonitor{Token: "3"})
+	e, err := idx.Entry("CHANGELOG")
 	c.Assert(err, IsNil)
-	err = f.Close()
+	c.Assert(e.FSMonitorValid, Equals, false)
+
+	// A modified file is read until it matches its entry.
+	status, err = w.Status()
 	c.Assert(err, IsNil)
+	c.Assert(status.File("CHANGELOG").Worktree, Equals, Modified)
+	c.Assert(m.tokens, DeepEquals, []string{"", "1", "2", "3"})
 
-	status, err := w.Status()
+	// A failing monitor falls back to reading the whole worktree.
+	err = util.WriteFile(fs, "LICENSE", []byte("foo"), 0644)
 	c.Assert(err, IsNil)
-	c.Assert(status.IsClean(), Equals, false)
-	c.Assert(status.File(".gitignore").Worktree, Equals, Modified)
+
+	m.err = errors.New("foo")
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("CHANGELOG").Worktree, Equals, Modified)
+	c.Assert(status.File("LICENSE").Worktree, Equals, Modified)
+
+	idx, err = s.Repository.Storer.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.FSMonitor, IsNil)
 }
 
-func (s *WorktreeSuite) TestStatusIgnored(c *C) {
-	fs := memfs.New()
+func (s *WorktreeSuite) TestStatusUntrackedCache(c *C) {
+	fs := s.TemporalFilesystem(c)
 	w := &Worktree{
 		r:          s.Repository,
 		Filesystem: fs,
 	}
 
-	w.Checkout(&CheckoutOptions{})
+	cfg, err := s.Repository.Config()
+	c.Assert(err, IsNil)
+	cfg.Core.UntrackedCache = true
+	c.Assert(s.Repository.SetConfig(cfg), IsNil)
 
-	fs.MkdirAll("another", os.ModePerm)
-	f, _ := fs.Create("another/file")
-	f.Close()
-	fs.MkdirAll("vendor/github.com", os.ModePerm)
-	f, _ = fs.Create("vendor/github.com/file")
-	f.Close()
-	fs.MkdirAll("vendor/gopkg.in", os.ModePerm)
-	f, _ = fs.Create("vendor/gopkg.in/file")
-	f.Close()
+	err = w.Checkout(&CheckoutOptions{Force: true})
+	c.Assert(err, IsNil)
 
-	status, _ := w.Status()
-	c.Assert(len(status), Equals, 3)
-	_, ok := status["another/file"]
-	c.Assert(ok, Equals, true)
-	_, ok = status["vendor/github.com/file"]
-	c.Assert(ok, Equals, true)
-	_, ok = status["vendor/gopkg.in/file"]
-	c.Assert(ok, Equals, true)
+	err = util.WriteFile(fs, "go/foo", []byte("foo"), 0644)
+	c.Assert(err, IsNil)
 
-	f, _ = fs.Create(".gitignore")
-	f.Write([]byte("vendor/g*/"))
-	f.Close()
-	f, _ = fs.Create("vendor/.gitignore")
-	f.Write([]byte("!github.com/\n"))
-	f.Close()
+	// The directories changed in the current second are not cached.
+	past := time.Now().Add(-time.Hour)
+	setPast := func(dirs ...string) {
+		for _, dir := range dirs {
+			c.Assert(os.Chtimes(filepath.Join(fs.Root(), dir), past, past), IsNil)
+		}
+	}
 
-	status, _ = w.Status()
-	c.Assert(len(status), Equals, 4)
-	_, ok = status[".gitignore"]
-	c.Assert(ok, Equals, true)
-	_, ok = status["another/file"]
-	c.Assert(ok, Equals, true)
-	_, ok = status["vendor/.gitignore"]
-	c.Assert(ok, Equals, true)
-	_, ok = status["vendor/github.com/file"]
-	c.Assert(ok, Equals, true)
-}
+	setPast("", "go", "json", "php", "vendor")
 
-func (s *WorktreeSuite) TestStatusUntracked(c *C) {
-	fs := memfs.New()
-	w := &Worktree{
-		r:          s.Repository,
-		Filesystem: fs,
-	}
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("go/foo").Worktree, Equals, Untracked)
 
-	err := w.Checkout(&CheckoutOptions{Force: true})
+	idx, err := s.Repository.Storer.Index()
 	c.Assert(err, IsNil)
+	root := idx.UntrackedCache.Root
+	c.Assert(root, NotNil)
+	c.Assert(root.Valid, Equals, true)
+	c.Assert(root.Dirs, HasLen, 4)
+	c.Assert(root.Dirs[0].Name, Equals, "go")
+	c.Assert(root.Dirs[0].Valid, Equals, true)
+	c.Assert(root.Dirs[0].Untracked, DeepEquals, []string{"foo"})
 
-	f, err := w.Filesystem.Create("foo")
+	// The listing of an unchanged directory is not read again.
+	err = util.WriteFile(fs, "go/bar", []byte("bar"), 0644)
 	c.Assert(err, IsNil)
-	c.Assert(f.Close(), IsNil)
+	setPast("go")
 
-	status, err := w.Status()
+	status, err = w.Status()
 	c.Assert(err, IsNil)
-	c.Assert(status.File("foo").Staging, Equals, Untracked)
-	c.Assert(status.File("foo").Worktree, Equals, Untracked)
-}
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("go/foo").Worktree, Equals, Untracked)
 
-func (s *WorktreeSuite) TestStatusDeleted(c *C) {
-	fs := s.TemporalFilesystem(c)
+	err = fs.Remove("go/foo")
+	c.Assert(err, IsNil)
 
-	w := &Worktree{
-		r:          s.Repository,
-		Filesystem: fs,
-	}
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("go/bar").Worktree, Equals, Untracked)
 
-	err := w.Checkout(&CheckoutOptions{})
+	// The cache is dropped once the entries of the index change.
+	setPast("go")
+	_, err = w.Status()
 	c.Assert(err, IsNil)
 
-	err = fs.Remove(".gitignore")
+	idx, err = s.Repository.Storer.Index()
 	c.Assert(err, IsNil)
+	c.Assert(idx.UntrackedCache.Root.Dirs[0].Valid, Equals, true)
 
-	status, err := w.Status()
+	_, err = idx.Remove("go/example.go")
 	c.Assert(err, IsNil)
-	c.Assert(status.IsClean(), Equals, false)
-	c.Assert(status.File(".gitignore").Worktree, Equals, Deleted)
+	c.Assert(s.Repository.Storer.SetIndex(idx), IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 2)
+	c.Assert(status.File("go/example.go").Worktree, Equals, Untracked)
 }
 
 func (s *WorktreeSuite) TestSubmodule(c *C) {
@@ -1851,6 +3162,71 @@ func (s *WorktreeSuite) TestAddSymlink(c *C) {
 	c.Assert(obj.Size(), Equals, int64(3))
 }
 
+func (s *WorktreeSuite) TestAddLargeFile(c *C) {
+	const size = 64 << 20
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	// A sparse file, whose content isn't held in memory either.
+	f, err := os.Create(filepath.Join(dir, "large"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(size), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	hasher := plumbing.NewHasher(plumbing.BlobObject, size)
+	_, err = io.Copy(hasher, io.LimitReader(test.ZeroReader{}, size))
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	h, err := w.Add("large")
+	runtime.ReadMemStats(&after)
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, hasher.Sum())
+	c.Assert(after.TotalAlloc-before.TotalAlloc < size/4, Equals, true,
+		Commentf("%d bytes allocated", after.TotalAlloc-before.TotalAlloc))
+
+	size2, err := r.Storer.EncodedObjectSize(h)
+	c.Assert(err, IsNil)
+	c.Assert(size2, Equals, int64(size))
+}
+
+func (s *WorktreeSuite) TestCheckoutLargeFile(c *C) {
+	const size = 64 << 20
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	f, err := os.Create(filepath.Join(dir, "large"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(size), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	_, err = w.Add("large")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("large", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+	c.Assert(os.Remove(filepath.Join(dir, "large")), IsNil)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err = w.Checkout(&CheckoutOptions{Force: true})
+	runtime.ReadMemStats(&after)
+	c.Assert(err, IsNil)
+	c.Assert(after.TotalAlloc-before.TotalAlloc < size/4, Equals, true,
+		Commentf("%d bytes allocated", after.TotalAlloc-before.TotalAlloc))
+
+	fi, err := os.Stat(filepath.Join(dir, "large"))
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(size))
+}
+
 func (s *WorktreeSuite) TestAddDirectory(c *C) {
 	fs := memfs.New()
 	w := &Worktree{
@@ -2025,54 +3401,184 @@ func (s *WorktreeSuite) TestAddFilenameStartingWithDot(c *C) {
 	c.Assert(err, IsNil)
 	err = util.WriteFile(w.Filesystem, "baz", []byte("BAZ"), 0o755)
 	c.Assert(err, IsNil)
-	err = util.WriteFile(w.Filesystem, "foo/bar/baz", []byte("BAZ"), 0o755)
+	err = util.WriteFile(w.Filesystem, "foo/bar/baz", []byte("BAZ"), 0o755)
+	c.Assert(err, IsNil)
+
+	_, err = w.Add("./qux")
+	c.Assert(err, IsNil)
+
+	_, err = w.Add("./baz")
+	c.Assert(err, IsNil)
+
+	_, err = w.Add("foo/bar/../bar/./baz")
+	c.Assert(err, IsNil)
+
+	idx, err = w.r.Storer.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Entries, HasLen, 12)
+
+	e, err := idx.Entry("qux")
+	c.Assert(err, IsNil)
+	c.Assert(e.Mode, Equals, filemode.Executable)
+
+	e, err = idx.Entry("baz")
+	c.Assert(err, IsNil)
+	c.Assert(e.Mode, Equals, filemode.Executable)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 3)
+
+	file := status.File("qux")
+	c.Assert(file.Staging, Equals, Added)
+	c.Assert(file.Worktree, Equals, Unmodified)
+
+	file = status.File("baz")
+	c.Assert(file.Staging, Equals, Added)
+	c.Assert(file.Worktree, Equals, Unmodified)
+
+	file = status.File("foo/bar/baz")
+	c.Assert(file.Staging, Equals, Added)
+	c.Assert(file.Worktree, Equals, Unmodified)
+
+}
+
+func (s *WorktreeSuite) TestAddGlobErrorNoMatches(c *C) {
+	r, _ := Init(memory.NewStorage(), memfs.New())
+	w, _ := r.Worktree()
+
+	err := w.AddGlob("foo")
+	c.Assert(err, Equals, ErrGlobNoMatches)
+}
+
+func (s *WorktreeSuite) TestAddIntentToAdd(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "README", []byte("foo"), 0644), IsNil)
+	_, err = w.Add("README")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "dir/new", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "other", []byte("bar"), 0644), IsNil)
+
+	err = w.AddWithOptions(&AddOptions{Path: "dir", IntentToAdd: true})
+	c.Assert(err, IsNil)
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+	e, err := idx.Entry("dir/new")
+	c.Assert(err, IsNil)
+	c.Assert(e.IntentToAdd, Equals, true)
+	c.Assert(e.Hash.String(), Equals, "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391")
+
+	_, err = r.BlobObject(e.Hash)
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("dir/new").Staging, Equals, Unmodified)
+	c.Assert(status.File("dir/new").Worktree, Equals, Added)
+	c.Assert(status.File("other").Worktree, Equals, Untracked)
+
+	_, err = w.Commit("bar\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(errors.Is(err, ErrIntentToAddNotStaged), Equals, true)
+
+	_, err = w.Add("dir/new")
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
 	c.Assert(err, IsNil)
+	c.Assert(status.File("dir/new").Staging, Equals, Added)
+	c.Assert(status.File("dir/new").Worktree, Equals, Unmodified)
 
-	_, err = w.Add("./qux")
+	_, err = w.Commit("bar\n", &CommitOptions{Author: defaultSignature()})
 	c.Assert(err, IsNil)
+}
 
-	_, err = w.Add("./baz")
+func (s *WorktreeSuite) TestAddIntentToAddCommitAll(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
 	c.Assert(err, IsNil)
 
-	_, err = w.Add("foo/bar/../bar/./baz")
+	w, err := r.Worktree()
 	c.Assert(err, IsNil)
 
-	idx, err = w.r.Storer.Index()
+	c.Assert(util.WriteFile(fs, "foo", []byte("foo"), 0644), IsNil)
+	err = w.AddWithOptions(&AddOptions{All: true, IntentToAdd: true})
 	c.Assert(err, IsNil)
-	c.Assert(idx.Entries, HasLen, 12)
 
-	e, err := idx.Entry("qux")
+	h, err := w.Commit("foo\n", &CommitOptions{All: true, Author: defaultSignature()})
 	c.Assert(err, IsNil)
-	c.Assert(e.Mode, Equals, filemode.Executable)
 
-	e, err = idx.Entry("baz")
+	commit, err := r.CommitObject(h)
 	c.Assert(err, IsNil)
-	c.Assert(e.Mode, Equals, filemode.Executable)
+	file, err := commit.File("foo")
+	c.Assert(err, IsNil)
+	content, err := file.Contents()
+	c.Assert(err, IsNil)
+	c.Assert(content, Equals, "foo")
 
 	status, err := w.Status()
 	c.Assert(err, IsNil)
-	c.Assert(status, HasLen, 3)
+	c.Assert(status.IsClean(), Equals, true)
+}
 
-	file := status.File("qux")
-	c.Assert(file.Staging, Equals, Added)
-	c.Assert(file.Worktree, Equals, Unmodified)
+func (s *WorktreeSuite) TestAddPathspec(c *C) {
+	_, w, _ := s.newPathspecRepository(c)
 
-	file = status.File("baz")
-	c.Assert(file.Staging, Equals, Added)
-	c.Assert(file.Worktree, Equals, Unmodified)
+	for _, name := range []string{"new.go", "dir/new.go", "dir/sub/new.min.js", "dir/sub/new.js"} {
+		c.Assert(util.WriteFile(w.Filesystem, name, []byte("bar"), 0644), IsNil)
+	}
 
-	file = status.File("foo/bar/baz")
-	c.Assert(file.Staging, Equals, Added)
-	c.Assert(file.Worktree, Equals, Unmodified)
+	err := w.AddWithOptions(&AddOptions{Pathspec: []string{":(glob)dir/**/*.js", ":(exclude)*.min.js", "dir/new.go"}})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 4)
+	c.Assert(status.File("dir/new.go").Staging, Equals, Added)
+	c.Assert(status.File("dir/sub/new.js").Staging, Equals, Added)
+	c.Assert(status.File("dir/sub/new.min.js").Worktree, Equals, Untracked)
+	c.Assert(status.File("new.go").Worktree, Equals, Untracked)
 
+	err = w.AddWithOptions(&AddOptions{Path: "foo", Pathspec: []string{"dir"}})
+	c.Assert(err, NotNil)
 }
 
-func (s *WorktreeSuite) TestAddGlobErrorNoMatches(c *C) {
-	r, _ := Init(memory.NewStorage(), memfs.New())
-	w, _ := r.Worktree()
+func (s *WorktreeSuite) TestAddUpdate(c *C) {
+	_, w, _ := s.newPathspecRepository(c)
 
-	err := w.AddGlob("foo")
-	c.Assert(err, Equals, ErrGlobNoMatches)
+	c.Assert(util.WriteFile(w.Filesystem, "foo.go", []byte("bar"), 0644), IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "dir/baz.go", []byte("bar"), 0644), IsNil)
+	c.Assert(w.Filesystem.Remove("dir/sub/qux.js"), IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "dir/new.go", []byte("bar"), 0644), IsNil)
+
+	err := w.AddWithOptions(&AddOptions{Update: true, Path: "dir"})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 4)
+	c.Assert(status.File("foo.go").Staging, Equals, Unmodified)
+	c.Assert(status.File("foo.go").Worktree, Equals, Modified)
+	c.Assert(status.File("dir/baz.go").Staging, Equals, Modified)
+	c.Assert(status.File("dir/sub/qux.js").Staging, Equals, Deleted)
+	c.Assert(status.File("dir/new.go").Worktree, Equals, Untracked)
+
+	err = w.AddWithOptions(&AddOptions{Update: true})
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo.go").Staging, Equals, Modified)
+	c.Assert(status.File("dir/new.go").Worktree, Equals, Untracked)
 }
 
 func (s *WorktreeSuite) TestAddSkipStatusAddedPath(c *C) {
@@ -2542,6 +4048,141 @@ func (s *WorktreeSuite) TestCleanBare(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *WorktreeSuite) newCleanRepository(c *C) (*Worktree, billy.Filesystem) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	for name, content := range map[string]string{
+		".gitignore":  "*.log\nbuild/\n",
+		"README":      "foo",
+		"dir/tracked": "foo",
+	} {
+		c.Assert(util.WriteFile(fs, name, []byte(content), 0644), IsNil)
+		_, err = w.Add(name)
+		c.Assert(err, IsNil)
+	}
+
+	_, err = w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	for _, name := range []string{
+		"untracked",
+		"debug.log",
+		"dir/untracked",
+		"new/file",
+		"build/out",
+		"nested/.git/HEAD",
+		"nested/file",
+	} {
+		c.Assert(util.WriteFile(fs, name, []byte("foo"), 0644), IsNil)
+	}
+
+	return w, fs
+}
+
+func (s *WorktreeSuite) TestCleanContextDryRun(c *C) {
+	w, fs := s.newCleanRepository(c)
+
+	removed, err := w.CleanContext(context.Background(), &CleanOptions{Dir: true, DryRun: true})
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{"dir/untracked", "new/", "untracked"})
+
+	for _, name := range []string{"untracked", "dir/untracked", "new/file"} {
+		_, err = fs.Lstat(name)
+		c.Assert(err, IsNil)
+	}
+
+	removed, err = w.CleanContext(context.Background(), &CleanOptions{Dir: true})
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{"dir/untracked", "new/", "untracked"})
+
+	for _, name := range []string{"untracked", "dir/untracked", "new"} {
+		_, err = fs.Lstat(name)
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+
+	for _, name := range []string{"README", "dir/tracked", "debug.log", "build/out", "nested/file"} {
+		_, err = fs.Lstat(name)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *WorktreeSuite) TestCleanContextWithoutDir(c *C) {
+	w, fs := s.newCleanRepository(c)
+
+	removed, err := w.CleanContext(context.Background(), &CleanOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{"dir/untracked", "untracked"})
+
+	_, err = fs.Lstat("new/file")
+	c.Assert(err, IsNil)
+}
+
+func (s *WorktreeSuite) TestCleanContextIgnored(c *C) {
+	w, _ := s.newCleanRepository(c)
+
+	removed, err := w.CleanContext(context.Background(), &CleanOptions{DryRun: true, OnlyIgnored: true})
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{"debug.log"})
+
+	removed, err = w.CleanContext(context.Background(), &CleanOptions{Dir: true, DryRun: true, OnlyIgnored: true})
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{"build/", "debug.log"})
+
+	removed, err = w.CleanContext(context.Background(), &CleanOptions{Dir: true, DryRun: true, RemoveIgnored: true})
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{"build/", "debug.log", "dir/untracked", "new/", "untracked"})
+
+	_, err = w.CleanContext(context.Background(), &CleanOptions{RemoveIgnored: true, OnlyIgnored: true})
+	c.Assert(err, Equals, ErrRemoveIgnoredAndOnlyIgnored)
+}
+
+func (s *WorktreeSuite) TestCleanContextNestedRepository(c *C) {
+	w, fs := s.newCleanRepository(c)
+
+	removed, err := w.CleanContext(context.Background(), &CleanOptions{Dir: true, Paths: []string{"nested"}})
+	c.Assert(err, IsNil)
+	c.Assert(removed, HasLen, 0)
+
+	_, err = fs.Lstat("nested/file")
+	c.Assert(err, IsNil)
+
+	removed, err = w.CleanContext(context.Background(), &CleanOptions{Dir: true, Force: true, Paths: []string{"nested"}})
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{"nested/"})
+
+	_, err = fs.Lstat("nested")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *WorktreeSuite) TestCleanContextPaths(c *C) {
+	w, fs := s.newCleanRepository(c)
+
+	removed, err := w.CleanContext(context.Background(), &CleanOptions{Dir: true, Paths: []string{"dir", "new/file"}})
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{"dir/untracked", "new/"})
+
+	_, err = fs.Lstat("untracked")
+	c.Assert(err, IsNil)
+}
+
+func (s *WorktreeSuite) TestCleanContextPrunesEmptyDirectories(c *C) {
+	w, fs := s.newCleanRepository(c)
+
+	c.Assert(fs.Remove("dir/tracked"), IsNil)
+
+	removed, err := w.CleanContext(context.Background(), &CleanOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(removed, DeepEquals, []string{"dir/", "untracked"})
+
+	_, err = fs.Lstat("dir")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
 func TestAlternatesRepo(t *testing.T) {
 	fs := fixtures.ByTag("alternates").One().Worktree()
 
@@ -2896,6 +4537,141 @@ func (s *WorktreeSuite) TestGrepBare(c *C) {
 	}
 }
 
+func (s *WorktreeSuite) newGrepRepository(c *C) (*Worktree, plumbing.Hash) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	for name, content := range map[string]string{
+		"foo.txt":     "one\ntwo\nFoo\nthree\nfour\nfive\nfoobar\n",
+		"bar.txt":     "foo\n",
+		"binary.bin":  "foo\x00bar",
+		"vendor/file": "foo\n",
+	} {
+		c.Assert(util.WriteFile(fs, name, []byte(content), 0644), IsNil)
+		_, err = w.Add(name)
+		c.Assert(err, IsNil)
+	}
+
+	h, err := w.Commit("foo\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	return w, h
+}
+
+func (s *WorktreeSuite) TestGrepOptions(c *C) {
+	w, h := s.newGrepRepository(c)
+	foo := regexp.MustCompile("foo")
+
+	result, err := w.Grep(&GrepOptions{
+		Patterns:         []*regexp.Regexp{foo},
+		IgnoreCase:       true,
+		WordRegexp:       true,
+		ExcludePathSpecs: []*regexp.Regexp{regexp.MustCompile("^vendor/")},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, []GrepResult{
+		{FileName: "bar.txt", LineNumber: 1, Content: "foo", TreeName: h.String()},
+		{FileName: "binary.bin", TreeName: h.String(), Binary: true},
+		{FileName: "foo.txt", LineNumber: 3, Content: "Foo", TreeName: h.String()},
+	})
+
+	c.Assert(result[1].String(), Equals, fmt.Sprintf("Binary file %s:binary.bin matches", h))
+	c.Assert(result[2].String(), Equals, fmt.Sprintf("%s:foo.txt:3:Foo", h))
+}
+
+func (s *WorktreeSuite) TestGrepContext(c *C) {
+	w, h := s.newGrepRepository(c)
+
+	result, err := w.Grep(&GrepOptions{
+		Patterns:      []*regexp.Regexp{regexp.MustCompile("(?i)^foo")},
+		PathSpecs:     []*regexp.Regexp{regexp.MustCompile("foo.txt")},
+		ContextBefore: 1,
+		ContextAfter:  2,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, []GrepResult{
+		{FileName: "foo.txt", LineNumber: 2, Content: "two", TreeName: h.String(), IsContext: true},
+		{FileName: "foo.txt", LineNumber: 3, Content: "Foo", TreeName: h.String()},
+		{FileName: "foo.txt", LineNumber: 4, Content: "three", TreeName: h.String(), IsContext: true},
+		{FileName: "foo.txt", LineNumber: 5, Content: "four", TreeName: h.String(), IsContext: true},
+		{FileName: "foo.txt", LineNumber: 6, Content: "five", TreeName: h.String(), IsContext: true},
+		{FileName: "foo.txt", LineNumber: 7, Content: "foobar", TreeName: h.String()},
+	})
+
+	c.Assert(result[0].String(), Equals, fmt.Sprintf("%s:foo.txt-2-two", h))
+}
+
+func (s *WorktreeSuite) TestGrepSources(c *C) {
+	w, _ := s.newGrepRepository(c)
+
+	c.Assert(util.WriteFile(w.Filesystem, "bar.txt", []byte("qux\n"), 0644), IsNil)
+	_, err := w.Add("bar.txt")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(w.Filesystem, "bar.txt", []byte("quux\n"), 0644), IsNil)
+	c.Assert(w.Filesystem.Remove("vendor/file"), IsNil)
+
+	opts := &GrepOptions{
+		Patterns: []*regexp.Regexp{regexp.MustCompile("^(qu|foo$)")},
+		Source:   GrepIndex,
+	}
+
+	result, err := w.Grep(opts)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, []GrepResult{
+		{FileName: "bar.txt", LineNumber: 1, Content: "qux"},
+		{FileName: "vendor/file", LineNumber: 1, Content: "foo"},
+	})
+
+	opts.Source = GrepWorktree
+	result, err = w.Grep(opts)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, []GrepResult{
+		{FileName: "bar.txt", LineNumber: 1, Content: "quux"},
+	})
+	c.Assert(result[0].String(), Equals, "bar.txt:1:quux")
+
+	opts.ReferenceName = plumbing.HEAD
+	_, err = w.Grep(opts)
+	c.Assert(err, Equals, ErrGrepSourceRevision)
+}
+
+func (s *WorktreeSuite) TestGrepIndexUnsorted(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	// The entries of an index held in memory are kept in the order they
+	// were added in.
+	for _, name := range []string{"b.txt", "a.txt"} {
+		c.Assert(util.WriteFile(fs, name, []byte("foo\n"), 0644), IsNil)
+		_, err = w.Add(name)
+		c.Assert(err, IsNil)
+	}
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Entries[0].Name, Equals, "b.txt")
+
+	for _, source := range []GrepSource{GrepIndex, GrepWorktree} {
+		result, err := w.Grep(&GrepOptions{
+			Patterns: []*regexp.Regexp{regexp.MustCompile("foo")},
+			Source:   source,
+		})
+		c.Assert(err, IsNil)
+		c.Assert(result, DeepEquals, []GrepResult{
+			{FileName: "a.txt", LineNumber: 1, Content: "foo"},
+			{FileName: "b.txt", LineNumber: 1, Content: "foo"},
+		})
+	}
+}
+
 func (s *WorktreeSuite) TestResetLingeringDirectories(c *C) {
 	dir := c.MkDir()
 
@@ -3390,3 +5166,116 @@ func TestFilePermissions(t *testing.T) {
 	}
 
 }
+
+func (s *WorktreeSuite) TestStatusContextCancelled(c *C) {
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: memfs.New(),
+	}
+
+	err := w.Checkout(&CheckoutOptions{Force: true})
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = w.StatusContext(ctx, StatusOptions{})
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+}
+
+func (s *WorktreeSuite) TestAddContextCancelled(c *C) {
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: memfs.New(),
+	}
+
+	err := w.Checkout(&CheckoutOptions{Force: true})
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(w.Filesystem, "file1", []byte("file1"), 0644)
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = w.AddContext(ctx, &AddOptions{All: true})
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+
+	// The index is untouched.
+	idx, err := w.r.Storer.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Entries, HasLen, 9)
+
+	err = w.AddContext(context.Background(), &AddOptions{All: true})
+	c.Assert(err, IsNil)
+
+	idx, err = w.r.Storer.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Entries, HasLen, 10)
+}
+
+func (s *WorktreeSuite) TestCheckoutContextInterrupted(c *C) {
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: memfs.New(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := w.CheckoutContext(ctx, &CheckoutOptions{
+		Force: true,
+		ProgressHandler: func(e ProgressEvent) {
+			if e.Done == 2 {
+				cancel()
+			}
+		},
+	})
+
+	var interrupted *CheckoutInterruptedError
+	c.Assert(errors.As(err, &interrupted), Equals, true)
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+	c.Assert(interrupted.Updated, HasLen, 2)
+
+	for _, path := range interrupted.Updated {
+		_, err := w.Filesystem.Lstat(path)
+		c.Assert(err, IsNil)
+	}
+
+	// The files not written are missing from the worktree.
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 7)
+	for _, path := range interrupted.Updated {
+		_, changed := status[path]
+		c.Assert(changed, Equals, false)
+	}
+
+	err = w.Checkout(&CheckoutOptions{Force: true})
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestResetContextCancelled(c *C) {
+	w := &Worktree{
+		r:          s.Repository,
+		Filesystem: memfs.New(),
+	}
+
+	err := w.Checkout(&CheckoutOptions{Force: true})
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	commit := plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9")
+	err = w.ResetContext(ctx, &ResetOptions{Mode: HardReset, Commit: commit})
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+
+	head, err := w.r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Not(Equals), commit)
+}